@@ -74,6 +74,32 @@ mod tests {
         assert!(!is_valid_ip_for_acme("192.168.1.1"));
     }
 
+    #[test]
+    fn custom_acme_directory_gets_its_own_account() {
+        let mut opts = ObtainOptions {
+            domain: "mail.example.net".into(),
+            email: "admin@example.net".into(),
+            state_dir: "/var/lib/madmail".into(),
+            cert_path: None,
+            key_path: None,
+            http_listen: "0.0.0.0:80".parse().unwrap(),
+            staging: true,
+            directory_url: None,
+            skip_if_valid: false,
+        };
+        assert!(opts.acme_directory().contains("staging"));
+        assert!(opts.le_account_path().ends_with("autocert/le-account.json"));
+
+        opts.directory_url = Some("https://ca.internal:9000/acme/acme/directory".into());
+        assert_eq!(
+            opts.acme_directory(),
+            "https://ca.internal:9000/acme/acme/directory"
+        );
+        assert!(opts
+            .le_account_path()
+            .ends_with("autocert/account-ca.internal_9000.json"));
+    }
+
     #[test]
     fn self_signed_roundtrip() {
         let dir = tempfile::tempdir().unwrap();
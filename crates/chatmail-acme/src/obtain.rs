@@ -47,6 +47,8 @@ pub struct ObtainOptions {
     /// Listen address for HTTP-01 (default `0.0.0.0:80`).
     pub http_listen: SocketAddr,
     pub staging: bool,
+    /// ACME directory URL (`acme_directory`); `None` is Let's Encrypt, honouring `staging`.
+    pub directory_url: Option<String>,
     /// Skip issuance when an existing cert is still valid (`get` only).
     pub skip_if_valid: bool,
 }
@@ -71,11 +73,45 @@ impl ObtainOptions {
         self.state_dir.join("autocert/account.key.pem")
     }
 
+    /// Account credentials are bound to one CA, so a custom directory gets its own file.
     pub fn le_account_path(&self) -> PathBuf {
-        self.state_dir.join("autocert/le-account.json")
+        match self.directory_url.as_deref().and_then(directory_host) {
+            Some(host) => self.state_dir.join(format!("autocert/account-{host}.json")),
+            None => self.state_dir.join("autocert/le-account.json"),
+        }
+    }
+
+    pub fn acme_directory(&self) -> String {
+        match self
+            .directory_url
+            .as_deref()
+            .filter(|u| !u.trim().is_empty())
+        {
+            Some(url) => url.trim().to_owned(),
+            None if self.staging => LetsEncrypt::Staging.url().to_owned(),
+            None => LetsEncrypt::Production.url().to_owned(),
+        }
     }
 }
 
+/// `https://ca.internal:9000/acme/dir` → `ca.internal_9000`.
+fn directory_host(url: &str) -> Option<String> {
+    let rest = url.trim().split_once("://").map_or(url.trim(), |(_, r)| r);
+    let host: String = rest
+        .split('/')
+        .next()?
+        .chars()
+        .map(|c| {
+            if c.is_ascii_alphanumeric() || c == '.' || c == '-' {
+                c
+            } else {
+                '_'
+            }
+        })
+        .collect();
+    (!host.is_empty()).then_some(host)
+}
+
 /// Obtain (or renew) a certificate and write PEM files.
 pub async fn obtain_certificate(opts: &ObtainOptions) -> Result<()> {
     if parse_public_ip(&opts.domain).is_ok() {
@@ -109,11 +145,7 @@ async fn obtain_dns_certificate(opts: &ObtainOptions) -> Result<()> {
         .start(&opts.http_listen)
         .map_err(|e| ChatmailError::config(format!("bind HTTP-01 on {}: {e}", opts.http_listen)))?;
 
-    let directory_url = if opts.staging {
-        LetsEncrypt::Staging.url().to_owned()
-    } else {
-        LetsEncrypt::Production.url().to_owned()
-    };
+    let directory_url = opts.acme_directory();
 
     let account = load_or_create_le_account(opts, &directory_url).await?;
 
@@ -21,7 +21,7 @@ use std::net::IpAddr;
 
 use chatmail_types::{ChatmailError, Result};
 use instant_acme::{
-    AuthorizationStatus, ChallengeType, Identifier, LetsEncrypt, NewOrder, OrderStatus, RetryPolicy,
+    AuthorizationStatus, ChallengeType, Identifier, NewOrder, OrderStatus, RetryPolicy,
 };
 use tracing::info;
 
@@ -99,11 +99,7 @@ pub async fn obtain_ip_certificate(opts: &ObtainOptions) -> Result<()> {
         .start(&opts.http_listen)
         .map_err(|e| ChatmailError::config(format!("bind HTTP-01 on {}: {e}", opts.http_listen)))?;
 
-    let directory_url = if opts.staging {
-        LetsEncrypt::Staging.url().to_owned()
-    } else {
-        LetsEncrypt::Production.url().to_owned()
-    };
+    let directory_url = opts.acme_directory();
 
     let account = load_or_create_le_account(opts, &directory_url).await?;
 
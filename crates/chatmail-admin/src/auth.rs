@@ -16,16 +16,39 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 use std::collections::HashMap;
-use std::sync::Mutex;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
 use std::time::{Duration, Instant};
 
 use subtle::ConstantTimeEq;
 
 const MAX_FAILED_PER_MINUTE: usize = 10;
 
+/// Concurrent `/events` streams allowed for the admin token.
+pub const MAX_EVENT_STREAMS: usize = 4;
+
+/// The `Authorization: Bearer …` token of an admin request envelope.
+pub fn bearer_token(headers: &HashMap<String, String>) -> Option<&str> {
+    headers
+        .get("Authorization")
+        .or_else(|| headers.get("authorization"))?
+        .strip_prefix("Bearer ")
+}
+
 pub struct AuthGate {
     token: String,
     failed: Mutex<HashMap<String, Vec<Instant>>>,
+    event_streams: Arc<AtomicUsize>,
+}
+
+/// One open `/events` stream; the slot frees when the stream is dropped.
+#[derive(Debug)]
+pub struct EventStreamPermit(Arc<AtomicUsize>);
+
+impl Drop for EventStreamPermit {
+    fn drop(&mut self) {
+        self.0.fetch_sub(1, Ordering::AcqRel);
+    }
 }
 
 impl AuthGate {
@@ -33,9 +56,20 @@ impl AuthGate {
         Self {
             token,
             failed: Mutex::new(HashMap::new()),
+            event_streams: Arc::new(AtomicUsize::new(0)),
         }
     }
 
+    /// Take a stream slot; `None` once [`MAX_EVENT_STREAMS`] are open.
+    pub fn open_event_stream(&self) -> Option<EventStreamPermit> {
+        self.event_streams
+            .fetch_update(Ordering::AcqRel, Ordering::Acquire, |n| {
+                (n < MAX_EVENT_STREAMS).then_some(n + 1)
+            })
+            .ok()?;
+        Some(EventStreamPermit(Arc::clone(&self.event_streams)))
+    }
+
     pub fn authenticate(
         &self,
         headers: &std::collections::HashMap<String, String>,
@@ -44,12 +78,7 @@ impl AuthGate {
         if self.token.is_empty() {
             return false;
         }
-        let auth = headers
-            .get("Authorization")
-            .or_else(|| headers.get("authorization"))
-            .map(|s| s.as_str())
-            .unwrap_or("");
-        let Some(token) = auth.strip_prefix("Bearer ") else {
+        let Some(token) = bearer_token(headers) else {
             self.record_failure(remote_ip);
             return false;
         };
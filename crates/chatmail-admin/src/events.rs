@@ -0,0 +1,310 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `GET <admin_path>/events` — Server-Sent Events for the admin dashboard.
+//!
+//! Authenticated with the same `Authorization: Bearer` token as the RPC endpoint. Each event
+//! is one SSE frame (`id`, `event` = type, `data` = JSON). `?types=a,b` narrows the stream;
+//! `Last-Event-ID` replays what a reconnecting client missed from the short in-memory buffer,
+//! and an `event: resync` frame tells it when that buffer no longer reaches back far enough.
+//! A client that falls behind the live buffer is disconnected; a comment frame every
+//! [`HEARTBEAT_INTERVAL`] keeps idle streams open through proxies.
+
+use std::collections::HashMap;
+use std::convert::Infallible;
+use std::net::SocketAddr;
+use std::time::Duration;
+
+use axum::body::{Body, Bytes};
+use axum::extract::{ConnectInfo, Query, State};
+use axum::http::{header, HeaderMap, HeaderValue, StatusCode};
+use axum::response::{IntoResponse, Response};
+use axum::Extension;
+use chatmail_state::{AdminEvent, AdminEventKind};
+use serde::Deserialize;
+use tokio::sync::broadcast::{self, error::RecvError};
+use tokio::time::{interval_at, Instant, Interval};
+
+use crate::auth::EventStreamPermit;
+use crate::handler::rate_limit_key;
+use crate::AdminState;
+
+pub const HEARTBEAT_INTERVAL: Duration = Duration::from_secs(15);
+
+#[derive(Debug, Default, Deserialize)]
+pub struct EventsQuery {
+    /// Comma-separated event types; all types when absent.
+    pub types: Option<String>,
+}
+
+/// Parse `?types=`; `Err` names the first unknown type.
+fn parse_types(raw: Option<&str>) -> Result<Option<Vec<AdminEventKind>>, String> {
+    let Some(raw) = raw.filter(|r| !r.trim().is_empty()) else {
+        return Ok(None);
+    };
+    raw.split(',')
+        .map(str::trim)
+        .filter(|t| !t.is_empty())
+        .map(|t| AdminEventKind::parse(t).ok_or_else(|| format!("unknown event type: {t}")))
+        .collect::<Result<Vec<_>, _>>()
+        .map(Some)
+}
+
+fn sse_frame(event: &AdminEvent) -> String {
+    format!(
+        "id: {}\nevent: {}\ndata: {}\n\n",
+        event.id,
+        event.kind.as_str(),
+        event.data
+    )
+}
+
+struct EventStream {
+    resync: bool,
+    replay: std::vec::IntoIter<AdminEvent>,
+    live: broadcast::Receiver<AdminEvent>,
+    types: Option<Vec<AdminEventKind>>,
+    heartbeat: Interval,
+    _permit: Option<EventStreamPermit>,
+}
+
+impl EventStream {
+    fn wants(types: &Option<Vec<AdminEventKind>>, kind: AdminEventKind) -> bool {
+        types.as_ref().map_or(true, |t| t.contains(&kind))
+    }
+
+    /// Next frame to send; `None` ends the response.
+    async fn next_frame(&mut self) -> Option<String> {
+        if std::mem::take(&mut self.resync) {
+            return Some("event: resync\ndata: {}\n\n".into());
+        }
+        for event in self.replay.by_ref() {
+            if Self::wants(&self.types, event.kind) {
+                return Some(sse_frame(&event));
+            }
+        }
+        loop {
+            tokio::select! {
+                recv = self.live.recv() => match recv {
+                    Ok(event) if Self::wants(&self.types, event.kind) => {
+                        return Some(sse_frame(&event));
+                    }
+                    Ok(_) => {}
+                    Err(RecvError::Lagged(skipped)) => {
+                        tracing::warn!(skipped, "admin events: slow consumer disconnected");
+                        return None;
+                    }
+                    Err(RecvError::Closed) => return None,
+                },
+                _ = self.heartbeat.tick() => return Some(": heartbeat\n\n".into()),
+            }
+        }
+    }
+
+    fn into_body(self) -> Body {
+        let frames = futures_util::stream::unfold(self, |mut s| async move {
+            let frame = s.next_frame().await?;
+            Some((Ok::<_, Infallible>(Bytes::from(frame)), s))
+        });
+        Body::from_stream(frames)
+    }
+}
+
+fn open_stream(
+    st: &AdminState,
+    last_event_id: Option<u64>,
+    types: Option<Vec<AdminEventKind>>,
+    permit: Option<EventStreamPermit>,
+    heartbeat: Duration,
+) -> EventStream {
+    let sub = st.app.admin_events.subscribe(last_event_id);
+    EventStream {
+        resync: sub.gap,
+        replay: sub.replay.into_iter(),
+        live: sub.live,
+        types,
+        heartbeat: interval_at(Instant::now() + heartbeat, heartbeat),
+        _permit: permit,
+    }
+}
+
+/// GET `/events`
+pub async fn events_handler(
+    State(st): State<AdminState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    Query(query): Query<EventsQuery>,
+) -> Response {
+    let remote = rate_limit_key(&st, &headers, peer);
+    let auth: HashMap<String, String> = headers
+        .get(header::AUTHORIZATION)
+        .and_then(|v| v.to_str().ok())
+        .map(|v| HashMap::from([("Authorization".to_string(), v.to_string())]))
+        .unwrap_or_default();
+    if !st.auth.authenticate(&auth, &remote) {
+        return (StatusCode::UNAUTHORIZED, "unauthorized").into_response();
+    }
+    let types = match parse_types(query.types.as_deref()) {
+        Ok(t) => t,
+        Err(msg) => return (StatusCode::BAD_REQUEST, msg).into_response(),
+    };
+    let Some(permit) = st.auth.open_event_stream() else {
+        return (StatusCode::TOO_MANY_REQUESTS, "too many event streams").into_response();
+    };
+    let last_event_id = headers
+        .get("last-event-id")
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.trim().parse().ok());
+
+    let stream = open_stream(&st, last_event_id, types, Some(permit), HEARTBEAT_INTERVAL);
+    let mut resp = stream.into_body().into_response();
+    let headers = resp.headers_mut();
+    headers.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static("text/event-stream"),
+    );
+    headers.insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    // nginx: do not buffer the stream.
+    headers.insert("x-accel-buffering", HeaderValue::from_static("no"));
+    resp
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use chatmail_config::AppConfig;
+    use chatmail_db::init_memory_db;
+    use chatmail_state::admin_events::SUBSCRIBER_BUFFER;
+    use chatmail_state::AppState;
+    use serde_json::json;
+    use tempfile::TempDir;
+
+    use super::*;
+
+    async fn test_admin_state() -> (AdminState, TempDir) {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let st = AdminState::new(
+            pool,
+            app,
+            AppConfig::default(),
+            dir.path().to_path_buf(),
+            "example.org".into(),
+            "secret-token-01234567890123456789012345678901".into(),
+            None,
+        );
+        (st, dir)
+    }
+
+    fn stream(
+        st: &AdminState,
+        last: Option<u64>,
+        types: Option<Vec<AdminEventKind>>,
+    ) -> EventStream {
+        open_stream(st, last, types, None, HEARTBEAT_INTERVAL)
+    }
+
+    #[test]
+    fn parses_type_filter() {
+        assert_eq!(parse_types(None).unwrap(), None);
+        assert_eq!(parse_types(Some(" ")).unwrap(), None);
+        assert_eq!(
+            parse_types(Some("ban_added, account_created")).unwrap(),
+            Some(vec![
+                AdminEventKind::BanAdded,
+                AdminEventKind::AccountCreated
+            ])
+        );
+        assert!(parse_types(Some("ban_added,nope")).is_err());
+    }
+
+    #[tokio::test]
+    async fn filters_by_type() {
+        let (st, _dir) = test_admin_state().await;
+        let mut stream = open(&st, None, Some(vec![AdminEventKind::BanAdded]));
+        let bus = &st.app.admin_events;
+        bus.publish(AdminEventKind::AccountCreated, json!({ "username": "a@x" }));
+        let id = bus.publish(AdminEventKind::BanAdded, json!({ "cidr": "192.0.2.1/32" }));
+        let frame = stream.next_frame().await.unwrap();
+        assert!(frame.starts_with(&format!("id: {id}\nevent: ban_added\ndata: {{")));
+        assert!(frame.contains("\"cidr\":\"192.0.2.1/32\""));
+        assert!(frame.ends_with("\n\n"));
+    }
+
+    #[tokio::test]
+    async fn resumes_after_reconnect() {
+        let (st, _dir) = test_admin_state().await;
+        let bus = &st.app.admin_events;
+        let mut first = open(&st, None, None);
+        let seen = bus.publish(AdminEventKind::SettingChanged, json!({}));
+        assert!(first
+            .next_frame()
+            .await
+            .unwrap()
+            .starts_with(&format!("id: {seen}\n")));
+        drop(first);
+
+        // Published while the client was away.
+        let missed = bus.publish(AdminEventKind::JobProgress, json!({ "job": "takeout" }));
+        let mut again = open(&st, Some(seen), None);
+        let frame = again.next_frame().await.unwrap();
+        assert!(frame.starts_with(&format!("id: {missed}\nevent: job_progress\n")));
+
+        // An id the buffer cannot serve asks the client to reload first.
+        let mut stale = open(&st, Some(missed + 100), None);
+        assert!(stale
+            .next_frame()
+            .await
+            .unwrap()
+            .starts_with("event: resync\n"));
+    }
+
+    #[tokio::test]
+    async fn slow_consumer_is_disconnected() {
+        let (st, _dir) = test_admin_state().await;
+        let mut stream = open(&st, None, None);
+        for _ in 0..SUBSCRIBER_BUFFER + 1 {
+            st.app
+                .admin_events
+                .publish(AdminEventKind::DeliveryFailed, json!({}));
+        }
+        assert_eq!(stream.next_frame().await, None);
+    }
+
+    #[tokio::test]
+    async fn heartbeat_keeps_idle_stream_alive() {
+        let (st, _dir) = test_admin_state().await;
+        let mut stream = open_stream(&st, None, None, None, Duration::from_millis(10));
+        assert_eq!(
+            stream.next_frame().await.as_deref(),
+            Some(": heartbeat\n\n")
+        );
+    }
+
+    #[test]
+    fn stream_slots_are_capped() {
+        let gate = crate::auth::AuthGate::new("tok".into());
+        let permits: Vec<_> = (0..crate::auth::MAX_EVENT_STREAMS)
+            .map(|_| gate.open_event_stream().unwrap())
+            .collect();
+        assert!(gate.open_event_stream().is_none());
+        drop(permits);
+        assert!(gate.open_event_stream().is_some());
+    }
+}
@@ -16,20 +16,23 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 use std::collections::HashMap;
+use std::net::SocketAddr;
 
 use axum::body::Bytes;
-use axum::extract::State;
+use axum::extract::{ConnectInfo, Request, State};
 use axum::http::HeaderMap;
-use axum::response::IntoResponse;
-use axum::Json;
+use axum::middleware::Next;
+use axum::response::{IntoResponse, Response};
+use axum::{Extension, Json};
+use chatmail_state::AdminEventKind;
+use chatmail_www::body_limit::{limit_body, BodyClass};
 use serde::{Deserialize, Serialize};
-use serde_json::Value;
+use serde_json::{json, Value};
 
-use crate::resources;
+use crate::auth::bearer_token;
+use crate::resources::{self, audit};
 use crate::AdminState;
 
-const MAX_BODY: usize = 1 << 20;
-
 #[derive(Debug, Deserialize)]
 pub struct AdminRequest {
     pub method: Option<String>,
@@ -53,18 +56,9 @@ pub struct AdminResponse {
 pub async fn admin_handler(
     State(st): State<AdminState>,
     headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
     body: Bytes,
 ) -> impl IntoResponse {
-    if body.len() > MAX_BODY {
-        return Json(envelope(
-            &st,
-            413,
-            None,
-            None,
-            Some("request body too large"),
-        ));
-    }
-
     let req: AdminRequest = match serde_json::from_slice(&body) {
         Ok(r) => r,
         Err(_) => {
@@ -78,12 +72,9 @@ pub async fn admin_handler(
         }
     };
 
-    let remote = headers
-        .get("x-forwarded-for")
-        .and_then(|v| v.to_str().ok())
-        .unwrap_or("127.0.0.1");
+    let remote = rate_limit_key(&st, &headers, peer);
     let inner = req.headers.unwrap_or_default();
-    if !st.auth.authenticate(&inner, remote) {
+    if !st.auth.authenticate(&inner, &remote) {
         return Json(envelope(&st, 401, None, None, Some("unauthorized")));
     }
 
@@ -92,12 +83,135 @@ pub async fn admin_handler(
         .unwrap_or_else(|| "GET".into())
         .to_ascii_uppercase();
 
-    match resources::dispatch(&st, &method, &req.resource, &req.body).await {
-        Ok((status, body)) => Json(envelope(&st, status, Some(&req.resource), body, None)),
+    // No-Log policy: with logging off nothing about the request is written to the trail.
+    let audited = method != "GET" && !st.file_config.logging_off();
+    let snapshot = audited
+        .then(|| value_snapshot(&method, &req.resource, &req.body))
+        .flatten();
+    let before = match &snapshot {
+        Some((resource, body)) => read_value(&st, resource, body).await,
+        None => Value::Null,
+    };
+    let result = resources::dispatch(&st, &method, &req.resource, &req.body).await;
+    if audited {
+        let (status, before, after) = match (&result, &snapshot) {
+            (Ok((status, _)), Some((resource, body))) => {
+                (*status, before, read_value(&st, resource, body).await)
+            }
+            (Ok((status, _)) | Err((status, _)), _) => (*status, Value::Null, Value::Null),
+        };
+        let token = bearer_token(&inner).unwrap_or("");
+        audit::record(
+            &st,
+            token,
+            &method,
+            &req.resource,
+            &req.body,
+            status,
+            &remote,
+            (&before, &after),
+        )
+        .await;
+    }
+    match result {
+        Ok((status, body)) => {
+            if method != "GET" && is_setting_resource(&req.resource) {
+                st.app.admin_events.publish(
+                    AdminEventKind::SettingChanged,
+                    json!({ "resource": req.resource, "method": method }),
+                );
+            }
+            Json(envelope(&st, status, Some(&req.resource), body, None))
+        }
         Err((status, msg)) => Json(envelope(&st, status, Some(&req.resource), None, Some(&msg))),
     }
 }
 
+/// Caps request bodies at `upload_body_limit` and refuses non-JSON bodies. Refusals use
+/// the envelope (`status` 413 / 415) like every other admin error.
+pub async fn body_limit_middleware(
+    State(st): State<AdminState>,
+    req: Request,
+    next: Next,
+) -> Response {
+    let class = BodyClass::Upload;
+    let limit = class.limit(0, st.file_config.effective_upload_body_limit(), 0);
+    match limit_body(req, class, limit).await {
+        Ok(req) => next.run(req).await,
+        Err((_, rejection)) => Json(envelope(
+            &st,
+            rejection.status().as_u16(),
+            None,
+            None,
+            Some(&rejection.message()),
+        ))
+        .into_response(),
+    }
+}
+
+/// Rate-limit key: forwarded headers only count when the peer is a `trusted_cdn` proxy.
+pub(crate) fn rate_limit_key(
+    st: &AdminState,
+    headers: &HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> String {
+    peer.map(|Extension(ConnectInfo(addr))| {
+        st.app
+            .trusted_proxies
+            .client_ip(addr.ip(), |name| {
+                headers.get(name).and_then(|v| v.to_str().ok())
+            })
+            .to_string()
+    })
+    .unwrap_or_else(|| "127.0.0.1".into())
+}
+
+/// Resources whose successful writes publish `setting_changed`.
+fn is_setting_resource(resource: &str) -> bool {
+    const EXACT: [&str; 7] = [
+        "/admin/registration",
+        "/admin/registration/jit",
+        "/admin/message-size",
+        "/admin/federation-size",
+        "/admin/notice",
+        "/admin/theme",
+        "/admin/settings/federation",
+    ];
+    const PREFIXES: [&str; 4] = [
+        "/admin/services/",
+        "/admin/settings/",
+        "/admin/federation/",
+        "/admin/policies/",
+    ];
+    resource != "/admin/settings/export"
+        && (EXACT.contains(&resource) || PREFIXES.iter().any(|p| resource.starts_with(p)))
+}
+
+/// Resource (with its `GET` body) read before and after a write, so the trail shows the
+/// value a settings, quota or account change replaced: settings resources, `/admin/quota`
+/// and `/admin/accounts/{username}` (also for `DELETE /admin/accounts`).
+fn value_snapshot(method: &str, resource: &str, body: &Value) -> Option<(String, Value)> {
+    if is_setting_resource(resource) || resource.starts_with("/admin/accounts/") {
+        return Some((resource.to_string(), Value::Null));
+    }
+    match (method, resource) {
+        (_, "/admin/quota") => Some((resource.to_string(), body.clone())),
+        ("DELETE", "/admin/accounts") => {
+            let username = body["username"].as_str().filter(|u| !u.is_empty())?;
+            Some((format!("/admin/accounts/{username}"), Value::Null))
+        }
+        _ => None,
+    }
+}
+
+/// `GET` body of `resource`, or `null` when it cannot be read (deleted account).
+async fn read_value(st: &AdminState, resource: &str, body: &Value) -> Value {
+    match resources::dispatch(st, "GET", resource, body).await {
+        Ok((_, Some(value))) => value,
+        _ => Value::Null,
+    }
+}
+
 fn envelope(
     st: &AdminState,
     status: u16,
@@ -113,3 +227,47 @@ fn envelope(
         version: st.version.clone(),
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn setting_writes_publish_events() {
+        assert!(is_setting_resource("/admin/registration"));
+        assert!(is_setting_resource("/admin/services/turn"));
+        assert!(is_setting_resource("/admin/settings/language"));
+        assert!(is_setting_resource("/admin/policies/reserved_names"));
+        assert!(!is_setting_resource("/admin/settings/export"));
+        assert!(!is_setting_resource("/admin/registration-token"));
+        assert!(!is_setting_resource("/admin/accounts"));
+        assert!(!is_setting_resource("/admin/bans"));
+    }
+
+    #[test]
+    fn settings_quota_and_account_writes_are_snapshotted() {
+        let snap = |method, resource, body| value_snapshot(method, resource, &body);
+        assert_eq!(
+            snap("POST", "/admin/settings/turn_secret", json!({})),
+            Some(("/admin/settings/turn_secret".into(), Value::Null))
+        );
+        let body = json!({ "username": "a@example.org", "max_bytes": 1 });
+        assert_eq!(
+            snap("PUT", "/admin/quota", body.clone()),
+            Some(("/admin/quota".into(), body))
+        );
+        assert_eq!(
+            snap(
+                "DELETE",
+                "/admin/accounts",
+                json!({ "username": "a@example.org" })
+            ),
+            Some(("/admin/accounts/a@example.org".into(), Value::Null))
+        );
+        assert_eq!(snap("POST", "/admin/accounts", json!({})), None);
+        assert_eq!(
+            snap("POST", "/admin/bans", json!({ "ip": "192.0.2.1" })),
+            None
+        );
+    }
+}
@@ -17,6 +17,7 @@
 
 pub mod auth;
 pub mod cors;
+pub mod events;
 pub mod handler;
 pub mod resources;
 pub mod router;
@@ -25,4 +26,8 @@ pub mod router;
 mod tests;
 
 pub use handler::{AdminRequest, AdminResponse};
+pub use resources::audit::redact_body;
+pub use resources::settings_transfer::{
+    export_settings, import_settings, ImportReport, ImportStatus, SettingsExport,
+};
 pub use router::{admin_router, AdminState};
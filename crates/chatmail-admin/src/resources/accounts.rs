@@ -16,13 +16,16 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 //! `/admin/accounts` — Madmail `resources.AccountsHandler`.
+//!
+//! `GET /admin/accounts?page=&per_page=&q=` pages through accounts in name order; the page is
+//! cut in SQL so large servers never load every account. `/admin/accounts/{username}` reads
+//! (`GET`) or deletes (`DELETE`) one account.
 
-use chatmail_auth::{hash_password, is_importable_hash, normalize_username};
+use chatmail_auth::{hash_password, is_importable_hash, normalize_username, random_string};
 use chatmail_db::{
     account_info, blocklist, passwords, registration_tokens, AccountQuotaInfo, ADMIN_DELETE_REASON,
     BULK_DELETE_REASON,
 };
-use getrandom::getrandom;
 use serde::Deserialize;
 use serde_json::{json, Value};
 
@@ -32,6 +35,12 @@ use crate::AdminState;
 /// Madmail admin `POST` uses 12-char localparts and 24-char passwords.
 const ADMIN_USERNAME_LEN: usize = 12;
 const ADMIN_PASSWORD_LEN: usize = 24;
+/// Admin-created passwords include symbols unless `password_charset` overrides them.
+const ADMIN_PASSWORD_CHARSET: &str =
+    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()_+-=[]{}|;:,.<>?";
+/// `GET /admin/accounts` page size when `per_page` is absent, and its upper bound.
+const DEFAULT_PER_PAGE: i64 = 100;
+const MAX_PER_PAGE: i64 = 1000;
 
 #[derive(Deserialize)]
 struct UsernameBody {
@@ -62,49 +71,21 @@ fn is_internal_settings_key(username: &str) -> bool {
     username.starts_with("__") && username.ends_with("__")
 }
 
-fn random_alnum(len: usize) -> Result<String, (u16, String)> {
-    const CHARSET: &[u8] = b"abcdefghijklmnopqrstuvwxyz0123456789";
-    let mut b = vec![0u8; len];
-    getrandom(&mut b).map_err(|e| (500, format!("failed to generate random string: {e}")))?;
-    Ok(b.iter()
-        .map(|x| CHARSET[(*x as usize) % CHARSET.len()] as char)
-        .collect())
-}
-
-fn random_password(len: usize) -> Result<String, (u16, String)> {
-    const CHARSET: &[u8] =
-        b"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()_+-=[]{}|;:,.<>?";
-    let mut b = vec![0u8; len];
-    getrandom(&mut b).map_err(|e| (500, format!("failed to generate password: {e}")))?;
-    Ok(b.iter()
-        .map(|x| CHARSET[(*x as usize) % CHARSET.len()] as char)
-        .collect())
+fn random_credential(charset: &str, len: usize) -> Result<String, (u16, String)> {
+    random_string(charset, len).map_err(|e| (500, format!("failed to generate credential: {e}")))
 }
 
+/// Mail and credentials go together: the mail directory is moved aside first and put back
+/// when the credentials cannot be removed, so a failure never leaves half an account.
 async fn delete_account_full(
     st: &AdminState,
     username: &str,
     reason: &str,
 ) -> Result<(), (u16, String)> {
-    let maildir = st.app.mailbox_store.maildir_for_user(username);
-    if maildir.root.exists() {
-        tokio::fs::remove_dir_all(&maildir.root)
-            .await
-            .map_err(db_err)?;
-    }
-    if let Err(e) = passwords::delete_user(&st.pool, username).await {
-        tracing::warn!(%username, error = %e, "admin delete: credentials removal failed");
-    }
-    st.app.auth.remove(username);
-    chatmail_db::account_info::delete_quota_row(&st.pool, username)
-        .await
-        .map_err(db_err)?;
-    blocklist::block_user(&st.pool, username, reason)
+    st.app
+        .delete_account(&st.pool, username, reason)
         .await
-        .map_err(db_err)?;
-    st.app.auth.block(username);
-    st.app.quota.invalidate(username);
-    Ok(())
+        .map_err(db_err)
 }
 
 async fn provision_account(
@@ -116,6 +97,16 @@ async fn provision_account(
         .await
         .map_err(db_err)?;
     st.app.auth.insert(username, stored_hash);
+    chatmail_db::device_codes::revoke_device_codes(&st.pool, username)
+        .await
+        .map_err(db_err)?;
+    chatmail_db::recovery_codes::delete_recovery_codes(&st.pool, username)
+        .await
+        .map_err(db_err)?;
+    chatmail_db::app_passwords::delete_app_passwords(&st.pool, username)
+        .await
+        .map_err(db_err)?;
+    st.app.sessions.revoke(username);
     st.app
         .mailbox_store
         .init_user_dir(username)
@@ -124,43 +115,188 @@ async fn provision_account(
     registration_tokens::ensure_new_account_quota(&st.pool, username)
         .await
         .map_err(db_err)?;
+    st.app.publish_account_created(username, "admin");
     Ok(())
 }
 
-/// Madmail `GET /admin/accounts` — quota usage + `quotas` login timestamps.
-async fn list_accounts(st: &AdminState) -> AdminResult {
-    let users = passwords::list_users(&st.pool).await.map_err(db_err)?;
-    let info = account_info::list_account_quota_info(&st.pool)
+/// `?page=&per_page=&q=` of `GET /admin/accounts` (and `GET /admin/contacts`).
+#[derive(Debug, PartialEq, Eq)]
+pub(super) struct ListQuery {
+    /// 1-based.
+    pub(super) page: i64,
+    pub(super) per_page: i64,
+    /// Case-insensitive substring of the username.
+    pub(super) q: String,
+}
+
+impl ListQuery {
+    pub(super) fn parse(query: &str) -> Result<Self, (u16, String)> {
+        let mut out = Self {
+            page: 1,
+            per_page: DEFAULT_PER_PAGE,
+            q: String::new(),
+        };
+        for pair in query.split('&').filter(|p| !p.is_empty()) {
+            let (name, value) = pair.split_once('=').unwrap_or((pair, ""));
+            let value = percent_decode(value)
+                .ok_or_else(|| (400, format!("invalid percent-encoding in {name}")))?;
+            let number = || {
+                value
+                    .parse::<i64>()
+                    .ok()
+                    .filter(|n| *n >= 1)
+                    .ok_or_else(|| (400, format!("{name} must be a positive integer")))
+            };
+            match name {
+                "page" => out.page = number()?,
+                "per_page" => out.per_page = number()?.min(MAX_PER_PAGE),
+                "q" => out.q = value.trim().to_string(),
+                _ => {}
+            }
+        }
+        Ok(out)
+    }
+}
+
+/// `%XX` and `+` decoding for query values and the `{username}` path segment.
+pub(super) fn percent_decode(raw: &str) -> Option<String> {
+    let bytes = raw.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        match bytes[i] {
+            b'%' => {
+                let hex = raw
+                    .get(i + 1..i + 3)
+                    .filter(|h| h.bytes().all(|b| b.is_ascii_hexdigit()))?;
+                out.push(u8::from_str_radix(hex, 16).ok()?);
+                i += 3;
+            }
+            b'+' => {
+                out.push(b' ');
+                i += 1;
+            }
+            b => {
+                out.push(b);
+                i += 1;
+            }
+        }
+    }
+    String::from_utf8(out).ok()
+}
+
+/// Quota usage, `quotas` timestamps and the message count of one account.
+async fn account_json(
+    st: &AdminState,
+    username: &str,
+    info: AccountQuotaInfo,
+) -> Result<Value, (u16, String)> {
+    let (used, max, is_default) = st.app.quota.get_quota(username);
+    let (_, deleted) = st.app.quota.usage(username);
+    let message_count = st
+        .app
+        .mailbox_store
+        .message_count(username)
         .await
         .map_err(db_err)?;
-    let mut accounts = Vec::new();
+    let AccountQuotaInfo {
+        created_at,
+        first_login_at,
+        last_login_at,
+        last_activity_at,
+    } = info;
+    Ok(json!({
+        "username": username,
+        "used_bytes": used,
+        "deleted_bytes": deleted,
+        "max_bytes": max,
+        "is_default_quota": is_default,
+        "message_count": message_count,
+        "created_at": created_at,
+        "first_login_at": first_login_at,
+        "last_login_at": last_login_at,
+        "last_activity_at": last_activity_at,
+    }))
+}
+
+/// Madmail `GET /admin/accounts` — one page of accounts with quota usage and `quotas` login
+/// timestamps; `total` counts every account matching `q`.
+async fn list_accounts(st: &AdminState, query: &str) -> AdminResult {
+    let ListQuery { page, per_page, q } = ListQuery::parse(query)?;
+    let offset = (page - 1).saturating_mul(per_page);
+    let (users, total) = passwords::list_users_page(&st.pool, &q, offset, per_page)
+        .await
+        .map_err(db_err)?;
+    let mut accounts = Vec::with_capacity(users.len());
     for u in users {
-        if is_internal_settings_key(&u) {
-            continue;
+        let info = account_info::account_quota_info(&st.pool, &u)
+            .await
+            .map_err(db_err)?;
+        accounts.push(account_json(st, &u, info).await?);
+    }
+    Ok((
+        200,
+        Some(json!({
+            "accounts": accounts,
+            "total": total,
+            "page": page,
+            "per_page": per_page,
+        })),
+    ))
+}
+
+/// `/admin/accounts/{username}`: `GET` one account, `DELETE` it (mail, credentials and
+/// sessions; the name is blocklisted like `DELETE /admin/accounts`).
+async fn account(st: &AdminState, method: &str, raw_username: &str) -> AdminResult {
+    let decoded =
+        percent_decode(raw_username).ok_or_else(|| (400, "invalid username encoding".into()))?;
+    let username = normalize_account_username(&decoded)?;
+    let exists = !is_internal_settings_key(&username)
+        && passwords::user_exists(&st.pool, &username)
+            .await
+            .map_err(db_err)?;
+    if !exists {
+        return Err((404, format!("account not found: {username}")));
+    }
+    match method {
+        "GET" => {
+            let info = account_info::account_quota_info(&st.pool, &username)
+                .await
+                .map_err(db_err)?;
+            Ok((200, Some(account_json(st, &username, info).await?)))
         }
-        let (used, max, is_default) = st.app.quota.get_quota(&u);
-        let AccountQuotaInfo {
-            created_at,
-            first_login_at,
-            last_login_at,
-        } = info.get(&u).copied().unwrap_or_default();
-        accounts.push(json!({
-            "username": u,
-            "used_bytes": used,
-            "max_bytes": max,
-            "is_default_quota": is_default,
-            "created_at": created_at,
-            "first_login_at": first_login_at,
-            "last_login_at": last_login_at,
-        }));
+        "DELETE" => {
+            delete_account_full(st, &username, ADMIN_DELETE_REASON).await?;
+            Ok((
+                200,
+                Some(json!({
+                    "deleted": username,
+                    "blocked": username,
+                    "reason": ADMIN_DELETE_REASON,
+                })),
+            ))
+        }
+        _ => Err((
+            405,
+            format!("method {method} not allowed for /admin/accounts/{{username}}"),
+        )),
     }
-    let total = accounts.len();
-    Ok((200, Some(json!({ "accounts": accounts, "total": total }))))
 }
 
-pub async fn accounts(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+pub async fn accounts(st: &AdminState, method: &str, resource: &str, body: &Value) -> AdminResult {
+    let (path, query) = resource.split_once('?').unwrap_or((resource, ""));
+    match path.strip_prefix("/admin/accounts") {
+        Some("") => {}
+        Some(rest) => match rest.strip_prefix('/') {
+            Some(username) if !username.is_empty() && !username.contains('/') => {
+                return account(st, method, username).await;
+            }
+            _ => return Err((404, format!("unknown resource: {resource}"))),
+        },
+        None => return Err((404, format!("unknown resource: {resource}"))),
+    }
     match method {
-        "GET" => list_accounts(st).await,
+        "GET" => list_accounts(st, query).await,
         "POST" => {
             if st.mail_domain.is_empty() {
                 return Err((
@@ -171,8 +307,15 @@ pub async fn accounts(st: &AdminState, method: &str, body: &Value) -> AdminResul
 
             const MAX_ATTEMPTS: u32 = 5;
             for _ in 0..MAX_ATTEMPTS {
-                let localpart = random_alnum(ADMIN_USERNAME_LEN)?;
-                let password = random_password(ADMIN_PASSWORD_LEN)?;
+                let localpart = random_credential(
+                    &st.file_config.generated_username_charset(),
+                    ADMIN_USERNAME_LEN,
+                )?;
+                let password = random_credential(
+                    &st.file_config
+                        .generated_password_charset(ADMIN_PASSWORD_CHARSET),
+                    ADMIN_PASSWORD_LEN,
+                )?;
                 let email = format!("{localpart}@{}", st.mail_domain);
 
                 if blocklist::is_blocked(&st.pool, &email)
@@ -307,7 +450,7 @@ async fn import_accounts(st: &AdminState, users: Vec<ImportUser>) -> AdminResult
             u.hash
         } else {
             let password = if u.password.is_empty() {
-                random_password(ADMIN_PASSWORD_LEN)?
+                random_credential(ADMIN_PASSWORD_CHARSET, ADMIN_PASSWORD_LEN)?
             } else {
                 u.password
             };
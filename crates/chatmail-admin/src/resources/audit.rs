@@ -0,0 +1,231 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/audit` — the `admin_audit` trail of admin API mutations and CLI settings changes,
+//! and the recording side the request handler calls for every authenticated non-`GET`
+//! request while logging is on.
+
+use chatmail_config::parse_duration;
+use chatmail_db::{
+    list_admin_audit, record_admin_audit, AdminAudit, AdminAuditQuery, AUDIT_ACTOR_API,
+};
+use serde_json::{json, Map, Value};
+
+use super::accounts::percent_decode;
+use super::settings_transfer::REDACTED;
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+const DEFAULT_LIMIT: i64 = 100;
+const MAX_LIMIT: i64 = 1000;
+/// Longest stored request body (characters); longer bodies are cut.
+const MAX_AUDIT_BODY_CHARS: usize = 4096;
+/// Body keys, resource names and setting keys containing these are treated as secrets
+/// (`turn_secret`, `ss_password`, `__SS_PASSWORD_SLOTS__`, …).
+const SECRET_MARKERS: [&str; 4] = ["password", "secret", "token", "hash"];
+
+fn is_secret_name(name: &str) -> bool {
+    let name = name.to_ascii_lowercase();
+    SECRET_MARKERS.iter().any(|m| name.contains(m))
+}
+
+/// `body` as stored in the trail: values under secret-looking keys become [`REDACTED`],
+/// and the whole body does when `resource` itself names a secret (`/admin/settings/ss_password`).
+/// Also used for the before / after values of a change.
+pub fn redact_body(resource: &str, body: &Value) -> String {
+    let path = resource.split('?').next().unwrap_or(resource);
+    let name = path.rsplit('/').next().unwrap_or("");
+    let redacted = if body.is_null() {
+        return String::new();
+    } else if is_secret_name(name) {
+        Value::String(REDACTED.into())
+    } else {
+        redact_value(body)
+    };
+    let mut text = redacted.to_string();
+    if let Some((cut, _)) = text.char_indices().nth(MAX_AUDIT_BODY_CHARS) {
+        text.truncate(cut);
+    }
+    text
+}
+
+fn redact_value(value: &Value) -> Value {
+    match value {
+        Value::Object(fields) => Value::Object(
+            fields
+                .iter()
+                .map(|(k, v)| {
+                    let v = if is_secret_name(k) {
+                        Value::String(REDACTED.into())
+                    } else {
+                        redact_value(v)
+                    };
+                    (k.clone(), v)
+                })
+                .collect::<Map<_, _>>(),
+        ),
+        Value::Array(items) => Value::Array(items.iter().map(redact_value).collect()),
+        other => other.clone(),
+    }
+}
+
+/// Write one trail row with the `(before, after)` values of the change (`null` when not
+/// captured); a failed write is logged and never fails the request.
+#[allow(clippy::too_many_arguments)]
+pub(crate) async fn record(
+    st: &AdminState,
+    token: &str,
+    method: &str,
+    resource: &str,
+    body: &Value,
+    status: u16,
+    remote: &str,
+    (before, after): (&Value, &Value),
+) {
+    let entry = AdminAudit {
+        id: 0,
+        created_at: 0,
+        token_prefix: token.trim().to_string(),
+        method: method.to_string(),
+        resource: resource.to_string(),
+        body: redact_body(resource, body),
+        status: i32::from(status),
+        remote: remote.to_string(),
+        actor: AUDIT_ACTOR_API.to_string(),
+        old_value: redact_body(resource, before),
+        new_value: redact_body(resource, after),
+    };
+    if let Err(e) = record_admin_audit(&st.pool, &entry).await {
+        tracing::warn!(%resource, error = %e, "admin audit write failed");
+    }
+}
+
+/// `?page=&limit=&from=&to=&since=&resource=`: 1-based page, rows per page, inclusive
+/// unix-second window, `since` as a duration back from now (`24h`) and a resource prefix.
+fn parse_query(query: &str, now: i64) -> Result<(i64, AdminAuditQuery), (u16, String)> {
+    let mut page = 1;
+    let mut out = AdminAuditQuery {
+        limit: DEFAULT_LIMIT,
+        ..Default::default()
+    };
+    for pair in query.split('&').filter(|p| !p.is_empty()) {
+        let (name, value) = pair.split_once('=').unwrap_or((pair, ""));
+        let number = |min: i64| {
+            value
+                .parse::<i64>()
+                .ok()
+                .filter(|n| *n >= min)
+                .ok_or_else(|| (400, format!("{name} must be an integer >= {min}")))
+        };
+        match name {
+            "page" => page = number(1)?,
+            "limit" => out.limit = number(1)?.min(MAX_LIMIT),
+            "from" => out.from = number(0)?,
+            "to" => out.to = number(0)?,
+            "since" => {
+                let age = parse_duration(value)
+                    .map_err(|_| (400, format!("{name} must be a duration such as 24h")))?;
+                out.from = now.saturating_sub(age.as_secs() as i64);
+            }
+            "resource" if !value.is_empty() => {
+                let prefix = percent_decode(value)
+                    .ok_or_else(|| (400, "resource is not valid percent-encoding".to_string()))?;
+                out.resource = Some(prefix);
+            }
+            _ => {}
+        }
+    }
+    out.offset = (page - 1).saturating_mul(out.limit);
+    Ok((page, out))
+}
+
+pub async fn audit(st: &AdminState, method: &str, resource: &str) -> AdminResult {
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed for /admin/audit")));
+    }
+    let query = resource.split_once('?').map(|(_, q)| q).unwrap_or("");
+    let (page, query) = parse_query(query, chatmail_db::policies::unix_now())?;
+    let (rows, total) = list_admin_audit(&st.pool, &query).await.map_err(db_err)?;
+    let entries: Vec<Value> = rows
+        .into_iter()
+        .map(|a| {
+            json!({
+                "id": a.id,
+                "timestamp": a.created_at,
+                "token": a.token_prefix,
+                "method": a.method,
+                "resource": a.resource,
+                "body": a.body,
+                "status": a.status,
+                "remote": a.remote,
+                "actor": a.actor,
+                "old_value": a.old_value,
+                "new_value": a.new_value,
+            })
+        })
+        .collect();
+    Ok((
+        200,
+        Some(json!({
+            "entries": entries,
+            "total": total,
+            "page": page,
+            "limit": query.limit,
+        })),
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn redacts_secret_keys_and_resources() {
+        let body = json!({ "username": "a@x.org", "users": [{ "password_hash": "$2y$" }] });
+        let text = redact_body("/admin/accounts", &body);
+        assert!(text.contains("a@x.org") && !text.contains("$2y$"), "{text}");
+        assert_eq!(
+            redact_body(
+                "/admin/settings/ss_password",
+                &json!({ "value": "hunter2" })
+            ),
+            format!("\"{REDACTED}\"")
+        );
+        assert_eq!(redact_body("/admin/reload", &Value::Null), "");
+        let long = json!({ "message": "x".repeat(MAX_AUDIT_BODY_CHARS * 2) });
+        assert_eq!(
+            redact_body("/admin/notice", &long).chars().count(),
+            MAX_AUDIT_BODY_CHARS
+        );
+    }
+
+    #[test]
+    fn query_defaults_and_bounds() {
+        let (page, q) = parse_query("", 0).unwrap();
+        assert_eq!((page, q.limit, q.offset), (1, DEFAULT_LIMIT, 0));
+        let (page, q) = parse_query("page=3&limit=5000&from=10&to=20", 0).unwrap();
+        assert_eq!(
+            (page, q.limit, q.offset, q.from, q.to),
+            (3, MAX_LIMIT, 2 * MAX_LIMIT, 10, 20)
+        );
+        assert_eq!(parse_query("page=0", 0).unwrap_err().0, 400);
+        let (_, q) = parse_query("since=24h&resource=%2Fadmin%2Fquota", 100_000).unwrap();
+        assert_eq!(q.from, 100_000 - 24 * 3600);
+        assert_eq!(q.resource.as_deref(), Some("/admin/quota"));
+        assert_eq!(parse_query("since=soon", 0).unwrap_err().0, 400);
+    }
+}
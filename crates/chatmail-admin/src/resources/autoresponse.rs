@@ -0,0 +1,157 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/accounts/{username}/autoresponse` — an account's own automatic reply (vacation
+//! notice), the admin side of `madmail autoresponse`.
+//!
+//! `PUT` replaces the whole reply, `DELETE` removes it. Changes reach the delivery path at
+//! once: the handler refreshes the server's responder cache instead of waiting for the 30 s
+//! timer.
+
+use chatmail_db::policies::unix_now;
+use chatmail_db::{passwords, Autoresponse};
+use chatmail_types::ChatmailError;
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use super::accounts::percent_decode;
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+pub(crate) const AUTORESPONSE_REQUEST_SCHEMA: &str = r#"{
+    "type": "object",
+    "required": ["body"],
+    "properties": {
+        "body": {"type": "string", "description": "Reply text; {{subject}} etc. are substituted"},
+        "subject": {"type": "string", "description": "Empty: Auto: <original subject>"},
+        "active": {"type": "boolean", "description": "Default true"},
+        "starts_at": {"type": "integer", "nullable": true, "description": "Unix; replies start"},
+        "ends_at": {"type": "integer", "nullable": true, "description": "Unix; replies stop"}
+    }
+}"#;
+
+#[derive(Deserialize)]
+struct AutoresponseBody {
+    body: String,
+    #[serde(default)]
+    subject: String,
+    #[serde(default = "default_active")]
+    active: bool,
+    #[serde(default)]
+    starts_at: Option<i64>,
+    #[serde(default)]
+    ends_at: Option<i64>,
+}
+
+fn default_active() -> bool {
+    true
+}
+
+fn autoresponse_err(e: ChatmailError) -> (u16, String) {
+    match e {
+        ChatmailError::Config(msg) => (400, msg),
+        other => db_err(other),
+    }
+}
+
+fn autoresponse_json(a: &Autoresponse) -> Value {
+    json!({
+        "username": a.username,
+        "active": a.active,
+        "in_effect": a.in_effect(unix_now()),
+        "subject": a.subject,
+        "body": a.body,
+        "starts_at": a.starts_at,
+        "ends_at": a.ends_at,
+        "updated_at": a.updated_at,
+    })
+}
+
+/// `resource` is `/admin/accounts/{username}/autoresponse`; the account must exist.
+pub async fn autoresponse(
+    st: &AdminState,
+    method: &str,
+    resource: &str,
+    body: &Value,
+) -> AdminResult {
+    let path = resource.split_once('?').map_or(resource, |(p, _)| p);
+    let raw = path
+        .strip_prefix("/admin/accounts/")
+        .and_then(|r| r.strip_suffix("/autoresponse"))
+        .filter(|u| !u.is_empty() && !u.contains('/'))
+        .ok_or_else(|| (404, format!("unknown resource: {resource}")))?;
+    let decoded = percent_decode(raw).ok_or_else(|| (400, "invalid username encoding".into()))?;
+    let username =
+        chatmail_auth::normalize_username(decoded.trim()).map_err(|e| (400, e.to_string()))?;
+    if !passwords::user_exists(&st.pool, &username)
+        .await
+        .map_err(db_err)?
+    {
+        return Err((404, format!("account not found: {username}")));
+    }
+    match method {
+        "GET" => match chatmail_db::get_autoresponse(&st.pool, &username)
+            .await
+            .map_err(db_err)?
+        {
+            Some(a) => Ok((200, Some(autoresponse_json(&a)))),
+            None => Err((404, format!("no autoresponse for {username}"))),
+        },
+        "PUT" => {
+            let req: AutoresponseBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let stored = chatmail_db::set_autoresponse(
+                &st.pool,
+                &Autoresponse {
+                    username,
+                    active: req.active,
+                    subject: req.subject,
+                    body: req.body,
+                    starts_at: req.starts_at,
+                    ends_at: req.ends_at,
+                    updated_at: 0,
+                },
+            )
+            .await
+            .map_err(autoresponse_err)?;
+            refresh_cache(st).await;
+            Ok((200, Some(autoresponse_json(&stored))))
+        }
+        "DELETE" => {
+            if !chatmail_db::clear_autoresponse(&st.pool, &username)
+                .await
+                .map_err(db_err)?
+            {
+                return Err((404, format!("no autoresponse for {username}")));
+            }
+            refresh_cache(st).await;
+            Ok((200, Some(json!({ "cleared": username }))))
+        }
+        _ => Err((
+            405,
+            format!("method {method} not allowed for /admin/accounts/{{username}}/autoresponse"),
+        )),
+    }
+}
+
+/// Make the change visible to delivery now; on failure the server keeps the old reply until
+/// the next [`chatmail_state::RESPONDER_REFRESH_INTERVAL`] reload.
+async fn refresh_cache(st: &AdminState) {
+    if let Err(e) = st.app.responders.refresh(&st.pool).await {
+        tracing::warn!(error = %e, "autoresponse cache refresh failed");
+    }
+}
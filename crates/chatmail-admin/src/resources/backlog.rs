@@ -0,0 +1,155 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/backlog` — accounts whose INBOX holds accepted-but-not-fetched mail.
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use super::AdminResult;
+use crate::AdminState;
+use chatmail_config::parse_duration;
+use chatmail_storage::{inbox_backlog, DEFAULT_BACKLOG_MIN_AGE, DEFAULT_BACKLOG_MIN_COUNT};
+
+#[derive(Deserialize, Default)]
+struct BacklogQuery {
+    /// `90m`, `24h`, `7d`; default 24h.
+    min_age: Option<String>,
+    min_count: Option<usize>,
+}
+
+pub async fn backlog(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed, use GET")));
+    }
+    let q: BacklogQuery = if body.is_null() {
+        BacklogQuery::default()
+    } else {
+        serde_json::from_value(body.clone())
+            .map_err(|e| (400, format!("invalid request body: {e}")))?
+    };
+    let min_age = match q.min_age.as_deref() {
+        Some(raw) => parse_duration(raw).map_err(|_| (400, format!("invalid min_age: {raw}")))?,
+        None => DEFAULT_BACKLOG_MIN_AGE,
+    };
+    let min_count = q.min_count.unwrap_or(DEFAULT_BACKLOG_MIN_COUNT);
+
+    let report = inbox_backlog(&st.app.mailbox_store, min_age, min_count)
+        .await
+        .map_err(|e| (500, e.to_string()))?;
+    let accounts: Vec<Value> = report
+        .accounts
+        .iter()
+        .map(|a| {
+            json!({
+                "username": a.username,
+                "stale": a.stale,
+                "stale_bytes": a.stale_bytes,
+                "oldest": a.oldest,
+            })
+        })
+        .collect();
+    Ok((
+        200,
+        Some(json!({
+            "min_age_secs": min_age.as_secs(),
+            "min_count": min_count,
+            "scanned": report.scanned,
+            "stale": report.stale,
+            "stale_bytes": report.stale_bytes,
+            "accounts": accounts,
+        })),
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use chatmail_config::AppConfig;
+    use chatmail_db::init_memory_db;
+    use chatmail_state::AppState;
+    use chatmail_storage::{store_add_flags, write_blob};
+    use tempfile::TempDir;
+
+    use super::*;
+
+    async fn test_admin_state() -> (AdminState, TempDir) {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let st = AdminState::new(
+            pool,
+            app,
+            AppConfig::default(),
+            dir.path().to_path_buf(),
+            "example.org".into(),
+            "secret-token-01234567890123456789012345678901".into(),
+            None,
+        );
+        (st, dir)
+    }
+
+    #[tokio::test]
+    async fn lists_unfetched_inboxes() {
+        let (st, _dir) = test_admin_state().await;
+        let store = &st.app.mailbox_store;
+        for (user, n) in [("idle@example.org", 3), ("active@example.org", 2)] {
+            store.init_user_dir(user).await.unwrap();
+            for i in 0..n {
+                let body = format!("Subject: {user} {i}\r\n\r\nx");
+                write_blob(store, user, &format!("m{i}"), body.as_bytes())
+                    .await
+                    .unwrap();
+            }
+        }
+        // The active client fetched its mail.
+        for i in 0..2 {
+            store_add_flags(
+                store,
+                "active@example.org",
+                "INBOX",
+                &format!("m{i}"),
+                true,
+                false,
+            )
+            .await
+            .unwrap();
+        }
+
+        let (code, v) = backlog(&st, "GET", &json!({ "min_age": "0s", "min_count": 1 }))
+            .await
+            .unwrap();
+        assert_eq!(code, 200);
+        let v = v.unwrap();
+        assert_eq!(v["scanned"], 2);
+        assert_eq!(v["stale"], 3);
+        let accounts = v["accounts"].as_array().unwrap();
+        assert_eq!(accounts.len(), 1);
+        assert_eq!(accounts[0]["username"], "idle@example.org");
+
+        // Defaults (24h / 50) hide freshly delivered mail.
+        let (_, v) = backlog(&st, "GET", &Value::Null).await.unwrap();
+        let v = v.unwrap();
+        assert_eq!(v["min_count"], 50);
+        assert!(v["accounts"].as_array().unwrap().is_empty());
+
+        let err = backlog(&st, "GET", &json!({ "min_age": "soon" })).await;
+        assert_eq!(err.unwrap_err().0, 400);
+        assert_eq!(backlog(&st, "POST", &json!({})).await.unwrap_err().0, 405);
+    }
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `POST /admin/backup` — online copies of the SQLite databases under `{state_dir}/backups`.
+
+use chatmail_db::policies::unix_now;
+use chatmail_db::{backup_databases, backup_stamp, sqlite_databases, BACKUP_DIR};
+use serde_json::json;
+
+use super::AdminResult;
+use crate::AdminState;
+
+pub(crate) const BACKUP_RESPONSE_SCHEMA: &str = r#"{
+    "type": "object",
+    "properties": {
+        "directory": {"type": "string"},
+        "databases": {
+            "type": "array",
+            "items": {
+                "type": "object",
+                "properties": {
+                    "name": {"type": "string"},
+                    "source": {"type": "string"},
+                    "path": {"type": "string", "nullable": true},
+                    "bytes": {"type": "integer"},
+                    "error": {"type": "string", "nullable": true}
+                }
+            }
+        },
+        "failed": {"type": "integer"}
+    }
+}"#;
+
+/// Copies are written server-side only; the target directory is not a request field, so
+/// the API cannot be pointed at arbitrary paths. Per-database failures are reported in the
+/// body rather than failing the request.
+pub async fn backup(st: &AdminState, method: &str) -> AdminResult {
+    if method != "POST" {
+        return Err((405, format!("method {method} not allowed, use POST")));
+    }
+    let dbs = sqlite_databases(&st.state_dir, &st.file_config).map_err(|e| (400, e.to_string()))?;
+    let dir = st.state_dir.join(BACKUP_DIR);
+    let report = backup_databases(&dbs, &dir, &backup_stamp(unix_now())).await;
+    let databases: Vec<_> = report
+        .iter()
+        .map(|db| {
+            json!({
+                "name": db.name,
+                "source": db.source,
+                "path": db.output,
+                "bytes": db.bytes,
+                "error": db.error,
+            })
+        })
+        .collect();
+    let failed = report.iter().filter(|db| db.error.is_some()).count();
+    Ok((
+        200,
+        Some(json!({
+            "directory": dir,
+            "databases": databases,
+            "failed": failed,
+        })),
+    ))
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/bans` — banned IPs / networks and `ja4:` TLS fingerprints (`bans`) and the
+//! `ban_audit` trail.
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+use chatmail_config::parse_duration;
+use chatmail_db::bans::{Ban, BanAudit, BAN_SOURCE_ADMIN};
+use chatmail_db::policies::unix_now;
+use chatmail_types::ChatmailError;
+
+/// Audit rows returned by `GET`.
+const AUDIT_LIMIT: i64 = 100;
+const ACTOR: &str = "admin-api";
+
+#[derive(Deserialize)]
+struct BanPost {
+    cidr: String,
+    #[serde(default)]
+    reason: String,
+    /// Absolute Unix seconds; omitted (and no `ttl`) = permanent.
+    expires_at: Option<i64>,
+    /// Relative lifetime (`90m`, `24h`, `7d`); ignored when `expires_at` is set.
+    ttl: Option<String>,
+}
+
+#[derive(Deserialize)]
+struct CidrBody {
+    cidr: String,
+}
+
+fn ban_err(e: ChatmailError) -> (u16, String) {
+    match e {
+        ChatmailError::Config(msg) => (400, msg),
+        other => db_err(other),
+    }
+}
+
+fn ban_json(b: &Ban, now: i64) -> Value {
+    json!({
+        "cidr": b.cidr,
+        "reason": b.reason,
+        "source": b.source,
+        "created_at": b.created_at,
+        "expires_at": b.expires_at,
+        "strikes": b.strikes,
+        "active": b.is_active(now),
+    })
+}
+
+fn audit_json(a: &BanAudit) -> Value {
+    json!({
+        "action": a.action,
+        "cidr": a.cidr,
+        "source": a.source,
+        "actor": a.actor,
+        "reason": a.reason,
+        "created_at": a.created_at,
+    })
+}
+
+/// Apply a change to the running listeners at once.
+async fn refresh(st: &AdminState) -> Result<(), (u16, String)> {
+    st.app.bans.refresh(&st.pool).await.map_err(db_err)
+}
+
+pub async fn bans(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    match method {
+        "GET" => {
+            let include_expired = body
+                .get("include_expired")
+                .and_then(Value::as_bool)
+                .unwrap_or(false);
+            let now = unix_now();
+            let bans: Vec<_> = chatmail_db::list_bans(&st.pool, include_expired)
+                .await
+                .map_err(db_err)?
+                .iter()
+                .map(|b| ban_json(b, now))
+                .collect();
+            let audit: Vec<_> = chatmail_db::list_ban_audit(&st.pool, AUDIT_LIMIT)
+                .await
+                .map_err(db_err)?
+                .iter()
+                .map(audit_json)
+                .collect();
+            Ok((
+                200,
+                Some(json!({
+                    "bans": bans,
+                    "total": bans.len(),
+                    "audit": audit,
+                })),
+            ))
+        }
+        "POST" => {
+            let req: BanPost =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let expires_at = match (req.expires_at, req.ttl.as_deref()) {
+                (Some(at), _) => at,
+                (None, Some(ttl)) => {
+                    let d =
+                        parse_duration(ttl).map_err(|_| (400, format!("invalid ttl: {ttl}")))?;
+                    unix_now() + d.as_secs() as i64
+                }
+                (None, None) => 0,
+            };
+            let ban = chatmail_db::add_ban(
+                &st.pool,
+                &req.cidr,
+                BAN_SOURCE_ADMIN,
+                &req.reason,
+                expires_at,
+            )
+            .await
+            .map_err(ban_err)?;
+            chatmail_db::record_ban_audit(
+                &st.pool,
+                "add",
+                &ban.cidr,
+                BAN_SOURCE_ADMIN,
+                ACTOR,
+                &ban.reason,
+            )
+            .await
+            .map_err(db_err)?;
+            refresh(st).await?;
+            st.app.publish_ban_added(&ban, ACTOR);
+            Ok((200, Some(ban_json(&ban, unix_now()))))
+        }
+        "DELETE" => {
+            let req: CidrBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let cidr = chatmail_db::normalize_ban_target(&req.cidr).map_err(ban_err)?;
+            let Some(ban) = chatmail_db::get_ban(&st.pool, &cidr)
+                .await
+                .map_err(db_err)?
+            else {
+                return Err((404, format!("no ban for {cidr}")));
+            };
+            chatmail_db::remove_ban(&st.pool, &cidr)
+                .await
+                .map_err(ban_err)?;
+            chatmail_db::record_ban_audit(&st.pool, "remove", &cidr, &ban.source, ACTOR, "")
+                .await
+                .map_err(db_err)?;
+            refresh(st).await?;
+            Ok((200, Some(json!({ "cidr": cidr, "removed": true }))))
+        }
+        _ => Err((405, format!("method {method} not allowed"))),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use chatmail_config::AppConfig;
+    use chatmail_db::init_memory_db;
+    use chatmail_state::AppState;
+    use tempfile::TempDir;
+
+    use super::*;
+
+    async fn test_admin_state() -> (AdminState, TempDir) {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let st = AdminState::new(
+            pool,
+            app,
+            AppConfig::default(),
+            dir.path().to_path_buf(),
+            "example.org".into(),
+            "secret-token-01234567890123456789012345678901".into(),
+            None,
+        );
+        (st, dir)
+    }
+
+    #[tokio::test]
+    async fn add_list_remove_with_audit() {
+        let (st, _dir) = test_admin_state().await;
+        let ip = "198.51.100.23".parse().unwrap();
+
+        let (code, v) = bans(
+            &st,
+            "POST",
+            &json!({ "cidr": "198.51.100.0/24", "reason": "spam", "ttl": "1h" }),
+        )
+        .await
+        .unwrap();
+        assert_eq!(code, 200);
+        let v = v.unwrap();
+        assert_eq!(v["cidr"], "198.51.100.0/24");
+        assert_eq!(v["source"], BAN_SOURCE_ADMIN);
+        assert!(st.app.bans.is_banned(ip));
+
+        let (_, v) = bans(&st, "GET", &Value::Null).await.unwrap();
+        let v = v.unwrap();
+        assert_eq!(v["total"], 1);
+        assert_eq!(v["audit"][0]["action"], "add");
+        assert_eq!(v["audit"][0]["actor"], ACTOR);
+
+        let (code, _) = bans(&st, "DELETE", &json!({ "cidr": "198.51.100.9/24" }))
+            .await
+            .unwrap();
+        assert_eq!(code, 200);
+        assert!(!st.app.bans.is_banned(ip));
+        let err = bans(&st, "DELETE", &json!({ "cidr": "198.51.100.0/24" }))
+            .await
+            .unwrap_err();
+        assert_eq!(err.0, 404);
+
+        let (_, v) = bans(&st, "GET", &Value::Null).await.unwrap();
+        assert_eq!(v.unwrap()["audit"][0]["action"], "remove");
+    }
+
+    #[tokio::test]
+    async fn rejects_bad_input() {
+        let (st, _dir) = test_admin_state().await;
+        for body in [
+            json!({ "cidr": "not-an-ip" }),
+            json!({ "cidr": "ja4:t13d1516h2_not_hex" }),
+            json!({ "cidr": "10.0.0.1", "ttl": "soon" }),
+            json!({}),
+        ] {
+            let err = bans(&st, "POST", &body).await.unwrap_err();
+            assert_eq!(err.0, 400, "{body}");
+        }
+        assert_eq!(bans(&st, "PUT", &Value::Null).await.unwrap_err().0, 405);
+    }
+}
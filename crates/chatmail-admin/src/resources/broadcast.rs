@@ -0,0 +1,164 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/broadcast` — announcements delivered into the INBOX of every (or every matching)
+//! local account by a paced background job (`madmail broadcast send`);
+//! `/admin/broadcast/{id}` reports one job.
+
+use std::sync::Arc;
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use chatmail_db::list_broadcast_audit;
+use chatmail_db::policies::unix_now;
+use chatmail_delivery::broadcast::{start_broadcast, Announcement};
+use chatmail_state::{select_recipients, BroadcastFilter};
+use chatmail_types::ChatmailError;
+
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+/// Audit rows returned by `GET /admin/broadcast`.
+const AUDIT_ROWS: i64 = 50;
+
+#[derive(Deserialize)]
+struct BroadcastRequest {
+    subject: String,
+    body: String,
+    #[serde(default)]
+    markdown: bool,
+    /// `all` (default), `active:<duration>`, `domain:<domain>`, `created_after:<date>` or
+    /// `accounts:<addr>,...`.
+    #[serde(default)]
+    filter: String,
+    /// Count the recipients without sending anything.
+    #[serde(default)]
+    dry_run: bool,
+    /// Recorded in the audit log (`admin-api` unless the CLI says otherwise).
+    #[serde(default)]
+    actor: Option<String>,
+}
+
+pub async fn broadcast(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    match method {
+        "GET" => {
+            let jobs: Vec<Value> = st
+                .app
+                .broadcasts
+                .list()
+                .iter()
+                .rev()
+                .map(|j| j.to_json())
+                .collect();
+            let audit: Vec<Value> = list_broadcast_audit(&st.pool, AUDIT_ROWS)
+                .await
+                .map_err(db_err)?
+                .into_iter()
+                .map(|a| {
+                    json!({
+                        "action": a.action,
+                        "id": a.job_id,
+                        "subject": a.subject,
+                        "filter": a.filter,
+                        "actor": a.actor,
+                        "recipients": a.recipients,
+                        "delivered": a.delivered,
+                        "failed": a.failed,
+                        "created_at": a.created_at,
+                    })
+                })
+                .collect();
+            Ok((
+                200,
+                Some(json!({
+                    "jobs": jobs,
+                    "audit": audit,
+                    "sender": st.app.broadcasts.sender(&st.mail_domain),
+                    "rate": st.app.broadcasts.rate(),
+                })),
+            ))
+        }
+        "POST" => {
+            let req: BroadcastRequest = serde_json::from_value(body.clone())
+                .map_err(|e| (400, format!("invalid request body: {e}")))?;
+            if req.subject.trim().is_empty() {
+                return Err((400, "subject is required".into()));
+            }
+            if req.body.trim().is_empty() {
+                return Err((400, "body is required".into()));
+            }
+            let filter = BroadcastFilter::parse(&req.filter).map_err(|e| (400, e.to_string()))?;
+            if req.dry_run {
+                let recipients = select_recipients(&st.pool, &filter, unix_now())
+                    .await
+                    .map_err(filter_err)?;
+                return Ok((
+                    200,
+                    Some(json!({
+                        "dry_run": true,
+                        "filter": filter.label(),
+                        "recipients": recipients.len(),
+                    })),
+                ));
+            }
+            let actor = req
+                .actor
+                .filter(|a| !a.trim().is_empty())
+                .unwrap_or_else(|| "admin-api".into());
+            let job = start_broadcast(
+                Arc::clone(&st.app),
+                st.pool.clone(),
+                &st.mail_domain,
+                Announcement {
+                    subject: req.subject,
+                    body: req.body,
+                    markdown: req.markdown,
+                },
+                filter,
+                &actor,
+            )
+            .await
+            .map_err(filter_err)?;
+            Ok((202, Some(job.to_json())))
+        }
+        _ => Err((405, format!("method {method} not allowed"))),
+    }
+}
+
+/// `GET /admin/broadcast/{id}` — one job from the in-memory list.
+pub async fn job(st: &AdminState, method: &str, resource: &str) -> AdminResult {
+    let id = resource.strip_prefix("/admin/broadcast/").unwrap_or("");
+    if id.is_empty() || id.contains('/') {
+        return Err((404, format!("unknown resource: {resource}")));
+    }
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    match st.app.broadcasts.get(id) {
+        Some(job) => Ok((200, Some(job.to_json()))),
+        None => Err((404, format!("broadcast {id} not found"))),
+    }
+}
+
+/// An `accounts:` filter naming an unknown address is the caller's mistake.
+fn filter_err(e: ChatmailError) -> (u16, String) {
+    match e {
+        ChatmailError::Config(msg) => (400, msg),
+        other => db_err(other),
+    }
+}
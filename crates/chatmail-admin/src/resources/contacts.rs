@@ -0,0 +1,166 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/contacts` — contact sharing links in `sharing.db` (`chatmail sharing`).
+//!
+//! Reads and writes go through the same pool as the view flusher, which is the file the
+//! web endpoint looks slugs up in on every request: a deleted or renamed link stops
+//! resolving on the next page load.
+
+use chatmail_db::{
+    get_sharing_contact, is_reserved_slug, list_sharing_contacts_page, remove_sharing_contact,
+    rename_sharing_contact, set_sharing_contact_name, sharing_slug_exists, validate_slug,
+    SharingContact,
+};
+use serde::Deserialize;
+use serde_json::{json, Value};
+use sqlx::SqlitePool;
+
+use super::accounts::{percent_decode, ListQuery};
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+/// `PATCH /admin/contacts/{slug}` body; either field may be left out.
+#[derive(Deserialize)]
+struct ContactPatch {
+    name: Option<String>,
+    slug: Option<String>,
+}
+
+/// One link; counters are left out with `sharing_analytics off`, like `chatmail sharing list`.
+fn contact_json(st: &AdminState, c: SharingContact) -> Value {
+    let mut entry = json!({
+        "slug": c.slug,
+        "kind": c.kind,
+        "name": c.name,
+        "url": c.url,
+        "member_note": c.member_note,
+        "description": c.description,
+        "created_at": c.created_at,
+        "expires_at": c.expires_at,
+    });
+    if st.app.share_views.enabled() {
+        entry["views"] = c.views.into();
+        entry["last_viewed"] = c.last_viewed.into();
+    }
+    entry
+}
+
+async fn sharing_pool(st: &AdminState) -> Result<&SqlitePool, (u16, String)> {
+    st.app.share_views.pool().await.map_err(db_err)
+}
+
+async fn list_contacts(st: &AdminState, query: &str) -> AdminResult {
+    let ListQuery { page, per_page, .. } = ListQuery::parse(query)?;
+    let offset = (page - 1).saturating_mul(per_page);
+    let pool = sharing_pool(st).await?;
+    let (rows, total) = list_sharing_contacts_page(pool, offset, per_page)
+        .await
+        .map_err(db_err)?;
+    let contacts: Vec<Value> = rows.into_iter().map(|c| contact_json(st, c)).collect();
+    Ok((
+        200,
+        Some(json!({
+            "contacts": contacts,
+            "total": total,
+            "page": page,
+            "per_page": per_page,
+        })),
+    ))
+}
+
+/// Rename `slug` to `new`, rejecting built-in and `sharing_reserved` slugs and live links.
+async fn rename_contact(st: &AdminState, slug: &str, new: &str) -> Result<(), (u16, String)> {
+    validate_slug(new).map_err(|e| (400, e.to_string()))?;
+    if is_reserved_slug(new) || st.app.policies.is_reserved_slug(new) {
+        return Err((400, format!("slug {new} is reserved")));
+    }
+    let pool = sharing_pool(st).await?;
+    if new != slug && sharing_slug_exists(pool, new).await.map_err(db_err)? {
+        return Err((409, format!("slug {new} is already taken")));
+    }
+    if !rename_sharing_contact(pool, slug, new)
+        .await
+        .map_err(db_err)?
+    {
+        return Err((404, format!("contact not found: {slug}")));
+    }
+    Ok(())
+}
+
+/// `/admin/contacts/{slug}`: `GET` one link, `DELETE` it, `PATCH` its name and/or slug.
+async fn contact(st: &AdminState, method: &str, raw_slug: &str, body: &Value) -> AdminResult {
+    let slug = percent_decode(raw_slug).ok_or_else(|| (400, "invalid slug encoding".into()))?;
+    let pool = sharing_pool(st).await?;
+    let Some(existing) = get_sharing_contact(pool, &slug).await.map_err(db_err)? else {
+        return Err((404, format!("contact not found: {slug}")));
+    };
+    match method {
+        "GET" => Ok((200, Some(contact_json(st, existing)))),
+        "DELETE" => {
+            remove_sharing_contact(pool, &slug).await.map_err(db_err)?;
+            Ok((200, Some(json!({ "deleted": slug }))))
+        }
+        "PATCH" => {
+            let patch: ContactPatch = serde_json::from_value(body.clone())
+                .map_err(|e| (400, format!("invalid request body: {e}")))?;
+            if patch.name.is_none() && patch.slug.is_none() {
+                return Err((400, "nothing to change: set name and/or slug".into()));
+            }
+            let mut slug = slug;
+            if let Some(new) = patch.slug.as_deref().map(str::trim) {
+                rename_contact(st, &slug, new).await?;
+                slug = new.to_string();
+            }
+            if let Some(name) = patch.name.as_deref() {
+                set_sharing_contact_name(pool, &slug, name.trim())
+                    .await
+                    .map_err(db_err)?;
+            }
+            let updated = get_sharing_contact(pool, &slug)
+                .await
+                .map_err(db_err)?
+                .ok_or_else(|| (404, format!("contact not found: {slug}")))?;
+            Ok((200, Some(contact_json(st, updated))))
+        }
+        _ => Err((
+            405,
+            format!("method {method} not allowed for /admin/contacts/{{slug}}"),
+        )),
+    }
+}
+
+pub async fn contacts(st: &AdminState, method: &str, resource: &str, body: &Value) -> AdminResult {
+    let (path, query) = resource.split_once('?').unwrap_or((resource, ""));
+    match path.strip_prefix("/admin/contacts") {
+        Some("") => {}
+        Some(rest) => match rest.strip_prefix('/') {
+            Some(slug) if !slug.is_empty() && !slug.contains('/') => {
+                return contact(st, method, slug, body).await;
+            }
+            _ => return Err((404, format!("unknown resource: {resource}"))),
+        },
+        None => return Err((404, format!("unknown resource: {resource}"))),
+    }
+    match method {
+        "GET" => list_contacts(st, query).await,
+        _ => Err((
+            405,
+            format!("method {method} not allowed for /admin/contacts"),
+        )),
+    }
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Outbound delivery throttle state (`GET /admin/delivery-stats`, `madmail delivery-stats`).
+
+use std::time::Instant;
+
+use serde_json::json;
+
+use super::AdminResult;
+use crate::AdminState;
+
+pub async fn delivery_stats(st: &AdminState, method: &str) -> AdminResult {
+    if method != "GET" {
+        return Err((405, "use GET".into()));
+    }
+    let throttle = &st.app.delivery_throttle;
+    let defaults = throttle.defaults();
+    let queue_depth = match chatmail_delivery::outbound_queue() {
+        Some(q) => Some(q.depth().await.map_err(|e| (500, e.to_string()))?),
+        None => None,
+    };
+    let domains: Vec<_> = throttle
+        .snapshot(Instant::now())
+        .into_iter()
+        .map(|r| {
+            json!({
+                "domain": r.domain,
+                "in_flight": r.in_flight,
+                "started_last_minute": r.started_last_minute,
+                "max_parallel": r.max_parallel,
+                "max_per_minute": r.max_per_minute,
+                "effective_parallel": r.effective_parallel,
+                "effective_per_minute": r.effective_per_minute,
+                "reduced": r.reduced,
+                "rate_limited": r.rate_limited,
+                "waits": r.waits,
+                "delivered": r.delivered,
+            })
+        })
+        .collect();
+    Ok((
+        200,
+        Some(json!({
+            "max_parallel_per_domain": defaults.max_parallel,
+            "max_messages_per_minute_per_domain": defaults.max_per_minute,
+            "queue_depth": queue_depth,
+            "domains": domains,
+            "total": domains.len(),
+        })),
+    ))
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! DKIM keys under `{state_dir}/dkim_keys` (`/admin/dkim`, `/rotate`, `/activate`).
+//!
+//! Rotation is two steps: `rotate` writes a key under a new date-based selector for every
+//! signing domain and returns the records to publish; `activate` switches signing to it once
+//! DNS has caught up. Old keys are left in place so mail in transit still verifies.
+
+use chatmail_db::{get_setting, set_setting, settings_keys};
+use chatmail_delivery::dkim::{
+    active_selectors, generate_key, list_keys, rotation_selector, signing_domains, valid_selector,
+    DkimAlgorithm, DkimKey,
+};
+use chatmail_types::ChatmailError;
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use super::status_storage::db_err;
+use super::AdminResult;
+use crate::AdminState;
+
+pub(crate) const DKIM_RESPONSE_SCHEMA: &str = r#"{
+    "type": "object",
+    "properties": {
+        "active": {"type": "array", "items": {"type": "string"}, "description": "Selectors signing now"},
+        "source": {"type": "string", "enum": ["setting", "config"]},
+        "domains": {"type": "array", "items": {"type": "string"}},
+        "keys": {
+            "type": "array",
+            "items": {
+                "type": "object",
+                "properties": {
+                    "domain": {"type": "string"},
+                    "selector": {"type": "string"},
+                    "algorithm": {"type": "string", "description": "rsa2048, rsa4096 or ed25519"},
+                    "created_at": {"type": "integer", "description": "Unix mtime of the key file"},
+                    "dns_name": {"type": "string"},
+                    "dns_value": {"type": "string", "description": "TXT value to publish"}
+                }
+            }
+        }
+    }
+}"#;
+
+pub(crate) const DKIM_ROTATE_REQUEST_SCHEMA: &str = r#"{
+    "type": "object",
+    "properties": {
+        "algorithm": {"type": "string", "enum": ["rsa2048", "rsa4096", "ed25519"]},
+        "selector": {"type": "string", "description": "Defaults to today's date (YYYYMMDD)"}
+    }
+}"#;
+
+pub(crate) const DKIM_ACTIVATE_REQUEST_SCHEMA: &str = r#"{
+    "type": "object",
+    "required": ["selector"],
+    "properties": {
+        "selector": {"type": "string"}
+    }
+}"#;
+
+#[derive(Debug, Default, Deserialize)]
+struct RotateBody {
+    /// `rsa2048` (default), `rsa4096` or `ed25519`.
+    algorithm: Option<String>,
+    /// Defaults to today's date (`20260316`, `20260316-2`, …).
+    selector: Option<String>,
+}
+
+#[derive(Debug, Deserialize)]
+struct ActivateBody {
+    selector: String,
+}
+
+fn domains(st: &AdminState) -> Vec<String> {
+    let local = st.file_config.effective_local_domains(&st.mail_domain);
+    signing_domains(&st.mail_domain, Some(&local.join(" ")))
+}
+
+fn keys_err(e: ChatmailError) -> (u16, String) {
+    match e {
+        ChatmailError::Config(msg) => (400, msg),
+        other => (500, other.to_string()),
+    }
+}
+
+/// `GET /admin/dkim` — key pairs with their DNS records, and the selectors signing now.
+pub async fn dkim(st: &AdminState, method: &str) -> AdminResult {
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    let keys = list_keys(&st.state_dir).map_err(keys_err)?;
+    let activated = get_setting(&st.pool, settings_keys::DKIM_SELECTOR)
+        .await
+        .map_err(db_err)?
+        .filter(|s| !s.trim().is_empty());
+    let active = active_selectors(&st.pool, &st.file_config.dkim_selectors)
+        .await
+        .map_err(db_err)?;
+    Ok((
+        200,
+        Some(json!({
+            "active": active,
+            "source": if activated.is_some() { "setting" } else { "config" },
+            "domains": domains(st),
+            "keys": keys,
+        })),
+    ))
+}
+
+/// `POST /admin/dkim/rotate` — new key pair per signing domain under a fresh selector; signing
+/// does not change until `/admin/dkim/activate`.
+pub async fn rotate(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    if method != "POST" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    let req: RotateBody = if body.is_null() {
+        RotateBody::default()
+    } else {
+        serde_json::from_value(body.clone()).map_err(|e| (400, format!("invalid body: {e}")))?
+    };
+    let algorithm = match req.algorithm.as_deref() {
+        None => DkimAlgorithm::default(),
+        Some(raw) => {
+            DkimAlgorithm::parse(raw).ok_or_else(|| (400, format!("unknown algorithm {raw:?}")))?
+        }
+    };
+    let domains = domains(st);
+    if domains.is_empty() {
+        return Err((400, "DKIM needs a mail domain, not an IP address".into()));
+    }
+    let state_dir = st.state_dir.clone();
+    let selector = match req.selector {
+        Some(s) if !valid_selector(s.trim()) => {
+            return Err((400, format!("invalid selector {s:?}")));
+        }
+        Some(s) => s.trim().to_string(),
+        None => rotation_selector(&state_dir, &domains, time::OffsetDateTime::now_utc()),
+    };
+    let keys: Vec<DkimKey> = {
+        let selector = selector.clone();
+        let domains = domains.clone();
+        tokio::task::spawn_blocking(move || {
+            domains
+                .iter()
+                .map(|d| generate_key(&state_dir, d, &selector, algorithm))
+                .collect::<chatmail_types::Result<Vec<_>>>()
+        })
+        .await
+        .map_err(|e| (500, format!("key generation failed: {e}")))?
+        .map_err(keys_err)?
+    };
+    Ok((
+        200,
+        Some(json!({
+            "selector": selector,
+            "algorithm": algorithm.as_str(),
+            "keys": keys,
+        })),
+    ))
+}
+
+/// `POST /admin/dkim/activate` — sign with `selector` from the next message on. The primary
+/// domain must have a key for it; domains without one send unsigned.
+pub async fn activate(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    if method != "POST" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    let req: ActivateBody =
+        serde_json::from_value(body.clone()).map_err(|e| (400, format!("invalid body: {e}")))?;
+    let selector = req.selector.trim();
+    if !valid_selector(selector) {
+        return Err((400, format!("invalid selector {selector:?}")));
+    }
+    let keys = list_keys(&st.state_dir).map_err(keys_err)?;
+    let domains = domains(st);
+    let has_key = |d: &String| {
+        keys.iter()
+            .any(|k| k.domain == *d && k.selector == selector)
+    };
+    if !domains.first().is_some_and(|d| has_key(d)) {
+        return Err((404, format!("no DKIM key for selector {selector}")));
+    }
+    let missing: Vec<&String> = domains.iter().filter(|d| !has_key(*d)).collect();
+    set_setting(&st.pool, settings_keys::DKIM_SELECTOR, selector)
+        .await
+        .map_err(db_err)?;
+    Ok((
+        200,
+        Some(json!({ "selector": selector, "missing_domains": missing })),
+    ))
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/domains/catchall` — per-domain catch-all mailboxes (`madmail domains catchall`).
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use chatmail_auth::normalize_username;
+use chatmail_db::policies::unix_now;
+use chatmail_db::{blocklist, passwords, DEFAULT_CATCHALL_DAILY_CAP};
+use chatmail_types::ChatmailError;
+
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+#[derive(Deserialize)]
+struct CatchallBody {
+    domain: String,
+    #[serde(default)]
+    target: String,
+    #[serde(default)]
+    daily_cap: Option<i64>,
+}
+
+fn catchall_err(e: ChatmailError) -> (u16, String) {
+    match e {
+        ChatmailError::Config(msg) => (400, msg),
+        other => db_err(other),
+    }
+}
+
+fn local_domain(st: &AdminState, raw: &str) -> Result<String, (u16, String)> {
+    let domain = raw.trim().trim_end_matches('.').to_ascii_lowercase();
+    if domain.is_empty() {
+        return Err((400, "domain is required".into()));
+    }
+    let local = st.file_config.effective_local_domains(&st.mail_domain);
+    if !local.iter().any(|d| d.eq_ignore_ascii_case(&domain)) {
+        return Err((400, format!("{domain} is not a local domain")));
+    }
+    Ok(domain)
+}
+
+pub async fn catchall(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    let inactive = chatmail_db::catchall_inactive_reason(&st.pool, st.file_config.auth_auto_create)
+        .await
+        .map_err(db_err)?;
+    match method {
+        "GET" => {
+            let day = chatmail_db::catchall_day(unix_now());
+            let mut rows = Vec::new();
+            for c in chatmail_db::list_catchalls(&st.pool)
+                .await
+                .map_err(db_err)?
+            {
+                let today = chatmail_db::catchall_deliveries(&st.pool, &c.domain, day)
+                    .await
+                    .map_err(db_err)?;
+                rows.push(json!({
+                    "domain": c.domain,
+                    "target": c.target,
+                    "daily_cap": c.daily_cap,
+                    "today": today,
+                    "created_at": c.created_at,
+                }));
+            }
+            let total = rows.len();
+            Ok((
+                200,
+                Some(json!({
+                    "catchalls": rows,
+                    "total": total,
+                    "active": inactive.is_none(),
+                    "inactive_reason": inactive,
+                    "exclude": st.app.catchalls.exclude_patterns(),
+                })),
+            ))
+        }
+        "PUT" | "POST" => {
+            let req: CatchallBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let domain = local_domain(st, &req.domain)?;
+            if req.target.trim().is_empty() {
+                return Err((400, "target is required".into()));
+            }
+            let target = normalize_username(req.target.trim()).map_err(|e| (400, e.to_string()))?;
+            if !passwords::user_exists(&st.pool, &target)
+                .await
+                .map_err(db_err)?
+            {
+                return Err((400, format!("catch-all target {target} has no account")));
+            }
+            if blocklist::is_blocked(&st.pool, &target)
+                .await
+                .map_err(db_err)?
+            {
+                return Err((400, format!("catch-all target {target} is blocked")));
+            }
+            let daily_cap = req.daily_cap.unwrap_or(DEFAULT_CATCHALL_DAILY_CAP);
+            let c = chatmail_db::set_catchall(&st.pool, &domain, &target, daily_cap)
+                .await
+                .map_err(catchall_err)?;
+            st.app.catchalls.refresh(&st.pool).await.map_err(db_err)?;
+            Ok((
+                200,
+                Some(json!({
+                    "domain": c.domain,
+                    "target": c.target,
+                    "daily_cap": c.daily_cap,
+                    "active": inactive.is_none(),
+                    "inactive_reason": inactive,
+                })),
+            ))
+        }
+        "DELETE" => {
+            let req: CatchallBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let removed = chatmail_db::clear_catchall(&st.pool, &req.domain)
+                .await
+                .map_err(catchall_err)?;
+            if !removed {
+                return Err((404, format!("no catch-all for {}", req.domain.trim())));
+            }
+            st.app.catchalls.refresh(&st.pool).await.map_err(db_err)?;
+            Ok((
+                200,
+                Some(json!({ "removed": req.domain.trim().to_ascii_lowercase() })),
+            ))
+        }
+        _ => Err((
+            405,
+            format!("method {method} not allowed for /admin/domains/catchall"),
+        )),
+    }
+}
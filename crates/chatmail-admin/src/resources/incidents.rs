@@ -0,0 +1,75 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! `/admin/incidents` — status page incident log; admins add manual notes.
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use chatmail_db::{list_incidents, record_incident, INCIDENT_LOG_CAP, INCIDENT_NOTE};
+
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+#[derive(Deserialize)]
+struct NoteBody {
+    message: String,
+    /// Affected component (`smtp`, `imap`, …); empty = whole service.
+    #[serde(default)]
+    component: String,
+}
+
+pub async fn incidents(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    match method {
+        "GET" => {
+            let rows = list_incidents(&st.pool, INCIDENT_LOG_CAP)
+                .await
+                .map_err(db_err)?;
+            let incidents: Vec<_> = rows
+                .into_iter()
+                .map(|i| {
+                    json!({
+                        "id": i.id,
+                        "component": i.component,
+                        "kind": i.kind,
+                        "message": i.message,
+                        "created_at": i.created_at,
+                    })
+                })
+                .collect();
+            let total = incidents.len();
+            Ok((200, Some(json!({ "incidents": incidents, "total": total }))))
+        }
+        "POST" => {
+            let req: NoteBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let message = req.message.trim();
+            if message.is_empty() {
+                return Err((400, "message is required".into()));
+            }
+            let component = match req.component.trim() {
+                "" => "service",
+                c => c,
+            };
+            record_incident(&st.pool, component, INCIDENT_NOTE, message)
+                .await
+                .map_err(db_err)?;
+            tracing::info!(component, "admin: incident note added");
+            Ok((200, Some(json!({ "recorded": true }))))
+        }
+        _ => Err((405, format!("method {method} not allowed"))),
+    }
+}
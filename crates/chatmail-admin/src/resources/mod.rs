@@ -16,19 +16,37 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 mod accounts;
+pub(crate) mod audit;
+mod autoresponse;
+mod backlog;
+mod backup;
+mod bans;
 mod blocklist;
+mod broadcast;
+mod contacts;
+mod delivery_stats;
+pub(crate) mod dkim;
 mod dns;
+mod domains;
 mod exchangers;
 mod federation;
 mod federation_size;
+mod incidents;
 mod message_size;
 mod notice;
+pub(crate) mod openapi;
+mod peers;
+mod policies;
 mod proxy;
 mod push;
 mod queue;
 mod quota;
+mod rate_limits;
 mod settings;
+mod settings_preview;
+pub mod settings_transfer;
 mod status_storage;
+mod theme;
 mod toggles;
 mod tokens;
 mod webmail_dev;
@@ -44,6 +62,8 @@ pub async fn dispatch(st: &AdminState, method: &str, resource: &str, body: &Valu
         "/admin/status" => status_storage::status(st, method).await,
         "/admin/overview" => status_storage::overview(st, method).await,
         "/admin/storage" => status_storage::storage(st, method).await,
+        "/admin/storage/migrate" => status_storage::storage_migrate(st, method).await,
+        "/admin/backup" => backup::backup(st, method).await,
         "/admin/restart" => status_storage::restart(method),
         "/admin/reload" => status_storage::reload(st, method, body).await,
         "/admin/registration" => toggles::registration(st, method, body).await,
@@ -107,21 +127,56 @@ pub async fn dispatch(st: &AdminState, method: &str, resource: &str, body: &Valu
         "/admin/services/ss_ws" => proxy::proxy_transport_disabled(method, body).await,
         "/admin/services/ss_grpc" => proxy::proxy_transport_disabled(method, body).await,
         "/admin/services/http_proxy" => proxy::http_proxy_service(st, method, body).await,
+        "/admin/ss-stats" => proxy::ss_stats(st, method).await,
+        "/admin/ss/rotate" => proxy::ss_rotate(st, method, body).await,
         "/admin/settings/federation" => federation::policy(st, method, body).await,
         "/admin/federation/rules" => federation::rules(st, method, body).await,
         "/admin/federation/silent-dismiss" => federation::silent_dismiss(st, method, body).await,
         "/admin/federation/servers" => federation::servers(st, method).await,
-        "/admin/accounts" => accounts::accounts(st, method, body).await,
+        "/admin/delivery-stats" => delivery_stats::delivery_stats(st, method).await,
+        "/admin/rate-limits" => rate_limits::rate_limits(st, method, body).await,
+        r if r == "/admin/accounts" || r.starts_with("/admin/accounts?") => {
+            accounts::accounts(st, method, r, body).await
+        }
+        r if r.starts_with("/admin/accounts/")
+            && r.split('?')
+                .next()
+                .is_some_and(|p| p.ends_with("/autoresponse")) =>
+        {
+            autoresponse::autoresponse(st, method, r, body).await
+        }
+        r if r.starts_with("/admin/accounts/") => accounts::accounts(st, method, r, body).await,
+        r if r == "/admin/contacts" || r.starts_with("/admin/contacts?") => {
+            contacts::contacts(st, method, r, body).await
+        }
+        r if r.starts_with("/admin/contacts/") => contacts::contacts(st, method, r, body).await,
+        r if r == "/admin/audit" || r.starts_with("/admin/audit?") => {
+            audit::audit(st, method, r).await
+        }
         "/admin/blocklist" => blocklist::blocklist(st, method, body).await,
+        "/admin/bans" => bans::bans(st, method, body).await,
+        "/admin/backlog" => backlog::backlog(st, method, body).await,
         "/admin/quota" => quota::quota(st, method, body).await,
         "/admin/message-size" => message_size::message_size(st, method, body).await,
         "/admin/federation-size" => federation_size::federation_size(st, method, body).await,
         "/admin/dns" => dns::dns(st, method, body).await,
+        "/admin/dkim" => dkim::dkim(st, method).await,
+        "/admin/dkim/rotate" => dkim::rotate(st, method, body).await,
+        "/admin/dkim/activate" => dkim::activate(st, method, body).await,
+        "/admin/domains/catchall" => domains::catchall(st, method, body).await,
+        "/admin/peers" => peers::peers(st, method, body).await,
         "/admin/exchangers" => exchangers::exchangers(st, method, body).await,
         "/admin/registration-token" => tokens::registration_token(st, method, body).await,
         "/admin/notice" => notice::notice(st, method, body).await,
+        "/admin/broadcast" => broadcast::broadcast(st, method, body).await,
+        r if r.starts_with("/admin/broadcast/") => broadcast::job(st, method, r).await,
+        "/admin/incidents" => incidents::incidents(st, method, body).await,
         "/admin/queue" => queue::queue(st, method, body).await,
+        "/admin/theme" => theme::theme(st, method, body).await,
+        "/admin/openapi.json" => openapi::openapi(st, method).await,
         "/admin/settings" => settings::all_settings(st, method).await,
+        "/admin/settings/export" => settings_transfer::export(st, method, body).await,
+        r if r.starts_with("/admin/policies/") => policies::policies(st, method, r, body).await,
         r if r.starts_with("/admin/settings/") => {
             let name = r.strip_prefix("/admin/settings/").unwrap_or("");
             if proxy::PROXY_SETTING_NAMES.contains(&name)
@@ -0,0 +1,639 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `GET /admin/openapi.json` — OpenAPI 3.0 description of the admin resources.
+//!
+//! The admin API is one RPC endpoint, so each documented path is a `resource` of the
+//! envelope and its schemas describe the envelope's inner `body`. The document is built
+//! from [`REGISTRY`], which lists what [`super::dispatch`] routes; schemas are raw JSON
+//! next to the handlers they describe (e.g. [`super::settings::SETTING_RESPONSE_SCHEMA`]).
+//! `testdata/openapi.json` holds the rendered document; rerun the golden test with
+//! `UPDATE_GOLDEN=1` after changing the registry.
+
+use serde_json::{json, Map, Value};
+
+use super::{autoresponse, backup, dkim, proxy, settings, settings_transfer, AdminResult};
+use crate::AdminState;
+
+/// One resource of the admin API as it appears in the OpenAPI document.
+#[derive(Debug, Clone, Copy)]
+pub struct HandlerMeta {
+    /// Resource path; `{name}` segments become path parameters.
+    pub path: &'static str,
+    pub methods: &'static [&'static str],
+    pub summary: &'static str,
+    /// JSON Schema of the `POST` / `PUT` / `PATCH` body.
+    pub request_schema: Option<&'static str>,
+    /// JSON Schema of a successful response body.
+    pub response_schema: Option<&'static str>,
+}
+
+const TITLE: &str = "madmail admin API";
+const DESCRIPTION: &str = "Every path is a `resource` of the admin RPC endpoint: send \
+     `POST <admin_path>` with `{\"method\", \"resource\", \"headers\", \"body\"}` and read \
+     `status`, `body` and `error` from the response envelope. Schemas describe the inner `body`.";
+const BEARER_DESCRIPTION: &str = "The admin token (`{state_dir}/admin_token`, \
+     `madmail admin-token`), sent as `Authorization: Bearer <token>` in the envelope `headers`.";
+
+/// Admin resources in the order [`super::dispatch`] matches them.
+pub static REGISTRY: &[HandlerMeta] = &[
+    HandlerMeta {
+        path: "/admin/status",
+        methods: &["GET"],
+        summary: "Server status, counters and health checks",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/overview",
+        methods: &["GET"],
+        summary: "Dashboard overview",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/storage",
+        methods: &["GET"],
+        summary: "Storage usage",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/storage/migrate",
+        methods: &["GET", "POST"],
+        summary: "Blob store migration status / start",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/backup",
+        methods: &["POST"],
+        summary: "Online copies of the SQLite databases under {state_dir}/backups",
+        request_schema: None,
+        response_schema: Some(backup::BACKUP_RESPONSE_SCHEMA),
+    },
+    HandlerMeta {
+        path: "/admin/restart",
+        methods: &["POST"],
+        summary: "Request a restart",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/reload",
+        methods: &["POST"],
+        summary: "Reload configuration",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/registration",
+        methods: &["GET", "POST"],
+        summary: "Open or close `/new` registration",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/registration/jit",
+        methods: &["GET", "POST"],
+        summary: "Just-in-time registration on first login",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/registration-token",
+        methods: &["GET", "POST", "DELETE"],
+        summary: "Registration tokens",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/turn",
+        methods: &["GET", "POST"],
+        summary: "TURN service toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/iroh",
+        methods: &["GET", "POST"],
+        summary: "Iroh relay toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/push",
+        methods: &["GET", "POST"],
+        summary: "Push notification mode",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/admin_web",
+        methods: &["GET", "POST"],
+        summary: "Admin web UI toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/auto_purge_seen",
+        methods: &["GET", "POST"],
+        summary: "Purge seen messages toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/message_retention",
+        methods: &["GET", "POST"],
+        summary: "Message retention toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/webimap",
+        methods: &["GET", "POST"],
+        summary: "HTTP IMAP API toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/websmtp",
+        methods: &["GET", "POST"],
+        summary: "HTTP SMTP API toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/webmail_dev",
+        methods: &["GET", "POST"],
+        summary: "Webmail development mode",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/shadowsocks",
+        methods: &["GET", "POST"],
+        summary: "Shadowsocks proxy toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/ss_ws",
+        methods: &["GET", "POST"],
+        summary: "Shadowsocks WebSocket transport (always off)",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/ss_grpc",
+        methods: &["GET", "POST"],
+        summary: "Shadowsocks gRPC transport (always off)",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/ss-stats",
+        methods: &["GET"],
+        summary: "Shadowsocks relay traffic counters",
+        request_schema: None,
+        response_schema: Some(proxy::SS_STATS_RESPONSE_SCHEMA),
+    },
+    HandlerMeta {
+        path: "/admin/ss/rotate",
+        methods: &["POST"],
+        summary: "Rotate the Shadowsocks password, keeping the old one for a grace period",
+        request_schema: Some(proxy::SS_ROTATE_REQUEST_SCHEMA),
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/services/http_proxy",
+        methods: &["GET", "POST"],
+        summary: "HTTP proxy toggle",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/settings",
+        methods: &["GET"],
+        summary: "All settings with effective values",
+        request_schema: None,
+        response_schema: Some(settings::ALL_SETTINGS_RESPONSE_SCHEMA),
+    },
+    HandlerMeta {
+        path: "/admin/settings/export",
+        methods: &["GET"],
+        summary: "Export stored settings",
+        request_schema: None,
+        response_schema: Some(settings_transfer::EXPORT_RESPONSE_SCHEMA),
+    },
+    HandlerMeta {
+        path: "/admin/settings/federation",
+        methods: &["GET", "POST"],
+        summary: "Federation policy and size cap",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/settings/{name}",
+        methods: &["GET", "POST"],
+        summary: "One named setting (`set` / `reset`)",
+        request_schema: Some(settings::SETTING_ACTION_REQUEST_SCHEMA),
+        response_schema: Some(settings::SETTING_RESPONSE_SCHEMA),
+    },
+    HandlerMeta {
+        path: "/admin/federation/rules",
+        methods: &["GET", "POST", "DELETE"],
+        summary: "Federation allow / block rules",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/federation/silent-dismiss",
+        methods: &["GET", "POST", "DELETE"],
+        summary: "Domains whose mail is silently dropped",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/federation/servers",
+        methods: &["GET"],
+        summary: "Federation peers seen in traffic",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/federation-size",
+        methods: &["GET", "PUT", "DELETE"],
+        summary: "Federation body size cap",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/message-size",
+        methods: &["GET", "PUT", "DELETE"],
+        summary: "Message size cap",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/delivery-stats",
+        methods: &["GET"],
+        summary: "Delivery statistics",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/rate-limits",
+        methods: &["GET", "DELETE"],
+        summary: "Per-account sending counters",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/accounts",
+        methods: &["GET", "POST", "DELETE", "PATCH"],
+        summary: "Accounts (list, create, delete, bulk actions)",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/accounts/{username}",
+        methods: &["GET", "DELETE"],
+        summary: "One account",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/accounts/{username}/autoresponse",
+        methods: &["GET", "PUT", "DELETE"],
+        summary: "Account automatic reply (vacation notice)",
+        request_schema: Some(autoresponse::AUTORESPONSE_REQUEST_SCHEMA),
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/contacts",
+        methods: &["GET"],
+        summary: "Contact share links",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/contacts/{slug}",
+        methods: &["GET", "DELETE", "PATCH"],
+        summary: "One contact share link",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/audit",
+        methods: &["GET"],
+        summary: "Admin API audit trail",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/blocklist",
+        methods: &["GET", "POST", "DELETE", "PATCH"],
+        summary: "Blocked usernames",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/bans",
+        methods: &["GET", "POST", "DELETE"],
+        summary: "IP bans",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/backlog",
+        methods: &["GET"],
+        summary: "Unfetched inboxes",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/quota",
+        methods: &["GET", "PUT", "POST", "DELETE"],
+        summary: "Storage quotas",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/dns",
+        methods: &["GET", "POST", "DELETE"],
+        summary: "Delivery endpoint overrides",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/dkim",
+        methods: &["GET"],
+        summary: "DKIM keys, their DNS records and the selectors signing now",
+        request_schema: None,
+        response_schema: Some(dkim::DKIM_RESPONSE_SCHEMA),
+    },
+    HandlerMeta {
+        path: "/admin/dkim/rotate",
+        methods: &["POST"],
+        summary: "Generate DKIM keys under a new selector; old keys are kept",
+        request_schema: Some(dkim::DKIM_ROTATE_REQUEST_SCHEMA),
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/dkim/activate",
+        methods: &["POST"],
+        summary: "Sign outbound mail with another DKIM selector",
+        request_schema: Some(dkim::DKIM_ACTIVATE_REQUEST_SCHEMA),
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/domains/catchall",
+        methods: &["GET", "PUT", "POST", "DELETE"],
+        summary: "Per-domain catch-all mailboxes",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/peers",
+        methods: &["GET", "POST", "PUT", "DELETE"],
+        summary: "Federation peers",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/exchangers",
+        methods: &["GET", "POST", "PUT", "DELETE"],
+        summary: "Exchangers",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/notice",
+        methods: &["GET", "POST"],
+        summary: "Send an admin notice",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/broadcast",
+        methods: &["GET", "POST"],
+        summary: "Announcement broadcasts",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/broadcast/{id}",
+        methods: &["GET"],
+        summary: "Broadcast job status",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/incidents",
+        methods: &["GET", "POST"],
+        summary: "Incident log",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/queue",
+        methods: &["POST"],
+        summary: "Queue maintenance",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/theme",
+        methods: &["GET", "POST"],
+        summary: "www theme",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/policies/{type}",
+        methods: &["GET", "POST", "DELETE"],
+        summary: "Policy entries of one type",
+        request_schema: None,
+        response_schema: None,
+    },
+    HandlerMeta {
+        path: "/admin/openapi.json",
+        methods: &["GET"],
+        summary: "This document",
+        request_schema: None,
+        response_schema: None,
+    },
+];
+
+pub async fn openapi(st: &AdminState, method: &str) -> AdminResult {
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    Ok((200, Some(openapi_document(&st.version))))
+}
+
+/// Render [`REGISTRY`] as an OpenAPI 3.0 document.
+pub fn openapi_document(version: &str) -> Value {
+    let mut paths = Map::new();
+    for meta in REGISTRY {
+        let mut item = Map::new();
+        let params: Vec<Value> = path_params(meta.path)
+            .map(|name| {
+                json!({
+                    "name": name,
+                    "in": "path",
+                    "required": true,
+                    "schema": { "type": "string" },
+                })
+            })
+            .collect();
+        if !params.is_empty() {
+            item.insert("parameters".into(), Value::Array(params));
+        }
+        for method in meta.methods {
+            item.insert(method.to_ascii_lowercase(), operation(meta, method));
+        }
+        paths.insert(meta.path.into(), Value::Object(item));
+    }
+    json!({
+        "openapi": "3.0.3",
+        "info": {
+            "title": TITLE,
+            "version": version,
+            "description": DESCRIPTION,
+        },
+        "paths": paths,
+        "components": {
+            "securitySchemes": {
+                "BearerAuth": {
+                    "type": "http",
+                    "scheme": "bearer",
+                    "description": BEARER_DESCRIPTION,
+                },
+            },
+        },
+        "security": [{ "BearerAuth": [] }],
+    })
+}
+
+fn operation(meta: &HandlerMeta, method: &str) -> Value {
+    let mut op = Map::new();
+    op.insert("operationId".into(), json!(operation_id(method, meta.path)));
+    op.insert("summary".into(), json!(meta.summary));
+    if matches!(method, "POST" | "PUT" | "PATCH") {
+        if let Some(schema) = meta.request_schema.and_then(parse_schema) {
+            op.insert(
+                "requestBody".into(),
+                json!({
+                    "required": true,
+                    "content": { "application/json": { "schema": schema } },
+                }),
+            );
+        }
+    }
+    let mut ok = json!({ "description": "Success" });
+    if let Some(schema) = meta.response_schema.and_then(parse_schema) {
+        ok["content"] = json!({ "application/json": { "schema": schema } });
+    }
+    op.insert(
+        "responses".into(),
+        json!({
+            "200": ok,
+            "default": { "description": "Error: `status` and `error` in the envelope" },
+        }),
+    );
+    Value::Object(op)
+}
+
+fn parse_schema(raw: &str) -> Option<Value> {
+    serde_json::from_str(raw).ok()
+}
+
+/// `get_admin_settings_name` for `GET /admin/settings/{name}`.
+fn operation_id(method: &str, path: &str) -> String {
+    let mut id = method.to_ascii_lowercase();
+    for part in path
+        .split(|c: char| !c.is_ascii_alphanumeric())
+        .filter(|p| !p.is_empty())
+    {
+        id.push('_');
+        id.push_str(part);
+    }
+    id
+}
+
+fn path_params(path: &str) -> impl Iterator<Item = &str> {
+    path.split('/')
+        .filter_map(|seg| seg.strip_prefix('{')?.strip_suffix('}'))
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashSet;
+    use std::path::PathBuf;
+
+    use super::*;
+
+    fn golden_path() -> PathBuf {
+        PathBuf::from(env!("CARGO_MANIFEST_DIR")).join("testdata/openapi.json")
+    }
+
+    #[test]
+    fn document_matches_golden_file() {
+        let rendered =
+            serde_json::to_string_pretty(&openapi_document("0.0.0-test")).unwrap() + "\n";
+        if std::env::var_os("UPDATE_GOLDEN").is_some() {
+            std::fs::write(golden_path(), &rendered).unwrap();
+            return;
+        }
+        let golden = std::fs::read_to_string(golden_path()).unwrap();
+        assert!(
+            rendered == golden,
+            "admin OpenAPI document changed; rerun with UPDATE_GOLDEN=1 and commit \
+             testdata/openapi.json if the change is intended"
+        );
+    }
+
+    #[test]
+    fn registry_schemas_parse_and_operation_ids_are_unique() {
+        let mut ids = HashSet::new();
+        for meta in REGISTRY {
+            for raw in [meta.request_schema, meta.response_schema]
+                .into_iter()
+                .flatten()
+            {
+                assert!(parse_schema(raw).is_some(), "{}: invalid schema", meta.path);
+            }
+            for method in meta.methods {
+                assert!(
+                    ids.insert(operation_id(method, meta.path)),
+                    "{method} {}",
+                    meta.path
+                );
+            }
+        }
+        assert_eq!(
+            operation_id("GET", "/admin/settings/{name}"),
+            "get_admin_settings_name"
+        );
+        assert_eq!(
+            path_params("/admin/contacts/{slug}").collect::<Vec<_>>(),
+            ["slug"]
+        );
+    }
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/peers` — federation peers and their last `/federation/info` (`madmail peers`).
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use chatmail_state::PeerSource;
+use chatmail_types::ChatmailError;
+
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+#[derive(Deserialize)]
+struct PeerBody {
+    url: String,
+}
+
+fn peer_err(e: ChatmailError) -> (u16, String) {
+    match e {
+        ChatmailError::Config(msg) => (400, msg),
+        other => db_err(other),
+    }
+}
+
+pub async fn peers(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    match method {
+        "GET" => {
+            let peers: Vec<Value> = st
+                .app
+                .peers
+                .snapshot()
+                .iter()
+                .map(|p| p.to_json())
+                .collect();
+            let total = peers.len();
+            Ok((
+                200,
+                Some(json!({
+                    "peers": peers,
+                    "total": total,
+                    "signed": st.app.peers.signing_secret().is_some(),
+                })),
+            ))
+        }
+        "POST" | "PUT" => {
+            let req: PeerBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let url = chatmail_db::normalize_peer_url(&req.url).map_err(peer_err)?;
+            let added = chatmail_db::add_peer(&st.pool, &url)
+                .await
+                .map_err(peer_err)?;
+            sync_stored(st).await?;
+            Ok((200, Some(json!({ "url": url, "added": added }))))
+        }
+        "DELETE" => {
+            let req: PeerBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let url = chatmail_db::normalize_peer_url(&req.url).map_err(peer_err)?;
+            let from_config = st
+                .app
+                .peers
+                .snapshot()
+                .iter()
+                .any(|p| p.base_url == url && p.source == PeerSource::Config);
+            if from_config {
+                return Err((400, format!("{url} comes from the peers directive")));
+            }
+            if !chatmail_db::remove_peer(&st.pool, &url)
+                .await
+                .map_err(peer_err)?
+            {
+                return Err((404, format!("{url} is not a stored peer")));
+            }
+            sync_stored(st).await?;
+            Ok((200, Some(json!({ "removed": url }))))
+        }
+        _ => Err((405, format!("method {method} not allowed for /admin/peers"))),
+    }
+}
+
+/// Show the change at once; the next refresh fetches new peers' documents.
+async fn sync_stored(st: &AdminState) -> Result<(), (u16, String)> {
+    let stored = chatmail_db::list_peers(&st.pool).await.map_err(db_err)?;
+    st.app
+        .peers
+        .set_stored(stored.into_iter().map(|p| p.base_url).collect());
+    Ok(())
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/policies/{type}` — unified policy lists (`policy_entries`).
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+use chatmail_config::parse_duration;
+use chatmail_db::policies::{unix_now, PolicyEntry, PolicyType};
+use chatmail_types::ChatmailError;
+
+#[derive(Deserialize)]
+struct PolicyPost {
+    pattern: String,
+    action: Option<String>,
+    #[serde(default)]
+    note: String,
+    /// Absolute Unix seconds.
+    expires_at: Option<i64>,
+    /// Relative lifetime (`90m`, `24h`, `7d`); ignored when `expires_at` is set.
+    ttl: Option<String>,
+}
+
+#[derive(Deserialize)]
+struct PatternBody {
+    pattern: String,
+}
+
+fn policy_err(e: ChatmailError) -> (u16, String) {
+    match e {
+        ChatmailError::Config(msg) => (400, msg),
+        other => db_err(other),
+    }
+}
+
+fn entry_json(e: &PolicyEntry, now: i64) -> Value {
+    json!({
+        "pattern": e.pattern,
+        "action": e.action,
+        "note": e.note,
+        "expires_at": e.expires_at,
+        "created_at": e.created_at,
+        "active": e.is_active(now),
+    })
+}
+
+pub async fn policies(st: &AdminState, method: &str, resource: &str, body: &Value) -> AdminResult {
+    let name = resource.strip_prefix("/admin/policies/").unwrap_or("");
+    let policy_type = PolicyType::parse(name).map_err(|e| (404, e.to_string()))?;
+    let cache = &st.app.policies;
+    match method {
+        "GET" => {
+            let now = unix_now();
+            let entries: Vec<_> = cache
+                .list(policy_type)
+                .iter()
+                .map(|e| entry_json(e, now))
+                .collect();
+            Ok((
+                200,
+                Some(json!({
+                    "type": policy_type.as_str(),
+                    "entries": entries,
+                    "total": entries.len(),
+                })),
+            ))
+        }
+        "POST" => {
+            let req: PolicyPost =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let expires_at = match (req.expires_at, req.ttl.as_deref()) {
+                (Some(at), _) => Some(at),
+                (None, Some(ttl)) => {
+                    let d =
+                        parse_duration(ttl).map_err(|_| (400, format!("invalid ttl: {ttl}")))?;
+                    Some(unix_now() + d.as_secs() as i64)
+                }
+                (None, None) => None,
+            };
+            let entry = cache
+                .add(
+                    &st.pool,
+                    policy_type,
+                    &req.pattern,
+                    req.action.as_deref(),
+                    &req.note,
+                    expires_at,
+                )
+                .await
+                .map_err(policy_err)?;
+            Ok((200, Some(entry_json(&entry, unix_now()))))
+        }
+        "DELETE" => {
+            let req: PatternBody =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            if req.pattern.trim().is_empty() {
+                return Err((400, "pattern is required".into()));
+            }
+            if !cache
+                .remove(&st.pool, policy_type, &req.pattern)
+                .await
+                .map_err(policy_err)?
+            {
+                return Err((
+                    404,
+                    format!("no {} entry {}", policy_type.as_str(), req.pattern),
+                ));
+            }
+            let remaining = cache.list(policy_type).len();
+            Ok((
+                200,
+                Some(json!({ "pattern": req.pattern, "remaining": remaining })),
+            ))
+        }
+        _ => Err((405, format!("method {method} not allowed"))),
+    }
+}
@@ -20,8 +20,13 @@
 use serde::Deserialize;
 use serde_json::{json, Value};
 
+use chatmail_config::parse_duration;
 use chatmail_db::{get_bool_setting, set_setting};
-use chatmail_shadowsocks::resolve_runtime;
+use chatmail_shadowsocks::{
+    generate_password, resolve_runtime, rotate_password, DEFAULT_ROTATION_GRACE,
+};
+use chatmail_types::ChatmailError;
+use getrandom::getrandom;
 
 use super::settings::generic_setting;
 use super::status_storage::db_err;
@@ -172,6 +177,113 @@ async fn ss_named_setting(st: &AdminState, method: &str, body: &Value, name: &st
     Ok(res)
 }
 
+pub(crate) const SS_STATS_RESPONSE_SCHEMA: &str = r#"{
+    "type": "object",
+    "properties": {
+        "bytes_in": {"type": "integer"},
+        "bytes_out": {"type": "integer"},
+        "active_connections": {"type": "integer"},
+        "total_connections": {"type": "integer"}
+    }
+}"#;
+
+/// `GET /admin/ss-stats` — relay traffic since the process started.
+pub async fn ss_stats(st: &AdminState, method: &str) -> AdminResult {
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    let traffic = st.app.shadowsocks.snapshot();
+    Ok((
+        200,
+        Some(json!({
+            "bytes_in": traffic.bytes_in,
+            "bytes_out": traffic.bytes_out,
+            "active_connections": traffic.active_connections,
+            "total_connections": traffic.total_connections,
+        })),
+    ))
+}
+
+pub(crate) const SS_ROTATE_REQUEST_SCHEMA: &str = r#"{
+    "type": "object",
+    "properties": {
+        "password": {"type": "string", "description": "New primary; generated when omitted"},
+        "grace": {"type": "string", "description": "Old password stays valid this long (10m)"},
+        "keep_old": {"type": "boolean", "description": "Keep the previous password with no expiry"}
+    }
+}"#;
+
+#[derive(Deserialize, Default)]
+struct RotateBody {
+    /// New primary password; generated for the cipher when omitted.
+    #[serde(default)]
+    password: Option<String>,
+    /// How long the previous password keeps working (`10m` by default).
+    #[serde(default)]
+    grace: Option<String>,
+    /// Keep the previous password with no expiry.
+    #[serde(default)]
+    keep_old: bool,
+}
+
+/// `POST /admin/ss/rotate` — make a new primary password; the previous one stays accepted
+/// for `grace` so connected clients can pick up the new URL.
+pub async fn ss_rotate(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    if method != "POST" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    if !st.file_config.ss_configured() {
+        return Err((400, SS_NOT_CONFIGURED.into()));
+    }
+    let req: RotateBody = if body.is_null() {
+        RotateBody::default()
+    } else {
+        serde_json::from_value(body.clone()).map_err(|e| (400, format!("invalid body: {e}")))?
+    };
+    let grace = match (req.keep_old, req.grace.as_deref()) {
+        (true, _) => None,
+        (false, None) => Some(DEFAULT_ROTATION_GRACE),
+        (false, Some(raw)) => {
+            Some(parse_duration(raw).map_err(|_| (400, format!("invalid grace: {raw}")))?)
+        }
+    };
+    let rt = resolve_runtime(&st.pool, &st.file_config, &st.mail_domain, &st.state_dir)
+        .await
+        .map_err(db_err)?;
+    let password = match req.password.map(|p| p.trim().to_string()) {
+        Some(p) => p,
+        None => {
+            let mut random = [0u8; 32];
+            getrandom(&mut random).map_err(|e| (500, format!("generate password: {e}")))?;
+            generate_password(&rt, &random)
+        }
+    };
+    let rotation = rotate_password(&st.pool, &rt, &password, grace)
+        .await
+        .map_err(|e| match e {
+            ChatmailError::Config(msg) => (400, msg),
+            other => db_err(other),
+        })?;
+    let rt = resolve_runtime(&st.pool, &st.file_config, &st.mail_domain, &st.state_dir)
+        .await
+        .map_err(db_err)?;
+    let urls = rt.urls(&st.mail_domain);
+    if st.reload_tx.is_some() {
+        trigger_soft_reload(st).await?;
+    }
+    Ok((
+        200,
+        Some(json!({
+            "password": password,
+            "retired_until": rotation.retired_until,
+            "slots": rotation.slots.len(),
+            "shadowsocks_url": urls.shadowsocks_url,
+            "shadowsocks_urls": urls.shadowsocks_urls,
+            "restart_required": st.reload_tx.is_some(),
+        })),
+    ))
+}
+
 /// `GET /admin/services/http_proxy` — not implemented.
 pub async fn http_proxy_service(st: &AdminState, method: &str, body: &Value) -> AdminResult {
     let _ = st;
@@ -191,9 +303,22 @@ pub async fn http_proxy_service(st: &AdminState, method: &str, body: &Value) ->
 }
 
 /// Shadowsocks snapshot for `GET /admin/settings`.
+#[allow(clippy::type_complexity)]
 pub async fn shadowsocks_settings_snapshot(
     st: &AdminState,
-) -> Result<(String, String, String, String, String, String, String), (u16, String)> {
+) -> Result<
+    (
+        String,
+        String,
+        String,
+        String,
+        String,
+        String,
+        String,
+        Vec<String>,
+    ),
+    (u16, String),
+> {
     if !st.file_config.ss_configured() {
         return Ok((
             "disabled".into(),
@@ -203,6 +328,7 @@ pub async fn shadowsocks_settings_snapshot(
             String::new(),
             String::new(),
             String::new(),
+            Vec::new(),
         ));
     }
     let rt = resolve_runtime(&st.pool, &st.file_config, &st.mail_domain, &st.state_dir)
@@ -222,6 +348,7 @@ pub async fn shadowsocks_settings_snapshot(
         rt.cipher,
         rt.password,
         urls.shadowsocks_url,
+        urls.shadowsocks_urls,
     ))
 }
 
@@ -30,6 +30,14 @@ struct QuotaGet {
     username: String,
 }
 
+#[derive(Deserialize)]
+struct QuotaAction {
+    action: String,
+    /// Empty: every account.
+    #[serde(default)]
+    username: String,
+}
+
 #[derive(Deserialize)]
 struct QuotaSet {
     #[serde(default)]
@@ -45,11 +53,13 @@ pub async fn quota(st: &AdminState, method: &str, body: &Value) -> AdminResult {
             });
             if !req.username.is_empty() {
                 let (used, max, is_default) = st.app.quota.get_quota(&req.username);
+                let (_, deleted) = st.app.quota.usage(&req.username);
                 return Ok((
                     200,
                     Some(json!({
                         "username": req.username,
                         "used_bytes": used,
+                        "deleted_bytes": deleted,
                         "max_bytes": max,
                         "is_default": is_default
                     })),
@@ -93,6 +103,17 @@ pub async fn quota(st: &AdminState, method: &str, body: &Value) -> AdminResult {
                 Some(json!({ "username": user, "max_bytes": req.max_bytes })),
             ))
         }
+        "POST" => {
+            let req: QuotaAction =
+                serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            if req.action != "recalc" {
+                return Err((
+                    400,
+                    format!("unknown action: {} (expected: recalc)", req.action),
+                ));
+            }
+            recalc(st, &req.username).await
+        }
         "DELETE" => {
             let req: QuotaGet =
                 serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
@@ -106,6 +127,48 @@ pub async fn quota(st: &AdminState, method: &str, body: &Value) -> AdminResult {
     }
 }
 
+/// Rescan maildir usage and overwrite the cached counters; reports the accounts that drifted.
+async fn recalc(st: &AdminState, username: &str) -> AdminResult {
+    let store = &st.app.mailbox_store;
+    let report = if username.is_empty() {
+        st.app
+            .quota
+            .recalculate_all(&st.pool, store)
+            .await
+            .map_err(db_err)?
+    } else {
+        if !chatmail_db::passwords::user_exists(&st.pool, username)
+            .await
+            .map_err(db_err)?
+        {
+            return Err((404, format!("user {username} not found")));
+        }
+        vec![st
+            .app
+            .quota
+            .recalculate(username, store)
+            .await
+            .map_err(db_err)?]
+    };
+    let drifted: Vec<Value> = report
+        .iter()
+        .filter(|r| r.drifted())
+        .map(|r| {
+            json!({
+                "username": r.username,
+                "before_bytes": r.before.0,
+                "after_bytes": r.after.0,
+                "before_deleted": r.before.1,
+                "after_deleted": r.after.1,
+            })
+        })
+        .collect();
+    Ok((
+        200,
+        Some(json!({ "accounts": report.len(), "drifted": drifted })),
+    ))
+}
+
 fn unix_now() -> i64 {
     std::time::SystemTime::now()
         .duration_since(std::time::UNIX_EPOCH)
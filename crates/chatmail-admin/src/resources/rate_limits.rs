@@ -0,0 +1,192 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/rate-limits` — per-account submission counters behind `max_messages_per_hour`.
+
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+use chatmail_auth::normalize_username;
+use chatmail_db::policies::unix_now;
+use chatmail_db::{reset_sending_stats, sending_hour, sending_stats_for, top_senders};
+
+/// Hours covered by GET.
+const WINDOW_HOURS: i64 = 24;
+/// Accounts returned by GET without a username.
+const TOP_SENDERS: i64 = 50;
+
+#[derive(Deserialize, Default)]
+struct RateLimitBody {
+    username: Option<String>,
+}
+
+pub async fn rate_limits(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    let req: RateLimitBody = if body.is_null() {
+        RateLimitBody::default()
+    } else {
+        serde_json::from_value(body.clone())
+            .map_err(|e| (400, format!("invalid request body: {e}")))?
+    };
+    let username = match req.username.as_deref().map(str::trim) {
+        Some(raw) if !raw.is_empty() => {
+            Some(normalize_username(raw).map_err(|e| (400, e.to_string()))?)
+        }
+        _ => None,
+    };
+    let limits = &st.app.sending_limits;
+    let hour = sending_hour(unix_now());
+    let since = hour - WINDOW_HOURS + 1;
+
+    match (method, username) {
+        ("GET", None) => {
+            let senders: Vec<Value> = top_senders(&st.pool, since, TOP_SENDERS)
+                .await
+                .map_err(db_err)?
+                .into_iter()
+                .map(|s| {
+                    json!({
+                        "username": s.username,
+                        "messages": s.messages,
+                        "recipients": s.recipients,
+                    })
+                })
+                .collect();
+            Ok((
+                200,
+                Some(json!({
+                    "max_messages_per_hour": limits.max_messages_per_hour(),
+                    "max_rcpt_per_message": limits.max_rcpt_per_message(),
+                    "hours": WINDOW_HOURS,
+                    "senders": senders,
+                })),
+            ))
+        }
+        ("GET", Some(username)) => {
+            let rows = sending_stats_for(&st.pool, &username, since)
+                .await
+                .map_err(db_err)?;
+            let this_hour = rows
+                .iter()
+                .find(|r| r.hour == hour)
+                .map_or(0, |r| r.messages);
+            let hours: Vec<Value> = rows
+                .iter()
+                .map(|r| {
+                    json!({
+                        "hour_start": r.hour * 3600,
+                        "messages": r.messages,
+                        "recipients": r.recipients,
+                    })
+                })
+                .collect();
+            Ok((
+                200,
+                Some(json!({
+                    "username": username,
+                    "max_messages_per_hour": limits.max_messages_per_hour(),
+                    "messages_this_hour": this_hour,
+                    "hours": hours,
+                })),
+            ))
+        }
+        ("DELETE", Some(username)) => {
+            let cleared = reset_sending_stats(&st.pool, &username)
+                .await
+                .map_err(db_err)?;
+            limits.forget(&username);
+            Ok((
+                200,
+                Some(json!({ "username": username, "cleared": cleared })),
+            ))
+        }
+        ("DELETE", None) => Err((400, "username is required".into())),
+        _ => Err((
+            405,
+            format!("method {method} not allowed, use GET or DELETE"),
+        )),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use chatmail_config::AppConfig;
+    use chatmail_db::{init_memory_db, record_sent_message};
+    use chatmail_state::AppState;
+    use tempfile::TempDir;
+
+    use super::*;
+
+    async fn test_admin_state() -> (AdminState, TempDir) {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let config = AppConfig {
+            max_messages_per_hour: Some(10),
+            ..Default::default()
+        };
+        let app = Arc::new(AppState::with_quota_and_message_limit(
+            dir.path(),
+            chatmail_config::DEFAULT_QUOTA_BYTES,
+            &config,
+            pool.clone(),
+        ));
+        let st = AdminState::new(
+            pool,
+            app,
+            config,
+            dir.path().to_path_buf(),
+            "example.org".into(),
+            "secret-token-01234567890123456789012345678901".into(),
+            None,
+        );
+        (st, dir)
+    }
+
+    #[tokio::test]
+    async fn lists_top_senders_and_resets_one() {
+        let (st, _dir) = test_admin_state().await;
+        let hour = sending_hour(unix_now());
+        for (user, n) in [("busy@example.org", 3), ("quiet@example.org", 1)] {
+            for _ in 0..n {
+                record_sent_message(&st.pool, user, hour, 2).await.unwrap();
+            }
+        }
+
+        let (code, v) = rate_limits(&st, "GET", &Value::Null).await.unwrap();
+        assert_eq!(code, 200);
+        let v = v.unwrap();
+        assert_eq!(v["max_messages_per_hour"], 10);
+        assert_eq!(v["senders"][0]["username"], "busy@example.org");
+        assert_eq!(v["senders"][0]["recipients"], 6);
+
+        let one = json!({ "username": "Busy@example.org" });
+        let (_, v) = rate_limits(&st, "GET", &one).await.unwrap();
+        assert_eq!(v.unwrap()["messages_this_hour"], 3);
+
+        let (_, v) = rate_limits(&st, "DELETE", &one).await.unwrap();
+        assert_eq!(v.unwrap()["cleared"], 1);
+        let (_, v) = rate_limits(&st, "GET", &one).await.unwrap();
+        assert_eq!(v.unwrap()["messages_this_hour"], 0);
+
+        let err = rate_limits(&st, "DELETE", &Value::Null).await;
+        assert_eq!(err.unwrap_err().0, 400);
+        assert_eq!(rate_limits(&st, "POST", &one).await.unwrap_err().0, 405);
+    }
+}
@@ -23,17 +23,47 @@ use serde::Deserialize;
 use serde_json::{json, Value};
 
 use chatmail_config::{
-    format_data_size, parse_bool_str, parse_duration, turn_relay_ports::TurnRelayPortRange,
-    AppConfig,
+    format_data_size, format_registration_rate, parse_bool_str, parse_duration,
+    parse_registration_rate, turn_relay_ports::TurnRelayPortRange, AppConfig,
+    MAX_PROOF_OF_WORK_BITS,
 };
 use chatmail_db::{
     delete_setting, format_retention_days, get_bool_setting, get_setting, set_setting,
     settings_keys, DbPool, DEFAULT_RETENTION_DAYS,
 };
 
-use super::{status_storage::db_err, AdminResult};
+use super::{settings_preview, status_storage::db_err, AdminResult};
 use crate::AdminState;
 
+/// OpenAPI schema of the `GET /admin/settings` body.
+pub(crate) const ALL_SETTINGS_RESPONSE_SCHEMA: &str = r#"{
+    "type": "object",
+    "description": "Stored value of every setting (empty when unset) plus `*_effective` values",
+    "additionalProperties": true
+}"#;
+
+/// OpenAPI schema of a `POST /admin/settings/{name}` body.
+pub(crate) const SETTING_ACTION_REQUEST_SCHEMA: &str = r#"{
+    "type": "object",
+    "required": ["action"],
+    "properties": {
+        "action": {"type": "string", "enum": ["set", "reset"]},
+        "value": {"description": "New value for `set` (string, number or boolean)"},
+        "dry_run": {"type": "boolean", "description": "Validate and preview without storing"}
+    }
+}"#;
+
+/// OpenAPI schema of [`setting_response`].
+pub(crate) const SETTING_RESPONSE_SCHEMA: &str = r#"{
+    "type": "object",
+    "properties": {
+        "key": {"type": "string"},
+        "value": {"type": "string"},
+        "is_set": {"type": "boolean"},
+        "restart_required": {"type": "boolean"}
+    }
+}"#;
+
 /// Build the full settings snapshot (admin-web `AllSettings` type).
 pub async fn all_settings(st: &AdminState, method: &str) -> AdminResult {
     if method != "GET" {
@@ -77,8 +107,16 @@ pub async fn all_settings(st: &AdminState, method: &str) -> AdminResult {
         .await
         .map_err(db_err)?;
 
-    let (ss_enabled, ss_ws_enabled, ss_grpc_enabled, ss_port, ss_cipher, ss_pass, shadowsocks_url) =
-        super::proxy::shadowsocks_settings_snapshot(st).await?;
+    let (
+        ss_enabled,
+        ss_ws_enabled,
+        ss_grpc_enabled,
+        ss_port,
+        ss_cipher,
+        ss_pass,
+        shadowsocks_url,
+        shadowsocks_urls,
+    ) = super::proxy::shadowsocks_settings_snapshot(st).await?;
 
     let mut body = serde_json::Map::new();
     body.insert("registration".into(), json!(registration));
@@ -301,6 +339,7 @@ pub async fn all_settings(st: &AdminState, method: &str) -> AdminResult {
         setting_value(pool, settings_keys::SS_PASSWORD, &ss_pass).await?,
     );
     body.insert("shadowsocks_url".into(), json!(shadowsocks_url));
+    body.insert("shadowsocks_urls".into(), json!(shadowsocks_urls));
 
     body.insert("ss_ws_enabled".into(), json!(ss_ws_enabled));
     body.insert("ss_grpc_enabled".into(), json!(ss_grpc_enabled));
@@ -377,6 +416,11 @@ pub async fn all_settings(st: &AdminState, method: &str) -> AdminResult {
         "max_federation_size",
         setting_value(pool, settings_keys::MAX_FEDERATION_SIZE, "").await?,
     );
+    insert_setting(
+        &mut body,
+        "max_accounts",
+        setting_value(pool, settings_keys::MAX_ACCOUNTS, "").await?,
+    );
     let effective = st.app.message_size.effective();
     insert_setting(
         &mut body,
@@ -395,6 +439,27 @@ pub async fn all_settings(st: &AdminState, method: &str) -> AdminResult {
         "federation_size_effective_bytes",
         json!(federation_effective),
     );
+    insert_setting(
+        &mut body,
+        "registration_rate",
+        setting_value(pool, settings_keys::REGISTRATION_RATE, "").await?,
+    );
+    insert_setting(
+        &mut body,
+        "proof_of_work_bits",
+        setting_value(pool, settings_keys::PROOF_OF_WORK_BITS, "").await?,
+    );
+    let limiter = &st.app.registration_limiter;
+    insert_setting(
+        &mut body,
+        "registration_rate_effective",
+        json!(format_registration_rate(limiter.rate(), limiter.burst())),
+    );
+    insert_setting(
+        &mut body,
+        "proof_of_work_bits_effective",
+        json!(st.app.pow_challenges.bits()),
+    );
 
     Ok((200, Some(Value::Object(body))))
 }
@@ -521,6 +586,9 @@ fn named_routes() -> HashMap<&'static str, NamedRoute> {
         ("appendlimit", k::APPENDLIMIT),
         ("max_message_size", k::MAX_MESSAGE_SIZE),
         ("max_federation_size", k::MAX_FEDERATION_SIZE),
+        ("max_accounts", k::MAX_ACCOUNTS),
+        ("registration_rate", k::REGISTRATION_RATE),
+        ("proof_of_work_bits", k::PROOF_OF_WORK_BITS),
         ("message_retention", k::MESSAGE_RETENTION),
         ("webmail_cors_origins", k::WEBMAIL_CORS_ORIGINS),
     ] {
@@ -571,6 +639,25 @@ struct SettingActionBody {
     value: Value,
 }
 
+/// Validate and normalize a `set` value. Shared by the write and `dry_run` paths.
+async fn prepare_setting_value(
+    st: &AdminState,
+    db_key: &str,
+    raw: &Value,
+) -> Result<String, (u16, String)> {
+    let mut value = body_value_as_string(raw);
+    if value.is_empty() {
+        return Err((400, "value is required for action 'set'".into()));
+    }
+    if db_key == settings_keys::ADMIN_WEB_PATH {
+        value = chatmail_admin_web::normalize_admin_web_path(&value)
+            .ok_or((400, "admin web path must not be empty".into()))?;
+    }
+    validate_setting_value(db_key, &value)?;
+    validate_turn_relay_port_setting(st, db_key, &value).await?;
+    Ok(value)
+}
+
 /// Madmail `GenericSettingHandler` — GET; POST `set` / `reset`.
 pub(crate) async fn generic_setting(
     st: &AdminState,
@@ -590,15 +677,14 @@ pub(crate) async fn generic_setting(
         "POST" => {
             let req: SettingActionBody =
                 serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?;
+            let dry_run = settings_preview::is_dry_run(body);
             match req.action.as_str() {
                 "set" => {
-                    let mut value = body_value_as_string(&req.value);
-                    if value.is_empty() {
-                        return Err((400, "value is required for action 'set'".into()));
+                    let value = prepare_setting_value(st, db_key, &req.value).await?;
+                    if dry_run {
+                        return settings_preview::setting_preview(st, db_key, Some(&value)).await;
                     }
                     if db_key == chatmail_db::settings_keys::ADMIN_WEB_PATH {
-                        value = chatmail_admin_web::normalize_admin_web_path(&value)
-                            .ok_or((400, "admin web path must not be empty".into()))?;
                         set_setting(
                             &st.pool,
                             chatmail_db::settings_keys::ADMIN_WEB_ENABLED,
@@ -607,22 +693,23 @@ pub(crate) async fn generic_setting(
                         .await
                         .map_err(db_err)?;
                     }
-                    validate_setting_value(db_key, &value)?;
-                    validate_turn_relay_port_setting(st, db_key, &value).await?;
                     set_setting(&st.pool, db_key, &value)
                         .await
                         .map_err(db_err)?;
                     super::message_size::refresh_message_size_after_setting(st, db_key).await;
                     super::federation_size::refresh_federation_size_after_setting(st, db_key).await;
+                    refresh_registration_guards_after_setting(st, db_key).await;
                     if db_key == chatmail_db::settings_keys::ADMIN_WEB_PATH {
                         super::toggles::trigger_http_routes_reload(st).await?;
                     }
                     Ok((200, Some(setting_response(db_key, &value, true, true))))
                 }
+                "reset" if dry_run => settings_preview::setting_preview(st, db_key, None).await,
                 "reset" => {
                     delete_setting(&st.pool, db_key).await.map_err(db_err)?;
                     super::message_size::refresh_message_size_after_setting(st, db_key).await;
                     super::federation_size::refresh_federation_size_after_setting(st, db_key).await;
+                    refresh_registration_guards_after_setting(st, db_key).await;
                     if db_key == chatmail_db::settings_keys::ADMIN_WEB_PATH {
                         super::toggles::trigger_http_routes_reload(st).await?;
                     }
@@ -671,6 +758,12 @@ async fn db_toggle_setting(
                     ));
                 }
             };
+            if settings_preview::is_dry_run(body) {
+                let was_on = get_bool_setting(&st.pool, db_key, default_enabled)
+                    .await
+                    .map_err(db_err)?;
+                return settings_preview::toggle_preview(st, db_key, was_on, on).await;
+            }
             set_setting(&st.pool, db_key, if on { "true" } else { "false" })
                 .await
                 .map_err(db_err)?;
@@ -681,6 +774,29 @@ async fn db_toggle_setting(
     }
 }
 
+/// Push a changed `__REGISTRATION_RATE__` / `__PROOF_OF_WORK_BITS__` into the running
+/// `/new` limiter and challenge issuer.
+async fn refresh_registration_guards_after_setting(st: &AdminState, db_key: &str) {
+    let refreshed = match db_key {
+        settings_keys::REGISTRATION_RATE => {
+            st.app
+                .registration_limiter
+                .refresh_from_db(&st.pool, &st.file_config)
+                .await
+        }
+        settings_keys::PROOF_OF_WORK_BITS => {
+            st.app
+                .pow_challenges
+                .refresh_from_db(&st.pool, &st.file_config)
+                .await
+        }
+        _ => return,
+    };
+    if let Err(e) = refreshed {
+        tracing::warn!(key = db_key, error = %e, "registration guard refresh failed");
+    }
+}
+
 fn setting_response(key: &str, value: &str, is_set: bool, restart_required: bool) -> Value {
     json!({
         "key": key,
@@ -700,7 +816,7 @@ fn body_value_as_string(value: &Value) -> String {
     }
 }
 
-fn validate_setting_value(key: &str, value: &str) -> Result<(), (u16, String)> {
+pub(super) fn validate_setting_value(key: &str, value: &str) -> Result<(), (u16, String)> {
     if value.contains('\0') || value.contains('\n') || value.contains('\r') {
         return Err((400, "value contains invalid characters".into()));
     }
@@ -725,6 +841,16 @@ fn validate_setting_value(key: &str, value: &str) -> Result<(), (u16, String)> {
         return Ok(());
     }
 
+    if key == settings_keys::MAX_ACCOUNTS {
+        if value.parse::<u64>().is_err() {
+            return Err((
+                400,
+                "invalid max_accounts: 0 (no cap) or a positive integer".into(),
+            ));
+        }
+        return Ok(());
+    }
+
     if key == settings_keys::DCLOGIN_IMAP_SECURITY || key == settings_keys::DCLOGIN_SMTP_SECURITY {
         match value {
             "starttls" | "ssl" | "default" => return Ok(()),
@@ -745,6 +871,30 @@ fn validate_setting_value(key: &str, value: &str) -> Result<(), (u16, String)> {
         return super::federation_size::validate_federation_size_value(value);
     }
 
+    if key == settings_keys::REGISTRATION_RATE {
+        if parse_registration_rate(value).is_none() {
+            return Err((
+                400,
+                "invalid registration_rate: use N/window (e.g. 10/1h, 10/hour) or requests \
+                 per second (0 turns the limiter off)"
+                    .into(),
+            ));
+        }
+        return Ok(());
+    }
+
+    if key == settings_keys::PROOF_OF_WORK_BITS {
+        match value.parse::<u32>() {
+            Ok(bits) if bits <= MAX_PROOF_OF_WORK_BITS => return Ok(()),
+            _ => {
+                return Err((
+                    400,
+                    format!("invalid proof_of_work_bits: 0 (off) to {MAX_PROOF_OF_WORK_BITS}"),
+                ));
+            }
+        }
+    }
+
     if key == settings_keys::MESSAGE_RETENTION {
         if parse_duration(value).is_err() {
             return Err((
@@ -791,7 +941,7 @@ fn validate_setting_value(key: &str, value: &str) -> Result<(), (u16, String)> {
     Ok(())
 }
 
-fn is_turn_relay_port_key(key: &str) -> bool {
+pub(super) fn is_turn_relay_port_key(key: &str) -> bool {
     matches!(
         key,
         settings_keys::TURN_RELAY_PORT_MIN | settings_keys::TURN_RELAY_PORT_MAX
@@ -867,7 +1017,7 @@ async fn effective_turn_control_port(
     })
 }
 
-fn is_port_key(key: &str) -> bool {
+pub(super) fn is_port_key(key: &str) -> bool {
     matches!(
         key,
         settings_keys::SMTP_PORT
@@ -0,0 +1,411 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Dry-run previews for settings writes (`"dry_run": true` on set/reset/toggle bodies).
+//!
+//! The preview runs the same validation as the real write, then reports old vs new value,
+//! how the change is applied, and cheap side effects computed per key group. Nothing is
+//! persisted.
+
+use std::net::IpAddr;
+
+use serde_json::{json, Map, Value};
+
+use chatmail_db::{get_setting, settings_keys, DbPool};
+
+use super::settings_transfer::{is_secret_setting, REDACTED};
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+/// `"dry_run": true` in a POST body.
+pub(super) fn is_dry_run(body: &Value) -> bool {
+    body.get("dry_run")
+        .and_then(Value::as_bool)
+        .unwrap_or(false)
+}
+
+/// How a stored value reaches the running server.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(super) enum ApplyMode {
+    /// Read per request or refreshed by the handler.
+    Immediate,
+    /// Admin/www routes are remounted by the handler.
+    HttpRoutesReload,
+    /// Listeners or services pick it up on restart.
+    Restart,
+}
+
+impl ApplyMode {
+    fn as_str(self) -> &'static str {
+        match self {
+            ApplyMode::Immediate => "immediate",
+            ApplyMode::HttpRoutesReload => "http_routes_reload",
+            ApplyMode::Restart => "restart",
+        }
+    }
+}
+
+pub(super) fn apply_mode(db_key: &str) -> ApplyMode {
+    use settings_keys as k;
+    match db_key {
+        k::APPENDLIMIT
+        | k::MAX_MESSAGE_SIZE
+        | k::MAX_FEDERATION_SIZE
+        | k::MAX_ACCOUNTS
+        | k::REGISTRATION_RATE
+        | k::PROOF_OF_WORK_BITS
+        | k::REGISTRATION_OPEN
+        | k::JIT_REGISTRATION_ENABLED
+        | k::REGISTRATION_TOKEN_REQUIRED
+        | k::AUTO_PURGE_SEEN => ApplyMode::Immediate,
+        k::ADMIN_WEB_PATH => ApplyMode::HttpRoutesReload,
+        _ => ApplyMode::Restart,
+    }
+}
+
+/// Listener a port / local-only key belongs to.
+pub(super) fn service_for_key(db_key: &str) -> Option<&'static str> {
+    use settings_keys as k;
+    Some(match db_key {
+        k::SMTP_PORT | k::SMTP_LOCAL_ONLY => "smtp",
+        k::SUBMISSION_PORT | k::SUBMISSION_LOCAL_ONLY => "submission",
+        k::SUBMISSION_TLS_PORT | k::SUBMISSION_TLS_LOCAL_ONLY => "submission_tls",
+        k::IMAP_PORT | k::IMAP_LOCAL_ONLY => "imap",
+        k::IMAP_TLS_PORT | k::IMAP_TLS_LOCAL_ONLY => "imap_tls",
+        k::TURN_PORT | k::TURN_LOCAL_ONLY | k::TURN_ENABLED => "turn",
+        k::SASL_PORT | k::SASL_LOCAL_ONLY => "sasl",
+        k::IROH_PORT | k::IROH_LOCAL_ONLY | k::IROH_ENABLED => "iroh",
+        k::HTTP_PORT | k::HTTP_LOCAL_ONLY => "http",
+        k::HTTPS_PORT | k::HTTPS_LOCAL_ONLY => "https",
+        k::HTTP_PROXY_PORT => "http_proxy",
+        k::SS_PORT | k::SS_WS_PORT | k::SS_GRPC_PORT => "shadowsocks",
+        k::PUSH_ENABLED => "push",
+        _ => return None,
+    })
+}
+
+/// Port key serving the listener guarded by `local_only_key`.
+fn port_key_for_local_only(local_only_key: &str) -> Option<&'static str> {
+    use settings_keys as k;
+    Some(match local_only_key {
+        k::SMTP_LOCAL_ONLY => k::SMTP_PORT,
+        k::SUBMISSION_LOCAL_ONLY => k::SUBMISSION_PORT,
+        k::SUBMISSION_TLS_LOCAL_ONLY => k::SUBMISSION_TLS_PORT,
+        k::IMAP_LOCAL_ONLY => k::IMAP_PORT,
+        k::IMAP_TLS_LOCAL_ONLY => k::IMAP_TLS_PORT,
+        k::TURN_LOCAL_ONLY => k::TURN_PORT,
+        k::SASL_LOCAL_ONLY => k::SASL_PORT,
+        k::IROH_LOCAL_ONLY => k::IROH_PORT,
+        k::HTTP_LOCAL_ONLY => k::HTTP_PORT,
+        k::HTTPS_LOCAL_ONLY => k::HTTPS_PORT,
+        _ => return None,
+    })
+}
+
+fn is_local_only_key(db_key: &str) -> bool {
+    port_key_for_local_only(db_key).is_some()
+}
+
+/// Preview for `set` (`new = Some`) or `reset` (`new = None`) of a validated value.
+pub(super) async fn setting_preview(
+    st: &AdminState,
+    db_key: &str,
+    new: Option<&str>,
+) -> AdminResult {
+    let old = get_setting(&st.pool, db_key).await.map_err(db_err)?;
+    let impact = setting_impact(st, db_key, old.as_deref(), new).await?;
+    Ok((200, Some(preview_body(db_key, old.as_deref(), new, impact))))
+}
+
+/// Preview for an enable/disable (or open/close) toggle stored as `true`/`false`.
+pub(super) async fn toggle_preview(
+    st: &AdminState,
+    db_key: &str,
+    old_on: bool,
+    new_on: bool,
+) -> AdminResult {
+    let old = if old_on { "true" } else { "false" };
+    let new = if new_on { "true" } else { "false" };
+    let impact = setting_impact(st, db_key, Some(old), Some(new)).await?;
+    Ok((
+        200,
+        Some(preview_body(db_key, Some(old), Some(new), impact)),
+    ))
+}
+
+fn preview_body(db_key: &str, old: Option<&str>, new: Option<&str>, impact: Value) -> Value {
+    let shown = |v: Option<&str>| match v {
+        Some(_) if is_secret_setting(db_key) => json!(REDACTED),
+        Some(v) => json!(v),
+        None => Value::Null,
+    };
+    let mode = apply_mode(db_key);
+    json!({
+        "dry_run": true,
+        "key": db_key,
+        "old_value": shown(old),
+        "new_value": shown(new),
+        "changed": old != new,
+        "apply": mode.as_str(),
+        "restart_required": mode == ApplyMode::Restart,
+        "services": service_for_key(db_key).into_iter().collect::<Vec<_>>(),
+        "impact": impact,
+    })
+}
+
+/// Cheap side effects per key group; empty object when nothing applies.
+async fn setting_impact(
+    st: &AdminState,
+    db_key: &str,
+    old: Option<&str>,
+    new: Option<&str>,
+) -> Result<Value, (u16, String)> {
+    let mut impact = Map::new();
+    if super::settings::is_port_key(db_key) {
+        if let Some(port) = new.and_then(|v| v.trim().parse::<u16>().ok()) {
+            let current = current_port(st, db_key).await;
+            impact.insert("port".into(), port_impact(port, current));
+        }
+    }
+    if closes_registration(db_key, old, new) {
+        impact.insert("registrations".into(), registration_impact(&st.pool).await?);
+    }
+    if is_local_only_key(db_key) && turns_on(old, new) {
+        impact.insert(
+            "public_connections".into(),
+            local_only_impact(st, db_key).await,
+        );
+    }
+    Ok(Value::Object(impact))
+}
+
+fn flag(v: Option<&str>) -> bool {
+    v.map(chatmail_config::parse_bool_str).unwrap_or(false)
+}
+
+fn turns_on(old: Option<&str>, new: Option<&str>) -> bool {
+    !flag(old) && flag(new)
+}
+
+fn closes_registration(db_key: &str, old: Option<&str>, new: Option<&str>) -> bool {
+    match db_key {
+        settings_keys::REGISTRATION_OPEN => flag(old) && !flag(new),
+        // JIT defaults to enabled when unset.
+        settings_keys::JIT_REGISTRATION_ENABLED => old.map_or(true, |_| flag(old)) && !flag(new),
+        settings_keys::REGISTRATION_TOKEN_REQUIRED => turns_on(old, new),
+        _ => false,
+    }
+}
+
+// ── Port changes ─────────────────────────────────────────────────────────────
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(super) enum PortProbe {
+    Free,
+    InUse,
+    /// Bind failed for another reason (e.g. privileged port without CAP_NET_BIND_SERVICE).
+    Unknown(String),
+}
+
+/// Try binding TCP `port` on all interfaces; the socket is closed immediately.
+pub(super) fn probe_port(port: u16) -> PortProbe {
+    match std::net::TcpListener::bind(("0.0.0.0", port)) {
+        Ok(_) => PortProbe::Free,
+        Err(e) if e.kind() == std::io::ErrorKind::AddrInUse => PortProbe::InUse,
+        Err(e) => PortProbe::Unknown(e.to_string()),
+    }
+}
+
+/// `current` is the port this listener is bound to now; it is in use by us, not a conflict.
+pub(super) fn port_impact(port: u16, current: Option<u16>) -> Value {
+    if current == Some(port) {
+        return json!({ "port": port, "status": "current" });
+    }
+    match probe_port(port) {
+        PortProbe::Free => json!({ "port": port, "status": "free" }),
+        PortProbe::InUse => json!({ "port": port, "status": "in_use" }),
+        PortProbe::Unknown(err) => json!({ "port": port, "status": "unknown", "error": err }),
+    }
+}
+
+/// Port the listener for `port_key` runs on: bound address when known, else the stored value.
+async fn current_port(st: &AdminState, port_key: &str) -> Option<u16> {
+    use settings_keys as k;
+    let snap = st.app.listener_ports.snapshot();
+    let bound = match port_key {
+        k::SMTP_PORT => snap
+            .smtp_addr
+            .as_deref()
+            .and_then(|a| a.rsplit_once(':'))
+            .map(|(_, p)| p.to_string()),
+        k::SUBMISSION_PORT => Some(snap.submission_plain_port),
+        k::SUBMISSION_TLS_PORT => Some(snap.submission_tls_port),
+        k::IMAP_PORT => Some(snap.imap_plain_port),
+        k::IMAP_TLS_PORT => Some(snap.imap_tls_port),
+        k::HTTP_PORT => Some(snap.http_plain_port),
+        k::HTTPS_PORT => Some(snap.http_tls_port),
+        _ => None,
+    };
+    if let Some(p) = bound.and_then(|p| p.parse().ok()) {
+        return Some(p);
+    }
+    get_setting(&st.pool, port_key)
+        .await
+        .ok()
+        .flatten()
+        .and_then(|v| v.trim().parse().ok())
+}
+
+// ── Registration closure ─────────────────────────────────────────────────────
+
+/// Accounts created in the last day / week — the intake a closure would cut off.
+pub(super) async fn registration_impact(pool: &DbPool) -> Result<Value, (u16, String)> {
+    let infos = chatmail_db::list_account_quota_info(pool)
+        .await
+        .map_err(db_err)?;
+    let now = chatmail_db::policies::unix_now();
+    let (day, week) = count_created_since(infos.values().map(|i| i.created_at), now);
+    Ok(json!({
+        "created_last_24h": day,
+        "created_last_7d": week,
+    }))
+}
+
+fn count_created_since(created: impl Iterator<Item = i64>, now: i64) -> (usize, usize) {
+    let (mut day, mut week) = (0, 0);
+    for at in created {
+        if at >= now - 86_400 {
+            day += 1;
+        }
+        if at >= now - 7 * 86_400 {
+            week += 1;
+        }
+    }
+    (day, week)
+}
+
+// ── Local-only toggles ───────────────────────────────────────────────────────
+
+/// Live connections on the listener from addresses local-only would refuse.
+async fn local_only_impact(st: &AdminState, local_only_key: &str) -> Value {
+    let Some(port_key) = port_key_for_local_only(local_only_key) else {
+        return Value::Null;
+    };
+    let Some(port) = current_port(st, port_key).await else {
+        return json!({ "available": false });
+    };
+    let imap = matches!(
+        local_only_key,
+        settings_keys::IMAP_LOCAL_ONLY | settings_keys::IMAP_TLS_LOCAL_ONLY
+    );
+    let peers = super::status_storage::listener_peers(port, imap);
+    let public = count_public(peers.iter().map(String::as_str));
+    json!({
+        "available": true,
+        "port": port,
+        "peers": peers.len(),
+        "public_peers": public,
+    })
+}
+
+fn count_public<'a>(peers: impl Iterator<Item = &'a str>) -> usize {
+    peers
+        .filter_map(|p| p.trim_matches(['[', ']']).parse::<IpAddr>().ok())
+        .filter(is_public_addr)
+        .count()
+}
+
+/// Not loopback, private, link-local, or unique-local — what `*_local_only` would refuse.
+fn is_public_addr(ip: &IpAddr) -> bool {
+    match ip {
+        IpAddr::V4(v4) => {
+            !(v4.is_private() || v4.is_loopback() || v4.is_link_local() || v4.is_unspecified())
+        }
+        IpAddr::V6(v6) => match v6.to_ipv4_mapped() {
+            Some(v4) => is_public_addr(&IpAddr::V4(v4)),
+            None => {
+                !(v6.is_loopback()
+                    || v6.is_unspecified()
+                    || v6.is_unique_local()
+                    || (v6.segments()[0] & 0xffc0) == 0xfe80)
+            }
+        },
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn port_probe_detects_busy_and_current_ports() {
+        let busy = std::net::TcpListener::bind(("0.0.0.0", 0)).unwrap();
+        let port = busy.local_addr().unwrap().port();
+        assert_eq!(probe_port(port), PortProbe::InUse);
+        assert_eq!(port_impact(port, Some(port))["status"], "current");
+        drop(busy);
+        assert_eq!(port_impact(port, None)["status"], "free");
+    }
+
+    #[test]
+    fn registration_closure_detection_and_counts() {
+        let k = settings_keys::REGISTRATION_OPEN;
+        assert!(closes_registration(k, Some("true"), Some("false")));
+        assert!(!closes_registration(k, Some("false"), Some("false")));
+        assert!(closes_registration(
+            settings_keys::JIT_REGISTRATION_ENABLED,
+            None,
+            Some("false")
+        ));
+        let now = 1_000_000;
+        let created = [now - 10, now - 2 * 86_400, now - 30 * 86_400];
+        assert_eq!(count_created_since(created.into_iter(), now), (1, 2));
+    }
+
+    #[test]
+    fn local_only_counts_public_peers() {
+        let peers = [
+            "127.0.0.1",
+            "10.1.2.3",
+            "203.0.113.9",
+            "[::1]",
+            "2001:db8::1",
+            "fe80::1",
+        ];
+        assert_eq!(count_public(peers.into_iter()), 2);
+        assert!(turns_on(None, Some("true")));
+        assert!(!turns_on(Some("true"), Some("true")));
+    }
+
+    #[test]
+    fn apply_mode_and_services() {
+        assert_eq!(apply_mode(settings_keys::IMAP_PORT), ApplyMode::Restart);
+        assert_eq!(
+            apply_mode(settings_keys::MAX_ACCOUNTS),
+            ApplyMode::Immediate
+        );
+        assert_eq!(
+            apply_mode(settings_keys::ADMIN_WEB_PATH),
+            ApplyMode::HttpRoutesReload
+        );
+        assert_eq!(
+            service_for_key(settings_keys::IMAP_TLS_LOCAL_ONLY),
+            Some("imap_tls")
+        );
+        assert_eq!(service_for_key(settings_keys::LANGUAGE), None);
+    }
+}
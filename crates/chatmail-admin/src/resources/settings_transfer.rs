@@ -0,0 +1,502 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Settings DB export/import — `GET /admin/settings/export` and `madmail settings`.
+//!
+//! Every `__KEY__` row is dumped as a key-sorted JSON document so it can live in
+//! config management. Secrets are replaced with [`REDACTED`] unless explicitly
+//! requested. Imports run each value through the admin API validation and write
+//! nothing unless every entry passes.
+
+use std::collections::BTreeMap;
+
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+
+use chatmail_config::{turn_relay_ports::TurnRelayPortRange, AppConfig};
+use chatmail_db::{
+    delete_setting, list_double_underscore_settings, set_setting, settings_keys, DbPool,
+};
+use chatmail_types::{ChatmailError, Result};
+
+use super::settings::{is_turn_relay_port_key, validate_setting_value};
+use super::{status_storage::db_err, AdminResult};
+use crate::AdminState;
+
+/// `format` marker of an export document.
+pub const SETTINGS_EXPORT_FORMAT: &str = "madmail-settings";
+/// Current export document version.
+pub const SETTINGS_EXPORT_VERSION: u32 = 1;
+/// Placeholder exported instead of a secret value; such entries are skipped on import.
+pub const REDACTED: &str = "<redacted>";
+
+/// Credential keys, redacted unless the export asks for secrets.
+pub const SECRET_SETTING_KEYS: &[&str] = &[
+    settings_keys::TURN_SECRET,
+    settings_keys::SS_PASSWORD,
+    settings_keys::SS_PASSWORD_SLOTS,
+    settings_keys::HTTP_PROXY_PASSWORD,
+];
+
+pub fn is_secret_setting(key: &str) -> bool {
+    SECRET_SETTING_KEYS.contains(&key)
+}
+
+/// Canonical settings dump (keys sorted by `BTreeMap`).
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SettingsExport {
+    pub format: String,
+    pub version: u32,
+    #[serde(default)]
+    pub secrets_included: bool,
+    pub settings: BTreeMap<String, String>,
+}
+
+impl SettingsExport {
+    /// Parse and sanity-check an export document.
+    pub fn parse(text: &str) -> Result<Self> {
+        let doc: Self = serde_json::from_str(text)
+            .map_err(|e| ChatmailError::config(format!("invalid settings export: {e}")))?;
+        if doc.format != SETTINGS_EXPORT_FORMAT {
+            return Err(ChatmailError::config(format!(
+                "invalid settings export: format {:?} (expected {SETTINGS_EXPORT_FORMAT:?})",
+                doc.format
+            )));
+        }
+        if doc.version == 0 || doc.version > SETTINGS_EXPORT_VERSION {
+            return Err(ChatmailError::config(format!(
+                "unsupported settings export version {} (max {SETTINGS_EXPORT_VERSION})",
+                doc.version
+            )));
+        }
+        Ok(doc)
+    }
+
+    /// Pretty JSON with a trailing newline (stable across runs).
+    pub fn to_json_pretty(&self) -> String {
+        let mut out = serde_json::to_string_pretty(self).unwrap_or_default();
+        out.push('\n');
+        out
+    }
+}
+
+/// Dump all runtime settings; secrets become [`REDACTED`] unless `include_secrets`.
+pub async fn export_settings(pool: &DbPool, include_secrets: bool) -> Result<SettingsExport> {
+    let settings = list_double_underscore_settings(pool)
+        .await?
+        .into_iter()
+        .map(|(key, value)| {
+            if !include_secrets && is_secret_setting(&key) {
+                (key, REDACTED.to_string())
+            } else {
+                (key, value)
+            }
+        })
+        .collect();
+    Ok(SettingsExport {
+        format: SETTINGS_EXPORT_FORMAT.to_string(),
+        version: SETTINGS_EXPORT_VERSION,
+        secrets_included: include_secrets,
+        settings,
+    })
+}
+
+/// Outcome for one imported key.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ImportStatus {
+    Created,
+    Updated,
+    Unchanged,
+    /// Redacted secret in the document; the stored value is left alone.
+    Skipped,
+    Invalid,
+}
+
+impl ImportStatus {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Created => "created",
+            Self::Updated => "updated",
+            Self::Unchanged => "unchanged",
+            Self::Skipped => "skipped",
+            Self::Invalid => "invalid",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ImportEntry {
+    pub key: String,
+    pub status: ImportStatus,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ImportReport {
+    pub dry_run: bool,
+    /// `true` once every change was written; never set when an entry is invalid.
+    pub applied: bool,
+    pub entries: Vec<ImportEntry>,
+}
+
+impl ImportReport {
+    pub fn invalid_count(&self) -> usize {
+        self.count(ImportStatus::Invalid)
+    }
+
+    pub fn count(&self, status: ImportStatus) -> usize {
+        self.entries.iter().filter(|e| e.status == status).count()
+    }
+}
+
+/// Validate every entry, then write the changed ones. Any invalid entry aborts the
+/// import before the first write; a DB error mid-way restores the previous values.
+pub async fn import_settings(
+    pool: &DbPool,
+    file_config: &AppConfig,
+    doc: &SettingsExport,
+    dry_run: bool,
+) -> Result<ImportReport> {
+    let current: BTreeMap<String, String> = list_double_underscore_settings(pool)
+        .await?
+        .into_iter()
+        .collect();
+
+    let mut entries: Vec<ImportEntry> = doc
+        .settings
+        .iter()
+        .map(|(key, value)| {
+            let (status, error) = if value == REDACTED {
+                (ImportStatus::Skipped, None)
+            } else if let Err(e) = validate_import_entry(key, value) {
+                (ImportStatus::Invalid, Some(e))
+            } else {
+                match current.get(key) {
+                    None => (ImportStatus::Created, None),
+                    Some(old) if old == value => (ImportStatus::Unchanged, None),
+                    Some(_) => (ImportStatus::Updated, None),
+                }
+            };
+            ImportEntry {
+                key: key.clone(),
+                status,
+                error,
+            }
+        })
+        .collect();
+
+    let imports_relay_ports = entries
+        .iter()
+        .any(|e| e.status != ImportStatus::Skipped && is_turn_relay_port_key(&e.key));
+    if imports_relay_ports && !entries.iter().any(|e| e.status == ImportStatus::Invalid) {
+        if let Err(e) = validate_turn_relay_range(&current, doc, file_config) {
+            for entry in entries
+                .iter_mut()
+                .filter(|e| is_turn_relay_port_key(&e.key))
+            {
+                entry.status = ImportStatus::Invalid;
+                entry.error = Some(e.clone());
+            }
+        }
+    }
+
+    let mut report = ImportReport {
+        dry_run,
+        applied: false,
+        entries,
+    };
+    if dry_run || report.invalid_count() > 0 {
+        return Ok(report);
+    }
+
+    let changes: Vec<(&str, &str)> = report
+        .entries
+        .iter()
+        .filter(|e| matches!(e.status, ImportStatus::Created | ImportStatus::Updated))
+        .filter_map(|e| doc.settings.get_key_value(&e.key))
+        .map(|(k, v)| (k.as_str(), v.as_str()))
+        .collect();
+    let mut written: Vec<&str> = Vec::new();
+    for (key, value) in changes {
+        if let Err(e) = set_setting(pool, key, value).await {
+            rollback(pool, &current, &written).await;
+            return Err(e);
+        }
+        written.push(key);
+    }
+    report.applied = true;
+    tracing::info!(changed = written.len(), "settings import applied");
+    Ok(report)
+}
+
+fn validate_import_entry(key: &str, value: &str) -> std::result::Result<(), String> {
+    let well_formed = key.len() > 4
+        && key.starts_with("__")
+        && key.ends_with("__")
+        && key
+            .chars()
+            .all(|c| c.is_ascii_uppercase() || c.is_ascii_digit() || c == '_');
+    if !well_formed {
+        return Err(format!("{key} is not a settings key (expected __NAME__)"));
+    }
+    if value.is_empty() {
+        return Err("value is required".into());
+    }
+    validate_setting_value(key, value).map_err(|(_, msg)| msg)
+}
+
+/// Same bounds check as `POST /admin/settings/turn_relay_port_*`, against the
+/// settings as they would look after the import.
+fn validate_turn_relay_range(
+    current: &BTreeMap<String, String>,
+    doc: &SettingsExport,
+    file_config: &AppConfig,
+) -> std::result::Result<(), String> {
+    let port = |key: &str, default: u16| {
+        doc.settings
+            .get(key)
+            .filter(|v| v.as_str() != REDACTED)
+            .or_else(|| current.get(key))
+            .and_then(|v| v.trim().parse::<u16>().ok())
+            .filter(|p| *p != 0)
+            .unwrap_or(default)
+    };
+    let file = file_config.effective_turn_relay_port_range();
+    let control_default = if file_config.turn_port == 0 {
+        3478
+    } else {
+        file_config.turn_port
+    };
+    let range = TurnRelayPortRange::resolve(
+        port(settings_keys::TURN_RELAY_PORT_MIN, file.min),
+        port(settings_keys::TURN_RELAY_PORT_MAX, file.max),
+    );
+    range.validate(port(settings_keys::TURN_PORT, control_default))
+}
+
+async fn rollback(pool: &DbPool, previous: &BTreeMap<String, String>, written: &[&str]) {
+    for key in written {
+        let res = match previous.get(*key) {
+            Some(value) => set_setting(pool, key, value).await,
+            None => delete_setting(pool, key).await,
+        };
+        if let Err(e) = res {
+            tracing::warn!(key, error = %e, "settings import rollback failed");
+        }
+    }
+}
+
+#[derive(Deserialize, Default)]
+struct ExportBody {
+    #[serde(default)]
+    include_secrets: bool,
+}
+
+/// OpenAPI schema of the [`SettingsExport`] document.
+pub(crate) const EXPORT_RESPONSE_SCHEMA: &str = r#"{
+    "type": "object",
+    "required": ["format", "version", "settings"],
+    "properties": {
+        "format": {"type": "string"},
+        "version": {"type": "integer"},
+        "secrets_included": {"type": "boolean"},
+        "settings": {"type": "object", "additionalProperties": {"type": "string"}}
+    }
+}"#;
+
+/// `GET /admin/settings/export` — body `{"include_secrets": true}` keeps secret values.
+pub async fn export(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed")));
+    }
+    let req: ExportBody = if body.is_null() {
+        ExportBody::default()
+    } else {
+        serde_json::from_value(body.clone()).map_err(|e| (400, e.to_string()))?
+    };
+    let doc = export_settings(&st.pool, req.include_secrets)
+        .await
+        .map_err(db_err)?;
+    let value = serde_json::to_value(doc).map_err(db_err)?;
+    Ok((200, Some(value)))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_db::{get_setting, init_memory_db, seed_install_defaults};
+
+    async fn wipe(pool: &DbPool) {
+        for (key, _) in list_double_underscore_settings(pool).await.unwrap() {
+            delete_setting(pool, &key).await.unwrap();
+        }
+    }
+
+    async fn seed(pool: &DbPool) {
+        seed_install_defaults(pool).await.unwrap();
+        for (key, value) in [
+            (settings_keys::SMTP_PORT, "2525"),
+            (settings_keys::REGISTRATION_OPEN, "true"),
+            (settings_keys::LANGUAGE, "fa"),
+            (settings_keys::MESSAGE_RETENTION, "30d"),
+            (settings_keys::TURN_SECRET, "s3cret"),
+        ] {
+            set_setting(pool, key, value).await.unwrap();
+        }
+    }
+
+    #[tokio::test]
+    async fn export_redacts_secrets_unless_requested() {
+        let pool = init_memory_db().await.unwrap();
+        seed(&pool).await;
+
+        let doc = export_settings(&pool, false).await.unwrap();
+        assert!(!doc.secrets_included);
+        assert_eq!(doc.settings[settings_keys::TURN_SECRET], REDACTED);
+        assert_eq!(doc.settings[settings_keys::SMTP_PORT], "2525");
+
+        let doc = export_settings(&pool, true).await.unwrap();
+        assert_eq!(doc.settings[settings_keys::TURN_SECRET], "s3cret");
+    }
+
+    #[tokio::test]
+    async fn round_trip_export_wipe_import_export() {
+        let pool = init_memory_db().await.unwrap();
+        seed(&pool).await;
+        let before = export_settings(&pool, false).await.unwrap();
+
+        wipe(&pool).await;
+        let doc = SettingsExport::parse(&before.to_json_pretty()).unwrap();
+        let report = import_settings(&pool, &AppConfig::default(), &doc, false)
+            .await
+            .unwrap();
+        assert!(report.applied);
+        assert_eq!(report.count(ImportStatus::Skipped), 1);
+        assert_eq!(
+            report.count(ImportStatus::Created),
+            before.settings.len() - 1
+        );
+
+        let after = export_settings(&pool, false).await.unwrap();
+        let non_secret = |d: &SettingsExport| {
+            d.settings
+                .iter()
+                .filter(|(k, _)| !is_secret_setting(k))
+                .map(|(k, v)| (k.clone(), v.clone()))
+                .collect::<BTreeMap<_, _>>()
+        };
+        assert_eq!(non_secret(&before), non_secret(&after));
+        assert_eq!(before.to_json_pretty(), {
+            let mut d = after.clone();
+            d.settings
+                .insert(settings_keys::TURN_SECRET.into(), REDACTED.into());
+            d.to_json_pretty()
+        });
+    }
+
+    #[tokio::test]
+    async fn round_trip_with_secrets_restores_them() {
+        let pool = init_memory_db().await.unwrap();
+        seed(&pool).await;
+        let before = export_settings(&pool, true).await.unwrap();
+        wipe(&pool).await;
+        import_settings(&pool, &AppConfig::default(), &before, false)
+            .await
+            .unwrap();
+        assert_eq!(export_settings(&pool, true).await.unwrap(), before);
+    }
+
+    #[tokio::test]
+    async fn invalid_value_rejects_whole_import() {
+        let pool = init_memory_db().await.unwrap();
+        let mut doc = export_settings(&pool, false).await.unwrap();
+        doc.settings
+            .insert(settings_keys::LANGUAGE.into(), "ru".into());
+        doc.settings
+            .insert(settings_keys::SMTP_PORT.into(), "70000".into());
+
+        let report = import_settings(&pool, &AppConfig::default(), &doc, false)
+            .await
+            .unwrap();
+        assert!(!report.applied);
+        assert_eq!(report.invalid_count(), 1);
+        let bad = report
+            .entries
+            .iter()
+            .find(|e| e.key == settings_keys::SMTP_PORT)
+            .unwrap();
+        assert!(bad.error.as_deref().unwrap().contains("port"));
+        assert!(get_setting(&pool, settings_keys::LANGUAGE)
+            .await
+            .unwrap()
+            .is_none());
+    }
+
+    #[tokio::test]
+    async fn dry_run_reports_without_writing() {
+        let pool = init_memory_db().await.unwrap();
+        set_setting(&pool, settings_keys::LANGUAGE, "en")
+            .await
+            .unwrap();
+        let mut doc = export_settings(&pool, false).await.unwrap();
+        doc.settings
+            .insert(settings_keys::LANGUAGE.into(), "es".into());
+        doc.settings
+            .insert(settings_keys::IMAP_PORT.into(), "1143".into());
+
+        let report = import_settings(&pool, &AppConfig::default(), &doc, true)
+            .await
+            .unwrap();
+        assert!(report.dry_run && !report.applied);
+        assert_eq!(report.count(ImportStatus::Updated), 1);
+        assert_eq!(report.count(ImportStatus::Created), 1);
+        assert_eq!(
+            get_setting(&pool, settings_keys::LANGUAGE)
+                .await
+                .unwrap()
+                .as_deref(),
+            Some("en")
+        );
+    }
+
+    #[tokio::test]
+    async fn relay_range_checked_against_merged_settings() {
+        let pool = init_memory_db().await.unwrap();
+        let mut doc = export_settings(&pool, false).await.unwrap();
+        doc.settings
+            .insert(settings_keys::TURN_RELAY_PORT_MIN.into(), "60000".into());
+        doc.settings
+            .insert(settings_keys::TURN_RELAY_PORT_MAX.into(), "50000".into());
+        let report = import_settings(&pool, &AppConfig::default(), &doc, false)
+            .await
+            .unwrap();
+        assert_eq!(report.invalid_count(), 2);
+        assert!(!report.applied);
+    }
+
+    #[test]
+    fn parse_rejects_foreign_documents() {
+        assert!(SettingsExport::parse(r#"{"settings":{}}"#).is_err());
+        assert!(SettingsExport::parse(r#"{"format":"other","version":1,"settings":{}}"#).is_err());
+        assert!(SettingsExport::parse(
+            r#"{"format":"madmail-settings","version":99,"settings":{}}"#
+        )
+        .is_err());
+        assert!(validate_import_entry("language", "en").is_err());
+    }
+}
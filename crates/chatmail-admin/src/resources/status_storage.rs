@@ -32,6 +32,7 @@ use chatmail_push::{
     AUTO_DISABLE_AFTER_FAILURES,
 };
 use chatmail_state::tracker::FederationStatRow;
+use chatmail_state::{CertMonitor, ClockMonitor, DiskGuard, StorageUsageMonitor};
 
 use super::AdminResult;
 use crate::AdminState;
@@ -131,6 +132,44 @@ async fn build_status_body(
     body.insert("sent_messages".into(), json!(sent));
     body.insert("outbound_messages".into(), json!(outbound));
     body.insert("received_messages".into(), json!(received));
+    body.insert(
+        "smtp_greeting".into(),
+        json!({
+            "greet_delay": st.file_config.greet_delay.map(|d| d.as_millis() as u64),
+            "early_talkers": st.app.greet.early_talkers(),
+        }),
+    );
+    body.insert(
+        "memory_budget".into(),
+        json!({
+            "limit_bytes": st.app.memory.limit(),
+            "used_bytes": st.app.memory.used(),
+            "rejections": st.app.memory.rejections(),
+        }),
+    );
+    body.insert(
+        "crashes".into(),
+        json!({
+            "total": st.app.crashes.total(),
+            "by_module": st.app.crashes.counts(),
+            "last_report": st.app.crashes.last_report(),
+            "dir": st.app.crashes.dir(),
+        }),
+    );
+    body.insert("clock".into(), clock_status_json(&st.app.clock));
+    body.insert("tls_certificate".into(), cert_status_json(&st.app.cert));
+    body.insert(
+        "storage_usage".into(),
+        storage_usage_json(&st.app.storage_usage),
+    );
+    body.insert(
+        "disk_guard".into(),
+        disk_guard_json(
+            &st.app.disk,
+            st.app.auth.len(),
+            chatmail_db::max_accounts(&st.pool).await.map_err(db_err)?,
+        ),
+    );
     let local = local_hostnames(st).await;
     let rows = st.app.federation_tracker.snapshot();
     if let Some(es) = email_servers_json_from_rows(&rows, &local) {
@@ -148,6 +187,8 @@ async fn build_status_body(
             "enabled": mr.enabled,
             "days": mr.days,
             "retention": mr.retention,
+            "keep_flagged": st.file_config.effective_retention_keep_flagged(),
+            "keep_unseen": st.file_config.retention_keep_unseen,
         }),
     );
     let push_enabled = push_runtime_enabled(&st.pool).await.map_err(db_err)?;
@@ -371,6 +412,72 @@ fn federation_traffic_json(rows: &[FederationStatRow], local: &HashSet<String>)
     }))
 }
 
+/// Disk tiers and the `max_accounts` cap (see `chatmail-state::DiskGuard`).
+fn disk_guard_json(disk: &DiskGuard, accounts: usize, max_accounts: Option<u64>) -> Value {
+    json!({
+        "tier": disk.tier().as_str(),
+        "percent_used": disk.usage().map(|u| u.percent_used()),
+        "soft_percent": disk.soft_percent(),
+        "hard_percent": disk.hard_percent(),
+        "soft_max_message_bytes": disk.soft_max_message(),
+        "reserved_bytes": disk.reserved_bytes(),
+        "accounts": accounts,
+        "max_accounts": max_accounts,
+    })
+}
+
+/// `clock_check` result: `ok` is false once skew exceeds the threshold.
+fn clock_status_json(clock: &ClockMonitor) -> Value {
+    let reading = clock.reading();
+    json!({
+        "enabled": clock.enabled(),
+        "ok": clock.skew().is_none(),
+        "threshold_secs": clock.threshold().as_secs(),
+        "source": reading.as_ref().map(|r| r.source.as_str()),
+        "skew_secs": reading.as_ref().map(|r| r.skew_secs),
+        "checked_at": reading.as_ref().map(|r| r.checked_at),
+        "error": reading.as_ref().and_then(|r| r.error.as_deref()),
+        "hint": clock.skew_hint(),
+    })
+}
+
+/// Active TLS certificate: `ok` is false inside `cert_expiry_warning`.
+fn cert_status_json(cert: &CertMonitor) -> Value {
+    let reading = cert.reading();
+    json!({
+        "enabled": reading.is_some(),
+        "ok": cert.expiry_hint().is_none(),
+        "warning_days": cert.warning().as_secs() / 86_400,
+        "cert_path": reading.as_ref().map(|r| r.cert_path.as_str()),
+        "not_after": reading.as_ref().map(|r| r.not_after),
+        "days_remaining": cert.days_remaining(),
+        "checked_at": reading.as_ref().map(|r| r.checked_at),
+        "hint": cert.expiry_hint(),
+    })
+}
+
+/// Last mail store scan: `ok` is false once orphaned blobs exceed `storage_orphan_warning`.
+fn storage_usage_json(storage: &StorageUsageMonitor) -> Value {
+    let reading = storage.reading();
+    let usage = reading.as_ref().map(|r| &r.usage);
+    json!({
+        "ok": storage.orphan_hint().is_none(),
+        "orphan_warning_bytes": storage.orphan_warning(),
+        "messages": usage.map(|u| u.messages),
+        "logical_bytes": usage.map(|u| u.logical_bytes),
+        "physical_bytes": usage.map(|u| u.physical_bytes),
+        "allocated_bytes": usage.map(|u| u.allocated_bytes),
+        "dedup_ratio": usage.and_then(|u| u.dedup_ratio()),
+        "blobs": usage.map(|u| u.blobs),
+        "blob_bytes": usage.map(|u| u.blob_bytes),
+        "orphan_blobs": usage.map(|u| u.orphan_blobs),
+        "orphan_bytes": usage.map(|u| u.orphan_bytes),
+        "checked_at": reading.as_ref().map(|r| r.checked_at),
+        "scan_ms": reading.as_ref().map(|r| r.elapsed_ms),
+        "hint": storage.orphan_hint(),
+    })
+}
+
 fn is_ip_like_domain(domain: &str) -> bool {
     let bare = domain.trim().trim_matches(|c| c == '[' || c == ']');
     chatmail_types::is_ipv4_literal(bare)
@@ -388,6 +495,21 @@ fn count_tcp_on_ports(ports: &[String]) -> (i32, std::collections::HashSet<Strin
     (connections, ips)
 }
 
+/// Distinct client IPs on a listener: in-process counters for IMAP (all IMAP listeners),
+/// `ss` on `port` otherwise.
+pub(super) fn listener_peers(port: u16, imap: bool) -> Vec<String> {
+    if imap {
+        let (conns, ips) = imap_connection_peers();
+        if conns > 0 || !ips.is_empty() {
+            return ips.into_iter().collect();
+        }
+    }
+    count_tcp_connections(&port.to_string())
+        .1
+        .into_iter()
+        .collect()
+}
+
 /// Established TCP connections on `sport = :port` via `ss` (Madmail `countTCPConnections`).
 fn count_tcp_connections(port: &str) -> (i32, std::collections::HashSet<String>) {
     let output = match std::process::Command::new("ss")
@@ -531,38 +653,33 @@ pub async fn storage(st: &AdminState, method: &str) -> AdminResult {
     Ok((200, Some(serde_json::Value::Object(body))))
 }
 
-#[cfg(unix)]
-fn disk_usage(path: &Path) -> Option<serde_json::Value> {
-    use std::ffi::CString;
-    use std::mem::MaybeUninit;
-
-    let path_str = path.to_str()?;
-    let cpath = CString::new(path_str).ok()?;
-    let mut stat = MaybeUninit::<libc::statvfs>::uninit();
-    if unsafe { libc::statvfs(cpath.as_ptr(), stat.as_mut_ptr()) } != 0 {
-        return None;
+/// Progress of `madmail storage migrate` (written by the CLI to the state dir).
+pub async fn storage_migrate(st: &AdminState, method: &str) -> AdminResult {
+    if method != "GET" {
+        return Err((405, format!("method {method} not allowed, use GET")));
+    }
+    let path = st.state_dir.join(chatmail_storage::PROGRESS_FILE);
+    match tokio::fs::read(&path).await {
+        Ok(raw) => {
+            let v: Value = serde_json::from_slice(&raw)
+                .map_err(|e| (500, format!("invalid migration progress file: {e}")))?;
+            Ok((200, Some(v)))
+        }
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => {
+            Ok((200, Some(json!({ "state": "idle" }))))
+        }
+        Err(e) => Err((500, e.to_string())),
     }
-    let stat = unsafe { stat.assume_init() };
-    let bsize = stat.f_frsize;
-    let total_bytes = stat.f_blocks * bsize;
-    let avail_bytes = stat.f_bavail * bsize;
-    let used_bytes = total_bytes.saturating_sub(avail_bytes);
-    let percent_used = if total_bytes > 0 {
-        used_bytes as f64 / total_bytes as f64 * 100.0
-    } else {
-        0.0
-    };
-    Some(json!({
-        "total_bytes": total_bytes,
-        "used_bytes": used_bytes,
-        "available_bytes": avail_bytes,
-        "percent_used": percent_used,
-    }))
 }
 
-#[cfg(not(unix))]
-fn disk_usage(_path: &Path) -> Option<serde_json::Value> {
-    None
+fn disk_usage(path: &Path) -> Option<serde_json::Value> {
+    let usage = chatmail_state::disk::disk_usage(path)?;
+    Some(json!({
+        "total_bytes": usage.total_bytes,
+        "used_bytes": usage.used_bytes(),
+        "available_bytes": usage.available_bytes,
+        "percent_used": usage.percent_used(),
+    }))
 }
 
 pub fn restart(method: &str) -> AdminResult {
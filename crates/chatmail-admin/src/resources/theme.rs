@@ -0,0 +1,307 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `/admin/theme` — upload, validate and activate custom www themes.
+//!
+//! `POST {"archive": "<base64 zip>"}` validates the upload and makes it active;
+//! `{"action": "validate", ...}` only reports issues. `rollback` and `deactivate`
+//! switch back without an upload. Activation remounts the HTTP routes so the new
+//! theme is served immediately.
+
+use base64::Engine as _;
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use chatmail_www::{ThemeStore, MAX_THEME_ARCHIVE_BYTES};
+
+use super::{toggles, AdminResult};
+use crate::AdminState;
+
+#[derive(Deserialize)]
+struct ThemeRequest {
+    #[serde(default)]
+    action: String,
+    #[serde(default)]
+    archive: String,
+}
+
+fn decode_archive(b64: &str) -> Result<Vec<u8>, (u16, String)> {
+    if b64.trim().is_empty() {
+        return Err((400, "archive is required (base64-encoded zip)".into()));
+    }
+    let data = base64::engine::general_purpose::STANDARD
+        .decode(b64.trim())
+        .map_err(|e| (400, format!("archive is not valid base64: {e}")))?;
+    if data.len() > MAX_THEME_ARCHIVE_BYTES {
+        return Err((
+            413,
+            format!(
+                "theme archive is {} bytes (limit {MAX_THEME_ARCHIVE_BYTES})",
+                data.len()
+            ),
+        ));
+    }
+    Ok(data)
+}
+
+async fn blocking<T: Send + 'static>(
+    f: impl FnOnce() -> chatmail_types::Result<T> + Send + 'static,
+) -> Result<T, (u16, String)> {
+    tokio::task::spawn_blocking(f)
+        .await
+        .map_err(|e| (500, format!("theme task failed: {e}")))?
+        .map_err(|e| (400, e.to_string()))
+}
+
+/// Remount HTTP routes; activation already happened, so a missing reload
+/// channel is reported rather than failing the request.
+async fn reload_routes(st: &AdminState) -> Value {
+    match toggles::trigger_http_routes_reload(st).await {
+        Ok(()) => json!(true),
+        Err((_, msg)) => {
+            tracing::warn!(error = %msg, "theme activated; HTTP routes not reloaded");
+            json!(false)
+        }
+    }
+}
+
+fn theme_status(st: &AdminState) -> Value {
+    let store = ThemeStore::new(&st.state_dir);
+    let active = store.current();
+    let source = if active.is_some() {
+        "theme"
+    } else if st.file_config.www_dir.is_some() {
+        "www_dir"
+    } else {
+        "embedded"
+    };
+    json!({
+        "active": active,
+        "previous": store.previous(),
+        "installed": store.list(),
+        "source": source,
+        "max_archive_bytes": MAX_THEME_ARCHIVE_BYTES,
+    })
+}
+
+pub async fn theme(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    match method {
+        "GET" => Ok((200, Some(theme_status(st)))),
+        "POST" => {
+            let req: ThemeRequest = serde_json::from_value(body.clone())
+                .map_err(|e| (400, format!("invalid request body: {e}")))?;
+            let store = ThemeStore::new(&st.state_dir);
+            let activation = match req.action.as_str() {
+                "" | "install" => {
+                    let data = decode_archive(&req.archive)?;
+                    blocking(move || store.install_archive(&data)).await?
+                }
+                "validate" => {
+                    let data = decode_archive(&req.archive)?;
+                    let report = blocking(move || store.validate_archive(&data)).await?;
+                    return Ok((
+                        200,
+                        Some(json!({
+                            "valid": report.is_ok(),
+                            "templates": report.templates,
+                            "assets": report.assets,
+                            "issues": report.issues,
+                        })),
+                    ));
+                }
+                "rollback" => blocking(move || store.rollback()).await?,
+                "deactivate" => {
+                    let was = blocking(move || store.deactivate()).await?;
+                    let reloaded = reload_routes(st).await;
+                    return Ok((
+                        200,
+                        Some(json!({ "deactivated": was, "reloaded": reloaded })),
+                    ));
+                }
+                other => {
+                    return Err((
+                        400,
+                        format!(
+                        "invalid action: {other} (expected install|validate|rollback|deactivate)"
+                    ),
+                    ))
+                }
+            };
+            let reloaded = reload_routes(st).await;
+            Ok((
+                200,
+                Some(json!({
+                    "active": activation.id,
+                    "previous": activation.previous,
+                    "templates": activation.templates,
+                    "skipped": activation.skipped,
+                    "reloaded": reloaded,
+                })),
+            ))
+        }
+        _ => Err((405, format!("method {method} not allowed"))),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use chatmail_config::AppConfig;
+    use chatmail_db::init_memory_db;
+    use chatmail_state::AppState;
+    use tempfile::TempDir;
+
+    use super::*;
+
+    async fn test_admin_state() -> (AdminState, TempDir) {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let st = AdminState::new(
+            pool,
+            app,
+            AppConfig::default(),
+            dir.path().to_path_buf(),
+            "example.org".into(),
+            "secret-token-01234567890123456789012345678901".into(),
+            None,
+        );
+        (st, dir)
+    }
+
+    /// One stored entry, enough for the upload path.
+    fn zip_one(name: &str, body: &[u8]) -> String {
+        let crc = {
+            let mut table = [0u32; 256];
+            for (i, slot) in table.iter_mut().enumerate() {
+                let mut c = i as u32;
+                for _ in 0..8 {
+                    c = if c & 1 != 0 {
+                        0xEDB8_8320 ^ (c >> 1)
+                    } else {
+                        c >> 1
+                    };
+                }
+                *slot = c;
+            }
+            !body.iter().fold(!0u32, |c, b| {
+                table[((c ^ *b as u32) & 0xff) as usize] ^ (c >> 8)
+            })
+        };
+        let len = (body.len() as u32).to_le_bytes();
+        let name_len = (name.len() as u16).to_le_bytes();
+        let mut out = Vec::new();
+        out.extend_from_slice(&0x0403_4b50u32.to_le_bytes());
+        out.extend_from_slice(&[20, 0, 0, 0, 0, 0, 0, 0, 0, 0]);
+        out.extend_from_slice(&crc.to_le_bytes());
+        out.extend_from_slice(&len);
+        out.extend_from_slice(&len);
+        out.extend_from_slice(&name_len);
+        out.extend_from_slice(&[0, 0]);
+        out.extend_from_slice(name.as_bytes());
+        out.extend_from_slice(body);
+        let cd_offset = (out.len() as u32).to_le_bytes();
+        let mut cd = Vec::new();
+        cd.extend_from_slice(&0x0201_4b50u32.to_le_bytes());
+        cd.extend_from_slice(&[20, 0, 20, 0, 0, 0, 0, 0, 0, 0, 0, 0]);
+        cd.extend_from_slice(&crc.to_le_bytes());
+        cd.extend_from_slice(&len);
+        cd.extend_from_slice(&len);
+        cd.extend_from_slice(&name_len);
+        cd.extend_from_slice(&[0; 12]);
+        cd.extend_from_slice(&0u32.to_le_bytes());
+        cd.extend_from_slice(name.as_bytes());
+        out.extend_from_slice(&cd);
+        out.extend_from_slice(&0x0605_4b50u32.to_le_bytes());
+        out.extend_from_slice(&[0, 0, 0, 0, 1, 0, 1, 0]);
+        out.extend_from_slice(&(cd.len() as u32).to_le_bytes());
+        out.extend_from_slice(&cd_offset);
+        out.extend_from_slice(&[0, 0]);
+        base64::engine::general_purpose::STANDARD.encode(out)
+    }
+
+    #[tokio::test]
+    async fn upload_activates_and_rejects_broken_themes() {
+        let (st, _dir) = test_admin_state().await;
+        let (status, body) = theme(&st, "GET", &json!({})).await.unwrap();
+        assert_eq!(status, 200);
+        assert_eq!(body.unwrap()["source"], "embedded");
+
+        let archive = zip_one("index.html", b"<h1>{{ MailDomain }}</h1>");
+        let (_, body) = theme(&st, "POST", &json!({ "archive": archive }))
+            .await
+            .unwrap();
+        let body = body.unwrap();
+        let first = body["active"].as_str().unwrap().to_string();
+        // No reload channel in tests: activation stands, reload is reported.
+        assert_eq!(body["reloaded"], false);
+
+        let broken = zip_one("index.html", b"{% if %}");
+        let (_, body) = theme(
+            &st,
+            "POST",
+            &json!({ "action": "validate", "archive": broken }),
+        )
+        .await
+        .unwrap();
+        let body = body.unwrap();
+        assert_eq!(body["valid"], false);
+        assert_eq!(body["issues"][0]["file"], "index.html");
+        let err = theme(&st, "POST", &json!({ "archive": broken }))
+            .await
+            .unwrap_err();
+        assert_eq!(err.0, 400);
+
+        let evil = zip_one("../../index.html", b"x");
+        assert_eq!(
+            theme(&st, "POST", &json!({ "archive": evil }))
+                .await
+                .unwrap_err()
+                .0,
+            400
+        );
+        let (_, body) = theme(&st, "GET", &json!({})).await.unwrap();
+        let body = body.unwrap();
+        assert_eq!(body["active"], first.as_str());
+        assert_eq!(body["installed"].as_array().unwrap().len(), 1);
+    }
+
+    #[tokio::test]
+    async fn rollback_restores_previous_theme() {
+        let (st, _dir) = test_admin_state().await;
+        let v1 = zip_one("index.html", b"v1");
+        let v2 = zip_one("index.html", b"v2");
+        let (_, b1) = theme(&st, "POST", &json!({ "archive": v1 })).await.unwrap();
+        let (_, b2) = theme(&st, "POST", &json!({ "archive": v2 })).await.unwrap();
+        let (id1, id2) = (b1.unwrap()["active"].clone(), b2.unwrap()["active"].clone());
+
+        let (_, body) = theme(&st, "POST", &json!({ "action": "rollback" }))
+            .await
+            .unwrap();
+        let body = body.unwrap();
+        assert_eq!(body["active"], id1);
+        assert_eq!(body["previous"], id2);
+
+        let (_, body) = theme(&st, "POST", &json!({ "action": "deactivate" }))
+            .await
+            .unwrap();
+        assert_eq!(body.unwrap()["deactivated"], id1);
+        let (_, body) = theme(&st, "GET", &json!({})).await.unwrap();
+        assert_eq!(body.unwrap()["source"], "embedded");
+    }
+}
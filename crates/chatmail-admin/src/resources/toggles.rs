@@ -20,9 +20,10 @@ use serde_json::{json, Value};
 use tokio::sync::oneshot;
 use tokio::time::{timeout, Duration};
 
-use chatmail_db::{get_bool_setting, set_setting, settings_keys, DbPool};
+use chatmail_db::{get_bool_setting, set_setting, settings_keys};
 use chatmail_state::{ReloadRequest, ReloadScope};
 
+use super::settings_preview::{is_dry_run, toggle_preview};
 use super::{status_storage::db_err, AdminResult};
 use crate::AdminState;
 
@@ -32,12 +33,12 @@ struct ActionBody {
 }
 
 pub async fn registration(st: &AdminState, method: &str, body: &Value) -> AdminResult {
-    registration_toggle(&st.pool, method, body).await
+    registration_toggle(st, method, body).await
 }
 
 pub async fn jit(st: &AdminState, method: &str, body: &Value) -> AdminResult {
     toggle_setting(
-        &st.pool,
+        st,
         method,
         body,
         settings_keys::JIT_REGISTRATION_ENABLED,
@@ -48,7 +49,7 @@ pub async fn jit(st: &AdminState, method: &str, body: &Value) -> AdminResult {
 }
 
 pub async fn service_bool(st: &AdminState, method: &str, body: &Value, key: &str) -> AdminResult {
-    let mut res = toggle_setting(&st.pool, method, body, key, "enabled", "disabled").await?;
+    let mut res = toggle_setting(st, method, body, key, "enabled", "disabled").await?;
     if method == "POST" && key == chatmail_db::settings_keys::ADMIN_WEB_ENABLED {
         if let Some(body) = &res.1 {
             if body.get("status").and_then(|v| v.as_str()) == Some("enabled") {
@@ -127,7 +128,8 @@ fn reload_send_err(err: tokio::sync::mpsc::error::TrySendError<ReloadRequest>) -
 }
 
 /// Madmail `RegistrationHandler` — actions `open` / `close`.
-async fn registration_toggle(pool: &DbPool, method: &str, body: &Value) -> AdminResult {
+async fn registration_toggle(st: &AdminState, method: &str, body: &Value) -> AdminResult {
+    let pool = &st.pool;
     match method {
         "GET" => {
             let open = get_bool_setting(pool, settings_keys::REGISTRATION_OPEN, false)
@@ -149,6 +151,12 @@ async fn registration_toggle(pool: &DbPool, method: &str, body: &Value) -> Admin
                     ));
                 }
             };
+            if is_dry_run(body) {
+                let was_open = get_bool_setting(pool, settings_keys::REGISTRATION_OPEN, false)
+                    .await
+                    .map_err(db_err)?;
+                return toggle_preview(st, settings_keys::REGISTRATION_OPEN, was_open, open).await;
+            }
             set_setting(
                 pool,
                 settings_keys::REGISTRATION_OPEN,
@@ -173,13 +181,14 @@ fn service_toggle_default_on(key: &str) -> bool {
 
 /// Madmail service toggles — actions `enable` / `disable`.
 async fn toggle_setting(
-    pool: &DbPool,
+    st: &AdminState,
     method: &str,
     body: &Value,
     key: &str,
     on_label: &str,
     off_label: &str,
 ) -> AdminResult {
+    let pool = &st.pool;
     match method {
         "GET" => {
             let on = get_bool_setting(pool, key, service_toggle_default_on(key))
@@ -198,6 +207,12 @@ async fn toggle_setting(
                 "disable" => false,
                 _ => return Err((400, "action must be enable or disable".into())),
             };
+            if is_dry_run(body) {
+                let was_on = get_bool_setting(pool, key, service_toggle_default_on(key))
+                    .await
+                    .map_err(db_err)?;
+                return toggle_preview(st, key, was_on, on).await;
+            }
             set_setting(pool, key, if on { "true" } else { "false" })
                 .await
                 .map_err(db_err)?;
@@ -18,8 +18,9 @@
 use std::path::PathBuf;
 use std::sync::Arc;
 
+use axum::extract::DefaultBodyLimit;
 use axum::middleware;
-use axum::routing::post;
+use axum::routing::{get, post};
 use axum::Router;
 use chatmail_config::AppConfig;
 use chatmail_db::DbPool;
@@ -28,7 +29,8 @@ use tokio::sync::mpsc;
 
 use crate::auth::AuthGate;
 use crate::cors::cors_middleware;
-use crate::handler::admin_handler;
+use crate::events::events_handler;
+use crate::handler::{admin_handler, body_limit_middleware};
 
 #[derive(Clone)]
 pub struct AdminState {
@@ -69,6 +71,12 @@ impl AdminState {
 pub fn admin_router(state: AdminState) -> Router {
     Router::new()
         .route("/", post(admin_handler))
+        .route("/events", get(events_handler))
+        .layer(DefaultBodyLimit::disable())
+        .layer(middleware::from_fn_with_state(
+            state.clone(),
+            body_limit_middleware,
+        ))
         .layer(middleware::from_fn(cors_middleware))
         .with_state(state)
 }
@@ -26,7 +26,7 @@ use chatmail_db::{
     seed_install_defaults, settings_keys,
 };
 use chatmail_push::{push_stats_snapshot, record_successful_delivery};
-use chatmail_state::{AppState, ReloadRequest, ReloadScope};
+use chatmail_state::{AppState, PolicyMode, ReloadRequest, ReloadScope};
 use serde_json::json;
 use tempfile::TempDir;
 use tokio::sync::mpsc;
@@ -113,6 +113,115 @@ async fn p9_shadowsocks_configured_toggle() {
     );
 }
 
+#[tokio::test]
+async fn ss_stats_reports_relay_counters() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let session = st.app.shadowsocks.open();
+    session.record_in(100);
+    session.record_out(40);
+
+    let (status, body) = resources::dispatch(&st, "GET", "/admin/ss-stats", &json!(null))
+        .await
+        .unwrap();
+    assert_eq!(status, 200);
+    let body = body.unwrap();
+    assert_eq!(body["bytes_in"], 100);
+    assert_eq!(body["bytes_out"], 40);
+    assert_eq!(body["active_connections"], 1);
+    assert_eq!(body["total_connections"], 1);
+
+    drop(session);
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/ss-stats", &json!(null))
+        .await
+        .unwrap();
+    assert_eq!(body.unwrap()["active_connections"], 0);
+    let err = resources::dispatch(&st, "POST", "/admin/ss-stats", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 405);
+}
+
+#[tokio::test]
+async fn quota_recalc_repairs_drifted_counters() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    for user in ["a@example.org", "b@example.org"] {
+        chatmail_db::passwords::create_user(&st.pool, user, "h")
+            .await
+            .unwrap();
+    }
+    let folder = st
+        .app
+        .mailbox_store
+        .init_mailbox_dir("a@example.org", "Archive")
+        .await
+        .unwrap();
+    tokio::fs::write(folder.new.join("m1"), vec![b'x'; 64])
+        .await
+        .unwrap();
+    st.app.quota.record_write("b@example.org", 500);
+
+    let (status, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/quota",
+        &json!({ "action": "recalc", "username": "" }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(status, 200);
+    let body = body.unwrap();
+    assert_eq!(body["accounts"], 2);
+    let mut drifted: Vec<_> = body["drifted"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .map(|d| {
+            (
+                d["username"].as_str().unwrap(),
+                d["after_bytes"].as_u64().unwrap(),
+            )
+        })
+        .collect();
+    drifted.sort();
+    assert_eq!(drifted, [("a@example.org", 64), ("b@example.org", 0)]);
+    assert_eq!(st.app.quota.usage("a@example.org"), (64, 0));
+    assert_eq!(st.app.quota.usage("b@example.org"), (0, 0));
+
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/quota",
+        &json!({ "action": "recalc", "username": "a@example.org" }),
+    )
+    .await
+    .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["accounts"], 1);
+    assert!(body["drifted"].as_array().unwrap().is_empty());
+
+    let err = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/quota",
+        &json!({ "action": "recalc", "username": "nobody@example.org" }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 404);
+    let err = resources::dispatch(&st, "POST", "/admin/quota", &json!({ "action": "wipe" }))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 400);
+}
+
 #[tokio::test]
 async fn p9_push_service_toggle() {
     let (st, _dir) = test_state(
@@ -242,143 +351,184 @@ async fn p9_admin_status_get() {
 }
 
 #[tokio::test]
-async fn p9_admin_overview_get() {
+async fn status_reports_clock_skew() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
         AppConfig::default(),
     )
     .await;
-    let (status, body) = resources::dispatch(&st, "GET", "/admin/overview", &json!({}))
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
         .await
         .unwrap();
-    assert_eq!(status, 200);
-    let body = body.unwrap();
-    assert!(body.get("version").is_some());
-    assert!(body.get("users").is_some());
-    assert!(body.get("uptime").is_some());
-    assert!(body.get("disk").is_some());
-    assert!(body.get("tokens").is_some());
-    assert!(body.get("sent_messages").is_some());
-    assert!(body.get("imap").is_some());
-    assert!(body.get("turn").is_some());
-    assert!(body.get("shadowsocks").is_some());
-    assert_eq!(
-        body.get("tokens")
-            .and_then(|v| v.get("total"))
-            .and_then(|v| v.as_i64()),
-        Some(0)
-    );
-    assert!(body.get("settings").is_some());
+    let clock = body.unwrap()["clock"].clone();
+    assert_eq!(clock["ok"], json!(true));
+    assert_eq!(clock["threshold_secs"], json!(120));
+    assert_eq!(clock["skew_secs"], json!(null));
+
+    let fake = chatmail_state::FixedOffsetTimeSource {
+        name: "https://time.example/".into(),
+        offset_secs: -600,
+    };
+    st.app.clock.check(&fake).await.unwrap();
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
+        .await
+        .unwrap();
+    let clock = body.unwrap()["clock"].clone();
+    assert_eq!(clock["ok"], json!(false));
+    assert_eq!(clock["source"], json!("https://time.example/"));
+    assert!(clock["hint"]
+        .as_str()
+        .unwrap()
+        .contains("s ahead of https://time.example/"));
 }
 
 #[tokio::test]
-async fn p9_ss_ws_and_grpc_transports_disabled() {
-    let mut cfg = AppConfig::default();
-    cfg.ss_addr = Some("0.0.0.0:8388".into());
-    cfg.ss_password = Some("pw".into());
-    let (st, _dir) = test_state("secret-token-01234567890123456789012345678901", cfg).await;
-
-    for path in ["/admin/services/ss_ws", "/admin/services/ss_grpc"] {
-        let (_, body) = resources::dispatch(&st, "GET", path, &json!({}))
-            .await
-            .unwrap();
-        assert_eq!(
-            body.unwrap().get("status").and_then(|v| v.as_str()),
-            Some("disabled")
-        );
-        let err = resources::dispatch(&st, "POST", path, &json!({ "action": "enable" }))
-            .await
-            .unwrap_err();
-        assert_eq!(err.0, 400);
-    }
+async fn status_reports_contained_panics() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
+        .await
+        .unwrap();
+    let crashes = body.unwrap()["crashes"].clone();
+    assert_eq!(crashes["total"], json!(0));
+    assert!(crashes["last_report"].is_null());
 
-    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings", &json!({}))
+    let panicked = chatmail_state::catch_panic(async { panic!("status test") })
+        .await
+        .unwrap_err();
+    let report = st
+        .app
+        .crashes
+        .report(&chatmail_state::CrashScope::new("imap", "test"), &panicked)
+        .unwrap();
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
         .await
         .unwrap();
-    let body = body.unwrap();
-    assert_eq!(
-        body.get("ss_ws_enabled").and_then(|v| v.as_str()),
-        Some("disabled")
-    );
-    assert_eq!(
-        body.get("ss_grpc_enabled").and_then(|v| v.as_str()),
-        Some("disabled")
-    );
+    let crashes = body.unwrap()["crashes"].clone();
+    assert_eq!(crashes["total"], json!(1));
+    assert_eq!(crashes["by_module"]["imap"], json!(1));
+    assert_eq!(crashes["last_report"], json!(report));
 }
 
 #[tokio::test]
-async fn p9_all_settings_includes_shadowsocks_url_field() {
-    let mut cfg = AppConfig::default();
-    cfg.ss_addr = Some("0.0.0.0:8388".into());
-    cfg.ss_password = Some("pw".into());
-    let (st, _dir) = test_state("secret-token-01234567890123456789012345678901", cfg).await;
-    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings", &json!({}))
+async fn status_reports_certificate_expiry() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
         .await
         .unwrap();
-    let body = body.unwrap();
-    assert_eq!(
-        body.get("ss_enabled").and_then(|v| v.as_str()),
-        Some("enabled")
+    let cert = body.unwrap()["tls_certificate"].clone();
+    assert_eq!(cert["enabled"], json!(false));
+    assert_eq!(cert["ok"], json!(true));
+    assert_eq!(cert["warning_days"], json!(14));
+
+    let now = chatmail_db::policies::unix_now();
+    st.app.cert.observe(
+        "/etc/madmail/certs/fullchain.pem",
+        now - 87 * 86_400,
+        now + 3 * 86_400 + 3600,
+        now,
     );
-    assert!(body
-        .get("shadowsocks_url")
-        .and_then(|v| v.as_str())
-        .is_some_and(|s| s.starts_with("ss://")));
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
+        .await
+        .unwrap();
+    let cert = body.unwrap()["tls_certificate"].clone();
+    assert_eq!(cert["ok"], json!(false));
+    assert_eq!(cert["days_remaining"], json!(3));
+    assert_eq!(cert["hint"], json!("TLS certificate expires in 3 days"));
 }
 
 #[tokio::test]
-async fn admin_message_size_get_put_delete() {
+async fn status_reports_storage_usage() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
-        AppConfig::default(),
+        AppConfig {
+            storage_orphan_warning: Some("1K".into()),
+            ..AppConfig::default()
+        },
     )
     .await;
-
-    let (_, body) = resources::dispatch(&st, "GET", "/admin/message-size", &json!({}))
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
         .await
         .unwrap();
-    let body = body.unwrap();
-    assert_eq!(
-        body.get("effective_bytes").and_then(|v| v.as_u64()),
-        Some(100 * 1024 * 1024)
-    );
-    assert_eq!(body.get("effective").and_then(|v| v.as_str()), Some("100M"));
+    let storage = body.unwrap()["storage_usage"].clone();
+    assert_eq!(storage["ok"], json!(true));
+    assert_eq!(storage["orphan_warning_bytes"], json!(1024));
+    assert_eq!(storage["checked_at"], json!(null));
 
-    let (_, body) =
-        resources::dispatch(&st, "PUT", "/admin/message-size", &json!({ "size": "64M" }))
-            .await
-            .unwrap();
-    assert_eq!(
-        body.unwrap()
-            .get("effective_bytes")
-            .and_then(|v| v.as_u64()),
-        Some(64 * 1024 * 1024)
+    st.app.storage_usage.observe(
+        chatmail_storage::StorageUsage {
+            messages: 4,
+            logical_bytes: 4000,
+            physical_bytes: 3000,
+            blobs: 2,
+            blob_bytes: 2000,
+            orphan_blobs: 1,
+            orphan_bytes: 1500,
+            ..Default::default()
+        },
+        std::time::Duration::from_millis(12),
+        1_800_000_000,
     );
-    assert_eq!(st.app.message_size.effective(), 64 * 1024 * 1024);
-
-    let (_, body) = resources::dispatch(&st, "DELETE", "/admin/message-size", &json!({}))
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
         .await
         .unwrap();
-    assert_eq!(
-        body.unwrap()
-            .get("effective_bytes")
-            .and_then(|v| v.as_u64()),
-        Some(DEFAULT_MAX_MESSAGE_BYTES)
-    );
+    let storage = body.unwrap()["storage_usage"].clone();
+    assert_eq!(storage["ok"], json!(false));
+    assert_eq!(storage["physical_bytes"], json!(3000));
+    assert_eq!(storage["orphan_blobs"], json!(1));
+    assert_eq!(storage["scan_ms"], json!(12));
+    assert!(storage["hint"].as_str().unwrap().contains("imap-acct gc"));
 }
 
 #[tokio::test]
-async fn admin_message_size_put_rejects_invalid() {
+async fn status_reports_disk_guard() {
+    struct FakeDisk;
+
+    impl chatmail_state::DiskProbe for FakeDisk {
+        fn usage(&self) -> Option<chatmail_state::DiskUsage> {
+            Some(chatmail_state::DiskUsage {
+                total_bytes: 100,
+                available_bytes: 4,
+            })
+        }
+    }
+
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
         AppConfig::default(),
     )
     .await;
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/max_accounts",
+        &json!({ "action": "set", "value": "500" }),
+    )
+    .await
+    .unwrap();
+    st.app.disk.refresh(&FakeDisk);
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
+        .await
+        .unwrap();
+    let disk = body.unwrap()["disk_guard"].clone();
+    assert_eq!(disk["tier"], json!("hard"));
+    assert_eq!(disk["percent_used"], json!(96.0));
+    assert_eq!(disk["soft_percent"], json!(85.0));
+    assert_eq!(disk["hard_percent"], json!(95.0));
+    assert_eq!(disk["max_accounts"], json!(500));
+
     let err = resources::dispatch(
         &st,
-        "PUT",
-        "/admin/message-size",
-        &json!({ "size": "not-a-size" }),
+        "POST",
+        "/admin/settings/max_accounts",
+        &json!({ "action": "set", "value": "-1" }),
     )
     .await
     .unwrap_err();
@@ -386,106 +536,750 @@ async fn admin_message_size_put_rejects_invalid() {
 }
 
 #[tokio::test]
-async fn admin_federation_size_get_put_delete() {
+async fn status_reports_retention_modifiers() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
-        AppConfig::default(),
+        AppConfig {
+            retention_keep_flagged: Some(false),
+            retention_keep_unseen: Some("2160h".into()),
+            ..AppConfig::default()
+        },
     )
     .await;
-
-    let (_, body) = resources::dispatch(&st, "GET", "/admin/federation-size", &json!({}))
-        .await
-        .unwrap();
-    let body = body.unwrap();
-    assert_eq!(
-        body.get("effective_bytes").and_then(|v| v.as_u64()),
-        Some(DEFAULT_MAX_FEDERATION_BYTES)
-    );
-    assert_eq!(body.get("effective").and_then(|v| v.as_str()), Some("70M"));
-
-    let (_, body) = resources::dispatch(
-        &st,
-        "PUT",
-        "/admin/federation-size",
-        &json!({ "size": "64M" }),
-    )
-    .await
-    .unwrap();
-    assert_eq!(
-        body.unwrap()
-            .get("effective_bytes")
-            .and_then(|v| v.as_u64()),
-        Some(64 * 1024 * 1024)
-    );
-    assert_eq!(st.app.federation_size.effective(), 64 * 1024 * 1024);
-
-    let (_, body) = resources::dispatch(&st, "DELETE", "/admin/federation-size", &json!({}))
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/status", &json!({}))
         .await
         .unwrap();
-    assert_eq!(
-        body.unwrap()
-            .get("effective_bytes")
-            .and_then(|v| v.as_u64()),
-        Some(DEFAULT_MAX_FEDERATION_BYTES)
-    );
+    let mr = body.unwrap()["message_retention"].clone();
+    assert_eq!(mr["keep_flagged"], json!(false));
+    assert_eq!(mr["keep_unseen"], json!("2160h"));
 }
 
 #[tokio::test]
-async fn admin_federation_settings_includes_size() {
+async fn admin_incident_notes_round_trip() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
         AppConfig::default(),
     )
     .await;
-    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings/federation", &json!({}))
+    let err = resources::dispatch(&st, "POST", "/admin/incidents", &json!({ "message": " " }))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 400);
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/incidents",
+        &json!({ "message": "Planned IMAP restart", "component": "imap" }),
+    )
+    .await
+    .unwrap();
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/incidents", &json!({}))
         .await
         .unwrap();
     let body = body.unwrap();
+    assert_eq!(body["total"], json!(1));
+    assert_eq!(body["incidents"][0]["kind"], json!("note"));
+    assert_eq!(body["incidents"][0]["component"], json!("imap"));
     assert_eq!(
-        body.get("federation_size_effective_bytes")
-            .and_then(|v| v.as_u64()),
-        Some(DEFAULT_MAX_FEDERATION_BYTES)
-    );
-    assert_eq!(
-        body.get("max_federation_size").and_then(|v| v.as_str()),
-        Some("70M")
+        body["incidents"][0]["message"],
+        json!("Planned IMAP restart")
     );
 }
 
 #[tokio::test]
-async fn admin_settings_max_federation_size_updates_effective() {
+async fn accounts_page_filter_get_and_delete_one() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
         AppConfig::default(),
     )
     .await;
-    resources::dispatch(
-        &st,
-        "POST",
-        "/admin/settings/max_federation_size",
+    for user in ["carol@example.org", "alice@example.org", "bob@example.org"] {
+        chatmail_db::passwords::create_user(&st.pool, user, "h")
+            .await
+            .unwrap();
+        st.app.auth.insert(user, "h");
+    }
+    let inbox = st
+        .app
+        .mailbox_store
+        .init_user_dir("bob@example.org")
+        .await
+        .unwrap();
+    std::fs::write(inbox.new.join("1.a"), b"x").unwrap();
+
+    let (_, body) =
+        resources::dispatch(&st, "GET", "/admin/accounts?page=2&per_page=2", &json!({}))
+            .await
+            .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["total"], json!(3));
+    assert_eq!(body["page"], json!(2));
+    assert_eq!(body["accounts"].as_array().unwrap().len(), 1);
+    assert_eq!(body["accounts"][0]["username"], json!("carol@example.org"));
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/accounts?q=BO", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["total"], json!(1));
+    assert_eq!(body["accounts"][0]["message_count"], json!(1));
+
+    let err = resources::dispatch(&st, "GET", "/admin/accounts?page=0", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 400);
+
+    let (_, one) = resources::dispatch(&st, "GET", "/admin/accounts/bob%40example.org", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(one.unwrap()["username"], json!("bob@example.org"));
+
+    resources::dispatch(&st, "DELETE", "/admin/accounts/bob@example.org", &json!({}))
+        .await
+        .unwrap();
+    assert!(!st.app.mailbox_store.user_root("bob@example.org").exists());
+    assert!(!st.app.auth.user_exists("bob@example.org"));
+    assert!(
+        chatmail_db::passwords::get_user_hash(&st.pool, "bob@example.org")
+            .await
+            .unwrap()
+            .is_none()
+    );
+    let err = resources::dispatch(&st, "GET", "/admin/accounts/bob@example.org", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
+}
+
+#[tokio::test]
+async fn admin_contacts_list_rename_delete() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let pool = st.app.share_views.pool().await.unwrap();
+    for slug in ["alice", "bob", "carol"] {
+        chatmail_db::create_sharing_contact(pool, slug, "reserved", "Reserved")
+            .await
+            .unwrap();
+    }
+
+    let (_, body) =
+        resources::dispatch(&st, "GET", "/admin/contacts?page=2&per_page=2", &json!({}))
+            .await
+            .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["total"], json!(3));
+    assert_eq!(body["contacts"].as_array().unwrap().len(), 1);
+
+    let (_, one) = resources::dispatch(
+        &st,
+        "PATCH",
+        "/admin/contacts/alice",
+        &json!({ "slug": "alice2", "name": "Alice" }),
+    )
+    .await
+    .unwrap();
+    let one = one.unwrap();
+    assert_eq!(
+        (one["slug"].clone(), one["name"].clone()),
+        (json!("alice2"), json!("Alice"))
+    );
+    assert!(chatmail_db::get_sharing_contact(pool, "alice")
+        .await
+        .unwrap()
+        .is_none());
+
+    for (new, status) in [("share", 400), ("bob", 409), ("a-b", 400)] {
+        let err = resources::dispatch(
+            &st,
+            "PATCH",
+            "/admin/contacts/carol",
+            &json!({ "slug": new }),
+        )
+        .await
+        .unwrap_err();
+        assert_eq!(err.0, status, "{new}: {}", err.1);
+    }
+
+    resources::dispatch(&st, "DELETE", "/admin/contacts/bob", &json!({}))
+        .await
+        .unwrap();
+    assert!(!chatmail_db::sharing_slug_exists(pool, "bob").await.unwrap());
+    let err = resources::dispatch(&st, "GET", "/admin/contacts/bob", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
+}
+
+#[tokio::test]
+async fn admin_settings_export_redacts_secrets() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/turn_secret",
+        &json!({ "action": "set", "value": "turn-s3cret" }),
+    )
+    .await
+    .unwrap();
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings/export", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["format"], json!("madmail-settings"));
+    assert_eq!(body["secrets_included"], json!(false));
+    assert_eq!(
+        body["settings"][settings_keys::TURN_SECRET],
+        json!("<redacted>")
+    );
+
+    let (_, body) = resources::dispatch(
+        &st,
+        "GET",
+        "/admin/settings/export",
+        &json!({ "include_secrets": true }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(
+        body.unwrap()["settings"][settings_keys::TURN_SECRET],
+        json!("turn-s3cret")
+    );
+
+    let err = resources::dispatch(&st, "POST", "/admin/settings/export", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 405);
+}
+
+#[tokio::test]
+async fn p9_admin_overview_get() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (status, body) = resources::dispatch(&st, "GET", "/admin/overview", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(status, 200);
+    let body = body.unwrap();
+    assert!(body.get("version").is_some());
+    assert!(body.get("users").is_some());
+    assert!(body.get("uptime").is_some());
+    assert!(body.get("disk").is_some());
+    assert!(body.get("tokens").is_some());
+    assert!(body.get("sent_messages").is_some());
+    assert!(body.get("imap").is_some());
+    assert!(body.get("turn").is_some());
+    assert!(body.get("shadowsocks").is_some());
+    assert_eq!(
+        body.get("tokens")
+            .and_then(|v| v.get("total"))
+            .and_then(|v| v.as_i64()),
+        Some(0)
+    );
+    assert!(body.get("settings").is_some());
+}
+
+#[tokio::test]
+async fn p9_ss_ws_and_grpc_transports_disabled() {
+    let mut cfg = AppConfig::default();
+    cfg.ss_addr = Some("0.0.0.0:8388".into());
+    cfg.ss_password = Some("pw".into());
+    let (st, _dir) = test_state("secret-token-01234567890123456789012345678901", cfg).await;
+
+    for path in ["/admin/services/ss_ws", "/admin/services/ss_grpc"] {
+        let (_, body) = resources::dispatch(&st, "GET", path, &json!({}))
+            .await
+            .unwrap();
+        assert_eq!(
+            body.unwrap().get("status").and_then(|v| v.as_str()),
+            Some("disabled")
+        );
+        let err = resources::dispatch(&st, "POST", path, &json!({ "action": "enable" }))
+            .await
+            .unwrap_err();
+        assert_eq!(err.0, 400);
+    }
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(
+        body.get("ss_ws_enabled").and_then(|v| v.as_str()),
+        Some("disabled")
+    );
+    assert_eq!(
+        body.get("ss_grpc_enabled").and_then(|v| v.as_str()),
+        Some("disabled")
+    );
+}
+
+#[tokio::test]
+async fn p9_all_settings_includes_shadowsocks_url_field() {
+    let mut cfg = AppConfig::default();
+    cfg.ss_addr = Some("0.0.0.0:8388".into());
+    cfg.ss_password = Some("pw".into());
+    let (st, _dir) = test_state("secret-token-01234567890123456789012345678901", cfg).await;
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(
+        body.get("ss_enabled").and_then(|v| v.as_str()),
+        Some("enabled")
+    );
+    assert!(body
+        .get("shadowsocks_url")
+        .and_then(|v| v.as_str())
+        .is_some_and(|s| s.starts_with("ss://")));
+}
+
+#[tokio::test]
+async fn ss_rotate_keeps_previous_password_for_grace() {
+    let mut cfg = AppConfig::default();
+    cfg.ss_addr = Some("0.0.0.0:8388".into());
+    cfg.ss_password = Some("first".into());
+    let (st, _dir) = test_state("secret-token-01234567890123456789012345678901", cfg).await;
+    let path = "/admin/ss/rotate";
+
+    let err = resources::dispatch(&st, "POST", path, &json!({ "grace": "soon" }))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 400);
+    let err = resources::dispatch(&st, "POST", path, &json!({ "password": "first" }))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 400);
+
+    let (status, body) = resources::dispatch(&st, "POST", path, &json!({ "password": "second" }))
+        .await
+        .unwrap();
+    assert_eq!(status, 200);
+    let body = body.unwrap();
+    assert_eq!(body["slots"], json!(1));
+    let retired_until = body["retired_until"].as_i64().unwrap();
+    assert!(retired_until > chatmail_db::policies::unix_now() + 500);
+
+    let (_, settings) = resources::dispatch(&st, "GET", "/admin/settings", &json!({}))
+        .await
+        .unwrap();
+    let settings = settings.unwrap();
+    let urls = settings["shadowsocks_urls"].as_array().unwrap();
+    assert_eq!(urls.len(), 2);
+    assert_eq!(urls[0], settings["shadowsocks_url"]);
+    assert_eq!(settings["ss_password"]["value"], json!("second"));
+
+    // A generated password replaces the primary; `keep_old` keeps "second" for good.
+    let (_, body) = resources::dispatch(&st, "POST", path, &json!({ "keep_old": true }))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert!(!body["password"].as_str().unwrap().is_empty());
+    assert_eq!(body["retired_until"], json!(null));
+    assert_eq!(body["shadowsocks_urls"].as_array().unwrap().len(), 3);
+}
+
+#[tokio::test]
+async fn dkim_rotate_keeps_old_keys_and_activate_switches_selector() {
+    let mut cfg = AppConfig::default();
+    cfg.local_domains = Some("example.org extra.org".into());
+    cfg.dkim_selectors = vec!["default".into()];
+    let (st, dir) = test_state("secret-token-01234567890123456789012345678901", cfg).await;
+    chatmail_delivery::dkim::generate_key(
+        dir.path(),
+        "example.org",
+        "default",
+        chatmail_delivery::dkim::DkimAlgorithm::Ed25519,
+    )
+    .unwrap();
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/dkim", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["active"], json!(["default"]));
+    assert_eq!(body["source"], json!("config"));
+    assert_eq!(body["keys"].as_array().unwrap().len(), 1);
+
+    let err = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/dkim/rotate",
+        &json!({ "algorithm": "dsa" }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 400);
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/dkim/rotate",
+        &json!({ "algorithm": "ed25519", "selector": "next" }),
+    )
+    .await
+    .unwrap();
+    let body = body.unwrap();
+    let keys = body["keys"].as_array().unwrap();
+    assert_eq!(keys.len(), 2, "one key per signing domain");
+    assert_eq!(keys[0]["dns_name"], json!("next._domainkey.example.org"));
+    assert!(keys[0]["dns_value"]
+        .as_str()
+        .unwrap()
+        .starts_with("v=DKIM1; k=ed25519; p="));
+
+    let err = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/dkim/activate",
+        &json!({ "selector": "missing" }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 404);
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/dkim/activate",
+        &json!({ "selector": "next" }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(body.unwrap()["missing_domains"], json!([]));
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/dkim", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["active"], json!(["next"]));
+    assert_eq!(body["source"], json!("setting"));
+    assert_eq!(body["keys"].as_array().unwrap().len(), 3, "old key kept");
+}
+
+#[tokio::test]
+async fn admin_message_size_get_put_delete() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/message-size", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(
+        body.get("effective_bytes").and_then(|v| v.as_u64()),
+        Some(100 * 1024 * 1024)
+    );
+    assert_eq!(body.get("effective").and_then(|v| v.as_str()), Some("100M"));
+
+    let (_, body) =
+        resources::dispatch(&st, "PUT", "/admin/message-size", &json!({ "size": "64M" }))
+            .await
+            .unwrap();
+    assert_eq!(
+        body.unwrap()
+            .get("effective_bytes")
+            .and_then(|v| v.as_u64()),
+        Some(64 * 1024 * 1024)
+    );
+    assert_eq!(st.app.message_size.effective(), 64 * 1024 * 1024);
+
+    let (_, body) = resources::dispatch(&st, "DELETE", "/admin/message-size", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(
+        body.unwrap()
+            .get("effective_bytes")
+            .and_then(|v| v.as_u64()),
+        Some(DEFAULT_MAX_MESSAGE_BYTES)
+    );
+}
+
+#[tokio::test]
+async fn admin_message_size_put_rejects_invalid() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let err = resources::dispatch(
+        &st,
+        "PUT",
+        "/admin/message-size",
+        &json!({ "size": "not-a-size" }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 400);
+}
+
+#[tokio::test]
+async fn admin_federation_size_get_put_delete() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/federation-size", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(
+        body.get("effective_bytes").and_then(|v| v.as_u64()),
+        Some(DEFAULT_MAX_FEDERATION_BYTES)
+    );
+    assert_eq!(body.get("effective").and_then(|v| v.as_str()), Some("70M"));
+
+    let (_, body) = resources::dispatch(
+        &st,
+        "PUT",
+        "/admin/federation-size",
+        &json!({ "size": "64M" }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(
+        body.unwrap()
+            .get("effective_bytes")
+            .and_then(|v| v.as_u64()),
+        Some(64 * 1024 * 1024)
+    );
+    assert_eq!(st.app.federation_size.effective(), 64 * 1024 * 1024);
+
+    let (_, body) = resources::dispatch(&st, "DELETE", "/admin/federation-size", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(
+        body.unwrap()
+            .get("effective_bytes")
+            .and_then(|v| v.as_u64()),
+        Some(DEFAULT_MAX_FEDERATION_BYTES)
+    );
+}
+
+#[tokio::test]
+async fn admin_federation_settings_includes_size() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings/federation", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(
+        body.get("federation_size_effective_bytes")
+            .and_then(|v| v.as_u64()),
+        Some(DEFAULT_MAX_FEDERATION_BYTES)
+    );
+    assert_eq!(
+        body.get("max_federation_size").and_then(|v| v.as_str()),
+        Some("70M")
+    );
+}
+
+#[tokio::test]
+async fn admin_settings_max_federation_size_updates_effective() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/max_federation_size",
         &json!({ "action": "set", "value": "55M" }),
     )
     .await
     .unwrap();
-    assert_eq!(st.app.federation_size.effective(), 55 * 1024 * 1024);
-}
+    assert_eq!(st.app.federation_size.effective(), 55 * 1024 * 1024);
+}
+
+#[tokio::test]
+async fn admin_settings_registration_guards_apply_at_runtime() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    for (name, value) in [("registration_rate", "10/1h"), ("proof_of_work_bits", "18")] {
+        resources::dispatch(
+            &st,
+            "POST",
+            &format!("/admin/settings/{name}"),
+            &json!({ "action": "set", "value": value }),
+        )
+        .await
+        .unwrap();
+    }
+    assert_eq!(st.app.registration_limiter.burst(), 10);
+    assert!((st.app.registration_limiter.rate() - 10.0 / 3600.0).abs() < 1e-12);
+    assert_eq!(st.app.pow_challenges.bits(), 18);
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/settings", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["registration_rate_effective"], "10/1h");
+    assert_eq!(body["proof_of_work_bits_effective"], 18);
+
+    for (name, value) in [("registration_rate", "lots"), ("proof_of_work_bits", "33")] {
+        let err = resources::dispatch(
+            &st,
+            "POST",
+            &format!("/admin/settings/{name}"),
+            &json!({ "action": "set", "value": value }),
+        )
+        .await
+        .unwrap_err();
+        assert_eq!(err.0, 400, "{name}");
+    }
+
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/proof_of_work_bits",
+        &json!({ "action": "reset" }),
+    )
+    .await
+    .unwrap();
+    assert!(!st.app.pow_challenges.is_enabled());
+}
+
+#[tokio::test]
+async fn openapi_document_covers_dispatched_resources() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (status, body) = resources::dispatch(&st, "GET", "/admin/openapi.json", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(status, 200);
+    let doc = body.unwrap();
+    assert_eq!(doc["openapi"], "3.0.3");
+    assert_eq!(
+        doc["components"]["securitySchemes"]["BearerAuth"]["scheme"],
+        "bearer"
+    );
+    assert!(doc["paths"]["/admin/settings/{name}"]["post"]["requestBody"].is_object());
+
+    // Every concrete registry path reaches a handler (an unlisted method gets 405, not 404).
+    for meta in resources::openapi::REGISTRY {
+        if meta.path.contains('{') {
+            continue;
+        }
+        if let Err((status, msg)) =
+            resources::dispatch(&st, "OPTIONS", meta.path, &json!(null)).await
+        {
+            assert!(
+                !msg.starts_with("unknown resource"),
+                "{} is in the OpenAPI registry but not routed ({status})",
+                meta.path
+            );
+        }
+    }
+}
+
+#[tokio::test]
+async fn admin_settings_appendlimit_updates_effective() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/appendlimit",
+        &json!({ "action": "set", "value": "10M" }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(st.app.message_size.effective(), 10 * 1024 * 1024);
+}
+
+#[tokio::test]
+async fn policies_crud_validation_and_federation_override() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+
+    let err = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/policies/federation",
+        &json!({ "pattern": "user@evil.example" }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 400, "federation patterns must be domains");
+
+    let err = resources::dispatch(&st, "GET", "/admin/policies/unknown", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
+
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/policies/federation",
+        &json!({ "pattern": ".evil.example", "note": "spam", "ttl": "1h" }),
+    )
+    .await
+    .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["action"], "deny");
+    assert_eq!(body["active"], true);
+    assert!(!st
+        .app
+        .federation_policy
+        .check_policy("mx.evil.example", PolicyMode::Accept));
+
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/policies/federation", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(body.unwrap()["total"], 1);
+
+    let (_, body) = resources::dispatch(
+        &st,
+        "DELETE",
+        "/admin/policies/federation",
+        &json!({ "pattern": ".evil.example" }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(body.unwrap()["remaining"], 0);
+    assert!(st
+        .app
+        .federation_policy
+        .check_policy("mx.evil.example", PolicyMode::Accept));
 
-#[tokio::test]
-async fn admin_settings_appendlimit_updates_effective() {
-    let (st, _dir) = test_state(
-        "secret-token-01234567890123456789012345678901",
-        AppConfig::default(),
-    )
-    .await;
-    resources::dispatch(
+    let err = resources::dispatch(
         &st,
-        "POST",
-        "/admin/settings/appendlimit",
-        &json!({ "action": "set", "value": "10M" }),
+        "DELETE",
+        "/admin/policies/federation",
+        &json!({ "pattern": ".evil.example" }),
     )
     .await
-    .unwrap();
-    assert_eq!(st.app.message_size.effective(), 10 * 1024 * 1024);
+    .unwrap_err();
+    assert_eq!(err.0, 404);
 }
 
 #[tokio::test]
@@ -679,7 +1473,293 @@ async fn turn_relay_port_settings_set_get_reset() {
 }
 
 #[tokio::test]
-async fn turn_relay_port_settings_reject_min_above_max() {
+async fn turn_relay_port_settings_reject_min_above_max() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/turn_relay_port_max",
+        &json!({ "action": "set", "value": "50000" }),
+    )
+    .await
+    .unwrap();
+    let err = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/turn_relay_port_min",
+        &json!({ "action": "set", "value": "60000" }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 400);
+    assert!(err.1.contains("must be <="));
+}
+
+#[tokio::test]
+async fn turn_relay_port_settings_reject_control_port_in_range() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let err = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/turn_relay_port_min",
+        &json!({ "action": "set", "value": "3000" }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 400);
+    assert!(err.1.contains("3478"));
+}
+
+#[tokio::test]
+async fn webmail_dev_enable_without_origin_sets_services_only() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/services/webmail_dev",
+        &json!({ "action": "enable" }),
+    )
+    .await
+    .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body.get("status").and_then(|v| v.as_str()), Some("enabled"));
+    assert_eq!(
+        body.get("browser_access_enabled").and_then(|v| v.as_bool()),
+        Some(true)
+    );
+}
+
+#[tokio::test]
+async fn webmail_dev_disable_turns_off_services() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    resources::dispatch(
+        &st,
+        "POST",
+        "/admin/services/webmail_dev",
+        &json!({ "action": "enable" }),
+    )
+    .await
+    .unwrap();
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/services/webmail_dev",
+        &json!({ "action": "disable" }),
+    )
+    .await
+    .unwrap();
+    let body = body.unwrap();
+    assert_eq!(
+        body.get("status").and_then(|v| v.as_str()),
+        Some("disabled")
+    );
+    assert_eq!(
+        body.get("browser_access_enabled").and_then(|v| v.as_bool()),
+        Some(false)
+    );
+}
+
+#[tokio::test]
+async fn webmail_dev_enable_sets_services_and_cors() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/services/webmail_dev",
+        &json!({
+            "action": "enable",
+            "origin": "http://127.0.0.1:5173"
+        }),
+    )
+    .await
+    .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body.get("status").and_then(|v| v.as_str()), Some("enabled"));
+    assert_eq!(
+        body.get("webimap_enabled").and_then(|v| v.as_str()),
+        Some("enabled")
+    );
+    assert_eq!(
+        body.get("websmtp_enabled").and_then(|v| v.as_str()),
+        Some("enabled")
+    );
+    assert_eq!(
+        body.get("cors_origins").and_then(|v| v.as_str()),
+        Some("http://127.0.0.1:5173")
+    );
+}
+
+#[tokio::test]
+async fn p9_auth_gate_bearer() {
+    use std::collections::HashMap;
+    let gate = crate::auth::AuthGate::new("secret-token-01234567890123456789012345678901".into());
+    let mut ok = HashMap::new();
+    ok.insert(
+        "Authorization".into(),
+        "Bearer secret-token-01234567890123456789012345678901".into(),
+    );
+    assert!(gate.authenticate(&ok, "127.0.0.1"));
+    let mut bad = HashMap::new();
+    bad.insert("Authorization".into(), "Bearer wrong".into());
+    assert!(!gate.authenticate(&bad, "127.0.0.1"));
+}
+
+#[tokio::test]
+async fn storage_migrate_reports_cli_progress() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/storage/migrate", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(body.unwrap()["state"], "idle");
+
+    std::fs::write(
+        st.state_dir.join(chatmail_storage::PROGRESS_FILE),
+        r#"{"state":"running","accounts_total":3,"accounts_done":1}"#,
+    )
+    .unwrap();
+    let (_, body) = resources::dispatch(&st, "GET", "/admin/storage/migrate", &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["state"], "running");
+    assert_eq!(body["accounts_done"], 1);
+
+    let err = resources::dispatch(&st, "POST", "/admin/storage/migrate", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 405);
+}
+
+#[tokio::test]
+async fn backup_copies_app_db_into_state_dir() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+    let live = chatmail_db::init_db(&st.state_dir.join("credentials.db"))
+        .await
+        .unwrap();
+    let err = resources::dispatch(&st, "GET", "/admin/backup", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 405);
+
+    let (status, body) = resources::dispatch(&st, "POST", "/admin/backup", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(status, 200);
+    let body = body.unwrap();
+    assert_eq!(body["failed"], 0);
+    let db = &body["databases"][0];
+    assert_eq!(db["name"], "credentials");
+    let copy = std::path::PathBuf::from(db["path"].as_str().unwrap());
+    assert!(copy.starts_with(st.state_dir.join("backups")));
+    assert_eq!(db["bytes"], std::fs::metadata(&copy).unwrap().len());
+    drop(live);
+}
+
+#[tokio::test]
+async fn backup_refuses_postgres() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig {
+            credentials_driver: Some("postgres".into()),
+            credentials_dsn: Some("postgres://madmail@localhost/madmail".into()),
+            ..AppConfig::default()
+        },
+    )
+    .await;
+    let err = resources::dispatch(&st, "POST", "/admin/backup", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 400);
+    assert!(err.1.contains("pg_dump"), "{}", err.1);
+}
+
+#[tokio::test]
+async fn settings_dry_run_previews_without_persisting() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig::default(),
+    )
+    .await;
+
+    let busy = std::net::TcpListener::bind(("0.0.0.0", 0)).unwrap();
+    let busy_port = busy.local_addr().unwrap().port();
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/imap_port",
+        &json!({ "action": "set", "value": busy_port.to_string(), "dry_run": true }),
+    )
+    .await
+    .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["dry_run"], true);
+    assert_eq!(body["old_value"], serde_json::Value::Null);
+    assert_eq!(body["new_value"], busy_port.to_string());
+    assert_eq!(body["restart_required"], true);
+    assert_eq!(body["services"], json!(["imap"]));
+    assert_eq!(body["impact"]["port"]["status"], "in_use");
+    assert!(chatmail_db::get_setting(&st.pool, settings_keys::IMAP_PORT)
+        .await
+        .unwrap()
+        .is_none());
+
+    // Same validation as the real write.
+    let err = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/imap_port",
+        &json!({ "action": "set", "value": "0", "dry_run": true }),
+    )
+    .await
+    .unwrap_err();
+    assert_eq!(err.0, 400);
+
+    let (_, body) = resources::dispatch(
+        &st,
+        "POST",
+        "/admin/settings/imap_local_only",
+        &json!({ "action": "set", "value": "true", "dry_run": true }),
+    )
+    .await
+    .unwrap();
+    assert!(body.unwrap()["impact"].get("public_connections").is_some());
+    assert!(
+        !get_bool_setting(&st.pool, settings_keys::IMAP_LOCAL_ONLY, false)
+            .await
+            .unwrap()
+    );
+}
+
+#[tokio::test]
+async fn registration_close_dry_run_reports_recent_signups() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
         AppConfig::default(),
@@ -688,144 +1768,635 @@ async fn turn_relay_port_settings_reject_min_above_max() {
     resources::dispatch(
         &st,
         "POST",
-        "/admin/settings/turn_relay_port_max",
-        &json!({ "action": "set", "value": "50000" }),
+        "/admin/registration",
+        &json!({ "action": "open" }),
     )
     .await
     .unwrap();
-    let err = resources::dispatch(
+    chatmail_db::passwords::create_user(&st.pool, "new@example.org", "hash")
+        .await
+        .unwrap();
+    chatmail_db::registration_tokens::ensure_new_account_quota(&st.pool, "new@example.org")
+        .await
+        .unwrap();
+
+    let (_, body) = resources::dispatch(
         &st,
         "POST",
-        "/admin/settings/turn_relay_port_min",
-        &json!({ "action": "set", "value": "60000" }),
+        "/admin/registration",
+        &json!({ "action": "close", "dry_run": true }),
     )
     .await
-    .unwrap_err();
-    assert_eq!(err.0, 400);
-    assert!(err.1.contains("must be <="));
+    .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["old_value"], "true");
+    assert_eq!(body["new_value"], "false");
+    assert_eq!(body["apply"], "immediate");
+    assert_eq!(body["impact"]["registrations"]["created_last_24h"], 1);
+    assert!(
+        get_bool_setting(&st.pool, settings_keys::REGISTRATION_OPEN, false)
+            .await
+            .unwrap()
+    );
 }
 
+/// End-to-end over HTTP with `chatmail-client`, so the client's envelope and response
+/// structs stay in step with the handlers.
 #[tokio::test]
-async fn turn_relay_port_settings_reject_control_port_in_range() {
+async fn admin_client_roundtrip_over_http() {
+    use chatmail_client::{AdminClient, ClientOptions, NewRegistrationToken, RetryPolicy};
+
+    let token = "secret-token-01234567890123456789012345678901";
+    let (st, _dir) = test_state(token, AppConfig::default()).await;
+    let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+    let addr = listener.local_addr().unwrap();
+    tokio::spawn(async move {
+        axum::serve(listener, crate::admin_router(st))
+            .await
+            .unwrap();
+    });
+    let endpoint = format!("http://{addr}/");
+    let options = ClientOptions::default().retry(RetryPolicy::none());
+
+    let denied = AdminClient::new(&endpoint, "wrong", &options).unwrap();
+    assert!(denied.accounts().await.unwrap_err().is_unauthorized());
+
+    let admin = AdminClient::new(&endpoint, token, &options).unwrap();
+    let created = admin.create_account().await.unwrap();
+    assert!(created.email.ends_with("@example.org"));
+    let list = admin.accounts().await.unwrap();
+    assert_eq!(list.total, 1);
+    assert_eq!(list.accounts[0].username, created.email);
+
+    let invite = admin
+        .create_registration_token(&NewRegistrationToken {
+            token: "client-compat".into(),
+            max_uses: 3,
+            comment: "compat".into(),
+            ..Default::default()
+        })
+        .await
+        .unwrap();
+    assert_eq!(invite.token, "client-compat");
+    assert_eq!(invite.status, "active");
+    let tokens = admin.registration_tokens().await.unwrap();
+    assert!(tokens.tokens.iter().any(|t| t.token == "client-compat"));
+    admin
+        .delete_registration_token("client-compat")
+        .await
+        .unwrap();
+
+    admin.block("spam@example.org", "compat").await.unwrap();
+    let blocked = admin.blocklist().await.unwrap();
+    assert!(blocked
+        .blocked
+        .iter()
+        .any(|b| b.username == "spam@example.org" && b.reason == "compat"));
+    admin.unblock("spam@example.org").await.unwrap();
+
+    assert_eq!(admin.service("webimap").await.unwrap().status, "disabled");
+    let err = admin
+        .call(
+            chatmail_client::Method::Patch,
+            "/admin/blocklist",
+            json!({ "action": "nope" }),
+        )
+        .await
+        .unwrap_err();
+    assert_eq!(err.status(), Some(400));
+}
+
+#[tokio::test]
+async fn account_autoresponse_put_get_delete() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
         AppConfig::default(),
     )
     .await;
+    chatmail_db::passwords::create_user(&st.pool, "alice@example.org", "x")
+        .await
+        .unwrap();
+    let path = "/admin/accounts/alice%40example.org/autoresponse";
+
+    let err = resources::dispatch(&st, "GET", path, &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
     let err = resources::dispatch(
         &st,
-        "POST",
-        "/admin/settings/turn_relay_port_min",
-        &json!({ "action": "set", "value": "3000" }),
+        "PUT",
+        "/admin/accounts/ghost@example.org/autoresponse",
+        &json!({ "body": "x" }),
     )
     .await
     .unwrap_err();
-    assert_eq!(err.0, 400);
-    assert!(err.1.contains("3478"));
+    assert_eq!(err.0, 404);
+    for bad in [
+        json!({}),
+        json!({ "body": " " }),
+        json!({ "body": "x", "starts_at": 200, "ends_at": 100 }),
+        json!({ "body": "x", "subject": "a\nBcc: evil@x" }),
+    ] {
+        let err = resources::dispatch(&st, "PUT", path, &bad)
+            .await
+            .unwrap_err();
+        assert_eq!(err.0, 400, "{bad}");
+    }
+
+    let (status, body) = resources::dispatch(
+        &st,
+        "PUT",
+        path,
+        &json!({ "subject": "On vacation", "body": "Back in May.", "ends_at": 4_000_000_000_i64 }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(status, 200);
+    let body = body.unwrap();
+    assert_eq!(body["username"], json!("alice@example.org"));
+    assert_eq!(body["active"], json!(true));
+    assert_eq!(body["in_effect"], json!(true));
+    assert_eq!(body["starts_at"], json!(null));
+    assert!(
+        st.app
+            .responders
+            .autoresponse("alice@example.org", chatmail_db::policies::unix_now())
+            .is_some(),
+        "cache refreshed"
+    );
+
+    let (_, body) = resources::dispatch(&st, "GET", path, &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(body.unwrap()["subject"], json!("On vacation"));
+    let err = resources::dispatch(&st, "POST", path, &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 405);
+
+    let (_, body) = resources::dispatch(&st, "DELETE", path, &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(body.unwrap()["cleared"], json!("alice@example.org"));
+    assert!(st.app.responders.is_empty());
+    let err = resources::dispatch(&st, "DELETE", path, &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
 }
 
 #[tokio::test]
-async fn webmail_dev_enable_without_origin_sets_services_only() {
+async fn domains_catchall_put_get_delete() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
-        AppConfig::default(),
+        AppConfig {
+            catchall_exclude: vec!["billing".into()],
+            ..AppConfig::default()
+        },
     )
     .await;
+    chatmail_db::passwords::create_user(&st.pool, "team@example.org", "x")
+        .await
+        .unwrap();
+    let path = "/admin/domains/catchall";
+
+    for bad in [
+        json!({ "domain": "other.org", "target": "team@example.org" }),
+        json!({ "domain": "example.org", "target": "ghost@example.org" }),
+        json!({ "domain": "example.org", "target": "team@example.org", "daily_cap": 0 }),
+    ] {
+        let err = resources::dispatch(&st, "PUT", path, &bad)
+            .await
+            .unwrap_err();
+        assert_eq!(err.0, 400, "{bad}");
+    }
+
     let (_, body) = resources::dispatch(
         &st,
-        "POST",
-        "/admin/services/webmail_dev",
-        &json!({ "action": "enable" }),
+        "PUT",
+        path,
+        &json!({ "domain": "Example.org", "target": "team@example.org" }),
     )
     .await
     .unwrap();
     let body = body.unwrap();
-    assert_eq!(body.get("status").and_then(|v| v.as_str()), Some("enabled"));
     assert_eq!(
-        body.get("browser_access_enabled").and_then(|v| v.as_bool()),
-        Some(true)
+        body["daily_cap"],
+        json!(chatmail_db::DEFAULT_CATCHALL_DAILY_CAP)
     );
+    assert!(
+        st.app.catchalls.get("example.org").is_some(),
+        "cache refreshed"
+    );
+
+    let (_, body) = resources::dispatch(&st, "GET", path, &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["total"], json!(1));
+    assert_eq!(body["catchalls"][0]["target"], json!("team@example.org"));
+    assert_eq!(body["catchalls"][0]["today"], json!(0));
+    assert_eq!(body["exclude"], json!(["billing"]));
+
+    resources::dispatch(&st, "DELETE", path, &json!({ "domain": "example.org" }))
+        .await
+        .unwrap();
+    assert!(st.app.catchalls.is_empty());
+    let err = resources::dispatch(&st, "DELETE", path, &json!({ "domain": "example.org" }))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
 }
 
 #[tokio::test]
-async fn webmail_dev_disable_turns_off_services() {
+async fn peers_add_list_remove() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
-        AppConfig::default(),
+        AppConfig {
+            peers: vec!["https://static.example".into()],
+            peer_secrets: vec!["k".into()],
+            ..AppConfig::default()
+        },
     )
     .await;
-    resources::dispatch(
+    let path = "/admin/peers";
+
+    let err = resources::dispatch(&st, "POST", path, &json!({ "url": "ftp://x.example" }))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 400);
+
+    let (_, body) = resources::dispatch(&st, "POST", path, &json!({ "url": "Peer.example/" }))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["url"], json!("https://peer.example"));
+    assert_eq!(body["added"], json!(true));
+
+    let (_, body) = resources::dispatch(&st, "GET", path, &json!({}))
+        .await
+        .unwrap();
+    let body = body.unwrap();
+    assert_eq!(body["total"], json!(2));
+    assert_eq!(body["signed"], json!(true));
+    assert_eq!(body["peers"][0]["base_url"], json!("https://peer.example"));
+    assert_eq!(body["peers"][0]["source"], json!("stored"));
+    assert!(body["peers"][0]["reachable"].is_null());
+    assert_eq!(body["peers"][1]["source"], json!("config"));
+
+    let err = resources::dispatch(
         &st,
-        "POST",
-        "/admin/services/webmail_dev",
-        &json!({ "action": "enable" }),
+        "DELETE",
+        path,
+        &json!({ "url": "https://static.example" }),
     )
     .await
-    .unwrap();
-    let (_, body) = resources::dispatch(
-        &st,
-        "POST",
-        "/admin/services/webmail_dev",
-        &json!({ "action": "disable" }),
+    .unwrap_err();
+    assert_eq!(err.0, 400, "config peers are not removable");
+    resources::dispatch(&st, "DELETE", path, &json!({ "url": "peer.example" }))
+        .await
+        .unwrap();
+    assert_eq!(st.app.peers.snapshot().len(), 1);
+    let err = resources::dispatch(&st, "DELETE", path, &json!({ "url": "peer.example" }))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
+}
+
+#[tokio::test]
+async fn delivery_stats_lists_per_domain_throttle() {
+    let (st, _dir) = test_state(
+        "secret-token-01234567890123456789012345678901",
+        AppConfig {
+            queue: chatmail_config::QueueSettings {
+                domain_limits: vec![chatmail_config::DomainLimit {
+                    domain: "gmail.com".into(),
+                    max_parallel: 2,
+                    max_per_minute: 20,
+                }],
+                ..Default::default()
+            },
+            ..AppConfig::default()
+        },
     )
-    .await
-    .unwrap();
+    .await;
+    let now = std::time::Instant::now();
+    let throttle = &st.app.delivery_throttle;
+    let _permit = throttle.acquire("gmail.com", now).unwrap();
+    assert!(throttle.record_deferral("gmail.com", "450 4.7.0 Try again later", now));
+
+    let (status, body) = resources::dispatch(&st, "GET", "/admin/delivery-stats", &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(status, 200);
     let body = body.unwrap();
-    assert_eq!(
-        body.get("status").and_then(|v| v.as_str()),
-        Some("disabled")
-    );
-    assert_eq!(
-        body.get("browser_access_enabled").and_then(|v| v.as_bool()),
-        Some(false)
-    );
+    assert_eq!(body["max_parallel_per_domain"], 4);
+    assert_eq!(body["max_messages_per_minute_per_domain"], 60);
+    assert_eq!(body["total"], 1);
+    let row = &body["domains"][0];
+    assert_eq!(row["domain"], "gmail.com");
+    assert_eq!(row["in_flight"], 1);
+    assert_eq!(row["max_parallel"], 2);
+    assert_eq!(row["effective_parallel"], 1);
+    assert_eq!(row["effective_per_minute"], 10);
+    assert_eq!(row["reduced"], true);
+
+    let err = resources::dispatch(&st, "POST", "/admin/delivery-stats", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 405);
 }
 
+/// The admin endpoint caps bodies at `upload_body_limit` and refuses non-JSON bodies,
+/// answering in the usual envelope.
 #[tokio::test]
-async fn webmail_dev_enable_sets_services_and_cors() {
+async fn admin_body_limit_and_content_type() {
+    use axum::body::{to_bytes, Body};
+    use axum::http::{header, Request};
+    use tower::ServiceExt;
+
+    let mut cfg = AppConfig::default();
+    cfg.upload_body_limit = Some("4K".into());
+    let (st, _dir) = test_state("tok", cfg).await;
+    let app = crate::admin_router(st);
+    let post = |content_type: &'static str, body: Vec<u8>| {
+        let app = app.clone();
+        async move {
+            let resp = app
+                .oneshot(
+                    Request::builder()
+                        .method("POST")
+                        .uri("/")
+                        .header(header::CONTENT_TYPE, content_type)
+                        .body(Body::from(body))
+                        .unwrap(),
+                )
+                .await
+                .unwrap();
+            let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            serde_json::from_slice::<serde_json::Value>(&bytes).unwrap()
+        }
+    };
+
+    let big = json!({ "resource": "/admin/status", "body": "x".repeat(5000) });
+    let env = post("application/json", serde_json::to_vec(&big).unwrap()).await;
+    assert_eq!(env["status"], 413);
+    assert!(env["error"].as_str().unwrap().contains("4096"));
+
+    let env = post("text/plain", br#"{"resource":"/admin/status"}"#.to_vec()).await;
+    assert_eq!(env["status"], 415);
+
+    let env = post(
+        "application/json",
+        br#"{"resource":"/admin/status"}"#.to_vec(),
+    )
+    .await;
+    assert_eq!(env["status"], 401);
+}
+
+#[tokio::test]
+async fn broadcast_dry_run_then_delivers_to_filtered_inboxes() {
     let (st, _dir) = test_state(
         "secret-token-01234567890123456789012345678901",
-        AppConfig::default(),
+        AppConfig {
+            broadcast_sender: Some("news@example.org".into()),
+            broadcast_rate: Some(1000),
+            ..AppConfig::default()
+        },
     )
     .await;
-    let (_, body) = resources::dispatch(
+    for user in ["a@example.org", "b@example.org", "c@other.example"] {
+        chatmail_db::passwords::create_user(&st.pool, user, "x")
+            .await
+            .unwrap();
+    }
+    let path = "/admin/broadcast";
+
+    for bad in [
+        json!({ "subject": "", "body": "b" }),
+        json!({ "subject": "s", "body": " " }),
+        json!({ "subject": "s", "body": "b", "filter": "active:soon" }),
+        json!({ "subject": "s", "body": "b", "filter": "accounts:a@example.org,z@example.org" }),
+    ] {
+        let err = resources::dispatch(&st, "POST", path, &bad)
+            .await
+            .unwrap_err();
+        assert_eq!(err.0, 400, "{bad}");
+    }
+
+    let (status, body) = resources::dispatch(
         &st,
         "POST",
-        "/admin/services/webmail_dev",
-        &json!({
-            "action": "enable",
-            "origin": "http://127.0.0.1:5173"
-        }),
+        path,
+        &json!({ "subject": "s", "body": "b", "filter": "domain:example.org", "dry_run": true }),
     )
     .await
     .unwrap();
-    let body = body.unwrap();
-    assert_eq!(body.get("status").and_then(|v| v.as_str()), Some("enabled"));
-    assert_eq!(
-        body.get("webimap_enabled").and_then(|v| v.as_str()),
-        Some("enabled")
-    );
+    assert_eq!(status, 200);
+    assert_eq!(body.unwrap()["recipients"], json!(2));
+    let listed = json!({
+        "subject": "s",
+        "body": "b",
+        "filter": "accounts:c@other.example",
+        "dry_run": true,
+    });
+    let (_, body) = resources::dispatch(&st, "POST", path, &listed)
+        .await
+        .unwrap();
+    assert_eq!(body.unwrap()["recipients"], json!(1));
+    assert!(st.app.broadcasts.list().is_empty(), "dry run starts no job");
+
+    let (status, body) = resources::dispatch(
+        &st,
+        "POST",
+        path,
+        &json!({ "subject": "Hello", "body": "**hi**", "markdown": true }),
+    )
+    .await
+    .unwrap();
+    assert_eq!(status, 202);
+    let id = body.unwrap()["id"].as_str().unwrap().to_string();
+
+    let mut job = json!(null);
+    for _ in 0..200 {
+        let (_, body) = resources::dispatch(&st, "GET", path, &json!({}))
+            .await
+            .unwrap();
+        let body = body.unwrap();
+        assert_eq!(body["sender"], json!("news@example.org"));
+        job = body["jobs"][0].clone();
+        if job["state"] == "finished" {
+            assert_eq!(body["audit"][0]["action"], json!("finish"));
+            assert_eq!(body["audit"][0]["actor"], json!("admin-api"));
+            break;
+        }
+        tokio::time::sleep(std::time::Duration::from_millis(10)).await;
+    }
+    assert_eq!(job["id"], json!(id));
+    assert_eq!(job["state"], json!("finished"));
+    let (status, one) = resources::dispatch(&st, "GET", &format!("{path}/{id}"), &json!({}))
+        .await
+        .unwrap();
+    assert_eq!(status, 200);
+    assert_eq!(one.unwrap(), job);
+    let err = resources::dispatch(&st, "GET", "/admin/broadcast/nope", &json!({}))
+        .await
+        .unwrap_err();
+    assert_eq!(err.0, 404);
     assert_eq!(
-        body.get("websmtp_enabled").and_then(|v| v.as_str()),
-        Some("enabled")
+        (job["delivered"].clone(), job["failed"].clone()),
+        (json!(3), json!(0))
     );
+    let inbox = chatmail_storage::list_inbox(&st.app.mailbox_store, "c@other.example")
+        .await
+        .unwrap();
+    assert_eq!(inbox.len(), 1);
+}
+
+/// One enveloped request through `app` with `token`; returns the response envelope.
+async fn envelope_call(
+    app: &axum::Router,
+    token: &str,
+    method: &str,
+    resource: &str,
+    body: serde_json::Value,
+) -> serde_json::Value {
+    use axum::body::{to_bytes, Body};
+    use axum::http::{header, Request};
+    use tower::ServiceExt;
+
+    let envelope = json!({
+        "method": method,
+        "resource": resource,
+        "headers": { "Authorization": format!("Bearer {token}") },
+        "body": body,
+    });
+    let resp = app
+        .clone()
+        .oneshot(
+            Request::builder()
+                .method("POST")
+                .uri("/")
+                .header(header::CONTENT_TYPE, "application/json")
+                .body(Body::from(serde_json::to_vec(&envelope).unwrap()))
+                .unwrap(),
+        )
+        .await
+        .unwrap();
+    let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    serde_json::from_slice::<serde_json::Value>(&bytes).unwrap()
+}
+
+/// `log stderr`: the audit trail is only written while logging is on.
+fn logging_config() -> AppConfig {
+    AppConfig {
+        log_target: Some("stderr".into()),
+        ..AppConfig::default()
+    }
+}
+
+/// Authenticated mutations land in `admin_audit` with an 8-character token prefix and a
+/// redacted body; reads do not, and `/admin/audit` pages the trail newest first.
+#[tokio::test]
+async fn admin_mutations_are_audited() {
+    let token = "secret-token-01234567890123456789012345678901";
+    let (st, _dir) = test_state(token, logging_config()).await;
+    let pool = st.pool.clone();
+    let app = crate::admin_router(st);
+    let call = |method, resource, body| envelope_call(&app, token, method, resource, body);
+
+    let env = call(
+        "POST",
+        "/admin/registration-token",
+        json!({ "token": "invite-abc", "max_uses": 1 }),
+    )
+    .await;
+    assert_eq!(env["status"], 201, "{env}");
+    let env = call("POST", "/admin/incidents", json!({ "message": " " })).await;
+    assert_eq!(env["status"], 400);
+    call("POST", "/admin/incidents", json!({ "message": "restart" })).await;
+    call("GET", "/admin/status", json!({})).await;
+
+    let (rows, total) = chatmail_db::list_admin_audit(&pool, &Default::default())
+        .await
+        .unwrap();
+    assert_eq!(total, 3, "GET is not audited");
+    let first = rows.last().unwrap();
+    assert_eq!(first.resource, "/admin/registration-token");
+    assert_eq!((first.method.as_str(), first.status), ("POST", 201));
+    assert_eq!(first.token_prefix, &token[..8]);
+    assert_eq!(first.remote, "127.0.0.1");
+    assert!(!first.body.contains("invite-abc"), "{}", first.body);
+    assert!(first.body.contains("max_uses"));
+    assert_eq!(rows[1].status, 400);
+
+    let env = call("GET", "/admin/audit?page=2&limit=2", json!({})).await;
+    let body = &env["body"];
+    assert_eq!(body["total"], json!(3));
     assert_eq!(
-        body.get("cors_origins").and_then(|v| v.as_str()),
-        Some("http://127.0.0.1:5173")
+        (body["page"].clone(), body["limit"].clone()),
+        (json!(2), json!(2))
     );
+    let entries = body["entries"].as_array().unwrap();
+    assert_eq!(entries.len(), 1);
+    assert_eq!(entries[0]["resource"], json!("/admin/registration-token"));
+    assert_eq!(entries[0]["token"], json!(&token[..8]));
+    assert_eq!(entries[0]["actor"], json!("admin-api"));
 }
 
+/// Settings writes carry the value before and after, TURN secrets stay out of the trail,
+/// and `/admin/audit?resource=` narrows the list.
 #[tokio::test]
-async fn p9_auth_gate_bearer() {
-    use std::collections::HashMap;
-    let gate = crate::auth::AuthGate::new("secret-token-01234567890123456789012345678901".into());
-    let mut ok = HashMap::new();
-    ok.insert(
-        "Authorization".into(),
-        "Bearer secret-token-01234567890123456789012345678901".into(),
-    );
-    assert!(gate.authenticate(&ok, "127.0.0.1"));
-    let mut bad = HashMap::new();
-    bad.insert("Authorization".into(), "Bearer wrong".into());
-    assert!(!gate.authenticate(&bad, "127.0.0.1"));
+async fn setting_changes_are_audited_with_values() {
+    let token = "secret-token-01234567890123456789012345678901";
+    let (st, _dir) = test_state(token, logging_config()).await;
+    let app = crate::admin_router(st);
+    let call = |method, resource, body| envelope_call(&app, token, method, resource, body);
+
+    for realm in ["a.example", "b.example"] {
+        let body = json!({ "action": "set", "value": realm });
+        let env = call("POST", "/admin/settings/turn_realm", body).await;
+        assert_eq!(env["status"], 200, "{env}");
+    }
+    let body = json!({ "action": "set", "value": "s3cret-turn" });
+    call("POST", "/admin/settings/turn_secret", body).await;
+
+    let env = call(
+        "GET",
+        "/admin/audit?since=1h&resource=%2Fadmin%2Fsettings%2Fturn_realm",
+        json!({}),
+    )
+    .await;
+    let body = &env["body"];
+    assert_eq!(body["total"], json!(2), "{env}");
+    let latest = &body["entries"][0];
+    assert!(latest["old_value"].as_str().unwrap().contains("a.example"));
+    assert!(latest["new_value"].as_str().unwrap().contains("b.example"));
+
+    let env = call(
+        "GET",
+        "/admin/audit?resource=/admin/settings/turn_secret",
+        json!({}),
+    )
+    .await;
+    let text = env["body"].to_string();
+    assert_eq!(env["body"]["total"], json!(1));
+    assert!(!text.contains("s3cret-turn"), "{text}");
+}
+
+/// No-Log policy: with `log off` (the default) admin mutations leave no audit rows.
+#[tokio::test]
+async fn admin_mutations_are_not_audited_without_logging() {
+    let token = "secret-token-01234567890123456789012345678901";
+    let (st, _dir) = test_state(token, AppConfig::default()).await;
+    let pool = st.pool.clone();
+    let app = crate::admin_router(st);
+    let body = json!({ "action": "set", "value": "a.example" });
+    let env = envelope_call(&app, token, "POST", "/admin/settings/turn_realm", body).await;
+    assert_eq!(env["status"], 200, "{env}");
+    let (rows, total) = chatmail_db::list_admin_audit(&pool, &Default::default())
+        .await
+        .unwrap();
+    assert!(rows.is_empty());
+    assert_eq!(total, 0);
 }
@@ -0,0 +1,114 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Owner-initiated password change (`POST /account/password`).
+
+use chatmail_config::CredentialPolicy;
+use chatmail_db::{app_passwords, device_codes, passwords, DbPool};
+use chatmail_types::Result;
+
+use crate::hash::hash_password;
+use crate::validate::validate_new_password;
+
+/// Check `new_password` against the policy and store it for `username`. Returns the new hash
+/// for the caller's auth cache, or `None` when the account no longer exists. Outstanding
+/// device codes and every app password are dropped; setting the password the account already
+/// has succeeds again.
+pub async fn change_password(
+    pool: &DbPool,
+    policy: &CredentialPolicy,
+    username: &str,
+    new_password: &str,
+) -> Result<Option<String>> {
+    validate_new_password(policy, new_password)?;
+    let hash = hash_password(new_password)?;
+    if !passwords::set_user_hash(pool, username, &hash).await? {
+        return Ok(None);
+    }
+    device_codes::revoke_device_codes(pool, username).await?;
+    app_passwords::delete_app_passwords(pool, username).await?;
+    Ok(Some(hash))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::hash::verify_password;
+    use chatmail_db::init_memory_db;
+    use chatmail_types::ChatmailError;
+
+    #[tokio::test]
+    async fn change_password_stores_hash_and_rejects_weak_passwords() {
+        let pool = init_memory_db().await.unwrap();
+        let policy = CredentialPolicy::default();
+        passwords::create_user(&pool, "a@x.org", &hash_password("old-password").unwrap())
+            .await
+            .unwrap();
+
+        let err = change_password(&pool, &policy, "a@x.org", "short")
+            .await
+            .unwrap_err();
+        assert!(matches!(err, ChatmailError::Config(_)));
+
+        for _ in 0..2 {
+            let hash = change_password(&pool, &policy, "a@x.org", "new-Password1")
+                .await
+                .unwrap()
+                .expect("account exists");
+            assert!(verify_password("new-Password1", &hash).unwrap());
+        }
+        let stored = passwords::get_user_hash(&pool, "a@x.org").await.unwrap();
+        assert!(verify_password("new-Password1", stored.as_deref().unwrap()).unwrap());
+
+        let gone = change_password(&pool, &policy, "gone@x.org", "new-Password1")
+            .await
+            .unwrap();
+        assert!(gone.is_none());
+    }
+
+    #[tokio::test]
+    async fn change_password_revokes_app_passwords() {
+        let pool = init_memory_db().await.unwrap();
+        let policy = CredentialPolicy::default();
+        passwords::create_user(&pool, "a@x.org", &hash_password("old-password").unwrap())
+            .await
+            .unwrap();
+        let app_hash = hash_password("app-password").unwrap();
+        app_passwords::create_app_password(&pool, "a@x.org", "device-code", &app_hash)
+            .await
+            .unwrap();
+        app_passwords::create_app_password(&pool, "b@x.org", "device-code", &app_hash)
+            .await
+            .unwrap();
+
+        change_password(&pool, &policy, "a@x.org", "new-Password1")
+            .await
+            .unwrap()
+            .expect("account exists");
+        assert!(app_passwords::list_app_passwords(&pool, "a@x.org")
+            .await
+            .unwrap()
+            .is_empty());
+        assert_eq!(
+            app_passwords::list_app_passwords(&pool, "b@x.org")
+                .await
+                .unwrap()
+                .len(),
+            1
+        );
+    }
+}
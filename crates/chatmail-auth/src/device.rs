@@ -0,0 +1,167 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Device provisioning: a signed-in device mints a short one-time code; a new device trades
+//! it for the account address plus a fresh app password.
+
+use base64::{engine::general_purpose::URL_SAFE_NO_PAD, Engine};
+use chatmail_db::{app_passwords, device_codes, DbPool};
+use chatmail_types::{ChatmailError, Result};
+use getrandom::fill;
+use sha2::{Digest, Sha256};
+
+use crate::generate::random_string;
+use crate::hash::hash_password;
+
+/// How long a device code stays redeemable.
+pub const DEVICE_CODE_TTL_SECS: i64 = 600;
+
+/// Label stored on app passwords created by redemption.
+pub const DEVICE_CODE_LABEL: &str = "device-code";
+
+/// 32 symbols (no `I`, `O`, `0`, `1`) so a random byte maps without bias and codes survive
+/// being read aloud or retyped.
+pub(crate) const CODE_ALPHABET: &[u8; 32] = b"ABCDEFGHJKLMNPQRSTUVWXYZ23456789";
+const CODE_LEN: usize = 8;
+
+pub(crate) fn random_bytes(n: usize) -> Result<Vec<u8>> {
+    let mut buf = vec![0u8; n];
+    fill(&mut buf).map_err(|e| ChatmailError::config(format!("device code rng: {e}")))?;
+    Ok(buf)
+}
+
+/// New code formatted for display (`ABCD-EFGH`).
+pub fn generate_device_code() -> Result<String> {
+    let bytes = random_bytes(CODE_LEN)?;
+    let mut out = String::with_capacity(CODE_LEN + 1);
+    for (i, b) in bytes.iter().enumerate() {
+        if i == CODE_LEN / 2 {
+            out.push('-');
+        }
+        out.push(CODE_ALPHABET[(*b % 32) as usize] as char);
+    }
+    Ok(out)
+}
+
+/// Canonical form of user input: case-insensitive, separators and spaces ignored.
+/// `None` when the result cannot be a code.
+pub fn normalize_device_code(raw: &str) -> Option<String> {
+    let code: String = raw
+        .chars()
+        .filter(|c| !matches!(c, '-' | ' '))
+        .map(|c| c.to_ascii_uppercase())
+        .collect();
+    let ok = code.len() == CODE_LEN && code.bytes().all(|b| CODE_ALPHABET.contains(&b));
+    ok.then_some(code)
+}
+
+/// Stored digest of a code (input may be display or canonical form).
+pub fn device_code_digest(code: &str) -> String {
+    let canonical = normalize_device_code(code).unwrap_or_else(|| code.to_string());
+    URL_SAFE_NO_PAD.encode(Sha256::digest(canonical.as_bytes()))
+}
+
+/// Mint a code for `username`; only its digest is stored.
+pub async fn issue_device_code(pool: &DbPool, username: &str) -> Result<String> {
+    let code = generate_device_code()?;
+    device_codes::create_device_code(
+        pool,
+        username,
+        &device_code_digest(&code),
+        DEVICE_CODE_TTL_SECS,
+    )
+    .await?;
+    Ok(code)
+}
+
+/// Consume `code` and create an app password of `password_len` characters from `charset`.
+/// Returns `(address, app_password)`, or `None` for an unknown, expired or used code.
+pub async fn redeem_device_code(
+    pool: &DbPool,
+    code: &str,
+    charset: &str,
+    password_len: usize,
+) -> Result<Option<(String, String)>> {
+    let Some(canonical) = normalize_device_code(code) else {
+        return Ok(None);
+    };
+    let Some(username) =
+        device_codes::redeem_device_code(pool, &device_code_digest(&canonical)).await?
+    else {
+        return Ok(None);
+    };
+    let password = random_string(charset, password_len)?;
+    let hash = hash_password(&password)?;
+    app_passwords::create_app_password(pool, &username, DEVICE_CODE_LABEL, &hash).await?;
+    Ok(Some((username, password)))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::hash::verify_password;
+    use chatmail_config::DEFAULT_GENERATED_CHARSET;
+    use chatmail_db::init_memory_db;
+
+    #[test]
+    fn codes_normalize_and_digest_stably() {
+        let code = generate_device_code().unwrap();
+        assert_eq!(code.len(), CODE_LEN + 1);
+        assert_eq!(&code[4..5], "-");
+        let typed = code.to_ascii_lowercase().replace('-', " ");
+        assert_eq!(
+            normalize_device_code(&typed).as_deref(),
+            Some(code.replace('-', "").as_str())
+        );
+        assert_eq!(device_code_digest(&typed), device_code_digest(&code));
+        assert_eq!(normalize_device_code("ABCD-EFG0"), None);
+        assert_eq!(normalize_device_code("ABC"), None);
+    }
+
+    #[tokio::test]
+    async fn redeem_creates_app_password_once() {
+        let pool = init_memory_db().await.unwrap();
+        let code = issue_device_code(&pool, "a@x.org").await.unwrap();
+
+        let (user, password) = redeem_device_code(&pool, &code, DEFAULT_GENERATED_CHARSET, 20)
+            .await
+            .unwrap()
+            .expect("first redemption");
+        assert_eq!(user, "a@x.org");
+        assert_eq!(password.len(), 20);
+
+        let hashes = app_passwords::app_password_hashes(&pool, "a@x.org")
+            .await
+            .unwrap();
+        assert_eq!(hashes.len(), 1);
+        assert!(verify_password(&password, &hashes[0]).unwrap());
+
+        assert!(
+            redeem_device_code(&pool, &code, DEFAULT_GENERATED_CHARSET, 20)
+                .await
+                .unwrap()
+                .is_none()
+        );
+        assert_eq!(
+            app_passwords::list_app_passwords(&pool, "a@x.org")
+                .await
+                .unwrap()
+                .len(),
+            1
+        );
+    }
+}
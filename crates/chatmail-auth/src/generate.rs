@@ -0,0 +1,61 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Random credentials drawn from an operator-configurable alphabet (`password_charset`).
+
+use chatmail_types::{ChatmailError, Result};
+use getrandom::fill;
+
+/// `len` characters drawn uniformly from the distinct characters of `charset`.
+pub fn random_string(charset: &str, len: usize) -> Result<String> {
+    let alphabet: Vec<char> = charset.chars().collect();
+    if alphabet.len() < 2 || alphabet.len() > 256 {
+        return Err(ChatmailError::config(format!(
+            "credential alphabet needs 2..=256 characters, got {}",
+            alphabet.len()
+        )));
+    }
+    // Rejection sampling: bytes at or above `limit` would favour the first symbols.
+    let limit = 256 - 256 % alphabet.len();
+    let mut out = String::with_capacity(len);
+    let mut buf = vec![0u8; len.max(16)];
+    while out.chars().count() < len {
+        fill(&mut buf).map_err(|e| ChatmailError::config(format!("random: {e}")))?;
+        for &b in &buf {
+            if (b as usize) < limit && out.chars().count() < len {
+                out.push(alphabet[b as usize % alphabet.len()]);
+            }
+        }
+    }
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn draws_only_from_charset() {
+        let s = random_string("abc!", 200).unwrap();
+        assert_eq!(s.len(), 200);
+        assert!(s.chars().all(|c| "abc!".contains(c)));
+        for c in "abc!".chars() {
+            assert!(s.contains(c), "{c} never drawn");
+        }
+        assert!(random_string("a", 8).is_err());
+    }
+}
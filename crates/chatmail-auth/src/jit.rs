@@ -18,7 +18,7 @@
 use std::sync::Arc;
 
 use chatmail_config::CredentialPolicy;
-use chatmail_db::{passwords, registration_tokens, DbPool, FirstLoginOutcome};
+use chatmail_db::{app_passwords, passwords, registration_tokens, DbPool, FirstLoginOutcome};
 use chatmail_state::{AppState, AuthCache};
 use chatmail_storage::MailboxStore;
 use chatmail_types::{ChatmailError, Result};
@@ -65,8 +65,11 @@ pub fn schedule_hash_upgrade_if_needed(
     }
     tokio::spawn(async move {
         if let Ok(new_hash) = hash_password(&password) {
-            let _ = passwords::create_user(&pool, &user, &new_hash).await;
-            auth.insert(&user, &new_hash);
+            // Only over the hash that was verified: a password change in between wins.
+            if let Ok(true) = passwords::swap_user_hash(&pool, &user, &stored_hash, &new_hash).await
+            {
+                auth.insert(&user, &new_hash);
+            }
         }
     });
 }
@@ -105,6 +108,17 @@ async fn verify_cached(
     Ok(ok)
 }
 
+/// Fallback after the primary password fails: any of the account's app passwords.
+/// Not cached, so the primary password keeps its fast-path entry.
+async fn verify_app_password(ctx: &AuthContext, user: &str, password: &str) -> Result<bool> {
+    for hash in app_passwords::app_password_hashes(&ctx.pool, user).await? {
+        if verify_password(password, &hash)? {
+            return Ok(true);
+        }
+    }
+    Ok(false)
+}
+
 /// Authenticate user; JIT-create account when enabled (Madmail pass_table + imapsql).
 pub async fn authenticate(ctx: &AuthContext, username: &str, password: &str) -> Result<()> {
     let user = normalize_username(username)?;
@@ -120,7 +134,9 @@ pub async fn authenticate(ctx: &AuthContext, username: &str, password: &str) ->
     }
 
     if let Some(hash) = ctx.state.auth.get_hash(&user) {
-        if verify_cached(ctx, &user, password, hash).await? {
+        if verify_cached(ctx, &user, password, hash).await?
+            || verify_app_password(ctx, &user, password).await?
+        {
             return finish_successful_login(ctx, &user).await;
         }
         return Err(ChatmailError::AuthFailed);
@@ -134,7 +150,9 @@ pub async fn authenticate(ctx: &AuthContext, username: &str, password: &str) ->
     }
 
     if let Some(hash) = ctx.state.auth.get_hash(&user) {
-        if verify_cached(ctx, &user, password, hash).await? {
+        if verify_cached(ctx, &user, password, hash).await?
+            || verify_app_password(ctx, &user, password).await?
+        {
             return finish_successful_login(ctx, &user).await;
         }
         return Err(ChatmailError::AuthFailed);
@@ -143,6 +161,15 @@ pub async fn authenticate(ctx: &AuthContext, username: &str, password: &str) ->
     if !ctx.state.auth.jit_registration_enabled() {
         return Err(ChatmailError::AuthFailed);
     }
+    if ctx.state.policies.is_reserved_name(&user) {
+        return Err(ChatmailError::AuthFailed);
+    }
+    // `user+tag` is mail for `user`; handing it out would let anyone claim another
+    // account's (or a reserved name's) subaddresses.
+    if ctx.state.subaddressing.split(&user).is_some() {
+        return Err(ChatmailError::AuthFailed);
+    }
+    ctx.state.check_registration_capacity(&ctx.pool).await?;
 
     validate_localpart_and_password(&ctx.credential_policy, &user, password)?;
 
@@ -154,17 +181,23 @@ pub async fn authenticate(ctx: &AuthContext, username: &str, password: &str) ->
         .record_verified(&user, password_sha256(password));
     ctx.state.mailbox_store.init_user_dir(&user).await?;
     registration_tokens::ensure_new_account_quota(&ctx.pool, &user).await?;
+    ctx.state.publish_account_created(&user, "jit");
 
     finish_successful_login(ctx, &user).await
 }
 
 async fn finish_successful_login(ctx: &AuthContext, user: &str) -> Result<()> {
     if ctx.state.auth.is_login_settled(user) {
+        ctx.state.activity.touch(&ctx.pool, user).await;
         return Ok(());
     }
     match registration_tokens::record_first_login(&ctx.pool, user).await? {
-        FirstLoginOutcome::Ok => {
+        outcome @ (FirstLoginOutcome::Ok | FirstLoginOutcome::FirstLogin) => {
+            if outcome == FirstLoginOutcome::FirstLogin {
+                ctx.state.publish_first_login(user);
+            }
             ctx.state.auth.mark_login_settled(user);
+            ctx.state.activity.touch(&ctx.pool, user).await;
             Ok(())
         }
         FirstLoginOutcome::AccountRemoved => {
@@ -224,6 +257,35 @@ mod tests {
         assert!(ctx.state.auth.user_exists("newuser1@example.org"));
     }
 
+    #[tokio::test]
+    async fn login_records_activity() {
+        let (ctx, _dir) = ctx_with_jit(true).await;
+        authenticate(&ctx, "active1@example.org", "longpassword")
+            .await
+            .unwrap();
+        let activity = chatmail_db::list_last_activity(&ctx.pool).await.unwrap();
+        assert!(activity["active1@example.org"] > 0);
+    }
+
+    #[tokio::test]
+    async fn jit_refused_at_max_accounts() {
+        let (ctx, _dir) = ctx_with_jit(true).await;
+        set_setting(&ctx.pool, settings_keys::MAX_ACCOUNTS, "1")
+            .await
+            .unwrap();
+        authenticate(&ctx, "first1@example.org", "longpassword")
+            .await
+            .unwrap();
+        assert!(matches!(
+            authenticate(&ctx, "second1@example.org", "longpassword").await,
+            Err(ChatmailError::ServerFull(_))
+        ));
+        // Existing accounts keep logging in.
+        authenticate(&ctx, "first1@example.org", "longpassword")
+            .await
+            .unwrap();
+    }
+
     /// P3-UT04: blocked users cannot authenticate even with correct password.
     #[tokio::test]
     async fn p3_ut04_test_blocked_user_rejected() {
@@ -243,6 +305,26 @@ mod tests {
     }
 
     /// P3-UT04 (plan): JIT disabled rejects unknown users.
+    #[tokio::test]
+    async fn jit_never_creates_subaddresses_of_suspended_or_reserved_names() {
+        let (ctx, _dir) = ctx_with_jit(true).await;
+        ctx.state.auth.insert("sleeper@example.org", "bcrypt:x");
+        ctx.state.auth.block("sleeper@example.org");
+        for user in ["sleeper+x@example.org", "postmaster+x@example.org"] {
+            assert!(
+                matches!(
+                    authenticate(&ctx, user, "longpassword").await,
+                    Err(ChatmailError::AuthFailed)
+                ),
+                "{user}"
+            );
+            assert!(!ctx.state.auth.user_exists(user), "{user}");
+        }
+        authenticate(&ctx, "plainuser@example.org", "longpassword")
+            .await
+            .unwrap();
+    }
+
     #[tokio::test]
     async fn p3_ut04_test_jit_disabled_rejects() {
         let (ctx, _dir) = ctx_with_jit(false).await;
@@ -290,6 +372,28 @@ mod tests {
         authenticate(&ctx, "legacy@example.org", "x").await.unwrap();
     }
 
+    #[tokio::test]
+    async fn app_password_authenticates_alongside_primary() {
+        let (ctx, _dir) = ctx_with_jit(false).await;
+        let user = "devices@example.org";
+        let hash = crate::hash_password("primarypassword").unwrap();
+        passwords::create_user(&ctx.pool, user, &hash)
+            .await
+            .unwrap();
+        ctx.state.auth.insert(user, &hash);
+        let app = crate::hash_password("apppassword1").unwrap();
+        app_passwords::create_app_password(&ctx.pool, user, "phone", &app)
+            .await
+            .unwrap();
+
+        authenticate(&ctx, user, "apppassword1").await.unwrap();
+        authenticate(&ctx, user, "primarypassword").await.unwrap();
+        assert!(matches!(
+            authenticate(&ctx, user, "wrongpassword").await,
+            Err(ChatmailError::AuthFailed)
+        ));
+    }
+
     #[tokio::test]
     async fn repeat_login_skips_record_first_login_db_fast_path() {
         let (ctx, _dir) = ctx_with_jit(true).await;
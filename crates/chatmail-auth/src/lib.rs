@@ -15,15 +15,28 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+pub mod change;
+pub mod device;
+pub mod generate;
 pub mod hash;
 pub mod jit;
 pub mod normalize;
+pub mod recovery;
 pub mod validate;
 
+pub use change::change_password;
+pub use device::{
+    device_code_digest, issue_device_code, normalize_device_code, redeem_device_code,
+    DEVICE_CODE_LABEL, DEVICE_CODE_TTL_SECS,
+};
+pub use generate::random_string;
 pub use hash::{
     hash_password, is_importable_hash, needs_default_hash_upgrade, verify_password,
     DEFAULT_HASH_PREFIX,
 };
 pub use jit::{authenticate, schedule_hash_upgrade_if_needed, AuthContext};
 pub use normalize::normalize_username;
-pub use validate::validate_localpart_and_password;
+pub use recovery::{
+    issue_recovery_codes, normalize_recovery_code, recovery_code_digest, redeem_recovery_code,
+};
+pub use validate::{validate_localpart_and_password, validate_new_password};
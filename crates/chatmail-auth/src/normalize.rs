@@ -18,6 +18,10 @@
 use chatmail_types::{wrap_ip_domain, ChatmailError, Result};
 
 /// Normalize email username (Madmail `auth.NormalizeUsername`: case-fold + IP brackets).
+///
+/// The local part is case-folded as Unicode, so an SMTPUTF8 address (`Jörg@`) normalizes
+/// like an ASCII one; whether a non-ASCII address is admissible at all is the transport's
+/// call (`SMTPUTF8` on `MAIL FROM`).
 pub fn normalize_username(raw: &str) -> Result<String> {
     let trimmed = raw.trim();
     if trimmed.is_empty() || !trimmed.contains('@') {
@@ -28,7 +32,7 @@ pub fn normalize_username(raw: &str) -> Result<String> {
         .ok_or_else(|| ChatmailError::config("invalid email address"))?;
     Ok(format!(
         "{}@{}",
-        local.to_ascii_lowercase(),
+        local.to_lowercase(),
         wrap_ip_domain(domain).to_ascii_lowercase()
     ))
 }
@@ -49,4 +53,16 @@ mod tests {
             "user@[1.2.3.4]"
         );
     }
+
+    #[test]
+    fn precis_casefold_covers_unicode_local_parts() {
+        assert_eq!(
+            normalize_username("J\u{d6}RG@Example.org").unwrap(),
+            "j\u{f6}rg@example.org"
+        );
+        assert_eq!(
+            normalize_username("\u{4f60}\u{597d}@example.org").unwrap(),
+            "\u{4f60}\u{597d}@example.org"
+        );
+    }
 }
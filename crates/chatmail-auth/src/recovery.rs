@@ -0,0 +1,177 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Account recovery codes: a handful of single-use codes handed out once with a new account.
+//! Each one trades for a freshly generated password when the original is lost.
+
+use base64::{engine::general_purpose::URL_SAFE_NO_PAD, Engine};
+use chatmail_db::{app_passwords, device_codes, passwords, recovery_codes, DbPool};
+use chatmail_types::Result;
+use sha2::{Digest, Sha256};
+use subtle::ConstantTimeEq;
+
+use crate::device::{random_bytes, CODE_ALPHABET};
+use crate::generate::random_string;
+use crate::hash::hash_password;
+
+/// 12 symbols from a 32-letter alphabet: 60 bits, shown as `XXXX-XXXX-XXXX`.
+const CODE_LEN: usize = 12;
+const GROUP: usize = 4;
+
+/// New code formatted for display (`ABCD-EFGH-JKLM`).
+pub fn generate_recovery_code() -> Result<String> {
+    let bytes = random_bytes(CODE_LEN)?;
+    let mut out = String::with_capacity(CODE_LEN + CODE_LEN / GROUP);
+    for (i, b) in bytes.iter().enumerate() {
+        if i > 0 && i % GROUP == 0 {
+            out.push('-');
+        }
+        out.push(CODE_ALPHABET[(*b % 32) as usize] as char);
+    }
+    Ok(out)
+}
+
+/// Canonical form of user input: case-insensitive, separators and spaces ignored.
+/// `None` when the result cannot be a code.
+pub fn normalize_recovery_code(raw: &str) -> Option<String> {
+    let code: String = raw
+        .chars()
+        .filter(|c| !matches!(c, '-' | ' '))
+        .map(|c| c.to_ascii_uppercase())
+        .collect();
+    let ok = code.len() == CODE_LEN && code.bytes().all(|b| CODE_ALPHABET.contains(&b));
+    ok.then_some(code)
+}
+
+/// Stored digest of a code. Salted with the address so equal codes on two accounts differ.
+pub fn recovery_code_digest(username: &str, code: &str) -> String {
+    let canonical = normalize_recovery_code(code).unwrap_or_else(|| code.to_string());
+    let digest = Sha256::new()
+        .chain_update(username.as_bytes())
+        .chain_update(b":")
+        .chain_update(canonical.as_bytes())
+        .finalize();
+    URL_SAFE_NO_PAD.encode(digest)
+}
+
+/// Replace `username`'s codes with `count` new ones; only digests are stored.
+pub async fn issue_recovery_codes(
+    pool: &DbPool,
+    username: &str,
+    count: u32,
+) -> Result<Vec<String>> {
+    let codes = (0..count)
+        .map(|_| generate_recovery_code())
+        .collect::<Result<Vec<_>>>()?;
+    let digests: Vec<String> = codes
+        .iter()
+        .map(|c| recovery_code_digest(username, c))
+        .collect();
+    recovery_codes::replace_recovery_codes(pool, username, &digests).await?;
+    Ok(codes)
+}
+
+/// Consume `code` and set a new password of `password_len` characters from `charset`.
+/// Returns `(password, hash)`, or `None` for an unknown account, a wrong code or a used one;
+/// the caller cannot tell those apart and neither can the client.
+pub async fn redeem_recovery_code(
+    pool: &DbPool,
+    username: &str,
+    code: &str,
+    charset: &str,
+    password_len: usize,
+) -> Result<Option<(String, String)>> {
+    let Some(canonical) = normalize_recovery_code(code) else {
+        return Ok(None);
+    };
+    let candidate = recovery_code_digest(username, &canonical);
+    // Compare against every stored digest so timing does not reveal which one matched.
+    let mut matched = None;
+    for (id, digest) in recovery_codes::recovery_code_digests(pool, username).await? {
+        if bool::from(digest.as_bytes().ct_eq(candidate.as_bytes())) {
+            matched = matched.or(Some(id));
+        }
+    }
+    let Some(id) = matched else {
+        return Ok(None);
+    };
+    if !recovery_codes::consume_recovery_code(pool, id).await? {
+        return Ok(None);
+    }
+    let password = random_string(charset, password_len)?;
+    let hash = hash_password(&password)?;
+    passwords::create_user(pool, username, &hash).await?;
+    device_codes::revoke_device_codes(pool, username).await?;
+    app_passwords::delete_app_passwords(pool, username).await?;
+    Ok(Some((password, hash)))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::hash::verify_password;
+    use chatmail_config::DEFAULT_GENERATED_CHARSET;
+    use chatmail_db::init_memory_db;
+
+    #[test]
+    fn codes_normalize_and_digest_per_account() {
+        let code = generate_recovery_code().unwrap();
+        assert_eq!(code.len(), 14);
+        assert_eq!(&code[4..5], "-");
+        let typed = code.to_ascii_lowercase().replace('-', " ");
+        assert_eq!(
+            recovery_code_digest("a@x.org", &typed),
+            recovery_code_digest("a@x.org", &code)
+        );
+        assert_ne!(
+            recovery_code_digest("a@x.org", &code),
+            recovery_code_digest("b@x.org", &code)
+        );
+        assert_eq!(normalize_recovery_code("ABCD-EFGH-JKL0"), None);
+    }
+
+    #[tokio::test]
+    async fn each_code_resets_the_password_once() {
+        let pool = init_memory_db().await.unwrap();
+        passwords::create_user(&pool, "a@x.org", &hash_password("old").unwrap())
+            .await
+            .unwrap();
+        let codes = issue_recovery_codes(&pool, "a@x.org", 3).await.unwrap();
+        assert_eq!(codes.len(), 3);
+
+        let redeem = |user: &'static str, code: String| {
+            let pool = pool.clone();
+            async move {
+                redeem_recovery_code(&pool, user, &code, DEFAULT_GENERATED_CHARSET, 20)
+                    .await
+                    .unwrap()
+            }
+        };
+        let (password, hash) = redeem("a@x.org", codes[1].clone())
+            .await
+            .expect("first use");
+        assert_eq!(password.len(), 20);
+        assert!(verify_password(&password, &hash).unwrap());
+        let stored = passwords::get_user_hash(&pool, "a@x.org").await.unwrap();
+        assert_eq!(stored.as_deref(), Some(hash.as_str()));
+
+        assert!(redeem("a@x.org", codes[1].clone()).await.is_none());
+        assert!(redeem("b@x.org", codes[0].clone()).await.is_none());
+        assert!(redeem("a@x.org", "not a code".into()).await.is_none());
+        assert!(redeem("a@x.org", codes[0].clone()).await.is_some());
+    }
+}
@@ -47,6 +47,24 @@ pub fn validate_localpart_and_password(
     Ok(())
 }
 
+/// Rules for a password the account owner picks (`POST /account/password`): at least
+/// `password_min_length` characters, all printable ASCII — the alphabet `password_charset`
+/// itself is held to, so the password survives dclogin links and Basic auth unchanged.
+pub fn validate_new_password(policy: &CredentialPolicy, password: &str) -> Result<()> {
+    let min_p = policy.password_min_length as usize;
+    if password.chars().count() < min_p {
+        return Err(ChatmailError::config(format!(
+            "password must be at least {min_p} characters"
+        )));
+    }
+    if let Some(c) = password.chars().find(|c| !c.is_ascii_graphic()) {
+        return Err(ChatmailError::config(format!(
+            "password may only contain printable ASCII characters without spaces (found {c:?})"
+        )));
+    }
+    Ok(())
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -94,6 +112,17 @@ mod tests {
         assert!(validate_localpart_and_password(&p, "@domain.org", "12345678").is_err());
     }
 
+    #[test]
+    fn new_password_needs_length_and_printable_ascii() {
+        let p = CredentialPolicy::default();
+        assert!(validate_new_password(&p, "Abc-123!xyz").is_ok());
+        for bad in ["short", "has space in it", "tab\there1", "pässwörter1"] {
+            assert!(validate_new_password(&p, bad).is_err(), "{bad:?}");
+        }
+        let err = validate_new_password(&p, "white space").unwrap_err();
+        assert!(matches!(err, ChatmailError::Config(msg) if msg.contains("printable ASCII")));
+    }
+
     #[test]
     fn password_error_mentions_min_length() {
         let p = CredentialPolicy::default();
@@ -0,0 +1,540 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Admin API client (`POST {admin_path}`, JSON envelope, bearer token).
+
+use std::collections::HashMap;
+
+use serde::de::DeserializeOwned;
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+
+use crate::error::{ClientError, Result};
+use crate::retry::RetryPolicy;
+use crate::tls::{normalize_base, ClientOptions};
+
+/// Method carried inside the envelope (the HTTP request itself is always `POST`).
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Method {
+    Get,
+    Post,
+    Put,
+    Patch,
+    Delete,
+}
+
+impl Method {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Get => "GET",
+            Self::Post => "POST",
+            Self::Put => "PUT",
+            Self::Patch => "PATCH",
+            Self::Delete => "DELETE",
+        }
+    }
+
+    /// `GET`, `PUT` and `DELETE` are retried on transient failures.
+    pub fn is_idempotent(self) -> bool {
+        matches!(self, Self::Get | Self::Put | Self::Delete)
+    }
+}
+
+/// Request envelope, as read by the server's `admin_handler`.
+#[derive(Debug, Clone, Serialize)]
+pub struct AdminRequest<'a> {
+    pub method: &'a str,
+    pub resource: &'a str,
+    pub headers: HashMap<&'static str, String>,
+    pub body: &'a Value,
+}
+
+/// Response envelope. `status` is the real result; HTTP is always 200.
+#[derive(Debug, Clone, Deserialize)]
+pub struct AdminResponse {
+    pub status: u16,
+    #[serde(default)]
+    pub resource: Option<String>,
+    #[serde(default)]
+    pub body: Option<Value>,
+    #[serde(default)]
+    pub error: Option<String>,
+    #[serde(default)]
+    pub version: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct Account {
+    pub username: String,
+    pub used_bytes: i64,
+    #[serde(default)]
+    pub deleted_bytes: i64,
+    pub max_bytes: i64,
+    pub is_default_quota: bool,
+    /// Messages across all mailboxes.
+    #[serde(default)]
+    pub message_count: u64,
+    #[serde(default)]
+    pub created_at: i64,
+    #[serde(default)]
+    pub first_login_at: i64,
+    #[serde(default)]
+    pub last_login_at: i64,
+    #[serde(default)]
+    pub last_activity_at: i64,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct AccountList {
+    pub accounts: Vec<Account>,
+    /// Accounts matching the filter, across all pages.
+    pub total: usize,
+    #[serde(default)]
+    pub page: usize,
+    #[serde(default)]
+    pub per_page: usize,
+}
+
+/// `POST /admin/accounts` — the password is only ever returned here.
+#[derive(Debug, Clone, Deserialize)]
+pub struct CreatedAccount {
+    pub email: String,
+    pub password: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct BlockedUser {
+    pub username: String,
+    pub reason: String,
+    pub blocked_at: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct BlockList {
+    pub blocked: Vec<BlockedUser>,
+    pub total: usize,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct RegistrationToken {
+    pub token: String,
+    pub max_uses: i32,
+    pub used_count: i32,
+    #[serde(default)]
+    pub pending_reservations: i32,
+    #[serde(default)]
+    pub comment: String,
+    #[serde(default)]
+    pub created_at: String,
+    pub expires_at: Option<String>,
+    pub status: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct RegistrationTokenList {
+    pub tokens: Vec<RegistrationToken>,
+    pub total: usize,
+}
+
+/// `POST /admin/registration-token`. An empty `token` lets the server generate one.
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct NewRegistrationToken {
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub token: String,
+    pub max_uses: i32,
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub comment: String,
+    /// Relative lifetime such as `7d` or `12h`.
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub expires_in: String,
+}
+
+/// `/admin/services/*` and toggle resources.
+#[derive(Debug, Clone, Deserialize)]
+pub struct ServiceStatus {
+    pub status: String,
+}
+
+/// Admin API client. Cheap to clone; clones share the connection pool.
+///
+/// ```no_run
+/// # async fn demo() -> chatmail_client::Result<()> {
+/// use chatmail_client::{AdminClient, ClientOptions, TlsMode};
+///
+/// let options = ClientOptions::default().tls(TlsMode::pinned(
+///     "5E:88:48:98:DA:28:04:71:51:D0:E5:6F:8D:C6:29:27:73:60:3D:0D:6A:AB:BD:D6:2A:11:EF:72:1D:15:42:D8",
+/// )?);
+/// let admin = AdminClient::new("https://mail.example.org/api/admin", "token", &options)?;
+/// for account in admin.accounts().await?.accounts {
+///     println!("{} {}", account.username, account.used_bytes);
+/// }
+/// admin.block("spam@mail.example.org", "abuse report").await?;
+/// # Ok(())
+/// # }
+/// ```
+#[derive(Debug, Clone)]
+pub struct AdminClient {
+    http: reqwest::Client,
+    endpoint: String,
+    token: String,
+    retry: RetryPolicy,
+}
+
+impl AdminClient {
+    /// `endpoint` is the full admin URL including `admin_path` (default `/api/admin`).
+    pub fn new(endpoint: &str, token: impl Into<String>, options: &ClientOptions) -> Result<Self> {
+        Ok(Self {
+            http: options.build_http()?,
+            endpoint: normalize_base(endpoint)?,
+            token: token.into(),
+            retry: options.retry,
+        })
+    }
+
+    /// Raw call; returns the envelope `body` (`null` when absent) or the envelope error.
+    pub async fn call(&self, method: Method, resource: &str, body: Value) -> Result<Value> {
+        self.retry
+            .run(method.is_idempotent(), || {
+                self.call_once(method, resource, &body)
+            })
+            .await
+    }
+
+    /// [`call`](Self::call) decoded into `T`.
+    pub async fn call_as<T: DeserializeOwned>(
+        &self,
+        method: Method,
+        resource: &str,
+        body: Value,
+    ) -> Result<T> {
+        let value = self.call(method, resource, body).await?;
+        serde_json::from_value(value).map_err(|e| ClientError::Decode(format!("{resource}: {e}")))
+    }
+
+    async fn call_once(&self, method: Method, resource: &str, body: &Value) -> Result<Value> {
+        let request = AdminRequest {
+            method: method.as_str(),
+            resource,
+            headers: HashMap::from([("Authorization", format!("Bearer {}", self.token))]),
+            body,
+        };
+        let resp = self.http.post(&self.endpoint).json(&request).send().await?;
+        // Non-200 only comes from something in front of the server (proxy, gateway).
+        if !resp.status().is_success() {
+            return Err(ClientError::Api {
+                status: resp.status().as_u16(),
+                message: resp.text().await.unwrap_or_default(),
+                resource: Some(resource.to_string()),
+            });
+        }
+        let envelope: AdminResponse = resp
+            .json()
+            .await
+            .map_err(|e| ClientError::Decode(format!("{resource}: {e}")))?;
+        if envelope.status >= 400 {
+            return Err(ClientError::Api {
+                status: envelope.status,
+                message: envelope.error.unwrap_or_default(),
+                resource: envelope.resource.or_else(|| Some(resource.to_string())),
+            });
+        }
+        Ok(envelope.body.unwrap_or(Value::Null))
+    }
+
+    pub async fn status(&self) -> Result<Value> {
+        self.call(Method::Get, "/admin/status", json!({})).await
+    }
+
+    pub async fn overview(&self) -> Result<Value> {
+        self.call(Method::Get, "/admin/overview", json!({})).await
+    }
+
+    /// First page (100 accounts); see [`accounts_page`](Self::accounts_page).
+    pub async fn accounts(&self) -> Result<AccountList> {
+        self.call_as(Method::Get, "/admin/accounts", json!({}))
+            .await
+    }
+
+    /// `page` is 1-based; `query` filters by a case-insensitive username substring.
+    pub async fn accounts_page(
+        &self,
+        page: usize,
+        per_page: usize,
+        query: &str,
+    ) -> Result<AccountList> {
+        let resource = format!(
+            "/admin/accounts?page={page}&per_page={per_page}&q={}",
+            encode_query_value(query)
+        );
+        self.call_as(Method::Get, &resource, json!({})).await
+    }
+
+    pub async fn account(&self, username: &str) -> Result<Account> {
+        let resource = format!("/admin/accounts/{}", encode_query_value(username));
+        self.call_as(Method::Get, &resource, json!({})).await
+    }
+
+    /// Not retried: a lost reply could otherwise create two accounts.
+    pub async fn create_account(&self) -> Result<CreatedAccount> {
+        self.call_as(Method::Post, "/admin/accounts", json!({}))
+            .await
+    }
+
+    /// Deletes the mailbox and blocks the address from re-registering.
+    pub async fn delete_account(&self, username: &str) -> Result<Value> {
+        self.call(
+            Method::Delete,
+            "/admin/accounts",
+            json!({ "username": username }),
+        )
+        .await
+    }
+
+    pub async fn blocklist(&self) -> Result<BlockList> {
+        self.call_as(Method::Get, "/admin/blocklist", json!({}))
+            .await
+    }
+
+    pub async fn block(&self, username: &str, reason: &str) -> Result<Value> {
+        self.call(
+            Method::Post,
+            "/admin/blocklist",
+            json!({ "username": username, "reason": reason }),
+        )
+        .await
+    }
+
+    pub async fn unblock(&self, username: &str) -> Result<Value> {
+        self.call(
+            Method::Delete,
+            "/admin/blocklist",
+            json!({ "username": username }),
+        )
+        .await
+    }
+
+    pub async fn registration_tokens(&self) -> Result<RegistrationTokenList> {
+        self.call_as(Method::Get, "/admin/registration-token", json!({}))
+            .await
+    }
+
+    pub async fn create_registration_token(
+        &self,
+        token: &NewRegistrationToken,
+    ) -> Result<RegistrationToken> {
+        let body = serde_json::to_value(token).map_err(|e| ClientError::Decode(e.to_string()))?;
+        self.call_as(Method::Post, "/admin/registration-token", body)
+            .await
+    }
+
+    pub async fn delete_registration_token(&self, token: &str) -> Result<Value> {
+        self.call(
+            Method::Delete,
+            "/admin/registration-token",
+            json!({ "token": token }),
+        )
+        .await
+    }
+
+    /// `GET /admin/settings` (all settings in one document).
+    pub async fn settings(&self) -> Result<Value> {
+        self.call(Method::Get, "/admin/settings", json!({})).await
+    }
+
+    /// `POST /admin/settings/{name}` with `{"action": "set"}`.
+    pub async fn set_setting(&self, name: &str, value: &str) -> Result<Value> {
+        self.call(
+            Method::Post,
+            &format!("/admin/settings/{name}"),
+            json!({ "action": "set", "value": value }),
+        )
+        .await
+    }
+
+    /// `POST /admin/settings/{name}` with `{"action": "reset"}`.
+    pub async fn reset_setting(&self, name: &str) -> Result<Value> {
+        self.call(
+            Method::Post,
+            &format!("/admin/settings/{name}"),
+            json!({ "action": "reset" }),
+        )
+        .await
+    }
+
+    /// `GET /admin/services/{name}` (`turn`, `iroh`, `webimap`, …).
+    pub async fn service(&self, name: &str) -> Result<ServiceStatus> {
+        self.call_as(Method::Get, &format!("/admin/services/{name}"), json!({}))
+            .await
+    }
+
+    /// `POST /admin/services/{name}` with `enable` / `disable`.
+    pub async fn set_service(&self, name: &str, enabled: bool) -> Result<ServiceStatus> {
+        let action = if enabled { "enable" } else { "disable" };
+        self.call_as(
+            Method::Post,
+            &format!("/admin/services/{name}"),
+            json!({ "action": action }),
+        )
+        .await
+    }
+}
+
+/// Percent-encode everything but RFC 3986 unreserved characters (`@` included).
+fn encode_query_value(value: &str) -> String {
+    let mut out = String::with_capacity(value.len());
+    for b in value.bytes() {
+        if b.is_ascii_alphanumeric() || matches!(b, b'-' | b'.' | b'_' | b'~') {
+            out.push(b as char);
+        } else {
+            out.push_str(&format!("%{b:02X}"));
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use std::time::Duration;
+
+    use wiremock::matchers::{body_partial_json, header, method, path};
+    use wiremock::{Mock, MockServer, ResponseTemplate};
+
+    use super::*;
+
+    fn fast_options() -> ClientOptions {
+        ClientOptions::default().retry(RetryPolicy {
+            max_attempts: 3,
+            initial_backoff: Duration::from_millis(1),
+            max_backoff: Duration::from_millis(1),
+        })
+    }
+
+    #[tokio::test]
+    async fn sends_envelope_and_decodes_body() {
+        let server = MockServer::start().await;
+        Mock::given(method("POST"))
+            .and(path("/api/admin"))
+            .and(body_partial_json(json!({
+                "method": "GET",
+                "resource": "/admin/accounts",
+                "headers": { "Authorization": "Bearer tok" },
+            })))
+            .respond_with(ResponseTemplate::new(200).set_body_json(json!({
+                "status": 200,
+                "resource": "/admin/accounts",
+                "body": { "accounts": [{
+                    "username": "a@x.org", "used_bytes": 10, "max_bytes": 100,
+                    "is_default_quota": true, "created_at": 1
+                }], "total": 1 },
+                "error": null,
+                "version": "2.18.2",
+            })))
+            .expect(1)
+            .mount(&server)
+            .await;
+        let admin = AdminClient::new(
+            &format!("{}/api/admin/", server.uri()),
+            "tok",
+            &fast_options(),
+        )
+        .unwrap();
+        let list = admin.accounts().await.unwrap();
+        assert_eq!(list.total, 1);
+        assert_eq!(list.accounts[0].username, "a@x.org");
+        assert_eq!(list.accounts[0].last_activity_at, 0);
+    }
+
+    #[tokio::test]
+    async fn envelope_error_keeps_status_and_message() {
+        let server = MockServer::start().await;
+        Mock::given(method("POST"))
+            .respond_with(ResponseTemplate::new(200).set_body_json(json!({
+                "status": 401, "error": "unauthorized", "version": "2.18.2",
+            })))
+            .expect(1)
+            .mount(&server)
+            .await;
+        let admin = AdminClient::new(&server.uri(), "wrong", &fast_options()).unwrap();
+        let err = admin.blocklist().await.unwrap_err();
+        assert!(err.is_unauthorized());
+        assert!(err.to_string().contains("unauthorized"), "{err}");
+    }
+
+    #[tokio::test]
+    async fn retries_idempotent_calls_only() {
+        let server = MockServer::start().await;
+        Mock::given(method("POST"))
+            .and(body_partial_json(json!({ "method": "GET" })))
+            .respond_with(ResponseTemplate::new(200).set_body_json(json!({
+                "status": 503, "error": "reloading", "version": "x",
+            })))
+            .expect(3)
+            .mount(&server)
+            .await;
+        Mock::given(method("POST"))
+            .and(body_partial_json(json!({ "method": "POST" })))
+            .respond_with(ResponseTemplate::new(502))
+            .expect(1)
+            .mount(&server)
+            .await;
+        let admin = AdminClient::new(&server.uri(), "tok", &fast_options()).unwrap();
+        assert_eq!(admin.status().await.unwrap_err().status(), Some(503));
+        // Gateway 502 in front of the server is transient too, but POST is not retried.
+        assert_eq!(
+            admin.create_account().await.unwrap_err().status(),
+            Some(502)
+        );
+    }
+
+    #[tokio::test]
+    async fn token_create_serializes_only_set_fields() {
+        let server = MockServer::start().await;
+        Mock::given(method("POST"))
+            .and(header("content-type", "application/json"))
+            .and(body_partial_json(json!({
+                "method": "POST",
+                "resource": "/admin/registration-token",
+                "body": { "max_uses": 5, "expires_in": "7d" },
+            })))
+            .respond_with(ResponseTemplate::new(200).set_body_json(json!({
+                "status": 201,
+                "body": {
+                    "token": "abc", "max_uses": 5, "used_count": 0,
+                    "pending_reservations": 0, "comment": "", "created_at": "now",
+                    "expires_at": "later", "status": "active"
+                },
+                "version": "x",
+            })))
+            .mount(&server)
+            .await;
+        let admin = AdminClient::new(&server.uri(), "tok", &fast_options()).unwrap();
+        let created = admin
+            .create_registration_token(&NewRegistrationToken {
+                max_uses: 5,
+                expires_in: "7d".into(),
+                ..Default::default()
+            })
+            .await
+            .unwrap();
+        assert_eq!(created.token, "abc");
+        assert_eq!(created.expires_at.as_deref(), Some("later"));
+        let sent = &server.received_requests().await.unwrap()[0];
+        let body: Value = serde_json::from_slice(&sent.body).unwrap();
+        assert!(body["body"].get("token").is_none());
+        assert!(body["body"].get("comment").is_none());
+    }
+}
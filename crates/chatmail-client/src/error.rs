@@ -0,0 +1,69 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Client errors. Server-side failures keep the status and message from the response
+//! envelope so callers can branch on them without parsing strings.
+
+use thiserror::Error;
+
+pub type Result<T> = std::result::Result<T, ClientError>;
+
+#[derive(Debug, Error)]
+pub enum ClientError {
+    #[error("invalid endpoint URL: {0}")]
+    InvalidUrl(String),
+    #[error("invalid certificate fingerprint: {0}")]
+    InvalidFingerprint(String),
+    #[error("HTTP client setup: {0}")]
+    Setup(String),
+    #[error("transport: {0}")]
+    Transport(#[from] reqwest::Error),
+    /// The server answered with an error status. For the admin API this is the `status`
+    /// field of the JSON envelope (the HTTP status is always 200); for `/new` it is the
+    /// HTTP status and `{"error": ...}` body.
+    #[error("{}: {message} (status {status})", resource.as_deref().unwrap_or("server"))]
+    Api {
+        status: u16,
+        message: String,
+        resource: Option<String>,
+    },
+    #[error("unexpected response: {0}")]
+    Decode(String),
+}
+
+impl ClientError {
+    /// Server status for [`ClientError::Api`]; `None` for local and transport errors.
+    pub fn status(&self) -> Option<u16> {
+        match self {
+            Self::Api { status, .. } => Some(*status),
+            _ => None,
+        }
+    }
+
+    pub fn is_unauthorized(&self) -> bool {
+        self.status() == Some(401)
+    }
+
+    /// Worth retrying: connection failures, timeouts and gateway / unavailable replies.
+    pub fn is_transient(&self) -> bool {
+        match self {
+            Self::Transport(e) => e.is_connect() || e.is_timeout(),
+            Self::Api { status, .. } => matches!(status, 502..=504),
+            _ => false,
+        }
+    }
+}
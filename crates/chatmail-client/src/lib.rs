@@ -0,0 +1,67 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Typed client for a madmail server: the token-protected admin API ([`AdminClient`]) and
+//! the public registration endpoints ([`RegistrationClient`]).
+//!
+//! Meant for monitoring scripts, dashboards and migration tools that would otherwise
+//! hand-roll the JSON envelope. Both clients take [`ClientOptions`]: TLS mode (WebPKI or a
+//! pinned self-signed certificate), request timeout and a [`RetryPolicy`] applied to
+//! idempotent calls. Calls are plain futures, so dropping one (or wrapping it in
+//! `tokio::time::timeout`) cancels it.
+//!
+//! ```no_run
+//! # async fn demo() -> chatmail_client::Result<()> {
+//! use chatmail_client::{AdminClient, ClientOptions, NewRegistrationToken};
+//!
+//! let admin = AdminClient::new(
+//!     "http://127.0.0.1:8080/api/admin",
+//!     std::fs::read_to_string("/var/lib/maddy/admin_token").unwrap().trim(),
+//!     &ClientOptions::default(),
+//! )?;
+//! let token = admin
+//!     .create_registration_token(&NewRegistrationToken {
+//!         max_uses: 10,
+//!         comment: "meetup".into(),
+//!         ..Default::default()
+//!     })
+//!     .await?;
+//! println!("invite token {}", token.token);
+//! # Ok(())
+//! # }
+//! ```
+//!
+//! Errors reported by the server come back as [`ClientError::Api`] with the status and
+//! message from the response envelope.
+
+pub mod admin;
+pub mod error;
+pub mod registration;
+pub mod retry;
+pub mod tls;
+
+pub use admin::{
+    Account, AccountList, AdminClient, BlockList, BlockedUser, CreatedAccount, Method,
+    NewRegistrationToken, RegistrationToken, RegistrationTokenList, ServiceStatus,
+};
+pub use error::{ClientError, Result};
+pub use registration::{
+    new_idempotency_key, NewAccount, NewAccountRequest, RegistrationClient, ServerInfo,
+    IDEMPOTENCY_HEADER,
+};
+pub use retry::RetryPolicy;
+pub use tls::{ClientOptions, TlsMode};
@@ -0,0 +1,284 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Public registration client: `POST /new` and `GET /status.json`.
+
+use serde::{Deserialize, Serialize};
+
+use crate::error::{ClientError, Result};
+use crate::retry::RetryPolicy;
+use crate::tls::{normalize_base, ClientOptions};
+
+/// Header the server reads for replay protection on `/new`.
+pub const IDEMPOTENCY_HEADER: &str = "Idempotency-Key";
+
+/// Fresh random key for [`NewAccountRequest::idempotency_key`].
+pub fn new_idempotency_key() -> String {
+    uuid::Uuid::new_v4().to_string()
+}
+
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct NewAccountRequest {
+    /// Registration token, required when the server has `registration_token_required`.
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub token: String,
+    /// Sent as the `Idempotency-Key` header. With a key set, a retried request returns the
+    /// account created by the first one, so [`RegistrationClient::create_account`] retries;
+    /// without one it does not.
+    #[serde(skip)]
+    pub idempotency_key: Option<String>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct NewAccount {
+    pub email: String,
+    pub password: String,
+    /// `dclogin:` link for Delta Chat.
+    pub dclogin_url: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct ComponentStatus {
+    pub name: String,
+    pub state: String,
+    pub since: i64,
+    pub checked_at: i64,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct Incident {
+    pub id: i64,
+    pub component: String,
+    pub kind: String,
+    pub message: String,
+    pub created_at: i64,
+}
+
+/// `GET /status.json` — public health summary.
+#[derive(Debug, Clone, Deserialize)]
+pub struct ServerInfo {
+    /// `operational`, `degraded` or `down`.
+    pub status: String,
+    pub started_at: i64,
+    pub uptime_seconds: i64,
+    #[serde(default)]
+    pub components: Vec<ComponentStatus>,
+    #[serde(default)]
+    pub incidents: Vec<Incident>,
+}
+
+#[derive(Deserialize)]
+struct ErrorBody {
+    error: String,
+}
+
+/// Client for the unauthenticated web endpoints.
+///
+/// ```no_run
+/// # async fn demo() -> chatmail_client::Result<()> {
+/// use chatmail_client::{new_idempotency_key, ClientOptions, NewAccountRequest, RegistrationClient};
+///
+/// let client = RegistrationClient::new("https://mail.example.org", &ClientOptions::default())?;
+/// let account = client
+///     .create_account(&NewAccountRequest {
+///         token: "invite-2026".into(),
+///         idempotency_key: Some(new_idempotency_key()),
+///     })
+///     .await?;
+/// println!("{} -> {}", account.email, account.dclogin_url);
+/// # Ok(())
+/// # }
+/// ```
+#[derive(Debug, Clone)]
+pub struct RegistrationClient {
+    http: reqwest::Client,
+    base: String,
+    retry: RetryPolicy,
+}
+
+impl RegistrationClient {
+    /// `base` is the web root, e.g. `https://mail.example.org`.
+    pub fn new(base: &str, options: &ClientOptions) -> Result<Self> {
+        Ok(Self {
+            http: options.build_http()?,
+            base: normalize_base(base)?,
+            retry: options.retry,
+        })
+    }
+
+    pub async fn create_account(&self, req: &NewAccountRequest) -> Result<NewAccount> {
+        let idempotent = req.idempotency_key.is_some();
+        self.retry
+            .run(idempotent, || async move {
+                let mut builder = self.http.post(format!("{}/new", self.base)).json(req);
+                if let Some(key) = &req.idempotency_key {
+                    builder = builder.header(IDEMPOTENCY_HEADER, key);
+                }
+                decode(builder.send().await?, "/new").await
+            })
+            .await
+    }
+
+    pub async fn server_info(&self) -> Result<ServerInfo> {
+        self.retry
+            .run(true, || async move {
+                let resp = self
+                    .http
+                    .get(format!("{}/status.json", self.base))
+                    .send()
+                    .await?;
+                decode(resp, "/status.json").await
+            })
+            .await
+    }
+}
+
+async fn decode<T: serde::de::DeserializeOwned>(resp: reqwest::Response, path: &str) -> Result<T> {
+    let status = resp.status();
+    let bytes = resp.bytes().await?;
+    if !status.is_success() {
+        let message = serde_json::from_slice::<ErrorBody>(&bytes)
+            .map(|b| b.error)
+            .unwrap_or_else(|_| String::from_utf8_lossy(&bytes).trim().to_string());
+        return Err(ClientError::Api {
+            status: status.as_u16(),
+            message,
+            resource: Some(path.to_string()),
+        });
+    }
+    serde_json::from_slice(&bytes).map_err(|e| ClientError::Decode(format!("{path}: {e}")))
+}
+
+#[cfg(test)]
+mod tests {
+    use std::time::Duration;
+
+    use serde_json::json;
+    use wiremock::matchers::{body_json, header, header_exists, method, path};
+    use wiremock::{Mock, MockServer, ResponseTemplate};
+
+    use super::*;
+
+    fn fast_options() -> ClientOptions {
+        ClientOptions::default().retry(RetryPolicy {
+            max_attempts: 3,
+            initial_backoff: Duration::from_millis(1),
+            max_backoff: Duration::from_millis(1),
+        })
+    }
+
+    #[tokio::test]
+    async fn create_account_sends_token_and_key() {
+        let server = MockServer::start().await;
+        Mock::given(method("POST"))
+            .and(path("/new"))
+            .and(header(IDEMPOTENCY_HEADER, "key-0123456789"))
+            .and(body_json(json!({ "token": "inv" })))
+            .respond_with(ResponseTemplate::new(200).set_body_json(json!({
+                "email": "abc@x.org", "password": "pw", "dclogin_url": "dclogin:abc@x.org",
+            })))
+            .expect(1)
+            .mount(&server)
+            .await;
+        let client = RegistrationClient::new(&server.uri(), &fast_options()).unwrap();
+        let account = client
+            .create_account(&NewAccountRequest {
+                token: "inv".into(),
+                idempotency_key: Some("key-0123456789".into()),
+            })
+            .await
+            .unwrap();
+        assert_eq!(account.email, "abc@x.org");
+    }
+
+    #[tokio::test]
+    async fn create_account_retries_only_with_key() {
+        let server = MockServer::start().await;
+        Mock::given(method("POST"))
+            .and(header_exists(IDEMPOTENCY_HEADER))
+            .respond_with(
+                ResponseTemplate::new(503).set_body_json(json!({ "error": "Server is full" })),
+            )
+            .expect(3)
+            .mount(&server)
+            .await;
+        let client = RegistrationClient::new(&server.uri(), &fast_options()).unwrap();
+        let err = client
+            .create_account(&NewAccountRequest {
+                idempotency_key: Some(new_idempotency_key()),
+                ..Default::default()
+            })
+            .await
+            .unwrap_err();
+        assert_eq!(err.status(), Some(503));
+        assert!(err.to_string().contains("Server is full"), "{err}");
+        server.verify().await;
+        server.reset().await;
+
+        Mock::given(method("POST"))
+            .respond_with(
+                ResponseTemplate::new(503).set_body_json(json!({ "error": "Server is full" })),
+            )
+            .expect(1)
+            .mount(&server)
+            .await;
+        assert!(client
+            .create_account(&NewAccountRequest::default())
+            .await
+            .is_err());
+    }
+
+    #[tokio::test]
+    async fn forbidden_is_not_retried() {
+        let server = MockServer::start().await;
+        Mock::given(method("POST"))
+            .respond_with(
+                ResponseTemplate::new(403)
+                    .set_body_json(json!({ "error": "Registration is closed" })),
+            )
+            .expect(1)
+            .mount(&server)
+            .await;
+        let client = RegistrationClient::new(&server.uri(), &fast_options()).unwrap();
+        let err = client
+            .create_account(&NewAccountRequest {
+                idempotency_key: Some(new_idempotency_key()),
+                ..Default::default()
+            })
+            .await
+            .unwrap_err();
+        assert_eq!(err.status(), Some(403));
+    }
+
+    #[tokio::test]
+    async fn server_info_decodes_status_json() {
+        let server = MockServer::start().await;
+        Mock::given(method("GET"))
+            .and(path("/status.json"))
+            .respond_with(ResponseTemplate::new(200).set_body_json(json!({
+                "status": "operational", "started_at": 100, "uptime_seconds": 5,
+                "components": [{ "name": "smtp", "state": "up", "since": 100, "checked_at": 105 }],
+                "incidents": [],
+            })))
+            .mount(&server)
+            .await;
+        let client = RegistrationClient::new(&server.uri(), &fast_options()).unwrap();
+        let info = client.server_info().await.unwrap();
+        assert_eq!(info.status, "operational");
+        assert_eq!(info.components[0].name, "smtp");
+    }
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Exponential backoff for idempotent calls.
+
+use std::future::Future;
+use std::time::Duration;
+
+use crate::error::Result;
+
+/// Retry schedule. Only idempotent requests are retried, and only on
+/// [`ClientError::is_transient`](crate::ClientError::is_transient) failures.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct RetryPolicy {
+    /// Total tries including the first; `1` disables retries.
+    pub max_attempts: u32,
+    pub initial_backoff: Duration,
+    pub max_backoff: Duration,
+}
+
+impl Default for RetryPolicy {
+    fn default() -> Self {
+        Self {
+            max_attempts: 3,
+            initial_backoff: Duration::from_millis(250),
+            max_backoff: Duration::from_secs(5),
+        }
+    }
+}
+
+impl RetryPolicy {
+    pub fn none() -> Self {
+        Self {
+            max_attempts: 1,
+            ..Self::default()
+        }
+    }
+
+    /// Delay before try number `attempt + 1` (`attempt` starts at 1).
+    pub fn backoff(&self, attempt: u32) -> Duration {
+        let factor = 1u32
+            .checked_shl(attempt.saturating_sub(1))
+            .unwrap_or(u32::MAX);
+        self.initial_backoff
+            .saturating_mul(factor)
+            .min(self.max_backoff)
+    }
+
+    pub(crate) async fn run<T, F, Fut>(&self, idempotent: bool, mut op: F) -> Result<T>
+    where
+        F: FnMut() -> Fut,
+        Fut: Future<Output = Result<T>>,
+    {
+        let attempts = if idempotent {
+            self.max_attempts.max(1)
+        } else {
+            1
+        };
+        let mut attempt = 1;
+        loop {
+            match op().await {
+                Err(e) if attempt < attempts && e.is_transient() => {
+                    tokio::time::sleep(self.backoff(attempt)).await;
+                    attempt += 1;
+                }
+                other => return other,
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::atomic::{AtomicU32, Ordering};
+
+    use super::*;
+    use crate::ClientError;
+
+    fn unavailable() -> ClientError {
+        ClientError::Api {
+            status: 503,
+            message: "busy".into(),
+            resource: None,
+        }
+    }
+
+    #[test]
+    fn backoff_doubles_up_to_cap() {
+        let p = RetryPolicy {
+            max_attempts: 10,
+            initial_backoff: Duration::from_millis(100),
+            max_backoff: Duration::from_millis(350),
+        };
+        assert_eq!(p.backoff(1), Duration::from_millis(100));
+        assert_eq!(p.backoff(2), Duration::from_millis(200));
+        assert_eq!(p.backoff(3), Duration::from_millis(350));
+        assert_eq!(p.backoff(40), Duration::from_millis(350));
+    }
+
+    #[tokio::test]
+    async fn retries_transient_only_when_idempotent() {
+        let p = RetryPolicy {
+            max_attempts: 3,
+            initial_backoff: Duration::from_millis(1),
+            max_backoff: Duration::from_millis(1),
+        };
+        let counter = AtomicU32::new(0);
+        let calls = &counter;
+        let r: Result<()> = p
+            .run(true, || async move {
+                calls.fetch_add(1, Ordering::SeqCst);
+                Err(unavailable())
+            })
+            .await;
+        assert_eq!(r.unwrap_err().status(), Some(503));
+        assert_eq!(calls.load(Ordering::SeqCst), 3);
+
+        calls.store(0, Ordering::SeqCst);
+        let _ = p
+            .run(false, || async move {
+                calls.fetch_add(1, Ordering::SeqCst);
+                Err::<(), _>(unavailable())
+            })
+            .await;
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+
+        calls.store(0, Ordering::SeqCst);
+        let _ = p
+            .run(true, || async move {
+                calls.fetch_add(1, Ordering::SeqCst);
+                Err::<(), _>(ClientError::Api {
+                    status: 400,
+                    message: "bad".into(),
+                    resource: None,
+                })
+            })
+            .await;
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+    }
+}
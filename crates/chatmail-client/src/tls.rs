@@ -0,0 +1,253 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Transport options shared by [`AdminClient`](crate::AdminClient) and
+//! [`RegistrationClient`](crate::RegistrationClient).
+//!
+//! Self-hosted servers often run on a self-signed certificate (`tls_mode self_signed`).
+//! [`TlsMode::Pinned`] accepts exactly one leaf certificate by SHA-256 fingerprint instead
+//! of turning verification off: the hostname and CA chain are ignored, the handshake
+//! signature is still checked.
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use rustls::client::danger::{HandshakeSignatureValid, ServerCertVerified, ServerCertVerifier};
+use rustls::crypto::{verify_tls12_signature, verify_tls13_signature, WebPkiSupportedAlgorithms};
+use rustls::pki_types::{CertificateDer, ServerName, UnixTime};
+use rustls::{DigitallySignedStruct, Error as RustlsError, SignatureScheme};
+use sha2::{Digest, Sha256};
+
+use crate::error::{ClientError, Result};
+use crate::retry::RetryPolicy;
+
+#[derive(Debug, Clone, PartialEq, Eq, Default)]
+pub enum TlsMode {
+    /// WebPKI roots and hostname verification.
+    #[default]
+    WebPki,
+    /// Trust only the leaf certificate with this SHA-256 fingerprint.
+    Pinned([u8; 32]),
+}
+
+impl TlsMode {
+    /// Pin from a hex fingerprint as printed by
+    /// `openssl x509 -noout -fingerprint -sha256`: colons optional, case ignored,
+    /// an optional `sha256:` / `SHA256 Fingerprint=` prefix is dropped.
+    pub fn pinned(fingerprint: &str) -> Result<Self> {
+        parse_fingerprint(fingerprint).map(Self::Pinned)
+    }
+}
+
+fn parse_fingerprint(raw: &str) -> Result<[u8; 32]> {
+    let trimmed = raw.trim();
+    let value = trimmed
+        .rsplit_once('=')
+        .map(|(_, v)| v)
+        .or_else(|| {
+            trimmed
+                .get(..7)
+                .filter(|p| p.eq_ignore_ascii_case("sha256:"))
+                .map(|_| &trimmed[7..])
+        })
+        .unwrap_or(trimmed);
+    let hex_digits: String = value
+        .chars()
+        .filter(|c| *c != ':' && !c.is_whitespace())
+        .collect();
+    let mut out = [0u8; 32];
+    hex::decode_to_slice(&hex_digits, &mut out)
+        .map_err(|_| ClientError::InvalidFingerprint("expected 64 hex digits (SHA-256)".into()))?;
+    Ok(out)
+}
+
+/// HTTP settings for either client.
+#[derive(Debug, Clone)]
+pub struct ClientOptions {
+    pub tls: TlsMode,
+    /// Whole-request timeout. Dropping the returned future also cancels a call.
+    pub timeout: Duration,
+    pub retry: RetryPolicy,
+    pub user_agent: String,
+}
+
+impl Default for ClientOptions {
+    fn default() -> Self {
+        Self {
+            tls: TlsMode::WebPki,
+            timeout: Duration::from_secs(30),
+            retry: RetryPolicy::default(),
+            user_agent: format!("madmail-client/{}", env!("CARGO_PKG_VERSION")),
+        }
+    }
+}
+
+impl ClientOptions {
+    pub fn tls(mut self, tls: TlsMode) -> Self {
+        self.tls = tls;
+        self
+    }
+
+    pub fn timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = timeout;
+        self
+    }
+
+    pub fn retry(mut self, retry: RetryPolicy) -> Self {
+        self.retry = retry;
+        self
+    }
+
+    pub(crate) fn build_http(&self) -> Result<reqwest::Client> {
+        let mut builder = reqwest::Client::builder()
+            .timeout(self.timeout)
+            .user_agent(self.user_agent.clone());
+        if let TlsMode::Pinned(fingerprint) = self.tls {
+            let config = rustls::ClientConfig::builder()
+                .dangerous()
+                .with_custom_certificate_verifier(Arc::new(PinnedCertVerifier::new(fingerprint)))
+                .with_no_client_auth();
+            builder = builder.use_preconfigured_tls(config);
+        }
+        builder
+            .build()
+            .map_err(|e| ClientError::Setup(e.to_string()))
+    }
+}
+
+/// Endpoints must be absolute `http(s)://` URLs; a trailing `/` is dropped.
+pub(crate) fn normalize_base(raw: &str) -> Result<String> {
+    let trimmed = raw.trim().trim_end_matches('/');
+    let rest = trimmed
+        .strip_prefix("https://")
+        .or_else(|| trimmed.strip_prefix("http://"));
+    match rest {
+        Some(host) if !host.is_empty() && !host.starts_with('/') => Ok(trimmed.to_string()),
+        _ => Err(ClientError::InvalidUrl(raw.to_string())),
+    }
+}
+
+#[derive(Debug)]
+struct PinnedCertVerifier {
+    fingerprint: [u8; 32],
+    algorithms: WebPkiSupportedAlgorithms,
+}
+
+impl PinnedCertVerifier {
+    fn new(fingerprint: [u8; 32]) -> Self {
+        Self {
+            fingerprint,
+            algorithms: rustls::crypto::ring::default_provider().signature_verification_algorithms,
+        }
+    }
+}
+
+impl ServerCertVerifier for PinnedCertVerifier {
+    fn verify_server_cert(
+        &self,
+        end_entity: &CertificateDer<'_>,
+        _intermediates: &[CertificateDer<'_>],
+        _server_name: &ServerName<'_>,
+        _ocsp_response: &[u8],
+        _now: UnixTime,
+    ) -> std::result::Result<ServerCertVerified, RustlsError> {
+        let digest: [u8; 32] = Sha256::digest(end_entity.as_ref()).into();
+        if digest == self.fingerprint {
+            Ok(ServerCertVerified::assertion())
+        } else {
+            Err(RustlsError::General(format!(
+                "certificate fingerprint {} does not match the pinned value",
+                hex::encode(digest)
+            )))
+        }
+    }
+
+    fn verify_tls12_signature(
+        &self,
+        message: &[u8],
+        cert: &CertificateDer<'_>,
+        dss: &DigitallySignedStruct,
+    ) -> std::result::Result<HandshakeSignatureValid, RustlsError> {
+        verify_tls12_signature(message, cert, dss, &self.algorithms)
+    }
+
+    fn verify_tls13_signature(
+        &self,
+        message: &[u8],
+        cert: &CertificateDer<'_>,
+        dss: &DigitallySignedStruct,
+    ) -> std::result::Result<HandshakeSignatureValid, RustlsError> {
+        verify_tls13_signature(message, cert, dss, &self.algorithms)
+    }
+
+    fn supported_verify_schemes(&self) -> Vec<SignatureScheme> {
+        self.algorithms.supported_schemes()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const FP: &str = "5E:88:48:98:DA:28:04:71:51:D0:E5:6F:8D:C6:29:27:73:60:3D:0D:6A:AB:BD:D6:2A:11:EF:72:1D:15:42:D8";
+
+    #[test]
+    fn fingerprint_formats() {
+        let want = parse_fingerprint(FP).unwrap();
+        assert_eq!(want[0], 0x5e);
+        assert_eq!(want[31], 0xd8);
+        let bare = FP.replace(':', "").to_ascii_lowercase();
+        assert_eq!(parse_fingerprint(&bare).unwrap(), want);
+        assert_eq!(parse_fingerprint(&format!("sha256:{bare}")).unwrap(), want);
+        assert_eq!(
+            parse_fingerprint(&format!("SHA256 Fingerprint={FP}")).unwrap(),
+            want
+        );
+        assert!(parse_fingerprint("AB:CD").is_err());
+        assert!(parse_fingerprint(&"zz".repeat(32)).is_err());
+    }
+
+    #[test]
+    fn pinned_verifier_matches_only_the_pinned_leaf() {
+        let cert = CertificateDer::from(b"not really DER, only hashed".to_vec());
+        let digest: [u8; 32] = Sha256::digest(cert.as_ref()).into();
+        let name = ServerName::try_from("mail.example.org").unwrap();
+        let ok = PinnedCertVerifier::new(digest);
+        assert!(ok
+            .verify_server_cert(&cert, &[], &name, &[], UnixTime::now())
+            .is_ok());
+        let other = PinnedCertVerifier::new([0u8; 32]);
+        assert!(other
+            .verify_server_cert(&cert, &[], &name, &[], UnixTime::now())
+            .is_err());
+    }
+
+    #[test]
+    fn base_url_validation() {
+        assert_eq!(
+            normalize_base("https://mail.example.org/api/admin/").unwrap(),
+            "https://mail.example.org/api/admin"
+        );
+        assert_eq!(
+            normalize_base("http://127.0.0.1:8080").unwrap(),
+            "http://127.0.0.1:8080"
+        );
+        assert!(normalize_base("mail.example.org").is_err());
+        assert!(normalize_base("https://").is_err());
+        assert!(normalize_base("ftp://x").is_err());
+    }
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Delta Chat app links for the contact landing page (`chatmail { app_*_url ... }`).
+//!
+//! Defaults point at upstream Delta Chat; operators shipping a fork override them.
+//! A directive value of `off` hides that link.
+
+/// Store / deep-link targets rendered by `contact_view.html`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct AppLinksConfig {
+    /// `app_invite_base_url` — universal-link base for invites (`{base}#FPR&a=…`).
+    pub invite_base_url: String,
+    /// `app_android_package` — restrict the Android intent to one package (empty = any handler).
+    pub android_package: String,
+    /// `app_fdroid_url`
+    pub fdroid_url: String,
+    /// `app_play_url` — also the Android intent fallback when set.
+    pub play_url: String,
+    /// `app_store_url` — iOS App Store.
+    pub app_store_url: String,
+    /// `app_flathub_url` — Linux desktop.
+    pub flathub_url: String,
+    /// `app_desktop_url` — generic desktop download page.
+    pub desktop_url: String,
+}
+
+pub const DEFAULT_APP_INVITE_BASE_URL: &str = "https://i.delta.chat/";
+pub const DEFAULT_APP_FDROID_URL: &str = "https://f-droid.org/packages/com.b44t.messenger/";
+pub const DEFAULT_APP_PLAY_URL: &str = "https://play.google.com/store/apps/details?id=chat.delta";
+pub const DEFAULT_APP_STORE_URL: &str = "https://apps.apple.com/app/delta-chat/id1459523234";
+pub const DEFAULT_APP_FLATHUB_URL: &str = "https://flathub.org/apps/chat.delta.desktop";
+pub const DEFAULT_APP_DESKTOP_URL: &str = "https://delta.chat/download";
+
+impl Default for AppLinksConfig {
+    fn default() -> Self {
+        Self {
+            invite_base_url: DEFAULT_APP_INVITE_BASE_URL.into(),
+            android_package: String::new(),
+            fdroid_url: DEFAULT_APP_FDROID_URL.into(),
+            play_url: DEFAULT_APP_PLAY_URL.into(),
+            app_store_url: DEFAULT_APP_STORE_URL.into(),
+            flathub_url: DEFAULT_APP_FLATHUB_URL.into(),
+            desktop_url: DEFAULT_APP_DESKTOP_URL.into(),
+        }
+    }
+}
+
+impl AppLinksConfig {
+    /// Apply one `app_*` directive; returns false for unknown names.
+    pub fn apply_directive(&mut self, name: &str, value: &str) -> bool {
+        let value = match value.trim() {
+            v if v.eq_ignore_ascii_case("off") => String::new(),
+            v => v.to_string(),
+        };
+        let slot = match name {
+            "app_invite_base_url" => &mut self.invite_base_url,
+            "app_android_package" => &mut self.android_package,
+            "app_fdroid_url" => &mut self.fdroid_url,
+            "app_play_url" => &mut self.play_url,
+            "app_store_url" => &mut self.app_store_url,
+            "app_flathub_url" => &mut self.flathub_url,
+            "app_desktop_url" => &mut self.desktop_url,
+            _ => return false,
+        };
+        *slot = value;
+        true
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn directives_override_and_disable() {
+        let mut links = AppLinksConfig::default();
+        assert!(links.apply_directive("app_play_url", "https://example.org/fork.apk"));
+        assert!(links.apply_directive("app_flathub_url", "off"));
+        assert!(!links.apply_directive("app_unknown", "x"));
+        assert_eq!(links.play_url, "https://example.org/fork.apk");
+        assert!(links.flathub_url.is_empty());
+        assert_eq!(links.app_store_url, DEFAULT_APP_STORE_URL);
+    }
+}
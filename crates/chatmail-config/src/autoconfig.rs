@@ -1,4 +1,5 @@
-//! Mozilla ISPDB autoconfig (`/.well-known/autoconfig/mail/config-v1.1.xml`).
+//! Mozilla ISPDB autoconfig (`/.well-known/autoconfig/mail/config-v1.1.xml`,
+//! `/mail/config-v1.1.xml`) and Outlook AutoDiscover (`/autodiscover/autodiscover.xml`).
 
 use crate::{port_from_listen, DcloginMailSettings, RuntimeListeners};
 
@@ -152,6 +153,130 @@ pub fn build_autoconfig_xml(params: &AutoconfigParams) -> String {
     )
 }
 
+/// Autoconfig answer for an address on a domain this server does not serve. Thunderbird
+/// has no error schema; a `clientConfig` without `emailProvider` makes it move on.
+pub fn build_autoconfig_error_xml(message: &str) -> String {
+    let message = xml_escape(message);
+    format!(
+        r#"<?xml version="1.0" encoding="UTF-8"?>
+<clientConfig version="1.1">
+  <error>{message}</error>
+</clientConfig>
+"#
+    )
+}
+
+const AUTODISCOVER_RESPONSE_NS: &str =
+    "http://schemas.microsoft.com/exchange/autodiscover/responseschema/2006";
+const AUTODISCOVER_OUTLOOK_NS: &str =
+    "http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a";
+
+fn autodiscover_protocol(
+    kind: &str,
+    host: &str,
+    port: &str,
+    encryption: &str,
+    login: &str,
+) -> String {
+    format!(
+        r#"      <Protocol>
+        <Type>{kind}</Type>
+        <Server>{host}</Server>
+        <Port>{port}</Port>
+        <LoginName>{login}</LoginName>
+        <DomainRequired>off</DomainRequired>
+        <SPA>off</SPA>
+        <SSL>on</SSL>
+        <Encryption>{encryption}</Encryption>
+        <AuthRequired>on</AuthRequired>
+      </Protocol>
+"#
+    )
+}
+
+/// Build an Outlook AutoDiscover answer (POX "outlook" response schema) for `email`.
+///
+/// One IMAP and one SMTP entry: implicit TLS (`SSL`) when that listener is bound, otherwise
+/// STARTTLS (`TLS`). There is no Exchange server, so the `EWS` part is a stub comment and
+/// Outlook falls back to the IMAP/SMTP protocols.
+pub fn build_autodiscover_xml(params: &AutoconfigParams, email: &str) -> String {
+    let host = xml_escape(&params.client_host);
+    let login = xml_escape(email);
+    let display = xml_escape(strip_brackets(&params.mail_domain));
+
+    let mut protocols = String::new();
+    if params.has_imap_tls {
+        protocols.push_str(&autodiscover_protocol(
+            "IMAP",
+            &host,
+            &params.imap_port_tls,
+            "SSL",
+            &login,
+        ));
+    } else if params.has_imap_plain {
+        protocols.push_str(&autodiscover_protocol(
+            "IMAP",
+            &host,
+            &params.imap_port_starttls,
+            "TLS",
+            &login,
+        ));
+    }
+    if params.has_submission_tls {
+        protocols.push_str(&autodiscover_protocol(
+            "SMTP",
+            &host,
+            &params.smtp_port_tls,
+            "SSL",
+            &login,
+        ));
+    } else if params.has_submission_plain {
+        protocols.push_str(&autodiscover_protocol(
+            "SMTP",
+            &host,
+            &params.smtp_port_starttls,
+            "TLS",
+            &login,
+        ));
+    }
+
+    format!(
+        r#"<?xml version="1.0" encoding="utf-8"?>
+<Autodiscover xmlns="{AUTODISCOVER_RESPONSE_NS}">
+  <Response xmlns="{AUTODISCOVER_OUTLOOK_NS}">
+    <User>
+      <DisplayName>{display} chatmail</DisplayName>
+    </User>
+    <Account>
+      <AccountType>email</AccountType>
+      <Action>settings</Action>
+      <!-- EWS: not offered, there is no Exchange server behind this domain -->
+{protocols}    </Account>
+  </Response>
+</Autodiscover>
+"#
+    )
+}
+
+/// AutoDiscover error document (`ErrorCode` 600, "Invalid Request"). Outlook expects this
+/// with status 200 rather than a 404 for addresses the server does not handle.
+pub fn build_autodiscover_error_xml(message: &str) -> String {
+    let message = xml_escape(message);
+    format!(
+        r#"<?xml version="1.0" encoding="utf-8"?>
+<Autodiscover xmlns="{AUTODISCOVER_RESPONSE_NS}">
+  <Response>
+    <Error Time="00:00:00" Id="0">
+      <ErrorCode>600</ErrorCode>
+      <Message>Invalid Request</Message>
+      <DebugData>{message}</DebugData>
+    </Error>
+  </Response>
+</Autodiscover>
+"#
+    )
+}
+
 fn strip_brackets(s: &str) -> &str {
     s.trim_matches(['[', ']'])
 }
@@ -218,6 +343,74 @@ mod tests {
         assert!(xml.contains("<hostname>192.0.2.1</hostname>"));
     }
 
+    fn tls_params(imap_tls: bool, submission_tls: bool) -> AutoconfigParams {
+        AutoconfigParams {
+            mail_domain: "example.org".into(),
+            client_host: "mail.example.org".into(),
+            imap_port_tls: "993".into(),
+            imap_port_starttls: "143".into(),
+            smtp_port_tls: "465".into(),
+            smtp_port_starttls: "587".into(),
+            has_imap_tls: imap_tls,
+            has_imap_plain: true,
+            has_submission_tls: submission_tls,
+            has_submission_plain: true,
+            has_imap_alpn_https: false,
+            https_port: None,
+        }
+    }
+
+    /// `(Type, Port, Encryption)` of every AutoDiscover `<Protocol>`, in order.
+    fn protocols(xml: &str) -> Vec<(String, String, String)> {
+        let text = |block: &str, tag: &str| {
+            let open = format!("<{tag}>");
+            let start = block.find(&open).map(|i| i + open.len()).unwrap();
+            let end = block[start..].find(&format!("</{tag}>")).unwrap();
+            block[start..start + end].to_string()
+        };
+        xml.split("<Protocol>")
+            .skip(1)
+            .map(|block| {
+                let block = block.split("</Protocol>").next().unwrap();
+                (
+                    text(block, "Type"),
+                    text(block, "Port"),
+                    text(block, "Encryption"),
+                )
+            })
+            .collect()
+    }
+
+    #[test]
+    fn autodiscover_prefers_implicit_tls_ports() {
+        let xml = build_autodiscover_xml(&tls_params(true, true), "alice@example.org");
+        assert!(xml.starts_with("<?xml"));
+        assert!(xml.contains("<LoginName>alice@example.org</LoginName>"));
+        assert!(xml.contains("<Server>mail.example.org</Server>"));
+        assert!(xml.contains("EWS"));
+        let tuple = |t: &str, p: &str, e: &str| (t.to_string(), p.to_string(), e.to_string());
+        assert_eq!(
+            protocols(&xml),
+            [tuple("IMAP", "993", "SSL"), tuple("SMTP", "465", "SSL")]
+        );
+
+        let xml = build_autodiscover_xml(&tls_params(false, false), "alice@example.org");
+        assert_eq!(
+            protocols(&xml),
+            [tuple("IMAP", "143", "TLS"), tuple("SMTP", "587", "TLS")]
+        );
+    }
+
+    #[test]
+    fn error_documents_escape_the_message() {
+        let xml = build_autodiscover_error_xml("domain <x> not served");
+        assert!(xml.contains("<ErrorCode>600</ErrorCode>"));
+        assert!(xml.contains("<DebugData>domain &lt;x&gt; not served</DebugData>"));
+        let xml = build_autoconfig_error_xml("a & b");
+        assert!(xml.contains("<error>a &amp; b</error>"));
+        assert!(!xml.contains("emailProvider"));
+    }
+
     #[test]
     fn autoconfig_omits_https_alpn_even_when_http_tls_bound() {
         let mail = DcloginMailSettings {
@@ -43,6 +43,18 @@ pub struct Cli {
 pub enum Command {
     /// Start the mail server (default).
     Run,
+    /// Throwaway local instance on loopback high ports (no root, systemd or real TLS).
+    Dev {
+        /// Listen on this base plus each standard port (10025, 10587, 10143, 10080, …).
+        #[arg(long, default_value_t = 10000)]
+        port_base: u16,
+        /// Use DIR as state directory instead of a fresh temp directory (never removed).
+        #[arg(long, value_name = "DIR")]
+        state: Option<PathBuf>,
+        /// Keep the temp state directory on exit.
+        #[arg(long)]
+        keep: bool,
+    },
     /// Replace this executable from a signed local file or URL.
     Upgrade {
         /// Path to signed binary, or `http://` / `https://` URL to a raw binary or `.tar.gz` / `.tgz` archive.
@@ -55,6 +67,12 @@ pub enum Command {
         /// failure an interactive TTY may prompt `[y/N]`.
         #[arg(long = "accept-unsafe-https")]
         accept_unsafe_https: bool,
+        /// Keep the replaced executable as `<binary>.bak` and put it back if a later step fails.
+        #[arg(long)]
+        backup: bool,
+        /// Verify the new binary, then print the planned steps without changing anything.
+        #[arg(long)]
+        dry_run: bool,
     },
     /// Replace this executable from a signed local file or URL (alias for `upgrade`).
     Update {
@@ -68,6 +86,12 @@ pub enum Command {
         /// failure an interactive TTY may prompt `[y/N]`.
         #[arg(long = "accept-unsafe-https")]
         accept_unsafe_https: bool,
+        /// Keep the replaced executable as `<binary>.bak` and put it back if a later step fails.
+        #[arg(long)]
+        backup: bool,
+        /// Verify the new binary, then print the planned steps without changing anything.
+        #[arg(long)]
+        dry_run: bool,
     },
     /// Display the admin API credentials.
     #[command(name = "admin-token")]
@@ -79,6 +103,13 @@ pub enum Command {
         #[arg(long)]
         no_qr: bool,
     },
+    /// Show the admin audit trail (admin API mutations and CLI settings changes, newest
+    /// first); same as `audit list`.
+    #[command(name = "admin-audit")]
+    AdminAudit(AuditListArgs),
+    /// Admin audit trail (`audit list`).
+    #[command(subcommand)]
+    Audit(AuditCommand),
     /// Serve the embedded admin-web SPA.
     #[command(name = "admin-web")]
     AdminWeb {
@@ -93,6 +124,9 @@ pub enum Command {
     /// List blocklisted usernames (`accounts ban-list`).
     #[command(name = "ban-list")]
     BanList,
+    /// Ban IP addresses / networks at the SMTP, IMAP and HTTP listeners.
+    #[command(subcommand)]
+    Ban(BanCommand),
     /// Manage blocked users (prevent re-registration).
     #[command(subcommand)]
     Blocklist(BlocklistCommand),
@@ -143,6 +177,9 @@ pub enum Command {
         #[arg(long, short = 'y')]
         yes: bool,
     },
+    /// Validate, install or roll back a custom www theme (`{state_dir}/themes`).
+    #[command(subcommand)]
+    Theme(ThemeCommand),
     /// IMAP mailboxes (folders) management.
     #[command(name = "imap-mboxes")]
     ImapMboxes,
@@ -150,8 +187,8 @@ pub enum Command {
     #[command(name = "imap-msgs")]
     ImapMsgs,
     /// IMAP storage accounts management.
-    #[command(name = "imap-acct")]
-    ImapAcct,
+    #[command(name = "imap-acct", subcommand)]
+    ImapAcct(ImapAcctCommand),
     /// Install and configure the mail server.
     Install(Box<InstallArgs>),
     /// TLS certificates (Let's Encrypt / file / self-signed).
@@ -177,6 +214,9 @@ pub enum Command {
         #[arg(long, short = 'd')]
         details: bool,
     },
+    /// Unified policy lists (federation, PGP passthrough, reserved names/slugs).
+    #[command(subcommand)]
+    Policy(PolicyCommand),
     /// Manage admin-panel ports.
     #[command(subcommand)]
     Port(PortCommand),
@@ -188,9 +228,74 @@ pub enum Command {
     },
     /// Delivery queue management.
     Queue,
+    /// Per-destination-domain caps of the running outbound queue (via the admin API).
+    #[command(name = "delivery-stats")]
+    DeliveryStats {
+        /// Override admin API base URL (default: from config + settings DB).
+        #[arg(long)]
+        url: Option<String>,
+        /// Skip TLS certificate verification (self-signed dev servers).
+        #[arg(long)]
+        insecure: bool,
+    },
+    /// Shadowsocks relay traffic of the running server (via the admin API).
+    #[command(name = "ss-stats")]
+    SsStats {
+        /// Override admin API base URL (default: from config + settings DB).
+        #[arg(long)]
+        url: Option<String>,
+        /// Skip TLS certificate verification (self-signed dev servers).
+        #[arg(long)]
+        insecure: bool,
+    },
+    /// Mail storage maintenance (copy accounts to another mail root).
+    #[command(subcommand)]
+    Storage(StorageCommand),
+    /// Export or import accounts with their mail, for moving to another server.
+    #[command(subcommand)]
+    Backup(BackupCommand),
+    /// Consistent copies of the live SQLite databases.
+    #[command(subcommand)]
+    Db(DbCommand),
     /// Scheduled maintenance jobs (retention, unused accounts, purge).
     #[command(subcommand)]
     Tasks(TasksCommand),
+    /// Compare the live DNS records (A, MX, SPF, DMARC, MTA-STS, DKIM) with the config.
+    #[command(name = "dns-check")]
+    DnsCheck {
+        /// Mail domain to check (default: `primary_domain` from the config).
+        #[arg(long)]
+        domain: Option<String>,
+        /// Create missing records through the Cloudflare API with this API token.
+        #[arg(
+            long,
+            value_name = "API_TOKEN",
+            env = "CLOUDFLARE_API_TOKEN",
+            hide_env_values = true,
+            requires = "zone_id"
+        )]
+        fix_cloudflare: Option<String>,
+        /// Cloudflare zone ID of the mail domain (with `--fix-cloudflare`).
+        #[arg(long, requires = "fix_cloudflare")]
+        zone_id: Option<String>,
+    },
+    /// Self-test: config, listeners, TLS, database, DNS, SMTP and IMAP.
+    Diagnose {
+        /// Also test IMAP `LOGIN` as this account.
+        #[arg(long, requires = "imap_password")]
+        imap_user: Option<String>,
+        /// Password for `--imap-user`.
+        #[arg(
+            long,
+            env = "CHATMAIL_DIAGNOSE_PASSWORD",
+            hide_env_values = true,
+            requires = "imap_user"
+        )]
+        imap_password: Option<String>,
+    },
+    /// Export or import the settings DB (`__KEY__` rows) as JSON.
+    #[command(subcommand)]
+    Settings(SettingsCommand),
     /// Manage registration tokens.
     #[command(
         name = "registration-tokens",
@@ -207,6 +312,27 @@ pub enum Command {
         #[arg(long)]
         insecure: bool,
     },
+    /// Automatic replies for local addresses.
+    #[command(subcommand)]
+    Responder(ResponderCommand),
+    /// An account's own automatic reply (vacation notice).
+    #[command(subcommand)]
+    Autoresponse(AutoresponseCommand),
+    /// Per-domain settings of the local domains (catch-all mailboxes).
+    #[command(subcommand)]
+    Domains(DomainsCommand),
+    /// Federation peers whose `/federation/info` informs delivery.
+    #[command(subcommand)]
+    Peers(PeersCommand),
+    /// DKIM signing keys (`{state_dir}/dkim_keys`): list, rotate, activate.
+    #[command(subcommand)]
+    Dkim(DkimCommand),
+    /// Account lifecycle webhooks (`webhook_url`).
+    #[command(subcommand)]
+    Webhook(WebhookCommand),
+    /// Deliver an announcement to every (or every matching) local account's INBOX.
+    #[command(subcommand)]
+    Broadcast(BroadcastCommand),
     /// DeltaChat contact sharing management.
     #[command(subcommand)]
     Sharing(SharingCommand),
@@ -226,7 +352,8 @@ pub enum Command {
     #[command(subcommand)]
     Firewall(FirewallCommand),
     /// Local credentials management.
-    Creds,
+    #[command(subcommand)]
+    Creds(CredsCommand),
     /// Enable, disable, or inspect WebIMAP HTTP API.
     #[command(subcommand)]
     Webimap(ServiceToggleCommand),
@@ -295,6 +422,18 @@ pub enum ProxyCommand {
         #[command(subcommand)]
         cmd: Option<ProxySettingCommand>,
     },
+    /// New primary password; the current one stays accepted for `--grace`.
+    Rotate {
+        /// New password (default: generated for the cipher).
+        #[arg(long)]
+        password: Option<String>,
+        /// How long the previous password keeps working.
+        #[arg(long, default_value = "10m", conflicts_with = "keep_old")]
+        grace: String,
+        /// Keep the previous password with no expiry (until `proxy password reset`).
+        #[arg(long)]
+        keep_old: bool,
+    },
 }
 
 /// `madmail proxy cipher` / `proxy password` — DB override set/reset.
@@ -394,6 +533,28 @@ pub enum LanguageCommand {
     Reset,
 }
 
+/// `chatmail settings` — settings DB dump / restore.
+#[derive(Debug, Subcommand, Clone)]
+pub enum SettingsCommand {
+    /// Print all settings as canonical JSON (secrets redacted by default).
+    Export {
+        /// Include TURN / Shadowsocks / HTTP proxy secrets in the dump.
+        #[arg(long)]
+        include_secrets: bool,
+        /// Write to FILE instead of stdout.
+        #[arg(long, short = 'o', value_name = "FILE")]
+        output: Option<PathBuf>,
+    },
+    /// Validate and apply a settings export (all or nothing).
+    Import {
+        #[arg(value_name = "FILE")]
+        file: PathBuf,
+        /// Validate and report per-key results without writing.
+        #[arg(long)]
+        dry_run: bool,
+    },
+}
+
 /// `chatmail tasks` — maintenance jobs (Madmail `imapsql` cleanup + queue purge).
 #[derive(Debug, Subcommand, Clone)]
 pub enum TasksCommand {
@@ -566,11 +727,145 @@ pub enum RegistrationTokensCommand {
     },
 }
 
+/// `chatmail policy` — entries in `policy_entries`.
+///
+/// TYPE is one of `federation`, `pgp-passthrough`, `reserved-name`, `sharing-reserved`,
+/// `subaddress-folder`.
+#[derive(Debug, Subcommand, Clone)]
+pub enum PolicyCommand {
+    /// Add or update an entry (`TYPE PATTERN`).
+    Add {
+        #[arg(value_name = "TYPE")]
+        policy_type: String,
+        #[arg(value_name = "PATTERN")]
+        pattern: String,
+        /// Entry action (federation: `deny` or `allow`; default per type).
+        #[arg(long)]
+        action: Option<String>,
+        /// Free-form operator note.
+        #[arg(long, default_value = "")]
+        note: String,
+        /// Stop matching after this long (`90m`, `24h`, `7d`).
+        #[arg(long, value_name = "DURATION")]
+        expires: Option<String>,
+    },
+    /// List entries, optionally for one TYPE.
+    List {
+        #[arg(value_name = "TYPE")]
+        policy_type: Option<String>,
+    },
+    /// Remove an entry (`TYPE PATTERN`).
+    #[command(alias = "delete")]
+    Remove {
+        #[arg(value_name = "TYPE")]
+        policy_type: String,
+        #[arg(value_name = "PATTERN")]
+        pattern: String,
+    },
+}
+
+/// `chatmail storage` — operations on the maildir store.
+#[derive(Debug, Subcommand, Clone)]
+pub enum StorageCommand {
+    /// Copy accounts into another mail root while the server keeps running.
+    ///
+    /// Resumable: rerunning skips messages already copied and accounts already verified.
+    Migrate(StorageMigrateArgs),
+}
+
+#[derive(Debug, Parser, Clone)]
+#[command(group(clap::ArgGroup::new("accounts").required(true).args(["user", "all"])))]
+pub struct StorageMigrateArgs {
+    /// Source state dir holding `mail/` (default: the configured state dir).
+    #[arg(long, value_name = "DIR")]
+    pub from: Option<PathBuf>,
+    /// Destination state dir; its `chatmail.db`, if present, receives quota rows.
+    #[arg(long, value_name = "DIR")]
+    pub to: PathBuf,
+    /// Migrate a single account.
+    #[arg(long, value_name = "USERNAME")]
+    pub user: Option<String>,
+    /// Migrate every account in the database.
+    #[arg(long)]
+    pub all: bool,
+    /// Accounts copied concurrently.
+    #[arg(long, default_value_t = 2, value_parser = clap::value_parser!(u16).range(1..=64))]
+    pub parallel: u16,
+    /// Pause after each message, in milliseconds.
+    #[arg(long, value_name = "MS", default_value_t = 0)]
+    pub throttle_ms: u64,
+    /// Messages per mailbox whose content hashes are compared afterwards.
+    #[arg(long, default_value_t = 16)]
+    pub verify_sample: usize,
+    /// Leave a `MIGRATED` note pointing at `--to` in each source account.
+    #[arg(long)]
+    pub mark_source: bool,
+    /// Copy accounts again even if already marked migrated.
+    #[arg(long)]
+    pub force: bool,
+}
+
+/// `chatmail backup` — portable `.tar.gz` archives of accounts and their mail.
+#[derive(Debug, Subcommand, Clone)]
+pub enum BackupCommand {
+    /// Write accounts, password hashes, quotas and every mailbox to an archive.
+    Export {
+        /// Archive to create (`.tar.gz`).
+        #[arg(long, short = 'o', value_name = "FILE")]
+        output: PathBuf,
+        /// Export a single account instead of all of them.
+        #[arg(long, value_name = "USERNAME")]
+        user: Option<String>,
+    },
+    /// Restore accounts from an archive written by `backup export`.
+    ///
+    /// Accounts that already exist are skipped, so rerunning an import is safe.
+    Import {
+        #[arg(value_name = "FILE")]
+        input: PathBuf,
+        /// Replace accounts that already exist (their mail is deleted first).
+        #[arg(long)]
+        overwrite: bool,
+    },
+    /// Archive the whole server: config file, every SQLite DB and the state directory.
+    Snapshot {
+        /// Archive to create (`.tar.gz`).
+        #[arg(long, short = 'o', value_name = "FILE")]
+        output: PathBuf,
+    },
+    /// Put a `backup snapshot` archive back in place after checking its checksums.
+    ///
+    /// Refuses while the service is running unless `--force` is given.
+    Restore {
+        #[arg(value_name = "FILE")]
+        input: PathBuf,
+        /// Stop the running service for the restore and start it again afterwards.
+        #[arg(long)]
+        force: bool,
+    },
+}
+
+/// `chatmail db` — the SQLite databases of a running server.
+#[derive(Debug, Subcommand, Clone)]
+pub enum DbCommand {
+    /// Copy every SQLite database to timestamped files while the server keeps running.
+    Backup {
+        /// Directory for the copies (default: `backups` in the state directory).
+        #[arg(long, short = 'o', value_name = "DIR")]
+        output: Option<PathBuf>,
+    },
+}
+
 /// `chatmail sharing` — Delta Chat contact share links (`sharing.db`).
 #[derive(Debug, Subcommand, Clone)]
 pub enum SharingCommand {
     /// List all contact share links.
     List,
+    /// Show one share link.
+    Show {
+        #[arg(value_name = "SLUG")]
+        slug: String,
+    },
     /// Create a new share link (`SLUG URL [NAME]`).
     Create {
         #[arg(value_name = "SLUG")]
@@ -579,6 +874,9 @@ pub enum SharingCommand {
         url: String,
         #[arg(value_name = "NAME")]
         name: Option<String>,
+        /// Delete the link after this long (`24h`, `7d`, …); it never expires by default.
+        #[arg(long, value_name = "DURATION")]
+        expires: Option<String>,
     },
     /// Reserve a slug (link points to `reserved`).
     Reserve {
@@ -600,6 +898,364 @@ pub enum SharingCommand {
         #[arg(value_name = "NEW_NAME")]
         name: Option<String>,
     },
+    /// Move a share link to a new slug, keeping its invite and counters.
+    Rename {
+        #[arg(value_name = "OLD")]
+        old: String,
+        #[arg(value_name = "NEW")]
+        new: String,
+    },
+}
+
+/// `chatmail imap-acct` — per-account maildir reports.
+#[derive(Debug, Subcommand, Clone)]
+pub enum ImapAcctCommand {
+    /// Accounts whose INBOX holds many unseen messages older than `--min-age`.
+    Backlog {
+        /// Only count messages delivered at least this long ago (`90m`, `24h`, `7d`).
+        #[arg(long, value_name = "DURATION", default_value = "24h")]
+        min_age: String,
+        /// Only list accounts with at least this many stale messages.
+        #[arg(long, value_name = "N", default_value_t = 50)]
+        min_count: usize,
+    },
+    /// Mail store size: per-account (logical) bytes, bytes on disk, and orphaned CAS blobs.
+    Stat,
+    /// Remove CAS blobs no mailbox links to any more.
+    Gc {
+        /// Only remove blobs unlinked for at least this long (never less than `1h`).
+        #[arg(long, value_name = "DURATION", default_value = "1h")]
+        min_age: String,
+        /// Report what would be removed without deleting anything.
+        #[arg(long)]
+        dry_run: bool,
+    },
+    /// Show or set one account's message size cap (IMAP APPEND and delivery).
+    Appendlimit {
+        /// Account address.
+        username: String,
+        /// New cap (`10M`, `512K`, or bytes); `0` removes it. The global `appendlimit`
+        /// stays the ceiling.
+        #[arg(short = 'v', long = "value", value_name = "SIZE")]
+        value: Option<String>,
+    },
+    /// Quota counters of the running server.
+    #[command(subcommand)]
+    Quota(ImapAcctQuotaCommand),
+}
+
+/// `chatmail imap-acct quota` — the running server's cached quota usage (via the admin API).
+#[derive(Debug, Subcommand, Clone)]
+pub enum ImapAcctQuotaCommand {
+    /// Rescan every mailbox of USERNAME (or of all accounts) and replace the cached usage.
+    Recalc {
+        /// Account address.
+        #[arg(required_unless_present = "all", conflicts_with = "all")]
+        username: Option<String>,
+        /// Recalculate every account.
+        #[arg(long)]
+        all: bool,
+        /// Override admin API base URL (default: from config + settings DB).
+        #[arg(long)]
+        url: Option<String>,
+        /// Skip TLS certificate verification (self-signed dev servers).
+        #[arg(long)]
+        insecure: bool,
+    },
+}
+
+/// `chatmail ban` — IP / network and TLS fingerprint bans (`bans` table).
+#[derive(Debug, Subcommand, Clone)]
+pub enum BanCommand {
+    /// Ban an address, CIDR or `ja4:` TLS fingerprint (replaces an existing ban of the same
+    /// range or fingerprint).
+    Add {
+        #[arg(value_name = "TARGET")]
+        cidr: String,
+        /// Free-form reason stored with the ban.
+        #[arg(long, default_value = "")]
+        reason: String,
+        /// Lift the ban after this long (`90m`, `24h`, `7d`); default: permanent.
+        #[arg(long, value_name = "DURATION")]
+        expires: Option<String>,
+    },
+    /// List active bans and the newest audit entries.
+    List {
+        /// Include expired bans still remembered for escalation.
+        #[arg(long)]
+        all: bool,
+    },
+    /// Lift a ban (`TARGET` as listed, or any address in the same range).
+    #[command(alias = "delete")]
+    Remove {
+        #[arg(value_name = "TARGET")]
+        cidr: String,
+    },
+}
+
+/// `chatmail responder` — operator auto-replies for local addresses (`responders` table).
+#[derive(Debug, Subcommand, Clone)]
+pub enum ResponderCommand {
+    /// Set (or replace) the automatic reply sent by ADDRESS.
+    Set {
+        #[arg(value_name = "ADDRESS")]
+        address: String,
+        /// Reply text; `{{subject}}`, `{{sender}}` and `{{address}}` are substituted. A first
+        /// line `Subject: …` sets the reply subject.
+        #[arg(long, value_name = "FILE")]
+        template: PathBuf,
+        /// Answer each correspondent at most once per this long (`72h`, `7d`).
+        #[arg(long, value_name = "DURATION", default_value = "72h")]
+        interval: String,
+    },
+    /// List responders.
+    List,
+    /// Stop answering for ADDRESS.
+    #[command(alias = "delete")]
+    Remove {
+        #[arg(value_name = "ADDRESS")]
+        address: String,
+    },
+}
+
+/// `chatmail autoresponse` — an account's own automatic reply (`autoresponses` table).
+#[derive(Debug, Subcommand, Clone)]
+pub enum AutoresponseCommand {
+    /// Set (or replace) USERNAME's automatic reply.
+    Set {
+        #[arg(value_name = "USERNAME")]
+        username: String,
+        /// Reply subject (default: `Auto: <original subject>`).
+        #[arg(long)]
+        subject: Option<String>,
+        /// Reply text; `{{subject}}`, `{{sender}}` and `{{address}}` are substituted.
+        #[arg(
+            long,
+            value_name = "TEXT",
+            conflicts_with = "body_file",
+            required_unless_present = "body_file"
+        )]
+        body: Option<String>,
+        /// Read the reply text from FILE instead of `--body`.
+        #[arg(long, value_name = "FILE")]
+        body_file: Option<PathBuf>,
+        /// No replies before this (`YYYY-MM-DD` UTC midnight, or unix seconds).
+        #[arg(long, value_name = "DATE")]
+        start: Option<String>,
+        /// No replies from this on (`YYYY-MM-DD` UTC midnight, or unix seconds).
+        #[arg(long, value_name = "DATE")]
+        end: Option<String>,
+        /// Store the reply switched off.
+        #[arg(long)]
+        inactive: bool,
+    },
+    /// Show USERNAME's automatic reply.
+    Show {
+        #[arg(value_name = "USERNAME")]
+        username: String,
+    },
+    /// Remove USERNAME's automatic reply.
+    #[command(alias = "delete")]
+    Clear {
+        #[arg(value_name = "USERNAME")]
+        username: String,
+    },
+}
+
+/// `chatmail domains` — per-domain settings of the local domains.
+#[derive(Debug, Subcommand, Clone)]
+pub enum DomainsCommand {
+    /// Deliver mail for unknown local parts of a domain to one mailbox (`catchalls` table).
+    #[command(subcommand)]
+    Catchall(CatchallCommand),
+}
+
+/// `chatmail domains catchall` — catch-all mailbox per local domain.
+#[derive(Debug, Subcommand, Clone)]
+pub enum CatchallCommand {
+    /// Send mail for unknown addresses of DOMAIN to the existing account TARGET.
+    Set {
+        #[arg(value_name = "DOMAIN")]
+        domain: String,
+        #[arg(value_name = "TARGET")]
+        target: String,
+        /// Redirect at most this many messages per UTC day; the rest are dropped.
+        #[arg(long, value_name = "N", default_value_t = 200)]
+        daily_cap: i64,
+    },
+    /// Remove the catch-all of DOMAIN.
+    #[command(alias = "remove")]
+    Clear {
+        #[arg(value_name = "DOMAIN")]
+        domain: String,
+    },
+    /// List catch-alls with today's delivery count.
+    List,
+}
+
+/// `chatmail peers` — federation peers (`peers` directive plus the `federation_peers` table).
+#[derive(Debug, Subcommand, Clone)]
+pub enum PeersCommand {
+    /// Show every peer with its reachability and advertised limits (via the admin API).
+    List {
+        /// Override admin API base URL (default: from config + settings DB).
+        #[arg(long)]
+        url: Option<String>,
+        /// Skip TLS certificate verification (self-signed dev servers).
+        #[arg(long)]
+        insecure: bool,
+    },
+    /// Store a peer base URL (`https://host[:port]`; a bare host means HTTPS).
+    Add {
+        #[arg(value_name = "URL")]
+        url: String,
+    },
+    /// Remove a stored peer.
+    #[command(alias = "rm")]
+    Remove {
+        #[arg(value_name = "URL")]
+        url: String,
+    },
+}
+
+/// `chatmail dkim` — outbound DKIM keys. Rotation never deletes a key: `rotate` adds one under
+/// a new selector, `activate` switches signing to it once its record is published.
+#[derive(Debug, Subcommand, Clone)]
+pub enum DkimCommand {
+    /// Show every key with its DNS record, and the selectors signing now.
+    List,
+    /// Generate a key for every signing domain under a new date-based selector.
+    Rotate {
+        /// Key type: `rsa2048` (default), `rsa4096` or `ed25519`.
+        #[arg(long, value_name = "ALGO", default_value = "rsa2048")]
+        algorithm: String,
+        /// Selector to use instead of today's date (`YYYYMMDD`).
+        #[arg(long)]
+        selector: Option<String>,
+    },
+    /// Sign outbound mail with SELECTOR from the next message on.
+    Activate {
+        #[arg(value_name = "SELECTOR")]
+        selector: String,
+    },
+}
+
+/// `chatmail webhook` — account lifecycle webhooks (`webhook_url`, `webhook_secret`).
+#[derive(Debug, Subcommand, Clone)]
+pub enum WebhookCommand {
+    /// POST a signed `ping` event to `webhook_url` once and report the answer.
+    Test,
+}
+
+/// `chatmail broadcast` — announcement jobs on the running server.
+#[derive(Debug, Subcommand, Clone)]
+pub enum BroadcastCommand {
+    /// Queue an announcement (`POST /admin/broadcast`).
+    Send(BroadcastArgs),
+    /// Show one job (`GET /admin/broadcast/{id}`), or every job the server keeps.
+    Status {
+        /// Job ID printed by `broadcast send`.
+        id: Option<String>,
+        /// Override admin API base URL (default: from config + settings DB).
+        #[arg(long)]
+        url: Option<String>,
+        /// Skip TLS certificate verification (self-signed dev servers).
+        #[arg(long)]
+        insecure: bool,
+    },
+}
+
+/// `chatmail audit` — the `admin_audit` trail.
+#[derive(Debug, Subcommand, Clone)]
+pub enum AuditCommand {
+    /// List audit entries, newest first.
+    List(AuditListArgs),
+}
+
+/// `chatmail audit list` / `chatmail admin-audit` filters and paging.
+#[derive(Debug, Parser, Clone)]
+pub struct AuditListArgs {
+    /// Page to show (1-based).
+    #[arg(long, default_value_t = 1, value_parser = clap::value_parser!(i64).range(1..))]
+    pub page: i64,
+    /// Entries per page.
+    #[arg(long, default_value_t = 50, value_parser = clap::value_parser!(i64).range(1..=1000))]
+    pub limit: i64,
+    /// Only entries newer than this (`24h`, `7d`, …).
+    #[arg(long, value_name = "DURATION")]
+    pub since: Option<String>,
+    /// Only entries whose resource starts with this (`/admin/settings/`, `__TURN`).
+    #[arg(long, value_name = "PREFIX")]
+    pub resource: Option<String>,
+}
+
+/// `chatmail broadcast send` — `POST /admin/broadcast` on the running server.
+#[derive(Debug, Parser, Clone)]
+pub struct BroadcastArgs {
+    #[arg(long)]
+    pub subject: String,
+    /// Message body (plain text, or Markdown with `--markdown`).
+    #[arg(
+        long,
+        required_unless_present = "body_file",
+        conflicts_with = "body_file"
+    )]
+    pub body: Option<String>,
+    /// Read the body from a file.
+    #[arg(long, value_name = "PATH")]
+    pub body_file: Option<PathBuf>,
+    /// Also send the body rendered from Markdown as HTML.
+    #[arg(long)]
+    pub markdown: bool,
+    /// Only accounts active within this duration (e.g. `30d`).
+    #[arg(
+        long,
+        value_name = "DURATION",
+        conflicts_with_all = ["domain", "created_after", "account"]
+    )]
+    pub active_since: Option<String>,
+    /// Only accounts of this local domain.
+    #[arg(long, conflicts_with_all = ["created_after", "account"])]
+    pub domain: Option<String>,
+    /// Only accounts created on or after this day (`YYYY-MM-DD`, UTC) or unix time.
+    #[arg(long, value_name = "DATE", conflicts_with = "account")]
+    pub created_after: Option<String>,
+    /// Only these addresses (repeatable); unknown addresses reject the broadcast.
+    #[arg(long = "account", value_name = "ADDR")]
+    pub account: Vec<String>,
+    /// Count the matching accounts from the database without sending anything.
+    #[arg(long)]
+    pub dry_run: bool,
+    /// Wait for the job to finish and report failed accounts.
+    #[arg(long)]
+    pub wait: bool,
+    /// Override admin API base URL (default: from config + settings DB).
+    #[arg(long)]
+    pub url: Option<String>,
+    /// Skip TLS certificate verification (self-signed dev servers).
+    #[arg(long)]
+    pub insecure: bool,
+}
+
+/// `chatmail theme` — custom www themes, staged under `{state_dir}/themes`.
+#[derive(Debug, Subcommand, Clone)]
+pub enum ThemeCommand {
+    /// Show the active theme, the rollback target and installed themes.
+    Status,
+    /// Parse and render every template in DIR against fixture data (no changes made).
+    Validate {
+        #[arg(value_name = "DIR")]
+        dir: PathBuf,
+    },
+    /// Validate DIR, copy it into the theme store and make it active.
+    Install {
+        #[arg(value_name = "DIR")]
+        dir: PathBuf,
+    },
+    /// Re-activate the previously active theme.
+    Rollback,
+    /// Stop serving the installed theme (back to `www_dir` or the embedded site).
+    Deactivate,
 }
 
 /// `chatmail uninstall` flags (Madmail `ctl/uninstall.go`).
@@ -687,6 +1343,29 @@ pub enum FirewallCommand {
     Remove,
 }
 
+/// `madmail creds` (direct DB).
+#[derive(Debug, Subcommand, Clone)]
+pub enum CredsCommand {
+    /// Per-account sending counters (`max_messages_per_hour`).
+    #[command(name = "rate-limit", subcommand)]
+    RateLimit(RateLimitCommand),
+}
+
+/// `madmail creds rate-limit`.
+#[derive(Debug, Subcommand, Clone)]
+pub enum RateLimitCommand {
+    /// Messages and recipients per hour over the last 24 hours (top senders without USER).
+    Show {
+        /// Email address.
+        username: Option<String>,
+    },
+    /// Clear the account's counters so it can send again this hour.
+    Reset {
+        /// Email address.
+        username: String,
+    },
+}
+
 /// `chatmail registration` — `__REGISTRATION_OPEN__`.
 #[derive(Debug, Subcommand, Clone)]
 pub enum RegistrationCommand {
@@ -871,6 +1550,8 @@ mod tests {
             Some(Command::Upgrade {
                 path_or_url,
                 accept_unsafe_https: false,
+                backup: false,
+                dry_run: false,
             }) if path_or_url == "https://relay.example/bin/madmail"
         ));
 
@@ -880,6 +1561,8 @@ mod tests {
             Some(Command::Update {
                 path_or_url,
                 accept_unsafe_https: false,
+                backup: false,
+                dry_run: false,
             }) if path_or_url == "/tmp/madmail-signed"
         ));
     }
@@ -913,10 +1596,200 @@ mod tests {
             Some(Command::Update {
                 accept_unsafe_https: true,
                 path_or_url,
+                ..
             }) if path_or_url == "https://relay.example/a.tar.gz"
         ));
     }
 
+    #[test]
+    fn upgrade_backup_and_dry_run_flags() {
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "upgrade",
+            "--backup",
+            "--dry-run",
+            "/tmp/madmail-signed",
+        ])
+        .unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Upgrade {
+                backup: true,
+                dry_run: true,
+                accept_unsafe_https: false,
+                ..
+            })
+        ));
+    }
+
+    #[test]
+    fn audit_list_and_admin_audit_share_filters() {
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "audit",
+            "list",
+            "--since",
+            "24h",
+            "--resource",
+            "__",
+        ])
+        .unwrap();
+        let Some(Command::Audit(AuditCommand::List(args))) = cli.command else {
+            panic!("expected audit list");
+        };
+        assert_eq!(args.since.as_deref(), Some("24h"));
+        assert_eq!(args.resource.as_deref(), Some("__"));
+        assert_eq!((args.page, args.limit), (1, 50));
+        let cli = Cli::try_parse_from(["madmail", "admin-audit", "--page", "2"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::AdminAudit(AuditListArgs { page: 2, .. }))
+        ));
+    }
+
+    #[test]
+    fn autoresponse_set_needs_one_body_source() {
+        let base = ["madmail", "autoresponse", "set", "a@x.org"];
+        let parse = |extra: &[&str]| Cli::try_parse_from(base.iter().chain(extra));
+        assert!(parse(&[]).is_err());
+        assert!(parse(&["--body", "x", "--body-file", "/tmp/b.txt"]).is_err());
+        let cli = parse(&["--body-file", "/tmp/b.txt", "--inactive"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Autoresponse(AutoresponseCommand::Set {
+                body: None,
+                body_file: Some(_),
+                inactive: true,
+                ..
+            }))
+        ));
+    }
+
+    #[test]
+    fn policy_add_parses_type_pattern_and_flags() {
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "policy",
+            "add",
+            "federation",
+            ".evil.example",
+            "--action",
+            "deny",
+            "--expires",
+            "7d",
+        ])
+        .unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Policy(PolicyCommand::Add {
+                policy_type,
+                pattern,
+                action: Some(_),
+                expires: Some(_),
+                ..
+            })) if policy_type == "federation" && pattern == ".evil.example"
+        ));
+
+        let cli = Cli::try_parse_from(["madmail", "policy", "list"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Policy(PolicyCommand::List { policy_type: None }))
+        ));
+    }
+
+    #[test]
+    fn storage_migrate_requires_accounts() {
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "storage",
+            "migrate",
+            "--to",
+            "/srv/new",
+            "--all",
+            "--parallel",
+            "4",
+        ])
+        .unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Storage(StorageCommand::Migrate(
+                StorageMigrateArgs {
+                    all: true,
+                    parallel: 4,
+                    user: None,
+                    ..
+                }
+            )))
+        ));
+        assert!(
+            Cli::try_parse_from(["madmail", "storage", "migrate", "--to", "/srv/new"]).is_err()
+        );
+        assert!(Cli::try_parse_from([
+            "madmail", "storage", "migrate", "--to", "/x", "--all", "--user", "a@b"
+        ])
+        .is_err());
+    }
+
+    #[test]
+    fn backup_export_and_import_parse() {
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "backup",
+            "export",
+            "-o",
+            "/tmp/a.tar.gz",
+            "--user",
+            "a@b",
+        ])
+        .unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Backup(BackupCommand::Export { user: Some(ref u), .. })) if u == "a@b"
+        ));
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "backup",
+            "import",
+            "/tmp/a.tar.gz",
+            "--overwrite",
+        ])
+        .unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Backup(BackupCommand::Import {
+                overwrite: true,
+                ..
+            }))
+        ));
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "backup",
+            "restore",
+            "/tmp/server.tar.gz",
+            "--force",
+        ])
+        .unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Backup(BackupCommand::Restore { force: true, .. }))
+        ));
+        assert!(Cli::try_parse_from(["madmail", "backup", "export"]).is_err());
+    }
+
+    #[test]
+    fn db_backup_output_is_optional() {
+        let cli = Cli::try_parse_from(["madmail", "db", "backup", "--output", "/srv/db"]).unwrap();
+        let Some(Command::Db(DbCommand::Backup { output })) = cli.command else {
+            panic!("expected db backup");
+        };
+        assert_eq!(output, Some(PathBuf::from("/srv/db")));
+        let cli = Cli::try_parse_from(["madmail", "db", "backup"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Db(DbCommand::Backup { output: None }))
+        ));
+    }
+
     #[test]
     fn html_migrate_accepts_yes_flag() {
         let cli = Cli::try_parse_from(["madmail", "html-migrate"]).unwrap();
@@ -1094,6 +1967,121 @@ mod tests {
         ));
     }
 
+    #[test]
+    fn imap_acct_quota_recalc_needs_username_or_all() {
+        use super::{ImapAcctCommand, ImapAcctQuotaCommand};
+
+        let cli =
+            Cli::try_parse_from(["chatmail", "imap-acct", "quota", "recalc", "a@x.org"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::ImapAcct(ImapAcctCommand::Quota(ImapAcctQuotaCommand::Recalc {
+                username: Some(u),
+                all: false,
+                ..
+            }))) if u == "a@x.org"
+        ));
+        let cli =
+            Cli::try_parse_from(["chatmail", "imap-acct", "quota", "recalc", "--all"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::ImapAcct(ImapAcctCommand::Quota(
+                ImapAcctQuotaCommand::Recalc {
+                    username: None,
+                    all: true,
+                    ..
+                }
+            )))
+        ));
+        assert!(Cli::try_parse_from(["chatmail", "imap-acct", "quota", "recalc"]).is_err());
+        assert!(Cli::try_parse_from([
+            "chatmail",
+            "imap-acct",
+            "quota",
+            "recalc",
+            "a@x.org",
+            "--all"
+        ])
+        .is_err());
+    }
+
+    #[test]
+    fn broadcast_send_takes_account_list_and_status_takes_id() {
+        use super::BroadcastCommand;
+
+        let cli = Cli::try_parse_from([
+            "chatmail",
+            "broadcast",
+            "send",
+            "--subject",
+            "s",
+            "--body",
+            "b",
+            "--account",
+            "a@x.org",
+            "--account",
+            "b@x.org",
+        ])
+        .unwrap();
+        let Some(Command::Broadcast(BroadcastCommand::Send(send))) = cli.command else {
+            panic!("expected broadcast send");
+        };
+        assert_eq!(send.account, ["a@x.org", "b@x.org"]);
+        let cli = Cli::try_parse_from(["chatmail", "broadcast", "status", "bc-1"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Broadcast(BroadcastCommand::Status { id: Some(ref id), .. }))
+                if id == "bc-1"
+        ));
+        assert!(Cli::try_parse_from([
+            "chatmail",
+            "broadcast",
+            "send",
+            "--subject",
+            "s",
+            "--body",
+            "b",
+            "--created-after",
+            "2024-01-01",
+            "--account",
+            "a@x.org",
+        ])
+        .is_err());
+    }
+
+    #[test]
+    fn install_acme_flag_conflicts_with_explicit_tls_mode() {
+        let cli = Cli::try_parse_from([
+            "madmail",
+            "install",
+            "--domain",
+            "mail.example.org",
+            "--acme",
+            "--acme-directory",
+            "https://ca.internal/acme/directory",
+        ])
+        .unwrap();
+        let Some(Command::Install(args)) = cli.command else {
+            panic!("expected install subcommand");
+        };
+        assert!(args.acme);
+        assert_eq!(
+            args.acme_directory.as_deref(),
+            Some("https://ca.internal/acme/directory")
+        );
+        assert!(
+            Cli::try_parse_from(["madmail", "install", "--acme", "--tls-mode", "file"]).is_err()
+        );
+
+        let cli = Cli::try_parse_from(["madmail", "certificate", "renew"]).unwrap();
+        assert!(matches!(
+            cli.command,
+            Some(Command::Certificate {
+                cmd: CertificateCommand::Get(_)
+            })
+        ));
+    }
+
     #[test]
     fn default_install_subcommand_flags_are_unset() {
         let mut cli =
@@ -0,0 +1,129 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Cold tier for old mail (`cold_storage { ... }` inside `storage.imapsql`).
+//!
+//! Strictly opt-in: the block's presence turns the tier on. Messages older than `age` are
+//! packed into compressed per-account files by the `cold-storage` maintenance task; reads stay
+//! transparent and quota keeps counting the original size.
+//!
+//! ```text
+//! storage.imapsql local_mailboxes {
+//!     cold_storage {
+//!         path /var/lib/maddy/cold
+//!         age 90d
+//!         compression gzip
+//!         promote_on_read no
+//!         compact_dead_percent 50
+//!     }
+//! }
+//! ```
+
+use std::path::PathBuf;
+use std::time::Duration;
+
+/// Default `age`: mail untouched for a quarter moves to packs.
+pub const DEFAULT_COLD_AGE: Duration = Duration::from_secs(90 * 86_400);
+/// Default `compact_dead_percent`.
+pub const DEFAULT_COMPACT_DEAD_PERCENT: u8 = 50;
+
+/// Settings from the `cold_storage` block.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ColdStorageConfig {
+    /// True once a `cold_storage` block is present (`enable no` inside it turns it back off).
+    pub enabled: bool,
+    /// `path` — pack directory (default `{state_dir}/cold`; may sit on slower, cheaper disk).
+    pub path: Option<PathBuf>,
+    /// `age` — minimum age (internal date) before a message moves (default `90d`).
+    pub age: Duration,
+    /// `compression` — `gzip` (default) or `none`.
+    pub compression: String,
+    /// `promote_on_read` — write a cold message back to the maildir when it is fetched.
+    pub promote_on_read: bool,
+    /// `compact_dead_percent` — rewrite a pack once this share of it was expunged (1–100).
+    pub compact_dead_percent: u8,
+}
+
+impl Default for ColdStorageConfig {
+    fn default() -> Self {
+        Self {
+            enabled: false,
+            path: None,
+            age: DEFAULT_COLD_AGE,
+            compression: "gzip".into(),
+            promote_on_read: false,
+            compact_dead_percent: DEFAULT_COMPACT_DEAD_PERCENT,
+        }
+    }
+}
+
+impl ColdStorageConfig {
+    /// Apply one directive from the `cold_storage` block; returns false for unknown names
+    /// or values (the previous setting is kept).
+    pub fn apply_directive(&mut self, name: &str, value: &str) -> bool {
+        let value = value.trim();
+        match name {
+            "enable" | "enabled" => match crate::parse_bool_str_opt(value) {
+                Some(on) => self.enabled = on,
+                None => return false,
+            },
+            "path" if !value.is_empty() => self.path = Some(PathBuf::from(value)),
+            "age" => match crate::parse_duration(value) {
+                Ok(age) => self.age = age,
+                Err(_) => return false,
+            },
+            "compression" => match value.to_ascii_lowercase().as_str() {
+                v @ ("gzip" | "none") => self.compression = v.to_string(),
+                _ => return false,
+            },
+            "promote_on_read" => match crate::parse_bool_str_opt(value) {
+                Some(on) => self.promote_on_read = on,
+                None => return false,
+            },
+            "compact_dead_percent" => match value.trim_end_matches('%').parse::<u8>() {
+                Ok(p) if (1..=100).contains(&p) => self.compact_dead_percent = p,
+                _ => return false,
+            },
+            _ => return false,
+        }
+        true
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn directives_override_defaults_and_reject_bad_values() {
+        let mut c = ColdStorageConfig::default();
+        assert!(!c.enabled);
+        assert!(c.apply_directive("path", "/srv/cold"));
+        assert!(c.apply_directive("age", "30d"));
+        assert!(c.apply_directive("compression", "none"));
+        assert!(c.apply_directive("promote_on_read", "yes"));
+        assert!(c.apply_directive("compact_dead_percent", "75%"));
+        assert!(!c.apply_directive("compression", "zstd"));
+        assert!(!c.apply_directive("compact_dead_percent", "0"));
+        assert!(!c.apply_directive("age", "soon"));
+        assert_eq!(c.path.as_deref(), Some(std::path::Path::new("/srv/cold")));
+        assert_eq!(c.age, Duration::from_secs(30 * 86_400));
+        assert_eq!(c.compression, "none", "bad value keeps the previous one");
+        assert!(c.promote_on_read);
+        assert_eq!(c.compact_dead_percent, 75);
+    }
+}
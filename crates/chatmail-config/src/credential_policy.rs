@@ -15,10 +15,42 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
-//! Username/password length limits from the `chatmail { … }` block (Madmail-compatible).
+//! Username/password length limits and generated-credential alphabets from the
+//! `chatmail { … }` block (Madmail-compatible).
 
 use crate::AppConfig;
 
+/// Alphabet for generated usernames, `/new` passwords and app passwords when
+/// `password_charset` is unset.
+pub const DEFAULT_GENERATED_CHARSET: &str = "abcdefghijklmnopqrstuvwxyz0123456789";
+
+/// Characters removed by `password_exclude_ambiguous` / `username_exclude_ambiguous`; they are
+/// easy to confuse when credentials are typed by hand instead of scanned.
+pub const AMBIGUOUS_CHARS: &str = "0Oo1Il";
+
+/// Floor for a customised password alphabet at the configured `password_length`.
+pub const MIN_PASSWORD_ENTROPY_BITS: f64 = 64.0;
+
+/// Entropy in bits of `len` symbols drawn uniformly from `alphabet_len` distinct symbols.
+pub fn entropy_bits(alphabet_len: usize, len: usize) -> f64 {
+    if alphabet_len < 2 {
+        return 0.0;
+    }
+    len as f64 * (alphabet_len as f64).log2()
+}
+
+/// Distinct characters of `charset` in order, optionally without [`AMBIGUOUS_CHARS`].
+fn effective_charset(charset: &str, exclude_ambiguous: bool) -> String {
+    let mut out = String::with_capacity(charset.len());
+    for c in charset.chars() {
+        if out.contains(c) || (exclude_ambiguous && AMBIGUOUS_CHARS.contains(c)) {
+            continue;
+        }
+        out.push(c);
+    }
+    out
+}
+
 /// Length rules for auto-generated credentials and JIT/login validation.
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub struct CredentialPolicy {
@@ -93,6 +125,56 @@ impl AppConfig {
             password_min_length: password_min,
         }
     }
+
+    /// Alphabet for generated passwords: `password_charset` (else `default`), without
+    /// ambiguous characters when `password_exclude_ambiguous` is on. Callers pass their own
+    /// default so admin-created passwords keep their symbols unless the operator overrides it.
+    pub fn generated_password_charset(&self, default: &str) -> String {
+        let base = self
+            .password_charset
+            .as_deref()
+            .filter(|s| !s.is_empty())
+            .unwrap_or(default);
+        effective_charset(base, self.password_exclude_ambiguous.unwrap_or(false))
+    }
+
+    /// Alphabet for generated localparts (lowercase letters and digits).
+    pub fn generated_username_charset(&self) -> String {
+        effective_charset(
+            DEFAULT_GENERATED_CHARSET,
+            self.username_exclude_ambiguous.unwrap_or(false),
+        )
+    }
+
+    /// Reject charset directives that would weaken generated passwords. Only checked when
+    /// `password_charset` or `password_exclude_ambiguous` is set, so existing configs keep loading.
+    pub fn validate_credential_charsets(&self) -> Result<(), String> {
+        if let Some(c) = self
+            .password_charset
+            .as_deref()
+            .and_then(|s| s.chars().find(|c| !c.is_ascii_graphic()))
+        {
+            return Err(format!(
+                "password_charset: {c:?} is not a printable ASCII character"
+            ));
+        }
+        if self.password_charset.is_none() && self.password_exclude_ambiguous.is_none() {
+            return Ok(());
+        }
+        let symbols = self
+            .generated_password_charset(DEFAULT_GENERATED_CHARSET)
+            .chars()
+            .count();
+        let len = self.credential_policy().generated_password_length();
+        let bits = entropy_bits(symbols, len);
+        if bits < MIN_PASSWORD_ENTROPY_BITS {
+            return Err(format!(
+                "password charset has {symbols} symbols; {len} characters give {bits:.1} bits of \
+                 entropy, below {MIN_PASSWORD_ENTROPY_BITS}; add symbols or raise password_length"
+            ));
+        }
+        Ok(())
+    }
 }
 
 #[cfg(test)]
@@ -162,6 +244,73 @@ mod tests {
         assert_eq!(p.generated_username_length(), 8);
     }
 
+    #[test]
+    fn entropy_bits_of_common_alphabets() {
+        assert_eq!(entropy_bits(1, 64), 0.0);
+        assert_eq!(entropy_bits(2, 64), 64.0);
+        assert_eq!(entropy_bits(16, 16), 64.0);
+        let default = entropy_bits(DEFAULT_GENERATED_CHARSET.len(), 16);
+        assert!((default - 82.72).abs() < 0.01, "{default}");
+    }
+
+    #[test]
+    fn charsets_unchanged_unless_configured() {
+        let cfg = AppConfig::default();
+        assert_eq!(
+            cfg.generated_password_charset(DEFAULT_GENERATED_CHARSET),
+            DEFAULT_GENERATED_CHARSET
+        );
+        assert_eq!(cfg.generated_password_charset("ab!?"), "ab!?");
+        assert_eq!(cfg.generated_username_charset(), DEFAULT_GENERATED_CHARSET);
+        assert_eq!(cfg.validate_credential_charsets(), Ok(()));
+        // Short default passwords predate the floor and are not rejected retroactively.
+        let short = AppConfig {
+            password_length: Some(8),
+            ..Default::default()
+        };
+        assert_eq!(short.validate_credential_charsets(), Ok(()));
+    }
+
+    #[test]
+    fn exclude_ambiguous_and_dedup() {
+        let cfg = AppConfig {
+            password_charset: Some("aabcO0lI1xyz!".into()),
+            password_exclude_ambiguous: Some(true),
+            username_exclude_ambiguous: Some(true),
+            ..Default::default()
+        };
+        assert_eq!(cfg.generated_password_charset("unused"), "abcxyz!");
+        let user = cfg.generated_username_charset();
+        assert_eq!(user.len(), DEFAULT_GENERATED_CHARSET.len() - 4);
+        assert!(!user.chars().any(|c| AMBIGUOUS_CHARS.contains(c)));
+    }
+
+    #[test]
+    fn validation_rejects_low_entropy_charset() {
+        let cfg = AppConfig {
+            password_charset: Some("0123456789".into()),
+            ..Default::default()
+        };
+        let err = cfg.validate_credential_charsets().unwrap_err();
+        assert!(err.contains("53.2 bits"), "{err}");
+
+        let cfg = AppConfig {
+            password_charset: Some("0123456789".into()),
+            password_length: Some(20),
+            ..Default::default()
+        };
+        assert_eq!(cfg.validate_credential_charsets(), Ok(()));
+
+        let cfg = AppConfig {
+            password_charset: Some("abc def".into()),
+            ..Default::default()
+        };
+        assert!(cfg
+            .validate_credential_charsets()
+            .unwrap_err()
+            .contains("printable ASCII"));
+    }
+
     #[test]
     fn unset_fields_use_defaults() {
         let cfg = AppConfig::default();
@@ -0,0 +1,172 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Authentication checks for mail arriving over `/mxdeliv` (`federation_checks { ... }`).
+//!
+//! The HTTP peer is not the sender's SPF-authorized MX, so SPF is skipped by default; DKIM is
+//! verified and DMARC is applied with DKIM-only alignment. Each check is set independently:
+//!
+//! ```text
+//! federation_checks {
+//!     spf off
+//!     dkim check
+//!     dmarc enforce
+//!     dmarc_alignment dkim
+//! }
+//! ```
+
+/// What to do with one check's result.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CheckMode {
+    /// Not evaluated and not recorded in `Authentication-Results`.
+    Off,
+    /// Evaluated and recorded; never changes the disposition.
+    Check,
+    /// Evaluated and recorded; a failure rejects the message.
+    Enforce,
+}
+
+impl CheckMode {
+    pub fn parse(s: &str) -> Option<Self> {
+        match s.trim().to_ascii_lowercase().as_str() {
+            "off" | "no" | "false" | "skip" => Some(Self::Off),
+            "check" | "on" | "yes" | "true" => Some(Self::Check),
+            "enforce" | "reject" => Some(Self::Enforce),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Off => "off",
+            Self::Check => "check",
+            Self::Enforce => "enforce",
+        }
+    }
+
+    pub fn is_on(self) -> bool {
+        self != Self::Off
+    }
+}
+
+/// Which identifiers may satisfy DMARC alignment.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DmarcAlignment {
+    /// Only an aligned passing DKIM signature (SPF says nothing about an HTTP peer).
+    Dkim,
+    /// DKIM or SPF, as on the SMTP path (needs `spf` not `off`).
+    Any,
+}
+
+impl DmarcAlignment {
+    pub fn parse(s: &str) -> Option<Self> {
+        match s.trim().to_ascii_lowercase().as_str() {
+            "dkim" => Some(Self::Dkim),
+            "any" | "dkim+spf" => Some(Self::Any),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Dkim => "dkim",
+            Self::Any => "any",
+        }
+    }
+}
+
+/// Check policy applied to every `/mxdeliv` message.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct FederationChecks {
+    /// `spf` — against the HTTP peer address and the `X-Mail-From` domain (default `off`).
+    pub spf: CheckMode,
+    /// `dkim` — `DKIM-Signature` verification (default `check`: enforcement is DMARC's job).
+    pub dkim: CheckMode,
+    /// `dmarc` — `enforce` rejects failures when the From domain publishes `p=reject`.
+    pub dmarc: CheckMode,
+    /// `dmarc_alignment` — `dkim` (default) or `any`.
+    pub dmarc_alignment: DmarcAlignment,
+}
+
+impl Default for FederationChecks {
+    fn default() -> Self {
+        Self {
+            spf: CheckMode::Off,
+            dkim: CheckMode::Check,
+            dmarc: CheckMode::Enforce,
+            dmarc_alignment: DmarcAlignment::Dkim,
+        }
+    }
+}
+
+impl FederationChecks {
+    /// Apply one directive from the `federation_checks` block; returns false for unknown names
+    /// or values (the previous setting is kept).
+    pub fn apply_directive(&mut self, name: &str, value: &str) -> bool {
+        if name == "dmarc_alignment" {
+            return DmarcAlignment::parse(value)
+                .map(|a| self.dmarc_alignment = a)
+                .is_some();
+        }
+        let slot = match name {
+            "spf" => &mut self.spf,
+            "dkim" => &mut self.dkim,
+            "dmarc" => &mut self.dmarc,
+            _ => return false,
+        };
+        match CheckMode::parse(value) {
+            Some(mode) => {
+                *slot = mode;
+                true
+            }
+            None => false,
+        }
+    }
+
+    /// True when no check runs (the message gets `Authentication-Results: ...; none`).
+    pub fn all_off(&self) -> bool {
+        !self.spf.is_on() && !self.dkim.is_on() && !self.dmarc.is_on()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn defaults_skip_spf_and_enforce_dmarc_on_dkim() {
+        let c = FederationChecks::default();
+        assert_eq!(c.spf, CheckMode::Off);
+        assert_eq!(c.dkim, CheckMode::Check);
+        assert_eq!(c.dmarc, CheckMode::Enforce);
+        assert_eq!(c.dmarc_alignment, DmarcAlignment::Dkim);
+    }
+
+    #[test]
+    fn directives_set_each_check_independently() {
+        let mut c = FederationChecks::default();
+        assert!(c.apply_directive("spf", "enforce"));
+        assert!(c.apply_directive("dkim", "off"));
+        assert!(c.apply_directive("dmarc_alignment", "any"));
+        assert!(!c.apply_directive("dmarc", "sometimes"));
+        assert!(!c.apply_directive("arc", "on"));
+        assert_eq!(c.spf, CheckMode::Enforce);
+        assert_eq!(c.dkim, CheckMode::Off);
+        assert_eq!(c.dmarc, CheckMode::Enforce, "bad value keeps the default");
+        assert_eq!(c.dmarc_alignment, DmarcAlignment::Any);
+    }
+}
@@ -124,15 +124,39 @@ pub struct InstallArgs {
     #[arg(long)]
     pub auto_ip_cert: bool,
 
+    /// Shorthand for `--tls-mode autocert`: obtain the certificate over ACME HTTP-01 during
+    /// install; the running server renews it 30 days before expiry.
+    #[arg(long, conflicts_with_all = ["tls_mode", "no_obtain_certificate"])]
+    pub acme: bool,
+
+    /// ACME directory URL (default: Let's Encrypt); written to the config for renewals.
+    #[arg(long)]
+    pub acme_directory: Option<String>,
+
     /// Website/UI language: `en`, `fa`, `ru`, `es` (Madmail `--lang`; seeds `__LANGUAGE__` in DB).
     #[arg(long, default_value = "en")]
     pub lang: String,
+
+    /// Further mail domains next to `--domain` (comma-separated or repeated): written to
+    /// `mail_domain` and `$(local_domains)`, with DNS guidance printed for each.
+    #[arg(long = "extra-domains", value_delimiter = ',')]
+    pub extra_domains: Vec<String>,
+
+    /// DKIM key type: `rsa2048`, `rsa4096` or `ed25519` (some providers do not verify ed25519).
+    #[arg(long, value_name = "ALGO", default_value = "rsa2048")]
+    pub dkim_algo: String,
+
+    /// Sign with two keys: `ed1` (ed25519) and `rsa1` (RSA, `--dkim-algo` size) instead of
+    /// `default`.
+    #[arg(long)]
+    pub dkim_dual: bool,
 }
 
 /// `madmail certificate` — Let's Encrypt via instant-acme HTTP-01.
 #[derive(Debug, Subcommand, Clone)]
 pub enum CertificateCommand {
     /// Obtain certificate if missing or expiring within 30 days.
+    #[command(alias = "renew")]
     Get(CertificateArgs),
     /// Force new certificate issuance.
     Regenerate(CertificateArgs),
@@ -189,6 +213,10 @@ pub struct CertificateArgs {
     #[arg(long)]
     pub staging: bool,
 
+    /// ACME directory URL (default: `acme_directory` from config, else Let's Encrypt).
+    #[arg(long)]
+    pub acme_directory: Option<String>,
+
     /// Force issuance on `get` even if current cert is still valid.
     #[arg(long)]
     pub force: bool,
@@ -15,15 +15,18 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+pub mod app_links;
 pub mod autoconfig;
 pub mod bool_str;
 pub mod cli;
 pub mod client_mail;
+pub mod cold_storage;
 pub mod config_autocert;
 pub mod config_www;
 pub mod credential_policy;
 pub mod data_size;
 pub mod db_path;
+pub mod federation_checks;
 pub mod install_cli;
 pub mod maddy;
 mod madmail_lexer;
@@ -31,6 +34,7 @@ mod madmail_parse;
 pub mod parse;
 pub mod paths;
 pub mod queue;
+pub mod registration_rate;
 pub mod turn_relay_ports;
 
 pub use config_autocert::update_config_autocert;
@@ -38,13 +42,20 @@ pub use config_www::update_config_www_dir;
 
 use std::path::PathBuf;
 
-pub use autoconfig::{build_autoconfig_xml, AutoconfigParams};
+pub use app_links::AppLinksConfig;
+pub use autoconfig::{
+    build_autoconfig_error_xml, build_autoconfig_xml, build_autodiscover_error_xml,
+    build_autodiscover_xml, AutoconfigParams,
+};
 pub use bool_str::{is_falsy, is_truthy, parse_bool_str, parse_bool_str_opt};
 pub use cli::{
-    AdminWebCommand, Args, Cli, Command, CompletionShell, EndpointCacheCommand, FederationCommand,
-    FirewallCommand, LanguageCommand, PortCommand, PortServiceCommand, ProxyCommand,
-    ProxySettingCommand, PushCommand, RegistrationCommand, RegistrationTokensCommand,
-    ServiceCommand, ServiceToggleCommand, SharingCommand, TasksCommand, UninstallArgs,
+    AdminWebCommand, Args, AuditCommand, AuditListArgs, AutoresponseCommand, BanCommand,
+    BroadcastArgs, BroadcastCommand, CatchallCommand, Cli, Command, CompletionShell, DbCommand,
+    DkimCommand, DomainsCommand, EndpointCacheCommand, FederationCommand, FirewallCommand,
+    ImapAcctCommand, ImapAcctQuotaCommand, LanguageCommand, PeersCommand, PortCommand,
+    PortServiceCommand, ProxyCommand, ProxySettingCommand, PushCommand, RegistrationCommand,
+    RegistrationTokensCommand, ResponderCommand, ServiceCommand, ServiceToggleCommand,
+    SettingsCommand, SharingCommand, TasksCommand, ThemeCommand, UninstallArgs, WebhookCommand,
     DEFAULT_WINDOWS_SERVICE_NAME, FIREWALL_RULE_PREFIX,
 };
 pub use client_mail::{
@@ -54,7 +65,11 @@ pub use client_mail::{
     effective_submission_tls_listen, effective_tls_pem_paths, listeners_need_tls_cert,
     port_from_listen, DbMailPorts, DcloginMailSettings, RuntimeListeners,
 };
-pub use credential_policy::CredentialPolicy;
+pub use cold_storage::ColdStorageConfig;
+pub use credential_policy::{
+    entropy_bits, CredentialPolicy, AMBIGUOUS_CHARS, DEFAULT_GENERATED_CHARSET,
+    MIN_PASSWORD_ENTROPY_BITS,
+};
 pub use data_size::{
     effective_default_quota_bytes, effective_max_federation_bytes, effective_max_message_bytes,
     format_data_size, parse_data_size, resolve_max_federation_bytes, resolve_max_message_bytes,
@@ -65,6 +80,7 @@ pub use db_path::{
     effective_app_db_path, effective_database_config, DatabaseConfig, DbDriver, CHATMAIL_RS_DB,
     MADMAIL_CREDENTIALS_DB,
 };
+pub use federation_checks::{CheckMode, DmarcAlignment, FederationChecks};
 pub use maddy::{
     maddy_listen_to_socket_addr, parse_duration, parse_maddy_conf_str, parse_maddy_config,
     resolve_state_path, ParseDurationError,
@@ -75,7 +91,64 @@ pub use paths::{
     apply_cli_defaults, detect_default_config_path, detect_default_state_dir,
     is_local_dev_state_dir,
 };
-pub use queue::QueueSettings;
+pub use queue::{DomainLimit, QueueSettings};
+pub use registration_rate::{format_registration_rate, parse_registration_rate, RegistrationRate};
+
+/// Recipients accepted per SMTP transaction / `/mxdeliv` POST when `max_rcpt` is unset.
+pub const DEFAULT_MAX_RCPT: usize = 100;
+
+/// Age past which `admin_audit` rows are pruned when `audit_retention` is unset (90 days).
+pub const DEFAULT_AUDIT_RETENTION: std::time::Duration =
+    std::time::Duration::from_secs(90 * 24 * 3600);
+
+/// State-dir usage (percent) that stops registrations when `disk_soft_limit` is unset.
+pub const DEFAULT_DISK_SOFT_PERCENT: f64 = 85.0;
+
+/// State-dir usage (percent) that makes storage read-only when `disk_hard_limit` is unset.
+pub const DEFAULT_DISK_HARD_PERCENT: f64 = 95.0;
+
+/// Largest message accepted above the soft limit when `disk_soft_max_message` is unset.
+pub const DEFAULT_DISK_SOFT_MAX_MESSAGE: u64 = 1024 * 1024;
+
+/// Orphaned blob bytes above which storage usage reports a warning when
+/// `storage_orphan_warning` is unset.
+pub const DEFAULT_STORAGE_ORPHAN_WARNING: u64 = 256 * 1024 * 1024;
+
+/// HTTP upload body cap (admin API, theme archives) when `upload_body_limit` is unset.
+pub const DEFAULT_UPLOAD_BODY_LIMIT: u64 = 1024 * 1024;
+
+/// Largest Sieve script when `sieve_max_size` is unset.
+pub const DEFAULT_SIEVE_MAX_SIZE: u64 = 64 * 1024;
+
+/// `POST /new` requests per second per client subnet when `reg_rate_limit` is unset.
+pub const DEFAULT_REG_RATE_LIMIT: f64 = 1.0;
+
+/// `POST /new` burst per client subnet when `reg_rate_burst` is unset.
+pub const DEFAULT_REG_RATE_BURST: u32 = 3;
+
+/// Largest accepted `proof_of_work_bits`; higher values are clamped.
+pub const MAX_PROOF_OF_WORK_BITS: u32 = 32;
+
+/// Largest accepted `recovery_codes`; higher values are clamped.
+pub const MAX_RECOVERY_CODES: u32 = 20;
+
+/// Time reference for the clock sanity check when `clock_check` is unset.
+pub const DEFAULT_CLOCK_CHECK_URL: &str = "https://www.cloudflare.com/";
+
+/// Local clock skew tolerated when `clock_skew_threshold` is unset.
+pub const DEFAULT_CLOCK_SKEW_THRESHOLD: std::time::Duration = std::time::Duration::from_secs(120);
+
+/// Days before `NotAfter` at which the TLS certificate counts as expiring when
+/// `cert_expiry_warning` is unset.
+pub const DEFAULT_CERT_EXPIRY_WARNING: std::time::Duration =
+    std::time::Duration::from_secs(14 * 86_400);
+
+/// How long `renewal_hook` may run when `renewal_hook_timeout` is unset.
+pub const DEFAULT_RENEWAL_HOOK_TIMEOUT: std::time::Duration = std::time::Duration::from_secs(300);
+
+/// In-flight work allowance on shutdown when `shutdown_drain` is unset. Kept under the
+/// installed unit's `TimeoutStopSec=7s` so phase two runs before systemd sends SIGKILL.
+pub const DEFAULT_SHUTDOWN_DRAIN: std::time::Duration = std::time::Duration::from_secs(5);
 
 /// Server configuration (static `maddy.conf` / `chatmail.toml` + derived paths).
 #[derive(Debug, Clone, Default, PartialEq)]
@@ -89,11 +162,115 @@ pub struct AppConfig {
     pub tls_mode: Option<String>,
     /// ACME contact email (`acme_email` directive / `chatmail.toml`).
     pub acme_email: Option<String>,
+    /// `acme_directory` — ACME server for autocert issuance and renewal (default Let's Encrypt).
+    pub acme_directory: Option<String>,
     /// `tls file <cert> <key>` from `maddy.conf`.
     pub tls_cert_path: Option<PathBuf>,
     pub tls_key_path: Option<PathBuf>,
     pub debug: bool,
+    /// `panic_abort` — abort the process on any panic instead of containing it to the
+    /// session or request (development fail-fast; default off).
+    pub panic_abort: bool,
+    /// `crash_dir` — where crash reports of contained panics go (default
+    /// `<state_dir>/crashes`).
+    pub crash_dir: Option<PathBuf>,
+    /// `crash_keep` — crash reports kept in `crash_dir`, oldest removed first (default 20).
+    pub crash_keep: Option<u32>,
     pub log_target: Option<String>,
+    /// `log_format` — `text` (default) or `json` (one object per line, fields as keys).
+    pub log_format: Option<String>,
+    /// `log_rotate_size` — rotate `log file:/path` targets at this size (default `50M`).
+    pub log_rotate_size: Option<String>,
+    /// `log_rotate_keep` — rotated files kept next to the live log (default 5).
+    pub log_rotate_keep: Option<u32>,
+    /// `log_rotate_compress` — gzip rotated files (default on).
+    pub log_rotate_compress: Option<bool>,
+    /// `trusted_cdn` — `cloudflare`, `none` (default), or a CIDR list; forwarded client-IP
+    /// headers are honoured only from these peers.
+    pub trusted_cdn: Option<String>,
+    /// `trusted_networks` — CIDR list never banned automatically (loopback never is either).
+    pub trusted_networks: Option<String>,
+    /// `ip_ban` — automatic IP bans from login failures and registration bursts (default on).
+    /// Manual bans are enforced either way.
+    pub ip_ban: Option<bool>,
+    /// `ip_ban_auth_failures` — failed IMAP/SMTP logins per `ip_ban_window` (default 20).
+    pub ip_ban_auth_failures: Option<u32>,
+    /// `ip_ban_registrations` — `/new` accounts per `ip_ban_window` (default 30).
+    pub ip_ban_registrations: Option<u32>,
+    /// `ip_ban_window` — sliding window for the thresholds above (default `10m`).
+    pub ip_ban_window: Option<std::time::Duration>,
+    /// `ip_ban_duration` — first automatic ban (default `15m`); doubles per repeat offence.
+    pub ip_ban_duration: Option<std::time::Duration>,
+    /// `ip_ban_max_duration` — cap on escalated bans (default `168h`).
+    pub ip_ban_max_duration: Option<std::time::Duration>,
+    /// `tls_fingerprint` — JA4 ClientHello fingerprints for logs and `ja4:` bans (default on).
+    pub tls_fingerprint: Option<bool>,
+    /// `takeout_ttl` — how long a finished `/takeout` archive waits for its single download
+    /// (default `24h`).
+    pub takeout_ttl: Option<std::time::Duration>,
+    /// `memory_budget` — `70%` (default, of cgroup/system memory), a size like `2G`, or `off`.
+    /// Past it, new message buffers, FETCH bodies and exports are refused until usage drops.
+    pub memory_budget: Option<String>,
+    /// `disk_soft_limit` — state-dir usage (default `85%`) past which registrations and
+    /// messages over `disk_soft_max_message` are refused with 452.
+    pub disk_soft_limit: Option<String>,
+    /// `disk_hard_limit` — usage (default `95%`) past which deliveries are deferred (451).
+    pub disk_hard_limit: Option<String>,
+    /// `disk_soft_max_message` — largest message accepted above the soft limit (default `1M`).
+    pub disk_soft_max_message: Option<String>,
+    /// `storage_orphan_warning` — orphaned `blobs/` bytes at which storage usage warns and
+    /// points at `imap-acct gc` (default `256M`).
+    pub storage_orphan_warning: Option<String>,
+    /// `clock_check` — HTTPS URL whose `Date` header is the time reference, or `off`.
+    /// Default [`DEFAULT_CLOCK_CHECK_URL`].
+    pub clock_check: Option<String>,
+    /// `clock_skew_threshold` — skew that counts as a problem (default `2m`).
+    pub clock_skew_threshold: Option<std::time::Duration>,
+    /// `cert_expiry_warning` — remaining validity at which the TLS certificate turns the
+    /// `tls_certificate` health component degraded and fires `renewal_hook` (default `14d`).
+    pub cert_expiry_warning: Option<std::time::Duration>,
+    /// `renewal_hook` — shell command run (as the server user) once the certificate crosses
+    /// `cert_expiry_warning`, e.g. `certbot renew --quiet`.
+    pub renewal_hook: Option<String>,
+    /// `renewal_hook_timeout` — the hook is killed after this long (default `5m`).
+    pub renewal_hook_timeout: Option<std::time::Duration>,
+    /// `shutdown_drain` — how long in-flight deliveries and IMAP commands may finish after
+    /// listeners close on shutdown (default `5s`).
+    pub shutdown_drain: Option<std::time::Duration>,
+    /// `responder_suppress` — extra sender patterns (`*` wildcard) automatic responders never
+    /// answer, on top of the built-in `mailer-daemon@*`, `*-request@*`, … list.
+    pub responder_suppress: Vec<String>,
+    /// `autoresponse_window` — an account's autoresponse answers each sender at most once per
+    /// this long (default 7 days).
+    pub autoresponse_window: Option<std::time::Duration>,
+    /// `audit_retention` — admin audit rows older than this are pruned hourly (default
+    /// [`DEFAULT_AUDIT_RETENTION`]; `0` keeps them forever).
+    pub audit_retention: Option<std::time::Duration>,
+    /// `webfinger_extra_links` — raw JSON link objects (one quoted argument each) appended to
+    /// every `/.well-known/webfinger` answer; entries that are not JSON objects are skipped.
+    pub webfinger_extra_links: Vec<String>,
+    /// `subaddress_delimiter` — separator of `user+tag@domain` subaddresses, stripped with the
+    /// tag before the account lookup (default `+`; an empty value turns subaddressing off).
+    pub subaddress_delimiter: Option<String>,
+    /// `broadcast_sender` — From address of `/admin/broadcast` announcements (default
+    /// `announcements@<primary domain>`).
+    pub broadcast_sender: Option<String>,
+    /// `broadcast_rate` — accounts per second an announcement broadcast delivers to (default
+    /// 20), so a large broadcast does not starve real mail.
+    pub broadcast_rate: Option<u32>,
+    /// `catchall_exclude` — extra local-part patterns (`*` wildcard) a domain catch-all never
+    /// receives, on top of the built-in `noreply`, `bounce*`, … list.
+    pub catchall_exclude: Vec<String>,
+    /// `peers` — base URLs of peer instances whose `/federation/info` is fetched and used as
+    /// delivery hints; more can be added at runtime with `madmail peers add`.
+    pub peers: Vec<String>,
+    /// `peer_secret` — shared HMAC keys for `/federation/info`: the first signs our document,
+    /// any of them verifies a peer's (list the old key second while rotating).
+    pub peer_secrets: Vec<String>,
+    /// `pwa_theme_color` — `theme_color` of the landing page web app manifest (`#rrggbb`).
+    pub pwa_theme_color: Option<String>,
+    /// `pwa_background_color` — splash-screen `background_color` of the manifest (`#rrggbb`).
+    pub pwa_background_color: Option<String>,
 
     /// `auth.pass_table` (JIT / auto_create).
     pub auth_auto_create: bool,
@@ -109,18 +286,82 @@ pub struct AppConfig {
     pub retention: Option<String>,
     /// `storage.imapsql unused_account_retention` — delete never-logged-in accounts (Madmail).
     pub unused_account_retention: Option<String>,
+    /// `storage.imapsql inactive_account_retention` — act on accounts with no login (or
+    /// inbound mail) for this long; see `inactive_account_action`.
+    pub inactive_account_retention: Option<String>,
+    /// `storage.imapsql inactive_account_action` — `delete` (default) or `suspend`.
+    pub inactive_account_action: Option<String>,
+    /// `storage.imapsql activity_counts_inbound` — inbound deliveries count as activity
+    /// (default `yes`).
+    pub activity_counts_inbound: Option<bool>,
+    /// `storage.imapsql db_maintenance_schedule` — `daily`, `weekly`, `monthly` or a
+    /// duration; the `db-maintenance` task runs on it (default off).
+    pub db_maintenance_schedule: Option<String>,
+    /// `storage.imapsql db_maintenance_max_deliveries` — `db-maintenance` skips itself while
+    /// more deliveries than this are in flight (default 4).
+    pub db_maintenance_max_deliveries: Option<usize>,
     pub appendlimit: Option<String>,
     /// `smtp` / `submission` `max_message_size` (e.g. `100M`).
     pub max_message_size: Option<String>,
+    /// `smtp` / `submission` `max_rcpt` — recipients per transaction (default
+    /// [`DEFAULT_MAX_RCPT`]); also caps `X-Mail-To` on `/mxdeliv`.
+    pub max_rcpt: Option<usize>,
+    /// `smtp` `greet_delay` — hold the banner this long; clients that talk first get 554.
+    /// Never applies to submission.
+    pub greet_delay: Option<std::time::Duration>,
+    /// `smtp` `greet_delay_exempt` — CIDRs (space/comma separated) that skip `greet_delay`.
+    pub greet_delay_exempt: Option<String>,
+    /// `submission` `archive` — copy every accepted outbound message to a local mailbox
+    /// (`user@domain`), a maildir (`maildir:/path`) or an SMTP target (`smtp://rcpt@host:port`).
+    pub archive: Option<String>,
+    /// `submission` `archive_fail_closed` — temp-reject submissions the archive cannot take
+    /// (default off: log and deliver anyway).
+    pub archive_fail_closed: Option<bool>,
+    /// `submission` `archive_senders` — addresses/domains (space/comma separated) whose mail
+    /// is archived (default: every sender).
+    pub archive_senders: Option<String>,
+    /// `submission` `max_messages_per_hour` — messages one account may submit per UTC hour
+    /// (default unlimited).
+    pub max_messages_per_hour: Option<u32>,
+    /// `submission` `max_rcpt_per_message` — recipients one account may name per message
+    /// (default: only `max_rcpt`).
+    pub max_rcpt_per_message: Option<usize>,
+    /// Selectors of the `dkim <domain> <domains…> <selector>` lines in the submission `modify`
+    /// block, in order; empty leaves outbound mail unsigned.
+    pub dkim_selectors: Vec<String>,
     /// `/mxdeliv` federation HTTP body cap (e.g. `70M`).
     pub max_federation_size: Option<String>,
     /// `storage.imapsql mail_fsync` — `always`, `optimized`, or `never` (Dovecot parity).
     pub mail_fsync: Option<String>,
     /// `storage.imapsql blob_dedup` — content-addressed dedup for identical payloads.
     pub blob_dedup: Option<String>,
+    /// `storage.imapsql cold_storage { path … age … compression … }` — pack old mail into
+    /// compressed per-account files (off unless the block is present).
+    pub cold_storage: ColdStorageConfig,
+    /// `storage.imapsql junk_training_url` — rspamd-compatible trainer base URL; Junk
+    /// reclassifications POST the raw message to `<url>/learnspam` or `<url>/learnham`.
+    pub junk_training_url: Option<String>,
+    /// `webhook_url` (`chatmail` or `storage.imapsql` block) — endpoint that receives account
+    /// lifecycle events as signed JSON POSTs.
+    pub webhook_url: Option<String>,
+    /// `webhook_secret` — HMAC-SHA256 key for the `X-Madmail-Signature` header; unsigned
+    /// when unset.
+    pub webhook_secret: Option<String>,
+    /// `webhook_events` — event allowlist (`account_created`, `account_deleted`,
+    /// `account_pruned`, `quota_exceeded`, `first_login`); empty sends all of them.
+    pub webhook_events: Vec<String>,
+    /// `storage.imapsql retention_keep_flagged` — retention never deletes `\Flagged`
+    /// messages (default yes).
+    pub retention_keep_flagged: Option<bool>,
+    /// `storage.imapsql retention_keep_unseen` — unread messages are kept at least this long
+    /// (duration; default: same as retention).
+    pub retention_keep_unseen: Option<String>,
 
     /// `chatmail` HTTP endpoint.
     pub mail_domain: Option<String>,
+    /// Further registration domains: `mail_domain example.org example.net` or a repeated
+    /// `mail_domain` directive. They are local domains too; the first name stays the default.
+    pub extra_mail_domains: Vec<String>,
     pub mx_domain: Option<String>,
     /// `username_length` — auto-generated localpart length (Madmail default: 8).
     pub username_length: Option<u32>,
@@ -132,15 +373,68 @@ pub struct AppConfig {
     pub max_username_length: Option<u32>,
     /// `password_min_length` — minimum password length on JIT account creation (default: 8).
     pub password_min_length: Option<u32>,
+    /// `password_charset` — alphabet for generated passwords (default lowercase + digits for
+    /// `/new`; admin-created passwords also use symbols).
+    pub password_charset: Option<String>,
+    /// `password_exclude_ambiguous` — drop `0 O o 1 I l` from generated passwords.
+    pub password_exclude_ambiguous: Option<bool>,
+    /// `username_exclude_ambiguous` — drop the same characters from generated usernames.
+    pub username_exclude_ambiguous: Option<bool>,
+    /// `reg_rate_limit` — `POST /new` requests per second per client /24 (IPv6 /64);
+    /// default [`DEFAULT_REG_RATE_LIMIT`], `0` turns the limiter off. `registration_rate 10 1h`
+    /// sets this and [`Self::reg_rate_burst`] together.
+    pub reg_rate_limit: Option<f64>,
+    /// `reg_rate_burst` — requests one subnet may make at once (default
+    /// [`DEFAULT_REG_RATE_BURST`]).
+    pub reg_rate_burst: Option<u32>,
+    /// `proof_of_work_bits` — leading zero bits a `GET /new` hashcash challenge demands
+    /// before `POST /new` creates an account (alias `registration_pow_bits`); unset or `0`
+    /// turns challenges off.
+    pub proof_of_work_bits: Option<u32>,
+    /// `recovery_codes` — single-use password recovery codes handed out with each new
+    /// account (`POST /new`, `madmail accounts create-user`); unset or `0` = none.
+    pub recovery_codes: Option<u32>,
     /// Default www UI language (`en`, `fa`, `ru`, `es`) when not set in DB.
     pub language: Option<String>,
+    /// `chatmail { upload_body_limit }` — largest HTTP upload body (admin API, theme
+    /// archives); default [`DEFAULT_UPLOAD_BODY_LIMIT`].
+    pub upload_body_limit: Option<String>,
     /// External www directory (`chatmail { www_dir ... }` / `html-serve`).
     /// Unset = default site from embedded RAM in the binary (fast; no disk reads).
     pub www_dir: Option<PathBuf>,
     /// `enable_contact_sharing` — Delta Chat `/share` pages and slug URLs.
     pub enable_contact_sharing: bool,
+    /// `allow_self_delete` — accounts may delete themselves with `DELETE /account` or
+    /// `POST /account/delete`.
+    pub allow_self_delete: bool,
+    /// `self_service` — `/account/password` and `/account/delete` (default on; `off` answers
+    /// `403` on both, whatever `allow_self_delete` says).
+    pub self_service: Option<bool>,
+    /// `sieve_store` — directory of per-account Sieve scripts (`<address>.sieve`); relative
+    /// paths are under the state dir. Unset = no filtering and no `/account/sieve`.
+    pub sieve_store: Option<PathBuf>,
+    /// `sieve_max_size` — largest Sieve script an account may store (default
+    /// [`DEFAULT_SIEVE_MAX_SIZE`]).
+    pub sieve_max_size: Option<String>,
     /// `sharing_dsn` — SQLite path for contact links (default `{state_dir}/sharing.db`).
     pub sharing_dsn: Option<String>,
+    /// `sharing_analytics` — aggregate per-link view counters (default on; `off` stops
+    /// collection and hides the counts).
+    pub sharing_analytics: Option<bool>,
+    /// `health_checks` — `/health`, `/healthz`, `/ready` and `/readyz` probe endpoints
+    /// (default on; `off` answers 404).
+    pub health_checks: Option<bool>,
+    /// `enable_metrics` — per-route HTTP, registration, Shadowsocks and delivery metrics, and
+    /// `/metrics` on the HTTP listener behind the admin token (default off).
+    pub enable_metrics: bool,
+    /// `metrics_token` — bearer token for `/metrics` instead of the admin token.
+    pub metrics_token: Option<String>,
+    /// `metrics_local_only` — answer `/metrics` to loopback peers only, without a token.
+    pub metrics_local_only: bool,
+    /// `app_*_url` / `app_android_package` — store and deep links on contact pages.
+    pub app_links: AppLinksConfig,
+    /// `federation_checks { spf … dkim … dmarc … }` — authentication checks on `/mxdeliv`.
+    pub federation_checks: FederationChecks,
     /// `admin_path` (default `/api/admin`).
     pub admin_path: Option<String>,
     /// `admin_web_path` — URL path for the embedded admin-web SPA (e.g. `/admin`).
@@ -193,8 +487,12 @@ pub struct AppConfig {
     /// `chatmail { ss_addr … ss_password … ss_cipher … }` — Shadowsocks proxy.
     pub ss_addr: Option<String>,
     pub ss_password: Option<String>,
+    /// `ss_passwords` list: extra password slots the listener also accepts (rotation).
+    pub ss_passwords: Vec<String>,
     /// Default `aes-128-gcm` when SS is enabled.
     pub ss_cipher: Option<String>,
+    /// `ss_protocol legacy|2022`; unset is `legacy`.
+    pub ss_protocol: Option<String>,
     pub ss_cert_path: Option<PathBuf>,
     pub ss_key_path: Option<PathBuf>,
     /// `ss_allowed_ports` list; empty = Madmail defaults + discovered mail ports.
@@ -214,10 +512,157 @@ impl AppConfig {
         state_dir.join("sharing.db")
     }
 
-    /// Shadowsocks is configured in `maddy.conf` (`ss_addr` + `ss_password`).
+    /// Per-link view counters: contact sharing on and `sharing_analytics` not `off`.
+    pub fn sharing_analytics_enabled(&self) -> bool {
+        self.enable_contact_sharing && self.sharing_analytics.unwrap_or(true)
+    }
+
+    /// `self_service`, default on.
+    pub fn self_service_enabled(&self) -> bool {
+        self.self_service.unwrap_or(true)
+    }
+
+    /// Self-deletion: `self_service` on and `allow_self_delete` set.
+    pub fn self_delete_enabled(&self) -> bool {
+        self.self_service_enabled() && self.allow_self_delete
+    }
+
+    /// `health_checks`, default on.
+    pub fn health_checks_enabled(&self) -> bool {
+        self.health_checks.unwrap_or(true)
+    }
+
+    /// Shadowsocks is configured in `maddy.conf` (`ss_addr` + `ss_password` or
+    /// `ss_passwords`).
     pub fn ss_configured(&self) -> bool {
         self.ss_addr.as_ref().is_some_and(|s| !s.is_empty())
-            && self.ss_password.as_ref().is_some_and(|s| !s.is_empty())
+            && (self.ss_password.as_ref().is_some_and(|s| !s.is_empty())
+                || !self.ss_passwords.is_empty())
+    }
+
+    /// Primary Shadowsocks password from `maddy.conf`: `ss_password`, else the first of
+    /// `ss_passwords`.
+    pub fn ss_primary_password(&self) -> &str {
+        self.ss_password
+            .as_deref()
+            .filter(|s| !s.is_empty())
+            .or(self.ss_passwords.first().map(String::as_str))
+            .unwrap_or("")
+    }
+
+    /// `retention_keep_flagged`, default on.
+    pub fn effective_retention_keep_flagged(&self) -> bool {
+        self.retention_keep_flagged.unwrap_or(true)
+    }
+
+    /// No-Log policy: `log` omitted or `off` and `debug` unset. The admin audit trail is not
+    /// written either then.
+    pub fn logging_off(&self) -> bool {
+        !self.debug
+            && self
+                .log_target
+                .as_deref()
+                .map(str::trim)
+                .map_or(true, |target| {
+                    target.is_empty() || target.eq_ignore_ascii_case("off")
+                })
+    }
+
+    /// `audit_retention` or [`DEFAULT_AUDIT_RETENTION`]; `None` when set to `0` (keep all).
+    pub fn effective_audit_retention(&self) -> Option<std::time::Duration> {
+        match self.audit_retention {
+            Some(d) if d.is_zero() => None,
+            Some(d) => Some(d),
+            None => Some(DEFAULT_AUDIT_RETENTION),
+        }
+    }
+
+    /// `max_rcpt` or [`DEFAULT_MAX_RCPT`].
+    pub fn effective_max_rcpt(&self) -> usize {
+        self.max_rcpt.unwrap_or(DEFAULT_MAX_RCPT)
+    }
+
+    /// `disk_soft_limit` as a percentage, or [`DEFAULT_DISK_SOFT_PERCENT`].
+    pub fn effective_disk_soft_percent(&self) -> f64 {
+        parse_percent(self.disk_soft_limit.as_deref()).unwrap_or(DEFAULT_DISK_SOFT_PERCENT)
+    }
+
+    /// `disk_hard_limit` as a percentage, or [`DEFAULT_DISK_HARD_PERCENT`].
+    pub fn effective_disk_hard_percent(&self) -> f64 {
+        parse_percent(self.disk_hard_limit.as_deref()).unwrap_or(DEFAULT_DISK_HARD_PERCENT)
+    }
+
+    /// `disk_soft_max_message` in bytes, or [`DEFAULT_DISK_SOFT_MAX_MESSAGE`].
+    pub fn effective_disk_soft_max_message(&self) -> u64 {
+        self.disk_soft_max_message
+            .as_deref()
+            .and_then(|v| parse_data_size(v).ok())
+            .unwrap_or(DEFAULT_DISK_SOFT_MAX_MESSAGE)
+    }
+
+    /// `storage_orphan_warning` in bytes, or [`DEFAULT_STORAGE_ORPHAN_WARNING`].
+    pub fn effective_storage_orphan_warning(&self) -> u64 {
+        self.storage_orphan_warning
+            .as_deref()
+            .and_then(|v| parse_data_size(v).ok())
+            .unwrap_or(DEFAULT_STORAGE_ORPHAN_WARNING)
+    }
+
+    /// `upload_body_limit` in bytes, or [`DEFAULT_UPLOAD_BODY_LIMIT`].
+    pub fn effective_upload_body_limit(&self) -> u64 {
+        self.upload_body_limit
+            .as_deref()
+            .and_then(|v| parse_data_size(v).ok())
+            .unwrap_or(DEFAULT_UPLOAD_BODY_LIMIT)
+    }
+
+    /// `sieve_max_size` in bytes, or [`DEFAULT_SIEVE_MAX_SIZE`].
+    pub fn effective_sieve_max_size(&self) -> u64 {
+        self.sieve_max_size
+            .as_deref()
+            .and_then(|v| parse_data_size(v).ok())
+            .unwrap_or(DEFAULT_SIEVE_MAX_SIZE)
+    }
+
+    /// `clock_check` URL, [`DEFAULT_CLOCK_CHECK_URL`], or `None` when set to `off`.
+    pub fn effective_clock_check(&self) -> Option<String> {
+        match self.clock_check.as_deref().map(str::trim) {
+            None | Some("") => Some(DEFAULT_CLOCK_CHECK_URL.to_string()),
+            Some(v) if v.eq_ignore_ascii_case("off") || v.eq_ignore_ascii_case("no") => None,
+            Some(v) => Some(v.to_string()),
+        }
+    }
+
+    /// `clock_skew_threshold` or [`DEFAULT_CLOCK_SKEW_THRESHOLD`].
+    pub fn effective_clock_skew_threshold(&self) -> std::time::Duration {
+        self.clock_skew_threshold
+            .unwrap_or(DEFAULT_CLOCK_SKEW_THRESHOLD)
+    }
+
+    /// `cert_expiry_warning` or [`DEFAULT_CERT_EXPIRY_WARNING`].
+    pub fn effective_cert_expiry_warning(&self) -> std::time::Duration {
+        self.cert_expiry_warning
+            .unwrap_or(DEFAULT_CERT_EXPIRY_WARNING)
+    }
+
+    /// `renewal_hook` command, `None` when unset or `off`.
+    pub fn effective_renewal_hook(&self) -> Option<String> {
+        match self.renewal_hook.as_deref().map(str::trim) {
+            None | Some("") => None,
+            Some(v) if v.eq_ignore_ascii_case("off") => None,
+            Some(v) => Some(v.to_string()),
+        }
+    }
+
+    /// `renewal_hook_timeout` or [`DEFAULT_RENEWAL_HOOK_TIMEOUT`].
+    pub fn effective_renewal_hook_timeout(&self) -> std::time::Duration {
+        self.renewal_hook_timeout
+            .unwrap_or(DEFAULT_RENEWAL_HOOK_TIMEOUT)
+    }
+
+    /// `shutdown_drain` or [`DEFAULT_SHUTDOWN_DRAIN`].
+    pub fn effective_shutdown_drain(&self) -> std::time::Duration {
+        self.shutdown_drain.unwrap_or(DEFAULT_SHUTDOWN_DRAIN)
     }
 
     /// Canonical `$(primary_domain)` (IPs as `[x.x.x.x]`).
@@ -226,10 +671,30 @@ impl AppConfig {
         chatmail_types::wrap_ip_domain(raw)
     }
 
-    /// All domains this server accepts locally (`$(local_domains)` + bracket/bare IP aliases).
+    /// All domains this server accepts locally (`$(local_domains)` + bracket/bare IP aliases),
+    /// including [`extra_mail_domains`](Self::extra_mail_domains).
     pub fn effective_local_domains(&self, hostname_fallback: &str) -> Vec<String> {
         let primary = self.effective_primary_domain(hostname_fallback);
-        chatmail_types::build_local_domains(&primary, self.local_domains.as_deref())
+        let mut local = self.local_domains.clone().unwrap_or_default();
+        for extra in &self.extra_mail_domains {
+            local.push(' ');
+            local.push_str(extra);
+        }
+        chatmail_types::build_local_domains(&primary, Some(local.as_str()))
+    }
+
+    /// Domains `/new` registers accounts under: the default
+    /// ([`effective_registration_domain`](Self::effective_registration_domain)) first, then
+    /// `extra_mail_domains` in config order.
+    pub fn mail_domains(&self, http_host: Option<&str>) -> Vec<String> {
+        let mut domains = vec![self.effective_registration_domain(http_host)];
+        for extra in &self.extra_mail_domains {
+            let extra = chatmail_types::wrap_ip_domain(extra);
+            if !extra.is_empty() && !domains.iter().any(|d| d.eq_ignore_ascii_case(&extra)) {
+                domains.push(extra);
+            }
+        }
+        domains
     }
 
     /// Domain for new accounts / dclogin (`user@domain` or `user@[1.2.3.4]`).
@@ -255,14 +720,25 @@ impl AppConfig {
         chatmail_types::wrap_ip_domain(fallback)
     }
 
-    /// JIT / login domain restriction (`auth.pass_table` `jit_domain`).
+    /// JIT / login domain restriction (`auth.pass_table` `jit_domain`), plus every extra mail
+    /// domain so their accounts can log in; several domains are separated by spaces.
     pub fn effective_jit_domain(&self, hostname_fallback: &str) -> Option<String> {
         let raw = self
             .jit_domain
             .as_deref()
             .or(self.primary_domain.as_deref())
             .unwrap_or(hostname_fallback);
-        Some(chatmail_types::wrap_ip_domain(raw))
+        let mut domains: Vec<String> = raw
+            .split_whitespace()
+            .map(chatmail_types::wrap_ip_domain)
+            .collect();
+        for extra in &self.extra_mail_domains {
+            let extra = chatmail_types::wrap_ip_domain(extra);
+            if !domains.iter().any(|d| d.eq_ignore_ascii_case(&extra)) {
+                domains.push(extra);
+            }
+        }
+        Some(domains.join(" "))
     }
 
     /// Effective SMTP submission/plain listen for madmail-v2 dev server.
@@ -346,6 +822,12 @@ pub fn default_state_dir() -> PathBuf {
     detect_default_state_dir()
 }
 
+/// `85%` or `85` → 85.0; `None` outside 1–100.
+fn parse_percent(raw: Option<&str>) -> Option<f64> {
+    let pct: f64 = raw?.trim().trim_end_matches('%').trim().parse().ok()?;
+    (pct > 0.0 && pct <= 100.0).then_some(pct)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -360,6 +842,32 @@ mod tests {
         assert_eq!(resolve_state_dir(cli, &cfg), PathBuf::from("/from/config"));
     }
 
+    #[test]
+    fn disk_limits_parse_percent_and_size() {
+        let cfg = AppConfig::default();
+        assert_eq!(cfg.effective_disk_soft_percent(), DEFAULT_DISK_SOFT_PERCENT);
+        assert_eq!(cfg.effective_disk_hard_percent(), DEFAULT_DISK_HARD_PERCENT);
+        assert_eq!(cfg.effective_disk_soft_max_message(), 1024 * 1024);
+        let cfg = AppConfig {
+            disk_soft_limit: Some("80%".into()),
+            disk_hard_limit: Some("150%".into()),
+            disk_soft_max_message: Some("512K".into()),
+            ..Default::default()
+        };
+        assert_eq!(cfg.effective_disk_soft_percent(), 80.0);
+        assert_eq!(cfg.effective_disk_hard_percent(), DEFAULT_DISK_HARD_PERCENT);
+        assert_eq!(cfg.effective_disk_soft_max_message(), 512 * 1024);
+        assert_eq!(
+            AppConfig::default().effective_storage_orphan_warning(),
+            DEFAULT_STORAGE_ORPHAN_WARNING
+        );
+        let cfg = AppConfig {
+            storage_orphan_warning: Some("1G".into()),
+            ..Default::default()
+        };
+        assert_eq!(cfg.effective_storage_orphan_warning(), 1024 * 1024 * 1024);
+    }
+
     #[test]
     fn registration_domain_primary_wins_over_http_host() {
         let cfg = AppConfig {
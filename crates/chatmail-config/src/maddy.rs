@@ -88,6 +88,9 @@ fn walk_nodes(nodes: &[Node], block_path: &[&str], cfg: &mut AppConfig) {
         if let Some(children) = node.children.as_ref() {
             let mut path: Vec<&str> = block_path.to_vec();
             path.push(node.name.as_str());
+            if node.name == "cold_storage" && in_block(block_path, "storage.imapsql") {
+                cfg.cold_storage.enabled = true;
+            }
             apply_endpoint_block(node, cfg);
             walk_nodes(children, &path, cfg);
             continue;
@@ -194,7 +197,85 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
             "state_dir" if has_value => cfg.state_dir = Some(value.clone().into()),
             "runtime_dir" if has_value => cfg.runtime_dir = Some(value.clone().into()),
             "debug" => cfg.debug = parse_bool(arg0),
+            "panic_abort" => cfg.panic_abort = parse_bool(arg0),
+            "crash_dir" if has_value => cfg.crash_dir = Some(value.clone().into()),
+            "crash_keep" if has_value => cfg.crash_keep = arg0.parse().ok().filter(|k| *k > 0),
             "log" if has_value => cfg.log_target = Some(value.clone()),
+            "log_format" if has_value => cfg.log_format = Some(value.clone()),
+            "log_rotate_size" if has_value => cfg.log_rotate_size = Some(value.clone()),
+            "log_rotate_keep" if has_value => {
+                cfg.log_rotate_keep = arg0.parse().ok();
+            }
+            "log_rotate_compress" => cfg.log_rotate_compress = Some(parse_bool(arg0)),
+            "trusted_cdn" if has_value => cfg.trusted_cdn = Some(value.clone()),
+            "trusted_networks" if has_value => cfg.trusted_networks = Some(value.clone()),
+            "ip_ban" => cfg.ip_ban = Some(parse_bool(arg0)),
+            "ip_ban_auth_failures" if has_value => cfg.ip_ban_auth_failures = arg0.parse().ok(),
+            "ip_ban_registrations" if has_value => cfg.ip_ban_registrations = arg0.parse().ok(),
+            "ip_ban_window" if has_value => {
+                cfg.ip_ban_window = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "ip_ban_duration" if has_value => {
+                cfg.ip_ban_duration = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "ip_ban_max_duration" if has_value => {
+                cfg.ip_ban_max_duration = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "tls_fingerprint" => cfg.tls_fingerprint = Some(parse_bool(arg0)),
+            "takeout_ttl" if has_value => {
+                cfg.takeout_ttl = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "memory_budget" if has_value => cfg.memory_budget = Some(value.clone()),
+            "disk_soft_limit" if has_value => cfg.disk_soft_limit = Some(arg0.to_string()),
+            "disk_hard_limit" if has_value => cfg.disk_hard_limit = Some(arg0.to_string()),
+            "disk_soft_max_message" if has_value => {
+                cfg.disk_soft_max_message = Some(arg0.to_string());
+            }
+            "storage_orphan_warning" if has_value => {
+                cfg.storage_orphan_warning = Some(arg0.to_string());
+            }
+            "clock_check" if has_value => cfg.clock_check = Some(arg0.to_string()),
+            "clock_skew_threshold" if has_value => {
+                cfg.clock_skew_threshold = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "cert_expiry_warning" if has_value => {
+                cfg.cert_expiry_warning = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "renewal_hook" if has_value => cfg.renewal_hook = Some(value.clone()),
+            "renewal_hook_timeout" if has_value => {
+                cfg.renewal_hook_timeout = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "shutdown_drain" if has_value => {
+                cfg.shutdown_drain = match arg0 {
+                    "0" => Some(std::time::Duration::ZERO),
+                    v => parse_duration(v).ok(),
+                };
+            }
+            "responder_suppress" => cfg.responder_suppress.extend(args.iter().cloned()),
+            "webfinger_extra_links" => cfg.webfinger_extra_links.extend(args.iter().cloned()),
+            "autoresponse_window" if has_value => {
+                cfg.autoresponse_window = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "audit_retention" if has_value => {
+                cfg.audit_retention = match arg0.trim() {
+                    "0" => Some(std::time::Duration::ZERO),
+                    other => parse_duration(other).ok(),
+                };
+            }
+            "subaddress_delimiter" => cfg.subaddress_delimiter = Some(arg0.to_string()),
+            "broadcast_sender" if has_value => cfg.broadcast_sender = Some(arg0.to_string()),
+            "broadcast_rate" if has_value => {
+                cfg.broadcast_rate = arg0.parse().ok().filter(|n| *n > 0);
+            }
+            "catchall_exclude" => cfg.catchall_exclude.extend(args.iter().cloned()),
+            "peers" => cfg.peers.extend(args.iter().cloned()),
+            "peer_secret" => cfg
+                .peer_secrets
+                .extend(args.iter().map(|a| strip_quotes(a))),
+            "pwa_theme_color" if has_value => cfg.pwa_theme_color = Some(arg0.to_string()),
+            "pwa_background_color" if has_value => {
+                cfg.pwa_background_color = Some(arg0.to_string());
+            }
             "max_federation_size" if has_value => {
                 cfg.max_federation_size = Some(value.clone());
             }
@@ -203,6 +284,7 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
             }
             "tls_mode" if has_value => cfg.tls_mode = Some(arg0.to_string()),
             "acme_email" if has_value => cfg.acme_email = Some(value.clone()),
+            "acme_directory" if has_value => cfg.acme_directory = Some(strip_quotes(&value)),
             "tls" if arg0 == "file" => {
                 if cfg.tls_mode.is_none() {
                     cfg.tls_mode = Some("file".into());
@@ -226,6 +308,11 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
         }
     }
 
+    if in_block(block_path, "cold_storage") {
+        cfg.cold_storage.apply_directive(name, &value);
+        return;
+    }
+
     if in_block(block_path, "storage.imapsql") {
         match name {
             "driver" if has_value => cfg.imapsql_driver = Some(value.clone()),
@@ -235,9 +322,38 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
             "unused_account_retention" if has_value => {
                 cfg.unused_account_retention = Some(value.clone());
             }
+            "inactive_account_retention" if has_value => {
+                cfg.inactive_account_retention = Some(value.clone());
+            }
+            "inactive_account_action" if has_value => {
+                cfg.inactive_account_action = Some(arg0.to_ascii_lowercase());
+            }
+            "activity_counts_inbound" if has_value => {
+                cfg.activity_counts_inbound = Some(parse_bool(arg0));
+            }
+            "db_maintenance_schedule" if has_value => {
+                cfg.db_maintenance_schedule = Some(arg0.to_ascii_lowercase());
+            }
+            "db_maintenance_max_deliveries" if has_value => {
+                if let Ok(n) = arg0.parse::<usize>() {
+                    cfg.db_maintenance_max_deliveries = Some(n);
+                }
+            }
             "appendlimit" if has_value => cfg.appendlimit = Some(value.clone()),
             "mail_fsync" if has_value => cfg.mail_fsync = Some(value.clone()),
             "blob_dedup" if has_value => cfg.blob_dedup = Some(value.clone()),
+            "junk_training_url" if has_value => {
+                cfg.junk_training_url = Some(strip_quotes(&value));
+            }
+            "webhook_url" | "webhook_secret" | "webhook_events" => {
+                apply_webhook_directive(name, args, cfg);
+            }
+            "retention_keep_flagged" if has_value => {
+                cfg.retention_keep_flagged = Some(parse_bool(arg0));
+            }
+            "retention_keep_unseen" if has_value => {
+                cfg.retention_keep_unseen = Some(value.clone());
+            }
             _ => {}
         }
     }
@@ -249,6 +365,43 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
         cfg.max_message_size = Some(value.clone());
     }
 
+    if (in_block(block_path, "smtp") || in_block(block_path, "submission")) && name == "max_rcpt" {
+        if let Some(n) = arg0.parse::<usize>().ok().filter(|n| *n > 0) {
+            cfg.max_rcpt = Some(n);
+        }
+    }
+
+    if in_block(block_path, "smtp") {
+        match name {
+            "greet_delay" if has_value => {
+                cfg.greet_delay = parse_duration(arg0).ok().filter(|d| !d.is_zero());
+            }
+            "greet_delay_exempt" if has_value => cfg.greet_delay_exempt = Some(value.clone()),
+            _ => {}
+        }
+    }
+
+    if in_block(block_path, "submission") {
+        match name {
+            "archive" if has_value => cfg.archive = Some(arg0.to_string()),
+            "archive_fail_closed" => cfg.archive_fail_closed = Some(parse_bool(arg0)),
+            "archive_senders" if has_value => cfg.archive_senders = Some(value.clone()),
+            "max_messages_per_hour" if has_value => {
+                cfg.max_messages_per_hour = arg0.parse::<u32>().ok().filter(|n| *n > 0);
+            }
+            "max_rcpt_per_message" if has_value => {
+                cfg.max_rcpt_per_message = arg0.parse::<usize>().ok().filter(|n| *n > 0);
+            }
+            "dkim" if in_block(block_path, "modify") && args.len() >= 2 => {
+                let selector = &args[args.len() - 1];
+                if !cfg.dkim_selectors.contains(selector) {
+                    cfg.dkim_selectors.push(selector.clone());
+                }
+            }
+            _ => {}
+        }
+    }
+
     if in_block(block_path, "target.queue") {
         match name {
             "max_tries" if has_value => {
@@ -284,6 +437,21 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
                     cfg.queue.max_delivery_secs = d.as_secs().max(1);
                 }
             }
+            "max_parallel_per_domain" if has_value => {
+                if let Ok(n) = arg0.parse::<u32>() {
+                    cfg.queue.max_parallel_per_domain = n.max(1);
+                }
+            }
+            "max_messages_per_minute_per_domain" if has_value => {
+                if let Ok(n) = arg0.parse::<u32>() {
+                    cfg.queue.max_messages_per_minute_per_domain = n.max(1);
+                }
+            }
+            "domain_limit" => {
+                if let Some(limit) = crate::DomainLimit::parse(args) {
+                    cfg.queue.domain_limits.push(limit);
+                }
+            }
             _ => {}
         }
     }
@@ -335,15 +503,35 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
         }
     }
 
+    if in_block(block_path, "federation_checks") {
+        cfg.federation_checks.apply_directive(name, arg0);
+        return;
+    }
+
     if in_block(block_path, "chatmail") {
         match name {
             "mail_domain" if has_value => {
-                cfg.mail_domain = Some(value.clone());
-                if cfg.primary_domain.is_none() {
-                    cfg.primary_domain = Some(value.clone());
+                for domain in args.iter().map(|a| strip_quotes(a)) {
+                    let Some(first) = cfg.mail_domain.as_deref() else {
+                        if cfg.primary_domain.is_none() {
+                            cfg.primary_domain = Some(domain.clone());
+                        }
+                        cfg.mail_domain = Some(domain);
+                        continue;
+                    };
+                    let known = |d: &String| d.eq_ignore_ascii_case(&domain);
+                    if !first.eq_ignore_ascii_case(&domain)
+                        && !cfg.extra_mail_domains.iter().any(known)
+                    {
+                        cfg.extra_mail_domains.push(domain);
+                    }
                 }
             }
             "mx_domain" if has_value => cfg.mx_domain = Some(value.clone()),
+            "log_format" if has_value => cfg.log_format = Some(value.clone()),
+            "webhook_url" | "webhook_secret" | "webhook_events" => {
+                apply_webhook_directive(name, args, cfg);
+            }
             "public_ip" if has_value => cfg.public_ip = Some(value.clone()),
             "admin_path" if has_value => cfg.admin_path = Some(value.clone()),
             "admin_web_path" if has_value => cfg.admin_web_path = Some(value.clone()),
@@ -352,10 +540,25 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
             "www_dir" if has_value => {
                 cfg.www_dir = Some(PathBuf::from(strip_quotes(&value)));
             }
+            "upload_body_limit" if has_value => cfg.upload_body_limit = Some(value.clone()),
             "enable_contact_sharing" => cfg.enable_contact_sharing = parse_bool(arg0),
+            "allow_self_delete" => cfg.allow_self_delete = parse_bool(arg0),
+            "self_service" => cfg.self_service = Some(parse_bool(arg0)),
+            "sieve_store" if has_value => {
+                cfg.sieve_store = Some(PathBuf::from(strip_quotes(&value)));
+            }
+            "sieve_max_size" if has_value => cfg.sieve_max_size = Some(value.clone()),
+            "sharing_analytics" => cfg.sharing_analytics = Some(parse_bool(arg0)),
+            "health_checks" => cfg.health_checks = Some(parse_bool(arg0)),
+            "enable_metrics" => cfg.enable_metrics = parse_bool(arg0),
+            "metrics_token" if has_value => cfg.metrics_token = Some(strip_quotes(&value)),
+            "metrics_local_only" => cfg.metrics_local_only = parse_bool(arg0),
             "sharing_dsn" if has_value => {
                 cfg.sharing_dsn = Some(strip_quotes(&value));
             }
+            n if n.starts_with("app_") && has_value => {
+                cfg.app_links.apply_directive(n, &strip_quotes(&value));
+            }
             "username_length" if has_value => {
                 if let Ok(n) = arg0.parse::<u32>() {
                     cfg.username_length = Some(n);
@@ -381,9 +584,56 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
                     cfg.password_min_length = Some(n);
                 }
             }
+            "password_charset" if has_value => {
+                cfg.password_charset = Some(strip_quotes(&value));
+            }
+            "password_exclude_ambiguous" => {
+                cfg.password_exclude_ambiguous = Some(parse_bool(arg0));
+            }
+            "username_exclude_ambiguous" => {
+                cfg.username_exclude_ambiguous = Some(parse_bool(arg0));
+            }
+            "reg_rate_limit" if has_value => {
+                cfg.reg_rate_limit = arg0
+                    .parse::<f64>()
+                    .ok()
+                    .filter(|r| r.is_finite() && *r >= 0.0);
+            }
+            "reg_rate_burst" if has_value => {
+                cfg.reg_rate_burst = arg0.parse::<u32>().ok().filter(|n| *n > 0);
+            }
+            "registration_rate" if has_value => {
+                if let Some(rate) = crate::parse_registration_rate(&value) {
+                    cfg.reg_rate_limit = Some(rate.per_second);
+                    if let Some(burst) = rate.burst {
+                        cfg.reg_rate_burst = Some(burst);
+                    }
+                }
+            }
+            "proof_of_work_bits" | "registration_pow_bits" if has_value => {
+                cfg.proof_of_work_bits = arg0
+                    .parse::<u32>()
+                    .ok()
+                    .map(|n| n.min(crate::MAX_PROOF_OF_WORK_BITS));
+            }
+            "recovery_codes" if has_value => {
+                cfg.recovery_codes = arg0
+                    .parse::<u32>()
+                    .ok()
+                    .map(|n| n.min(crate::MAX_RECOVERY_CODES));
+            }
             "ss_addr" if has_value => cfg.ss_addr = Some(strip_quotes(&value)),
             "ss_password" if has_value => cfg.ss_password = Some(strip_quotes(&value)),
+            "ss_passwords" => {
+                for p in args {
+                    let p = strip_quotes(p);
+                    if !p.is_empty() && !cfg.ss_passwords.contains(&p) {
+                        cfg.ss_passwords.push(p);
+                    }
+                }
+            }
             "ss_cipher" if has_value => cfg.ss_cipher = Some(strip_quotes(&value)),
+            "ss_protocol" if has_value => cfg.ss_protocol = Some(strip_quotes(&value)),
             "ss_cert" if has_value => cfg.ss_cert_path = Some(strip_quotes(&value).into()),
             "ss_key" if has_value => cfg.ss_key_path = Some(strip_quotes(&value).into()),
             "ss_allowed_ports" => {
@@ -399,6 +649,23 @@ fn apply_directive(name: &str, args: &[String], block_path: &[&str], cfg: &mut A
     }
 }
 
+/// `webhook_*` is accepted in both the `chatmail` and the `storage.imapsql` block.
+fn apply_webhook_directive(name: &str, args: &[String], cfg: &mut AppConfig) {
+    let Some(arg0) = args.first() else {
+        return;
+    };
+    match name {
+        "webhook_url" => cfg.webhook_url = Some(strip_quotes(arg0)),
+        "webhook_secret" => cfg.webhook_secret = Some(strip_quotes(arg0)),
+        _ => cfg.webhook_events.extend(
+            args.iter()
+                .flat_map(|a| a.split(','))
+                .map(|e| e.trim().to_ascii_lowercase())
+                .filter(|e| !e.is_empty()),
+        ),
+    }
+}
+
 fn strip_quotes(s: &str) -> String {
     let s = s.trim();
     if (s.starts_with('"') && s.ends_with('"')) || (s.starts_with('\'') && s.ends_with('\'')) {
@@ -567,6 +834,8 @@ target.queue remote_queue {
     max_parallelism 4
     initial_retry 30m
     max_delivery_time 5m
+    max_parallel_per_domain 3
+    domain_limit gmail.com 2 20
 }
 "#;
         let cfg = parse_maddy_config(content).expect("parse");
@@ -594,6 +863,10 @@ target.queue remote_queue {
         assert_eq!(cfg.queue.max_parallelism, 4);
         assert_eq!(cfg.queue.initial_retry_secs, 30 * 60);
         assert_eq!(cfg.queue.max_delivery_secs, 5 * 60);
+        assert_eq!(cfg.queue.max_parallel_per_domain, 3);
+        assert_eq!(cfg.queue.max_messages_per_minute_per_domain, 60);
+        assert_eq!(cfg.queue.domain_limits.len(), 1);
+        assert_eq!(cfg.queue.domain_limits[0].domain, "gmail.com");
         assert_eq!(cfg.username_length, Some(8));
         assert_eq!(cfg.password_length, Some(16));
         assert_eq!(cfg.min_username_length, Some(8));
@@ -629,6 +902,32 @@ chatmail tcp://0.0.0.0:80 {
         assert_eq!(p.password_min_length, 9);
     }
 
+    #[test]
+    fn mail_domain_accepts_several_domains() {
+        let content = r#"
+chatmail tcp://0.0.0.0:80 {
+    mail_domain example.org example.net
+}
+chatmail tls://0.0.0.0:443 {
+    mail_domain example.org
+    mail_domain example.com
+}
+"#;
+        let cfg = parse_maddy_config(content).unwrap();
+        assert_eq!(cfg.mail_domain.as_deref(), Some("example.org"));
+        assert_eq!(cfg.extra_mail_domains, ["example.net", "example.com"]);
+        assert_eq!(
+            cfg.mail_domains(Some("other.host")),
+            ["example.org", "example.net", "example.com"]
+        );
+        let local = cfg.effective_local_domains("example.org");
+        assert!(local.iter().any(|d| d == "example.com"), "{local:?}");
+        assert_eq!(
+            cfg.effective_jit_domain("example.org").as_deref(),
+            Some("example.org example.net example.com")
+        );
+    }
+
     #[test]
     fn parse_bool_accepts_madmail_values() {
         let content = "auth.pass_table db {\nauto_create on\n}\n";
@@ -717,11 +1016,16 @@ auth.pass_table local_authdb {
         let content = r#"
 tls_mode autocert
 acme_email admin@example.org
+acme_directory https://ca.internal/acme/directory
 tls file /var/lib/madmail/certs/fullchain.pem /var/lib/madmail/certs/privkey.pem
 "#;
         let cfg = parse_maddy_config(content).unwrap();
         assert_eq!(cfg.tls_mode.as_deref(), Some("autocert"));
         assert_eq!(cfg.acme_email.as_deref(), Some("admin@example.org"));
+        assert_eq!(
+            cfg.acme_directory.as_deref(),
+            Some("https://ca.internal/acme/directory")
+        );
     }
 
     #[test]
@@ -799,4 +1103,546 @@ turn {
         assert_eq!(cfg.mail_fsync.as_deref(), Some("optimized"));
         assert_eq!(cfg.blob_dedup.as_deref(), Some("on"));
     }
+
+    #[test]
+    fn parses_max_rcpt() {
+        let cfg = parse_maddy_config("smtp tcp://0.0.0.0:25 {\n    max_rcpt 250\n}\n").unwrap();
+        assert_eq!(cfg.max_rcpt, Some(250));
+        assert_eq!(cfg.effective_max_rcpt(), 250);
+        let cfg =
+            parse_maddy_config("submission tcp://0.0.0.0:587 {\n    max_rcpt 0\n}\n").unwrap();
+        assert_eq!(cfg.max_rcpt, None);
+        assert_eq!(cfg.effective_max_rcpt(), crate::DEFAULT_MAX_RCPT);
+    }
+
+    #[test]
+    fn parses_greet_delay_in_smtp_block_only() {
+        let cfg = parse_maddy_config(
+            "smtp tcp://0.0.0.0:25 {\n    greet_delay 3s\n    greet_delay_exempt 10.0.0.0/8, 192.0.2.1\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.greet_delay, Some(std::time::Duration::from_secs(3)));
+        assert_eq!(
+            cfg.greet_delay_exempt.as_deref(),
+            Some("10.0.0.0/8, 192.0.2.1")
+        );
+        let cfg =
+            parse_maddy_config("submission tcp://0.0.0.0:587 {\n    greet_delay 3s\n}\n").unwrap();
+        assert_eq!(cfg.greet_delay, None);
+        let cfg = parse_maddy_config("smtp tcp://0.0.0.0:25 {\n    greet_delay 0\n}\n").unwrap();
+        assert_eq!(cfg.greet_delay, None);
+    }
+
+    #[test]
+    fn parses_archive_in_submission_block_only() {
+        let cfg = parse_maddy_config(
+            "submission tcp://0.0.0.0:587 {\n    archive archive@example.org\n    archive_fail_closed yes\n    archive_senders boss@example.org, example.net\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.archive.as_deref(), Some("archive@example.org"));
+        assert_eq!(cfg.archive_fail_closed, Some(true));
+        assert_eq!(
+            cfg.archive_senders.as_deref(),
+            Some("boss@example.org, example.net")
+        );
+        let cfg =
+            parse_maddy_config("smtp tcp://0.0.0.0:25 {\n    archive archive@example.org\n}\n")
+                .unwrap();
+        assert_eq!(cfg.archive, None);
+    }
+
+    #[test]
+    fn parses_sharing_analytics_switch() {
+        let cfg = parse_maddy_config("enable_contact_sharing yes\n").unwrap();
+        assert!(cfg.sharing_analytics_enabled());
+        let cfg =
+            parse_maddy_config("enable_contact_sharing yes\nsharing_analytics off\n").unwrap();
+        assert_eq!(cfg.sharing_analytics, Some(false));
+        assert!(!cfg.sharing_analytics_enabled());
+        let cfg = parse_maddy_config("sharing_analytics on\n").unwrap();
+        assert!(!cfg.sharing_analytics_enabled());
+    }
+
+    #[test]
+    fn parses_self_service_switch() {
+        let cfg = parse_maddy_config("allow_self_delete yes\n").unwrap();
+        assert!(cfg.self_service_enabled());
+        assert!(cfg.self_delete_enabled());
+        let cfg = parse_maddy_config("allow_self_delete yes\nself_service off\n").unwrap();
+        assert_eq!(cfg.self_service, Some(false));
+        assert!(!cfg.self_delete_enabled());
+        assert!(!parse_maddy_config("").unwrap().self_delete_enabled());
+    }
+
+    #[test]
+    fn parses_sieve_store() {
+        let cfg = parse_maddy_config("").unwrap();
+        assert!(cfg.sieve_store.is_none());
+        assert_eq!(
+            cfg.effective_sieve_max_size(),
+            crate::DEFAULT_SIEVE_MAX_SIZE
+        );
+        let cfg = parse_maddy_config("sieve_store sieve\nsieve_max_size 8K\n").unwrap();
+        assert_eq!(cfg.sieve_store.as_deref(), Some(Path::new("sieve")));
+        assert_eq!(cfg.effective_sieve_max_size(), 8 * 1024);
+    }
+
+    #[test]
+    fn parses_health_checks_switch() {
+        assert!(parse_maddy_config("").unwrap().health_checks_enabled());
+        let cfg = parse_maddy_config("health_checks off\n").unwrap();
+        assert!(!cfg.health_checks_enabled());
+    }
+
+    #[test]
+    fn parses_enable_metrics() {
+        assert!(!parse_maddy_config("").unwrap().enable_metrics);
+        assert!(
+            parse_maddy_config("enable_metrics yes\n")
+                .unwrap()
+                .enable_metrics
+        );
+        let cfg = parse_maddy_config("metrics_token \"scrape\"\nmetrics_local_only on\n").unwrap();
+        assert_eq!(cfg.metrics_token.as_deref(), Some("scrape"));
+        assert!(cfg.metrics_local_only);
+    }
+
+    #[test]
+    fn parses_clock_check() {
+        let cfg = parse_maddy_config("hostname mx.example\n").unwrap();
+        assert_eq!(
+            cfg.effective_clock_check().as_deref(),
+            Some(crate::DEFAULT_CLOCK_CHECK_URL)
+        );
+        assert_eq!(
+            cfg.effective_clock_skew_threshold(),
+            crate::DEFAULT_CLOCK_SKEW_THRESHOLD
+        );
+        let cfg =
+            parse_maddy_config("clock_check https://time.example/\nclock_skew_threshold 30s\n")
+                .unwrap();
+        assert_eq!(
+            cfg.effective_clock_check().as_deref(),
+            Some("https://time.example/")
+        );
+        assert_eq!(
+            cfg.effective_clock_skew_threshold(),
+            std::time::Duration::from_secs(30)
+        );
+        let cfg = parse_maddy_config("clock_check off\n").unwrap();
+        assert_eq!(cfg.effective_clock_check(), None);
+    }
+
+    #[test]
+    fn parses_certificate_expiry_directives() {
+        let cfg = parse_maddy_config("hostname mx.example\n").unwrap();
+        assert_eq!(
+            cfg.effective_cert_expiry_warning(),
+            crate::DEFAULT_CERT_EXPIRY_WARNING
+        );
+        assert_eq!(cfg.effective_renewal_hook(), None);
+        assert_eq!(
+            cfg.effective_renewal_hook_timeout(),
+            crate::DEFAULT_RENEWAL_HOOK_TIMEOUT
+        );
+        let cfg = parse_maddy_config(
+            "cert_expiry_warning 21d\nrenewal_hook certbot renew --quiet\nrenewal_hook_timeout 2m\n",
+        )
+        .unwrap();
+        assert_eq!(
+            cfg.effective_cert_expiry_warning(),
+            std::time::Duration::from_secs(21 * 86_400)
+        );
+        assert_eq!(
+            cfg.effective_renewal_hook().as_deref(),
+            Some("certbot renew --quiet")
+        );
+        assert_eq!(
+            cfg.effective_renewal_hook_timeout(),
+            std::time::Duration::from_secs(120)
+        );
+        let cfg = parse_maddy_config("renewal_hook off\n").unwrap();
+        assert_eq!(cfg.effective_renewal_hook(), None);
+    }
+
+    #[test]
+    fn responder_suppress_accumulates() {
+        let cfg = parse_maddy_config(
+            "responder_suppress *@lists.example.org\nresponder_suppress alerts@* bot-*@*\n",
+        )
+        .unwrap();
+        assert_eq!(
+            cfg.responder_suppress,
+            ["*@lists.example.org", "alerts@*", "bot-*@*"]
+        );
+    }
+
+    #[test]
+    fn webfinger_extra_links_keep_raw_json() {
+        let cfg = parse_maddy_config(
+            r#"webfinger_extra_links "{\"rel\":\"self\",\"href\":\"https://x.org/u\"}""#,
+        )
+        .unwrap();
+        assert_eq!(
+            cfg.webfinger_extra_links,
+            [r#"{"rel":"self","href":"https://x.org/u"}"#]
+        );
+    }
+
+    #[test]
+    fn catchall_exclude_accumulates() {
+        let cfg = parse_maddy_config("catchall_exclude billing\ncatchall_exclude sales-* *-bot\n")
+            .unwrap();
+        assert_eq!(cfg.catchall_exclude, ["billing", "sales-*", "*-bot"]);
+    }
+
+    #[test]
+    fn peers_and_peer_secrets_accumulate() {
+        let cfg = parse_maddy_config(
+            "peers https://a.example https://b.example:8443\npeer_secret \"new\" old\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.peers, ["https://a.example", "https://b.example:8443"]);
+        assert_eq!(cfg.peer_secrets, ["new", "old"]);
+    }
+
+    #[test]
+    fn parses_crash_settings() {
+        let cfg = parse_maddy_config("hostname mx.example\n").unwrap();
+        assert!(!cfg.panic_abort);
+        assert!(cfg.crash_dir.is_none());
+        let cfg =
+            parse_maddy_config("panic_abort yes\ncrash_dir /var/crash/madmail\ncrash_keep 0\n")
+                .unwrap();
+        assert!(cfg.panic_abort);
+        assert_eq!(
+            cfg.crash_dir.as_deref(),
+            Some(std::path::Path::new("/var/crash/madmail"))
+        );
+        assert_eq!(cfg.crash_keep, None, "0 falls back to the default");
+    }
+
+    #[test]
+    fn parses_subaddress_delimiter() {
+        let cfg = parse_maddy_config("hostname mx.example\n").unwrap();
+        assert!(cfg.subaddress_delimiter.is_none());
+        let cfg = parse_maddy_config("subaddress_delimiter -\n").unwrap();
+        assert_eq!(cfg.subaddress_delimiter.as_deref(), Some("-"));
+        let cfg = parse_maddy_config("subaddress_delimiter \"\"\n").unwrap();
+        assert_eq!(
+            cfg.subaddress_delimiter.as_deref(),
+            Some(""),
+            "empty disables"
+        );
+    }
+
+    #[test]
+    fn parses_broadcast_directives() {
+        let cfg =
+            parse_maddy_config("broadcast_sender news@example.org\nbroadcast_rate 5\n").unwrap();
+        assert_eq!(cfg.broadcast_sender.as_deref(), Some("news@example.org"));
+        assert_eq!(cfg.broadcast_rate, Some(5));
+        let cfg = parse_maddy_config("broadcast_rate 0\n").unwrap();
+        assert_eq!(cfg.broadcast_rate, None, "0 falls back to the default");
+    }
+
+    #[test]
+    fn parses_pwa_colors() {
+        // `#` starts a comment, so colors are quoted (or written without it).
+        let cfg = parse_maddy_config("pwa_theme_color \"#0b7285\"\npwa_background_color f8f9fa\n")
+            .unwrap();
+        assert_eq!(cfg.pwa_theme_color.as_deref(), Some("#0b7285"));
+        assert_eq!(cfg.pwa_background_color.as_deref(), Some("f8f9fa"));
+    }
+
+    #[test]
+    fn parses_autoresponse_window() {
+        let cfg = parse_maddy_config("hostname mx.example\n").unwrap();
+        assert_eq!(cfg.autoresponse_window, None);
+        let cfg = parse_maddy_config("autoresponse_window 3d\n").unwrap();
+        assert_eq!(
+            cfg.autoresponse_window,
+            Some(std::time::Duration::from_secs(3 * 24 * 3600))
+        );
+        let cfg = parse_maddy_config("autoresponse_window 0\n").unwrap();
+        assert_eq!(cfg.autoresponse_window, None);
+    }
+
+    #[test]
+    fn parses_audit_retention_and_no_log_policy() {
+        let cfg = parse_maddy_config("hostname mx.example\n").unwrap();
+        assert_eq!(
+            cfg.effective_audit_retention(),
+            Some(crate::DEFAULT_AUDIT_RETENTION)
+        );
+        assert!(cfg.logging_off());
+        let cfg = parse_maddy_config("audit_retention 24h\nlog stderr\n").unwrap();
+        assert_eq!(
+            cfg.effective_audit_retention(),
+            Some(std::time::Duration::from_secs(24 * 3600))
+        );
+        assert!(!cfg.logging_off());
+        let cfg = parse_maddy_config("audit_retention 0\nlog off\n").unwrap();
+        assert_eq!(cfg.effective_audit_retention(), None);
+        assert!(cfg.logging_off());
+    }
+
+    #[test]
+    fn parses_shutdown_drain() {
+        let cfg = parse_maddy_config("hostname mx.example\n").unwrap();
+        assert_eq!(
+            cfg.effective_shutdown_drain(),
+            crate::DEFAULT_SHUTDOWN_DRAIN
+        );
+        let cfg = parse_maddy_config("shutdown_drain 20s\n").unwrap();
+        assert_eq!(
+            cfg.effective_shutdown_drain(),
+            std::time::Duration::from_secs(20)
+        );
+        let cfg = parse_maddy_config("shutdown_drain 0\n").unwrap();
+        assert_eq!(cfg.effective_shutdown_drain(), std::time::Duration::ZERO);
+    }
+
+    #[test]
+    fn parses_trusted_cdn() {
+        let cfg = parse_maddy_config("trusted_cdn 10.0.0.0/8 2001:db8::/32\n").unwrap();
+        assert_eq!(cfg.trusted_cdn.as_deref(), Some("10.0.0.0/8 2001:db8::/32"));
+        let cfg = parse_maddy_config("trusted_cdn cloudflare\n").unwrap();
+        assert_eq!(cfg.trusted_cdn.as_deref(), Some("cloudflare"));
+    }
+
+    #[test]
+    fn parses_junk_training_url() {
+        let cfg = parse_maddy_config(
+            "storage.imapsql local_mailboxes {\n    junk_training_url \"http://127.0.0.1:11334\"\n}\n",
+        )
+        .unwrap();
+        assert_eq!(
+            cfg.junk_training_url.as_deref(),
+            Some("http://127.0.0.1:11334")
+        );
+    }
+
+    #[test]
+    fn parses_webhook_directives_in_either_block() {
+        let cfg = parse_maddy_config(concat!(
+            "storage.imapsql local_mailboxes {\n",
+            "    webhook_url \"https://hooks.example/madmail\"\n",
+            "    webhook_secret \"s3cret\"\n",
+            "    webhook_events account_created,first_login quota_exceeded\n",
+            "}\n",
+        ))
+        .unwrap();
+        assert_eq!(
+            cfg.webhook_url.as_deref(),
+            Some("https://hooks.example/madmail")
+        );
+        assert_eq!(cfg.webhook_secret.as_deref(), Some("s3cret"));
+        assert_eq!(
+            cfg.webhook_events,
+            ["account_created", "first_login", "quota_exceeded"]
+        );
+
+        let cfg = parse_maddy_config(
+            "chatmail tcp://0.0.0.0:80 {\n    webhook_url https://hooks.example/a\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.webhook_url.as_deref(), Some("https://hooks.example/a"));
+        assert!(cfg.webhook_events.is_empty());
+    }
+
+    #[test]
+    fn parses_retention_modifiers() {
+        let cfg = parse_maddy_config(
+            "storage.imapsql local_mailboxes {\n    retention 720h\n    retention_keep_flagged no\n    retention_keep_unseen 2160h\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.retention_keep_flagged, Some(false));
+        assert!(!cfg.effective_retention_keep_flagged());
+        assert_eq!(cfg.retention_keep_unseen.as_deref(), Some("2160h"));
+        let cfg = parse_maddy_config("storage.imapsql local_mailboxes {\n}\n").unwrap();
+        assert!(cfg.effective_retention_keep_flagged());
+        assert_eq!(cfg.retention_keep_unseen, None);
+    }
+
+    #[test]
+    fn parses_submission_rate_limits() {
+        let cfg = parse_maddy_config(
+            "submission tcp://0.0.0.0:587 {\n    max_messages_per_hour 200\n    max_rcpt_per_message 50\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.max_messages_per_hour, Some(200));
+        assert_eq!(cfg.max_rcpt_per_message, Some(50));
+        let cfg = parse_maddy_config(
+            "smtp tcp://0.0.0.0:25 {\n    max_messages_per_hour 200\n}\nsubmission tcp://0.0.0.0:587 {\n    max_rcpt_per_message 0\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.max_messages_per_hour, None);
+        assert_eq!(cfg.max_rcpt_per_message, None);
+    }
+
+    #[test]
+    fn parses_dkim_selectors_from_submission_modify() {
+        let cfg = parse_maddy_config(
+            "$(primary_domain) = example.org\n$(local_domains) = $(primary_domain) extra.org\n\
+             submission tcp://0.0.0.0:587 {\n    source $(local_domains) {\n        \
+             default_destination {\n            modify {\n                \
+             dkim $(primary_domain) $(local_domains) ed1\n                \
+             dkim $(primary_domain) $(local_domains) rsa1\n                \
+             dkim $(primary_domain) $(local_domains) ed1\n            }\n        }\n    }\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.dkim_selectors, ["ed1", "rsa1"]);
+        let cfg = parse_maddy_config(
+            "chatmail tls://0.0.0.0:443 {\n    federation_checks {\n        dkim check\n    }\n}\n",
+        )
+        .unwrap();
+        assert!(cfg.dkim_selectors.is_empty());
+    }
+
+    #[test]
+    fn parses_ss_password_slots() {
+        let cfg = parse_maddy_config(
+            "chatmail tls://0.0.0.0:443 {\n    ss_addr 0.0.0.0:8388\n    ss_password one\n    ss_passwords \"two\" three two\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.ss_password.as_deref(), Some("one"));
+        assert_eq!(cfg.ss_passwords, ["two", "three"]);
+        assert!(cfg.ss_configured());
+    }
+
+    #[test]
+    fn parses_registration_rate_limit() {
+        let cfg = parse_maddy_config(
+            "chatmail tls://0.0.0.0:443 {\n    reg_rate_limit 0.5\n    reg_rate_burst 5\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.reg_rate_limit, Some(0.5));
+        assert_eq!(cfg.reg_rate_burst, Some(5));
+        let cfg = parse_maddy_config(
+            "chatmail tls://0.0.0.0:443 {\n    reg_rate_limit -1\n    reg_rate_burst 0\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.reg_rate_limit, None);
+        assert_eq!(cfg.reg_rate_burst, None);
+    }
+
+    #[test]
+    fn parses_proof_of_work_bits() {
+        let cfg =
+            parse_maddy_config("chatmail tls://0.0.0.0:443 {\n    proof_of_work_bits 20\n}\n")
+                .unwrap();
+        assert_eq!(cfg.proof_of_work_bits, Some(20));
+        let cfg =
+            parse_maddy_config("chatmail tls://0.0.0.0:443 {\n    proof_of_work_bits 64\n}\n")
+                .unwrap();
+        assert_eq!(cfg.proof_of_work_bits, Some(32));
+    }
+
+    #[test]
+    fn parses_registration_rate_and_pow_aliases() {
+        let cfg = parse_maddy_config(
+            "chatmail tls://0.0.0.0:443 {\n    registration_rate 10 1h\n    registration_pow_bits 18\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.reg_rate_burst, Some(10));
+        assert!((cfg.reg_rate_limit.unwrap() - 10.0 / 3600.0).abs() < 1e-12);
+        assert_eq!(cfg.proof_of_work_bits, Some(18));
+        let cfg = parse_maddy_config(
+            "chatmail tls://0.0.0.0:443 {\n    registration_rate 0.5\n    reg_rate_burst 4\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.reg_rate_limit, Some(0.5));
+        assert_eq!(cfg.reg_rate_burst, Some(4));
+    }
+
+    #[test]
+    fn parses_recovery_codes() {
+        let cfg =
+            parse_maddy_config("chatmail tls://0.0.0.0:443 {\n    recovery_codes 5\n}\n").unwrap();
+        assert_eq!(cfg.recovery_codes, Some(5));
+        let cfg =
+            parse_maddy_config("chatmail tls://0.0.0.0:443 {\n    recovery_codes 99\n}\n").unwrap();
+        assert_eq!(cfg.recovery_codes, Some(20));
+    }
+
+    #[test]
+    fn parses_federation_checks_block() {
+        let cfg = parse_maddy_config(
+            "chatmail tls://0.0.0.0:443 {\n    federation_checks {\n        spf check\n        dkim enforce\n        dmarc off\n        dmarc_alignment any\n    }\n}\n",
+        )
+        .unwrap();
+        let checks = cfg.federation_checks;
+        assert_eq!(checks.spf, crate::CheckMode::Check);
+        assert_eq!(checks.dkim, crate::CheckMode::Enforce);
+        assert_eq!(checks.dmarc, crate::CheckMode::Off);
+        assert_eq!(checks.dmarc_alignment, crate::DmarcAlignment::Any);
+        assert_eq!(
+            parse_maddy_config("chatmail tls://0.0.0.0:443 {\n}\n")
+                .unwrap()
+                .federation_checks,
+            crate::FederationChecks::default()
+        );
+    }
+
+    #[test]
+    fn parses_cold_storage_block() {
+        let cfg = parse_maddy_config(
+            "storage.imapsql local_mailboxes {\n    mail_fsync never\n    cold_storage {\n        path /srv/cold\n        age 30d\n        compression none\n        promote_on_read yes\n    }\n}\n",
+        )
+        .unwrap();
+        let cold = cfg.cold_storage;
+        assert!(cold.enabled, "the block opts in");
+        assert_eq!(cold.path, Some(PathBuf::from("/srv/cold")));
+        assert_eq!(cold.age, std::time::Duration::from_secs(30 * 86_400));
+        assert_eq!(cold.compression, "none");
+        assert!(cold.promote_on_read);
+        assert_eq!(cfg.mail_fsync.as_deref(), Some("never"));
+
+        let off = parse_maddy_config(
+            "storage.imapsql local_mailboxes {\n    cold_storage {\n        enable no\n    }\n}\n",
+        )
+        .unwrap();
+        assert!(!off.cold_storage.enabled);
+        assert!(
+            !parse_maddy_config("storage.imapsql local_mailboxes {\n}\n")
+                .unwrap()
+                .cold_storage
+                .enabled
+        );
+    }
+
+    #[test]
+    fn parses_db_maintenance_directives() {
+        let cfg = parse_maddy_config(
+            "storage.imapsql local_mailboxes {\n    db_maintenance_schedule Weekly\n    db_maintenance_max_deliveries 10\n}\n",
+        )
+        .unwrap();
+        assert_eq!(cfg.db_maintenance_schedule.as_deref(), Some("weekly"));
+        assert_eq!(cfg.db_maintenance_max_deliveries, Some(10));
+    }
+
+    #[test]
+    fn parses_log_file_target_with_rotation() {
+        let cfg = parse_maddy_config(
+            "log file:/var/log/madmail/madmail.log\nlog_rotate_size 10M\nlog_rotate_keep 3\nlog_rotate_compress off\n",
+        )
+        .unwrap();
+        assert_eq!(
+            cfg.log_target.as_deref(),
+            Some("file:/var/log/madmail/madmail.log")
+        );
+        assert_eq!(cfg.log_rotate_size.as_deref(), Some("10M"));
+        assert_eq!(cfg.log_rotate_keep, Some(3));
+        assert_eq!(cfg.log_rotate_compress, Some(false));
+    }
+
+    #[test]
+    fn parses_log_format_top_level_and_in_chatmail_block() {
+        let cfg = parse_maddy_config("log stderr\nlog_format json\n").unwrap();
+        assert_eq!(cfg.log_format.as_deref(), Some("json"));
+        let cfg =
+            parse_maddy_config("chatmail tcp://0.0.0.0:80 {\n    log_format json\n}\n").unwrap();
+        assert_eq!(cfg.log_format.as_deref(), Some("json"));
+    }
 }
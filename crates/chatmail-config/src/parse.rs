@@ -36,12 +36,14 @@ pub struct TomlConfig {
     pub runtime_dir: Option<String>,
     pub tls_mode: Option<String>,
     pub acme_email: Option<String>,
+    pub acme_directory: Option<String>,
     #[serde(
         default,
         deserialize_with = "crate::bool_str::deserialize_option_bool_flexible"
     )]
     pub debug: Option<bool>,
     pub log: Option<String>,
+    pub log_format: Option<String>,
     pub smtp_listen: Option<String>,
     pub submission_listen: Option<String>,
     pub submission_tls_listen: Option<String>,
@@ -76,20 +78,23 @@ pub struct TomlConfig {
 pub fn load_config(path: &Path) -> Result<AppConfig> {
     let content = std::fs::read_to_string(path).map_err(ChatmailError::from)?;
     let ext = path.extension().and_then(|e| e.to_str()).unwrap_or("");
-    match ext {
-        "toml" => Ok(toml_to_app_config(&content)?),
+    let cfg = match ext {
+        "toml" => toml_to_app_config(&content)?,
         "conf" => {
-            maddy::parse_maddy_config(&content).map_err(|e| ChatmailError::config(e.to_string()))
+            maddy::parse_maddy_config(&content).map_err(|e| ChatmailError::config(e.to_string()))?
         }
         _ => {
             if content.trim_start().starts_with('{') {
-                Ok(toml_to_app_config(&content)?)
+                toml_to_app_config(&content)?
             } else {
                 maddy::parse_maddy_config(&content)
-                    .map_err(|e| ChatmailError::config(e.to_string()))
+                    .map_err(|e| ChatmailError::config(e.to_string()))?
             }
         }
-    }
+    };
+    cfg.validate_credential_charsets()
+        .map_err(ChatmailError::config)?;
+    Ok(cfg)
 }
 
 fn toml_to_app_config(content: &str) -> Result<AppConfig> {
@@ -106,10 +111,51 @@ fn toml_to_app_config(content: &str) -> Result<AppConfig> {
         runtime_dir: parsed.runtime_dir.map(Into::into),
         tls_mode: parsed.tls_mode,
         acme_email: parsed.acme_email,
+        acme_directory: parsed.acme_directory,
         tls_cert_path: None,
         tls_key_path: None,
         debug: parsed.debug.unwrap_or(false),
+        panic_abort: false,
+        crash_dir: None,
+        crash_keep: None,
         log_target: parsed.log,
+        log_format: parsed.log_format,
+        log_rotate_size: None,
+        log_rotate_keep: None,
+        log_rotate_compress: None,
+        trusted_cdn: None,
+        trusted_networks: None,
+        ip_ban: None,
+        ip_ban_auth_failures: None,
+        ip_ban_registrations: None,
+        ip_ban_window: None,
+        ip_ban_duration: None,
+        ip_ban_max_duration: None,
+        tls_fingerprint: None,
+        takeout_ttl: None,
+        memory_budget: None,
+        disk_soft_limit: None,
+        disk_hard_limit: None,
+        disk_soft_max_message: None,
+        storage_orphan_warning: None,
+        clock_check: None,
+        clock_skew_threshold: None,
+        cert_expiry_warning: None,
+        renewal_hook: None,
+        renewal_hook_timeout: None,
+        shutdown_drain: None,
+        responder_suppress: vec![],
+        autoresponse_window: None,
+        audit_retention: None,
+        webfinger_extra_links: vec![],
+        subaddress_delimiter: None,
+        broadcast_sender: None,
+        broadcast_rate: None,
+        catchall_exclude: vec![],
+        peers: vec![],
+        peer_secrets: vec![],
+        pwa_theme_color: None,
+        pwa_background_color: None,
         auth_auto_create: parsed.auth_auto_create.unwrap_or(false),
         jit_domain: parsed.jit_domain,
         credentials_driver: None,
@@ -119,24 +165,65 @@ fn toml_to_app_config(content: &str) -> Result<AppConfig> {
         default_quota: None,
         retention: None,
         unused_account_retention: None,
+        inactive_account_retention: None,
+        inactive_account_action: None,
+        activity_counts_inbound: None,
+        db_maintenance_schedule: None,
+        db_maintenance_max_deliveries: None,
         appendlimit: None,
         max_message_size: None,
+        max_rcpt: None,
+        greet_delay: None,
+        greet_delay_exempt: None,
+        archive: None,
+        archive_fail_closed: None,
+        archive_senders: None,
+        max_messages_per_hour: None,
+        max_rcpt_per_message: None,
+        dkim_selectors: Vec::new(),
         max_federation_size: None,
         mail_fsync: None,
         blob_dedup: None,
+        cold_storage: crate::ColdStorageConfig::default(),
+        junk_training_url: None,
+        webhook_url: None,
+        webhook_secret: None,
+        webhook_events: vec![],
+        retention_keep_flagged: None,
+        retention_keep_unseen: None,
         mail_domain: None,
+        extra_mail_domains: vec![],
         mx_domain,
         username_length: None,
         password_length: None,
         min_username_length: None,
         max_username_length: None,
         password_min_length: None,
+        password_charset: None,
+        password_exclude_ambiguous: None,
+        username_exclude_ambiguous: None,
+        reg_rate_limit: None,
+        reg_rate_burst: None,
+        proof_of_work_bits: None,
+        recovery_codes: None,
         admin_path: None,
         admin_web_path: parsed.admin_web_path,
         language: parsed.language,
         www_dir: parsed.www_dir.map(PathBuf::from),
+        upload_body_limit: None,
         enable_contact_sharing: false,
+        allow_self_delete: false,
+        self_service: None,
+        sieve_store: None,
+        sieve_max_size: None,
         sharing_dsn: None,
+        sharing_analytics: None,
+        health_checks: None,
+        enable_metrics: false,
+        metrics_token: None,
+        metrics_local_only: false,
+        app_links: crate::AppLinksConfig::default(),
+        federation_checks: crate::FederationChecks::default(),
         admin_token: None,
         smtp_listen: parsed.smtp_listen,
         submission_listen: parsed.submission_listen,
@@ -165,7 +252,9 @@ fn toml_to_app_config(content: &str) -> Result<AppConfig> {
         iroh_port: 0,
         ss_addr: None,
         ss_password: None,
+        ss_passwords: vec![],
         ss_cipher: None,
+        ss_protocol: None,
         ss_cert_path: None,
         ss_key_path: None,
         ss_allowed_ports: vec![],
@@ -221,6 +310,28 @@ tls_mode = "autocert"
         assert!(matches!(err, ChatmailError::Config(_)));
     }
 
+    #[test]
+    fn weak_password_charset_fails_at_load() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("maddy.conf");
+        let block = "chatmail tcp://0.0.0.0:80 {\n    password_charset abcdef\n    \
+                     password_exclude_ambiguous yes\n}\n";
+        std::fs::write(&path, block).unwrap();
+        let err = load_config(&path).unwrap_err();
+        assert!(
+            matches!(&err, ChatmailError::Config(m) if m.contains("bits of entropy")),
+            "{err}"
+        );
+
+        std::fs::write(&path, block.replace("abcdef", "abcdefghjkmnpqrstuvwxyz")).unwrap();
+        let cfg = load_config(&path).unwrap();
+        assert_eq!(cfg.password_exclude_ambiguous, Some(true));
+        assert_eq!(
+            cfg.password_charset.as_deref(),
+            Some("abcdefghjkmnpqrstuvwxyz")
+        );
+    }
+
     #[test]
     fn p1_ut02_load_maddy_conf_file() {
         let dir = tempfile::tempdir().unwrap();
@@ -37,6 +37,49 @@ pub struct QueueSettings {
     /// Max time a message may stay in the outbound queue (default: 600s = 10m).
     /// After this, the message is dropped as failed (madmail-v2; Madmail retries much longer).
     pub max_delivery_secs: u64,
+    /// Concurrent deliveries to one recipient domain (default: 4).
+    pub max_parallel_per_domain: u32,
+    /// Delivery attempts started per minute for one recipient domain (default: 60).
+    pub max_messages_per_minute_per_domain: u32,
+    /// `domain_limit` overrides of the two per-domain caps, first match wins.
+    pub domain_limits: Vec<DomainLimit>,
+}
+
+/// `domain_limit <domain> <max_parallel> <max_per_minute>` inside `target.queue`.
+///
+/// `domain` matches the recipient domain itself and every subdomain of it.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DomainLimit {
+    pub domain: String,
+    pub max_parallel: u32,
+    pub max_per_minute: u32,
+}
+
+impl DomainLimit {
+    /// Parse the three directive arguments; `None` when malformed.
+    pub fn parse(args: &[String]) -> Option<Self> {
+        let [domain, parallel, per_minute] = args else {
+            return None;
+        };
+        let domain = domain.trim().trim_start_matches('.').to_ascii_lowercase();
+        if domain.is_empty() {
+            return None;
+        }
+        Some(Self {
+            domain,
+            max_parallel: parallel.parse::<u32>().ok()?.max(1),
+            max_per_minute: per_minute.parse::<u32>().ok()?.max(1),
+        })
+    }
+
+    /// True when `domain` is this entry's domain or one of its subdomains.
+    pub fn matches(&self, domain: &str) -> bool {
+        let domain = domain.trim_end_matches('.').to_ascii_lowercase();
+        domain == self.domain
+            || domain
+                .strip_suffix(self.domain.as_str())
+                .is_some_and(|rest| rest.ends_with('.'))
+    }
 }
 
 impl Default for QueueSettings {
@@ -49,6 +92,9 @@ impl Default for QueueSettings {
             retry_time_scale: 1.25,
             post_init_delay_secs: 10,
             max_delivery_secs: 10 * 60,
+            max_parallel_per_domain: 4,
+            max_messages_per_minute_per_domain: 60,
+            domain_limits: Vec::new(),
         }
     }
 }
@@ -75,6 +121,26 @@ mod tests {
         assert!((d.retry_time_scale - 1.25).abs() < f64::EPSILON);
         assert_eq!(d.post_init_delay_secs, 10);
         assert_eq!(d.max_delivery_secs, 600);
+        assert_eq!(d.max_parallel_per_domain, 4);
+        assert_eq!(d.max_messages_per_minute_per_domain, 60);
+        assert!(d.domain_limits.is_empty());
+    }
+
+    #[test]
+    fn domain_limit_parses_and_matches_subdomains() {
+        let args = |v: &[&str]| v.iter().map(|s| s.to_string()).collect::<Vec<_>>();
+        let limit = DomainLimit::parse(&args(&["Gmail.com", "2", "20"])).expect("parse");
+        assert_eq!(limit.domain, "gmail.com");
+        assert_eq!((limit.max_parallel, limit.max_per_minute), (2, 20));
+        assert!(limit.matches("gmail.com"));
+        assert!(limit.matches("MX.Gmail.com"));
+        assert!(!limit.matches("notgmail.com"));
+        assert!(!limit.matches("gmail.com.evil.org"));
+
+        assert!(DomainLimit::parse(&args(&["gmail.com", "2"])).is_none());
+        assert!(DomainLimit::parse(&args(&["gmail.com", "x", "20"])).is_none());
+        let clamped = DomainLimit::parse(&args(&["a.org", "0", "0"])).expect("parse");
+        assert_eq!((clamped.max_parallel, clamped.max_per_minute), (1, 1));
     }
 
     #[test]
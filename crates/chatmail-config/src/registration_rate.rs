@@ -0,0 +1,127 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `registration_rate` values: `POST /new` allowance per client subnet.
+//!
+//! The maddy directive takes `registration_rate 10 1h`; the `__REGISTRATION_RATE__`
+//! setting stores the same thing as `10/1h` (unit names like `10/hour` work too). A bare
+//! number is requests per second, as with `reg_rate_limit`.
+
+use std::time::Duration;
+
+use crate::maddy::parse_duration;
+
+/// A parsed `registration_rate`.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct RegistrationRate {
+    /// Tokens added per second; `0` = limiter off.
+    pub per_second: f64,
+    /// `N` of `N per window`; `None` for a bare per-second rate (burst stays as configured).
+    pub burst: Option<u32>,
+}
+
+/// Parse `10 1h`, `10/1h`, `10/hour` or a bare per-second rate (`0.5`). `0` turns the
+/// limiter off.
+pub fn parse_registration_rate(value: &str) -> Option<RegistrationRate> {
+    let value = value.trim();
+    let (count, window) = match value.split_once(|c: char| c == '/' || c.is_whitespace()) {
+        Some((count, window)) => (count.trim(), Some(window.trim())),
+        None => (value, None),
+    };
+    let Some(window) = window else {
+        let per_second = count.parse::<f64>().ok()?;
+        return (per_second.is_finite() && per_second >= 0.0).then_some(RegistrationRate {
+            per_second,
+            burst: None,
+        });
+    };
+    let count = count.parse::<u32>().ok()?;
+    let window = match window.to_ascii_lowercase().as_str() {
+        "second" | "sec" | "s" => Duration::from_secs(1),
+        "minute" | "min" | "m" => Duration::from_secs(60),
+        "hour" | "h" => Duration::from_secs(3600),
+        "day" | "d" => Duration::from_secs(86_400),
+        other => parse_duration(other).ok()?,
+    };
+    if window.is_zero() {
+        return None;
+    }
+    if count == 0 {
+        return Some(RegistrationRate {
+            per_second: 0.0,
+            burst: None,
+        });
+    }
+    Some(RegistrationRate {
+        per_second: f64::from(count) / window.as_secs_f64(),
+        burst: Some(count),
+    })
+}
+
+/// Canonical `N/window` form for a per-second rate and burst (what the admin API shows).
+pub fn format_registration_rate(per_second: f64, burst: u32) -> String {
+    if per_second <= 0.0 {
+        return "0".into();
+    }
+    let burst = burst.max(1);
+    let window = f64::from(burst) / per_second;
+    let secs = window.round();
+    if secs < 1.0 || (window - secs).abs() > 1e-6 || secs > u32::MAX as f64 {
+        return per_second.to_string();
+    }
+    let secs = secs as u64;
+    let window = match secs {
+        s if s % 86_400 == 0 => format!("{}d", s / 86_400),
+        s if s % 3600 == 0 => format!("{}h", s / 3600),
+        s if s % 60 == 0 => format!("{}m", s / 60),
+        s => format!("{s}s"),
+    };
+    format!("{burst}/{window}")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_windowed_and_bare_rates() {
+        let r = parse_registration_rate("10 1h").unwrap();
+        assert_eq!(r.burst, Some(10));
+        assert!((r.per_second - 10.0 / 3600.0).abs() < 1e-12);
+        assert_eq!(parse_registration_rate("10/1h"), Some(r));
+        assert_eq!(parse_registration_rate("10/hour"), Some(r));
+        assert_eq!(
+            parse_registration_rate("0.5"),
+            Some(RegistrationRate {
+                per_second: 0.5,
+                burst: None
+            })
+        );
+        assert_eq!(parse_registration_rate("0 1h").unwrap().per_second, 0.0);
+        for bad in ["", "-1", "ten/1h", "10/0s", "10/fortnight", "NaN"] {
+            assert_eq!(parse_registration_rate(bad), None, "{bad}");
+        }
+    }
+
+    #[test]
+    fn formats_back_to_window_form() {
+        assert_eq!(format_registration_rate(10.0 / 3600.0, 10), "10/1h");
+        assert_eq!(format_registration_rate(1.0, 3), "3/3s");
+        assert_eq!(format_registration_rate(0.3, 1), "0.3");
+        assert_eq!(format_registration_rate(0.0, 3), "0");
+    }
+}
@@ -0,0 +1,11 @@
+-- Unified policy lists (pgp_passthrough, federation, reserved_name, sharing_reserved).
+CREATE TABLE IF NOT EXISTS policy_entries (
+    id BIGSERIAL PRIMARY KEY,
+    policy_type TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    action TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    expires_at BIGINT,
+    created_at BIGINT NOT NULL DEFAULT (FLOOR(EXTRACT(EPOCH FROM NOW())))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS policy_entries_type_pattern_key ON policy_entries (policy_type, pattern);
@@ -0,0 +1,17 @@
+-- Per-device app passwords and one-time device provisioning codes.
+CREATE TABLE IF NOT EXISTS app_passwords (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    label TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT (FLOOR(EXTRACT(EPOCH FROM NOW())))
+);
+CREATE INDEX IF NOT EXISTS app_passwords_username_idx ON app_passwords (username);
+CREATE TABLE IF NOT EXISTS device_codes (
+    code_hash TEXT PRIMARY KEY NOT NULL,
+    username TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    used_at BIGINT
+);
+CREATE INDEX IF NOT EXISTS device_codes_username_idx ON device_codes (username);
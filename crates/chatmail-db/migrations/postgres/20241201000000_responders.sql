@@ -0,0 +1,13 @@
+-- Operator auto-replies for local addresses and the last reply sent to each correspondent.
+CREATE TABLE IF NOT EXISTS responders (
+    address TEXT PRIMARY KEY NOT NULL,
+    template TEXT NOT NULL,
+    interval_secs BIGINT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS responder_replies (
+    address TEXT NOT NULL,
+    correspondent TEXT NOT NULL,
+    sent_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (address, correspondent)
+);
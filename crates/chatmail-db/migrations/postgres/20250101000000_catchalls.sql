@@ -0,0 +1,13 @@
+-- Per-domain catch-all mailboxes and their per-day delivery counters.
+CREATE TABLE IF NOT EXISTS catchalls (
+    domain TEXT PRIMARY KEY NOT NULL,
+    target TEXT NOT NULL,
+    daily_cap BIGINT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS catchall_daily (
+    domain TEXT NOT NULL,
+    day BIGINT NOT NULL,
+    deliveries BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (domain, day)
+);
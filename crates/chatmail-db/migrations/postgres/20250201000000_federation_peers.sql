@@ -0,0 +1,5 @@
+-- Peer instances whose /federation/info is fetched for delivery hints (`madmail peers`).
+CREATE TABLE IF NOT EXISTS federation_peers (
+    base_url TEXT PRIMARY KEY NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,13 @@
+-- Admin announcement broadcasts (`POST /admin/broadcast`, `madmail broadcast`).
+CREATE TABLE IF NOT EXISTS broadcast_audit (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    filter TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    recipients BIGINT NOT NULL DEFAULT 0,
+    delivered BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,8 @@
+-- Per-account submissions per UTC hour (`submission` `max_messages_per_hour`).
+CREATE TABLE IF NOT EXISTS sending_stats (
+    username TEXT NOT NULL,
+    hour BIGINT NOT NULL,
+    messages BIGINT NOT NULL DEFAULT 0,
+    recipients BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, hour)
+);
@@ -0,0 +1,17 @@
+-- Hashed single-use account recovery codes (`chatmail` `recovery_codes`).
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_username_idx ON recovery_codes (username);
+
+-- Self-service credential changes (password recovery).
+CREATE TABLE IF NOT EXISTS account_audit (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    username TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT 0
+);
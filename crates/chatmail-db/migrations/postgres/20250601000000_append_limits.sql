@@ -0,0 +1,5 @@
+-- Per-account APPEND / delivery size cap; the global appendlimit stays the ceiling.
+CREATE TABLE IF NOT EXISTS append_limits (
+    username TEXT PRIMARY KEY NOT NULL,
+    max_bytes BIGINT NOT NULL DEFAULT 0
+);
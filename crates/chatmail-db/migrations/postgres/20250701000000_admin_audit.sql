@@ -0,0 +1,12 @@
+-- Admin API mutations: who (token prefix, remote), what (method, resource, redacted body), outcome.
+CREATE TABLE IF NOT EXISTS admin_audit (
+    id BIGSERIAL PRIMARY KEY,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    token_prefix TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    resource TEXT NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 0,
+    remote TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS admin_audit_created_at_idx ON admin_audit (created_at);
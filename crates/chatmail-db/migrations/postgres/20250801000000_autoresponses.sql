@@ -0,0 +1,16 @@
+-- Per-account vacation replies and the last reply sent to each correspondent.
+CREATE TABLE IF NOT EXISTS autoresponses (
+    username TEXT PRIMARY KEY NOT NULL,
+    active INTEGER NOT NULL DEFAULT 1,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    starts_at BIGINT NOT NULL DEFAULT 0,
+    ends_at BIGINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS autoresponse_replies (
+    username TEXT NOT NULL,
+    correspondent TEXT NOT NULL,
+    sent_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, correspondent)
+);
@@ -0,0 +1,5 @@
+-- Last IMAP/SMTP login or inbound delivery per account (inactive-account retention).
+CREATE TABLE IF NOT EXISTS account_activity (
+    username TEXT PRIMARY KEY NOT NULL,
+    last_activity_at INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,18 @@
+-- Banned IPs / networks (canonical CIDR; expires_at 0 = permanent) and their audit trail.
+CREATE TABLE IF NOT EXISTS bans (
+    cidr TEXT PRIMARY KEY NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER NOT NULL DEFAULT 0,
+    strikes INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS ban_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    cidr TEXT NOT NULL,
+    source TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,13 @@
+-- Per-domain catch-all mailboxes and their per-day delivery counters.
+CREATE TABLE IF NOT EXISTS catchalls (
+    domain TEXT PRIMARY KEY NOT NULL,
+    target TEXT NOT NULL,
+    daily_cap INTEGER NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS catchall_daily (
+    domain TEXT NOT NULL,
+    day INTEGER NOT NULL,
+    deliveries INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (domain, day)
+);
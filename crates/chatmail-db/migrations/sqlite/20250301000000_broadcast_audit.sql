@@ -0,0 +1,13 @@
+-- Admin announcement broadcasts (`POST /admin/broadcast`, `madmail broadcast`).
+CREATE TABLE IF NOT EXISTS broadcast_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    filter TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    recipients INTEGER NOT NULL DEFAULT 0,
+    delivered INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,8 @@
+-- Per-account submissions per UTC hour (`submission` `max_messages_per_hour`).
+CREATE TABLE IF NOT EXISTS sending_stats (
+    username TEXT NOT NULL,
+    hour INTEGER NOT NULL,
+    messages INTEGER NOT NULL DEFAULT 0,
+    recipients INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, hour)
+);
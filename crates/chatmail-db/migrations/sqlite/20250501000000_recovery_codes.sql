@@ -0,0 +1,17 @@
+-- Hashed single-use account recovery codes (`chatmail` `recovery_codes`).
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_username_idx ON recovery_codes (username);
+
+-- Self-service credential changes (password recovery).
+CREATE TABLE IF NOT EXISTS account_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    username TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0
+);
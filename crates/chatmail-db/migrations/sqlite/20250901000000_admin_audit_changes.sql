@@ -0,0 +1,4 @@
+-- Audit rows written by the CLI (`actor` = 'cli'), and the value a change replaced and set.
+ALTER TABLE admin_audit ADD COLUMN actor TEXT NOT NULL DEFAULT 'admin-api';
+ALTER TABLE admin_audit ADD COLUMN old_value TEXT NOT NULL DEFAULT '';
+ALTER TABLE admin_audit ADD COLUMN new_value TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,149 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Last account activity (`account_activity` table): logins and, optionally, inbound mail.
+//!
+//! Kept out of `quotas` so Go Madmail schemas (`quota` table) need no column migration.
+//! Writes are throttled in `chatmail-state`; this module only stores and queries.
+
+use std::collections::HashMap;
+
+use chatmail_types::Result;
+
+use crate::account_info::{list_account_quota_info, AccountQuotaInfo};
+use crate::{db_execute, db_fetch_all, DbPool};
+
+/// Raise `username`'s last activity to `at` (never moves it backwards).
+pub async fn record_activity(pool: &DbPool, username: &str, at: i64) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO account_activity (username, last_activity_at) VALUES (?, ?)
+         ON CONFLICT (username) DO UPDATE SET last_activity_at = excluded.last_activity_at
+         WHERE account_activity.last_activity_at < excluded.last_activity_at",
+        username,
+        at
+    )?;
+    Ok(())
+}
+
+pub async fn list_last_activity(pool: &DbPool) -> Result<HashMap<String, i64>> {
+    let rows: Vec<(String, i64)> = db_fetch_all!(
+        pool,
+        (String, i64),
+        "SELECT username, last_activity_at FROM account_activity"
+    )?;
+    Ok(rows.into_iter().collect())
+}
+
+pub async fn delete_activity(pool: &DbPool, username: &str) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM account_activity WHERE username = ?",
+        username
+    )?;
+    Ok(())
+}
+
+/// Most recent sign of life: activity, last/first login, or creation for accounts that
+/// predate activity tracking.
+pub fn last_seen(info: &AccountQuotaInfo) -> i64 {
+    let first_login = if info.first_login_at > 1 {
+        info.first_login_at
+    } else {
+        0
+    };
+    info.last_activity_at
+        .max(info.last_login_at)
+        .max(first_login)
+        .max(info.created_at)
+}
+
+/// Inactive-retention predicate: the account logged in at least once (never-used accounts
+/// belong to `unused_account_retention`) and shows no activity since `cutoff`.
+pub fn is_inactive(info: &AccountQuotaInfo, cutoff: i64) -> bool {
+    info.first_login_at != 1 && last_seen(info) < cutoff
+}
+
+/// Accounts matching [`is_inactive`] for `cutoff`, sorted by username.
+pub async fn list_inactive_accounts(pool: &DbPool, cutoff: i64) -> Result<Vec<String>> {
+    let mut users: Vec<String> = list_account_quota_info(pool)
+        .await?
+        .into_iter()
+        .filter(|(u, info)| {
+            !(u.starts_with("__") && u.ends_with("__")) && is_inactive(info, cutoff)
+        })
+        .map(|(u, _)| u)
+        .collect();
+    users.sort();
+    Ok(users)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    fn info(created: i64, first: i64, last_login: i64, activity: i64) -> AccountQuotaInfo {
+        AccountQuotaInfo {
+            created_at: created,
+            first_login_at: first,
+            last_login_at: last_login,
+            last_activity_at: activity,
+        }
+    }
+
+    #[test]
+    fn inactive_predicate() {
+        let cutoff = 1_000;
+        // Never logged in: left to unused-account pruning.
+        assert!(!is_inactive(&info(10, 1, 0, 0), cutoff));
+        // Logged in long ago, nothing since.
+        assert!(is_inactive(&info(10, 20, 30, 0), cutoff));
+        // Recent activity, login, or (untracked legacy row) creation keeps the account.
+        assert!(!is_inactive(&info(10, 20, 30, 1_500), cutoff));
+        assert!(!is_inactive(&info(10, 20, 1_200, 0), cutoff));
+        assert!(!is_inactive(&info(1_100, 0, 0, 0), cutoff));
+        assert!(is_inactive(&info(500, 0, 0, 0), cutoff));
+    }
+
+    #[tokio::test]
+    async fn record_activity_is_monotonic_and_listed() {
+        let pool = init_memory_db().await.unwrap();
+        db_execute!(
+            &pool,
+            "INSERT INTO quotas (username, max_storage, created_at, first_login_at, last_login_at)
+             VALUES ('old@x.org', 0, 10, 20, 30), ('busy@x.org', 0, 10, 20, 30)"
+        )
+        .unwrap();
+        record_activity(&pool, "busy@x.org", 2_000).await.unwrap();
+        record_activity(&pool, "busy@x.org", 1_500).await.unwrap();
+        assert_eq!(
+            list_last_activity(&pool).await.unwrap().get("busy@x.org"),
+            Some(&2_000)
+        );
+        let infos = list_account_quota_info(&pool).await.unwrap();
+        assert_eq!(infos["busy@x.org"].last_activity_at, 2_000);
+        assert_eq!(infos["old@x.org"].last_activity_at, 0);
+
+        assert_eq!(
+            list_inactive_accounts(&pool, 1_000).await.unwrap(),
+            vec!["old@x.org".to_string()]
+        );
+        delete_activity(&pool, "busy@x.org").await.unwrap();
+        assert_eq!(list_inactive_accounts(&pool, 1_000).await.unwrap().len(), 2);
+    }
+}
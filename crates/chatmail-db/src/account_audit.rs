@@ -0,0 +1,112 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Account security audit trail (`account_audit` table): password recoveries and other
+//! credential changes made without the operator, newest kept up to [`ACCOUNT_AUDIT_CAP`].
+
+use chatmail_types::Result;
+
+use crate::policies::unix_now;
+use crate::{db_execute, db_fetch_all, DbPool};
+
+/// Audit rows kept; older ones are trimmed on insert.
+pub const ACCOUNT_AUDIT_CAP: i64 = 1000;
+
+/// `action` of a password reset with a recovery code.
+pub const AUDIT_RECOVERED: &str = "recovered";
+
+/// `action` of a password change by the account owner (`POST /account/password`).
+pub const AUDIT_PASSWORD_CHANGED: &str = "password-changed";
+
+/// `action` of a device code exchanged for an app password (`POST /device-redeem`).
+pub const AUDIT_DEVICE_REDEEMED: &str = "device-redeemed";
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct AccountAudit {
+    pub id: i64,
+    pub action: String,
+    pub username: String,
+    /// Who acted: the client IP for self-service actions.
+    pub actor: String,
+    pub created_at: i64,
+}
+
+/// Append one audit row and trim the log to [`ACCOUNT_AUDIT_CAP`].
+pub async fn record_account_audit(
+    pool: &DbPool,
+    action: &str,
+    username: &str,
+    actor: &str,
+) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO account_audit (action, username, actor, created_at) VALUES (?, ?, ?, ?)",
+        action,
+        username,
+        actor,
+        unix_now()
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM account_audit WHERE id NOT IN
+         (SELECT id FROM account_audit ORDER BY id DESC LIMIT ?)",
+        ACCOUNT_AUDIT_CAP
+    )?;
+    Ok(())
+}
+
+/// Newest `limit` audit rows, newest first.
+pub async fn list_account_audit(pool: &DbPool, limit: i64) -> Result<Vec<AccountAudit>> {
+    let rows: Vec<(i64, String, String, String, i64)> = db_fetch_all!(
+        pool,
+        (i64, String, String, String, i64),
+        "SELECT id, action, username, actor, created_at FROM account_audit
+         ORDER BY id DESC LIMIT ?",
+        limit
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(|(id, action, username, actor, created_at)| AccountAudit {
+            id,
+            action,
+            username,
+            actor,
+            created_at,
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn audit_is_newest_first() {
+        let pool = init_memory_db().await.unwrap();
+        record_account_audit(&pool, AUDIT_RECOVERED, "a@x.org", "192.0.2.1")
+            .await
+            .unwrap();
+        record_account_audit(&pool, AUDIT_RECOVERED, "b@x.org", "")
+            .await
+            .unwrap();
+        let rows = list_account_audit(&pool, 10).await.unwrap();
+        let users: Vec<_> = rows.iter().map(|r| r.username.as_str()).collect();
+        assert_eq!(users, ["b@x.org", "a@x.org"]);
+        assert_eq!(rows[1].actor, "192.0.2.1");
+    }
+}
@@ -22,13 +22,15 @@ use std::collections::HashMap;
 use chatmail_types::Result;
 
 use crate::settings_keys::GLOBAL_QUOTA_USERNAME;
-use crate::{db_execute, db_fetch_all, DbPool};
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
 
 #[derive(Debug, Clone, Copy, Default)]
 pub struct AccountQuotaInfo {
     pub created_at: i64,
     pub first_login_at: i64,
     pub last_login_at: i64,
+    /// From `account_activity`; 0 when nothing was recorded yet.
+    pub last_activity_at: i64,
 }
 
 pub async fn list_account_quota_info(pool: &DbPool) -> Result<HashMap<String, AccountQuotaInfo>> {
@@ -39,22 +41,46 @@ pub async fn list_account_quota_info(pool: &DbPool) -> Result<HashMap<String, Ac
     );
     let rows: Vec<(String, i64, i64, i64)> =
         db_fetch_all!(pool, (String, i64, i64, i64), &sql, GLOBAL_QUOTA_USERNAME)?;
+    let activity = crate::account_activity::list_last_activity(pool).await?;
 
     Ok(rows
         .into_iter()
         .map(|(u, created_at, first_login_at, last_login_at)| {
+            let last_activity_at = activity.get(&u).copied().unwrap_or(0);
             (
                 u,
                 AccountQuotaInfo {
                     created_at,
                     first_login_at,
                     last_login_at,
+                    last_activity_at,
                 },
             )
         })
         .collect())
 }
 
+/// [`list_account_quota_info`] for one account; zeros when it has no `quotas` row.
+pub async fn account_quota_info(pool: &DbPool, username: &str) -> Result<AccountQuotaInfo> {
+    let qt = crate::schema::quota_table(pool).await?;
+    let sql =
+        format!("SELECT created_at, first_login_at, last_login_at FROM {qt} WHERE username = ?");
+    let row: Option<(i64, i64, i64)> = db_fetch_optional!(pool, (i64, i64, i64), &sql, username)?;
+    let (created_at, first_login_at, last_login_at) = row.unwrap_or_default();
+    let activity: Option<(i64,)> = db_fetch_optional!(
+        pool,
+        (i64,),
+        "SELECT last_activity_at FROM account_activity WHERE username = ?",
+        username
+    )?;
+    Ok(AccountQuotaInfo {
+        created_at,
+        first_login_at,
+        last_login_at,
+        last_activity_at: activity.map(|(at,)| at).unwrap_or(0),
+    })
+}
+
 pub async fn delete_quota_row(pool: &DbPool, username: &str) -> Result<()> {
     let qt = crate::schema::quota_table(pool).await?;
     let sql = format!("DELETE FROM {qt} WHERE username = ?");
@@ -62,6 +88,64 @@ pub async fn delete_quota_row(pool: &DbPool, username: &str) -> Result<()> {
     Ok(())
 }
 
+/// One account's `quotas` row, as moved between instances.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct QuotaRow {
+    pub max_storage: i64,
+    pub created_at: i64,
+    pub first_login_at: i64,
+    pub last_login_at: i64,
+}
+
+pub async fn get_quota_row(pool: &DbPool, username: &str) -> Result<Option<QuotaRow>> {
+    let qt = crate::schema::quota_table(pool).await?;
+    let sql = format!(
+        "SELECT max_storage, created_at, first_login_at, last_login_at FROM {qt}
+         WHERE username = ?"
+    );
+    let row = db_fetch_optional!(pool, (i64, i64, i64, i64), &sql, username)?;
+    Ok(row.map(
+        |(max_storage, created_at, first_login_at, last_login_at)| QuotaRow {
+            max_storage,
+            created_at,
+            first_login_at,
+            last_login_at,
+        },
+    ))
+}
+
+/// Insert or replace the `quotas` row of `username`.
+pub async fn put_quota_row(pool: &DbPool, username: &str, row: &QuotaRow) -> Result<()> {
+    let qt = crate::schema::quota_table(pool).await?;
+    let sql = format!(
+        "INSERT INTO {qt} (username, max_storage, created_at, first_login_at, last_login_at)
+         VALUES (?, ?, ?, ?, ?)
+         ON CONFLICT(username) DO UPDATE SET max_storage = excluded.max_storage,
+             created_at = excluded.created_at, first_login_at = excluded.first_login_at,
+             last_login_at = excluded.last_login_at"
+    );
+    db_execute!(
+        pool,
+        &sql,
+        username,
+        row.max_storage,
+        row.created_at,
+        row.first_login_at,
+        row.last_login_at
+    )?;
+    Ok(())
+}
+
+/// Copy one account's `quotas` row between databases (storage migration to a new
+/// instance). Returns false when the source has no row for `username`.
+pub async fn copy_quota_row(src: &DbPool, dst: &DbPool, username: &str) -> Result<bool> {
+    let Some(row) = get_quota_row(src, username).await? else {
+        return Ok(false);
+    };
+    put_quota_row(dst, username, &row).await?;
+    Ok(true)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -94,5 +178,37 @@ mod tests {
         let info = map.get("alice@x.org").unwrap();
         assert_eq!(info.created_at, 100);
         assert_eq!(info.first_login_at, 1);
+
+        let one = account_quota_info(&pool, "alice@x.org").await.unwrap();
+        assert_eq!((one.created_at, one.first_login_at), (100, 1));
+        assert_eq!(
+            account_quota_info(&pool, "bob@x.org")
+                .await
+                .unwrap()
+                .created_at,
+            0
+        );
+    }
+
+    #[tokio::test]
+    async fn copy_quota_row_between_databases() {
+        let src = init_memory_db().await.unwrap();
+        let dst = init_memory_db().await.unwrap();
+        db_execute!(
+            &src,
+            "INSERT INTO quotas (username, max_storage, created_at, first_login_at, last_login_at)
+             VALUES ('bob@x.org', 5000, 10, 20, 30)"
+        )
+        .unwrap();
+        assert!(copy_quota_row(&src, &dst, "bob@x.org").await.unwrap());
+        assert!(!copy_quota_row(&src, &dst, "nobody@x.org").await.unwrap());
+        let row: Option<(i64, i64)> = db_fetch_optional!(
+            &dst,
+            (i64, i64),
+            "SELECT max_storage, last_login_at FROM quotas WHERE username = ?",
+            "bob@x.org"
+        )
+        .unwrap();
+        assert_eq!(row, Some((5000, 30)));
     }
 }
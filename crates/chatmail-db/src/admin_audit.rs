@@ -0,0 +1,290 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Admin audit trail (`admin_audit` table): every mutating admin API request with the
+//! caller's token prefix and address, the redacted body and the response status, plus the
+//! settings changes the CLI makes. Settings, quota and account changes also carry the
+//! redacted value before and after. Newest rows are kept up to [`ADMIN_AUDIT_CAP`], and
+//! [`prune_admin_audit`] drops rows past `audit_retention`.
+
+use chatmail_types::Result;
+
+use crate::policies::unix_now;
+use crate::pool::pg_sql;
+use crate::{db_execute, db_fetch_all, db_fetch_scalar, DbPool};
+
+/// Audit rows kept; older ones are trimmed on insert.
+pub const ADMIN_AUDIT_CAP: i64 = 10_000;
+
+/// Characters of the admin token stored with each row.
+pub const AUDIT_TOKEN_PREFIX_CHARS: usize = 8;
+
+/// `actor` of rows written by the admin API.
+pub const AUDIT_ACTOR_API: &str = "admin-api";
+/// `actor` of rows written by `madmail` CLI commands.
+pub const AUDIT_ACTOR_CLI: &str = "cli";
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct AdminAudit {
+    pub id: i64,
+    pub created_at: i64,
+    /// First [`AUDIT_TOKEN_PREFIX_CHARS`] characters of the bearer token.
+    pub token_prefix: String,
+    pub method: String,
+    pub resource: String,
+    /// Request body as JSON with secrets replaced by the caller.
+    pub body: String,
+    /// Envelope status of the response.
+    pub status: i32,
+    pub remote: String,
+    /// [`AUDIT_ACTOR_API`] or [`AUDIT_ACTOR_CLI`].
+    pub actor: String,
+    /// Redacted value the change replaced; empty when not captured.
+    pub old_value: String,
+    /// Redacted value after the change; empty when not captured.
+    pub new_value: String,
+}
+
+/// Time window, resource prefix and page of [`list_admin_audit`]; `from` / `to` are
+/// inclusive unix seconds.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct AdminAuditQuery {
+    pub from: i64,
+    pub to: i64,
+    /// Only rows whose resource starts with this (`/admin/settings/`, `__TURN`).
+    pub resource: Option<String>,
+    pub offset: i64,
+    pub limit: i64,
+}
+
+impl Default for AdminAuditQuery {
+    fn default() -> Self {
+        Self {
+            from: 0,
+            to: i64::MAX,
+            resource: None,
+            offset: 0,
+            limit: 100,
+        }
+    }
+}
+
+type AdminAuditRow = (
+    i64,
+    i64,
+    String,
+    String,
+    String,
+    String,
+    i32,
+    String,
+    String,
+    String,
+    String,
+);
+
+/// Append one audit row (`id` and `created_at` of `entry` are ignored) and trim the log
+/// to [`ADMIN_AUDIT_CAP`].
+pub async fn record_admin_audit(pool: &DbPool, entry: &AdminAudit) -> Result<()> {
+    let token_prefix: String = entry
+        .token_prefix
+        .chars()
+        .take(AUDIT_TOKEN_PREFIX_CHARS)
+        .collect();
+    db_execute!(
+        pool,
+        "INSERT INTO admin_audit (created_at, token_prefix, method, resource, body, status,
+         remote, actor, old_value, new_value)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+        unix_now(),
+        token_prefix,
+        entry.method.as_str(),
+        entry.resource.as_str(),
+        entry.body.as_str(),
+        entry.status,
+        entry.remote.as_str(),
+        entry.actor.as_str(),
+        entry.old_value.as_str(),
+        entry.new_value.as_str()
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM admin_audit WHERE id NOT IN
+         (SELECT id FROM admin_audit ORDER BY id DESC LIMIT ?)",
+        ADMIN_AUDIT_CAP
+    )?;
+    Ok(())
+}
+
+/// One page of audit rows matching `query`, newest first, and how many rows match.
+pub async fn list_admin_audit(
+    pool: &DbPool,
+    query: &AdminAuditQuery,
+) -> Result<(Vec<AdminAudit>, i64)> {
+    // `substr` rather than `LIKE`: resource paths and setting keys contain `_`.
+    let prefix = query.resource.as_deref().unwrap_or("");
+    let prefix_len = prefix.chars().count() as i32;
+    let rows: Vec<AdminAuditRow> = db_fetch_all!(
+        pool,
+        AdminAuditRow,
+        "SELECT id, created_at, token_prefix, method, resource, body, status, remote, actor,
+         old_value, new_value
+         FROM admin_audit WHERE created_at >= ? AND created_at <= ?
+         AND substr(resource, 1, ?) = ?
+         ORDER BY id DESC LIMIT ? OFFSET ?",
+        query.from,
+        query.to,
+        prefix_len,
+        prefix,
+        query.limit,
+        query.offset
+    )?;
+    let total = db_fetch_scalar!(
+        pool,
+        i64,
+        "SELECT COUNT(*) FROM admin_audit WHERE created_at >= ? AND created_at <= ?
+         AND substr(resource, 1, ?) = ?",
+        query.from,
+        query.to,
+        prefix_len,
+        prefix
+    )?;
+    let rows = rows
+        .into_iter()
+        .map(|row| AdminAudit {
+            id: row.0,
+            created_at: row.1,
+            token_prefix: row.2,
+            method: row.3,
+            resource: row.4,
+            body: row.5,
+            status: row.6,
+            remote: row.7,
+            actor: row.8,
+            old_value: row.9,
+            new_value: row.10,
+        })
+        .collect();
+    Ok((rows, total))
+}
+
+/// Delete rows created before `before` (unix seconds); returns how many went.
+pub async fn prune_admin_audit(pool: &DbPool, before: i64) -> Result<u64> {
+    const PRUNE: &str = "DELETE FROM admin_audit WHERE created_at < ?";
+    let deleted = match pool {
+        DbPool::Sqlite(p) => sqlx::query(PRUNE)
+            .bind(before)
+            .execute(p)
+            .await?
+            .rows_affected(),
+        DbPool::Postgres(p) => sqlx::query(&pg_sql(PRUNE))
+            .bind(before)
+            .execute(p)
+            .await?
+            .rows_affected(),
+    };
+    Ok(deleted)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    fn entry(resource: &str, status: i32) -> AdminAudit {
+        AdminAudit {
+            id: 0,
+            created_at: 0,
+            token_prefix: "0123456789abcdef".into(),
+            method: "POST".into(),
+            resource: resource.into(),
+            body: "{}".into(),
+            status,
+            remote: "192.0.2.1".into(),
+            actor: AUDIT_ACTOR_API.into(),
+            old_value: String::new(),
+            new_value: String::new(),
+        }
+    }
+
+    #[tokio::test]
+    async fn audit_pages_newest_first_with_short_token() {
+        let pool = init_memory_db().await.unwrap();
+        for (i, resource) in ["/admin/a", "/admin/b", "/admin/c"].iter().enumerate() {
+            record_admin_audit(&pool, &entry(resource, 200 + i as i32))
+                .await
+                .unwrap();
+        }
+        let page = AdminAuditQuery {
+            offset: 1,
+            limit: 1,
+            ..Default::default()
+        };
+        let (rows, total) = list_admin_audit(&pool, &page).await.unwrap();
+        assert_eq!(total, 3);
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0].resource, "/admin/b");
+        assert_eq!(rows[0].token_prefix, "01234567");
+        assert_eq!(rows[0].status, 201);
+
+        let future = AdminAuditQuery {
+            from: unix_now() + 3600,
+            ..Default::default()
+        };
+        let (rows, total) = list_admin_audit(&pool, &future).await.unwrap();
+        assert!(rows.is_empty());
+        assert_eq!(total, 0);
+    }
+
+    #[tokio::test]
+    async fn audit_keeps_values_filters_by_resource_and_prunes() {
+        let pool = init_memory_db().await.unwrap();
+        record_admin_audit(&pool, &entry("/admin/settings/smtp_port", 200))
+            .await
+            .unwrap();
+        let change = AdminAudit {
+            method: "SET".into(),
+            actor: AUDIT_ACTOR_CLI.into(),
+            old_value: "\"open\"".into(),
+            new_value: "\"closed\"".into(),
+            ..entry("__REGISTRATION_OPEN__", 0)
+        };
+        record_admin_audit(&pool, &change).await.unwrap();
+
+        let query = AdminAuditQuery {
+            resource: Some("__REG".into()),
+            ..Default::default()
+        };
+        let (rows, total) = list_admin_audit(&pool, &query).await.unwrap();
+        assert_eq!(total, 1);
+        assert_eq!(rows[0].actor, AUDIT_ACTOR_CLI);
+        assert_eq!(
+            (rows[0].old_value.as_str(), rows[0].new_value.as_str()),
+            ("\"open\"", "\"closed\"")
+        );
+        let query = AdminAuditQuery {
+            resource: Some("/admin/settings_".into()),
+            ..Default::default()
+        };
+        assert_eq!(list_admin_audit(&pool, &query).await.unwrap().1, 0);
+
+        assert_eq!(prune_admin_audit(&pool, unix_now() - 60).await.unwrap(), 0);
+        assert_eq!(prune_admin_audit(&pool, unix_now() + 60).await.unwrap(), 2);
+        let (_, total) = list_admin_audit(&pool, &Default::default()).await.unwrap();
+        assert_eq!(total, 0);
+    }
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-device app passwords (`app_passwords` table).
+//!
+//! An app password authenticates the same account as the primary password but can be listed
+//! and revoked on its own. Hashes use the same format as `passwords`.
+
+use chatmail_types::Result;
+
+use crate::{db_execute, db_fetch_all, DbPool};
+
+/// App password metadata (never includes the hash).
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct AppPassword {
+    pub id: i64,
+    pub label: String,
+    pub created_at: i64,
+}
+
+pub async fn create_app_password(
+    pool: &DbPool,
+    username: &str,
+    label: &str,
+    hash: &str,
+) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO app_passwords (username, label, hash, created_at) VALUES (?, ?, ?, ?)",
+        username,
+        label,
+        hash,
+        crate::policies::unix_now()
+    )?;
+    Ok(())
+}
+
+/// App passwords for `username`, oldest first.
+pub async fn list_app_passwords(pool: &DbPool, username: &str) -> Result<Vec<AppPassword>> {
+    let rows: Vec<(i64, String, i64)> = db_fetch_all!(
+        pool,
+        (i64, String, i64),
+        "SELECT id, label, created_at FROM app_passwords WHERE username = ? ORDER BY id",
+        username
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(|(id, label, created_at)| AppPassword {
+            id,
+            label,
+            created_at,
+        })
+        .collect())
+}
+
+/// Stored hashes for `username` (login fallback after the primary password fails).
+pub async fn app_password_hashes(pool: &DbPool, username: &str) -> Result<Vec<String>> {
+    let rows: Vec<(String,)> = db_fetch_all!(
+        pool,
+        (String,),
+        "SELECT hash FROM app_passwords WHERE username = ?",
+        username
+    )?;
+    Ok(rows.into_iter().map(|(h,)| h).collect())
+}
+
+/// Drop every app password for `username` (account deletion, password change or reset).
+pub async fn delete_app_passwords(pool: &DbPool, username: &str) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM app_passwords WHERE username = ?",
+        username
+    )?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn create_list_and_delete() {
+        let pool = init_memory_db().await.unwrap();
+        create_app_password(&pool, "a@x.org", "phone", "sha256:1")
+            .await
+            .unwrap();
+        create_app_password(&pool, "a@x.org", "laptop", "sha256:2")
+            .await
+            .unwrap();
+        create_app_password(&pool, "b@x.org", "phone", "sha256:3")
+            .await
+            .unwrap();
+
+        let listed = list_app_passwords(&pool, "a@x.org").await.unwrap();
+        let labels: Vec<_> = listed.iter().map(|p| p.label.as_str()).collect();
+        assert_eq!(labels, ["phone", "laptop"]);
+        assert_eq!(
+            app_password_hashes(&pool, "a@x.org").await.unwrap(),
+            ["sha256:1", "sha256:2"]
+        );
+
+        delete_app_passwords(&pool, "a@x.org").await.unwrap();
+        assert!(list_app_passwords(&pool, "a@x.org")
+            .await
+            .unwrap()
+            .is_empty());
+        assert_eq!(list_app_passwords(&pool, "b@x.org").await.unwrap().len(), 1);
+    }
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-account message size caps (`append_limits` table), set with
+//! `imap-acct appendlimit`.
+//!
+//! A row caps what IMAP APPEND and local delivery accept for one account; the global
+//! `appendlimit` / `max_message_size` stays the ceiling. Accounts without a row (or with
+//! 0) get the global cap. The runtime copy lives in `chatmail-state`.
+
+use std::collections::HashMap;
+
+use chatmail_types::Result;
+
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
+
+/// `username`'s own cap in bytes; `None` when unset (global cap applies).
+pub async fn get_append_limit(pool: &DbPool, username: &str) -> Result<Option<u64>> {
+    let row: Option<(i64,)> = db_fetch_optional!(
+        pool,
+        (i64,),
+        "SELECT max_bytes FROM append_limits WHERE username = ?",
+        username
+    )?;
+    Ok(row.map(|(b,)| b.max(0) as u64).filter(|b| *b > 0))
+}
+
+/// Set `username`'s cap; 0 removes it.
+pub async fn set_append_limit(pool: &DbPool, username: &str, max_bytes: u64) -> Result<()> {
+    if max_bytes == 0 {
+        return delete_append_limit(pool, username).await;
+    }
+    let max_bytes = i64::try_from(max_bytes).unwrap_or(i64::MAX);
+    db_execute!(
+        pool,
+        "INSERT INTO append_limits (username, max_bytes) VALUES (?, ?)
+         ON CONFLICT (username) DO UPDATE SET max_bytes = excluded.max_bytes",
+        username,
+        max_bytes
+    )?;
+    Ok(())
+}
+
+pub async fn delete_append_limit(pool: &DbPool, username: &str) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM append_limits WHERE username = ?",
+        username
+    )?;
+    Ok(())
+}
+
+pub async fn list_append_limits(pool: &DbPool) -> Result<HashMap<String, u64>> {
+    let rows: Vec<(String, i64)> = db_fetch_all!(
+        pool,
+        (String, i64),
+        "SELECT username, max_bytes FROM append_limits WHERE max_bytes > 0"
+    )?;
+    Ok(rows.into_iter().map(|(u, b)| (u, b as u64)).collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn set_get_and_clear_with_zero() {
+        let pool = init_memory_db().await.unwrap();
+        assert_eq!(get_append_limit(&pool, "a@x").await.unwrap(), None);
+        set_append_limit(&pool, "a@x", 4096).await.unwrap();
+        set_append_limit(&pool, "a@x", 2048).await.unwrap();
+        assert_eq!(get_append_limit(&pool, "a@x").await.unwrap(), Some(2048));
+        assert_eq!(list_append_limits(&pool).await.unwrap().len(), 1);
+        set_append_limit(&pool, "a@x", 0).await.unwrap();
+        assert_eq!(get_append_limit(&pool, "a@x").await.unwrap(), None);
+        assert!(list_append_limits(&pool).await.unwrap().is_empty());
+    }
+}
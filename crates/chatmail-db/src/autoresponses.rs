@@ -0,0 +1,339 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-account automatic replies (`autoresponses` table): vacation notices, "this account
+//! has moved", set with `madmail autoresponse` or `/admin/accounts/{username}/autoresponse`.
+//!
+//! A row holds the reply subject and body, an on/off switch and an optional start / end
+//! time. `autoresponse_replies` remembers when each correspondent was last answered, so a
+//! sender gets one reply per suppression window (`autoresponse_window`, 7 days by default).
+
+use chatmail_types::{ChatmailError, Result};
+
+use crate::policies::unix_now;
+use crate::responders::{claim_reply, normalize_address, RESPONDER_TEMPLATE_MAX};
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
+
+/// Suppression window when `autoresponse_window` is unset: one reply per sender per 7 days.
+pub const DEFAULT_AUTORESPONSE_WINDOW_SECS: i64 = 7 * 24 * 3600;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Autoresponse {
+    pub username: String,
+    /// Switched off rows are kept (with their text) but never answer.
+    pub active: bool,
+    /// Reply subject; empty means `Auto: <original subject>`.
+    pub subject: String,
+    pub body: String,
+    /// Unix seconds; no replies before this.
+    pub starts_at: Option<i64>,
+    /// Unix seconds; no replies from this on.
+    pub ends_at: Option<i64>,
+    pub updated_at: i64,
+}
+
+impl Autoresponse {
+    /// `true` when mail arriving at `now` should be answered.
+    pub fn in_effect(&self, now: i64) -> bool {
+        self.active
+            && self.starts_at.is_none_or(|t| now >= t)
+            && self.ends_at.is_none_or(|t| now < t)
+    }
+}
+
+type AutoresponseRow = (String, i32, String, String, i64, i64, i64);
+
+fn autoresponse_from_row(
+    (username, active, subject, body, starts_at, ends_at, updated_at): AutoresponseRow,
+) -> Autoresponse {
+    Autoresponse {
+        username,
+        active: active != 0,
+        subject,
+        body,
+        starts_at: (starts_at > 0).then_some(starts_at),
+        ends_at: (ends_at > 0).then_some(ends_at),
+        updated_at,
+    }
+}
+
+const SELECT_AUTORESPONSE: &str =
+    "SELECT username, active, subject, body, starts_at, ends_at, updated_at FROM autoresponses";
+
+pub async fn get_autoresponse(pool: &DbPool, username: &str) -> Result<Option<Autoresponse>> {
+    let username = normalize_address(username)?;
+    let row: Option<AutoresponseRow> = db_fetch_optional!(
+        pool,
+        AutoresponseRow,
+        &format!("{SELECT_AUTORESPONSE} WHERE username = ?"),
+        username.as_str()
+    )?;
+    Ok(row.map(autoresponse_from_row))
+}
+
+/// Add or replace `autoresponse.username`'s reply; `updated_at` is set to now. Replacing
+/// keeps the reply log, so an edit does not re-answer everyone already answered.
+pub async fn set_autoresponse(pool: &DbPool, autoresponse: &Autoresponse) -> Result<Autoresponse> {
+    let username = normalize_address(&autoresponse.username)?;
+    let subject = autoresponse.subject.trim();
+    if subject.contains(['\r', '\n']) {
+        return Err(ChatmailError::config(
+            "autoresponse subject must be one line",
+        ));
+    }
+    if autoresponse.body.trim().is_empty() {
+        return Err(ChatmailError::config("autoresponse body is empty"));
+    }
+    if autoresponse.body.len() + subject.len() > RESPONDER_TEMPLATE_MAX {
+        return Err(ChatmailError::config(format!(
+            "autoresponse exceeds {RESPONDER_TEMPLATE_MAX} bytes"
+        )));
+    }
+    if let (Some(start), Some(end)) = (autoresponse.starts_at, autoresponse.ends_at) {
+        if end <= start {
+            return Err(ChatmailError::config(
+                "autoresponse must end after it starts",
+            ));
+        }
+    }
+    if autoresponse.starts_at.is_some_and(|t| t <= 0)
+        || autoresponse.ends_at.is_some_and(|t| t <= 0)
+    {
+        return Err(ChatmailError::config("autoresponse times must be positive"));
+    }
+    let stored = Autoresponse {
+        username,
+        subject: subject.to_string(),
+        updated_at: unix_now(),
+        ..autoresponse.clone()
+    };
+    db_execute!(
+        pool,
+        "INSERT INTO autoresponses
+             (username, active, subject, body, starts_at, ends_at, updated_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?)
+         ON CONFLICT(username) DO UPDATE SET
+             active = excluded.active, subject = excluded.subject, body = excluded.body,
+             starts_at = excluded.starts_at, ends_at = excluded.ends_at,
+             updated_at = excluded.updated_at",
+        stored.username.as_str(),
+        i32::from(stored.active),
+        stored.subject.as_str(),
+        stored.body.as_str(),
+        stored.starts_at.unwrap_or(0),
+        stored.ends_at.unwrap_or(0),
+        stored.updated_at
+    )?;
+    Ok(stored)
+}
+
+/// Delete `username`'s autoresponse and reply log. Returns `false` when there was none.
+pub async fn clear_autoresponse(pool: &DbPool, username: &str) -> Result<bool> {
+    if get_autoresponse(pool, username).await?.is_none() {
+        return Ok(false);
+    }
+    delete_autoresponse(pool, &normalize_address(username)?).await?;
+    Ok(true)
+}
+
+/// Account removal: drop the row and reply log without validating the name.
+pub async fn delete_autoresponse(pool: &DbPool, username: &str) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM autoresponses WHERE username = ?",
+        username
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM autoresponse_replies WHERE username = ?",
+        username
+    )?;
+    Ok(())
+}
+
+/// All autoresponses ordered by username, switched off ones included.
+pub async fn list_autoresponses(pool: &DbPool) -> Result<Vec<Autoresponse>> {
+    let rows: Vec<AutoresponseRow> = db_fetch_all!(
+        pool,
+        AutoresponseRow,
+        &format!("{SELECT_AUTORESPONSE} ORDER BY username")
+    )?;
+    Ok(rows.into_iter().map(autoresponse_from_row).collect())
+}
+
+/// When `username`'s autoresponse last answered `correspondent` (Unix seconds).
+pub async fn last_autoresponse_reply(
+    pool: &DbPool,
+    username: &str,
+    correspondent: &str,
+) -> Result<Option<i64>> {
+    let row: Option<(i64,)> = db_fetch_optional!(
+        pool,
+        (i64,),
+        "SELECT sent_at FROM autoresponse_replies WHERE username = ? AND correspondent = ?",
+        username.trim().to_ascii_lowercase(),
+        correspondent.trim().to_ascii_lowercase()
+    )?;
+    Ok(row.map(|(t,)| t))
+}
+
+/// Reserve a reply from `username` to `correspondent` at `now`; `false` when the previous
+/// one is less than `window_secs` old. Atomic, like [`crate::claim_responder_reply`].
+pub async fn claim_autoresponse_reply(
+    pool: &DbPool,
+    username: &str,
+    correspondent: &str,
+    window_secs: i64,
+    now: i64,
+) -> Result<bool> {
+    const CLAIM: &str = "INSERT INTO autoresponse_replies (username, correspondent, sent_at)
+         VALUES (?, ?, ?)
+         ON CONFLICT(username, correspondent) DO UPDATE SET sent_at = excluded.sent_at
+         WHERE autoresponse_replies.sent_at <= ?";
+    claim_reply(pool, CLAIM, username, correspondent, window_secs, now).await
+}
+
+/// Drop reply rows older than `window_secs`, and rows whose autoresponse is gone.
+pub async fn prune_autoresponse_replies(pool: &DbPool, window_secs: i64) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM autoresponse_replies WHERE sent_at < ?
+             OR username NOT IN (SELECT username FROM autoresponses)",
+        unix_now().saturating_sub(window_secs)
+    )?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    fn away(username: &str) -> Autoresponse {
+        Autoresponse {
+            username: username.into(),
+            active: true,
+            subject: "Away".into(),
+            body: "Back on Monday.".into(),
+            starts_at: None,
+            ends_at: None,
+            updated_at: 0,
+        }
+    }
+
+    #[tokio::test]
+    async fn set_replace_get_clear() {
+        let pool = init_memory_db().await.unwrap();
+        set_autoresponse(&pool, &away(" Alice@Example.org "))
+            .await
+            .unwrap();
+        let replaced = Autoresponse {
+            active: false,
+            starts_at: Some(100),
+            ends_at: Some(200),
+            ..away("alice@example.org")
+        };
+        set_autoresponse(&pool, &replaced).await.unwrap();
+        let got = get_autoresponse(&pool, "ALICE@example.org")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(got.username, "alice@example.org");
+        assert!(!got.active);
+        assert_eq!((got.starts_at, got.ends_at), (Some(100), Some(200)));
+        assert_eq!(list_autoresponses(&pool).await.unwrap().len(), 1);
+
+        for bad in [
+            away("alice"),
+            Autoresponse {
+                body: " ".into(),
+                ..away("a@b")
+            },
+            Autoresponse {
+                subject: "two\nlines".into(),
+                ..away("a@b")
+            },
+            Autoresponse {
+                starts_at: Some(200),
+                ends_at: Some(200),
+                ..away("a@b")
+            },
+        ] {
+            assert!(set_autoresponse(&pool, &bad).await.is_err(), "{bad:?}");
+        }
+
+        assert!(clear_autoresponse(&pool, "alice@example.org")
+            .await
+            .unwrap());
+        assert!(!clear_autoresponse(&pool, "alice@example.org")
+            .await
+            .unwrap());
+        assert!(list_autoresponses(&pool).await.unwrap().is_empty());
+    }
+
+    #[test]
+    fn in_effect_honours_switch_and_window() {
+        let timed = Autoresponse {
+            starts_at: Some(100),
+            ends_at: Some(200),
+            ..away("a@b")
+        };
+        assert!(away("a@b").in_effect(1));
+        assert!(!timed.in_effect(99));
+        assert!(timed.in_effect(100));
+        assert!(!timed.in_effect(200));
+        let off = Autoresponse {
+            active: false,
+            ..away("a@b")
+        };
+        assert!(!off.in_effect(150));
+    }
+
+    #[tokio::test]
+    async fn claim_and_prune_follow_the_window() {
+        let pool = init_memory_db().await.unwrap();
+        set_autoresponse(&pool, &away("a@x")).await.unwrap();
+        let now = unix_now();
+        let week = DEFAULT_AUTORESPONSE_WINDOW_SECS;
+        assert!(
+            claim_autoresponse_reply(&pool, "a@x", "bob@y", week, now - 60)
+                .await
+                .unwrap()
+        );
+        assert!(!claim_autoresponse_reply(&pool, "a@x", "Bob@y", week, now)
+            .await
+            .unwrap());
+        claim_autoresponse_reply(&pool, "a@x", "old@y", week, now - week - 60)
+            .await
+            .unwrap();
+        claim_autoresponse_reply(&pool, "gone@x", "bob@y", week, now)
+            .await
+            .unwrap();
+
+        prune_autoresponse_replies(&pool, week).await.unwrap();
+        for (username, correspondent, kept) in [
+            ("a@x", "bob@y", true),
+            ("a@x", "old@y", false),
+            ("gone@x", "bob@y", false),
+        ] {
+            let last = last_autoresponse_reply(&pool, username, correspondent)
+                .await
+                .unwrap();
+            assert_eq!(last.is_some(), kept, "{username} -> {correspondent}");
+        }
+    }
+}
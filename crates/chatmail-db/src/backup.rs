@@ -0,0 +1,225 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Online copies of the SQLite databases (`db backup`, `POST /admin/backup`).
+//!
+//! Each database is copied with `VACUUM INTO` over its own read-only connection. SQLite
+//! reads the source inside one read transaction, so the copy is consistent while deliveries
+//! keep writing through the WAL, and writers are never blocked. The copy lands next to its
+//! final name and is renamed into place, so a file named `{name}-{stamp}.db` is always
+//! complete. Databases are copied one after another and reported one by one: a failure
+//! leaves the others alone.
+
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::time::Duration;
+
+use chatmail_config::{effective_database_config, AppConfig};
+use chatmail_types::{ChatmailError, Result};
+use sqlx::sqlite::{SqliteConnectOptions, SqliteConnection};
+use sqlx::Connection;
+
+/// Directory under `state_dir` that `POST /admin/backup` writes to.
+pub const BACKUP_DIR: &str = "backups";
+
+/// How long a copy waits for a writer holding the database lock (rollback-journal DBs).
+const BACKUP_BUSY_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// Outcome of copying one database.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DatabaseBackup {
+    /// `credentials` (or `chatmail`) for the app DB, `sharing` for the contact sharing DB.
+    pub name: String,
+    pub source: PathBuf,
+    /// The finished copy; `None` when it failed.
+    pub output: Option<PathBuf>,
+    pub bytes: u64,
+    pub error: Option<String>,
+}
+
+/// The SQLite databases of this server as `(name, path)`: the app DB (credentials,
+/// mailbox metadata and settings) and, when it exists, the contact sharing DB.
+///
+/// Fails for a Postgres app DB, which `VACUUM INTO` cannot copy.
+pub fn sqlite_databases(state_dir: &Path, config: &AppConfig) -> Result<Vec<(String, PathBuf)>> {
+    let app = effective_database_config(state_dir, config);
+    if app.is_postgres() {
+        return Err(ChatmailError::config(
+            "database backup is not supported for the postgres driver; use pg_dump",
+        ));
+    }
+    let app = PathBuf::from(app.dsn);
+    let mut dbs = vec![(db_name(&app), app)];
+    let sharing = config.sharing_db_path(state_dir);
+    if sharing.is_file() && !dbs.iter().any(|(_, path)| *path == sharing) {
+        dbs.push(("sharing".to_string(), sharing));
+    }
+    Ok(dbs)
+}
+
+fn db_name(path: &Path) -> String {
+    path.file_stem()
+        .map(|s| s.to_string_lossy().into_owned())
+        .filter(|s| !s.is_empty())
+        .unwrap_or_else(|| "database".to_string())
+}
+
+/// `20261016T093000Z` for a Unix time, as used in backup file names.
+pub fn backup_stamp(unix: i64) -> String {
+    let Ok(t) = time::OffsetDateTime::from_unix_timestamp(unix) else {
+        return unix.to_string();
+    };
+    format!(
+        "{:04}{:02}{:02}T{:02}{:02}{:02}Z",
+        t.year(),
+        u8::from(t.month()),
+        t.day(),
+        t.hour(),
+        t.minute(),
+        t.second()
+    )
+}
+
+/// Copy every database in `dbs` to `dir/{name}-{stamp}.db`, creating `dir` if needed.
+pub async fn backup_databases(
+    dbs: &[(String, PathBuf)],
+    dir: &Path,
+    stamp: &str,
+) -> Vec<DatabaseBackup> {
+    let mut out = Vec::with_capacity(dbs.len());
+    for (name, source) in dbs {
+        let dest = dir.join(format!("{name}-{stamp}.db"));
+        let result = copy_database(source, &dest).await;
+        if let Err(e) = &result {
+            tracing::warn!(db = %source.display(), error = %e, "database backup failed");
+        }
+        out.push(match result {
+            Ok(bytes) => DatabaseBackup {
+                name: name.clone(),
+                source: source.clone(),
+                output: Some(dest),
+                bytes,
+                error: None,
+            },
+            Err(e) => DatabaseBackup {
+                name: name.clone(),
+                source: source.clone(),
+                output: None,
+                bytes: 0,
+                error: Some(e.to_string()),
+            },
+        });
+    }
+    out
+}
+
+async fn copy_database(source: &Path, dest: &Path) -> Result<u64> {
+    if !source.is_file() {
+        return Err(ChatmailError::config(format!(
+            "no database at {}",
+            source.display()
+        )));
+    }
+    if let Some(dir) = dest.parent() {
+        tokio::fs::create_dir_all(dir).await?;
+    }
+    let name = dest.file_name().unwrap_or_default().to_string_lossy();
+    let staging = dest.with_file_name(format!(".{name}.tmp"));
+    let copied = vacuum_into(source, &staging).await;
+    let renamed = match copied {
+        Ok(()) => tokio::fs::rename(&staging, dest).await.map_err(Into::into),
+        Err(e) => Err(e),
+    };
+    if let Err(e) = renamed {
+        let _ = tokio::fs::remove_file(&staging).await;
+        return Err(e);
+    }
+    Ok(tokio::fs::metadata(dest).await?.len())
+}
+
+/// Consistent copy of a live SQLite DB at `dest`. `VACUUM INTO` refuses to overwrite, so
+/// `dest` is cleared first.
+pub async fn vacuum_into(db: &Path, dest: &Path) -> Result<()> {
+    let _ = tokio::fs::remove_file(dest).await;
+    let options = SqliteConnectOptions::from_str(&format!("sqlite:{}", db.display()))?
+        .read_only(true)
+        .busy_timeout(BACKUP_BUSY_TIMEOUT);
+    let mut conn = SqliteConnection::connect_with(&options).await?;
+    sqlx::query("VACUUM INTO ?")
+        .bind(dest.to_string_lossy().into_owned())
+        .execute(&mut conn)
+        .await?;
+    conn.close().await?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn stamp_is_utc_and_sortable() {
+        assert_eq!(backup_stamp(0), "19700101T000000Z");
+        assert_eq!(backup_stamp(1_791_797_400), "20261012T093000Z");
+    }
+
+    #[tokio::test]
+    async fn copies_live_databases_and_reports_each() {
+        let dir = tempfile::tempdir().unwrap();
+        let app = dir.path().join("credentials.db");
+        let pool = crate::init_db(&app).await.unwrap();
+        crate::passwords::create_user(&pool, "u@x.org", "bcrypt:x")
+            .await
+            .unwrap();
+        let dbs = vec![
+            ("credentials".to_string(), app.clone()),
+            ("sharing".to_string(), dir.path().join("missing.db")),
+        ];
+
+        // The pool stays open, as it does under a running server.
+        let out = dir.path().join("backups");
+        let report = backup_databases(&dbs, &out, "20260101T000000Z").await;
+        assert_eq!(report.len(), 2);
+        let copy = report[0].output.clone().unwrap();
+        assert_eq!(copy, out.join("credentials-20260101T000000Z.db"));
+        assert_eq!(report[0].bytes, std::fs::metadata(&copy).unwrap().len());
+        assert!(report[1].output.is_none());
+        assert!(report[1].error.as_deref().unwrap().contains("no database"));
+
+        let leftovers: Vec<_> = std::fs::read_dir(&out)
+            .unwrap()
+            .map(|e| e.unwrap().file_name())
+            .collect();
+        assert_eq!(leftovers.len(), 1, "no staging files left: {leftovers:?}");
+
+        let restored = crate::init_db(&copy).await.unwrap();
+        assert!(crate::passwords::user_exists(&restored, "u@x.org")
+            .await
+            .unwrap());
+    }
+
+    #[test]
+    fn postgres_is_refused() {
+        let cfg = AppConfig {
+            credentials_driver: Some("postgres".into()),
+            credentials_dsn: Some("host=127.0.0.1 dbname=maddy".into()),
+            ..AppConfig::default()
+        };
+        let err = sqlite_databases(Path::new("/var/lib/maddy"), &cfg).unwrap_err();
+        assert!(err.to_string().contains("pg_dump"), "{err}");
+    }
+}
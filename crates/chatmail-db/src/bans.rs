@@ -0,0 +1,467 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! IP / network and TLS fingerprint bans (`bans` table) and their audit trail (`ban_audit`).
+//!
+//! Rows are CIDRs in canonical form (`203.0.113.7/32`, `2001:db8::/48`) or JA4 ClientHello
+//! fingerprints prefixed with `ja4:`; the `cidr` column holds either. `expires_at = 0` is
+//! permanent. `strikes` counts automatic bans of the same CIDR so repeat offenders get
+//! longer bans; the row (and its count) is kept for a while after it expires.
+
+use std::net::IpAddr;
+
+use chatmail_types::{ChatmailError, Result};
+
+use crate::policies::unix_now;
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
+
+pub const BAN_SOURCE_MANUAL: &str = "manual";
+pub const BAN_SOURCE_AUTH: &str = "auth";
+pub const BAN_SOURCE_REGISTRATION: &str = "registration";
+pub const BAN_SOURCE_ADMIN: &str = "admin";
+
+/// Prefix marking a `bans` row as a JA4 TLS fingerprint rather than a CIDR.
+pub const BAN_FINGERPRINT_PREFIX: &str = "ja4:";
+
+/// Audit rows kept after each insert; older rows are dropped.
+pub const BAN_AUDIT_CAP: i64 = 1000;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Ban {
+    pub cidr: String,
+    pub reason: String,
+    pub source: String,
+    pub created_at: i64,
+    /// Unix seconds; `0` = never expires.
+    pub expires_at: i64,
+    pub strikes: i64,
+}
+
+impl Ban {
+    pub fn is_active(&self, now: i64) -> bool {
+        self.expires_at == 0 || self.expires_at > now
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct BanAudit {
+    pub id: i64,
+    pub action: String,
+    pub cidr: String,
+    pub source: String,
+    pub actor: String,
+    pub reason: String,
+    pub created_at: i64,
+}
+
+/// Canonical `addr/prefix` for an address or CIDR; host bits are cleared.
+pub fn normalize_ban_cidr(raw: &str) -> Result<String> {
+    let raw = raw.trim();
+    let invalid = || ChatmailError::config(format!("invalid IP address or CIDR: {raw:?}"));
+    let (addr, prefix) = match raw.split_once('/') {
+        Some((a, p)) => (a, Some(p.parse::<u8>().map_err(|_| invalid())?)),
+        None => (raw, None),
+    };
+    let addr: IpAddr = addr
+        .trim_start_matches('[')
+        .trim_end_matches(']')
+        .parse()
+        .map_err(|_| invalid())?;
+    let addr = match addr {
+        IpAddr::V6(v6) => v6.to_ipv4_mapped().map(IpAddr::V4).unwrap_or(addr),
+        v4 => v4,
+    };
+    let max = if addr.is_ipv4() { 32 } else { 128 };
+    let prefix = prefix.unwrap_or(max);
+    if prefix > max {
+        return Err(invalid());
+    }
+    let masked = match addr {
+        IpAddr::V4(v4) => {
+            let mask = u32::MAX.checked_shl(32 - prefix as u32).unwrap_or(0);
+            IpAddr::V4((u32::from(v4) & mask).into())
+        }
+        IpAddr::V6(v6) => {
+            let mask = u128::MAX.checked_shl(128 - prefix as u32).unwrap_or(0);
+            IpAddr::V6((u128::from(v6) & mask).into())
+        }
+    };
+    Ok(format!("{masked}/{prefix}"))
+}
+
+/// Canonical `ja4:` ban key: lowercase, shape `t13d1516h2_8daaf6152771_e5627efa2ab1`.
+pub fn normalize_ban_fingerprint(raw: &str) -> Result<String> {
+    let raw = raw.trim();
+    let lower = raw.to_ascii_lowercase();
+    let fp = lower.strip_prefix(BAN_FINGERPRINT_PREFIX).unwrap_or(&lower);
+    let parts: Vec<&str> = fp.split('_').collect();
+    let valid = matches!(parts.as_slice(), [a, b, c]
+        if a.len() == 10
+            && a.bytes().all(|x| x.is_ascii_alphanumeric())
+            && [b, c].iter().all(|h| h.len() == 12 && h.bytes().all(|x| x.is_ascii_hexdigit())));
+    if !valid {
+        return Err(ChatmailError::config(format!(
+            "invalid JA4 fingerprint: {raw:?}"
+        )));
+    }
+    Ok(format!("{BAN_FINGERPRINT_PREFIX}{fp}"))
+}
+
+/// Canonical ban key: a `ja4:` fingerprint, otherwise an address or CIDR.
+pub fn normalize_ban_target(raw: &str) -> Result<String> {
+    if raw
+        .trim()
+        .to_ascii_lowercase()
+        .starts_with(BAN_FINGERPRINT_PREFIX)
+    {
+        normalize_ban_fingerprint(raw)
+    } else {
+        normalize_ban_cidr(raw)
+    }
+}
+
+fn is_known_source(source: &str) -> bool {
+    matches!(
+        source,
+        BAN_SOURCE_MANUAL | BAN_SOURCE_AUTH | BAN_SOURCE_REGISTRATION | BAN_SOURCE_ADMIN
+    )
+}
+
+type BanRow = (String, String, String, i64, i64, i64);
+
+fn ban_from_row((cidr, reason, source, created_at, expires_at, strikes): BanRow) -> Ban {
+    Ban {
+        cidr,
+        reason,
+        source,
+        created_at,
+        expires_at,
+        strikes,
+    }
+}
+
+pub async fn get_ban(pool: &DbPool, cidr: &str) -> Result<Option<Ban>> {
+    let row: Option<BanRow> = db_fetch_optional!(
+        pool,
+        BanRow,
+        "SELECT cidr, reason, source, created_at, expires_at, strikes FROM bans WHERE cidr = ?",
+        cidr
+    )?;
+    Ok(row.map(ban_from_row))
+}
+
+async fn upsert_ban(pool: &DbPool, ban: &Ban) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO bans (cidr, reason, source, created_at, expires_at, strikes)
+         VALUES (?, ?, ?, ?, ?, ?)
+         ON CONFLICT(cidr) DO UPDATE SET
+             reason = excluded.reason, source = excluded.source,
+             created_at = excluded.created_at, expires_at = excluded.expires_at,
+             strikes = excluded.strikes",
+        ban.cidr.as_str(),
+        ban.reason.as_str(),
+        ban.source.as_str(),
+        ban.created_at,
+        ban.expires_at,
+        ban.strikes
+    )?;
+    Ok(())
+}
+
+/// Add or replace a ban on a CIDR or `ja4:` fingerprint. `expires_at = 0` is permanent; an
+/// existing strike count is kept.
+pub async fn add_ban(
+    pool: &DbPool,
+    cidr: &str,
+    source: &str,
+    reason: &str,
+    expires_at: i64,
+) -> Result<Ban> {
+    if !is_known_source(source) {
+        return Err(ChatmailError::config(format!(
+            "unknown ban source: {source}"
+        )));
+    }
+    let cidr = normalize_ban_target(cidr)?;
+    let strikes = get_ban(pool, &cidr).await?.map_or(0, |b| b.strikes);
+    let ban = Ban {
+        cidr,
+        reason: reason.trim().to_string(),
+        source: source.to_string(),
+        created_at: unix_now(),
+        expires_at: expires_at.max(0),
+        strikes,
+    };
+    upsert_ban(pool, &ban).await?;
+    Ok(ban)
+}
+
+/// Length of the `strikes`-th automatic ban: `base · 2^(strikes-1)`, capped at `max`.
+pub fn escalated_ban_secs(strikes: i64, base_secs: i64, max_secs: i64) -> i64 {
+    let base = base_secs.max(1);
+    let shift = (strikes.max(1) - 1).min(62) as u32;
+    base.checked_mul(1i64 << shift)
+        .unwrap_or(i64::MAX)
+        .min(max_secs.max(base))
+}
+
+/// Automatic ban: bump the strike count and ban for the escalated duration.
+///
+/// Never shortens a ban already in force (permanent or expiring later); such a ban is
+/// returned unchanged apart from the strike count.
+pub async fn escalate_ban(
+    pool: &DbPool,
+    cidr: &str,
+    source: &str,
+    reason: &str,
+    base_secs: i64,
+    max_secs: i64,
+) -> Result<Ban> {
+    if !is_known_source(source) {
+        return Err(ChatmailError::config(format!(
+            "unknown ban source: {source}"
+        )));
+    }
+    let cidr = normalize_ban_target(cidr)?;
+    let now = unix_now();
+    let existing = get_ban(pool, &cidr).await?;
+    let strikes = existing.as_ref().map_or(0, |b| b.strikes) + 1;
+    let expires_at = now.saturating_add(escalated_ban_secs(strikes, base_secs, max_secs));
+    let ban = match existing {
+        Some(b) if b.is_active(now) && (b.expires_at == 0 || b.expires_at >= expires_at) => {
+            Ban { strikes, ..b }
+        }
+        _ => Ban {
+            cidr,
+            reason: reason.trim().to_string(),
+            source: source.to_string(),
+            created_at: now,
+            expires_at,
+            strikes,
+        },
+    };
+    upsert_ban(pool, &ban).await?;
+    Ok(ban)
+}
+
+/// Delete a ban (and its strike count). Returns `false` when there was none.
+pub async fn remove_ban(pool: &DbPool, cidr: &str) -> Result<bool> {
+    let cidr = normalize_ban_target(cidr)?;
+    if get_ban(pool, &cidr).await?.is_none() {
+        return Ok(false);
+    }
+    db_execute!(pool, "DELETE FROM bans WHERE cidr = ?", cidr.as_str())?;
+    Ok(true)
+}
+
+/// Bans ordered by CIDR; expired rows (kept for their strike count) only when asked.
+pub async fn list_bans(pool: &DbPool, include_expired: bool) -> Result<Vec<Ban>> {
+    let rows: Vec<BanRow> = if include_expired {
+        db_fetch_all!(
+            pool,
+            BanRow,
+            "SELECT cidr, reason, source, created_at, expires_at, strikes FROM bans ORDER BY cidr"
+        )?
+    } else {
+        db_fetch_all!(
+            pool,
+            BanRow,
+            "SELECT cidr, reason, source, created_at, expires_at, strikes FROM bans
+             WHERE expires_at = 0 OR expires_at > ? ORDER BY cidr",
+            unix_now()
+        )?
+    };
+    Ok(rows.into_iter().map(ban_from_row).collect())
+}
+
+/// Forget bans that expired more than `keep_secs` ago (their strike count goes with them).
+pub async fn prune_bans(pool: &DbPool, keep_secs: i64) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM bans WHERE expires_at <> 0 AND expires_at < ?",
+        unix_now().saturating_sub(keep_secs)
+    )?;
+    Ok(())
+}
+
+/// Append one audit row (`add`, `remove`, `auto`) and trim the log to [`BAN_AUDIT_CAP`].
+pub async fn record_ban_audit(
+    pool: &DbPool,
+    action: &str,
+    cidr: &str,
+    source: &str,
+    actor: &str,
+    reason: &str,
+) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO ban_audit (action, cidr, source, actor, reason, created_at)
+         VALUES (?, ?, ?, ?, ?, ?)",
+        action,
+        cidr,
+        source,
+        actor,
+        reason,
+        unix_now()
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM ban_audit WHERE id NOT IN (SELECT id FROM ban_audit ORDER BY id DESC LIMIT ?)",
+        BAN_AUDIT_CAP
+    )?;
+    Ok(())
+}
+
+/// Newest `limit` audit rows, newest first.
+pub async fn list_ban_audit(pool: &DbPool, limit: i64) -> Result<Vec<BanAudit>> {
+    let rows: Vec<(i64, String, String, String, String, String, i64)> = db_fetch_all!(
+        pool,
+        (i64, String, String, String, String, String, i64),
+        "SELECT id, action, cidr, source, actor, reason, created_at FROM ban_audit
+         ORDER BY id DESC LIMIT ?",
+        limit
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(
+            |(id, action, cidr, source, actor, reason, created_at)| BanAudit {
+                id,
+                action,
+                cidr,
+                source,
+                actor,
+                reason,
+                created_at,
+            },
+        )
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[test]
+    fn normalizes_cidrs() {
+        for (raw, want) in [
+            ("203.0.113.7", Some("203.0.113.7/32")),
+            (" 203.0.113.7/24 ", Some("203.0.113.0/24")),
+            ("::ffff:192.0.2.1", Some("192.0.2.1/32")),
+            ("[2001:db8::1]", Some("2001:db8::1/128")),
+            ("2001:db8:1:2::5/48", Some("2001:db8:1::/48")),
+            ("0.0.0.0/0", Some("0.0.0.0/0")),
+            ("10.0.0.1/33", None),
+            ("example.org", None),
+            ("10.0.0.1/x", None),
+        ] {
+            assert_eq!(normalize_ban_cidr(raw).ok().as_deref(), want, "{raw}");
+        }
+    }
+
+    #[test]
+    fn normalizes_fingerprints() {
+        let want = Some("ja4:t13d1516h2_8daaf6152771_e5627efa2ab1");
+        for raw in [
+            "ja4:t13d1516h2_8daaf6152771_e5627efa2ab1",
+            " JA4:T13D1516H2_8DAAF6152771_E5627EFA2AB1 ",
+        ] {
+            assert_eq!(normalize_ban_target(raw).ok().as_deref(), want, "{raw}");
+        }
+        for raw in [
+            "ja4:",
+            "ja4:t13d1516h2_8daaf6152771",
+            "ja4:t13d1516h2_8daaf615277z_e5627efa2ab1",
+            "ja4:t13d1516h2x_8daaf6152771_e5627efa2ab1",
+        ] {
+            assert!(normalize_ban_target(raw).is_err(), "{raw}");
+        }
+        assert_eq!(normalize_ban_target("192.0.2.1").unwrap(), "192.0.2.1/32");
+    }
+
+    #[test]
+    fn escalation_doubles_up_to_cap() {
+        assert_eq!(escalated_ban_secs(1, 900, 3600), 900);
+        assert_eq!(escalated_ban_secs(2, 900, 3600), 1800);
+        assert_eq!(escalated_ban_secs(3, 900, 3600), 3600);
+        assert_eq!(escalated_ban_secs(40, 900, 3600), 3600);
+        assert_eq!(escalated_ban_secs(100, 900, i64::MAX), i64::MAX);
+    }
+
+    #[tokio::test]
+    async fn escalate_counts_strikes_and_never_shortens() {
+        let pool = init_memory_db().await.unwrap();
+        let first = escalate_ban(&pool, "198.51.100.9", BAN_SOURCE_AUTH, "logins", 60, 600)
+            .await
+            .unwrap();
+        assert_eq!(first.strikes, 1);
+        assert!(first.expires_at - unix_now() <= 60);
+
+        // Expire it, keep the strike count: the next ban is twice as long.
+        db_execute!(pool, "UPDATE bans SET expires_at = 1").unwrap();
+        let second = escalate_ban(&pool, "198.51.100.9", BAN_SOURCE_AUTH, "logins", 60, 600)
+            .await
+            .unwrap();
+        assert_eq!(second.strikes, 2);
+        assert!(second.expires_at - unix_now() > 60);
+
+        add_ban(&pool, "198.51.100.0/24", BAN_SOURCE_MANUAL, "abuse", 0)
+            .await
+            .unwrap();
+        let kept = escalate_ban(&pool, "198.51.100.0/24", BAN_SOURCE_AUTH, "x", 60, 600)
+            .await
+            .unwrap();
+        assert_eq!(kept.expires_at, 0);
+        assert_eq!(kept.source, BAN_SOURCE_MANUAL);
+        assert_eq!(kept.reason, "abuse");
+    }
+
+    #[tokio::test]
+    async fn list_remove_and_prune() {
+        let pool = init_memory_db().await.unwrap();
+        add_ban(&pool, "192.0.2.1", BAN_SOURCE_MANUAL, "", 0)
+            .await
+            .unwrap();
+        add_ban(&pool, "192.0.2.2", BAN_SOURCE_ADMIN, "", 1)
+            .await
+            .unwrap();
+        assert!(add_ban(&pool, "192.0.2.3", "robot", "", 0).await.is_err());
+        assert_eq!(list_bans(&pool, false).await.unwrap().len(), 1);
+        assert_eq!(list_bans(&pool, true).await.unwrap().len(), 2);
+
+        prune_bans(&pool, 60).await.unwrap();
+        assert_eq!(list_bans(&pool, true).await.unwrap().len(), 1);
+
+        assert!(remove_ban(&pool, "192.0.2.1/32").await.unwrap());
+        assert!(!remove_ban(&pool, "192.0.2.1").await.unwrap());
+        assert!(list_bans(&pool, true).await.unwrap().is_empty());
+    }
+
+    #[tokio::test]
+    async fn audit_is_newest_first_and_capped() {
+        let pool = init_memory_db().await.unwrap();
+        for i in 0..BAN_AUDIT_CAP + 2 {
+            record_ban_audit(&pool, "add", &format!("n{i}"), BAN_SOURCE_MANUAL, "cli", "")
+                .await
+                .unwrap();
+        }
+        let all = list_ban_audit(&pool, BAN_AUDIT_CAP * 2).await.unwrap();
+        assert_eq!(all.len() as i64, BAN_AUDIT_CAP);
+        assert_eq!(all[0].cidr, format!("n{}", BAN_AUDIT_CAP + 1));
+    }
+}
@@ -26,6 +26,7 @@ pub const BULK_DELETE_REASON: &str = "bulk delete via admin";
 pub const MANUAL_BLOCK_REASON: &str = "manually blocked";
 pub const CLI_DELETE_REASON: &str = "account deleted via CLI";
 pub const CLI_BAN_REASON: &str = "banned via CLI";
+pub const SELF_DELETE_REASON: &str = "deleted by account owner";
 
 pub async fn block_user(pool: &DbPool, username: &str, reason: &str) -> Result<()> {
     // Set blocked_at explicitly so legacy Postgres tables without a DEFAULT still get a value.
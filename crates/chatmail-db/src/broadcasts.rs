@@ -0,0 +1,169 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Audit trail of admin announcement broadcasts (`broadcast_audit`).
+//!
+//! A broadcast writes a `start` row when its job is queued and a `finish` row with the
+//! delivery counts when it ends. Dry runs are not recorded.
+
+use chatmail_types::Result;
+
+use crate::policies::unix_now;
+use crate::{db_execute, db_fetch_all, DbPool};
+
+/// Audit rows kept after each insert; older rows are dropped.
+pub const BROADCAST_AUDIT_CAP: i64 = 1000;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct BroadcastAudit {
+    pub id: i64,
+    /// `start` or `finish`.
+    pub action: String,
+    pub job_id: String,
+    pub subject: String,
+    /// Recipient filter as given (`all`, `active:7d`, `domain:example.org`).
+    pub filter: String,
+    /// `admin-api` or `cli`.
+    pub actor: String,
+    pub recipients: i64,
+    pub delivered: i64,
+    pub failed: i64,
+    pub created_at: i64,
+}
+
+/// Append one audit row and trim the log to [`BROADCAST_AUDIT_CAP`].
+#[allow(clippy::too_many_arguments)]
+pub async fn record_broadcast_audit(
+    pool: &DbPool,
+    action: &str,
+    job_id: &str,
+    subject: &str,
+    filter: &str,
+    actor: &str,
+    recipients: i64,
+    delivered: i64,
+    failed: i64,
+) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO broadcast_audit
+         (action, job_id, subject, filter, actor, recipients, delivered, failed, created_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+        action,
+        job_id,
+        subject,
+        filter,
+        actor,
+        recipients,
+        delivered,
+        failed,
+        unix_now()
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM broadcast_audit WHERE id NOT IN
+         (SELECT id FROM broadcast_audit ORDER BY id DESC LIMIT ?)",
+        BROADCAST_AUDIT_CAP
+    )?;
+    Ok(())
+}
+
+type AuditRow = (
+    i64,
+    String,
+    String,
+    String,
+    String,
+    String,
+    i64,
+    i64,
+    i64,
+    i64,
+);
+
+/// Newest `limit` audit rows, newest first.
+pub async fn list_broadcast_audit(pool: &DbPool, limit: i64) -> Result<Vec<BroadcastAudit>> {
+    let rows: Vec<AuditRow> = db_fetch_all!(
+        pool,
+        AuditRow,
+        "SELECT id, action, job_id, subject, filter, actor, recipients, delivered, failed,
+         created_at FROM broadcast_audit ORDER BY id DESC LIMIT ?",
+        limit
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(
+            |(
+                id,
+                action,
+                job_id,
+                subject,
+                filter,
+                actor,
+                recipients,
+                delivered,
+                failed,
+                created_at,
+            )| BroadcastAudit {
+                id,
+                action,
+                job_id,
+                subject,
+                filter,
+                actor,
+                recipients,
+                delivered,
+                failed,
+                created_at,
+            },
+        )
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn audit_is_newest_first_and_capped() {
+        let pool = init_memory_db().await.unwrap();
+        record_broadcast_audit(&pool, "start", "j1", "Maintenance", "all", "cli", 3, 0, 0)
+            .await
+            .unwrap();
+        record_broadcast_audit(&pool, "finish", "j1", "Maintenance", "all", "cli", 3, 2, 1)
+            .await
+            .unwrap();
+        let rows = list_broadcast_audit(&pool, 10).await.unwrap();
+        assert_eq!(rows.len(), 2);
+        assert_eq!(rows[0].action, "finish");
+        assert_eq!((rows[0].delivered, rows[0].failed), (2, 1));
+        assert_eq!(rows[1].action, "start");
+        assert_eq!(rows[1].recipients, 3);
+
+        for i in 0..BROADCAST_AUDIT_CAP {
+            record_broadcast_audit(&pool, "start", &format!("n{i}"), "s", "all", "cli", 0, 0, 0)
+                .await
+                .unwrap();
+        }
+        let all = list_broadcast_audit(&pool, BROADCAST_AUDIT_CAP * 2)
+            .await
+            .unwrap();
+        assert_eq!(all.len() as i64, BROADCAST_AUDIT_CAP);
+        assert_eq!(all[0].job_id, format!("n{}", BROADCAST_AUDIT_CAP - 1));
+    }
+}
@@ -0,0 +1,307 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-domain catch-all mailboxes (`catchalls`) and the per-day delivery counters
+//! (`catchall_daily`) that cap them.
+//!
+//! A catch-all row names the account that receives mail for unknown local parts of one
+//! domain. Every redirected message claims one slot of the domain's daily cap, so a
+//! dictionary attack against the domain cannot fill the target mailbox. Counter rows are
+//! keyed by UTC day number (`unix_time / 86400`) and pruned once the day is over.
+
+use chatmail_types::{ChatmailError, Result};
+
+use crate::policies::unix_now;
+use crate::pool::pg_sql;
+use crate::settings::get_bool_setting;
+use crate::settings_keys;
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
+
+/// Redirected deliveries per domain and UTC day when none is given.
+pub const DEFAULT_CATCHALL_DAILY_CAP: i64 = 200;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Catchall {
+    pub domain: String,
+    /// Account that receives mail for unknown local parts of `domain`.
+    pub target: String,
+    /// Redirected deliveries allowed per UTC day.
+    pub daily_cap: i64,
+    pub created_at: i64,
+}
+
+type CatchallRow = (String, String, i64, i64);
+
+fn catchall_from_row((domain, target, daily_cap, created_at): CatchallRow) -> Catchall {
+    Catchall {
+        domain,
+        target,
+        daily_cap,
+        created_at,
+    }
+}
+
+fn normalize_domain(raw: &str) -> Result<String> {
+    let domain = raw.trim().trim_end_matches('.').to_ascii_lowercase();
+    if domain.is_empty() || domain.contains('@') || domain.contains(char::is_whitespace) {
+        return Err(ChatmailError::config(format!(
+            "invalid catch-all domain: {raw:?}"
+        )));
+    }
+    Ok(domain)
+}
+
+/// UTC day number used by `catchall_daily`.
+pub fn catchall_day(now: i64) -> i64 {
+    now.div_euclid(86_400)
+}
+
+/// Why catch-alls are currently not applied, judged from the stored registration settings
+/// and the config's `auto_create`: while an unknown address may still become an account
+/// (JIT registration, open registration, `auto_create`), its mail is never redirected.
+pub async fn catchall_inactive_reason(
+    pool: &DbPool,
+    auto_create: bool,
+) -> Result<Option<&'static str>> {
+    if auto_create {
+        return Ok(Some("auto_create is on"));
+    }
+    let jit = get_bool_setting(pool, settings_keys::JIT_REGISTRATION_ENABLED, true).await?
+        || get_bool_setting(pool, settings_keys::REGISTRATION_OPEN, true).await?;
+    Ok(jit.then_some("JIT registration is on"))
+}
+
+pub async fn get_catchall(pool: &DbPool, domain: &str) -> Result<Option<Catchall>> {
+    let domain = normalize_domain(domain)?;
+    let row: Option<CatchallRow> = db_fetch_optional!(
+        pool,
+        CatchallRow,
+        "SELECT domain, target, daily_cap, created_at FROM catchalls WHERE domain = ?",
+        domain.as_str()
+    )?;
+    Ok(row.map(catchall_from_row))
+}
+
+/// Add or replace the catch-all for `domain`. The caller checks that `target` is an
+/// existing local account; today's counter is kept, so lowering the cap applies at once.
+pub async fn set_catchall(
+    pool: &DbPool,
+    domain: &str,
+    target: &str,
+    daily_cap: i64,
+) -> Result<Catchall> {
+    let domain = normalize_domain(domain)?;
+    let target = target.trim().to_ascii_lowercase();
+    if !target
+        .split_once('@')
+        .is_some_and(|(l, d)| !l.is_empty() && !d.is_empty())
+    {
+        return Err(ChatmailError::config(format!(
+            "invalid catch-all target: {target:?}"
+        )));
+    }
+    if daily_cap <= 0 {
+        return Err(ChatmailError::config(
+            "catch-all daily cap must be positive",
+        ));
+    }
+    let catchall = Catchall {
+        domain,
+        target,
+        daily_cap,
+        created_at: unix_now(),
+    };
+    db_execute!(
+        pool,
+        "INSERT INTO catchalls (domain, target, daily_cap, created_at)
+         VALUES (?, ?, ?, ?)
+         ON CONFLICT(domain) DO UPDATE SET
+             target = excluded.target, daily_cap = excluded.daily_cap,
+             created_at = excluded.created_at",
+        catchall.domain.as_str(),
+        catchall.target.as_str(),
+        catchall.daily_cap,
+        catchall.created_at
+    )?;
+    Ok(catchall)
+}
+
+/// Delete the catch-all for `domain` and its counters. Returns `false` when there was none.
+pub async fn clear_catchall(pool: &DbPool, domain: &str) -> Result<bool> {
+    let domain = normalize_domain(domain)?;
+    if get_catchall(pool, &domain).await?.is_none() {
+        return Ok(false);
+    }
+    db_execute!(
+        pool,
+        "DELETE FROM catchalls WHERE domain = ?",
+        domain.as_str()
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM catchall_daily WHERE domain = ?",
+        domain.as_str()
+    )?;
+    Ok(true)
+}
+
+/// All catch-alls ordered by domain.
+pub async fn list_catchalls(pool: &DbPool) -> Result<Vec<Catchall>> {
+    let rows: Vec<CatchallRow> = db_fetch_all!(
+        pool,
+        CatchallRow,
+        "SELECT domain, target, daily_cap, created_at FROM catchalls ORDER BY domain"
+    )?;
+    Ok(rows.into_iter().map(catchall_from_row).collect())
+}
+
+/// Redirected deliveries for `domain` on UTC day `day`.
+pub async fn catchall_deliveries(pool: &DbPool, domain: &str, day: i64) -> Result<i64> {
+    let row: Option<(i64,)> = db_fetch_optional!(
+        pool,
+        (i64,),
+        "SELECT deliveries FROM catchall_daily WHERE domain = ? AND day = ?",
+        domain.trim().to_ascii_lowercase(),
+        day
+    )?;
+    Ok(row.map_or(0, |(n,)| n))
+}
+
+/// Take one of `daily_cap` slots for `domain` on `day`: returns `false` (and counts
+/// nothing) once the cap is reached. Atomic, so concurrent deliveries never overshoot.
+pub async fn claim_catchall_delivery(
+    pool: &DbPool,
+    domain: &str,
+    daily_cap: i64,
+    day: i64,
+) -> Result<bool> {
+    if daily_cap <= 0 {
+        return Ok(false);
+    }
+    const CLAIM: &str = "INSERT INTO catchall_daily (domain, day, deliveries)
+         VALUES (?, ?, 1)
+         ON CONFLICT(domain, day) DO UPDATE SET deliveries = catchall_daily.deliveries + 1
+         WHERE catchall_daily.deliveries < ?";
+    let domain = domain.trim().to_ascii_lowercase();
+    let affected = match pool {
+        DbPool::Sqlite(p) => sqlx::query(CLAIM)
+            .bind(&domain)
+            .bind(day)
+            .bind(daily_cap)
+            .execute(p)
+            .await?
+            .rows_affected(),
+        DbPool::Postgres(p) => sqlx::query(&pg_sql(CLAIM))
+            .bind(&domain)
+            .bind(day)
+            .bind(daily_cap)
+            .execute(p)
+            .await?
+            .rows_affected(),
+    };
+    Ok(affected > 0)
+}
+
+/// Drop counters for days before `day`.
+pub async fn prune_catchall_daily(pool: &DbPool, day: i64) -> Result<()> {
+    db_execute!(pool, "DELETE FROM catchall_daily WHERE day < ?", day)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn set_replace_list_clear() {
+        let pool = init_memory_db().await.unwrap();
+        set_catchall(&pool, " Example.ORG. ", "Team@example.org", 10)
+            .await
+            .unwrap();
+        let c = set_catchall(&pool, "example.org", "ops@example.org", 20)
+            .await
+            .unwrap();
+        assert_eq!(c.domain, "example.org");
+
+        let all = list_catchalls(&pool).await.unwrap();
+        assert_eq!(all.len(), 1);
+        assert_eq!(all[0].target, "ops@example.org");
+        assert_eq!(all[0].daily_cap, 20);
+
+        assert!(clear_catchall(&pool, "EXAMPLE.org").await.unwrap());
+        assert!(!clear_catchall(&pool, "example.org").await.unwrap());
+        assert!(get_catchall(&pool, "example.org").await.unwrap().is_none());
+    }
+
+    #[tokio::test]
+    async fn rejects_bad_input() {
+        let pool = init_memory_db().await.unwrap();
+        for (domain, target, cap) in [
+            ("", "a@example.org", 1),
+            ("a@example.org", "a@example.org", 1),
+            ("example.org", "nobody", 1),
+            ("example.org", "a@example.org", 0),
+        ] {
+            assert!(
+                set_catchall(&pool, domain, target, cap).await.is_err(),
+                "{domain} {target} {cap}"
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn claim_stops_at_cap_and_resets_next_day() {
+        let pool = init_memory_db().await.unwrap();
+        let day = catchall_day(1_800_000_000);
+        for _ in 0..3 {
+            assert!(claim_catchall_delivery(&pool, "example.org", 3, day)
+                .await
+                .unwrap());
+        }
+        assert!(!claim_catchall_delivery(&pool, "example.org", 3, day)
+            .await
+            .unwrap());
+        assert_eq!(
+            catchall_deliveries(&pool, "example.org", day)
+                .await
+                .unwrap(),
+            3
+        );
+        // A raised cap applies to the same day.
+        assert!(claim_catchall_delivery(&pool, "example.org", 4, day)
+            .await
+            .unwrap());
+
+        assert!(claim_catchall_delivery(&pool, "example.org", 3, day + 1)
+            .await
+            .unwrap());
+        prune_catchall_daily(&pool, day + 1).await.unwrap();
+        assert_eq!(
+            catchall_deliveries(&pool, "example.org", day)
+                .await
+                .unwrap(),
+            0
+        );
+        assert_eq!(
+            catchall_deliveries(&pool, "example.org", day + 1)
+                .await
+                .unwrap(),
+            1
+        );
+    }
+}
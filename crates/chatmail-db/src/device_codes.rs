@@ -0,0 +1,139 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! One-time device provisioning codes (`device_codes` table).
+//!
+//! Only a digest of each code is stored; callers hash before every lookup. A code is
+//! redeemable once, until `expires_at`, and is dropped when the account password changes.
+
+use chatmail_types::Result;
+
+use crate::pool::pg_sql;
+use crate::{db_execute, db_fetch_optional, DbPool};
+
+pub async fn create_device_code(
+    pool: &DbPool,
+    username: &str,
+    code_hash: &str,
+    ttl_secs: i64,
+) -> Result<()> {
+    let now = crate::policies::unix_now();
+    db_execute!(
+        pool,
+        "DELETE FROM device_codes WHERE expires_at <= ? OR used_at IS NOT NULL",
+        now
+    )?;
+    db_execute!(
+        pool,
+        "INSERT INTO device_codes (code_hash, username, created_at, expires_at)
+         VALUES (?, ?, ?, ?)",
+        code_hash,
+        username,
+        now,
+        now + ttl_secs
+    )?;
+    Ok(())
+}
+
+/// Mark the code used and return its account. `None` when unknown, expired or already used;
+/// of two concurrent redemptions only one wins.
+pub async fn redeem_device_code(pool: &DbPool, code_hash: &str) -> Result<Option<String>> {
+    let now = crate::policies::unix_now();
+    let row: Option<(String,)> = db_fetch_optional!(
+        pool,
+        (String,),
+        "SELECT username FROM device_codes
+         WHERE code_hash = ? AND used_at IS NULL AND expires_at > ?",
+        code_hash,
+        now
+    )?;
+    let Some((username,)) = row else {
+        return Ok(None);
+    };
+    const CLAIM: &str = "UPDATE device_codes SET used_at = ?
+         WHERE code_hash = ? AND used_at IS NULL AND expires_at > ?";
+    let affected = match pool {
+        DbPool::Sqlite(p) => sqlx::query(CLAIM)
+            .bind(now)
+            .bind(code_hash)
+            .bind(now)
+            .execute(p)
+            .await?
+            .rows_affected(),
+        DbPool::Postgres(p) => sqlx::query(&pg_sql(CLAIM))
+            .bind(now)
+            .bind(code_hash)
+            .bind(now)
+            .execute(p)
+            .await?
+            .rows_affected(),
+    };
+    Ok((affected > 0).then_some(username))
+}
+
+/// Drop every outstanding code for `username` (password change, account deletion).
+pub async fn revoke_device_codes(pool: &DbPool, username: &str) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM device_codes WHERE username = ?",
+        username
+    )?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn code_redeems_once() {
+        let pool = init_memory_db().await.unwrap();
+        create_device_code(&pool, "a@x.org", "h1", 600)
+            .await
+            .unwrap();
+        assert_eq!(
+            redeem_device_code(&pool, "h1").await.unwrap().as_deref(),
+            Some("a@x.org")
+        );
+        assert_eq!(redeem_device_code(&pool, "h1").await.unwrap(), None);
+        assert_eq!(redeem_device_code(&pool, "nope").await.unwrap(), None);
+    }
+
+    #[tokio::test]
+    async fn expired_code_is_rejected() {
+        let pool = init_memory_db().await.unwrap();
+        create_device_code(&pool, "a@x.org", "h1", -1)
+            .await
+            .unwrap();
+        assert_eq!(redeem_device_code(&pool, "h1").await.unwrap(), None);
+    }
+
+    #[tokio::test]
+    async fn revoke_drops_only_that_account() {
+        let pool = init_memory_db().await.unwrap();
+        create_device_code(&pool, "a@x.org", "h1", 600)
+            .await
+            .unwrap();
+        create_device_code(&pool, "b@x.org", "h2", 600)
+            .await
+            .unwrap();
+        revoke_device_codes(&pool, "a@x.org").await.unwrap();
+        assert_eq!(redeem_device_code(&pool, "h1").await.unwrap(), None);
+        assert!(redeem_device_code(&pool, "h2").await.unwrap().is_some());
+    }
+}
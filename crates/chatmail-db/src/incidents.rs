@@ -0,0 +1,104 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Status page incident log (`incidents` table).
+//!
+//! The health probe appends `outage` / `recovery` rows when a component flips; admins add
+//! `note` rows. Only the newest [`INCIDENT_LOG_CAP`] rows are kept.
+
+use chatmail_types::Result;
+
+use crate::{db_execute, db_fetch_all, DbPool};
+
+/// Rows kept after each insert; older incidents are dropped.
+pub const INCIDENT_LOG_CAP: i64 = 500;
+
+pub const INCIDENT_OUTAGE: &str = "outage";
+pub const INCIDENT_RECOVERY: &str = "recovery";
+pub const INCIDENT_NOTE: &str = "note";
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Incident {
+    pub id: i64,
+    pub component: String,
+    pub kind: String,
+    pub message: String,
+    pub created_at: i64,
+}
+
+/// Append one incident and trim the log to [`INCIDENT_LOG_CAP`].
+pub async fn record_incident(
+    pool: &DbPool,
+    component: &str,
+    kind: &str,
+    message: &str,
+) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO incidents (component, kind, message, created_at) VALUES (?, ?, ?, ?)",
+        component,
+        kind,
+        message,
+        crate::policies::unix_now()
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM incidents WHERE id NOT IN (SELECT id FROM incidents ORDER BY id DESC LIMIT ?)",
+        INCIDENT_LOG_CAP
+    )?;
+    Ok(())
+}
+
+/// Newest `limit` incidents, newest first.
+pub async fn list_incidents(pool: &DbPool, limit: i64) -> Result<Vec<Incident>> {
+    let rows: Vec<(i64, String, String, String, i64)> = db_fetch_all!(
+        pool,
+        (i64, String, String, String, i64),
+        "SELECT id, component, kind, message, created_at FROM incidents ORDER BY id DESC LIMIT ?",
+        limit
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(|(id, component, kind, message, created_at)| Incident {
+            id,
+            component,
+            kind,
+            message,
+            created_at,
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn newest_first_and_capped() {
+        let pool = init_memory_db().await.unwrap();
+        for i in 0..INCIDENT_LOG_CAP + 3 {
+            record_incident(&pool, "smtp", INCIDENT_NOTE, &format!("n{i}"))
+                .await
+                .unwrap();
+        }
+        let all = list_incidents(&pool, INCIDENT_LOG_CAP * 2).await.unwrap();
+        assert_eq!(all.len() as i64, INCIDENT_LOG_CAP);
+        assert_eq!(all[0].message, format!("n{}", INCIDENT_LOG_CAP + 2));
+        assert_eq!(all.last().unwrap().message, "n3");
+        assert_eq!(list_incidents(&pool, 2).await.unwrap().len(), 2);
+    }
+}
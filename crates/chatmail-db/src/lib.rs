@@ -15,11 +15,23 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+pub mod account_activity;
+pub mod account_audit;
 pub mod account_info;
+pub mod admin_audit;
+pub mod app_passwords;
+pub mod append_limits;
+pub mod autoresponses;
+pub mod backup;
+pub mod bans;
 pub mod blocklist;
+pub mod broadcasts;
+pub mod catchalls;
+pub mod device_codes;
 pub mod endpoint_cache;
 pub mod federation_policy;
 pub mod inbound;
+pub mod incidents;
 pub mod mail_ports;
 pub mod maintenance;
 pub mod message_retention;
@@ -27,10 +39,15 @@ pub mod message_stats;
 pub mod models;
 pub mod modseq;
 pub mod passwords;
+pub mod peers;
+pub mod policies;
 pub mod pool;
 pub mod quota_defaults;
+pub mod recovery_codes;
 pub mod registration_tokens;
+pub mod responders;
 pub mod schema;
+pub mod sending_stats;
 pub mod settings;
 pub mod settings_keys;
 pub mod sharing;
@@ -42,11 +59,55 @@ use chatmail_types::Result;
 use sqlx::sqlite::{SqliteConnectOptions, SqlitePoolOptions};
 use std::str::FromStr;
 
-pub use account_info::{delete_quota_row, list_account_quota_info, AccountQuotaInfo};
+pub use account_activity::{
+    delete_activity, is_inactive, last_seen, list_inactive_accounts, list_last_activity,
+    record_activity,
+};
+pub use account_audit::{
+    list_account_audit, record_account_audit, AccountAudit, ACCOUNT_AUDIT_CAP,
+    AUDIT_DEVICE_REDEEMED, AUDIT_PASSWORD_CHANGED, AUDIT_RECOVERED,
+};
+pub use account_info::{
+    account_quota_info, copy_quota_row, delete_quota_row, get_quota_row, list_account_quota_info,
+    put_quota_row, AccountQuotaInfo, QuotaRow,
+};
+pub use admin_audit::{
+    list_admin_audit, prune_admin_audit, record_admin_audit, AdminAudit, AdminAuditQuery,
+    ADMIN_AUDIT_CAP, AUDIT_ACTOR_API, AUDIT_ACTOR_CLI, AUDIT_TOKEN_PREFIX_CHARS,
+};
+pub use app_passwords::{
+    app_password_hashes, create_app_password, delete_app_passwords, list_app_passwords, AppPassword,
+};
+pub use append_limits::{
+    delete_append_limit, get_append_limit, list_append_limits, set_append_limit,
+};
+pub use autoresponses::{
+    claim_autoresponse_reply, clear_autoresponse, delete_autoresponse, get_autoresponse,
+    last_autoresponse_reply, list_autoresponses, prune_autoresponse_replies, set_autoresponse,
+    Autoresponse, DEFAULT_AUTORESPONSE_WINDOW_SECS,
+};
+pub use backup::{
+    backup_databases, backup_stamp, sqlite_databases, vacuum_into, DatabaseBackup, BACKUP_DIR,
+};
+pub use bans::{
+    add_ban, escalate_ban, escalated_ban_secs, get_ban, list_ban_audit, list_bans,
+    normalize_ban_cidr, normalize_ban_fingerprint, normalize_ban_target, prune_bans,
+    record_ban_audit, remove_ban, Ban, BanAudit, BAN_AUDIT_CAP, BAN_FINGERPRINT_PREFIX,
+    BAN_SOURCE_ADMIN, BAN_SOURCE_AUTH, BAN_SOURCE_MANUAL, BAN_SOURCE_REGISTRATION,
+};
 pub use blocklist::{
     block_user, is_blocked, list_blocked_users, unblock_user, ADMIN_DELETE_REASON,
-    BULK_DELETE_REASON, CLI_BAN_REASON, CLI_DELETE_REASON, MANUAL_BLOCK_REASON,
+    BULK_DELETE_REASON, CLI_BAN_REASON, CLI_DELETE_REASON, MANUAL_BLOCK_REASON, SELF_DELETE_REASON,
+};
+pub use broadcasts::{
+    list_broadcast_audit, record_broadcast_audit, BroadcastAudit, BROADCAST_AUDIT_CAP,
 };
+pub use catchalls::{
+    catchall_day, catchall_deliveries, catchall_inactive_reason, claim_catchall_delivery,
+    clear_catchall, get_catchall, list_catchalls, prune_catchall_daily, set_catchall, Catchall,
+    DEFAULT_CATCHALL_DAILY_CAP,
+};
+pub use device_codes::{create_device_code, redeem_device_code, revoke_device_codes};
 pub use endpoint_cache::{
     get_endpoint_override, list_endpoint_overrides, remove_endpoint_override,
     set_endpoint_override, EndpointOverrideRow,
@@ -57,6 +118,10 @@ pub use federation_policy::{
 pub use inbound::{
     inbound_local_recipient_allowed, is_federation_rcpt_blocked, is_federation_sender_blocked,
 };
+pub use incidents::{
+    list_incidents, record_incident, Incident, INCIDENT_LOG_CAP, INCIDENT_NOTE, INCIDENT_OUTAGE,
+    INCIDENT_RECOVERY,
+};
 pub use mail_ports::{db_ports_from_settings, load_mail_port_overrides};
 pub use maintenance::{list_dormant_accounts, remove_account_without_blocklist};
 pub use message_retention::{
@@ -70,20 +135,43 @@ pub use message_stats::{
     start_flush_task as start_message_stats_flush,
 };
 pub use modseq::{load_all_modseq, upsert_modseq};
+pub use peers::{add_peer, list_peers, normalize_peer_url, remove_peer, Peer};
+pub use policies::{
+    add_policy_entry, list_policy_entries, purge_expired_policy_entries, remove_policy_entry,
+    PolicyEntry, PolicyType,
+};
 pub use pool::{connect_database, pg_sql, DbBackend, DbPool};
 pub use quota_defaults::resolve_default_quota_bytes;
+pub use recovery_codes::{
+    consume_recovery_code, delete_recovery_codes, recovery_code_digests, replace_recovery_codes,
+};
 pub use registration_tokens::{
-    attach_registration_token, ensure_new_account_quota, list_login_settled_usernames,
-    record_first_login, reserve_registration_token, validate_registration_token, FirstLoginOutcome,
+    attach_registration_token, check_registration_token, ensure_new_account_quota,
+    list_login_settled_usernames, record_first_login, reserve_registration_token,
+    validate_registration_token, FirstLoginOutcome, TokenRejection,
+};
+pub use responders::{
+    claim_responder_reply, get_responder, last_responder_reply, list_responders,
+    prune_responder_replies, remove_responder, set_responder, Responder, RESPONDER_TEMPLATE_MAX,
+};
+pub use sending_stats::{
+    messages_sent_in_hour, prune_sending_stats, record_sent_message, reset_sending_stats,
+    sending_hour, sending_stats_for, top_senders, SenderTotal, SendingStats,
+    SENDING_STATS_KEEP_HOURS,
 };
 pub use settings::{
     delete_setting, get_bool_setting, get_enabled_setting, get_setting, get_settings_many,
-    list_double_underscore_settings, seed_install_defaults, set_setting,
+    list_double_underscore_settings, max_accounts, seed_install_defaults, set_setting,
 };
 pub use sharing::{
-    create_sharing_contact, get_sharing_contact, init_sharing_db, list_sharing_contacts,
-    normalize_sharing_url, remove_sharing_contact, sharing_slug_exists, update_sharing_contact,
-    validate_slug, SharingContact,
+    add_sharing_views, create_sharing_contact, create_sharing_invite, get_live_sharing_contact,
+    get_sharing_contact, init_sharing_db, invite_address, invite_kind, is_reserved_slug,
+    list_sharing_contacts, list_sharing_contacts_for, list_sharing_contacts_page,
+    normalize_sharing_url, parse_invite_url, parse_sharing_ttl, prune_expired_sharing_contacts,
+    remove_sharing_contact, remove_sharing_contacts_for, rename_sharing_contact,
+    set_sharing_contact_name, sharing_slug_exists, update_sharing_contact, validate_group_fields,
+    validate_slug, GroupInviteFields, InviteKind, InviteUrl, SharingContact, SharingViewBatch,
+    MAX_GROUP_DESCRIPTION_CHARS, MAX_MEMBER_NOTE_CHARS, MAX_SHARING_TTL,
 };
 
 /// Open (or create) the application database and run embedded migrations.
@@ -136,6 +224,7 @@ mod tests {
         "exchangers",
         "passwords",
         "push_tokens",
+        "policy_entries",
     ];
 
     /// P1-UT03: migrations are idempotent on the same pool.
@@ -44,6 +44,12 @@ pub async fn remove_account_without_blocklist(
     let qt = crate::schema::quota_table(pool).await?;
     let sql = format!("DELETE FROM {qt} WHERE username = ?");
     db_execute!(pool, &sql, username)?;
+    crate::app_passwords::delete_app_passwords(pool, username).await?;
+    crate::device_codes::revoke_device_codes(pool, username).await?;
+    crate::recovery_codes::delete_recovery_codes(pool, username).await?;
+    crate::account_activity::delete_activity(pool, username).await?;
+    crate::append_limits::delete_append_limit(pool, username).await?;
+    crate::autoresponses::delete_autoresponse(pool, username).await?;
     Ok(())
 }
 
@@ -17,7 +17,7 @@
 
 use chatmail_types::Result;
 
-use crate::pool::DbBackend;
+use crate::pool::{pg_sql, DbBackend};
 use crate::schema::PasswordsLayout;
 use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
 
@@ -82,6 +82,60 @@ pub async fn create_user(pool: &DbPool, username: &str, hash: &str) -> Result<()
     Ok(())
 }
 
+/// Replace the hash of an existing account; never creates one. Returns false when the account
+/// is gone. Writing the hash it already has still counts as an update.
+pub async fn set_user_hash(pool: &DbPool, username: &str, hash: &str) -> Result<bool> {
+    update_hash(pool, username, hash, None).await
+}
+
+/// Like [`set_user_hash`], but only while the stored hash is still `expected`, so a background
+/// re-hash cannot undo a password change that landed in between.
+pub async fn swap_user_hash(
+    pool: &DbPool,
+    username: &str,
+    expected: &str,
+    hash: &str,
+) -> Result<bool> {
+    update_hash(pool, username, hash, Some(expected)).await
+}
+
+async fn update_hash(
+    pool: &DbPool,
+    username: &str,
+    hash: &str,
+    expected: Option<&str>,
+) -> Result<bool> {
+    let (hash_col, user_col) = match detect_schema(pool).await? {
+        PasswordsLayout::ChatmailRs => ("hash", "username"),
+        PasswordsLayout::MadmailKv => ("value", "key"),
+        PasswordsLayout::Unknown => return Ok(false),
+    };
+    let mut sql = format!("UPDATE passwords SET {hash_col} = ? WHERE {user_col} = ?");
+    if expected.is_some() {
+        sql.push_str(&format!(" AND {hash_col} = ?"));
+    }
+    let affected = match pool {
+        DbPool::Sqlite(p) => {
+            let q = sqlx::query(&sql).bind(hash).bind(username);
+            let q = match expected {
+                Some(e) => q.bind(e),
+                None => q,
+            };
+            q.execute(p).await?.rows_affected()
+        }
+        DbPool::Postgres(p) => {
+            let sql = pg_sql(&sql);
+            let q = sqlx::query(&sql).bind(hash).bind(username);
+            let q = match expected {
+                Some(e) => q.bind(e),
+                None => q,
+            };
+            q.execute(p).await?.rows_affected()
+        }
+    };
+    Ok(affected > 0)
+}
+
 pub async fn delete_user(pool: &DbPool, username: &str) -> Result<()> {
     match detect_schema(pool).await? {
         PasswordsLayout::MadmailKv => {
@@ -129,11 +183,60 @@ pub async fn list_all_credentials(pool: &DbPool) -> Result<Vec<(String, String)>
     }
 }
 
+/// One page of account names in name order, skipping internal `__KEY__` rows.
+///
+/// `filter` is a case-insensitive substring (empty matches everything). Returns the page
+/// and the number of matching accounts; only the page is read into memory.
+pub async fn list_users_page(
+    pool: &DbPool,
+    filter: &str,
+    offset: i64,
+    limit: i64,
+) -> Result<(Vec<String>, i64)> {
+    let col = match detect_schema(pool).await? {
+        PasswordsLayout::ChatmailRs => "username",
+        PasswordsLayout::MadmailKv => "key",
+        PasswordsLayout::Unknown => return Ok((Vec::new(), 0)),
+    };
+    let not_internal = match pool.backend() {
+        DbBackend::Sqlite => format!("{col} NOT GLOB '__*__'"),
+        DbBackend::Postgres => format!("{col} !~ '^__.*__$'"),
+    };
+    let filter_clause = format!("WHERE {not_internal} AND LOWER({col}) LIKE ? ESCAPE '\\'");
+    let pattern = format!("%{}%", escape_like(&filter.to_lowercase()));
+
+    let sql = format!("SELECT COUNT(*) FROM passwords {filter_clause}");
+    let total = crate::db_fetch_scalar!(pool, i64, &sql, pattern.as_str())?;
+    let sql =
+        format!("SELECT {col} FROM passwords {filter_clause} ORDER BY {col} LIMIT ? OFFSET ?");
+    let rows: Vec<(String,)> =
+        db_fetch_all!(pool, (String,), &sql, pattern.as_str(), limit, offset)?;
+    Ok((rows.into_iter().map(|(u,)| u).collect(), total))
+}
+
+/// Escape `%`, `_` and `\` for a `LIKE ... ESCAPE '\'` pattern.
+fn escape_like(s: &str) -> String {
+    let mut out = String::with_capacity(s.len());
+    for c in s.chars() {
+        if matches!(c, '%' | '_' | '\\') {
+            out.push('\\');
+        }
+        out.push(c);
+    }
+    out
+}
+
 pub async fn delete_user_full(pool: &DbPool, username: &str, reason: &str) -> Result<()> {
     delete_user(pool, username).await?;
     let qt = crate::schema::quota_table(pool).await?;
     let sql = format!("DELETE FROM {qt} WHERE username = ?");
     db_execute!(pool, &sql, username)?;
+    crate::app_passwords::delete_app_passwords(pool, username).await?;
+    crate::device_codes::revoke_device_codes(pool, username).await?;
+    crate::recovery_codes::delete_recovery_codes(pool, username).await?;
+    crate::account_activity::delete_activity(pool, username).await?;
+    crate::append_limits::delete_append_limit(pool, username).await?;
+    crate::autoresponses::delete_autoresponse(pool, username).await?;
     crate::blocklist::block_user(pool, username, reason).await?;
     Ok(())
 }
@@ -160,6 +263,42 @@ mod tests {
         );
     }
 
+    #[tokio::test]
+    async fn set_user_hash_updates_existing_accounts_only() {
+        let pool = init_memory_db().await.unwrap();
+        create_user(&pool, "u@example.org", "old").await.unwrap();
+        assert!(set_user_hash(&pool, "u@example.org", "new").await.unwrap());
+        assert!(
+            set_user_hash(&pool, "u@example.org", "new").await.unwrap(),
+            "same hash again"
+        );
+        assert!(!set_user_hash(&pool, "gone@example.org", "new")
+            .await
+            .unwrap());
+        assert_eq!(
+            get_user_hash(&pool, "u@example.org")
+                .await
+                .unwrap()
+                .as_deref(),
+            Some("new")
+        );
+        assert!(!user_exists(&pool, "gone@example.org").await.unwrap());
+
+        assert!(!swap_user_hash(&pool, "u@example.org", "old", "rehashed")
+            .await
+            .unwrap());
+        assert!(swap_user_hash(&pool, "u@example.org", "new", "rehashed")
+            .await
+            .unwrap());
+        assert_eq!(
+            get_user_hash(&pool, "u@example.org")
+                .await
+                .unwrap()
+                .as_deref(),
+            Some("rehashed")
+        );
+    }
+
     #[tokio::test]
     async fn test_passwords_madmail_kv_schema() {
         let pool = init_memory_db().await.unwrap();
@@ -198,6 +337,28 @@ mod tests {
         assert!(!users.iter().any(|u| u.starts_with("__")));
     }
 
+    #[tokio::test]
+    async fn list_users_page_filters_and_pages_in_sql() {
+        let pool = init_memory_db().await.unwrap();
+        for user in ["carol@x.org", "alice@x.org", "bob@x.org", "al_x@y.org"] {
+            create_user(&pool, user, "h").await.unwrap();
+        }
+        create_user(&pool, "__REGISTRATION_OPEN__", "true")
+            .await
+            .unwrap();
+
+        let (page, total) = list_users_page(&pool, "", 1, 2).await.unwrap();
+        assert_eq!(total, 4);
+        assert_eq!(page, ["alice@x.org", "bob@x.org"]);
+
+        let (page, total) = list_users_page(&pool, "X.ORG", 0, 10).await.unwrap();
+        assert_eq!(total, 3, "case-insensitive substring");
+        assert_eq!(page.len(), 3);
+
+        let (page, _) = list_users_page(&pool, "l_", 0, 10).await.unwrap();
+        assert_eq!(page, ["al_x@y.org"], "`_` is literal, not a wildcard");
+    }
+
     #[tokio::test]
     async fn test_is_blocked() {
         let pool = init_memory_db().await.unwrap();
@@ -0,0 +1,181 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Federation peers added at runtime (`federation_peers`, `madmail peers add`).
+//!
+//! Each row is a peer's base URL, normalized to `scheme://host[:port]`; the fetched
+//! `/federation/info` documents live in memory only (`chatmail-state::peers`). Peers from
+//! the `peers` config directive are not stored here.
+
+use chatmail_types::{ChatmailError, Result};
+
+use crate::policies::unix_now;
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Peer {
+    /// `https://host[:port]` or `http://host[:port]`.
+    pub base_url: String,
+    pub created_at: i64,
+}
+
+/// `https://Host.example:8443/` → `https://host.example:8443`; a bare host gets `https://`.
+/// Paths, queries, credentials and other schemes are rejected.
+pub fn normalize_peer_url(raw: &str) -> Result<String> {
+    let invalid = || ChatmailError::config(format!("invalid peer URL: {raw:?}"));
+    let trimmed = raw.trim();
+    let (scheme, rest) = match trimmed.split_once("://") {
+        Some((scheme, rest)) => (scheme.to_ascii_lowercase(), rest),
+        None => ("https".to_string(), trimmed),
+    };
+    if scheme != "https" && scheme != "http" {
+        return Err(invalid());
+    }
+    let authority = rest.strip_suffix('/').unwrap_or(rest).to_ascii_lowercase();
+    let (host, port) = if authority.starts_with('[') {
+        match authority.split_once("]:") {
+            Some((ip, port)) => (&authority[..=ip.len()], Some(port)),
+            None => (authority.as_str(), None),
+        }
+    } else {
+        match authority.split_once(':') {
+            Some((host, port)) => (host, Some(port)),
+            None => (authority.as_str(), None),
+        }
+    };
+    let host_ok = !host.is_empty()
+        && host.len() <= 253
+        && (host
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '.' || c == '-')
+            || (host.starts_with('[')
+                && host.ends_with(']')
+                && host[1..host.len() - 1]
+                    .parse::<std::net::Ipv6Addr>()
+                    .is_ok()));
+    let port_ok = port.map_or(true, |p| p.parse::<u16>().is_ok_and(|p| p != 0));
+    if !host_ok || !port_ok {
+        return Err(invalid());
+    }
+    Ok(format!("{scheme}://{authority}"))
+}
+
+/// Store a peer; returns `false` when it was already listed.
+pub async fn add_peer(pool: &DbPool, base_url: &str) -> Result<bool> {
+    let base_url = normalize_peer_url(base_url)?;
+    let existing: Option<(String,)> = db_fetch_optional!(
+        pool,
+        (String,),
+        "SELECT base_url FROM federation_peers WHERE base_url = ?",
+        base_url.as_str()
+    )?;
+    if existing.is_some() {
+        return Ok(false);
+    }
+    db_execute!(
+        pool,
+        "INSERT INTO federation_peers (base_url, created_at) VALUES (?, ?)",
+        base_url.as_str(),
+        unix_now()
+    )?;
+    Ok(true)
+}
+
+/// Delete a stored peer. Returns `false` when there was none.
+pub async fn remove_peer(pool: &DbPool, base_url: &str) -> Result<bool> {
+    let base_url = normalize_peer_url(base_url)?;
+    let existing: Option<(String,)> = db_fetch_optional!(
+        pool,
+        (String,),
+        "SELECT base_url FROM federation_peers WHERE base_url = ?",
+        base_url.as_str()
+    )?;
+    if existing.is_none() {
+        return Ok(false);
+    }
+    db_execute!(
+        pool,
+        "DELETE FROM federation_peers WHERE base_url = ?",
+        base_url.as_str()
+    )?;
+    Ok(true)
+}
+
+/// All stored peers ordered by URL.
+pub async fn list_peers(pool: &DbPool) -> Result<Vec<Peer>> {
+    let rows: Vec<(String, i64)> = db_fetch_all!(
+        pool,
+        (String, i64),
+        "SELECT base_url, created_at FROM federation_peers ORDER BY base_url"
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(|(base_url, created_at)| Peer {
+            base_url,
+            created_at,
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[test]
+    fn normalizes_peer_urls() {
+        for (raw, want) in [
+            ("peer.example", "https://peer.example"),
+            (" HTTPS://Peer.Example/ ", "https://peer.example"),
+            ("http://peer.example:8080", "http://peer.example:8080"),
+            ("https://[2001:db8::1]:443", "https://[2001:db8::1]:443"),
+            ("https://[2001:db8::1]", "https://[2001:db8::1]"),
+        ] {
+            assert_eq!(normalize_peer_url(raw).unwrap(), want, "{raw}");
+        }
+        for raw in [
+            "",
+            "ftp://peer.example",
+            "https://peer.example/federation/info",
+            "https://user@peer.example",
+            "https://peer.example?x=1",
+            "https://peer.example:0",
+            "https://peer.example:99999",
+            "https://peer example",
+        ] {
+            assert!(normalize_peer_url(raw).is_err(), "{raw}");
+        }
+    }
+
+    #[tokio::test]
+    async fn add_list_remove() {
+        let pool = init_memory_db().await.unwrap();
+        assert!(add_peer(&pool, "b.example").await.unwrap());
+        assert!(add_peer(&pool, "https://a.example/").await.unwrap());
+        assert!(!add_peer(&pool, "HTTPS://B.example").await.unwrap());
+        let urls: Vec<_> = list_peers(&pool)
+            .await
+            .unwrap()
+            .into_iter()
+            .map(|p| p.base_url)
+            .collect();
+        assert_eq!(urls, ["https://a.example", "https://b.example"]);
+        assert!(remove_peer(&pool, "a.example").await.unwrap());
+        assert!(!remove_peer(&pool, "a.example").await.unwrap());
+        assert_eq!(list_peers(&pool).await.unwrap().len(), 1);
+    }
+}
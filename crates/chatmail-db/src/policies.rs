@@ -0,0 +1,558 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Unified policy lists (`policy_entries` table): PGP passthrough, federation allow/deny,
+//! reserved local parts, reserved sharing slugs, and subaddress folder filing behind one
+//! storage and matcher layer.
+//!
+//! Pattern forms (case-insensitive):
+//! - `example.org` — exact
+//! - `*.example.org`, `bot-?` — glob (`*` any run, `?` one character)
+//! - `.example.org` — domain suffix (the domain itself and every subdomain)
+//! - `@example.org` — address lists only (PGP passthrough, subaddress folders): every
+//!   address at the domain
+
+use chatmail_types::{address_domain, ChatmailError, Result};
+
+use crate::federation_policy::normalize_federation_domain;
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
+
+/// Which list an entry belongs to.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum PolicyType {
+    /// Recipients/senders exempt from the PGP-only requirement.
+    PgpPassthrough,
+    /// Federation peer domains (`allow` / `deny`).
+    Federation,
+    /// Local parts that registration must never hand out.
+    ReservedName,
+    /// Contact-sharing slugs held back from `sharing create`.
+    SharingReserved,
+    /// Accounts whose `user+tag@` mail is filed into an existing mailbox named `tag`.
+    SubaddressFolder,
+}
+
+impl PolicyType {
+    pub const ALL: [PolicyType; 5] = [
+        PolicyType::PgpPassthrough,
+        PolicyType::Federation,
+        PolicyType::ReservedName,
+        PolicyType::SharingReserved,
+        PolicyType::SubaddressFolder,
+    ];
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            PolicyType::PgpPassthrough => "pgp_passthrough",
+            PolicyType::Federation => "federation",
+            PolicyType::ReservedName => "reserved_name",
+            PolicyType::SharingReserved => "sharing_reserved",
+            PolicyType::SubaddressFolder => "subaddress_folder",
+        }
+    }
+
+    /// Parse a type name; `-` and `_` are interchangeable (`pgp-passthrough`).
+    pub fn parse(raw: &str) -> Result<Self> {
+        let norm = raw.trim().to_ascii_lowercase().replace('-', "_");
+        Self::ALL
+            .into_iter()
+            .find(|t| t.as_str() == norm)
+            .ok_or_else(|| {
+                ChatmailError::config(format!(
+                    "unknown policy type: {raw} (expected one of: {})",
+                    Self::ALL.map(PolicyType::as_str).join(", ")
+                ))
+            })
+    }
+
+    /// Actions accepted for this type; the first is the default.
+    pub fn actions(self) -> &'static [&'static str] {
+        match self {
+            PolicyType::PgpPassthrough => &["allow"],
+            PolicyType::Federation => &["deny", "allow"],
+            PolicyType::ReservedName | PolicyType::SharingReserved => &["reserve"],
+            PolicyType::SubaddressFolder => &["file"],
+        }
+    }
+
+    pub fn default_action(self) -> &'static str {
+        self.actions()[0]
+    }
+
+    /// Validate and normalize a pattern for this type.
+    pub fn validate_pattern(self, raw: &str) -> Result<String> {
+        let p = raw.trim().to_ascii_lowercase();
+        if p.is_empty() {
+            return Err(ChatmailError::config("PATTERN is required"));
+        }
+        if p.chars().any(char::is_whitespace) {
+            return Err(ChatmailError::config("PATTERN must not contain whitespace"));
+        }
+        let ok = match self {
+            PolicyType::Federation => {
+                let d = normalize_federation_domain(&p);
+                let ok = is_domain_pattern(&d);
+                if ok {
+                    return Ok(d);
+                }
+                ok
+            }
+            PolicyType::PgpPassthrough | PolicyType::SubaddressFolder => match p.rsplit_once('@') {
+                Some((local, domain)) => {
+                    is_local_part_pattern(local, true) && is_domain_pattern(domain)
+                }
+                None => false,
+            },
+            PolicyType::ReservedName => is_local_part_pattern(&p, false),
+            PolicyType::SharingReserved => p
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || c == '*' || c == '?'),
+        };
+        if !ok {
+            let want = match self {
+                PolicyType::Federation => "a domain (example.org, *.example.org, .example.org)",
+                PolicyType::PgpPassthrough | PolicyType::SubaddressFolder => {
+                    "an address (user@example.org, *@example.org, @example.org)"
+                }
+                PolicyType::ReservedName => "a local part (admin, postmaster, bot-*)",
+                PolicyType::SharingReserved => "an alphanumeric slug (glob allowed)",
+            };
+            return Err(ChatmailError::config(format!(
+                "invalid {} pattern {raw:?}: expected {want}",
+                self.as_str()
+            )));
+        }
+        Ok(p)
+    }
+
+    pub fn validate_action(self, raw: Option<&str>) -> Result<&'static str> {
+        let Some(raw) = raw.map(str::trim).filter(|s| !s.is_empty()) else {
+            return Ok(self.default_action());
+        };
+        self.actions()
+            .iter()
+            .copied()
+            .find(|a| a.eq_ignore_ascii_case(raw))
+            .ok_or_else(|| {
+                ChatmailError::config(format!(
+                    "invalid action {raw:?} for {} (expected: {})",
+                    self.as_str(),
+                    self.actions().join(", ")
+                ))
+            })
+    }
+
+    /// Whether `pattern` (already validated) matches `value` for this list.
+    pub fn matches(self, pattern: &str, value: &str) -> bool {
+        match self {
+            PolicyType::Federation => pattern_matches(pattern, &normalize_federation_domain(value)),
+            PolicyType::PgpPassthrough | PolicyType::SubaddressFolder => {
+                match pattern.strip_prefix('@') {
+                    Some(domain) => address_domain(value)
+                        .is_some_and(|d| pattern_matches(domain, &normalize_federation_domain(&d))),
+                    None => pattern_matches(pattern, value),
+                }
+            }
+            PolicyType::ReservedName => {
+                let local = value.split('@').next().unwrap_or(value);
+                pattern_matches(pattern, local)
+            }
+            PolicyType::SharingReserved => pattern_matches(pattern, value),
+        }
+    }
+}
+
+fn is_domain_pattern(d: &str) -> bool {
+    let body = d
+        .strip_prefix("*.")
+        .or_else(|| d.strip_prefix('.'))
+        .unwrap_or(d);
+    if body.is_empty() || body.contains("..") || body.starts_with('-') {
+        return false;
+    }
+    // IPv4 / IPv6 literals (brackets already stripped by normalization).
+    if body.parse::<std::net::IpAddr>().is_ok() {
+        return true;
+    }
+    body.contains('.')
+        && body
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '.' | '-' | '*' | '?'))
+}
+
+fn is_local_part_pattern(l: &str, allow_empty: bool) -> bool {
+    if l.is_empty() {
+        return allow_empty;
+    }
+    l.chars()
+        .all(|c| c.is_ascii_alphanumeric() || matches!(c, '.' | '_' | '-' | '+' | '*' | '?'))
+}
+
+/// Exact, glob (`*`/`?`), or domain-suffix (leading `.`) match, case-insensitive.
+pub fn pattern_matches(pattern: &str, value: &str) -> bool {
+    let pattern = pattern.to_ascii_lowercase();
+    let value = value.trim().to_ascii_lowercase();
+    if let Some(suffix) = pattern.strip_prefix('.') {
+        return domain_suffix_match(suffix, &value);
+    }
+    if pattern.contains(['*', '?']) {
+        return glob_match(&pattern, &value);
+    }
+    pattern == value
+}
+
+/// `example.org` matches `example.org` and `a.b.example.org`, not `badexample.org`.
+pub fn domain_suffix_match(suffix: &str, domain: &str) -> bool {
+    domain == suffix
+        || domain
+            .strip_suffix(suffix)
+            .is_some_and(|head| head.ends_with('.'))
+}
+
+/// Shell-style glob over bytes: `*` matches any run (including empty), `?` exactly one byte.
+pub fn glob_match(pattern: &str, value: &str) -> bool {
+    let (p, v) = (pattern.as_bytes(), value.as_bytes());
+    let (mut pi, mut vi) = (0usize, 0usize);
+    let mut star: Option<(usize, usize)> = None;
+    while vi < v.len() {
+        if pi < p.len() && (p[pi] == b'?' || p[pi] == v[vi]) {
+            pi += 1;
+            vi += 1;
+        } else if pi < p.len() && p[pi] == b'*' {
+            star = Some((pi, vi));
+            pi += 1;
+        } else if let Some((sp, sv)) = star {
+            pi = sp + 1;
+            vi = sv + 1;
+            star = Some((sp, sv + 1));
+        } else {
+            return false;
+        }
+    }
+    p[pi..].iter().all(|&c| c == b'*')
+}
+
+/// One `policy_entries` row.
+#[derive(Debug, Clone, PartialEq, Eq, sqlx::FromRow)]
+pub struct PolicyEntry {
+    pub id: i64,
+    pub policy_type: String,
+    pub pattern: String,
+    pub action: String,
+    pub note: String,
+    /// Unix seconds after which the entry stops matching (`None` = permanent).
+    pub expires_at: Option<i64>,
+    pub created_at: i64,
+}
+
+impl PolicyEntry {
+    pub fn is_active(&self, now: i64) -> bool {
+        self.expires_at.is_none_or(|t| t > now)
+    }
+
+    pub fn kind(&self) -> Option<PolicyType> {
+        PolicyType::parse(&self.policy_type).ok()
+    }
+
+    /// Active and matching `value` (expired entries never match).
+    pub fn matches(&self, value: &str, now: i64) -> bool {
+        self.is_active(now) && self.kind().is_some_and(|k| k.matches(&self.pattern, value))
+    }
+}
+
+/// Current Unix time in seconds (expiry comparisons).
+pub fn unix_now() -> i64 {
+    std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_secs() as i64)
+        .unwrap_or(0)
+}
+
+const SELECT_COLUMNS: &str =
+    "SELECT id, policy_type, pattern, action, note, expires_at, created_at FROM policy_entries";
+
+/// Insert or replace the entry for `(policy_type, pattern)` after per-type validation.
+pub async fn add_policy_entry(
+    pool: &DbPool,
+    policy_type: PolicyType,
+    pattern: &str,
+    action: Option<&str>,
+    note: &str,
+    expires_at: Option<i64>,
+) -> Result<PolicyEntry> {
+    let pattern = policy_type.validate_pattern(pattern)?;
+    let action = policy_type.validate_action(action)?;
+    db_execute!(
+        pool,
+        "INSERT INTO policy_entries (policy_type, pattern, action, note, expires_at, created_at)
+         VALUES (?, ?, ?, ?, ?, ?)
+         ON CONFLICT(policy_type, pattern) DO UPDATE SET
+             action = excluded.action, note = excluded.note, expires_at = excluded.expires_at",
+        policy_type.as_str(),
+        pattern.as_str(),
+        action,
+        note.trim(),
+        expires_at,
+        unix_now()
+    )?;
+    get_policy_entry(pool, policy_type, &pattern)
+        .await?
+        .ok_or_else(|| ChatmailError::storage("policy entry vanished after insert"))
+}
+
+pub async fn get_policy_entry(
+    pool: &DbPool,
+    policy_type: PolicyType,
+    pattern: &str,
+) -> Result<Option<PolicyEntry>> {
+    let sql = format!("{SELECT_COLUMNS} WHERE policy_type = ? AND pattern = ?");
+    db_fetch_optional!(
+        pool,
+        PolicyEntry,
+        &sql,
+        policy_type.as_str(),
+        pattern.trim().to_ascii_lowercase()
+    )
+}
+
+/// Entries of one type (or all types), including expired ones — callers filter with
+/// [`PolicyEntry::is_active`] so operators can still see what lapsed.
+pub async fn list_policy_entries(
+    pool: &DbPool,
+    policy_type: Option<PolicyType>,
+) -> Result<Vec<PolicyEntry>> {
+    match policy_type {
+        Some(t) => {
+            let sql = format!("{SELECT_COLUMNS} WHERE policy_type = ? ORDER BY pattern");
+            db_fetch_all!(pool, PolicyEntry, &sql, t.as_str())
+        }
+        None => {
+            let sql = format!("{SELECT_COLUMNS} ORDER BY policy_type, pattern");
+            db_fetch_all!(pool, PolicyEntry, &sql)
+        }
+    }
+}
+
+/// Remove one entry; `Ok(false)` when nothing matched.
+pub async fn remove_policy_entry(
+    pool: &DbPool,
+    policy_type: PolicyType,
+    pattern: &str,
+) -> Result<bool> {
+    let pattern = pattern.trim().to_ascii_lowercase();
+    if get_policy_entry(pool, policy_type, &pattern)
+        .await?
+        .is_none()
+    {
+        return Ok(false);
+    }
+    db_execute!(
+        pool,
+        "DELETE FROM policy_entries WHERE policy_type = ? AND pattern = ?",
+        policy_type.as_str(),
+        pattern.as_str()
+    )?;
+    Ok(true)
+}
+
+/// Delete entries whose `expires_at` has passed (housekeeping; matching ignores them anyway).
+pub async fn purge_expired_policy_entries(pool: &DbPool, now: i64) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM policy_entries WHERE expires_at IS NOT NULL AND expires_at <= ?",
+        now
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[test]
+    fn exact_glob_and_suffix_matchers() {
+        assert!(pattern_matches("example.org", "Example.ORG"));
+        assert!(!pattern_matches("example.org", "sub.example.org"));
+
+        assert!(pattern_matches("*.example.org", "a.example.org"));
+        assert!(!pattern_matches("*.example.org", "example.org"));
+        assert!(pattern_matches("bot-?", "bot-1"));
+        assert!(!pattern_matches("bot-?", "bot-12"));
+        assert!(glob_match("a*b*c", "axxbyyc"));
+        assert!(!glob_match("a*b*c", "axxbyy"));
+
+        assert!(pattern_matches(".example.org", "example.org"));
+        assert!(pattern_matches(".example.org", "deep.sub.example.org"));
+        assert!(!pattern_matches(".example.org", "badexample.org"));
+    }
+
+    #[test]
+    fn type_specific_matching() {
+        let pgp = PolicyType::PgpPassthrough;
+        assert!(pgp.matches("@example.org", "anyone@Example.org"));
+        assert!(!pgp.matches("@example.org", "anyone@other.org"));
+        assert!(pgp.matches("*@example.org", "x@example.org"));
+        assert!(pgp.matches("ops@example.org", "OPS@example.org"));
+
+        let fed = PolicyType::Federation;
+        assert!(fed.matches("1.2.3.4", "[1.2.3.4]"));
+
+        let folders = PolicyType::SubaddressFolder;
+        assert!(folders.matches("@example.org", "me@example.org"));
+        assert!(folders.matches("me@example.org", "me@example.org"));
+        assert!(!folders.matches("me@example.org", "you@example.org"));
+
+        let reserved = PolicyType::ReservedName;
+        assert!(reserved.matches("admin", "admin@example.org"));
+        assert!(reserved.matches("post*", "postmaster"));
+    }
+
+    #[test]
+    fn per_type_validation() {
+        let fed = PolicyType::Federation;
+        assert_eq!(fed.validate_pattern("[1.1.1.1]").unwrap(), "1.1.1.1");
+        assert_eq!(
+            fed.validate_pattern("*.Example.org").unwrap(),
+            "*.example.org"
+        );
+        assert!(fed.validate_pattern(".example.org").is_ok());
+        assert!(fed.validate_pattern("user@example.org").is_err());
+        assert!(fed.validate_pattern("localhost").is_err());
+        assert!(fed.validate_pattern("bad domain.org").is_err());
+
+        let pgp = PolicyType::PgpPassthrough;
+        assert!(pgp.validate_pattern("@example.org").is_ok());
+        assert!(pgp.validate_pattern("*@example.org").is_ok());
+        assert!(pgp.validate_pattern("example.org").is_err());
+        assert!(PolicyType::SubaddressFolder
+            .validate_pattern("me@example.org")
+            .is_ok());
+        assert!(PolicyType::SubaddressFolder.validate_pattern("me").is_err());
+        assert_eq!(
+            PolicyType::SubaddressFolder.validate_action(None).unwrap(),
+            "file"
+        );
+
+        assert!(PolicyType::ReservedName.validate_pattern("admin").is_ok());
+        assert!(PolicyType::ReservedName
+            .validate_pattern("admin@x.org")
+            .is_err());
+        assert!(PolicyType::SharingReserved
+            .validate_pattern("team*")
+            .is_ok());
+        assert!(PolicyType::SharingReserved
+            .validate_pattern("team-a")
+            .is_err());
+
+        assert_eq!(fed.validate_action(None).unwrap(), "deny");
+        assert_eq!(fed.validate_action(Some("ALLOW")).unwrap(), "allow");
+        assert!(fed.validate_action(Some("reserve")).is_err());
+        assert_eq!(
+            PolicyType::parse("pgp-passthrough").unwrap(),
+            PolicyType::PgpPassthrough
+        );
+        assert!(PolicyType::parse("nope").is_err());
+    }
+
+    #[tokio::test]
+    async fn crud_roundtrip_and_upsert() {
+        let pool = init_memory_db().await.unwrap();
+        let e = add_policy_entry(
+            &pool,
+            PolicyType::Federation,
+            "Evil.Example",
+            None,
+            "spam source",
+            None,
+        )
+        .await
+        .unwrap();
+        assert_eq!(e.pattern, "evil.example");
+        assert_eq!(e.action, "deny");
+
+        let e2 = add_policy_entry(
+            &pool,
+            PolicyType::Federation,
+            "evil.example",
+            Some("allow"),
+            "",
+            None,
+        )
+        .await
+        .unwrap();
+        assert_eq!(e2.id, e.id, "same (type, pattern) is updated in place");
+        assert_eq!(e2.action, "allow");
+
+        add_policy_entry(&pool, PolicyType::ReservedName, "admin", None, "", None)
+            .await
+            .unwrap();
+        assert_eq!(list_policy_entries(&pool, None).await.unwrap().len(), 2);
+        assert_eq!(
+            list_policy_entries(&pool, Some(PolicyType::Federation))
+                .await
+                .unwrap()
+                .len(),
+            1
+        );
+
+        assert!(
+            remove_policy_entry(&pool, PolicyType::Federation, "EVIL.example")
+                .await
+                .unwrap()
+        );
+        assert!(
+            !remove_policy_entry(&pool, PolicyType::Federation, "evil.example")
+                .await
+                .unwrap()
+        );
+    }
+
+    #[tokio::test]
+    async fn expired_entries_stop_matching() {
+        let pool = init_memory_db().await.unwrap();
+        let now = unix_now();
+        let live = add_policy_entry(
+            &pool,
+            PolicyType::ReservedName,
+            "temp",
+            None,
+            "",
+            Some(now + 3600),
+        )
+        .await
+        .unwrap();
+        assert!(live.matches("temp", now));
+        assert!(!live.matches("temp", now + 3600), "expiry is exclusive");
+
+        add_policy_entry(
+            &pool,
+            PolicyType::ReservedName,
+            "gone",
+            None,
+            "",
+            Some(now - 1),
+        )
+        .await
+        .unwrap();
+        purge_expired_policy_entries(&pool, now).await.unwrap();
+        let left = list_policy_entries(&pool, Some(PolicyType::ReservedName))
+            .await
+            .unwrap();
+        assert_eq!(left.len(), 1);
+        assert_eq!(left[0].pattern, "temp");
+    }
+}
@@ -49,6 +49,20 @@ impl DbPool {
     pub fn is_postgres(&self) -> bool {
         matches!(self.backend(), DbBackend::Postgres)
     }
+
+    /// Round-trip `SELECT 1` (health probe).
+    pub async fn ping(&self) -> Result<()> {
+        crate::db_fetch_one!(*self, (i32,), "SELECT 1")?;
+        Ok(())
+    }
+
+    /// Wait for checked-out connections to return, then close them (last shutdown step).
+    pub async fn close(&self) {
+        match self {
+            Self::Sqlite(p) => p.close().await,
+            Self::Postgres(p) => p.close().await,
+        }
+    }
 }
 
 /// Fetch zero or one row (`?` placeholders; adapted for PostgreSQL).
@@ -291,6 +305,7 @@ pub(crate) async fn run_migrations(pool: &DbPool) -> Result<()> {
             "skipping madmail-v2 sqlx migrations (existing Madmail / go-imap-sql schema); ensuring application tables"
         );
         apply_legacy_schema_tables(pool).await?;
+        apply_legacy_schema_columns(pool).await?;
         return Ok(());
     }
 
@@ -348,6 +363,41 @@ async fn apply_legacy_schema_tables(pool: &DbPool) -> Result<()> {
     Ok(())
 }
 
+/// Columns added to application tables after their first release, as
+/// `(table, column, definition)`. `CREATE TABLE IF NOT EXISTS` leaves a table from an older
+/// release as it was, so the ensure path adds each missing column.
+const ENSURE_COLUMNS: &[(&str, &str, &str)] = &[
+    ("admin_audit", "actor", "TEXT NOT NULL DEFAULT 'admin-api'"),
+    ("admin_audit", "old_value", "TEXT NOT NULL DEFAULT ''"),
+    ("admin_audit", "new_value", "TEXT NOT NULL DEFAULT ''"),
+];
+
+/// Add the [`ENSURE_COLUMNS`] a legacy-schema database lacks.
+async fn apply_legacy_schema_columns(pool: &DbPool) -> Result<()> {
+    for (table, column, definition) in ENSURE_COLUMNS {
+        let sql = match pool.backend() {
+            DbBackend::Postgres => {
+                format!("ALTER TABLE {table} ADD COLUMN IF NOT EXISTS {column} {definition}")
+            }
+            DbBackend::Sqlite => {
+                let present = db_fetch_scalar!(
+                    pool,
+                    i64,
+                    "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+                    *table,
+                    *column
+                )?;
+                if present > 0 {
+                    continue;
+                }
+                format!("ALTER TABLE {table} ADD COLUMN {column} {definition}")
+            }
+        };
+        db_execute!(pool, &sql)?;
+    }
+    Ok(())
+}
+
 /// Single-statement DDL/DML for the SQLite legacy-schema ensure path.
 const SQLITE_ENSURE_TABLE_STATEMENTS: &[&str] = &[
     r#"CREATE TABLE IF NOT EXISTS settings (
@@ -449,6 +499,154 @@ const SQLITE_ENSURE_TABLE_STATEMENTS: &[&str] = &[
     modseq INTEGER NOT NULL DEFAULT 0
 )"#,
     r#"CREATE UNIQUE INDEX IF NOT EXISTS mailbox_modseq_username_key ON mailbox_modseq (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS policy_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    policy_type TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    action TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    expires_at INTEGER,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+)"#,
+    r#"CREATE UNIQUE INDEX IF NOT EXISTS policy_entries_type_pattern_key ON policy_entries (policy_type, pattern)"#,
+    r#"CREATE TABLE IF NOT EXISTS app_passwords (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    label TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS app_passwords_username_idx ON app_passwords (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS device_codes (
+    code_hash TEXT PRIMARY KEY NOT NULL,
+    username TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS device_codes_username_idx ON device_codes (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS incidents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    component TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS account_activity (
+    username TEXT PRIMARY KEY NOT NULL,
+    last_activity_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS bans (
+    cidr TEXT PRIMARY KEY NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER NOT NULL DEFAULT 0,
+    strikes INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS ban_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    cidr TEXT NOT NULL,
+    source TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS responders (
+    address TEXT PRIMARY KEY NOT NULL,
+    template TEXT NOT NULL,
+    interval_secs INTEGER NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS responder_replies (
+    address TEXT NOT NULL,
+    correspondent TEXT NOT NULL,
+    sent_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (address, correspondent)
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS catchalls (
+    domain TEXT PRIMARY KEY NOT NULL,
+    target TEXT NOT NULL,
+    daily_cap INTEGER NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS catchall_daily (
+    domain TEXT NOT NULL,
+    day INTEGER NOT NULL,
+    deliveries INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (domain, day)
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS federation_peers (
+    base_url TEXT PRIMARY KEY NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS broadcast_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    filter TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    recipients INTEGER NOT NULL DEFAULT 0,
+    delivered INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS sending_stats (
+    username TEXT NOT NULL,
+    hour INTEGER NOT NULL,
+    messages INTEGER NOT NULL DEFAULT 0,
+    recipients INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, hour)
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS recovery_codes_username_idx ON recovery_codes (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS account_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    username TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS append_limits (
+    username TEXT PRIMARY KEY NOT NULL,
+    max_bytes INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS admin_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    token_prefix TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    resource TEXT NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 0,
+    remote TEXT NOT NULL DEFAULT '',
+    actor TEXT NOT NULL DEFAULT 'admin-api',
+    old_value TEXT NOT NULL DEFAULT '',
+    new_value TEXT NOT NULL DEFAULT ''
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS admin_audit_created_at_idx ON admin_audit (created_at)"#,
+    r#"CREATE TABLE IF NOT EXISTS autoresponses (
+    username TEXT PRIMARY KEY NOT NULL,
+    active INTEGER NOT NULL DEFAULT 1,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    starts_at INTEGER NOT NULL DEFAULT 0,
+    ends_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS autoresponse_replies (
+    username TEXT NOT NULL,
+    correspondent TEXT NOT NULL,
+    sent_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, correspondent)
+)"#,
 ];
 
 /// Single-statement DDL/DML for the PostgreSQL legacy-schema ensure path.
@@ -553,6 +751,154 @@ ON CONFLICT (name) DO NOTHING"#,
     modseq BIGINT NOT NULL DEFAULT 0
 )"#,
     r#"CREATE UNIQUE INDEX IF NOT EXISTS mailbox_modseq_username_key ON mailbox_modseq (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS policy_entries (
+    id BIGSERIAL PRIMARY KEY,
+    policy_type TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    action TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    expires_at BIGINT,
+    created_at BIGINT NOT NULL DEFAULT (FLOOR(EXTRACT(EPOCH FROM NOW())))
+)"#,
+    r#"CREATE UNIQUE INDEX IF NOT EXISTS policy_entries_type_pattern_key ON policy_entries (policy_type, pattern)"#,
+    r#"CREATE TABLE IF NOT EXISTS app_passwords (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    label TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT (FLOOR(EXTRACT(EPOCH FROM NOW())))
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS app_passwords_username_idx ON app_passwords (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS device_codes (
+    code_hash TEXT PRIMARY KEY NOT NULL,
+    username TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    used_at BIGINT
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS device_codes_username_idx ON device_codes (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS incidents (
+    id BIGSERIAL PRIMARY KEY,
+    component TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT (FLOOR(EXTRACT(EPOCH FROM NOW())))
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS account_activity (
+    username TEXT PRIMARY KEY NOT NULL,
+    last_activity_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS bans (
+    cidr TEXT PRIMARY KEY NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    expires_at BIGINT NOT NULL DEFAULT 0,
+    strikes BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS ban_audit (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    cidr TEXT NOT NULL,
+    source TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS responders (
+    address TEXT PRIMARY KEY NOT NULL,
+    template TEXT NOT NULL,
+    interval_secs BIGINT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS responder_replies (
+    address TEXT NOT NULL,
+    correspondent TEXT NOT NULL,
+    sent_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (address, correspondent)
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS catchalls (
+    domain TEXT PRIMARY KEY NOT NULL,
+    target TEXT NOT NULL,
+    daily_cap BIGINT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS catchall_daily (
+    domain TEXT NOT NULL,
+    day BIGINT NOT NULL,
+    deliveries BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (domain, day)
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS federation_peers (
+    base_url TEXT PRIMARY KEY NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS broadcast_audit (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    filter TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    recipients BIGINT NOT NULL DEFAULT 0,
+    delivered BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS sending_stats (
+    username TEXT NOT NULL,
+    hour BIGINT NOT NULL,
+    messages BIGINT NOT NULL DEFAULT 0,
+    recipients BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, hour)
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS recovery_codes_username_idx ON recovery_codes (username)"#,
+    r#"CREATE TABLE IF NOT EXISTS account_audit (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    username TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS append_limits (
+    username TEXT PRIMARY KEY NOT NULL,
+    max_bytes BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS admin_audit (
+    id BIGSERIAL PRIMARY KEY,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    token_prefix TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    resource TEXT NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 0,
+    remote TEXT NOT NULL DEFAULT '',
+    actor TEXT NOT NULL DEFAULT 'admin-api',
+    old_value TEXT NOT NULL DEFAULT '',
+    new_value TEXT NOT NULL DEFAULT ''
+)"#,
+    r#"CREATE INDEX IF NOT EXISTS admin_audit_created_at_idx ON admin_audit (created_at)"#,
+    r#"CREATE TABLE IF NOT EXISTS autoresponses (
+    username TEXT PRIMARY KEY NOT NULL,
+    active INTEGER NOT NULL DEFAULT 1,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    starts_at BIGINT NOT NULL DEFAULT 0,
+    ends_at BIGINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL DEFAULT 0
+)"#,
+    r#"CREATE TABLE IF NOT EXISTS autoresponse_replies (
+    username TEXT NOT NULL,
+    correspondent TEXT NOT NULL,
+    sent_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, correspondent)
+)"#,
 ];
 
 /// Rewrite SQLite `?` placeholders to PostgreSQL `$1`, `$2`, …
@@ -619,6 +965,7 @@ mod tests {
                 "federation_server_stats",
                 "federation_silent_dismiss",
                 "mailbox_modseq",
+                "policy_entries",
                 "settings",
                 "passwords",
                 "registration_tokens",
@@ -0,0 +1,125 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Account recovery codes (`recovery_codes` table).
+//!
+//! Only digests are stored (see `chatmail_auth::recovery`). Each code resets the password
+//! once; an account's codes are dropped when its password is set another way or the account
+//! is deleted.
+
+use chatmail_types::Result;
+
+use crate::pool::pg_sql;
+use crate::{db_execute, db_fetch_all, DbPool};
+
+/// Replace every code of `username` with `digests`.
+pub async fn replace_recovery_codes(
+    pool: &DbPool,
+    username: &str,
+    digests: &[String],
+) -> Result<()> {
+    delete_recovery_codes(pool, username).await?;
+    let now = crate::policies::unix_now();
+    for digest in digests {
+        db_execute!(
+            pool,
+            "INSERT INTO recovery_codes (username, code_hash, created_at) VALUES (?, ?, ?)",
+            username,
+            digest.as_str(),
+            now
+        )?;
+    }
+    Ok(())
+}
+
+/// `(id, digest)` of every unused code of `username`.
+pub async fn recovery_code_digests(pool: &DbPool, username: &str) -> Result<Vec<(i64, String)>> {
+    db_fetch_all!(
+        pool,
+        (i64, String),
+        "SELECT id, code_hash FROM recovery_codes WHERE username = ? ORDER BY id",
+        username
+    )
+}
+
+/// Delete code `id`; false when it is already gone (of two concurrent uses only one wins).
+pub async fn consume_recovery_code(pool: &DbPool, id: i64) -> Result<bool> {
+    const CLAIM: &str = "DELETE FROM recovery_codes WHERE id = ?";
+    let affected = match pool {
+        DbPool::Sqlite(p) => sqlx::query(CLAIM)
+            .bind(id)
+            .execute(p)
+            .await?
+            .rows_affected(),
+        DbPool::Postgres(p) => sqlx::query(&pg_sql(CLAIM))
+            .bind(id)
+            .execute(p)
+            .await?
+            .rows_affected(),
+    };
+    Ok(affected > 0)
+}
+
+/// Drop every code for `username` (password change, account deletion).
+pub async fn delete_recovery_codes(pool: &DbPool, username: &str) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM recovery_codes WHERE username = ?",
+        username
+    )?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn codes_are_replaced_and_consumed_once() {
+        let pool = init_memory_db().await.unwrap();
+        replace_recovery_codes(&pool, "a@x.org", &["old".into()])
+            .await
+            .unwrap();
+        replace_recovery_codes(&pool, "a@x.org", &["h1".into(), "h2".into()])
+            .await
+            .unwrap();
+        replace_recovery_codes(&pool, "b@x.org", &["h3".into()])
+            .await
+            .unwrap();
+
+        let codes = recovery_code_digests(&pool, "a@x.org").await.unwrap();
+        let digests: Vec<_> = codes.iter().map(|(_, d)| d.as_str()).collect();
+        assert_eq!(digests, ["h1", "h2"]);
+        assert!(consume_recovery_code(&pool, codes[0].0).await.unwrap());
+        assert!(!consume_recovery_code(&pool, codes[0].0).await.unwrap());
+        assert_eq!(
+            recovery_code_digests(&pool, "a@x.org").await.unwrap().len(),
+            1
+        );
+
+        delete_recovery_codes(&pool, "a@x.org").await.unwrap();
+        assert!(recovery_code_digests(&pool, "a@x.org")
+            .await
+            .unwrap()
+            .is_empty());
+        assert_eq!(
+            recovery_code_digests(&pool, "b@x.org").await.unwrap().len(),
+            1
+        );
+    }
+}
@@ -46,10 +46,49 @@ async fn fetch_scalar_i64(pool: &DbPool, sql: &str, bind: &str) -> Result<i64> {
     Ok(row.0)
 }
 
+/// Why a registration token cannot be used right now.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TokenRejection {
+    NotFound,
+    Expired,
+    Exhausted,
+}
+
+impl TokenRejection {
+    /// Stable machine-readable reason for API responses.
+    pub fn reason(self) -> &'static str {
+        match self {
+            Self::NotFound => "token_not_found",
+            Self::Expired => "token_expired",
+            Self::Exhausted => "token_exhausted",
+        }
+    }
+
+    pub fn into_error(self) -> ChatmailError {
+        match self {
+            Self::NotFound => token_not_found(),
+            Self::Expired => token_expired(),
+            Self::Exhausted => token_exhausted(),
+        }
+    }
+}
+
 pub async fn validate_registration_token(pool: &DbPool, token: &str) -> Result<()> {
+    match check_registration_token(pool, token).await? {
+        Some(rejection) => Err(rejection.into_error()),
+        None => Ok(()),
+    }
+}
+
+/// `None` when `token` has a free slot; reservations by accounts that have not logged in yet
+/// count as used.
+pub async fn check_registration_token(
+    pool: &DbPool,
+    token: &str,
+) -> Result<Option<TokenRejection>> {
     let token = token.trim();
     if token.is_empty() {
-        return Err(token_not_found());
+        return Ok(Some(TokenRejection::NotFound));
     }
 
     let row: Option<(i64, i64)> = db_fetch_optional!(
@@ -68,15 +107,15 @@ pub async fn validate_registration_token(pool: &DbPool, token: &str) -> Result<(
             "SELECT expires_at FROM registration_tokens WHERE token = ?",
             token
         )?;
-        return Err(if exists.is_some() {
-            token_expired()
+        return Ok(Some(if exists.is_some() {
+            TokenRejection::Expired
         } else {
-            token_not_found()
-        });
+            TokenRejection::NotFound
+        }));
     };
 
     if used_count >= max_uses {
-        return Err(token_exhausted());
+        return Ok(Some(TokenRejection::Exhausted));
     }
 
     let pending = {
@@ -89,10 +128,10 @@ pub async fn validate_registration_token(pool: &DbPool, token: &str) -> Result<(
     };
 
     if used_count + pending >= max_uses {
-        return Err(token_exhausted());
+        return Ok(Some(TokenRejection::Exhausted));
     }
 
-    Ok(())
+    Ok(None)
 }
 
 pub async fn ensure_new_account_quota(pool: &DbPool, username: &str) -> Result<()> {
@@ -117,13 +156,67 @@ pub async fn attach_registration_token(pool: &DbPool, username: &str, token: &st
     Ok(())
 }
 
-pub async fn reserve_registration_token(pool: &DbPool, username: &str, token: &str) -> Result<()> {
-    attach_registration_token(pool, username, token).await
+/// Attach `token` to the new account only while it still has a free slot (pending
+/// reservations included). `false` means the token filled up, expired or was deleted.
+///
+/// The check and the write are one statement; on Postgres the token row is locked first so
+/// concurrent sign-ups on the same token queue up instead of all seeing the last free slot.
+pub async fn reserve_registration_token(
+    pool: &DbPool,
+    username: &str,
+    token: &str,
+) -> Result<bool> {
+    let token = token.trim();
+    ensure_new_account_quota(pool, username).await?;
+    let qt = crate::schema::quota_table(pool).await?;
+    let sql = format!(
+        "UPDATE {qt} SET used_token = ?
+         WHERE username = ? AND EXISTS (
+             SELECT 1 FROM registration_tokens t
+             WHERE t.token = ?
+               AND (t.expires_at IS NULL OR t.expires_at > CURRENT_TIMESTAMP)
+               AND t.used_count + (
+                   SELECT COUNT(*) FROM {qt} p
+                   WHERE p.used_token = t.token AND p.first_login_at = 1
+               ) < t.max_uses
+         )"
+    );
+    let affected = match pool {
+        DbPool::Sqlite(p) => sqlx::query(&sql)
+            .bind(token)
+            .bind(username)
+            .bind(token)
+            .execute(p)
+            .await?
+            .rows_affected(),
+        DbPool::Postgres(p) => {
+            let mut tx = p.begin().await?;
+            sqlx::query(&pg_sql(
+                "SELECT token FROM registration_tokens WHERE token = ? FOR UPDATE",
+            ))
+            .bind(token)
+            .execute(&mut *tx)
+            .await?;
+            let affected = sqlx::query(&pg_sql(&sql))
+                .bind(token)
+                .bind(username)
+                .bind(token)
+                .execute(&mut *tx)
+                .await?
+                .rows_affected();
+            tx.commit().await?;
+            affected
+        }
+    };
+    Ok(affected > 0)
 }
 
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub enum FirstLoginOutcome {
+    /// A later login (or an account without a quota row); only `last_login_at` moved.
     Ok,
+    /// This login settled the account's pending first login.
+    FirstLogin,
     AccountRemoved,
 }
 
@@ -169,7 +262,7 @@ pub async fn record_first_login(pool: &DbPool, username: &str) -> Result<FirstLo
     );
     db_execute!(pool, &sql, now, now, username)?;
 
-    Ok(FirstLoginOutcome::Ok)
+    Ok(FirstLoginOutcome::FirstLogin)
 }
 
 async fn consume_registration_token(pool: &DbPool, token: &str) -> Result<bool> {
@@ -244,6 +337,34 @@ mod tests {
         assert_eq!(used_count.0, 0);
     }
 
+    #[tokio::test]
+    async fn reservations_stop_at_max_uses() {
+        let pool = init_memory_db().await.unwrap();
+        seed_token(&pool, "invite-two", 2).await;
+
+        let reserved = tokio::join!(
+            reserve_registration_token(&pool, "a@x.org", "invite-two"),
+            reserve_registration_token(&pool, "b@x.org", "invite-two"),
+            reserve_registration_token(&pool, "c@x.org", "invite-two"),
+        );
+        let granted = [reserved.0, reserved.1, reserved.2]
+            .into_iter()
+            .filter(|r| *r.as_ref().unwrap())
+            .count();
+        assert_eq!(granted, 2);
+        assert_eq!(
+            check_registration_token(&pool, "invite-two").await.unwrap(),
+            Some(TokenRejection::Exhausted)
+        );
+        assert!(!reserve_registration_token(&pool, "d@x.org", "missing")
+            .await
+            .unwrap());
+        assert_eq!(
+            check_registration_token(&pool, "missing").await.unwrap(),
+            Some(TokenRejection::NotFound)
+        );
+    }
+
     #[tokio::test]
     async fn first_login_consumes_token_and_clears_pending() {
         let pool = init_memory_db().await.unwrap();
@@ -252,6 +373,10 @@ mod tests {
             .await
             .unwrap();
 
+        assert_eq!(
+            record_first_login(&pool, "bob@x.org").await.unwrap(),
+            FirstLoginOutcome::FirstLogin
+        );
         assert_eq!(
             record_first_login(&pool, "bob@x.org").await.unwrap(),
             FirstLoginOutcome::Ok
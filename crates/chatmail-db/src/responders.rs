@@ -0,0 +1,313 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Operator-defined automatic responders (`responders` table) and the per-correspondent
+//! reply log (`responder_replies`) that rate-limits them.
+//!
+//! A responder row holds the reply template for one local address and the minimum interval
+//! between two replies to the same correspondent. Reply rows older than their responder's
+//! interval no longer matter and are pruned.
+
+use chatmail_types::{ChatmailError, Result};
+
+use crate::policies::unix_now;
+use crate::pool::pg_sql;
+use crate::{db_execute, db_fetch_all, db_fetch_optional, DbPool};
+
+/// Largest template accepted; replies are short plain-text notes.
+pub const RESPONDER_TEMPLATE_MAX: usize = 64 * 1024;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Responder {
+    pub address: String,
+    pub template: String,
+    /// Minimum seconds between two replies to the same correspondent.
+    pub interval_secs: i64,
+    pub created_at: i64,
+}
+
+type ResponderRow = (String, String, i64, i64);
+
+fn responder_from_row((address, template, interval_secs, created_at): ResponderRow) -> Responder {
+    Responder {
+        address,
+        template,
+        interval_secs,
+        created_at,
+    }
+}
+
+pub(crate) fn normalize_address(raw: &str) -> Result<String> {
+    let address = raw.trim().to_ascii_lowercase();
+    match address.split_once('@') {
+        Some((local, domain)) if !local.is_empty() && !domain.is_empty() => Ok(address),
+        _ => Err(ChatmailError::config(format!(
+            "invalid responder address: {raw:?}"
+        ))),
+    }
+}
+
+pub async fn get_responder(pool: &DbPool, address: &str) -> Result<Option<Responder>> {
+    let address = normalize_address(address)?;
+    let row: Option<ResponderRow> = db_fetch_optional!(
+        pool,
+        ResponderRow,
+        "SELECT address, template, interval_secs, created_at FROM responders WHERE address = ?",
+        address.as_str()
+    )?;
+    Ok(row.map(responder_from_row))
+}
+
+/// Add or replace the responder for `address`. Replacing keeps the reply log, so a template
+/// edit does not re-answer everyone who already got a reply.
+pub async fn set_responder(
+    pool: &DbPool,
+    address: &str,
+    template: &str,
+    interval_secs: i64,
+) -> Result<Responder> {
+    let address = normalize_address(address)?;
+    if template.trim().is_empty() {
+        return Err(ChatmailError::config("responder template is empty"));
+    }
+    if template.len() > RESPONDER_TEMPLATE_MAX {
+        return Err(ChatmailError::config(format!(
+            "responder template exceeds {RESPONDER_TEMPLATE_MAX} bytes"
+        )));
+    }
+    if interval_secs <= 0 {
+        return Err(ChatmailError::config("responder interval must be positive"));
+    }
+    let responder = Responder {
+        address,
+        template: template.to_string(),
+        interval_secs,
+        created_at: unix_now(),
+    };
+    db_execute!(
+        pool,
+        "INSERT INTO responders (address, template, interval_secs, created_at)
+         VALUES (?, ?, ?, ?)
+         ON CONFLICT(address) DO UPDATE SET
+             template = excluded.template, interval_secs = excluded.interval_secs,
+             created_at = excluded.created_at",
+        responder.address.as_str(),
+        responder.template.as_str(),
+        responder.interval_secs,
+        responder.created_at
+    )?;
+    Ok(responder)
+}
+
+/// Delete a responder and its reply log. Returns `false` when there was none.
+pub async fn remove_responder(pool: &DbPool, address: &str) -> Result<bool> {
+    let address = normalize_address(address)?;
+    if get_responder(pool, &address).await?.is_none() {
+        return Ok(false);
+    }
+    db_execute!(
+        pool,
+        "DELETE FROM responders WHERE address = ?",
+        address.as_str()
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM responder_replies WHERE address = ?",
+        address.as_str()
+    )?;
+    Ok(true)
+}
+
+/// All responders ordered by address.
+pub async fn list_responders(pool: &DbPool) -> Result<Vec<Responder>> {
+    let rows: Vec<ResponderRow> = db_fetch_all!(
+        pool,
+        ResponderRow,
+        "SELECT address, template, interval_secs, created_at FROM responders ORDER BY address"
+    )?;
+    Ok(rows.into_iter().map(responder_from_row).collect())
+}
+
+/// When `address` last replied to `correspondent` (Unix seconds), if it ever did.
+pub async fn last_responder_reply(
+    pool: &DbPool,
+    address: &str,
+    correspondent: &str,
+) -> Result<Option<i64>> {
+    let row: Option<(i64,)> = db_fetch_optional!(
+        pool,
+        (i64,),
+        "SELECT sent_at FROM responder_replies WHERE address = ? AND correspondent = ?",
+        address.trim().to_ascii_lowercase(),
+        correspondent.trim().to_ascii_lowercase()
+    )?;
+    Ok(row.map(|(t,)| t))
+}
+
+/// Reserve a reply from `address` to `correspondent` at `now`: returns `false` (and records
+/// nothing) when the previous reply is less than `interval_secs` old. Atomic, so concurrent
+/// deliveries from the same correspondent produce one reply.
+pub async fn claim_responder_reply(
+    pool: &DbPool,
+    address: &str,
+    correspondent: &str,
+    interval_secs: i64,
+    now: i64,
+) -> Result<bool> {
+    const CLAIM: &str = "INSERT INTO responder_replies (address, correspondent, sent_at)
+         VALUES (?, ?, ?)
+         ON CONFLICT(address, correspondent) DO UPDATE SET sent_at = excluded.sent_at
+         WHERE responder_replies.sent_at <= ?";
+    claim_reply(pool, CLAIM, address, correspondent, interval_secs, now).await
+}
+
+/// Run a reply-log `CLAIM` upsert (binds: owner, correspondent, now, due) and report whether
+/// it wrote a row.
+pub(crate) async fn claim_reply(
+    pool: &DbPool,
+    claim: &str,
+    owner: &str,
+    correspondent: &str,
+    interval_secs: i64,
+    now: i64,
+) -> Result<bool> {
+    let owner = owner.trim().to_ascii_lowercase();
+    let correspondent = correspondent.trim().to_ascii_lowercase();
+    let due = now.saturating_sub(interval_secs);
+    let affected = match pool {
+        DbPool::Sqlite(p) => sqlx::query(claim)
+            .bind(&owner)
+            .bind(&correspondent)
+            .bind(now)
+            .bind(due)
+            .execute(p)
+            .await?
+            .rows_affected(),
+        DbPool::Postgres(p) => sqlx::query(&pg_sql(claim))
+            .bind(&owner)
+            .bind(&correspondent)
+            .bind(now)
+            .bind(due)
+            .execute(p)
+            .await?
+            .rows_affected(),
+    };
+    Ok(affected > 0)
+}
+
+/// Drop reply rows older than their responder's interval, and rows whose responder is gone.
+pub async fn prune_responder_replies(pool: &DbPool) -> Result<()> {
+    db_execute!(
+        pool,
+        "DELETE FROM responder_replies WHERE sent_at < ? - COALESCE(
+             (SELECT interval_secs FROM responders r WHERE r.address = responder_replies.address),
+             0)",
+        unix_now()
+    )?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn set_replace_list_remove() {
+        let pool = init_memory_db().await.unwrap();
+        set_responder(&pool, " Help@Example.org ", "first", 3600)
+            .await
+            .unwrap();
+        let r = set_responder(&pool, "help@example.org", "second", 7200)
+            .await
+            .unwrap();
+        assert_eq!(r.address, "help@example.org");
+
+        let all = list_responders(&pool).await.unwrap();
+        assert_eq!(all.len(), 1);
+        assert_eq!(all[0].template, "second");
+        assert_eq!(all[0].interval_secs, 7200);
+
+        assert!(set_responder(&pool, "help", "x", 60).await.is_err());
+        assert!(set_responder(&pool, "a@b", "  ", 60).await.is_err());
+        assert!(set_responder(&pool, "a@b", "x", 0).await.is_err());
+
+        assert!(remove_responder(&pool, "HELP@example.org").await.unwrap());
+        assert!(!remove_responder(&pool, "help@example.org").await.unwrap());
+        assert!(list_responders(&pool).await.unwrap().is_empty());
+    }
+
+    #[tokio::test]
+    async fn claim_honours_interval() {
+        let pool = init_memory_db().await.unwrap();
+        let now = unix_now();
+        assert!(claim_responder_reply(&pool, "help@x", "bob@y", 100, now)
+            .await
+            .unwrap());
+        assert!(
+            !claim_responder_reply(&pool, "help@x", "BOB@y", 100, now + 99)
+                .await
+                .unwrap()
+        );
+        // Other correspondents and other responders are independent.
+        assert!(claim_responder_reply(&pool, "help@x", "carol@y", 100, now)
+            .await
+            .unwrap());
+        assert!(claim_responder_reply(&pool, "info@x", "bob@y", 100, now)
+            .await
+            .unwrap());
+        assert!(
+            claim_responder_reply(&pool, "help@x", "bob@y", 100, now + 100)
+                .await
+                .unwrap()
+        );
+        assert_eq!(
+            last_responder_reply(&pool, "help@x", "bob@y")
+                .await
+                .unwrap(),
+            Some(now + 100)
+        );
+    }
+
+    #[tokio::test]
+    async fn prune_drops_rows_past_their_interval() {
+        let pool = init_memory_db().await.unwrap();
+        set_responder(&pool, "help@x", "hi", 3600).await.unwrap();
+        let now = unix_now();
+        for (who, at) in [("fresh@y", now - 60), ("stale@y", now - 7200)] {
+            claim_responder_reply(&pool, "help@x", who, 3600, at)
+                .await
+                .unwrap();
+        }
+        // No responder any more: its rows go regardless of age.
+        claim_responder_reply(&pool, "gone@x", "bob@y", 3600, now - 60)
+            .await
+            .unwrap();
+
+        prune_responder_replies(&pool).await.unwrap();
+        for (address, correspondent, kept) in [
+            ("help@x", "fresh@y", true),
+            ("help@x", "stale@y", false),
+            ("gone@x", "bob@y", false),
+        ] {
+            let last = last_responder_reply(&pool, address, correspondent)
+                .await
+                .unwrap();
+            assert_eq!(last.is_some(), kept, "{address} -> {correspondent}");
+        }
+    }
+}
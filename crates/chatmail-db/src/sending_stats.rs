@@ -0,0 +1,226 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-account submission counters (`sending_stats`) behind the sending rate limit.
+//!
+//! Every message a user submits (SMTP submission, WebSMTP) adds one to the row of its UTC
+//! hour bucket (`unix_time / 3600`) together with its recipient count. Queue retries never
+//! pass through submission, so a message is counted once however often delivery is
+//! attempted. Rows older than [`SENDING_STATS_KEEP_HOURS`] are pruned.
+
+use chatmail_types::Result;
+
+use crate::{db_execute, db_fetch_all, db_fetch_one, db_fetch_optional, DbPool};
+
+/// Hour buckets kept for `madmail creds rate-limit show` and `/admin/rate-limits`.
+pub const SENDING_STATS_KEEP_HOURS: i64 = 7 * 24;
+
+/// One account's submissions in one UTC hour.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SendingStats {
+    pub username: String,
+    /// UTC hour number (`unix_time / 3600`).
+    pub hour: i64,
+    pub messages: i64,
+    pub recipients: i64,
+}
+
+/// An account's totals over a range of hours, for the top-senders view.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SenderTotal {
+    pub username: String,
+    pub messages: i64,
+    pub recipients: i64,
+}
+
+/// UTC hour number used by `sending_stats`.
+pub fn sending_hour(now: i64) -> i64 {
+    now.div_euclid(3600)
+}
+
+/// Messages `username` submitted during `hour`.
+pub async fn messages_sent_in_hour(pool: &DbPool, username: &str, hour: i64) -> Result<i64> {
+    let row: Option<(i64,)> = db_fetch_optional!(
+        pool,
+        (i64,),
+        "SELECT messages FROM sending_stats WHERE username = ? AND hour = ?",
+        username,
+        hour
+    )?;
+    Ok(row.map_or(0, |(n,)| n))
+}
+
+/// Count one submitted message with `recipients` recipients in `hour`.
+pub async fn record_sent_message(
+    pool: &DbPool,
+    username: &str,
+    hour: i64,
+    recipients: i64,
+) -> Result<()> {
+    db_execute!(
+        pool,
+        "INSERT INTO sending_stats (username, hour, messages, recipients)
+         VALUES (?, ?, 1, ?)
+         ON CONFLICT(username, hour) DO UPDATE SET
+             messages = sending_stats.messages + 1,
+             recipients = sending_stats.recipients + excluded.recipients",
+        username,
+        hour,
+        recipients
+    )?;
+    Ok(())
+}
+
+/// `username`'s hour buckets from `since_hour` on, newest first.
+pub async fn sending_stats_for(
+    pool: &DbPool,
+    username: &str,
+    since_hour: i64,
+) -> Result<Vec<SendingStats>> {
+    let rows: Vec<(String, i64, i64, i64)> = db_fetch_all!(
+        pool,
+        (String, i64, i64, i64),
+        "SELECT username, hour, messages, recipients FROM sending_stats
+         WHERE username = ? AND hour >= ? ORDER BY hour DESC",
+        username,
+        since_hour
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(|(username, hour, messages, recipients)| SendingStats {
+            username,
+            hour,
+            messages,
+            recipients,
+        })
+        .collect())
+}
+
+/// Accounts with the most messages since `since_hour`, busiest first.
+pub async fn top_senders(pool: &DbPool, since_hour: i64, limit: i64) -> Result<Vec<SenderTotal>> {
+    let rows: Vec<(String, i64, i64)> = db_fetch_all!(
+        pool,
+        (String, i64, i64),
+        "SELECT username, CAST(SUM(messages) AS BIGINT), CAST(SUM(recipients) AS BIGINT)
+         FROM sending_stats WHERE hour >= ?
+         GROUP BY username
+         ORDER BY SUM(messages) DESC, username
+         LIMIT ?",
+        since_hour,
+        limit
+    )?;
+    Ok(rows
+        .into_iter()
+        .map(|(username, messages, recipients)| SenderTotal {
+            username,
+            messages,
+            recipients,
+        })
+        .collect())
+}
+
+/// Forget every counter of `username`; returns the number of hour rows removed.
+pub async fn reset_sending_stats(pool: &DbPool, username: &str) -> Result<i64> {
+    let (rows,): (i64,) = db_fetch_one!(
+        pool,
+        (i64,),
+        "SELECT COUNT(*) FROM sending_stats WHERE username = ?",
+        username
+    )?;
+    db_execute!(
+        pool,
+        "DELETE FROM sending_stats WHERE username = ?",
+        username
+    )?;
+    Ok(rows)
+}
+
+/// Drop counters for hours before `hour`.
+pub async fn prune_sending_stats(pool: &DbPool, hour: i64) -> Result<()> {
+    db_execute!(pool, "DELETE FROM sending_stats WHERE hour < ?", hour)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::init_memory_db;
+
+    #[tokio::test]
+    async fn counts_per_hour_and_ranks_senders() {
+        let pool = init_memory_db().await.unwrap();
+        let hour = sending_hour(1_800_000_000);
+        for _ in 0..3 {
+            record_sent_message(&pool, "a@example.org", hour, 2)
+                .await
+                .unwrap();
+        }
+        record_sent_message(&pool, "a@example.org", hour - 1, 1)
+            .await
+            .unwrap();
+        record_sent_message(&pool, "b@example.org", hour, 40)
+            .await
+            .unwrap();
+
+        assert_eq!(
+            messages_sent_in_hour(&pool, "a@example.org", hour)
+                .await
+                .unwrap(),
+            3
+        );
+        assert_eq!(
+            messages_sent_in_hour(&pool, "a@example.org", hour + 1)
+                .await
+                .unwrap(),
+            0
+        );
+
+        let rows = sending_stats_for(&pool, "a@example.org", hour - 1)
+            .await
+            .unwrap();
+        assert_eq!(rows.len(), 2);
+        assert_eq!(
+            (rows[0].hour, rows[0].messages, rows[0].recipients),
+            (hour, 3, 6)
+        );
+
+        let top = top_senders(&pool, hour - 1, 10).await.unwrap();
+        assert_eq!(top[0].username, "a@example.org");
+        assert_eq!((top[0].messages, top[0].recipients), (4, 7));
+        assert_eq!(top[1].username, "b@example.org");
+        assert_eq!(top_senders(&pool, hour - 1, 1).await.unwrap().len(), 1);
+
+        prune_sending_stats(&pool, hour).await.unwrap();
+        assert_eq!(
+            sending_stats_for(&pool, "a@example.org", 0)
+                .await
+                .unwrap()
+                .len(),
+            1
+        );
+        assert_eq!(
+            reset_sending_stats(&pool, "a@example.org").await.unwrap(),
+            1
+        );
+        assert_eq!(
+            messages_sent_in_hour(&pool, "a@example.org", hour)
+                .await
+                .unwrap(),
+            0
+        );
+    }
+}
@@ -255,6 +255,14 @@ pub async fn get_enabled_setting(pool: &DbPool, key: &str, default_enabled: bool
     }
 }
 
+/// `__MAX_ACCOUNTS__` cap on registered accounts; `None` when unset, `0` or unparsable.
+pub async fn max_accounts(pool: &DbPool) -> Result<Option<u64>> {
+    Ok(get_setting(pool, crate::settings_keys::MAX_ACCOUNTS)
+        .await?
+        .and_then(|v| v.trim().parse::<u64>().ok())
+        .filter(|n| *n > 0))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -91,6 +91,8 @@ pub const TURN_TTL: &str = "__TURN_TTL__";
 pub const IROH_RELAY_URL: &str = "__IROH_RELAY_URL__";
 pub const SS_CIPHER: &str = "__SS_CIPHER__";
 pub const SS_PASSWORD: &str = "__SS_PASSWORD__";
+/// JSON list of rotated-out Shadowsocks passwords still accepted (`POST /admin/ss/rotate`).
+pub const SS_PASSWORD_SLOTS: &str = "__SS_PASSWORD_SLOTS__";
 pub const HTTP_PROXY_PATH: &str = "__HTTP_PROXY_PATH__";
 pub const HTTP_PROXY_USERNAME: &str = "__HTTP_PROXY_USERNAME__";
 pub const HTTP_PROXY_PASSWORD: &str = "__HTTP_PROXY_PASSWORD__";
@@ -105,6 +107,15 @@ pub const APPENDLIMIT: &str = "__APPENDLIMIT__";
 pub const MAX_MESSAGE_SIZE: &str = "__MAX_MESSAGE_SIZE__";
 /// `/mxdeliv` federation HTTP body cap override (e.g. `70M`).
 pub const MAX_FEDERATION_SIZE: &str = "__MAX_FEDERATION_SIZE__";
+/// Registered-account cap; registration answers "server full" at the limit.
+pub const MAX_ACCOUNTS: &str = "__MAX_ACCOUNTS__";
+/// `POST /new` allowance per client subnet override (`10/1h`, or a bare per-second rate).
+pub const REGISTRATION_RATE: &str = "__REGISTRATION_RATE__";
+/// `proof_of_work_bits` override; `0` turns `/new` challenges off.
+pub const PROOF_OF_WORK_BITS: &str = "__PROOF_OF_WORK_BITS__";
+/// DKIM selector used for outbound signing instead of the config's `dkim … <selector>` lines
+/// (`POST /admin/dkim/activate`).
+pub const DKIM_SELECTOR: &str = "__DKIM_SELECTOR__";
 
 /// Pseudo-username row in `quotas` for server-wide default cap.
 pub const GLOBAL_QUOTA_USERNAME: &str = "__GLOBAL_DEFAULT__";
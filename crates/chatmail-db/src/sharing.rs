@@ -18,6 +18,7 @@
 //! Delta Chat contact sharing (`sharing.db` / `contacts` table, Madmail `mdb.Contact`).
 
 use std::path::Path;
+use std::time::Duration;
 
 use chatmail_types::{ChatmailError, Result};
 use sqlx::sqlite::{SqliteConnectOptions, SqlitePool, SqlitePoolOptions};
@@ -27,8 +28,21 @@ use std::str::FromStr;
 pub struct SharingContact {
     pub slug: String,
     pub url: String,
+    /// Personal name for contact invites, group name for group invites.
     pub name: String,
     pub created_at: String,
+    /// [`InviteKind::as_str`] — `contact` or `group`.
+    pub kind: String,
+    /// Free-form expected member count (group invites only), e.g. `~50 members`.
+    pub member_note: String,
+    /// Group description (group invites only).
+    pub description: String,
+    /// Slug page renders counted by the view flusher (`sharing_analytics`).
+    pub views: i64,
+    /// UTC day (`YYYY-MM-DD`) of the latest counted view; empty when never viewed.
+    pub last_viewed: String,
+    /// UTC `YYYY-MM-DD HH:MM:SS` after which the link stops resolving; `None` never expires.
+    pub expires_at: Option<String>,
 }
 
 const CONTACTS_DDL: &str = r"
@@ -36,10 +50,75 @@ CREATE TABLE IF NOT EXISTS contacts (
     slug TEXT PRIMARY KEY NOT NULL,
     url TEXT NOT NULL,
     name TEXT NOT NULL DEFAULT '',
-    created_at TEXT NOT NULL DEFAULT (datetime('now'))
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    kind TEXT NOT NULL DEFAULT 'contact',
+    member_note TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    views INTEGER NOT NULL DEFAULT 0,
+    last_viewed TEXT NOT NULL DEFAULT '',
+    expires_at TEXT
 );
 ";
 
+/// Columns added after the first `sharing.db` release; appended to older files on open.
+const CONTACTS_ADDED_COLUMNS: &[(&str, &str)] = &[
+    ("kind", "TEXT NOT NULL DEFAULT 'contact'"),
+    ("member_note", "TEXT NOT NULL DEFAULT ''"),
+    ("description", "TEXT NOT NULL DEFAULT ''"),
+    ("views", "INTEGER NOT NULL DEFAULT 0"),
+    ("last_viewed", "TEXT NOT NULL DEFAULT ''"),
+    ("expires_at", "TEXT"),
+];
+
+const CONTACT_COLUMNS: &str = "slug, url, name, created_at, kind, member_note, description, \
+                               views, last_viewed, expires_at";
+
+/// SQL condition for links that have not expired.
+const NOT_EXPIRED: &str = "(expires_at IS NULL OR expires_at > datetime('now'))";
+
+/// Longest lifetime a sharing link may be given (ten years).
+pub const MAX_SHARING_TTL: Duration = Duration::from_secs(3650 * 86_400);
+/// Longest accepted group member-count note (characters).
+pub const MAX_MEMBER_NOTE_CHARS: usize = 64;
+/// Longest accepted group description (characters).
+pub const MAX_GROUP_DESCRIPTION_CHARS: usize = 500;
+/// Longest accepted Delta Chat group id (`x=` parameter).
+const MAX_GROUP_ID_CHARS: usize = 64;
+/// Hex digits in an OpenPGP v4 fingerprint.
+const FINGERPRINT_HEX_CHARS: usize = 40;
+/// Longest accepted invite address (`a=`, decoded; RFC 5321 path limit).
+const MAX_INVITE_ADDR_CHARS: usize = 254;
+/// Longest accepted contact or group name (`n=` / `g=`, decoded characters).
+const MAX_INVITE_NAME_CHARS: usize = 256;
+/// Longest accepted invite number or auth token (`i=` / `s=`).
+const MAX_INVITE_TOKEN_CHARS: usize = 64;
+
+const WEB_INVITE_PREFIX: &str = "https://i.delta.chat/#";
+const OPENPGP4FPR: &str = "openpgp4fpr:";
+
+/// What a shared Delta Chat invite joins: a 1:1 chat or a group.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum InviteKind {
+    Contact,
+    Group,
+}
+
+impl InviteKind {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Contact => "contact",
+            Self::Group => "group",
+        }
+    }
+}
+
+/// Optional group page fields from the `/share` form.
+#[derive(Debug, Clone, Default)]
+pub struct GroupInviteFields {
+    pub member_note: String,
+    pub description: String,
+}
+
 /// Open or create the sharing SQLite database (default: `{state_dir}/sharing.db`).
 pub async fn init_sharing_db(path: &Path) -> Result<SqlitePool> {
     if let Some(parent) = path.parent() {
@@ -54,9 +133,24 @@ pub async fn init_sharing_db(path: &Path) -> Result<SqlitePool> {
         .connect_with(options)
         .await?;
     sqlx::query(CONTACTS_DDL).execute(&pool).await?;
+    add_missing_columns(&pool).await?;
     Ok(pool)
 }
 
+async fn add_missing_columns(pool: &SqlitePool) -> Result<()> {
+    let existing: Vec<(String,)> = sqlx::query_as("SELECT name FROM pragma_table_info('contacts')")
+        .fetch_all(pool)
+        .await?;
+    for (column, decl) in CONTACTS_ADDED_COLUMNS {
+        if !existing.iter().any(|(name,)| name == column) {
+            sqlx::query(&format!("ALTER TABLE contacts ADD COLUMN {column} {decl}"))
+                .execute(pool)
+                .await?;
+        }
+    }
+    Ok(())
+}
+
 pub fn validate_slug(slug: &str) -> Result<()> {
     if slug.is_empty() {
         return Err(ChatmailError::config("SLUG is required"));
@@ -69,70 +163,552 @@ pub fn validate_slug(slug: &str) -> Result<()> {
     Ok(())
 }
 
-/// Convert Delta Chat web invite to `openpgp4fpr:` (Madmail `sharingCreateInternal`).
+/// Slugs that must not be used for contact pages because the web endpoint serves them
+/// itself (Madmail Go reserved list). Admin `sharing_reserved` policies come on top.
+pub fn is_reserved_slug(slug: &str) -> bool {
+    matches!(
+        slug,
+        "share"
+            | "qr"
+            | "new"
+            | "madmail"
+            | "mxdeliv"
+            | "main.css"
+            | "index.html"
+            | "info.html"
+            | "security.html"
+            | "deploy.html"
+            | "app"
+            | "docs"
+            | "webimap"
+            | "websmtp"
+            | "inv"
+    )
+}
+
+/// Parse a link lifetime such as `24h` or `7d`; empty input means the link never expires.
+pub fn parse_sharing_ttl(raw: &str) -> Result<Option<Duration>> {
+    let raw = raw.trim();
+    if raw.is_empty() {
+        return Ok(None);
+    }
+    match chatmail_config::parse_duration(raw) {
+        Ok(ttl) if ttl <= MAX_SHARING_TTL => Ok(Some(ttl)),
+        _ => Err(ChatmailError::config(
+            "expiry must be a duration such as 24h or 7d (at most 3650d)",
+        )),
+    }
+}
+
+/// Parsed Delta Chat invite (`https://i.delta.chat/#FPR&…` or `openpgp4fpr:FPR#…`).
+///
+/// Values are percent-decoded; [`InviteUrl::to_openpgp4fpr`] re-encodes them canonically.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct InviteUrl {
+    /// Uppercase hex fingerprint.
+    pub fingerprint: String,
+    /// `a=`: address of the inviting account.
+    pub addr: String,
+    /// `n=`: display name of the inviting account.
+    pub name: Option<String>,
+    /// `g=` and `x=`: group name and group id (group invites only).
+    pub group: Option<(String, String)>,
+    /// `i=`: invite number.
+    pub invite_number: String,
+    /// `s=`: auth token.
+    pub auth: String,
+}
+
+impl InviteUrl {
+    pub fn kind(&self) -> InviteKind {
+        if self.group.is_some() {
+            InviteKind::Group
+        } else {
+            InviteKind::Contact
+        }
+    }
+
+    /// `openpgp4fpr:FPR#a=…&n=…&g=…&x=…&i=…&s=…` with absent parameters left out.
+    pub fn to_openpgp4fpr(&self) -> String {
+        let mut params = vec![("a", self.addr.as_str())];
+        if let Some(name) = &self.name {
+            params.push(("n", name));
+        }
+        if let Some((name, id)) = &self.group {
+            params.push(("g", name));
+            params.push(("x", id));
+        }
+        params.push(("i", &self.invite_number));
+        params.push(("s", &self.auth));
+        let query: Vec<String> = params
+            .into_iter()
+            .map(|(k, v)| format!("{k}={}", percent_encode(v)))
+            .collect();
+        format!("{OPENPGP4FPR}{}#{}", self.fingerprint, query.join("&"))
+    }
+}
+
+/// Validate a Delta Chat invite. Each failure has its own message, so the `/share` form and
+/// `madmail sharing create` can tell the user what is wrong with a pasted link.
+pub fn parse_invite_url(raw: &str) -> Result<InviteUrl> {
+    let raw = raw.trim();
+    let (fingerprint, params) = if let Some(rest) = raw.strip_prefix(WEB_INVITE_PREFIX) {
+        rest.split_once('&').unwrap_or((rest, ""))
+    } else if raw
+        .get(..OPENPGP4FPR.len())
+        .is_some_and(|scheme| scheme.eq_ignore_ascii_case(OPENPGP4FPR))
+    {
+        let rest = &raw[OPENPGP4FPR.len()..];
+        rest.split_once('#').unwrap_or((rest, ""))
+    } else {
+        return Err(ChatmailError::config(
+            "URL must be DeltaChat web link (https://i.delta.chat/#...) or openpgp4fpr: link",
+        ));
+    };
+    if fingerprint.len() != FINGERPRINT_HEX_CHARS
+        || !fingerprint.chars().all(|c| c.is_ascii_hexdigit())
+    {
+        return Err(ChatmailError::config(format!(
+            "invite fingerprint must be {FINGERPRINT_HEX_CHARS} hex characters"
+        )));
+    }
+
+    let mut values: [Option<String>; 6] = Default::default();
+    const KEYS: [&str; 6] = ["a", "n", "g", "x", "i", "s"];
+    for pair in params.split('&').filter(|p| !p.is_empty()) {
+        let Some((key, value)) = pair.split_once('=') else {
+            return Err(ChatmailError::config(format!(
+                "invite parameter {pair:?} has no value"
+            )));
+        };
+        let Some(slot) = KEYS.iter().position(|k| *k == key) else {
+            return Err(ChatmailError::config(format!(
+                "invite parameter {key:?} is not allowed (expected a, n, i, s, g or x)"
+            )));
+        };
+        if values[slot].is_some() {
+            return Err(ChatmailError::config(format!(
+                "invite parameter {key}= appears more than once"
+            )));
+        }
+        // Delta Chat writes spaces in names as `+`.
+        let value = if key == "n" || key == "g" {
+            value.replace('+', " ")
+        } else {
+            value.to_string()
+        };
+        let decoded = percent_decode(&value).ok_or_else(|| {
+            ChatmailError::config(format!(
+                "invite parameter {key}= is not valid percent-encoding"
+            ))
+        })?;
+        let limit = match key {
+            "a" => MAX_INVITE_ADDR_CHARS,
+            "n" | "g" => MAX_INVITE_NAME_CHARS,
+            "x" => MAX_GROUP_ID_CHARS,
+            _ => MAX_INVITE_TOKEN_CHARS,
+        };
+        if decoded.chars().count() > limit {
+            return Err(ChatmailError::config(format!(
+                "invite parameter {key}= is longer than {limit} characters"
+            )));
+        }
+        if decoded.chars().any(char::is_control) {
+            return Err(ChatmailError::config(format!(
+                "invite parameter {key}= contains control characters"
+            )));
+        }
+        values[slot] = Some(decoded);
+    }
+    let [addr, name, group_name, group_id, invite_number, auth] = values;
+
+    let addr = addr
+        .filter(|a| !a.is_empty())
+        .ok_or_else(|| ChatmailError::config("invite is missing the inviter address (a=)"))?;
+    match addr.split_once('@') {
+        Some((local, domain)) if !local.is_empty() && !domain.is_empty() => {}
+        _ => {
+            return Err(ChatmailError::config(
+                "invite address (a=) is not an email address",
+            ))
+        }
+    }
+    let token = |value: Option<String>, what: &str| -> Result<String> {
+        let value = value
+            .filter(|v| !v.is_empty())
+            .ok_or_else(|| ChatmailError::config(format!("invite is missing its {what}")))?;
+        if !value
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_')
+        {
+            return Err(ChatmailError::config(format!(
+                "invite {what} may only contain letters, digits, - and _"
+            )));
+        }
+        Ok(value)
+    };
+    let invite_number = token(invite_number, "invite number (i=)")?;
+    let auth = token(auth, "auth token (s=)")?;
+    let group = match (group_name, group_id) {
+        (None, None) => None,
+        (Some(name), Some(id)) => {
+            if name.trim().is_empty() {
+                return Err(ChatmailError::config(
+                    "group invite has an empty group name",
+                ));
+            }
+            Some((name, token(Some(id), "group id (x=)")?))
+        }
+        _ => {
+            return Err(ChatmailError::config(
+                "group invite must carry both a group name and a group id",
+            ))
+        }
+    };
+
+    Ok(InviteUrl {
+        fingerprint: fingerprint.to_ascii_uppercase(),
+        addr,
+        name,
+        group,
+        invite_number,
+        auth,
+    })
+}
+
+/// Canonical `openpgp4fpr:` form of a pasted invite ([`parse_invite_url`]); `reserved` passes
+/// through (Madmail `sharingCreateInternal`).
 pub fn normalize_sharing_url(raw: &str) -> Result<String> {
     let raw = raw.trim();
-    if raw == "reserved" || raw.starts_with("openpgp4fpr:") {
+    if raw == "reserved" {
         return Ok(raw.to_string());
     }
-    const PREFIX: &str = "https://i.delta.chat/#";
-    if let Some(content) = raw.strip_prefix(PREFIX) {
-        if let Some(idx) = content.find('&') {
-            return Ok(format!(
-                "openpgp4fpr:{}#{}",
-                &content[..idx],
-                &content[idx + 1..]
+    Ok(parse_invite_url(raw)?.to_openpgp4fpr())
+}
+
+/// Strict `%XX` decoding: a stray `%` or a non-UTF-8 result is an error.
+fn percent_decode(raw: &str) -> Option<String> {
+    let bytes = raw.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] == b'%' {
+            let hex = raw
+                .get(i + 1..i + 3)
+                .filter(|h| h.bytes().all(|b| b.is_ascii_hexdigit()))?;
+            out.push(u8::from_str_radix(hex, 16).ok()?);
+            i += 3;
+        } else {
+            out.push(bytes[i]);
+            i += 1;
+        }
+    }
+    String::from_utf8(out).ok()
+}
+
+/// Percent-encode everything but RFC 3986 unreserved characters.
+fn percent_encode(value: &str) -> String {
+    let mut out = String::with_capacity(value.len());
+    for b in value.bytes() {
+        if b.is_ascii_alphanumeric() || matches!(b, b'-' | b'.' | b'_' | b'~') {
+            out.push(b as char);
+        } else {
+            out.push_str(&format!("%{b:02X}"));
+        }
+    }
+    out
+}
+
+/// Contact vs group invite from a normalized `openpgp4fpr:` URL.
+///
+/// Delta Chat group invites carry the group name (`g=`) and group id (`x=`) after the
+/// fingerprint; contact invites carry neither. One without the other, an empty value or a
+/// group id outside `[A-Za-z0-9_-]` is rejected rather than shown as a contact page.
+pub fn invite_kind(url: &str) -> Result<InviteKind> {
+    let Some((_, params)) = url.split_once('#') else {
+        return Ok(InviteKind::Contact);
+    };
+    let mut group_name = None;
+    let mut group_id = None;
+    for pair in params.split('&') {
+        let (key, value) = pair.split_once('=').unwrap_or((pair, ""));
+        match key {
+            "g" => group_name = Some(value),
+            "x" => group_id = Some(value),
+            _ => {}
+        }
+    }
+    match (group_name, group_id) {
+        (None, None) => Ok(InviteKind::Contact),
+        (Some(name), Some(id)) => {
+            if name.is_empty() {
+                return Err(ChatmailError::config(
+                    "group invite has an empty group name",
+                ));
+            }
+            if id.is_empty()
+                || id.len() > MAX_GROUP_ID_CHARS
+                || !id
+                    .chars()
+                    .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_')
+            {
+                return Err(ChatmailError::config(
+                    "group invite has an invalid group id",
+                ));
+            }
+            Ok(InviteKind::Group)
+        }
+        _ => Err(ChatmailError::config(
+            "group invite must carry both a group name and a group id",
+        )),
+    }
+}
+
+/// Account address (`a=`) an invite URL belongs to, percent-decoded and lowercased.
+pub fn invite_address(url: &str) -> Option<String> {
+    let (_, params) = url.split_once('#')?;
+    let raw = params
+        .split('&')
+        .find_map(|pair| pair.strip_prefix("a="))
+        .filter(|v| !v.is_empty())?;
+    let bytes = raw.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] == b'%' {
+            if let Some(b) = raw
+                .get(i + 1..i + 3)
+                .and_then(|hex| u8::from_str_radix(hex, 16).ok())
+            {
+                out.push(b);
+                i += 3;
+                continue;
+            }
+        }
+        out.push(bytes[i]);
+        i += 1;
+    }
+    String::from_utf8(out).ok().map(|a| a.to_ascii_lowercase())
+}
+
+/// Length and character limits for the group page fields. Contact invites take neither.
+pub fn validate_group_fields(kind: InviteKind, fields: &GroupInviteFields) -> Result<()> {
+    if kind == InviteKind::Contact {
+        if !fields.member_note.is_empty() || !fields.description.is_empty() {
+            return Err(ChatmailError::config(
+                "member count and description are only for group invites",
             ));
         }
-        return Ok(format!("openpgp4fpr:{content}"));
+        return Ok(());
     }
-    Err(ChatmailError::config(
-        "URL must be DeltaChat web link (https://i.delta.chat/#...) or openpgp4fpr: link",
-    ))
+    if fields.member_note.chars().count() > MAX_MEMBER_NOTE_CHARS {
+        return Err(ChatmailError::config(format!(
+            "member count note must be at most {MAX_MEMBER_NOTE_CHARS} characters"
+        )));
+    }
+    if fields.member_note.chars().any(char::is_control) {
+        return Err(ChatmailError::config(
+            "member count note must be a single line",
+        ));
+    }
+    if fields.description.chars().count() > MAX_GROUP_DESCRIPTION_CHARS {
+        return Err(ChatmailError::config(format!(
+            "group description must be at most {MAX_GROUP_DESCRIPTION_CHARS} characters"
+        )));
+    }
+    if fields
+        .description
+        .chars()
+        .any(|c| c.is_control() && c != '\n' && c != '\r')
+    {
+        return Err(ChatmailError::config(
+            "group description contains control characters",
+        ));
+    }
+    Ok(())
 }
 
 pub async fn get_sharing_contact(pool: &SqlitePool, slug: &str) -> Result<Option<SharingContact>> {
-    let row = sqlx::query_as::<_, SharingContact>(
-        "SELECT slug, url, name, created_at FROM contacts WHERE slug = ?",
-    )
+    let row = sqlx::query_as::<_, SharingContact>(&format!(
+        "SELECT {CONTACT_COLUMNS} FROM contacts WHERE slug = ?"
+    ))
+    .bind(slug)
+    .fetch_optional(pool)
+    .await?;
+    Ok(row)
+}
+
+/// Like [`get_sharing_contact`], but an expired link is `None`.
+pub async fn get_live_sharing_contact(
+    pool: &SqlitePool,
+    slug: &str,
+) -> Result<Option<SharingContact>> {
+    let row = sqlx::query_as::<_, SharingContact>(&format!(
+        "SELECT {CONTACT_COLUMNS} FROM contacts WHERE slug = ? AND {NOT_EXPIRED}"
+    ))
     .bind(slug)
     .fetch_optional(pool)
     .await?;
     Ok(row)
 }
 
+/// Whether `slug` is taken; an expired link frees its slug before it is pruned.
 pub async fn sharing_slug_exists(pool: &SqlitePool, slug: &str) -> Result<bool> {
-    let row: Option<(i32,)> = sqlx::query_as("SELECT 1 FROM contacts WHERE slug = ? LIMIT 1")
-        .bind(slug)
-        .fetch_optional(pool)
-        .await?;
+    let row: Option<(i32,)> = sqlx::query_as(&format!(
+        "SELECT 1 FROM contacts WHERE slug = ? AND {NOT_EXPIRED} LIMIT 1"
+    ))
+    .bind(slug)
+    .fetch_optional(pool)
+    .await?;
     Ok(row.is_some())
 }
 
-pub async fn list_sharing_contacts(pool: &SqlitePool) -> Result<Vec<SharingContact>> {
-    let rows = sqlx::query_as::<_, SharingContact>(
-        "SELECT slug, url, name, created_at FROM contacts ORDER BY created_at DESC",
+/// Delete links past their `expires_at`; returns how many were removed.
+pub async fn prune_expired_sharing_contacts(pool: &SqlitePool) -> Result<u64> {
+    let result = sqlx::query(
+        "DELETE FROM contacts WHERE expires_at IS NOT NULL AND expires_at <= datetime('now')",
     )
+    .execute(pool)
+    .await?;
+    Ok(result.rows_affected())
+}
+
+pub async fn list_sharing_contacts(pool: &SqlitePool) -> Result<Vec<SharingContact>> {
+    let rows = sqlx::query_as::<_, SharingContact>(&format!(
+        "SELECT {CONTACT_COLUMNS} FROM contacts ORDER BY created_at DESC"
+    ))
     .fetch_all(pool)
     .await?;
     Ok(rows)
 }
 
+/// One page of links, newest first, and the total number of links.
+pub async fn list_sharing_contacts_page(
+    pool: &SqlitePool,
+    offset: i64,
+    limit: i64,
+) -> Result<(Vec<SharingContact>, i64)> {
+    let rows = sqlx::query_as::<_, SharingContact>(&format!(
+        "SELECT {CONTACT_COLUMNS} FROM contacts ORDER BY created_at DESC, slug LIMIT ? OFFSET ?"
+    ))
+    .bind(limit)
+    .bind(offset)
+    .fetch_all(pool)
+    .await?;
+    let (total,): (i64,) = sqlx::query_as("SELECT COUNT(*) FROM contacts")
+        .fetch_one(pool)
+        .await?;
+    Ok((rows, total))
+}
+
+/// Links whose invite belongs to `address` ([`invite_address`]), newest first.
+pub async fn list_sharing_contacts_for(
+    pool: &SqlitePool,
+    address: &str,
+) -> Result<Vec<SharingContact>> {
+    let address = address.to_ascii_lowercase();
+    Ok(list_sharing_contacts(pool)
+        .await?
+        .into_iter()
+        .filter(|c| invite_address(&c.url).as_deref() == Some(address.as_str()))
+        .collect())
+}
+
+/// Remove every link whose invite belongs to `address` (account deletion); returns how
+/// many went.
+pub async fn remove_sharing_contacts_for(pool: &SqlitePool, address: &str) -> Result<u64> {
+    let owned = list_sharing_contacts_for(pool, address).await?;
+    let mut tx = pool.begin().await?;
+    let mut removed = 0;
+    for contact in &owned {
+        removed += sqlx::query("DELETE FROM contacts WHERE slug = ?")
+            .bind(&contact.slug)
+            .execute(&mut *tx)
+            .await?
+            .rows_affected();
+    }
+    tx.commit().await?;
+    Ok(removed)
+}
+
+/// One batched view-counter update: `(slug, views to add, unix day of the latest view)`.
+pub type SharingViewBatch = [(String, i64, i64)];
+
+/// Add debounced view counts in one transaction; `last_viewed` only moves forward.
+/// Slugs removed since the views were counted are skipped.
+pub async fn add_sharing_views(pool: &SqlitePool, batch: &SharingViewBatch) -> Result<()> {
+    let mut tx = pool.begin().await?;
+    for (slug, views, day) in batch {
+        sqlx::query(
+            "UPDATE contacts SET views = views + ?, \
+             last_viewed = max(last_viewed, date(? * 86400, 'unixepoch')) WHERE slug = ?",
+        )
+        .bind(views)
+        .bind(day)
+        .bind(slug)
+        .execute(&mut *tx)
+        .await?;
+    }
+    tx.commit().await?;
+    Ok(())
+}
+
 pub async fn create_sharing_contact(
     pool: &SqlitePool,
     slug: &str,
     raw_url: &str,
     name: &str,
 ) -> Result<()> {
+    create_sharing_invite(
+        pool,
+        slug,
+        raw_url,
+        name,
+        &GroupInviteFields::default(),
+        None,
+    )
+    .await
+    .map(|_| ())
+}
+
+/// Store a contact or group invite; the kind comes from the URL ([`invite_kind`]). With
+/// `ttl` the link expires that long from now. An expired link holding `slug` is replaced.
+pub async fn create_sharing_invite(
+    pool: &SqlitePool,
+    slug: &str,
+    raw_url: &str,
+    name: &str,
+    group: &GroupInviteFields,
+    ttl: Option<Duration>,
+) -> Result<InviteKind> {
     validate_slug(slug)?;
     let url = normalize_sharing_url(raw_url)?;
-    sqlx::query("INSERT INTO contacts (slug, url, name) VALUES (?, ?, ?)")
-        .bind(slug)
-        .bind(&url)
-        .bind(name)
-        .execute(pool)
-        .await?;
-    Ok(())
+    let kind = invite_kind(&url)?;
+    validate_group_fields(kind, group)?;
+    let ttl_secs = ttl.map(|t| i64::try_from(t.as_secs()).unwrap_or(i64::MAX));
+    let mut tx = pool.begin().await?;
+    sqlx::query(&format!(
+        "DELETE FROM contacts WHERE slug = ? AND NOT {NOT_EXPIRED}"
+    ))
+    .bind(slug)
+    .execute(&mut *tx)
+    .await?;
+    // `datetime('now', NULL)` is NULL: no TTL, no expiry.
+    sqlx::query(
+        "INSERT INTO contacts (slug, url, name, kind, member_note, description, expires_at) \
+         VALUES (?, ?, ?, ?, ?, ?, datetime('now', '+' || ? || ' seconds'))",
+    )
+    .bind(slug)
+    .bind(&url)
+    .bind(name)
+    .bind(kind.as_str())
+    .bind(&group.member_note)
+    .bind(&group.description)
+    .bind(ttl_secs)
+    .execute(&mut *tx)
+    .await?;
+    tx.commit().await?;
+    Ok(kind)
 }
 
 pub async fn remove_sharing_contact(pool: &SqlitePool, slug: &str) -> Result<bool> {
@@ -150,16 +726,19 @@ pub async fn update_sharing_contact(
     name: Option<&str>,
 ) -> Result<bool> {
     let url = normalize_sharing_url(raw_url)?;
+    let kind = invite_kind(&url)?.as_str();
     let result = if let Some(n) = name {
-        sqlx::query("UPDATE contacts SET url = ?, name = ? WHERE slug = ?")
+        sqlx::query("UPDATE contacts SET url = ?, kind = ?, name = ? WHERE slug = ?")
             .bind(&url)
+            .bind(kind)
             .bind(n)
             .bind(slug)
             .execute(pool)
             .await?
     } else {
-        sqlx::query("UPDATE contacts SET url = ? WHERE slug = ?")
+        sqlx::query("UPDATE contacts SET url = ?, kind = ? WHERE slug = ?")
             .bind(&url)
+            .bind(kind)
             .bind(slug)
             .execute(pool)
             .await?
@@ -167,14 +746,360 @@ pub async fn update_sharing_contact(
     Ok(result.rows_affected() > 0)
 }
 
+/// Set the display name of `slug`; `false` when there is no such link.
+pub async fn set_sharing_contact_name(pool: &SqlitePool, slug: &str, name: &str) -> Result<bool> {
+    let result = sqlx::query("UPDATE contacts SET name = ? WHERE slug = ?")
+        .bind(name)
+        .bind(slug)
+        .execute(pool)
+        .await?;
+    Ok(result.rows_affected() > 0)
+}
+
+/// Move the link at `old` to `new`, keeping its invite, name and counters; `false` when
+/// `old` does not exist. `new` must be a valid, unreserved slug no live link holds.
+pub async fn rename_sharing_contact(pool: &SqlitePool, old: &str, new: &str) -> Result<bool> {
+    validate_slug(new)?;
+    if is_reserved_slug(new) {
+        return Err(ChatmailError::config(format!("slug {new} is reserved")));
+    }
+    if old == new {
+        return Ok(get_sharing_contact(pool, old).await?.is_some());
+    }
+    let mut tx = pool.begin().await?;
+    let taken: Option<(i32,)> = sqlx::query_as(&format!(
+        "SELECT 1 FROM contacts WHERE slug = ? AND {NOT_EXPIRED} LIMIT 1"
+    ))
+    .bind(new)
+    .fetch_optional(&mut *tx)
+    .await?;
+    if taken.is_some() {
+        return Err(ChatmailError::config(format!(
+            "slug {new} is already taken"
+        )));
+    }
+    sqlx::query("DELETE FROM contacts WHERE slug = ?")
+        .bind(new)
+        .execute(&mut *tx)
+        .await?;
+    let result = sqlx::query("UPDATE contacts SET slug = ? WHERE slug = ?")
+        .bind(new)
+        .bind(old)
+        .execute(&mut *tx)
+        .await?;
+    tx.commit().await?;
+    Ok(result.rows_affected() > 0)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    const FPR: &str = "0123456789ABCDEF0123456789ABCDEF01234567";
+
     #[test]
     fn normalize_delta_web_link() {
-        let u = normalize_sharing_url("https://i.delta.chat/#ABCDEF&x=1").unwrap();
-        assert_eq!(u, "openpgp4fpr:ABCDEF#x=1");
+        let u = normalize_sharing_url(&format!(
+            "https://i.delta.chat/#{}&a=alice%40x.org&n=Alice&i=inv&s=auth",
+            FPR.to_ascii_lowercase()
+        ))
+        .unwrap();
+        assert_eq!(
+            u,
+            format!("openpgp4fpr:{FPR}#a=alice%40x.org&n=Alice&i=inv&s=auth")
+        );
+        assert_eq!(
+            normalize_sharing_url(&u).unwrap(),
+            u,
+            "canonical form is stable"
+        );
+        assert_eq!(normalize_sharing_url(" reserved ").unwrap(), "reserved");
+    }
+
+    #[test]
+    fn parse_invite_url_cases() {
+        let web = |params: &str| format!("https://i.delta.chat/#{FPR}&{params}");
+        let ok = [
+            (
+                web("a=alice%40x.org&n=Alice&i=inv&s=auth"),
+                format!("openpgp4fpr:{FPR}#a=alice%40x.org&n=Alice&i=inv&s=auth"),
+                InviteKind::Contact,
+            ),
+            // Unicode names, `+` for spaces, parameters in any order.
+            (
+                web("s=auth&i=inv&n=J%C3%BCrgen+M%C3%BCller&a=j%40x.org"),
+                format!("openpgp4fpr:{FPR}#a=j%40x.org&n=J%C3%BCrgen%20M%C3%BCller&i=inv&s=auth"),
+                InviteKind::Contact,
+            ),
+            (
+                format!("OPENPGP4FPR:{FPR}#a=b%40x.org&g=%E8%AF%BB%E4%B9%A6&x=Ab3-d_9QzX&i=1&s=2"),
+                format!("openpgp4fpr:{FPR}#a=b%40x.org&g=%E8%AF%BB%E4%B9%A6&x=Ab3-d_9QzX&i=1&s=2"),
+                InviteKind::Group,
+            ),
+        ];
+        for (raw, canonical, kind) in ok {
+            let invite = parse_invite_url(&raw).unwrap_or_else(|e| panic!("{raw}: {e}"));
+            assert_eq!(invite.to_openpgp4fpr(), canonical);
+            assert_eq!(invite.kind(), kind);
+        }
+        let group = parse_invite_url(&ok_group()).unwrap();
+        assert_eq!(group.group, Some(("Rust Club".into(), "grp42".into())));
+
+        let long_name = "é".repeat(MAX_INVITE_NAME_CHARS + 1);
+        let long_token = "t".repeat(MAX_INVITE_TOKEN_CHARS + 1);
+        let bad = [
+            ("https://delta.chat/#x".to_string(), "DeltaChat web link"),
+            (web("a=a%40x.org&i=1&s=2").replace(FPR, "ABCDEF"), "40 hex"),
+            (
+                web("a=a%40x.org&i=1&s=2").replace(FPR, &FPR.replace('0', "G")),
+                "40 hex",
+            ),
+            (web("a=a%40x.org&i=1"), "auth token (s=)"),
+            (web("a=a%40x.org&s=2"), "invite number (i=)"),
+            (web("a=a%40x.org&i=&s=2"), "invite number (i=)"),
+            (web("n=Alice&i=1&s=2"), "address (a=)"),
+            (web("a=alice&i=1&s=2"), "not an email address"),
+            (
+                web("a=a%40x.org&i=1&s=2&name=Test"),
+                "\"name\" is not allowed",
+            ),
+            (
+                web("a=a%40x.org&a=b%40x.org&i=1&s=2"),
+                "a= appears more than once",
+            ),
+            (web("a=a%40x.org&i=1&s=2&n"), "has no value"),
+            (web("a=a%40x.org&n=%zz&i=1&s=2"), "percent-encoding"),
+            (web("a=a%40x.org&n=%C3%28&i=1&s=2"), "percent-encoding"),
+            (web("a=a%40x.org&n=A%0Ab&i=1&s=2"), "control characters"),
+            (
+                web(&format!("a=a%40x.org&n={long_name}&i=1&s=2")),
+                "longer than 256",
+            ),
+            (
+                web(&format!("a=a%40x.org&i={long_token}&s=2")),
+                "longer than 64",
+            ),
+            (
+                web("a=a%40x.org&i=1&s=a%20b"),
+                "auth token (s=) may only contain",
+            ),
+            (
+                web("a=a%40x.org&g=Club&i=1&s=2"),
+                "both a group name and a group id",
+            ),
+            (web("a=a%40x.org&g=+&x=grp&i=1&s=2"), "empty group name"),
+            (web("a=a%40x.org&g=Club&x=ab.cd&i=1&s=2"), "group id (x=)"),
+        ];
+        for (raw, want) in bad {
+            let err = parse_invite_url(&raw).expect_err(&raw).to_string();
+            assert!(err.contains(want), "{raw}: {err}");
+        }
+    }
+
+    fn ok_group() -> String {
+        format!("https://i.delta.chat/#{FPR}&a=alice%40x.org&g=Rust%20Club&x=grp42&i=inv&s=auth")
+    }
+
+    #[test]
+    fn invite_kind_contact_and_group_links() {
+        let contact = normalize_sharing_url(&format!(
+            "https://i.delta.chat/#{FPR}&a=alice%40x.org&n=Alice&i=inv&s=auth"
+        ))
+        .unwrap();
+        assert_eq!(invite_kind(&contact).unwrap(), InviteKind::Contact);
+        assert_eq!(
+            invite_kind("openpgp4fpr:ABCDEF").unwrap(),
+            InviteKind::Contact
+        );
+
+        let group = normalize_sharing_url(&ok_group()).unwrap();
+        assert_eq!(invite_kind(&group).unwrap(), InviteKind::Group);
+    }
+
+    #[test]
+    fn invite_kind_rejects_malformed_group_params() {
+        for url in [
+            "openpgp4fpr:FP#a=a%40x&g=Club&i=1&s=2",
+            "openpgp4fpr:FP#a=a%40x&x=abc&i=1&s=2",
+            "openpgp4fpr:FP#a=a%40x&g=&x=abc",
+            "openpgp4fpr:FP#a=a%40x&g=Club&x=",
+            "openpgp4fpr:FP#a=a%40x&g=Club&x=ab%3Cscript",
+            "openpgp4fpr:FP#g=Club&x=ab.cd",
+        ] {
+            assert!(invite_kind(url).is_err(), "{url}");
+        }
+        let long_id = format!("openpgp4fpr:FP#g=Club&x={}", "a".repeat(65));
+        assert!(invite_kind(&long_id).is_err());
+    }
+
+    #[test]
+    fn group_fields_limits() {
+        let ok = GroupInviteFields {
+            member_note: "~40 members".into(),
+            description: "Weekly meetups.\nBe kind.".into(),
+        };
+        validate_group_fields(InviteKind::Group, &ok).unwrap();
+        assert!(validate_group_fields(InviteKind::Contact, &ok).is_err());
+        validate_group_fields(InviteKind::Contact, &GroupInviteFields::default()).unwrap();
+
+        let long_note = GroupInviteFields {
+            member_note: "x".repeat(MAX_MEMBER_NOTE_CHARS + 1),
+            ..Default::default()
+        };
+        assert!(validate_group_fields(InviteKind::Group, &long_note).is_err());
+        let multiline_note = GroupInviteFields {
+            member_note: "a\nb".into(),
+            ..Default::default()
+        };
+        assert!(validate_group_fields(InviteKind::Group, &multiline_note).is_err());
+        let long_desc = GroupInviteFields {
+            description: "é".repeat(MAX_GROUP_DESCRIPTION_CHARS + 1),
+            ..Default::default()
+        };
+        assert!(validate_group_fields(InviteKind::Group, &long_desc).is_err());
+    }
+
+    #[tokio::test]
+    async fn sharing_db_upgrades_legacy_contacts_table() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("sharing.db");
+        {
+            let options = SqliteConnectOptions::from_str(&format!("sqlite:{}", path.display()))
+                .unwrap()
+                .create_if_missing(true);
+            let legacy = SqlitePool::connect_with(options).await.unwrap();
+            sqlx::query(
+                "CREATE TABLE contacts (slug TEXT PRIMARY KEY NOT NULL, url TEXT NOT NULL, \
+                 name TEXT NOT NULL DEFAULT '', created_at TEXT NOT NULL DEFAULT (datetime('now')))",
+            )
+            .execute(&legacy)
+            .await
+            .unwrap();
+            sqlx::query(
+                "INSERT INTO contacts (slug, url, name) VALUES ('old', 'openpgp4fpr:FP', 'Old')",
+            )
+            .execute(&legacy)
+            .await
+            .unwrap();
+            legacy.close().await;
+        }
+        let pool = init_sharing_db(&path).await.unwrap();
+        let row = get_sharing_contact(&pool, "old").await.unwrap().unwrap();
+        assert_eq!(row.kind, "contact");
+        assert_eq!(row.description, "");
+        assert_eq!((row.views, row.last_viewed.as_str()), (0, ""));
+    }
+
+    #[test]
+    fn invite_address_decodes_owner() {
+        assert_eq!(
+            invite_address("openpgp4fpr:FP#a=Alice%40x.org&n=Alice&i=1&s=2").as_deref(),
+            Some("alice@x.org")
+        );
+        assert_eq!(invite_address("openpgp4fpr:FP#n=Alice"), None);
+        assert_eq!(invite_address("openpgp4fpr:FP"), None);
+        assert_eq!(
+            invite_address("openpgp4fpr:FP#a=%zz%4").as_deref(),
+            Some("%zz%4")
+        );
+    }
+
+    #[tokio::test]
+    async fn sharing_views_accumulate_by_day() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = init_sharing_db(&dir.path().join("sharing.db"))
+            .await
+            .unwrap();
+        create_sharing_contact(
+            &pool,
+            "alice",
+            "https://i.delta.chat/#FP&a=alice%40x.org",
+            "A",
+        )
+        .await
+        .unwrap();
+        create_sharing_contact(&pool, "bob", "https://i.delta.chat/#FP&a=bob%40x.org", "B")
+            .await
+            .unwrap();
+        // 2024-01-02 and 2024-01-01 as unix days.
+        add_sharing_views(
+            &pool,
+            &[("alice".into(), 3, 19724), ("gone".into(), 1, 19724)],
+        )
+        .await
+        .unwrap();
+        add_sharing_views(&pool, &[("alice".into(), 2, 19723)])
+            .await
+            .unwrap();
+        let row = get_sharing_contact(&pool, "alice").await.unwrap().unwrap();
+        assert_eq!(row.views, 5);
+        assert_eq!(row.last_viewed, "2024-01-02");
+
+        let mine = list_sharing_contacts_for(&pool, "Alice@x.org")
+            .await
+            .unwrap();
+        assert_eq!(mine.len(), 1);
+        assert_eq!(mine[0].slug, "alice");
+
+        assert_eq!(
+            remove_sharing_contacts_for(&pool, "alice@x.org")
+                .await
+                .unwrap(),
+            1
+        );
+        assert!(get_sharing_contact(&pool, "alice").await.unwrap().is_none());
+        assert!(get_sharing_contact(&pool, "bob").await.unwrap().is_some());
+    }
+
+    #[tokio::test]
+    async fn sharing_links_expire_and_are_pruned() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = init_sharing_db(&dir.path().join("sharing.db"))
+            .await
+            .unwrap();
+        let url = |who: &str| format!("openpgp4fpr:{FPR}#a={who}%40x.org&i=1&s=2");
+        let none = GroupInviteFields::default();
+        let hour = Some(Duration::from_secs(3600));
+        for slug in ["temp", "old"] {
+            create_sharing_invite(&pool, slug, &url(slug), "", &none, hour)
+                .await
+                .unwrap();
+        }
+        create_sharing_invite(&pool, "perm", &url("perm"), "", &none, None)
+            .await
+            .unwrap();
+        let temp = get_live_sharing_contact(&pool, "temp").await.unwrap();
+        assert!(temp.unwrap().expires_at.is_some());
+        let perm = get_live_sharing_contact(&pool, "perm").await.unwrap();
+        assert_eq!(perm.unwrap().expires_at, None);
+
+        sqlx::query(
+            "UPDATE contacts SET expires_at = datetime('now', '-1 minute') WHERE slug != 'perm'",
+        )
+        .execute(&pool)
+        .await
+        .unwrap();
+        assert!(get_live_sharing_contact(&pool, "temp")
+            .await
+            .unwrap()
+            .is_none());
+        assert!(get_sharing_contact(&pool, "temp").await.unwrap().is_some());
+        assert!(!sharing_slug_exists(&pool, "temp").await.unwrap());
+        assert!(sharing_slug_exists(&pool, "perm").await.unwrap());
+
+        // An expired slug can be taken again before the pruner runs.
+        create_sharing_invite(&pool, "temp", &url("new"), "New", &none, None)
+            .await
+            .unwrap();
+        let temp = get_live_sharing_contact(&pool, "temp")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!((temp.name.as_str(), temp.expires_at), ("New", None));
+
+        assert_eq!(prune_expired_sharing_contacts(&pool).await.unwrap(), 1);
+        assert!(get_sharing_contact(&pool, "old").await.unwrap().is_none());
+        assert_eq!(list_sharing_contacts(&pool).await.unwrap().len(), 2);
     }
 
     #[tokio::test]
@@ -188,6 +1113,78 @@ mod tests {
         let rows = list_sharing_contacts(&pool).await.unwrap();
         assert_eq!(rows.len(), 1);
         assert_eq!(rows[0].slug, "alice");
+        assert_eq!(rows[0].kind, "contact");
         assert!(remove_sharing_contact(&pool, "alice").await.unwrap());
+
+        let fields = GroupInviteFields {
+            member_note: "about 30".into(),
+            description: "Local <b>chess</b> club".into(),
+        };
+        let kind = create_sharing_invite(
+            &pool,
+            "chess",
+            "https://i.delta.chat/#FP&a=a%40x&g=Chess&x=grp1&i=1&s=2",
+            "Chess Club",
+            &fields,
+            None,
+        )
+        .await
+        .unwrap();
+        assert_eq!(kind, InviteKind::Group);
+        let row = get_sharing_contact(&pool, "chess").await.unwrap().unwrap();
+        assert_eq!(row.kind, "group");
+        assert_eq!(row.member_note, "about 30");
+        assert_eq!(row.description, "Local <b>chess</b> club");
+    }
+
+    #[tokio::test]
+    async fn sharing_rename_and_page() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = init_sharing_db(&dir.path().join("sharing.db"))
+            .await
+            .unwrap();
+        for slug in ["alice", "bob", "carol"] {
+            create_sharing_contact(&pool, slug, "reserved", "Reserved")
+                .await
+                .unwrap();
+        }
+        let (page, total) = list_sharing_contacts_page(&pool, 1, 1).await.unwrap();
+        assert_eq!((page.len(), total), (1, 3));
+
+        assert!(rename_sharing_contact(&pool, "alice", "alice2")
+            .await
+            .unwrap());
+        assert!(get_sharing_contact(&pool, "alice").await.unwrap().is_none());
+        assert!(get_sharing_contact(&pool, "alice2")
+            .await
+            .unwrap()
+            .is_some());
+        assert!(!rename_sharing_contact(&pool, "alice", "dave")
+            .await
+            .unwrap());
+        for (new, err) in [
+            ("bob", "already taken"),
+            ("share", "reserved"),
+            ("a-b", "alphanumeric"),
+        ] {
+            let e = rename_sharing_contact(&pool, "carol", new)
+                .await
+                .unwrap_err();
+            assert!(e.to_string().contains(err), "{new}: {e}");
+        }
+        assert!(is_reserved_slug("docs") && !is_reserved_slug("alice"));
+
+        assert!(set_sharing_contact_name(&pool, "bob", "Bob").await.unwrap());
+        assert_eq!(
+            get_sharing_contact(&pool, "bob")
+                .await
+                .unwrap()
+                .unwrap()
+                .name,
+            "Bob"
+        );
+        assert!(!set_sharing_contact_name(&pool, "nobody", "X")
+            .await
+            .unwrap());
     }
 }
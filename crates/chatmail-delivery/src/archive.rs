@@ -0,0 +1,345 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Writes `submission { archive ... }` copies of accepted outbound mail.
+//!
+//! The copy is taken before primary delivery so `archive_fail_closed` can temp-reject a
+//! submission without any recipient having received it. Local copies go straight to the
+//! mailbox store and never re-enter submission, so the archive cannot loop.
+
+use std::path::Path;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use chatmail_state::{archived_copy, ArchiveTarget};
+use chatmail_types::{ChatmailError, Result};
+use tracing::{debug, warn};
+
+use crate::mail_options::MailOptions;
+use crate::router::{DeliveryContext, OutboundJob};
+use crate::tls_required::TlsPolicy;
+
+impl DeliveryContext {
+    /// Archive one submission. `Err` only when the copy failed and `archive_fail_closed` is on.
+    pub(crate) async fn archive_outbound(
+        &self,
+        mail_from: &str,
+        recipients: &[String],
+        data: &[u8],
+    ) -> Result<()> {
+        let archive = &self.state.archive;
+        if !archive.applies_to(mail_from) {
+            return Ok(());
+        }
+        let copy = archived_copy(mail_from, recipients, data);
+        let written = match archive.target() {
+            Some(Ok(target)) => self.write_archive_copy(target, mail_from, &copy).await,
+            Some(Err(e)) => Err(e.to_string()),
+            None => return Ok(()),
+        };
+        match written {
+            Ok(()) => {
+                debug!(from = %mail_from, rcpts = recipients.len(), "submission archived");
+                Ok(())
+            }
+            Err(e) if archive.fail_closed() => {
+                warn!(from = %mail_from, error = %e, "archive copy failed; submission deferred");
+                Err(ChatmailError::storage(format!("archive: {e}")))
+            }
+            Err(e) => {
+                warn!(from = %mail_from, error = %e, "archive copy failed; delivering anyway");
+                Ok(())
+            }
+        }
+    }
+
+    async fn write_archive_copy(
+        &self,
+        target: &ArchiveTarget,
+        mail_from: &str,
+        copy: &[u8],
+    ) -> std::result::Result<(), String> {
+        match target {
+            ArchiveTarget::Mailbox(mailbox) => {
+                self.state
+                    .quota
+                    .check_quota(mailbox, copy.len() as u64)
+                    .map_err(|e| e.to_string())?;
+                let msg_id = uuid::Uuid::new_v4().to_string();
+                let outcome = chatmail_storage::deliver_local_messages(
+                    &self.state.mailbox_store,
+                    &[(mailbox.clone(), msg_id.clone())],
+                    copy,
+                )
+                .await
+                .map_err(|e| e.to_string())?;
+                if let Some((_, _, err)) = outcome.failed.first() {
+                    return Err(err.clone());
+                }
+                self.state.quota.record_write(mailbox, copy.len() as u64);
+                self.state.events.notify_new_message(mailbox, &msg_id);
+                Ok(())
+            }
+            ArchiveTarget::Maildir(dir) => write_maildir(dir, &self.primary_domain, copy)
+                .await
+                .map_err(|e| format!("maildir {}: {e}", dir.display())),
+            ArchiveTarget::Smtp { rcpt, endpoint } => {
+                let job = OutboundJob {
+                    mail_from: mail_from.to_string(),
+                    rcpt_to: rcpt.clone(),
+                    data: copy.to_vec(),
+                    options: MailOptions::default(),
+                    priority: 0,
+                };
+                let helo = crate::transport::helo_name_for(self);
+                crate::federation_smtp::deliver_to_host(
+                    endpoint,
+                    &helo,
+                    &job,
+                    TlsPolicy::Opportunistic,
+                )
+                .await
+                .map_err(|e| format!("smtp {endpoint}: {e}"))
+            }
+        }
+    }
+}
+
+/// Maildir delivery: write under `tmp/`, then rename into `new/`.
+async fn write_maildir(dir: &Path, host: &str, copy: &[u8]) -> std::io::Result<()> {
+    for sub in ["tmp", "new", "cur"] {
+        tokio::fs::create_dir_all(dir.join(sub)).await?;
+    }
+    let secs = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0);
+    let host = host.replace(['/', ':'], "_");
+    let name = format!("{secs}.{}.{host}", uuid::Uuid::new_v4().simple());
+    let tmp = dir.join("tmp").join(&name);
+    tokio::fs::write(&tmp, copy).await?;
+    tokio::fs::rename(&tmp, dir.join("new").join(&name)).await
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::{Arc, Mutex};
+
+    use chatmail_config::AppConfig;
+    use chatmail_db::init_memory_db;
+    use chatmail_state::AppState;
+    use tokio::io::{AsyncBufReadExt, AsyncWriteExt, BufReader};
+    use tokio::net::TcpListener;
+
+    use super::*;
+
+    const BODY: &[u8] = b"From: a@local.test\r\nTo: b@local.test\r\nSubject: q3\r\n\r\nnumbers\r\n";
+
+    async fn context(dir: &Path, archive: &str, fail_closed: bool) -> DeliveryContext {
+        let pool = init_memory_db().await.unwrap();
+        let config = AppConfig {
+            archive: Some(archive.to_string()),
+            archive_fail_closed: Some(fail_closed),
+            ..AppConfig::default()
+        };
+        let app = AppState::with_quota_and_message_limit(
+            dir,
+            chatmail_config::DEFAULT_QUOTA_BYTES,
+            &config,
+            pool.clone(),
+        );
+        app.auth.hydrate(&pool).await.unwrap();
+        DeliveryContext {
+            pool,
+            state: Arc::new(app),
+            primary_domain: "local.test".into(),
+            local_domains: chatmail_types::build_local_domains("local.test", None),
+        }
+    }
+
+    async fn inbox(ctx: &DeliveryContext, user: &str) -> Vec<Vec<u8>> {
+        let store = &ctx.state.mailbox_store;
+        let mut out = Vec::new();
+        for entry in chatmail_storage::list_inbox(store, user).await.unwrap() {
+            out.push(
+                chatmail_storage::read_blob(store, user, "INBOX", &entry.msg_id)
+                    .await
+                    .unwrap(),
+            );
+        }
+        out
+    }
+
+    /// Minimal SMTP sink; returns its address and `(RCPT line, DATA)` per message.
+    async fn spawn_smtp_sink() -> (String, Arc<Mutex<Vec<(String, String)>>>) {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap().to_string();
+        let received = Arc::new(Mutex::new(Vec::new()));
+        let seen = Arc::clone(&received);
+        tokio::spawn(async move {
+            while let Ok((stream, _)) = listener.accept().await {
+                let (r, mut w) = stream.into_split();
+                let mut lines = BufReader::new(r).lines();
+                w.write_all(b"220 sink ESMTP\r\n").await.unwrap();
+                let (mut rcpt, mut data, mut in_data) = (String::new(), String::new(), false);
+                while let Ok(Some(line)) = lines.next_line().await {
+                    if in_data {
+                        if line == "." {
+                            in_data = false;
+                            seen.lock()
+                                .unwrap()
+                                .push((rcpt.clone(), std::mem::take(&mut data)));
+                            w.write_all(b"250 2.0.0 queued\r\n").await.unwrap();
+                        } else {
+                            data.push_str(&line);
+                            data.push_str("\r\n");
+                        }
+                        continue;
+                    }
+                    let upper = line.to_ascii_uppercase();
+                    if upper.starts_with("RCPT") {
+                        rcpt = line.clone();
+                    } else if upper.starts_with("DATA") {
+                        in_data = true;
+                        w.write_all(b"354 go\r\n").await.unwrap();
+                        continue;
+                    } else if upper.starts_with("QUIT") {
+                        w.write_all(b"221 bye\r\n").await.unwrap();
+                        break;
+                    }
+                    w.write_all(b"250 OK\r\n").await.unwrap();
+                }
+            }
+        });
+        (addr, received)
+    }
+
+    #[tokio::test]
+    async fn archives_to_local_mailbox() {
+        let dir = tempfile::tempdir().unwrap();
+        let ctx = context(dir.path(), "archive@local.test", false).await;
+        ctx.submit_authenticated(
+            "a@local.test",
+            &["b@local.test".into()],
+            BODY,
+            MailOptions::default(),
+        )
+        .await
+        .unwrap();
+
+        assert_eq!(inbox(&ctx, "b@local.test").await, vec![BODY.to_vec()]);
+        let archived = inbox(&ctx, "archive@local.test").await;
+        assert_eq!(archived.len(), 1);
+        let text = String::from_utf8(archived[0].clone()).unwrap();
+        assert!(
+            text.starts_with("X-Archived-For: from=<a@local.test>;\r\n rcpt=<b@local.test>\r\n")
+        );
+        assert!(text.ends_with(std::str::from_utf8(BODY).unwrap()));
+
+        // Mail sent from the archive account is delivered but not archived again.
+        ctx.submit_authenticated(
+            "archive@local.test",
+            &["b@local.test".into()],
+            BODY,
+            MailOptions::default(),
+        )
+        .await
+        .unwrap();
+        assert_eq!(inbox(&ctx, "b@local.test").await.len(), 2);
+        assert_eq!(inbox(&ctx, "archive@local.test").await.len(), 1);
+    }
+
+    #[tokio::test]
+    async fn archives_to_maildir() {
+        let dir = tempfile::tempdir().unwrap();
+        let maildir = dir.path().join("journal");
+        let target = format!("maildir:{}", maildir.display());
+        let ctx = context(dir.path(), &target, false).await;
+        ctx.submit_authenticated(
+            "a@local.test",
+            &["b@local.test".into()],
+            BODY,
+            MailOptions::default(),
+        )
+        .await
+        .unwrap();
+
+        let files: Vec<_> = std::fs::read_dir(maildir.join("new")).unwrap().collect();
+        assert_eq!(files.len(), 1);
+        let copy = std::fs::read(files[0].as_ref().unwrap().path()).unwrap();
+        assert!(copy.starts_with(b"X-Archived-For: from=<a@local.test>"));
+        assert_eq!(std::fs::read_dir(maildir.join("tmp")).unwrap().count(), 0);
+    }
+
+    #[tokio::test]
+    async fn archives_to_smtp_target() {
+        let dir = tempfile::tempdir().unwrap();
+        let (addr, received) = spawn_smtp_sink().await;
+        let ctx = context(dir.path(), &format!("smtp://journal@{addr}"), true).await;
+        ctx.submit_authenticated(
+            "a@local.test",
+            &["b@local.test".into()],
+            BODY,
+            MailOptions::default(),
+        )
+        .await
+        .unwrap();
+
+        assert_eq!(inbox(&ctx, "b@local.test").await, vec![BODY.to_vec()]);
+        let received = received.lock().unwrap().clone();
+        assert_eq!(received.len(), 1);
+        let host = addr.rsplit_once(':').unwrap().0;
+        assert_eq!(received[0].0, format!("RCPT TO:<journal@{host}>"));
+        assert!(received[0]
+            .1
+            .starts_with("X-Archived-For: from=<a@local.test>;\r\n rcpt=<b@local.test>\r\n"));
+    }
+
+    #[tokio::test]
+    async fn fail_closed_defers_when_archive_unreachable() {
+        let closed = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = closed.local_addr().unwrap().to_string();
+        drop(closed);
+        let target = format!("smtp://journal@{addr}");
+
+        let dir = tempfile::tempdir().unwrap();
+        let ctx = context(dir.path(), &target, true).await;
+        let err = ctx
+            .submit_authenticated(
+                "a@local.test",
+                &["b@local.test".into()],
+                BODY,
+                MailOptions::default(),
+            )
+            .await
+            .unwrap_err();
+        assert!(matches!(err, ChatmailError::Storage(_)), "{err}");
+        assert!(inbox(&ctx, "b@local.test").await.is_empty());
+
+        // Fail-open: the same failure is logged and the message still goes out.
+        let dir = tempfile::tempdir().unwrap();
+        let ctx = context(dir.path(), &target, false).await;
+        ctx.submit_authenticated(
+            "a@local.test",
+            &["b@local.test".into()],
+            BODY,
+            MailOptions::default(),
+        )
+        .await
+        .unwrap();
+        assert_eq!(inbox(&ctx, "b@local.test").await, vec![BODY.to_vec()]);
+    }
+}
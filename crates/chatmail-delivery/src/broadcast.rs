@@ -0,0 +1,433 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Admin announcement broadcasts (`POST /admin/broadcast`, `madmail broadcast`).
+//!
+//! The message is built here, once per recipient, and written straight into each selected
+//! account's INBOX through the storage layer, paced by [`chatmail_state::BroadcastPacer`].
+//! Nothing goes through the outbound queue, so an announcement is never forwarded, relayed
+//! or bounced; a failed account is recorded on the job and skipped. With `markdown` set the
+//! body is sent as `multipart/alternative`: the source as `text/plain` and a small subset of
+//! Markdown (headings, lists, emphasis, code, links) rendered to `text/html`.
+
+use std::sync::Arc;
+
+use chatmail_db::policies::unix_now;
+use chatmail_db::{record_broadcast_audit, DbPool};
+use chatmail_state::broadcast::select_recipients;
+use chatmail_state::{AppState, BroadcastFilter, BroadcastJob};
+use chatmail_storage::write_blob;
+use chatmail_types::Result;
+use time::format_description::well_known::Rfc2822;
+use time::OffsetDateTime;
+use tracing::{info, warn};
+
+use crate::responder::header_safe;
+
+/// Deliveries between two `job_progress` events.
+const PROGRESS_EVERY: u64 = 50;
+
+/// What `POST /admin/broadcast` sends.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Announcement {
+    pub subject: String,
+    pub body: String,
+    /// Also send the body rendered from Markdown as `text/html`.
+    pub markdown: bool,
+}
+
+/// The announcement for `to`, from `from`; `domain` names the Message-ID host.
+pub fn build_announcement(from: &str, to: &str, msg: &Announcement, domain: &str) -> Vec<u8> {
+    let date = OffsetDateTime::now_utc()
+        .format(&Rfc2822)
+        .unwrap_or_else(|_| OffsetDateTime::now_utc().to_string());
+    let id_host = domain.trim_matches(|c| c == '[' || c == ']');
+    let text = crlf_body(&msg.body);
+    let content = if msg.markdown {
+        let boundary = format!("broadcast-{}", uuid::Uuid::new_v4().simple());
+        let html = crlf_body(&format!(
+            "<!DOCTYPE html>\n<html><body>\n{}</body></html>",
+            render_markdown(&msg.body)
+        ));
+        format!(
+            "Content-Type: multipart/alternative; boundary=\"{boundary}\"\r\n\
+             \r\n\
+             --{boundary}\r\n\
+             Content-Type: text/plain; charset=utf-8\r\n\
+             Content-Transfer-Encoding: 8bit\r\n\
+             \r\n\
+             {text}\
+             --{boundary}\r\n\
+             Content-Type: text/html; charset=utf-8\r\n\
+             Content-Transfer-Encoding: 8bit\r\n\
+             \r\n\
+             {html}\
+             --{boundary}--\r\n"
+        )
+    } else {
+        format!(
+            "Content-Type: text/plain; charset=utf-8\r\n\
+             Content-Transfer-Encoding: 8bit\r\n\
+             \r\n\
+             {text}"
+        )
+    };
+    format!(
+        "From: Announcements <{from}>\r\n\
+         To: <{to}>\r\n\
+         Subject: {subject}\r\n\
+         Date: {date}\r\n\
+         Message-ID: <{msg_id}@{id_host}>\r\n\
+         Auto-Submitted: auto-generated\r\n\
+         Precedence: bulk\r\n\
+         MIME-Version: 1.0\r\n\
+         {content}",
+        subject = header_safe(&msg.subject),
+        msg_id = uuid::Uuid::new_v4(),
+    )
+    .into_bytes()
+}
+
+fn crlf_body(body: &str) -> String {
+    let mut out = body.replace("\r\n", "\n").replace('\n', "\r\n");
+    if !out.ends_with("\r\n") {
+        out.push_str("\r\n");
+    }
+    out
+}
+
+/// HTML for the Markdown subset announcements use: `#` headings, `-`/`*` lists,
+/// paragraphs, `**strong**`, `*em*`, `` `code` `` and `[text](url)` links to http(s) and
+/// mailto URLs. Everything else is escaped text.
+pub fn render_markdown(src: &str) -> String {
+    let src = src.replace("\r\n", "\n");
+    let mut html = String::new();
+    for block in src.split("\n\n").map(str::trim).filter(|b| !b.is_empty()) {
+        let lines: Vec<&str> = block.lines().map(str::trim).collect();
+        if lines
+            .iter()
+            .all(|l| l.starts_with("- ") || l.starts_with("* "))
+        {
+            html.push_str("<ul>\n");
+            for line in lines {
+                html.push_str(&format!("<li>{}</li>\n", render_inline(&line[2..])));
+            }
+            html.push_str("</ul>\n");
+            continue;
+        }
+        let mut paragraph: Vec<String> = Vec::new();
+        for line in lines {
+            let level = line.chars().take_while(|c| *c == '#').count();
+            if (1..=6).contains(&level) && line[level..].starts_with(' ') {
+                flush_paragraph(&mut html, &mut paragraph);
+                html.push_str(&format!(
+                    "<h{level}>{}</h{level}>\n",
+                    render_inline(line[level..].trim())
+                ));
+            } else {
+                paragraph.push(render_inline(line));
+            }
+        }
+        flush_paragraph(&mut html, &mut paragraph);
+    }
+    html
+}
+
+fn flush_paragraph(html: &mut String, lines: &mut Vec<String>) {
+    if !lines.is_empty() {
+        html.push_str(&format!("<p>{}</p>\n", lines.join("<br>\n")));
+        lines.clear();
+    }
+}
+
+fn escape_html(s: &str) -> String {
+    s.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
+
+fn render_inline(text: &str) -> String {
+    let mut out = String::new();
+    let mut rest = text;
+    while !rest.is_empty() {
+        if let Some((html, after)) = inline_span(rest) {
+            out.push_str(&html);
+            rest = after;
+            continue;
+        }
+        let ch = rest.chars().next().unwrap_or_default();
+        out.push_str(&escape_html(&ch.to_string()));
+        rest = &rest[ch.len_utf8()..];
+    }
+    out
+}
+
+/// A span starting at the beginning of `s` and the text after it.
+fn inline_span(s: &str) -> Option<(String, &str)> {
+    if let Some(inner) = s.strip_prefix('`') {
+        let end = inner.find('`')?;
+        let code = escape_html(&inner[..end]);
+        return Some((format!("<code>{code}</code>"), &inner[end + 1..]));
+    }
+    if let Some(inner) = s.strip_prefix("**") {
+        let end = inner.find("**").filter(|e| *e > 0)?;
+        let strong = render_inline(&inner[..end]);
+        return Some((format!("<strong>{strong}</strong>"), &inner[end + 2..]));
+    }
+    if let Some(inner) = s.strip_prefix('*') {
+        let end = inner.find('*').filter(|e| *e > 0)?;
+        let em = render_inline(&inner[..end]);
+        return Some((format!("<em>{em}</em>"), &inner[end + 1..]));
+    }
+    if let Some(inner) = s.strip_prefix('[') {
+        let close = inner.find("](")?;
+        let after = &inner[close + 2..];
+        let end = after.find(')')?;
+        let url = after[..end].trim();
+        let safe = ["https://", "http://", "mailto:"]
+            .iter()
+            .any(|p| url.to_ascii_lowercase().starts_with(p));
+        if !safe {
+            return None;
+        }
+        let label = render_inline(&inner[..close]);
+        return Some((
+            format!("<a href=\"{}\">{label}</a>", escape_html(url)),
+            &after[end + 1..],
+        ));
+    }
+    None
+}
+
+/// Write `raw` into `to`'s INBOX, quota-checked, and notify IMAP and push like inbound mail.
+async fn deliver_announcement(
+    state: &AppState,
+    pool: &DbPool,
+    from: &str,
+    to: &str,
+    raw: &[u8],
+) -> Result<()> {
+    state.quota.check_quota(to, raw.len() as u64)?;
+    let msg_id = uuid::Uuid::new_v4().to_string();
+    write_blob(&state.mailbox_store, to, &msg_id, raw).await?;
+    state.quota.record_write(to, raw.len() as u64);
+    state.events.notify_new_message(to, &msg_id);
+    state.notify_inbound_push(pool, from, to).await;
+    Ok(())
+}
+
+/// Select the recipients for `filter`, record the broadcast in the audit log and deliver
+/// `msg` to them in the background. The returned job is already listed on
+/// `state.broadcasts`; once every account was tried it is audited again and then marked
+/// finished.
+pub async fn start_broadcast(
+    state: Arc<AppState>,
+    pool: DbPool,
+    domain: &str,
+    msg: Announcement,
+    filter: BroadcastFilter,
+    actor: &str,
+) -> Result<Arc<BroadcastJob>> {
+    let recipients = select_recipients(&pool, &filter, unix_now()).await?;
+    let job = Arc::new(BroadcastJob::new(
+        &msg.subject,
+        filter,
+        actor,
+        recipients.len(),
+    ));
+    record_broadcast_audit(
+        &pool,
+        "start",
+        &job.id,
+        &job.subject,
+        &job.filter.label(),
+        actor,
+        recipients.len() as i64,
+        0,
+        0,
+    )
+    .await?;
+    state.broadcasts.register(Arc::clone(&job));
+    state.broadcasts.publish_progress(&job);
+    info!(id = %job.id, recipients = recipients.len(), actor, "broadcast started");
+
+    let sender = state.broadcasts.sender(domain);
+    let domain = domain.to_string();
+    let running = Arc::clone(&job);
+    tokio::spawn(async move {
+        let mut pacer = state.broadcasts.pacer();
+        for (n, rcpt) in recipients.iter().enumerate() {
+            pacer.tick().await;
+            let raw = build_announcement(&sender, rcpt, &msg, &domain);
+            match deliver_announcement(&state, &pool, &sender, rcpt, &raw).await {
+                Ok(()) => running.record_delivered(),
+                Err(e) => {
+                    warn!(id = %running.id, rcpt = %rcpt, error = %e, "broadcast delivery failed");
+                    running.record_failure(rcpt, &e.to_string());
+                }
+            }
+            if (n as u64 + 1) % PROGRESS_EVERY == 0 {
+                state.broadcasts.publish_progress(&running);
+            }
+        }
+        let (delivered, failed) = running.counts();
+        info!(id = %running.id, delivered, failed, "broadcast finished");
+        if let Err(e) = record_broadcast_audit(
+            &pool,
+            "finish",
+            &running.id,
+            &running.subject,
+            &running.filter.label(),
+            &running.actor,
+            running.recipients as i64,
+            delivered as i64,
+            failed as i64,
+        )
+        .await
+        {
+            warn!(id = %running.id, error = %e, "broadcast: audit write failed");
+        }
+        running.finish();
+        state.broadcasts.publish_progress(&running);
+    });
+    Ok(job)
+}
+
+#[cfg(test)]
+mod tests {
+    use std::time::Duration;
+
+    use chatmail_db::{init_memory_db, list_broadcast_audit, passwords};
+    use chatmail_state::BroadcastState;
+
+    use super::*;
+
+    fn announcement(markdown: bool) -> Announcement {
+        Announcement {
+            subject: "Maintenance\r\nBcc: x@evil".into(),
+            body: "# Downtime\n\nThe server restarts **Sunday**.\n\n- IMAP\n- SMTP\n".into(),
+            markdown,
+        }
+    }
+
+    #[test]
+    fn plain_announcement_is_single_text_part() {
+        let raw = build_announcement(
+            "announcements@example.org",
+            "bob@example.org",
+            &announcement(false),
+            "example.org",
+        );
+        let parsed = mail_parser::MessageParser::default().parse(&raw).unwrap();
+        let ct = parsed.content_type().unwrap();
+        assert_eq!((ct.ctype(), ct.subtype()), ("text", Some("plain")));
+        assert_eq!(parsed.parts.len(), 1);
+        assert!(parsed.body_text(0).unwrap().contains("**Sunday**"));
+        let head = String::from_utf8_lossy(&raw);
+        assert!(
+            head.contains("Subject: Maintenance  Bcc: x@evil\r\n"),
+            "header injection folded into the subject"
+        );
+        assert!(!head.contains("\r\nBcc:"));
+        assert!(head.contains("Auto-Submitted: auto-generated\r\n"));
+        assert!(head.contains("From: Announcements <announcements@example.org>\r\n"));
+    }
+
+    #[test]
+    fn markdown_announcement_is_text_and_html_alternative() {
+        let raw = build_announcement(
+            "announcements@example.org",
+            "bob@example.org",
+            &announcement(true),
+            "example.org",
+        );
+        let parsed = mail_parser::MessageParser::default().parse(&raw).unwrap();
+        let ct = parsed.content_type().unwrap();
+        assert_eq!(
+            (ct.ctype(), ct.subtype()),
+            ("multipart", Some("alternative"))
+        );
+        assert_eq!(parsed.parts.len(), 3, "root + text/plain + text/html");
+        let text = parsed.body_text(0).unwrap();
+        assert!(text.contains("# Downtime"), "{text}");
+        let html = parsed.body_html(0).unwrap();
+        assert!(html.contains("<h1>Downtime</h1>"), "{html}");
+        assert!(html.contains("<strong>Sunday</strong>"), "{html}");
+        assert!(html.contains("<li>IMAP</li>"), "{html}");
+    }
+
+    #[test]
+    fn renders_markdown_subset_and_escapes_the_rest() {
+        assert_eq!(
+            render_markdown("Hi *all*, see [docs](https://x.example/a?b=1&c=2) or `a<b`"),
+            "<p>Hi <em>all</em>, see <a href=\"https://x.example/a?b=1&amp;c=2\">docs</a> \
+             or <code>a&lt;b</code></p>\n"
+        );
+        assert_eq!(
+            render_markdown("[x](javascript:alert(1)) <script>"),
+            "<p>[x](javascript:alert(1)) &lt;script&gt;</p>\n"
+        );
+        assert_eq!(
+            render_markdown("line one\nline two\n\n## Next"),
+            "<p>line one<br>\nline two</p>\n<h2>Next</h2>\n"
+        );
+        assert_eq!(render_markdown("2 * 3 = 6"), "<p>2 * 3 = 6</p>\n");
+    }
+
+    #[tokio::test]
+    async fn broadcast_reaches_selected_inboxes_and_is_audited() {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        for user in ["a@one.test", "b@one.test", "c@two.test"] {
+            passwords::create_user(&pool, user, "hash").await.unwrap();
+        }
+        let state = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let job = start_broadcast(
+            Arc::clone(&state),
+            pool.clone(),
+            "one.test",
+            announcement(false),
+            BroadcastFilter::Domain("one.test".into()),
+            "cli",
+        )
+        .await
+        .unwrap();
+        assert_eq!(job.recipients, 2);
+        for _ in 0..200 {
+            if job.state() == BroadcastState::Finished {
+                break;
+            }
+            tokio::time::sleep(Duration::from_millis(10)).await;
+        }
+        assert_eq!(job.state(), BroadcastState::Finished);
+        assert_eq!(job.counts(), (2, 0));
+        for (user, want) in [("a@one.test", 1), ("b@one.test", 1), ("c@two.test", 0)] {
+            let ids = chatmail_storage::list_inbox(&state.mailbox_store, user)
+                .await
+                .unwrap_or_default();
+            assert_eq!(ids.len(), want, "{user}");
+        }
+        assert!(state.broadcasts.get(&job.id).is_some());
+
+        let audit = list_broadcast_audit(&pool, 10).await.unwrap();
+        let actions: Vec<_> = audit.iter().map(|a| a.action.as_str()).collect();
+        assert_eq!(actions, ["finish", "start"]);
+        assert_eq!(audit[0].filter, "domain:one.test");
+        assert_eq!((audit[0].delivered, audit[0].failed), (2, 0));
+    }
+}
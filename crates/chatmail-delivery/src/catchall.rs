@@ -0,0 +1,116 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Catch-all delivery (`madmail domains catchall`): mail for a local address without an
+//! account goes to the domain's catch-all mailbox instead of being dropped.
+//!
+//! [`chatmail_state::Catchalls`] decides whether an address is redirected (precedence,
+//! exclusions, daily cap); this module stores the copy. Each copy keeps the original
+//! message untouched below a prepended `X-Original-To` header, so the owner of the
+//! catch-all can tell which address was written to. Catch-all copies never trigger
+//! automatic responders, and a failure only costs the redirected copy.
+
+use tracing::{debug, warn};
+
+use chatmail_db::policies::unix_now;
+
+use crate::DeliveryContext;
+
+/// Header naming the address a catch-all copy was originally sent to.
+pub const ORIGINAL_TO_HEADER: &str = "X-Original-To";
+
+/// `data` with an `X-Original-To: rcpt` header prepended.
+pub fn with_original_to(rcpt: &str, data: &[u8]) -> Vec<u8> {
+    let mut out = format!("{ORIGINAL_TO_HEADER}: {rcpt}\r\n").into_bytes();
+    out.extend_from_slice(data);
+    out
+}
+
+impl DeliveryContext {
+    /// Deliver to the catch-all mailbox every recipient in `unknown` (local addresses
+    /// without an account) that has one; the rest are dropped as before.
+    ///
+    /// Returns how many copies were stored. Errors are logged, never returned: the
+    /// message was already accepted for its real recipients.
+    pub async fn deliver_catchall(
+        &self,
+        mail_from: &str,
+        unknown: &[String],
+        data: &[u8],
+    ) -> usize {
+        if unknown.is_empty() || self.state.catchalls.is_empty() {
+            for rcpt in unknown {
+                debug!(rcpt = %rcpt, "silently dropped inbound local delivery");
+            }
+            return 0;
+        }
+        let mut stored = 0;
+        for rcpt in unknown {
+            let Some(target) = self
+                .state
+                .catchalls
+                .redirect(&self.pool, &self.state.auth, rcpt, unix_now())
+                .await
+            else {
+                debug!(rcpt = %rcpt, "silently dropped inbound local delivery");
+                continue;
+            };
+            let copy = with_original_to(rcpt, data);
+            if let Err(e) = self.state.admit_local_delivery(&target, copy.len()) {
+                warn!(rcpt = %rcpt, target = %target, error = %e, "catch-all: target refused");
+                continue;
+            }
+            let delivery = [(target.clone(), uuid::Uuid::new_v4().to_string())];
+            let outcome = match chatmail_storage::deliver_local_messages(
+                &self.state.mailbox_store,
+                &delivery,
+                &copy,
+            )
+            .await
+            {
+                Ok(outcome) => outcome,
+                Err(e) => {
+                    warn!(rcpt = %rcpt, target = %target, error = %e, "catch-all delivery failed");
+                    continue;
+                }
+            };
+            for (target, msg_id) in &outcome.delivered {
+                self.state.quota.record_write(target, copy.len() as u64);
+                self.state.events.notify_new_message(target, msg_id);
+                self.state
+                    .notify_inbound_push(&self.pool, mail_from, target)
+                    .await;
+                self.state.activity.touch_inbound(&self.pool, target).await;
+                stored += 1;
+            }
+        }
+        stored
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn original_to_is_prepended_above_the_untouched_message() {
+        let data = b"From: a@remote.org\r\nSubject: hi\r\n\r\nbody";
+        let copy = with_original_to("sales@example.org", data);
+        assert!(copy.starts_with(b"X-Original-To: sales@example.org\r\nFrom: a@remote.org\r\n"));
+        assert!(copy.ends_with(data));
+    }
+}
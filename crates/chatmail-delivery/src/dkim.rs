@@ -0,0 +1,790 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Outbound DKIM signing (RFC 6376 `rsa-sha256`, RFC 8463 `ed25519-sha256`) and its key files.
+//!
+//! Keys use Madmail's layout: `{state_dir}/dkim_keys/{domain}_{selector}.key` holds the PKCS#8
+//! PEM private key and `{domain}_{selector}.dns` the TXT value to publish at
+//! `{selector}._domainkey.{domain}`. Authenticated submissions to remote recipients are signed
+//! with the selectors of the submission `modify { dkim … }` lines, or with the one stored in
+//! [`settings_keys::DKIM_SELECTOR`] by `/admin/dkim/activate`. A selector without a key file for
+//! the sender's domain is skipped, so mail goes out unsigned rather than not at all.
+//!
+//! Nothing here deletes a key: after a rotation the old selector must keep verifying for mail
+//! still in transit.
+
+use std::path::{Path, PathBuf};
+
+use base64::engine::general_purpose::STANDARD as B64;
+use base64::Engine as _;
+use chatmail_db::{get_setting, settings_keys, DbPool};
+use chatmail_types::{address_domain, ChatmailError, Result};
+use openssl::error::ErrorStack;
+use openssl::hash::MessageDigest;
+use openssl::pkey::{Id, PKey, Private};
+use openssl::rsa::Rsa;
+use openssl::sha::sha256;
+use openssl::sign::Signer;
+use serde::Serialize;
+use tracing::{debug, warn};
+
+use crate::router::DeliveryContext;
+
+/// Directory under the state dir holding the key pairs.
+pub const DKIM_KEYS_DIR: &str = "dkim_keys";
+
+/// Madmail's selector, written by `install` unless `--dkim-dual` asks for two.
+pub const DEFAULT_DKIM_SELECTOR: &str = "default";
+
+/// Header fields signed when present. `From` is listed once more than it occurs, so a second
+/// `From` added in transit breaks the signature.
+const SIGNED_HEADERS: &[&str] = &[
+    "from",
+    "reply-to",
+    "sender",
+    "subject",
+    "date",
+    "message-id",
+    "to",
+    "cc",
+    "in-reply-to",
+    "references",
+    "mime-version",
+    "content-type",
+    "content-transfer-encoding",
+    "autocrypt",
+    "chat-version",
+];
+
+/// Key type for new keys (`--dkim-algo`, `algorithm` in `/admin/dkim/rotate`).
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum DkimAlgorithm {
+    #[default]
+    Rsa2048,
+    Rsa4096,
+    /// Smaller and faster, but some large providers still do not verify it.
+    Ed25519,
+}
+
+impl DkimAlgorithm {
+    pub const ALL: [Self; 3] = [Self::Rsa2048, Self::Rsa4096, Self::Ed25519];
+
+    pub fn parse(value: &str) -> Option<Self> {
+        match value.trim().to_ascii_lowercase().as_str() {
+            "rsa2048" | "rsa" => Some(Self::Rsa2048),
+            "rsa4096" => Some(Self::Rsa4096),
+            "ed25519" => Some(Self::Ed25519),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Rsa2048 => "rsa2048",
+            Self::Rsa4096 => "rsa4096",
+            Self::Ed25519 => "ed25519",
+        }
+    }
+
+    fn generate(self) -> std::result::Result<PKey<Private>, ErrorStack> {
+        match self {
+            Self::Rsa2048 => PKey::from_rsa(Rsa::generate(2048)?),
+            Self::Rsa4096 => PKey::from_rsa(Rsa::generate(4096)?),
+            Self::Ed25519 => PKey::generate_ed25519(),
+        }
+    }
+}
+
+/// One key pair found under [`DKIM_KEYS_DIR`].
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct DkimKey {
+    pub domain: String,
+    pub selector: String,
+    /// `rsa2048`, `rsa4096`, `ed25519`, or `rsa<bits>` for other imported RSA keys.
+    pub algorithm: String,
+    /// Unix mtime of the `.key` file.
+    pub created_at: i64,
+    /// Where the record goes: `{selector}._domainkey.{domain}`.
+    pub dns_name: String,
+    /// TXT value, e.g. `v=DKIM1; k=ed25519; p=…`.
+    pub dns_value: String,
+}
+
+/// `{state_dir}/dkim_keys`
+pub fn keys_dir(state_dir: &Path) -> PathBuf {
+    state_dir.join(DKIM_KEYS_DIR)
+}
+
+fn key_path(state_dir: &Path, domain: &str, selector: &str) -> PathBuf {
+    keys_dir(state_dir).join(format!("{domain}_{selector}.key"))
+}
+
+/// Domains that get keys: the primary domain first, then the other local domains. IP literals
+/// are left out, DKIM needs a DNS name.
+pub fn signing_domains(primary_domain: &str, local_domains: Option<&str>) -> Vec<String> {
+    let mut out: Vec<String> = Vec::new();
+    let others = local_domains.unwrap_or_default().split_whitespace();
+    for domain in std::iter::once(primary_domain).chain(others) {
+        let domain = domain.trim().to_ascii_lowercase();
+        if domain.is_empty()
+            || domain.starts_with('[')
+            || domain.parse::<std::net::IpAddr>().is_ok()
+            || out.contains(&domain)
+        {
+            continue;
+        }
+        out.push(domain);
+    }
+    out
+}
+
+/// Selectors are one DNS label of letters, digits and `-` (no `_`: it separates the domain in
+/// file names).
+pub fn valid_selector(selector: &str) -> bool {
+    !selector.is_empty()
+        && selector.len() <= 63
+        && !selector.starts_with('-')
+        && selector
+            .bytes()
+            .all(|b| b.is_ascii_alphanumeric() || b == b'-')
+}
+
+fn crypto_err(e: ErrorStack) -> ChatmailError {
+    ChatmailError::Config(format!("DKIM key: {e}"))
+}
+
+/// Every readable key pair, sorted by domain and then by age (oldest first).
+pub fn list_keys(state_dir: &Path) -> Result<Vec<DkimKey>> {
+    let entries = match std::fs::read_dir(keys_dir(state_dir)) {
+        Ok(entries) => entries,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => return Err(e.into()),
+    };
+    let mut keys = Vec::new();
+    for entry in entries {
+        let path = entry?.path();
+        let Some(stem) = path
+            .file_name()
+            .and_then(|n| n.to_str())
+            .and_then(|n| n.strip_suffix(".key"))
+        else {
+            continue;
+        };
+        let Some((domain, selector)) = stem.rsplit_once('_') else {
+            continue;
+        };
+        match describe_key(state_dir, domain, selector) {
+            Ok(key) => keys.push(key),
+            Err(e) => warn!(path = %path.display(), error = %e, "skipping unreadable DKIM key"),
+        }
+    }
+    keys.sort_by(|a, b| {
+        (&a.domain, a.created_at, &a.selector).cmp(&(&b.domain, b.created_at, &b.selector))
+    });
+    Ok(keys)
+}
+
+fn describe_key(state_dir: &Path, domain: &str, selector: &str) -> Result<DkimKey> {
+    let path = key_path(state_dir, domain, selector);
+    let key = read_key(state_dir, domain, selector)?;
+    let created_at = std::fs::metadata(&path)?
+        .modified()
+        .ok()
+        .and_then(|t| t.duration_since(std::time::UNIX_EPOCH).ok())
+        .map_or(0, |d| d.as_secs() as i64);
+    // The `.dns` file is what the operator published; fall back to deriving it.
+    let dns_value = match std::fs::read_to_string(path.with_extension("dns")) {
+        Ok(v) if !v.trim().is_empty() => v.trim().to_string(),
+        _ => dns_record(&key)?,
+    };
+    Ok(DkimKey {
+        domain: domain.to_string(),
+        selector: selector.to_string(),
+        algorithm: key_algorithm(&key),
+        created_at,
+        dns_name: format!("{selector}._domainkey.{domain}"),
+        dns_value,
+    })
+}
+
+fn key_algorithm(key: &PKey<Private>) -> String {
+    match key.id() {
+        Id::ED25519 => "ed25519".into(),
+        Id::RSA => format!("rsa{}", key.bits()),
+        other => format!("{other:?}").to_ascii_lowercase(),
+    }
+}
+
+/// Private key of `selector` for `domain`.
+pub fn read_key(state_dir: &Path, domain: &str, selector: &str) -> Result<PKey<Private>> {
+    let pem = std::fs::read(key_path(state_dir, domain, selector))?;
+    PKey::private_key_from_pem(&pem).map_err(crypto_err)
+}
+
+/// TXT value publishing the public half of `key`.
+pub fn dns_record(key: &PKey<Private>) -> Result<String> {
+    match key.id() {
+        Id::RSA => {
+            let spki = key.public_key_to_der().map_err(crypto_err)?;
+            Ok(format!("v=DKIM1; k=rsa; p={}", B64.encode(spki)))
+        }
+        Id::ED25519 => {
+            let raw = key.raw_public_key().map_err(crypto_err)?;
+            Ok(format!("v=DKIM1; k=ed25519; p={}", B64.encode(raw)))
+        }
+        _ => Err(ChatmailError::Config(
+            "DKIM keys must be RSA or ed25519".into(),
+        )),
+    }
+}
+
+/// Create the `.key` (mode 0600) and `.dns` files for a new selector, owned like the state dir.
+/// An existing key is never replaced.
+pub fn generate_key(
+    state_dir: &Path,
+    domain: &str,
+    selector: &str,
+    algorithm: DkimAlgorithm,
+) -> Result<DkimKey> {
+    use std::io::Write as _;
+    use std::os::unix::fs::OpenOptionsExt;
+
+    if !valid_selector(selector) {
+        return Err(ChatmailError::Config(format!(
+            "invalid DKIM selector {selector:?}"
+        )));
+    }
+    let dir = keys_dir(state_dir);
+    std::fs::create_dir_all(&dir)?;
+    match_owner(&dir, state_dir)?;
+
+    let key = algorithm.generate().map_err(crypto_err)?;
+    let pem = key.private_key_to_pem_pkcs8().map_err(crypto_err)?;
+    let record = dns_record(&key)?;
+    let path = key_path(state_dir, domain, selector);
+    let mut file = std::fs::OpenOptions::new()
+        .write(true)
+        .create_new(true)
+        .mode(0o600)
+        .open(&path)
+        .map_err(|e| match e.kind() {
+            std::io::ErrorKind::AlreadyExists => {
+                ChatmailError::Config(format!("DKIM key {selector} for {domain} already exists"))
+            }
+            _ => e.into(),
+        })?;
+    file.write_all(&pem)?;
+    file.sync_all()?;
+    match_owner(&path, state_dir)?;
+    let dns_path = path.with_extension("dns");
+    std::fs::write(&dns_path, format!("{record}\n"))?;
+    match_owner(&dns_path, state_dir)?;
+    describe_key(state_dir, domain, selector)
+}
+
+/// Hand `path` to the state dir's owner when someone else (root running the CLI) created it.
+fn match_owner(path: &Path, state_dir: &Path) -> Result<()> {
+    use std::os::unix::fs::MetadataExt;
+
+    let owner = std::fs::metadata(state_dir)?;
+    let meta = std::fs::metadata(path)?;
+    if meta.uid() != owner.uid() || meta.gid() != owner.gid() {
+        std::os::unix::fs::chown(path, Some(owner.uid()), Some(owner.gid()))?;
+    }
+    Ok(())
+}
+
+/// Date-based selector for a rotation at `now` (`20260316`, then `20260316-2`, …), unused by
+/// every domain in `domains`.
+pub fn rotation_selector(
+    state_dir: &Path,
+    domains: &[String],
+    now: time::OffsetDateTime,
+) -> String {
+    let date = now.date();
+    let base = format!(
+        "{:04}{:02}{:02}",
+        date.year(),
+        u8::from(date.month()),
+        date.day()
+    );
+    let taken = |selector: &str| {
+        domains
+            .iter()
+            .any(|d| key_path(state_dir, d, selector).exists())
+    };
+    let mut selector = base.clone();
+    let mut n = 2;
+    while taken(&selector) {
+        selector = format!("{base}-{n}");
+        n += 1;
+    }
+    selector
+}
+
+/// Selectors outbound mail is signed with: [`settings_keys::DKIM_SELECTOR`] when set, else the
+/// configured ones.
+pub async fn active_selectors(pool: &DbPool, configured: &[String]) -> Result<Vec<String>> {
+    match get_setting(pool, settings_keys::DKIM_SELECTOR).await? {
+        Some(s) if !s.trim().is_empty() => Ok(vec![s.trim().to_string()]),
+        _ => Ok(configured.to_vec()),
+    }
+}
+
+/// `data` with one `DKIM-Signature` per active selector that has a key for the sender's domain,
+/// or `None` when nothing signs it. Failures are logged and leave the message unsigned.
+///
+/// Reading the keys and signing (up to rsa4096, once per selector) run on the blocking pool.
+pub(crate) async fn sign_outbound(
+    ctx: &DeliveryContext,
+    mail_from: &str,
+    data: &[u8],
+) -> Option<Vec<u8>> {
+    let domain = address_domain(mail_from).filter(|d| !d.starts_with('['))?;
+    if !ctx.is_local(mail_from) {
+        return None;
+    }
+    let selectors = match active_selectors(&ctx.pool, &ctx.state.dkim_selectors).await {
+        Ok(s) if s.is_empty() => return None,
+        Ok(s) => s,
+        Err(e) => {
+            warn!(error = %e, "DKIM: cannot read the active selector; sending unsigned");
+            return None;
+        }
+    };
+    let state_dir = ctx.state.mailbox_store.state_dir().to_path_buf();
+    let message = data.to_vec();
+    let signing = tokio::task::spawn_blocking(move || {
+        let now = time::OffsetDateTime::now_utc().unix_timestamp();
+        let fields = signature_fields(&state_dir, &domain, &selectors, &message, now);
+        (fields, message)
+    });
+    let (fields, mut message) = match signing.await {
+        Ok(signed) => signed,
+        Err(e) => {
+            warn!(error = %e, "DKIM: signing task failed; sending unsigned");
+            return None;
+        }
+    };
+    if fields.is_empty() {
+        return None;
+    }
+    let mut out = fields.concat().into_bytes();
+    out.append(&mut message);
+    Some(out)
+}
+
+/// One `DKIM-Signature` field per selector with a readable key for `domain`.
+fn signature_fields(
+    state_dir: &Path,
+    domain: &str,
+    selectors: &[String],
+    message: &[u8],
+    now: i64,
+) -> Vec<String> {
+    let mut fields = Vec::new();
+    for selector in selectors {
+        let path = key_path(state_dir, domain, selector);
+        let pem = match std::fs::read(&path) {
+            Ok(pem) => pem,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => {
+                debug!(domain = %domain, selector = %selector, "DKIM: no key; not signing");
+                continue;
+            }
+            Err(e) => {
+                warn!(path = %path.display(), error = %e, "DKIM: cannot read key");
+                continue;
+            }
+        };
+        let signed = PKey::private_key_from_pem(&pem)
+            .map_err(crypto_err)
+            .and_then(|key| sign_message(message, domain, selector, &key, now));
+        match signed {
+            Ok(field) => fields.push(field),
+            Err(e) => {
+                warn!(domain = %domain, selector = %selector, error = %e, "DKIM: signing failed")
+            }
+        }
+    }
+    fields
+}
+
+/// `DKIM-Signature` header field (with its CRLF) for `message`, relaxed/relaxed, timestamped
+/// `now`.
+pub fn sign_message(
+    message: &[u8],
+    domain: &str,
+    selector: &str,
+    key: &PKey<Private>,
+    now: i64,
+) -> Result<String> {
+    let algorithm = match key.id() {
+        Id::RSA => "rsa-sha256",
+        Id::ED25519 => "ed25519-sha256",
+        _ => {
+            return Err(ChatmailError::Config(
+                "DKIM keys must be RSA or ed25519".into(),
+            ))
+        }
+    };
+    let (fields, body) = header_fields(message);
+    let body_hash = B64.encode(sha256(&relaxed_body(body)));
+
+    let mut names: Vec<&str> = Vec::new();
+    for name in SIGNED_HEADERS {
+        names.extend(fields.iter().filter(|(n, _)| n == name).map(|_| *name));
+    }
+    names.push("from");
+    let mut field = format!(
+        "DKIM-Signature: v=1; a={algorithm}; c=relaxed/relaxed; d={domain};\r\n\ts={selector}; \
+         t={now}; h={};\r\n\tbh={body_hash};\r\n\tb=",
+        names.join(":")
+    );
+
+    // Each listed name takes the next unused instance from the bottom (RFC 6376 §5.4.2).
+    let mut data = Vec::new();
+    let mut used = vec![false; fields.len()];
+    for name in &names {
+        let pick = (0..fields.len())
+            .rev()
+            .find(|&i| !used[i] && fields[i].0 == *name);
+        if let Some(i) = pick {
+            used[i] = true;
+            data.extend_from_slice(&relaxed_header(fields[i].1));
+        }
+    }
+    let mut own = relaxed_header(field.as_bytes());
+    own.truncate(own.len() - 2);
+    data.extend_from_slice(&own);
+
+    let signature =
+        match key.id() {
+            Id::RSA => Signer::new(MessageDigest::sha256(), key)
+                .and_then(|mut s| s.sign_oneshot_to_vec(&data)),
+            _ => Signer::new_without_digest(key)
+                .and_then(|mut s| s.sign_oneshot_to_vec(&sha256(&data))),
+        }
+        .map_err(crypto_err)?;
+    let b = B64.encode(signature);
+    let chunks: Vec<&str> = b
+        .as_bytes()
+        .chunks(72)
+        .map(|c| std::str::from_utf8(c).unwrap_or_default())
+        .collect();
+    field.push_str(&chunks.join("\r\n\t "));
+    field.push_str("\r\n");
+    Ok(field)
+}
+
+/// Header fields as (lowercase name, raw bytes with continuation lines), and the body.
+fn header_fields(message: &[u8]) -> (Vec<(String, &[u8])>, &[u8]) {
+    let mut fields: Vec<(String, &[u8])> = Vec::new();
+    let mut start = 0;
+    let mut pos = 0;
+    while pos < message.len() {
+        let line_end = message[pos..]
+            .iter()
+            .position(|&b| b == b'\n')
+            .map_or(message.len(), |i| pos + i + 1);
+        let line = &message[pos..line_end];
+        if line == b"\r\n" || line == b"\n" {
+            return (fields, &message[line_end..]);
+        }
+        match fields.last_mut() {
+            Some(last) if matches!(line.first(), Some(b' ' | b'\t')) => {
+                last.1 = &message[start..line_end];
+            }
+            _ => {
+                let name = line
+                    .iter()
+                    .position(|&b| b == b':')
+                    .map(|i| {
+                        String::from_utf8_lossy(&line[..i])
+                            .trim()
+                            .to_ascii_lowercase()
+                    })
+                    .unwrap_or_default();
+                start = pos;
+                fields.push((name, line));
+            }
+        }
+        pos = line_end;
+    }
+    (fields, &message[message.len()..])
+}
+
+fn is_wsp(b: u8) -> bool {
+    b == b' ' || b == b'\t'
+}
+
+/// Relaxed header canonicalization (RFC 6376 §3.4.2), ending in CRLF.
+fn relaxed_header(raw: &[u8]) -> Vec<u8> {
+    let colon = raw.iter().position(|&b| b == b':').unwrap_or(raw.len());
+    let mut out = String::from_utf8_lossy(&raw[..colon])
+        .trim()
+        .to_ascii_lowercase()
+        .into_bytes();
+    out.push(b':');
+    let mut pending_space = false;
+    let value_start = out.len();
+    for &b in raw.get(colon + 1..).unwrap_or_default() {
+        match b {
+            b'\r' | b'\n' => {}
+            b if is_wsp(b) => pending_space = true,
+            b => {
+                if pending_space && out.len() > value_start {
+                    out.push(b' ');
+                }
+                pending_space = false;
+                out.push(b);
+            }
+        }
+    }
+    out.extend_from_slice(b"\r\n");
+    out
+}
+
+/// Relaxed body canonicalization (RFC 6376 §3.4.4).
+fn relaxed_body(body: &[u8]) -> Vec<u8> {
+    let mut lines: Vec<Vec<u8>> = Vec::new();
+    let mut rest = body;
+    while !rest.is_empty() {
+        let (line, next) = match rest.windows(2).position(|w| w == b"\r\n") {
+            Some(i) => (&rest[..i], &rest[i + 2..]),
+            None => (rest, &rest[rest.len()..]),
+        };
+        let mut out = Vec::with_capacity(line.len());
+        let mut pending_space = false;
+        for &b in line {
+            if is_wsp(b) {
+                pending_space = true;
+            } else {
+                if pending_space {
+                    out.push(b' ');
+                }
+                pending_space = false;
+                out.push(b);
+            }
+        }
+        lines.push(out);
+        rest = next;
+    }
+    while lines.last().is_some_and(|l| l.is_empty()) {
+        lines.pop();
+    }
+    let mut out = Vec::with_capacity(body.len());
+    for line in lines {
+        out.extend_from_slice(&line);
+        out.extend_from_slice(b"\r\n");
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use chatmail_db::init_memory_db;
+    use chatmail_state::AppState;
+    use openssl::pkey::Public;
+    use openssl::sign::Verifier;
+
+    use super::*;
+
+    const MSG: &[u8] = b"From: Alice <alice@local.test>\r\nTo: bob@remote.test\r\n\
+        Subject:  hi\r\n\tthere\r\n\r\nHello  there \r\n\r\n\r\n";
+
+    fn tag<'a>(field: &'a str, name: &str) -> &'a str {
+        field
+            .split(';')
+            .filter_map(|part| part.split_once('='))
+            .find(|(n, _)| n.trim() == name)
+            .map_or("", |(_, v)| v.trim())
+    }
+
+    /// Checks `field` against `MSG` the way a verifier does, with the public key of `record`.
+    fn verifies(field: &str, record: &str) -> bool {
+        let p = B64.decode(tag(record, "p")).unwrap();
+        let b: String = tag(field, "b").split_whitespace().collect();
+        let signature = B64.decode(b).unwrap();
+        let (fields, body) = header_fields(MSG);
+        assert_eq!(
+            tag(field, "bh"),
+            B64.encode(sha256(&relaxed_body(body))),
+            "body hash"
+        );
+        let mut data = Vec::new();
+        for name in tag(field, "h").split(':') {
+            if let Some((_, raw)) = fields.iter().rev().find(|(n, _)| n == name) {
+                data.extend_from_slice(&relaxed_header(raw));
+            }
+        }
+        let (unsigned, _) = field.split_once("\tb=").unwrap();
+        let mut own = relaxed_header(format!("{unsigned}\tb=").as_bytes());
+        own.truncate(own.len() - 2);
+        data.extend_from_slice(&own);
+        if tag(record, "k") == "ed25519" {
+            let key: PKey<Public> = PKey::public_key_from_raw_bytes(&p, Id::ED25519).unwrap();
+            let mut v = Verifier::new_without_digest(&key).unwrap();
+            v.verify_oneshot(&signature, &sha256(&data)).unwrap()
+        } else {
+            let key = PKey::public_key_from_der(&p).unwrap();
+            let mut v = Verifier::new(MessageDigest::sha256(), &key).unwrap();
+            v.verify_oneshot(&signature, &data).unwrap()
+        }
+    }
+
+    /// RFC 6376 §3.4.5 example.
+    #[test]
+    fn relaxed_canonicalization_matches_rfc_example() {
+        assert_eq!(relaxed_header(b"A: X\r\n"), b"a:X\r\n".to_vec());
+        assert_eq!(
+            relaxed_header(b"B : Y\t\r\n\tZ  \r\n"),
+            b"b:Y Z\r\n".to_vec()
+        );
+        assert_eq!(
+            relaxed_body(b" C \r\nD \t E\r\n\r\n\r\n"),
+            b" C\r\nD E\r\n".to_vec()
+        );
+        assert_eq!(relaxed_body(b""), Vec::<u8>::new());
+    }
+
+    #[test]
+    fn dns_record_matches_each_algorithm() {
+        let dir = tempfile::tempdir().unwrap();
+        for (selector, algorithm) in [
+            ("r2", DkimAlgorithm::Rsa2048),
+            ("ed", DkimAlgorithm::Ed25519),
+        ] {
+            let key = generate_key(dir.path(), "local.test", selector, algorithm).unwrap();
+            assert_eq!(key.algorithm, algorithm.as_str());
+            assert_eq!(key.dns_name, format!("{selector}._domainkey.local.test"));
+            let p = B64.decode(tag(&key.dns_value, "p")).unwrap();
+            match algorithm {
+                DkimAlgorithm::Ed25519 => {
+                    assert!(key.dns_value.starts_with("v=DKIM1; k=ed25519; p="));
+                    assert_eq!(p.len(), 32, "raw public key");
+                }
+                _ => {
+                    assert!(key.dns_value.starts_with("v=DKIM1; k=rsa; p="));
+                    let public = PKey::public_key_from_der(&p).unwrap();
+                    assert_eq!(public.bits(), 2048);
+                }
+            }
+            let dns = std::fs::read_to_string(
+                keys_dir(dir.path()).join(format!("local.test_{selector}.dns")),
+            )
+            .unwrap();
+            assert_eq!(dns.trim(), key.dns_value);
+        }
+    }
+
+    #[test]
+    fn generate_never_replaces_a_key_and_lists_what_exists() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let dir = tempfile::tempdir().unwrap();
+        let first =
+            generate_key(dir.path(), "local.test", "default", DkimAlgorithm::Ed25519).unwrap();
+        let again = generate_key(dir.path(), "local.test", "default", DkimAlgorithm::Ed25519);
+        assert!(matches!(again, Err(ChatmailError::Config(_))));
+        assert!(generate_key(dir.path(), "local.test", "a_b", DkimAlgorithm::Ed25519).is_err());
+
+        let path = keys_dir(dir.path()).join("local.test_default.key");
+        let mode = std::fs::metadata(&path).unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+        std::fs::write(keys_dir(dir.path()).join("README"), "x").unwrap();
+
+        assert_eq!(list_keys(dir.path()).unwrap(), vec![first]);
+        assert!(list_keys(&dir.path().join("missing")).unwrap().is_empty());
+    }
+
+    #[test]
+    fn signing_domains_put_the_primary_first_and_skip_ips() {
+        assert_eq!(
+            signing_domains(
+                "Example.org",
+                Some("example.org extra.org 203.0.113.7 [::1]")
+            ),
+            ["example.org", "extra.org"]
+        );
+        assert!(signing_domains("203.0.113.7", None).is_empty());
+    }
+
+    #[test]
+    fn rotation_selector_is_dated_and_unused() {
+        let dir = tempfile::tempdir().unwrap();
+        let domains = vec!["local.test".to_string(), "extra.test".to_string()];
+        let now = time::OffsetDateTime::from_unix_timestamp(1_773_662_400).unwrap();
+        assert_eq!(rotation_selector(dir.path(), &domains, now), "20260316");
+        generate_key(dir.path(), "extra.test", "20260316", DkimAlgorithm::Ed25519).unwrap();
+        assert_eq!(rotation_selector(dir.path(), &domains, now), "20260316-2");
+    }
+
+    #[test]
+    fn signatures_verify_for_rsa_and_ed25519() {
+        let dir = tempfile::tempdir().unwrap();
+        for (selector, algorithm) in [("r", DkimAlgorithm::Rsa2048), ("e", DkimAlgorithm::Ed25519)]
+        {
+            let info = generate_key(dir.path(), "local.test", selector, algorithm).unwrap();
+            let key = read_key(dir.path(), "local.test", selector).unwrap();
+            let field = sign_message(MSG, "local.test", selector, &key, 1_700_000_000).unwrap();
+            assert!(field.starts_with("DKIM-Signature: v=1; a="));
+            assert!(field.ends_with("\r\n"));
+            assert_eq!(tag(&field, "d"), "local.test");
+            assert_eq!(tag(&field, "s"), selector);
+            assert_eq!(tag(&field, "t"), "1700000000");
+            assert_eq!(tag(&field, "h"), "from:subject:to:from", "From oversigned");
+            assert!(verifies(&field, &info.dns_value), "{algorithm:?}");
+        }
+    }
+
+    #[tokio::test]
+    async fn outbound_mail_uses_the_activated_selector() {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState {
+            dkim_selectors: vec!["default".into()],
+            ..AppState::new(dir.path(), pool.clone())
+        });
+        let ctx = DeliveryContext {
+            pool: pool.clone(),
+            state: Arc::clone(&app),
+            primary_domain: "local.test".into(),
+            local_domains: chatmail_types::build_local_domains("local.test", None),
+        };
+        assert_eq!(
+            sign_outbound(&ctx, "alice@local.test", MSG).await,
+            None,
+            "no key yet"
+        );
+
+        generate_key(dir.path(), "local.test", "default", DkimAlgorithm::Ed25519).unwrap();
+        generate_key(dir.path(), "local.test", "20260316", DkimAlgorithm::Ed25519).unwrap();
+        let signed = sign_outbound(&ctx, "alice@local.test", MSG).await.unwrap();
+        assert!(signed.starts_with(b"DKIM-Signature:"));
+        assert!(signed.ends_with(MSG));
+        assert_eq!(sign_outbound(&ctx, "someone@remote.test", MSG).await, None);
+
+        chatmail_db::set_setting(&pool, settings_keys::DKIM_SELECTOR, "20260316")
+            .await
+            .unwrap();
+        let signed = sign_outbound(&ctx, "alice@local.test", MSG).await.unwrap();
+        let field = String::from_utf8_lossy(&signed[..signed.len() - MSG.len()]).to_string();
+        assert_eq!(tag(&field, "s"), "20260316");
+    }
+}
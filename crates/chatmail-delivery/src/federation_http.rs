@@ -26,6 +26,7 @@ use std::time::Duration;
 use reqwest::Client;
 
 static FEDERATION_HTTP: OnceLock<Client> = OnceLock::new();
+static FEDERATION_HTTPS_VERIFIED: OnceLock<Client> = OnceLock::new();
 
 /// Process-wide federation HTTP client (initialized on first use).
 pub fn federation_http_client() -> &'static Client {
@@ -40,6 +41,20 @@ pub fn federation_http_client() -> &'static Client {
     })
 }
 
+/// Federation client that verifies certificates against WebPKI roots — the only HTTPS
+/// `/mxdeliv` path that satisfies RFC 8689 REQUIRETLS.
+pub fn federation_https_verified_client() -> &'static Client {
+    FEDERATION_HTTPS_VERIFIED.get_or_init(|| {
+        Client::builder()
+            .https_only(true)
+            .timeout(Duration::from_secs(60))
+            .connect_timeout(Duration::from_secs(30))
+            .pool_max_idle_per_host(16)
+            .build()
+            .expect("federation verified reqwest client")
+    })
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -15,8 +15,10 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
-//! Outbound SMTP federation client (RFC 3207 STARTTLS when advertised).
+//! Outbound SMTP federation client (RFC 3207 STARTTLS when advertised, RFC 8689 REQUIRETLS,
+//! RFC 6710 MT-PRIORITY, RFC 6531 SMTPUTF8, RFC 6152 8BITMIME).
 
+use std::fmt;
 use std::sync::{Arc, OnceLock};
 use std::time::Duration;
 
@@ -30,9 +32,13 @@ use tokio_rustls::client::TlsStream;
 use tokio_rustls::TlsConnector;
 use tracing::{debug, info, warn};
 
+use crate::mail_options::BodyType;
+use crate::priority::ehlo_advertises_mt_priority;
 use crate::router::OutboundJob;
+use crate::tls_required::TlsPolicy;
 
 static FEDERATION_SMTP_TLS: OnceLock<TlsConnector> = OnceLock::new();
+static FEDERATION_SMTP_VERIFIED_TLS: OnceLock<TlsConnector> = OnceLock::new();
 
 /// Process-wide SMTP TLS client (accepts self-signed / expired peer certs, like HTTP `/mxdeliv`).
 fn federation_smtp_tls_connector() -> &'static TlsConnector {
@@ -45,6 +51,19 @@ fn federation_smtp_tls_connector() -> &'static TlsConnector {
     })
 }
 
+/// SMTP TLS client for REQUIRETLS messages: WebPKI roots, hostname checked.
+fn federation_smtp_verified_tls_connector() -> &'static TlsConnector {
+    FEDERATION_SMTP_VERIFIED_TLS.get_or_init(|| {
+        let roots = rustls::RootCertStore {
+            roots: webpki_roots::TLS_SERVER_ROOTS.to_vec(),
+        };
+        let config = rustls::ClientConfig::builder()
+            .with_root_certificates(roots)
+            .with_no_client_auth();
+        TlsConnector::from(Arc::new(config))
+    })
+}
+
 #[derive(Debug)]
 struct SkipServerVerification;
 
@@ -87,6 +106,65 @@ impl ServerCertVerifier for SkipServerVerification {
     }
 }
 
+/// SMTP fallback failure. `requiretls` marks a peer that was reached but cannot carry a
+/// REQUIRETLS message (no TLS, unverified certificate, or no `REQUIRETLS` in EHLO);
+/// `smtputf8` one that cannot take an internationalized message (no `SMTPUTF8` in EHLO).
+#[derive(Debug)]
+pub struct SmtpError {
+    pub reason: String,
+    pub requiretls: bool,
+    pub smtputf8: bool,
+}
+
+impl SmtpError {
+    fn requiretls(reason: impl Into<String>) -> Self {
+        Self {
+            requiretls: true,
+            ..Self::from(reason.into())
+        }
+    }
+
+    fn smtputf8(reason: impl Into<String>) -> Self {
+        Self {
+            smtputf8: true,
+            ..Self::from(reason.into())
+        }
+    }
+}
+
+impl From<String> for SmtpError {
+    fn from(reason: String) -> Self {
+        Self {
+            reason,
+            requiretls: false,
+            smtputf8: false,
+        }
+    }
+}
+
+impl fmt::Display for SmtpError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(&self.reason)
+    }
+}
+
+/// TLS requirements and client for one delivery attempt.
+struct SmtpTls<'a> {
+    policy: TlsPolicy,
+    connector: &'a TlsConnector,
+}
+
+impl SmtpTls<'static> {
+    fn for_policy(policy: TlsPolicy) -> Self {
+        let connector = if policy.is_required() {
+            federation_smtp_verified_tls_connector()
+        } else {
+            federation_smtp_tls_connector()
+        };
+        Self { policy, connector }
+    }
+}
+
 enum SmtpTransport {
     Plain(TcpStream),
     Tls(Box<TlsStream<TcpStream>>),
@@ -116,7 +194,14 @@ impl SmtpTransport {
 /// classic Chatmail relays (`nine.testrun.org`, etc.) that expose SMTP only on :443.
 ///
 /// `helo_name` is this server's identity for EHLO (primary_domain / public IP), not the remote host.
-pub async fn deliver(host: &str, job: &OutboundJob, helo_name: &str) -> Result<(), String> {
+/// Under [`TlsPolicy::Required`] both ports insist on a verified certificate and a peer that
+/// advertises REQUIRETLS; [`TlsPolicy::Relaxed`] retries :25 in cleartext if STARTTLS fails.
+pub async fn deliver(
+    host: &str,
+    job: &OutboundJob,
+    helo_name: &str,
+    policy: TlsPolicy,
+) -> Result<(), SmtpError> {
     let connect_host = host.trim_matches(|c| c == '[' || c == ']');
     let helo = if helo_name.trim().is_empty() {
         connect_host
@@ -129,8 +214,11 @@ pub async fn deliver(host: &str, job: &OutboundJob, helo_name: &str) -> Result<(
         .map(|(_, d)| d)
         .unwrap_or(connect_host);
 
+    let tls = SmtpTls::for_policy(policy);
     let endpoint25 = format!("{connect_host}:25");
-    match deliver_plain_starttls(&endpoint25, connect_host, rcpt_domain, helo, job).await {
+    let e25 = match deliver_plain_starttls(&endpoint25, connect_host, rcpt_domain, helo, job, &tls)
+        .await
+    {
         Ok(()) => {
             info!(endpoint = %endpoint25, rcpt = %job.rcpt_to, "federation: SMTP delivery ok (port 25)");
             return Ok(());
@@ -142,13 +230,18 @@ pub async fn deliver(host: &str, job: &OutboundJob, helo_name: &str) -> Result<(
                 error = %e25,
                 "federation: SMTP :25 failed, trying implicit TLS :443"
             );
+            e25
         }
-    }
+    };
 
     let endpoint443 = format!("{connect_host}:443");
-    deliver_implicit_tls(&endpoint443, connect_host, rcpt_domain, helo, job)
+    deliver_implicit_tls(&endpoint443, connect_host, rcpt_domain, helo, job, &tls)
         .await
-        .map_err(|e443| format!("smtp :25 failed; smtp :443 tls: {e443}"))
+        .map_err(|e443| SmtpError {
+            reason: format!("smtp :25: {e25}; smtp :443 tls: {e443}"),
+            requiretls: e25.requiretls || e443.requiretls,
+            smtputf8: e25.smtputf8 || e443.smtputf8,
+        })
 }
 
 /// Plain SMTP on :25 with optional STARTTLS (RFC 3207).
@@ -158,7 +251,60 @@ async fn deliver_plain_starttls(
     rcpt_domain: &str,
     helo_name: &str,
     job: &OutboundJob,
-) -> Result<(), String> {
+    tls: &SmtpTls<'_>,
+) -> Result<(), SmtpError> {
+    let (mut transport, ehlo_plain) = open_plain_session(endpoint, helo_name).await?;
+
+    if !ehlo_advertises_starttls(&ehlo_plain) {
+        if tls.policy.is_required() {
+            return Err(SmtpError::requiretls("peer does not offer STARTTLS"));
+        }
+        return run_smtp_transaction(&mut transport, job, false, &ehlo_plain).await;
+    }
+
+    debug!(endpoint, "federation: SMTP STARTTLS upgrade");
+    transport.write_all(b"STARTTLS\r\n").await?;
+    read_smtp_reply(&mut transport, 220).await?;
+
+    let SmtpTransport::Plain(stream) = transport else {
+        return Err("smtp starttls: expected plain transport".to_string().into());
+    };
+    let server_name = smtp_tls_server_name(connect_host, rcpt_domain)?;
+    let handshake = tokio::time::timeout(
+        Duration::from_secs(30),
+        tls.connector.connect(server_name, stream),
+    )
+    .await
+    .map_err(|_| "smtp starttls timeout".to_string())?;
+    let tls_stream = match handshake {
+        Ok(s) => s,
+        Err(e) if tls.policy == TlsPolicy::Relaxed => {
+            // RFC 8689 §5: `TLS-Required: No` lets the message go out without TLS.
+            warn!(endpoint, error = %e, "federation: STARTTLS failed, TLS-Required: No, retrying in cleartext");
+            let (mut transport, ehlo) = open_plain_session(endpoint, helo_name).await?;
+            return run_smtp_transaction(&mut transport, job, false, &ehlo).await;
+        }
+        Err(e) if tls.policy.is_required() => {
+            return Err(SmtpError::requiretls(format!("smtp starttls: {e}")));
+        }
+        Err(e) => return Err(format!("smtp starttls: {e}").into()),
+    };
+
+    transport = SmtpTransport::Tls(Box::new(tls_stream));
+    transport.write_all(format!("EHLO {helo_name}\r\n")).await?;
+    let ehlo_tls = read_smtp_reply(&mut transport, 250).await?;
+    if tls.policy.is_required() && !ehlo_advertises_requiretls(&ehlo_tls) {
+        return Err(SmtpError::requiretls("peer does not advertise REQUIRETLS"));
+    }
+
+    run_smtp_transaction(&mut transport, job, tls.policy.is_required(), &ehlo_tls).await
+}
+
+/// Connect in cleartext, read the banner and send EHLO; returns the EHLO reply.
+async fn open_plain_session(
+    endpoint: &str,
+    helo_name: &str,
+) -> Result<(SmtpTransport, String), String> {
     debug!(endpoint, "federation: SMTP plain connect");
 
     let stream = tokio::time::timeout(Duration::from_secs(30), TcpStream::connect(endpoint))
@@ -170,31 +316,8 @@ async fn deliver_plain_starttls(
     read_smtp_reply(&mut transport, 220).await?;
 
     transport.write_all(format!("EHLO {helo_name}\r\n")).await?;
-    let ehlo_plain = read_smtp_reply(&mut transport, 250).await?;
-
-    if ehlo_advertises_starttls(&ehlo_plain) {
-        debug!(endpoint, "federation: SMTP STARTTLS upgrade");
-        transport.write_all(b"STARTTLS\r\n").await?;
-        read_smtp_reply(&mut transport, 220).await?;
-
-        let SmtpTransport::Plain(stream) = transport else {
-            return Err("smtp starttls: expected plain transport".into());
-        };
-        let server_name = smtp_tls_server_name(connect_host, rcpt_domain)?;
-        let tls_stream = tokio::time::timeout(
-            Duration::from_secs(30),
-            federation_smtp_tls_connector().connect(server_name, stream),
-        )
-        .await
-        .map_err(|_| "smtp starttls timeout".to_string())?
-        .map_err(|e| format!("smtp starttls: {e}"))?;
-
-        transport = SmtpTransport::Tls(Box::new(tls_stream));
-        transport.write_all(format!("EHLO {helo_name}\r\n")).await?;
-        read_smtp_reply(&mut transport, 250).await?;
-    }
-
-    run_smtp_transaction(&mut transport, job).await
+    let ehlo = read_smtp_reply(&mut transport, 250).await?;
+    Ok((transport, ehlo))
 }
 
 /// Implicit TLS on :443 (Chatmail / Delta Chat relay SMTP submission style).
@@ -204,7 +327,8 @@ async fn deliver_implicit_tls(
     rcpt_domain: &str,
     helo_name: &str,
     job: &OutboundJob,
-) -> Result<(), String> {
+    tls: &SmtpTls<'_>,
+) -> Result<(), SmtpError> {
     info!(endpoint, rcpt = %job.rcpt_to, "federation: SMTP implicit TLS connect");
 
     let stream = tokio::time::timeout(Duration::from_secs(30), TcpStream::connect(endpoint))
@@ -215,28 +339,61 @@ async fn deliver_implicit_tls(
     let server_name = smtp_tls_server_name(connect_host, rcpt_domain)?;
     let tls_stream = tokio::time::timeout(
         Duration::from_secs(30),
-        federation_smtp_tls_connector().connect(server_name, stream),
+        tls.connector.connect(server_name, stream),
     )
     .await
     .map_err(|_| "smtp tls handshake timeout".to_string())?
-    .map_err(|e| format!("smtp tls handshake: {e}"))?;
+    .map_err(|e| {
+        let reason = format!("smtp tls handshake: {e}");
+        if tls.policy.is_required() {
+            SmtpError::requiretls(reason)
+        } else {
+            reason.into()
+        }
+    })?;
 
     let mut transport = SmtpTransport::Tls(Box::new(tls_stream));
     read_smtp_reply(&mut transport, 220).await?;
     transport.write_all(format!("EHLO {helo_name}\r\n")).await?;
-    read_smtp_reply(&mut transport, 250).await?;
+    let ehlo = read_smtp_reply(&mut transport, 250).await?;
+    if tls.policy.is_required() && !ehlo_advertises_requiretls(&ehlo) {
+        return Err(SmtpError::requiretls("peer does not advertise REQUIRETLS"));
+    }
 
-    run_smtp_transaction(&mut transport, job).await?;
+    run_smtp_transaction(&mut transport, job, tls.policy.is_required(), &ehlo).await?;
     info!(endpoint, rcpt = %job.rcpt_to, "federation: SMTP delivery ok (port 443 TLS)");
     Ok(())
 }
 
+/// `ehlo` is the reply the MAIL parameters are negotiated against; `MT-PRIORITY` is only sent
+/// to a next hop that advertises it, and only for a non-default priority. A message that
+/// needs SMTPUTF8 fails before MAIL when the hop lacks it: there is no downgrade.
 async fn run_smtp_transaction(
     transport: &mut SmtpTransport,
     job: &OutboundJob,
-) -> Result<(), String> {
+    require_tls: bool,
+    ehlo: &str,
+) -> Result<(), SmtpError> {
+    let mut params = String::new();
+    if require_tls {
+        params.push_str(" REQUIRETLS");
+    }
+    if job.options.smtputf8 && ehlo_advertises_keyword(ehlo, "SMTPUTF8") {
+        params.push_str(" SMTPUTF8");
+    } else if job
+        .options
+        .needs_smtputf8(&job.mail_from, &job.rcpt_to, &job.data)
+    {
+        return Err(SmtpError::smtputf8("peer does not advertise SMTPUTF8"));
+    }
+    if job.options.body == BodyType::EightBitMime && ehlo_advertises_keyword(ehlo, "8BITMIME") {
+        params.push_str(" BODY=8BITMIME");
+    }
+    if job.priority != 0 && ehlo_advertises_mt_priority(ehlo) {
+        params.push_str(&format!(" MT-PRIORITY={}", job.priority));
+    }
     transport
-        .write_all(format!("MAIL FROM:<{}>\r\n", job.mail_from))
+        .write_all(format!("MAIL FROM:<{}>{params}\r\n", job.mail_from))
         .await?;
     read_smtp_reply(transport, 250).await?;
 
@@ -260,6 +417,28 @@ async fn run_smtp_transaction(
     Ok(())
 }
 
+/// One plain-SMTP attempt to a fixed `host:port` (STARTTLS when offered, no MX lookup and no
+/// :443 fallback). Used for `smtp://` archive targets and `smtp://` endpoint overrides.
+pub(crate) async fn deliver_to_host(
+    endpoint: &str,
+    helo_name: &str,
+    job: &OutboundJob,
+    policy: TlsPolicy,
+) -> Result<(), SmtpError> {
+    let connect_host = endpoint
+        .rsplit_once(':')
+        .map(|(h, _)| h)
+        .unwrap_or(endpoint)
+        .trim_matches(|c| c == '[' || c == ']');
+    let rcpt_domain = job
+        .rcpt_to
+        .rsplit_once('@')
+        .map(|(_, d)| d)
+        .unwrap_or(connect_host);
+    let tls = SmtpTls::for_policy(policy);
+    deliver_plain_starttls(endpoint, connect_host, rcpt_domain, helo_name, job, &tls).await
+}
+
 /// Legacy name used by unit tests.
 #[cfg(test)]
 async fn deliver_to_endpoint(
@@ -267,8 +446,9 @@ async fn deliver_to_endpoint(
     connect_host: &str,
     rcpt_domain: &str,
     job: &OutboundJob,
-) -> Result<(), String> {
-    deliver_plain_starttls(endpoint, connect_host, rcpt_domain, connect_host, job).await
+) -> Result<(), SmtpError> {
+    let tls = SmtpTls::for_policy(TlsPolicy::Opportunistic);
+    deliver_plain_starttls(endpoint, connect_host, rcpt_domain, connect_host, job, &tls).await
 }
 
 fn smtp_tls_server_name(
@@ -291,6 +471,22 @@ fn ehlo_advertises_starttls(ehlo_response: &str) -> bool {
     })
 }
 
+/// RFC 8689 §4.1: the extension keyword on its own EHLO line.
+fn ehlo_advertises_requiretls(ehlo_response: &str) -> bool {
+    ehlo_advertises_keyword(ehlo_response, "REQUIRETLS")
+}
+
+/// Whether an EHLO reply lists `keyword` as an extension (first word of a `250` line).
+fn ehlo_advertises_keyword(ehlo_response: &str, keyword: &str) -> bool {
+    ehlo_response.lines().any(|line| {
+        line.starts_with("250")
+            && line
+                .get(4..)
+                .and_then(|rest| rest.split_whitespace().next())
+                .is_some_and(|kw| kw.eq_ignore_ascii_case(keyword))
+    })
+}
+
 async fn read_smtp_reply(
     transport: &mut SmtpTransport,
     expect_code: u16,
@@ -362,9 +558,124 @@ mod tests {
     use rcgen::generate_simple_self_signed;
     use rustls::pki_types::{CertificateDer, PrivateKeyDer};
     use rustls::{ClientConfig, RootCertStore, ServerConfig};
+    use tokio::io::{AsyncBufReadExt, BufReader};
     use tokio::net::TcpListener;
 
     use super::*;
+    use crate::mail_options::MailOptions;
+
+    async fn deliver_with_policy(
+        endpoint: &str,
+        job: &OutboundJob,
+        policy: TlsPolicy,
+        connector: &TlsConnector,
+    ) -> Result<(), SmtpError> {
+        let tls = SmtpTls { policy, connector };
+        deliver_plain_starttls(endpoint, "localhost", "test", "client.test", job, &tls).await
+    }
+
+    /// Real inbound session on loopback; returns its address.
+    async fn spawn_smtp_session(starttls_config: Option<Arc<ServerConfig>>) -> String {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState::new(std::env::temp_dir(), pool.clone()));
+        let cfg = SmtpSessionConfig {
+            hostname: "mx.test".into(),
+            primary_domain: "test".into(),
+            local_domains: vec!["test".into()],
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            require_auth: false,
+            module: "smtp",
+            starttls_config,
+        };
+        let std_listener = StdListener::bind("127.0.0.1:0").unwrap();
+        std_listener.set_nonblocking(true).unwrap();
+        let addr = std_listener.local_addr().unwrap();
+        tokio::spawn(async move {
+            let listener = TcpListener::from_std(std_listener).unwrap();
+            let (stream, _) = listener.accept().await.unwrap();
+            let mut session = SmtpSession::new(ctx, pool, cfg);
+            let _ = session.handle_connection(stream).await;
+        });
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        addr.to_string()
+    }
+
+    /// Scripted plaintext peer. With `broken_starttls` the first connection offers
+    /// STARTTLS and then hangs up instead of a handshake; later ones are cleartext only.
+    /// `mt_priority` adds the RFC 6710 keyword to every EHLO reply.
+    /// Returns the address and every `MAIL` line received.
+    async fn spawn_plaintext_mock(
+        broken_starttls: bool,
+        mt_priority: bool,
+    ) -> (String, Arc<std::sync::Mutex<Vec<String>>>) {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap().to_string();
+        let mail_lines = Arc::new(std::sync::Mutex::new(Vec::new()));
+        let seen = Arc::clone(&mail_lines);
+        tokio::spawn(async move {
+            let mut conn = 0;
+            while let Ok((stream, _)) = listener.accept().await {
+                let offer_starttls = broken_starttls && conn == 0;
+                conn += 1;
+                let (r, mut w) = stream.into_split();
+                let mut lines = BufReader::new(r).lines();
+                w.write_all(b"220 mock ESMTP\r\n").await.unwrap();
+                let mut in_data = false;
+                while let Ok(Some(line)) = lines.next_line().await {
+                    if in_data {
+                        if line == "." {
+                            in_data = false;
+                            w.write_all(b"250 2.0.0 queued\r\n").await.unwrap();
+                        }
+                        continue;
+                    }
+                    let upper = line.to_ascii_uppercase();
+                    let reply: &[u8] = if upper.starts_with("EHLO") {
+                        match (offer_starttls, mt_priority) {
+                            (true, true) => b"250-mock\r\n250-MT-PRIORITY\r\n250 STARTTLS\r\n",
+                            (true, false) => b"250-mock\r\n250 STARTTLS\r\n",
+                            (false, true) => b"250-mock\r\n250 MT-PRIORITY\r\n",
+                            (false, false) => b"250 mock\r\n",
+                        }
+                    } else if upper.starts_with("STARTTLS") {
+                        w.write_all(b"220 go ahead\r\n").await.unwrap();
+                        break;
+                    } else if upper.starts_with("MAIL") {
+                        seen.lock().unwrap().push(line.clone());
+                        b"250 OK\r\n"
+                    } else if upper.starts_with("DATA") {
+                        in_data = true;
+                        b"354 go\r\n"
+                    } else if upper.starts_with("QUIT") {
+                        w.write_all(b"221 bye\r\n").await.unwrap();
+                        break;
+                    } else {
+                        b"250 OK\r\n"
+                    };
+                    w.write_all(reply).await.unwrap();
+                }
+            }
+        });
+        (addr, mail_lines)
+    }
+
+    fn pgp_job(require_tls: bool) -> OutboundJob {
+        let data = std::str::from_utf8(PGP_MIME_BODY)
+            .unwrap()
+            .replace("sender@test", "sender@other.test")
+            .into_bytes();
+        OutboundJob {
+            mail_from: "sender@other.test".into(),
+            rcpt_to: "rcpt@test".into(),
+            data,
+            options: MailOptions {
+                require_tls,
+                ..MailOptions::default()
+            },
+            priority: 0,
+        }
+    }
 
     #[test]
     fn ehlo_detects_starttls_extension() {
@@ -373,6 +684,15 @@ mod tests {
         assert!(!ehlo_advertises_starttls("250-mx.test\r\n250 OK\r\n"));
     }
 
+    #[test]
+    fn ehlo_detects_requiretls_extension() {
+        assert!(ehlo_advertises_requiretls(
+            "250-mx.test\r\n250-REQUIRETLS\r\n250 OK\r\n"
+        ));
+        assert!(ehlo_advertises_requiretls("250 requiretls\r\n"));
+        assert!(!ehlo_advertises_requiretls("250-mx.test\r\n250 OK\r\n"));
+    }
+
     fn loopback_tls_configs() -> (Arc<ServerConfig>, Arc<ClientConfig>) {
         let rc = generate_simple_self_signed(vec!["localhost".into()]).unwrap();
         let cert = CertificateDer::from(rc.cert.der().to_vec());
@@ -433,10 +753,198 @@ mod tests {
             mail_from: "sender@other.test".into(),
             rcpt_to: "rcpt@test".into(),
             data,
+            options: MailOptions::default(),
+            priority: 0,
         };
 
         deliver_to_endpoint(&addr.to_string(), "mx.test", "test", &job)
             .await
             .expect("STARTTLS SMTP federation delivery");
     }
+
+    /// RFC 8689: verified STARTTLS to a peer advertising REQUIRETLS carries the parameter.
+    #[tokio::test]
+    async fn requiretls_delivers_over_verified_starttls() {
+        let (tls_server, tls_client) = loopback_tls_configs();
+        let addr = spawn_smtp_session(Some(tls_server)).await;
+        let connector = TlsConnector::from(tls_client);
+
+        deliver_with_policy(&addr, &pgp_job(true), TlsPolicy::Required, &connector)
+            .await
+            .expect("REQUIRETLS delivery over verified STARTTLS");
+    }
+
+    /// An untrusted certificate does not satisfy REQUIRETLS.
+    #[tokio::test]
+    async fn requiretls_refuses_unverified_certificate() {
+        let (tls_server, _) = loopback_tls_configs();
+        let (_, other_client) = loopback_tls_configs();
+        let addr = spawn_smtp_session(Some(tls_server)).await;
+        let connector = TlsConnector::from(other_client);
+
+        let err = deliver_with_policy(&addr, &pgp_job(true), TlsPolicy::Required, &connector)
+            .await
+            .unwrap_err();
+        assert!(err.requiretls, "{err}");
+    }
+
+    /// A cleartext-only peer fails REQUIRETLS permanently without sending MAIL.
+    #[tokio::test]
+    async fn requiretls_refuses_plaintext_peer() {
+        let (addr, mail_lines) = spawn_plaintext_mock(false, false).await;
+        let err = deliver_with_policy(
+            &addr,
+            &pgp_job(true),
+            TlsPolicy::Required,
+            federation_smtp_verified_tls_connector(),
+        )
+        .await
+        .unwrap_err();
+        assert!(err.requiretls, "{err}");
+        assert!(mail_lines.lock().unwrap().is_empty());
+    }
+
+    /// `TLS-Required: No`: a failed STARTTLS handshake falls back to cleartext.
+    #[tokio::test]
+    async fn tls_required_no_falls_back_to_cleartext() {
+        let (addr, mail_lines) = spawn_plaintext_mock(true, false).await;
+        deliver_with_policy(
+            &addr,
+            &pgp_job(false),
+            TlsPolicy::Relaxed,
+            federation_smtp_tls_connector(),
+        )
+        .await
+        .expect("cleartext retry after broken STARTTLS");
+        let lines = mail_lines.lock().unwrap();
+        assert_eq!(lines.len(), 1);
+        assert!(!lines[0].to_ascii_uppercase().contains("REQUIRETLS"));
+    }
+
+    /// RFC 6710: the priority goes out only when the next hop advertises MT-PRIORITY.
+    #[tokio::test]
+    async fn mt_priority_sent_only_when_advertised() {
+        let mut job = pgp_job(false);
+        job.priority = crate::priority::SECURE_JOIN_PRIORITY;
+
+        for advertised in [true, false] {
+            let (addr, mail_lines) = spawn_plaintext_mock(false, advertised).await;
+            deliver_with_policy(
+                &addr,
+                &job,
+                TlsPolicy::Opportunistic,
+                federation_smtp_tls_connector(),
+            )
+            .await
+            .expect("cleartext delivery");
+            let lines = mail_lines.lock().unwrap();
+            assert_eq!(lines.len(), 1);
+            assert_eq!(
+                lines[0].contains(" MT-PRIORITY=6"),
+                advertised,
+                "advertised={advertised}: {}",
+                lines[0]
+            );
+        }
+
+        // The default priority is never spelled out.
+        let (addr, mail_lines) = spawn_plaintext_mock(false, true).await;
+        deliver_with_policy(
+            &addr,
+            &pgp_job(false),
+            TlsPolicy::Opportunistic,
+            federation_smtp_tls_connector(),
+        )
+        .await
+        .expect("cleartext delivery");
+        assert!(!mail_lines.lock().unwrap()[0].contains("MT-PRIORITY"));
+    }
+
+    /// Our inbound session advertises MT-PRIORITY, so a trusted loopback hop gets it.
+    #[tokio::test]
+    async fn mt_priority_round_trip_against_inbound_session() {
+        let addr = spawn_smtp_session(None).await;
+        let mut job = pgp_job(false);
+        job.priority = 3;
+        deliver_to_endpoint(&addr, "mx.test", "test", &job)
+            .await
+            .expect("MT-PRIORITY accepted by the inbound session");
+    }
+
+    fn utf8_job(rcpt_to: &str) -> OutboundJob {
+        let mut job = pgp_job(false);
+        job.rcpt_to = rcpt_to.into();
+        job.data = [b"Subject: Gr\xc3\xbc\xc3\x9fe\r\n".as_slice(), &job.data].concat();
+        job.options.smtputf8 = true;
+        job.options.body = BodyType::EightBitMime;
+        job
+    }
+
+    /// RFC 6531: an internationalized message is never downgraded; a hop without SMTPUTF8
+    /// fails it before MAIL.
+    #[tokio::test]
+    async fn smtputf8_message_fails_without_peer_support() {
+        let (addr, mail_lines) = spawn_plaintext_mock(false, false).await;
+        let err = deliver_with_policy(
+            &addr,
+            &utf8_job("j\u{f6}rg@test"),
+            TlsPolicy::Opportunistic,
+            federation_smtp_tls_connector(),
+        )
+        .await
+        .unwrap_err();
+        assert!(err.smtputf8, "{err}");
+        assert!(!err.requiretls);
+        assert!(mail_lines.lock().unwrap().is_empty());
+    }
+
+    /// The SMTPUTF8 flag alone does not need the extension when nothing is non-ASCII, and
+    /// unadvertised parameters are not sent.
+    #[tokio::test]
+    async fn ascii_message_with_smtputf8_flag_reaches_legacy_peer() {
+        let (addr, mail_lines) = spawn_plaintext_mock(false, false).await;
+        let mut job = pgp_job(false);
+        job.options.smtputf8 = true;
+        job.options.body = BodyType::EightBitMime;
+        deliver_with_policy(
+            &addr,
+            &job,
+            TlsPolicy::Opportunistic,
+            federation_smtp_tls_connector(),
+        )
+        .await
+        .expect("cleartext delivery");
+        let lines = mail_lines.lock().unwrap();
+        assert_eq!(lines.len(), 1);
+        assert!(!lines[0].contains("SMTPUTF8"), "{}", lines[0]);
+        assert!(!lines[0].contains("BODY="), "{}", lines[0]);
+    }
+
+    /// Our inbound session advertises SMTPUTF8 and 8BITMIME: a unicode local part and an
+    /// 8-bit body go through.
+    #[tokio::test]
+    async fn smtputf8_round_trip_against_inbound_session() {
+        let addr = spawn_smtp_session(None).await;
+        let mut job = utf8_job("j\u{f6}rg@test");
+        job.data.extend_from_slice(b"caf\xe9\r\n");
+        deliver_to_endpoint(&addr, "mx.test", "test", &job)
+            .await
+            .expect("SMTPUTF8 message accepted by the inbound session");
+    }
+
+    /// Without the header a broken STARTTLS stays a (temporary) failure.
+    #[tokio::test]
+    async fn opportunistic_does_not_downgrade_after_starttls_failure() {
+        let (addr, mail_lines) = spawn_plaintext_mock(true, false).await;
+        let err = deliver_with_policy(
+            &addr,
+            &pgp_job(false),
+            TlsPolicy::Opportunistic,
+            federation_smtp_tls_connector(),
+        )
+        .await
+        .unwrap_err();
+        assert!(!err.requiretls, "{err}");
+        assert!(mail_lines.lock().unwrap().is_empty());
+    }
 }
@@ -15,12 +15,25 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+mod archive;
+pub mod broadcast;
+pub mod catchall;
+pub mod dkim;
 mod federation_http;
 mod federation_smtp;
+pub mod mail_options;
+pub mod priority;
 pub mod queue;
+pub mod responder;
 pub mod router;
+pub mod sieve;
+pub mod subaddress;
+pub mod tls_required;
 pub mod transport;
 
+pub use mail_options::MailOptions;
 pub use queue::{OutboundQueue, QueueConfig, QueueStore};
 pub use router::{outbound_queue, start_outbound_queue, DeliveryContext, OutboundJob};
+pub use sieve::{parse_sieve, SieveOutcome, SieveScript};
+pub use tls_required::TlsPolicy;
 pub use transport::DeliveryOutcome;
@@ -0,0 +1,206 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `MAIL FROM` parameters that travel with a message to the next hop: RFC 8689 REQUIRETLS,
+//! RFC 6531 SMTPUTF8 and the RFC 6152 `BODY=8BITMIME` declaration.
+//!
+//! The SMTP session parses them once into [`MailOptions`]; the queue stores them on every
+//! entry and the worker replays them, as ESMTP parameters over SMTP and as the
+//! [`MAIL_OPTIONS_HEADER`] on `/mxdeliv`, where the receiving server restores them. A message
+//! that needs SMTPUTF8 is never downgraded: a hop without the extension fails it with
+//! [`SMTPUTF8_FAILURE`].
+
+use serde::{Deserialize, Serialize};
+
+use crate::tls_required::mail_from_requires_tls;
+
+/// Enhanced status text for an internationalized message the next hop cannot take (RFC 6531).
+pub const SMTPUTF8_FAILURE: &str = "5.6.7 SMTPUTF8 support required";
+
+/// `/mxdeliv` request header carrying [`MailOptions::header_value`].
+pub const MAIL_OPTIONS_HEADER: &str = "X-Mail-Options";
+
+/// The `BODY=` declaration of a transaction. `BINARYMIME` is not offered (no CHUNKING).
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub enum BodyType {
+    #[default]
+    #[serde(rename = "7BIT")]
+    SevenBit,
+    #[serde(rename = "8BITMIME")]
+    EightBitMime,
+}
+
+/// Envelope options of one message, as declared on `MAIL FROM`.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct MailOptions {
+    /// RFC 8689 REQUIRETLS: relay only over verified TLS.
+    pub require_tls: bool,
+    /// RFC 6531 SMTPUTF8: addresses and headers may carry UTF-8.
+    pub smtputf8: bool,
+    pub body: BodyType,
+}
+
+impl MailOptions {
+    /// Parse the ESMTP parameters of a `MAIL FROM` command line. An unknown `BODY=` value
+    /// is an error; other parameters are left to their own parsers.
+    pub fn from_mail_from(line: &str) -> Result<Self, String> {
+        let mut options = Self {
+            require_tls: mail_from_requires_tls(line),
+            ..Self::default()
+        };
+        for param in mail_params(line).split_whitespace() {
+            options.apply(param)?;
+        }
+        Ok(options)
+    }
+
+    /// Restore options from an `X-Mail-Options` value (the keywords of [`Self::header_value`]).
+    pub fn from_header(value: &str) -> Result<Self, String> {
+        let mut options = Self::default();
+        for param in value.split_whitespace() {
+            if param.eq_ignore_ascii_case("REQUIRETLS") {
+                options.require_tls = true;
+            } else {
+                options.apply(param)?;
+            }
+        }
+        Ok(options)
+    }
+
+    /// `X-Mail-Options` value for `/mxdeliv`, or `None` when every option is at its default.
+    pub fn header_value(&self) -> Option<String> {
+        let mut params = Vec::new();
+        if self.require_tls {
+            params.push("REQUIRETLS");
+        }
+        if self.smtputf8 {
+            params.push("SMTPUTF8");
+        }
+        if self.body == BodyType::EightBitMime {
+            params.push("BODY=8BITMIME");
+        }
+        (!params.is_empty()).then(|| params.join(" "))
+    }
+
+    /// Whether this message can only be relayed to a hop that supports SMTPUTF8: it was
+    /// submitted with the parameter and its envelope or header section is not plain ASCII.
+    pub fn needs_smtputf8(&self, mail_from: &str, rcpt_to: &str, data: &[u8]) -> bool {
+        self.smtputf8
+            && (!mail_from.is_ascii() || !rcpt_to.is_ascii() || !header_section(data).is_ascii())
+    }
+
+    fn apply(&mut self, param: &str) -> Result<(), String> {
+        if param.eq_ignore_ascii_case("SMTPUTF8") {
+            self.smtputf8 = true;
+        } else if let Some(value) = param
+            .get(..5)
+            .filter(|k| k.eq_ignore_ascii_case("BODY="))
+            .map(|_| &param[5..])
+        {
+            self.body = if value.eq_ignore_ascii_case("7BIT") {
+                BodyType::SevenBit
+            } else if value.eq_ignore_ascii_case("8BITMIME") {
+                BodyType::EightBitMime
+            } else {
+                return Err(format!("unsupported BODY={value}"));
+            };
+        }
+        Ok(())
+    }
+}
+
+/// Whether `addr` can go into a transaction declared with (or without) SMTPUTF8: a non-ASCII
+/// mailbox needs the extension (RFC 6531).
+pub fn address_allowed(addr: &str, smtputf8: bool) -> bool {
+    smtputf8 || addr.is_ascii()
+}
+
+/// The parameter part of a `MAIL FROM` line, after the closing `>` of the path.
+fn mail_params(line: &str) -> &str {
+    match line.find('>') {
+        Some(i) => &line[i + 1..],
+        None => line.split_once(char::is_whitespace).map_or("", |(_, r)| r),
+    }
+}
+
+/// The top-level header section (up to the first empty line).
+fn header_section(data: &[u8]) -> &[u8] {
+    let end = data
+        .windows(4)
+        .position(|w| w == b"\r\n\r\n")
+        .or_else(|| data.windows(2).position(|w| w == b"\n\n"))
+        .unwrap_or(data.len());
+    &data[..end]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_mail_from_parameters() {
+        let o = MailOptions::from_mail_from("MAIL FROM:<a@x> SMTPUTF8 BODY=8BITMIME").unwrap();
+        assert!(o.smtputf8);
+        assert_eq!(o.body, BodyType::EightBitMime);
+        assert!(!o.require_tls);
+
+        let o = MailOptions::from_mail_from("MAIL FROM:<a@x> body=7bit REQUIRETLS").unwrap();
+        assert_eq!(o.body, BodyType::SevenBit);
+        assert!(o.require_tls);
+        assert!(!o.smtputf8);
+
+        assert_eq!(
+            MailOptions::from_mail_from("MAIL FROM:<smtputf8@x> SIZE=10").unwrap(),
+            MailOptions::default()
+        );
+        assert!(MailOptions::from_mail_from("MAIL FROM:<a@x> BODY=BINARYMIME").is_err());
+    }
+
+    #[test]
+    fn header_round_trips() {
+        assert_eq!(MailOptions::default().header_value(), None);
+        let o = MailOptions {
+            require_tls: true,
+            smtputf8: true,
+            body: BodyType::EightBitMime,
+        };
+        let value = o.header_value().unwrap();
+        assert_eq!(value, "REQUIRETLS SMTPUTF8 BODY=8BITMIME");
+        assert_eq!(MailOptions::from_header(&value).unwrap(), o);
+        assert!(MailOptions::from_header("BODY=9BIT").is_err());
+    }
+
+    #[test]
+    fn smtputf8_needed_only_for_non_ascii_envelope_or_headers() {
+        let utf8 = MailOptions {
+            smtputf8: true,
+            ..MailOptions::default()
+        };
+        let ascii = b"From: a@x\r\nSubject: hi\r\n\r\nk\xc3\xa4se\r\n";
+        assert!(!utf8.needs_smtputf8("a@x", "b@y", ascii));
+        assert!(utf8.needs_smtputf8("a@x", "j\u{f6}rg@y", ascii));
+        assert!(utf8.needs_smtputf8("a@x", "b@y", "Subject: gr\u{fc}\u{df}e\r\n\r\n".as_bytes()));
+        assert!(!MailOptions::default().needs_smtputf8("a@x", "j\u{f6}rg@y", ascii));
+    }
+
+    #[test]
+    fn non_ascii_address_needs_smtputf8() {
+        assert!(address_allowed("a@x", false));
+        assert!(!address_allowed("\u{4f60}\u{597d}@x", false));
+        assert!(address_allowed("\u{4f60}\u{597d}@x", true));
+    }
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! RFC 6710 `MT-PRIORITY` and outbound queue scheduling bands.
+//!
+//! Priority is carried through the queue on [`crate::OutboundJob::priority`]. A client-supplied
+//! value is never trusted on authenticated submission: there the priority is our own
+//! [`classify`] result, so a user cannot jump the queue with bulk mail. Inbound relay accepts
+//! the `MT-PRIORITY` parameter only from trusted peers (`trusted_networks`, loopback).
+
+use chatmail_types::{ChatmailError, Result};
+
+/// Lowest priority allowed by RFC 6710 §3.
+pub const MT_PRIORITY_MIN: i8 = -9;
+/// Highest priority allowed by RFC 6710 §3.
+pub const MT_PRIORITY_MAX: i8 = 9;
+/// Priority given to Delta Chat Secure-Join handshake messages (`vc-*` / `vg-*`).
+pub const SECURE_JOIN_PRIORITY: i8 = 6;
+
+/// Queue band a message is scheduled in; free worker slots go to [`QueueBand::High`] first.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum QueueBand {
+    High,
+    Normal,
+}
+
+impl QueueBand {
+    /// Positive priorities are expedited; zero and negative ones share the normal band.
+    pub fn for_priority(priority: i8) -> Self {
+        if priority > 0 {
+            Self::High
+        } else {
+            Self::Normal
+        }
+    }
+}
+
+/// Our own priority for a message: Secure-Join handshakes are latency-sensitive, the rest is 0.
+pub fn classify(data: &[u8]) -> i8 {
+    if chatmail_pgp::is_secure_join_message(data) {
+        SECURE_JOIN_PRIORITY
+    } else {
+        0
+    }
+}
+
+/// Optional `MT-PRIORITY=` ESMTP parameter on `MAIL FROM` (RFC 6710 §4.2).
+pub fn mail_from_mt_priority(line: &str) -> Result<Option<i8>> {
+    let params = match line.find('>') {
+        Some(i) => &line[i + 1..],
+        None => line.split_once(char::is_whitespace).map_or("", |(_, r)| r),
+    };
+    let Some(value) = params.split_whitespace().find_map(|p| {
+        p.split_once('=')
+            .filter(|(k, _)| k.eq_ignore_ascii_case("MT-PRIORITY"))
+            .map(|(_, v)| v)
+    }) else {
+        return Ok(None);
+    };
+    value
+        .parse::<i8>()
+        .ok()
+        .filter(|p| (MT_PRIORITY_MIN..=MT_PRIORITY_MAX).contains(p))
+        .map(Some)
+        .ok_or_else(|| ChatmailError::protocol("bad MT-PRIORITY parameter"))
+}
+
+/// RFC 6710 §4.1: the extension keyword on its own EHLO line.
+pub(crate) fn ehlo_advertises_mt_priority(ehlo_response: &str) -> bool {
+    ehlo_response.lines().any(|line| {
+        line.starts_with("250")
+            && line
+                .get(4..)
+                .and_then(|rest| rest.split_whitespace().next())
+                .is_some_and(|kw| kw.eq_ignore_ascii_case("MT-PRIORITY"))
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SECURE_JOIN: &[u8] =
+        b"From: bob@x.test\r\nSecure-Join: vg-request\r\nContent-Type: multipart/mixed\r\n\r\nbody";
+
+    #[test]
+    fn secure_join_classified_into_high_band() {
+        assert_eq!(classify(SECURE_JOIN), SECURE_JOIN_PRIORITY);
+        assert_eq!(
+            QueueBand::for_priority(classify(SECURE_JOIN)),
+            QueueBand::High
+        );
+
+        let plain =
+            b"From: bob@x.test\r\nContent-Type: multipart/encrypted\r\n\r\nSecure-Join: vc-request";
+        assert_eq!(classify(plain), 0);
+        assert_eq!(QueueBand::for_priority(classify(plain)), QueueBand::Normal);
+        assert_eq!(QueueBand::for_priority(-4), QueueBand::Normal);
+    }
+
+    #[test]
+    fn mail_from_parameter_parsing() {
+        assert_eq!(mail_from_mt_priority("MAIL FROM:<a@x>").unwrap(), None);
+        assert_eq!(
+            mail_from_mt_priority("MAIL FROM:<a@x> SIZE=10 mt-priority=3").unwrap(),
+            Some(3)
+        );
+        assert_eq!(
+            mail_from_mt_priority("MAIL FROM:<a@x> MT-PRIORITY=-9").unwrap(),
+            Some(-9)
+        );
+        assert_eq!(
+            mail_from_mt_priority("MAIL FROM:<a@x> MT-PRIORITY=+2").unwrap(),
+            Some(2)
+        );
+        assert!(mail_from_mt_priority("MAIL FROM:<a@x> MT-PRIORITY=10").is_err());
+        assert!(mail_from_mt_priority("MAIL FROM:<a@x> MT-PRIORITY=high").is_err());
+        assert_eq!(
+            mail_from_mt_priority("MAIL FROM:<mt-priority=5@x>").unwrap(),
+            None
+        );
+    }
+
+    #[test]
+    fn ehlo_detects_mt_priority_extension() {
+        assert!(ehlo_advertises_mt_priority(
+            "250-mx.test\r\n250-MT-PRIORITY MIXER\r\n250 OK\r\n"
+        ));
+        assert!(ehlo_advertises_mt_priority("250 mt-priority\r\n"));
+        assert!(!ehlo_advertises_mt_priority("250-mx.test\r\n250 OK\r\n"));
+    }
+}
@@ -23,6 +23,8 @@ use serde::{Deserialize, Serialize};
 use tokio::fs;
 use tokio::io::AsyncWriteExt;
 
+use crate::mail_options::{BodyType, MailOptions};
+
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct QueueMeta {
     pub id: String,
@@ -36,6 +38,18 @@ pub struct QueueMeta {
     pub next_attempt_unix: u64,
     #[serde(default)]
     pub last_error: Option<String>,
+    /// RFC 8689 REQUIRETLS from the original `MAIL FROM` (absent in older `.meta` files).
+    #[serde(default)]
+    pub require_tls: bool,
+    /// RFC 6531 SMTPUTF8 from the original `MAIL FROM`.
+    #[serde(default)]
+    pub smtputf8: bool,
+    /// RFC 6152 `BODY=` from the original `MAIL FROM`.
+    #[serde(default)]
+    pub body: BodyType,
+    /// RFC 6710 MT-PRIORITY; picks the scheduling band after a reload or retry.
+    #[serde(default)]
+    pub priority: i8,
 }
 
 impl QueueMeta {
@@ -47,6 +61,15 @@ impl QueueMeta {
             self.last_attempt_unix
         }
     }
+
+    /// The `MAIL FROM` parameters to replay on delivery.
+    pub fn mail_options(&self) -> MailOptions {
+        MailOptions {
+            require_tls: self.require_tls,
+            smtputf8: self.smtputf8,
+            body: self.body,
+        }
+    }
 }
 
 #[derive(Clone)]
@@ -68,6 +91,7 @@ impl QueueStore {
         Ok(())
     }
 
+    #[allow(clippy::too_many_arguments)]
     pub async fn write_new(
         &self,
         id: &str,
@@ -75,6 +99,8 @@ impl QueueStore {
         rcpt_to: &str,
         body: &[u8],
         next_attempt_unix: u64,
+        options: MailOptions,
+        priority: i8,
     ) -> Result<()> {
         let now = now_unix();
         let meta = QueueMeta {
@@ -86,6 +112,10 @@ impl QueueStore {
             last_attempt_unix: 0,
             next_attempt_unix,
             last_error: None,
+            require_tls: options.require_tls,
+            smtputf8: options.smtputf8,
+            body: options.body,
+            priority,
         };
         self.write_body(id, body).await?;
         self.write_meta(&meta).await?;
@@ -115,6 +145,8 @@ impl QueueStore {
         rcpts: &[String],
         body: &[u8],
         next_attempt_unix: u64,
+        options: MailOptions,
+        priority: i8,
     ) -> Result<Vec<String>> {
         if rcpts.is_empty() {
             return Ok(Vec::new());
@@ -127,7 +159,16 @@ impl QueueStore {
             .collect();
 
         match self
-            .write_shared_inner(mail_from, rcpts, body, next_attempt_unix, now, &ids)
+            .write_shared_inner(
+                mail_from,
+                rcpts,
+                body,
+                next_attempt_unix,
+                options,
+                priority,
+                now,
+                &ids,
+            )
             .await
         {
             Ok(()) => Ok(ids),
@@ -138,12 +179,15 @@ impl QueueStore {
         }
     }
 
+    #[allow(clippy::too_many_arguments)]
     async fn write_shared_inner(
         &self,
         mail_from: &str,
         rcpts: &[String],
         body: &[u8],
         next_attempt_unix: u64,
+        options: MailOptions,
+        priority: i8,
         now: u64,
         ids: &[String],
     ) -> Result<()> {
@@ -176,6 +220,10 @@ impl QueueStore {
                 last_attempt_unix: 0,
                 next_attempt_unix,
                 last_error: None,
+                require_tls: options.require_tls,
+                smtputf8: options.smtputf8,
+                body: options.body,
+                priority,
             };
             self.write_meta(&meta).await?;
 
@@ -359,7 +407,14 @@ mod tests {
         store.ensure_dir().await.unwrap();
 
         let ids = store
-            .write_shared("a@local.test", &[], b"body", now_unix())
+            .write_shared(
+                "a@local.test",
+                &[],
+                b"body",
+                now_unix(),
+                MailOptions::default(),
+                0,
+            )
             .await
             .unwrap();
         assert!(ids.is_empty());
@@ -378,7 +433,14 @@ mod tests {
             "u2@remote.test".into(),
         ];
         let ids = store
-            .write_shared("a@local.test", &rcpts, b"shared-body", now_unix())
+            .write_shared(
+                "a@local.test",
+                &rcpts,
+                b"shared-body",
+                now_unix(),
+                MailOptions::default(),
+                0,
+            )
             .await
             .unwrap();
         assert_eq!(ids.len(), 3);
@@ -409,6 +471,8 @@ mod tests {
                 &["u0@remote.test".into(), "u1@remote.test".into()],
                 b"body",
                 now_unix(),
+                MailOptions::default(),
+                0,
             )
             .await
             .unwrap_err();
@@ -434,6 +498,8 @@ mod tests {
                 &["u0@remote.test".into(), "u1@remote.test".into()],
                 b"body",
                 now_unix(),
+                MailOptions::default(),
+                0,
             )
             .await
             .unwrap_err();
@@ -465,6 +531,8 @@ mod tests {
                 ],
                 b"body",
                 now_unix(),
+                MailOptions::default(),
+                0,
             )
             .await
             .unwrap_err();
@@ -490,6 +558,12 @@ mod tests {
                 &["only@remote.test".into()],
                 body,
                 now_unix(),
+                MailOptions {
+                    require_tls: true,
+                    smtputf8: true,
+                    body: BodyType::EightBitMime,
+                },
+                crate::priority::SECURE_JOIN_PRIORITY,
             )
             .await
             .unwrap();
@@ -497,6 +571,10 @@ mod tests {
         let (meta, loaded) = store.load(&ids[0]).await.unwrap();
         assert_eq!(meta.rcpt_to, "only@remote.test");
         assert_eq!(meta.mail_from, "a@local.test");
+        assert!(meta.require_tls, "REQUIRETLS must survive a queue reload");
+        assert!(meta.smtputf8, "SMTPUTF8 must survive a queue reload");
+        assert_eq!(meta.body, BodyType::EightBitMime);
+        assert_eq!(meta.priority, crate::priority::SECURE_JOIN_PRIORITY);
         assert_eq!(loaded, body);
 
         use std::os::unix::fs::MetadataExt;
@@ -519,7 +597,14 @@ mod tests {
             "c@remote.test".into(),
         ];
         let ids = store
-            .write_shared("a@local.test", &rcpts, body, now_unix())
+            .write_shared(
+                "a@local.test",
+                &rcpts,
+                body,
+                now_unix(),
+                MailOptions::default(),
+                0,
+            )
             .await
             .unwrap();
 
@@ -548,6 +633,8 @@ mod tests {
                 ],
                 body,
                 now_unix(),
+                MailOptions::default(),
+                0,
             )
             .await
             .unwrap();
@@ -595,6 +682,8 @@ mod tests {
                     &["u0@remote.test".into(), "u1@remote.test".into()],
                     b"fail-me",
                     now_unix(),
+                    MailOptions::default(),
+                    0,
                 )
                 .await
                 .unwrap_err();
@@ -608,6 +697,8 @@ mod tests {
                 &["u0@remote.test".into(), "u1@remote.test".into()],
                 b"ok-me",
                 now_unix(),
+                MailOptions::default(),
+                0,
             )
             .await
             .unwrap();
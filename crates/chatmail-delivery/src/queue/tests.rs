@@ -63,6 +63,10 @@ mod unit {
             last_attempt_unix: 0,
             next_attempt_unix: now,
             last_error: None,
+            require_tls: false,
+            smtputf8: false,
+            body: Default::default(),
+            priority: 0,
         };
         assert!(!cfg.is_expired(&fresh));
 
@@ -118,6 +122,10 @@ mod unit {
             last_attempt_unix: now.saturating_sub(120),
             next_attempt_unix: now,
             last_error: None,
+            require_tls: false,
+            smtputf8: false,
+            body: Default::default(),
+            priority: 0,
         };
         assert!(cfg.is_expired(&legacy));
     }
@@ -135,6 +143,10 @@ mod unit {
             last_attempt_unix: 200,
             next_attempt_unix: 300,
             last_error: None,
+            require_tls: false,
+            smtputf8: false,
+            body: Default::default(),
+            priority: 0,
         };
         assert_eq!(meta.effective_queued_at(), 100);
     }
@@ -16,32 +16,85 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 use std::sync::Arc;
-use std::time::{Duration, SystemTime};
+use std::time::{Duration, Instant, SystemTime};
 
+use chatmail_state::AdminEventKind;
 use chatmail_types::Result;
+use serde_json::json;
 use tokio::sync::{mpsc, Semaphore};
-use tracing::{info, warn};
+use tracing::{debug, info, warn};
 
+use crate::mail_options::MailOptions;
+use crate::priority::QueueBand;
 use crate::router::{DeliveryContext, OutboundJob};
 use crate::transport::{deliver_remote, DeliveryOutcome};
 
 use super::config::QueueConfig;
-use super::store::{now_unix, QueueStore};
+use super::store::{now_unix, QueueMeta, QueueStore};
 
 pub struct OutboundQueue {
     ctx: DeliveryContext,
     config: QueueConfig,
     store: QueueStore,
-    work_tx: mpsc::UnboundedSender<String>,
+    work_tx: WorkSender,
     started_at: SystemTime,
 }
 
+/// Ready queue ids, one channel per [`QueueBand`].
+#[derive(Clone)]
+struct WorkSender {
+    high: mpsc::UnboundedSender<String>,
+    normal: mpsc::UnboundedSender<String>,
+}
+
+impl WorkSender {
+    fn send(&self, id: String, band: QueueBand) {
+        let tx = match band {
+            QueueBand::High => &self.high,
+            QueueBand::Normal => &self.normal,
+        };
+        let _ = tx.send(id);
+    }
+}
+
+struct WorkReceiver {
+    high: mpsc::UnboundedReceiver<String>,
+    normal: mpsc::UnboundedReceiver<String>,
+}
+
+fn work_channel() -> (WorkSender, WorkReceiver) {
+    let (high_tx, high_rx) = mpsc::unbounded_channel();
+    let (normal_tx, normal_rx) = mpsc::unbounded_channel();
+    (
+        WorkSender {
+            high: high_tx,
+            normal: normal_tx,
+        },
+        WorkReceiver {
+            high: high_rx,
+            normal: normal_rx,
+        },
+    )
+}
+
+impl WorkReceiver {
+    /// Next ready id, always draining the high band before the normal one.
+    async fn recv(&mut self) -> Option<String> {
+        tokio::select! {
+            biased;
+            Some(id) = self.high.recv() => Some(id),
+            Some(id) = self.normal.recv() => Some(id),
+            else => None,
+        }
+    }
+}
+
 impl OutboundQueue {
     pub async fn start(ctx: DeliveryContext, config: QueueConfig) -> Result<Arc<Self>> {
         let store = QueueStore::new(config.location.clone());
         store.ensure_dir().await?;
 
-        let (work_tx, work_rx) = mpsc::unbounded_channel();
+        let (work_tx, work_rx) = work_channel();
         let max_delivery = config.max_delivery_time;
         let queue = Arc::new(Self {
             ctx,
@@ -69,9 +122,17 @@ impl OutboundQueue {
     pub async fn enqueue(&self, job: OutboundJob) -> Result<()> {
         let id = uuid::Uuid::new_v4().to_string();
         self.store
-            .write_new(&id, &job.mail_from, &job.rcpt_to, &job.data, now_unix())
+            .write_new(
+                &id,
+                &job.mail_from,
+                &job.rcpt_to,
+                &job.data,
+                now_unix(),
+                job.options,
+                job.priority,
+            )
             .await?;
-        let _ = self.work_tx.send(id);
+        self.work_tx.send(id, QueueBand::for_priority(job.priority));
         Ok(())
     }
 
@@ -88,16 +149,18 @@ impl OutboundQueue {
         mail_from: &str,
         rcpts: &[String],
         data: &[u8],
+        options: MailOptions,
+        priority: i8,
     ) -> Result<()> {
         if rcpts.is_empty() {
             return Ok(());
         }
         let ids = self
             .store
-            .write_shared(mail_from, rcpts, data, now_unix())
+            .write_shared(mail_from, rcpts, data, now_unix(), options, priority)
             .await?;
         for id in ids {
-            let _ = self.work_tx.send(id);
+            self.work_tx.send(id, QueueBand::for_priority(priority));
         }
         Ok(())
     }
@@ -147,7 +210,8 @@ impl OutboundQueue {
                 continue;
             }
             let run_at = meta.next_attempt_unix.max(min_start);
-            self.schedule_id(&id, run_at).await;
+            self.schedule_id(&id, run_at, QueueBand::for_priority(meta.priority))
+                .await;
             loaded += 1;
         }
         if loaded > 0 {
@@ -167,29 +231,34 @@ impl OutboundQueue {
             .unwrap_or(false)
     }
 
-    async fn schedule_id(&self, id: &str, run_at_unix: u64) {
+    async fn schedule_id(&self, id: &str, run_at_unix: u64, band: QueueBand) {
         let id = id.to_string();
         let work_tx = self.work_tx.clone();
         let now = now_unix();
         if run_at_unix <= now {
-            let _ = work_tx.send(id);
+            work_tx.send(id, band);
             return;
         }
         let delay_secs = run_at_unix.saturating_sub(now);
         tokio::spawn(async move {
             tokio::time::sleep(Duration::from_secs(delay_secs)).await;
-            let _ = work_tx.send(id);
+            work_tx.send(id, band);
         });
     }
 
-    async fn run_worker(self: Arc<Self>, mut work_rx: mpsc::UnboundedReceiver<String>) {
+    async fn run_worker(self: Arc<Self>, mut work_rx: WorkReceiver) {
         let sem = Arc::new(Semaphore::new(self.config.max_parallelism));
-        while let Some(id) = work_rx.recv().await {
-            let q = Arc::clone(&self);
+        loop {
+            // Wait for a free slot before picking the next id, so a congested queue hands
+            // that slot to the high band (Secure-Join handshakes) first.
             let permit = match sem.clone().acquire_owned().await {
                 Ok(p) => p,
                 Err(_) => break,
             };
+            let Some(id) = work_rx.recv().await else {
+                break;
+            };
+            let q = Arc::clone(&self);
             tokio::spawn(async move {
                 let _permit = permit;
                 q.process_entry(&id).await;
@@ -212,6 +281,27 @@ impl OutboundQueue {
             return;
         }
 
+        // Per-domain caps: over-budget entries stay queued without spending a try.
+        let domain = meta
+            .rcpt_to
+            .rsplit_once('@')
+            .map(|(_, d)| d.to_string())
+            .unwrap_or_default();
+        let throttle = &self.ctx.state.delivery_throttle;
+        let _domain_permit = match throttle.acquire(&domain, Instant::now()) {
+            Ok(p) => p,
+            Err(wait) => {
+                debug!(%id, %domain, ?wait, "outbound delivery held by per-domain cap");
+                self.schedule_id(
+                    id,
+                    now_unix() + wait.as_secs().max(1),
+                    QueueBand::for_priority(meta.priority),
+                )
+                .await;
+                return;
+            }
+        };
+
         meta.tries_count += 1;
         meta.last_attempt_unix = now_unix();
 
@@ -219,12 +309,25 @@ impl OutboundQueue {
             mail_from: meta.mail_from.clone(),
             rcpt_to: meta.rcpt_to.clone(),
             data,
+            options: meta.mail_options(),
+            priority: meta.priority,
         };
 
-        match deliver_remote(&self.ctx, &job).await {
+        let started = Instant::now();
+        let outcome = deliver_remote(&self.ctx, &job).await;
+        chatmail_metrics::observe_delivery_duration(
+            match &outcome {
+                DeliveryOutcome::Success => "success",
+                DeliveryOutcome::Temporary { .. } => "temporary",
+                DeliveryOutcome::Permanent { .. } => "permanent",
+            },
+            started.elapsed().as_secs_f64(),
+        );
+        match outcome {
             DeliveryOutcome::Success => {
                 info!(%id, rcpt = %meta.rcpt_to, attempt = meta.tries_count, "outbound delivery succeeded");
                 chatmail_db::increment_outbound();
+                throttle.record_delivered(&domain);
                 self.store.remove(id).await;
             }
             DeliveryOutcome::Permanent { reason } => {
@@ -235,9 +338,13 @@ impl OutboundQueue {
                     error = %reason,
                     "outbound delivery permanent failure"
                 );
+                self.publish_failure(&meta, "permanent", &reason);
                 self.store.remove(id).await;
             }
             DeliveryOutcome::Temporary { reason } => {
+                if throttle.record_deferral(&domain, &reason, Instant::now()) {
+                    warn!(%id, %domain, error = %reason, "rate-limit deferral, reducing per-domain cap");
+                }
                 meta.last_error = Some(reason.clone());
                 if meta.tries_count >= self.config.max_tries {
                     warn!(
@@ -247,6 +354,7 @@ impl OutboundQueue {
                         error = %reason,
                         "outbound delivery exceeded max_tries, dropping"
                     );
+                    self.publish_failure(&meta, "max_tries", &reason);
                     self.store.remove(id).await;
                     return;
                 }
@@ -263,7 +371,12 @@ impl OutboundQueue {
                     error = %reason,
                     "outbound delivery failed, requeued"
                 );
-                self.schedule_id(id, meta.next_attempt_unix).await;
+                self.schedule_id(
+                    id,
+                    meta.next_attempt_unix,
+                    QueueBand::for_priority(meta.priority),
+                )
+                .await;
             }
         }
     }
@@ -278,6 +391,48 @@ impl OutboundQueue {
             last_error = ?meta.last_error,
             "outbound delivery expired (max_delivery_time), marking failed and removing from queue"
         );
+        let reason = meta
+            .last_error
+            .as_deref()
+            .unwrap_or("max_delivery_time exceeded");
+        self.publish_failure(meta, "expired", reason);
         self.store.remove(id).await;
     }
+
+    /// `delivery_failed` on the admin event stream for a message leaving the queue undelivered.
+    fn publish_failure(&self, meta: &QueueMeta, cause: &str, reason: &str) {
+        self.ctx.state.admin_events.publish(
+            AdminEventKind::DeliveryFailed,
+            json!({
+                "mail_from": meta.mail_from,
+                "rcpt": meta.rcpt_to,
+                "attempts": meta.tries_count,
+                "cause": cause,
+                "error": reason,
+            }),
+        );
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn high_band_is_drained_before_normal() {
+        let (tx, mut rx) = work_channel();
+        tx.send("bulk-1".into(), QueueBand::Normal);
+        tx.send(
+            "join".into(),
+            QueueBand::for_priority(crate::priority::SECURE_JOIN_PRIORITY),
+        );
+        tx.send("bulk-2".into(), QueueBand::for_priority(0));
+
+        assert_eq!(rx.recv().await.as_deref(), Some("join"));
+        assert_eq!(rx.recv().await.as_deref(), Some("bulk-1"));
+        assert_eq!(rx.recv().await.as_deref(), Some("bulk-2"));
+
+        drop(tx);
+        assert_eq!(rx.recv().await, None);
+    }
 }
@@ -0,0 +1,937 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Automatic responders (`madmail responder`): when a local address with a responder
+//! receives mail, answer the envelope sender with the operator's template. An account's own
+//! autoresponse (`madmail autoresponse`, a vacation notice) takes the responder's place
+//! while it is in effect, and answers each sender once per `autoresponse_window`.
+//!
+//! Loop prevention follows RFC 3834. [`skip_reason`] refuses to answer bounces (empty
+//! return path), anything already automatic (`Auto-Submitted`, reports, Secure-Join
+//! handshakes), mailing-list and bulk traffic, senders that ask not to be answered, and
+//! role addresses on the suppression list. What is left is answered at most once per
+//! responder interval per correspondent (the `responder_replies` log). Replies carry
+//! `Auto-Submitted: auto-replied` and go out with a null return path, so nothing ever
+//! answers or bounces back to them.
+
+use std::sync::Arc;
+
+use mail_parser::MessageParser;
+use time::format_description::well_known::Rfc2822;
+use time::OffsetDateTime;
+use tracing::{debug, info, warn};
+
+use chatmail_db::policies::unix_now;
+use chatmail_db::{Autoresponse, Responder};
+
+use crate::{DeliveryContext, MailOptions};
+
+/// Senders never answered, whatever `responder_suppress` says (`*` matches any run).
+pub const BUILTIN_SUPPRESS: &[&str] = &[
+    "mailer-daemon@*",
+    "postmaster@*",
+    "noreply@*",
+    "no-reply@*",
+    "no_reply@*",
+    "donotreply@*",
+    "do-not-reply@*",
+    "bounce@*",
+    "bounces@*",
+    "*-bounce@*",
+    "*-bounces@*",
+    "*-bounces+*@*",
+    "owner-*@*",
+    "*-owner@*",
+    "*-request@*",
+    "listserv@*",
+    "majordomo@*",
+];
+
+/// Why an inbound message was not answered.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SkipReason {
+    /// Empty return path: a bounce or another automatic message.
+    NullSender,
+    /// `Auto-Submitted` other than `no`.
+    AutoSubmitted,
+    /// `multipart/report` (delivery or disposition notification).
+    Report,
+    /// Delta Chat Secure-Join handshake.
+    Handshake,
+    /// `List-*` header present.
+    MailingList,
+    /// `Precedence: bulk`, `list` or `junk`.
+    Bulk,
+    /// `X-Auto-Response-Suppress` asks for no automatic replies.
+    ResponseSuppressed,
+    /// Sender matches the built-in or `responder_suppress` list.
+    SuppressedSender,
+    /// The responder address wrote to itself.
+    OwnAddress,
+}
+
+impl SkipReason {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::NullSender => "null_sender",
+            Self::AutoSubmitted => "auto_submitted",
+            Self::Report => "report",
+            Self::Handshake => "handshake",
+            Self::MailingList => "mailing_list",
+            Self::Bulk => "bulk",
+            Self::ResponseSuppressed => "response_suppressed",
+            Self::SuppressedSender => "suppressed_sender",
+            Self::OwnAddress => "own_address",
+        }
+    }
+}
+
+/// The header fields of an inbound message that responders look at.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct InboundHeaders {
+    pub auto_submitted: Option<String>,
+    pub precedence: Option<String>,
+    pub auto_response_suppress: Option<String>,
+    pub list: bool,
+    pub report: bool,
+    pub secure_join: bool,
+    /// `Subject` as sent (possibly RFC 2047 encoded), unfolded.
+    pub subject_raw: String,
+    /// `Subject` decoded, for template substitution.
+    pub subject: String,
+    pub message_id: Option<String>,
+    pub references: String,
+}
+
+impl InboundHeaders {
+    pub fn parse(raw: &[u8]) -> Self {
+        let head = header_block(raw);
+        let mut out = Self::default();
+        for (name, value) in unfolded_headers(head) {
+            match name.as_str() {
+                "auto-submitted" => {
+                    out.auto_submitted.get_or_insert(value);
+                }
+                "precedence" => {
+                    out.precedence.get_or_insert(value);
+                }
+                "x-auto-response-suppress" => {
+                    out.auto_response_suppress.get_or_insert(value);
+                }
+                "content-type" => {
+                    out.report |= value.to_ascii_lowercase().starts_with("multipart/report");
+                }
+                "secure-join" => out.secure_join = true,
+                "subject" if out.subject_raw.is_empty() => out.subject_raw = value,
+                "message-id" if out.message_id.is_none() => out.message_id = msg_id(&value),
+                "references" if out.references.is_empty() => out.references = value,
+                n if n.starts_with("list-") => out.list = true,
+                _ => {}
+            }
+        }
+        out.subject = MessageParser::default()
+            .parse(head)
+            .and_then(|m| m.subject().map(str::to_string))
+            .unwrap_or_else(|| out.subject_raw.clone());
+        out
+    }
+}
+
+/// Header section including the blank line that ends it (whole input when there is none).
+pub(crate) fn header_block(raw: &[u8]) -> &[u8] {
+    let end = raw
+        .windows(4)
+        .position(|w| w == b"\r\n\r\n")
+        .map(|i| i + 4)
+        .or_else(|| raw.windows(2).position(|w| w == b"\n\n").map(|i| i + 2));
+    &raw[..end.unwrap_or(raw.len())]
+}
+
+/// `(lowercase name, unfolded trimmed value)` pairs in order.
+pub(crate) fn unfolded_headers(head: &[u8]) -> Vec<(String, String)> {
+    let text = String::from_utf8_lossy(head);
+    let mut out: Vec<(String, String)> = Vec::new();
+    for line in text.split('\n') {
+        let line = line.trim_end_matches('\r');
+        if line.is_empty() {
+            break;
+        }
+        if line.starts_with([' ', '\t']) {
+            if let Some((_, value)) = out.last_mut() {
+                value.push(' ');
+                value.push_str(line.trim());
+            }
+            continue;
+        }
+        if let Some((name, value)) = line.split_once(':') {
+            out.push((name.trim().to_ascii_lowercase(), value.trim().to_string()));
+        }
+    }
+    out
+}
+
+/// First `<…>` token of a `Message-ID` value.
+fn msg_id(value: &str) -> Option<String> {
+    let start = value.find('<')?;
+    let end = start + value[start..].find('>')?;
+    let id = &value[start..=end];
+    (id.len() > 2 && !id.contains(char::is_whitespace)).then(|| id.to_string())
+}
+
+/// `*`-wildcard match, ASCII case-insensitive.
+fn wildcard_match(pattern: &str, text: &str) -> bool {
+    let (p, t) = (pattern.as_bytes(), text.as_bytes());
+    let (mut pi, mut ti) = (0, 0);
+    let mut star: Option<(usize, usize)> = None;
+    while ti < t.len() {
+        if pi < p.len() && p[pi] == b'*' {
+            star = Some((pi, ti));
+            pi += 1;
+        } else if pi < p.len() && p[pi].eq_ignore_ascii_case(&t[ti]) {
+            pi += 1;
+            ti += 1;
+        } else if let Some((sp, st)) = star {
+            pi = sp + 1;
+            ti = st + 1;
+            star = Some((sp, st + 1));
+        } else {
+            return false;
+        }
+    }
+    p[pi..].iter().all(|&c| c == b'*')
+}
+
+/// `true` when `sender` matches [`BUILTIN_SUPPRESS`] or one of `extra`.
+pub fn sender_suppressed(sender: &str, extra: &[String]) -> bool {
+    BUILTIN_SUPPRESS
+        .iter()
+        .copied()
+        .chain(extra.iter().map(String::as_str))
+        .any(|p| wildcard_match(p, sender))
+}
+
+/// Why `responder` must not answer this message, or `None` when it may (subject to the
+/// per-correspondent interval, which needs the database).
+pub fn skip_reason(
+    mail_from: &str,
+    headers: &InboundHeaders,
+    responder: &str,
+    extra_suppress: &[String],
+) -> Option<SkipReason> {
+    let sender = mail_from
+        .trim()
+        .trim_start_matches('<')
+        .trim_end_matches('>');
+    if sender.is_empty() {
+        return Some(SkipReason::NullSender);
+    }
+    if headers
+        .auto_submitted
+        .as_deref()
+        .is_some_and(|v| !first_token(v).eq_ignore_ascii_case("no"))
+    {
+        return Some(SkipReason::AutoSubmitted);
+    }
+    if headers.report {
+        return Some(SkipReason::Report);
+    }
+    if headers.secure_join {
+        return Some(SkipReason::Handshake);
+    }
+    if headers.list {
+        return Some(SkipReason::MailingList);
+    }
+    if headers.precedence.as_deref().is_some_and(|v| {
+        matches!(
+            first_token(v).to_ascii_lowercase().as_str(),
+            "bulk" | "list" | "junk"
+        )
+    }) {
+        return Some(SkipReason::Bulk);
+    }
+    if headers.auto_response_suppress.as_deref().is_some_and(|v| {
+        v.split(',').any(|t| {
+            ["all", "oof", "autoreply"]
+                .iter()
+                .any(|k| t.trim().eq_ignore_ascii_case(k))
+        })
+    }) {
+        return Some(SkipReason::ResponseSuppressed);
+    }
+    if sender_suppressed(sender, extra_suppress) {
+        return Some(SkipReason::SuppressedSender);
+    }
+    if sender.eq_ignore_ascii_case(responder) {
+        return Some(SkipReason::OwnAddress);
+    }
+    None
+}
+
+/// Value up to the first `;` or comment, trimmed (`auto-generated; foo` → `auto-generated`).
+fn first_token(value: &str) -> &str {
+    value.split([';', '(']).next().unwrap_or("").trim()
+}
+
+pub(crate) fn header_safe(value: &str) -> String {
+    value
+        .chars()
+        .map(|c| if c.is_control() { ' ' } else { c })
+        .collect::<String>()
+        .trim()
+        .to_string()
+}
+
+fn substitute(template: &str, subject: &str, sender: &str, address: &str) -> String {
+    template
+        .replace("{{subject}}", subject)
+        .replace("{{sender}}", sender)
+        .replace("{{address}}", address)
+}
+
+/// The reply from `responder` to `to`. A first template line `Subject: …` sets the subject
+/// (a blank line after it is dropped); otherwise it is `Auto: <original subject>`
+/// (RFC 3834 §3.1.5).
+pub fn build_reply(responder: &Responder, to: &str, headers: &InboundHeaders) -> Vec<u8> {
+    let template = responder.template.replace("\r\n", "\n");
+    let (subject_line, body) = match template.split_once('\n') {
+        Some((first, rest)) if first.starts_with("Subject:") => {
+            (Some(&first[8..]), rest.strip_prefix('\n').unwrap_or(rest))
+        }
+        None if template.starts_with("Subject:") => (Some(&template[8..]), ""),
+        _ => (None, template.as_str()),
+    };
+    compose_reply(&responder.address, to, subject_line, body, headers)
+}
+
+/// The reply from an account's autoresponse to `to`; an empty subject becomes
+/// `Auto: <original subject>`. Placeholders work as in responder templates.
+pub fn build_autoresponse(
+    autoresponse: &Autoresponse,
+    to: &str,
+    headers: &InboundHeaders,
+) -> Vec<u8> {
+    let subject = Some(autoresponse.subject.as_str()).filter(|s| !s.trim().is_empty());
+    let body = autoresponse.body.replace("\r\n", "\n");
+    compose_reply(&autoresponse.username, to, subject, &body, headers)
+}
+
+fn compose_reply(
+    address: &str,
+    to: &str,
+    subject_line: Option<&str>,
+    body: &str,
+    headers: &InboundHeaders,
+) -> Vec<u8> {
+    let subject = match subject_line {
+        Some(line) => header_safe(&substitute(line, &headers.subject, to, address)),
+        None => {
+            let original = header_safe(&headers.subject_raw);
+            if original.is_empty() {
+                "Auto: (no subject)".to_string()
+            } else {
+                format!("Auto: {original}")
+            }
+        }
+    };
+    let mut body = substitute(body, &headers.subject, to, address).replace('\n', "\r\n");
+    if !body.ends_with("\r\n") {
+        body.push_str("\r\n");
+    }
+
+    let domain = chatmail_types::address_domain(address).unwrap_or_else(|| "localhost".into());
+    let id_host = domain.trim_matches(|c| c == '[' || c == ']');
+    let date = OffsetDateTime::now_utc()
+        .format(&Rfc2822)
+        .unwrap_or_else(|_| OffsetDateTime::now_utc().to_string());
+    let mut threading = String::new();
+    if let Some(id) = &headers.message_id {
+        let references = header_safe(&headers.references);
+        let references = if references.is_empty() {
+            id.clone()
+        } else {
+            format!("{references} {id}")
+        };
+        threading = format!("In-Reply-To: {id}\r\nReferences: {references}\r\n");
+    }
+    format!(
+        "From: <{address}>\r\n\
+         To: <{to}>\r\n\
+         Subject: {subject}\r\n\
+         Date: {date}\r\n\
+         Message-ID: <{msg_id}@{id_host}>\r\n\
+         {threading}\
+         Auto-Submitted: auto-replied\r\n\
+         MIME-Version: 1.0\r\n\
+         Content-Type: text/plain; charset=utf-8\r\n\
+         Content-Transfer-Encoding: 8bit\r\n\
+         \r\n\
+         {body}",
+        msg_id = uuid::Uuid::new_v4(),
+    )
+    .into_bytes()
+}
+
+/// What answers one recipient.
+enum Answer {
+    /// The account's own autoresponse, while it is in effect.
+    Account(Arc<Autoresponse>),
+    /// The operator's responder for the address.
+    Responder(Arc<Responder>),
+}
+
+impl Answer {
+    fn address(&self) -> &str {
+        match self {
+            Self::Account(a) => &a.username,
+            Self::Responder(r) => &r.address,
+        }
+    }
+}
+
+impl DeliveryContext {
+    /// Answer, in the background, every recipient in `delivered` that has an autoresponse in
+    /// effect or a responder.
+    ///
+    /// Called after a message was stored for local recipients; never delays or fails the
+    /// delivery itself.
+    pub fn spawn_auto_replies<'a>(
+        &self,
+        mail_from: &str,
+        delivered: impl IntoIterator<Item = &'a String>,
+        data: &[u8],
+    ) {
+        let cache = &self.state.responders;
+        if cache.is_empty() {
+            return;
+        }
+        let now = unix_now();
+        let answers: Vec<_> = delivered
+            .into_iter()
+            .filter_map(|rcpt| {
+                cache
+                    .autoresponse(rcpt, now)
+                    .map(Answer::Account)
+                    .or_else(|| cache.get(rcpt).map(Answer::Responder))
+            })
+            .collect();
+        if answers.is_empty() {
+            return;
+        }
+        let ctx = DeliveryContext {
+            pool: self.pool.clone(),
+            state: Arc::clone(&self.state),
+            primary_domain: self.primary_domain.clone(),
+            local_domains: self.local_domains.clone(),
+        };
+        let mail_from = mail_from.to_string();
+        let data = data.to_vec();
+        tokio::spawn(async move {
+            let headers = InboundHeaders::parse(&data);
+            for answer in answers {
+                ctx.auto_reply(&answer, &mail_from, &headers).await;
+            }
+        });
+    }
+
+    async fn auto_reply(&self, answer: &Answer, mail_from: &str, headers: &InboundHeaders) {
+        let address = answer.address();
+        let extra = self.state.responders.suppress_patterns();
+        if let Some(reason) = skip_reason(mail_from, headers, address, extra) {
+            let reason = reason.as_str();
+            debug!(responder = %address, from = %mail_from, reason, "no auto-reply");
+            return;
+        }
+        let sender = mail_from
+            .trim()
+            .trim_start_matches('<')
+            .trim_end_matches('>');
+        let to = match chatmail_auth::normalize_username(sender) {
+            Ok(to) => to,
+            Err(_) => return,
+        };
+        let claimed = match answer {
+            Answer::Account(_) => {
+                let window = self.state.responders.autoresponse_window();
+                chatmail_db::claim_autoresponse_reply(&self.pool, address, &to, window, unix_now())
+                    .await
+            }
+            Answer::Responder(r) => {
+                chatmail_db::claim_responder_reply(
+                    &self.pool,
+                    address,
+                    &to,
+                    r.interval_secs,
+                    unix_now(),
+                )
+                .await
+            }
+        };
+        match claimed {
+            Ok(true) => {}
+            Ok(false) => {
+                debug!(responder = %address, to = %to, "auto-reply interval not elapsed");
+                return;
+            }
+            Err(e) => {
+                warn!(responder = %address, error = %e, "auto-reply state lookup failed");
+                return;
+            }
+        }
+        let reply = match answer {
+            Answer::Account(a) => build_autoresponse(a, &to, headers),
+            Answer::Responder(r) => build_reply(r, &to, headers),
+        };
+        // Null return path (RFC 3834 §3.3): a failed reply must not bounce to anyone.
+        match self
+            .submit_authenticated(
+                "",
+                std::slice::from_ref(&to),
+                &reply,
+                MailOptions::default(),
+            )
+            .await
+        {
+            Ok(()) => info!(responder = %address, to = %to, "auto-reply sent"),
+            Err(e) => warn!(responder = %address, to = %to, error = %e, "auto-reply failed"),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use chatmail_state::AppState;
+
+    use super::*;
+
+    const RESPONDER: &str = "help@example.org";
+
+    fn headers(raw: &str) -> InboundHeaders {
+        InboundHeaders::parse(format!("{raw}\r\n\r\nbody").as_bytes())
+    }
+
+    fn skip(mail_from: &str, raw: &str) -> Option<SkipReason> {
+        skip_reason(mail_from, &headers(raw), RESPONDER, &[])
+    }
+
+    #[test]
+    fn answers_ordinary_mail() {
+        for raw in [
+            "From: bob@peer.test\r\nSubject: hi",
+            "Auto-Submitted: no",
+            "Auto-Submitted: No (explicitly)",
+            "Precedence: first-class",
+            "X-Auto-Response-Suppress: DR, RN",
+            "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"",
+        ] {
+            assert_eq!(skip("bob@peer.test", raw), None, "{raw}");
+        }
+        assert_eq!(skip("<bob@peer.test>", "Subject: hi"), None);
+    }
+
+    #[test]
+    fn loop_prevention_table() {
+        use SkipReason::*;
+        for (mail_from, raw, want) in [
+            ("", "Subject: hi", NullSender),
+            ("<>", "Subject: hi", NullSender),
+            ("  ", "Subject: hi", NullSender),
+            (
+                "bob@peer.test",
+                "Auto-Submitted: auto-replied",
+                AutoSubmitted,
+            ),
+            (
+                "bob@peer.test",
+                "Auto-Submitted: auto-generated",
+                AutoSubmitted,
+            ),
+            (
+                "bob@peer.test",
+                "auto-submitted: Auto-Notified; x=y",
+                AutoSubmitted,
+            ),
+            (
+                "bob@peer.test",
+                "Auto-Submitted: something-new",
+                AutoSubmitted,
+            ),
+            (
+                "bob@peer.test",
+                "Auto-Submitted:\r\n auto-replied",
+                AutoSubmitted,
+            ),
+            (
+                "bob@peer.test",
+                "Content-Type: multipart/report; report-type=delivery-status",
+                Report,
+            ),
+            ("bob@peer.test", "Secure-Join: vc-request", Handshake),
+            (
+                "bob@peer.test",
+                "List-Id: <dev.lists.peer.test>",
+                MailingList,
+            ),
+            (
+                "bob@peer.test",
+                "List-Unsubscribe: <mailto:x@peer.test>",
+                MailingList,
+            ),
+            (
+                "bob@peer.test",
+                "LIST-POST: <mailto:dev@peer.test>",
+                MailingList,
+            ),
+            ("bob@peer.test", "Precedence: bulk", Bulk),
+            ("bob@peer.test", "Precedence: LIST", Bulk),
+            ("bob@peer.test", "Precedence: junk", Bulk),
+            (
+                "bob@peer.test",
+                "X-Auto-Response-Suppress: All",
+                ResponseSuppressed,
+            ),
+            (
+                "bob@peer.test",
+                "X-Auto-Response-Suppress: DR, OOF",
+                ResponseSuppressed,
+            ),
+            (
+                "bob@peer.test",
+                "X-Auto-Response-Suppress: AutoReply",
+                ResponseSuppressed,
+            ),
+            ("MAILER-DAEMON@peer.test", "Subject: hi", SuppressedSender),
+            ("postmaster@peer.test", "Subject: hi", SuppressedSender),
+            ("noreply@peer.test", "Subject: hi", SuppressedSender),
+            ("no-reply@peer.test", "Subject: hi", SuppressedSender),
+            ("donotreply@peer.test", "Subject: hi", SuppressedSender),
+            (
+                "dev-bounces@lists.peer.test",
+                "Subject: hi",
+                SuppressedSender,
+            ),
+            (
+                "dev-bounces+bob=x.test@lists.peer.test",
+                "Subject: hi",
+                SuppressedSender,
+            ),
+            ("owner-dev@peer.test", "Subject: hi", SuppressedSender),
+            ("dev-owner@peer.test", "Subject: hi", SuppressedSender),
+            ("dev-request@peer.test", "Subject: hi", SuppressedSender),
+            ("majordomo@peer.test", "Subject: hi", SuppressedSender),
+            ("help@example.org", "Subject: hi", OwnAddress),
+            ("<HELP@Example.org>", "Subject: hi", OwnAddress),
+        ] {
+            assert_eq!(skip(mail_from, raw), Some(want), "{mail_from:?} {raw:?}");
+        }
+    }
+
+    #[test]
+    fn header_in_body_does_not_count() {
+        let h = InboundHeaders::parse(b"Subject: hi\r\n\r\nAuto-Submitted: auto-replied\r\n");
+        assert_eq!(skip_reason("bob@peer.test", &h, RESPONDER, &[]), None);
+    }
+
+    #[test]
+    fn configured_suppression_patterns() {
+        let extra = vec!["*@lists.peer.test".to_string(), "alerts@*".to_string()];
+        let h = headers("Subject: hi");
+        for (sender, suppressed) in [
+            ("anyone@lists.peer.test", true),
+            ("ALERTS@monitoring.test", true),
+            ("bob@peer.test", false),
+            ("bob@lists.peer.test.evil", false),
+        ] {
+            assert_eq!(
+                skip_reason(sender, &h, RESPONDER, &extra) == Some(SkipReason::SuppressedSender),
+                suppressed,
+                "{sender}"
+            );
+        }
+    }
+
+    #[test]
+    fn wildcards() {
+        assert!(wildcard_match("*", ""));
+        assert!(wildcard_match("a*c", "abbbc"));
+        assert!(wildcard_match("*-request@*", "dev-request@x"));
+        assert!(!wildcard_match("*-request@*", "request@x"));
+        assert!(!wildcard_match("owner-*@*", "bowner-x@y"));
+        assert!(!wildcard_match("a*c", "abcd"));
+    }
+
+    fn responder(template: &str) -> Responder {
+        Responder {
+            address: RESPONDER.into(),
+            template: template.into(),
+            interval_secs: 3600,
+            created_at: 0,
+        }
+    }
+
+    fn reply_text(template: &str, raw: &str) -> String {
+        let reply = build_reply(&responder(template), "bob@peer.test", &headers(raw));
+        String::from_utf8(reply).unwrap()
+    }
+
+    #[test]
+    fn reply_headers_and_threading() {
+        let text = reply_text(
+            "Thanks for writing to {{address}} about \"{{subject}}\", {{sender}}.",
+            "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\nMessage-ID: <m2@peer.test>\r\n\
+             References: <m0@peer.test>\r\n <m1@peer.test>",
+        );
+        let (head, body) = text.split_once("\r\n\r\n").unwrap();
+        assert!(head.contains("From: <help@example.org>\r\n"), "{head}");
+        assert!(head.contains("To: <bob@peer.test>\r\n"), "{head}");
+        assert!(
+            head.contains("Subject: Auto: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n"),
+            "{head}"
+        );
+        assert!(head.contains("Auto-Submitted: auto-replied\r\n"), "{head}");
+        assert!(head.contains("In-Reply-To: <m2@peer.test>\r\n"), "{head}");
+        assert!(
+            head.contains("References: <m0@peer.test> <m1@peer.test> <m2@peer.test>\r\n"),
+            "{head}"
+        );
+        assert!(head.contains("@example.org>\r\n"), "{head}");
+        assert_eq!(
+            body,
+            "Thanks for writing to help@example.org about \"Grüße\", bob@peer.test.\r\n"
+        );
+
+        // The reply itself is never answered by another responder.
+        let reply = InboundHeaders::parse(text.as_bytes());
+        assert_eq!(
+            skip_reason("", &reply, "other@example.org", &[]),
+            Some(SkipReason::NullSender)
+        );
+        assert_eq!(
+            skip_reason("help@example.org", &reply, "other@example.org", &[]),
+            Some(SkipReason::AutoSubmitted)
+        );
+    }
+
+    #[test]
+    fn reply_without_message_id_has_no_threading() {
+        let text = reply_text("Away.\n", "From: bob@peer.test");
+        assert!(text.contains("Subject: Auto: (no subject)\r\n"), "{text}");
+        assert!(!text.contains("In-Reply-To:"), "{text}");
+        assert!(!text.contains("References:"), "{text}");
+        assert!(text.ends_with("\r\n\r\nAway.\r\n"), "{text}");
+    }
+
+    #[test]
+    fn template_subject_line() {
+        let text = reply_text(
+            "Subject: Out of office ({{subject}})\n\nBack on Monday.\nBye",
+            "Subject: Report\r\nMessage-ID: <m@peer.test>",
+        );
+        assert!(
+            text.contains("Subject: Out of office (Report)\r\n"),
+            "{text}"
+        );
+        assert!(
+            text.ends_with("\r\n\r\nBack on Monday.\r\nBye\r\n"),
+            "{text}"
+        );
+
+        // A subject cannot smuggle extra header lines into the reply.
+        let text = reply_text("Subject: {{subject}}\n\nx", "Subject: a\r\n\tBcc: evil@x");
+        assert!(text.contains("Subject: a"), "{text}");
+        assert!(
+            !text.contains("\nBcc:") && !text.contains("\n\tBcc:"),
+            "{text}"
+        );
+    }
+
+    #[test]
+    fn autoresponse_subject_and_body() {
+        let mut away = Autoresponse {
+            username: "alice@example.org".into(),
+            active: true,
+            subject: "Away until {{address}} returns".into(),
+            body: "Re {{subject}}:\r\nback soon.".into(),
+            starts_at: None,
+            ends_at: None,
+            updated_at: 0,
+        };
+        let raw = "Subject: Plans\r\nMessage-ID: <m@peer.test>";
+        let text =
+            String::from_utf8(build_autoresponse(&away, "bob@peer.test", &headers(raw))).unwrap();
+        assert!(text.contains("From: <alice@example.org>\r\n"), "{text}");
+        assert!(
+            text.contains("Subject: Away until alice@example.org returns\r\n"),
+            "{text}"
+        );
+        assert!(text.contains("Auto-Submitted: auto-replied\r\n"), "{text}");
+        assert!(
+            text.ends_with("\r\n\r\nRe Plans:\r\nback soon.\r\n"),
+            "{text}"
+        );
+
+        away.subject = " ".into();
+        let text =
+            String::from_utf8(build_autoresponse(&away, "bob@peer.test", &headers(raw))).unwrap();
+        assert!(text.contains("Subject: Auto: Plans\r\n"), "{text}");
+    }
+
+    fn inbox_files(app: &AppState, user: &str) -> Vec<String> {
+        let new = app.mailbox_store.maildir_for_user(user).new;
+        let mut out = Vec::new();
+        if let Ok(entries) = std::fs::read_dir(new) {
+            for e in entries.flatten() {
+                out.push(std::fs::read_to_string(e.path()).unwrap_or_default());
+            }
+        }
+        out
+    }
+
+    #[tokio::test]
+    async fn local_sender_answered_once_per_interval() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        for user in ["bob@local.test", "help@local.test"] {
+            chatmail_db::passwords::create_user(&pool, user, "bcrypt:x")
+                .await
+                .unwrap();
+        }
+        chatmail_db::set_responder(&pool, "help@local.test", "Away.", 3600)
+            .await
+            .unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        app.auth.hydrate(&pool).await.unwrap();
+        app.responders.refresh(&pool).await.unwrap();
+        let ctx = DeliveryContext {
+            pool: pool.clone(),
+            state: Arc::clone(&app),
+            primary_domain: "local.test".into(),
+            local_domains: chatmail_types::build_local_domains("local.test", None),
+        };
+
+        let body = b"From: bob@local.test\r\nTo: help@local.test\r\nSubject: hi\r\n\
+                     Message-ID: <m1@local.test>\r\n\r\nhello";
+        let rcpts = ["help@local.test".to_string()];
+        for _ in 0..3 {
+            ctx.route_message("bob@local.test", &rcpts, body)
+                .await
+                .unwrap();
+        }
+        // Replies go out in the background; give them time to land (and any extras too).
+        for _ in 0..100 {
+            if !inbox_files(&app, "bob@local.test").is_empty() {
+                break;
+            }
+            tokio::time::sleep(std::time::Duration::from_millis(20)).await;
+        }
+        tokio::time::sleep(std::time::Duration::from_millis(200)).await;
+        let replies = inbox_files(&app, "bob@local.test");
+        assert_eq!(replies.len(), 1, "{replies:?}");
+        let reply = &replies[0];
+        assert!(
+            reply.contains("Auto-Submitted: auto-replied\r\n"),
+            "{reply}"
+        );
+        assert!(
+            reply.contains("In-Reply-To: <m1@local.test>\r\n"),
+            "{reply}"
+        );
+        assert!(reply.ends_with("\r\n\r\nAway.\r\n"), "{reply}");
+        assert_eq!(inbox_files(&app, "help@local.test").len(), 3);
+    }
+
+    #[tokio::test]
+    async fn account_autoresponse_answers_once_and_skips_lists() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        for user in ["bob@local.test", "carol@local.test", "alice@local.test"] {
+            chatmail_db::passwords::create_user(&pool, user, "bcrypt:x")
+                .await
+                .unwrap();
+        }
+        // The account's autoresponse wins over an operator responder for the same address.
+        chatmail_db::set_responder(&pool, "alice@local.test", "Responder.", 3600)
+            .await
+            .unwrap();
+        chatmail_db::set_autoresponse(
+            &pool,
+            &Autoresponse {
+                username: "alice@local.test".into(),
+                active: true,
+                subject: "On vacation".into(),
+                body: "Back in May.".into(),
+                starts_at: None,
+                ends_at: None,
+                updated_at: 0,
+            },
+        )
+        .await
+        .unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        app.auth.hydrate(&pool).await.unwrap();
+        app.responders.refresh(&pool).await.unwrap();
+        let ctx = DeliveryContext {
+            pool: pool.clone(),
+            state: Arc::clone(&app),
+            primary_domain: "local.test".into(),
+            local_domains: chatmail_types::build_local_domains("local.test", None),
+        };
+
+        let rcpts = ["alice@local.test".to_string()];
+        let plain = b"From: bob@local.test\r\nSubject: hi\r\n\r\nhello";
+        let list = b"From: carol@local.test\r\nList-Id: <dev.local.test>\r\n\r\nhello";
+        for _ in 0..2 {
+            ctx.route_message("bob@local.test", &rcpts, plain)
+                .await
+                .unwrap();
+        }
+        ctx.route_message("carol@local.test", &rcpts, list)
+            .await
+            .unwrap();
+        for _ in 0..100 {
+            if !inbox_files(&app, "bob@local.test").is_empty() {
+                break;
+            }
+            tokio::time::sleep(std::time::Duration::from_millis(20)).await;
+        }
+        tokio::time::sleep(std::time::Duration::from_millis(200)).await;
+        let replies = inbox_files(&app, "bob@local.test");
+        assert_eq!(replies.len(), 1, "{replies:?}");
+        assert!(
+            replies[0].contains("Subject: On vacation\r\n"),
+            "{}",
+            replies[0]
+        );
+        assert!(
+            replies[0].ends_with("\r\n\r\nBack in May.\r\n"),
+            "{}",
+            replies[0]
+        );
+        assert!(inbox_files(&app, "carol@local.test").is_empty());
+        assert!(
+            chatmail_db::last_autoresponse_reply(&pool, "alice@local.test", "bob@local.test")
+                .await
+                .unwrap()
+                .is_some()
+        );
+        assert!(
+            chatmail_db::last_responder_reply(&pool, "alice@local.test", "bob@local.test")
+                .await
+                .unwrap()
+                .is_none()
+        );
+    }
+}
@@ -21,11 +21,12 @@ use std::sync::Arc;
 use chatmail_auth::normalize_username;
 use chatmail_config::QueueSettings;
 use chatmail_db::DbPool;
-use chatmail_state::AppState;
+use chatmail_state::{AppState, LocalRcpt};
 use chatmail_types::{address_domain, address_is_local, ChatmailError, Result};
 use tokio::sync::OnceCell;
 use tracing::{debug, info, warn};
 
+use crate::mail_options::MailOptions;
 use crate::queue::{OutboundQueue, QueueConfig};
 
 #[derive(Debug, Clone)]
@@ -33,6 +34,10 @@ pub struct OutboundJob {
     pub mail_from: String,
     pub rcpt_to: String,
     pub data: Vec<u8>,
+    /// `MAIL FROM` parameters replayed to the next hop (REQUIRETLS, SMTPUTF8, `BODY=`).
+    pub options: MailOptions,
+    /// RFC 6710 `MT-PRIORITY` (-9..=9); positive values are scheduled in the high band.
+    pub priority: i8,
 }
 
 pub struct DeliveryContext {
@@ -71,11 +76,15 @@ impl DeliveryContext {
         mail_from: String,
         rcpt_to: String,
         data: Vec<u8>,
+        options: MailOptions,
     ) -> Result<()> {
+        let priority = crate::priority::classify(&data);
         let job = OutboundJob {
             mail_from,
             rcpt_to,
             data,
+            options,
+            priority,
         };
         let queue = OUTBOUND_QUEUE
             .get()
@@ -85,16 +94,23 @@ impl DeliveryContext {
 
     /// Enqueue a message for many remote recipients at once (federated group fan-out),
     /// writing the body once and hard-linking it into each recipient's queue entry.
+    ///
+    /// `priority` must be our own [`crate::priority::classify`] result unless it came in as
+    /// `MT-PRIORITY` from a trusted peer.
     pub async fn enqueue_remote_batch(
         &self,
         mail_from: &str,
         rcpts: &[String],
         data: &[u8],
+        options: MailOptions,
+        priority: i8,
     ) -> Result<()> {
         let queue = OUTBOUND_QUEUE
             .get()
             .ok_or_else(|| ChatmailError::storage("outbound queue not started"))?;
-        queue.enqueue_batch(mail_from, rcpts, data).await
+        queue
+            .enqueue_batch(mail_from, rcpts, data, options, priority)
+            .await
     }
 
     /// Authenticated mail submission — **shared** by SMTP AUTH (587/465) and WebSMTP.
@@ -106,28 +122,41 @@ impl DeliveryContext {
     /// - federation policy + silent-dismiss on remote RCPT
     ///
     /// Does **not** treat the message as inbound federation (no inbound metrics / policy bypass).
+    /// `options` are the SMTP `MAIL FROM` parameters (REQUIRETLS, SMTPUTF8, `BODY=`); WebSMTP
+    /// passes the defaults.
+    /// A client `MT-PRIORITY` is never honoured here: remote copies get our own classification,
+    /// which puts Secure-Join handshakes in the high queue band.
     pub async fn submit_authenticated(
         &self,
         mail_from: &str,
         recipients: &[String],
         data: &[u8],
+        options: MailOptions,
     ) -> Result<()> {
         self.state.check_message_size(data.len())?;
 
         let ingest_start = std::time::Instant::now();
         let total_rcpts = recipients.len();
         let mut local_deliveries: Vec<(String, String)> = Vec::new();
+        let mut subaddressed: Vec<LocalRcpt> = Vec::new();
         let mut remote_rcpts: Vec<String> = Vec::new();
 
         for raw_rcpt in recipients {
             let rcpt = normalize_username(raw_rcpt)?;
-            self.state.quota.check_quota(&rcpt, data.len() as u64)?;
 
             if self.is_local(&rcpt) {
                 // Authenticated submission may deliver to any local address (SMTP AUTH parity).
-                local_deliveries.push((rcpt, uuid::Uuid::new_v4().to_string()));
+                let local = self.state.resolve_local_rcpt(&rcpt);
+                self.state
+                    .admit_local_delivery(&local.account, data.len())?;
+                if local.tag.is_some() {
+                    subaddressed.push(local);
+                } else {
+                    local_deliveries.push((local.account, uuid::Uuid::new_v4().to_string()));
+                }
                 continue;
             }
+            self.state.quota.check_quota(&rcpt, data.len() as u64)?;
 
             let domain = address_domain(&rcpt).unwrap_or_default();
             let mode = self.state.federation_policy.global_mode();
@@ -145,14 +174,20 @@ impl DeliveryContext {
             remote_rcpts.push(rcpt);
         }
 
+        self.archive_outbound(mail_from, recipients, data).await?;
+
         // Federated group fan-out: one body write + hard-links (same principle as local
         // delivery) rather than a full separate copy per remote recipient.
         let remote_enqueued = remote_rcpts.len();
         if !remote_rcpts.is_empty() {
-            self.enqueue_remote_batch(mail_from, &remote_rcpts, data)
+            let priority = crate::priority::classify(data);
+            let signed = crate::dkim::sign_outbound(self, mail_from, data).await;
+            let outbound = signed.as_deref().unwrap_or(data);
+            self.enqueue_remote_batch(mail_from, &remote_rcpts, outbound, options, priority)
                 .await?;
         }
 
+        let filtered = self.take_filtered(&mut local_deliveries).await;
         let rcpt_phase = ingest_start.elapsed();
         if !local_deliveries.is_empty() {
             let local_n = local_deliveries.len();
@@ -171,7 +206,9 @@ impl DeliveryContext {
                 self.state
                     .notify_inbound_push(&self.pool, mail_from, rcpt)
                     .await;
+                self.state.activity.touch_inbound(&self.pool, rcpt).await;
             }
+            self.spawn_auto_replies(mail_from, outcome.delivered.iter().map(|(r, _)| r), data);
             if !outcome.failed.is_empty() {
                 for (rcpt, _msg_id, err) in &outcome.failed {
                     warn!(rcpt = %rcpt, error = %err, "local delivery failed for recipient");
@@ -202,6 +239,9 @@ impl DeliveryContext {
                 "authenticated submission enqueued for federation"
             );
         }
+        self.deliver_subaddressed(mail_from, &subaddressed, data)
+            .await;
+        self.deliver_filtered(mail_from, &filtered, data).await;
         Ok(())
     }
 
@@ -227,6 +267,8 @@ impl DeliveryContext {
 
         let mut local_deliveries: Vec<(String, String)> = Vec::new();
         let mut remote_rcpts: Vec<String> = Vec::new();
+        let mut unknown_rcpts: Vec<String> = Vec::new();
+        let mut subaddressed: Vec<LocalRcpt> = Vec::new();
 
         for (domain, rcpts) in by_domain {
             if self.local_domains.iter().any(|d| {
@@ -235,12 +277,19 @@ impl DeliveryContext {
                     .any(|f| f.eq_ignore_ascii_case(&domain))
             }) {
                 for rcpt in rcpts {
-                    if !self.state.auth.local_recipient_allowed(&rcpt) {
-                        debug!(rcpt = %rcpt, "silently dropped inbound local delivery");
+                    // `user+tag@` is checked (and charged) as `user@`.
+                    let local = self.state.resolve_local_rcpt(&rcpt);
+                    if !self.state.auth.local_recipient_allowed(&local.account) {
+                        unknown_rcpts.push(local.account);
                         continue;
                     }
-                    self.state.quota.check_quota(&rcpt, data.len() as u64)?;
-                    local_deliveries.push((rcpt, uuid::Uuid::new_v4().to_string()));
+                    self.state
+                        .admit_local_delivery(&local.account, data.len())?;
+                    if local.tag.is_some() {
+                        subaddressed.push(local);
+                    } else {
+                        local_deliveries.push((local.account, uuid::Uuid::new_v4().to_string()));
+                    }
                 }
             } else {
                 let mode = self.state.federation_policy.global_mode();
@@ -264,10 +313,18 @@ impl DeliveryContext {
         // Federated group fan-out: one body write + hard-links (same principle as local
         // delivery) rather than a full separate copy per remote recipient.
         if !remote_rcpts.is_empty() {
-            self.enqueue_remote_batch(mail_from, &remote_rcpts, data)
-                .await?;
+            let priority = crate::priority::classify(data);
+            self.enqueue_remote_batch(
+                mail_from,
+                &remote_rcpts,
+                data,
+                MailOptions::default(),
+                priority,
+            )
+            .await?;
         }
 
+        let filtered = self.take_filtered(&mut local_deliveries).await;
         if !local_deliveries.is_empty() {
             let outcome = chatmail_storage::deliver_local_messages(
                 &self.state.mailbox_store,
@@ -281,7 +338,9 @@ impl DeliveryContext {
                 self.state
                     .notify_inbound_push(&self.pool, mail_from, rcpt)
                     .await;
+                self.state.activity.touch_inbound(&self.pool, rcpt).await;
             }
+            self.spawn_auto_replies(mail_from, outcome.delivered.iter().map(|(r, _)| r), data);
             if !outcome.failed.is_empty() {
                 for (rcpt, _msg_id, err) in &outcome.failed {
                     warn!(rcpt = %rcpt, error = %err, "local delivery failed for recipient");
@@ -293,6 +352,10 @@ impl DeliveryContext {
                 );
             }
         }
+        self.deliver_subaddressed(mail_from, &subaddressed, data)
+            .await;
+        self.deliver_filtered(mail_from, &filtered, data).await;
+        self.deliver_catchall(mail_from, &unknown_rcpts, data).await;
         chatmail_db::record_inbound_delivery();
         Ok(())
     }
@@ -394,7 +457,7 @@ mod tests {
         let recipients: Vec<String> = (0..25).map(|i| format!("u{i}@remote.test")).collect();
         let body = b"From: a@local.test\r\nTo: g@remote.test\r\n\r\nx";
         queue
-            .enqueue_batch("a@local.test", &recipients, body)
+            .enqueue_batch("a@local.test", &recipients, body, MailOptions::default(), 0)
             .await
             .unwrap();
 
@@ -445,7 +508,7 @@ mod tests {
         .unwrap();
 
         queue
-            .enqueue_batch("a@local.test", &[], b"nobody")
+            .enqueue_batch("a@local.test", &[], b"nobody", MailOptions::default(), 0)
             .await
             .unwrap();
         assert_eq!(queue.depth().await.unwrap(), 0);
@@ -475,7 +538,13 @@ mod tests {
 
         let body = b"From: a@local.test\r\nTo: u@remote.test\r\n\r\none";
         queue
-            .enqueue_batch("a@local.test", &["u@remote.test".into()], body)
+            .enqueue_batch(
+                "a@local.test",
+                &["u@remote.test".into()],
+                body,
+                MailOptions::default(),
+                0,
+            )
             .await
             .unwrap();
 
@@ -486,6 +555,7 @@ mod tests {
         let (meta, loaded) = store.load(&id).await.unwrap();
         assert_eq!(meta.mail_from, "a@local.test");
         assert_eq!(meta.rcpt_to, "u@remote.test");
+        assert!(!meta.require_tls);
         assert_eq!(loaded, body);
     }
 
@@ -521,6 +591,8 @@ mod tests {
                     "u2@remote.test".into(),
                 ],
                 b"will-fail",
+                MailOptions::default(),
+                0,
             )
             .await
             .unwrap_err();
@@ -572,5 +644,203 @@ mod tests {
             .unwrap();
 
         assert_eq!(app.auth.len(), 2);
+        let activity = chatmail_db::list_last_activity(&pool).await.unwrap();
+        assert!(activity.contains_key("u1@local.test") && activity.contains_key("u2@local.test"));
+    }
+
+    /// With registration closed, mail to unknown addresses of a domain with a catch-all
+    /// lands in the catch-all mailbox (marked with `X-Original-To`) until the daily cap;
+    /// reserved addresses and existing accounts are unaffected.
+    #[tokio::test]
+    async fn route_message_redirects_unknown_rcpts_to_catchall() {
+        use chatmail_db::settings_keys;
+
+        let pool = init_memory_db().await.unwrap();
+        for key in [
+            settings_keys::JIT_REGISTRATION_ENABLED,
+            settings_keys::REGISTRATION_OPEN,
+        ] {
+            chatmail_db::set_setting(&pool, key, "false").await.unwrap();
+        }
+        for user in ["team@local.test", "u1@local.test"] {
+            chatmail_db::passwords::create_user(&pool, user, "bcrypt:x")
+                .await
+                .unwrap();
+        }
+        chatmail_db::set_catchall(&pool, "local.test", "team@local.test", 2)
+            .await
+            .unwrap();
+
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        app.auth.hydrate(&pool).await.unwrap();
+        app.catchalls.refresh(&pool).await.unwrap();
+        let ctx = DeliveryContext {
+            pool: pool.clone(),
+            state: Arc::clone(&app),
+            primary_domain: "local.test".into(),
+            local_domains: chatmail_types::build_local_domains("local.test", None),
+        };
+
+        let body = b"From: a@remote.test\r\nTo: sales@local.test\r\n\r\nhello";
+        let recipients: Vec<String> = [
+            "u1@local.test",
+            "sales@local.test",
+            "postmaster@local.test",
+            "typo@local.test",
+            "third@local.test",
+        ]
+        .map(String::from)
+        .to_vec();
+        ctx.route_message("a@remote.test", &recipients, body)
+            .await
+            .unwrap();
+
+        let store = &app.mailbox_store;
+        let delivered = chatmail_storage::list_inbox(store, "u1@local.test")
+            .await
+            .unwrap();
+        assert_eq!(delivered.len(), 1);
+        let caught = chatmail_storage::list_inbox(store, "team@local.test")
+            .await
+            .unwrap();
+        assert_eq!(caught.len(), 2, "cap of 2 stops the third unknown address");
+
+        let paths = store.maildir_for_user("team@local.test");
+        let mut originals = Vec::new();
+        for entry in &caught {
+            let raw = std::fs::read(paths.new.join(&entry.msg_id))
+                .or_else(|_| std::fs::read(paths.cur.join(&entry.msg_id)))
+                .unwrap();
+            assert!(raw.ends_with(body), "original message kept intact");
+            let first = raw.split(|&b| b == b'\n').next().unwrap();
+            originals.push(String::from_utf8_lossy(first).trim_end().to_string());
+        }
+        originals.sort();
+        assert_eq!(
+            originals,
+            [
+                "X-Original-To: sales@local.test",
+                "X-Original-To: typo@local.test"
+            ]
+        );
+    }
+
+    /// A recipient's Sieve script files matching mail into Junk instead of INBOX; other mail
+    /// and other recipients still land in INBOX.
+    #[tokio::test]
+    async fn route_message_files_into_junk_by_sieve_script() {
+        let pool = init_memory_db().await.unwrap();
+        for user in ["u1@local.test", "u2@local.test"] {
+            chatmail_db::passwords::create_user(&pool, user, "bcrypt:x")
+                .await
+                .unwrap();
+        }
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState {
+            sieve: Arc::new(chatmail_state::SieveStore::new(
+                Some(dir.path().join("sieve")),
+                chatmail_config::DEFAULT_SIEVE_MAX_SIZE,
+            )),
+            ..AppState::new(dir.path(), pool.clone())
+        });
+        app.auth.hydrate(&pool).await.unwrap();
+        app.sieve
+            .save(
+                "u1@local.test",
+                r#"require "fileinto"; if header :contains "subject" "spam" { fileinto "Junk"; }"#,
+            )
+            .await
+            .unwrap();
+        let ctx = DeliveryContext {
+            pool: pool.clone(),
+            state: Arc::clone(&app),
+            primary_domain: "local.test".into(),
+            local_domains: chatmail_types::build_local_domains("local.test", None),
+        };
+
+        let recipients = vec!["u1@local.test".to_string(), "u2@local.test".to_string()];
+        let spam = b"From: a@remote.test\r\nSubject: Buy SPAM now\r\n\r\nhello";
+        ctx.route_message("a@remote.test", &recipients, spam)
+            .await
+            .unwrap();
+        let ham = b"From: a@remote.test\r\nSubject: lunch\r\n\r\nhello";
+        ctx.route_message("a@remote.test", &recipients[..1], ham)
+            .await
+            .unwrap();
+
+        let store = &app.mailbox_store;
+        let junk = chatmail_storage::list_mailbox_messages(store, "u1@local.test", "Junk")
+            .await
+            .unwrap();
+        assert_eq!(junk.len(), 1);
+        let inbox = chatmail_storage::list_inbox(store, "u1@local.test")
+            .await
+            .unwrap();
+        assert_eq!(
+            inbox.len(),
+            1,
+            "only the message without spam in the subject"
+        );
+        let other = chatmail_storage::list_inbox(store, "u2@local.test")
+            .await
+            .unwrap();
+        assert_eq!(
+            other.len(),
+            1,
+            "recipients without a script are not filtered"
+        );
+    }
+
+    /// `keep` plus `fileinto` writes two copies; each is checked against the quota, so an
+    /// account with room for one gets only the kept copy.
+    #[tokio::test]
+    async fn sieve_copies_are_checked_against_the_quota() {
+        let pool = init_memory_db().await.unwrap();
+        for user in ["u1@local.test", "u2@local.test"] {
+            chatmail_db::passwords::create_user(&pool, user, "bcrypt:x")
+                .await
+                .unwrap();
+        }
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState {
+            sieve: Arc::new(chatmail_state::SieveStore::new(
+                Some(dir.path().join("sieve")),
+                chatmail_config::DEFAULT_SIEVE_MAX_SIZE,
+            )),
+            ..AppState::new(dir.path(), pool.clone())
+        });
+        app.auth.hydrate(&pool).await.unwrap();
+        for user in ["u1@local.test", "u2@local.test"] {
+            app.sieve
+                .save(user, r#"require "fileinto"; keep; fileinto "Archive";"#)
+                .await
+                .unwrap();
+        }
+        let msg = b"From: a@remote.test\r\nSubject: hi\r\n\r\nhello";
+        app.quota
+            .set_max_bytes("u1@local.test", msg.len() as u64 * 3 / 2);
+        let ctx = DeliveryContext {
+            pool: pool.clone(),
+            state: Arc::clone(&app),
+            primary_domain: "local.test".into(),
+            local_domains: chatmail_types::build_local_domains("local.test", None),
+        };
+
+        let recipients = vec!["u1@local.test".to_string(), "u2@local.test".to_string()];
+        ctx.route_message("a@remote.test", &recipients, msg)
+            .await
+            .unwrap();
+
+        let store = &app.mailbox_store;
+        for (user, archived) in [("u1@local.test", 0), ("u2@local.test", 1)] {
+            let inbox = chatmail_storage::list_inbox(store, user).await.unwrap();
+            assert_eq!(inbox.len(), 1, "{user}");
+            let archive = chatmail_storage::list_mailbox_messages(store, user, "Archive")
+                .await
+                .unwrap_or_default();
+            assert_eq!(archive.len(), archived, "{user}");
+        }
+        assert!(app.quota.used_bytes("u1@local.test") <= msg.len() as u64 * 3 / 2);
     }
 }
@@ -0,0 +1,1119 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Sieve filtering (RFC 5228) of local deliveries.
+//!
+//! An account with a script in `sieve_store` ([`chatmail_state::SieveStore`]) has every copy
+//! stored for it run through the script first. The script picks the mailboxes that get the
+//! copy: `fileinto` adds one, `keep` and the implicit keep add the mailbox the copy would
+//! have gone to anyway (INBOX, or the subaddress folder), and `discard` drops it. A stored
+//! script that cannot be read or no longer parses delivers as if there were none.
+//!
+//! Only filing is supported. Commands: `require`, `if` / `elsif` / `else`, `stop`, `keep`,
+//! `discard` and `fileinto` (with `:copy`). Tests: `address`, `envelope`, `header`,
+//! `exists`, `size`, `allof`, `anyof`, `not`, `true` and `false`, matched with `:is`,
+//! `:contains` or `:matches` under the `i;ascii-casemap` (default) or `i;octet` comparator.
+//! Actions that would send mail (`redirect`, `reject`, `vacation`) are refused by
+//! [`parse_sieve`]. Header values are compared unfolded, without MIME decoding.
+
+use chatmail_types::{ChatmailError, Result};
+use tracing::{debug, warn};
+
+use crate::responder::{header_block, unfolded_headers};
+use crate::DeliveryContext;
+
+/// Extensions a script may `require`.
+pub const SIEVE_EXTENSIONS: &[&str] = &[
+    "fileinto",
+    "copy",
+    "envelope",
+    "comparator-i;octet",
+    "comparator-i;ascii-casemap",
+];
+
+const INBOX: &str = "INBOX";
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    Ident(String),
+    Tag(String),
+    Str(String),
+    Num(u64),
+    Punct(char),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum MatchType {
+    Is,
+    Contains,
+    Matches,
+}
+
+#[derive(Debug, Clone, Copy)]
+struct Comparison {
+    match_type: MatchType,
+    /// `i;octet`; the default `i;ascii-casemap` ignores ASCII case.
+    case_sensitive: bool,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum AddressPart {
+    All,
+    LocalPart,
+    Domain,
+}
+
+#[derive(Debug, Clone)]
+enum Test {
+    True,
+    False,
+    Not(Box<Test>),
+    AllOf(Vec<Test>),
+    AnyOf(Vec<Test>),
+    Exists(Vec<String>),
+    Size {
+        over: bool,
+        limit: u64,
+    },
+    Header {
+        cmp: Comparison,
+        names: Vec<String>,
+        keys: Vec<String>,
+    },
+    Address {
+        cmp: Comparison,
+        part: AddressPart,
+        headers: Vec<String>,
+        keys: Vec<String>,
+    },
+    Envelope {
+        cmp: Comparison,
+        part: AddressPart,
+        fields: Vec<String>,
+        keys: Vec<String>,
+    },
+}
+
+#[derive(Debug, Clone)]
+enum Command {
+    If {
+        branches: Vec<(Test, Vec<Command>)>,
+        otherwise: Vec<Command>,
+    },
+    Stop,
+    Keep,
+    Discard,
+    FileInto {
+        mailbox: String,
+        copy: bool,
+    },
+}
+
+/// A script accepted by [`parse_sieve`].
+#[derive(Debug, Clone)]
+pub struct SieveScript {
+    commands: Vec<Command>,
+}
+
+/// Where a script sent one copy.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct SieveOutcome {
+    /// `keep` ran, or nothing cancelled the implicit keep.
+    pub keep: bool,
+    /// `fileinto` targets in script order, without repeats.
+    pub fileinto: Vec<String>,
+}
+
+impl SieveOutcome {
+    /// Mailboxes that get a copy, with `keep_in` standing for the keep; empty when the
+    /// script discarded the message.
+    pub fn mailboxes(&self, keep_in: &str) -> Vec<String> {
+        let targets = self
+            .keep
+            .then_some(keep_in)
+            .into_iter()
+            .chain(self.fileinto.iter().map(String::as_str));
+        let mut out: Vec<String> = Vec::new();
+        for mailbox in targets {
+            if !out.iter().any(|m| same_mailbox(m, mailbox)) {
+                out.push(mailbox.to_string());
+            }
+        }
+        out
+    }
+}
+
+fn same_mailbox(a: &str, b: &str) -> bool {
+    a == b || (a.eq_ignore_ascii_case(INBOX) && b.eq_ignore_ascii_case(INBOX))
+}
+
+/// What a script sees of one delivery.
+#[derive(Debug, Clone)]
+pub struct SieveMessage {
+    mail_from: String,
+    rcpt_to: String,
+    /// `(lowercase name, unfolded value)` in message order.
+    headers: Vec<(String, String)>,
+    size: u64,
+}
+
+impl SieveMessage {
+    pub fn new(mail_from: &str, rcpt_to: &str, raw: &[u8]) -> Self {
+        Self {
+            mail_from: mail_from.to_string(),
+            rcpt_to: rcpt_to.to_string(),
+            headers: unfolded_headers(header_block(raw)),
+            size: raw.len() as u64,
+        }
+    }
+
+    fn header_values<'a>(&'a self, name: &'a str) -> impl Iterator<Item = &'a str> + 'a {
+        self.headers
+            .iter()
+            .filter(move |(n, _)| n.eq_ignore_ascii_case(name))
+            .map(|(_, v)| v.as_str())
+    }
+
+    fn envelope(&self, field: &str) -> &str {
+        if field.eq_ignore_ascii_case("from") {
+            &self.mail_from
+        } else {
+            &self.rcpt_to
+        }
+    }
+}
+
+fn syntax(line: usize, msg: impl std::fmt::Display) -> ChatmailError {
+    ChatmailError::config(format!("sieve line {line}: {msg}"))
+}
+
+fn is_ident_char(c: char) -> bool {
+    c.is_ascii_alphanumeric() || c == '_'
+}
+
+fn lex(source: &str) -> Result<Vec<(Token, usize)>> {
+    let chars: Vec<char> = source.chars().collect();
+    let mut out = Vec::new();
+    let (mut i, mut line) = (0, 1);
+    while i < chars.len() {
+        let c = chars[i];
+        match c {
+            '\n' => {
+                line += 1;
+                i += 1;
+            }
+            c if c.is_whitespace() => i += 1,
+            '#' => {
+                while i < chars.len() && chars[i] != '\n' {
+                    i += 1;
+                }
+            }
+            '/' if chars.get(i + 1) == Some(&'*') => {
+                let start = line;
+                i += 2;
+                loop {
+                    match chars.get(i) {
+                        None => return Err(syntax(start, "unterminated comment")),
+                        Some('*') if chars.get(i + 1) == Some(&'/') => {
+                            i += 2;
+                            break;
+                        }
+                        Some(ch) => {
+                            line += usize::from(*ch == '\n');
+                            i += 1;
+                        }
+                    }
+                }
+            }
+            '"' => {
+                let start = line;
+                let mut s = String::new();
+                i += 1;
+                loop {
+                    let ch = match chars.get(i) {
+                        None => return Err(syntax(start, "unterminated string")),
+                        Some('"') => break,
+                        Some('\\') if i + 1 < chars.len() => {
+                            i += 1;
+                            chars[i]
+                        }
+                        Some(ch) => *ch,
+                    };
+                    line += usize::from(ch == '\n');
+                    s.push(ch);
+                    i += 1;
+                }
+                i += 1;
+                out.push((Token::Str(s), start));
+            }
+            ':' => {
+                let start = i + 1;
+                let mut end = start;
+                while end < chars.len() && is_ident_char(chars[end]) {
+                    end += 1;
+                }
+                if end == start {
+                    return Err(syntax(line, "expected a tag name after ':'"));
+                }
+                let tag: String = chars[start..end].iter().collect();
+                out.push((Token::Tag(tag.to_ascii_lowercase()), line));
+                i = end;
+            }
+            c if c.is_ascii_digit() => {
+                let mut n: u64 = 0;
+                while let Some(d) = chars.get(i).and_then(|c| c.to_digit(10)) {
+                    n = n
+                        .checked_mul(10)
+                        .and_then(|n| n.checked_add(u64::from(d)))
+                        .ok_or_else(|| syntax(line, "number too large"))?;
+                    i += 1;
+                }
+                let scale: u64 = match chars.get(i).map(char::to_ascii_uppercase) {
+                    Some('K') => 1 << 10,
+                    Some('M') => 1 << 20,
+                    Some('G') => 1 << 30,
+                    _ => 1,
+                };
+                i += usize::from(scale > 1);
+                out.push((Token::Num(n.saturating_mul(scale)), line));
+            }
+            c if c.is_ascii_alphabetic() || c == '_' => {
+                let start = i;
+                while i < chars.len() && is_ident_char(chars[i]) {
+                    i += 1;
+                }
+                let word = chars[start..i]
+                    .iter()
+                    .collect::<String>()
+                    .to_ascii_lowercase();
+                if word == "text" && chars.get(i) == Some(&':') {
+                    let start_line = line;
+                    let text = multiline(&chars, &mut i, &mut line)?;
+                    out.push((Token::Str(text), start_line));
+                } else {
+                    out.push((Token::Ident(word), line));
+                }
+            }
+            '[' | ']' | '(' | ')' | '{' | '}' | ',' | ';' => {
+                out.push((Token::Punct(c), line));
+                i += 1;
+            }
+            other => return Err(syntax(line, format!("unexpected character {other:?}"))),
+        }
+    }
+    Ok(out)
+}
+
+/// Body of a `text:` string: `*i` is on the `:`. Lines up to one holding a lone `.`, with
+/// a leading `..` unstuffed to `.`.
+fn multiline(chars: &[char], i: &mut usize, line: &mut usize) -> Result<String> {
+    let start = *line;
+    *i += 1;
+    while matches!(chars.get(*i), Some(' ' | '\t')) {
+        *i += 1;
+    }
+    if chars.get(*i) == Some(&'#') {
+        while *i < chars.len() && chars[*i] != '\n' {
+            *i += 1;
+        }
+    }
+    if chars.get(*i) == Some(&'\r') {
+        *i += 1;
+    }
+    if chars.get(*i) != Some(&'\n') {
+        return Err(syntax(start, "expected a line break after text:"));
+    }
+    *i += 1;
+    *line += 1;
+    let mut text = String::new();
+    loop {
+        if *i >= chars.len() {
+            return Err(syntax(start, "unterminated text: string"));
+        }
+        let end = chars[*i..]
+            .iter()
+            .position(|c| *c == '\n')
+            .map_or(chars.len(), |p| *i + p);
+        let mut l: String = chars[*i..end].iter().collect();
+        if l.ends_with('\r') {
+            l.pop();
+        }
+        *i = (end + 1).min(chars.len());
+        *line += 1;
+        if l == "." {
+            return Ok(text);
+        }
+        text.push_str(if l.starts_with("..") { &l[1..] } else { &l });
+        text.push_str("\r\n");
+    }
+}
+
+struct Parser {
+    tokens: Vec<(Token, usize)>,
+    pos: usize,
+    extensions: Vec<String>,
+}
+
+impl Parser {
+    fn peek(&self) -> Option<&Token> {
+        self.tokens.get(self.pos).map(|(t, _)| t)
+    }
+
+    fn line(&self) -> usize {
+        self.tokens
+            .get(self.pos)
+            .or(self.tokens.last())
+            .map_or(1, |(_, line)| *line)
+    }
+
+    fn err(&self, msg: impl std::fmt::Display) -> ChatmailError {
+        syntax(self.line(), msg)
+    }
+
+    fn next(&mut self) -> Option<Token> {
+        let token = self.tokens.get(self.pos).map(|(t, _)| t.clone());
+        self.pos += usize::from(token.is_some());
+        token
+    }
+
+    fn eat(&mut self, punct: char) -> bool {
+        if self.peek() == Some(&Token::Punct(punct)) {
+            self.pos += 1;
+            return true;
+        }
+        false
+    }
+
+    fn expect(&mut self, punct: char) -> Result<()> {
+        if self.eat(punct) {
+            return Ok(());
+        }
+        Err(self.err(format!("expected '{punct}'")))
+    }
+
+    fn need(&self, extension: &str) -> Result<()> {
+        if self.extensions.iter().any(|e| e == extension) {
+            return Ok(());
+        }
+        Err(self.err(format!("missing require \"{extension}\"")))
+    }
+
+    fn string(&mut self) -> Result<String> {
+        match self.peek() {
+            Some(Token::Str(s)) => {
+                let s = s.clone();
+                self.pos += 1;
+                Ok(s)
+            }
+            _ => Err(self.err("expected a string")),
+        }
+    }
+
+    /// A command or test name.
+    fn identifier(&mut self, what: &str) -> Result<String> {
+        match self.peek() {
+            Some(Token::Ident(name)) => {
+                let name = name.clone();
+                self.pos += 1;
+                Ok(name)
+            }
+            _ => Err(self.err(format!("expected a {what}"))),
+        }
+    }
+
+    /// A string or `["a", "b", …]`.
+    fn string_list(&mut self) -> Result<Vec<String>> {
+        if !self.eat('[') {
+            return Ok(vec![self.string()?]);
+        }
+        let mut list = vec![self.string()?];
+        while self.eat(',') {
+            list.push(self.string()?);
+        }
+        self.expect(']')?;
+        Ok(list)
+    }
+
+    /// Commands up to the end of input (`top_level`) or the `}` closing a block.
+    fn commands(&mut self, top_level: bool) -> Result<Vec<Command>> {
+        let mut commands = Vec::new();
+        loop {
+            match self.peek() {
+                None if top_level => return Ok(commands),
+                None => return Err(self.err("missing '}'")),
+                Some(Token::Punct('}')) if !top_level => return Ok(commands),
+                _ => {}
+            }
+            let name = self.identifier("command")?;
+            if name == "require" {
+                if !top_level || !commands.is_empty() {
+                    return Err(self.err("require must come before any other command"));
+                }
+                for extension in self.string_list()? {
+                    let extension = extension.to_ascii_lowercase();
+                    if !SIEVE_EXTENSIONS.contains(&extension.as_str()) {
+                        return Err(self.err(format!("unsupported extension \"{extension}\"")));
+                    }
+                    self.extensions.push(extension);
+                }
+                self.expect(';')?;
+                continue;
+            }
+            commands.push(self.command(&name)?);
+        }
+    }
+
+    fn block(&mut self) -> Result<Vec<Command>> {
+        self.expect('{')?;
+        let commands = self.commands(false)?;
+        self.expect('}')?;
+        Ok(commands)
+    }
+
+    fn command(&mut self, name: &str) -> Result<Command> {
+        let command = match name {
+            "if" => {
+                let mut branches = vec![(self.test()?, self.block()?)];
+                let mut otherwise = Vec::new();
+                loop {
+                    match self.peek() {
+                        Some(Token::Ident(w)) if w == "elsif" => {
+                            self.pos += 1;
+                            branches.push((self.test()?, self.block()?));
+                        }
+                        Some(Token::Ident(w)) if w == "else" => {
+                            self.pos += 1;
+                            otherwise = self.block()?;
+                            break;
+                        }
+                        _ => break,
+                    }
+                }
+                return Ok(Command::If {
+                    branches,
+                    otherwise,
+                });
+            }
+            "elsif" | "else" => return Err(self.err(format!("{name} without a preceding if"))),
+            "stop" => Command::Stop,
+            "keep" => Command::Keep,
+            "discard" => Command::Discard,
+            "fileinto" => {
+                self.need("fileinto")?;
+                let mut copy = false;
+                while let Some(Token::Tag(tag)) = self.peek() {
+                    if tag != "copy" {
+                        return Err(self.err(format!("unknown fileinto option :{tag}")));
+                    }
+                    self.need("copy")?;
+                    self.pos += 1;
+                    copy = true;
+                }
+                let mailbox = self.string()?;
+                if mailbox.trim().is_empty() {
+                    return Err(self.err("fileinto needs a mailbox name"));
+                }
+                Command::FileInto { mailbox, copy }
+            }
+            "redirect" | "reject" | "ereject" | "vacation" | "notify" => {
+                return Err(self.err(format!(
+                    "{name} is not supported: scripts can only file messages"
+                )));
+            }
+            other => return Err(self.err(format!("unknown command \"{other}\""))),
+        };
+        self.expect(';')?;
+        Ok(command)
+    }
+
+    /// Match type, comparator and (for `address` / `envelope`) address part tags.
+    fn comparison(&mut self, with_part: bool) -> Result<(Comparison, AddressPart)> {
+        let mut cmp = Comparison {
+            match_type: MatchType::Is,
+            case_sensitive: false,
+        };
+        let (mut match_set, mut part) = (false, AddressPart::All);
+        while let Some(Token::Tag(tag)) = self.peek().cloned() {
+            self.pos += 1;
+            match tag.as_str() {
+                "is" | "contains" | "matches" if match_set => {
+                    return Err(self.err("more than one match type"));
+                }
+                "is" | "contains" | "matches" => {
+                    match_set = true;
+                    cmp.match_type = match tag.as_str() {
+                        "is" => MatchType::Is,
+                        "contains" => MatchType::Contains,
+                        _ => MatchType::Matches,
+                    };
+                }
+                "comparator" => {
+                    cmp.case_sensitive = match self.string()?.to_ascii_lowercase().as_str() {
+                        "i;octet" => true,
+                        "i;ascii-casemap" => false,
+                        other => {
+                            return Err(self.err(format!("unsupported comparator \"{other}\"")))
+                        }
+                    };
+                }
+                "all" if with_part => part = AddressPart::All,
+                "localpart" if with_part => part = AddressPart::LocalPart,
+                "domain" if with_part => part = AddressPart::Domain,
+                other => return Err(self.err(format!("unexpected tag :{other}"))),
+            }
+        }
+        Ok((cmp, part))
+    }
+
+    fn test(&mut self) -> Result<Test> {
+        let name = self.identifier("test")?;
+        Ok(match name.as_str() {
+            "true" => Test::True,
+            "false" => Test::False,
+            "not" => Test::Not(Box::new(self.test()?)),
+            "allof" | "anyof" => {
+                self.expect('(')?;
+                let mut tests = vec![self.test()?];
+                while self.eat(',') {
+                    tests.push(self.test()?);
+                }
+                self.expect(')')?;
+                if name == "allof" {
+                    Test::AllOf(tests)
+                } else {
+                    Test::AnyOf(tests)
+                }
+            }
+            "exists" => Test::Exists(self.string_list()?),
+            "size" => {
+                let over = match self.next() {
+                    Some(Token::Tag(t)) if t == "over" => true,
+                    Some(Token::Tag(t)) if t == "under" => false,
+                    _ => return Err(self.err("size needs :over or :under")),
+                };
+                let Some(Token::Num(limit)) = self.next() else {
+                    return Err(self.err("size needs a number"));
+                };
+                Test::Size { over, limit }
+            }
+            "header" => {
+                let (cmp, _) = self.comparison(false)?;
+                Test::Header {
+                    cmp,
+                    names: self.string_list()?,
+                    keys: self.string_list()?,
+                }
+            }
+            "address" => {
+                let (cmp, part) = self.comparison(true)?;
+                Test::Address {
+                    cmp,
+                    part,
+                    headers: self.string_list()?,
+                    keys: self.string_list()?,
+                }
+            }
+            "envelope" => {
+                self.need("envelope")?;
+                let (cmp, part) = self.comparison(true)?;
+                let fields = self.string_list()?;
+                if let Some(bad) = fields
+                    .iter()
+                    .find(|f| !f.eq_ignore_ascii_case("from") && !f.eq_ignore_ascii_case("to"))
+                {
+                    return Err(self.err(format!("unknown envelope part \"{bad}\"")));
+                }
+                Test::Envelope {
+                    cmp,
+                    part,
+                    fields,
+                    keys: self.string_list()?,
+                }
+            }
+            other => return Err(self.err(format!("unknown test \"{other}\""))),
+        })
+    }
+}
+
+/// Parse and check `source`; errors name the line (`sieve line 3: …`).
+pub fn parse_sieve(source: &str) -> Result<SieveScript> {
+    let mut parser = Parser {
+        tokens: lex(source)?,
+        pos: 0,
+        extensions: Vec::new(),
+    };
+    let commands = parser.commands(true)?;
+    Ok(SieveScript { commands })
+}
+
+impl SieveScript {
+    pub fn evaluate(&self, msg: &SieveMessage) -> SieveOutcome {
+        let mut run = Run {
+            implicit_keep: true,
+            outcome: SieveOutcome::default(),
+        };
+        run.commands(&self.commands, msg);
+        run.outcome.keep |= run.implicit_keep;
+        run.outcome
+    }
+}
+
+struct Run {
+    implicit_keep: bool,
+    outcome: SieveOutcome,
+}
+
+impl Run {
+    /// False once `stop` ran.
+    fn commands(&mut self, commands: &[Command], msg: &SieveMessage) -> bool {
+        for command in commands {
+            match command {
+                Command::If {
+                    branches,
+                    otherwise,
+                } => {
+                    let body = branches
+                        .iter()
+                        .find(|(test, _)| test.eval(msg))
+                        .map_or(otherwise, |(_, body)| body);
+                    if !self.commands(body, msg) {
+                        return false;
+                    }
+                }
+                Command::Stop => return false,
+                Command::Keep => self.outcome.keep = true,
+                Command::Discard => self.implicit_keep = false,
+                Command::FileInto { mailbox, copy } => {
+                    self.implicit_keep &= *copy;
+                    if !self.outcome.fileinto.contains(mailbox) {
+                        self.outcome.fileinto.push(mailbox.clone());
+                    }
+                }
+            }
+        }
+        true
+    }
+}
+
+impl Test {
+    fn eval(&self, msg: &SieveMessage) -> bool {
+        match self {
+            Test::True => true,
+            Test::False => false,
+            Test::Not(test) => !test.eval(msg),
+            Test::AllOf(tests) => tests.iter().all(|t| t.eval(msg)),
+            Test::AnyOf(tests) => tests.iter().any(|t| t.eval(msg)),
+            Test::Exists(names) => names
+                .iter()
+                .all(|name| msg.header_values(name).next().is_some()),
+            Test::Size { over: true, limit } => msg.size > *limit,
+            Test::Size { over: false, limit } => msg.size < *limit,
+            Test::Header { cmp, names, keys } => names
+                .iter()
+                .flat_map(|name| msg.header_values(name))
+                .any(|value| cmp.any(value, keys)),
+            Test::Address {
+                cmp,
+                part,
+                headers,
+                keys,
+            } => headers
+                .iter()
+                .flat_map(|name| msg.header_values(name))
+                .flat_map(header_addresses)
+                .any(|addr| cmp.any(address_part(&addr, *part), keys)),
+            Test::Envelope {
+                cmp,
+                part,
+                fields,
+                keys,
+            } => fields
+                .iter()
+                .any(|field| cmp.any(address_part(msg.envelope(field), *part), keys)),
+        }
+    }
+}
+
+impl Comparison {
+    fn any(&self, value: &str, keys: &[String]) -> bool {
+        keys.iter().any(|key| self.matches(value, key))
+    }
+
+    fn matches(&self, value: &str, key: &str) -> bool {
+        let fold = |s: &str| {
+            if self.case_sensitive {
+                s.to_string()
+            } else {
+                s.to_ascii_lowercase()
+            }
+        };
+        let (value, key) = (fold(value), fold(key));
+        match self.match_type {
+            MatchType::Is => value == key,
+            MatchType::Contains => value.contains(&key),
+            MatchType::Matches => {
+                let (p, t): (Vec<char>, Vec<char>) =
+                    (key.chars().collect(), value.chars().collect());
+                glob(&p, &t)
+            }
+        }
+    }
+}
+
+/// `:matches`: `*` is any run of characters, `?` exactly one, `\` escapes the next one.
+fn glob(pattern: &[char], text: &[char]) -> bool {
+    let (mut p, mut t) = (0, 0);
+    let mut star: Option<(usize, usize)> = None;
+    while t < text.len() {
+        match pattern.get(p) {
+            Some('*') => {
+                star = Some((p, t));
+                p += 1;
+                continue;
+            }
+            Some('?') => {
+                p += 1;
+                t += 1;
+                continue;
+            }
+            Some('\\') if pattern.get(p + 1) == Some(&text[t]) => {
+                p += 2;
+                t += 1;
+                continue;
+            }
+            Some(c) if *c != '\\' && *c == text[t] => {
+                p += 1;
+                t += 1;
+                continue;
+            }
+            _ => {}
+        }
+        let Some((star_p, star_t)) = star else {
+            return false;
+        };
+        p = star_p + 1;
+        t = star_t + 1;
+        star = Some((star_p, star_t + 1));
+    }
+    pattern[p..].iter().all(|c| *c == '*')
+}
+
+/// Addresses in an address header value: the `<…>` part of each entry when present, else
+/// the bare entry (group names and trailing `;` removed).
+fn header_addresses(value: &str) -> Vec<String> {
+    let mut entries = Vec::new();
+    let mut current = String::new();
+    let (mut quoted, mut angle) = (false, false);
+    for c in value.chars() {
+        match c {
+            '"' if !angle => quoted = !quoted,
+            '<' if !quoted => angle = true,
+            '>' if !quoted => angle = false,
+            ',' if !quoted && !angle => {
+                entries.push(std::mem::take(&mut current));
+                continue;
+            }
+            _ => {}
+        }
+        current.push(c);
+    }
+    entries.push(current);
+    entries
+        .iter()
+        .filter_map(|entry| {
+            let addr = match entry.rfind('<') {
+                Some(start) => entry[start + 1..].split('>').next().unwrap_or(""),
+                None => entry.rsplit(':').next().unwrap_or("").trim_end_matches(';'),
+            };
+            let addr = addr.trim();
+            (!addr.is_empty()).then(|| addr.to_string())
+        })
+        .collect()
+}
+
+fn address_part(addr: &str, part: AddressPart) -> &str {
+    match (part, addr.rsplit_once('@')) {
+        (AddressPart::All, _) => addr,
+        (AddressPart::LocalPart, Some((local, _))) => local,
+        (AddressPart::LocalPart, None) => addr,
+        (AddressPart::Domain, Some((_, domain))) => domain,
+        (AddressPart::Domain, None) => "",
+    }
+}
+
+impl DeliveryContext {
+    /// How `account`'s script files a copy of `data` for the envelope recipient `rcpt_to`;
+    /// `None` when the account has no usable script.
+    pub async fn sieve_outcome(
+        &self,
+        mail_from: &str,
+        account: &str,
+        rcpt_to: &str,
+        data: &[u8],
+    ) -> Option<SieveOutcome> {
+        let source = match self.state.sieve.load(account).await {
+            Ok(source) => source?,
+            Err(e) => {
+                warn!(%account, error = %e, "sieve: reading the script failed");
+                return None;
+            }
+        };
+        match parse_sieve(&source) {
+            Ok(script) => Some(script.evaluate(&SieveMessage::new(mail_from, rcpt_to, data))),
+            Err(e) => {
+                warn!(%account, error = %e, "sieve: stored script does not parse");
+                None
+            }
+        }
+    }
+
+    /// Move the recipients of `deliveries` whose account has a Sieve script out of the
+    /// plain INBOX fan-out. Store their copies with [`Self::deliver_filtered`].
+    pub async fn take_filtered(&self, deliveries: &mut Vec<(String, String)>) -> Vec<String> {
+        if !self.state.sieve.enabled() {
+            return Vec::new();
+        }
+        let mut filtered = Vec::new();
+        let mut plain = Vec::with_capacity(deliveries.len());
+        for (rcpt, msg_id) in deliveries.drain(..) {
+            if self.state.sieve.has_script(&rcpt).await {
+                filtered.push(rcpt);
+            } else {
+                plain.push((rcpt, msg_id));
+            }
+        }
+        *deliveries = plain;
+        filtered
+    }
+
+    /// Store a copy for each recipient from [`Self::take_filtered`] in the mailboxes its
+    /// script picks. The caller already allowed and quota-checked every recipient.
+    ///
+    /// Returns how many recipients got at least one copy; failures are logged.
+    pub async fn deliver_filtered(&self, mail_from: &str, rcpts: &[String], data: &[u8]) -> usize {
+        let mut delivered: Vec<String> = Vec::new();
+        for rcpt in rcpts {
+            let mailboxes = match self.sieve_outcome(mail_from, rcpt, rcpt, data).await {
+                Some(outcome) => outcome.mailboxes(INBOX),
+                None => vec![INBOX.to_string()],
+            };
+            if mailboxes.is_empty() {
+                debug!(%rcpt, "sieve: message discarded");
+                continue;
+            }
+            if self.store_copies(mail_from, rcpt, &mailboxes, data).await {
+                delivered.push(rcpt.clone());
+            }
+        }
+        self.spawn_auto_replies(mail_from, &delivered, data);
+        delivered.len()
+    }
+
+    /// Write `copy` into each of `account`'s `mailboxes` and announce it. False when no copy
+    /// could be stored; failures are logged per mailbox.
+    ///
+    /// The caller admitted one copy; each one is checked against the quota again before it is
+    /// written, so `keep` plus `fileinto` cannot store past the limit.
+    pub(crate) async fn store_copies(
+        &self,
+        mail_from: &str,
+        account: &str,
+        mailboxes: &[String],
+        copy: &[u8],
+    ) -> bool {
+        let mut stored = false;
+        for mailbox in mailboxes {
+            if let Err(e) = self.state.quota.check_quota(account, copy.len() as u64) {
+                warn!(%account, %mailbox, error = %e, "sieve: no quota left for this copy");
+                break;
+            }
+            let msg_id = uuid::Uuid::new_v4().to_string();
+            if let Err(e) = chatmail_storage::write_blob_mailbox(
+                &self.state.mailbox_store,
+                account,
+                mailbox,
+                &msg_id,
+                copy,
+            )
+            .await
+            {
+                warn!(%account, %mailbox, error = %e, "local delivery into mailbox failed");
+                continue;
+            }
+            self.state.quota.record_write(account, copy.len() as u64);
+            self.state.events.notify_new_message(account, &msg_id);
+            stored = true;
+        }
+        if stored {
+            self.state
+                .notify_inbound_push(&self.pool, mail_from, account)
+                .await;
+            self.state.activity.touch_inbound(&self.pool, account).await;
+        }
+        stored
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const MSG: &[u8] = b"From: \"Spam, Inc.\" <offers@Bulk.example>\r\n\
+To: u@x.org, Other <o@y.org>\r\n\
+Subject: [SPAM] cheap\r\n\
+ offers\r\n\
+List-Id: <deals.bulk.example>\r\n\
+\r\n\
+body\r\n";
+
+    fn run(source: &str) -> SieveOutcome {
+        let script = parse_sieve(source).unwrap_or_else(|e| panic!("{source}: {e}"));
+        script.evaluate(&SieveMessage::new("bounce@bulk.example", "u@x.org", MSG))
+    }
+
+    fn filed(mailboxes: &[&str]) -> SieveOutcome {
+        SieveOutcome {
+            keep: false,
+            fileinto: mailboxes.iter().map(|m| m.to_string()).collect(),
+        }
+    }
+
+    #[test]
+    fn scripts_file_keep_and_discard() {
+        let kept = SieveOutcome {
+            keep: true,
+            fileinto: vec![],
+        };
+        let cases: &[(&str, SieveOutcome)] = &[
+            ("", kept.clone()),
+            ("# nothing\r\n/* at all */", kept.clone()),
+            (
+                r#"require "fileinto"; if header :contains "subject" "spam" { fileinto "Junk"; }"#,
+                filed(&["Junk"]),
+            ),
+            (
+                r#"require ["fileinto", "copy"]; fileinto :copy "Archive";"#,
+                SieveOutcome {
+                    keep: true,
+                    fileinto: vec!["Archive".into()],
+                },
+            ),
+            ("discard;", SieveOutcome::default()),
+            ("discard; keep;", kept.clone()),
+            (
+                r#"require "fileinto"; fileinto "A"; stop; fileinto "B";"#,
+                filed(&["A"]),
+            ),
+            (
+                r#"require "fileinto";
+                if header :is "subject" "nope" { fileinto "A"; }
+                elsif exists "list-id" { fileinto "Lists"; }
+                else { fileinto "B"; }"#,
+                filed(&["Lists"]),
+            ),
+            (
+                r#"require "fileinto";
+                if allof (address :domain "from" "bulk.example", not size :over 1M) {
+                    fileinto "Bulk";
+                }"#,
+                filed(&["Bulk"]),
+            ),
+            (
+                r#"require "fileinto";
+                if anyof (false, address :localpart "to" "o") { fileinto "Cc"; }"#,
+                filed(&["Cc"]),
+            ),
+            (
+                r#"require ["fileinto", "envelope"];
+                if envelope :matches "from" "bounce@*.example" { fileinto "Bounces"; }"#,
+                filed(&["Bounces"]),
+            ),
+            (
+                r#"require "fileinto";
+                if header :matches :comparator "i;octet" "subject" "[spam]*" { fileinto "X"; }"#,
+                kept.clone(),
+            ),
+            (
+                r#"require "fileinto";
+                if header :matches "subject" "*cheap offers" { fileinto "Unfolded"; }"#,
+                filed(&["Unfolded"]),
+            ),
+            (
+                r#"require "fileinto";
+                fileinto text:
+..dotted
+.
+;"#,
+                filed(&[".dotted\r\n"]),
+            ),
+            (
+                r#"require "fileinto"; if size :under 10 { fileinto "Tiny"; }"#,
+                kept,
+            ),
+        ];
+        for (source, want) in cases {
+            assert_eq!(&run(source), want, "{source}");
+        }
+    }
+
+    #[test]
+    fn refuses_unsupported_or_malformed_scripts() {
+        let cases: &[(&str, &str)] = &[
+            (r#"fileinto "Junk";"#, "missing require \"fileinto\""),
+            (r#"require "vacation";"#, "unsupported extension"),
+            (r#"keep; require "fileinto";"#, "require must come before"),
+            (r#"redirect "a@b.org";"#, "redirect is not supported"),
+            ("keep", "expected ';'"),
+            ("if true { keep;", "missing '}'"),
+            ("frobnicate;", "unknown command"),
+            (
+                r#"if header :count "gt" "to" "1" { keep; }"#,
+                "unexpected tag :count",
+            ),
+            ("else { keep; }", "else without a preceding if"),
+            ("keep;\n\"open", "sieve line 2: unterminated string"),
+        ];
+        for (source, want) in cases {
+            let err = parse_sieve(source).unwrap_err().to_string();
+            assert!(err.contains(want), "{source}: {err}");
+        }
+    }
+
+    #[test]
+    fn glob_and_address_helpers() {
+        let g = |p: &str, t: &str| {
+            let (p, t): (Vec<char>, Vec<char>) = (p.chars().collect(), t.chars().collect());
+            glob(&p, &t)
+        };
+        assert!(g("*", ""));
+        assert!(g("a?c*", "abcdef"));
+        assert!(g("\\*x", "*x"));
+        assert!(!g("\\*x", "ax"));
+        assert!(!g("a*b", "acd"));
+        assert_eq!(
+            header_addresses(r#""Doe, J" <j@x.org>, k@y.org, team: a@z.org;"#),
+            ["j@x.org", "k@y.org", "a@z.org"]
+        );
+        assert_eq!(
+            SieveOutcome {
+                keep: true,
+                fileinto: vec!["inbox".into(), "Junk".into()],
+            }
+            .mailboxes(INBOX),
+            ["INBOX", "Junk"]
+        );
+    }
+}
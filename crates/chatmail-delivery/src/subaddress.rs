@@ -0,0 +1,108 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Subaddressed delivery (`subaddress_delimiter`): mail for `user+tag@domain` is stored for
+//! `user`.
+//!
+//! [`chatmail_state::Subaddressing`] maps the recipient onto its account, and callers run
+//! the usual recipient checks against that account; this module stores the copy. Each copy
+//! keeps the original message untouched below prepended `Delivered-To` and `X-Original-To`
+//! headers naming the address as written. Accounts listed under the `subaddress_folder`
+//! policy get the copy filed into their mailbox named after the tag (compared
+//! case-insensitively) when one exists; everything else lands in INBOX. An account with a
+//! Sieve script has that mailbox stand in for the script's keep ([`crate::sieve`]).
+
+use chatmail_state::LocalRcpt;
+use tracing::{debug, warn};
+
+use crate::catchall::ORIGINAL_TO_HEADER;
+use crate::DeliveryContext;
+
+/// Header naming the envelope address a subaddressed copy was delivered to.
+pub const DELIVERED_TO_HEADER: &str = "Delivered-To";
+
+const INBOX: &str = "INBOX";
+
+/// `data` with `Delivered-To` and `X-Original-To` headers for `original` prepended.
+pub fn with_delivered_to(original: &str, data: &[u8]) -> Vec<u8> {
+    let mut out =
+        format!("{DELIVERED_TO_HEADER}: {original}\r\n{ORIGINAL_TO_HEADER}: {original}\r\n")
+            .into_bytes();
+    out.extend_from_slice(data);
+    out
+}
+
+impl DeliveryContext {
+    /// Mailbox that receives the copy for `rcpt`: the existing mailbox named after its tag
+    /// when the account files subaddresses, INBOX otherwise.
+    pub async fn subaddress_mailbox(&self, rcpt: &LocalRcpt) -> String {
+        let Some(tag) = rcpt.tag.as_deref().filter(|t| !t.is_empty()) else {
+            return INBOX.into();
+        };
+        if !self.state.policies.files_subaddresses(&rcpt.account) {
+            return INBOX.into();
+        }
+        match chatmail_storage::list_user_mailboxes(&self.state.mailbox_store, &rcpt.account).await
+        {
+            Ok(names) => names
+                .into_iter()
+                .find(|name| name.eq_ignore_ascii_case(tag))
+                .unwrap_or_else(|| INBOX.into()),
+            Err(e) => {
+                warn!(account = %rcpt.account, error = %e, "subaddress: listing mailboxes failed");
+                INBOX.into()
+            }
+        }
+    }
+
+    /// Store a copy for every subaddressed recipient in `rcpts`, which the caller already
+    /// allowed and quota-checked for their account.
+    ///
+    /// Returns how many copies were stored. Failures are logged per recipient, like the
+    /// plain local fan-out.
+    pub async fn deliver_subaddressed(
+        &self,
+        mail_from: &str,
+        rcpts: &[LocalRcpt],
+        data: &[u8],
+    ) -> usize {
+        let mut delivered: Vec<String> = Vec::new();
+        for rcpt in rcpts {
+            let copy = with_delivered_to(&rcpt.original, data);
+            let keep_in = self.subaddress_mailbox(rcpt).await;
+            let mailboxes = match self
+                .sieve_outcome(mail_from, &rcpt.account, &rcpt.original, &copy)
+                .await
+            {
+                Some(outcome) => outcome.mailboxes(&keep_in),
+                None => vec![keep_in],
+            };
+            if mailboxes.is_empty() {
+                debug!(rcpt = %rcpt.original, "sieve: subaddressed message discarded");
+                continue;
+            }
+            if self
+                .store_copies(mail_from, &rcpt.account, &mailboxes, &copy)
+                .await
+            {
+                delivered.push(rcpt.account.clone());
+            }
+        }
+        self.spawn_auto_replies(mail_from, &delivered, data);
+        delivered.len()
+    }
+}
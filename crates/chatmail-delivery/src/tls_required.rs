@@ -0,0 +1,120 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! RFC 8689 REQUIRETLS and `TLS-Required:` header semantics for outbound federation.
+//!
+//! The `REQUIRETLS` MAIL FROM parameter is carried through the queue on
+//! [`crate::MailOptions::require_tls`]; the worker turns it (or a `TLS-Required: No`
+//! header) into a [`TlsPolicy`] before picking a transport.
+
+/// Enhanced status text for a message that could not be relayed with REQUIRETLS (RFC 8689 §5).
+pub const REQUIRETLS_FAILURE: &str = "5.7.30 REQUIRETLS support required";
+
+/// How strictly one outbound attempt must protect the message in transit.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TlsPolicy {
+    /// REQUIRETLS: verified TLS only, and the next hop must advertise REQUIRETLS.
+    Required,
+    /// Default federation behaviour: STARTTLS when offered, certificates not verified.
+    Opportunistic,
+    /// `TLS-Required: No`: deliver in cleartext when the TLS handshake fails.
+    Relaxed,
+}
+
+impl TlsPolicy {
+    /// RFC 8689 §4.1: the REQUIRETLS parameter wins and the header is then ignored.
+    pub fn for_message(require_tls: bool, data: &[u8]) -> Self {
+        if require_tls {
+            Self::Required
+        } else if tls_required_no(data) {
+            Self::Relaxed
+        } else {
+            Self::Opportunistic
+        }
+    }
+
+    pub fn is_required(self) -> bool {
+        self == Self::Required
+    }
+}
+
+/// `true` when the top-level header section carries `TLS-Required: No` (RFC 8689 §5).
+pub fn tls_required_no(data: &[u8]) -> bool {
+    let Some(value) = header_value(data, "TLS-Required") else {
+        return false;
+    };
+    value.eq_ignore_ascii_case("no")
+}
+
+/// Whether a `MAIL FROM` command line carries the `REQUIRETLS` ESMTP parameter.
+pub fn mail_from_requires_tls(line: &str) -> bool {
+    let params = match line.find('>') {
+        Some(i) => &line[i + 1..],
+        None => line.split_once(char::is_whitespace).map_or("", |(_, r)| r),
+    };
+    params
+        .split_whitespace()
+        .any(|p| p.eq_ignore_ascii_case("REQUIRETLS"))
+}
+
+fn header_value(raw: &[u8], name: &str) -> Option<String> {
+    let text = String::from_utf8_lossy(raw);
+    for line in text.lines() {
+        if line.is_empty() {
+            break;
+        }
+        if line.starts_with(char::is_whitespace) {
+            continue;
+        }
+        if let Some((k, v)) = line.split_once(':') {
+            if k.trim().eq_ignore_ascii_case(name) {
+                return Some(v.trim().to_string());
+            }
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn requiretls_parameter_overrides_header() {
+        let relaxed = b"From: a@x\r\nTLS-Required: No\r\n\r\nbody";
+        assert_eq!(TlsPolicy::for_message(true, relaxed), TlsPolicy::Required);
+        assert_eq!(TlsPolicy::for_message(false, relaxed), TlsPolicy::Relaxed);
+        assert_eq!(
+            TlsPolicy::for_message(false, b"From: a@x\r\n\r\nbody"),
+            TlsPolicy::Opportunistic
+        );
+    }
+
+    #[test]
+    fn tls_required_header_only_read_from_headers() {
+        assert!(tls_required_no(b"tls-required:   no\r\n\r\n"));
+        assert!(!tls_required_no(b"TLS-Required: yes\r\n\r\n"));
+        assert!(!tls_required_no(b"From: a@x\r\n\r\nTLS-Required: No\r\n"));
+    }
+
+    #[test]
+    fn mail_from_parameter_detection() {
+        assert!(mail_from_requires_tls("MAIL FROM:<a@x> REQUIRETLS"));
+        assert!(mail_from_requires_tls("MAIL FROM:<a@x> SIZE=10 requiretls"));
+        assert!(!mail_from_requires_tls("MAIL FROM:<a@x> SIZE=10"));
+        assert!(!mail_from_requires_tls("MAIL FROM:<requiretls@x>"));
+    }
+}
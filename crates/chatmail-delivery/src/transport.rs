@@ -15,13 +15,16 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use chatmail_state::PeerHints;
 use chatmail_types::is_ipv4_literal;
 use reqwest::Client;
 use tracing::debug;
 use tracing::warn;
 
-use crate::federation_http::federation_http_client;
+use crate::federation_http::{federation_http_client, federation_https_verified_client};
+use crate::mail_options::{MAIL_OPTIONS_HEADER, SMTPUTF8_FAILURE};
 use crate::router::{DeliveryContext, OutboundJob};
+use crate::tls_required::{TlsPolicy, REQUIRETLS_FAILURE};
 
 #[derive(Debug)]
 pub enum DeliveryOutcome {
@@ -37,6 +40,8 @@ enum FederationTarget {
     MxdelivUrl(String),
     /// Hostname or IP — use `https://{host}/mxdeliv` then `http://{host}/mxdeliv`.
     Host(String),
+    /// `smtp://host[:port]` override: plain SMTP to that endpoint only, no `/mxdeliv`.
+    Smtp(String),
 }
 
 pub async fn deliver_remote(ctx: &DeliveryContext, job: &OutboundJob) -> DeliveryOutcome {
@@ -61,17 +66,48 @@ pub async fn deliver_remote(ctx: &DeliveryContext, job: &OutboundJob) -> Deliver
         };
     }
 
+    // A verified peer document states the size limit; don't send what will be refused.
+    let hints = ctx.state.peers.hints_for(&domain);
+    if let Some(limit) = hints
+        .map(|h| h.max_message_size)
+        .filter(|limit| job.data.len() as u64 > *limit)
+    {
+        warn!(
+            rcpt = %job.rcpt_to,
+            size = job.data.len(),
+            limit,
+            "federation: message exceeds the peer's advertised size limit"
+        );
+        return DeliveryOutcome::Permanent {
+            reason: format!(
+                "message is {} bytes; {domain} accepts at most {limit} (peer federation info)",
+                job.data.len()
+            ),
+        };
+    }
+
     ctx.state.federation_tracker.increment_queue(&domain);
 
     let target = resolve_federation_target(ctx, &domain).await;
-    let client = federation_http_client();
+    let tls_policy = TlsPolicy::for_message(job.options.require_tls, &job.data);
+    // REQUIRETLS: only verified HTTPS counts; never fall back to plain HTTP.
+    let allow_http = !tls_policy.is_required();
+    let client = if allow_http {
+        federation_http_client()
+    } else {
+        federation_https_verified_client()
+    };
     // HELO/EHLO must identify *this* server, not the remote MX host.
     let helo = helo_name_for(ctx);
 
     let http_reason = match &target {
+        FederationTarget::Smtp(endpoint) => {
+            debug!(%endpoint, rcpt = %job.rcpt_to, "federation: smtp:// endpoint override");
+            "skipped: smtp:// endpoint override".to_string()
+        }
         FederationTarget::MxdelivUrl(url) => {
             debug!(%url, rcpt = %job.rcpt_to, "federation: endpoint rewrite URL");
-            match try_mxdeliv_url(client, url, job).await {
+            match try_mxdeliv_url(client, url, job, allow_http).await {
                 Ok(method) => {
                     record_success(ctx, &domain, method);
                     return DeliveryOutcome::Success;
@@ -94,7 +130,8 @@ pub async fn deliver_remote(ctx: &DeliveryContext, job: &OutboundJob) -> Deliver
         }
         FederationTarget::Host(host) => {
             debug!(%host, rcpt = %job.rcpt_to, "federation: resolved host");
-            match try_mxdeliv_host(client, host, job).await {
+            let (https, http) = mxdeliv_schemes(hints.as_ref(), job.data.len());
+            match try_mxdeliv_host(client, host, job, https, http && allow_http).await {
                 Ok(method) => {
                     record_success(ctx, &domain, method);
                     return DeliveryOutcome::Success;
@@ -113,17 +150,51 @@ pub async fn deliver_remote(ctx: &DeliveryContext, job: &OutboundJob) -> Deliver
         }
     };
 
-    let smtp_host = match &target {
-        FederationTarget::Host(h) => h.clone(),
+    let (smtp_host, smtp_result) = match &target {
+        FederationTarget::Smtp(endpoint) => {
+            let result =
+                crate::federation_smtp::deliver_to_host(endpoint, &helo, job, tls_policy).await;
+            (endpoint.clone(), result)
+        }
+        FederationTarget::Host(h) => {
+            let result = crate::federation_smtp::deliver(h, job, &helo, tls_policy).await;
+            (h.clone(), result)
+        }
         FederationTarget::MxdelivUrl(url) => {
-            host_from_mxdeliv_url(url).unwrap_or_else(|| domain.clone())
+            let host = host_from_mxdeliv_url(url).unwrap_or_else(|| domain.clone());
+            let result = crate::federation_smtp::deliver(&host, job, &helo, tls_policy).await;
+            (host, result)
         }
     };
-    match crate::federation_smtp::deliver(&smtp_host, job, &helo).await {
+    match smtp_result {
         Ok(()) => {
             record_success(ctx, &domain, "SMTP");
             DeliveryOutcome::Success
         }
+        Err(e) if e.requiretls => {
+            warn!(
+                rcpt = %job.rcpt_to,
+                host = %smtp_host,
+                error = %e,
+                "federation: REQUIRETLS could not be honoured, failing message"
+            );
+            record_failure(ctx, &domain, "SMTP");
+            DeliveryOutcome::Permanent {
+                reason: format!("{REQUIRETLS_FAILURE} (http: {http_reason}; smtp: {e})"),
+            }
+        }
+        Err(e) if e.smtputf8 => {
+            warn!(
+                rcpt = %job.rcpt_to,
+                host = %smtp_host,
+                error = %e,
+                "federation: next hop cannot take an SMTPUTF8 message, failing it"
+            );
+            record_failure(ctx, &domain, "SMTP");
+            DeliveryOutcome::Permanent {
+                reason: format!("{SMTPUTF8_FAILURE} (http: {http_reason}; smtp: {e})"),
+            }
+        }
         Err(e) => {
             warn!(
                 rcpt = %job.rcpt_to,
@@ -139,7 +210,7 @@ pub async fn deliver_remote(ctx: &DeliveryContext, job: &OutboundJob) -> Deliver
     }
 }
 
-fn helo_name_for(ctx: &DeliveryContext) -> String {
+pub(crate) fn helo_name_for(ctx: &DeliveryContext) -> String {
     let raw = ctx.primary_domain.trim();
     if raw.is_empty() {
         return "localhost".into();
@@ -158,19 +229,44 @@ struct PostError {
     permanent: bool,
 }
 
-/// HTTPS then HTTP to `https://{host}/mxdeliv` / `http://{host}/mxdeliv`.
+/// `(https, http)`: which `/mxdeliv` schemes to try for a message of `size` bytes. Without
+/// peer hints both are tried; a verified peer document drops the schemes it does not list,
+/// and both when the message is over its `/mxdeliv` body cap (SMTP still gets a chance).
+fn mxdeliv_schemes(hints: Option<&PeerHints>, size: usize) -> (bool, bool) {
+    match hints {
+        None => (true, true),
+        Some(h) => {
+            let fits = size as u64 <= h.max_http_body;
+            (h.https && fits, h.http && fits)
+        }
+    }
+}
+
+/// HTTPS then HTTP to `https://{host}/mxdeliv` / `http://{host}/mxdeliv`, each only when
+/// enabled.
 async fn try_mxdeliv_host(
     client: &Client,
     host: &str,
     job: &OutboundJob,
+    https: bool,
+    http: bool,
 ) -> Result<&'static str, PostError> {
+    let http_url = format!("http://{host}/mxdeliv");
+    if !https {
+        if !http {
+            return Err(PostError {
+                reason: "skipped: peer federation info rules out /mxdeliv".into(),
+                permanent: false,
+            });
+        }
+        return post_mxdeliv(client, &http_url, job).await.map(|()| "HTTP");
+    }
     let https_url = format!("https://{host}/mxdeliv");
     match post_mxdeliv(client, &https_url, job).await {
         Ok(()) => Ok("HTTPS"),
-        Err(e) if e.permanent => Err(e),
+        Err(e) if e.permanent || !http => Err(e),
         Err(e) => {
             debug!(%https_url, error = %e.reason, "federation: HTTPS failed, trying HTTP");
-            let http_url = format!("http://{host}/mxdeliv");
             match post_mxdeliv(client, &http_url, job).await {
                 Ok(()) => Ok("HTTP"),
                 Err(e2) => Err(PostError {
@@ -187,10 +283,17 @@ async fn try_mxdeliv_url(
     client: &Client,
     url: &str,
     job: &OutboundJob,
+    allow_http: bool,
 ) -> Result<&'static str, PostError> {
+    if !allow_http && !url.starts_with("https://") {
+        return Err(PostError {
+            reason: "REQUIRETLS: cleartext endpoint skipped".into(),
+            permanent: false,
+        });
+    }
     match post_mxdeliv(client, url, job).await {
         Ok(()) => Ok(scheme_label(url)),
-        Err(e) if e.permanent => Err(e),
+        Err(e) if e.permanent || !allow_http => Err(e),
         Err(e) if url.starts_with("https://") => {
             let http_url = url.replacen("https://", "http://", 1);
             match post_mxdeliv(client, &http_url, job).await {
@@ -205,11 +308,17 @@ async fn try_mxdeliv_url(
     }
 }
 
+/// Addresses go out as raw UTF-8 header bytes; the `MAIL FROM` parameters ride along in
+/// `X-Mail-Options` so the receiver can restore them.
 async fn post_mxdeliv(client: &Client, url: &str, job: &OutboundJob) -> Result<(), PostError> {
-    let res = client
+    let mut req = client
         .post(url)
         .header("X-Mail-From", &job.mail_from)
-        .header("X-Mail-To", &job.rcpt_to)
+        .header("X-Mail-To", &job.rcpt_to);
+    if let Some(options) = job.options.header_value() {
+        req = req.header(MAIL_OPTIONS_HEADER, options);
+    }
+    let res = req
         .body(job.data.clone())
         .send()
         .await
@@ -309,6 +418,9 @@ async fn resolve_federation_target(ctx: &DeliveryContext, domain: &str) -> Feder
         if let Some((h,)) = row {
             let h = h.trim();
             if !h.is_empty() {
+                if let Some(rest) = h.strip_prefix("smtp://") {
+                    return FederationTarget::Smtp(smtp_override_endpoint(rest));
+                }
                 if h.contains("://") {
                     return FederationTarget::MxdelivUrl(normalize_rewrite_url(h));
                 }
@@ -319,6 +431,21 @@ async fn resolve_federation_target(ctx: &DeliveryContext, domain: &str) -> Feder
     FederationTarget::Host(mxdeliv_host_for_url(domain))
 }
 
+/// `host:port` for an `smtp://host[:port]` override; port 25 when none is given.
+fn smtp_override_endpoint(rest: &str) -> String {
+    let host_port = rest.trim().trim_end_matches('/');
+    let has_port = host_port.rsplit_once(':').is_some_and(|(host, port)| {
+        !port.is_empty()
+            && port.bytes().all(|b| b.is_ascii_digit())
+            && (!host.contains(':') || host.ends_with(']'))
+    });
+    if has_port {
+        host_port.to_string()
+    } else {
+        format!("{host_port}:25")
+    }
+}
+
 /// Match Madmail endpoint_cache key forms (`1.1.1.1` vs `[1.1.1.1]`).
 fn lookup_keys(domain: &str) -> Vec<String> {
     let lower = domain.to_ascii_lowercase();
@@ -336,6 +463,7 @@ fn lookup_keys(domain: &str) -> Vec<String> {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::mail_options::{BodyType, MailOptions};
 
     #[test]
     fn normalize_rewrite_appends_mxdeliv() {
@@ -345,4 +473,88 @@ mod tests {
             "https://relay.example.com/mxdeliv"
         );
     }
+
+    #[test]
+    fn smtp_override_defaults_to_port_25() {
+        assert_eq!(smtp_override_endpoint("127.0.0.1:2525"), "127.0.0.1:2525");
+        assert_eq!(smtp_override_endpoint("mx.example/"), "mx.example:25");
+        assert_eq!(smtp_override_endpoint("[::1]"), "[::1]:25");
+        assert_eq!(smtp_override_endpoint("[::1]:2525"), "[::1]:2525");
+    }
+
+    #[test]
+    fn peer_hints_narrow_mxdeliv_schemes() {
+        let hints = PeerHints {
+            https: false,
+            http: true,
+            smtp: true,
+            max_message_size: 10_000,
+            max_http_body: 1_000,
+        };
+        assert_eq!(mxdeliv_schemes(None, 5_000), (true, true));
+        assert_eq!(mxdeliv_schemes(Some(&hints), 500), (false, true));
+        assert_eq!(mxdeliv_schemes(Some(&hints), 5_000), (false, false));
+    }
+
+    /// `/mxdeliv` carries an SMTPUTF8 recipient as raw UTF-8 and the `MAIL FROM` parameters
+    /// in `X-Mail-Options`; the 8-bit body goes out unchanged.
+    #[tokio::test]
+    async fn post_mxdeliv_sends_utf8_addresses_and_mail_options() {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        const TAIL: &[u8] = b"caf\xe9\r\n";
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        let server = tokio::spawn(async move {
+            let (mut stream, _) = listener.accept().await.unwrap();
+            let mut req = Vec::new();
+            let mut buf = [0u8; 4096];
+            while !req.ends_with(TAIL) {
+                let n = stream.read(&mut buf).await.unwrap();
+                if n == 0 {
+                    break;
+                }
+                req.extend_from_slice(&buf[..n]);
+            }
+            stream
+                .write_all(b"HTTP/1.1 200 OK\r\ncontent-length: 2\r\nconnection: close\r\n\r\nOK")
+                .await
+                .unwrap();
+            req
+        });
+
+        let mut data = b"Subject: x\r\n\r\n".to_vec();
+        data.extend_from_slice(TAIL);
+        let job = OutboundJob {
+            mail_from: "sender@local.test".into(),
+            rcpt_to: "j\u{f6}rg@remote.test".into(),
+            data: data.clone(),
+            options: MailOptions {
+                smtputf8: true,
+                body: BodyType::EightBitMime,
+                ..MailOptions::default()
+            },
+            priority: 0,
+        };
+        post_mxdeliv(
+            federation_http_client(),
+            &format!("http://{addr}/mxdeliv"),
+            &job,
+        )
+        .await
+        .map_err(|e| e.reason)
+        .unwrap();
+
+        let req = server.await.unwrap();
+        assert!(req.ends_with(&data));
+        let end = req.windows(4).position(|w| w == b"\r\n\r\n").unwrap();
+        let head = String::from_utf8(req[..end].to_vec())
+            .unwrap()
+            .to_ascii_lowercase();
+        assert!(head.contains("x-mail-to: j\u{f6}rg@remote.test"), "{head}");
+        assert!(
+            head.contains("x-mail-options: smtputf8 body=8bitmime"),
+            "{head}"
+        );
+    }
 }
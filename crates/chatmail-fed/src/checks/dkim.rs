@@ -0,0 +1,629 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! DKIM verification (RFC 6376) for `rsa-sha256` and `ed25519-sha256` (RFC 8463).
+//!
+//! `rsa-sha1` is refused (RFC 8301). At most [`MAX_SIGNATURES`] signatures are verified per
+//! message; RSA keys from 1024 bits are accepted, as RFC 8301 requires of verifiers.
+
+use base64::engine::general_purpose::STANDARD as B64;
+use base64::Engine as _;
+use chatmail_state::{DnsError, DnsResolver};
+use ring::digest::{digest, SHA256};
+use ring::signature::{UnparsedPublicKey, ED25519, RSA_PKCS1_1024_8192_SHA256_FOR_LEGACY_USE_ONLY};
+
+use super::HeaderField;
+
+/// Signatures beyond this many are ignored (each one costs a DNS lookup).
+pub const MAX_SIGNATURES: usize = 5;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DkimStatus {
+    Pass,
+    Fail,
+    Neutral,
+    TempError,
+    PermError,
+}
+
+impl DkimStatus {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Pass => "pass",
+            Self::Fail => "fail",
+            Self::Neutral => "neutral",
+            Self::TempError => "temperror",
+            Self::PermError => "permerror",
+        }
+    }
+}
+
+/// Result for one `DKIM-Signature` field.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DkimResult {
+    pub status: DkimStatus,
+    /// `d=` (lowercase), empty when missing.
+    pub domain: String,
+    /// `s=`
+    pub selector: String,
+    /// First 8 characters of `b=` (RFC 6008 `header.b`).
+    pub b_prefix: String,
+    pub reason: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Algorithm {
+    RsaSha256,
+    Ed25519Sha256,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Canon {
+    Simple,
+    Relaxed,
+}
+
+#[derive(Debug)]
+struct Signature {
+    algorithm: Algorithm,
+    signature: Vec<u8>,
+    body_hash: Vec<u8>,
+    header_canon: Canon,
+    body_canon: Canon,
+    domain: String,
+    headers: Vec<String>,
+    identity: Option<String>,
+    body_length: Option<usize>,
+    selector: String,
+    expires: Option<u64>,
+}
+
+/// Verify every `DKIM-Signature` (up to [`MAX_SIGNATURES`]); empty when the message has none.
+pub async fn verify_message(
+    headers: &[HeaderField<'_>],
+    body: &[u8],
+    dns: &dyn DnsResolver,
+    now: u64,
+) -> Vec<DkimResult> {
+    let mut out = Vec::new();
+    for field in headers
+        .iter()
+        .filter(|f| f.name == "dkim-signature")
+        .take(MAX_SIGNATURES)
+    {
+        out.push(verify_one(field, headers, body, dns, now).await);
+    }
+    out
+}
+
+async fn verify_one(
+    field: &HeaderField<'_>,
+    headers: &[HeaderField<'_>],
+    body: &[u8],
+    dns: &dyn DnsResolver,
+    now: u64,
+) -> DkimResult {
+    let tags = parse_tags(&field.value());
+    let mut result = DkimResult {
+        status: DkimStatus::PermError,
+        domain: tag(&tags, "d").to_ascii_lowercase(),
+        selector: tag(&tags, "s").to_string(),
+        b_prefix: tag(&tags, "b")
+            .chars()
+            .filter(|c| !c.is_whitespace())
+            .take(8)
+            .collect(),
+        reason: None,
+    };
+    let sig = match parse_signature(&tags) {
+        Ok(sig) => sig,
+        Err(reason) => return result.with(DkimStatus::PermError, reason),
+    };
+    if sig.expires.is_some_and(|x| x < now) {
+        return result.with(DkimStatus::Fail, "signature expired");
+    }
+
+    let canon_body = canonical_body(body, sig.body_canon);
+    let hashed_body = match sig.body_length {
+        Some(l) if l > canon_body.len() => {
+            return result.with(DkimStatus::PermError, "l= exceeds body length")
+        }
+        Some(l) => &canon_body[..l],
+        None => &canon_body[..],
+    };
+    if digest(&SHA256, hashed_body).as_ref() != sig.body_hash.as_slice() {
+        return result.with(DkimStatus::Fail, "body hash mismatch");
+    }
+
+    let key = match lookup_key(dns, &sig).await {
+        Ok(key) => key,
+        Err((status, reason)) => return result.with(status, &reason),
+    };
+
+    let data = signed_data(field, headers, &sig);
+    let verified = match sig.algorithm {
+        Algorithm::RsaSha256 => {
+            let pkcs1 = spki_rsa_key(&key).unwrap_or(key.as_slice());
+            UnparsedPublicKey::new(&RSA_PKCS1_1024_8192_SHA256_FOR_LEGACY_USE_ONLY, pkcs1)
+                .verify(&data, &sig.signature)
+        }
+        Algorithm::Ed25519Sha256 => UnparsedPublicKey::new(&ED25519, &key)
+            .verify(digest(&SHA256, &data).as_ref(), &sig.signature),
+    };
+    match verified {
+        Ok(()) => {
+            result.status = DkimStatus::Pass;
+            result
+        }
+        Err(_) => result.with(DkimStatus::Fail, "signature did not verify"),
+    }
+}
+
+impl DkimResult {
+    fn with(mut self, status: DkimStatus, reason: &str) -> Self {
+        self.status = status;
+        self.reason = Some(reason.to_string());
+        self
+    }
+}
+
+/// `tag=value` pairs of a DKIM tag-list, in order; names are trimmed, values keep inner spaces.
+fn parse_tags(value: &str) -> Vec<(String, String)> {
+    value
+        .split(';')
+        .filter_map(|part| {
+            let (name, value) = part.split_once('=')?;
+            Some((name.trim().to_string(), value.trim().to_string()))
+        })
+        .collect()
+}
+
+fn tag<'a>(tags: &'a [(String, String)], name: &str) -> &'a str {
+    tags.iter()
+        .find(|(n, _)| n == name)
+        .map(|(_, v)| v.as_str())
+        .unwrap_or("")
+}
+
+fn decode_b64(value: &str) -> Option<Vec<u8>> {
+    let compact: String = value.chars().filter(|c| !c.is_whitespace()).collect();
+    B64.decode(compact).ok()
+}
+
+fn parse_signature(tags: &[(String, String)]) -> Result<Signature, &'static str> {
+    for required in ["v", "a", "b", "bh", "d", "h", "s"] {
+        if tag(tags, required).is_empty() {
+            return Err("missing required tag");
+        }
+    }
+    if tag(tags, "v") != "1" {
+        return Err("unsupported version");
+    }
+    let algorithm = match tag(tags, "a").to_ascii_lowercase().as_str() {
+        "rsa-sha256" => Algorithm::RsaSha256,
+        "ed25519-sha256" => Algorithm::Ed25519Sha256,
+        "rsa-sha1" => return Err("rsa-sha1 is not accepted"),
+        _ => return Err("unknown algorithm"),
+    };
+    let (header_canon, body_canon) = match tag(tags, "c").to_ascii_lowercase().as_str() {
+        "" | "simple" | "simple/simple" => (Canon::Simple, Canon::Simple),
+        "relaxed" | "relaxed/simple" => (Canon::Relaxed, Canon::Simple),
+        "simple/relaxed" => (Canon::Simple, Canon::Relaxed),
+        "relaxed/relaxed" => (Canon::Relaxed, Canon::Relaxed),
+        _ => return Err("unknown canonicalization"),
+    };
+    let headers: Vec<String> = tag(tags, "h")
+        .split(':')
+        .map(|h| h.trim().to_ascii_lowercase())
+        .filter(|h| !h.is_empty())
+        .collect();
+    if !headers.iter().any(|h| h == "from") {
+        return Err("From is not signed");
+    }
+    let domain = tag(tags, "d").to_ascii_lowercase();
+    let identity = Some(tag(tags, "i"))
+        .filter(|i| !i.is_empty())
+        .map(|i| i.to_ascii_lowercase());
+    if let Some(i) = &identity {
+        let i_domain = i.rsplit_once('@').map(|(_, d)| d).unwrap_or("");
+        if i_domain != domain && !i_domain.ends_with(&format!(".{domain}")) {
+            return Err("i= is not within d=");
+        }
+    }
+    let body_length = match tag(tags, "l") {
+        "" => None,
+        l => Some(l.parse().map_err(|_| "invalid l=")?),
+    };
+    let expires = match tag(tags, "x") {
+        "" => None,
+        x => Some(x.parse().map_err(|_| "invalid x=")?),
+    };
+    Ok(Signature {
+        algorithm,
+        signature: decode_b64(tag(tags, "b")).ok_or("invalid b=")?,
+        body_hash: decode_b64(tag(tags, "bh")).ok_or("invalid bh=")?,
+        header_canon,
+        body_canon,
+        domain,
+        headers,
+        identity,
+        body_length,
+        selector: tag(tags, "s").to_string(),
+        expires,
+    })
+}
+
+async fn lookup_key(
+    dns: &dyn DnsResolver,
+    sig: &Signature,
+) -> Result<Vec<u8>, (DkimStatus, String)> {
+    let name = format!("{}._domainkey.{}", sig.selector, sig.domain);
+    let records = match dns.txt(&name).await {
+        Ok(r) => r,
+        Err(DnsError::NotFound) => return Err((DkimStatus::PermError, "no key".into())),
+        Err(e) => return Err((DkimStatus::TempError, e.to_string())),
+    };
+    let Some(record) = records.first() else {
+        return Err((DkimStatus::PermError, "no key".into()));
+    };
+    let tags = parse_tags(record);
+    let version = tag(&tags, "v");
+    if !version.is_empty() && version != "DKIM1" {
+        return Err((DkimStatus::PermError, "bad key record".into()));
+    }
+    let key_type = match tag(&tags, "k") {
+        "" | "rsa" => Algorithm::RsaSha256,
+        "ed25519" => Algorithm::Ed25519Sha256,
+        _ => return Err((DkimStatus::PermError, "unknown key type".into())),
+    };
+    if key_type != sig.algorithm {
+        return Err((DkimStatus::PermError, "key type does not match a=".into()));
+    }
+    let hashes = tag(&tags, "h");
+    if !hashes.is_empty() && !hashes.split(':').any(|h| h.trim() == "sha256") {
+        return Err((DkimStatus::PermError, "key does not allow sha256".into()));
+    }
+    if tag(&tags, "t").split(':').any(|f| f.trim() == "s") {
+        let strict = sig
+            .identity
+            .as_deref()
+            .and_then(|i| i.rsplit_once('@'))
+            .is_none_or(|(_, d)| d == sig.domain);
+        if !strict {
+            return Err((
+                DkimStatus::PermError,
+                "i= subdomain not allowed by t=s".into(),
+            ));
+        }
+    }
+    match tag(&tags, "p") {
+        "" => Err((DkimStatus::PermError, "key revoked".into())),
+        p => decode_b64(p).ok_or((DkimStatus::PermError, "invalid key".into())),
+    }
+}
+
+/// Canonicalized signed header fields followed by the signature field with `b=` emptied.
+fn signed_data(field: &HeaderField<'_>, headers: &[HeaderField<'_>], sig: &Signature) -> Vec<u8> {
+    let mut data = Vec::new();
+    // Each name in h= consumes the next instance from the bottom; missing ones sign nothing.
+    let mut used = vec![false; headers.len()];
+    for name in &sig.headers {
+        let pick = (0..headers.len())
+            .rev()
+            .find(|&i| !used[i] && headers[i].name == *name);
+        if let Some(i) = pick {
+            used[i] = true;
+            data.extend_from_slice(&canonical_header(headers[i].raw, sig.header_canon));
+        }
+    }
+    let unsigned = strip_b_value(field.raw);
+    let mut canon = canonical_header(&unsigned, sig.header_canon);
+    while canon.ends_with(b"\r\n") {
+        canon.truncate(canon.len() - 2);
+    }
+    data.extend_from_slice(&canon);
+    data
+}
+
+/// The raw signature field with the value of its `b=` tag removed.
+fn strip_b_value(raw: &[u8]) -> Vec<u8> {
+    let text = String::from_utf8_lossy(raw);
+    let Some((name, value)) = text.split_once(':') else {
+        return raw.to_vec();
+    };
+    let parts: Vec<String> = value
+        .split(';')
+        .map(|part| match part.split_once('=') {
+            Some((tag, _)) if tag.trim() == "b" => format!("{tag}="),
+            _ => part.to_string(),
+        })
+        .collect();
+    let mut out = format!("{name}:{}", parts.join(";"));
+    if !out.ends_with("\r\n") && text.ends_with("\r\n") {
+        out.push_str("\r\n");
+    }
+    out.into_bytes()
+}
+
+fn is_wsp(b: u8) -> bool {
+    b == b' ' || b == b'\t'
+}
+
+fn canonical_header(raw: &[u8], canon: Canon) -> Vec<u8> {
+    if canon == Canon::Simple {
+        return raw.to_vec();
+    }
+    let colon = raw.iter().position(|&b| b == b':').unwrap_or(raw.len());
+    let name = String::from_utf8_lossy(&raw[..colon])
+        .trim()
+        .to_ascii_lowercase();
+    let mut value = Vec::new();
+    let mut pending_space = false;
+    for &b in raw.get(colon + 1..).unwrap_or_default() {
+        match b {
+            b'\r' | b'\n' => {}
+            b if is_wsp(b) => pending_space = true,
+            b => {
+                if pending_space && !value.is_empty() {
+                    value.push(b' ');
+                }
+                pending_space = false;
+                value.push(b);
+            }
+        }
+    }
+    let mut out = name.into_bytes();
+    out.push(b':');
+    out.extend_from_slice(&value);
+    out.extend_from_slice(b"\r\n");
+    out
+}
+
+fn canonical_body(body: &[u8], canon: Canon) -> Vec<u8> {
+    let mut lines: Vec<Vec<u8>> = Vec::new();
+    let mut rest = body;
+    while !rest.is_empty() {
+        let (line, next) = match rest.windows(2).position(|w| w == b"\r\n") {
+            Some(i) => (&rest[..i], &rest[i + 2..]),
+            None => (rest, &rest[rest.len()..]),
+        };
+        lines.push(match canon {
+            Canon::Simple => line.to_vec(),
+            Canon::Relaxed => {
+                let mut out = Vec::with_capacity(line.len());
+                let mut pending_space = false;
+                for &b in line {
+                    if is_wsp(b) {
+                        pending_space = true;
+                    } else {
+                        if pending_space {
+                            out.push(b' ');
+                        }
+                        pending_space = false;
+                        out.push(b);
+                    }
+                }
+                out
+            }
+        });
+        rest = next;
+    }
+    while lines.last().is_some_and(|l| l.is_empty()) {
+        lines.pop();
+    }
+    if lines.is_empty() {
+        return match canon {
+            Canon::Simple => b"\r\n".to_vec(),
+            Canon::Relaxed => Vec::new(),
+        };
+    }
+    let mut out = Vec::with_capacity(body.len() + 2);
+    for line in lines {
+        out.extend_from_slice(&line);
+        out.extend_from_slice(b"\r\n");
+    }
+    out
+}
+
+/// RSAPublicKey inside a SubjectPublicKeyInfo (the usual `p=` encoding); `None` if not SPKI.
+fn spki_rsa_key(der: &[u8]) -> Option<&[u8]> {
+    let (tag, spki, _) = der_tlv(der)?;
+    if tag != 0x30 {
+        return None;
+    }
+    let (alg_tag, _, rest) = der_tlv(spki)?;
+    let (bits_tag, bits, _) = der_tlv(rest)?;
+    if alg_tag != 0x30 || bits_tag != 0x03 || bits.first() != Some(&0) {
+        return None;
+    }
+    Some(&bits[1..])
+}
+
+/// One DER element: tag, contents, and the bytes after it.
+fn der_tlv(der: &[u8]) -> Option<(u8, &[u8], &[u8])> {
+    let tag = *der.first()?;
+    let first = *der.get(1)? as usize;
+    let (len, header) = if first < 0x80 {
+        (first, 2)
+    } else {
+        let n = first & 0x7f;
+        if n == 0 || n > 3 {
+            return None;
+        }
+        let len = der
+            .get(2..2 + n)?
+            .iter()
+            .fold(0usize, |acc, &b| (acc << 8) | b as usize);
+        (len, 2 + n)
+    };
+    let contents = der.get(header..header + len)?;
+    Some((tag, contents, &der[header + len..]))
+}
+
+/// Test helper: sign `message` with `ed25519-sha256`, relaxed/relaxed, signing From, To and
+/// Subject. Returns the message with the `DKIM-Signature` field prepended and the key record.
+#[cfg(test)]
+pub(crate) fn sign_ed25519(message: &[u8], domain: &str, selector: &str) -> (Vec<u8>, String) {
+    use ring::rand::SystemRandom;
+    use ring::signature::{Ed25519KeyPair, KeyPair};
+
+    let pkcs8 = Ed25519KeyPair::generate_pkcs8(&SystemRandom::new()).unwrap();
+    let pair = Ed25519KeyPair::from_pkcs8(pkcs8.as_ref()).unwrap();
+    let record = format!(
+        "v=DKIM1; k=ed25519; p={}",
+        B64.encode(pair.public_key().as_ref())
+    );
+
+    let (headers, body) = super::split_message(message);
+    let bh = B64.encode(digest(&SHA256, &canonical_body(body, Canon::Relaxed)));
+    let field = format!(
+        "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d={domain};\r\n s={selector}; h=from:to:subject; bh={bh}; b=\r\n"
+    );
+    let sig_field = HeaderField {
+        name: "dkim-signature".into(),
+        offset: 0,
+        raw: field.as_bytes(),
+    };
+    let sig = Signature {
+        algorithm: Algorithm::Ed25519Sha256,
+        signature: Vec::new(),
+        body_hash: Vec::new(),
+        header_canon: Canon::Relaxed,
+        body_canon: Canon::Relaxed,
+        domain: domain.into(),
+        headers: vec!["from".into(), "to".into(), "subject".into()],
+        identity: None,
+        body_length: None,
+        selector: selector.into(),
+        expires: None,
+    };
+    let data = signed_data(&sig_field, &headers, &sig);
+    let b = B64.encode(pair.sign(digest(&SHA256, &data).as_ref()));
+    let mut out = field.trim_end_matches("\r\n").to_string().into_bytes();
+    out.extend_from_slice(b.as_bytes());
+    out.extend_from_slice(b"\r\n");
+    out.extend_from_slice(message);
+    (out, record)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_state::StaticResolver;
+
+    const MSG: &[u8] = b"From: Alice <alice@peer.example>\r\nTo: bob@example.org\r\n\
+        Subject: hi\r\n\r\nHello  there \r\n\r\n\r\n";
+
+    async fn verify(message: &[u8], dns: &StaticResolver) -> Vec<DkimResult> {
+        let (headers, body) = super::super::split_message(message);
+        verify_message(&headers, body, dns, 0).await
+    }
+
+    /// RFC 6376 §3.4.5 example.
+    #[test]
+    fn relaxed_canonicalization_matches_rfc_example() {
+        assert_eq!(
+            canonical_header(b"A: X\r\n", Canon::Relaxed),
+            b"a:X\r\n".to_vec()
+        );
+        assert_eq!(
+            canonical_header(b"B : Y\t\r\n\tZ  \r\n", Canon::Relaxed),
+            b"b:Y Z\r\n".to_vec()
+        );
+        assert_eq!(
+            canonical_body(b" C \r\nD \t E\r\n\r\n\r\n", Canon::Relaxed),
+            b" C\r\nD E\r\n".to_vec()
+        );
+        assert_eq!(canonical_body(b"", Canon::Simple), b"\r\n".to_vec());
+        assert_eq!(canonical_body(b"", Canon::Relaxed), Vec::<u8>::new());
+    }
+
+    #[tokio::test]
+    async fn ed25519_signature_passes_and_tampering_fails() {
+        let (signed, record) = sign_ed25519(MSG, "peer.example", "sel");
+        let dns = StaticResolver::default().with_txt("sel._domainkey.peer.example", &record);
+        let results = verify(&signed, &dns).await;
+        assert_eq!(results.len(), 1);
+        assert_eq!(results[0].status, DkimStatus::Pass, "{:?}", results[0]);
+        assert_eq!(results[0].domain, "peer.example");
+        assert_eq!(results[0].b_prefix.len(), 8);
+
+        let body_edit = [&signed[..signed.len() - 6], b"!\r\n\r\n\r\n"].concat();
+        let results = verify(&body_edit, &dns).await;
+        assert_eq!(results[0].status, DkimStatus::Fail);
+        assert_eq!(results[0].reason.as_deref(), Some("body hash mismatch"));
+
+        let text = String::from_utf8(signed)
+            .unwrap()
+            .replace("Subject: hi", "Subject: ho");
+        let results = verify(text.as_bytes(), &dns).await;
+        assert_eq!(results[0].status, DkimStatus::Fail);
+    }
+
+    /// Outbound signatures from `chatmail_delivery::dkim` pass this verifier.
+    #[tokio::test]
+    async fn outbound_signer_passes_for_each_key_type() {
+        use chatmail_delivery::dkim::{generate_key, read_key, sign_message, DkimAlgorithm};
+
+        let dir = tempfile::tempdir().unwrap();
+        for algorithm in [DkimAlgorithm::Rsa2048, DkimAlgorithm::Ed25519] {
+            let selector = algorithm.as_str();
+            let key = generate_key(dir.path(), "peer.example", selector, algorithm).unwrap();
+            let private = read_key(dir.path(), "peer.example", selector).unwrap();
+            let field = sign_message(MSG, "peer.example", selector, &private, 0).unwrap();
+            let signed = [field.as_bytes(), MSG].concat();
+            let dns = StaticResolver::default().with_txt(&key.dns_name, &key.dns_value);
+            let results = verify(&signed, &dns).await;
+            assert_eq!(
+                results[0].status,
+                DkimStatus::Pass,
+                "{algorithm:?}: {results:?}"
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn missing_or_revoked_key_is_permerror() {
+        let (signed, _) = sign_ed25519(MSG, "peer.example", "sel");
+        let results = verify(&signed, &StaticResolver::default()).await;
+        assert_eq!(results[0].status, DkimStatus::PermError);
+        let dns = StaticResolver::default().with_txt("sel._domainkey.peer.example", "v=DKIM1; p=");
+        let results = verify(&signed, &dns).await;
+        assert_eq!(results[0].reason.as_deref(), Some("key revoked"));
+    }
+
+    #[tokio::test]
+    async fn rsa_sha1_is_refused() {
+        let msg =
+            b"DKIM-Signature: v=1; a=rsa-sha1; d=peer.example; s=s; h=from; bh=AA==; b=AA==\r\n\
+            From: a@peer.example\r\n\r\nx";
+        let results = verify(msg, &StaticResolver::default()).await;
+        assert_eq!(results[0].status, DkimStatus::PermError);
+        assert_eq!(
+            results[0].reason.as_deref(),
+            Some("rsa-sha1 is not accepted")
+        );
+    }
+
+    #[test]
+    fn unwraps_rsa_key_from_spki() {
+        // SEQUENCE { SEQUENCE { } BIT STRING { 0x00, SEQUENCE { } } }
+        let der = [0x30, 0x07, 0x30, 0x00, 0x03, 0x03, 0x00, 0x30, 0x00];
+        assert_eq!(spki_rsa_key(&der[..]), Some(&[0x30, 0x00][..]));
+        assert_eq!(spki_rsa_key(&[0x02, 0x01, 0x05][..]), None, "bare INTEGER");
+    }
+}
@@ -0,0 +1,323 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! DMARC (RFC 7489) evaluation against the DKIM (and optionally SPF) results.
+//!
+//! The organizational domain is approximated without the Public Suffix List: the last two
+//! labels, or three under a few common two-label suffixes ([`MULTI_LABEL_SUFFIXES`]).
+
+use chatmail_state::{DnsError, DnsResolver};
+
+use super::dkim::{DkimResult, DkimStatus};
+use super::spf::SpfResult;
+use super::HeaderField;
+
+/// Public suffixes with two labels that are common enough to matter for alignment.
+pub const MULTI_LABEL_SUFFIXES: &[&str] = &[
+    "co.uk", "org.uk", "ac.uk", "gov.uk", "com.au", "net.au", "org.au", "co.nz", "co.jp", "ne.jp",
+    "or.jp", "com.br", "com.cn", "com.tr", "co.za", "co.in", "co.kr", "com.mx",
+];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DmarcStatus {
+    Pass,
+    Fail,
+    /// No DMARC record for the From domain.
+    None,
+    TempError,
+    PermError,
+}
+
+impl DmarcStatus {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Pass => "pass",
+            Self::Fail => "fail",
+            Self::None => "none",
+            Self::TempError => "temperror",
+            Self::PermError => "permerror",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DmarcPolicy {
+    None,
+    Quarantine,
+    Reject,
+}
+
+impl DmarcPolicy {
+    fn parse(s: &str) -> Option<Self> {
+        match s.trim().to_ascii_lowercase().as_str() {
+            "none" => Some(Self::None),
+            "quarantine" => Some(Self::Quarantine),
+            "reject" => Some(Self::Reject),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::None => "none",
+            Self::Quarantine => "quarantine",
+            Self::Reject => "reject",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DmarcOutcome {
+    pub status: DmarcStatus,
+    /// RFC5322.From domain, empty when it could not be determined.
+    pub from_domain: String,
+    /// Policy that applies to the From domain (`sp=` for subdomains), when a record exists.
+    pub policy: Option<DmarcPolicy>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Record {
+    policy: DmarcPolicy,
+    subdomain_policy: Option<DmarcPolicy>,
+    strict_dkim: bool,
+    strict_spf: bool,
+}
+
+/// Evaluate DMARC for the message's From domain.
+///
+/// `spf` is the SPF result and `X-Mail-From` domain when SPF may satisfy alignment.
+pub async fn evaluate(
+    headers: &[HeaderField<'_>],
+    dkim: &[DkimResult],
+    spf: Option<(SpfResult, &str)>,
+    dns: &dyn DnsResolver,
+) -> DmarcOutcome {
+    let mut from = headers.iter().filter(|f| f.name == "from");
+    let from_domain = match (from.next(), from.next()) {
+        (Some(field), None) => from_header_domain(&field.value()),
+        _ => None,
+    };
+    let Some(from_domain) = from_domain else {
+        return DmarcOutcome {
+            status: DmarcStatus::PermError,
+            from_domain: String::new(),
+            policy: None,
+        };
+    };
+    let mut outcome = DmarcOutcome {
+        status: DmarcStatus::None,
+        from_domain,
+        policy: None,
+    };
+
+    let org = organizational_domain(&outcome.from_domain);
+    let (record, at_org) = match fetch_record(dns, &outcome.from_domain).await {
+        Ok(Some(r)) => (r, false),
+        Ok(None) if org != outcome.from_domain => match fetch_record(dns, &org).await {
+            Ok(Some(r)) => (r, true),
+            Ok(None) => return outcome,
+            Err(status) => return outcome.with(status),
+        },
+        Ok(None) => return outcome,
+        Err(status) => return outcome.with(status),
+    };
+    outcome.policy = Some(match (at_org, record.subdomain_policy) {
+        (true, Some(sp)) => sp,
+        _ => record.policy,
+    });
+
+    let aligned = |domain: &str, strict: bool| {
+        if strict {
+            domain == outcome.from_domain
+        } else {
+            organizational_domain(domain) == org
+        }
+    };
+    let dkim_aligned = dkim
+        .iter()
+        .any(|r| r.status == DkimStatus::Pass && aligned(&r.domain, record.strict_dkim));
+    let spf_aligned = spf.is_some_and(|(result, domain)| {
+        result == SpfResult::Pass && aligned(domain, record.strict_spf)
+    });
+    outcome.status = if dkim_aligned || spf_aligned {
+        DmarcStatus::Pass
+    } else {
+        DmarcStatus::Fail
+    };
+    outcome
+}
+
+impl DmarcOutcome {
+    fn with(mut self, status: DmarcStatus) -> Self {
+        self.status = status;
+        self
+    }
+}
+
+/// `Ok(None)` when there is no (or more than one) DMARC record at `_dmarc.{domain}`.
+async fn fetch_record(dns: &dyn DnsResolver, domain: &str) -> Result<Option<Record>, DmarcStatus> {
+    let records = match dns.txt(&format!("_dmarc.{domain}")).await {
+        Ok(r) => r,
+        Err(DnsError::NotFound) => return Ok(None),
+        Err(DnsError::Temporary(_)) => return Err(DmarcStatus::TempError),
+    };
+    let mut dmarc = records.iter().filter(|r| {
+        r.split(';')
+            .next()
+            .is_some_and(|v| v.replace(' ', "").eq_ignore_ascii_case("v=DMARC1"))
+    });
+    match (dmarc.next(), dmarc.next()) {
+        (Some(record), None) => Ok(parse_record(record)),
+        _ => Ok(None),
+    }
+}
+
+fn parse_record(record: &str) -> Option<Record> {
+    let mut out = Record {
+        policy: DmarcPolicy::None,
+        subdomain_policy: None,
+        strict_dkim: false,
+        strict_spf: false,
+    };
+    let mut has_policy = false;
+    for part in record.split(';').skip(1) {
+        let Some((name, value)) = part.split_once('=') else {
+            continue;
+        };
+        let value = value.trim();
+        match name.trim().to_ascii_lowercase().as_str() {
+            "p" => {
+                out.policy = DmarcPolicy::parse(value)?;
+                has_policy = true;
+            }
+            "sp" => out.subdomain_policy = DmarcPolicy::parse(value),
+            "adkim" => out.strict_dkim = value.eq_ignore_ascii_case("s"),
+            "aspf" => out.strict_spf = value.eq_ignore_ascii_case("s"),
+            _ => {}
+        }
+    }
+    // RFC 7489 §6.6.3: a record without a valid p= is treated as having none.
+    has_policy.then_some(out)
+}
+
+/// Domain of the single address in a From field value; `None` for zero or several addresses.
+fn from_header_domain(value: &str) -> Option<String> {
+    let mut in_quote = false;
+    let mut depth = 0usize;
+    let mut addresses = 1;
+    let mut unquoted = String::new();
+    for c in value.chars() {
+        match c {
+            '"' if depth == 0 => in_quote = !in_quote,
+            '(' if !in_quote => depth += 1,
+            ')' if !in_quote && depth > 0 => depth -= 1,
+            ',' if !in_quote && depth == 0 => addresses += 1,
+            c if !in_quote && depth == 0 => unquoted.push(c),
+            _ => {}
+        }
+    }
+    if addresses != 1 {
+        return None;
+    }
+    let addr = match (unquoted.rfind('<'), unquoted.rfind('>')) {
+        (Some(open), Some(close)) if open < close => &unquoted[open + 1..close],
+        _ => unquoted.as_str(),
+    };
+    let domain = super::domain_of(addr.trim());
+    (!domain.is_empty()).then_some(domain)
+}
+
+/// Approximate organizational domain; see the module docs.
+pub fn organizational_domain(domain: &str) -> String {
+    let domain = domain.trim_end_matches('.').to_ascii_lowercase();
+    let labels: Vec<&str> = domain.split('.').collect();
+    let keep = if labels.len() >= 3
+        && MULTI_LABEL_SUFFIXES.contains(&labels[labels.len() - 2..].join(".").as_str())
+    {
+        3
+    } else {
+        2
+    };
+    labels[labels.len().saturating_sub(keep)..].join(".")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_state::StaticResolver;
+
+    fn pass(domain: &str) -> DkimResult {
+        DkimResult {
+            status: DkimStatus::Pass,
+            domain: domain.into(),
+            selector: "s".into(),
+            b_prefix: String::new(),
+            reason: None,
+        }
+    }
+
+    async fn run(from: &str, dkim: &[DkimResult], dns: &StaticResolver) -> DmarcOutcome {
+        let msg = format!("From: {from}\r\n\r\nx");
+        let (headers, _) = super::super::split_message(msg.as_bytes());
+        evaluate(&headers, dkim, None, dns).await
+    }
+
+    #[test]
+    fn organizational_domain_handles_common_suffixes() {
+        assert_eq!(organizational_domain("mail.peer.example"), "peer.example");
+        assert_eq!(organizational_domain("a.b.example.co.uk"), "example.co.uk");
+        assert_eq!(organizational_domain("example"), "example");
+    }
+
+    #[test]
+    fn from_domain_skips_display_names_and_comments() {
+        assert_eq!(
+            from_header_domain("\"Eve <eve@evil.test>\" (x@y.z) <a@Peer.Example>").as_deref(),
+            Some("peer.example")
+        );
+        assert_eq!(from_header_domain("a@one.test, b@two.test"), None);
+    }
+
+    #[tokio::test]
+    async fn relaxed_alignment_passes_for_subdomain_signature() {
+        let dns = StaticResolver::default().with_txt("_dmarc.peer.example", "v=DMARC1; p=reject");
+        let out = run("a@peer.example", &[pass("mail.peer.example")], &dns).await;
+        assert_eq!(out.status, DmarcStatus::Pass);
+        assert_eq!(out.policy, Some(DmarcPolicy::Reject));
+
+        let dns = StaticResolver::default()
+            .with_txt("_dmarc.peer.example", "v=DMARC1; p=reject; adkim=s");
+        let out = run("a@peer.example", &[pass("mail.peer.example")], &dns).await;
+        assert_eq!(out.status, DmarcStatus::Fail);
+    }
+
+    #[tokio::test]
+    async fn subdomain_falls_back_to_org_record_and_sp() {
+        let dns = StaticResolver::default()
+            .with_txt("_dmarc.peer.example", "v=DMARC1; p=reject; sp=quarantine");
+        let out = run("a@news.peer.example", &[], &dns).await;
+        assert_eq!(out.status, DmarcStatus::Fail);
+        assert_eq!(out.policy, Some(DmarcPolicy::Quarantine));
+    }
+
+    #[tokio::test]
+    async fn no_record_is_none() {
+        let out = run("a@peer.example", &[], &StaticResolver::default()).await;
+        assert_eq!(out.status, DmarcStatus::None);
+        assert_eq!(out.policy, None);
+    }
+}
@@ -0,0 +1,367 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! SPF, DKIM and DMARC for messages arriving over `/mxdeliv` (`federation_checks`).
+//!
+//! [`check_federated_message`] runs the checks the policy enables, decides whether the message
+//! is rejected, and renders the `Authentication-Results` header (RFC 8601) that is prepended
+//! before delivery. The header names the transport (`http-federation`) so filters and users
+//! can tell these messages from SMTP ones. Results claiming this server's authserv-id that
+//! arrive with the message are removed first.
+
+pub mod dkim;
+pub mod dmarc;
+pub mod spf;
+
+use std::net::IpAddr;
+
+use chatmail_config::{CheckMode, DmarcAlignment, FederationChecks};
+use chatmail_state::DnsResolver;
+
+use self::dkim::{DkimResult, DkimStatus};
+use self::dmarc::{DmarcOutcome, DmarcPolicy, DmarcStatus};
+use self::spf::SpfResult;
+
+/// Transport recorded in the `Authentication-Results` comment.
+pub const FEDERATION_TRANSPORT: &str = "http-federation";
+
+/// One header field: lowercase name plus the raw bytes (folded lines and CRLF included).
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct HeaderField<'a> {
+    pub name: String,
+    /// Byte offset of the field in the message.
+    pub offset: usize,
+    pub raw: &'a [u8],
+}
+
+impl HeaderField<'_> {
+    /// Unfolded value after the colon, trimmed.
+    pub fn value(&self) -> String {
+        let raw = String::from_utf8_lossy(self.raw);
+        let value = raw.split_once(':').map(|(_, v)| v).unwrap_or("");
+        value
+            .replace("\r\n", "")
+            .replace('\n', "")
+            .trim()
+            .to_string()
+    }
+}
+
+/// Split a message into header fields and body (the bytes after the empty line).
+pub fn split_message(message: &[u8]) -> (Vec<HeaderField<'_>>, &[u8]) {
+    let mut fields: Vec<HeaderField<'_>> = Vec::new();
+    let mut pos = 0;
+    while pos < message.len() {
+        let line_end = message[pos..]
+            .iter()
+            .position(|&b| b == b'\n')
+            .map(|i| pos + i + 1)
+            .unwrap_or(message.len());
+        let line = &message[pos..line_end];
+        if line == b"\r\n" || line == b"\n" {
+            return (fields, &message[line_end..]);
+        }
+        let continuation = matches!(line.first(), Some(b' ' | b'\t'));
+        match fields.last_mut() {
+            Some(last) if continuation => last.raw = &message[last.offset..line_end],
+            _ => {
+                let name = line
+                    .iter()
+                    .position(|&b| b == b':')
+                    .map(|i| {
+                        String::from_utf8_lossy(&line[..i])
+                            .trim()
+                            .to_ascii_lowercase()
+                    })
+                    .unwrap_or_default();
+                fields.push(HeaderField {
+                    name,
+                    offset: pos,
+                    raw: line,
+                });
+            }
+        }
+        pos = line_end;
+    }
+    (fields, &message[message.len()..])
+}
+
+/// Outcome of the enabled checks for one message.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct IngressVerdict {
+    /// Full `Authentication-Results:` field, CRLF-terminated.
+    pub header: String,
+    /// Set when an `enforce` check failed; the reason is logged, not sent to the peer.
+    pub reject: Option<String>,
+}
+
+/// Run `checks` against a `/mxdeliv` message.
+///
+/// `peer` is the HTTP client address (SPF), `mail_from` the `X-Mail-From` envelope sender.
+pub async fn check_federated_message(
+    checks: &FederationChecks,
+    dns: &dyn DnsResolver,
+    authserv_id: &str,
+    peer: Option<IpAddr>,
+    mail_from: &str,
+    message: &[u8],
+) -> IngressVerdict {
+    let (headers, body) = split_message(message);
+    let mail_from_domain = domain_of(mail_from);
+    let mut results: Vec<String> = Vec::new();
+    let mut reject = None;
+
+    let spf = if checks.spf.is_on() {
+        let result = match peer {
+            Some(ip) if !mail_from_domain.is_empty() => {
+                spf::check_host(dns, ip, &mail_from_domain).await
+            }
+            _ => SpfResult::None,
+        };
+        let client = peer
+            .map(|ip| format!(" (client-ip={ip})"))
+            .unwrap_or_default();
+        results.push(format!(
+            "spf={}{client} smtp.mailfrom={}",
+            result.as_str(),
+            or_none(&mail_from_domain)
+        ));
+        if checks.spf == CheckMode::Enforce && result == SpfResult::Fail {
+            reject = Some(format!("spf=fail for {mail_from_domain}"));
+        }
+        Some(result)
+    } else {
+        None
+    };
+
+    // DMARC needs the DKIM results even when they are not reported.
+    let dkim = if checks.dkim.is_on() || checks.dmarc.is_on() {
+        dkim::verify_message(&headers, body, dns, unix_now()).await
+    } else {
+        Vec::new()
+    };
+    if checks.dkim.is_on() {
+        if dkim.is_empty() {
+            results.push("dkim=none".into());
+        }
+        results.extend(dkim.iter().map(DkimResult::resinfo));
+        let passed = dkim.iter().any(|r| r.status == DkimStatus::Pass);
+        if checks.dkim == CheckMode::Enforce && !passed && reject.is_none() {
+            reject = Some("no valid DKIM signature".into());
+        }
+    }
+
+    if checks.dmarc.is_on() {
+        let spf_for_dmarc = match (checks.dmarc_alignment, spf) {
+            (DmarcAlignment::Any, Some(result)) => Some((result, mail_from_domain.as_str())),
+            _ => None,
+        };
+        let outcome = dmarc::evaluate(&headers, &dkim, spf_for_dmarc, dns).await;
+        results.push(outcome.resinfo());
+        if checks.dmarc == CheckMode::Enforce && reject.is_none() && outcome.rejects() {
+            reject = Some(format!("dmarc=fail (p=reject) for {}", outcome.from_domain));
+        }
+    }
+
+    IngressVerdict {
+        header: authentication_results(authserv_id, &results),
+        reject,
+    }
+}
+
+/// `Authentication-Results: {authserv_id} (transport=http-federation); ...`
+pub fn authentication_results(authserv_id: &str, results: &[String]) -> String {
+    let mut out =
+        format!("Authentication-Results: {authserv_id} (transport={FEDERATION_TRANSPORT});");
+    if results.is_empty() {
+        out.push_str(" none");
+    }
+    for (i, r) in results.iter().enumerate() {
+        out.push_str("\r\n\t");
+        out.push_str(r);
+        if i + 1 < results.len() {
+            out.push(';');
+        }
+    }
+    out.push_str("\r\n");
+    out
+}
+
+/// `message` without `Authentication-Results` fields that claim `authserv_id` (RFC 8601 §5),
+/// with `header` prepended.
+pub fn with_authentication_results(message: &[u8], authserv_id: &str, header: &str) -> Vec<u8> {
+    let (fields, body) = split_message(message);
+    let mut out = Vec::with_capacity(message.len() + header.len());
+    out.extend_from_slice(header.as_bytes());
+    if fields.is_empty() {
+        out.extend_from_slice(message);
+        return out;
+    }
+    let header_end = message.len() - body.len();
+    let mut pos = 0;
+    for field in &fields {
+        if field.name == "authentication-results" && claims_authserv_id(field, authserv_id) {
+            out.extend_from_slice(&message[pos..field.offset]);
+            pos = field.offset + field.raw.len();
+        }
+    }
+    out.extend_from_slice(&message[pos..header_end]);
+    out.extend_from_slice(body);
+    out
+}
+
+fn claims_authserv_id(field: &HeaderField<'_>, authserv_id: &str) -> bool {
+    let value = field.value();
+    let id = value
+        .split(|c: char| c == ';' || c == '(' || c.is_whitespace())
+        .next()
+        .unwrap_or("");
+    id.eq_ignore_ascii_case(authserv_id)
+}
+
+/// Lowercase domain part of an address (`""` for the null sender).
+pub fn domain_of(addr: &str) -> String {
+    addr.trim()
+        .trim_matches(|c| c == '<' || c == '>')
+        .rsplit_once('@')
+        .map(|(_, d)| d.trim_end_matches('.').to_ascii_lowercase())
+        .unwrap_or_default()
+}
+
+fn or_none(s: &str) -> &str {
+    if s.is_empty() {
+        "<>"
+    } else {
+        s
+    }
+}
+
+fn unix_now() -> u64 {
+    std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0)
+}
+
+impl DkimResult {
+    fn resinfo(&self) -> String {
+        let comment = self
+            .reason
+            .as_deref()
+            .map(|r| format!(" ({r})"))
+            .unwrap_or_default();
+        format!(
+            "dkim={}{comment} header.d={} header.s={} header.b={}",
+            self.status.as_str(),
+            or_none(&self.domain),
+            or_none(&self.selector),
+            or_none(&self.b_prefix),
+        )
+    }
+}
+
+impl DmarcOutcome {
+    fn resinfo(&self) -> String {
+        let policy = self
+            .policy
+            .map(|p| format!(" (p={})", p.as_str()))
+            .unwrap_or_default();
+        format!(
+            "dmarc={}{policy} header.from={}",
+            self.status.as_str(),
+            or_none(&self.from_domain)
+        )
+    }
+
+    fn rejects(&self) -> bool {
+        self.status == DmarcStatus::Fail && self.policy == Some(DmarcPolicy::Reject)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn splits_folded_headers_and_body() {
+        let msg = b"From: a@b.test\r\nSubject: one\r\n two\r\n\r\nbody\r\n";
+        let (fields, body) = split_message(msg);
+        assert_eq!(fields.len(), 2);
+        assert_eq!(fields[1].name, "subject");
+        assert_eq!(fields[1].raw, b"Subject: one\r\n two\r\n");
+        assert_eq!(fields[1].value(), "one two");
+        assert_eq!(body, b"body\r\n");
+    }
+
+    #[test]
+    fn replaces_own_results_and_keeps_foreign_ones() {
+        let msg = b"Authentication-Results: example.org; dkim=pass\r\n\
+            Authentication-Results: peer.test; spf=pass\r\nFrom: a@peer.test\r\n\r\nx";
+        let out = with_authentication_results(
+            msg,
+            "example.org",
+            "Authentication-Results: example.org (transport=http-federation); none\r\n",
+        );
+        let text = String::from_utf8(out).unwrap();
+        assert_eq!(
+            text,
+            "Authentication-Results: example.org (transport=http-federation); none\r\n\
+             Authentication-Results: peer.test; spf=pass\r\nFrom: a@peer.test\r\n\r\nx"
+        );
+    }
+
+    #[test]
+    fn header_lists_each_result() {
+        let h = authentication_results(
+            "example.org",
+            &[
+                "dkim=none".into(),
+                "dmarc=none header.from=peer.test".into(),
+            ],
+        );
+        assert_eq!(
+            h,
+            "Authentication-Results: example.org (transport=http-federation);\r\n\
+             \tdkim=none;\r\n\tdmarc=none header.from=peer.test\r\n"
+        );
+    }
+
+    #[tokio::test]
+    async fn all_checks_off_records_none() {
+        let checks = FederationChecks {
+            spf: CheckMode::Off,
+            dkim: CheckMode::Off,
+            dmarc: CheckMode::Off,
+            dmarc_alignment: DmarcAlignment::Dkim,
+        };
+        let dns = chatmail_state::StaticResolver::default();
+        let v = check_federated_message(
+            &checks,
+            &dns,
+            "example.org",
+            None,
+            "a@peer.test",
+            b"From: a@peer.test\r\n\r\nx",
+        )
+        .await;
+        assert_eq!(
+            v.header,
+            "Authentication-Results: example.org (transport=http-federation); none\r\n"
+        );
+        assert!(v.reject.is_none());
+    }
+}
@@ -0,0 +1,338 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! SPF `check_host()` (RFC 7208) for the `X-Mail-From` domain of federated messages.
+//!
+//! Macros are not expanded (a record that uses them is a `permerror`) and `ptr` never
+//! matches, as §5.5 recommends; every other mechanism and `redirect=` is supported.
+
+use std::future::Future;
+use std::net::IpAddr;
+use std::pin::Pin;
+
+use chatmail_state::{DnsError, DnsResolver, IpNet};
+
+/// RFC 7208 §4.6.4: DNS-querying terms per evaluation.
+const MAX_LOOKUPS: usize = 10;
+/// RFC 7208 §4.6.4: addresses looked up for one `mx` term.
+const MAX_MX_NAMES: usize = 10;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SpfResult {
+    Pass,
+    Fail,
+    SoftFail,
+    Neutral,
+    None,
+    TempError,
+    PermError,
+}
+
+impl SpfResult {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Pass => "pass",
+            Self::Fail => "fail",
+            Self::SoftFail => "softfail",
+            Self::Neutral => "neutral",
+            Self::None => "none",
+            Self::TempError => "temperror",
+            Self::PermError => "permerror",
+        }
+    }
+
+    fn from_qualifier(q: char) -> Self {
+        match q {
+            '-' => Self::Fail,
+            '~' => Self::SoftFail,
+            '?' => Self::Neutral,
+            _ => Self::Pass,
+        }
+    }
+}
+
+/// Evaluate the SPF policy of `domain` for a message sent from `ip`.
+pub async fn check_host(dns: &dyn DnsResolver, ip: IpAddr, domain: &str) -> SpfResult {
+    let mut lookups = 0;
+    evaluate(dns, ip, domain.trim_end_matches('.'), &mut lookups).await
+}
+
+type Eval<'a> = Pin<Box<dyn Future<Output = SpfResult> + Send + 'a>>;
+
+fn evaluate<'a>(
+    dns: &'a dyn DnsResolver,
+    ip: IpAddr,
+    domain: &'a str,
+    lookups: &'a mut usize,
+) -> Eval<'a> {
+    Box::pin(async move {
+        let record = match fetch_record(dns, domain).await {
+            Ok(Some(r)) => r,
+            Ok(None) => return SpfResult::None,
+            Err(result) => return result,
+        };
+        let mut redirect = None;
+        for term in record.split_ascii_whitespace().skip(1) {
+            if term.contains('%') {
+                return SpfResult::PermError;
+            }
+            if let Some((name, value)) = term.split_once('=') {
+                if name.eq_ignore_ascii_case("redirect") {
+                    redirect = Some(value.to_string());
+                }
+                // Unknown modifiers (exp=, ...) are ignored.
+                continue;
+            }
+            let (qualifier, mechanism) = match term.chars().next() {
+                Some(q @ ('+' | '-' | '~' | '?')) => (q, &term[1..]),
+                _ => ('+', term),
+            };
+            let matched = match mechanism_matches(dns, ip, domain, mechanism, lookups).await {
+                Ok(m) => m,
+                Err(result) => return result,
+            };
+            if matched {
+                return SpfResult::from_qualifier(qualifier);
+            }
+        }
+        match redirect {
+            Some(target) => {
+                *lookups += 1;
+                if *lookups > MAX_LOOKUPS {
+                    return SpfResult::PermError;
+                }
+                match evaluate(dns, ip, &target, lookups).await {
+                    SpfResult::None => SpfResult::PermError,
+                    other => other,
+                }
+            }
+            None => SpfResult::Neutral,
+        }
+    })
+}
+
+/// `Ok(None)` when the domain publishes no SPF record; several records are a `permerror`.
+async fn fetch_record(dns: &dyn DnsResolver, domain: &str) -> Result<Option<String>, SpfResult> {
+    let records = match dns.txt(domain).await {
+        Ok(r) => r,
+        Err(DnsError::NotFound) => return Ok(None),
+        Err(DnsError::Temporary(_)) => return Err(SpfResult::TempError),
+    };
+    let mut spf = records.into_iter().filter(|r| is_spf_record(r));
+    match (spf.next(), spf.next()) {
+        (Some(record), None) => Ok(Some(record)),
+        (Some(_), Some(_)) => Err(SpfResult::PermError),
+        _ => Ok(None),
+    }
+}
+
+/// `v=spf1` followed by a space or the end of the record (`v=spf10` is not SPF).
+fn is_spf_record(record: &str) -> bool {
+    let record = record.trim_start();
+    record
+        .get(..6)
+        .is_some_and(|v| v.eq_ignore_ascii_case("v=spf1"))
+        && matches!(record.as_bytes().get(6), None | Some(b' '))
+}
+
+async fn mechanism_matches(
+    dns: &dyn DnsResolver,
+    ip: IpAddr,
+    domain: &str,
+    mechanism: &str,
+    lookups: &mut usize,
+) -> Result<bool, SpfResult> {
+    let (name, arg) = match mechanism.split_once([':', '/']) {
+        Some((n, _)) => (n, &mechanism[n.len()..]),
+        None => (mechanism, ""),
+    };
+    let name = name.to_ascii_lowercase();
+    if matches!(name.as_str(), "a" | "mx" | "include" | "exists" | "ptr") {
+        *lookups += 1;
+        if *lookups > MAX_LOOKUPS {
+            return Err(SpfResult::PermError);
+        }
+    }
+    match name.as_str() {
+        "all" => Ok(true),
+        "ip4" | "ip6" => {
+            let net = arg
+                .strip_prefix(':')
+                .and_then(IpNet::parse)
+                .filter(|net| net.is_ipv4() == (name == "ip4"))
+                .ok_or(SpfResult::PermError)?;
+            Ok(net.contains(ip))
+        }
+        "a" | "mx" => {
+            let (target, v4, v6) = domain_spec_with_cidr(arg, domain)?;
+            let hosts = if name == "a" {
+                vec![target]
+            } else {
+                match dns.mx(&target).await {
+                    Ok(mx) if mx.len() > MAX_MX_NAMES => return Err(SpfResult::PermError),
+                    Ok(mx) => mx,
+                    Err(DnsError::NotFound) => return Ok(false),
+                    Err(DnsError::Temporary(_)) => return Err(SpfResult::TempError),
+                }
+            };
+            for host in hosts {
+                let addrs = match dns.ips(&host).await {
+                    Ok(a) => a,
+                    Err(DnsError::NotFound) => continue,
+                    Err(DnsError::Temporary(_)) => return Err(SpfResult::TempError),
+                };
+                let hit = addrs.into_iter().any(|addr| {
+                    let prefix = if addr.is_ipv4() { v4 } else { v6 };
+                    IpNet::parse(&format!("{addr}/{prefix}")).is_some_and(|net| net.contains(ip))
+                });
+                if hit {
+                    return Ok(true);
+                }
+            }
+            Ok(false)
+        }
+        "include" => {
+            let target = arg.strip_prefix(':').ok_or(SpfResult::PermError)?;
+            match evaluate(dns, ip, target, lookups).await {
+                SpfResult::Pass => Ok(true),
+                SpfResult::Fail | SpfResult::SoftFail | SpfResult::Neutral => Ok(false),
+                SpfResult::TempError => Err(SpfResult::TempError),
+                SpfResult::None | SpfResult::PermError => Err(SpfResult::PermError),
+            }
+        }
+        "exists" => {
+            let target = arg.strip_prefix(':').ok_or(SpfResult::PermError)?;
+            match dns.ips(target).await {
+                Ok(addrs) => Ok(addrs.iter().any(IpAddr::is_ipv4)),
+                Err(DnsError::NotFound) => Ok(false),
+                Err(DnsError::Temporary(_)) => Err(SpfResult::TempError),
+            }
+        }
+        "ptr" => Ok(false),
+        _ => Err(SpfResult::PermError),
+    }
+}
+
+/// `[:domain][/v4cidr][//v6cidr]` for `a` and `mx`.
+fn domain_spec_with_cidr(arg: &str, current: &str) -> Result<(String, u8, u8), SpfResult> {
+    let (spec, cidr) = match arg.find('/') {
+        Some(i) => (&arg[..i], &arg[i..]),
+        None => (arg, ""),
+    };
+    let target = match spec.strip_prefix(':') {
+        Some(t) if !t.is_empty() => t.to_string(),
+        Some(_) => return Err(SpfResult::PermError),
+        None if spec.is_empty() => current.to_string(),
+        None => return Err(SpfResult::PermError),
+    };
+    let parse = |s: &str, max: u8| match s.parse::<u8>() {
+        Ok(p) if p <= max => Ok(p),
+        _ => Err(SpfResult::PermError),
+    };
+    let (v4, v6) = match cidr.split_once("//") {
+        Some((v4, v6)) => (v4, Some(v6)),
+        None => (cidr, None),
+    };
+    let v4 = match v4.strip_prefix('/') {
+        Some(p) => parse(p, 32)?,
+        None if v4.is_empty() => 32,
+        None => return Err(SpfResult::PermError),
+    };
+    let v6 = match v6 {
+        Some(p) => parse(p, 128)?,
+        None => 128,
+    };
+    Ok((target, v4, v6))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_state::StaticResolver;
+
+    fn ip(s: &str) -> IpAddr {
+        s.parse().unwrap()
+    }
+
+    #[tokio::test]
+    async fn ip4_and_all_qualifiers() {
+        let dns =
+            StaticResolver::default().with_txt("peer.example", "v=spf1 ip4:192.0.2.0/24 -all");
+        assert_eq!(
+            check_host(&dns, ip("192.0.2.7"), "peer.example").await,
+            SpfResult::Pass
+        );
+        assert_eq!(
+            check_host(&dns, ip("198.51.100.1"), "peer.example").await,
+            SpfResult::Fail
+        );
+        assert_eq!(
+            check_host(&dns, ip("::ffff:192.0.2.7"), "peer.example").await,
+            SpfResult::Pass
+        );
+        assert_eq!(
+            check_host(&dns, ip("192.0.2.7"), "other.example").await,
+            SpfResult::None
+        );
+    }
+
+    #[tokio::test]
+    async fn a_mx_include_and_redirect() {
+        let mut dns = StaticResolver::default()
+            .with_txt("peer.example", "v=spf1 mx include:relay.example ~all")
+            .with_txt("relay.example", "v=spf1 a/30 -all")
+            .with_txt("alias.example", "v=spf1 redirect=peer.example");
+        dns.mx
+            .insert("peer.example".into(), vec!["mx.peer.example".into()]);
+        dns.ips
+            .insert("mx.peer.example".into(), vec![ip("203.0.113.5")]);
+        dns.ips
+            .insert("relay.example".into(), vec![ip("198.51.100.8")]);
+
+        assert_eq!(
+            check_host(&dns, ip("203.0.113.5"), "peer.example").await,
+            SpfResult::Pass
+        );
+        assert_eq!(
+            check_host(&dns, ip("198.51.100.10"), "peer.example").await,
+            SpfResult::Pass
+        );
+        assert_eq!(
+            check_host(&dns, ip("198.51.100.20"), "peer.example").await,
+            SpfResult::SoftFail
+        );
+        assert_eq!(
+            check_host(&dns, ip("203.0.113.5"), "alias.example").await,
+            SpfResult::Pass
+        );
+    }
+
+    #[tokio::test]
+    async fn lookup_limit_and_macros_are_permerror() {
+        let mut dns =
+            StaticResolver::default().with_txt("loop.example", "v=spf1 include:loop.example");
+        assert_eq!(
+            check_host(&dns, ip("192.0.2.1"), "loop.example").await,
+            SpfResult::PermError
+        );
+        dns = dns.with_txt("macro.example", "v=spf1 exists:%{i}.bl.example -all");
+        assert_eq!(
+            check_host(&dns, ip("192.0.2.1"), "macro.example").await,
+            SpfResult::PermError
+        );
+    }
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `GET /federation/info` — what this instance accepts, for peers that list us in `peers`.
+//!
+//! The body is [`local_federation_info`]; with a `peer_secret` it is signed in the
+//! `X-Madmail-Signature` header so peers sharing the key can act on it.
+
+use axum::extract::State;
+use axum::http::{header, HeaderValue, StatusCode};
+use axum::response::{IntoResponse, Response};
+use chatmail_state::{local_federation_info, sign_info, SIGNATURE_HEADER};
+
+use crate::mxdeliv::FedState;
+
+pub async fn federation_info_handler(State(st): State<FedState>) -> Response {
+    let info = local_federation_info(&st.app, &st.primary_domain);
+    let body = serde_json::to_vec(&info.to_json()).unwrap_or_default();
+    let signature = st
+        .app
+        .peers
+        .signing_secret()
+        .and_then(|secret| HeaderValue::from_str(&sign_info(secret, &body)).ok());
+    let mut resp = (
+        StatusCode::OK,
+        [
+            (header::CONTENT_TYPE, "application/json"),
+            (header::CACHE_CONTROL, "no-store"),
+        ],
+        body,
+    )
+        .into_response();
+    if let Some(signature) = signature {
+        resp.headers_mut().insert(SIGNATURE_HEADER, signature);
+    }
+    resp
+}
@@ -15,7 +15,10 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+pub mod checks;
+pub mod info;
 pub mod mxdeliv;
+pub mod recover;
 pub mod security;
 pub mod server;
 
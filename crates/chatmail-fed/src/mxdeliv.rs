@@ -15,18 +15,23 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::net::{IpAddr, SocketAddr};
 use std::sync::Arc;
 
 use axum::body::Bytes;
-use axum::extract::State;
-use axum::http::{HeaderMap, StatusCode};
-use axum::response::IntoResponse;
+use axum::extract::{ConnectInfo, State};
+use axum::http::{header, HeaderMap, StatusCode};
+use axum::response::{IntoResponse, Response};
+use axum::Extension;
 use chatmail_db::{is_federation_sender_blocked, DbPool};
+use chatmail_delivery::mail_options::{address_allowed, MAIL_OPTIONS_HEADER};
+use chatmail_delivery::{DeliveryContext, MailOptions};
 use chatmail_pgp::{enforce_encryption, EnforceOptions};
-use chatmail_state::AppState;
+use chatmail_state::{AppState, LocalRcpt};
 use chatmail_storage::deliver_local_messages;
 use chatmail_types::ChatmailError;
 
+use crate::checks::{check_federated_message, with_authentication_results};
 use crate::security::recipient_matches_server;
 
 #[derive(Clone)]
@@ -52,11 +57,68 @@ pub fn mxdeliv_http_status(err: &ChatmailError) -> StatusCode {
 pub async fn mxdeliv_handler(
     State(st): State<FedState>,
     headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
     body: Bytes,
-) -> impl IntoResponse {
-    match handle_mxdeliv(&st, &headers, &body).await {
-        Ok(()) => (StatusCode::OK, "OK"),
-        Err(e) => (mxdeliv_http_status(&e), status_body(&e)),
+) -> Response {
+    let resp = mxdeliv_response(&st, &headers, peer, body).await;
+    chatmail_metrics::record_http_delivery(resp.status().as_u16());
+    resp
+}
+
+async fn mxdeliv_response(
+    st: &FedState,
+    headers: &HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    body: Bytes,
+) -> Response {
+    // Cap fan-out before any work; the sender's queue splits the list and retries.
+    let rcpts = headers.get_all("x-mail-to").iter().count();
+    if rcpts > st.app.max_rcpt {
+        tracing::debug!(rcpts, max = st.app.max_rcpt, "mxdeliv: too many recipients");
+        return (
+            StatusCode::BAD_REQUEST,
+            format!(
+                "too many recipients: {rcpts} X-Mail-To, limit {}",
+                st.app.max_rcpt
+            ),
+        )
+            .into_response();
+    }
+    if st.app.drain.is_draining() {
+        return (
+            StatusCode::SERVICE_UNAVAILABLE,
+            [(header::RETRY_AFTER, "60")],
+            "shutting down",
+        )
+            .into_response();
+    }
+    let _work = st.app.drain.track();
+    if !st.app.memory.admit("http") {
+        return (
+            StatusCode::SERVICE_UNAVAILABLE,
+            [(header::RETRY_AFTER, "60")],
+            "server busy",
+        )
+            .into_response();
+    }
+    if st.app.disk.admit_message(body.len() as u64).is_err() {
+        return (
+            StatusCode::SERVICE_UNAVAILABLE,
+            [(header::RETRY_AFTER, "300")],
+            "insufficient storage",
+        )
+            .into_response();
+    }
+    let _lease = st.app.memory.reserve(body.len() as u64);
+    // The connecting relay, for SPF; behind a trusted proxy the forwarded address.
+    let peer = peer.map(|Extension(ConnectInfo(addr))| {
+        st.app.trusted_proxies.client_ip(addr.ip(), |name| {
+            headers.get(name).and_then(|v| v.to_str().ok())
+        })
+    });
+    match handle_mxdeliv(st, headers, &body, peer).await {
+        Ok(()) => (StatusCode::OK, "OK").into_response(),
+        Err(e) => (mxdeliv_http_status(&e), status_body(&e)).into_response(),
     }
 }
 
@@ -75,6 +137,7 @@ async fn handle_mxdeliv(
     st: &FedState,
     headers: &HeaderMap,
     body: &[u8],
+    peer: Option<IpAddr>,
 ) -> chatmail_types::Result<()> {
     let mail_from = header_str(headers, "x-mail-from").unwrap_or_default();
     // One POST carries one X-Mail-To header per recipient on this server
@@ -83,6 +146,18 @@ async fn handle_mxdeliv(
     if rcpts.is_empty() {
         return Err(ChatmailError::protocol("missing X-Mail-To"));
     }
+    // The sender's `MAIL FROM` parameters; SMTPUTF8 is what admits non-ASCII addresses.
+    let options = match header_str(headers, MAIL_OPTIONS_HEADER) {
+        Some(value) => MailOptions::from_header(&value).map_err(ChatmailError::protocol)?,
+        None => MailOptions::default(),
+    };
+    if !address_allowed(&mail_from, options.smtputf8)
+        || rcpts.iter().any(|r| !address_allowed(r, options.smtputf8))
+    {
+        return Err(ChatmailError::protocol(
+            "non-ASCII address without SMTPUTF8",
+        ));
+    }
 
     rcpts.retain(|rcpt| {
         let keep = recipient_matches_server(rcpt, &st.local_domains);
@@ -100,14 +175,18 @@ async fn handle_mxdeliv(
         return Ok(());
     }
 
-    rcpts.retain(|rcpt| {
-        let keep = st.app.auth.local_recipient_allowed(rcpt);
-        if !keep {
+    // Addresses without an account (or reserved) are dropped unless the domain has a
+    // catch-all, which is delivered once the message passed the checks below. `user+tag@`
+    // is checked as `user@`.
+    let (rcpts, unknown): (Vec<LocalRcpt>, Vec<LocalRcpt>) = rcpts
+        .iter()
+        .map(|rcpt| st.app.resolve_local_rcpt(rcpt))
+        .partition(|local| st.app.auth.local_recipient_allowed(&local.account));
+    let unknown: Vec<String> = unknown.into_iter().map(|l| l.account).collect();
+    if rcpts.is_empty() && st.app.catchalls.is_empty() {
+        for rcpt in &unknown {
             tracing::debug!(rcpt = %rcpt, "mxdeliv: silently dropped (no account or reserved rcpt)");
         }
-        keep
-    });
-    if rcpts.is_empty() {
         return Ok(());
     }
 
@@ -128,37 +207,72 @@ async fn handle_mxdeliv(
     st.app.check_federation_size(body.len())?;
     st.app.check_message_size(body.len())?;
 
-    // An over-quota recipient only fails the request when no other
-    // recipient remains; erroring for all would make the remote queue
-    // retry (and re-deliver) the message for recipients that are fine.
+    // An over-quota (or over its own size cap) recipient only fails the request when no
+    // other recipient remains; erroring for all would make the remote queue retry (and
+    // re-deliver) the message for recipients that are fine.
     let mut deliveries: Vec<(String, String)> = Vec::new();
-    let mut quota_err = None;
-    for rcpt in rcpts {
-        match st.app.quota.check_quota(&rcpt, body.len() as u64) {
-            Ok(()) => deliveries.push((rcpt, uuid::Uuid::new_v4().to_string())),
+    let mut subaddressed: Vec<LocalRcpt> = Vec::new();
+    let mut refused = None;
+    for local in rcpts {
+        match st.app.admit_local_delivery(&local.account, body.len()) {
+            Ok(()) if local.tag.is_some() => subaddressed.push(local),
+            Ok(()) => deliveries.push((local.account, uuid::Uuid::new_v4().to_string())),
             Err(e) => {
-                tracing::warn!(rcpt = %rcpt, error = %e, "mxdeliv: recipient over quota");
-                quota_err = Some(e);
+                tracing::warn!(rcpt = %local.original, error = %e, "mxdeliv: recipient refused");
+                refused = Some(e);
             }
         }
     }
-    if deliveries.is_empty() {
-        return Err(quota_err.expect("empty deliveries only after quota errors"));
+    if let (true, true, Some(e)) = (deliveries.is_empty(), subaddressed.is_empty(), refused) {
+        return Err(e);
     }
 
+    let recipients: Vec<String> = deliveries
+        .iter()
+        .map(|(rcpt, _)| rcpt.clone())
+        .chain(subaddressed.iter().map(|l| l.account.clone()))
+        .chain(unknown.iter().cloned())
+        .collect();
     enforce_encryption(
         body,
         &EnforceOptions {
+            // The peer's `from` is unauthenticated: only the recipients can opt out.
+            passthrough: st.app.policies.pgp_passthrough(None, &recipients),
             mail_from: mail_from.clone(),
-            recipients: deliveries.iter().map(|(rcpt, _)| rcpt.clone()).collect(),
+            recipients,
         },
     )?;
 
+    // `federation_checks`: SPF/DKIM/DMARC on the federation path, reported in an
+    // Authentication-Results field that replaces any claiming our authserv-id.
+    let verdict = check_federated_message(
+        &st.app.federation_checks,
+        st.app.dns.as_ref(),
+        &st.primary_domain,
+        peer,
+        &mail_from,
+        body,
+    )
+    .await;
+    if let Some(reason) = verdict.reject {
+        tracing::info!(from = %mail_from, %reason, "mxdeliv: rejected by federation_checks");
+        return Err(ChatmailError::FederationRejected(sender_domain));
+    }
+    let body = with_authentication_results(body, &st.primary_domain, &verdict.header);
+    let body = body.as_slice();
+
     // Madmail: inbound HTTP counts on sender domain with empty transport (inbound_deliveries++).
     st.app
         .federation_tracker
         .record_success(&sender_domain, 0, "");
 
+    let delivery = DeliveryContext {
+        pool: st.pool.clone(),
+        state: Arc::clone(&st.app),
+        primary_domain: st.primary_domain.clone(),
+        local_domains: st.local_domains.clone(),
+    };
+    let filtered = delivery.take_filtered(&mut deliveries).await;
     let outcome = deliver_local_messages(&st.app.mailbox_store, &deliveries, body).await?;
     // Notify (and charge quota) only for recipients whose body is durably
     // on disk, mirroring the SMTP session path.
@@ -166,19 +280,28 @@ async fn handle_mxdeliv(
         st.app.quota.record_write(rcpt, body.len() as u64);
         st.app.events.notify_new_message(rcpt, msg_id);
         st.app.notify_inbound_push(&st.pool, &mail_from, rcpt).await;
+        st.app.activity.touch_inbound(&st.pool, rcpt).await;
     }
+    delivery.spawn_auto_replies(&mail_from, outcome.delivered.iter().map(|(r, _)| r), body);
     for (rcpt, _msg_id, err) in &outcome.failed {
         tracing::warn!(rcpt = %rcpt, error = %err, "mxdeliv: local delivery failed for recipient");
     }
+    delivery
+        .deliver_subaddressed(&mail_from, &subaddressed, body)
+        .await;
+    delivery.deliver_filtered(&mail_from, &filtered, body).await;
+    delivery.deliver_catchall(&mail_from, &unknown, body).await;
 
     chatmail_db::record_inbound_delivery();
     Ok(())
 }
 
+/// Header values are decoded as UTF-8, not `to_str()`: SMTPUTF8 addresses arrive as raw
+/// UTF-8 bytes, which `to_str()` (visible ASCII only) would drop.
 fn header_str(headers: &HeaderMap, name: &str) -> Option<String> {
     headers
         .get(name)
-        .and_then(|v| v.to_str().ok())
+        .and_then(|v| std::str::from_utf8(v.as_bytes()).ok())
         .map(|s| s.to_string())
 }
 
@@ -186,7 +309,7 @@ fn header_strs(headers: &HeaderMap, name: &str) -> Vec<String> {
     headers
         .get_all(name)
         .iter()
-        .filter_map(|v| v.to_str().ok())
+        .filter_map(|v| std::str::from_utf8(v.as_bytes()).ok())
         .map(|s| s.to_string())
         .collect()
 }
@@ -194,11 +317,82 @@ fn header_strs(headers: &HeaderMap, name: &str) -> Vec<String> {
 #[cfg(test)]
 mod tests {
     use super::*;
-    use axum::http::StatusCode;
+    use axum::http::{HeaderValue, StatusCode};
     use chatmail_db::init_memory_db;
     use chatmail_state::AppState;
     use std::sync::Arc;
 
+    #[tokio::test]
+    async fn rejects_x_mail_to_over_limit() {
+        use axum::body::to_bytes;
+
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let mut config = chatmail_config::AppConfig::default();
+        config.max_rcpt = Some(2);
+        let app = Arc::new(AppState::with_quota_and_message_limit(
+            dir.path(),
+            chatmail_config::DEFAULT_QUOTA_BYTES,
+            &config,
+            pool.clone(),
+        ));
+        for user in ["alice@example.org", "bob@example.org", "carol@example.org"] {
+            chatmail_db::passwords::create_user(&pool, user, "x")
+                .await
+                .unwrap();
+        }
+        app.federation_policy.hydrate(&pool).await.unwrap();
+        app.auth.hydrate(&pool).await.unwrap();
+        let st = FedState {
+            pool,
+            app,
+            primary_domain: "example.org".into(),
+            local_domains: chatmail_types::build_local_domains("example.org", None),
+        };
+        let pgp = Bytes::from_static(b"From: a@peer.test\r\nTo: alice@example.org\r\nContent-Type: multipart/encrypted; boundary=b\r\n\r\n--b\r\nContent-Type: application/pgp-encrypted\r\n\r\nv\r\n--b--\r\n");
+
+        let mut headers = HeaderMap::new();
+        headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
+        headers.append("x-mail-to", "alice@example.org".parse().unwrap());
+        headers.append("x-mail-to", "bob@example.org".parse().unwrap());
+        let resp = mxdeliv_handler(State(st.clone()), headers.clone(), None, pgp.clone()).await;
+        assert_eq!(resp.status(), StatusCode::OK, "exactly at the limit");
+
+        headers.append("x-mail-to", "carol@example.org".parse().unwrap());
+        let resp = mxdeliv_handler(State(st.clone()), headers, None, pgp).await;
+        assert_eq!(resp.status(), StatusCode::BAD_REQUEST);
+        let body = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+        assert!(
+            String::from_utf8_lossy(&body).contains("limit 2"),
+            "{body:?}"
+        );
+        assert_eq!(st.app.quota.used_bytes("carol@example.org"), 0);
+    }
+
+    #[tokio::test]
+    async fn returns_503_while_memory_budget_exhausted() {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState {
+            memory: Arc::new(chatmail_state::MemoryBudget::new(1024)),
+            ..AppState::new(dir.path(), pool.clone())
+        });
+        let _held = app.memory.reserve(4096);
+        let st = FedState {
+            pool,
+            app: Arc::clone(&app),
+            primary_domain: "example.org".into(),
+            local_domains: chatmail_types::build_local_domains("example.org", None),
+        };
+        let mut headers = HeaderMap::new();
+        headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
+        headers.append("x-mail-to", "alice@example.org".parse().unwrap());
+        let resp = mxdeliv_handler(State(st), headers, None, Bytes::from_static(b"x")).await;
+        assert_eq!(resp.status(), StatusCode::SERVICE_UNAVAILABLE);
+        assert!(resp.headers().contains_key(header::RETRY_AFTER));
+        assert_eq!(app.memory.rejections(), 1);
+    }
+
     /// P7-UT01: federation silently drops admin-style recipients.
     #[tokio::test]
     async fn p7_ut01_test_silently_drops_admin_recipient() {
@@ -219,7 +413,7 @@ mod tests {
         headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
         headers.insert("x-mail-to", "admin@example.org".parse().unwrap());
 
-        handle_mxdeliv(&st, &headers, pgp).await.unwrap();
+        handle_mxdeliv(&st, &headers, pgp, None).await.unwrap();
         assert_eq!(st.app.quota.used_bytes("admin@example.org"), 0);
     }
 
@@ -256,7 +450,7 @@ mod tests {
         headers.insert("x-mail-from", "sender@evil.test".parse().unwrap());
         headers.insert("x-mail-to", "user@example.org".parse().unwrap());
 
-        let err = handle_mxdeliv(&st, &headers, pgp).await.unwrap_err();
+        let err = handle_mxdeliv(&st, &headers, pgp, None).await.unwrap_err();
         assert!(matches!(err, ChatmailError::FederationRejected(_)));
         assert_eq!(mxdeliv_http_status(&err), StatusCode::FORBIDDEN);
     }
@@ -308,11 +502,13 @@ mod tests {
         headers.insert("x-mail-to", "user@example.org".parse().unwrap());
         let oversized = vec![b'x'; 5 * 1024 * 1024];
 
-        let err = handle_mxdeliv(&st, &headers, &oversized).await.unwrap_err();
+        let err = handle_mxdeliv(&st, &headers, &oversized, None)
+            .await
+            .unwrap_err();
         assert!(matches!(err, ChatmailError::MessageTooLarge));
         assert_eq!(mxdeliv_http_status(&err), StatusCode::PAYLOAD_TOO_LARGE);
 
-        handle_mxdeliv(&st, &headers, pgp).await.unwrap();
+        handle_mxdeliv(&st, &headers, pgp, None).await.unwrap();
     }
 
     #[tokio::test]
@@ -338,8 +534,14 @@ mod tests {
         headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
         headers.insert("x-mail-to", "user@example.org".parse().unwrap());
 
-        handle_mxdeliv(&st, &headers, pgp).await.unwrap();
-        assert_eq!(app.quota.used_bytes("user@example.org"), pgp.len() as u64);
+        handle_mxdeliv(&st, &headers, pgp, None).await.unwrap();
+        assert_eq!(
+            app.quota.used_bytes("user@example.org"),
+            stored_size(&app, "user@example.org")
+        );
+        assert!(stored_size(&app, "user@example.org") > pgp.len() as u64);
+        let activity = chatmail_db::list_last_activity(&st.pool).await.unwrap();
+        assert!(activity.contains_key("user@example.org"));
     }
 
     /// One POST with several X-Mail-To headers (TDD 07-federation.md) must
@@ -373,12 +575,236 @@ mod tests {
         // A dropped recipient must not affect delivery to the others.
         headers.append("x-mail-to", "ghost@example.org".parse().unwrap());
 
-        handle_mxdeliv(&st, &headers, pgp).await.unwrap();
-        assert_eq!(app.quota.used_bytes("alice@example.org"), pgp.len() as u64);
-        assert_eq!(app.quota.used_bytes("bob@example.org"), pgp.len() as u64);
+        handle_mxdeliv(&st, &headers, pgp, None).await.unwrap();
+        for rcpt in ["alice@example.org", "bob@example.org"] {
+            assert_eq!(app.quota.used_bytes(rcpt), stored_size(&app, rcpt));
+            assert_eq!(stored(&app, rcpt, "INBOX").len(), 1);
+        }
         assert_eq!(app.quota.used_bytes("ghost@example.org"), 0);
     }
 
+    /// SMTPUTF8 over `/mxdeliv`: the unicode local part arrives as raw UTF-8 header bytes,
+    /// `X-Mail-Options` restores the sender's parameters and the 8-bit body is stored as sent.
+    #[tokio::test]
+    async fn delivers_unicode_local_part_and_8bit_body_with_smtputf8() {
+        let pool = init_memory_db().await.unwrap();
+        chatmail_db::passwords::create_user(&pool, "j\u{f6}rg@example.org", "hash")
+            .await
+            .unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        app.federation_policy.hydrate(&pool).await.unwrap();
+        app.auth.hydrate(&pool).await.unwrap();
+        let st = FedState {
+            pool,
+            app: Arc::clone(&app),
+            primary_domain: "example.org".into(),
+            local_domains: chatmail_types::build_local_domains("example.org", None),
+        };
+
+        let mut body = "From: a@peer.test\r\nTo: j\u{f6}rg@example.org\r\nSubject: Gr\u{fc}\u{df}e\r\nContent-Type: multipart/encrypted; boundary=b\r\n\r\n--b\r\nContent-Type: application/pgp-encrypted\r\n\r\nv\r\n"
+            .as_bytes()
+            .to_vec();
+        body.extend_from_slice(b"caf\xe9\r\n--b--\r\n");
+        let mut headers = HeaderMap::new();
+        headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
+        headers.insert(
+            "x-mail-to",
+            HeaderValue::from_bytes("j\u{f6}rg@example.org".as_bytes()).unwrap(),
+        );
+
+        let err = handle_mxdeliv(&st, &headers, &body, None)
+            .await
+            .unwrap_err();
+        assert!(matches!(err, ChatmailError::Protocol(_)), "{err}");
+        assert!(stored(&app, "j\u{f6}rg@example.org", "INBOX").is_empty());
+
+        headers.insert(
+            "x-mail-options",
+            HeaderValue::from_static("SMTPUTF8 BODY=8BITMIME"),
+        );
+        handle_mxdeliv(&st, &headers, &body, None).await.unwrap();
+        assert_eq!(
+            app.quota.used_bytes("j\u{f6}rg@example.org"),
+            stored_size(&app, "j\u{f6}rg@example.org")
+        );
+        let inbox = stored(&app, "j\u{f6}rg@example.org", "INBOX");
+        assert_eq!(inbox.len(), 1);
+        assert!(
+            inbox[0].contains("Subject: Gr\u{fc}\u{df}e"),
+            "{}",
+            inbox[0]
+        );
+    }
+
+    /// Messages stored in `mailbox` of `user`.
+    fn stored(app: &AppState, user: &str, mailbox: &str) -> Vec<String> {
+        let new = app.mailbox_store.maildir_for_mailbox(user, mailbox).new;
+        let Ok(entries) = std::fs::read_dir(new) else {
+            return vec![];
+        };
+        entries
+            .filter_map(|e| std::fs::read(e.ok()?.path()).ok())
+            .map(|b| String::from_utf8_lossy(&b).into_owned())
+            .collect()
+    }
+
+    /// On-disk bytes of the messages in `user`'s INBOX.
+    fn stored_size(app: &AppState, user: &str) -> u64 {
+        let new = app.mailbox_store.maildir_for_mailbox(user, "INBOX").new;
+        std::fs::read_dir(new)
+            .into_iter()
+            .flatten()
+            .filter_map(|e| e.ok()?.metadata().ok())
+            .map(|m| m.len())
+            .sum()
+    }
+
+    #[tokio::test]
+    async fn subaddresses_reach_the_account_and_file_into_existing_tag_folders() {
+        let pool = init_memory_db().await.unwrap();
+        for user in [
+            "me@example.org",
+            "you@example.org",
+            "postmaster@example.org",
+        ] {
+            chatmail_db::passwords::create_user(&pool, user, "hash")
+                .await
+                .unwrap();
+        }
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        app.federation_policy.hydrate(&pool).await.unwrap();
+        app.auth.hydrate(&pool).await.unwrap();
+        app.policies
+            .add(
+                &pool,
+                chatmail_db::policies::PolicyType::SubaddressFolder,
+                "me@example.org",
+                None,
+                "",
+                None,
+            )
+            .await
+            .unwrap();
+        for user in ["me@example.org", "you@example.org"] {
+            app.mailbox_store
+                .init_mailbox_dir(user, "Lists")
+                .await
+                .unwrap();
+        }
+
+        let st = FedState {
+            pool,
+            app: Arc::clone(&app),
+            primary_domain: "example.org".into(),
+            local_domains: chatmail_types::build_local_domains("example.org", None),
+        };
+
+        let pgp = b"From: a@peer.test\r\nTo: me@example.org\r\nContent-Type: multipart/encrypted; boundary=b\r\n\r\n--b\r\nContent-Type: application/pgp-encrypted\r\n\r\nv\r\n--b--\r\n";
+        let mut headers = HeaderMap::new();
+        headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
+        for rcpt in [
+            "me+lists@example.org",
+            "me+other@example.org",
+            "you+lists@example.org",
+            "postmaster+x@example.org",
+        ] {
+            headers.append("x-mail-to", rcpt.parse().unwrap());
+        }
+        handle_mxdeliv(&st, &headers, pgp, None).await.unwrap();
+
+        let filed = stored(&app, "me@example.org", "Lists");
+        assert_eq!(filed.len(), 1, "tag matches the folder case-insensitively");
+        assert!(filed[0].starts_with(
+            "Delivered-To: me+lists@example.org\r\nX-Original-To: me+lists@example.org\r\n\
+             Authentication-Results: example.org (transport=http-federation);"
+        ));
+        assert!(filed[0].contains("\r\nFrom: a@peer.test\r\n"));
+        let inbox = stored(&app, "me@example.org", "INBOX");
+        assert_eq!(inbox.len(), 1, "no folder for the tag");
+        assert!(inbox[0].starts_with("Delivered-To: me+other@example.org\r\n"));
+
+        assert_eq!(stored(&app, "you@example.org", "INBOX").len(), 1);
+        assert!(
+            stored(&app, "you@example.org", "Lists").is_empty(),
+            "filing is opt-in per account"
+        );
+
+        assert_eq!(app.quota.used_bytes("postmaster@example.org"), 0);
+        assert!(stored(&app, "postmaster@example.org", "INBOX").is_empty());
+    }
+
+    /// Default `federation_checks` (DKIM + DMARC, SPF skipped) on messages signed by the peer.
+    #[tokio::test]
+    async fn federation_checks_record_dkim_and_enforce_dmarc() {
+        let pool = init_memory_db().await.unwrap();
+        chatmail_db::passwords::create_user(&pool, "user@example.org", "hash")
+            .await
+            .unwrap();
+        let pgp = b"From: a@peer.example\r\nTo: user@example.org\r\nSubject: x\r\nContent-Type: multipart/encrypted; boundary=b\r\n\r\n--b\r\nContent-Type: application/pgp-encrypted\r\n\r\nv\r\n--b--\r\n";
+        let (signed, key) = crate::checks::dkim::sign_ed25519(pgp, "peer.example", "sel");
+        let dns = chatmail_state::StaticResolver::default()
+            .with_txt("sel._domainkey.peer.example", &key)
+            .with_txt("_dmarc.peer.example", "v=DMARC1; p=reject");
+        let dir = tempfile::tempdir().unwrap();
+        let app = Arc::new(AppState {
+            dns: Arc::new(dns),
+            ..AppState::new(dir.path(), pool.clone())
+        });
+        app.federation_policy.hydrate(&pool).await.unwrap();
+        app.auth.hydrate(&pool).await.unwrap();
+        let st = FedState {
+            pool,
+            app: Arc::clone(&app),
+            primary_domain: "example.org".into(),
+            local_domains: chatmail_types::build_local_domains("example.org", None),
+        };
+        let mut headers = HeaderMap::new();
+        headers.insert("x-mail-from", "a@peer.example".parse().unwrap());
+        headers.insert("x-mail-to", "user@example.org".parse().unwrap());
+
+        // A forged result for our authserv-id is replaced, not trusted.
+        let mut forged = b"Authentication-Results: example.org; dkim=pass\r\n".to_vec();
+        forged.extend_from_slice(&signed);
+        let resp = mxdeliv_handler(State(st.clone()), headers.clone(), None, forged.into()).await;
+        assert_eq!(resp.status(), StatusCode::OK);
+        let inbox = stored(&app, "user@example.org", "INBOX");
+        assert_eq!(inbox.len(), 1);
+        let msg = &inbox[0];
+        assert!(
+            msg.starts_with("Authentication-Results: example.org (transport=http-federation);\r\n\tdkim=pass header.d=peer.example header.s=sel"),
+            "{msg}"
+        );
+        assert!(
+            msg.contains("\tdmarc=pass (p=reject) header.from=peer.example\r\n"),
+            "{msg}"
+        );
+        assert!(!msg.contains("spf="), "SPF is skipped by default: {msg}");
+        assert_eq!(msg.matches("Authentication-Results:").count(), 1, "{msg}");
+
+        // The body no longer matches the signature: DKIM fails and p=reject refuses it.
+        let broken = String::from_utf8(signed)
+            .unwrap()
+            .replace("\r\nv\r\n", "\r\nw\r\n");
+        let resp = mxdeliv_handler(State(st.clone()), headers.clone(), None, broken.into()).await;
+        assert_eq!(resp.status(), StatusCode::FORBIDDEN);
+        assert_eq!(stored(&app, "user@example.org", "INBOX").len(), 1);
+
+        // Unsigned mail from a domain without DMARC is delivered and marked as such.
+        headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
+        let unsigned = String::from_utf8_lossy(pgp).replace("peer.example", "peer.test");
+        handle_mxdeliv(&st, &headers, unsigned.as_bytes(), None)
+            .await
+            .unwrap();
+        let inbox = stored(&app, "user@example.org", "INBOX");
+        let msg = inbox.iter().find(|m| m.contains("a@peer.test")).unwrap();
+        assert!(
+            msg.starts_with("Authentication-Results: example.org (transport=http-federation);\r\n\tdkim=none;\r\n\tdmarc=none header.from=peer.test\r\n"),
+            "{msg}"
+        );
+    }
+
     #[tokio::test]
     async fn p7_silently_drops_unknown_user() {
         let pool = init_memory_db().await.unwrap();
@@ -398,7 +824,7 @@ mod tests {
         headers.insert("x-mail-from", "sender@peer.test".parse().unwrap());
         headers.insert("x-mail-to", "ghost@example.org".parse().unwrap());
 
-        handle_mxdeliv(&st, &headers, pgp).await.unwrap();
+        handle_mxdeliv(&st, &headers, pgp, None).await.unwrap();
         assert_eq!(st.app.quota.used_bytes("ghost@example.org"), 0);
     }
 
@@ -425,7 +851,7 @@ mod tests {
         headers.insert("x-mail-from", "admin@peer.test".parse().unwrap());
         headers.insert("x-mail-to", "user@example.org".parse().unwrap());
 
-        handle_mxdeliv(&st, &headers, pgp).await.unwrap();
+        handle_mxdeliv(&st, &headers, pgp, None).await.unwrap();
         assert_eq!(st.app.quota.used_bytes("user@example.org"), 0);
     }
 }
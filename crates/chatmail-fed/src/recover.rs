@@ -0,0 +1,110 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Panic containment for every route on the HTTP listener (federation, web UI, admin API).
+//!
+//! A panicking handler answers its own request with `500` and leaves a crash report; the
+//! connection task and every other request keep going. See [`chatmail_state::crash`].
+
+use std::net::SocketAddr;
+use std::sync::Arc;
+
+use axum::extract::{ConnectInfo, Request, State};
+use axum::http::StatusCode;
+use axum::middleware::{self, Next};
+use axum::response::{IntoResponse, Response};
+use axum::Router;
+use chatmail_state::{catch_panic, AppState, CrashScope};
+
+type RecoverState = (Arc<AppState>, Arc<str>);
+
+/// Wrap every route of `router` (already merged) in [`recover_panics`].
+pub fn with_panic_recovery(router: Router, app: Arc<AppState>, listener: &str) -> Router {
+    router.layer(middleware::from_fn_with_state(
+        (app, Arc::<str>::from(listener)),
+        recover_panics,
+    ))
+}
+
+async fn recover_panics(
+    State((app, listener)): State<RecoverState>,
+    req: Request,
+    next: Next,
+) -> Response {
+    let method = req.method().clone();
+    // Path only: query strings carry tokens (`/takeout`, `/inv`, admin links).
+    let path = req.uri().path().to_string();
+    let peer = req
+        .extensions()
+        .get::<ConnectInfo<SocketAddr>>()
+        .map(|c| c.0.to_string());
+    match catch_panic(next.run(req)).await {
+        Ok(resp) => resp,
+        Err(panic) => {
+            let scope = CrashScope::new("http", listener.as_ref())
+                .field("method", method)
+                .field("path", path)
+                .field("peer", peer.as_deref().unwrap_or("unknown"));
+            app.crashes.report(&scope, &panic);
+            (StatusCode::INTERNAL_SERVER_ERROR, "internal server error\n").into_response()
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use axum::body::Body;
+    use axum::routing::get;
+    use tower::ServiceExt;
+
+    use super::*;
+
+    /// Test-only handler standing in for a bug in a real one.
+    async fn injected_panic() -> &'static str {
+        panic!("injected handler panic")
+    }
+
+    #[tokio::test]
+    async fn panicking_handler_gets_500_and_a_report_while_others_are_served() {
+        chatmail_state::install_panic_hook(false);
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool));
+        let router = Router::new()
+            .route("/ok", get(|| async { "fine" }))
+            .route("/boom", get(injected_panic));
+        let router = with_panic_recovery(router, Arc::clone(&app), "127.0.0.1:443");
+
+        let get_path = |path: &str| {
+            axum::http::Request::builder()
+                .uri(path)
+                .body(Body::empty())
+                .unwrap()
+        };
+        let boom = router.clone().oneshot(get_path("/boom?token=secret")).await;
+        assert_eq!(boom.unwrap().status(), StatusCode::INTERNAL_SERVER_ERROR);
+        let ok = router.oneshot(get_path("/ok")).await.unwrap();
+        assert_eq!(ok.status(), StatusCode::OK);
+
+        assert_eq!(app.crashes.counts().get("http"), Some(&1));
+        let report = std::fs::read_to_string(app.crashes.last_report().unwrap()).unwrap();
+        assert!(report.contains("panic: injected handler panic"), "{report}");
+        assert!(report.contains("path: /boom\n"), "{report}");
+        assert!(report.contains("instance: 127.0.0.1:443"), "{report}");
+        assert!(!report.contains("secret"), "{report}");
+    }
+}
@@ -15,13 +15,14 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::net::SocketAddr;
 use std::sync::Arc;
 
-use axum::extract::DefaultBodyLimit;
-use axum::routing::post;
-use axum::Router;
+use axum::extract::{ConnectInfo, DefaultBodyLimit};
+use axum::routing::{get, post};
+use axum::{Extension, Router};
 use chatmail_db::DbPool;
-use chatmail_state::AppState;
+use chatmail_state::{AppState, TlsFingerprint, FEDERATION_INFO_PATH};
 use chatmail_types::Result;
 use hyper_util::rt::{TokioExecutor, TokioIo};
 use hyper_util::server::conn::auto::Builder;
@@ -32,13 +33,16 @@ use tokio_rustls::TlsAcceptor;
 use tokio_util::sync::CancellationToken;
 use tracing::info;
 
+use crate::info::federation_info_handler;
 use crate::mxdeliv::{mxdeliv_handler, FedState};
+use crate::recover::with_panic_recovery;
 
 pub fn federation_router(state: FedState) -> Router {
     // Axum defaults to 2 MiB; federated post-messages exceed that (cap: max_federation_size).
     let max_body = state.app.federation_size.effective().max(1) as usize;
     Router::new()
         .route("/mxdeliv", post(mxdeliv_handler))
+        .route(FEDERATION_INFO_PATH, get(federation_info_handler))
         .layer(DefaultBodyLimit::max(max_body))
         .with_state(state)
 }
@@ -54,6 +58,7 @@ pub async fn run_http_listener(
     local_domains: Vec<String>,
     extra: Option<Router>,
 ) -> Result<()> {
+    let screen = Arc::clone(&app);
     let state = FedState {
         pool,
         app,
@@ -64,16 +69,20 @@ pub async fn run_http_listener(
     if let Some(more) = extra {
         router = router.merge(more);
     }
+    let router = with_panic_recovery(router, Arc::clone(&screen), addr);
 
     let listener = TcpListener::bind(addr).await?;
     let tls_acceptor = tls.map(TlsAcceptor::from);
     info!(%addr, tls = tls_acceptor.is_some(), "HTTP listener (federation + admin)");
 
     if tls_acceptor.is_none() {
-        return axum::serve(listener, router)
-            .with_graceful_shutdown(cancel.cancelled_owned())
-            .await
-            .map_err(|e| chatmail_types::ChatmailError::protocol(e.to_string()));
+        return axum::serve(
+            listener,
+            router.into_make_service_with_connect_info::<SocketAddr>(),
+        )
+        .with_graceful_shutdown(cancel.cancelled_owned())
+        .await
+        .map_err(|e| chatmail_types::ChatmailError::protocol(e.to_string()));
     }
 
     loop {
@@ -84,9 +93,23 @@ pub async fn run_http_listener(
             }
             accept = listener.accept() => {
                 let (stream, peer) = accept?;
-                let app = router.clone();
+                // Same `ConnectInfo` extension the plain branch gets from axum::serve.
+                let mut app = router.clone().layer(Extension(ConnectInfo(peer)));
                 let acceptor = tls_acceptor.clone().expect("tls branch");
+                let screen = Arc::clone(&screen);
                 tokio::spawn(async move {
+                    match screen.screen_tls_client(&stream).await {
+                        Ok(Some(ja4)) => app = app.layer(Extension(TlsFingerprint(ja4))),
+                        Ok(None) => {}
+                        Err(ja4) => {
+                            tracing::debug!(
+                                %peer,
+                                %ja4,
+                                "HTTP connection with banned TLS fingerprint dropped"
+                            );
+                            return;
+                        }
+                    }
                     let tls_stream = match acceptor.accept(stream).await {
                         Ok(s) => s,
                         Err(e) => {
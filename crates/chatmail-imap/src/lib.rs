@@ -16,6 +16,7 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 pub mod connection_stats;
+mod search;
 pub mod server;
 pub mod session;
 
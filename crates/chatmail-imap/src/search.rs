@@ -0,0 +1,694 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `SEARCH` / `UID SEARCH` criteria (RFC 3501 §6.4.4).
+//!
+//! The argument string parses into a [`SearchKey`] tree; matching runs per message. Keys that
+//! only need the listing (sequence/UID sets, flags, internal date, size) are decided without
+//! touching disk, so a message body is read only when a header/body/sent-date key is still open.
+
+use std::time::SystemTime;
+
+use chatmail_storage::MaildirFlags;
+use time::{Date, Month, OffsetDateTime};
+
+/// One parsed search key.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) enum SearchKey {
+    /// `ALL`, and keys that hold for every message here (`OLD`, `UNANSWERED`, `UNDRAFT`).
+    All,
+    /// Keys that no message can satisfy (`RECENT`, `NEW`, `ANSWERED`, `DRAFT`, unknown keyword).
+    Nothing,
+    /// Space-separated keys and parenthesised groups: all must match.
+    And(Vec<SearchKey>),
+    Not(Box<SearchKey>),
+    Or(Box<SearchKey>, Box<SearchKey>),
+    SeqSet(NumSet),
+    UidSet(NumSet),
+    Seen(bool),
+    Deleted(bool),
+    Flagged(bool),
+    /// `KEYWORD $Junk` / `UNKEYWORD $NotJunk` — the only keywords the maildir keeps.
+    Junk(bool),
+    NotJunk(bool),
+    Before(Date),
+    On(Date),
+    Since(Date),
+    SentBefore(Date),
+    SentOn(Date),
+    SentSince(Date),
+    Larger(u64),
+    Smaller(u64),
+    /// `HEADER`, `FROM`, `TO`, `CC`, `BCC`, `SUBJECT`: field name and substring.
+    Header(String, String),
+    /// `BODY` — substring of the text after the header block.
+    Body(String),
+    /// `TEXT` — substring of headers or body.
+    Text(String),
+}
+
+/// Sequence or UID set; `*` is kept symbolic and resolved against the mailbox at match time.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct NumSet(Vec<(SetNum, SetNum)>);
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum SetNum {
+    Num(u32),
+    Star,
+}
+
+impl NumSet {
+    fn parse(s: &str) -> Option<Self> {
+        let num = |p: &str| match p {
+            "*" => Some(SetNum::Star),
+            _ => p.parse::<u32>().ok().filter(|n| *n > 0).map(SetNum::Num),
+        };
+        let mut ranges = Vec::new();
+        for part in s.split(',') {
+            let range = match part.split_once(':') {
+                Some((a, b)) => (num(a)?, num(b)?),
+                None => {
+                    let n = num(part)?;
+                    (n, n)
+                }
+            };
+            ranges.push(range);
+        }
+        Some(Self(ranges))
+    }
+
+    fn contains(&self, n: u32, max: u32) -> bool {
+        let resolve = |v: SetNum| match v {
+            SetNum::Num(n) => n,
+            SetNum::Star => max,
+        };
+        self.0.iter().any(|&(a, b)| {
+            let (lo, hi) = (resolve(a).min(resolve(b)), resolve(a).max(resolve(b)));
+            (lo..=hi).contains(&n)
+        })
+    }
+}
+
+/// What a key is evaluated against. `raw` is the full message when it was loaded.
+pub(crate) struct SearchMessage<'a> {
+    pub seq: u32,
+    pub uid: u32,
+    pub size: u64,
+    pub received: SystemTime,
+    pub flags: &'a MaildirFlags,
+    pub raw: Option<&'a [u8]>,
+}
+
+/// Mailbox-wide values `*` resolves to.
+#[derive(Debug, Clone, Copy)]
+pub(crate) struct SearchBounds {
+    pub max_seq: u32,
+    pub max_uid: u32,
+}
+
+/// Why the arguments were rejected; the session maps it to `BAD` or `NO [BADCHARSET]`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) enum SearchError {
+    Syntax(String),
+    BadCharset,
+}
+
+impl SearchKey {
+    /// Evaluate against `msg`. `None` means the answer depends on message content that was not
+    /// supplied; with `raw` set the result is always `Some`.
+    pub(crate) fn matches(&self, msg: &SearchMessage<'_>, bounds: SearchBounds) -> Option<bool> {
+        let content = |f: &dyn Fn(&[u8]) -> bool| msg.raw.map(f);
+        match self {
+            SearchKey::All => Some(true),
+            SearchKey::Nothing => Some(false),
+            SearchKey::And(keys) => {
+                let mut open = false;
+                for key in keys {
+                    match key.matches(msg, bounds) {
+                        Some(false) => return Some(false),
+                        Some(true) => {}
+                        None => open = true,
+                    }
+                }
+                if open {
+                    None
+                } else {
+                    Some(true)
+                }
+            }
+            SearchKey::Not(key) => key.matches(msg, bounds).map(|m| !m),
+            SearchKey::Or(a, b) => match (a.matches(msg, bounds), b.matches(msg, bounds)) {
+                (Some(true), _) | (_, Some(true)) => Some(true),
+                (Some(false), Some(false)) => Some(false),
+                _ => None,
+            },
+            SearchKey::SeqSet(set) => Some(set.contains(msg.seq, bounds.max_seq)),
+            SearchKey::UidSet(set) => Some(set.contains(msg.uid, bounds.max_uid)),
+            SearchKey::Seen(want) => Some(msg.flags.seen == *want),
+            SearchKey::Deleted(want) => Some(msg.flags.deleted == *want),
+            SearchKey::Flagged(want) => Some(msg.flags.flagged == *want),
+            SearchKey::Junk(want) => Some(msg.flags.junk == *want),
+            SearchKey::NotJunk(want) => Some(msg.flags.not_junk == *want),
+            SearchKey::Before(d) => Some(utc_date(msg.received) < *d),
+            SearchKey::On(d) => Some(utc_date(msg.received) == *d),
+            SearchKey::Since(d) => Some(utc_date(msg.received) >= *d),
+            SearchKey::SentBefore(d) => content(&|raw| sent_date(raw).is_some_and(|s| s < *d)),
+            SearchKey::SentOn(d) => content(&|raw| sent_date(raw) == Some(*d)),
+            SearchKey::SentSince(d) => content(&|raw| sent_date(raw).is_some_and(|s| s >= *d)),
+            SearchKey::Larger(n) => Some(msg.size > *n),
+            SearchKey::Smaller(n) => Some(msg.size < *n),
+            SearchKey::Header(field, needle) => content(&|raw| {
+                header_values(raw, field)
+                    .iter()
+                    .any(|v| contains_ci(v.as_bytes(), needle))
+            }),
+            SearchKey::Body(needle) => content(&|raw| contains_ci(split_body(raw).1, needle)),
+            SearchKey::Text(needle) => content(&|raw| contains_ci(raw, needle)),
+        }
+    }
+}
+
+/// Parse `SEARCH` arguments (after the command name), including an optional leading
+/// `CHARSET`. Only `US-ASCII` and `UTF-8` are accepted.
+pub(crate) fn parse_search(args: &str) -> Result<SearchKey, SearchError> {
+    let mut tokens = tokenize(args)?;
+    tokens.reverse();
+    if matches!(tokens.last(), Some(Token::Atom(a)) if a.eq_ignore_ascii_case("CHARSET")) {
+        tokens.pop();
+        let charset = match tokens.pop() {
+            Some(Token::Atom(s) | Token::Quoted(s)) => s,
+            _ => return Err(syntax("CHARSET needs a name")),
+        };
+        if !["US-ASCII", "UTF-8"]
+            .iter()
+            .any(|c| c.eq_ignore_ascii_case(&charset))
+        {
+            return Err(SearchError::BadCharset);
+        }
+    }
+    let mut keys = Vec::new();
+    while !tokens.is_empty() {
+        keys.push(parse_key(&mut tokens)?);
+    }
+    match keys.len() {
+        0 => Err(syntax("SEARCH needs at least one key")),
+        1 => Ok(keys.pop().expect("one key")),
+        _ => Ok(SearchKey::And(keys)),
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Token {
+    Atom(String),
+    Quoted(String),
+    Open,
+    Close,
+}
+
+fn syntax(msg: impl Into<String>) -> SearchError {
+    SearchError::Syntax(msg.into())
+}
+
+fn tokenize(args: &str) -> Result<Vec<Token>, SearchError> {
+    let mut out = Vec::new();
+    let mut chars = args.chars().peekable();
+    while let Some(&c) = chars.peek() {
+        match c {
+            c if c.is_ascii_whitespace() => {
+                chars.next();
+            }
+            '(' => {
+                chars.next();
+                out.push(Token::Open);
+            }
+            ')' => {
+                chars.next();
+                out.push(Token::Close);
+            }
+            '"' => {
+                chars.next();
+                let mut s = String::new();
+                loop {
+                    match chars.next() {
+                        Some('"') => break,
+                        Some('\\') => match chars.next() {
+                            Some(e) => s.push(e),
+                            None => return Err(syntax("unterminated quoted string")),
+                        },
+                        Some(ch) => s.push(ch),
+                        None => return Err(syntax("unterminated quoted string")),
+                    }
+                }
+                out.push(Token::Quoted(s));
+            }
+            '{' => return Err(syntax("literals are not supported in SEARCH")),
+            _ => {
+                let mut s = String::new();
+                while let Some(&ch) = chars.peek() {
+                    if ch.is_ascii_whitespace() || ch == '(' || ch == ')' || ch == '"' {
+                        break;
+                    }
+                    s.push(ch);
+                    chars.next();
+                }
+                out.push(Token::Atom(s));
+            }
+        }
+    }
+    Ok(out)
+}
+
+/// `tokens` is reversed so the next token is at the end.
+fn parse_key(tokens: &mut Vec<Token>) -> Result<SearchKey, SearchError> {
+    let atom = match tokens.pop() {
+        Some(Token::Open) => {
+            let mut keys = Vec::new();
+            loop {
+                match tokens.last() {
+                    Some(Token::Close) => {
+                        tokens.pop();
+                        break;
+                    }
+                    Some(_) => keys.push(parse_key(tokens)?),
+                    None => return Err(syntax("unbalanced parenthesis")),
+                }
+            }
+            if keys.is_empty() {
+                return Err(syntax("empty search group"));
+            }
+            return Ok(SearchKey::And(keys));
+        }
+        Some(Token::Close) => return Err(syntax("unbalanced parenthesis")),
+        Some(Token::Quoted(s)) => return Err(syntax(format!("unexpected string {s:?}"))),
+        Some(Token::Atom(a)) => a,
+        None => return Err(syntax("missing search key")),
+    };
+    let upper = atom.to_ascii_uppercase();
+    let key = match upper.as_str() {
+        "ALL" | "OLD" | "UNANSWERED" | "UNDRAFT" => SearchKey::All,
+        "RECENT" | "NEW" | "ANSWERED" | "DRAFT" => SearchKey::Nothing,
+        "SEEN" => SearchKey::Seen(true),
+        "UNSEEN" => SearchKey::Seen(false),
+        "DELETED" => SearchKey::Deleted(true),
+        "UNDELETED" => SearchKey::Deleted(false),
+        "FLAGGED" => SearchKey::Flagged(true),
+        "UNFLAGGED" => SearchKey::Flagged(false),
+        "KEYWORD" | "UNKEYWORD" => {
+            let want = upper == "KEYWORD";
+            let flag = string_arg(tokens, &upper)?;
+            if flag.eq_ignore_ascii_case("$Junk") {
+                SearchKey::Junk(want)
+            } else if flag.eq_ignore_ascii_case("$NotJunk") {
+                SearchKey::NotJunk(want)
+            } else if want {
+                SearchKey::Nothing
+            } else {
+                SearchKey::All
+            }
+        }
+        "BEFORE" => SearchKey::Before(date_arg(tokens, &upper)?),
+        "ON" => SearchKey::On(date_arg(tokens, &upper)?),
+        "SINCE" => SearchKey::Since(date_arg(tokens, &upper)?),
+        "SENTBEFORE" => SearchKey::SentBefore(date_arg(tokens, &upper)?),
+        "SENTON" => SearchKey::SentOn(date_arg(tokens, &upper)?),
+        "SENTSINCE" => SearchKey::SentSince(date_arg(tokens, &upper)?),
+        "LARGER" => SearchKey::Larger(number_arg(tokens, &upper)?),
+        "SMALLER" => SearchKey::Smaller(number_arg(tokens, &upper)?),
+        "FROM" | "TO" | "CC" | "BCC" | "SUBJECT" => {
+            SearchKey::Header(upper.clone(), string_arg(tokens, &upper)?)
+        }
+        "HEADER" => {
+            let field = string_arg(tokens, &upper)?;
+            SearchKey::Header(field.to_ascii_uppercase(), string_arg(tokens, &upper)?)
+        }
+        "BODY" => SearchKey::Body(string_arg(tokens, &upper)?),
+        "TEXT" => SearchKey::Text(string_arg(tokens, &upper)?),
+        "NOT" => SearchKey::Not(Box::new(parse_key(tokens)?)),
+        "OR" => {
+            let a = parse_key(tokens)?;
+            let b = parse_key(tokens)?;
+            SearchKey::Or(Box::new(a), Box::new(b))
+        }
+        "UID" => match tokens.pop() {
+            Some(Token::Atom(set)) => SearchKey::UidSet(
+                NumSet::parse(&set).ok_or_else(|| syntax(format!("bad UID set {set:?}")))?,
+            ),
+            _ => return Err(syntax("UID needs a set")),
+        },
+        _ => match NumSet::parse(&atom) {
+            Some(set) => SearchKey::SeqSet(set),
+            None => return Err(syntax(format!("unknown search key {atom:?}"))),
+        },
+    };
+    Ok(key)
+}
+
+fn string_arg(tokens: &mut Vec<Token>, key: &str) -> Result<String, SearchError> {
+    match tokens.pop() {
+        Some(Token::Atom(s) | Token::Quoted(s)) => Ok(s),
+        _ => Err(syntax(format!("{key} needs a string"))),
+    }
+}
+
+fn number_arg(tokens: &mut Vec<Token>, key: &str) -> Result<u64, SearchError> {
+    match tokens.pop() {
+        Some(Token::Atom(s)) => s
+            .parse()
+            .map_err(|_| syntax(format!("{key} needs a number"))),
+        _ => Err(syntax(format!("{key} needs a number"))),
+    }
+}
+
+fn date_arg(tokens: &mut Vec<Token>, key: &str) -> Result<Date, SearchError> {
+    match tokens.pop() {
+        Some(Token::Atom(s) | Token::Quoted(s)) => parse_search_date(&s)
+            .ok_or_else(|| syntax(format!("{key} needs a date like 1-Feb-1994"))),
+        _ => Err(syntax(format!("{key} needs a date"))),
+    }
+}
+
+/// RFC 3501 `date`: `d-Mon-yyyy` or `dd-Mon-yyyy`.
+fn parse_search_date(s: &str) -> Option<Date> {
+    let mut parts = s.split('-');
+    let (day, month, year) = (parts.next()?, parts.next()?, parts.next()?);
+    if parts.next().is_some() {
+        return None;
+    }
+    make_date(day, month, year)
+}
+
+fn make_date(day: &str, month: &str, year: &str) -> Option<Date> {
+    let day: u8 = day.parse().ok()?;
+    let year: i32 = year.parse().ok()?;
+    Date::from_calendar_date(year, month_from_name(month)?, day).ok()
+}
+
+fn month_from_name(name: &str) -> Option<Month> {
+    const MONTHS: [Month; 12] = [
+        Month::January,
+        Month::February,
+        Month::March,
+        Month::April,
+        Month::May,
+        Month::June,
+        Month::July,
+        Month::August,
+        Month::September,
+        Month::October,
+        Month::November,
+        Month::December,
+    ];
+    const NAMES: [&str; 12] = [
+        "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC",
+    ];
+    let name = name.get(..3)?;
+    NAMES
+        .iter()
+        .position(|n| n.eq_ignore_ascii_case(name))
+        .map(|i| MONTHS[i])
+}
+
+fn utc_date(t: SystemTime) -> Date {
+    let secs = t
+        .duration_since(std::time::UNIX_EPOCH)
+        .map_or(0, |d| d.as_secs() as i64);
+    OffsetDateTime::from_unix_timestamp(secs)
+        .unwrap_or(OffsetDateTime::UNIX_EPOCH)
+        .date()
+}
+
+/// Calendar date of the `Date:` header, in the sender's own zone (RFC 3501 ignores time and
+/// timezone for the SENT* keys). Messages without a parsable header match none of them.
+fn sent_date(raw: &[u8]) -> Option<Date> {
+    let value = header_values(raw, "DATE").into_iter().next()?;
+    let value = value
+        .split_once(',')
+        .map_or(value.as_str(), |(_, rest)| rest);
+    let mut parts = value.split_whitespace();
+    make_date(parts.next()?, parts.next()?, parts.next()?)
+}
+
+/// Split a message into its header block and body at the first empty line.
+fn split_body(raw: &[u8]) -> (&[u8], &[u8]) {
+    for (i, w) in raw.windows(2).enumerate() {
+        if w == b"\n\n" {
+            return (&raw[..i + 1], &raw[i + 2..]);
+        }
+        if w == b"\n\r" && raw.get(i + 2) == Some(&b'\n') {
+            return (&raw[..i + 1], &raw[i + 3..]);
+        }
+    }
+    (raw, &[])
+}
+
+/// Unfolded values of every header field named `field` (ASCII case-insensitive).
+fn header_values(raw: &[u8], field: &str) -> Vec<String> {
+    let head = String::from_utf8_lossy(split_body(raw).0);
+    let mut out: Vec<String> = Vec::new();
+    let mut current = false;
+    for line in head.lines() {
+        let line = line.trim_end_matches('\r');
+        if line.starts_with([' ', '\t']) {
+            if current {
+                if let Some(last) = out.last_mut() {
+                    last.push(' ');
+                    last.push_str(line.trim());
+                }
+            }
+            continue;
+        }
+        current = false;
+        if let Some((name, value)) = line.split_once(':') {
+            if name.trim().eq_ignore_ascii_case(field) {
+                current = true;
+                out.push(value.trim().to_string());
+            }
+        }
+    }
+    out
+}
+
+/// Case-insensitive substring test. ASCII needles fold byte-wise; anything else compares the
+/// lowercased UTF-8 text.
+fn contains_ci(hay: &[u8], needle: &str) -> bool {
+    if needle.is_empty() {
+        return true;
+    }
+    if needle.is_ascii() {
+        let n = needle.as_bytes();
+        return hay.windows(n.len()).any(|w| w.eq_ignore_ascii_case(n));
+    }
+    String::from_utf8_lossy(hay)
+        .to_lowercase()
+        .contains(&needle.to_lowercase())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::{Duration, UNIX_EPOCH};
+
+    const MSG_A: &[u8] = b"From: Alice <alice@test>\r\nTo: bob@test\r\nSubject: Lunch plans\r\nMessage-ID: <a1@test>\r\nDate: Mon, 3 Feb 2025 10:00:00 +0100\r\n\r\nShall we meet at NOON?\r\n";
+    const MSG_B: &[u8] = b"From: carol@test\r\nTo: bob@test\r\nCc: dave@test\r\nSubject: Re: build\r\n broken again\r\nMessage-ID: <b2@test>\r\nDate: 10 Mar 2025 23:30:00 -0500\r\n\r\nThe pipeline is red.\r\n";
+    const MSG_C: &[u8] =
+        b"From: eve@test\nSubject: no date\n\nplain LF body with Gr\xc3\xbc\xc3\x9fe\n";
+
+    struct Fixture {
+        uid: u32,
+        raw: &'static [u8],
+        received: SystemTime,
+        flags: MaildirFlags,
+    }
+
+    fn day(secs: u64) -> SystemTime {
+        UNIX_EPOCH + Duration::from_secs(secs)
+    }
+
+    fn fixtures() -> Vec<Fixture> {
+        vec![
+            Fixture {
+                uid: 10,
+                raw: MSG_A,
+                // 2025-02-03 12:00 UTC
+                received: day(1_738_584_000),
+                flags: MaildirFlags {
+                    seen: true,
+                    ..Default::default()
+                },
+            },
+            Fixture {
+                uid: 20,
+                raw: MSG_B,
+                // 2025-03-11 05:00 UTC
+                received: day(1_741_669_200),
+                flags: MaildirFlags {
+                    flagged: true,
+                    junk: true,
+                    ..Default::default()
+                },
+            },
+            Fixture {
+                uid: 30,
+                raw: MSG_C,
+                // 2025-04-01 00:00 UTC
+                received: day(1_743_465_600),
+                flags: MaildirFlags::default(),
+            },
+        ]
+    }
+
+    /// UIDs matching `query`, evaluated the way the session does: listing first, body on demand.
+    fn search(query: &str) -> Vec<u32> {
+        let key = parse_search(query).unwrap();
+        let all = fixtures();
+        let bounds = SearchBounds {
+            max_seq: all.len() as u32,
+            max_uid: all.last().unwrap().uid,
+        };
+        all.iter()
+            .enumerate()
+            .filter(|(i, f)| {
+                let mut msg = SearchMessage {
+                    seq: *i as u32 + 1,
+                    uid: f.uid,
+                    size: f.raw.len() as u64,
+                    received: f.received,
+                    flags: &f.flags,
+                    raw: None,
+                };
+                key.matches(&msg, bounds).unwrap_or_else(|| {
+                    msg.raw = Some(f.raw);
+                    key.matches(&msg, bounds).expect("decided with content")
+                })
+            })
+            .map(|(_, f)| f.uid)
+            .collect()
+    }
+
+    #[test]
+    fn sequence_uid_and_flag_keys() {
+        assert_eq!(search("ALL"), [10, 20, 30]);
+        assert_eq!(search("2:*"), [20, 30]);
+        assert_eq!(search("*"), [30]);
+        assert_eq!(search("UID 15:25"), [20]);
+        assert_eq!(search("UID 20,*"), [20, 30]);
+        assert_eq!(search("SEEN"), [10]);
+        assert_eq!(search("UNSEEN"), [20, 30]);
+        assert_eq!(search("FLAGGED KEYWORD $Junk"), [20]);
+        assert_eq!(search("UNKEYWORD $junk"), [10, 30]);
+        assert_eq!(search("KEYWORD $Forwarded"), Vec::<u32>::new());
+        assert_eq!(search("NEW"), Vec::<u32>::new());
+    }
+
+    #[test]
+    fn header_keys_are_case_insensitive_and_unfold() {
+        assert_eq!(search("HEADER Message-ID <B2@TEST>"), [20]);
+        assert_eq!(search("header message-id \"\""), [10, 20]);
+        assert_eq!(search("FROM ALICE"), [10]);
+        assert_eq!(search("TO bob@test"), [10, 20]);
+        assert_eq!(search("CC dave"), [20]);
+        assert_eq!(search("BCC dave"), Vec::<u32>::new());
+        assert_eq!(search("SUBJECT \"build broken\""), [20]);
+        assert_eq!(search("SUBJECT \"no date\""), [30]);
+    }
+
+    #[test]
+    fn body_and_text_keys() {
+        assert_eq!(search("BODY noon"), [10]);
+        assert_eq!(
+            search("BODY lunch"),
+            Vec::<u32>::new(),
+            "subject is not body"
+        );
+        assert_eq!(search("TEXT lunch"), [10]);
+        assert_eq!(search("TEXT PIPELINE"), [20]);
+        assert_eq!(search("BODY GRÜSSE"), Vec::<u32>::new());
+        assert_eq!(search("BODY GRÜßE"), [30]);
+    }
+
+    #[test]
+    fn internal_and_sent_date_keys() {
+        assert_eq!(search("BEFORE 11-Mar-2025"), [10]);
+        assert_eq!(search("ON 11-Mar-2025"), [20]);
+        assert_eq!(search("SINCE \"11-Mar-2025\""), [20, 30]);
+        // Sent dates use the header's own calendar day: 10 Mar in -0500.
+        assert_eq!(search("SENTON 10-Mar-2025"), [20]);
+        assert_eq!(search("SENTBEFORE 10-Mar-2025"), [10]);
+        assert_eq!(
+            search("SENTSINCE 4-feb-2025"),
+            [20],
+            "no Date header never matches"
+        );
+    }
+
+    #[test]
+    fn size_keys() {
+        let a = MSG_A.len() as u64;
+        assert_eq!(search(&format!("LARGER {}", a - 1)), [10, 20]);
+        assert_eq!(search(&format!("SMALLER {a}")), [30]);
+    }
+
+    #[test]
+    fn not_or_and_groups() {
+        assert_eq!(search("NOT SEEN"), [20, 30]);
+        assert_eq!(search("OR FROM alice FROM eve"), [10, 30]);
+        assert_eq!(search("NOT (FROM alice UNSEEN)"), [10, 20, 30]);
+        assert_eq!(search("NOT (TO bob OR SEEN FLAGGED)"), [30]);
+        assert_eq!(search("OR (UID 10 BODY noon) (UID 30 BODY noon)"), [10]);
+        assert_eq!(search("UID 20:* HEADER To bob"), [20]);
+        assert_eq!(
+            search("UID 1:15 NOT HEADER Message-ID a1"),
+            Vec::<u32>::new()
+        );
+    }
+
+    #[test]
+    fn listing_keys_decide_without_content() {
+        let key = parse_search("UID 10 OR HEADER To bob TEXT x").unwrap();
+        let flags = MaildirFlags::default();
+        let msg = SearchMessage {
+            seq: 2,
+            uid: 20,
+            size: 1,
+            received: UNIX_EPOCH,
+            flags: &flags,
+            raw: None,
+        };
+        let bounds = SearchBounds {
+            max_seq: 3,
+            max_uid: 30,
+        };
+        assert_eq!(key.matches(&msg, bounds), Some(false));
+        let open = parse_search("UID 20 HEADER To bob").unwrap();
+        assert_eq!(open.matches(&msg, bounds), None);
+    }
+
+    #[test]
+    fn parse_errors_and_charset() {
+        assert_eq!(parse_search("CHARSET utf-8 ALL").unwrap(), SearchKey::All);
+        assert_eq!(
+            parse_search("CHARSET KOI8-R ALL"),
+            Err(SearchError::BadCharset)
+        );
+        assert!(parse_search("").is_err());
+        assert!(parse_search("(SEEN").is_err());
+        assert!(parse_search("SINCE 31-Feb-2025").is_err());
+        assert!(parse_search("FROM {5}").is_err());
+        assert!(parse_search("BOGUS").is_err());
+        assert!(parse_search("UID").is_err());
+    }
+}
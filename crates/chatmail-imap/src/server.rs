@@ -15,13 +15,14 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::net::SocketAddr;
 use std::sync::Arc;
 
 use chatmail_db::DbPool;
 use chatmail_state::AppState;
 use chatmail_types::Result;
 use rustls::ServerConfig;
-use tokio::net::TcpListener;
+use tokio::net::{TcpListener, TcpStream};
 use tokio_rustls::TlsAcceptor;
 use tokio_util::sync::CancellationToken;
 use tracing::info;
@@ -53,6 +54,10 @@ pub async fn run_imap_listener(
             }
             accept = listener.accept() => {
                 let (stream, peer) = accept?;
+                if ctx.bans.is_banned(peer.ip()) {
+                    tracing::debug!(%peer, "IMAP connection from banned address dropped");
+                    continue;
+                }
                 // Disable Nagle: IMAP is a chatty request/response protocol (DONE → FETCH →
                 // STORE → EXPUNGE → IDLE). With Nagle + delayed-ACK, each small reply can stall
                 // 40–200ms, compounding across the per-message fetch cycle into multi-second
@@ -67,15 +72,10 @@ pub async fn run_imap_listener(
                 let acceptor = tls_acceptor.clone();
                 tokio::spawn(async move {
                     connection_stats::on_open(&peer_ip);
-                    let mut session = ImapSession::new(ctx, pool, cfg);
+                    let mut session =
+                        ImapSession::new(Arc::clone(&ctx), pool, cfg).with_peer(peer.ip());
                     let result = if let Some(acceptor) = acceptor {
-                        match acceptor.accept(stream).await {
-                            Ok(tls_stream) => session.handle_tls_connection(tls_stream).await,
-                            Err(e) => {
-                                tracing::debug!(%peer, error = %e, "IMAP TLS handshake failed");
-                                Ok(())
-                            }
-                        }
+                        serve_implicit_tls(session, &ctx, acceptor, stream, peer).await
                     } else {
                         session.handle_connection(stream).await
                     };
@@ -89,3 +89,28 @@ pub async fn run_imap_listener(
     }
     Ok(())
 }
+
+/// Implicit TLS: fingerprint the ClientHello, drop banned fingerprints, then handshake.
+async fn serve_implicit_tls(
+    session: ImapSession,
+    ctx: &AppState,
+    acceptor: TlsAcceptor,
+    stream: TcpStream,
+    peer: SocketAddr,
+) -> Result<()> {
+    let ja4 = match ctx.screen_tls_client(&stream).await {
+        Ok(ja4) => ja4,
+        Err(ja4) => {
+            tracing::debug!(%peer, %ja4, "IMAP connection with banned TLS fingerprint dropped");
+            return Ok(());
+        }
+    };
+    let mut session = session.with_tls_fingerprint(ja4);
+    match acceptor.accept(stream).await {
+        Ok(tls_stream) => session.handle_tls_connection(tls_stream).await,
+        Err(e) => {
+            tracing::debug!(%peer, error = %e, "IMAP TLS handshake failed");
+            Ok(())
+        }
+    }
+}
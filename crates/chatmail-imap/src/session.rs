@@ -15,6 +15,7 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::net::IpAddr;
 use std::sync::Arc;
 
 use chatmail_auth::{normalize_username, AuthContext};
@@ -22,12 +23,17 @@ use chatmail_config::CredentialPolicy;
 use chatmail_db::DbPool;
 use chatmail_iroh::IrohDiscovery;
 use chatmail_pgp::{enforce_encryption, EnforceOptions};
-use chatmail_state::{AppState, NewMessageEvent};
+use chatmail_state::junk::{keyword_direction, move_direction};
+use chatmail_state::{
+    catch_panic, AppState, ClockMonitor, CrashScope, JunkDirection, JunkReclassifiedEvent,
+    NewMessageEvent, Panicked,
+};
 use chatmail_storage::{
-    commit_mailbox_blob_from_tmp, copy_message, expunge_deleted, list_mailbox_messages,
-    mailbox_exists, move_message, read_blob, read_blob_known, read_blob_range_known,
-    storage_policy::FsyncMode, store_add_flags, stream_append_direct_final_no_hash,
-    stream_append_to_tmp, write_blob_mailbox, StoredMessage,
+    commit_mailbox_blob_from_tmp, copy_message, expunge_deleted_bytes, list_user_mailboxes,
+    mailbox_exists, mailbox_snapshot, move_message, read_blob, read_blob_known,
+    read_blob_range_known, storage_policy::FsyncMode, store_add_flags, store_set_flagged,
+    store_set_junk_keyword, stream_append_direct_final_no_hash, stream_append_to_tmp,
+    write_blob_mailbox, MailboxExpunge, StoredMessage,
 };
 use chatmail_turn::TurnDiscovery;
 use chatmail_types::{ChatmailError, Result};
@@ -40,6 +46,8 @@ use tokio_rustls::server::TlsStream;
 use tokio_rustls::TlsAcceptor;
 use tracing::{debug, warn};
 
+use crate::search::{parse_search, SearchBounds, SearchError, SearchMessage};
+
 /// Max time a single IDLE notification may spend writing unsolicited updates to the client socket.
 /// Per-subscriber egress isolation (Stalwart push-manager pattern): a half-open / wedged TCP
 /// connection is dropped instead of pinning its IDLE task (and its broadcast receiver) forever.
@@ -47,6 +55,9 @@ use tracing::{debug, warn};
 /// are a few bytes.
 const IDLE_EMIT_TIMEOUT: std::time::Duration = std::time::Duration::from_secs(120);
 
+/// Sent when the account's password was reset or the account deleted under the session.
+const REVOKED_BYE: &[u8] = b"* BYE Credentials changed, please log in again\r\n";
+
 #[derive(Clone)]
 pub struct ImapSessionConfig {
     pub hostname: String,
@@ -94,7 +105,16 @@ pub struct ImapSession {
     /// was the throughput wall. The cache is invalidated whenever the version counter advances
     /// (any local or remote mutation bumps it), so it never serves stale state.
     cached_inbox_version: u64,
+    /// [`chatmail_storage::MailboxStore::expunge_generation`] the cache was built at: retention
+    /// prunes delete files without bumping the inbox version.
+    cached_expunge_generation: u64,
     cached_inbox_messages: Option<Vec<MailMessage>>,
+    /// Expunges made outside this session (retention prunes) while a mailbox is selected.
+    expunges_rx: Option<broadcast::Receiver<MailboxExpunge>>,
+    /// `* n EXPUNGE` lines not yet sent. Sequence numbers are computed when the expunge is
+    /// taken off `expunges_rx`, against the view the client still has, and the lines wait for
+    /// a command during which RFC 3501 allows EXPUNGE (not FETCH, STORE or SEARCH).
+    pending_expunges: String,
     /// Per-user delivery subscription kept alive for the whole connection so that messages
     /// arriving while the client is *between* IDLE commands (i.e. mid FETCH/STORE/re-IDLE) stay
     /// buffered in the broadcast channel instead of being dropped. Re-subscribing on every IDLE
@@ -102,6 +122,12 @@ pub struct ImapSession {
     /// burst most receivers missed the live EXISTS push and only discovered the mail on Delta
     /// Chat's ~75s periodic IDLE refresh — the cause of the heavy tail latency and message loss.
     events_rx: Option<broadcast::Receiver<NewMessageEvent>>,
+    /// Client address; failed `LOGIN` from it counts toward an automatic IP ban.
+    peer: Option<IpAddr>,
+    /// JA4 of the client's TLS ClientHello, for abuse logs only.
+    tls_fingerprint: Option<String>,
+    /// Account generation at LOGIN; see [`chatmail_state::SessionRevocations`].
+    login_generation: u64,
 }
 
 #[derive(Clone)]
@@ -113,6 +139,8 @@ struct MailMessage {
     filename: String,
     size: u64,
     internal_date: String,
+    /// Raw internal date; SEARCH BEFORE/ON/SINCE compare its UTC calendar day.
+    received: std::time::SystemTime,
     flags: chatmail_storage::MaildirFlags,
 }
 
@@ -129,11 +157,27 @@ impl ImapSession {
             messages: Vec::new(),
             announced_exists: 0,
             cached_inbox_version: 0,
+            cached_expunge_generation: 0,
             cached_inbox_messages: None,
+            expunges_rx: None,
+            pending_expunges: String::new(),
             events_rx: None,
+            peer: None,
+            tls_fingerprint: None,
+            login_generation: 0,
         }
     }
 
+    pub fn with_peer(mut self, peer: IpAddr) -> Self {
+        self.peer = Some(peer);
+        self
+    }
+
+    pub fn with_tls_fingerprint(mut self, fingerprint: Option<String>) -> Self {
+        self.tls_fingerprint = fingerprint;
+        self
+    }
+
     pub async fn handle_connection(&mut self, stream: TcpStream) -> Result<()> {
         if self.cfg.starttls_config.is_some() {
             self.serve_with_starttls_upgrade(stream).await
@@ -183,6 +227,17 @@ impl ImapSession {
                 writer.flush().await?;
                 let reader = lines.into_inner();
                 let stream = reader.unsplit(writer);
+                match self.ctx.screen_tls_client(&stream).await {
+                    Ok(ja4) => self.tls_fingerprint = ja4,
+                    Err(ja4) => {
+                        tracing::debug!(
+                            peer = ?self.peer,
+                            %ja4,
+                            "IMAP STARTTLS with banned TLS fingerprint dropped"
+                        );
+                        return Ok(());
+                    }
+                }
                 let acceptor = TlsAcceptor::from(cfg);
                 let tls = acceptor
                     .accept(stream)
@@ -202,9 +257,18 @@ impl ImapSession {
                 continue;
             }
 
-            let resp = self
-                .dispatch(&mut lines, tag, &cmd, &args, &mut writer, false)
-                .await?;
+            // IDLE can last for the whole session; only bounded commands hold shutdown open.
+            let _work = (cmd_upper != "IDLE").then(|| self.ctx.drain.track());
+            let dispatched =
+                catch_panic(self.dispatch(&mut lines, tag, &cmd, &args, &mut writer, false)).await;
+            let resp = match dispatched {
+                Ok(resp) => resp?,
+                Err(panic) => {
+                    self.report_panic(&panic, &cmd_upper, false);
+                    writer.write_all(b"* BYE Internal server error\r\n").await?;
+                    break;
+                }
+            };
             if let Some(r) = resp {
                 writer.write_all(r.as_bytes()).await?;
             }
@@ -273,12 +337,31 @@ impl ImapSession {
                 continue;
             }
 
-            let resp = self
-                .dispatch(&mut lines, tag, &cmd, &args, &mut writer, tls_active)
-                .await?;
+            if self.is_revoked() {
+                writer.write_all(REVOKED_BYE).await?;
+                break;
+            }
+
+            // IDLE can last for the whole session; only bounded commands hold shutdown open.
+            let _work = (cmd_upper != "IDLE").then(|| self.ctx.drain.track());
+            let dispatched =
+                catch_panic(self.dispatch(&mut lines, tag, &cmd, &args, &mut writer, tls_active))
+                    .await;
+            let resp = match dispatched {
+                Ok(resp) => resp?,
+                Err(panic) => {
+                    self.report_panic(&panic, &cmd_upper, tls_active);
+                    writer.write_all(b"* BYE Internal server error\r\n").await?;
+                    break;
+                }
+            };
             if let Some(r) = resp {
                 writer.write_all(r.as_bytes()).await?;
             }
+            // IDLE already said BYE when it was cut short by a revocation.
+            if cmd_upper == "IDLE" && self.is_revoked() {
+                break;
+            }
             if cmd_upper == "LOGOUT" {
                 writer
                     .write_all(b"* BYE madmail-v2 logging out\r\n")
@@ -308,7 +391,13 @@ impl ImapSession {
         W: AsyncWriteExt + Unpin,
     {
         let t = tag.unwrap_or("*");
-        match cmd.to_ascii_uppercase().as_str() {
+        let cmd_upper = cmd.to_ascii_uppercase();
+        self.collect_expunges().await?;
+        if expunge_allowed_during(&cmd_upper) && !self.pending_expunges.is_empty() {
+            let lines = std::mem::take(&mut self.pending_expunges);
+            writer.write_all(lines.as_bytes()).await?;
+        }
+        match cmd_upper.as_str() {
             "CAPABILITY" => Ok(Some(format!(
                 "* CAPABILITY {}\r\n{t} OK CAPABILITY completed\r\n",
                 capability_string(
@@ -318,6 +407,8 @@ impl ImapSession {
                 )
             ))),
             "NOOP" => Ok(Some(format!("{t} OK NOOP completed\r\n"))),
+            #[cfg(test)]
+            "XINJECTPANIC" => panic!("injected IMAP session panic"),
             "LOGIN" => {
                 let (user, pass) = match parse_login_args(args) {
                     Ok(v) => v,
@@ -334,6 +425,17 @@ impl ImapSession {
                     credential_policy: self.cfg.credential_policy,
                 };
                 if let Err(e) = chatmail_auth::authenticate(&auth, &user, &pass).await {
+                    if let ChatmailError::AuthFailed = e {
+                        tracing::debug!(
+                            peer = ?self.peer,
+                            ja4 = ?self.tls_fingerprint,
+                            %user,
+                            "IMAP LOGIN failed"
+                        );
+                        if let Some(ip) = self.peer {
+                            self.ctx.note_auth_failure(&self.pool, ip).await;
+                        }
+                    }
                     return Ok(Some(format_imap_login_failure(t, &e)));
                 }
                 let user = match normalize_username(&user) {
@@ -346,6 +448,7 @@ impl ImapSession {
                     .mailbox_store
                     .init_mailbox_dir(&user, "DeltaChat")
                     .await;
+                self.login_generation = self.ctx.sessions.generation(&user);
                 self.authenticated_user = Some(user);
                 Ok(Some(format!("{t} OK LOGIN completed\r\n")))
             }
@@ -374,7 +477,11 @@ impl ImapSession {
                     let user = self.require_user()?;
                     if let Some(folder) = self.selected_mailbox.clone() {
                         if self.selected_folder_needs_expunge {
-                            let _ = expunge_deleted(&self.ctx.mailbox_store, &user, &folder).await;
+                            if let Ok((_, freed)) =
+                                expunge_deleted_bytes(&self.ctx.mailbox_store, &user, &folder).await
+                            {
+                                self.ctx.quota.record_remove(&user, freed, true);
+                            }
                             self.selected_folder_needs_expunge = false;
                             // Files removed on disk → invalidate any cached listing.
                             self.bump_inbox(&user, &folder);
@@ -387,6 +494,8 @@ impl ImapSession {
                 self.selected_mailbox = None;
                 self.messages.clear();
                 self.announced_exists = 0;
+                self.expunges_rx = None;
+                self.pending_expunges.clear();
                 Ok(Some(format!("{t} OK CLOSE completed\r\n")))
             }
             "STATUS" => {
@@ -395,11 +504,13 @@ impl ImapSession {
                 if !mailbox_exists(&self.ctx.mailbox_store, &user, &mailbox).await {
                     return Ok(Some(format!("{t} NO Mailbox does not exist\r\n")));
                 }
-                let msgs = list_messages(&self.ctx, &user, &mailbox).await?;
-                let exists = msgs.len();
-                let uid_next = msgs.last().map(|m| m.uid + 1).unwrap_or(1);
+                // Counts are maintained on the shared listing; STATUS never walks it.
+                let snap = mailbox_snapshot(&self.ctx.mailbox_store, &user, &mailbox).await?;
+                let exists = snap.counts.messages;
+                let unseen = snap.counts.unseen;
+                let uid_next = snap.uid_next();
                 Ok(Some(format!(
-                    "* STATUS \"{mailbox}\" (MESSAGES {exists} UIDNEXT {uid_next} UIDVALIDITY 1 UNSEEN {exists})\r\n{t} OK STATUS completed\r\n"
+                    "* STATUS \"{mailbox}\" (MESSAGES {exists} UIDNEXT {uid_next} UIDVALIDITY 1 UNSEEN {unseen})\r\n{t} OK STATUS completed\r\n"
                 )))
             }
             "FETCH" => {
@@ -413,6 +524,12 @@ impl ImapSession {
                 self.handle_fetch(t, rest, &user, true, writer).await?;
                 Ok(None)
             }
+            "UID" if args.to_ascii_uppercase().starts_with("SEARCH") => {
+                let user = self.require_user()?;
+                let rest = args.split_once(' ').map(|(_, r)| r).unwrap_or("");
+                let resp = self.handle_search(t, rest, &user, true).await?;
+                Ok(Some(resp))
+            }
             "UID" if args.to_ascii_uppercase().starts_with("STORE") => {
                 let user = self.require_user()?;
                 let rest = args.split_once(' ').map(|(_, r)| r).unwrap_or("");
@@ -428,16 +545,34 @@ impl ImapSession {
             "UID" if args.to_ascii_uppercase().starts_with("COPY") => {
                 let user = self.require_user()?;
                 let rest = args.split_once(' ').map(|(_, r)| r).unwrap_or("");
-                let resp = self.handle_copy(t, rest, &user).await?;
-                Ok(Some(resp))
+                match self.handle_copy(t, rest, &user).await {
+                    Ok(resp) => Ok(Some(resp)),
+                    Err(ChatmailError::QuotaExceeded { .. }) => {
+                        Ok(Some(format!("{t} NO [OVERQUOTA] COPY rejected\r\n")))
+                    }
+                    Err(e) => Err(e),
+                }
             }
             "STORE" => {
                 let user = self.require_user()?;
                 let resp = self.handle_store(t, args, &user, false).await?;
                 Ok(Some(resp))
             }
+            "SEARCH" => {
+                let user = self.require_user()?;
+                let resp = self.handle_search(t, args, &user, false).await?;
+                Ok(Some(resp))
+            }
             "APPEND" => {
                 let user = self.require_user()?;
+                // Disk above its limits: refuse before the continuation so no literal is sent.
+                if let Some((_, size)) = parse_literal_size(args) {
+                    if self.ctx.disk.admit_message(size as u64).is_err() {
+                        return Ok(Some(format!(
+                            "{t} NO [UNAVAILABLE] Server storage is full, try again later\r\n"
+                        )));
+                    }
+                }
                 // Synchronizing literal `{N}` (no +): client waits for continuation (RFC 3501).
                 if let Some((_, _, non_sync)) = parse_literal_spec(args) {
                     if !non_sync {
@@ -506,6 +641,26 @@ impl ImapSession {
         }
     }
 
+    /// A command panicked: log and report it; the caller sends `BYE` and ends this session only.
+    fn report_panic(&self, panic: &Panicked, command: &str, tls_active: bool) {
+        let peer = self
+            .peer
+            .map_or_else(|| "unknown".into(), |ip| ip.to_string());
+        let scope = CrashScope::new("imap", peer)
+            .field("user", self.authenticated_user.as_deref().unwrap_or("-"))
+            .field("mailbox", self.selected_mailbox.as_deref().unwrap_or("-"))
+            .field("command", command)
+            .field("tls", tls_active);
+        self.ctx.crashes.report(&scope, panic);
+    }
+
+    /// The logged-in account's password was reset or the account deleted since LOGIN.
+    fn is_revoked(&self) -> bool {
+        self.authenticated_user
+            .as_deref()
+            .is_some_and(|u| self.ctx.sessions.is_revoked(u, self.login_generation))
+    }
+
     fn require_user(&self) -> Result<String> {
         self.authenticated_user
             .clone()
@@ -519,13 +674,15 @@ impl ImapSession {
             return list_messages(&self.ctx, user, mailbox).await;
         }
         let version = self.ctx.events.inbox_version(user);
-        if version == self.cached_inbox_version {
+        let generation = self.ctx.mailbox_store.expunge_generation();
+        if version == self.cached_inbox_version && generation == self.cached_expunge_generation {
             if let Some(cached) = &self.cached_inbox_messages {
                 return Ok(cached.clone());
             }
         }
         let msgs = list_messages(&self.ctx, user, mailbox).await?;
         self.cached_inbox_version = version;
+        self.cached_expunge_generation = generation;
         self.cached_inbox_messages = Some(msgs.clone());
         Ok(msgs)
     }
@@ -538,6 +695,20 @@ impl ImapSession {
         }
     }
 
+    /// Publish a Junk reclassification; a non-blocking broadcast so STORE/MOVE never wait on
+    /// the trainer.
+    fn publish_junk(&self, user: &str, mailbox: &str, msg: &MailMessage, direction: JunkDirection) {
+        self.ctx
+            .events
+            .publish_junk_reclassified(JunkReclassifiedEvent {
+                username: user.to_string(),
+                mailbox: mailbox.to_string(),
+                msg_id: msg.id.clone(),
+                size: msg.size,
+                direction,
+            });
+    }
+
     /// Read a message body, preferring the filename the listing already discovered (a direct file
     /// open) and falling back to the scanning `read_blob` only if the entry moved (e.g. a flag
     /// change relocated it between `new/` and `cur/`) since the cached listing was built.
@@ -610,6 +781,15 @@ impl ImapSession {
         W: AsyncWriteExt + Unpin,
     {
         let mode = fetch_response_mode(args);
+        if mode != FetchResponseMode::Metadata && !self.ctx.memory.admit("imap") {
+            writer
+                .write_all(
+                    format!("{tag} NO [LIMIT] Server busy, retry the FETCH later\r\n").as_bytes(),
+                )
+                .await?;
+            writer.flush().await?;
+            return Ok(());
+        }
         let partial = if mode == FetchResponseMode::FullBody {
             parse_body_partial(args)
         } else {
@@ -695,11 +875,84 @@ impl ImapSession {
             }
         }
         out.extend_from_slice(format!("{tag} OK FETCH completed\r\n").as_bytes());
+        let _lease = self.ctx.memory.reserve(out.len() as u64);
         writer.write_all(&out).await?;
         writer.flush().await?;
         Ok(())
     }
 
+    /// SEARCH / UID SEARCH. Listing-only keys (sets, flags, internal date, size) are decided
+    /// first; a message body is read only when a header/body/sent-date key is still undecided.
+    async fn handle_search(
+        &mut self,
+        tag: &str,
+        args: &str,
+        user: &str,
+        by_uid: bool,
+    ) -> Result<String> {
+        let key = match parse_search(args) {
+            Ok(key) => key,
+            Err(SearchError::BadCharset) => {
+                return Ok(format!(
+                    "{tag} NO [BADCHARSET (US-ASCII UTF-8)] Unsupported charset\r\n"
+                ));
+            }
+            Err(SearchError::Syntax(msg)) => return Ok(format!("{tag} BAD {msg}\r\n")),
+        };
+        let mailbox = self
+            .selected_mailbox
+            .as_deref()
+            .unwrap_or("INBOX")
+            .to_string();
+        self.messages = self.load_messages(user, &mailbox).await?;
+        let bounds = SearchBounds {
+            max_seq: self.messages.len() as u32,
+            max_uid: self.messages.last().map_or(0, |m| m.uid),
+        };
+
+        let mut hits = Vec::new();
+        let mut admitted = false;
+        for (i, m) in self.messages.iter().enumerate() {
+            let seq = (i + 1) as u32;
+            let mut msg = SearchMessage {
+                seq,
+                uid: m.uid,
+                size: m.size,
+                received: m.received,
+                flags: &m.flags,
+                raw: None,
+            };
+            let hit = match key.matches(&msg, bounds) {
+                Some(hit) => hit,
+                None => {
+                    // Admission is checked once, at the first body this search has to read.
+                    if !admitted && !self.ctx.memory.admit("imap") {
+                        return Ok(format!(
+                            "{tag} NO [LIMIT] Server busy, retry the SEARCH later\r\n"
+                        ));
+                    }
+                    // Expunged since the listing was built: nothing left to match.
+                    let Ok(raw) = self.read_message_body(user, &mailbox, m).await else {
+                        continue;
+                    };
+                    admitted = true;
+                    msg.raw = Some(&raw);
+                    key.matches(&msg, bounds).unwrap_or(false)
+                }
+            };
+            if hit {
+                hits.push(if by_uid { m.uid } else { seq });
+            }
+        }
+
+        let mut resp = String::from("* SEARCH");
+        for n in hits {
+            resp.push_str(&format!(" {n}"));
+        }
+        resp.push_str(&format!("\r\n{tag} OK SEARCH completed\r\n"));
+        Ok(resp)
+    }
+
     async fn handle_store(
         &mut self,
         tag: &str,
@@ -725,9 +978,12 @@ impl ImapSession {
             mode == StoreMode::Add && flags.iter().any(|f| f.eq_ignore_ascii_case("\\Seen"));
         let add_deleted =
             mode == StoreMode::Add && flags.iter().any(|f| f.eq_ignore_ascii_case("\\Deleted"));
+        let add_flagged =
+            mode == StoreMode::Add && flags.iter().any(|f| f.eq_ignore_ascii_case("\\Flagged"));
         if add_deleted {
             self.selected_folder_needs_expunge = true;
         }
+        let junk = keyword_direction(&flags);
 
         let mut out = String::new();
         let mut deleted_count = 0usize;
@@ -735,6 +991,23 @@ impl ImapSession {
             let Some(msg) = self.messages.iter().find(|m| m.uid == uid) else {
                 continue;
             };
+            // Keyword first: `\Deleted` in the same STORE unlinks the file.
+            if let Some(direction) = junk {
+                let (_, changed) = store_set_junk_keyword(
+                    &self.ctx.mailbox_store,
+                    user,
+                    mailbox,
+                    &msg.id,
+                    direction == JunkDirection::Spam,
+                )
+                .await?;
+                if changed {
+                    self.publish_junk(user, mailbox, msg, direction);
+                }
+            }
+            if add_flagged && !add_deleted {
+                store_set_flagged(&self.ctx.mailbox_store, user, mailbox, &msg.id).await?;
+            }
             let new_flags = store_add_flags(
                 &self.ctx.mailbox_store,
                 user,
@@ -746,6 +1019,10 @@ impl ImapSession {
             .await?;
             if add_deleted {
                 deleted_count += 1;
+                // `\Deleted` unlinks immediately (relay semantics); quota covers every mailbox.
+                self.ctx
+                    .quota
+                    .record_remove(user, msg.size, msg.flags.deleted);
             }
             let seq = self
                 .messages
@@ -792,8 +1069,12 @@ impl ImapSession {
             self.ctx.mailbox_store.init_mailbox_dir(user, &dest).await?;
         }
         let moved = msgs.len();
+        let junk = move_direction(from, &dest);
         for m in &msgs {
             move_message(&self.ctx.mailbox_store, user, from, &dest, &m.id).await?;
+            if let Some(direction) = junk {
+                self.publish_junk(user, &dest, m, direction);
+            }
         }
         // Client removed `moved` known messages from the source mailbox; lower the baseline so a
         // concurrently-delivered message is still announced on the next IDLE.
@@ -817,11 +1098,16 @@ impl ImapSession {
         if msgs.is_empty() {
             return Ok(format!("{tag} NO [TRYCREATE] No such messages\r\n"));
         }
+        // Copies are new files in the same account: charge them like APPEND. MOVE renames
+        // within the account and leaves usage unchanged.
+        let bytes: u64 = msgs.iter().map(|m| m.size).sum();
+        self.ctx.quota.check_quota(user, bytes)?;
         if !mailbox_exists(&self.ctx.mailbox_store, user, &dest).await {
             self.ctx.mailbox_store.init_mailbox_dir(user, &dest).await?;
         }
         for m in &msgs {
             copy_message(&self.ctx.mailbox_store, user, from, &dest, &m.id).await?;
+            self.ctx.quota.record_write(user, m.size);
         }
         Ok(format!("{tag} OK UID COPY completed\r\n"))
     }
@@ -867,6 +1153,7 @@ impl ImapSession {
         // actually grew, so a duplicate buffered event in the loop below is a harmless no-op.
         self.emit_idle_updates(writer, &user).await?;
 
+        let mut revocations = self.ctx.sessions.subscribe();
         let mut idle_line = String::new();
         loop {
             tokio::select! {
@@ -920,6 +1207,23 @@ impl ImapSession {
                         Err(RecvError::Closed) => break,
                     }
                 }
+                expunge = recv_expunge(&mut self.expunges_rx) => {
+                    self.note_expunge(expunge).await?;
+                    let lines = std::mem::take(&mut self.pending_expunges);
+                    if !lines.is_empty() {
+                        writer.write_all(lines.as_bytes()).await?;
+                        writer.flush().await?;
+                    }
+                }
+                Ok(()) = revocations.changed() => {
+                    if self.is_revoked() {
+                        debug!(%user, "IMAP IDLE ended: session revoked");
+                        self.events_rx = Some(rx);
+                        writer.write_all(REVOKED_BYE).await?;
+                        writer.flush().await?;
+                        return Ok(());
+                    }
+                }
             }
         }
 
@@ -934,6 +1238,61 @@ impl ImapSession {
         Ok(())
     }
 
+    /// Queue `EXPUNGE` lines for every expunge published since the last command.
+    async fn collect_expunges(&mut self) -> Result<()> {
+        loop {
+            let Some(rx) = self.expunges_rx.as_mut() else {
+                return Ok(());
+            };
+            let expunge = match rx.try_recv() {
+                Ok(expunge) => Ok(expunge),
+                Err(broadcast::error::TryRecvError::Lagged(n)) => Err(RecvError::Lagged(n)),
+                Err(_) => return Ok(()),
+            };
+            self.note_expunge(expunge).await?;
+        }
+    }
+
+    /// Queue `EXPUNGE` lines for one published expunge (or, after a lag, for everything the
+    /// listing no longer has) and drop those messages from the session's view.
+    async fn note_expunge(
+        &mut self,
+        expunge: std::result::Result<MailboxExpunge, RecvError>,
+    ) -> Result<()> {
+        let (Some(user), Some(mailbox)) = (
+            self.authenticated_user.clone(),
+            self.selected_mailbox.clone(),
+        ) else {
+            return Ok(());
+        };
+        let gone: Vec<u32> = match expunge {
+            Ok(e) if e.user == user && e.mailbox.eq_ignore_ascii_case(&mailbox) => e.uids,
+            Ok(_) => return Ok(()),
+            Err(RecvError::Lagged(_)) => {
+                let live = list_messages(&self.ctx, &user, &mailbox).await?;
+                self.messages
+                    .iter()
+                    .map(|m| m.uid)
+                    .filter(|uid| !live.iter().any(|l| l.uid == *uid))
+                    .collect()
+            }
+            Err(RecvError::Closed) => {
+                self.expunges_rx = None;
+                return Ok(());
+            }
+        };
+        for uid in gone {
+            let Some(pos) = self.messages.iter().position(|m| m.uid == uid) else {
+                continue;
+            };
+            self.messages.remove(pos);
+            self.announced_exists = self.announced_exists.saturating_sub(1);
+            self.pending_expunges
+                .push_str(&format!("* {} EXPUNGE\r\n", pos + 1));
+        }
+        Ok(())
+    }
+
     /// Reload INBOX and send unsolicited EXISTS / RECENT if the message count grew.
     async fn emit_idle_updates<W>(&mut self, writer: &mut W, user: &str) -> Result<()>
     where
@@ -992,6 +1351,11 @@ impl ImapSession {
             if let Some(v) =
                 shared_metadata_value(key, self.cfg.turn.as_ref(), self.cfg.iroh.as_ref())
             {
+                if v.is_some() {
+                    if let Some(hint) = turn_clock_hint(key, &self.ctx.clock) {
+                        warn!(%user, "TURN credentials issued with skewed clock: {hint}");
+                    }
+                }
                 entries.push(format_metadata_value(key, v.as_deref()));
             }
         }
@@ -1060,7 +1424,7 @@ impl ImapSession {
     where
         R: tokio::io::AsyncRead + Unpin,
     {
-        let max_bytes = self.ctx.message_size.effective();
+        let max_bytes = self.ctx.message_size.effective_for(user);
         let mailbox = self
             .selected_mailbox
             .clone()
@@ -1121,6 +1485,7 @@ impl ImapSession {
                     &EnforceOptions {
                         mail_from: user.to_string(),
                         recipients: vec![user.to_string()],
+                        ..Default::default()
                     },
                 ) {
                     tokio::fs::remove_file(&tmp_path).await.ok();
@@ -1197,6 +1562,7 @@ impl ImapSession {
             &EnforceOptions {
                 mail_from: user.to_string(),
                 recipients: vec![user.to_string()],
+                ..Default::default()
             },
         )?;
         self.ctx.quota.check_quota(user, written_len as u64)?;
@@ -1215,10 +1581,22 @@ impl ImapSession {
     async fn format_list_response(&self, tag: &str) -> Result<String> {
         let user = self.require_user()?;
         let mut out = String::new();
-        for name in ["INBOX", "DeltaChat"] {
-            if mailbox_exists(&self.ctx.mailbox_store, &user, name).await {
-                out.push_str(&format!("* LIST (\\HasNoChildren) \"/\" \"{name}\"\r\n"));
+        // Every folder on disk, not just DeltaChat and Junk: Sieve `fileinto` and subaddress
+        // filing write to folders of any name.
+        for name in list_user_mailboxes(&self.ctx.mailbox_store, &user).await? {
+            if !mailbox_exists(&self.ctx.mailbox_store, &user, &name).await {
+                continue;
             }
+            // RFC 6154 special-use: moves in/out of this mailbox are junk-training signals.
+            let attrs = if name == "Junk" {
+                "\\HasNoChildren \\Junk"
+            } else {
+                "\\HasNoChildren"
+            };
+            out.push_str(&format!(
+                "* LIST ({attrs}) \"/\" {}\r\n",
+                imap_quote_mailbox(&name)
+            ));
         }
         out.push_str(&format!("{tag} OK LIST completed\r\n"));
         Ok(out)
@@ -1236,6 +1614,9 @@ impl ImapSession {
             return Ok(format!("{tag} NO [NONEXISTENT] Mailbox does not exist\r\n"));
         }
         self.selected_mailbox = Some(mailbox.clone());
+        // Subscribe before listing: a prune between the two is then reported, not lost.
+        self.expunges_rx = Some(self.ctx.mailbox_store.subscribe_expunges());
+        self.pending_expunges.clear();
         self.messages = self.load_messages(user, &mailbox).await?;
         let exists = self.messages.len();
         self.announced_exists = exists;
@@ -1247,13 +1628,30 @@ impl ImapSession {
 }
 
 async fn list_messages(ctx: &AppState, user: &str, mailbox: &str) -> Result<Vec<MailMessage>> {
-    Ok(list_mailbox_messages(&ctx.mailbox_store, user, mailbox)
+    Ok(mailbox_snapshot(&ctx.mailbox_store, user, mailbox)
         .await?
-        .into_iter()
+        .messages
+        .iter()
+        .cloned()
         .map(stored_to_mail_message)
         .collect())
 }
 
+/// RFC 3501 §7.4.1: no `EXPUNGE` while a sequence-number FETCH, STORE or SEARCH runs.
+fn expunge_allowed_during(cmd_upper: &str) -> bool {
+    !matches!(cmd_upper, "FETCH" | "STORE" | "SEARCH")
+}
+
+/// Next expunge for the selected mailbox; never resolves with nothing selected.
+async fn recv_expunge(
+    rx: &mut Option<broadcast::Receiver<MailboxExpunge>>,
+) -> std::result::Result<MailboxExpunge, RecvError> {
+    match rx {
+        Some(rx) => rx.recv().await,
+        None => std::future::pending().await,
+    }
+}
+
 /// Carry the persistent uidlist UID through to the IMAP layer instead of renumbering by position,
 /// so UIDs stay stable for the life of the mailbox (IMAP UIDVALIDITY contract).
 fn stored_to_mail_message(m: StoredMessage) -> MailMessage {
@@ -1263,6 +1661,7 @@ fn stored_to_mail_message(m: StoredMessage) -> MailMessage {
         filename: m.filename,
         size: m.size,
         internal_date: format_internal_date(m.internal_date),
+        received: m.internal_date,
         flags: m.flags,
     }
 }
@@ -1469,6 +1868,17 @@ fn parse_quoted_devicetoken_value(args: &str) -> Option<String> {
     Some(inner[..end].to_string())
 }
 
+/// TURN credentials embed an expiry time; with a skewed clock clients see them as
+/// expired or not yet valid.
+fn turn_clock_hint(key: &str, clock: &ClockMonitor) -> Option<String> {
+    matches!(
+        key,
+        "/shared/vendor/deltachat/turn" | "/shared/vendor/deltachat/turns"
+    )
+    .then(|| clock.skew_hint())
+    .flatten()
+}
+
 fn shared_metadata_value(
     key: &str,
     turn: Option<&TurnDiscovery>,
@@ -1809,6 +2219,9 @@ fn format_imap_login_failure(tag: &str, err: &ChatmailError) -> String {
             unreachable!();
         };
         format!("{tag} BAD {msg}\r\n")
+    } else if matches!(err, ChatmailError::ServerFull(_)) {
+        // RFC 5530: temporary, the client may retry later.
+        format!("{tag} NO [UNAVAILABLE] Server full\r\n")
     } else {
         format!("{tag} NO [AUTHENTICATIONFAILED] LOGIN failed\r\n")
     }
@@ -1881,6 +2294,7 @@ mod tests {
                 filename: "first".into(),
                 size: 1,
                 internal_date: "01-Jan-2020 00:00:00 +0000".into(),
+                received: std::time::UNIX_EPOCH,
                 flags: Default::default(),
             },
             MailMessage {
@@ -1889,6 +2303,7 @@ mod tests {
                 filename: "second".into(),
                 size: 2,
                 internal_date: "02-Jan-2020 00:00:00 +0000".into(),
+                received: std::time::UNIX_EPOCH,
                 flags: Default::default(),
             },
         ];
@@ -2141,6 +2556,7 @@ mod tests {
             &EnforceOptions {
                 mail_from: "u@example.org".into(),
                 recipients: vec!["u@example.org".into()],
+                ..Default::default()
             },
         )
         .unwrap_err();
@@ -2206,6 +2622,27 @@ mod tests {
         );
     }
 
+    #[tokio::test]
+    async fn turn_metadata_carries_clock_skew_hint() {
+        let clock = ClockMonitor::default();
+        assert_eq!(
+            turn_clock_hint("/shared/vendor/deltachat/turn", &clock),
+            None
+        );
+
+        let fake = chatmail_state::FixedOffsetTimeSource {
+            name: "https://time.example/".into(),
+            offset_secs: 900,
+        };
+        clock.check(&fake).await.unwrap();
+        let hint = turn_clock_hint("/shared/vendor/deltachat/turns", &clock).unwrap();
+        assert!(hint.contains("behind https://time.example/"), "{hint}");
+        assert_eq!(
+            turn_clock_hint("/shared/vendor/deltachat/irohrelay", &clock),
+            None
+        );
+    }
+
     #[tokio::test]
     async fn p6_ut02_test_quota_quotaroot_format() {
         let dir = tempfile::tempdir().unwrap();
@@ -2415,6 +2852,162 @@ mod integration_tests {
         assert_eq!(literal, &body[..], "binary body round-trips byte-for-byte");
     }
 
+    /// A revoked account loses its sessions: an idling one at once, a busy one on its next
+    /// command. A fresh LOGIN is not affected.
+    #[tokio::test]
+    async fn revoked_account_sessions_get_bye() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("pw").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let addr = spawn_imap_server(pool, Arc::clone(&ctx)).await;
+
+        let mut idler = TcpStream::connect(addr).await.unwrap();
+        let _ = read_until(&mut idler, b"IMAP4rev1 ready").await;
+        idler.write_all(b"a1 LOGIN u@test pw\r\n").await.unwrap();
+        let _ = read_until(&mut idler, b"a1 OK").await;
+        idler.write_all(b"a2 SELECT INBOX\r\n").await.unwrap();
+        let _ = read_until(&mut idler, b"a2 OK").await;
+        idler.write_all(b"a3 IDLE\r\n").await.unwrap();
+        let _ = read_until(&mut idler, b"+ idling").await;
+
+        let mut busy = TcpStream::connect(addr).await.unwrap();
+        let _ = read_until(&mut busy, b"IMAP4rev1 ready").await;
+        busy.write_all(b"b1 LOGIN u@test pw\r\n").await.unwrap();
+        let _ = read_until(&mut busy, b"b1 OK").await;
+
+        ctx.sessions.revoke("u@test");
+        let bye = read_until(&mut idler, b"* BYE").await;
+        assert!(String::from_utf8_lossy(&bye).contains("log in again"));
+        busy.write_all(b"b2 NOOP\r\n").await.unwrap();
+        let bye = read_until(&mut busy, b"* BYE").await;
+        assert!(!String::from_utf8_lossy(&bye).contains("b2 OK"));
+
+        let mut fresh = TcpStream::connect(addr).await.unwrap();
+        let _ = read_until(&mut fresh, b"IMAP4rev1 ready").await;
+        fresh
+            .write_all(b"c1 LOGIN u@test pw\r\nc2 NOOP\r\n")
+            .await
+            .unwrap();
+        let ok = read_until(&mut fresh, b"c2 OK").await;
+        assert!(String::from_utf8_lossy(&ok).contains("c2 OK"));
+    }
+
+    /// A retention prune under a selected mailbox reaches the session as `EXPUNGE`, so
+    /// sequence numbers, FETCH and STATUS all agree on what is left.
+    #[tokio::test]
+    async fn retention_prune_reports_expunge_to_selected_session() {
+        use chatmail_storage::{purge_mail_blobs_with_policy, RetentionPolicy};
+
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("pw").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        for id in ["old", "fresh"] {
+            write_blob(&ctx.mailbox_store, "u@test", id, b"Subject: x\r\n\r\nbody")
+                .await
+                .unwrap();
+        }
+        let old = ctx.mailbox_store.maildir_for_user("u@test").new.join("old");
+        std::fs::File::options()
+            .write(true)
+            .open(&old)
+            .unwrap()
+            .set_modified(std::time::UNIX_EPOCH + Duration::from_secs(1))
+            .unwrap();
+
+        let addr = spawn_imap_server(pool, Arc::clone(&ctx)).await;
+        let mut stream = TcpStream::connect(addr).await.unwrap();
+        let _ = read_until(&mut stream, b"IMAP4rev1 ready").await;
+        stream.write_all(b"a1 LOGIN u@test pw\r\n").await.unwrap();
+        let _ = read_until(&mut stream, b"a1 OK").await;
+        stream.write_all(b"a2 SELECT INBOX\r\n").await.unwrap();
+        let select = read_until(&mut stream, b"a2 OK").await;
+        assert!(String::from_utf8_lossy(&select).contains("* 2 EXISTS"));
+
+        let policy = RetentionPolicy::new(Duration::from_secs(3600));
+        let pruned = purge_mail_blobs_with_policy(&ctx.mailbox_store, &policy)
+            .await
+            .unwrap();
+        assert_eq!(pruned, 1);
+
+        // Sequence-number FETCH must not carry the EXPUNGE; the NOOP after it does.
+        stream.write_all(b"a3 FETCH 1:* (UID)\r\n").await.unwrap();
+        let fetch = String::from_utf8_lossy(&read_until(&mut stream, b"a3 OK").await).into_owned();
+        assert!(!fetch.contains("EXPUNGE"), "{fetch}");
+        stream.write_all(b"a4 NOOP\r\n").await.unwrap();
+        let noop = String::from_utf8_lossy(&read_until(&mut stream, b"a4 OK").await).into_owned();
+        assert!(noop.contains("* 1 EXPUNGE\r\n"), "{noop}");
+
+        stream.write_all(b"a5 FETCH 1:* (UID)\r\n").await.unwrap();
+        let fetch = String::from_utf8_lossy(&read_until(&mut stream, b"a5 OK").await).into_owned();
+        assert!(fetch.contains("* 1 FETCH (UID 2"), "{fetch}");
+        assert!(!fetch.contains("* 2 FETCH"), "{fetch}");
+        stream
+            .write_all(b"a6 STATUS INBOX (MESSAGES)\r\n")
+            .await
+            .unwrap();
+        let status = read_until(&mut stream, b"a6 OK").await;
+        assert!(String::from_utf8_lossy(&status).contains("MESSAGES 1 "));
+    }
+
+    /// Body FETCH is refused with `NO [LIMIT]` while the memory budget is exhausted; flag-only
+    /// FETCH keeps working, and body FETCH resumes once usage drops.
+    #[tokio::test]
+    async fn fetch_body_refused_while_memory_budget_exhausted() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("pw").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        let ctx = Arc::new(AppState {
+            memory: Arc::new(chatmail_state::MemoryBudget::new(1 << 20)),
+            ..AppState::new(dir.path(), pool.clone())
+        });
+        write_blob(
+            &ctx.mailbox_store,
+            "u@test",
+            "m1",
+            b"From: u@test\r\nSubject: x\r\n\r\nhello\r\n",
+        )
+        .await
+        .unwrap();
+        let held = ctx.memory.reserve(2 << 20);
+
+        let addr = spawn_imap_server(pool, Arc::clone(&ctx)).await;
+        let mut stream = TcpStream::connect(addr).await.unwrap();
+        let _ = read_until(&mut stream, b"IMAP4rev1 ready").await;
+        stream.write_all(b"a001 LOGIN u@test pw\r\n").await.unwrap();
+        let _ = read_until(&mut stream, b"a001 OK").await;
+        stream.write_all(b"a002 SELECT INBOX\r\n").await.unwrap();
+        let _ = read_until(&mut stream, b"a002 OK").await;
+
+        stream.write_all(b"a003 FETCH 1 BODY[]\r\n").await.unwrap();
+        let resp = read_until(&mut stream, b"\r\n").await;
+        assert!(
+            resp.starts_with(b"a003 NO [LIMIT]"),
+            "{}",
+            String::from_utf8_lossy(&resp)
+        );
+        stream.write_all(b"a004 FETCH 1 (FLAGS)\r\n").await.unwrap();
+        let resp = read_until(&mut stream, b"a004 OK").await;
+        assert!(String::from_utf8_lossy(&resp).contains("FLAGS"));
+
+        drop(held);
+        stream.write_all(b"a005 FETCH 1 BODY[]\r\n").await.unwrap();
+        let resp = read_until(&mut stream, b"a005 OK FETCH completed\r\n").await;
+        assert!(String::from_utf8_lossy(&resp).contains("hello"));
+        assert_eq!(ctx.memory.rejections(), 1);
+        assert_eq!(ctx.memory.used(), 0);
+    }
+
     /// P10-UT07: `BODY[]<offset.count>` partial fetch returns only the requested window with the
     /// origin octet echoed, and the bytes match the corresponding slice of the full body.
     #[tokio::test]
@@ -2576,6 +3169,102 @@ mod integration_tests {
         assert!(t.contains("MESSAGE-ID"), "header fetch: {t}");
     }
 
+    /// SEARCH / UID SEARCH over delivered mail: header, text, date and flag keys, UID ranges
+    /// combined with header keys, and the BAD / BADCHARSET refusals.
+    #[tokio::test]
+    async fn search_and_uid_search_match_criteria() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("pw").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let bodies: [&[u8]; 3] = [
+            b"From: alice@test\r\nSubject: Lunch\r\nMessage-ID: <m1@test>\r\nDate: 3 Feb 2025 10:00:00 +0000\r\n\r\nnoon?\r\n",
+            b"From: bob@test\r\nSubject: Build\r\nMessage-ID: <m2@test>\r\nDate: 10 Mar 2025 10:00:00 +0000\r\n\r\nThe pipeline is red.\r\n",
+            b"From: alice@test\r\nSubject: Re: Build\r\nMessage-ID: <m3@test>\r\n\r\nFixed the PIPELINE.\r\n",
+        ];
+        for (i, body) in bodies.iter().enumerate() {
+            write_blob(&ctx.mailbox_store, "u@test", &format!("m{}", i + 1), body)
+                .await
+                .unwrap();
+        }
+
+        let t = imap_dialog(
+            pool,
+            ctx,
+            &[
+                "a1 LOGIN u@test pw",
+                "a2 SELECT INBOX",
+                "a3 UID STORE 2 +FLAGS (\\Seen)",
+                "a4 SEARCH FROM ALICE",
+                "a5 UID SEARCH UID 2:* HEADER Message-ID <m3@test>",
+                "a6 UID SEARCH UID 1:2 HEADER Message-ID <m3@test>",
+                "a7 SEARCH OR SUBJECT lunch TEXT \"pipeline is\"",
+                "a8 SEARCH NOT (TEXT pipeline UNSEEN)",
+                "a9 SEARCH SENTSINCE 1-Mar-2025",
+                "b1 SEARCH SINCE 1-Jan-2000 SEEN",
+                "b2 SEARCH BEFORE 1-Jan-2000",
+                "b3 SEARCH CHARSET KOI8-R ALL",
+                "b4 SEARCH BOGUS",
+                "b5 LOGOUT",
+            ],
+        )
+        .await;
+        assert!(t.contains("* SEARCH 1 3\r\na4 OK SEARCH"), "from: {t}");
+        assert!(t.contains("* SEARCH 3\r\na5 OK"), "uid range + header: {t}");
+        assert!(t.contains("* SEARCH\r\na6 OK"), "uid range excludes: {t}");
+        assert!(t.contains("* SEARCH 1 2\r\na7 OK"), "or: {t}");
+        assert!(t.contains("* SEARCH 1 2\r\na8 OK"), "not group: {t}");
+        assert!(t.contains("* SEARCH 2\r\na9 OK"), "sent date: {t}");
+        assert!(
+            t.contains("* SEARCH 2\r\nb1 OK"),
+            "internal date + flag: {t}"
+        );
+        assert!(t.contains("* SEARCH\r\nb2 OK"), "before: {t}");
+        assert!(t.contains("b3 NO [BADCHARSET"), "charset: {t}");
+        assert!(t.contains("b4 BAD"), "unknown key: {t}");
+    }
+
+    /// STATUS reports the maintained unseen counter, not the message total.
+    #[tokio::test]
+    async fn status_reports_unseen_after_flag_change() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("pw").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        for id in ["m1", "m2"] {
+            write_blob(&ctx.mailbox_store, "u@test", id, b"Subject: x\r\n\r\nx")
+                .await
+                .unwrap();
+        }
+        // Warm the listing so the flag change goes through the copy-on-write path.
+        mailbox_snapshot(&ctx.mailbox_store, "u@test", "INBOX")
+            .await
+            .unwrap();
+        store_add_flags(&ctx.mailbox_store, "u@test", "INBOX", "m1", true, false)
+            .await
+            .unwrap();
+
+        let t = imap_dialog(
+            pool,
+            ctx,
+            &[
+                "a1 LOGIN u@test pw",
+                "a2 STATUS INBOX (MESSAGES UNSEEN)",
+                "a3 LOGOUT",
+            ],
+        )
+        .await;
+        assert!(t.contains("MESSAGES 2 "), "status: {t}");
+        assert!(t.contains("UNSEEN 1)"), "status: {t}");
+    }
+
     /// Login domain validation must return tagged NO, not drop the connection (E2E test #19).
     #[tokio::test]
     async fn imap_login_rejects_invalid_domain_with_no() {
@@ -2702,6 +3391,40 @@ mod integration_tests {
         assert!(t.contains(MESSAGE_FILE_TOO_BIG), "append toobig: {t}");
     }
 
+    /// `imap-acct appendlimit`: an account's own cap refuses literals the global one allows.
+    #[tokio::test]
+    async fn imap_append_over_account_limit_is_toobig() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("pw").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        chatmail_db::set_append_limit(&pool, "u@test", 1024)
+            .await
+            .unwrap();
+        let cfg = chatmail_config::AppConfig::default();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        ctx.hydrate(&pool, &cfg).await.unwrap();
+        assert!(ctx.message_size.effective() > 1024);
+
+        let payload = vec![b'x'; 2000];
+        let t = imap_dialog(
+            pool,
+            ctx,
+            &[
+                "c001 LOGIN u@test pw",
+                &format!("c002 APPEND INBOX {{{}}}", payload.len()),
+                &format!("LITERAL:{}", String::from_utf8_lossy(&payload)),
+            ],
+        )
+        .await;
+        assert!(
+            t.contains("c002 NO [TOOBIG]"),
+            "append over account limit: {t}"
+        );
+    }
+
     fn large_pgp_mime_body(min_bytes: usize) -> Vec<u8> {
         let header = b"From: u@test\r\nTo: u@test\r\nContent-Type: multipart/encrypted; boundary=b\r\n\r\n--b\r\nContent-Type: application/pgp-encrypted\r\n\r\nv\r\n--b--\r\n";
         let mut body = header.to_vec();
@@ -3174,6 +3897,75 @@ mod integration_tests {
         assert!(t.contains("/shared/vendor/deltachat/turn"), "metadata: {t}");
     }
 
+    /// Junk training: the `$Junk` keyword path and MOVE across the Junk mailbox each publish one
+    /// `JunkReclassified` event per transition; re-applying the same keyword publishes nothing.
+    #[tokio::test]
+    async fn junk_keyword_and_move_publish_reclassified_once() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("pw").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let body = b"From: a@b.test\r\nTo: u@test\r\nContent-Type: multipart/encrypted; boundary=b\r\n\r\n--b\r\nContent-Type: application/pgp-encrypted\r\n\r\nv\r\n--b--\r\n";
+        write_blob(&ctx.mailbox_store, "u@test", "m1", body)
+            .await
+            .unwrap();
+        write_blob(&ctx.mailbox_store, "u@test", "m2", body)
+            .await
+            .unwrap();
+        // A folder only Sieve `fileinto` writes to.
+        write_blob_mailbox(&ctx.mailbox_store, "u@test", "Archive", "m3", body)
+            .await
+            .unwrap();
+        let mut rx = ctx.events.subscribe_junk();
+
+        let t = imap_dialog(
+            pool,
+            Arc::clone(&ctx),
+            &[
+                "j001 LOGIN u@test pw",
+                "j002 SELECT INBOX",
+                "j003 UID STORE 1 +FLAGS ($Junk)",
+                "j004 UID STORE 1 +FLAGS ($Junk)",
+                "j005 UID MOVE 2 Junk",
+                "j006 LIST \"\" \"*\"",
+                "j007 SELECT Junk",
+                "j008 UID MOVE 1 INBOX",
+                "j009 LOGOUT",
+            ],
+        )
+        .await;
+        assert!(t.contains("$Junk"), "STORE echoes keyword: {t}");
+        assert!(t.contains("j005 OK"), "move into Junk: {t}");
+        assert!(
+            t.contains("\\Junk) \"/\" \"Junk\""),
+            "special-use LIST: {t}"
+        );
+        assert!(
+            t.contains("* LIST (\\HasNoChildren) \"/\" \"Archive\""),
+            "folders on disk are listed: {t}"
+        );
+        assert!(t.contains("j008 OK"), "move out of Junk: {t}");
+
+        let mut events = Vec::new();
+        while let Ok(ev) = rx.try_recv() {
+            events.push(ev);
+        }
+        assert_eq!(events.len(), 3, "one event per transition: {events:?}");
+        assert_eq!(events[0].direction, JunkDirection::Spam);
+        assert_eq!(events[0].mailbox, "INBOX");
+        assert_eq!(events[1].direction, JunkDirection::Spam);
+        assert_eq!(events[1].mailbox, "Junk");
+        assert_ne!(events[0].msg_id, events[1].msg_id);
+        assert_eq!(events[2].direction, JunkDirection::Ham);
+        assert_eq!(events[2].mailbox, "INBOX");
+        assert_eq!(events[2].msg_id, events[1].msg_id);
+        assert!(events.iter().all(|e| e.username == "u@test" && e.size > 0));
+        assert_eq!(ctx.events.junk_reclassified_counts(), (2, 1));
+    }
+
     fn loopback_tls_configs() -> (Arc<ServerConfig>, Arc<rustls::ClientConfig>) {
         use rcgen::generate_simple_self_signed;
         use rustls::pki_types::{CertificateDer, PrivateKeyDer};
@@ -3460,4 +4252,184 @@ mod integration_tests {
             "greeting: {greeting}"
         );
     }
+
+    /// A banned JA4 fingerprint is refused before the handshake whatever the source address;
+    /// rotating loopback addresses stand in for a client hopping IPs.
+    #[tokio::test]
+    async fn imap_banned_tls_fingerprint_rejected_across_source_ips() {
+        use rustls::pki_types::ServerName;
+        use tokio::net::TcpSocket;
+        use tokio_rustls::TlsConnector;
+
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let (tls_server, tls_client) = loopback_tls_configs();
+
+        // Learn the fingerprint of this rustls client from a throwaway listener.
+        let probe = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let probe_addr = probe.local_addr().unwrap();
+        let client = Arc::clone(&tls_client);
+        let dialer = tokio::spawn(async move {
+            let stream = TcpStream::connect(probe_addr).await.unwrap();
+            let server_name = ServerName::try_from("localhost").unwrap();
+            let _ = TlsConnector::from(client)
+                .connect(server_name, stream)
+                .await;
+        });
+        let (probe_stream, _) = probe.accept().await.unwrap();
+        let ja4 = ctx
+            .tls_fingerprints
+            .fingerprint(&probe_stream)
+            .await
+            .expect("rustls ClientHello fingerprint");
+        drop(probe_stream);
+        let _ = dialer.await;
+
+        let addr = {
+            let l = StdListener::bind("127.0.0.1:0").unwrap();
+            l.local_addr().unwrap()
+        };
+        let cancel = tokio_util::sync::CancellationToken::new();
+        let cfg = ImapSessionConfig {
+            hostname: "imap.test".into(),
+            primary_domain: "test".into(),
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            turn: None,
+            iroh: None,
+            push_enabled: true,
+            starttls_config: None,
+        };
+        let listener = {
+            let (cancel, ctx, pool) = (cancel.clone(), Arc::clone(&ctx), pool.clone());
+            tokio::spawn(async move {
+                let addr = addr.to_string();
+                crate::server::run_imap_listener(
+                    &addr,
+                    cancel,
+                    Some(tls_server),
+                    None,
+                    ctx,
+                    pool,
+                    cfg,
+                )
+                .await
+            })
+        };
+        tokio::time::sleep(Duration::from_millis(50)).await;
+
+        let greet_from = |source: &'static str| {
+            let client = Arc::clone(&tls_client);
+            async move {
+                let socket = TcpSocket::new_v4().unwrap();
+                socket.bind(format!("{source}:0").parse().unwrap()).unwrap();
+                let stream = socket.connect(addr).await.unwrap();
+                let server_name = ServerName::try_from("localhost").unwrap();
+                let connect = TlsConnector::from(client).connect(server_name, stream);
+                let Ok(Ok(mut tls)) = tokio::time::timeout(Duration::from_secs(3), connect).await
+                else {
+                    return String::new();
+                };
+                let mut buf = [0u8; 512];
+                let n = tokio::time::timeout(Duration::from_secs(3), tls.read(&mut buf))
+                    .await
+                    .ok()
+                    .and_then(|r| r.ok())
+                    .unwrap_or(0);
+                String::from_utf8_lossy(&buf[..n]).into_owned()
+            }
+        };
+
+        assert!(greet_from("127.0.0.2").await.contains("IMAP4rev1 ready"));
+
+        chatmail_db::add_ban(
+            &pool,
+            &format!("ja4:{ja4}"),
+            chatmail_db::BAN_SOURCE_MANUAL,
+            "spray tool",
+            0,
+        )
+        .await
+        .unwrap();
+        ctx.bans.refresh(&pool).await.unwrap();
+        for source in ["127.0.0.3", "127.0.0.4", "127.0.0.5"] {
+            assert!(!ctx.bans.is_banned(source.parse().unwrap()));
+            assert_eq!(greet_from(source).await, "", "{source}");
+        }
+
+        chatmail_db::remove_ban(&pool, &format!("ja4:{ja4}"))
+            .await
+            .unwrap();
+        ctx.bans.refresh(&pool).await.unwrap();
+        assert!(greet_from("127.0.0.6").await.contains("IMAP4rev1 ready"));
+
+        cancel.cancel();
+        let _ = listener.await;
+    }
+
+    /// A panic in one session's command ends that session with `BYE` and a crash report;
+    /// another session on the same listener keeps working.
+    #[tokio::test]
+    async fn panicking_command_only_ends_its_own_session() {
+        chatmail_state::install_panic_hook(false);
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let addr = {
+            let l = StdListener::bind("127.0.0.1:0").unwrap();
+            l.local_addr().unwrap()
+        };
+        let cancel = tokio_util::sync::CancellationToken::new();
+        let cfg = ImapSessionConfig {
+            hostname: "imap.test".into(),
+            primary_domain: "test".into(),
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            turn: None,
+            iroh: None,
+            push_enabled: false,
+            starttls_config: None,
+        };
+        let listener = {
+            let (cancel, ctx, pool) = (cancel.clone(), Arc::clone(&ctx), pool.clone());
+            tokio::spawn(async move {
+                let addr = addr.to_string();
+                crate::server::run_imap_listener(&addr, cancel, None, None, ctx, pool, cfg).await
+            })
+        };
+        tokio::time::sleep(Duration::from_millis(50)).await;
+
+        let mut healthy = TcpStream::connect(addr).await.unwrap();
+        let _ = read_until(&mut healthy, b"IMAP4rev1 ready").await;
+        let mut doomed = TcpStream::connect(addr).await.unwrap();
+        let _ = read_until(&mut doomed, b"IMAP4rev1 ready").await;
+
+        doomed.write_all(b"d1 XINJECTPANIC\r\n").await.unwrap();
+        let bye = read_until(&mut doomed, b"* BYE").await;
+        assert!(
+            String::from_utf8_lossy(&bye).contains("* BYE Internal server error"),
+            "{}",
+            String::from_utf8_lossy(&bye)
+        );
+        let mut buf = [0u8; 64];
+        let closed = tokio::time::timeout(Duration::from_secs(2), doomed.read(&mut buf)).await;
+        assert!(matches!(closed, Ok(Ok(0))), "session must close after BYE");
+
+        healthy.write_all(b"h1 NOOP\r\n").await.unwrap();
+        let noop = read_until(&mut healthy, b"h1 OK").await;
+        assert!(String::from_utf8_lossy(&noop).contains("h1 OK NOOP completed"));
+
+        assert_eq!(ctx.crashes.counts().get("imap"), Some(&1));
+        let report = std::fs::read_to_string(ctx.crashes.last_report().unwrap()).unwrap();
+        assert!(report.contains("command: XINJECTPANIC"), "{report}");
+        assert!(
+            report.contains("panic: injected IMAP session panic"),
+            "{report}"
+        );
+        assert!(report.starts_with("madmail crash report"), "{report}");
+
+        cancel.cancel();
+        let _ = listener.await;
+    }
 }
@@ -17,11 +17,19 @@
 
 //! Prometheus / OpenMetrics exporter (Madmail `openmetrics` endpoint).
 
+mod http;
 mod metrics;
+mod scrape;
 mod server;
 
+pub use http::track_http;
 pub use metrics::{
-    exposition_text, init_metrics, record_smtp_aborted, record_smtp_completed,
-    record_smtp_failed_command, record_smtp_failed_login, record_smtp_started, set_queue_length,
+    exposition_text, init_metrics, observe_delivery_duration, record_http_delivery,
+    record_http_request, record_memory_backpressure, record_panic_recovered, record_registration,
+    record_shadowsocks_bytes, record_smtp_aborted, record_smtp_completed,
+    record_smtp_failed_command, record_smtp_failed_login, record_smtp_started, set_memory_budget,
+    set_queue_length, set_stale_backlog_accounts, set_storage_accounts, set_storage_usage,
+    set_tls_certificate_expiry, ShadowsocksConnection,
 };
-pub use server::run_openmetrics_listener;
+pub use scrape::{on_scrape, SCRAPE_HOOK_TIMEOUT};
+pub use server::{metrics_handler, run_openmetrics_listener};
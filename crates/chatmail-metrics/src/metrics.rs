@@ -16,7 +16,10 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 use once_cell::sync::Lazy;
-use prometheus::{register_counter_vec, register_gauge_vec, Encoder, TextEncoder};
+use prometheus::{
+    register_counter, register_counter_vec, register_gauge, register_gauge_vec,
+    register_histogram_vec, Encoder, TextEncoder,
+};
 
 static STARTED: Lazy<prometheus::CounterVec> = Lazy::new(|| {
     register_counter_vec!(
@@ -109,12 +112,253 @@ pub fn record_smtp_ratelimit_deferred(module: &str) {
     RATELIMIT_DEFERRED.with_label_values(&[module]).inc();
 }
 
+pub fn set_memory_budget(used_bytes: u64, limit_bytes: u64) {
+    MEMORY_USED.set(used_bytes as f64);
+    MEMORY_LIMIT.set(limit_bytes as f64);
+}
+
+pub fn set_stale_backlog_accounts(accounts: usize) {
+    STALE_BACKLOG_ACCOUNTS.set(accounts as f64);
+}
+
+/// Seconds until the active TLS certificate's `NotAfter` (negative once expired).
+pub fn set_tls_certificate_expiry(secs_left: i64) {
+    TLS_CERT_EXPIRY.set(secs_left as f64);
+}
+
+/// Last storage usage scan: per-account bytes, on-disk bytes, and orphaned CAS blob bytes.
+pub fn set_storage_usage(logical: u64, physical: u64, orphaned: u64) {
+    STORAGE_BYTES
+        .with_label_values(&["logical"])
+        .set(logical as f64);
+    STORAGE_BYTES
+        .with_label_values(&["physical"])
+        .set(physical as f64);
+    STORAGE_BYTES
+        .with_label_values(&["orphaned"])
+        .set(orphaned as f64);
+}
+
+pub fn record_memory_backpressure(protocol: &str) {
+    MEMORY_REJECTIONS.with_label_values(&[protocol]).inc();
+}
+
+pub fn record_panic_recovered(module: &str) {
+    PANICS_RECOVERED.with_label_values(&[module]).inc();
+}
+
 pub fn set_queue_length(module: &str, location: &str, depth: f64) {
     QUEUE_LENGTH
         .with_label_values(&[module, location])
         .set(depth);
 }
 
+static MEMORY_USED: Lazy<prometheus::Gauge> = Lazy::new(|| {
+    register_gauge!(
+        "maddy_memory_budget_used_bytes",
+        "Bytes held by registered message, FETCH and export buffers"
+    )
+    .unwrap()
+});
+
+static MEMORY_LIMIT: Lazy<prometheus::Gauge> = Lazy::new(|| {
+    register_gauge!(
+        "maddy_memory_budget_limit_bytes",
+        "Memory budget for registered buffers (0 = unlimited)"
+    )
+    .unwrap()
+});
+
+static STALE_BACKLOG_ACCOUNTS: Lazy<prometheus::Gauge> = Lazy::new(|| {
+    register_gauge!(
+        "maddy_imap_stale_backlog_accounts",
+        "Accounts whose INBOX holds an accepted-but-not-fetched backlog (last scan)"
+    )
+    .unwrap()
+});
+
+static TLS_CERT_EXPIRY: Lazy<prometheus::Gauge> = Lazy::new(|| {
+    register_gauge!(
+        "maddy_tls_certificate_expiry_seconds",
+        "Seconds until the served TLS certificate expires (negative once expired)"
+    )
+    .unwrap()
+});
+
+static STORAGE_BYTES: Lazy<prometheus::GaugeVec> = Lazy::new(|| {
+    register_gauge_vec!(
+        "maddy_storage_bytes",
+        "Mail store bytes from the last usage scan (logical, physical, orphaned)",
+        &["kind"]
+    )
+    .unwrap()
+});
+
+static MEMORY_REJECTIONS: Lazy<prometheus::CounterVec> = Lazy::new(|| {
+    register_counter_vec!(
+        "maddy_memory_backpressure_rejections",
+        "Operations refused because the memory budget was exhausted",
+        &["protocol"]
+    )
+    .unwrap()
+});
+
+static PANICS_RECOVERED: Lazy<prometheus::CounterVec> = Lazy::new(|| {
+    register_counter_vec!(
+        "maddy_panics_recovered",
+        "Panics contained to one session or request instead of ending the process",
+        &["module"]
+    )
+    .unwrap()
+});
+
+static REGISTRATIONS: Lazy<prometheus::CounterVec> = Lazy::new(|| {
+    register_counter_vec!(
+        "chatmail_registrations_total",
+        "Account registration attempts on /new",
+        &["result"]
+    )
+    .unwrap()
+});
+
+static HTTP_REQUESTS: Lazy<prometheus::CounterVec> = Lazy::new(|| {
+    register_counter_vec!(
+        "chatmail_http_requests_total",
+        "HTTP requests served, by route pattern",
+        &["path", "method", "status"]
+    )
+    .unwrap()
+});
+
+static HTTP_DURATION: Lazy<prometheus::HistogramVec> = Lazy::new(|| {
+    register_histogram_vec!(
+        "chatmail_http_request_duration_seconds",
+        "HTTP handler latency, by route pattern",
+        &["path", "method"]
+    )
+    .unwrap()
+});
+
+static SHADOWSOCKS_ACTIVE: Lazy<prometheus::Gauge> = Lazy::new(|| {
+    register_gauge!(
+        "chatmail_shadowsocks_connections_active",
+        "Shadowsocks connections currently relayed"
+    )
+    .unwrap()
+});
+
+static SHADOWSOCKS_TOTAL: Lazy<prometheus::Counter> = Lazy::new(|| {
+    register_counter!(
+        "chatmail_shadowsocks_connections_total",
+        "Shadowsocks connections accepted"
+    )
+    .unwrap()
+});
+
+static SS_BYTES: Lazy<prometheus::CounterVec> = Lazy::new(|| {
+    register_counter_vec!(
+        "chatmail_ss_bytes_total",
+        "Shadowsocks payload bytes relayed; in is client to server",
+        &["direction"]
+    )
+    .unwrap()
+});
+
+static SS_ACTIVE: Lazy<prometheus::Gauge> = Lazy::new(|| {
+    register_gauge!(
+        "chatmail_ss_connections_active",
+        "Shadowsocks connections currently relayed (ss-stats)"
+    )
+    .unwrap()
+});
+
+static HTTP_DELIVERY: Lazy<prometheus::CounterVec> = Lazy::new(|| {
+    register_counter_vec!(
+        "chatmail_http_delivery_total",
+        "Inbound federation deliveries on /mxdeliv by response status",
+        &["status"]
+    )
+    .unwrap()
+});
+
+static STORAGE_ACCOUNTS: Lazy<prometheus::Gauge> = Lazy::new(|| {
+    register_gauge!(
+        "chatmail_storage_accounts",
+        "Registered accounts, counted when /metrics is scraped"
+    )
+    .unwrap()
+});
+
+static DELIVERY_DURATION: Lazy<prometheus::HistogramVec> = Lazy::new(|| {
+    register_histogram_vec!(
+        "chatmail_delivery_duration_seconds",
+        "Outbound delivery attempt latency",
+        &["outcome"],
+        vec![0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0, 120.0]
+    )
+    .unwrap()
+});
+
+/// `result`: `ok`, `closed` (registration off), `rejected` (token / rate limit / name) or
+/// `error`.
+pub fn record_registration(result: &str) {
+    REGISTRATIONS.with_label_values(&[result]).inc();
+}
+
+pub fn record_http_delivery(status: u16) {
+    HTTP_DELIVERY
+        .with_label_values(&[&status.to_string()])
+        .inc();
+}
+
+pub fn set_storage_accounts(accounts: u64) {
+    STORAGE_ACCOUNTS.set(accounts as f64);
+}
+
+/// `path` is the matched route pattern (`/webimap/message/{uid}`), never the raw URI.
+pub fn record_http_request(path: &str, method: &str, status: u16, seconds: f64) {
+    HTTP_REQUESTS
+        .with_label_values(&[path, method, &status.to_string()])
+        .inc();
+    HTTP_DURATION
+        .with_label_values(&[path, method])
+        .observe(seconds);
+}
+
+/// `outcome`: `success`, `temporary` or `permanent`.
+pub fn observe_delivery_duration(outcome: &str, seconds: f64) {
+    DELIVERY_DURATION
+        .with_label_values(&[outcome])
+        .observe(seconds);
+}
+
+/// `direction`: `in` (read from the client) or `out` (written to it).
+pub fn record_shadowsocks_bytes(direction: &str, bytes: u64) {
+    SS_BYTES
+        .with_label_values(&[direction])
+        .inc_by(bytes as f64);
+}
+
+/// Counts one relayed Shadowsocks connection in the active gauge until dropped.
+#[derive(Debug)]
+pub struct ShadowsocksConnection(());
+
+impl ShadowsocksConnection {
+    pub fn open() -> Self {
+        SHADOWSOCKS_TOTAL.inc();
+        SHADOWSOCKS_ACTIVE.inc();
+        SS_ACTIVE.inc();
+        Self(())
+    }
+}
+
+impl Drop for ShadowsocksConnection {
+    fn drop(&mut self) {
+        SHADOWSOCKS_ACTIVE.dec();
+        SS_ACTIVE.dec();
+    }
+}
+
 /// Register all metric families with the global registry (call before serving `/metrics`).
 pub fn init_metrics() {
     let _ = &*STARTED;
@@ -124,6 +368,23 @@ pub fn init_metrics() {
     let _ = &*FAILED_LOGINS;
     let _ = &*FAILED_COMMANDS;
     let _ = &*QUEUE_LENGTH;
+    let _ = &*MEMORY_USED;
+    let _ = &*MEMORY_LIMIT;
+    let _ = &*MEMORY_REJECTIONS;
+    let _ = &*PANICS_RECOVERED;
+    let _ = &*STALE_BACKLOG_ACCOUNTS;
+    let _ = &*TLS_CERT_EXPIRY;
+    let _ = &*STORAGE_BYTES;
+    let _ = &*REGISTRATIONS;
+    let _ = &*HTTP_REQUESTS;
+    let _ = &*HTTP_DURATION;
+    let _ = &*SHADOWSOCKS_ACTIVE;
+    let _ = &*DELIVERY_DURATION;
+    let _ = &*SHADOWSOCKS_TOTAL;
+    let _ = &*SS_BYTES;
+    let _ = &*SS_ACTIVE;
+    let _ = &*HTTP_DELIVERY;
+    let _ = &*STORAGE_ACCOUNTS;
     // Create label children so `/metrics` is non-empty before the first SMTP event.
     let _ = STARTED.with_label_values(&["smtp"]);
     let _ = STARTED.with_label_values(&["submission"]);
@@ -133,6 +394,8 @@ pub fn init_metrics() {
     let _ = ABORTED.with_label_values(&["submission"]);
     let _ = FAILED_LOGINS.with_label_values(&["smtp"]);
     let _ = FAILED_LOGINS.with_label_values(&["submission"]);
+    let _ = SS_BYTES.with_label_values(&["in"]);
+    let _ = SS_BYTES.with_label_values(&["out"]);
 }
 
 /// Full Prometheus text exposition (for tests and debugging).
@@ -221,4 +484,51 @@ mod tests {
         let queue = sample_value(&body, "maddy_queue_length", r#"location="/tmp/q""#).unwrap();
         assert!((queue - 2.0).abs() < f64::EPSILON, "queue={queue}");
     }
+
+    #[test]
+    fn chatmail_http_registration_and_shadowsocks_metrics() {
+        record_registration("ok");
+        record_http_request("/metrics_unit_test/{id}", "GET", 200, 0.01);
+        observe_delivery_duration("success", 0.2);
+        let conn = ShadowsocksConnection::open();
+        record_shadowsocks_bytes("in", 512);
+        let body = exposition_text().expect("exposition");
+        let ok = sample_value(&body, "chatmail_registrations_total", r#"result="ok""#).unwrap();
+        assert!(ok >= 1.0, "registrations={ok}");
+        let labels = r#"path="/metrics_unit_test/{id}""#;
+        let hits = sample_value(&body, "chatmail_http_requests_total", labels).unwrap();
+        assert!((hits - 1.0).abs() < f64::EPSILON, "requests={hits}");
+        assert!(body.contains(r#"status="200""#));
+        assert!(body.contains("chatmail_http_request_duration_seconds_bucket"));
+        assert!(body.contains("chatmail_delivery_duration_seconds_bucket"));
+        let active = sample_value(&body, "chatmail_shadowsocks_connections_active", "").unwrap();
+        assert!(active >= 1.0, "active={active}");
+        let total = sample_value(&body, "chatmail_shadowsocks_connections_total", "").unwrap();
+        assert!(total >= 1.0, "total={total}");
+        let ss_active = sample_value(&body, "chatmail_ss_connections_active", "").unwrap();
+        assert!(ss_active >= 1.0, "ss active={ss_active}");
+        let ss_in = sample_value(&body, "chatmail_ss_bytes_total", r#"direction="in""#).unwrap();
+        assert!(ss_in >= 512.0, "ss in={ss_in}");
+        drop(conn);
+    }
+
+    #[test]
+    fn http_delivery_and_account_gauges() {
+        record_http_delivery(403);
+        set_storage_accounts(7);
+        let body = exposition_text().expect("exposition");
+        let rejected =
+            sample_value(&body, "chatmail_http_delivery_total", r#"status="403""#).unwrap();
+        assert!(rejected >= 1.0, "403s={rejected}");
+        let accounts = sample_value(&body, "chatmail_storage_accounts", "").unwrap();
+        assert!((accounts - 7.0).abs() < f64::EPSILON, "accounts={accounts}");
+    }
+
+    #[test]
+    fn stale_backlog_gauge_reports_last_scan() {
+        set_stale_backlog_accounts(3);
+        let body = exposition_text().expect("exposition");
+        let v = sample_value(&body, "maddy_imap_stale_backlog_accounts", "").unwrap();
+        assert!((v - 3.0).abs() < f64::EPSILON, "stale={v}");
+    }
 }
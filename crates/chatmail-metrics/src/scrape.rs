@@ -0,0 +1,97 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Gauges refreshed when `/metrics` is scraped rather than by a background poller.
+//!
+//! Hooks are keyed by name so a listener rebuilt on reload replaces its hook instead of
+//! stacking a second one. Each hook gets [`SCRAPE_HOOK_TIMEOUT`]; a slow one leaves its gauge
+//! at the previous value and the scrape still answers.
+
+use std::future::Future;
+use std::pin::Pin;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use once_cell::sync::Lazy;
+
+pub const SCRAPE_HOOK_TIMEOUT: Duration = Duration::from_secs(2);
+
+type HookFuture = Pin<Box<dyn Future<Output = ()> + Send>>;
+type ScrapeHook = Arc<dyn Fn() -> HookFuture + Send + Sync>;
+
+static HOOKS: Lazy<Mutex<Vec<(String, ScrapeHook)>>> = Lazy::new(|| Mutex::new(Vec::new()));
+
+/// Run `hook` before every scrape; replaces an earlier hook registered under `name`.
+pub fn on_scrape<F, Fut>(name: &str, hook: F)
+where
+    F: Fn() -> Fut + Send + Sync + 'static,
+    Fut: Future<Output = ()> + Send + 'static,
+{
+    let hook: ScrapeHook = Arc::new(move || Box::pin(hook()));
+    let mut hooks = HOOKS.lock().unwrap_or_else(|e| e.into_inner());
+    match hooks.iter_mut().find(|(n, _)| n == name) {
+        Some(slot) => slot.1 = hook,
+        None => hooks.push((name.to_string(), hook)),
+    }
+}
+
+pub(crate) async fn run_scrape_hooks() {
+    let hooks: Vec<ScrapeHook> = HOOKS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .iter()
+        .map(|(_, h)| Arc::clone(h))
+        .collect();
+    for hook in hooks {
+        if tokio::time::timeout(SCRAPE_HOOK_TIMEOUT, hook())
+            .await
+            .is_err()
+        {
+            tracing::debug!("metrics scrape hook timed out");
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::atomic::{AtomicUsize, Ordering};
+
+    use super::*;
+
+    #[tokio::test]
+    async fn hook_registered_again_replaces_the_first() {
+        static FIRST: AtomicUsize = AtomicUsize::new(0);
+        static SECOND: AtomicUsize = AtomicUsize::new(0);
+        on_scrape("scrape_unit_test", || async {
+            FIRST.fetch_add(1, Ordering::SeqCst);
+        });
+        on_scrape("scrape_unit_test", || async {
+            SECOND.fetch_add(1, Ordering::SeqCst);
+        });
+        run_scrape_hooks().await;
+        assert_eq!(FIRST.load(Ordering::SeqCst), 0);
+        assert!(SECOND.load(Ordering::SeqCst) >= 1);
+        let hooks = HOOKS.lock().unwrap();
+        assert_eq!(
+            hooks
+                .iter()
+                .filter(|(n, _)| n == "scrape_unit_test")
+                .count(),
+            1
+        );
+    }
+}
@@ -26,8 +26,12 @@ use tokio_util::sync::CancellationToken;
 use tracing::info;
 
 use crate::metrics::{gather_bytes, init_metrics};
+use crate::scrape::run_scrape_hooks;
 
-async fn metrics_handler() -> impl IntoResponse {
+/// `GET /metrics` body in Prometheus text format, after the [`on_scrape`](crate::on_scrape)
+/// hooks have refreshed their gauges.
+pub async fn metrics_handler() -> impl IntoResponse {
+    run_scrape_hooks().await;
     match gather_bytes() {
         Ok(body) => {
             let text = String::from_utf8(body)
@@ -22,10 +22,15 @@ use mail_parser::{Message, MessageParser, MimeHeaders};
 pub struct EnforceOptions {
     pub mail_from: String,
     pub recipients: Vec<String>,
+    /// Every recipient, or the authenticated sender, is on the `pgp_passthrough` policy list.
+    pub passthrough: bool,
 }
 
 /// PGP-only policy gate (Madmail `pgp_verify.EnforceEncryption`).
 pub fn enforce_encryption(raw: &[u8], opts: &EnforceOptions) -> Result<()> {
+    if opts.passthrough {
+        return Ok(());
+    }
     if raw
         .windows(b"application/pgp-encrypted".len())
         .any(|w| w == b"application/pgp-encrypted")
@@ -87,6 +92,14 @@ fn validate_secure_join_mime(msg: &Message<'_>, raw: &[u8]) -> bool {
     secure_join_body_prefix(msg) || secure_join_body_raw(raw)
 }
 
+/// Whether the top-level header section carries a Secure-Join step (`vc-*` / `vg-*`).
+///
+/// Header-only: used to prioritise handshake traffic, not to accept plaintext — that is
+/// [`enforce_encryption`]'s job.
+pub fn is_secure_join_message(raw: &[u8]) -> bool {
+    secure_join_step_raw(raw).is_some_and(|step| step.starts_with("vc-") || step.starts_with("vg-"))
+}
+
 fn secure_join_step_raw(raw: &[u8]) -> Option<String> {
     let text = std::str::from_utf8(raw).ok()?;
     let (headers, _) = split_headers_body(text)?;
@@ -232,6 +245,23 @@ mod tests {
         assert!(enforce_encryption(raw, &EnforceOptions::default()).is_err());
     }
 
+    /// Secure-Join detection looks at the top-level header only.
+    #[test]
+    fn test_is_secure_join_message_reads_header() {
+        let raw = build_vc_request_raw("bob@test", "alice@test", "invite-token-123");
+        assert!(is_secure_join_message(raw.as_bytes()));
+        assert!(is_secure_join_message(
+            b"From: a@b.test\r\nsecure-join: VG-Request\r\n\r\nx"
+        ));
+        assert!(!is_secure_join_message(
+            b"From: a@b.test\r\nSecure-Join: other\r\n\r\nx"
+        ));
+        assert!(!is_secure_join_message(
+            b"From: a@b.test\r\n\r\nSecure-Join: vc-request\r\n"
+        ));
+        assert!(!is_secure_join_message(PGP_MIME));
+    }
+
     /// multipart/mixed with wrong body line is rejected.
     #[test]
     fn test_secure_join_bad_body_rejected() {
@@ -246,6 +276,7 @@ mod tests {
         let opts = EnforceOptions {
             mail_from: "mailer-daemon@b.test".into(),
             recipients: vec![],
+            ..Default::default()
         };
         assert!(enforce_encryption(raw, &opts).is_ok());
     }
@@ -256,4 +287,15 @@ mod tests {
         let raw = b"From: a@b.test\r\nTo: c@d.test\r\nContent-Type: multipart/encrypted; boundary=x\r\n\r\n--x\r\nContent-Type: text/plain\r\n\r\nnope\r\n--x--\r\n";
         assert!(enforce_encryption(raw, &EnforceOptions::default()).is_err());
     }
+
+    /// Policy passthrough skips the PGP-only gate entirely.
+    #[test]
+    fn test_passthrough_skips_enforcement() {
+        let raw = b"From: a@b.test\r\nTo: c@d.test\r\nContent-Type: text/plain\r\n\r\nhello";
+        let opts = EnforceOptions {
+            passthrough: true,
+            ..Default::default()
+        };
+        assert!(enforce_encryption(raw, &opts).is_ok());
+    }
 }
@@ -17,13 +17,64 @@
 
 use shadowsocks::crypto::CipherKind;
 
+/// `ss_protocol`: which Shadowsocks generation the raw TCP relay speaks.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum SsProtocol {
+    /// AEAD ciphers of the original protocol (SIP004).
+    #[default]
+    Legacy,
+    /// Shadowsocks 2022 (SIP022): BLAKE3 key derivation, timestamped headers, replay filter.
+    Aead2022,
+}
+
+impl SsProtocol {
+    /// `legacy` (also empty) or `2022`.
+    pub fn parse(name: &str) -> Option<Self> {
+        match name.trim().to_ascii_lowercase().as_str() {
+            "" | "legacy" => Some(Self::Legacy),
+            "2022" => Some(Self::Aead2022),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Legacy => "legacy",
+            Self::Aead2022 => "2022",
+        }
+    }
+
+    /// Cipher names [`parse_cipher`] accepts, for error messages.
+    pub fn supported_ciphers(self) -> &'static str {
+        match self {
+            Self::Legacy => "aes-128-gcm, aes-256-gcm, chacha20-ietf-poly1305",
+            Self::Aead2022 => "2022-blake3-aes-128-gcm, 2022-blake3-aes-256-gcm",
+        }
+    }
+}
+
 /// Map `maddy.conf` / Madmail cipher name to shadowsocks-rs `CipherKind`.
-pub fn parse_cipher(name: &str) -> Option<CipherKind> {
-    match name.trim().to_ascii_lowercase().as_str() {
-        "aes-128-gcm" | "aes_128_gcm" => Some(CipherKind::AES_128_GCM),
-        "aes-256-gcm" | "aes_256_gcm" => Some(CipherKind::AES_256_GCM),
-        "chacha20-ietf-poly1305" | "chacha20_ietf_poly1305" => Some(CipherKind::CHACHA20_POLY1305),
-        _ => None,
+///
+/// Under [`SsProtocol::Aead2022`] the plain `aes-128-gcm` / `aes-256-gcm` names select their
+/// `2022-blake3-` counterparts, so installs that set `ss_cipher` only need `ss_protocol`.
+pub fn parse_cipher(name: &str, protocol: SsProtocol) -> Option<CipherKind> {
+    let name = name.trim().to_ascii_lowercase().replace('_', "-");
+    match protocol {
+        SsProtocol::Legacy => match name.as_str() {
+            "aes-128-gcm" => Some(CipherKind::AES_128_GCM),
+            "aes-256-gcm" => Some(CipherKind::AES_256_GCM),
+            "chacha20-ietf-poly1305" => Some(CipherKind::CHACHA20_POLY1305),
+            _ => None,
+        },
+        SsProtocol::Aead2022 => match name.as_str() {
+            "2022-blake3-aes-128-gcm" | "aes-128-gcm" => {
+                Some(CipherKind::AEAD2022_BLAKE3_AES_128_GCM)
+            }
+            "2022-blake3-aes-256-gcm" | "aes-256-gcm" => {
+                Some(CipherKind::AEAD2022_BLAKE3_AES_256_GCM)
+            }
+            _ => None,
+        },
     }
 }
 
@@ -44,13 +95,38 @@ mod tests {
 
     #[test]
     fn parse_cipher_accepts_hyphen_and_underscore_aliases() {
-        assert_eq!(parse_cipher("AES-128-GCM"), Some(CipherKind::AES_128_GCM));
-        assert_eq!(parse_cipher("aes_256_gcm"), Some(CipherKind::AES_256_GCM));
+        let legacy = SsProtocol::Legacy;
         assert_eq!(
-            parse_cipher(" chacha20-ietf-poly1305 "),
+            parse_cipher("AES-128-GCM", legacy),
+            Some(CipherKind::AES_128_GCM)
+        );
+        assert_eq!(
+            parse_cipher("aes_256_gcm", legacy),
+            Some(CipherKind::AES_256_GCM)
+        );
+        assert_eq!(
+            parse_cipher(" chacha20-ietf-poly1305 ", legacy),
             Some(CipherKind::CHACHA20_POLY1305)
         );
-        assert_eq!(parse_cipher("rc4-md5"), None);
+        assert_eq!(parse_cipher("rc4-md5", legacy), None);
+        assert_eq!(parse_cipher("2022-blake3-aes-128-gcm", legacy), None);
+    }
+
+    #[test]
+    fn protocol_2022_selects_blake3_ciphers() {
+        let v2022 = SsProtocol::Aead2022;
+        assert_eq!(
+            parse_cipher("2022-blake3-aes-256-gcm", v2022),
+            Some(CipherKind::AEAD2022_BLAKE3_AES_256_GCM)
+        );
+        assert_eq!(
+            parse_cipher("aes-128-gcm", v2022),
+            Some(CipherKind::AEAD2022_BLAKE3_AES_128_GCM)
+        );
+        assert_eq!(parse_cipher("chacha20-ietf-poly1305", v2022), None);
+        assert_eq!(SsProtocol::parse("2022"), Some(v2022));
+        assert_eq!(SsProtocol::parse(" Legacy "), Some(SsProtocol::Legacy));
+        assert_eq!(SsProtocol::parse("2019"), None);
     }
 
     #[test]
@@ -19,15 +19,22 @@
 
 mod allowed_ports;
 mod cipher;
+mod counted;
 mod runtime;
 mod server;
+mod slots;
 mod urls;
 mod xray;
 
 pub use allowed_ports::build_allowed_ports;
-pub use cipher::parse_cipher;
+pub use cipher::{parse_cipher, SsProtocol};
 pub use runtime::{
-    resolve_runtime, resolve_runtime_from_settings, ss_runtime_enabled, ShadowsocksRuntime,
+    resolve_runtime, resolve_runtime_from_settings, ss_protocol, ss_runtime_enabled,
+    ShadowsocksRuntime,
+};
+pub use server::{spawn_shadowsocks_server, ShadowsocksHandle, SS_DRAIN_TIMEOUT};
+pub use slots::{
+    check_password, generate_password, rotate_password, PasswordSlot, Rotation,
+    DEFAULT_ROTATION_GRACE,
 };
-pub use server::{spawn_shadowsocks_server, ShadowsocksHandle};
 pub use urls::ShadowsocksUrls;
@@ -20,18 +20,26 @@ use std::net::SocketAddr;
 use std::path::PathBuf;
 
 use chatmail_config::{AppConfig, DbMailPorts};
+use chatmail_db::policies::unix_now;
 use chatmail_db::{settings_keys, DbPool};
-use chatmail_types::Result;
+use chatmail_types::{ChatmailError, Result};
 
 use crate::allowed_ports::build_allowed_ports;
+use crate::cipher::SsProtocol;
+use crate::slots::{active_slots, PasswordSlot};
 use crate::urls::ShadowsocksUrls;
 
 /// Resolved Shadowsocks parameters (file config + admin DB overrides).
 #[derive(Debug, Clone)]
 pub struct ShadowsocksRuntime {
     pub listen_addr: String,
+    /// Primary password, the one in the client URL.
     pub password: String,
+    /// Further passwords the listener accepts (`ss_passwords`, rotation slots).
+    pub extra_passwords: Vec<PasswordSlot>,
     pub cipher: String,
+    /// `ss_protocol` from `maddy.conf`; selects how [`Self::cipher`] is read.
+    pub protocol: SsProtocol,
     pub mail_domain: String,
     pub public_ip: String,
     pub enabled: bool,
@@ -50,6 +58,16 @@ impl ShadowsocksRuntime {
     pub fn urls(&self, host_hint: &str) -> ShadowsocksUrls {
         ShadowsocksUrls::build(self, host_hint)
     }
+
+    /// The primary password, then every slot still valid at `now`.
+    pub fn active_passwords(&self, now: i64) -> impl Iterator<Item = &str> {
+        std::iter::once(self.password.as_str()).chain(
+            self.extra_passwords
+                .iter()
+                .filter(move |s| s.active(now))
+                .map(|s| s.password.as_str()),
+        )
+    }
 }
 
 /// Load runtime SS settings from `maddy.conf` and the settings DB.
@@ -74,6 +92,7 @@ pub async fn resolve_runtime_with_db(
         pool,
         &[
             settings_keys::SS_PASSWORD,
+            settings_keys::SS_PASSWORD_SLOTS,
             settings_keys::SS_CIPHER,
             settings_keys::SS_PORT,
             settings_keys::SS_ENABLED,
@@ -95,13 +114,22 @@ pub fn resolve_runtime_from_settings(
     let password = string_from_settings(
         settings,
         settings_keys::SS_PASSWORD,
-        file.ss_password.as_deref().unwrap_or(""),
+        file.ss_primary_password(),
+    );
+    let extra_passwords = active_slots(
+        file,
+        settings
+            .get(settings_keys::SS_PASSWORD_SLOTS)
+            .map(String::as_str),
+        &password,
+        unix_now(),
     );
     let cipher = string_from_settings(
         settings,
         settings_keys::SS_CIPHER,
         file.ss_cipher.as_deref().unwrap_or("aes-128-gcm"),
     );
+    let protocol = ss_protocol(file)?;
     let listen_addr = listen_from_settings(&ss_addr, settings);
     let enabled =
         file.ss_configured() && bool_from_settings(settings, settings_keys::SS_ENABLED, true);
@@ -114,7 +142,9 @@ pub fn resolve_runtime_from_settings(
     Ok(ShadowsocksRuntime {
         listen_addr,
         password,
+        extra_passwords,
         cipher,
+        protocol,
         mail_domain: mail_domain.to_string(),
         public_ip: file.public_ip.clone().unwrap_or_default(),
         enabled,
@@ -126,6 +156,16 @@ pub fn resolve_runtime_from_settings(
     })
 }
 
+/// `ss_protocol` from `maddy.conf`; unset is [`SsProtocol::Legacy`].
+pub fn ss_protocol(file: &AppConfig) -> Result<SsProtocol> {
+    let name = file.ss_protocol.as_deref().unwrap_or("");
+    SsProtocol::parse(name).ok_or_else(|| {
+        ChatmailError::config(format!(
+            "unsupported ss_protocol {name:?} (expected legacy or 2022)"
+        ))
+    })
+}
+
 fn string_from_settings(map: &HashMap<String, String>, key: &str, default: &str) -> String {
     map.get(key)
         .filter(|s| !s.is_empty())
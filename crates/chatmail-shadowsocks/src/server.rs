@@ -17,34 +17,67 @@
 
 use std::collections::HashSet;
 use std::net::SocketAddr;
+use std::pin::Pin;
 use std::sync::atomic::{AtomicBool, Ordering};
 use std::sync::Arc;
+use std::task::{ready, Context as TaskContext, Poll};
+use std::time::Duration;
 
+use chatmail_db::policies::unix_now;
+use chatmail_state::{ShadowsocksSession, ShadowsocksStats};
 use shadowsocks::config::{ServerAddr, ServerConfig, ServerType};
-use shadowsocks::context::Context;
+use shadowsocks::context::{Context, SharedContext};
+use shadowsocks::crypto::CipherKind;
 use shadowsocks::relay::socks5::Address;
-use shadowsocks::relay::tcprelay::ProxyListener;
-use tokio::io::copy_bidirectional;
-use tokio::net::TcpStream;
+use shadowsocks::relay::tcprelay::proxy_stream::server::ProxyServerStream;
+use tokio::io::{copy_bidirectional, AsyncRead, AsyncWrite, ReadBuf};
+use tokio::net::{TcpListener, TcpStream};
 use tokio_util::sync::CancellationToken;
+use tokio_util::task::TaskTracker;
 use tracing::{debug, info, warn};
 
-use crate::cipher::parse_cipher;
+use crate::cipher::{parse_cipher, SsProtocol};
+use crate::counted::Counted;
 use crate::runtime::ShadowsocksRuntime;
 
+/// How long a reload lets relayed connections finish before cutting them.
+pub const SS_DRAIN_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Handshake bytes kept so a connection can be retried with the next password slot. A
+/// wrong key fails on the first encrypted chunk, well inside this.
+const REPLAY_LIMIT: usize = 1024;
+
 /// Running Shadowsocks listeners (TCP + optional Xray children).
 pub struct ShadowsocksHandle {
+    /// Stops the accept loop, which drops the listener.
     cancel: CancellationToken,
+    /// Cuts relayed connections still open after the drain.
+    relays_cancel: CancellationToken,
+    relays: TaskTracker,
     tcp_join: tokio::task::JoinHandle<()>,
     xray: crate::xray::XrayChildren,
     enabled: Arc<AtomicBool>,
 }
 
 impl ShadowsocksHandle {
-    pub fn shutdown(mut self) {
+    /// Stop accepting, then give relayed connections up to `drain` before cutting them. The
+    /// listen ports (raw TCP and Xray) are free once this returns, so a restart can rebind.
+    pub async fn shutdown(mut self, drain: Duration) {
         self.cancel.cancel();
-        self.xray.kill();
-        self.tcp_join.abort();
+        let _ = (&mut self.tcp_join).await;
+        self.xray.stop().await;
+        self.relays.close();
+        if tokio::time::timeout(drain, self.relays.wait())
+            .await
+            .is_err()
+        {
+            warn!(
+                open = self.relays.len(),
+                "Shadowsocks: cutting relayed connections after drain timeout"
+            );
+            self.relays_cancel.cancel();
+            self.relays.wait().await;
+        }
     }
 
     /// Reflect admin `__SS_ENABLED__` (connections are dropped when false).
@@ -53,14 +86,28 @@ impl ShadowsocksHandle {
     }
 }
 
-/// Start raw TCP Shadowsocks and optional Xray WS/gRPC transports.
+/// Fallback when dropped without [`ShadowsocksHandle::shutdown`]: nothing is awaited.
+impl Drop for ShadowsocksHandle {
+    fn drop(&mut self) {
+        self.cancel.cancel();
+        self.relays_cancel.cancel();
+        self.xray.kill();
+        self.tcp_join.abort();
+    }
+}
+
+/// Start raw TCP Shadowsocks and optional Xray WS/gRPC transports. Raw TCP relays count
+/// their traffic in `stats`; Xray transports are not counted.
 pub async fn spawn_shadowsocks_server(
     rt: ShadowsocksRuntime,
+    stats: Arc<ShadowsocksStats>,
 ) -> chatmail_types::Result<ShadowsocksHandle> {
-    let method = parse_cipher(&rt.cipher).ok_or_else(|| {
+    let method = parse_cipher(&rt.cipher, rt.protocol).ok_or_else(|| {
         chatmail_types::ChatmailError::config(format!(
-            "unsupported shadowsocks cipher: {}",
-            rt.cipher
+            "unsupported shadowsocks cipher for ss_protocol {}: {} (supported: {})",
+            rt.protocol.as_str(),
+            rt.cipher,
+            rt.protocol.supported_ciphers()
         ))
     })?;
 
@@ -68,40 +115,58 @@ pub async fn spawn_shadowsocks_server(
         chatmail_types::ChatmailError::config(format!("invalid ss_addr {}: {e}", rt.listen_addr))
     })?;
 
-    let context = Context::new_shared(ServerType::Local);
-    let svr_cfg = ServerConfig::new(ServerAddr::SocketAddr(listen), &rt.password, method)
-        .map_err(|e| chatmail_types::ChatmailError::config(format!("shadowsocks config: {e}")))?;
+    let mut slots = vec![KeySlot::new(
+        listen,
+        &rt.password,
+        method,
+        None,
+        "shadowsocks config",
+    )?];
+    for (n, slot) in rt.extra_passwords.iter().enumerate() {
+        let what = format!("shadowsocks password slot {}", n + 1);
+        slots.push(KeySlot::new(
+            listen,
+            &slot.password,
+            method,
+            slot.expires_at,
+            &what,
+        )?);
+    }
+    let slots = Arc::new(slots);
 
-    let listener = ProxyListener::bind(context.clone(), &svr_cfg)
-        .await
-        .map_err(|e| {
-            chatmail_types::ChatmailError::config(format!(
-                "shadowsocks listen on {}: {e}",
-                rt.listen_addr
-            ))
-        })?;
+    let listener = TcpListener::bind(listen).await.map_err(|e| {
+        chatmail_types::ChatmailError::config(format!(
+            "shadowsocks listen on {}: {e}",
+            rt.listen_addr
+        ))
+    })?;
 
     info!(
         listen = %rt.listen_addr,
-        cipher = %rt.cipher,
+        cipher = %method,
+        protocol = rt.protocol.as_str(),
+        passwords = slots.len(),
         "Shadowsocks: raw TCP listener started"
     );
 
     let allowed: Arc<HashSet<String>> = Arc::new(rt.allowed_ports.clone());
     let cancel = CancellationToken::new();
     let child_cancel = cancel.child_token();
+    let relays_cancel = CancellationToken::new();
+    let relays = TaskTracker::new();
     let xray = crate::xray::spawn_xray_transports(&rt, rt.ws_enabled, rt.grpc_enabled)?;
     let enabled = Arc::new(AtomicBool::new(rt.enabled));
 
     let tcp_join = {
         let allowed = Arc::clone(&allowed);
         let enabled = Arc::clone(&enabled);
+        let (relays, relays_cancel) = (relays.clone(), relays_cancel.clone());
         tokio::spawn(async move {
             loop {
                 tokio::select! {
                     _ = child_cancel.cancelled() => break,
                     accept = listener.accept() => {
-                        let Ok((mut stream, peer)) = accept else {
+                        let Ok((stream, peer)) = accept else {
                             if child_cancel.is_cancelled() {
                                 break;
                             }
@@ -111,8 +176,15 @@ pub async fn spawn_shadowsocks_server(
                             continue;
                         }
                         let allowed = Arc::clone(&allowed);
-                        tokio::spawn(async move {
-                            if let Err(e) = relay_connection(&mut stream, &allowed, peer).await {
+                        let slots = Arc::clone(&slots);
+                        let stop = relays_cancel.clone();
+                        let session = stats.open();
+                        relays.spawn(async move {
+                            let relayed = tokio::select! {
+                                _ = stop.cancelled() => return,
+                                r = relay_connection(stream, &slots, &allowed, peer, &session) => r,
+                            };
+                            if let Err(e) = relayed {
                                 if !matches!(e.kind(), std::io::ErrorKind::ConnectionReset | std::io::ErrorKind::BrokenPipe) {
                                     warn!(%peer, error = %e, "shadowsocks relay");
                                 }
@@ -126,21 +198,83 @@ pub async fn spawn_shadowsocks_server(
 
     Ok(ShadowsocksHandle {
         cancel,
+        relays_cancel,
+        relays,
         tcp_join,
         xray,
         enabled,
     })
 }
 
-async fn relay_connection<S>(
-    stream: &mut shadowsocks::relay::tcprelay::proxy_stream::server::ProxyServerStream<S>,
+/// One accepted password: its derived key, and a replay filter of its own so a salt seen
+/// while trying another slot does not count as a replay.
+struct KeySlot {
+    config: ServerConfig,
+    context: SharedContext,
+    expires_at: Option<i64>,
+}
+
+impl KeySlot {
+    fn new(
+        listen: SocketAddr,
+        password: &str,
+        method: CipherKind,
+        expires_at: Option<i64>,
+        what: &str,
+    ) -> chatmail_types::Result<Self> {
+        let config = ServerConfig::new(ServerAddr::SocketAddr(listen), password, method)
+            .map_err(|e| chatmail_types::ChatmailError::config(format!("{what}: {e}")))?;
+        Ok(Self {
+            config,
+            context: Context::new_shared(ServerType::Local),
+            expires_at,
+        })
+    }
+}
+
+/// Try the slots valid now in order (primary first) and relay with the first whose key
+/// decrypts the handshake.
+async fn relay_connection(
+    stream: TcpStream,
+    slots: &[KeySlot],
     allowed: &HashSet<String>,
     peer: SocketAddr,
+    session: &ShadowsocksSession,
+) -> std::io::Result<()> {
+    let now = unix_now();
+    let active: Vec<&KeySlot> = slots
+        .iter()
+        .filter(|s| s.expires_at.is_none_or(|t| now < t))
+        .collect();
+    let limit = if active.len() > 1 { REPLAY_LIMIT } else { 0 };
+    let mut replay = Replay::new(stream, limit);
+    let mut last_err = None;
+    for slot in active {
+        replay.rewind()?;
+        let mut stream = ProxyServerStream::from_stream(
+            slot.context.clone(),
+            &mut replay,
+            slot.config.method(),
+            slot.config.key(),
+        );
+        match stream.handshake().await {
+            Ok(target) => return relay_to(&mut stream, target, allowed, peer, session).await,
+            Err(e) => last_err = Some(e),
+        }
+    }
+    Err(last_err.unwrap_or_else(|| std::io::ErrorKind::PermissionDenied.into()))
+}
+
+async fn relay_to<S>(
+    stream: &mut ProxyServerStream<S>,
+    target: Address,
+    allowed: &HashSet<String>,
+    peer: SocketAddr,
+    session: &ShadowsocksSession,
 ) -> std::io::Result<()>
 where
-    S: tokio::io::AsyncRead + tokio::io::AsyncWrite + Unpin,
+    S: AsyncRead + AsyncWrite + Unpin,
 {
-    let target = stream.handshake().await?;
     let port = target_port(&target);
     if !allowed.contains(&port) {
         warn!(%peer, %port, "shadowsocks: blocking port not used by this server");
@@ -150,7 +284,7 @@ where
     let local = format!("127.0.0.1:{port}");
     debug!(%peer, ?target, %local, "shadowsocks: relaying to local service");
     let mut remote = TcpStream::connect(&local).await?;
-    copy_bidirectional(stream, &mut remote).await?;
+    copy_bidirectional(&mut Counted::new(stream, session), &mut remote).await?;
     Ok(())
 }
 
@@ -160,3 +294,346 @@ fn target_port(addr: &Address) -> String {
         Address::DomainNameAddress(_, port) => port.to_string(),
     }
 }
+
+/// Records the first `limit` bytes read so [`Replay::rewind`] can serve them again to the
+/// next handshake attempt. Past the limit it reads straight through and cannot rewind.
+struct Replay<S> {
+    inner: S,
+    seen: Vec<u8>,
+    pos: usize,
+    limit: usize,
+    overflowed: bool,
+}
+
+impl<S> Replay<S> {
+    fn new(inner: S, limit: usize) -> Self {
+        Self {
+            inner,
+            seen: Vec::new(),
+            pos: 0,
+            limit,
+            overflowed: false,
+        }
+    }
+
+    fn rewind(&mut self) -> std::io::Result<()> {
+        if self.overflowed {
+            return Err(std::io::Error::new(
+                std::io::ErrorKind::InvalidData,
+                "shadowsocks handshake too long to retry with another password",
+            ));
+        }
+        self.pos = 0;
+        Ok(())
+    }
+}
+
+impl<S: AsyncRead + Unpin> AsyncRead for Replay<S> {
+    fn poll_read(
+        self: Pin<&mut Self>,
+        cx: &mut TaskContext<'_>,
+        buf: &mut ReadBuf<'_>,
+    ) -> Poll<std::io::Result<()>> {
+        let this = self.get_mut();
+        if this.pos < this.seen.len() {
+            let n = (this.seen.len() - this.pos).min(buf.remaining());
+            buf.put_slice(&this.seen[this.pos..this.pos + n]);
+            this.pos += n;
+            return Poll::Ready(Ok(()));
+        }
+        let before = buf.filled().len();
+        ready!(Pin::new(&mut this.inner).poll_read(cx, buf))?;
+        if !this.overflowed {
+            let fresh = &buf.filled()[before..];
+            if this.seen.len() + fresh.len() <= this.limit {
+                this.seen.extend_from_slice(fresh);
+                this.pos = this.seen.len();
+            } else {
+                this.overflowed = true;
+                this.seen = Vec::new();
+                this.pos = 0;
+            }
+        }
+        Poll::Ready(Ok(()))
+    }
+}
+
+impl<S: AsyncWrite + Unpin> AsyncWrite for Replay<S> {
+    fn poll_write(
+        self: Pin<&mut Self>,
+        cx: &mut TaskContext<'_>,
+        buf: &[u8],
+    ) -> Poll<std::io::Result<usize>> {
+        Pin::new(&mut self.get_mut().inner).poll_write(cx, buf)
+    }
+
+    fn poll_flush(self: Pin<&mut Self>, cx: &mut TaskContext<'_>) -> Poll<std::io::Result<()>> {
+        Pin::new(&mut self.get_mut().inner).poll_flush(cx)
+    }
+
+    fn poll_shutdown(self: Pin<&mut Self>, cx: &mut TaskContext<'_>) -> Poll<std::io::Result<()>> {
+        Pin::new(&mut self.get_mut().inner).poll_shutdown(cx)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn runtime(listen_addr: String) -> ShadowsocksRuntime {
+        ShadowsocksRuntime {
+            listen_addr,
+            password: "test-password".into(),
+            extra_passwords: Vec::new(),
+            cipher: "aes-128-gcm".into(),
+            protocol: SsProtocol::Legacy,
+            mail_domain: "example.org".into(),
+            public_ip: "127.0.0.1".into(),
+            enabled: true,
+            ws_enabled: false,
+            grpc_enabled: false,
+            allowed_ports: HashSet::from(["993".to_string()]),
+            tls_cert_path: Default::default(),
+            tls_key_path: Default::default(),
+        }
+    }
+
+    #[tokio::test]
+    async fn shutdown_cuts_idle_relay_and_frees_the_port() {
+        let addr = std::net::TcpListener::bind("127.0.0.1:0")
+            .unwrap()
+            .local_addr()
+            .unwrap()
+            .to_string();
+        let stats = Arc::new(ShadowsocksStats::new());
+        let handle = spawn_shadowsocks_server(runtime(addr.clone()), Arc::clone(&stats))
+            .await
+            .unwrap();
+
+        // A client that never finishes the handshake keeps its relay task open.
+        let _client = TcpStream::connect(&addr).await.unwrap();
+        tokio::time::sleep(Duration::from_millis(50)).await;
+        assert_eq!(handle.relays.len(), 1);
+
+        tokio::time::timeout(
+            Duration::from_secs(5),
+            handle.shutdown(Duration::from_millis(100)),
+        )
+        .await
+        .expect("shutdown returns after the drain timeout");
+
+        let again = spawn_shadowsocks_server(runtime(addr), stats)
+            .await
+            .expect("restart on the same port");
+        again.shutdown(Duration::ZERO).await;
+    }
+
+    /// Local echo service standing in for IMAP.
+    async fn spawn_echo() -> SocketAddr {
+        let echo = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let echo_addr = echo.local_addr().unwrap();
+        tokio::spawn(async move {
+            while let Ok((mut conn, _)) = echo.accept().await {
+                tokio::spawn(async move {
+                    let (mut r, mut w) = conn.split();
+                    let _ = tokio::io::copy(&mut r, &mut w).await;
+                });
+            }
+        });
+        echo_addr
+    }
+
+    /// Send `payload` to `target` through the relay at `addr` and read the echo back.
+    async fn echo_through(
+        addr: SocketAddr,
+        cipher: &str,
+        protocol: SsProtocol,
+        password: &str,
+        target: SocketAddr,
+        payload: &[u8],
+    ) -> std::io::Result<Vec<u8>> {
+        use shadowsocks::relay::tcprelay::proxy_stream::client::ProxyClientStream;
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let context = Context::new_shared(ServerType::Local);
+        let method = parse_cipher(cipher, protocol).unwrap();
+        let svr_cfg = ServerConfig::new(ServerAddr::SocketAddr(addr), password, method).unwrap();
+        let mut client =
+            ProxyClientStream::connect(context, &svr_cfg, Address::from(target)).await?;
+        client.write_all(payload).await?;
+        let mut back = vec![0u8; payload.len()];
+        tokio::time::timeout(Duration::from_secs(5), client.read_exact(&mut back))
+            .await
+            .map_err(|_| std::io::Error::from(std::io::ErrorKind::TimedOut))??;
+        Ok(back)
+    }
+
+    #[tokio::test]
+    async fn concurrent_relays_count_bytes_and_connections() {
+        use shadowsocks::relay::tcprelay::proxy_stream::client::ProxyClientStream;
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let echo_addr = spawn_echo().await;
+        let addr = std::net::TcpListener::bind("127.0.0.1:0")
+            .unwrap()
+            .local_addr()
+            .unwrap();
+        let mut rt = runtime(addr.to_string());
+        rt.allowed_ports = HashSet::from([echo_addr.port().to_string()]);
+        let stats = Arc::new(ShadowsocksStats::new());
+        let handle = spawn_shadowsocks_server(rt, Arc::clone(&stats))
+            .await
+            .unwrap();
+
+        let context = Context::new_shared(ServerType::Local);
+        let method = parse_cipher("aes-128-gcm", SsProtocol::Legacy).unwrap();
+        let svr_cfg =
+            ServerConfig::new(ServerAddr::SocketAddr(addr), "test-password", method).unwrap();
+        let mut clients = Vec::new();
+        for payload in [&b"hello"[..], &b"madmail!"[..]] {
+            let mut client =
+                ProxyClientStream::connect(context.clone(), &svr_cfg, Address::from(echo_addr))
+                    .await
+                    .unwrap();
+            client.write_all(payload).await.unwrap();
+            let mut back = vec![0u8; payload.len()];
+            client.read_exact(&mut back).await.unwrap();
+            assert_eq!(back, payload);
+            clients.push(client);
+        }
+
+        let open = stats.snapshot();
+        assert_eq!(open.active_connections, 2);
+        assert_eq!(open.total_connections, 2);
+        assert_eq!(open.bytes_in, 13);
+        assert_eq!(open.bytes_out, 13);
+
+        drop(clients);
+        for _ in 0..100 {
+            if stats.snapshot().active_connections == 0 {
+                break;
+            }
+            tokio::time::sleep(Duration::from_millis(20)).await;
+        }
+        let closed = stats.snapshot();
+        assert_eq!(closed.active_connections, 0);
+        assert_eq!(closed.total_connections, 2);
+        handle.shutdown(Duration::ZERO).await;
+    }
+
+    #[tokio::test]
+    async fn protocol_2022_relays_2022_clients_only() {
+        const KEY: &str = "AAECAwQFBgcICQoLDA0ODw==";
+        let echo_addr = spawn_echo().await;
+        let blocked = std::net::TcpListener::bind("127.0.0.1:0").unwrap();
+        let blocked_addr = blocked.local_addr().unwrap();
+
+        let addr = std::net::TcpListener::bind("127.0.0.1:0")
+            .unwrap()
+            .local_addr()
+            .unwrap();
+        let mut rt = runtime(addr.to_string());
+        rt.protocol = SsProtocol::Aead2022;
+        rt.cipher = "2022-blake3-aes-128-gcm".into();
+        rt.password = KEY.into();
+        rt.allowed_ports = HashSet::from([echo_addr.port().to_string()]);
+        let stats = Arc::new(ShadowsocksStats::new());
+        let handle = spawn_shadowsocks_server(rt, Arc::clone(&stats))
+            .await
+            .unwrap();
+
+        let v2022 = SsProtocol::Aead2022;
+        let back = echo_through(
+            addr,
+            "2022-blake3-aes-128-gcm",
+            v2022,
+            KEY,
+            echo_addr,
+            b"hi",
+        )
+        .await
+        .unwrap();
+        assert_eq!(back, b"hi");
+
+        // `ss_allowed_ports` still applies: the relay hangs up on other ports.
+        assert!(echo_through(
+            addr,
+            "2022-blake3-aes-128-gcm",
+            v2022,
+            KEY,
+            blocked_addr,
+            b"x"
+        )
+        .await
+        .is_err());
+        // A legacy AEAD client cannot authenticate against a 2022 listener.
+        let legacy = SsProtocol::Legacy;
+        assert!(
+            echo_through(addr, "aes-128-gcm", legacy, KEY, echo_addr, b"hello")
+                .await
+                .is_err()
+        );
+        assert_eq!(stats.snapshot().bytes_in, 2);
+        handle.shutdown(Duration::ZERO).await;
+    }
+
+    #[tokio::test]
+    async fn second_password_slot_relays_until_it_expires() {
+        use crate::slots::PasswordSlot;
+
+        let echo_addr = spawn_echo().await;
+        let addr = std::net::TcpListener::bind("127.0.0.1:0")
+            .unwrap()
+            .local_addr()
+            .unwrap();
+        let mut rt = runtime(addr.to_string());
+        rt.allowed_ports = HashSet::from([echo_addr.port().to_string()]);
+        rt.extra_passwords = vec![
+            PasswordSlot {
+                password: "retired".into(),
+                expires_at: Some(unix_now() - 1),
+            },
+            PasswordSlot {
+                password: "second".into(),
+                expires_at: Some(unix_now() + 600),
+            },
+        ];
+        let stats = Arc::new(ShadowsocksStats::new());
+        let handle = spawn_shadowsocks_server(rt, Arc::clone(&stats))
+            .await
+            .unwrap();
+
+        let legacy = SsProtocol::Legacy;
+        for password in ["second", "test-password"] {
+            let back = echo_through(addr, "aes-128-gcm", legacy, password, echo_addr, b"slot")
+                .await
+                .unwrap();
+            assert_eq!(back, b"slot", "{password}");
+        }
+        for password in ["retired", "wrong"] {
+            assert!(
+                echo_through(addr, "aes-128-gcm", legacy, password, echo_addr, b"x")
+                    .await
+                    .is_err(),
+                "{password}"
+            );
+        }
+        assert_eq!(stats.snapshot().bytes_in, 8);
+        handle.shutdown(Duration::ZERO).await;
+    }
+
+    #[tokio::test]
+    async fn protocol_2022_rejects_legacy_ciphers_and_short_keys() {
+        let mut rt = runtime("127.0.0.1:0".into());
+        rt.protocol = SsProtocol::Aead2022;
+        rt.cipher = "chacha20-ietf-poly1305".into();
+        let stats = Arc::new(ShadowsocksStats::new());
+        assert!(spawn_shadowsocks_server(rt.clone(), Arc::clone(&stats))
+            .await
+            .is_err());
+        // 2022 keys are base64 of exactly the cipher's key length.
+        rt.cipher = "2022-blake3-aes-256-gcm".into();
+        rt.password = "AAECAwQFBgcICQoLDA0ODw==".into();
+        assert!(spawn_shadowsocks_server(rt, stats).await.is_err());
+    }
+}
@@ -0,0 +1,191 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Extra Shadowsocks password slots, so the password can be rotated without cutting
+//! clients off.
+//!
+//! The listener accepts the primary password (`ss_password` / `__SS_PASSWORD__`, the one in
+//! the client URL) and every active slot: `ss_passwords` from `maddy.conf`, which never
+//! expire, and the slots [`rotate_password`] keeps in `__SS_PASSWORD_SLOTS__`. A rotated-out
+//! password stays valid for a grace period so clients can fetch the new URL and reconnect.
+//! Expired slots are refused on accept and dropped at the next rotation.
+
+use std::net::SocketAddr;
+use std::time::Duration;
+
+use base64::engine::general_purpose::{STANDARD, URL_SAFE_NO_PAD};
+use base64::Engine as _;
+use chatmail_config::AppConfig;
+use chatmail_db::policies::unix_now;
+use chatmail_db::{get_setting, set_setting, settings_keys, DbPool};
+use chatmail_types::{ChatmailError, Result};
+use serde::{Deserialize, Serialize};
+use shadowsocks::config::{ServerAddr, ServerConfig};
+
+use crate::cipher::{parse_cipher, SsProtocol};
+use crate::runtime::ShadowsocksRuntime;
+
+/// How long `POST /admin/ss/rotate` keeps the previous password by default.
+pub const DEFAULT_ROTATION_GRACE: Duration = Duration::from_secs(10 * 60);
+
+/// A password the listener accepts besides the primary one.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct PasswordSlot {
+    pub password: String,
+    /// Unix seconds from which the slot is refused; `None` never expires.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub expires_at: Option<i64>,
+}
+
+impl PasswordSlot {
+    pub fn active(&self, now: i64) -> bool {
+        self.expires_at.is_none_or(|t| now < t)
+    }
+}
+
+/// Slots active at `now`: the stored rotation slots (newest first), then `ss_passwords`.
+/// `primary` and repeats are left out.
+pub(crate) fn active_slots(
+    file: &AppConfig,
+    stored: Option<&str>,
+    primary: &str,
+    now: i64,
+) -> Vec<PasswordSlot> {
+    let file_slots = file.ss_passwords.iter().map(|p| PasswordSlot {
+        password: p.clone(),
+        expires_at: None,
+    });
+    let mut slots: Vec<PasswordSlot> = Vec::new();
+    for slot in parse_stored(stored).into_iter().chain(file_slots) {
+        if slot.active(now)
+            && !slot.password.is_empty()
+            && slot.password != primary
+            && !slots.iter().any(|s| s.password == slot.password)
+        {
+            slots.push(slot);
+        }
+    }
+    slots
+}
+
+/// `__SS_PASSWORD_SLOTS__`: a JSON array of [`PasswordSlot`]; anything else counts as empty.
+fn parse_stored(raw: Option<&str>) -> Vec<PasswordSlot> {
+    raw.filter(|s| !s.trim().is_empty())
+        .and_then(|s| serde_json::from_str(s).ok())
+        .unwrap_or_default()
+}
+
+/// Refuse a password the listener could not use with `rt`'s cipher (2022 keys must be
+/// base64 of exactly the cipher's key length).
+pub fn check_password(rt: &ShadowsocksRuntime, password: &str) -> Result<()> {
+    if password.is_empty() {
+        return Err(ChatmailError::config("password must not be empty"));
+    }
+    let method = parse_cipher(&rt.cipher, rt.protocol).ok_or_else(|| {
+        ChatmailError::config(format!(
+            "unsupported shadowsocks cipher for ss_protocol {}: {}",
+            rt.protocol.as_str(),
+            rt.cipher
+        ))
+    })?;
+    let addr = ServerAddr::SocketAddr(SocketAddr::from(([0, 0, 0, 0], 0)));
+    ServerConfig::new(addr, password, method)
+        .map(|_| ())
+        .map_err(|e| ChatmailError::config(format!("invalid shadowsocks password: {e}")))
+}
+
+/// A new password for `rt`'s cipher from `random`: under `ss_protocol 2022` a base64 key
+/// of the cipher's key length, otherwise 16 bytes of URL-safe base64 (as `install` makes).
+pub fn generate_password(rt: &ShadowsocksRuntime, random: &[u8; 32]) -> String {
+    match (rt.protocol, parse_cipher(&rt.cipher, rt.protocol)) {
+        (SsProtocol::Aead2022, Some(kind)) => STANDARD.encode(&random[..kind.key_len()]),
+        _ => URL_SAFE_NO_PAD.encode(&random[..16]),
+    }
+}
+
+/// Result of [`rotate_password`].
+#[derive(Debug, Clone)]
+pub struct Rotation {
+    /// Unix time the previous password stops working; `None` keeps it.
+    pub retired_until: Option<i64>,
+    /// Stored rotation slots after the change (newest first).
+    pub slots: Vec<PasswordSlot>,
+}
+
+/// Make `password` the primary password. The previous primary moves to the front of the
+/// stored slots and expires after `grace` (`None` keeps it until `proxy password reset`
+/// clears the slots). Expired slots are pruned. The listener picks the change up on reload.
+pub async fn rotate_password(
+    pool: &DbPool,
+    rt: &ShadowsocksRuntime,
+    password: &str,
+    grace: Option<Duration>,
+) -> Result<Rotation> {
+    check_password(rt, password)?;
+    if password == rt.password {
+        return Err(ChatmailError::config(
+            "new password is the current primary password",
+        ));
+    }
+    let now = unix_now();
+    let retired_until = grace.map(|g| now.saturating_add(g.as_secs() as i64));
+    let stored = get_setting(pool, settings_keys::SS_PASSWORD_SLOTS).await?;
+    let mut slots = vec![PasswordSlot {
+        password: rt.password.clone(),
+        expires_at: retired_until,
+    }];
+    for slot in parse_stored(stored.as_deref()) {
+        if slot.active(now)
+            && slot.password != password
+            && !slots.iter().any(|s| s.password == slot.password)
+        {
+            slots.push(slot);
+        }
+    }
+    let json = serde_json::to_string(&slots)
+        .map_err(|e| ChatmailError::config(format!("encode password slots: {e}")))?;
+    set_setting(pool, settings_keys::SS_PASSWORD_SLOTS, &json).await?;
+    set_setting(pool, settings_keys::SS_PASSWORD, password).await?;
+    Ok(Rotation {
+        retired_until,
+        slots,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn active_slots_skip_expired_primary_and_repeats() {
+        let file = AppConfig {
+            ss_passwords: vec!["file".into(), "main".into()],
+            ..AppConfig::default()
+        };
+        let stored = r#"[{"password":"old","expires_at":200},{"password":"older","expires_at":100},
+            {"password":"file"}]"#;
+        let names = |now| {
+            active_slots(&file, Some(stored), "main", now)
+                .into_iter()
+                .map(|s| s.password)
+                .collect::<Vec<_>>()
+        };
+        assert_eq!(names(50), ["old", "older", "file"]);
+        assert_eq!(names(150), ["old", "file"]);
+        assert_eq!(names(200), ["file"]);
+        assert_eq!(active_slots(&file, Some("not json"), "main", 0).len(), 1);
+    }
+}
@@ -16,14 +16,26 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 use base64::{engine::general_purpose::STANDARD, Engine as _};
-use percent_encoding::{utf8_percent_encode, NON_ALPHANUMERIC};
+use chatmail_db::policies::unix_now;
+use percent_encoding::{utf8_percent_encode, AsciiSet, NON_ALPHANUMERIC};
 
+use crate::cipher::{parse_cipher, SsProtocol};
 use crate::runtime::ShadowsocksRuntime;
 
+/// RFC 3986 unreserved characters stay literal in SIP022 user info.
+const USERINFO: &AsciiSet = &NON_ALPHANUMERIC
+    .remove(b'-')
+    .remove(b'.')
+    .remove(b'_')
+    .remove(b'~');
+
 /// Client URLs and v2rayNG JSON (Madmail `getShadowsocks*` / `getV2rayNGConfig*`).
 #[derive(Debug, Clone, Default)]
 pub struct ShadowsocksUrls {
+    /// Raw TCP URL for the primary password.
     pub shadowsocks_url: String,
+    /// Raw TCP URLs for every accepted password, primary first.
+    pub shadowsocks_urls: Vec<String>,
     pub ws_url: String,
     pub grpc_url: String,
     pub v2ray_ng_ws: String,
@@ -37,12 +49,19 @@ impl ShadowsocksUrls {
         }
         let host = resolve_host(rt, host_hint);
         let (base_port, ws_port, grpc_port) = effective_ports(rt);
-        let auth = ss_auth_segment(&rt.cipher, &rt.password);
+        let auth = user_info(rt, &rt.password);
 
-        let shadowsocks_url = format!(
-            "ss://{auth}@{host}:{base_port}#{}",
-            url_encode_fragment(&host)
-        );
+        let shadowsocks_urls: Vec<String> = rt
+            .active_passwords(unix_now())
+            .map(|password| {
+                format!(
+                    "ss://{}@{host}:{base_port}#{}",
+                    user_info(rt, password),
+                    url_encode_fragment(&host)
+                )
+            })
+            .collect();
+        let shadowsocks_url = shadowsocks_urls[0].clone();
 
         let ws_url = if rt.ws_enabled {
             let plugin = utf8_percent_encode(
@@ -86,6 +105,7 @@ impl ShadowsocksUrls {
 
         Self {
             shadowsocks_url,
+            shadowsocks_urls,
             ws_url,
             grpc_url,
             v2ray_ng_ws,
@@ -94,6 +114,14 @@ impl ShadowsocksUrls {
     }
 }
 
+/// URL user info for `password` under `rt`'s protocol.
+fn user_info(rt: &ShadowsocksRuntime, password: &str) -> String {
+    match rt.protocol {
+        SsProtocol::Legacy => ss_auth_segment(&rt.cipher, password),
+        SsProtocol::Aead2022 => sip022_user_info(&rt.cipher, password),
+    }
+}
+
 fn ss_auth_segment(cipher: &str, password: &str) -> String {
     let user_info = format!("{cipher}:{password}");
     let mut auth = STANDARD.encode(user_info.as_bytes());
@@ -103,6 +131,18 @@ fn ss_auth_segment(cipher: &str, password: &str) -> String {
     auth
 }
 
+/// SIP022 `method:password`, percent-encoded rather than base64 (the key already is).
+fn sip022_user_info(cipher: &str, password: &str) -> String {
+    let method = parse_cipher(cipher, SsProtocol::Aead2022)
+        .map(|kind| kind.to_string())
+        .unwrap_or_else(|| cipher.to_string());
+    format!(
+        "{}:{}",
+        utf8_percent_encode(&method, USERINFO),
+        utf8_percent_encode(password, USERINFO)
+    )
+}
+
 fn resolve_host(rt: &ShadowsocksRuntime, host_hint: &str) -> String {
     let (host, _) = split_host_port(&rt.listen_addr);
     let host = host.as_str().trim();
@@ -215,7 +255,9 @@ mod tests {
         ShadowsocksRuntime {
             listen_addr: listen.to_string(),
             password: "secret-pass".to_string(),
+            extra_passwords: Vec::new(),
             cipher: "aes-128-gcm".to_string(),
+            protocol: SsProtocol::Legacy,
             mail_domain: "mail.example.org".to_string(),
             public_ip: String::new(),
             enabled,
@@ -258,6 +300,41 @@ mod tests {
         assert!(urls.shadowsocks_url.contains("@10.0.0.5:8388#"));
     }
 
+    #[test]
+    fn protocol_2022_url_uses_sip022_user_info() {
+        let mut rt = sample_runtime("10.0.0.5:8388", true);
+        rt.protocol = SsProtocol::Aead2022;
+        rt.password = "YctPZ6U7xPPcU+gp3u+0tx/tRizJN9K8y+uKlW2qjlI=".into();
+        let urls = ShadowsocksUrls::build(&rt, "ignored");
+        assert_eq!(
+            urls.shadowsocks_url,
+            "ss://2022-blake3-aes-128-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%2FtRizJN9K8y%2BuKlW2qjlI%3D\
+             @10.0.0.5:8388#10%2E0%2E0%2E5"
+        );
+    }
+
+    #[test]
+    fn urls_list_every_active_password_primary_first() {
+        use crate::slots::PasswordSlot;
+
+        let mut rt = sample_runtime("10.0.0.5:8388", true);
+        rt.extra_passwords = vec![
+            PasswordSlot {
+                password: "next".into(),
+                expires_at: None,
+            },
+            PasswordSlot {
+                password: "gone".into(),
+                expires_at: Some(1),
+            },
+        ];
+        let urls = ShadowsocksUrls::build(&rt, "ignored");
+        assert_eq!(urls.shadowsocks_urls.len(), 2);
+        assert_eq!(urls.shadowsocks_urls[0], urls.shadowsocks_url);
+        let next = ss_auth_segment("aes-128-gcm", "next");
+        assert!(urls.shadowsocks_urls[1].starts_with(&format!("ss://{next}@10.0.0.5:8388#")));
+    }
+
     #[test]
     fn ss_auth_segment_strips_base64_padding() {
         let auth = ss_auth_segment("aes-128-gcm", "pw");
@@ -40,6 +40,15 @@ impl XrayChildren {
             let _ = c.start_kill();
         }
     }
+
+    /// Kill and reap both children, so their ports are free when this returns.
+    pub async fn stop(&mut self) {
+        for child in [self.ws.take(), self.grpc.take()].into_iter().flatten() {
+            let mut child = child;
+            let _ = child.start_kill();
+            let _ = child.wait().await;
+        }
+    }
 }
 
 pub fn spawn_xray_transports(
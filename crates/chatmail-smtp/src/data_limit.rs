@@ -18,15 +18,15 @@
 //! SMTP DATA size limits (streaming read, dot-stuffing).
 
 use chatmail_types::{ChatmailError, Result};
-use tokio::io::AsyncBufRead;
+use tokio::io::{AsyncBufRead, AsyncBufReadExt};
 
 /// RFC 5321 dot-stuffing: a leading `.` on a DATA line is removed.
-pub fn unstuff_smtp_data_line(line: &str) -> &str {
-    line.strip_prefix('.').unwrap_or(line)
+pub fn unstuff_smtp_data_line(line: &[u8]) -> &[u8] {
+    line.strip_prefix(b".").unwrap_or(line)
 }
 
 /// Bytes contributed by one DATA line (unstuffed payload + CRLF).
-pub fn smtp_data_line_octets(line: &str) -> u64 {
+pub fn smtp_data_line_octets(line: &[u8]) -> u64 {
     let n = unstuff_smtp_data_line(line).len();
     u64::try_from(n).unwrap_or(u64::MAX).saturating_add(2)
 }
@@ -52,7 +52,9 @@ pub fn parse_smtp_size_parameter(line: &str) -> Result<Option<u64>> {
 
 /// Read SMTP DATA until a lone `.`, enforcing `max_bytes` on stored content.
 ///
-/// If the limit is exceeded, remaining lines are drained but not stored.
+/// Lines are read as bytes from the reader underneath `lines`, so an 8BITMIME body that is
+/// not UTF-8 is stored as sent. If the limit is exceeded, remaining lines are drained but
+/// not stored.
 pub async fn read_smtp_data_limited<R>(
     lines: &mut tokio::io::Lines<R>,
     max_bytes: u64,
@@ -60,20 +62,29 @@ pub async fn read_smtp_data_limited<R>(
 where
     R: AsyncBufRead + Unpin,
 {
+    let reader = lines.get_mut();
     let mut data = Vec::new();
     let mut over_limit = false;
+    let mut buf = Vec::new();
 
-    while let Some(line) = lines.next_line().await? {
-        if line == "." {
+    loop {
+        buf.clear();
+        if reader.read_until(b'\n', &mut buf).await? == 0 {
+            break;
+        }
+        let line = buf
+            .strip_suffix(b"\n")
+            .map(|l| l.strip_suffix(b"\r").unwrap_or(l))
+            .unwrap_or(buf.as_slice());
+        if line == b"." {
             break;
         }
         if !over_limit {
-            let add = smtp_data_line_octets(&line);
+            let add = smtp_data_line_octets(line);
             if data.len() as u64 + add > max_bytes {
                 over_limit = true;
             } else {
-                let unstuffed = unstuff_smtp_data_line(&line);
-                data.extend_from_slice(unstuffed.as_bytes());
+                data.extend_from_slice(unstuff_smtp_data_line(line));
                 data.extend_from_slice(b"\r\n");
             }
         }
@@ -93,14 +104,14 @@ mod tests {
 
     #[test]
     fn unstuff_strips_one_leading_dot() {
-        assert_eq!(unstuff_smtp_data_line(".."), ".");
-        assert_eq!(unstuff_smtp_data_line("hello"), "hello");
+        assert_eq!(unstuff_smtp_data_line(b".."), b".");
+        assert_eq!(unstuff_smtp_data_line(b"hello"), b"hello");
     }
 
     #[test]
     fn smtp_data_line_octets_includes_crlf() {
-        assert_eq!(smtp_data_line_octets("ab"), 4);
-        assert_eq!(smtp_data_line_octets(".."), 3);
+        assert_eq!(smtp_data_line_octets(b"ab"), 4);
+        assert_eq!(smtp_data_line_octets(b".."), 3);
     }
 
     #[test]
@@ -138,7 +149,7 @@ mod tests {
     async fn read_smtp_data_limited_accepts_exactly_at_limit() {
         let input = "aaaa\r\n.\r\n";
         let mut lines = BufReader::new(input.as_bytes()).lines();
-        assert_eq!(smtp_data_line_octets("aaaa"), 6);
+        assert_eq!(smtp_data_line_octets(b"aaaa"), 6);
         let body = read_smtp_data_limited(&mut lines, 6).await.unwrap();
         assert_eq!(body, b"aaaa\r\n");
     }
@@ -166,8 +177,17 @@ mod tests {
     async fn read_smtp_data_limited_counts_unstuffed_dot_line() {
         let input = "..end\r\n.\r\n";
         let mut lines = BufReader::new(input.as_bytes()).lines();
-        assert_eq!(smtp_data_line_octets("..end"), 6);
+        assert_eq!(smtp_data_line_octets(b"..end"), 6);
         let err = read_smtp_data_limited(&mut lines, 5).await.unwrap_err();
         assert!(matches!(err, ChatmailError::MessageTooLarge));
     }
+
+    #[tokio::test]
+    async fn read_smtp_data_limited_keeps_8bit_bytes() {
+        let input: &[u8] = b"Subject: caf\xe9\r\n\r\n\xff\xfe\n.\r\nQUIT\r\n";
+        let mut lines = BufReader::new(input).lines();
+        let body = read_smtp_data_limited(&mut lines, 64).await.unwrap();
+        assert_eq!(body, b"Subject: caf\xe9\r\n\r\n\xff\xfe\r\n");
+        assert_eq!(lines.next_line().await.unwrap().as_deref(), Some("QUIT"));
+    }
 }
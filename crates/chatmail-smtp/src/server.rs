@@ -15,13 +15,14 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::net::SocketAddr;
 use std::sync::Arc;
 
 use chatmail_db::DbPool;
 use chatmail_state::AppState;
 use chatmail_types::Result;
 use rustls::ServerConfig;
-use tokio::net::TcpListener;
+use tokio::net::{TcpListener, TcpStream};
 use tokio_rustls::TlsAcceptor;
 use tokio_util::sync::CancellationToken;
 use tracing::info;
@@ -58,6 +59,10 @@ pub async fn run_smtp_listener(
             }
             accept = listener.accept() => {
                 let (stream, peer) = accept?;
+                if ctx.bans.is_banned(peer.ip()) {
+                    tracing::debug!(%peer, "SMTP connection from banned address dropped");
+                    continue;
+                }
                 // Disable Nagle so small SMTP command replies aren't delayed by 40–200ms.
                 if let Err(e) = stream.set_nodelay(true) {
                     tracing::debug!(%peer, error = %e, "SMTP set_nodelay failed");
@@ -67,15 +72,10 @@ pub async fn run_smtp_listener(
                 let cfg = cfg.clone();
                 let acceptor = tls_acceptor.clone();
                 tokio::spawn(async move {
-                    let mut session = SmtpSession::new(ctx, pool, cfg);
+                    let mut session =
+                        SmtpSession::new(Arc::clone(&ctx), pool, cfg).with_peer(peer.ip());
                     let result = if let Some(acceptor) = acceptor {
-                        match acceptor.accept(stream).await {
-                            Ok(tls_stream) => session.handle_tls_connection(tls_stream).await,
-                            Err(e) => {
-                                tracing::debug!(%peer, error = %e, "SMTP TLS handshake failed");
-                                Ok(())
-                            }
-                        }
+                        serve_implicit_tls(session, &ctx, acceptor, stream, peer).await
                     } else {
                         session.handle_connection(stream).await
                     };
@@ -88,3 +88,28 @@ pub async fn run_smtp_listener(
     }
     Ok(())
 }
+
+/// Implicit TLS: fingerprint the ClientHello, drop banned fingerprints, then handshake.
+async fn serve_implicit_tls(
+    session: SmtpSession,
+    ctx: &AppState,
+    acceptor: TlsAcceptor,
+    stream: TcpStream,
+    peer: SocketAddr,
+) -> Result<()> {
+    let ja4 = match ctx.screen_tls_client(&stream).await {
+        Ok(ja4) => ja4,
+        Err(ja4) => {
+            tracing::debug!(%peer, %ja4, "SMTP connection with banned TLS fingerprint dropped");
+            return Ok(());
+        }
+    };
+    let mut session = session.with_tls_fingerprint(ja4);
+    match acceptor.accept(stream).await {
+        Ok(tls_stream) => session.handle_tls_connection(tls_stream).await,
+        Err(e) => {
+            tracing::debug!(%peer, error = %e, "SMTP TLS handshake failed");
+            Ok(())
+        }
+    }
+}
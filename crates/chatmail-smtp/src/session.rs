@@ -15,15 +15,20 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::net::IpAddr;
 use std::sync::Arc;
 
 use base64::Engine;
 use chatmail_auth::{normalize_username, AuthContext};
 use chatmail_config::CredentialPolicy;
 use chatmail_db::DbPool;
-use chatmail_delivery::DeliveryContext;
+use chatmail_delivery::mail_options::address_allowed;
+use chatmail_delivery::priority::{classify, mail_from_mt_priority};
+use chatmail_delivery::{DeliveryContext, MailOptions};
 use chatmail_pgp::{enforce_encryption, EnforceOptions};
-use chatmail_state::AppState;
+use chatmail_state::{
+    catch_panic, AppState, CrashScope, DiskTier, LocalRcpt, SENDING_RATE_LIMITED,
+};
 use chatmail_storage::deliver_local_messages;
 use chatmail_types::{ChatmailError, Result};
 use rustls::ServerConfig;
@@ -58,8 +63,17 @@ pub struct SmtpSession {
     pub cfg: SmtpSessionConfig,
     authenticated_user: Option<String>,
     mail_from: String,
+    /// `REQUIRETLS`, `SMTPUTF8` and `BODY=` on the current `MAIL FROM`; carried into the
+    /// outbound queue.
+    mail_options: MailOptions,
+    /// RFC 6710 `MT-PRIORITY` on the current `MAIL FROM`; see [`Self::relay_priority`].
+    mt_priority: Option<i8>,
     rcpt_to: Vec<String>,
     seen_ehlo: bool,
+    /// Client address; failed `AUTH` from it counts toward an automatic IP ban.
+    peer: Option<IpAddr>,
+    /// JA4 of the client's TLS ClientHello, for abuse logs only.
+    tls_fingerprint: Option<String>,
 }
 
 impl SmtpSession {
@@ -70,23 +84,97 @@ impl SmtpSession {
             cfg,
             authenticated_user: None,
             mail_from: String::new(),
+            mail_options: MailOptions::default(),
+            mt_priority: None,
             rcpt_to: Vec::new(),
             seen_ehlo: false,
+            peer: None,
+            tls_fingerprint: None,
         }
     }
 
-    pub async fn handle_connection(&mut self, stream: TcpStream) -> Result<()> {
+    pub fn with_peer(mut self, peer: IpAddr) -> Self {
+        self.peer = Some(peer);
+        self
+    }
+
+    pub fn with_tls_fingerprint(mut self, fingerprint: Option<String>) -> Self {
+        self.tls_fingerprint = fingerprint;
+        self
+    }
+
+    pub async fn handle_connection(&mut self, mut stream: TcpStream) -> Result<()> {
+        if !self.cfg.require_auth && !self.hold_greeting(&mut stream).await? {
+            return Ok(());
+        }
         if self.cfg.starttls_config.is_some() {
             self.serve_with_starttls_upgrade(stream).await
         } else {
             let (reader, writer) = tokio::io::split(stream);
-            self.serve(reader, writer, false).await
+            self.serve_contained(reader, writer, false).await
+        }
+    }
+
+    /// `greet_delay`: wait before the banner and drop clients that talk first.
+    /// Returns `false` when the session must end (early talker rejected or peer hung up).
+    async fn hold_greeting(&self, stream: &mut TcpStream) -> Result<bool> {
+        let Ok(peer) = stream.peer_addr() else {
+            return Ok(true);
+        };
+        let Some(delay) = self.ctx.greet.delay_for(peer.ip()) else {
+            return Ok(true);
+        };
+        let mut probe = [0u8; 1];
+        match tokio::time::timeout(delay, stream.peek(&mut probe)).await {
+            Err(_) => Ok(true),
+            Ok(Ok(0)) => Ok(false),
+            Ok(Ok(_)) => {
+                self.ctx.greet.record_early_talker();
+                tracing::info!(peer = %peer.ip(), "smtp: early talker rejected before greeting");
+                stream
+                    .write_all(b"554 5.5.0 Protocol violation: sent data before greeting\r\n")
+                    .await?;
+                Ok(false)
+            }
+            Ok(Err(e)) => Err(e.into()),
         }
     }
 
     pub async fn handle_tls_connection(&mut self, stream: TlsStream<TcpStream>) -> Result<()> {
         let (reader, writer) = tokio::io::split(stream);
-        self.serve(reader, writer, true).await
+        self.serve_contained(reader, writer, true).await
+    }
+
+    /// [`Self::serve`] with panic containment: a panic is logged and reported, and only this
+    /// client gets `421` before its connection closes.
+    async fn serve_contained<R, W>(
+        &mut self,
+        reader: R,
+        mut writer: W,
+        tls_active: bool,
+    ) -> Result<()>
+    where
+        R: tokio::io::AsyncRead + Unpin,
+        W: AsyncWriteExt + Unpin,
+    {
+        match catch_panic(self.serve(reader, &mut writer, tls_active)).await {
+            Ok(result) => result,
+            Err(panic) => {
+                let peer = self
+                    .peer
+                    .map_or_else(|| "unknown".into(), |ip| ip.to_string());
+                let scope = CrashScope::new(self.cfg.module, peer)
+                    .field("user", self.authenticated_user.as_deref().unwrap_or("-"))
+                    .field("mail_from", &self.mail_from)
+                    .field("recipients", self.rcpt_to.len())
+                    .field("tls", tls_active);
+                self.ctx.crashes.report(&scope, &panic);
+                writer
+                    .write_all(b"421 4.3.0 Internal server error, closing connection\r\n")
+                    .await?;
+                Ok(())
+            }
+        }
     }
 
     async fn serve_with_starttls_upgrade(&mut self, stream: TcpStream) -> Result<()> {
@@ -129,6 +217,17 @@ impl SmtpSession {
                     writer.flush().await?;
                     let reader = lines.into_inner();
                     let stream = reader.unsplit(writer);
+                    match self.ctx.screen_tls_client(&stream).await {
+                        Ok(ja4) => self.tls_fingerprint = ja4,
+                        Err(ja4) => {
+                            tracing::debug!(
+                                peer = ?self.peer,
+                                %ja4,
+                                "SMTP STARTTLS with banned TLS fingerprint dropped"
+                            );
+                            return Ok(());
+                        }
+                    }
                     let acceptor = TlsAcceptor::from(cfg);
                     let tls = acceptor
                         .accept(stream)
@@ -166,9 +265,19 @@ impl SmtpSession {
             "250-SIZE {}\r\n",
             self.ctx.message_size.effective()
         ));
+        // Bodies and addresses are passed through unchanged; see `MailOptions`.
+        out.push_str("250-8BITMIME\r\n");
+        out.push_str("250-SMTPUTF8\r\n");
         if tls_active || self.cfg.starttls_config.is_none() {
             out.push_str("250-AUTH PLAIN\r\n");
         }
+        // RFC 8689 §4.1: only offered once the session itself is protected.
+        if tls_active {
+            out.push_str("250-REQUIRETLS\r\n");
+        }
+        out.push_str("250-MT-PRIORITY\r\n");
+        // RFC 9422: lets compliant clients split large recipient lists up front.
+        out.push_str(&format!("250-LIMITS RCPTMAX={}\r\n", self.ctx.max_rcpt));
         out.push_str("250 OK\r\n");
         out
     }
@@ -243,10 +352,22 @@ impl SmtpSession {
                     };
                     if let Err(e) = chatmail_auth::authenticate(&auth, &user.0, &user.1).await {
                         chatmail_metrics::record_smtp_failed_login(self.cfg.module);
-                        writer
-                            .write_all(b"535 5.7.8 Invalid credentials\r\n")
-                            .await?;
-                        tracing::debug!(error = %e, "SMTP AUTH failed");
+                        if let (Some(ip), ChatmailError::AuthFailed) = (self.peer, &e) {
+                            self.ctx.note_auth_failure(&self.pool, ip).await;
+                        }
+                        let reply: &[u8] = if matches!(e, ChatmailError::ServerFull(_)) {
+                            b"454 4.7.0 Server full\r\n"
+                        } else {
+                            b"535 5.7.8 Invalid credentials\r\n"
+                        };
+                        writer.write_all(reply).await?;
+                        tracing::debug!(
+                            peer = ?self.peer,
+                            ja4 = ?self.tls_fingerprint,
+                            user = %user.0,
+                            error = %e,
+                            "SMTP AUTH failed"
+                        );
                         continue;
                     }
                     self.authenticated_user = Some(normalize_username(&user.0)?);
@@ -255,6 +376,18 @@ impl SmtpSession {
                         .await?;
                 }
                 "MAIL" => {
+                    if self.ctx.drain.is_draining() {
+                        writer
+                            .write_all(b"421 4.3.2 Service shutting down\r\n")
+                            .await?;
+                        chatmail_metrics::record_smtp_failed_command(
+                            self.cfg.module,
+                            "MAIL",
+                            421,
+                            "4.3.2",
+                        );
+                        break;
+                    }
                     if !self.seen_ehlo {
                         writer.write_all(b"503 5.5.1 EHLO first\r\n").await?;
                         chatmail_metrics::record_smtp_failed_command(
@@ -277,7 +410,43 @@ impl SmtpSession {
                         );
                         continue;
                     }
+                    let options = match MailOptions::from_mail_from(&line) {
+                        Ok(o) => o,
+                        Err(_) => {
+                            writer.write_all(b"501 5.5.4 Bad BODY\r\n").await?;
+                            chatmail_metrics::record_smtp_failed_command(
+                                self.cfg.module,
+                                "MAIL",
+                                501,
+                                "5.5.4",
+                            );
+                            continue;
+                        }
+                    };
+                    if options.require_tls && !tls_active {
+                        writer
+                            .write_all(b"530 5.7.10 REQUIRETLS needs a TLS session\r\n")
+                            .await?;
+                        chatmail_metrics::record_smtp_failed_command(
+                            self.cfg.module,
+                            "MAIL",
+                            530,
+                            "5.7.10",
+                        );
+                        continue;
+                    }
+                    self.mail_options = options;
                     match parse_path_addr(&line, "FROM:") {
+                        Ok(addr) if !address_allowed(&addr, options.smtputf8) => {
+                            writer.write_all(SMTPUTF8_REQUIRED).await?;
+                            chatmail_metrics::record_smtp_failed_command(
+                                self.cfg.module,
+                                "MAIL",
+                                553,
+                                "5.6.7",
+                            );
+                            continue;
+                        }
                         Ok(addr) => self.mail_from = addr,
                         Err(e) => {
                             writer.write_all(b"501 5.5.4 Bad address\r\n").await?;
@@ -290,6 +459,20 @@ impl SmtpSession {
                             return Err(e);
                         }
                     }
+                    self.mt_priority = match mail_from_mt_priority(&line) {
+                        Ok(p) => p,
+                        Err(_) => {
+                            writer.write_all(b"501 5.5.4 Bad MT-PRIORITY\r\n").await?;
+                            chatmail_metrics::record_smtp_failed_command(
+                                self.cfg.module,
+                                "MAIL",
+                                501,
+                                "5.5.4",
+                            );
+                            self.mail_from.clear();
+                            continue;
+                        }
+                    };
                     let declared = match parse_smtp_size_parameter(&line) {
                         Ok(s) => s,
                         Err(_) => {
@@ -322,6 +505,46 @@ impl SmtpSession {
                             continue;
                         }
                     }
+                    if let Err(tier) = self.ctx.disk.admit_message(declared.unwrap_or(0)) {
+                        let (reply, code) = disk_refusal_reply(tier);
+                        writer.write_all(reply).await?;
+                        chatmail_metrics::record_smtp_failed_command(
+                            self.cfg.module,
+                            "MAIL",
+                            code,
+                            "4.3.1",
+                        );
+                        self.mail_from.clear();
+                        continue;
+                    }
+                    if let Some(user) = self.authenticated_user.as_deref() {
+                        let refusal = match self
+                            .ctx
+                            .sending_limits
+                            .check_message(&self.pool, user)
+                            .await
+                        {
+                            Ok(()) => None,
+                            Err(ChatmailError::RateLimited(_)) => {
+                                Some((format!("{SENDING_RATE_LIMITED}\r\n"), 450, "4.7.1"))
+                            }
+                            Err(e) => {
+                                tracing::warn!(error = %e, "sending rate limit lookup failed");
+                                Some(("451 4.0.0 Temporary failure\r\n".to_string(), 451, "4.0.0"))
+                            }
+                        };
+                        if let Some((reply, code, enhanced)) = refusal {
+                            writer.write_all(reply.as_bytes()).await?;
+                            chatmail_metrics::record_smtp_failed_command(
+                                self.cfg.module,
+                                "MAIL",
+                                code,
+                                enhanced,
+                            );
+                            self.mail_from.clear();
+                            continue;
+                        }
+                    }
                     if !self.cfg.require_auth {
                         let policy_mode = self.ctx.federation_policy.global_mode();
                         if check_inbound_mail_from(
@@ -357,7 +580,49 @@ impl SmtpSession {
                         );
                         continue;
                     }
+                    if self.rcpt_to.len() >= self.ctx.max_rcpt {
+                        // 452 (not 5xx) so the client retries the rest in a new transaction.
+                        writer
+                            .write_all(b"452 4.5.3 Too many recipients\r\n")
+                            .await?;
+                        chatmail_metrics::record_smtp_failed_command(
+                            self.cfg.module,
+                            "RCPT",
+                            452,
+                            "4.5.3",
+                        );
+                        continue;
+                    }
+                    if let Some(user) = self.authenticated_user.as_deref() {
+                        if self
+                            .ctx
+                            .sending_limits
+                            .check_rcpt(user, self.rcpt_to.len())
+                            .is_err()
+                        {
+                            writer
+                                .write_all(format!("{SENDING_RATE_LIMITED}\r\n").as_bytes())
+                                .await?;
+                            chatmail_metrics::record_smtp_failed_command(
+                                self.cfg.module,
+                                "RCPT",
+                                450,
+                                "4.7.1",
+                            );
+                            continue;
+                        }
+                    }
                     let rcpt = match parse_path_addr(&line, "TO:") {
+                        Ok(a) if !address_allowed(&a, self.mail_options.smtputf8) => {
+                            writer.write_all(SMTPUTF8_REQUIRED).await?;
+                            chatmail_metrics::record_smtp_failed_command(
+                                self.cfg.module,
+                                "RCPT",
+                                553,
+                                "5.6.7",
+                            );
+                            continue;
+                        }
                         Ok(a) => match normalize_username(&a) {
                             Ok(r) => r,
                             Err(e) => {
@@ -412,6 +677,31 @@ impl SmtpSession {
                         );
                         continue;
                     }
+                    // Shutdown waits for this transaction before storage closes.
+                    let _work = self.ctx.drain.track();
+                    if !self.ctx.memory.admit(self.cfg.module) {
+                        writer
+                            .write_all(b"452 4.3.1 Insufficient system storage\r\n")
+                            .await?;
+                        chatmail_metrics::record_smtp_failed_command(
+                            self.cfg.module,
+                            "DATA",
+                            452,
+                            "4.3.1",
+                        );
+                        continue;
+                    }
+                    if let Err(tier) = self.ctx.disk.admit_message(0) {
+                        let (reply, code) = disk_refusal_reply(tier);
+                        writer.write_all(reply).await?;
+                        chatmail_metrics::record_smtp_failed_command(
+                            self.cfg.module,
+                            "DATA",
+                            code,
+                            "4.3.1",
+                        );
+                        continue;
+                    }
                     writer.write_all(b"354 Start mail input\r\n").await?;
                     let max_bytes = self.ctx.message_size.effective();
                     let data = match read_smtp_data_limited(&mut lines, max_bytes).await {
@@ -435,6 +725,21 @@ impl SmtpSession {
                         }
                         Err(e) => return Err(e),
                     };
+                    // SIZE= is optional, so large bodies are only known here.
+                    if let Err(tier) = self.ctx.disk.admit_message(data.len() as u64) {
+                        let (reply, code) = disk_refusal_reply(tier);
+                        writer.write_all(reply).await?;
+                        chatmail_metrics::record_smtp_failed_command(
+                            self.cfg.module,
+                            "DATA",
+                            code,
+                            "4.3.1",
+                        );
+                        self.mail_from.clear();
+                        self.rcpt_to.clear();
+                        continue;
+                    }
+                    let _lease = self.ctx.memory.reserve(data.len() as u64);
                     match self.ingest_data(&data).await {
                         Ok(()) => {
                             chatmail_metrics::record_smtp_completed(self.cfg.module);
@@ -520,6 +825,8 @@ impl SmtpSession {
                     writer.write_all(b"250 2.0.0 OK\r\n").await?;
                 }
                 "NOOP" => writer.write_all(b"250 2.0.0 OK\r\n").await?,
+                #[cfg(test)]
+                "XINJECTPANIC" => panic!("injected SMTP session panic"),
                 _ => {
                     writer
                         .write_all(b"502 5.5.1 Command not implemented\r\n")
@@ -530,6 +837,16 @@ impl SmtpSession {
         Ok(())
     }
 
+    /// Queue priority for relayed mail. A client's `MT-PRIORITY` is honoured only from trusted
+    /// peers (`trusted_networks`, loopback); everyone else gets our own classification, so
+    /// nobody can jump the queue by asking. Submission never reaches this: it always classifies.
+    fn relay_priority(&self, data: &[u8]) -> i8 {
+        match (self.mt_priority, self.peer) {
+            (Some(priority), Some(ip)) if self.ctx.bans.is_exempt(ip) => priority,
+            _ => classify(data),
+        }
+    }
+
     async fn ingest_data(&self, data: &[u8]) -> Result<()> {
         self.ctx.check_message_size(data.len())?;
 
@@ -541,6 +858,10 @@ impl SmtpSession {
                 &EnforceOptions {
                     mail_from: self.mail_from.clone(),
                     recipients: self.rcpt_to.clone(),
+                    passthrough: self
+                        .ctx
+                        .policies
+                        .pgp_passthrough(Some(&self.mail_from), &self.rcpt_to),
                 },
             )?;
             let delivery = DeliveryContext {
@@ -550,9 +871,21 @@ impl SmtpSession {
                 local_domains: self.cfg.local_domains.clone(),
             };
             delivery
-                .submit_authenticated(&self.mail_from, &self.rcpt_to, data)
+                .submit_authenticated(&self.mail_from, &self.rcpt_to, data, self.mail_options)
                 .await?;
             chatmail_db::record_smtp_accepted(true);
+            let sender = self
+                .authenticated_user
+                .as_deref()
+                .unwrap_or(&self.mail_from);
+            if let Err(e) = self
+                .ctx
+                .sending_limits
+                .record(&self.pool, sender, data, self.rcpt_to.len())
+                .await
+            {
+                tracing::warn!(user = sender, error = %e, "sending_stats update failed");
+            }
             return Ok(());
         }
 
@@ -567,6 +900,8 @@ impl SmtpSession {
             &EnforceOptions {
                 mail_from: self.mail_from.clone(),
                 recipients: self.rcpt_to.clone(),
+                // Unauthenticated MAIL FROM: only the recipients can opt out.
+                passthrough: self.ctx.policies.pgp_passthrough(None, &self.rcpt_to),
             },
         )?;
 
@@ -581,16 +916,25 @@ impl SmtpSession {
         let total_rcpts = self.rcpt_to.len();
         let mut local_deliveries: Vec<(String, String)> = Vec::new();
         let mut remote_rcpts: Vec<String> = Vec::new();
+        let mut unknown_rcpts: Vec<String> = Vec::new();
+        let mut subaddressed: Vec<LocalRcpt> = Vec::new();
 
         for rcpt in &self.rcpt_to {
             let rcpt = normalize_username(rcpt)?;
-            self.ctx.quota.check_quota(&rcpt, data.len() as u64)?;
-            if delivery.is_local(&rcpt) {
-                if !self.ctx.auth.local_recipient_allowed(&rcpt) {
-                    tracing::debug!(rcpt = %rcpt, "silently dropped inbound local delivery");
-                    continue;
+            // `user+tag@` is checked (and charged) as `user@`.
+            let local = delivery
+                .is_local(&rcpt)
+                .then(|| self.ctx.resolve_local_rcpt(&rcpt));
+            let account = local.as_ref().map_or(&rcpt, |l| &l.account);
+            self.ctx.admit_local_delivery(account, data.len())?;
+            if let Some(local) = local {
+                if !self.ctx.auth.local_recipient_allowed(&local.account) {
+                    unknown_rcpts.push(local.account);
+                } else if local.tag.is_some() {
+                    subaddressed.push(local);
+                } else {
+                    local_deliveries.push((local.account, uuid::Uuid::new_v4().to_string()));
                 }
-                local_deliveries.push((rcpt, uuid::Uuid::new_v4().to_string()));
             } else if self
                 .ctx
                 .federation_silent_dismiss
@@ -607,10 +951,17 @@ impl SmtpSession {
         // separate durable copy per recipient inside the SMTP DATA transaction.
         if !remote_rcpts.is_empty() {
             delivery
-                .enqueue_remote_batch(&self.mail_from, &remote_rcpts, data)
+                .enqueue_remote_batch(
+                    &self.mail_from,
+                    &remote_rcpts,
+                    data,
+                    self.mail_options,
+                    self.relay_priority(data),
+                )
                 .await?;
         }
 
+        let filtered = delivery.take_filtered(&mut local_deliveries).await;
         let rcpt_phase = ingest_start.elapsed();
         if !local_deliveries.is_empty() {
             let local_n = local_deliveries.len();
@@ -625,7 +976,13 @@ impl SmtpSession {
                 self.ctx
                     .notify_inbound_push(&self.pool, &self.mail_from, rcpt)
                     .await;
+                self.ctx.activity.touch_inbound(&self.pool, rcpt).await;
             }
+            delivery.spawn_auto_replies(
+                &self.mail_from,
+                outcome.delivered.iter().map(|(r, _)| r),
+                data,
+            );
             if !outcome.failed.is_empty() {
                 for (rcpt, _msg_id, err) in &outcome.failed {
                     tracing::warn!(rcpt = %rcpt, error = %err, "local delivery failed for recipient");
@@ -648,6 +1005,15 @@ impl SmtpSession {
                 "SMTP local fan-out timing"
             );
         }
+        delivery
+            .deliver_subaddressed(&self.mail_from, &subaddressed, data)
+            .await;
+        delivery
+            .deliver_filtered(&self.mail_from, &filtered, data)
+            .await;
+        delivery
+            .deliver_catchall(&self.mail_from, &unknown_rcpts, data)
+            .await;
         chatmail_db::record_inbound_delivery();
         if let Some((_, sender_domain)) = self.mail_from.rsplit_once('@') {
             let sender_domain = sender_domain.to_ascii_lowercase();
@@ -662,6 +1028,21 @@ impl SmtpSession {
     }
 }
 
+/// RFC 6531: a non-ASCII mailbox in a transaction that did not declare `SMTPUTF8`.
+const SMTPUTF8_REQUIRED: &[u8] = b"553 5.6.7 Must declare SMTPUTF8 to send unicode address\r\n";
+
+/// Reply for a [`chatmail_state::DiskGuard::admit_message`] refusal: 451 (deferred) while
+/// storage is read-only, 452 for large messages above the soft limit.
+fn disk_refusal_reply(tier: DiskTier) -> (&'static [u8], u16) {
+    match tier {
+        DiskTier::Hard => (
+            b"451 4.3.1 Mail system storage is read-only, try again later\r\n",
+            451,
+        ),
+        _ => (b"452 4.3.1 Insufficient system storage\r\n", 452),
+    }
+}
+
 fn parse_path_addr(line: &str, prefix: &str) -> Result<String> {
     let upper = line.to_ascii_uppercase();
     let idx = upper
@@ -740,8 +1121,12 @@ mod tests {
             },
             authenticated_user: None,
             mail_from: String::new(),
+            mail_options: MailOptions::default(),
+            mt_priority: None,
             rcpt_to: Vec::new(),
             seen_ehlo: false,
+            peer: None,
+            tls_fingerprint: None,
         };
         assert!(!s.seen_ehlo);
         s.seen_ehlo = true;
@@ -812,6 +1197,7 @@ mod tests {
                 if acc.contains("250 ")
                     || acc.contains("235 ")
                     || acc.contains("354 ")
+                    || acc.contains("452 ")
                     || acc.contains("523 ")
                     || acc.contains("552 ")
                     || acc.contains("554 ")
@@ -858,6 +1244,38 @@ mod tests {
         assert!(t.contains("523"), "got: {t}");
     }
 
+    #[tokio::test]
+    async fn panicking_session_gets_421_and_a_crash_report() {
+        chatmail_state::install_panic_hook(false);
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let t = smtp_dialog(
+            SmtpSessionConfig {
+                hostname: "mx.test".into(),
+                primary_domain: "test".into(),
+                local_domains: vec!["test".into()],
+                jit_domain: None,
+                credential_policy: CredentialPolicy::default(),
+                require_auth: false,
+                module: "smtp",
+                starttls_config: None,
+            },
+            pool,
+            Arc::clone(&ctx),
+            &["EHLO client.test", "XINJECTPANIC"],
+        )
+        .await;
+        assert!(t.contains("421 4.3.0"), "got: {t}");
+        assert_eq!(ctx.crashes.counts().get("smtp"), Some(&1));
+        let report = std::fs::read_to_string(ctx.crashes.last_report().unwrap()).unwrap();
+        assert!(report.contains("module: smtp"), "{report}");
+        assert!(
+            report.contains("panic: injected SMTP session panic"),
+            "{report}"
+        );
+    }
+
     #[tokio::test]
     async fn p4_submission_from_envelope_mismatch_554() {
         let pool = chatmail_db::init_memory_db().await.unwrap();
@@ -895,6 +1313,288 @@ mod tests {
         assert!(t.contains("554 5.6.0"), "got: {t}");
     }
 
+    #[tokio::test]
+    async fn rcpt_limit_returns_452_at_boundary() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let mut config = chatmail_config::AppConfig::default();
+        config.max_rcpt = Some(3);
+        let ctx = Arc::new(AppState::with_quota_and_message_limit(
+            std::env::temp_dir(),
+            chatmail_config::DEFAULT_QUOTA_BYTES,
+            &config,
+            pool.clone(),
+        ));
+        let t = smtp_dialog(
+            SmtpSessionConfig {
+                hostname: "mx.test".into(),
+                primary_domain: "test".into(),
+                local_domains: vec!["test".into()],
+                jit_domain: None,
+                credential_policy: CredentialPolicy::default(),
+                require_auth: false,
+                module: "smtp",
+                starttls_config: None,
+            },
+            pool,
+            ctx,
+            &[
+                "EHLO client.test",
+                "MAIL FROM:<a@peer.test>",
+                "RCPT TO:<r1@test>",
+                "RCPT TO:<r2@test>",
+                "RCPT TO:<r3@test>",
+                "RCPT TO:<r4@test>",
+            ],
+        )
+        .await;
+        assert!(t.contains("250-LIMITS RCPTMAX=3\r\n"), "got: {t}");
+        assert!(t.contains("250-MT-PRIORITY\r\n"), "got: {t}");
+        assert_eq!(t.matches("250 2.1.5 OK").count(), 3, "got: {t}");
+        assert!(t.ends_with("452 4.5.3 Too many recipients\r\n"), "got: {t}");
+    }
+
+    /// RFC 6710: an out-of-range MT-PRIORITY is a syntax error on MAIL.
+    #[tokio::test]
+    async fn mt_priority_out_of_range_is_rejected() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState::new(std::env::temp_dir(), pool.clone()));
+        let t = smtp_dialog(
+            SmtpSessionConfig {
+                hostname: "mx.test".into(),
+                primary_domain: "test".into(),
+                local_domains: vec!["test".into()],
+                jit_domain: None,
+                credential_policy: CredentialPolicy::default(),
+                require_auth: false,
+                module: "smtp",
+                starttls_config: None,
+            },
+            pool,
+            ctx,
+            &[
+                "EHLO client.test",
+                "MAIL FROM:<a@peer.test> MT-PRIORITY=12",
+                "MAIL FROM:<a@peer.test> MT-PRIORITY=-3",
+            ],
+        )
+        .await;
+        assert!(t.contains("501 5.5.4 Bad MT-PRIORITY\r\n"), "got: {t}");
+        assert!(t.ends_with("250 2.1.0 OK\r\n"), "got: {t}");
+    }
+
+    /// MT-PRIORITY from an untrusted peer falls back to our own classification.
+    #[tokio::test]
+    async fn mt_priority_only_honoured_from_trusted_peers() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState::new(std::env::temp_dir(), pool.clone()));
+        let cfg = SmtpSessionConfig {
+            hostname: "mx.test".into(),
+            primary_domain: "test".into(),
+            local_domains: vec!["test".into()],
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            require_auth: false,
+            module: "smtp",
+            starttls_config: None,
+        };
+        let plain = b"From: a@peer.test\r\n\r\nx";
+        let join = b"From: a@peer.test\r\nSecure-Join: vc-request\r\n\r\nx";
+
+        let mut trusted = SmtpSession::new(Arc::clone(&ctx), pool.clone(), cfg.clone())
+            .with_peer("127.0.0.1".parse().unwrap());
+        trusted.mt_priority = Some(-5);
+        assert_eq!(trusted.relay_priority(join), -5);
+
+        let mut untrusted =
+            SmtpSession::new(ctx, pool, cfg).with_peer("203.0.113.7".parse().unwrap());
+        untrusted.mt_priority = Some(9);
+        assert_eq!(untrusted.relay_priority(plain), 0);
+        assert_eq!(
+            untrusted.relay_priority(join),
+            chatmail_delivery::priority::SECURE_JOIN_PRIORITY
+        );
+    }
+
+    #[tokio::test]
+    async fn data_refused_with_452_while_memory_budget_exhausted() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState {
+            memory: Arc::new(chatmail_state::MemoryBudget::new(1024)),
+            ..AppState::new(std::env::temp_dir(), pool.clone())
+        });
+        let _held = ctx.memory.reserve(2048);
+        let t = smtp_dialog(
+            SmtpSessionConfig {
+                hostname: "mx.test".into(),
+                primary_domain: "test".into(),
+                local_domains: vec!["test".into()],
+                jit_domain: None,
+                credential_policy: CredentialPolicy::default(),
+                require_auth: false,
+                module: "smtp",
+                starttls_config: None,
+            },
+            pool,
+            Arc::clone(&ctx),
+            &[
+                "EHLO client.test",
+                "MAIL FROM:<a@peer.test>",
+                "RCPT TO:<r1@test>",
+                "DATA",
+            ],
+        )
+        .await;
+        assert!(
+            t.ends_with("452 4.3.1 Insufficient system storage\r\n"),
+            "got: {t}"
+        );
+        assert!(!t.contains("354"), "got: {t}");
+        assert_eq!(ctx.memory.rejections(), 1);
+    }
+
+    struct FakeDisk(f64);
+
+    impl chatmail_state::DiskProbe for FakeDisk {
+        fn usage(&self) -> Option<chatmail_state::DiskUsage> {
+            Some(chatmail_state::DiskUsage {
+                total_bytes: 1000,
+                available_bytes: 1000 - (self.0 * 10.0) as u64,
+            })
+        }
+    }
+
+    #[tokio::test]
+    async fn disk_tiers_refuse_large_then_all_mail() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState {
+            disk: Arc::new(chatmail_state::DiskGuard::new(85.0, 95.0, 1024)),
+            ..AppState::new(std::env::temp_dir(), pool.clone())
+        });
+        let cfg = SmtpSessionConfig {
+            hostname: "mx.test".into(),
+            primary_domain: "test".into(),
+            local_domains: vec!["test".into()],
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            require_auth: false,
+            module: "smtp",
+            starttls_config: None,
+        };
+        let commands = [
+            "EHLO client.test",
+            "MAIL FROM:<a@peer.test> SIZE=4096",
+            "MAIL FROM:<a@peer.test> SIZE=512",
+        ];
+
+        ctx.disk.refresh(&FakeDisk(90.0));
+        let t = smtp_dialog(cfg.clone(), pool.clone(), Arc::clone(&ctx), &commands).await;
+        assert!(
+            t.contains("452 4.3.1 Insufficient system storage\r\n"),
+            "got: {t}"
+        );
+        assert!(t.ends_with("250 2.1.0 OK\r\n"), "got: {t}");
+
+        ctx.disk.refresh(&FakeDisk(97.0));
+        let t = smtp_dialog(cfg.clone(), pool.clone(), Arc::clone(&ctx), &commands).await;
+        assert_eq!(t.matches("451 4.3.1").count(), 2, "got: {t}");
+
+        ctx.disk.refresh(&FakeDisk(50.0));
+        let t = smtp_dialog(cfg, pool, Arc::clone(&ctx), &commands).await;
+        assert_eq!(t.matches("250 2.1.0 OK").count(), 2, "got: {t}");
+    }
+
+    #[tokio::test]
+    async fn drain_finishes_in_flight_data_and_refuses_new_mail() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("x").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        ctx.auth.hydrate(&pool).await.unwrap();
+        let cfg = SmtpSessionConfig {
+            hostname: "mx.test".into(),
+            primary_domain: "test".into(),
+            local_domains: vec!["test".into()],
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            require_auth: false,
+            module: "smtp",
+            starttls_config: None,
+        };
+
+        let std_listener = StdListener::bind("127.0.0.1:0").unwrap();
+        std_listener.set_nonblocking(true).unwrap();
+        let addr = std_listener.local_addr().unwrap();
+        let (pool_bg, ctx_bg) = (pool.clone(), Arc::clone(&ctx));
+        tokio::spawn(async move {
+            let listener = tokio::net::TcpListener::from_std(std_listener).unwrap();
+            loop {
+                let (stream, _) = listener.accept().await.unwrap();
+                let mut session =
+                    SmtpSession::new(Arc::clone(&ctx_bg), pool_bg.clone(), cfg.clone());
+                tokio::spawn(async move {
+                    let _ = session.handle_connection(stream).await;
+                });
+            }
+        });
+
+        let mut buf = [0u8; 4096];
+        let mut slow = TcpStream::connect(addr).await.unwrap();
+        for line in [
+            "EHLO peer.test",
+            "MAIL FROM:<a@peer.test>",
+            "RCPT TO:<u@test>",
+            "DATA",
+        ] {
+            slow.write_all(format!("{line}\r\n").as_bytes())
+                .await
+                .unwrap();
+            read_smtp_chunk(&mut slow, &mut buf).await;
+        }
+        let body = std::str::from_utf8(PGP_MIME_BODY)
+            .unwrap()
+            .replace("sender@test", "a@peer.test")
+            .replace("rcpt@test", "u@test");
+        let (head, tail) = body.split_at(body.len() / 2);
+        slow.write_all(head.as_bytes()).await.unwrap();
+        tokio::time::sleep(Duration::from_millis(40)).await;
+
+        ctx.drain.start_draining();
+        let waiter = {
+            let ctx = Arc::clone(&ctx);
+            tokio::spawn(async move { ctx.drain.wait_idle(Duration::from_secs(5)).await })
+        };
+
+        let mut late = TcpStream::connect(addr).await.unwrap();
+        late.write_all(b"EHLO late.test\r\n").await.unwrap();
+        read_smtp_chunk(&mut late, &mut buf).await;
+        late.write_all(b"MAIL FROM:<b@peer.test>\r\n")
+            .await
+            .unwrap();
+        let refused = read_smtp_chunk(&mut late, &mut buf).await;
+        assert!(refused.contains("421 4.3.2"), "got: {refused}");
+        assert!(!waiter.is_finished(), "drain must wait for the open DATA");
+
+        slow.write_all(tail.as_bytes()).await.unwrap();
+        slow.write_all(b".\r\n").await.unwrap();
+        let done = read_smtp_chunk(&mut slow, &mut buf).await;
+        assert!(done.contains("250 2.0.0 OK"), "got: {done}");
+        assert!(waiter.await.unwrap());
+        assert_eq!(ctx.drain.in_flight(), 0);
+
+        pool.close().await;
+        let paths = ctx.mailbox_store.maildir_for_user("u@test");
+        let n = std::fs::read_dir(&paths.new)
+            .map(|d| d.count())
+            .unwrap_or(0)
+            + std::fs::read_dir(&paths.cur)
+                .map(|d| d.count())
+                .unwrap_or(0);
+        assert_eq!(n, 1);
+    }
+
     #[tokio::test]
     async fn p4_inbound_federation_rejects_mail_from() {
         let pool = chatmail_db::init_memory_db().await.unwrap();
@@ -919,6 +1619,66 @@ mod tests {
         assert!(t.contains("554 5.7.1"), "got: {t}");
     }
 
+    /// RFC 6531/6152 inbound: a unicode local part needs `SMTPUTF8` on MAIL, then the 8-bit
+    /// message reaches the account's maildir unchanged.
+    #[tokio::test]
+    async fn inbound_smtputf8_delivers_unicode_local_part() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        chatmail_db::passwords::create_user(&pool, "j\u{f6}rg@test", "hash")
+            .await
+            .unwrap();
+        let ctx = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let body = std::str::from_utf8(PGP_MIME_BODY)
+            .unwrap()
+            .replace("sender@test", "sender@peer.test")
+            .replace("Subject: e", "Subject: Gr\u{fc}\u{df}e");
+        let t = smtp_dialog(
+            SmtpSessionConfig {
+                hostname: "mx.test".into(),
+                primary_domain: "test".into(),
+                local_domains: vec!["test".into()],
+                jit_domain: None,
+                credential_policy: CredentialPolicy::default(),
+                require_auth: false,
+                module: "smtp",
+                starttls_config: None,
+            },
+            pool,
+            ctx.clone(),
+            &[
+                "EHLO client.test",
+                "MAIL FROM:<sender@peer.test> BODY=BINARYMIME",
+                "MAIL FROM:<sender@peer.test>",
+                "RCPT TO:<J\u{f6}rg@test>",
+                "RSET",
+                "MAIL FROM:<sender@peer.test> SMTPUTF8 BODY=8BITMIME",
+                "RCPT TO:<J\u{f6}rg@test>",
+                "DATA",
+                &format!("DATA:{body}"),
+                ".DATA_END",
+                "QUIT",
+            ],
+        )
+        .await;
+        assert!(t.contains("250-8BITMIME\r\n"), "got: {t}");
+        assert!(t.contains("250-SMTPUTF8\r\n"), "got: {t}");
+        assert!(t.contains("501 5.5.4 Bad BODY"), "got: {t}");
+        assert!(t.contains("553 5.6.7"), "got: {t}");
+        assert_eq!(t.matches("250 2.1.5 OK").count(), 1, "got: {t}");
+        let paths = ctx.mailbox_store.maildir_for_user("j\u{f6}rg@test");
+        let stored: Vec<String> = std::fs::read_dir(&paths.new)
+            .unwrap()
+            .map(|e| std::fs::read_to_string(e.unwrap().path()).unwrap())
+            .collect();
+        assert_eq!(stored.len(), 1);
+        assert!(
+            stored[0].contains("Subject: Gr\u{fc}\u{df}e"),
+            "{}",
+            stored[0]
+        );
+    }
+
     #[tokio::test]
     async fn submission_silent_dismiss_accepts_without_local_delivery() {
         let dir = tempfile::tempdir().unwrap();
@@ -1003,7 +1763,7 @@ mod tests {
                 module: "submission",
                 starttls_config: None,
             },
-            pool,
+            pool.clone(),
             ctx.clone(),
             &[
                 "EHLO client.test",
@@ -1029,6 +1789,74 @@ mod tests {
             n_new + n_cur >= 1,
             "expected maildir message in new/ or cur/"
         );
+        // AUTH (login) and the local delivery both count as activity.
+        let activity = chatmail_db::list_last_activity(&pool).await.unwrap();
+        assert!(activity["u@test"] > 0);
+    }
+
+    #[tokio::test]
+    async fn submission_rate_limit_refuses_mail_and_rcpt_with_450() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = hash_password("secret").unwrap();
+        chatmail_db::passwords::create_user(&pool, "u@test", &hash)
+            .await
+            .unwrap();
+        let config = chatmail_config::AppConfig {
+            max_messages_per_hour: Some(1),
+            max_rcpt_per_message: Some(1),
+            ..Default::default()
+        };
+        let ctx = Arc::new(AppState::with_quota_and_message_limit(
+            dir.path(),
+            chatmail_config::DEFAULT_QUOTA_BYTES,
+            &config,
+            pool.clone(),
+        ));
+        let b64 = base64::engine::general_purpose::STANDARD.encode("\0u@test\0secret");
+        let auth = format!("AUTH PLAIN {b64}");
+        let body = std::str::from_utf8(PGP_MIME_BODY)
+            .unwrap()
+            .replace("sender@test", "u@test");
+        let t = smtp_dialog(
+            SmtpSessionConfig {
+                hostname: "mx.test".into(),
+                primary_domain: "test".into(),
+                local_domains: vec!["test".into()],
+                jit_domain: None,
+                credential_policy: CredentialPolicy::default(),
+                require_auth: true,
+                module: "submission",
+                starttls_config: None,
+            },
+            pool.clone(),
+            ctx,
+            &[
+                "EHLO client.test",
+                &auth,
+                "MAIL FROM:<u@test>",
+                "RCPT TO:<u@test>",
+                "RCPT TO:<other@test>",
+                "DATA",
+                &format!("DATA:{body}"),
+                ".DATA_END",
+                "MAIL FROM:<u@test>",
+                "QUIT",
+            ],
+        )
+        .await;
+        assert_eq!(t.matches("250 2.1.5 OK").count(), 1, "got: {t}");
+        assert!(t.contains("250 2.0.0 OK"), "got: {t}");
+        assert_eq!(
+            t.matches("450 4.7.1 Sending rate limit exceeded").count(),
+            2,
+            "got: {t}"
+        );
+        let hour = chatmail_db::sending_hour(chatmail_db::policies::unix_now());
+        let rows = chatmail_db::sending_stats_for(&pool, "u@test", hour)
+            .await
+            .unwrap();
+        assert_eq!((rows[0].messages, rows[0].recipients), (1, 1));
     }
 
     #[tokio::test]
@@ -1159,6 +1987,36 @@ mod tests {
         assert!(!t.contains("354"), "should not reach DATA, got: {t}");
     }
 
+    /// RFC 8689: REQUIRETLS is not offered in cleartext and is refused there.
+    #[tokio::test]
+    async fn mail_from_requiretls_rejected_without_tls() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState::new(std::env::temp_dir(), pool.clone()));
+        let t = smtp_dialog(
+            SmtpSessionConfig {
+                hostname: "mx.test".into(),
+                primary_domain: "test".into(),
+                local_domains: vec!["test".into()],
+                jit_domain: None,
+                credential_policy: CredentialPolicy::default(),
+                require_auth: false,
+                module: "smtp",
+                starttls_config: None,
+            },
+            pool,
+            ctx,
+            &[
+                "EHLO client.test",
+                "MAIL FROM:<a@peer.test> REQUIRETLS",
+                "RCPT TO:<b@test>",
+            ],
+        )
+        .await;
+        assert!(!t.contains("REQUIRETLS\r\n"), "cleartext EHLO: {t}");
+        assert!(t.contains("530 5.7.10"), "got: {t}");
+        assert!(t.contains("503 5.5.1 MAIL first"), "got: {t}");
+    }
+
     #[tokio::test]
     async fn inbound_silently_drops_unknown_local_user() {
         let dir = tempfile::tempdir().unwrap();
@@ -1321,9 +2179,12 @@ mod tests {
         let plain = s.format_ehlo(false);
         assert!(plain.contains("250-STARTTLS\r\n"));
         assert!(!plain.contains("AUTH PLAIN"));
+        assert!(!plain.contains("REQUIRETLS"));
         let tls = s.format_ehlo(true);
         assert!(!tls.contains("STARTTLS"));
         assert!(tls.contains("250-AUTH PLAIN\r\n"));
+        assert!(tls.contains("250-REQUIRETLS\r\n"));
+        assert!(plain.contains("250-8BITMIME\r\n") && plain.contains("250-SMTPUTF8\r\n"));
     }
 
     /// Inbound port 25: STARTTLS upgrade for federation clients (e.g. filtermail-transport).
@@ -1378,8 +2239,9 @@ mod tests {
         smtp_write(&mut tls, "EHLO client.test").await;
         let ehlo_tls = read_smtp_until(&mut tls, "250 OK").await;
         assert!(!ehlo_tls.contains("STARTTLS"), "post-TLS EHLO: {ehlo_tls}");
+        assert!(ehlo_tls.contains("REQUIRETLS"), "post-TLS EHLO: {ehlo_tls}");
 
-        smtp_write(&mut tls, "MAIL FROM:<sender@other.test>").await;
+        smtp_write(&mut tls, "MAIL FROM:<sender@other.test> REQUIRETLS").await;
         let mail_from = read_smtp_until(&mut tls, "250").await;
         assert!(mail_from.contains("250"), "MAIL FROM: {mail_from}");
     }
@@ -1498,4 +2360,74 @@ mod tests {
         let ehlo = read_smtp_until(&mut tls, "250 OK").await;
         assert!(ehlo.contains("AUTH PLAIN"), "EHLO: {ehlo}");
     }
+
+    async fn spawn_greet_delay_server(require_auth: bool) -> (std::net::SocketAddr, Arc<AppState>) {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let ctx = Arc::new(AppState {
+            greet: Arc::new(chatmail_state::GreetGuard::new(
+                Some(Duration::from_millis(300)),
+                Vec::new(),
+            )),
+            ..AppState::new(std::env::temp_dir(), pool.clone())
+        });
+        let cfg = SmtpSessionConfig {
+            hostname: "mx.test".into(),
+            primary_domain: "test".into(),
+            local_domains: vec!["test".into()],
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            require_auth,
+            module: if require_auth { "submission" } else { "smtp" },
+            starttls_config: None,
+        };
+
+        let std_listener = StdListener::bind("127.0.0.1:0").unwrap();
+        std_listener.set_nonblocking(true).unwrap();
+        let addr = std_listener.local_addr().unwrap();
+        let ctx_bg = Arc::clone(&ctx);
+        tokio::spawn(async move {
+            let listener = tokio::net::TcpListener::from_std(std_listener).unwrap();
+            let (stream, _) = listener.accept().await.unwrap();
+            let mut session = SmtpSession::new(ctx_bg, pool, cfg);
+            let _ = session.handle_connection(stream).await;
+        });
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        (addr, ctx)
+    }
+
+    #[tokio::test]
+    async fn greet_delay_rejects_early_talker() {
+        let (addr, ctx) = spawn_greet_delay_server(false).await;
+        let mut stream = TcpStream::connect(addr).await.unwrap();
+        smtp_write(&mut stream, "EHLO bot.test").await;
+        let reply = read_smtp_until(&mut stream, "554 ").await;
+        assert!(reply.starts_with("554 5.5.0"), "early talker: {reply}");
+        assert!(!reply.contains("220 "), "banner must not be sent: {reply}");
+        assert_eq!(ctx.greet.early_talkers(), 1);
+    }
+
+    #[tokio::test]
+    async fn greet_delay_admits_client_that_waits_for_banner() {
+        let (addr, ctx) = spawn_greet_delay_server(false).await;
+        let mut stream = TcpStream::connect(addr).await.unwrap();
+        let banner = read_smtp_until(&mut stream, "220 ").await;
+        assert!(banner.starts_with("220 mx.test"), "banner: {banner}");
+        smtp_write(&mut stream, "EHLO mta.test").await;
+        let ehlo = read_smtp_until(&mut stream, "250 OK").await;
+        assert!(ehlo.contains("250"), "EHLO: {ehlo}");
+        assert_eq!(ctx.greet.early_talkers(), 0);
+    }
+
+    #[tokio::test]
+    async fn greet_delay_does_not_apply_to_submission() {
+        let (addr, ctx) = spawn_greet_delay_server(true).await;
+        let mut stream = TcpStream::connect(addr).await.unwrap();
+        smtp_write(&mut stream, "EHLO client.test").await;
+        let reply = read_smtp_until(&mut stream, "250 OK").await;
+        assert!(
+            reply.starts_with("220 mx.test"),
+            "submission banner: {reply}"
+        );
+        assert_eq!(ctx.greet.early_talkers(), 0);
+    }
 }
@@ -0,0 +1,125 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-account last-activity tracking for `inactive_account_retention`.
+//!
+//! Logins (and inbound deliveries unless `activity_counts_inbound no`) bump
+//! `account_activity.last_activity_at`. Hot paths call [`ActivityTracker::touch`] on every
+//! event; the DB is written at most once per [`ACTIVITY_WRITE_INTERVAL`] per account.
+
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use chatmail_db::DbPool;
+use dashmap::DashMap;
+use tracing::debug;
+
+/// Hour resolution is plenty for retention measured in days.
+pub const ACTIVITY_WRITE_INTERVAL: Duration = Duration::from_secs(3600);
+
+#[derive(Debug)]
+pub struct ActivityTracker {
+    /// Last unix time written to the DB per account (process-local).
+    written: DashMap<String, i64>,
+    interval: i64,
+    counts_inbound: bool,
+}
+
+impl Default for ActivityTracker {
+    fn default() -> Self {
+        Self::new(true)
+    }
+}
+
+impl ActivityTracker {
+    pub fn new(counts_inbound: bool) -> Self {
+        Self {
+            written: DashMap::new(),
+            interval: ACTIVITY_WRITE_INTERVAL.as_secs() as i64,
+            counts_inbound,
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.activity_counts_inbound.unwrap_or(true))
+    }
+
+    /// Record a login or submission by `username`.
+    pub async fn touch(&self, pool: &DbPool, username: &str) {
+        self.touch_at(pool, username, chatmail_db::policies::unix_now())
+            .await;
+    }
+
+    /// Record mail delivered to `username`; no-op with `activity_counts_inbound no`.
+    pub async fn touch_inbound(&self, pool: &DbPool, username: &str) {
+        if self.counts_inbound {
+            self.touch(pool, username).await;
+        }
+    }
+
+    async fn touch_at(&self, pool: &DbPool, username: &str, now: i64) {
+        let key = username.to_ascii_lowercase();
+        if let Some(last) = self.written.get(&key) {
+            if now - *last < self.interval {
+                return;
+            }
+        }
+        self.written.insert(key.clone(), now);
+        if let Err(e) = chatmail_db::record_activity(pool, &key, now).await {
+            debug!(user = %key, "account activity write failed: {e}");
+            self.written.remove(&key);
+        }
+    }
+
+    /// Drop the throttle entry after the account is deleted.
+    pub fn forget(&self, username: &str) {
+        self.written.remove(&username.to_ascii_lowercase());
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_db::{init_memory_db, list_last_activity};
+
+    #[tokio::test]
+    async fn touch_writes_once_per_interval() {
+        let pool = init_memory_db().await.unwrap();
+        let tracker = ActivityTracker::new(true);
+        tracker.touch_at(&pool, "u@test", 1_000).await;
+        tracker.touch_at(&pool, "u@test", 1_500).await;
+        assert_eq!(list_last_activity(&pool).await.unwrap()["u@test"], 1_000);
+        tracker.touch_at(&pool, "u@test", 1_000 + 3_600).await;
+        assert_eq!(list_last_activity(&pool).await.unwrap()["u@test"], 4_600);
+    }
+
+    #[tokio::test]
+    async fn inbound_respects_config() {
+        let pool = init_memory_db().await.unwrap();
+        ActivityTracker::new(false)
+            .touch_inbound(&pool, "u@test")
+            .await;
+        assert!(list_last_activity(&pool).await.unwrap().is_empty());
+        ActivityTracker::new(true)
+            .touch_inbound(&pool, "u@test")
+            .await;
+        assert!(list_last_activity(&pool)
+            .await
+            .unwrap()
+            .contains_key("u@test"));
+    }
+}
@@ -0,0 +1,232 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Server-wide events for the admin dashboard's live stream (`GET <admin_path>/events`).
+//!
+//! Publishers (registration, the outbound queue, takeout jobs, bans, admin settings writes)
+//! call [`AdminEventBus::publish`]. Each event gets a monotonic id and is kept in a short
+//! replay buffer so a reconnecting client can resume from `Last-Event-ID`. Live delivery uses
+//! a bounded broadcast channel: a subscriber that falls [`SUBSCRIBER_BUFFER`] events behind
+//! sees `Lagged` and is disconnected instead of buffering without limit.
+
+use std::collections::VecDeque;
+use std::sync::Mutex;
+
+use chatmail_db::policies::unix_now;
+use serde_json::Value;
+use tokio::sync::broadcast;
+
+/// Events kept for `Last-Event-ID` resumption.
+pub const REPLAY_CAPACITY: usize = 256;
+
+/// Live events a subscriber may fall behind before it is dropped.
+pub const SUBSCRIBER_BUFFER: usize = 64;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum AdminEventKind {
+    AccountCreated,
+    DeliveryFailed,
+    JobProgress,
+    SettingChanged,
+    BanAdded,
+}
+
+impl AdminEventKind {
+    pub const ALL: [Self; 5] = [
+        Self::AccountCreated,
+        Self::DeliveryFailed,
+        Self::JobProgress,
+        Self::SettingChanged,
+        Self::BanAdded,
+    ];
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::AccountCreated => "account_created",
+            Self::DeliveryFailed => "delivery_failed",
+            Self::JobProgress => "job_progress",
+            Self::SettingChanged => "setting_changed",
+            Self::BanAdded => "ban_added",
+        }
+    }
+
+    pub fn parse(s: &str) -> Option<Self> {
+        Self::ALL.into_iter().find(|k| k.as_str() == s)
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct AdminEvent {
+    pub id: u64,
+    pub kind: AdminEventKind,
+    /// JSON object; always carries `at` (unix seconds).
+    pub data: Value,
+}
+
+/// Buffered events plus a live receiver, taken atomically so nothing falls in between.
+#[derive(Debug)]
+pub struct AdminEventSubscription {
+    pub replay: Vec<AdminEvent>,
+    pub live: broadcast::Receiver<AdminEvent>,
+    /// `Last-Event-ID` is older than the replay buffer (or from before a restart): the
+    /// client missed events and should reload its views.
+    pub gap: bool,
+}
+
+#[derive(Debug)]
+struct Replay {
+    next_id: u64,
+    events: VecDeque<AdminEvent>,
+}
+
+#[derive(Debug)]
+pub struct AdminEventBus {
+    replay: Mutex<Replay>,
+    live: broadcast::Sender<AdminEvent>,
+}
+
+impl Default for AdminEventBus {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl AdminEventBus {
+    pub fn new() -> Self {
+        Self {
+            replay: Mutex::new(Replay {
+                next_id: 1,
+                events: VecDeque::with_capacity(REPLAY_CAPACITY),
+            }),
+            live: broadcast::channel(SUBSCRIBER_BUFFER).0,
+        }
+    }
+
+    fn replay(&self) -> std::sync::MutexGuard<'_, Replay> {
+        self.replay.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// Record an event; never blocks on subscribers. Returns its id.
+    pub fn publish(&self, kind: AdminEventKind, data: Value) -> u64 {
+        let mut data = match data {
+            Value::Object(map) => map,
+            other => {
+                let mut map = serde_json::Map::new();
+                map.insert("value".into(), other);
+                map
+            }
+        };
+        data.insert("at".into(), unix_now().into());
+        let mut replay = self.replay();
+        let event = AdminEvent {
+            id: replay.next_id,
+            kind,
+            data: Value::Object(data),
+        };
+        replay.next_id += 1;
+        if replay.events.len() == REPLAY_CAPACITY {
+            replay.events.pop_front();
+        }
+        replay.events.push_back(event.clone());
+        // No receivers is the normal case when no dashboard is open.
+        let _ = self.live.send(event);
+        replay.next_id - 1
+    }
+
+    /// Subscribe, replaying buffered events after `last_event_id` (none without one).
+    pub fn subscribe(&self, last_event_id: Option<u64>) -> AdminEventSubscription {
+        let replay = self.replay();
+        let live = self.live.subscribe();
+        let Some(last) = last_event_id else {
+            return AdminEventSubscription {
+                replay: Vec::new(),
+                live,
+                gap: false,
+            };
+        };
+        let oldest = replay.events.front().map_or(replay.next_id, |e| e.id);
+        let gap = last + 1 < oldest || last >= replay.next_id;
+        let events = replay
+            .events
+            .iter()
+            .filter(|e| e.id > last)
+            .cloned()
+            .collect();
+        AdminEventSubscription {
+            replay: events,
+            live,
+            gap,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::*;
+
+    #[test]
+    fn kinds_round_trip() {
+        for kind in AdminEventKind::ALL {
+            assert_eq!(AdminEventKind::parse(kind.as_str()), Some(kind));
+        }
+        assert_eq!(AdminEventKind::parse("bogus"), None);
+    }
+
+    #[test]
+    fn resumes_after_last_event_id() {
+        let bus = AdminEventBus::new();
+        for i in 0..5 {
+            bus.publish(AdminEventKind::BanAdded, json!({ "n": i }));
+        }
+        let sub = bus.subscribe(Some(3));
+        assert!(!sub.gap);
+        let ids: Vec<u64> = sub.replay.iter().map(|e| e.id).collect();
+        assert_eq!(ids, vec![4, 5]);
+        assert_eq!(sub.replay[0].data["n"], 3);
+        assert!(sub.replay[0].data["at"].as_i64().unwrap() > 0);
+
+        assert!(bus.subscribe(None).replay.is_empty());
+        assert!(bus.subscribe(Some(5)).replay.is_empty());
+        assert!(bus.subscribe(Some(42)).gap, "id from before a restart");
+    }
+
+    #[test]
+    fn gap_when_replay_buffer_overflowed() {
+        let bus = AdminEventBus::new();
+        for _ in 0..REPLAY_CAPACITY + 10 {
+            bus.publish(AdminEventKind::JobProgress, json!({}));
+        }
+        let sub = bus.subscribe(Some(5));
+        assert!(sub.gap);
+        assert_eq!(sub.replay.len(), REPLAY_CAPACITY);
+        assert!(!bus.subscribe(Some(10)).gap);
+    }
+
+    #[tokio::test]
+    async fn live_events_follow_replay() {
+        let bus = AdminEventBus::new();
+        bus.publish(AdminEventKind::AccountCreated, json!({ "username": "a@x" }));
+        let mut sub = bus.subscribe(Some(0));
+        bus.publish(AdminEventKind::AccountCreated, json!({ "username": "b@x" }));
+        assert_eq!(sub.replay.len(), 1);
+        let live = sub.live.recv().await.unwrap();
+        assert_eq!(live.id, 2);
+        assert_eq!(live.data["username"], "b@x");
+    }
+}
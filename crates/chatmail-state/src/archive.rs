@@ -0,0 +1,294 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Outbound archive for authenticated submission (`submission { archive ... }`).
+//!
+//! Every accepted outbound message from an in-scope sender is copied, with an
+//! `X-Archived-For` header recording the envelope, to one destination: a local mailbox, a
+//! maildir on disk, or a remote SMTP recipient. The copy never changes what the recipients
+//! get. This module holds the parsed settings; `chatmail-delivery` writes the copies.
+
+use std::path::PathBuf;
+
+use chatmail_config::AppConfig;
+use tracing::warn;
+
+/// Header prepended to archived copies; client-supplied instances are stripped.
+pub const ARCHIVED_FOR_HEADER: &str = "X-Archived-For";
+
+/// Where archived copies go.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum ArchiveTarget {
+    /// `user@domain` on this server, written straight into its INBOX.
+    Mailbox(String),
+    /// `maildir:/path`: one file per copy in `new/`.
+    Maildir(PathBuf),
+    /// `smtp://rcpt@host[:port]`: plain SMTP (STARTTLS when offered) to `host:port`.
+    Smtp { rcpt: String, endpoint: String },
+}
+
+impl ArchiveTarget {
+    pub fn parse(raw: &str) -> std::result::Result<Self, String> {
+        let raw = raw.trim();
+        if let Some(path) = raw.strip_prefix("maildir:") {
+            if path.is_empty() || !path.starts_with('/') {
+                return Err(format!("maildir path must be absolute: {raw}"));
+            }
+            return Ok(Self::Maildir(PathBuf::from(path)));
+        }
+        if let Some(rest) = raw.strip_prefix("smtp://") {
+            let (user, hostport) = rest
+                .rsplit_once('@')
+                .filter(|(u, h)| !u.is_empty() && !h.is_empty())
+                .ok_or_else(|| format!("smtp target needs rcpt@host: {raw}"))?;
+            let (host, endpoint) = match hostport.rsplit_once(':') {
+                Some((h, p)) if !hostport.ends_with(']') => {
+                    p.parse::<u16>()
+                        .map_err(|_| format!("bad smtp port in {raw}"))?;
+                    (h, hostport.to_string())
+                }
+                _ => (hostport, format!("{hostport}:25")),
+            };
+            return Ok(Self::Smtp {
+                rcpt: format!("{user}@{host}").to_ascii_lowercase(),
+                endpoint,
+            });
+        }
+        match raw.split_once('@') {
+            Some((u, d)) if !u.is_empty() && !d.is_empty() && !raw.contains(['/', ' ']) => {
+                Ok(Self::Mailbox(raw.to_ascii_lowercase()))
+            }
+            _ => Err(format!("unrecognised archive target: {raw}")),
+        }
+    }
+}
+
+#[derive(Debug, Default)]
+pub struct OutboundArchive {
+    /// `None` when archiving is off; `Some(Err)` keeps a bad target so every submission
+    /// fails the way `archive_fail_closed` says instead of silently skipping the copy.
+    target: Option<std::result::Result<ArchiveTarget, String>>,
+    fail_closed: bool,
+    /// Lowercased addresses and bare domains; empty archives every sender.
+    senders: Vec<String>,
+}
+
+impl OutboundArchive {
+    pub fn new(target: Option<&str>, fail_closed: bool, senders: Option<&str>) -> Self {
+        let target = target.filter(|t| !t.trim().is_empty()).map(|t| {
+            let parsed = ArchiveTarget::parse(t);
+            if let Err(e) = &parsed {
+                warn!(error = %e, "submission archive target invalid; every copy will fail");
+            }
+            parsed
+        });
+        let senders = senders
+            .unwrap_or("")
+            .split(|c: char| c == ',' || c.is_whitespace())
+            .filter(|s| !s.is_empty())
+            .map(|s| s.trim_start_matches('@').to_ascii_lowercase())
+            .collect();
+        Self {
+            target,
+            fail_closed,
+            senders,
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(
+            config.archive.as_deref(),
+            config.archive_fail_closed.unwrap_or(false),
+            config.archive_senders.as_deref(),
+        )
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.target.is_some()
+    }
+
+    pub fn target(&self) -> Option<std::result::Result<&ArchiveTarget, &str>> {
+        self.target
+            .as_ref()
+            .map(|t| t.as_ref().map_err(String::as_str))
+    }
+
+    pub fn fail_closed(&self) -> bool {
+        self.fail_closed
+    }
+
+    /// Whether mail from `sender` is copied. The local archive mailbox itself is never in
+    /// scope, so mail sent from it cannot feed back into it.
+    pub fn applies_to(&self, sender: &str) -> bool {
+        if !self.enabled() {
+            return false;
+        }
+        let sender = sender.to_ascii_lowercase();
+        if let Some(Ok(ArchiveTarget::Mailbox(mailbox))) = &self.target {
+            if *mailbox == sender {
+                return false;
+            }
+        }
+        if self.senders.is_empty() {
+            return true;
+        }
+        let domain = sender.rsplit_once('@').map(|(_, d)| d).unwrap_or("");
+        self.senders
+            .iter()
+            .any(|s| *s == sender || (!s.contains('@') && *s == domain))
+    }
+}
+
+/// The archived copy of `data`: an `X-Archived-For` header with the envelope, followed by
+/// the message minus any `X-Archived-For` the client put there itself.
+pub fn archived_copy(mail_from: &str, rcpts: &[String], data: &[u8]) -> Vec<u8> {
+    let mut out = Vec::with_capacity(data.len() + 64 + rcpts.len() * 32);
+    out.extend_from_slice(format!("{ARCHIVED_FOR_HEADER}: from=<{mail_from}>").as_bytes());
+    for (i, rcpt) in rcpts.iter().enumerate() {
+        let sep = if i == 0 { ";\r\n rcpt=" } else { ",\r\n " };
+        out.extend_from_slice(format!("{sep}<{rcpt}>").as_bytes());
+    }
+    out.extend_from_slice(b"\r\n");
+
+    let body_start = header_end(data);
+    let mut skipping = false;
+    let mut pos = 0;
+    while pos < body_start {
+        let end = data[pos..body_start]
+            .iter()
+            .position(|&b| b == b'\n')
+            .map(|i| pos + i + 1)
+            .unwrap_or(body_start);
+        let line = &data[pos..end];
+        let continuation = matches!(line.first(), Some(b' ' | b'\t'));
+        if !continuation {
+            skipping = is_archived_for(line);
+        }
+        if !skipping {
+            out.extend_from_slice(line);
+        }
+        pos = end;
+    }
+    out.extend_from_slice(&data[body_start..]);
+    out
+}
+
+/// Offset of the blank line ending the header block (or `data.len()` without one).
+fn header_end(data: &[u8]) -> usize {
+    let mut pos = 0;
+    while pos < data.len() {
+        let end = data[pos..]
+            .iter()
+            .position(|&b| b == b'\n')
+            .map(|i| pos + i + 1)
+            .unwrap_or(data.len());
+        let line = &data[pos..end];
+        if line == b"\r\n" || line == b"\n" {
+            return pos;
+        }
+        pos = end;
+    }
+    data.len()
+}
+
+fn is_archived_for(line: &[u8]) -> bool {
+    let name = ARCHIVED_FOR_HEADER.as_bytes();
+    line.len() > name.len() && line[..name.len()].eq_ignore_ascii_case(name) && {
+        let rest = &line[name.len()..];
+        let colon = rest.iter().position(|&b| b != b' ' && b != b'\t');
+        colon.is_some_and(|i| rest[i] == b':')
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_targets() {
+        assert_eq!(
+            ArchiveTarget::parse("Archive@Example.org").unwrap(),
+            ArchiveTarget::Mailbox("archive@example.org".into())
+        );
+        assert_eq!(
+            ArchiveTarget::parse("maildir:/var/archive").unwrap(),
+            ArchiveTarget::Maildir("/var/archive".into())
+        );
+        assert_eq!(
+            ArchiveTarget::parse("smtp://journal@vault.example:2525").unwrap(),
+            ArchiveTarget::Smtp {
+                rcpt: "journal@vault.example".into(),
+                endpoint: "vault.example:2525".into(),
+            }
+        );
+        assert_eq!(
+            ArchiveTarget::parse("smtp://journal@vault.example").unwrap(),
+            ArchiveTarget::Smtp {
+                rcpt: "journal@vault.example".into(),
+                endpoint: "vault.example:25".into(),
+            }
+        );
+        assert!(ArchiveTarget::parse("maildir:relative").is_err());
+        assert!(ArchiveTarget::parse("smtp://vault.example").is_err());
+        assert!(ArchiveTarget::parse("smtp://j@vault.example:smtp").is_err());
+        assert!(ArchiveTarget::parse("nowhere").is_err());
+    }
+
+    #[test]
+    fn scopes_by_sender_and_domain() {
+        let all = OutboundArchive::new(Some("archive@example.org"), false, None);
+        assert!(all.applies_to("a@example.org"));
+        assert!(
+            !all.applies_to("Archive@example.org"),
+            "no loop via archive box"
+        );
+
+        let scoped = OutboundArchive::new(
+            Some("archive@example.org"),
+            false,
+            Some("boss@example.org, @example.net"),
+        );
+        assert!(scoped.applies_to("BOSS@example.org"));
+        assert!(!scoped.applies_to("intern@example.org"));
+        assert!(scoped.applies_to("anyone@example.net"));
+        assert!(!scoped.applies_to("anyone@sub.example.net"));
+
+        assert!(!OutboundArchive::default().applies_to("a@example.org"));
+    }
+
+    #[test]
+    fn bad_target_stays_enabled() {
+        let archive = OutboundArchive::new(Some("nowhere"), true, None);
+        assert!(archive.enabled());
+        assert!(archive.applies_to("a@example.org"));
+        assert!(matches!(archive.target(), Some(Err(_))));
+    }
+
+    #[test]
+    fn archived_copy_records_envelope_and_strips_forgeries() {
+        let data = b"From: a@example.org\r\nX-Archived-For: from=<forged>;\r\n rcpt=<x>\r\n\
+Subject: hi\r\n\r\nX-Archived-For: body text stays\r\n";
+        let rcpts = vec!["b@example.org".to_string(), "c@other.test".to_string()];
+        let copy = archived_copy("a@example.org", &rcpts, data);
+        let copy = std::str::from_utf8(&copy).unwrap();
+        assert_eq!(
+            copy,
+            "X-Archived-For: from=<a@example.org>;\r\n rcpt=<b@example.org>,\r\n <c@other.test>\r\n\
+From: a@example.org\r\nSubject: hi\r\n\r\nX-Archived-For: body text stays\r\n"
+        );
+    }
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Hourly pruning of the admin audit trail (`admin_audit`) down to `audit_retention`.
+
+use std::time::Duration;
+
+use chatmail_db::DbPool;
+use tokio::task::JoinHandle;
+
+/// How often rows past `audit_retention` are deleted.
+pub const ADMIN_AUDIT_PRUNE_INTERVAL: Duration = Duration::from_secs(3600);
+
+/// Delete audit rows older than `retention` now and every [`ADMIN_AUDIT_PRUNE_INTERVAL`].
+pub fn start_admin_audit_prune(pool: DbPool, retention: Duration) -> JoinHandle<()> {
+    let age = i64::try_from(retention.as_secs()).unwrap_or(i64::MAX);
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(ADMIN_AUDIT_PRUNE_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            let before = chatmail_db::policies::unix_now().saturating_sub(age);
+            match chatmail_db::prune_admin_audit(&pool, before).await {
+                Ok(0) => {}
+                Ok(deleted) => tracing::debug!(deleted, "admin audit pruned"),
+                Err(e) => tracing::warn!(error = %e, "admin audit prune failed"),
+            }
+        }
+    })
+}
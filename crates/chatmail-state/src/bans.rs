@@ -0,0 +1,602 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! IP / network and TLS fingerprint bans: the in-memory matcher every listener consults
+//! before doing any work, and the counters that turn login failures and registration bursts
+//! into automatic bans.
+//!
+//! The `bans` table is the source of truth. [`IpBans::refresh`] rebuilds a [`BanMatcher`]
+//! from the active rows after every change made through the server and on a 30 s timer, so
+//! bans written by `madmail ban` while the server runs apply without a reload.
+
+use std::collections::{BTreeMap, HashMap, VecDeque};
+use std::net::IpAddr;
+use std::sync::{Arc, RwLock};
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use chatmail_db::bans::{Ban, BAN_FINGERPRINT_PREFIX, BAN_SOURCE_AUTH, BAN_SOURCE_REGISTRATION};
+use chatmail_db::policies::unix_now;
+use chatmail_db::DbPool;
+use chatmail_types::Result;
+use dashmap::DashMap;
+use tokio::task::JoinHandle;
+
+use crate::client_ip::{parse_cidr_list, IpNet};
+
+pub const DEFAULT_BAN_AUTH_FAILURES: u32 = 20;
+pub const DEFAULT_BAN_REGISTRATIONS: u32 = 30;
+pub const DEFAULT_BAN_WINDOW: Duration = Duration::from_secs(600);
+pub const DEFAULT_BAN_DURATION: Duration = Duration::from_secs(900);
+pub const DEFAULT_BAN_MAX_DURATION: Duration = Duration::from_secs(7 * 24 * 3600);
+/// Expired bans (and their strike count) are forgotten this long after expiry.
+pub const BAN_STRIKE_MEMORY: Duration = Duration::from_secs(7 * 24 * 3600);
+/// How often the matcher is rebuilt from the database.
+pub const BAN_REFRESH_INTERVAL: Duration = Duration::from_secs(30);
+/// Counter entries kept before idle ones are dropped.
+const MAX_TRACKED_IPS: usize = 100_000;
+
+/// Maps addresses to the expiry of the longest ban covering them.
+///
+/// Ranges from all rows are flattened into disjoint, sorted segments (one list per address
+/// family), so a lookup is a binary search regardless of how many bans overlap. `ja4:` rows
+/// go to a separate map keyed by the bare fingerprint.
+#[derive(Debug, Default, Clone, PartialEq, Eq)]
+pub struct BanMatcher {
+    v4: Vec<Segment>,
+    v6: Vec<Segment>,
+    fingerprints: HashMap<String, i64>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Segment {
+    start: u128,
+    end: u128,
+    /// Unix seconds; `i64::MAX` = permanent.
+    expires_at: i64,
+}
+
+impl BanMatcher {
+    /// Build from `(cidr, expires_at)` pairs (`expires_at = 0` = permanent); `cidr` may also
+    /// be a `ja4:` fingerprint. Entries that do not parse are skipped.
+    pub fn build<'a>(entries: impl IntoIterator<Item = (&'a str, i64)>) -> Self {
+        let mut v4 = Vec::new();
+        let mut v6 = Vec::new();
+        let mut fingerprints: HashMap<String, i64> = HashMap::new();
+        for (cidr, expires_at) in entries {
+            let expires_at = if expires_at == 0 {
+                i64::MAX
+            } else {
+                expires_at
+            };
+            if let Some(fp) = cidr.strip_prefix(BAN_FINGERPRINT_PREFIX) {
+                let until = fingerprints.entry(fp.to_string()).or_default();
+                *until = (*until).max(expires_at);
+                continue;
+            }
+            let Some(net) = IpNet::parse(cidr) else {
+                continue;
+            };
+            let (start, end) = net.bounds();
+            if net.is_ipv4() {
+                v4.push((start, end, expires_at));
+            } else {
+                v6.push((start, end, expires_at));
+            }
+        }
+        Self {
+            v4: flatten(v4),
+            v6: flatten(v6),
+            fingerprints,
+        }
+    }
+
+    pub fn from_bans(bans: &[Ban]) -> Self {
+        Self::build(bans.iter().map(|b| (b.cidr.as_str(), b.expires_at)))
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.v4.is_empty() && self.v6.is_empty() && self.fingerprints.is_empty()
+    }
+
+    /// Expiry of the longest ban on `ip` still in force at `now` (`i64::MAX` = permanent).
+    pub fn banned_until(&self, ip: IpAddr, now: i64) -> Option<i64> {
+        let (segments, key) = match canonical(ip) {
+            IpAddr::V4(v4) => (&self.v4, u32::from(v4) as u128),
+            IpAddr::V6(v6) => (&self.v6, u128::from(v6)),
+        };
+        let idx = segments.partition_point(|s| s.start <= key);
+        let seg = segments.get(idx.checked_sub(1)?)?;
+        (key <= seg.end && seg.expires_at > now).then_some(seg.expires_at)
+    }
+
+    pub fn is_banned(&self, ip: IpAddr, now: i64) -> bool {
+        self.banned_until(ip, now).is_some()
+    }
+
+    /// Like [`Self::banned_until`] for a JA4 TLS fingerprint (without the `ja4:` prefix).
+    pub fn fingerprint_banned_until(&self, fingerprint: &str, now: i64) -> Option<i64> {
+        let until = *self.fingerprints.get(fingerprint)?;
+        (until > now).then_some(until)
+    }
+}
+
+/// Sweep over range boundaries keeping a multiset of the expiries in force; each stretch
+/// between boundaries takes the largest. Neighbouring stretches with equal expiry merge.
+fn flatten(ranges: Vec<(u128, u128, i64)>) -> Vec<Segment> {
+    // (position, is_start, expires_at); ends sort before starts at the same position.
+    let mut events: Vec<(u128, bool, i64)> = Vec::with_capacity(ranges.len() * 2);
+    for (start, end, expires_at) in ranges {
+        events.push((start, true, expires_at));
+        if let Some(after) = end.checked_add(1) {
+            events.push((after, false, expires_at));
+        }
+    }
+    events.sort_unstable();
+
+    let mut active: BTreeMap<i64, usize> = BTreeMap::new();
+    let mut out: Vec<Segment> = Vec::new();
+    let mut i = 0;
+    while i < events.len() {
+        let pos = events[i].0;
+        while i < events.len() && events[i].0 == pos {
+            let (_, is_start, expires_at) = events[i];
+            if is_start {
+                *active.entry(expires_at).or_default() += 1;
+            } else if let Some(n) = active.get_mut(&expires_at) {
+                *n -= 1;
+                if *n == 0 {
+                    active.remove(&expires_at);
+                }
+            }
+            i += 1;
+        }
+        let Some((&expires_at, _)) = active.last_key_value() else {
+            continue;
+        };
+        let end = events.get(i).map_or(u128::MAX, |e| e.0 - 1);
+        match out.last_mut() {
+            Some(prev) if prev.expires_at == expires_at && prev.end.checked_add(1) == Some(pos) => {
+                prev.end = end;
+            }
+            _ => out.push(Segment {
+                start: pos,
+                end,
+                expires_at,
+            }),
+        }
+    }
+    out
+}
+
+fn canonical(ip: IpAddr) -> IpAddr {
+    match ip {
+        IpAddr::V6(v6) => v6.to_ipv4_mapped().map(IpAddr::V4).unwrap_or(ip),
+        v4 => v4,
+    }
+}
+
+/// Events that count toward an automatic ban.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum BanTrigger {
+    /// Failed IMAP `LOGIN`/`AUTHENTICATE` or SMTP `AUTH`.
+    AuthFailure,
+    /// Account created through `/new`.
+    Registration,
+}
+
+impl BanTrigger {
+    pub fn source(self) -> &'static str {
+        match self {
+            BanTrigger::AuthFailure => BAN_SOURCE_AUTH,
+            BanTrigger::Registration => BAN_SOURCE_REGISTRATION,
+        }
+    }
+}
+
+/// `ip_ban*` directives with defaults applied.
+#[derive(Debug, Clone)]
+pub struct BanSettings {
+    pub enabled: bool,
+    pub auth_failures: u32,
+    pub registrations: u32,
+    pub window: Duration,
+    pub duration: Duration,
+    pub max_duration: Duration,
+    /// `trusted_networks`; loopback is always exempt on top of these.
+    pub exempt: Vec<IpNet>,
+}
+
+impl Default for BanSettings {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            auth_failures: DEFAULT_BAN_AUTH_FAILURES,
+            registrations: DEFAULT_BAN_REGISTRATIONS,
+            window: DEFAULT_BAN_WINDOW,
+            duration: DEFAULT_BAN_DURATION,
+            max_duration: DEFAULT_BAN_MAX_DURATION,
+            exempt: Vec::new(),
+        }
+    }
+}
+
+impl BanSettings {
+    pub fn from_config(config: &AppConfig) -> Self {
+        let d = Self::default();
+        Self {
+            enabled: config.ip_ban.unwrap_or(d.enabled),
+            auth_failures: config.ip_ban_auth_failures.unwrap_or(d.auth_failures),
+            registrations: config.ip_ban_registrations.unwrap_or(d.registrations),
+            window: config.ip_ban_window.unwrap_or(d.window),
+            duration: config.ip_ban_duration.unwrap_or(d.duration),
+            max_duration: config.ip_ban_max_duration.unwrap_or(d.max_duration),
+            exempt: config
+                .trusted_networks
+                .as_deref()
+                .map(parse_cidr_list)
+                .unwrap_or_default(),
+        }
+    }
+
+    fn threshold(&self, trigger: BanTrigger) -> u32 {
+        match trigger {
+            BanTrigger::AuthFailure => self.auth_failures,
+            BanTrigger::Registration => self.registrations,
+        }
+    }
+}
+
+/// Active-ban matcher plus per-IP sliding-window counters for automatic bans.
+#[derive(Debug, Default)]
+pub struct IpBans {
+    settings: BanSettings,
+    matcher: RwLock<Arc<BanMatcher>>,
+    events: DashMap<(IpAddr, BanTrigger), VecDeque<i64>>,
+}
+
+impl IpBans {
+    pub fn new(settings: BanSettings) -> Self {
+        Self {
+            settings,
+            matcher: RwLock::new(Arc::new(BanMatcher::default())),
+            events: DashMap::new(),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(BanSettings::from_config(config))
+    }
+
+    pub fn settings(&self) -> &BanSettings {
+        &self.settings
+    }
+
+    /// Whether connections from `ip` must be refused now.
+    pub fn is_banned(&self, ip: IpAddr) -> bool {
+        self.matcher().is_banned(ip, unix_now())
+    }
+
+    /// Whether TLS clients with this JA4 fingerprint must be refused now.
+    pub fn is_fingerprint_banned(&self, fingerprint: &str) -> bool {
+        self.matcher()
+            .fingerprint_banned_until(fingerprint, unix_now())
+            .is_some()
+    }
+
+    pub fn matcher(&self) -> Arc<BanMatcher> {
+        Arc::clone(&self.matcher.read().unwrap_or_else(|e| e.into_inner()))
+    }
+
+    pub fn replace(&self, matcher: BanMatcher) {
+        *self.matcher.write().unwrap_or_else(|e| e.into_inner()) = Arc::new(matcher);
+    }
+
+    /// Rebuild the matcher from the active rows of `bans`.
+    pub async fn refresh(&self, pool: &DbPool) -> Result<()> {
+        let bans = chatmail_db::list_bans(pool, false).await?;
+        self.replace(BanMatcher::from_bans(&bans));
+        Ok(())
+    }
+
+    /// Never banned automatically: loopback and `trusted_networks`.
+    pub fn is_exempt(&self, ip: IpAddr) -> bool {
+        canonical(ip).is_loopback() || self.settings.exempt.iter().any(|n| n.contains(ip))
+    }
+
+    /// Count one `trigger` event from `ip` at `now`. Returns `true` when this event reaches
+    /// the threshold; the counter then starts over.
+    pub fn record_at(&self, trigger: BanTrigger, ip: IpAddr, now: i64) -> bool {
+        let threshold = self.settings.threshold(trigger);
+        if !self.settings.enabled || threshold == 0 || self.is_exempt(ip) {
+            return false;
+        }
+        let window = self.settings.window.as_secs() as i64;
+        if self.events.len() >= MAX_TRACKED_IPS {
+            self.events
+                .retain(|_, q| q.back().is_some_and(|&t| now - t < window));
+        }
+        let mut q = self.events.entry((canonical(ip), trigger)).or_default();
+        while q.front().is_some_and(|&t| now - t >= window) {
+            q.pop_front();
+        }
+        q.push_back(now);
+        if q.len() < threshold as usize {
+            return false;
+        }
+        q.clear();
+        true
+    }
+
+    /// Count an event and, at the threshold, write an escalating ban for the single address
+    /// and apply it at once, returning the stored ban. Storage errors are logged; the caller's
+    /// request goes on.
+    pub async fn note(&self, pool: &DbPool, trigger: BanTrigger, ip: IpAddr) -> Option<Ban> {
+        if !self.record_at(trigger, ip, unix_now()) {
+            return None;
+        }
+        let ip = canonical(ip);
+        let reason = match trigger {
+            BanTrigger::AuthFailure => format!(
+                "{} failed logins in {}s",
+                self.settings.auth_failures,
+                self.settings.window.as_secs()
+            ),
+            BanTrigger::Registration => format!(
+                "{} registrations in {}s",
+                self.settings.registrations,
+                self.settings.window.as_secs()
+            ),
+        };
+        let ban = match chatmail_db::escalate_ban(
+            pool,
+            &ip.to_string(),
+            trigger.source(),
+            &reason,
+            self.settings.duration.as_secs() as i64,
+            self.settings.max_duration.as_secs() as i64,
+        )
+        .await
+        {
+            Ok(ban) => ban,
+            Err(e) => {
+                tracing::warn!(%ip, error = %e, "automatic ban not stored");
+                return None;
+            }
+        };
+        tracing::warn!(
+            %ip,
+            source = trigger.source(),
+            strikes = ban.strikes,
+            expires_at = ban.expires_at,
+            "IP banned automatically"
+        );
+        if let Err(e) = chatmail_db::record_ban_audit(
+            pool,
+            "auto",
+            &ban.cidr,
+            trigger.source(),
+            "server",
+            &reason,
+        )
+        .await
+        {
+            tracing::warn!(error = %e, "ban audit write failed");
+        }
+        if let Err(e) = self.refresh(pool).await {
+            tracing::warn!(error = %e, "ban list refresh failed");
+        }
+        Some(ban)
+    }
+}
+
+/// Re-read `bans` every [`BAN_REFRESH_INTERVAL`] (picks up CLI edits) and prune rows past
+/// [`BAN_STRIKE_MEMORY`].
+pub fn start_ban_refresh(bans: Arc<IpBans>, pool: DbPool) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(BAN_REFRESH_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            if let Err(e) = chatmail_db::prune_bans(&pool, BAN_STRIKE_MEMORY.as_secs() as i64).await
+            {
+                tracing::warn!(error = %e, "ban prune failed");
+            }
+            if let Err(e) = bans.refresh(&pool).await {
+                tracing::warn!(error = %e, "ban list refresh failed");
+            }
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn ip(s: &str) -> IpAddr {
+        s.parse().unwrap()
+    }
+
+    #[test]
+    fn matcher_table() {
+        const NOW: i64 = 1_000;
+        let m = BanMatcher::build([
+            ("10.0.0.0/8", 2_000),
+            ("10.1.0.0/16", 0),
+            ("10.1.2.3", 500),
+            ("192.0.2.0/24", 1_500),
+            ("192.0.2.128/25", 3_000),
+            ("198.51.100.7/32", 900),
+            ("2001:db8::/32", 0),
+            ("::/0", 1_200),
+            ("not-an-ip", 0),
+        ]);
+        let table: &[(&str, Option<i64>)] = &[
+            ("10.2.3.4", Some(2_000)),
+            ("10.1.2.3", Some(i64::MAX)),
+            ("10.1.255.255", Some(i64::MAX)),
+            ("10.255.255.255", Some(2_000)),
+            ("11.0.0.0", None),
+            ("9.255.255.255", None),
+            ("192.0.2.1", Some(1_500)),
+            ("192.0.2.127", Some(1_500)),
+            ("192.0.2.128", Some(3_000)),
+            ("192.0.2.255", Some(3_000)),
+            ("192.0.3.0", None),
+            // Expired host ban.
+            ("198.51.100.7", None),
+            ("::ffff:10.9.9.9", Some(2_000)),
+            ("2001:db8::1", Some(i64::MAX)),
+            ("2001:db9::1", Some(1_200)),
+            ("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", Some(1_200)),
+            ("0.0.0.0", None),
+        ];
+        for (addr, want) in table {
+            assert_eq!(m.banned_until(ip(addr), NOW), *want, "{addr}");
+        }
+        assert!(!m.is_banned(ip("10.2.3.4"), 2_000));
+        assert!(m.is_banned(ip("10.1.0.1"), i64::MAX - 1));
+    }
+
+    #[test]
+    fn matcher_keeps_fingerprints_apart() {
+        const FP: &str = "t13d1516h2_8daaf6152771_e5627efa2ab1";
+        let m = BanMatcher::build([
+            ("ja4:t13d1516h2_8daaf6152771_e5627efa2ab1", 1_500),
+            ("ja4:t13d1516h2_8daaf6152771_e5627efa2ab1", 800),
+            ("ja4:t12i030400_e854b2f3a2d0_91e0de064512", 900),
+            ("10.0.0.0/8", 0),
+        ]);
+        assert_eq!(m.fingerprint_banned_until(FP, 1_000), Some(1_500));
+        assert_eq!(
+            m.fingerprint_banned_until("t12i030400_e854b2f3a2d0_91e0de064512", 1_000),
+            None
+        );
+        assert_eq!(m.fingerprint_banned_until("10.0.0.0/8", 1_000), None);
+        assert!(m.is_banned(ip("10.1.1.1"), 1_000));
+        assert!(!BanMatcher::build([("ja4:x", 0)]).is_empty());
+    }
+
+    #[test]
+    fn matcher_merges_equal_neighbours() {
+        let m = BanMatcher::build([("10.0.0.0/25", 0), ("10.0.0.128/25", 0), ("10.0.0.5", 0)]);
+        assert_eq!(m.v4.len(), 1);
+        assert_eq!(m.v4[0].end - m.v4[0].start, 255);
+        assert!(BanMatcher::build([]).is_empty());
+    }
+
+    fn bans(threshold: u32, exempt: &str) -> IpBans {
+        IpBans::new(BanSettings {
+            auth_failures: threshold,
+            registrations: threshold,
+            window: Duration::from_secs(60),
+            exempt: parse_cidr_list(exempt),
+            ..BanSettings::default()
+        })
+    }
+
+    #[test]
+    fn threshold_within_window() {
+        let b = bans(3, "");
+        let peer = ip("203.0.113.9");
+        assert!(!b.record_at(BanTrigger::AuthFailure, peer, 0));
+        assert!(!b.record_at(BanTrigger::AuthFailure, peer, 10));
+        // Registrations are counted separately.
+        assert!(!b.record_at(BanTrigger::Registration, peer, 11));
+        // First event slid out of the window.
+        assert!(!b.record_at(BanTrigger::AuthFailure, peer, 65));
+        assert!(b.record_at(BanTrigger::AuthFailure, peer, 66));
+        // Counter starts over after a ban.
+        assert!(!b.record_at(BanTrigger::AuthFailure, peer, 67));
+    }
+
+    #[test]
+    fn exempt_and_disabled_never_trigger() {
+        let b = bans(1, "10.0.0.0/8, 2001:db8::/32");
+        for addr in [
+            "10.1.1.1",
+            "2001:db8::5",
+            "127.0.0.1",
+            "::1",
+            "::ffff:127.0.0.1",
+        ] {
+            assert!(!b.record_at(BanTrigger::AuthFailure, ip(addr), 0), "{addr}");
+        }
+        assert!(b.record_at(BanTrigger::AuthFailure, ip("198.51.100.1"), 0));
+        let off = IpBans::new(BanSettings {
+            enabled: false,
+            auth_failures: 1,
+            ..BanSettings::default()
+        });
+        assert!(!off.record_at(BanTrigger::AuthFailure, ip("198.51.100.1"), 0));
+    }
+
+    #[test]
+    fn settings_from_config() {
+        let cfg = AppConfig {
+            ip_ban: Some(false),
+            ip_ban_auth_failures: Some(5),
+            ip_ban_duration: Some(Duration::from_secs(60)),
+            trusted_networks: Some("192.0.2.0/24".into()),
+            ..AppConfig::default()
+        };
+        let s = BanSettings::from_config(&cfg);
+        assert!(!s.enabled);
+        assert_eq!(s.auth_failures, 5);
+        assert_eq!(s.registrations, DEFAULT_BAN_REGISTRATIONS);
+        assert_eq!(s.duration, Duration::from_secs(60));
+        assert_eq!(s.max_duration, DEFAULT_BAN_MAX_DURATION);
+        assert!(IpBans::new(s).is_exempt(ip("192.0.2.7")));
+    }
+
+    #[tokio::test]
+    async fn automatic_ban_applies_and_escalates() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let b = bans(2, "");
+        let peer = ip("203.0.113.50");
+        b.note(&pool, BanTrigger::AuthFailure, peer).await;
+        assert!(!b.is_banned(peer));
+        b.note(&pool, BanTrigger::AuthFailure, peer).await;
+        assert!(b.is_banned(peer));
+        assert!(!b.is_banned(ip("203.0.113.51")));
+
+        let first = chatmail_db::get_ban(&pool, "203.0.113.50/32")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(first.source, BAN_SOURCE_AUTH);
+        assert_eq!(first.strikes, 1);
+
+        // Let it expire; the next offence earns a ban twice as long.
+        chatmail_db::db_execute!(pool, "UPDATE bans SET expires_at = 1").unwrap();
+        b.refresh(&pool).await.unwrap();
+        assert!(!b.is_banned(peer));
+        b.note(&pool, BanTrigger::AuthFailure, peer).await;
+        b.note(&pool, BanTrigger::AuthFailure, peer).await;
+        assert!(b.is_banned(peer));
+        let second = chatmail_db::get_ban(&pool, "203.0.113.50/32")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(second.strikes, 2);
+        assert!(second.expires_at - unix_now() > DEFAULT_BAN_DURATION.as_secs() as i64);
+
+        let audit = chatmail_db::list_ban_audit(&pool, 10).await.unwrap();
+        assert_eq!(audit.len(), 2);
+        assert_eq!(audit[0].action, "auto");
+    }
+}
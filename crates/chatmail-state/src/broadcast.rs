@@ -0,0 +1,591 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Admin announcement broadcasts: recipient filters, pacing and the in-memory job list.
+//!
+//! `POST /admin/broadcast` selects local accounts with a [`BroadcastFilter`] and hands them
+//! to `chatmail-delivery::broadcast`, which writes the message into each INBOX at most
+//! `broadcast_rate` accounts per second ([`BroadcastPacer`]). Progress and per-account
+//! failures live on the [`BroadcastJob`]; the last [`BROADCAST_JOBS_KEPT`] jobs are kept
+//! for `GET /admin/broadcast` and every running job reports `job_progress` on the admin
+//! event stream. Broadcasts only ever reach local accounts: nothing is queued, forwarded
+//! or bounced.
+
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use chatmail_config::{parse_duration, AppConfig};
+use chatmail_db::policies::unix_now;
+use chatmail_db::{list_account_quota_info, passwords, DbPool};
+use chatmail_types::{address_domain, ChatmailError, Result};
+use tokio::time::Instant;
+
+use crate::admin_events::{AdminEventBus, AdminEventKind};
+
+/// Default `broadcast_rate` (accounts per second).
+pub const DEFAULT_BROADCAST_RATE: u32 = 20;
+/// Local part of the default `broadcast_sender`.
+pub const DEFAULT_BROADCAST_LOCALPART: &str = "announcements";
+/// Finished and running jobs listed by `GET /admin/broadcast`.
+pub const BROADCAST_JOBS_KEPT: usize = 20;
+/// Per-account failures kept on a job; later ones are only counted.
+pub const BROADCAST_FAILURES_KEPT: usize = 100;
+
+/// Which local accounts receive a broadcast.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum BroadcastFilter {
+    All,
+    /// Accounts with a sign of life (activity, login or creation) within the duration.
+    ActiveSince(Duration),
+    /// Accounts of one local domain (case-insensitive).
+    Domain(String),
+    /// Accounts created at or after this unix time.
+    CreatedAfter(i64),
+    /// An explicit list of local addresses (lowercased, sorted, deduplicated).
+    Accounts(Vec<String>),
+}
+
+impl BroadcastFilter {
+    /// `all` (or empty), `active:<duration>`, `domain:<domain>`,
+    /// `created_after:<YYYY-MM-DD | unix seconds>`, `accounts:<addr>,<addr>,...`.
+    pub fn parse(raw: &str) -> Result<Self> {
+        let raw = raw.trim();
+        if raw.is_empty() || raw.eq_ignore_ascii_case("all") {
+            return Ok(Self::All);
+        }
+        let invalid = || {
+            ChatmailError::config(format!(
+                "invalid broadcast filter {raw:?} (want all, active:<duration>, \
+                 domain:<domain>, created_after:<date> or accounts:<addr>,...)"
+            ))
+        };
+        match raw.split_once(':').ok_or_else(invalid)? {
+            ("active", d) => parse_duration(d.trim())
+                .ok()
+                .filter(|d| !d.is_zero())
+                .map(Self::ActiveSince)
+                .ok_or_else(invalid),
+            ("domain", d) if !d.trim().is_empty() => {
+                Ok(Self::Domain(d.trim().to_ascii_lowercase()))
+            }
+            ("created_after", d) => parse_day_or_unix(d.trim())
+                .map(Self::CreatedAfter)
+                .ok_or_else(invalid),
+            ("accounts", list) => {
+                let mut accounts: Vec<String> = list
+                    .split(',')
+                    .map(|a| a.trim().to_ascii_lowercase())
+                    .filter(|a| !a.is_empty())
+                    .collect();
+                if accounts.is_empty() || accounts.iter().any(|a| address_domain(a).is_none()) {
+                    return Err(invalid());
+                }
+                accounts.sort();
+                accounts.dedup();
+                Ok(Self::Accounts(accounts))
+            }
+            _ => Err(invalid()),
+        }
+    }
+
+    /// Canonical form accepted by [`parse`](Self::parse); stored in the audit log.
+    pub fn label(&self) -> String {
+        match self {
+            Self::All => "all".into(),
+            Self::ActiveSince(d) => format!("active:{}s", d.as_secs()),
+            Self::Domain(d) => format!("domain:{d}"),
+            Self::CreatedAfter(t) => format!("created_after:{t}"),
+            Self::Accounts(a) => format!("accounts:{}", a.join(",")),
+        }
+    }
+}
+
+/// `YYYY-MM-DD` (UTC midnight) or plain unix seconds.
+pub fn parse_day_or_unix(raw: &str) -> Option<i64> {
+    if let Ok(secs) = raw.parse::<i64>() {
+        return (secs >= 0).then_some(secs);
+    }
+    let mut parts = raw.splitn(3, '-').map(|p| p.parse::<i64>().ok());
+    let (y, m, d) = (parts.next()??, parts.next()??, parts.next()??);
+    if !(1970..=9999).contains(&y) || !(1..=12).contains(&m) || !(1..=31).contains(&d) {
+        return None;
+    }
+    // Days since 1970-01-01 in the proleptic Gregorian calendar (Hinnant's days_from_civil).
+    let (y, m) = if m <= 2 { (y - 1, m + 9) } else { (y, m - 3) };
+    let era = y / 400;
+    let yoe = y - era * 400;
+    let doy = (153 * m + 2) / 5 + d - 1;
+    let doe = yoe * 365 + yoe / 4 - yoe / 100 + doy;
+    Some((era * 146_097 + doe - 719_468) * 86_400)
+}
+
+/// Local accounts matching `filter` at unix time `now`, sorted.
+pub async fn select_recipients(
+    pool: &DbPool,
+    filter: &BroadcastFilter,
+    now: i64,
+) -> Result<Vec<String>> {
+    let mut users = passwords::list_users(pool).await?;
+    match filter {
+        BroadcastFilter::All => {}
+        BroadcastFilter::ActiveSince(d) => {
+            let cutoff = now.saturating_sub(d.as_secs() as i64);
+            let info = list_account_quota_info(pool).await?;
+            users.retain(|u| {
+                info.get(u)
+                    .is_some_and(|i| chatmail_db::last_seen(i) >= cutoff)
+            });
+        }
+        BroadcastFilter::Domain(domain) => {
+            users.retain(|u| address_domain(u).is_some_and(|d| d.eq_ignore_ascii_case(domain)));
+        }
+        BroadcastFilter::CreatedAfter(cutoff) => {
+            let info = list_account_quota_info(pool).await?;
+            users.retain(|u| info.get(u).is_some_and(|i| i.created_at >= *cutoff));
+        }
+        BroadcastFilter::Accounts(wanted) => {
+            let unknown: Vec<&str> = wanted
+                .iter()
+                .filter(|a| !users.contains(a))
+                .map(String::as_str)
+                .collect();
+            if !unknown.is_empty() {
+                return Err(ChatmailError::config(format!(
+                    "unknown account(s): {}",
+                    unknown.join(", ")
+                )));
+            }
+            users = wanted.clone();
+        }
+    }
+    users.sort();
+    users.dedup();
+    Ok(users)
+}
+
+/// Spaces deliveries `1 / rate` seconds apart. A late caller is not made to catch up with
+/// a burst: the next slot is counted from whenever the last one was taken.
+#[derive(Debug)]
+pub struct BroadcastPacer {
+    interval: Duration,
+    next: Option<Instant>,
+}
+
+impl BroadcastPacer {
+    pub fn new(per_second: u32) -> Self {
+        Self {
+            interval: Duration::from_secs(1) / per_second.max(1),
+            next: None,
+        }
+    }
+
+    pub fn interval(&self) -> Duration {
+        self.interval
+    }
+
+    /// How long to wait at `now` before the next delivery; reserves that slot.
+    pub fn delay(&mut self, now: Instant) -> Duration {
+        let slot = self.next.map_or(now, |next| next.max(now));
+        self.next = Some(slot + self.interval);
+        slot - now
+    }
+
+    /// Sleep until the next delivery slot.
+    pub async fn tick(&mut self) {
+        let delay = self.delay(Instant::now());
+        if !delay.is_zero() {
+            tokio::time::sleep(delay).await;
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum BroadcastState {
+    Running,
+    Finished,
+}
+
+impl BroadcastState {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Running => "running",
+            Self::Finished => "finished",
+        }
+    }
+}
+
+#[derive(Debug)]
+pub struct BroadcastJob {
+    pub id: String,
+    pub subject: String,
+    pub filter: BroadcastFilter,
+    /// Who started it (`admin-api`, `cli`).
+    pub actor: String,
+    pub created_at: i64,
+    pub recipients: u64,
+    delivered: AtomicU64,
+    failed: AtomicU64,
+    failures: Mutex<Vec<(String, String)>>,
+    state: Mutex<BroadcastState>,
+}
+
+impl BroadcastJob {
+    pub fn new(subject: &str, filter: BroadcastFilter, actor: &str, recipients: usize) -> Self {
+        Self {
+            id: format!("{:016x}", rand::random::<u64>()),
+            subject: subject.to_string(),
+            filter,
+            actor: actor.to_string(),
+            created_at: unix_now(),
+            recipients: recipients as u64,
+            delivered: AtomicU64::new(0),
+            failed: AtomicU64::new(0),
+            failures: Mutex::new(Vec::new()),
+            state: Mutex::new(BroadcastState::Running),
+        }
+    }
+
+    pub fn state(&self) -> BroadcastState {
+        *self.state.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    pub fn finish(&self) {
+        *self.state.lock().unwrap_or_else(|e| e.into_inner()) = BroadcastState::Finished;
+    }
+
+    pub fn record_delivered(&self) {
+        self.delivered.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn record_failure(&self, account: &str, error: &str) {
+        self.failed.fetch_add(1, Ordering::Relaxed);
+        let mut failures = self.failures.lock().unwrap_or_else(|e| e.into_inner());
+        if failures.len() < BROADCAST_FAILURES_KEPT {
+            failures.push((account.to_string(), error.to_string()));
+        }
+    }
+
+    /// `(delivered, failed)` so far.
+    pub fn counts(&self) -> (u64, u64) {
+        (
+            self.delivered.load(Ordering::Relaxed),
+            self.failed.load(Ordering::Relaxed),
+        )
+    }
+
+    /// `(account, error)` of the first [`BROADCAST_FAILURES_KEPT`] failed deliveries.
+    pub fn failures(&self) -> Vec<(String, String)> {
+        self.failures
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+    }
+
+    /// Job status as reported by `GET /admin/broadcast` and `job_progress`.
+    pub fn to_json(&self) -> serde_json::Value {
+        let (delivered, failed) = self.counts();
+        let failures: Vec<_> = self
+            .failures()
+            .into_iter()
+            .map(|(account, error)| serde_json::json!({ "account": account, "error": error }))
+            .collect();
+        serde_json::json!({
+            "id": self.id,
+            "subject": self.subject,
+            "filter": self.filter.label(),
+            "actor": self.actor,
+            "state": self.state().as_str(),
+            "created_at": self.created_at,
+            "recipients": self.recipients,
+            "delivered": delivered,
+            "failed": failed,
+            "failures": failures,
+        })
+    }
+}
+
+/// `broadcast_sender` / `broadcast_rate` and the recent jobs.
+#[derive(Debug)]
+pub struct Broadcasts {
+    sender: Option<String>,
+    rate: u32,
+    jobs: Mutex<Vec<Arc<BroadcastJob>>>,
+    events: Option<Arc<AdminEventBus>>,
+}
+
+impl Default for Broadcasts {
+    fn default() -> Self {
+        Self::new(None, DEFAULT_BROADCAST_RATE)
+    }
+}
+
+impl Broadcasts {
+    pub fn new(sender: Option<String>, rate: u32) -> Self {
+        Self {
+            sender: sender.filter(|s| !s.trim().is_empty()),
+            rate: rate.max(1),
+            jobs: Mutex::new(Vec::new()),
+            events: None,
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(
+            config.broadcast_sender.clone(),
+            config.broadcast_rate.unwrap_or(DEFAULT_BROADCAST_RATE),
+        )
+    }
+
+    /// Publish `job_progress` for every broadcast on `events`.
+    pub fn with_events(mut self, events: Arc<AdminEventBus>) -> Self {
+        self.events = Some(events);
+        self
+    }
+
+    /// `broadcast_sender`, or `announcements@{domain}`.
+    pub fn sender(&self, domain: &str) -> String {
+        self.sender
+            .clone()
+            .unwrap_or_else(|| format!("{DEFAULT_BROADCAST_LOCALPART}@{domain}"))
+    }
+
+    pub fn rate(&self) -> u32 {
+        self.rate
+    }
+
+    pub fn pacer(&self) -> BroadcastPacer {
+        BroadcastPacer::new(self.rate)
+    }
+
+    fn jobs(&self) -> std::sync::MutexGuard<'_, Vec<Arc<BroadcastJob>>> {
+        self.jobs.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// Track `job`, dropping the oldest finished jobs beyond [`BROADCAST_JOBS_KEPT`].
+    pub fn register(&self, job: Arc<BroadcastJob>) {
+        let mut jobs = self.jobs();
+        jobs.push(job);
+        while jobs.len() > BROADCAST_JOBS_KEPT {
+            match jobs
+                .iter()
+                .position(|j| j.state() == BroadcastState::Finished)
+            {
+                Some(i) => {
+                    jobs.remove(i);
+                }
+                None => break,
+            }
+        }
+    }
+
+    /// Tracked jobs, oldest first.
+    pub fn list(&self) -> Vec<Arc<BroadcastJob>> {
+        self.jobs().clone()
+    }
+
+    pub fn get(&self, id: &str) -> Option<Arc<BroadcastJob>> {
+        self.jobs().iter().find(|j| j.id == id).cloned()
+    }
+
+    pub fn publish_progress(&self, job: &BroadcastJob) {
+        let Some(events) = &self.events else {
+            return;
+        };
+        let (delivered, failed) = job.counts();
+        events.publish(
+            AdminEventKind::JobProgress,
+            serde_json::json!({
+                "job": "broadcast",
+                "id": job.id,
+                "state": job.state().as_str(),
+                "recipients": job.recipients,
+                "delivered": delivered,
+                "failed": failed,
+            }),
+        );
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use chatmail_db::init_memory_db;
+
+    use super::*;
+
+    #[test]
+    fn parses_filters() {
+        assert_eq!(BroadcastFilter::parse("").unwrap(), BroadcastFilter::All);
+        assert_eq!(BroadcastFilter::parse("ALL").unwrap(), BroadcastFilter::All);
+        assert_eq!(
+            BroadcastFilter::parse("active:7d").unwrap(),
+            BroadcastFilter::ActiveSince(Duration::from_secs(7 * 86400))
+        );
+        assert_eq!(
+            BroadcastFilter::parse("domain:Example.ORG").unwrap(),
+            BroadcastFilter::Domain("example.org".into())
+        );
+        assert_eq!(
+            BroadcastFilter::parse("created_after:2024-03-01").unwrap(),
+            BroadcastFilter::CreatedAfter(1_709_251_200)
+        );
+        assert_eq!(
+            BroadcastFilter::parse("created_after:86400").unwrap(),
+            BroadcastFilter::CreatedAfter(86400)
+        );
+        assert_eq!(
+            BroadcastFilter::parse("accounts:B@x.test, a@x.test,b@x.test").unwrap(),
+            BroadcastFilter::Accounts(vec!["a@x.test".into(), "b@x.test".into()])
+        );
+        for bad in [
+            "active:",
+            "active:0s",
+            "domain:",
+            "users:bob",
+            "recent",
+            "created_after:2024-13-01",
+            "created_after:yesterday",
+            "accounts:",
+            "accounts:bob",
+        ] {
+            assert!(BroadcastFilter::parse(bad).is_err(), "{bad}");
+        }
+        let f = BroadcastFilter::ActiveSince(Duration::from_secs(3600));
+        assert_eq!(BroadcastFilter::parse(&f.label()).unwrap(), f);
+        let f = BroadcastFilter::Accounts(vec!["a@x.test".into(), "b@x.test".into()]);
+        assert_eq!(BroadcastFilter::parse(&f.label()).unwrap(), f);
+    }
+
+    #[tokio::test]
+    async fn selects_recipients_by_filter() {
+        let pool = init_memory_db().await.unwrap();
+        for user in ["a@one.test", "b@one.test", "c@two.test"] {
+            passwords::create_user(&pool, user, "hash").await.unwrap();
+        }
+        chatmail_db::db_execute!(
+            &pool,
+            "INSERT INTO quotas (username, max_storage, created_at, first_login_at, last_login_at)
+             VALUES ('a@one.test', 0, 10, 20, 30), ('b@one.test', 0, 10, 20, 30),
+             ('c@two.test', 0, 10, 20, 30)"
+        )
+        .unwrap();
+        let now = unix_now();
+        chatmail_db::record_activity(&pool, "a@one.test", now - 60)
+            .await
+            .unwrap();
+
+        let all = select_recipients(&pool, &BroadcastFilter::All, now)
+            .await
+            .unwrap();
+        assert_eq!(all, ["a@one.test", "b@one.test", "c@two.test"]);
+
+        let two = BroadcastFilter::Domain("two.test".into());
+        assert_eq!(
+            select_recipients(&pool, &two, now).await.unwrap(),
+            ["c@two.test"]
+        );
+
+        let active = BroadcastFilter::ActiveSince(Duration::from_secs(3600));
+        assert_eq!(
+            select_recipients(&pool, &active, now).await.unwrap(),
+            ["a@one.test"]
+        );
+        let recent = BroadcastFilter::ActiveSince(Duration::from_secs(30));
+        assert!(select_recipients(&pool, &recent, now)
+            .await
+            .unwrap()
+            .is_empty());
+
+        chatmail_db::db_execute!(
+            &pool,
+            "UPDATE quotas SET created_at = 500 WHERE username = 'c@two.test'"
+        )
+        .unwrap();
+        let created = BroadcastFilter::CreatedAfter(500);
+        assert_eq!(
+            select_recipients(&pool, &created, now).await.unwrap(),
+            ["c@two.test"]
+        );
+
+        let listed = BroadcastFilter::parse("accounts:c@two.test,a@one.test").unwrap();
+        assert_eq!(
+            select_recipients(&pool, &listed, now).await.unwrap(),
+            ["a@one.test", "c@two.test"]
+        );
+        let stranger = BroadcastFilter::parse("accounts:a@one.test,z@one.test").unwrap();
+        let err = select_recipients(&pool, &stranger, now).await.unwrap_err();
+        assert!(matches!(err, ChatmailError::Config(ref m) if m.contains("z@one.test")));
+    }
+
+    #[test]
+    fn pacer_spaces_deliveries() {
+        let mut pacer = BroadcastPacer::new(4);
+        assert_eq!(pacer.interval(), Duration::from_millis(250));
+        let t0 = Instant::now();
+        assert_eq!(pacer.delay(t0), Duration::ZERO);
+        assert_eq!(pacer.delay(t0), Duration::from_millis(250));
+        assert_eq!(pacer.delay(t0), Duration::from_millis(500));
+        // Ten slots take 2.25s however fast the caller asks.
+        let mut pacer = BroadcastPacer::new(4);
+        let last = (0..10).map(|_| pacer.delay(t0)).last().unwrap();
+        assert_eq!(last, Duration::from_millis(2250));
+        // Idle time is not banked as a burst.
+        let mut pacer = BroadcastPacer::new(4);
+        pacer.delay(t0);
+        let late = t0 + Duration::from_secs(5);
+        assert_eq!(pacer.delay(late), Duration::ZERO);
+        assert_eq!(pacer.delay(late), Duration::from_millis(250));
+    }
+
+    #[tokio::test]
+    async fn pacer_tick_waits_for_the_slot() {
+        let mut pacer = BroadcastPacer::new(50);
+        let start = std::time::Instant::now();
+        for _ in 0..5 {
+            pacer.tick().await;
+        }
+        assert!(
+            start.elapsed() >= Duration::from_millis(80),
+            "{:?}",
+            start.elapsed()
+        );
+    }
+
+    #[test]
+    fn job_list_keeps_running_jobs_and_caps_finished() {
+        let b = Broadcasts::default();
+        assert_eq!(b.sender("example.org"), "announcements@example.org");
+        assert_eq!(b.rate(), DEFAULT_BROADCAST_RATE);
+        let running = Arc::new(BroadcastJob::new("s", BroadcastFilter::All, "cli", 1));
+        b.register(Arc::clone(&running));
+        for _ in 0..BROADCAST_JOBS_KEPT + 3 {
+            let job = Arc::new(BroadcastJob::new("s", BroadcastFilter::All, "cli", 1));
+            job.finish();
+            b.register(job);
+        }
+        let jobs = b.list();
+        assert_eq!(jobs.len(), BROADCAST_JOBS_KEPT);
+        assert!(b.get(&running.id).is_some());
+
+        running.record_delivered();
+        running.record_failure("x@test", "quota exceeded");
+        assert_eq!(running.counts(), (1, 1));
+        let status = running.to_json();
+        assert_eq!(status["state"], "running");
+        assert_eq!(status["failures"][0]["account"], "x@test");
+    }
+}
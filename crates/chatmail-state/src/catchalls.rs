@@ -0,0 +1,323 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-domain catch-all mailboxes (`madmail domains catchall`), cached for the delivery path.
+//!
+//! When inbound mail names a local address that has no account, [`Catchalls::redirect`]
+//! returns the domain's catch-all target instead of letting the message drop. Safeguards:
+//!
+//! - JIT registration and `auth.pass_table` `auto_create` take precedence: while either is
+//!   on, any unknown address may still become an account, so its mail must not end up in
+//!   somebody else's mailbox and every catch-all stays inactive;
+//! - reserved addresses (`postmaster`, `abuse`, …), [`BUILTIN_CATCHALL_EXCLUDE`] and
+//!   `catchall_exclude` local parts are never redirected;
+//! - each redirect claims a slot of the domain's daily cap (`catchall_daily`), so a
+//!   dictionary run against the domain cannot flood the target.
+//!
+//! The `catchalls` table is reloaded every [`CATCHALL_REFRESH_INTERVAL`], like responders.
+
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex, RwLock};
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use chatmail_db::policies::glob_match;
+use chatmail_db::{catchall_day, claim_catchall_delivery, is_federation_rcpt_blocked};
+use chatmail_db::{Catchall, DbPool};
+use chatmail_types::Result;
+use tokio::task::JoinHandle;
+
+use crate::auth::AuthCache;
+
+/// How often the catch-all list is reloaded from the database.
+pub const CATCHALL_REFRESH_INTERVAL: Duration = Duration::from_secs(30);
+
+/// Local parts never redirected, whatever `catchall_exclude` says (`*` matches any run).
+/// Mail to these bounces at the sender like any other unknown address.
+pub const BUILTIN_CATCHALL_EXCLUDE: &[&str] = &[
+    "noreply",
+    "no-reply",
+    "donotreply",
+    "do-not-reply",
+    "bounce*",
+    "*-request",
+    "owner-*",
+];
+
+#[derive(Debug, Default)]
+pub struct Catchalls {
+    by_domain: RwLock<HashMap<String, Arc<Catchall>>>,
+    /// `catchall_exclude` patterns (lowercase), checked on top of the built-in list.
+    exclude: Vec<String>,
+    /// `auth.pass_table` `auto_create`.
+    auto_create: bool,
+    /// Domain → UTC day on which its cap was last reported, so the warning logs once a day.
+    cap_reported: Mutex<HashMap<String, i64>>,
+}
+
+impl Catchalls {
+    pub fn new(exclude: Vec<String>, auto_create: bool) -> Self {
+        Self {
+            by_domain: RwLock::default(),
+            exclude: exclude
+                .into_iter()
+                .map(|p| p.trim().to_ascii_lowercase())
+                .filter(|p| !p.is_empty())
+                .collect(),
+            auto_create,
+            cap_reported: Mutex::default(),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.catchall_exclude.clone(), config.auth_auto_create)
+    }
+
+    /// Extra local-part patterns never redirected.
+    pub fn exclude_patterns(&self) -> &[String] {
+        &self.exclude
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.by_domain.read().map_or(true, |m| m.is_empty())
+    }
+
+    /// Catch-all configured for `domain`, active or not.
+    pub fn get(&self, domain: &str) -> Option<Arc<Catchall>> {
+        self.by_domain
+            .read()
+            .ok()?
+            .get(&domain.to_ascii_lowercase())
+            .cloned()
+    }
+
+    /// Why catch-alls are not applied right now, if they are not.
+    pub fn inactive_reason(&self, auth: &AuthCache) -> Option<&'static str> {
+        if self.auto_create {
+            Some("auto_create is on")
+        } else if auth.jit_registration_enabled() {
+            Some("JIT registration is on")
+        } else {
+            None
+        }
+    }
+
+    pub fn replace(&self, catchalls: Vec<Catchall>) {
+        let map = catchalls
+            .into_iter()
+            .map(|c| (c.domain.clone(), Arc::new(c)))
+            .collect();
+        if let Ok(mut guard) = self.by_domain.write() {
+            *guard = map;
+        }
+    }
+
+    pub async fn refresh(&self, pool: &DbPool) -> Result<()> {
+        self.replace(chatmail_db::list_catchalls(pool).await?);
+        Ok(())
+    }
+
+    /// The rule that would take mail for `rcpt` (a local address without an account),
+    /// before the daily cap is consulted.
+    pub fn resolve(&self, rcpt: &str, auth: &AuthCache) -> Option<Arc<Catchall>> {
+        let (local, domain) = rcpt.rsplit_once('@')?;
+        let rule = self.get(domain)?;
+        if let Some(reason) = self.inactive_reason(auth) {
+            tracing::debug!(rcpt = %rcpt, "catch-all: inactive ({reason})");
+            return None;
+        }
+        let local = local.to_ascii_lowercase();
+        if is_federation_rcpt_blocked(rcpt)
+            || BUILTIN_CATCHALL_EXCLUDE
+                .iter()
+                .copied()
+                .chain(self.exclude.iter().map(String::as_str))
+                .any(|p| glob_match(p, &local))
+        {
+            tracing::debug!(rcpt = %rcpt, "catch-all: excluded local part");
+            return None;
+        }
+        if !auth.user_exists(&rule.target) || auth.is_blocked(&rule.target) {
+            tracing::warn!(
+                domain = %rule.domain,
+                target = %rule.target,
+                "catch-all: target account is missing or blocked"
+            );
+            return None;
+        }
+        Some(rule)
+    }
+
+    /// Catch-all mailbox that should receive mail for `rcpt`, a local address without an
+    /// account, or `None` to drop it as before. Counts against the domain's daily cap.
+    pub async fn redirect(
+        &self,
+        pool: &DbPool,
+        auth: &AuthCache,
+        rcpt: &str,
+        now: i64,
+    ) -> Option<String> {
+        let rule = self.resolve(rcpt, auth)?;
+        let day = catchall_day(now);
+        match claim_catchall_delivery(pool, &rule.domain, rule.daily_cap, day).await {
+            Ok(true) => {
+                tracing::info!(rcpt = %rcpt, target = %rule.target, "catch-all: redirected");
+                Some(rule.target.clone())
+            }
+            Ok(false) => {
+                let mut reported = self.cap_reported.lock().unwrap_or_else(|e| e.into_inner());
+                if reported.insert(rule.domain.clone(), day) != Some(day) {
+                    tracing::warn!(
+                        domain = %rule.domain,
+                        daily_cap = rule.daily_cap,
+                        "catch-all: daily cap reached; dropping further unknown recipients today"
+                    );
+                }
+                tracing::debug!(rcpt = %rcpt, "catch-all: over daily cap");
+                None
+            }
+            Err(e) => {
+                tracing::warn!(rcpt = %rcpt, error = %e, "catch-all: counter update failed");
+                None
+            }
+        }
+    }
+}
+
+/// Re-read `catchalls` every [`CATCHALL_REFRESH_INTERVAL`] (picks up CLI edits) and prune
+/// counters of past days.
+pub fn start_catchall_refresh(catchalls: Arc<Catchalls>, pool: DbPool) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(CATCHALL_REFRESH_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            let today = catchall_day(chatmail_db::policies::unix_now());
+            if let Err(e) = chatmail_db::prune_catchall_daily(&pool, today).await {
+                tracing::warn!(error = %e, "catch-all counter prune failed");
+            }
+            if let Err(e) = catchalls.refresh(&pool).await {
+                tracing::warn!(error = %e, "catch-all list refresh failed");
+            }
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use chatmail_db::settings_keys;
+
+    use super::*;
+
+    const NOW: i64 = 1_800_000_000;
+
+    /// Closed registration and JIT off, `team@example.org` exists.
+    async fn setup(exclude: &[&str], auto_create: bool) -> (DbPool, AuthCache, Catchalls) {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        for key in [
+            settings_keys::JIT_REGISTRATION_ENABLED,
+            settings_keys::REGISTRATION_OPEN,
+        ] {
+            chatmail_db::set_setting(&pool, key, "false").await.unwrap();
+        }
+        chatmail_db::passwords::create_user(&pool, "team@example.org", "x")
+            .await
+            .unwrap();
+        chatmail_db::set_catchall(&pool, "example.org", "team@example.org", 2)
+            .await
+            .unwrap();
+        let auth = AuthCache::new();
+        auth.hydrate(&pool).await.unwrap();
+        let catchalls =
+            Catchalls::new(exclude.iter().map(|s| s.to_string()).collect(), auto_create);
+        catchalls.refresh(&pool).await.unwrap();
+        (pool, auth, catchalls)
+    }
+
+    #[tokio::test]
+    async fn redirects_unknown_local_parts_up_to_the_cap() {
+        let (pool, auth, catchalls) = setup(&[], false).await;
+        for rcpt in ["sales@example.org", "typo@example.org"] {
+            assert_eq!(
+                catchalls.redirect(&pool, &auth, rcpt, NOW).await.as_deref(),
+                Some("team@example.org")
+            );
+        }
+        assert_eq!(
+            catchalls
+                .redirect(&pool, &auth, "third@example.org", NOW)
+                .await,
+            None,
+            "daily cap of 2 reached"
+        );
+        assert_eq!(
+            catchalls
+                .redirect(&pool, &auth, "third@example.org", NOW + 86_400)
+                .await
+                .as_deref(),
+            Some("team@example.org"),
+            "next UTC day starts over"
+        );
+        assert_eq!(
+            catchalls.redirect(&pool, &auth, "x@other.org", NOW).await,
+            None
+        );
+    }
+
+    #[tokio::test]
+    async fn jit_registration_and_auto_create_take_precedence() {
+        let (pool, auth, catchalls) = setup(&[], true).await;
+        assert_eq!(catchalls.inactive_reason(&auth), Some("auto_create is on"));
+        assert!(catchalls.resolve("sales@example.org", &auth).is_none());
+
+        let catchalls = Catchalls::new(vec![], false);
+        catchalls.refresh(&pool).await.unwrap();
+        chatmail_db::set_setting(&pool, settings_keys::JIT_REGISTRATION_ENABLED, "true")
+            .await
+            .unwrap();
+        auth.hydrate(&pool).await.unwrap();
+        assert_eq!(
+            catchalls.inactive_reason(&auth),
+            Some("JIT registration is on")
+        );
+        assert!(catchalls.resolve("sales@example.org", &auth).is_none());
+    }
+
+    #[tokio::test]
+    async fn role_addresses_and_missing_targets_are_not_redirected() {
+        let (pool, auth, catchalls) = setup(&[" Billing ", "sales-*"], false).await;
+        assert_eq!(catchalls.exclude_patterns(), ["billing", "sales-*"]);
+        for rcpt in [
+            "postmaster@example.org",
+            "abuse@example.org",
+            "noreply@example.org",
+            "bounces+x@example.org",
+            "list-request@example.org",
+            "billing@example.org",
+            "sales-eu@example.org",
+        ] {
+            assert!(catchalls.resolve(rcpt, &auth).is_none(), "{rcpt}");
+        }
+        assert!(catchalls.resolve("sales@example.org", &auth).is_some());
+
+        chatmail_db::set_catchall(&pool, "example.org", "gone@example.org", 2)
+            .await
+            .unwrap();
+        catchalls.refresh(&pool).await.unwrap();
+        assert!(catchalls.resolve("sales@example.org", &auth).is_none());
+    }
+}
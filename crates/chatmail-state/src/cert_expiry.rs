@@ -0,0 +1,293 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! TLS certificate expiry (`cert_expiry_warning`, `renewal_hook`).
+//!
+//! Self-signed and hand-managed certificates expire silently; the first symptom is every
+//! client failing at once. The supervisor's certificate watch feeds the active certificate's
+//! validity into [`CertMonitor::observe`], which logs escalating warnings at
+//! [`CERT_EXPIRY_STAGES`] days and tells the caller when to run `renewal_hook`.
+//! `GET /status`, `/admin/status` and OpenMetrics read the cached result.
+//!
+//! Stages and the warning window are capped at a third of the certificate's lifetime, so a
+//! six-day Let's Encrypt IP certificate only warns once its in-process renewal is late.
+
+use std::sync::{Mutex, RwLock};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use chatmail_config::AppConfig;
+
+/// Days before `NotAfter` at which a warning is logged; later stages log louder.
+pub const CERT_EXPIRY_STAGES: [i64; 4] = [30, 14, 7, 1];
+
+/// Active certificate as last seen by [`CertMonitor::observe`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CertReading {
+    pub cert_path: String,
+    /// `NotBefore` in unix seconds.
+    pub not_before: i64,
+    /// `NotAfter` in unix seconds.
+    pub not_after: i64,
+    /// Local unix time of the observation.
+    pub checked_at: i64,
+}
+
+/// What the caller should do after [`CertMonitor::observe`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct CertObservation {
+    /// A [`CERT_EXPIRY_STAGES`] entry crossed by this observation (already logged).
+    pub stage: Option<i64>,
+    /// The certificate just entered the warning window: run `renewal_hook` once.
+    pub run_hook: bool,
+}
+
+#[derive(Debug, Default)]
+struct Escalation {
+    /// `NotAfter` the fields below refer to; a renewed certificate starts over.
+    not_after: i64,
+    /// Lowest stage already logged.
+    stage: Option<i64>,
+    hook_fired: bool,
+}
+
+#[derive(Debug)]
+pub struct CertMonitor {
+    warning: Duration,
+    last: RwLock<Option<CertReading>>,
+    escalation: Mutex<Escalation>,
+}
+
+impl Default for CertMonitor {
+    fn default() -> Self {
+        Self::new(chatmail_config::DEFAULT_CERT_EXPIRY_WARNING)
+    }
+}
+
+impl CertMonitor {
+    pub fn new(warning: Duration) -> Self {
+        Self {
+            warning,
+            last: RwLock::new(None),
+            escalation: Mutex::new(Escalation::default()),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.effective_cert_expiry_warning())
+    }
+
+    /// `cert_expiry_warning`.
+    pub fn warning(&self) -> Duration {
+        self.warning
+    }
+
+    /// Record the active certificate. Each stage is logged once per certificate (warn at 30
+    /// and 14 days, error from 7 days on); a renewed certificate resets the escalation.
+    pub fn observe(
+        &self,
+        cert_path: &str,
+        not_before: i64,
+        not_after: i64,
+        now: i64,
+    ) -> CertObservation {
+        *self.last.write().unwrap_or_else(|e| e.into_inner()) = Some(CertReading {
+            cert_path: cert_path.to_string(),
+            not_before,
+            not_after,
+            checked_at: now,
+        });
+        chatmail_metrics::set_tls_certificate_expiry(not_after - now);
+
+        let mut esc = self.escalation.lock().unwrap_or_else(|e| e.into_inner());
+        if esc.not_after != not_after {
+            if esc.stage.is_some() {
+                tracing::info!(cert = %cert_path, not_after, "tls: certificate replaced");
+            }
+            *esc = Escalation {
+                not_after,
+                ..Escalation::default()
+            };
+        }
+
+        let days = days_left(not_after, now);
+        let cap = (not_after - not_before) / 3;
+        let mut out = CertObservation::default();
+        let stage = CERT_EXPIRY_STAGES
+            .iter()
+            .copied()
+            .filter(|s| days <= *s && *s * 86_400 <= cap)
+            .min();
+        if let Some(stage) = stage.filter(|s| esc.stage.map_or(true, |prev| *s < prev)) {
+            esc.stage = Some(stage);
+            out.stage = Some(stage);
+            let hint = expiry_hint(days);
+            if stage <= 7 {
+                tracing::error!(cert = %cert_path, days_left = days, "tls: {hint}");
+            } else {
+                tracing::warn!(cert = %cert_path, days_left = days, "tls: {hint}");
+            }
+        }
+        if !esc.hook_fired && not_after - now <= self.window(not_before, not_after) {
+            esc.hook_fired = true;
+            out.run_hook = true;
+        }
+        out
+    }
+
+    pub fn reading(&self) -> Option<CertReading> {
+        self.last.read().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+
+    /// Whole days left on the active certificate (negative once expired).
+    pub fn days_remaining(&self) -> Option<i64> {
+        Some(days_left(self.reading()?.not_after, unix_now()))
+    }
+
+    /// Operator-facing note once the certificate is inside `cert_expiry_warning`.
+    pub fn expiry_hint(&self) -> Option<String> {
+        let reading = self.reading()?;
+        let now = unix_now();
+        (reading.not_after - now <= self.window(reading.not_before, reading.not_after))
+            .then(|| expiry_hint(days_left(reading.not_after, now)))
+    }
+
+    /// Warning window in seconds for a certificate with this validity.
+    fn window(&self, not_before: i64, not_after: i64) -> i64 {
+        (self.warning.as_secs() as i64).min((not_after - not_before) / 3)
+    }
+}
+
+fn expiry_hint(days: i64) -> String {
+    match days {
+        d if d < 0 => format!("TLS certificate expired {} days ago", -d),
+        0 => "TLS certificate expires within a day".to_string(),
+        1 => "TLS certificate expires in 1 day".to_string(),
+        d => format!("TLS certificate expires in {d} days"),
+    }
+}
+
+fn days_left(not_after: i64, now: i64) -> i64 {
+    (not_after - now).div_euclid(86_400)
+}
+
+fn unix_now() -> i64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs() as i64)
+        .unwrap_or(0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const DAY: i64 = 86_400;
+    /// Long before every `now` below: a year-scale lifetime, no cap.
+    const ISSUED: i64 = 1_000_000_000;
+
+    #[test]
+    fn stages_escalate_once_per_certificate() {
+        let cert = CertMonitor::new(Duration::from_secs(14 * DAY as u64));
+        let now = 1_800_000_000;
+        let not_after = now + 40 * DAY;
+
+        assert_eq!(
+            cert.observe("c.pem", ISSUED, not_after, now),
+            CertObservation::default()
+        );
+        let at = |days: i64| not_after - days * DAY;
+        assert_eq!(
+            cert.observe("c.pem", ISSUED, not_after, at(30)).stage,
+            Some(30)
+        );
+        assert_eq!(cert.observe("c.pem", ISSUED, not_after, at(29)).stage, None);
+
+        let crossed = cert.observe("c.pem", ISSUED, not_after, at(14));
+        assert_eq!(crossed.stage, Some(14));
+        assert!(crossed.run_hook, "warning threshold crossed");
+        assert_eq!(
+            cert.observe("c.pem", ISSUED, not_after, at(13)),
+            CertObservation::default()
+        );
+
+        // Several stages skipped at once (server was down) log only the lowest.
+        assert_eq!(
+            cert.observe("c.pem", ISSUED, not_after, at(0)).stage,
+            Some(1)
+        );
+        assert_eq!(
+            cert.observe("c.pem", ISSUED, not_after, not_after + DAY)
+                .stage,
+            None
+        );
+    }
+
+    #[test]
+    fn renewed_certificate_resets_escalation() {
+        let cert = CertMonitor::new(Duration::from_secs(7 * DAY as u64));
+        let now = 1_800_000_000;
+        let old = cert.observe("c.pem", ISSUED, now + 3 * DAY, now);
+        assert_eq!(old.stage, Some(7));
+        assert!(old.run_hook);
+
+        let renewed = cert.observe("c.pem", ISSUED, now + 90 * DAY, now + 60);
+        assert_eq!(renewed, CertObservation::default());
+        let again = cert.observe("c.pem", ISSUED, now + 90 * DAY, now + 84 * DAY);
+        assert_eq!(again.stage, Some(7));
+        assert!(again.run_hook, "hook fires again for the new certificate");
+    }
+
+    #[test]
+    fn short_lived_certificate_warns_only_when_renewal_is_late() {
+        // Six-day IP certificate, renewed in-process with four days left.
+        let cert = CertMonitor::default();
+        let issued = 1_800_000_000;
+        let not_after = issued + 6 * DAY;
+        assert_eq!(
+            cert.observe("c.pem", issued, not_after, issued + 60),
+            CertObservation::default()
+        );
+        assert_eq!(
+            cert.observe("c.pem", issued, not_after, not_after - 3 * DAY),
+            CertObservation::default()
+        );
+        let late = cert.observe("c.pem", issued, not_after, not_after - DAY);
+        assert_eq!(late.stage, Some(1));
+        assert!(late.run_hook);
+    }
+
+    #[test]
+    fn hint_only_inside_warning_window() {
+        let cert = CertMonitor::default();
+        assert_eq!(cert.expiry_hint(), None);
+        let now = unix_now();
+        cert.observe("c.pem", ISSUED, now + 60 * DAY - 3600, now);
+        assert_eq!(cert.expiry_hint(), None);
+        assert_eq!(cert.days_remaining(), Some(59));
+
+        cert.observe("c.pem", ISSUED, now + 5 * DAY + 60, now);
+        assert_eq!(
+            cert.expiry_hint().as_deref(),
+            Some("TLS certificate expires in 5 days")
+        );
+        cert.observe("c.pem", ISSUED, now - 2 * DAY + 3600, now);
+        assert_eq!(
+            cert.expiry_hint().as_deref(),
+            Some("TLS certificate expired 2 days ago")
+        );
+    }
+}
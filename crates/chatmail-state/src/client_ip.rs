@@ -0,0 +1,401 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Client IP derivation behind a CDN / reverse proxy (`trusted_cdn` directive).
+//!
+//! Forwarded headers are only believed when the TCP peer is a trusted proxy; anyone else
+//! could set them to dodge rate limits or pin registrations on someone else's address.
+
+use std::fmt;
+use std::net::IpAddr;
+use std::sync::RwLock;
+
+use chatmail_types::{ChatmailError, Result};
+
+pub const CF_CONNECTING_IP: &str = "cf-connecting-ip";
+pub const X_FORWARDED_FOR: &str = "x-forwarded-for";
+
+/// Published Cloudflare edge ranges (<https://www.cloudflare.com/ips/>), used until the
+/// refresh job fetches a newer list.
+pub const CLOUDFLARE_RANGES: &[&str] = &[
+    "173.245.48.0/20",
+    "103.21.244.0/22",
+    "103.22.200.0/22",
+    "103.31.4.0/22",
+    "141.101.64.0/18",
+    "108.162.192.0/18",
+    "190.93.240.0/20",
+    "188.114.96.0/20",
+    "197.234.240.0/22",
+    "198.41.128.0/17",
+    "162.158.0.0/15",
+    "104.16.0.0/13",
+    "104.24.0.0/14",
+    "172.64.0.0/13",
+    "131.0.72.0/22",
+    "2400:cb00::/32",
+    "2606:4700::/32",
+    "2803:f800::/32",
+    "2405:b500::/32",
+    "2405:8100::/32",
+    "2a06:98c0::/29",
+    "2c0f:f248::/32",
+];
+
+/// One CIDR range; a bare address is a host route.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct IpNet {
+    addr: IpAddr,
+    prefix: u8,
+}
+
+impl IpNet {
+    pub fn parse(s: &str) -> Option<Self> {
+        let s = s.trim();
+        let (addr, prefix) = match s.split_once('/') {
+            Some((a, p)) => (a.parse::<IpAddr>().ok()?, Some(p.parse::<u8>().ok()?)),
+            None => (s.parse::<IpAddr>().ok()?, None),
+        };
+        let max = if addr.is_ipv4() { 32 } else { 128 };
+        let prefix = prefix.unwrap_or(max);
+        if prefix > max {
+            return None;
+        }
+        Some(Self { addr, prefix })
+    }
+
+    pub fn is_ipv4(&self) -> bool {
+        self.addr.is_ipv4()
+    }
+
+    /// First and last address of the range as integers (IPv4 in the low 32 bits).
+    pub fn bounds(&self) -> (u128, u128) {
+        let (addr, bits) = match self.addr {
+            IpAddr::V4(v4) => (u32::from(v4) as u128, 32),
+            IpAddr::V6(v6) => (u128::from(v6), 128),
+        };
+        let host = bits - u32::from(self.prefix);
+        let host_mask = if host == 0 {
+            0
+        } else {
+            u128::MAX >> (128 - host)
+        };
+        (addr & !host_mask, addr | host_mask)
+    }
+
+    pub fn contains(&self, ip: IpAddr) -> bool {
+        match (self.addr, canonical(ip)) {
+            (IpAddr::V4(net), IpAddr::V4(ip)) => prefix_eq(
+                u32::from(net) as u128,
+                u32::from(ip) as u128,
+                self.prefix,
+                32,
+            ),
+            (IpAddr::V6(net), IpAddr::V6(ip)) => {
+                prefix_eq(u128::from(net), u128::from(ip), self.prefix, 128)
+            }
+            _ => false,
+        }
+    }
+}
+
+impl fmt::Display for IpNet {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}/{}", self.addr, self.prefix)
+    }
+}
+
+fn prefix_eq(a: u128, b: u128, prefix: u8, bits: u32) -> bool {
+    let shift = bits - u32::from(prefix);
+    shift >= bits || (a >> shift) == (b >> shift)
+}
+
+/// Dual-stack listeners report IPv4 peers as `::ffff:a.b.c.d`.
+fn canonical(ip: IpAddr) -> IpAddr {
+    match ip {
+        IpAddr::V6(v6) => v6
+            .to_ipv4_mapped()
+            .map(IpAddr::V4)
+            .unwrap_or(IpAddr::V6(v6)),
+        v4 => v4,
+    }
+}
+
+/// Ranges from a newline/comma/whitespace separated list (`#` comments allowed). Entries
+/// that do not parse are skipped.
+pub fn parse_cidr_list(text: &str) -> Vec<IpNet> {
+    text.lines()
+        .map(|l| l.split('#').next().unwrap_or(""))
+        .flat_map(|l| l.split(|c: char| c == ',' || c.is_whitespace()))
+        .filter(|t| !t.is_empty())
+        .filter_map(IpNet::parse)
+        .collect()
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CdnMode {
+    /// Headers are never trusted; the TCP peer is the client.
+    None,
+    /// Cloudflare edges; `CF-Connecting-IP` wins over `X-Forwarded-For`.
+    Cloudflare,
+    /// Operator-supplied proxy ranges; `X-Forwarded-For` only.
+    Cidr,
+}
+
+/// Trusted proxy ranges plus the rule for picking the client address out of their headers.
+#[derive(Debug)]
+pub struct TrustedProxies {
+    mode: CdnMode,
+    ranges: RwLock<Vec<IpNet>>,
+}
+
+impl Default for TrustedProxies {
+    fn default() -> Self {
+        Self::none()
+    }
+}
+
+impl TrustedProxies {
+    pub fn none() -> Self {
+        Self {
+            mode: CdnMode::None,
+            ranges: RwLock::new(Vec::new()),
+        }
+    }
+
+    pub fn cloudflare() -> Self {
+        Self {
+            mode: CdnMode::Cloudflare,
+            ranges: RwLock::new(
+                CLOUDFLARE_RANGES
+                    .iter()
+                    .filter_map(|r| IpNet::parse(r))
+                    .collect(),
+            ),
+        }
+    }
+
+    /// Parse the `trusted_cdn` directive value: `cloudflare`, `none`, or CIDRs.
+    pub fn from_directive(value: &str) -> Result<Self> {
+        let v = value.trim();
+        if v.is_empty() || v.eq_ignore_ascii_case("none") || v.eq_ignore_ascii_case("off") {
+            return Ok(Self::none());
+        }
+        if v.eq_ignore_ascii_case("cloudflare") {
+            return Ok(Self::cloudflare());
+        }
+        let mut ranges = Vec::new();
+        for token in v
+            .split(|c: char| c == ',' || c.is_whitespace())
+            .filter(|t| !t.is_empty())
+        {
+            ranges.push(IpNet::parse(token).ok_or_else(|| {
+                ChatmailError::config(format!("trusted_cdn: invalid CIDR {token:?}"))
+            })?);
+        }
+        Ok(Self {
+            mode: CdnMode::Cidr,
+            ranges: RwLock::new(ranges),
+        })
+    }
+
+    /// From config; an invalid directive disables header trust rather than failing boot.
+    pub fn from_config(value: Option<&str>) -> Self {
+        match value.map(Self::from_directive) {
+            Some(Ok(p)) => p,
+            Some(Err(e)) => {
+                tracing::warn!(error = %e, "ignoring trusted_cdn; forwarded headers are not trusted");
+                Self::none()
+            }
+            None => Self::none(),
+        }
+    }
+
+    pub fn mode(&self) -> CdnMode {
+        self.mode
+    }
+
+    pub fn ranges(&self) -> Vec<IpNet> {
+        self.ranges
+            .read()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+    }
+
+    /// Swap in a freshly fetched range list (Cloudflare refresh job). Empty lists are
+    /// refused so a bad fetch cannot silently drop every edge.
+    pub fn replace_ranges(&self, ranges: Vec<IpNet>) -> bool {
+        if ranges.is_empty() {
+            return false;
+        }
+        *self.ranges.write().unwrap_or_else(|e| e.into_inner()) = ranges;
+        true
+    }
+
+    pub fn is_trusted(&self, ip: IpAddr) -> bool {
+        self.mode != CdnMode::None
+            && self
+                .ranges
+                .read()
+                .unwrap_or_else(|e| e.into_inner())
+                .iter()
+                .any(|n| n.contains(ip))
+    }
+
+    /// The address every remote-IP consumer should use. `header` looks up a request header
+    /// by lowercase name; it is only consulted when `peer` is a trusted proxy.
+    pub fn client_ip<'a>(&self, peer: IpAddr, header: impl Fn(&str) -> Option<&'a str>) -> IpAddr {
+        let peer = canonical(peer);
+        if !self.is_trusted(peer) {
+            return peer;
+        }
+        if self.mode == CdnMode::Cloudflare {
+            if let Some(ip) = header(CF_CONNECTING_IP).and_then(|v| v.trim().parse().ok()) {
+                return canonical(ip);
+            }
+        }
+        match header(X_FORWARDED_FOR) {
+            Some(xff) => self.last_untrusted_hop(peer, xff),
+            None => peer,
+        }
+    }
+
+    /// Walk `X-Forwarded-For` right to left past our own proxies; the first hop we do not
+    /// trust is the client. Anything left of it was written by the client and is ignored.
+    fn last_untrusted_hop(&self, peer: IpAddr, xff: &str) -> IpAddr {
+        let mut client = peer;
+        for hop in xff.rsplit(',') {
+            let Ok(ip) = hop.trim().parse::<IpAddr>() else {
+                break;
+            };
+            client = canonical(ip);
+            if !self.is_trusted(client) {
+                break;
+            }
+        }
+        client
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn ip(s: &str) -> IpAddr {
+        s.parse().unwrap()
+    }
+
+    fn headers<'a>(pairs: &'a [(&'a str, &'a str)]) -> impl Fn(&str) -> Option<&'a str> {
+        move |name| pairs.iter().find(|(k, _)| *k == name).map(|(_, v)| *v)
+    }
+
+    #[test]
+    fn cidr_contains() {
+        let net = IpNet::parse("10.1.0.0/16").unwrap();
+        assert!(net.contains(ip("10.1.2.3")));
+        assert!(net.contains(ip("::ffff:10.1.2.3")));
+        assert!(!net.contains(ip("10.2.0.1")));
+        let v6 = IpNet::parse("2a06:98c0::/29").unwrap();
+        assert!(v6.contains(ip("2a06:98c7::1")));
+        assert!(!v6.contains(ip("2a06:98c8::1")));
+        assert!(IpNet::parse("0.0.0.0/0").unwrap().contains(ip("8.8.8.8")));
+        assert_eq!(
+            IpNet::parse("192.0.2.7").unwrap().to_string(),
+            "192.0.2.7/32"
+        );
+        assert!(IpNet::parse("10.0.0.0/33").is_none());
+        assert!(IpNet::parse("example.org").is_none());
+    }
+
+    #[test]
+    fn directive_parsing() {
+        assert_eq!(
+            TrustedProxies::from_directive("none").unwrap().mode(),
+            CdnMode::None
+        );
+        let cf = TrustedProxies::from_directive("Cloudflare").unwrap();
+        assert_eq!(cf.mode(), CdnMode::Cloudflare);
+        assert_eq!(cf.ranges().len(), CLOUDFLARE_RANGES.len());
+        let cidr = TrustedProxies::from_directive("10.0.0.0/8, 2001:db8::/32").unwrap();
+        assert_eq!(cidr.mode(), CdnMode::Cidr);
+        assert_eq!(cidr.ranges().len(), 2);
+        assert!(TrustedProxies::from_directive("10.0.0.0/8 bogus").is_err());
+        assert_eq!(
+            TrustedProxies::from_config(Some("bogus")).mode(),
+            CdnMode::None
+        );
+    }
+
+    #[test]
+    fn cloudflare_header_precedence() {
+        let cf = TrustedProxies::cloudflare();
+        let edge = ip("162.158.1.2");
+        let h = [
+            (CF_CONNECTING_IP, "198.51.100.9"),
+            (X_FORWARDED_FOR, "203.0.113.5"),
+        ];
+        assert_eq!(cf.client_ip(edge, headers(&h)), ip("198.51.100.9"));
+        // No CF-Connecting-IP: fall back to X-Forwarded-For.
+        let h = [(X_FORWARDED_FOR, "203.0.113.5")];
+        assert_eq!(cf.client_ip(edge, headers(&h)), ip("203.0.113.5"));
+        // Garbage CF-Connecting-IP is ignored, not trusted as-is.
+        let h = [(CF_CONNECTING_IP, "nope")];
+        assert_eq!(cf.client_ip(edge, headers(&h)), edge);
+    }
+
+    #[test]
+    fn untrusted_peers_cannot_spoof() {
+        let cf = TrustedProxies::cloudflare();
+        let attacker = ip("203.0.113.66");
+        let h = [
+            (CF_CONNECTING_IP, "198.51.100.9"),
+            (X_FORWARDED_FOR, "198.51.100.9"),
+        ];
+        assert_eq!(cf.client_ip(attacker, headers(&h)), attacker);
+
+        let none = TrustedProxies::none();
+        let local = ip("127.0.0.1");
+        assert_eq!(none.client_ip(local, headers(&h)), local);
+    }
+
+    #[test]
+    fn forwarded_for_takes_last_untrusted_hop() {
+        let p = TrustedProxies::from_directive("10.0.0.0/8").unwrap();
+        let proxy = ip("10.0.0.2");
+        // Client prepended a fake hop; the edge proxy appended the real one.
+        let h = [(X_FORWARDED_FOR, "1.2.3.4, 198.51.100.9, 10.0.0.7")];
+        assert_eq!(p.client_ip(proxy, headers(&h)), ip("198.51.100.9"));
+        // CIDR mode never reads CF-Connecting-IP.
+        let h = [(CF_CONNECTING_IP, "1.2.3.4")];
+        assert_eq!(p.client_ip(proxy, headers(&h)), proxy);
+        // Every hop trusted: leftmost wins.
+        let h = [(X_FORWARDED_FOR, "10.9.9.9, 10.0.0.7")];
+        assert_eq!(p.client_ip(proxy, headers(&h)), ip("10.9.9.9"));
+        // A malformed hop stops the walk at the last good one.
+        let h = [(X_FORWARDED_FOR, "198.51.100.9, junk, 10.0.0.7")];
+        assert_eq!(p.client_ip(proxy, headers(&h)), ip("10.0.0.7"));
+    }
+
+    #[test]
+    fn replace_ranges_refuses_empty() {
+        let cf = TrustedProxies::cloudflare();
+        assert!(!cf.replace_ranges(Vec::new()));
+        assert!(cf.is_trusted(ip("104.16.0.1")));
+        assert!(cf.replace_ranges(parse_cidr_list("192.0.2.0/24\n# comment\n2001:db8::/32\n")));
+        assert!(!cf.is_trusted(ip("104.16.0.1")));
+        assert!(cf.is_trusted(ip("192.0.2.10")));
+    }
+}
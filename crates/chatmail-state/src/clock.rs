@@ -0,0 +1,228 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Clock sanity check (`clock_check`).
+//!
+//! TLS validity windows, TURN credentials (`expiry:user` HMAC) and DKIM `t=`/`x=` tags all
+//! trust the local wall clock. A background task compares it against a [`TimeSource`] and
+//! [`ClockMonitor`] caches the result for `/admin/status` and for hints on time-sensitive
+//! failures. The clock is never adjusted here; fixing NTP is the operator's job.
+
+use std::sync::RwLock;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use async_trait::async_trait;
+use chatmail_config::AppConfig;
+
+/// Reference time for [`ClockMonitor::check`].
+#[async_trait]
+pub trait TimeSource: Send + Sync {
+    /// Label shown in status output and logs (usually the URL).
+    fn name(&self) -> String;
+    /// Reference unix time in seconds.
+    async fn unix_now(&self) -> Result<i64, String>;
+}
+
+/// Outcome of the last [`ClockMonitor::check`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ClockReading {
+    pub source: String,
+    /// Local minus reference time; positive when the local clock is ahead.
+    pub skew_secs: i64,
+    /// Local unix time of the check.
+    pub checked_at: i64,
+    /// Set when the source was unreachable; `skew_secs` then keeps the previous value.
+    pub error: Option<String>,
+}
+
+#[derive(Debug)]
+pub struct ClockMonitor {
+    enabled: bool,
+    threshold: Duration,
+    last: RwLock<Option<ClockReading>>,
+}
+
+impl Default for ClockMonitor {
+    fn default() -> Self {
+        Self::new(true, chatmail_config::DEFAULT_CLOCK_SKEW_THRESHOLD)
+    }
+}
+
+impl ClockMonitor {
+    pub fn new(enabled: bool, threshold: Duration) -> Self {
+        Self {
+            enabled,
+            threshold,
+            last: RwLock::new(None),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(
+            config.effective_clock_check().is_some(),
+            config.effective_clock_skew_threshold(),
+        )
+    }
+
+    /// False when `clock_check off`.
+    pub fn enabled(&self) -> bool {
+        self.enabled
+    }
+
+    pub fn threshold(&self) -> Duration {
+        self.threshold
+    }
+
+    /// Query `source` once and cache the skew. Logs a warning on every check past the
+    /// threshold so the problem stays visible in the journal until it is fixed.
+    pub async fn check(&self, source: &dyn TimeSource) -> Result<i64, String> {
+        let before = local_unix_now();
+        let result = source.unix_now().await;
+        let after = local_unix_now();
+        let name = source.name();
+        match result {
+            Ok(reference) => {
+                let skew = (before + after) / 2 - reference;
+                let was_skewed = self.skew().is_some();
+                self.record(&name, skew, after, None);
+                if let Some(hint) = self.skew_hint() {
+                    tracing::warn!(source = %name, skew_secs = skew, "clock: {hint}");
+                } else if was_skewed {
+                    tracing::info!(source = %name, skew_secs = skew, "clock: skew resolved");
+                }
+                Ok(skew)
+            }
+            Err(e) => {
+                tracing::debug!(source = %name, error = %e, "clock: time source unreachable");
+                let skew = self.reading().map(|r| r.skew_secs).unwrap_or(0);
+                self.record(&name, skew, after, Some(e.clone()));
+                Err(e)
+            }
+        }
+    }
+
+    fn record(&self, source: &str, skew_secs: i64, checked_at: i64, error: Option<String>) {
+        *self.last.write().unwrap_or_else(|e| e.into_inner()) = Some(ClockReading {
+            source: source.to_string(),
+            skew_secs,
+            checked_at,
+            error,
+        });
+    }
+
+    pub fn reading(&self) -> Option<ClockReading> {
+        self.last.read().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+
+    /// Measured skew when it exceeds the threshold.
+    pub fn skew(&self) -> Option<i64> {
+        let skew = self.reading()?.skew_secs;
+        (skew.unsigned_abs() > self.threshold.as_secs()).then_some(skew)
+    }
+
+    /// Operator-facing note for errors that depend on the clock (TLS, DKIM, TURN).
+    pub fn skew_hint(&self) -> Option<String> {
+        let skew = self.skew()?;
+        let source = self.reading().map(|r| r.source).unwrap_or_default();
+        let direction = if skew > 0 { "ahead of" } else { "behind" };
+        Some(format!(
+            "local clock is {}s {direction} {source} (threshold {}s); check NTP",
+            skew.unsigned_abs(),
+            self.threshold.as_secs()
+        ))
+    }
+}
+
+fn local_unix_now() -> i64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs() as i64)
+        .unwrap_or(0)
+}
+
+/// Test double: reports the local clock shifted by `offset_secs`.
+#[derive(Debug, Clone)]
+pub struct FixedOffsetTimeSource {
+    pub name: String,
+    /// Added to local time; `-300` makes the local clock look 5 minutes ahead.
+    pub offset_secs: i64,
+}
+
+#[async_trait]
+impl TimeSource for FixedOffsetTimeSource {
+    fn name(&self) -> String {
+        self.name.clone()
+    }
+
+    async fn unix_now(&self) -> Result<i64, String> {
+        Ok(local_unix_now() + self.offset_secs)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    struct Unreachable;
+
+    #[async_trait]
+    impl TimeSource for Unreachable {
+        fn name(&self) -> String {
+            "https://time.example/".into()
+        }
+
+        async fn unix_now(&self) -> Result<i64, String> {
+            Err("connection refused".into())
+        }
+    }
+
+    fn source(offset_secs: i64) -> FixedOffsetTimeSource {
+        FixedOffsetTimeSource {
+            name: "https://time.example/".into(),
+            offset_secs,
+        }
+    }
+
+    #[tokio::test]
+    async fn skew_past_threshold_produces_hint() {
+        let clock = ClockMonitor::new(true, Duration::from_secs(120));
+        assert_eq!(clock.skew_hint(), None);
+
+        let skew = clock.check(&source(-300)).await.unwrap();
+        assert!((299..=301).contains(&skew), "skew {skew}");
+        assert!(clock.skew().is_some());
+        let hint = clock.skew_hint().unwrap();
+        assert!(hint.contains("ahead of https://time.example/"), "{hint}");
+        assert!(hint.contains("threshold 120s"), "{hint}");
+
+        clock.check(&source(600)).await.unwrap();
+        assert!(clock.skew_hint().unwrap().contains("behind"));
+
+        clock.check(&source(30)).await.unwrap();
+        assert_eq!(clock.skew(), None);
+        assert_eq!(clock.skew_hint(), None);
+    }
+
+    #[tokio::test]
+    async fn unreachable_source_keeps_last_skew() {
+        let clock = ClockMonitor::default();
+        clock.check(&source(-600)).await.unwrap();
+        assert!(clock.check(&Unreachable).await.is_err());
+        let reading = clock.reading().unwrap();
+        assert_eq!(reading.error.as_deref(), Some("connection refused"));
+        assert!(clock.skew().is_some());
+    }
+}
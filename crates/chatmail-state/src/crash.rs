@@ -0,0 +1,431 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Panic containment for sessions and HTTP handlers, with on-disk crash reports.
+//!
+//! A panic inside one IMAP command, SMTP session or HTTP request is caught with
+//! [`catch_panic`] and handed to [`CrashReporter::report`]: the stack is logged with the
+//! module and request metadata, the per-module crash counter (`/admin/status`, OpenMetrics)
+//! is bumped, and a report file (stack, version, config hash, recent log lines) is written
+//! to `crash_dir` (default `<state_dir>/crashes`), keeping the newest `crash_keep`. The
+//! caller then ends only the affected connection (IMAP `BYE`, SMTP `421`, HTTP `500`).
+//!
+//! `panic_abort yes` keeps the old fail-fast behaviour for development: the hook installed
+//! by [`install_panic_hook`] aborts the process on the first panic.
+
+use std::any::Any;
+use std::cell::RefCell;
+use std::collections::{BTreeMap, VecDeque};
+use std::future::Future;
+use std::panic::AssertUnwindSafe;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Mutex, Once};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use chatmail_config::AppConfig;
+use futures_util::FutureExt;
+use sha2::{Digest, Sha256};
+
+/// Default `crash_keep`: reports kept in `crash_dir`.
+pub const DEFAULT_CRASH_KEEP: usize = 20;
+
+/// Log lines kept in memory for the excerpt in a crash report.
+pub const LOG_EXCERPT_LINES: usize = 200;
+
+const REPORT_PREFIX: &str = "crash-";
+const REPORT_SUFFIX: &str = ".txt";
+
+static RECENT_LOG: Mutex<VecDeque<String>> = Mutex::new(VecDeque::new());
+static PANIC_ABORT: AtomicBool = AtomicBool::new(false);
+static HOOK: Once = Once::new();
+
+thread_local! {
+    static LAST_PANIC: RefCell<Option<(Option<String>, String)>> = const { RefCell::new(None) };
+}
+
+/// Feed formatted log output into the excerpt ring. Only what the `log` targets actually
+/// write ends up here, so No-Log instances produce reports without log lines.
+pub fn record_log_output(buf: &[u8]) {
+    let mut ring = RECENT_LOG.lock().unwrap_or_else(|e| e.into_inner());
+    push_log_lines(&mut ring, buf, LOG_EXCERPT_LINES);
+}
+
+fn push_log_lines(ring: &mut VecDeque<String>, buf: &[u8], cap: usize) {
+    let text = String::from_utf8_lossy(buf);
+    for line in text.lines().filter(|l| !l.trim().is_empty()) {
+        if ring.len() == cap {
+            ring.pop_front();
+        }
+        ring.push_back(line.to_string());
+    }
+}
+
+/// Most recent log lines, oldest first.
+pub fn recent_log_lines() -> Vec<String> {
+    let ring = RECENT_LOG.lock().unwrap_or_else(|e| e.into_inner());
+    ring.iter().cloned().collect()
+}
+
+/// Capture location and backtrace of every panic for [`catch_panic`]; with `abort`
+/// (`panic_abort yes`) the process aborts after the default panic message instead.
+///
+/// The hook is installed once; later calls only update the abort flag.
+pub fn install_panic_hook(abort: bool) {
+    PANIC_ABORT.store(abort, Ordering::Relaxed);
+    HOOK.call_once(|| {
+        let previous = std::panic::take_hook();
+        std::panic::set_hook(Box::new(move |info| {
+            let location = info
+                .location()
+                .map(|l| format!("{}:{}", l.file(), l.line()));
+            let stack = std::backtrace::Backtrace::force_capture().to_string();
+            LAST_PANIC.with(|last| *last.borrow_mut() = Some((location, stack)));
+            previous(info);
+            if PANIC_ABORT.load(Ordering::Relaxed) {
+                std::process::abort();
+            }
+        }));
+    });
+}
+
+/// A panic caught by [`catch_panic`].
+#[derive(Debug, Clone)]
+pub struct Panicked {
+    pub message: String,
+    /// `file:line` of the panic; needs [`install_panic_hook`].
+    pub location: Option<String>,
+    /// Backtrace captured by the panic hook; empty without it.
+    pub stack: String,
+}
+
+impl Panicked {
+    fn from_payload(payload: Box<dyn Any + Send>) -> Self {
+        let message = if let Some(s) = payload.downcast_ref::<&str>() {
+            (*s).to_string()
+        } else if let Some(s) = payload.downcast_ref::<String>() {
+            s.clone()
+        } else {
+            "non-string panic payload".to_string()
+        };
+        let (location, stack) = LAST_PANIC
+            .with(|last| last.borrow_mut().take())
+            .unwrap_or_default();
+        Self {
+            message,
+            location,
+            stack,
+        }
+    }
+}
+
+/// Run `fut`, turning a panic in any of its polls into `Err` instead of unwinding the task.
+///
+/// The future is dropped after a panic; callers must not reuse state it borrowed mutably
+/// beyond closing the connection.
+pub async fn catch_panic<F: Future>(fut: F) -> Result<F::Output, Panicked> {
+    AssertUnwindSafe(fut)
+        .catch_unwind()
+        .await
+        .map_err(Panicked::from_payload)
+}
+
+/// Where a panic happened: the module (`imap`, `smtp`, `submission`, `http`, …), the
+/// listener or session instance, and request metadata for the log and report.
+#[derive(Debug, Clone, Default)]
+pub struct CrashScope {
+    pub module: String,
+    pub instance: String,
+    pub fields: Vec<(&'static str, String)>,
+}
+
+impl CrashScope {
+    pub fn new(module: impl Into<String>, instance: impl Into<String>) -> Self {
+        Self {
+            module: module.into(),
+            instance: instance.into(),
+            fields: Vec::new(),
+        }
+    }
+
+    pub fn field(mut self, name: &'static str, value: impl std::fmt::Display) -> Self {
+        self.fields.push((name, value.to_string()));
+        self
+    }
+}
+
+#[derive(Debug)]
+pub struct CrashReporter {
+    dir: PathBuf,
+    keep: usize,
+    version: &'static str,
+    config_hash: String,
+    counts: Mutex<BTreeMap<String, u64>>,
+    last_report: Mutex<Option<PathBuf>>,
+}
+
+impl CrashReporter {
+    pub fn new(dir: impl Into<PathBuf>, keep: usize, config_hash: impl Into<String>) -> Self {
+        Self {
+            dir: dir.into(),
+            keep: keep.max(1),
+            version: env!("CARGO_PKG_VERSION"),
+            config_hash: config_hash.into(),
+            counts: Mutex::new(BTreeMap::new()),
+            last_report: Mutex::new(None),
+        }
+    }
+
+    /// `crash_dir` (default `<state_dir>/crashes`) and `crash_keep`.
+    pub fn from_config(state_dir: &Path, config: &AppConfig) -> Self {
+        let dir = config
+            .crash_dir
+            .clone()
+            .unwrap_or_else(|| state_dir.join("crashes"));
+        let keep = config.crash_keep.map_or(DEFAULT_CRASH_KEEP, |k| k as usize);
+        Self::new(dir, keep, config_hash(config))
+    }
+
+    pub fn dir(&self) -> &Path {
+        &self.dir
+    }
+
+    /// Log, count and write a report for `panic`; returns the report path when written.
+    pub fn report(&self, scope: &CrashScope, panic: &Panicked) -> Option<PathBuf> {
+        *self
+            .counts
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .entry(scope.module.clone())
+            .or_default() += 1;
+        chatmail_metrics::record_panic_recovered(&scope.module);
+        let fields = scope
+            .fields
+            .iter()
+            .map(|(k, v)| format!("{k}={v}"))
+            .collect::<Vec<_>>()
+            .join(" ");
+        tracing::error!(
+            module = %scope.module,
+            instance = %scope.instance,
+            location = panic.location.as_deref().unwrap_or("unknown"),
+            %fields,
+            stack = %panic.stack,
+            "recovered from panic: {}",
+            panic.message
+        );
+        match self.write_report(scope, panic) {
+            Ok(path) => {
+                *self.last_report.lock().unwrap_or_else(|e| e.into_inner()) = Some(path.clone());
+                Some(path)
+            }
+            Err(e) => {
+                tracing::warn!(dir = %self.dir.display(), error = %e, "crash report not written");
+                None
+            }
+        }
+    }
+
+    /// Recovered panics per module since start.
+    pub fn counts(&self) -> BTreeMap<String, u64> {
+        self.counts
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+    }
+
+    pub fn total(&self) -> u64 {
+        self.counts().values().sum()
+    }
+
+    pub fn last_report(&self) -> Option<PathBuf> {
+        self.last_report
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+    }
+
+    fn write_report(&self, scope: &CrashScope, panic: &Panicked) -> std::io::Result<PathBuf> {
+        std::fs::create_dir_all(&self.dir)?;
+        let now = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .unwrap_or_default();
+        let module: String = scope
+            .module
+            .chars()
+            .map(|c| if c.is_ascii_alphanumeric() { c } else { '_' })
+            .collect();
+        // Zero-padded millis keep lexical order chronological for rotation.
+        let mut path = self.dir.join(format!(
+            "{REPORT_PREFIX}{:015}-{module}{REPORT_SUFFIX}",
+            now.as_millis()
+        ));
+        let mut n = 1;
+        while path.exists() {
+            path = self.dir.join(format!(
+                "{REPORT_PREFIX}{:015}-{module}-{n}{REPORT_SUFFIX}",
+                now.as_millis()
+            ));
+            n += 1;
+        }
+
+        let mut out = String::new();
+        out.push_str("madmail crash report\n");
+        out.push_str(&format!("time: {}\n", now.as_secs()));
+        out.push_str(&format!("version: {}\n", self.version));
+        out.push_str(&format!("config_sha256: {}\n", self.config_hash));
+        out.push_str(&format!("module: {}\n", scope.module));
+        out.push_str(&format!("instance: {}\n", scope.instance));
+        for (k, v) in &scope.fields {
+            out.push_str(&format!("{k}: {v}\n"));
+        }
+        out.push_str(&format!("panic: {}\n", panic.message));
+        out.push_str(&format!(
+            "location: {}\n",
+            panic.location.as_deref().unwrap_or("unknown")
+        ));
+        out.push_str("\n--- stack ---\n");
+        if panic.stack.is_empty() {
+            out.push_str("(unavailable: panic hook not installed)\n");
+        } else {
+            out.push_str(&panic.stack);
+            out.push('\n');
+        }
+        out.push_str("\n--- recent log ---\n");
+        for line in recent_log_lines() {
+            out.push_str(&line);
+            out.push('\n');
+        }
+        std::fs::write(&path, out)?;
+        self.rotate();
+        Ok(path)
+    }
+
+    /// Drop the oldest reports beyond `keep`.
+    fn rotate(&self) {
+        let Ok(entries) = std::fs::read_dir(&self.dir) else {
+            return;
+        };
+        let mut reports: Vec<PathBuf> = entries
+            .filter_map(|e| e.ok())
+            .map(|e| e.path())
+            .filter(|p| {
+                p.file_name()
+                    .and_then(|n| n.to_str())
+                    .is_some_and(|n| n.starts_with(REPORT_PREFIX) && n.ends_with(REPORT_SUFFIX))
+            })
+            .collect();
+        if reports.len() <= self.keep {
+            return;
+        }
+        reports.sort();
+        for old in &reports[..reports.len() - self.keep] {
+            let _ = std::fs::remove_file(old);
+        }
+    }
+}
+
+/// Short SHA-256 of the effective configuration, to tell reports from different configs apart
+/// without copying secrets into them.
+pub fn config_hash(config: &AppConfig) -> String {
+    let digest = Sha256::digest(format!("{config:?}").as_bytes());
+    digest[..8].iter().map(|b| format!("{b:02x}")).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn reports_in(dir: &Path) -> Vec<PathBuf> {
+        let mut v: Vec<PathBuf> = std::fs::read_dir(dir)
+            .map(|r| r.filter_map(|e| e.ok()).map(|e| e.path()).collect())
+            .unwrap_or_default();
+        v.sort();
+        v
+    }
+
+    #[tokio::test]
+    async fn caught_panic_is_counted_and_reported() {
+        install_panic_hook(false);
+        let tmp = tempfile::tempdir().unwrap();
+        let reporter = CrashReporter::new(tmp.path().join("crashes"), 5, "abc123");
+        record_log_output(b"INFO before the crash\n");
+
+        let panicked = catch_panic(async { panic!("boom {}", 7) })
+            .await
+            .unwrap_err();
+        assert_eq!(panicked.message, "boom 7");
+        assert!(panicked.location.as_deref().unwrap().contains("crash.rs"));
+
+        let scope = CrashScope::new("imap", "127.0.0.1:143")
+            .field("peer", "192.0.2.1")
+            .field("command", "FETCH");
+        let path = reporter.report(&scope, &panicked).expect("report written");
+        let report = std::fs::read_to_string(&path).unwrap();
+        assert!(report.contains("module: imap"), "{report}");
+        assert!(report.contains("command: FETCH"), "{report}");
+        assert!(report.contains("panic: boom 7"), "{report}");
+        assert!(report.contains("config_sha256: abc123"), "{report}");
+        assert!(report.contains(env!("CARGO_PKG_VERSION")), "{report}");
+        assert!(report.contains("INFO before the crash"), "{report}");
+        assert_eq!(reporter.counts().get("imap"), Some(&1));
+        assert_eq!(reporter.last_report(), Some(path));
+
+        assert_eq!(catch_panic(async { 5 }).await.unwrap(), 5);
+        assert_eq!(reporter.total(), 1);
+    }
+
+    #[test]
+    fn reports_rotate_to_keep() {
+        let tmp = tempfile::tempdir().unwrap();
+        let reporter = CrashReporter::new(tmp.path(), 3, "h");
+        let panicked = Panicked {
+            message: "x".into(),
+            location: None,
+            stack: String::new(),
+        };
+        for _ in 0..5 {
+            reporter
+                .report(&CrashScope::new("http", "test"), &panicked)
+                .unwrap();
+        }
+        let left = reports_in(tmp.path());
+        assert_eq!(left.len(), 3);
+        assert_eq!(left.last(), reporter.last_report().as_ref());
+        assert_eq!(reporter.counts().get("http"), Some(&5));
+    }
+
+    #[test]
+    fn log_ring_keeps_the_newest_lines() {
+        let mut ring = VecDeque::new();
+        push_log_lines(&mut ring, b"one\ntwo\n\n", 3);
+        push_log_lines(&mut ring, b"three\nfour\n", 3);
+        assert_eq!(ring, ["two", "three", "four"]);
+    }
+
+    #[test]
+    fn config_hash_tracks_config_changes() {
+        let a = AppConfig::default();
+        let b = AppConfig {
+            debug: true,
+            ..AppConfig::default()
+        };
+        assert_eq!(config_hash(&a), config_hash(&a));
+        assert_ne!(config_hash(&a), config_hash(&b));
+        assert_eq!(config_hash(&a).len(), 16);
+    }
+}
@@ -0,0 +1,313 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Disk pressure guard for the state directory.
+//!
+//! SQLite and maildirs share one filesystem; once it is full both corrupt together. A probe
+//! feeds [`DiskGuard::refresh`] and the protocol endpoints ask the cached [`DiskTier`]:
+//!
+//! - [`DiskTier::Soft`] (`disk_soft_limit`, 85%): no new accounts, and messages over
+//!   `disk_soft_max_message` get 452;
+//! - [`DiskTier::Hard`] (`disk_hard_limit`, 95%): storage is read-only, deliveries get 451
+//!   and IMAP keeps serving reads.
+//!
+//! Tiers are recomputed on every probe, so recovery needs no operator action. Space promised
+//! to work in progress (takeout archives being written) is [reserved](DiskGuard::reserve) and
+//! counted as used until it is released.
+
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::RwLock;
+
+use chatmail_config::AppConfig;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum DiskTier {
+    Normal,
+    Soft,
+    Hard,
+}
+
+impl DiskTier {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Normal => "normal",
+            Self::Soft => "soft",
+            Self::Hard => "hard",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct DiskUsage {
+    pub total_bytes: u64,
+    pub available_bytes: u64,
+}
+
+impl DiskUsage {
+    pub fn used_bytes(&self) -> u64 {
+        self.total_bytes.saturating_sub(self.available_bytes)
+    }
+
+    pub fn percent_used(&self) -> f64 {
+        if self.total_bytes == 0 {
+            return 0.0;
+        }
+        self.used_bytes() as f64 / self.total_bytes as f64 * 100.0
+    }
+}
+
+/// Source of [`DiskUsage`]; tests substitute a fixed value.
+pub trait DiskProbe: Send + Sync {
+    fn usage(&self) -> Option<DiskUsage>;
+}
+
+/// `statvfs(2)` on a directory (space available to unprivileged users).
+#[derive(Debug, Clone)]
+pub struct StatvfsProbe {
+    path: PathBuf,
+}
+
+impl StatvfsProbe {
+    pub fn new(path: impl Into<PathBuf>) -> Self {
+        Self { path: path.into() }
+    }
+}
+
+impl DiskProbe for StatvfsProbe {
+    fn usage(&self) -> Option<DiskUsage> {
+        disk_usage(&self.path)
+    }
+}
+
+#[cfg(unix)]
+pub fn disk_usage(path: &Path) -> Option<DiskUsage> {
+    use std::ffi::CString;
+    use std::mem::MaybeUninit;
+
+    let cpath = CString::new(path.to_str()?).ok()?;
+    let mut stat = MaybeUninit::<libc::statvfs>::uninit();
+    if unsafe { libc::statvfs(cpath.as_ptr(), stat.as_mut_ptr()) } != 0 {
+        return None;
+    }
+    let stat = unsafe { stat.assume_init() };
+    let bsize = stat.f_frsize as u64;
+    Some(DiskUsage {
+        total_bytes: stat.f_blocks as u64 * bsize,
+        available_bytes: stat.f_bavail as u64 * bsize,
+    })
+}
+
+#[cfg(not(unix))]
+pub fn disk_usage(_path: &Path) -> Option<DiskUsage> {
+    None
+}
+
+#[derive(Debug)]
+pub struct DiskGuard {
+    soft_percent: f64,
+    hard_percent: f64,
+    soft_max_message: u64,
+    reserved: AtomicU64,
+    state: RwLock<(DiskTier, Option<DiskUsage>)>,
+}
+
+impl Default for DiskGuard {
+    fn default() -> Self {
+        Self::from_config(&AppConfig::default())
+    }
+}
+
+impl DiskGuard {
+    pub fn new(soft_percent: f64, hard_percent: f64, soft_max_message: u64) -> Self {
+        Self {
+            soft_percent,
+            hard_percent: hard_percent.max(soft_percent),
+            soft_max_message,
+            reserved: AtomicU64::new(0),
+            state: RwLock::new((DiskTier::Normal, None)),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(
+            config.effective_disk_soft_percent(),
+            config.effective_disk_hard_percent(),
+            config.effective_disk_soft_max_message(),
+        )
+    }
+
+    pub fn soft_percent(&self) -> f64 {
+        self.soft_percent
+    }
+
+    pub fn hard_percent(&self) -> f64 {
+        self.hard_percent
+    }
+
+    pub fn soft_max_message(&self) -> u64 {
+        self.soft_max_message
+    }
+
+    /// Read the probe and update the tier. Returns the new tier when it changed.
+    /// A failed probe keeps the previous tier.
+    pub fn refresh(&self, probe: &dyn DiskProbe) -> Option<DiskTier> {
+        let mut usage = probe.usage()?;
+        usage.available_bytes = usage.available_bytes.saturating_sub(self.reserved_bytes());
+        let pct = usage.percent_used();
+        let tier = if pct >= self.hard_percent {
+            DiskTier::Hard
+        } else if pct >= self.soft_percent {
+            DiskTier::Soft
+        } else {
+            DiskTier::Normal
+        };
+        let prev = {
+            let mut state = self.state.write().unwrap_or_else(|e| e.into_inner());
+            let prev = state.0;
+            *state = (tier, Some(usage));
+            prev
+        };
+        if prev == tier {
+            return None;
+        }
+        match tier {
+            DiskTier::Hard => tracing::error!(
+                percent_used = pct,
+                limit = self.hard_percent,
+                "disk: hard limit reached; storage is read-only and deliveries are deferred"
+            ),
+            DiskTier::Soft => tracing::warn!(
+                percent_used = pct,
+                limit = self.soft_percent,
+                "disk: soft limit reached; registrations and large messages are refused"
+            ),
+            DiskTier::Normal => {
+                tracing::info!(percent_used = pct, "disk: usage back below soft limit")
+            }
+        }
+        Some(tier)
+    }
+
+    /// Count `bytes` as used from the next [`refresh`](Self::refresh) on.
+    pub fn reserve(&self, bytes: u64) {
+        self.reserved.fetch_add(bytes, Ordering::Relaxed);
+    }
+
+    /// Return a [`reserve`](Self::reserve)d amount once the data is on disk or abandoned.
+    pub fn release(&self, bytes: u64) {
+        let _ = self
+            .reserved
+            .fetch_update(Ordering::Relaxed, Ordering::Relaxed, |r| {
+                Some(r.saturating_sub(bytes))
+            });
+    }
+
+    pub fn reserved_bytes(&self) -> u64 {
+        self.reserved.load(Ordering::Relaxed)
+    }
+
+    pub fn tier(&self) -> DiskTier {
+        self.state.read().unwrap_or_else(|e| e.into_inner()).0
+    }
+
+    pub fn usage(&self) -> Option<DiskUsage> {
+        self.state.read().unwrap_or_else(|e| e.into_inner()).1
+    }
+
+    /// New accounts are only created below the soft limit.
+    pub fn accepts_registrations(&self) -> bool {
+        self.tier() == DiskTier::Normal
+    }
+
+    /// Whether a delivery of `size` bytes (0 when unknown yet) may be stored now.
+    /// `Err` carries the tier that refused it.
+    pub fn admit_message(&self, size: u64) -> Result<(), DiskTier> {
+        match self.tier() {
+            DiskTier::Normal => Ok(()),
+            DiskTier::Soft if size <= self.soft_max_message => Ok(()),
+            tier => Err(tier),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    struct FakeProbe(Option<f64>);
+
+    impl DiskProbe for FakeProbe {
+        fn usage(&self) -> Option<DiskUsage> {
+            let pct = self.0?;
+            Some(DiskUsage {
+                total_bytes: 10_000,
+                available_bytes: 10_000 - (pct * 100.0) as u64,
+            })
+        }
+    }
+
+    #[test]
+    fn tiers_engage_and_recover() {
+        let guard = DiskGuard::new(85.0, 95.0, 1024);
+        assert_eq!(guard.refresh(&FakeProbe(Some(50.0))), None);
+        assert!(guard.accepts_registrations());
+        assert_eq!(guard.admit_message(1 << 20), Ok(()));
+
+        assert_eq!(guard.refresh(&FakeProbe(Some(90.0))), Some(DiskTier::Soft));
+        assert!(!guard.accepts_registrations());
+        assert_eq!(guard.admit_message(0), Ok(()));
+        assert_eq!(guard.admit_message(1024), Ok(()));
+        assert_eq!(guard.admit_message(1025), Err(DiskTier::Soft));
+
+        assert_eq!(guard.refresh(&FakeProbe(Some(96.0))), Some(DiskTier::Hard));
+        assert_eq!(guard.admit_message(0), Err(DiskTier::Hard));
+
+        // Probe failures keep the last known tier.
+        assert_eq!(guard.refresh(&FakeProbe(None)), None);
+        assert_eq!(guard.tier(), DiskTier::Hard);
+
+        assert_eq!(
+            guard.refresh(&FakeProbe(Some(40.0))),
+            Some(DiskTier::Normal)
+        );
+        assert!(guard.accepts_registrations());
+        assert_eq!(guard.admit_message(1 << 20), Ok(()));
+    }
+
+    #[test]
+    fn reservations_count_as_used() {
+        let guard = DiskGuard::new(85.0, 95.0, 1024);
+        guard.reserve(4_000);
+        // 50% used + 40% reserved.
+        assert_eq!(guard.refresh(&FakeProbe(Some(50.0))), Some(DiskTier::Soft));
+        guard.release(4_000);
+        guard.release(1);
+        assert_eq!(guard.reserved_bytes(), 0);
+        assert_eq!(
+            guard.refresh(&FakeProbe(Some(50.0))),
+            Some(DiskTier::Normal)
+        );
+    }
+
+    #[test]
+    fn statvfs_reads_temp_dir() {
+        let dir = tempfile::tempdir().unwrap();
+        if let Some(usage) = StatvfsProbe::new(dir.path()).usage() {
+            assert!(usage.total_bytes >= usage.available_bytes);
+        }
+    }
+}
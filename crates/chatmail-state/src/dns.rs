@@ -0,0 +1,442 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! DNS lookups for inbound mail authentication (DKIM keys, DMARC and SPF records).
+//!
+//! The workspace has no resolver crate; [`SystemResolver`] speaks plain DNS to the nameservers
+//! in `/etc/resolv.conf` (UDP with EDNS0, TCP when truncated). Only the record types the
+//! checks need are supported. Special-use names (`.test`, `.invalid`, `.localhost`,
+//! `.example`) are answered `NotFound` without a query.
+
+use std::collections::HashMap;
+use std::net::{IpAddr, Ipv4Addr, Ipv6Addr, SocketAddr};
+use std::time::Duration;
+
+use async_trait::async_trait;
+use tokio::io::{AsyncReadExt, AsyncWriteExt};
+use tokio::net::{TcpStream, UdpSocket};
+
+/// Per-query timeout (each nameserver is tried in turn).
+pub const DNS_TIMEOUT: Duration = Duration::from_secs(3);
+
+/// EDNS0 UDP payload size advertised in queries (DNS flag day 2020 value).
+const EDNS_UDP_SIZE: u16 = 1232;
+
+const TYPE_A: u16 = 1;
+const TYPE_MX: u16 = 15;
+const TYPE_TXT: u16 = 16;
+const TYPE_AAAA: u16 = 28;
+const TYPE_OPT: u16 = 41;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum DnsError {
+    /// NXDOMAIN or no records of the requested type.
+    NotFound,
+    /// Timeout, SERVFAIL or a malformed answer; callers report `temperror`.
+    Temporary(String),
+}
+
+impl std::fmt::Display for DnsError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::NotFound => f.write_str("no such record"),
+            Self::Temporary(e) => write!(f, "dns lookup failed: {e}"),
+        }
+    }
+}
+
+#[async_trait]
+pub trait DnsResolver: Send + Sync {
+    /// TXT records at `name`; the character-strings of one record are concatenated.
+    async fn txt(&self, name: &str) -> Result<Vec<String>, DnsError>;
+    /// MX exchange hosts at `name`, lowest preference first.
+    async fn mx(&self, name: &str) -> Result<Vec<String>, DnsError>;
+    /// A and AAAA addresses of `name`.
+    async fn ips(&self, name: &str) -> Result<Vec<IpAddr>, DnsError>;
+}
+
+/// Names that never resolve on the public DNS (RFC 2606, RFC 6761).
+pub fn is_special_use(name: &str) -> bool {
+    let name = name.trim_end_matches('.').to_ascii_lowercase();
+    let tld = name.rsplit('.').next().unwrap_or("");
+    matches!(tld, "test" | "invalid" | "localhost" | "example")
+}
+
+/// Stub resolver over the system nameservers.
+#[derive(Debug, Clone)]
+pub struct SystemResolver {
+    servers: Vec<SocketAddr>,
+    timeout: Duration,
+}
+
+impl Default for SystemResolver {
+    fn default() -> Self {
+        Self::from_resolv_conf()
+    }
+}
+
+impl SystemResolver {
+    pub fn new(servers: Vec<SocketAddr>, timeout: Duration) -> Self {
+        Self { servers, timeout }
+    }
+
+    /// `nameserver` lines from `/etc/resolv.conf`; `127.0.0.1:53` when there are none.
+    pub fn from_resolv_conf() -> Self {
+        let text = std::fs::read_to_string("/etc/resolv.conf").unwrap_or_default();
+        let mut servers = parse_resolv_conf(&text);
+        if servers.is_empty() {
+            servers.push(SocketAddr::from((Ipv4Addr::LOCALHOST, 53)));
+        }
+        Self::new(servers, DNS_TIMEOUT)
+    }
+
+    async fn query(&self, name: &str, qtype: u16) -> Result<Vec<Record>, DnsError> {
+        if is_special_use(name) {
+            return Err(DnsError::NotFound);
+        }
+        let id: u16 = rand::random();
+        let packet = build_query(id, name, qtype)
+            .ok_or_else(|| DnsError::Temporary(format!("invalid name {name:?}")))?;
+        let mut last = DnsError::Temporary("no nameserver configured".into());
+        for server in &self.servers {
+            match tokio::time::timeout(self.timeout, exchange(*server, id, &packet)).await {
+                Ok(Ok(answer)) => return parse_answer(&answer, id, qtype),
+                Ok(Err(e)) => last = DnsError::Temporary(format!("{server}: {e}")),
+                Err(_) => last = DnsError::Temporary(format!("{server}: timed out")),
+            }
+        }
+        Err(last)
+    }
+}
+
+#[async_trait]
+impl DnsResolver for SystemResolver {
+    async fn txt(&self, name: &str) -> Result<Vec<String>, DnsError> {
+        let records = self.query(name, TYPE_TXT).await?;
+        Ok(records
+            .into_iter()
+            .filter_map(|r| match r {
+                Record::Txt(t) => Some(t),
+                _ => None,
+            })
+            .collect())
+    }
+
+    async fn mx(&self, name: &str) -> Result<Vec<String>, DnsError> {
+        let mut hosts: Vec<(u16, String)> = self
+            .query(name, TYPE_MX)
+            .await?
+            .into_iter()
+            .filter_map(|r| match r {
+                Record::Mx(pref, host) => Some((pref, host)),
+                _ => None,
+            })
+            .collect();
+        hosts.sort();
+        Ok(hosts.into_iter().map(|(_, h)| h).collect())
+    }
+
+    async fn ips(&self, name: &str) -> Result<Vec<IpAddr>, DnsError> {
+        let mut out = Vec::new();
+        let mut not_found = 0;
+        for qtype in [TYPE_A, TYPE_AAAA] {
+            match self.query(name, qtype).await {
+                Ok(records) => out.extend(records.into_iter().filter_map(|r| match r {
+                    Record::Ip(ip) => Some(ip),
+                    _ => None,
+                })),
+                Err(DnsError::NotFound) => not_found += 1,
+                Err(e) => return Err(e),
+            }
+        }
+        if out.is_empty() && not_found == 2 {
+            return Err(DnsError::NotFound);
+        }
+        Ok(out)
+    }
+}
+
+/// Test double: fixed answers; every other name is `NotFound`.
+#[derive(Debug, Clone, Default)]
+pub struct StaticResolver {
+    pub txt: HashMap<String, Vec<String>>,
+    pub mx: HashMap<String, Vec<String>>,
+    pub ips: HashMap<String, Vec<IpAddr>>,
+}
+
+impl StaticResolver {
+    pub fn with_txt(mut self, name: &str, record: &str) -> Self {
+        self.txt
+            .entry(name.to_ascii_lowercase())
+            .or_default()
+            .push(record.to_string());
+        self
+    }
+}
+
+fn lookup<T: Clone>(map: &HashMap<String, Vec<T>>, name: &str) -> Result<Vec<T>, DnsError> {
+    let key = name.trim_end_matches('.').to_ascii_lowercase();
+    map.get(&key)
+        .filter(|v| !v.is_empty())
+        .cloned()
+        .ok_or(DnsError::NotFound)
+}
+
+#[async_trait]
+impl DnsResolver for StaticResolver {
+    async fn txt(&self, name: &str) -> Result<Vec<String>, DnsError> {
+        lookup(&self.txt, name)
+    }
+
+    async fn mx(&self, name: &str) -> Result<Vec<String>, DnsError> {
+        lookup(&self.mx, name)
+    }
+
+    async fn ips(&self, name: &str) -> Result<Vec<IpAddr>, DnsError> {
+        lookup(&self.ips, name)
+    }
+}
+
+fn parse_resolv_conf(text: &str) -> Vec<SocketAddr> {
+    text.lines()
+        .filter_map(|line| {
+            let mut parts = line.split_whitespace();
+            (parts.next()? == "nameserver").then_some(())?;
+            // Drop an IPv6 zone (`fe80::1%eth0`); link-local servers are rare on mail hosts.
+            let addr = parts.next()?.split('%').next()?;
+            addr.parse::<IpAddr>()
+                .ok()
+                .map(|ip| SocketAddr::new(ip, 53))
+        })
+        .collect()
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Record {
+    Txt(String),
+    Mx(u16, String),
+    Ip(IpAddr),
+}
+
+fn build_query(id: u16, name: &str, qtype: u16) -> Option<Vec<u8>> {
+    let mut p = Vec::with_capacity(64);
+    p.extend_from_slice(&id.to_be_bytes());
+    p.extend_from_slice(&[0x01, 0x00]); // RD
+    p.extend_from_slice(&[0, 1, 0, 0, 0, 0, 0, 1]); // QD=1, AN=0, NS=0, AR=1 (OPT)
+    for label in name.trim_end_matches('.').split('.') {
+        if label.is_empty() || label.len() > 63 {
+            return None;
+        }
+        p.push(label.len() as u8);
+        p.extend_from_slice(label.as_bytes());
+    }
+    p.push(0);
+    p.extend_from_slice(&qtype.to_be_bytes());
+    p.extend_from_slice(&[0, 1]); // class IN
+
+    // OPT pseudo-record: root name, type, UDP size, extended rcode/version/flags, no options.
+    p.push(0);
+    p.extend_from_slice(&TYPE_OPT.to_be_bytes());
+    p.extend_from_slice(&EDNS_UDP_SIZE.to_be_bytes());
+    p.extend_from_slice(&[0, 0, 0, 0, 0, 0]);
+    (p.len() <= 512).then_some(p)
+}
+
+async fn exchange(server: SocketAddr, id: u16, packet: &[u8]) -> std::io::Result<Vec<u8>> {
+    let bind: SocketAddr = match server {
+        SocketAddr::V4(_) => (Ipv4Addr::UNSPECIFIED, 0).into(),
+        SocketAddr::V6(_) => (Ipv6Addr::UNSPECIFIED, 0).into(),
+    };
+    let socket = UdpSocket::bind(bind).await?;
+    socket.connect(server).await?;
+    socket.send(packet).await?;
+    let mut buf = vec![0u8; EDNS_UDP_SIZE as usize];
+    loop {
+        let n = socket.recv(&mut buf).await?;
+        // Ignore stray datagrams that do not answer this query.
+        if n < 12 || u16::from_be_bytes([buf[0], buf[1]]) != id {
+            continue;
+        }
+        let truncated = buf[2] & 0x02 != 0;
+        if !truncated {
+            buf.truncate(n);
+            return Ok(buf);
+        }
+        break;
+    }
+
+    let mut stream = TcpStream::connect(server).await?;
+    let mut framed = (packet.len() as u16).to_be_bytes().to_vec();
+    framed.extend_from_slice(packet);
+    stream.write_all(&framed).await?;
+    let len = stream.read_u16().await? as usize;
+    let mut answer = vec![0u8; len];
+    stream.read_exact(&mut answer).await?;
+    Ok(answer)
+}
+
+fn parse_answer(msg: &[u8], id: u16, qtype: u16) -> Result<Vec<Record>, DnsError> {
+    let bad = || DnsError::Temporary("malformed answer".into());
+    if msg.len() < 12 || u16::from_be_bytes([msg[0], msg[1]]) != id {
+        return Err(bad());
+    }
+    match msg[3] & 0x0f {
+        0 => {}
+        3 => return Err(DnsError::NotFound),
+        rcode => return Err(DnsError::Temporary(format!("rcode {rcode}"))),
+    }
+    let qdcount = u16::from_be_bytes([msg[4], msg[5]]);
+    let ancount = u16::from_be_bytes([msg[6], msg[7]]);
+    let mut pos = 12;
+    for _ in 0..qdcount {
+        pos = read_name(msg, pos).ok_or_else(bad)?.1 + 4;
+    }
+    let mut out = Vec::new();
+    for _ in 0..ancount {
+        pos = read_name(msg, pos).ok_or_else(bad)?.1;
+        let head = msg.get(pos..pos + 10).ok_or_else(bad)?;
+        let rtype = u16::from_be_bytes([head[0], head[1]]);
+        let rdlen = u16::from_be_bytes([head[8], head[9]]) as usize;
+        let start = pos + 10;
+        let rdata = msg.get(start..start + rdlen).ok_or_else(bad)?;
+        pos = start + rdlen;
+        // CNAME chains are followed by the recursive server; only keep the asked-for type.
+        if rtype != qtype {
+            continue;
+        }
+        let record = match rtype {
+            TYPE_TXT => {
+                let mut text = Vec::new();
+                let mut i = 0;
+                while i < rdata.len() {
+                    let len = rdata[i] as usize;
+                    text.extend_from_slice(rdata.get(i + 1..i + 1 + len).ok_or_else(bad)?);
+                    i += 1 + len;
+                }
+                Record::Txt(String::from_utf8_lossy(&text).into_owned())
+            }
+            TYPE_MX if rdlen >= 3 => {
+                let pref = u16::from_be_bytes([rdata[0], rdata[1]]);
+                let (host, _) = read_name(msg, start + 2).ok_or_else(bad)?;
+                Record::Mx(pref, host)
+            }
+            TYPE_A if rdlen == 4 => {
+                Record::Ip(IpAddr::from([rdata[0], rdata[1], rdata[2], rdata[3]]))
+            }
+            TYPE_AAAA if rdlen == 16 => {
+                let octets: [u8; 16] = rdata.try_into().map_err(|_| bad())?;
+                Record::Ip(IpAddr::from(octets))
+            }
+            _ => return Err(bad()),
+        };
+        out.push(record);
+    }
+    if out.is_empty() {
+        return Err(DnsError::NotFound);
+    }
+    Ok(out)
+}
+
+/// Decode a (possibly compressed) name at `pos`; returns it and the offset after it.
+fn read_name(msg: &[u8], mut pos: usize) -> Option<(String, usize)> {
+    let mut labels: Vec<String> = Vec::new();
+    let mut end = None;
+    for _ in 0..128 {
+        let len = *msg.get(pos)? as usize;
+        match len {
+            0 => {
+                let next = end.unwrap_or(pos + 1);
+                return Some((labels.join("."), next));
+            }
+            l if l & 0xc0 == 0xc0 => {
+                let ptr = ((l & 0x3f) << 8) | *msg.get(pos + 1)? as usize;
+                end.get_or_insert(pos + 2);
+                pos = ptr;
+            }
+            l if l <= 63 => {
+                let label = msg.get(pos + 1..pos + 1 + l)?;
+                labels.push(String::from_utf8_lossy(label).to_ascii_lowercase());
+                pos += 1 + l;
+            }
+            _ => return None,
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Answer to `build_query(7, "peer.example.org", TXT)` with one split TXT record.
+    fn txt_answer() -> Vec<u8> {
+        let mut msg = build_query(7, "peer.example.org", TYPE_TXT).unwrap();
+        msg.truncate(msg.len() - 11); // drop OPT
+        msg[2] = 0x81;
+        msg[3] = 0x80;
+        msg[7] = 1; // ANCOUNT
+        msg[11] = 0; // ARCOUNT
+        msg.extend_from_slice(&[0xc0, 12]); // name -> question
+        msg.extend_from_slice(&[0, 16, 0, 1, 0, 0, 1, 0]);
+        let rdata: &[u8] = b"\x08v=spf1 a\x05 -all";
+        msg.extend_from_slice(&(rdata.len() as u16).to_be_bytes());
+        msg.extend_from_slice(rdata);
+        msg
+    }
+
+    #[test]
+    fn parses_compressed_txt_answer_and_joins_strings() {
+        let records = parse_answer(&txt_answer(), 7, TYPE_TXT).unwrap();
+        assert_eq!(records, vec![Record::Txt("v=spf1 a -all".into())]);
+    }
+
+    #[test]
+    fn nxdomain_and_wrong_id_are_distinguished() {
+        let mut msg = txt_answer();
+        msg[3] = 0x83;
+        assert_eq!(parse_answer(&msg, 7, TYPE_TXT), Err(DnsError::NotFound));
+        assert!(matches!(
+            parse_answer(&txt_answer(), 8, TYPE_TXT),
+            Err(DnsError::Temporary(_))
+        ));
+    }
+
+    #[test]
+    fn resolv_conf_nameservers() {
+        let servers = parse_resolv_conf(
+            "# comment\nsearch lan\nnameserver 192.0.2.53\nnameserver fe80::1%eth0\n",
+        );
+        assert_eq!(
+            servers,
+            vec![
+                "192.0.2.53:53".parse().unwrap(),
+                "[fe80::1]:53".parse().unwrap()
+            ]
+        );
+    }
+
+    #[tokio::test]
+    async fn special_use_names_are_never_queried() {
+        let resolver = SystemResolver::new(vec![], DNS_TIMEOUT);
+        assert_eq!(
+            resolver.txt("_dmarc.peer.test").await,
+            Err(DnsError::NotFound)
+        );
+        assert!(is_special_use("mail.example."));
+        assert!(!is_special_use("example.org"));
+    }
+}
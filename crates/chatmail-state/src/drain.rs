@@ -0,0 +1,122 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Shutdown drain: stop taking new work, then wait for in-flight work before storage closes.
+//!
+//! Endpoints ask [`DrainGate::is_draining`] before starting a new transaction (SMTP `MAIL`,
+//! `/mxdeliv`) and hold a [`DrainGuard`] from [`DrainGate::track`] while they write. The
+//! supervisor calls [`DrainGate::start_draining`], closes listeners, and waits in
+//! [`DrainGate::wait_idle`] before the database pool is closed.
+
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::sync::Arc;
+use std::time::Duration;
+
+use tokio::sync::Notify;
+
+#[derive(Debug, Default)]
+struct Inner {
+    draining: AtomicBool,
+    in_flight: AtomicUsize,
+    idle: Notify,
+}
+
+/// Shared shutdown state; cheap to clone into each endpoint.
+#[derive(Debug, Clone, Default)]
+pub struct DrainGate {
+    inner: Arc<Inner>,
+}
+
+/// One unit of in-flight work; dropping it may wake [`DrainGate::wait_idle`].
+#[derive(Debug)]
+pub struct DrainGuard {
+    inner: Arc<Inner>,
+}
+
+impl Drop for DrainGuard {
+    fn drop(&mut self) {
+        if self.inner.in_flight.fetch_sub(1, Ordering::AcqRel) == 1 {
+            self.inner.idle.notify_waiters();
+        }
+    }
+}
+
+impl DrainGate {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Phase one of shutdown: endpoints refuse new transactions from now on.
+    pub fn start_draining(&self) {
+        self.inner.draining.store(true, Ordering::Release);
+    }
+
+    pub fn is_draining(&self) -> bool {
+        self.inner.draining.load(Ordering::Acquire)
+    }
+
+    /// Count work that must finish before shutdown closes storage. Work that already
+    /// passed the [`Self::is_draining`] check is tracked even while draining.
+    pub fn track(&self) -> DrainGuard {
+        self.inner.in_flight.fetch_add(1, Ordering::AcqRel);
+        DrainGuard {
+            inner: Arc::clone(&self.inner),
+        }
+    }
+
+    pub fn in_flight(&self) -> usize {
+        self.inner.in_flight.load(Ordering::Acquire)
+    }
+
+    /// Wait until no work is tracked or `limit` passes. Returns `true` when idle.
+    pub async fn wait_idle(&self, limit: Duration) -> bool {
+        let wait = async {
+            loop {
+                let notified = self.inner.idle.notified();
+                if self.in_flight() == 0 {
+                    return;
+                }
+                notified.await;
+            }
+        };
+        tokio::time::timeout(limit, wait).await.is_ok()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn wait_idle_waits_for_tracked_work() {
+        let gate = DrainGate::new();
+        assert!(gate.wait_idle(Duration::from_millis(10)).await);
+
+        let guard = gate.track();
+        gate.start_draining();
+        assert!(gate.is_draining());
+        assert!(!gate.wait_idle(Duration::from_millis(20)).await);
+
+        let waiter = {
+            let gate = gate.clone();
+            tokio::spawn(async move { gate.wait_idle(Duration::from_secs(5)).await })
+        };
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        drop(guard);
+        assert!(waiter.await.unwrap());
+        assert_eq!(gate.in_flight(), 0);
+    }
+}
@@ -20,6 +20,8 @@ use std::sync::atomic::{AtomicU64, Ordering};
 use dashmap::DashMap;
 use tokio::sync::broadcast;
 
+use crate::junk::{JunkDirection, JunkReclassifiedEvent};
+
 #[derive(Debug, Clone)]
 pub struct NewMessageEvent {
     pub username: String,
@@ -50,6 +52,11 @@ pub struct EventBus {
     /// publisher or other subscribers; this gauge lets operators tell whether the burst buffer is
     /// undersized for the live load (sustained growth ⇒ raise `USER_CHANNEL_CAPACITY`).
     lagged_events: AtomicU64,
+    /// Server-wide junk reclassification fan-out (trainer task, stats). Unlike delivery events
+    /// these are not per-user: the consumers are operator-side integrations, not IMAP sessions.
+    junk: broadcast::Sender<JunkReclassifiedEvent>,
+    junk_spam: AtomicU64,
+    junk_ham: AtomicU64,
 }
 
 /// Per-user IDLE notification fan-out buffer.
@@ -61,6 +68,10 @@ pub struct EventBus {
 /// truly *fails* when there are no receivers at all (tracked via `no_receiver_drops`).
 const USER_CHANNEL_CAPACITY: usize = 256;
 
+/// Junk reclassification buffer. Publishing never blocks IMAP STORE/MOVE; a trainer that falls
+/// this far behind skips events (`Lagged`) rather than slowing the client.
+const JUNK_CHANNEL_CAPACITY: usize = 1024;
+
 impl EventBus {
     pub fn new() -> Self {
         Self {
@@ -68,6 +79,9 @@ impl EventBus {
             inbox_versions: DashMap::new(),
             no_receiver_drops: AtomicU64::new(0),
             lagged_events: AtomicU64::new(0),
+            junk: broadcast::channel(JUNK_CHANNEL_CAPACITY).0,
+            junk_spam: AtomicU64::new(0),
+            junk_ham: AtomicU64::new(0),
         }
     }
 
@@ -175,6 +189,31 @@ impl EventBus {
     pub fn subscribe(&self, username: &str) -> broadcast::Receiver<NewMessageEvent> {
         self.user_sender(username).subscribe()
     }
+
+    /// Publish a Junk reclassification (IMAP STORE `$Junk`/`$NotJunk`, MOVE across Junk).
+    ///
+    /// Call once per actual transition; the counters feed operator statistics even when no
+    /// trainer is subscribed.
+    pub fn publish_junk_reclassified(&self, event: JunkReclassifiedEvent) {
+        match event.direction {
+            JunkDirection::Spam => self.junk_spam.fetch_add(1, Ordering::Relaxed),
+            JunkDirection::Ham => self.junk_ham.fetch_add(1, Ordering::Relaxed),
+        };
+        // No subscriber (no `junk_training_url`) is the common case, not an error.
+        let _ = self.junk.send(event);
+    }
+
+    pub fn subscribe_junk(&self) -> broadcast::Receiver<JunkReclassifiedEvent> {
+        self.junk.subscribe()
+    }
+
+    /// `(spam, ham)` reclassification counts since boot.
+    pub fn junk_reclassified_counts(&self) -> (u64, u64) {
+        (
+            self.junk_spam.load(Ordering::Relaxed),
+            self.junk_ham.load(Ordering::Relaxed),
+        )
+    }
 }
 
 impl Default for EventBus {
@@ -289,4 +328,30 @@ mod tests {
         bus.record_lag();
         assert_eq!(bus.lagged_events(), 2);
     }
+
+    #[tokio::test]
+    async fn junk_reclassified_reaches_subscriber_and_counts() {
+        let bus = EventBus::new();
+        // Publishing with no subscriber is counted but never fails.
+        bus.publish_junk_reclassified(JunkReclassifiedEvent {
+            username: "a@test".into(),
+            mailbox: "Junk".into(),
+            msg_id: "m0".into(),
+            size: 10,
+            direction: JunkDirection::Spam,
+        });
+        let mut rx = bus.subscribe_junk();
+        bus.publish_junk_reclassified(JunkReclassifiedEvent {
+            username: "a@test".into(),
+            mailbox: "INBOX".into(),
+            msg_id: "m1".into(),
+            size: 42,
+            direction: JunkDirection::Ham,
+        });
+        let ev = rx.recv().await.unwrap();
+        assert_eq!(ev.msg_id, "m1");
+        assert_eq!(ev.size, 42);
+        assert_eq!(ev.direction, JunkDirection::Ham);
+        assert_eq!(bus.junk_reclassified_counts(), (1, 1));
+    }
 }
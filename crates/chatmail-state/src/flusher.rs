@@ -25,8 +25,12 @@ use tokio::task::JoinHandle;
 use tracing::debug;
 
 use crate::events::EventBus;
+use crate::share_views::ShareViews;
 use crate::tracker::FederationTracker;
 
+/// How often expired contact-sharing links are deleted from `sharing.db`.
+const SHARE_LINK_PRUNE_INTERVAL: Duration = Duration::from_secs(60 * 60);
+
 pub struct FlusherHandle {
     shutdown_tx: watch::Sender<bool>,
     task: JoinHandle<()>,
@@ -43,12 +47,15 @@ pub fn start_flusher(
     pool: DbPool,
     tracker: Arc<FederationTracker>,
     events: Arc<EventBus>,
+    share_views: Arc<ShareViews>,
 ) -> FlusherHandle {
     let (shutdown_tx, mut shutdown_rx) = watch::channel(false);
 
     let task = tokio::spawn(async move {
         let mut interval = tokio::time::interval(Duration::from_secs(30));
         interval.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        let mut link_prune = tokio::time::interval(SHARE_LINK_PRUNE_INTERVAL);
+        link_prune.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
 
         loop {
             tokio::select! {
@@ -61,11 +68,24 @@ pub fn start_flusher(
                     if let Err(e) = flush_modseq(&pool, &events).await {
                         tracing::warn!(error = %e, "mailbox modseq flush failed");
                     }
+                    if let Err(e) = share_views.flush().await {
+                        tracing::warn!(error = %e, "share view counters flush failed");
+                    }
+                }
+                _ = link_prune.tick() => {
+                    match share_views.prune_expired_links().await {
+                        Ok(0) => {}
+                        Ok(n) => debug!(removed = n, "expired sharing links pruned"),
+                        Err(e) => tracing::warn!(error = %e, "sharing link prune failed"),
+                    }
                 }
                 _ = shutdown_rx.changed() => {
                     if *shutdown_rx.borrow() {
                         let _ = flush_federation_stats(&pool, &tracker).await;
                         let _ = flush_modseq(&pool, &events).await;
+                        if let Err(e) = share_views.flush().await {
+                            tracing::warn!(error = %e, "share view counters lost at shutdown");
+                        }
                         break;
                     }
                 }
@@ -161,6 +181,45 @@ mod tests {
         assert_eq!(row, (1, 1, 1));
     }
 
+    #[tokio::test]
+    async fn share_views_flush_on_shutdown() {
+        let dir = tempfile::tempdir().unwrap();
+        let sharing_db = dir.path().join("sharing.db");
+        let sharing = chatmail_db::init_sharing_db(&sharing_db).await.unwrap();
+        chatmail_db::create_sharing_contact(
+            &sharing,
+            "alice",
+            "openpgp4fpr:0123456789ABCDEF0123456789ABCDEF01234567#a=a%40x.org&i=1&s=2",
+            "A",
+        )
+        .await
+        .unwrap();
+        let pool = init_memory_db().await.unwrap();
+        let views = Arc::new(ShareViews::new(
+            true,
+            sharing_db,
+            crate::SHARE_VIEW_DEBOUNCE,
+        ));
+        let flusher = start_flusher(
+            pool,
+            Arc::new(FederationTracker::new()),
+            Arc::new(EventBus::new()),
+            Arc::clone(&views),
+        );
+        // Let the immediate first tick pass so only the shutdown flush can write.
+        tokio::time::sleep(Duration::from_millis(50)).await;
+        assert!(views.record("alice", Some("192.0.2.1".parse().unwrap())));
+        flusher.shutdown().await;
+
+        assert_eq!(views.pending_views("alice"), 0);
+        let row = chatmail_db::get_sharing_contact(&sharing, "alice")
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(row.views, 1);
+        assert!(!row.last_viewed.is_empty());
+    }
+
     /// P10-UT09: INBOX versions flush to the durable modseq table and reload monotonically.
     #[tokio::test]
     async fn p10_ut09_modseq_flush_and_seed() {
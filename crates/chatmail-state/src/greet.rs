@@ -0,0 +1,100 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Inbound SMTP pre-greeting delay (`smtp { greet_delay 3s }`).
+//!
+//! MTAs wait for the 220 banner before sending anything; bots that blast commands early are
+//! "early talkers". The SMTP endpoint holds the banner for [`GreetGuard::delay_for`] and
+//! rejects clients that send bytes in the meantime.
+
+use std::net::IpAddr;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+
+use crate::client_ip::{parse_cidr_list, IpNet};
+
+#[derive(Debug, Default)]
+pub struct GreetGuard {
+    delay: Option<Duration>,
+    exempt: Vec<IpNet>,
+    early_talkers: AtomicU64,
+}
+
+impl GreetGuard {
+    pub fn new(delay: Option<Duration>, exempt: Vec<IpNet>) -> Self {
+        Self {
+            delay,
+            exempt,
+            early_talkers: AtomicU64::new(0),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        let exempt = config
+            .greet_delay_exempt
+            .as_deref()
+            .map(parse_cidr_list)
+            .unwrap_or_default();
+        Self::new(config.greet_delay, exempt)
+    }
+
+    /// Banner delay for a connection from `peer`; `None` when disabled or exempt.
+    pub fn delay_for(&self, peer: IpAddr) -> Option<Duration> {
+        let delay = self.delay?;
+        if self.exempt.iter().any(|net| net.contains(peer)) {
+            return None;
+        }
+        Some(delay)
+    }
+
+    pub fn record_early_talker(&self) {
+        self.early_talkers.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Sessions rejected for talking before the banner since start.
+    pub fn early_talkers(&self) -> u64 {
+        self.early_talkers.load(Ordering::Relaxed)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn exempt_ranges_skip_delay() {
+        let cfg = AppConfig {
+            greet_delay: Some(Duration::from_secs(3)),
+            greet_delay_exempt: Some("10.0.0.0/8, 2001:db8::/32".into()),
+            ..AppConfig::default()
+        };
+        let guard = GreetGuard::from_config(&cfg);
+        assert_eq!(
+            guard.delay_for("198.51.100.7".parse().unwrap()),
+            Some(Duration::from_secs(3))
+        );
+        assert_eq!(guard.delay_for("10.1.2.3".parse().unwrap()), None);
+        assert_eq!(guard.delay_for("2001:db8::1".parse().unwrap()), None);
+        assert_eq!(
+            GreetGuard::from_config(&AppConfig::default())
+                .delay_for("198.51.100.7".parse().unwrap()),
+            None
+        );
+    }
+}
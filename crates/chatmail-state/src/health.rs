@@ -0,0 +1,200 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Cached component health for the public status page (`GET /status`).
+//!
+//! A background probe feeds [`HealthMonitor::observe`]; readers only see the cached
+//! snapshot, so serving the page never probes anything. A component must fail for
+//! `debounce` before it counts as an outage, which keeps one dropped probe off the
+//! incident log.
+
+use std::collections::BTreeMap;
+use std::sync::RwLock;
+use std::time::Duration;
+
+/// Debounce used by [`HealthMonitor::default`].
+pub const DEFAULT_INCIDENT_DEBOUNCE: Duration = Duration::from_secs(120);
+
+/// Edge reported by [`HealthMonitor::observe`]; the caller records it as an incident.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum HealthTransition {
+    /// Failing for longer than the debounce.
+    Outage,
+    /// Passing again after an [`HealthTransition::Outage`].
+    Recovered,
+}
+
+/// Public state of one component.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ComponentState {
+    Operational,
+    /// Failing, but not yet for the debounce period.
+    Degraded,
+    Down,
+}
+
+impl ComponentState {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Operational => "operational",
+            Self::Degraded => "degraded",
+            Self::Down => "down",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ComponentHealth {
+    pub name: String,
+    pub state: ComponentState,
+    /// Last probe error (empty while passing).
+    pub detail: String,
+    /// Unix time the component last changed between passing and failing.
+    pub since: i64,
+    pub checked_at: i64,
+}
+
+#[derive(Debug)]
+struct Entry {
+    failing_since: Option<i64>,
+    down: bool,
+    detail: String,
+    since: i64,
+    checked_at: i64,
+}
+
+#[derive(Debug)]
+pub struct HealthMonitor {
+    started_at: i64,
+    debounce: i64,
+    components: RwLock<BTreeMap<String, Entry>>,
+}
+
+impl Default for HealthMonitor {
+    fn default() -> Self {
+        Self::new(DEFAULT_INCIDENT_DEBOUNCE)
+    }
+}
+
+impl HealthMonitor {
+    pub fn new(debounce: Duration) -> Self {
+        Self {
+            started_at: chatmail_db::policies::unix_now(),
+            debounce: debounce.as_secs() as i64,
+            components: RwLock::new(BTreeMap::new()),
+        }
+    }
+
+    /// Process start (unix seconds) for the uptime line.
+    pub fn started_at(&self) -> i64 {
+        self.started_at
+    }
+
+    /// Record one probe result taken at `now`; returns the edge to log, if any.
+    pub fn observe(
+        &self,
+        name: &str,
+        ok: bool,
+        detail: &str,
+        now: i64,
+    ) -> Option<HealthTransition> {
+        let mut map = self.components.write().ok()?;
+        let entry = map.entry(name.to_string()).or_insert_with(|| Entry {
+            failing_since: None,
+            down: false,
+            detail: String::new(),
+            since: now,
+            checked_at: now,
+        });
+        entry.checked_at = now;
+        if ok {
+            entry.detail.clear();
+            if entry.failing_since.take().is_some() {
+                entry.since = now;
+            }
+            if entry.down {
+                entry.down = false;
+                return Some(HealthTransition::Recovered);
+            }
+            return None;
+        }
+        entry.detail = detail.to_string();
+        if entry.failing_since.is_none() {
+            entry.failing_since = Some(now);
+            entry.since = now;
+        }
+        let failing_since = entry.failing_since.unwrap_or(now);
+        if !entry.down && now - failing_since >= self.debounce {
+            entry.down = true;
+            return Some(HealthTransition::Outage);
+        }
+        None
+    }
+
+    /// Components in name order.
+    pub fn snapshot(&self) -> Vec<ComponentHealth> {
+        let Ok(map) = self.components.read() else {
+            return Vec::new();
+        };
+        map.iter()
+            .map(|(name, e)| ComponentHealth {
+                name: name.clone(),
+                state: match (e.failing_since, e.down) {
+                    (_, true) => ComponentState::Down,
+                    (Some(_), false) => ComponentState::Degraded,
+                    (None, false) => ComponentState::Operational,
+                },
+                detail: e.detail.clone(),
+                since: e.since,
+                checked_at: e.checked_at,
+            })
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn outage_only_after_debounce_and_recovery_once() {
+        let m = HealthMonitor::new(Duration::from_secs(60));
+        assert_eq!(m.observe("smtp", true, "", 1000), None);
+        assert_eq!(m.observe("smtp", false, "refused", 1010), None);
+        assert_eq!(m.snapshot()[0].state, ComponentState::Degraded);
+        // Short blip: recovers before the debounce, no incident either way.
+        assert_eq!(m.observe("smtp", true, "", 1040), None);
+        assert_eq!(m.observe("smtp", false, "refused", 1100), None);
+        assert_eq!(m.observe("smtp", false, "refused", 1159), None);
+        assert_eq!(
+            m.observe("smtp", false, "refused", 1160),
+            Some(HealthTransition::Outage)
+        );
+        assert_eq!(m.observe("smtp", false, "refused", 1200), None);
+        let snap = &m.snapshot()[0];
+        assert_eq!(snap.state, ComponentState::Down);
+        assert_eq!(snap.since, 1100);
+        assert_eq!(snap.detail, "refused");
+        assert_eq!(
+            m.observe("smtp", true, "", 1230),
+            Some(HealthTransition::Recovered)
+        );
+        assert_eq!(m.observe("smtp", true, "", 1260), None);
+        let snap = &m.snapshot()[0];
+        assert_eq!(snap.state, ComponentState::Operational);
+        assert_eq!(snap.since, 1230);
+    }
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Junk reclassification signals (`$Junk` / `$NotJunk` keywords and moves across the Junk
+//! mailbox) published on the [`crate::EventBus`] for trainer integrations and operator stats.
+
+/// Which way the user reclassified a message.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum JunkDirection {
+    /// Into Junk / `$Junk`: train as spam.
+    Spam,
+    /// Out of Junk / `$NotJunk`: train as ham.
+    Ham,
+}
+
+impl JunkDirection {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            JunkDirection::Spam => "spam",
+            JunkDirection::Ham => "ham",
+        }
+    }
+
+    /// rspamd controller endpoint for this direction (`/learnspam` / `/learnham`).
+    pub fn learn_path(self) -> &'static str {
+        match self {
+            JunkDirection::Spam => "/learnspam",
+            JunkDirection::Ham => "/learnham",
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct JunkReclassifiedEvent {
+    pub username: String,
+    /// Mailbox the message lives in *after* the transition (where a trainer can read it).
+    pub mailbox: String,
+    pub msg_id: String,
+    pub size: u64,
+    pub direction: JunkDirection,
+}
+
+/// The mailbox LIST advertises with the `\Junk` special-use attribute (RFC 6154), which
+/// delivery and Sieve `fileinto` also use. A client-made "Spam" folder is an ordinary one.
+pub fn is_junk_mailbox(name: &str) -> bool {
+    name.eq_ignore_ascii_case("Junk")
+}
+
+/// Classify a move between two mailboxes: into Junk is spam, out of Junk is ham, anything else
+/// (including Junk → Junk) is not a reclassification.
+pub fn move_direction(from: &str, to: &str) -> Option<JunkDirection> {
+    match (is_junk_mailbox(from), is_junk_mailbox(to)) {
+        (false, true) => Some(JunkDirection::Spam),
+        (true, false) => Some(JunkDirection::Ham),
+        _ => None,
+    }
+}
+
+/// Classify a `+FLAGS` keyword list. When both keywords are present the request is ambiguous
+/// and ignored.
+pub fn keyword_direction<S: AsRef<str>>(flags: &[S]) -> Option<JunkDirection> {
+    let junk = flags
+        .iter()
+        .any(|f| f.as_ref().eq_ignore_ascii_case("$Junk"));
+    let not_junk = flags
+        .iter()
+        .any(|f| f.as_ref().eq_ignore_ascii_case("$NotJunk"));
+    match (junk, not_junk) {
+        (true, false) => Some(JunkDirection::Spam),
+        (false, true) => Some(JunkDirection::Ham),
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn move_direction_only_across_junk_boundary() {
+        assert_eq!(move_direction("INBOX", "Junk"), Some(JunkDirection::Spam));
+        assert_eq!(move_direction("junk", "INBOX"), Some(JunkDirection::Ham));
+        assert_eq!(move_direction("INBOX", "DeltaChat"), None);
+        assert_eq!(move_direction("INBOX", "Spam"), None);
+        assert_eq!(move_direction("Junk", "Spam"), Some(JunkDirection::Ham));
+    }
+
+    #[test]
+    fn keyword_direction_parses_flags() {
+        assert_eq!(
+            keyword_direction(&["\\Seen", "$Junk"]),
+            Some(JunkDirection::Spam)
+        );
+        assert_eq!(keyword_direction(&["$notjunk"]), Some(JunkDirection::Ham));
+        assert_eq!(keyword_direction(&["$Junk", "$NotJunk"]), None);
+        assert_eq!(keyword_direction::<&str>(&[]), None);
+    }
+}
@@ -15,39 +15,154 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+pub mod activity;
+pub mod admin_events;
+pub mod archive;
+pub mod audit_prune;
 pub mod auth;
+pub mod bans;
+pub mod broadcast;
+pub mod catchalls;
+pub mod cert_expiry;
+pub mod client_ip;
+pub mod clock;
+pub mod crash;
+pub mod disk;
+pub mod dns;
+pub mod drain;
 pub mod events;
 pub mod federation_size;
 pub mod flusher;
+pub mod greet;
+pub mod health;
+pub mod junk;
 pub mod listener_ports;
+pub mod memory;
 pub mod message_size;
+pub mod peers;
+pub mod policies;
 pub mod policy;
+pub mod proof_of_work;
 pub mod quota;
+pub mod registration_limit;
 pub mod reload;
+pub mod responders;
+pub mod sending_limits;
+pub mod sessions;
+pub mod shadowsocks_stats;
+pub mod share_views;
+pub mod sieve;
 pub mod silent_dismiss;
+pub mod storage_usage;
+pub mod subaddress;
+pub mod takeout;
+pub mod throttle;
+pub mod tls_fingerprint;
 pub mod tracker;
+pub mod webhooks;
 
 use std::sync::Arc;
 
-use chatmail_config::AppConfig;
+use chatmail_config::{AppConfig, FederationChecks};
 use chatmail_db::DbPool;
 use chatmail_push::{push_runtime_enabled, PushNotifier};
-use chatmail_storage::{MailboxStore, StoragePolicy};
+use chatmail_storage::{ColdPolicy, MailboxStore, PackCompression, StoragePolicy};
 use chatmail_types::Result;
 use dashmap::DashMap;
 use tokio::sync::Mutex;
 
+pub use activity::{ActivityTracker, ACTIVITY_WRITE_INTERVAL};
+pub use admin_events::{AdminEvent, AdminEventBus, AdminEventKind, AdminEventSubscription};
+pub use archive::{archived_copy, ArchiveTarget, OutboundArchive, ARCHIVED_FOR_HEADER};
+pub use audit_prune::{start_admin_audit_prune, ADMIN_AUDIT_PRUNE_INTERVAL};
 pub use auth::AuthCache;
+pub use bans::{start_ban_refresh, BanMatcher, BanSettings, BanTrigger, IpBans};
+pub use broadcast::{
+    parse_day_or_unix, select_recipients, BroadcastFilter, BroadcastJob, BroadcastPacer,
+    BroadcastState, Broadcasts, DEFAULT_BROADCAST_RATE,
+};
+pub use catchalls::{
+    start_catchall_refresh, Catchalls, BUILTIN_CATCHALL_EXCLUDE, CATCHALL_REFRESH_INTERVAL,
+};
+pub use cert_expiry::{CertMonitor, CertObservation, CertReading, CERT_EXPIRY_STAGES};
+pub use client_ip::{CdnMode, IpNet, TrustedProxies};
+pub use clock::{ClockMonitor, ClockReading, FixedOffsetTimeSource, TimeSource};
+pub use crash::{
+    catch_panic, install_panic_hook, record_log_output, CrashReporter, CrashScope, Panicked,
+    DEFAULT_CRASH_KEEP,
+};
+pub use disk::{DiskGuard, DiskProbe, DiskTier, DiskUsage, StatvfsProbe};
+pub use dns::{is_special_use, DnsError, DnsResolver, StaticResolver, SystemResolver};
+pub use drain::{DrainGate, DrainGuard};
 pub use events::{EventBus, NewMessageEvent};
 pub use federation_size::FederationSizeLimit;
 pub use flusher::{flush_federation_stats, flush_modseq, start_flusher, FlusherHandle};
+pub use greet::GreetGuard;
+pub use health::{ComponentHealth, ComponentState, HealthMonitor, HealthTransition};
+pub use junk::{JunkDirection, JunkReclassifiedEvent};
 pub use listener_ports::{ListenerPorts, ListenerPortsStore};
+pub use memory::{MemoryBudget, MemoryLease};
 pub use message_size::MessageSizeLimit;
+pub use peers::{
+    local_federation_info, sign_info, verify_info, FederationInfo, FetchedInfo, InfoFetcher,
+    PeerDirectory, PeerHints, PeerSource, PeerStatus, FEDERATION_INFO_PATH, MAX_INFO_BYTES,
+    PEER_REFRESH_INTERVAL, SIGNATURE_HEADER,
+};
+pub use policies::PolicyCache;
 pub use policy::{FederationPolicyCache, PolicyMode};
-pub use quota::QuotaCache;
+pub use proof_of_work::{
+    start_proof_of_work_sweep, PowChallenge, PowChallenges, PowError, POW_CHALLENGE_TTL,
+};
+pub use quota::{QuotaCache, QuotaRecalc};
+pub use registration_limit::{
+    start_registration_limit_sweep, RegistrationLimiter, REG_BUCKET_IDLE,
+};
 pub use reload::{ReloadRequest, ReloadScope};
+pub use responders::{start_responder_refresh, Responders, RESPONDER_REFRESH_INTERVAL};
+pub use sending_limits::{SendingLimits, SENDING_RATE_LIMITED};
+pub use sessions::SessionRevocations;
+pub use shadowsocks_stats::{ShadowsocksSession, ShadowsocksStats, ShadowsocksTraffic};
+pub use share_views::{ShareViews, SHARE_VIEW_DEBOUNCE};
+pub use sieve::SieveStore;
 pub use silent_dismiss::FederationSilentDismissCache;
+pub use storage_usage::{
+    start_storage_usage_scan, StorageUsageMonitor, StorageUsageReading, STORAGE_SCAN_INTERVAL,
+};
+pub use subaddress::{LocalRcpt, Subaddressing, DEFAULT_SUBADDRESS_DELIMITER};
+pub use takeout::{
+    start_takeout_sweeper, TakeoutDownload, TakeoutJob, TakeoutSpool, TakeoutState,
+    DEFAULT_TAKEOUT_TTL,
+};
+pub use throttle::{
+    is_rate_limit_reply, DeliveryThrottle, DomainCaps, DomainThrottleRow, ThrottlePermit,
+};
+pub use tls_fingerprint::{TlsFingerprint, TlsFingerprinter};
 pub use tracker::{FederationTracker, ServerStat};
+pub use webhooks::{
+    WebhookDelivery, WebhookEvent, WebhookSender, Webhooks, WEBHOOK_EVENT_HEADER,
+    WEBHOOK_MAX_ATTEMPTS,
+};
+
+/// Maildir policy from `storage.imapsql` (`mail_fsync`, `blob_dedup`, `cold_storage`).
+/// A relative `cold_storage path` is taken from `state_dir`.
+pub fn storage_policy(config: &AppConfig, state_dir: &std::path::Path) -> StoragePolicy {
+    let mut policy =
+        StoragePolicy::from_config(config.mail_fsync.as_deref(), config.blob_dedup.as_deref());
+    let cold = &config.cold_storage;
+    if cold.enabled {
+        policy.cold = Some(ColdPolicy {
+            root: match &cold.path {
+                Some(path) => state_dir.join(path),
+                None => state_dir.join("cold"),
+            },
+            min_age: cold.age,
+            compression: PackCompression::parse(&cold.compression).unwrap_or_default(),
+            promote_on_read: cold.promote_on_read,
+            compact_dead_percent: cold.compact_dead_percent,
+        });
+    }
+    policy
+}
 
 /// Shared hot-path state hydrated at boot.
 #[derive(Clone)]
@@ -59,6 +174,8 @@ pub struct AppState {
     pub federation_tracker: Arc<FederationTracker>,
     pub federation_policy: Arc<FederationPolicyCache>,
     pub federation_silent_dismiss: Arc<FederationSilentDismissCache>,
+    /// Unified `policy_entries` (federation overrides, PGP passthrough, reserved names/slugs).
+    pub policies: Arc<PolicyCache>,
     pub mailbox_store: Arc<MailboxStore>,
     pub events: Arc<EventBus>,
     /// FCM/APNS wake-up via Delta Chat notification proxy.
@@ -67,6 +184,74 @@ pub struct AppState {
     pub listener_ports: Arc<ListenerPortsStore>,
     /// Per-user mutexes so concurrent JIT logins coalesce on one DB create.
     pub jit_flights: Arc<DashMap<String, Arc<Mutex<()>>>>,
+    /// `max_rcpt`: recipients per SMTP transaction and per `/mxdeliv` POST.
+    pub max_rcpt: usize,
+    /// `trusted_cdn` proxy ranges; resolve client IPs through [`TrustedProxies::client_ip`].
+    pub trusted_proxies: Arc<TrustedProxies>,
+    /// Inbound SMTP `greet_delay` + early-talker counter.
+    pub greet: Arc<GreetGuard>,
+    /// Banned IPs/networks and TLS fingerprints checked on accept, plus automatic-ban counters.
+    pub bans: Arc<IpBans>,
+    /// `POST /new` token buckets per client subnet (`reg_rate_limit` / `reg_rate_burst`).
+    pub registration_limiter: Arc<RegistrationLimiter>,
+    /// Outstanding `GET /new` hashcash challenges (`proof_of_work_bits`).
+    pub pow_challenges: Arc<PowChallenges>,
+    /// Per-account generations that end IMAP sessions after a password reset or deletion.
+    pub sessions: Arc<SessionRevocations>,
+    /// JA4 ClientHello fingerprints for TLS listeners (`tls_fingerprint`).
+    pub tls_fingerprints: Arc<TlsFingerprinter>,
+    /// Cached component states behind `GET /status`.
+    pub health: Arc<HealthMonitor>,
+    /// `memory_budget`: large buffers register here; refuse new work when exhausted.
+    pub memory: Arc<MemoryBudget>,
+    /// Last `clock_check` result; see [`ClockMonitor::skew_hint`].
+    pub clock: Arc<ClockMonitor>,
+    /// Active TLS certificate expiry; see [`CertMonitor::expiry_hint`].
+    pub cert: Arc<CertMonitor>,
+    /// State-dir disk tiers (`disk_soft_limit` / `disk_hard_limit`).
+    pub disk: Arc<DiskGuard>,
+    /// Shutdown drain: endpoints refuse new transactions and track in-flight writes.
+    pub drain: DrainGate,
+    /// Throttled `account_activity` writes for `inactive_account_retention`.
+    pub activity: Arc<ActivityTracker>,
+    /// Background `/takeout` exports and their single-use download tokens.
+    pub takeout: Arc<TakeoutSpool>,
+    /// `/admin/broadcast` announcement jobs, `broadcast_sender` and `broadcast_rate`.
+    pub broadcasts: Arc<Broadcasts>,
+    /// `submission` `archive`: copies of accepted outbound mail.
+    pub archive: Arc<OutboundArchive>,
+    /// `submission` `modify { dkim … }` selectors; `__DKIM_SELECTOR__` overrides them.
+    pub dkim_selectors: Vec<String>,
+    /// Live events for the admin dashboard stream.
+    pub admin_events: Arc<AdminEventBus>,
+    /// Debounced contact-share view counters, written out by the state flusher.
+    pub share_views: Arc<ShareViews>,
+    /// Automatic responders for local addresses (`madmail responder`).
+    pub responders: Arc<Responders>,
+    /// Per-domain catch-all mailboxes for unknown local addresses (`madmail domains catchall`).
+    pub catchalls: Arc<Catchalls>,
+    /// `subaddress_delimiter`: maps `user+tag@` recipients onto their account.
+    pub subaddressing: Arc<Subaddressing>,
+    /// Federation peers and their last `/federation/info` documents (`madmail peers`).
+    pub peers: Arc<PeerDirectory>,
+    /// Last mail store usage scan and the orphaned-blob warning.
+    pub storage_usage: Arc<StorageUsageMonitor>,
+    /// Per-destination-domain caps shared by every outbound queue attempt.
+    pub delivery_throttle: Arc<DeliveryThrottle>,
+    /// Recovered panics per module and their crash reports (`crash_dir`).
+    pub crashes: Arc<CrashReporter>,
+    /// Per-account submission caps (`max_messages_per_hour`, `max_rcpt_per_message`).
+    pub sending_limits: Arc<SendingLimits>,
+    /// `federation_checks`: SPF/DKIM/DMARC policy for `/mxdeliv` messages.
+    pub federation_checks: FederationChecks,
+    /// TXT/MX/address lookups for those checks.
+    pub dns: Arc<dyn DnsResolver>,
+    /// Account lifecycle events for `webhook_url`.
+    pub webhooks: Arc<Webhooks>,
+    /// Shadowsocks relay traffic, kept across listener reloads.
+    pub shadowsocks: Arc<ShadowsocksStats>,
+    /// Per-account Sieve scripts (`sieve_store`).
+    pub sieve: Arc<SieveStore>,
 }
 
 impl AppState {
@@ -97,25 +282,69 @@ impl AppState {
         let state_dir = state_dir.as_ref().to_path_buf();
         let queue_dir = state_dir.join("pending_notifications");
         let push = Arc::new(PushNotifier::new(pool, queue_dir, None));
+        let policies = Arc::new(PolicyCache::new());
+        let quota = Arc::new(QuotaCache::new(default_quota_bytes));
+        let admin_events = Arc::new(AdminEventBus::new());
+        let takeout = Arc::new(
+            TakeoutSpool::from_config(&state_dir, config).with_events(Arc::clone(&admin_events)),
+        );
+        let broadcasts =
+            Arc::new(Broadcasts::from_config(config).with_events(Arc::clone(&admin_events)));
+        let share_views = Arc::new(ShareViews::from_config(&state_dir, config));
+        let crashes = Arc::new(CrashReporter::from_config(&state_dir, config));
         Self {
             auth: Arc::new(AuthCache::new()),
             message_size: Arc::new(MessageSizeLimit::new(config)),
             federation_size: Arc::new(FederationSizeLimit::new(config)),
-            quota: Arc::new(QuotaCache::new(default_quota_bytes)),
+            quota,
             federation_tracker: Arc::new(FederationTracker::new()),
-            federation_policy: Arc::new(FederationPolicyCache::new()),
+            federation_policy: Arc::new(FederationPolicyCache::with_policies(Arc::clone(
+                &policies,
+            ))),
             federation_silent_dismiss: Arc::new(FederationSilentDismissCache::new()),
+            policies,
             mailbox_store: Arc::new(MailboxStore::with_policy(
-                state_dir,
-                StoragePolicy::from_config(
-                    config.mail_fsync.as_deref(),
-                    config.blob_dedup.as_deref(),
-                ),
+                state_dir.clone(),
+                storage_policy(config, &state_dir),
             )),
             events: Arc::new(EventBus::new()),
             push,
             listener_ports: Arc::new(ListenerPortsStore::new()),
             jit_flights: Arc::new(DashMap::new()),
+            max_rcpt: config.effective_max_rcpt(),
+            trusted_proxies: Arc::new(TrustedProxies::from_config(config.trusted_cdn.as_deref())),
+            greet: Arc::new(GreetGuard::from_config(config)),
+            bans: Arc::new(IpBans::from_config(config)),
+            registration_limiter: Arc::new(RegistrationLimiter::from_config(config)),
+            pow_challenges: Arc::new(PowChallenges::from_config(config)),
+            sessions: Arc::new(SessionRevocations::new()),
+            tls_fingerprints: Arc::new(TlsFingerprinter::from_config(config)),
+            health: Arc::new(HealthMonitor::default()),
+            memory: Arc::new(MemoryBudget::from_config(config)),
+            clock: Arc::new(ClockMonitor::from_config(config)),
+            cert: Arc::new(CertMonitor::from_config(config)),
+            disk: Arc::new(DiskGuard::from_config(config)),
+            drain: DrainGate::new(),
+            activity: Arc::new(ActivityTracker::from_config(config)),
+            takeout,
+            broadcasts,
+            archive: Arc::new(OutboundArchive::from_config(config)),
+            dkim_selectors: config.dkim_selectors.clone(),
+            admin_events,
+            share_views,
+            responders: Arc::new(Responders::from_config(config)),
+            catchalls: Arc::new(Catchalls::from_config(config)),
+            subaddressing: Arc::new(Subaddressing::from_config(config)),
+            peers: Arc::new(PeerDirectory::from_config(config)),
+            storage_usage: Arc::new(StorageUsageMonitor::from_config(config)),
+            delivery_throttle: Arc::new(DeliveryThrottle::from_settings(&config.queue)),
+            crashes,
+            sending_limits: Arc::new(SendingLimits::from_config(config)),
+            federation_checks: config.federation_checks,
+            dns: Arc::new(SystemResolver::from_resolv_conf()),
+            webhooks: Arc::new(Webhooks::from_config(config)),
+            shadowsocks: Arc::new(ShadowsocksStats::new()),
+            sieve: Arc::new(SieveStore::from_config(&state_dir, config)),
         }
     }
 
@@ -127,6 +356,146 @@ impl AppState {
             .clone()
     }
 
+    /// Account that stores mail for the normalized local recipient `rcpt`; see
+    /// [`Subaddressing::resolve`].
+    pub fn resolve_local_rcpt(&self, rcpt: &str) -> LocalRcpt {
+        self.subaddressing.resolve(rcpt, &self.auth)
+    }
+
+    /// Refuse new accounts at `max_accounts` or while the disk is above its soft limit.
+    pub async fn check_registration_capacity(&self, pool: &DbPool) -> Result<()> {
+        if !self.disk.accepts_registrations() {
+            return Err(chatmail_types::ChatmailError::ServerFull(
+                "disk usage above soft limit".into(),
+            ));
+        }
+        if let Some(max) = chatmail_db::max_accounts(pool).await? {
+            if self.auth.len() as u64 >= max {
+                return Err(chatmail_types::ChatmailError::ServerFull(format!(
+                    "max_accounts ({max}) reached"
+                )));
+            }
+        }
+        Ok(())
+    }
+
+    /// Delete `username` with its mail, credentials and per-account rows, then blocklist the
+    /// name with `reason`. Mail is moved aside first and put back if the credentials cannot
+    /// be deleted, so a failure never leaves a login without its mailboxes.
+    pub async fn delete_account(&self, pool: &DbPool, username: &str, reason: &str) -> Result<()> {
+        let store = &self.mailbox_store;
+        let detached = store.detach_user(username).await?;
+        if let Err(e) = chatmail_db::passwords::delete_user(pool, username).await {
+            if let Some(dir) = &detached {
+                if let Err(restore) = store.restore_detached(username, dir).await {
+                    tracing::error!(
+                        %username,
+                        error = %restore,
+                        "account delete: restoring mail failed"
+                    );
+                }
+            }
+            return Err(e);
+        }
+        self.auth.remove(username);
+        chatmail_db::account_info::delete_quota_row(pool, username).await?;
+        chatmail_db::app_passwords::delete_app_passwords(pool, username).await?;
+        chatmail_db::device_codes::revoke_device_codes(pool, username).await?;
+        chatmail_db::recovery_codes::delete_recovery_codes(pool, username).await?;
+        chatmail_db::account_activity::delete_activity(pool, username).await?;
+        chatmail_db::append_limits::delete_append_limit(pool, username).await?;
+        chatmail_db::autoresponses::delete_autoresponse(pool, username).await?;
+        self.activity.forget(username);
+        self.message_size.forget_account(username);
+        chatmail_db::blocklist::block_user(pool, username, reason).await?;
+        self.auth.block(username);
+        self.quota.invalidate(username);
+        self.sessions.revoke(username);
+        if let Some(dir) = detached {
+            if let Err(e) = store.remove_detached(&dir).await {
+                tracing::warn!(%username, error = %e, "account delete: mail removal failed");
+            }
+        }
+        if let Err(e) = store.remove_cold(username).await {
+            tracing::warn!(%username, error = %e, "account delete: cold pack removal failed");
+        }
+        if let Err(e) = self.sieve.remove(username).await {
+            tracing::warn!(%username, error = %e, "account delete: sieve script removal failed");
+        }
+        self.webhooks.emit(
+            WebhookEvent::AccountDeleted,
+            serde_json::json!({ "username": username, "reason": reason }),
+        );
+        Ok(())
+    }
+
+    /// Count a failed login from `ip` toward an automatic ban (`trusted_cdn` proxies
+    /// excluded: their address stands for many clients).
+    pub async fn note_auth_failure(&self, pool: &DbPool, ip: std::net::IpAddr) {
+        self.note_ban_trigger(pool, BanTrigger::AuthFailure, ip)
+            .await;
+    }
+
+    /// Count an account created from `ip` toward an automatic ban.
+    pub async fn note_registration(&self, pool: &DbPool, ip: std::net::IpAddr) {
+        self.note_ban_trigger(pool, BanTrigger::Registration, ip)
+            .await;
+    }
+
+    async fn note_ban_trigger(&self, pool: &DbPool, trigger: BanTrigger, ip: std::net::IpAddr) {
+        if self.trusted_proxies.is_trusted(ip) {
+            return;
+        }
+        if let Some(ban) = self.bans.note(pool, trigger, ip).await {
+            self.publish_ban_added(&ban, "auto");
+        }
+    }
+
+    /// `ban_added` on the admin event stream.
+    pub fn publish_ban_added(&self, ban: &chatmail_db::Ban, actor: &str) {
+        self.admin_events.publish(
+            AdminEventKind::BanAdded,
+            serde_json::json!({
+                "cidr": ban.cidr,
+                "source": ban.source,
+                "reason": ban.reason,
+                "expires_at": ban.expires_at,
+                "strikes": ban.strikes,
+                "actor": actor,
+            }),
+        );
+    }
+
+    /// `account_created` on the admin event stream and to the webhook; `source` is
+    /// `registration`, `jit` or `admin`.
+    pub fn publish_account_created(&self, username: &str, source: &str) {
+        let data = serde_json::json!({ "username": username, "source": source });
+        self.webhooks
+            .emit(WebhookEvent::AccountCreated, data.clone());
+        self.admin_events
+            .publish(AdminEventKind::AccountCreated, data);
+    }
+
+    /// `first_login` to the webhook once an account's pending first login is settled.
+    pub fn publish_first_login(&self, username: &str) {
+        self.webhooks.emit(
+            WebhookEvent::FirstLogin,
+            serde_json::json!({ "username": username }),
+        );
+    }
+
+    /// Fingerprint the ClientHello waiting on `stream` before the TLS handshake. `Err` holds
+    /// a banned fingerprint; the caller drops the connection.
+    pub async fn screen_tls_client(
+        &self,
+        stream: &tokio::net::TcpStream,
+    ) -> std::result::Result<Option<String>, String> {
+        match self.tls_fingerprints.fingerprint(stream).await {
+            Some(fp) if self.bans.is_fingerprint_banned(&fp) => Err(fp),
+            fp => Ok(fp),
+        }
+    }
+
     pub fn check_message_size(&self, len: usize) -> Result<()> {
         if len as u64 > self.message_size.effective() {
             return Err(chatmail_types::ChatmailError::message_too_large());
@@ -134,6 +503,27 @@ impl AppState {
         Ok(())
     }
 
+    /// Per-recipient admission for mail stored into local mailbox `user`: the account's
+    /// own size cap (`imap-acct appendlimit`), then its storage quota. A quota rejection
+    /// emits the throttled `quota_exceeded` webhook.
+    pub fn admit_local_delivery(&self, user: &str, len: usize) -> Result<()> {
+        if len as u64 > self.message_size.effective_for(user) {
+            return Err(chatmail_types::ChatmailError::message_too_large());
+        }
+        let admitted = self.quota.check_quota(user, len as u64);
+        if let Err(chatmail_types::ChatmailError::QuotaExceeded {
+            user,
+            used,
+            incoming,
+            max,
+        }) = &admitted
+        {
+            self.webhooks
+                .emit_quota_exceeded(user, *used, *incoming, *max);
+        }
+        admitted
+    }
+
     pub fn check_federation_size(&self, len: usize) -> Result<()> {
         if len as u64 > self.federation_size.effective() {
             return Err(chatmail_types::ChatmailError::message_too_large());
@@ -154,10 +544,18 @@ impl AppState {
         self.auth.hydrate(pool).await?;
         self.message_size.hydrate(pool, config).await?;
         self.federation_size.hydrate(pool, config).await?;
+        self.registration_limiter
+            .refresh_from_db(pool, config)
+            .await?;
+        self.pow_challenges.refresh_from_db(pool, config).await?;
         self.quota.hydrate(pool, &self.mailbox_store).await?;
         self.federation_policy.hydrate(pool).await?;
         self.federation_silent_dismiss.hydrate(pool).await?;
+        self.policies.hydrate(pool).await?;
         self.federation_tracker.hydrate(pool).await?;
+        self.bans.refresh(pool).await?;
+        self.responders.refresh(pool).await?;
+        self.catchalls.refresh(pool).await?;
         // Seed durable INBOX modseq so change-ids stay monotonic across restarts.
         for (user, modseq) in chatmail_db::load_all_modseq(pool).await? {
             self.events.seed_inbox_version(&user, modseq.max(0) as u64);
@@ -170,6 +568,7 @@ impl AppState {
             pool,
             Arc::clone(&self.federation_tracker),
             Arc::clone(&self.events),
+            Arc::clone(&self.share_views),
         )
     }
 }
@@ -202,5 +601,31 @@ mod tests {
         let default = AppState::new("/tmp/chatmail-default", pool);
         assert_eq!(default.mailbox_store.policy().fsync_mode, FsyncMode::Always);
         assert!(default.mailbox_store.policy().cas_enabled);
+        assert!(
+            default.mailbox_store.policy().cold.is_none(),
+            "cold tier is opt-in"
+        );
+    }
+
+    #[test]
+    fn cold_storage_block_becomes_cold_policy() {
+        let mut cfg = AppConfig::default();
+        cfg.cold_storage.enabled = true;
+        cfg.cold_storage.path = Some("packs".into());
+        cfg.cold_storage.compression = "none".into();
+        let state_dir = std::path::Path::new("/var/lib/maddy");
+        let cold = storage_policy(&cfg, state_dir).cold.unwrap();
+        assert_eq!(cold.root, state_dir.join("packs"));
+        assert_eq!(cold.compression, PackCompression::None);
+        assert_eq!(
+            cold.min_age,
+            chatmail_config::cold_storage::DEFAULT_COLD_AGE
+        );
+
+        cfg.cold_storage.path = None;
+        assert_eq!(
+            storage_policy(&cfg, state_dir).cold.unwrap().root,
+            state_dir.join("cold")
+        );
     }
 }
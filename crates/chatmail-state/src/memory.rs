@@ -0,0 +1,246 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Global memory budget for large buffers (`memory_budget`, default 70% of RAM).
+//!
+//! Message bodies, FETCH literals and HTTP body downloads register a [`MemoryLease`] for as
+//! long as they hold their buffer. Once the total reaches the budget, [`MemoryBudget::admit`]
+//! refuses new expensive work (SMTP 452, IMAP `NO [LIMIT]`, HTTP 503) while leases already
+//! handed out finish normally. Admission resumes below [`RESUME_PERCENT`] of the budget so a
+//! server hovering at the limit does not flap.
+
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::Arc;
+
+use chatmail_config::{parse_data_size, AppConfig};
+
+/// Share of detected memory used when `memory_budget` is unset.
+pub const DEFAULT_BUDGET_PERCENT: u64 = 70;
+/// Backpressure lifts once usage falls below this share of the budget.
+pub const RESUME_PERCENT: u64 = 90;
+
+#[derive(Debug, Default)]
+pub struct MemoryBudget {
+    /// Bytes; 0 = unlimited.
+    limit: u64,
+    used: AtomicU64,
+    shedding: AtomicBool,
+    rejections: AtomicU64,
+}
+
+/// Registered buffer; releases its bytes on drop.
+#[derive(Debug)]
+pub struct MemoryLease {
+    budget: Arc<MemoryBudget>,
+    bytes: u64,
+}
+
+impl Drop for MemoryLease {
+    fn drop(&mut self) {
+        self.budget.release(self.bytes);
+    }
+}
+
+impl MemoryBudget {
+    pub fn new(limit: u64) -> Self {
+        chatmail_metrics::set_memory_budget(0, limit);
+        Self {
+            limit,
+            ..Self::default()
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        let raw = config.memory_budget.as_deref().unwrap_or("").trim();
+        let limit = match raw {
+            "" => percent_of_memory(DEFAULT_BUDGET_PERCENT),
+            "off" | "none" | "0" => 0,
+            _ => match raw.strip_suffix('%') {
+                Some(p) => p
+                    .trim()
+                    .parse()
+                    .map(percent_of_memory)
+                    .unwrap_or_else(|_| percent_of_memory(DEFAULT_BUDGET_PERCENT)),
+                None => parse_data_size(raw).unwrap_or_else(|e| {
+                    tracing::warn!(value = raw, error = %e, "memory_budget: invalid size, using default");
+                    percent_of_memory(DEFAULT_BUDGET_PERCENT)
+                }),
+            },
+        };
+        if limit > 0 {
+            tracing::debug!(limit, "memory budget enabled");
+        }
+        Self::new(limit)
+    }
+
+    pub fn limit(&self) -> u64 {
+        self.limit
+    }
+
+    pub fn used(&self) -> u64 {
+        self.used.load(Ordering::Relaxed)
+    }
+
+    pub fn rejections(&self) -> u64 {
+        self.rejections.load(Ordering::Relaxed)
+    }
+
+    /// Register `bytes` of buffered data. Always succeeds: callers check [`Self::admit`]
+    /// before starting work, not halfway through it.
+    pub fn reserve(self: &Arc<Self>, bytes: u64) -> MemoryLease {
+        let used = self.used.fetch_add(bytes, Ordering::Relaxed) + bytes;
+        self.update(used);
+        MemoryLease {
+            budget: Arc::clone(self),
+            bytes,
+        }
+    }
+
+    fn release(&self, bytes: u64) {
+        let used = self.used.fetch_sub(bytes, Ordering::Relaxed) - bytes;
+        self.update(used);
+    }
+
+    fn update(&self, used: u64) {
+        chatmail_metrics::set_memory_budget(used, self.limit);
+        if self.limit == 0 {
+            return;
+        }
+        if used >= self.limit {
+            if !self.shedding.swap(true, Ordering::Relaxed) {
+                tracing::warn!(
+                    used,
+                    limit = self.limit,
+                    "memory budget exhausted; shedding load"
+                );
+            }
+        } else if used < self.limit / 100 * RESUME_PERCENT
+            && self.shedding.swap(false, Ordering::Relaxed)
+        {
+            tracing::info!(used, limit = self.limit, "memory budget recovered");
+        }
+    }
+
+    /// May a new expensive operation start? `protocol` labels the rejection metric.
+    pub fn admit(&self, protocol: &str) -> bool {
+        if !self.shedding.load(Ordering::Relaxed) {
+            return true;
+        }
+        self.rejections.fetch_add(1, Ordering::Relaxed);
+        chatmail_metrics::record_memory_backpressure(protocol);
+        false
+    }
+}
+
+fn percent_of_memory(percent: u64) -> u64 {
+    detect_memory_bytes().map_or(0, |total| total / 100 * percent.min(100))
+}
+
+/// Smaller of the cgroup limit (v2, then v1) and `MemTotal`; `None` off Linux.
+fn detect_memory_bytes() -> Option<u64> {
+    let read = |path: &str| std::fs::read_to_string(path).ok();
+    let cgroup = read("/sys/fs/cgroup/memory.max")
+        .or_else(|| read("/sys/fs/cgroup/memory/memory.limit_in_bytes"))
+        .and_then(|s| parse_cgroup_limit(&s));
+    let system = read("/proc/meminfo").and_then(|s| parse_meminfo_total(&s));
+    match (cgroup, system) {
+        (Some(c), Some(s)) => Some(c.min(s)),
+        (c, s) => c.or(s),
+    }
+}
+
+/// `memory.max` / `memory.limit_in_bytes`; `max` and the v1 "unlimited" sentinel → `None`.
+fn parse_cgroup_limit(raw: &str) -> Option<u64> {
+    let value: u64 = raw.trim().parse().ok()?;
+    (value < 1 << 60).then_some(value)
+}
+
+fn parse_meminfo_total(raw: &str) -> Option<u64> {
+    let line = raw.lines().find(|l| l.starts_with("MemTotal:"))?;
+    let kib: u64 = line.split_whitespace().nth(1)?.parse().ok()?;
+    Some(kib * 1024)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn backpressure_with_hysteresis() {
+        let budget = Arc::new(MemoryBudget::new(1000));
+        assert!(budget.admit("smtp"));
+        let a = budget.reserve(600);
+        let b = budget.reserve(400);
+        assert_eq!(budget.used(), 1000);
+        assert!(!budget.admit("smtp"));
+        drop(b);
+        // 600 is below the 900-byte resume mark.
+        assert!(budget.admit("imap"));
+        let c = budget.reserve(500);
+        assert!(!budget.admit("http"));
+        drop(a);
+        assert!(budget.admit("http"));
+        let d = budget.reserve(500);
+        assert!(!budget.admit("smtp"));
+        drop(c);
+        assert_eq!(budget.used(), 500);
+        assert!(budget.admit("smtp"));
+        drop(d);
+        assert_eq!(budget.used(), 0);
+        assert_eq!(budget.rejections(), 3);
+    }
+
+    #[test]
+    fn stays_shedding_between_resume_mark_and_limit() {
+        let budget = Arc::new(MemoryBudget::new(1000));
+        let a = budget.reserve(950);
+        let b = budget.reserve(100);
+        assert!(!budget.admit("smtp"));
+        drop(b);
+        // 950: under the limit but above the 900 resume mark, still refusing.
+        assert!(!budget.admit("smtp"));
+        drop(a);
+        assert!(budget.admit("smtp"));
+    }
+
+    #[test]
+    fn unlimited_budget_never_refuses() {
+        let budget = Arc::new(MemoryBudget::new(0));
+        let _lease = budget.reserve(u32::MAX as u64);
+        assert!(budget.admit("smtp"));
+    }
+
+    #[test]
+    fn parses_memory_sources_and_config() {
+        assert_eq!(parse_cgroup_limit("max\n"), None);
+        assert_eq!(parse_cgroup_limit("9223372036854771712\n"), None);
+        assert_eq!(parse_cgroup_limit("536870912\n"), Some(536_870_912));
+        assert_eq!(
+            parse_meminfo_total("MemTotal:        2048 kB\nMemFree: 1 kB\n"),
+            Some(2048 * 1024)
+        );
+        let cfg = AppConfig {
+            memory_budget: Some("off".into()),
+            ..AppConfig::default()
+        };
+        assert_eq!(MemoryBudget::from_config(&cfg).limit(), 0);
+        let cfg = AppConfig {
+            memory_budget: Some("64M".into()),
+            ..AppConfig::default()
+        };
+        assert_eq!(MemoryBudget::from_config(&cfg).limit(), 64 * 1024 * 1024);
+    }
+}
@@ -20,14 +20,19 @@ use std::sync::atomic::{AtomicU64, Ordering};
 use chatmail_config::{
     effective_max_message_bytes, parse_data_size, resolve_max_message_bytes, AppConfig,
 };
-use chatmail_db::{get_setting, set_setting, settings_keys, DbPool};
+use chatmail_db::{get_setting, list_append_limits, set_setting, settings_keys, DbPool};
 use chatmail_types::Result;
+use dashmap::DashMap;
 
 /// Runtime SMTP/IMAP/WebSMTP message size cap (config + DB overrides).
+///
+/// Accounts may carry their own, smaller cap (`append_limits`, `imap-acct appendlimit`);
+/// the global cap stays the ceiling for them.
 #[derive(Debug)]
 pub struct MessageSizeLimit {
     config_bytes: u64,
     effective_bytes: AtomicU64,
+    per_account: DashMap<String, u64>,
 }
 
 impl MessageSizeLimit {
@@ -36,6 +41,7 @@ impl MessageSizeLimit {
         Self {
             config_bytes,
             effective_bytes: AtomicU64::new(config_bytes),
+            per_account: DashMap::new(),
         }
     }
 
@@ -43,6 +49,36 @@ impl MessageSizeLimit {
         self.effective_bytes.load(Ordering::Relaxed)
     }
 
+    /// Cap for mail stored into `user`'s mailbox: the account's own cap when set, never
+    /// above the global one.
+    pub fn effective_for(&self, user: &str) -> u64 {
+        let global = self.effective();
+        match self.per_account.get(user) {
+            Some(own) if *own > 0 => global.min(*own),
+            _ => global,
+        }
+    }
+
+    /// Set (or with 0, clear) `user`'s own cap and apply it immediately.
+    pub async fn set_account_limit(&self, pool: &DbPool, user: &str, bytes: u64) -> Result<()> {
+        chatmail_db::set_append_limit(pool, user, bytes).await?;
+        self.apply_account_limit(user, bytes);
+        Ok(())
+    }
+
+    fn apply_account_limit(&self, user: &str, bytes: u64) {
+        if bytes == 0 {
+            self.per_account.remove(user);
+        } else {
+            self.per_account.insert(user.to_string(), bytes);
+        }
+    }
+
+    /// Drop a deleted account's cap from memory.
+    pub fn forget_account(&self, user: &str) {
+        self.per_account.remove(user);
+    }
+
     pub fn config_bytes(&self) -> u64 {
         self.config_bytes
     }
@@ -57,6 +93,12 @@ impl MessageSizeLimit {
         let max = get_setting(pool, settings_keys::MAX_MESSAGE_SIZE).await?;
         let eff = resolve_max_message_bytes(config_eff, append.as_deref(), max.as_deref())?;
         self.effective_bytes.store(eff, Ordering::Relaxed);
+        let per_account = list_append_limits(pool).await?;
+        self.per_account
+            .retain(|user, _| per_account.contains_key(user));
+        for (user, bytes) in per_account {
+            self.apply_account_limit(&user, bytes);
+        }
         Ok(())
     }
 
@@ -119,4 +161,62 @@ mod tests {
         lim.refresh_from_db(&pool, &cfg).await.unwrap();
         assert_eq!(lim.effective(), 40 * 1024 * 1024);
     }
+
+    #[tokio::test]
+    async fn account_limit_below_global_wins() {
+        let pool = init_memory_db().await.unwrap();
+        let cfg = AppConfig::default();
+        let lim = MessageSizeLimit::new(&cfg);
+        lim.set_limit(&pool, &cfg, "10M").await.unwrap();
+        lim.set_account_limit(&pool, "a@x", 1024 * 1024)
+            .await
+            .unwrap();
+        assert_eq!(lim.effective_for("a@x"), 1024 * 1024);
+        assert_eq!(lim.effective_for("b@x"), 10 * 1024 * 1024);
+    }
+
+    #[tokio::test]
+    async fn global_limit_caps_a_larger_account_limit() {
+        let pool = init_memory_db().await.unwrap();
+        let cfg = AppConfig::default();
+        let lim = MessageSizeLimit::new(&cfg);
+        lim.set_account_limit(&pool, "a@x", 50 * 1024 * 1024)
+            .await
+            .unwrap();
+        lim.set_limit(&pool, &cfg, "2M").await.unwrap();
+        assert_eq!(lim.effective_for("a@x"), 2 * 1024 * 1024);
+    }
+
+    #[tokio::test]
+    async fn zero_account_limit_means_global_only() {
+        let pool = init_memory_db().await.unwrap();
+        let cfg = AppConfig::default();
+        let lim = MessageSizeLimit::new(&cfg);
+        lim.set_limit(&pool, &cfg, "3M").await.unwrap();
+        lim.set_account_limit(&pool, "a@x", 1024).await.unwrap();
+        lim.set_account_limit(&pool, "a@x", 0).await.unwrap();
+        assert_eq!(lim.effective_for("a@x"), 3 * 1024 * 1024);
+        assert_eq!(
+            chatmail_db::get_append_limit(&pool, "a@x").await.unwrap(),
+            None
+        );
+    }
+
+    #[tokio::test]
+    async fn hydrate_loads_account_limits_written_elsewhere() {
+        let pool = init_memory_db().await.unwrap();
+        let cfg = AppConfig::default();
+        // As `imap-acct appendlimit` does, straight to the DB.
+        chatmail_db::set_append_limit(&pool, "a@x", 4096)
+            .await
+            .unwrap();
+        let lim = MessageSizeLimit::new(&cfg);
+        lim.hydrate(&pool, &cfg).await.unwrap();
+        assert_eq!(lim.effective_for("a@x"), 4096);
+        chatmail_db::set_append_limit(&pool, "a@x", 0)
+            .await
+            .unwrap();
+        lim.refresh_from_db(&pool, &cfg).await.unwrap();
+        assert_eq!(lim.effective_for("a@x"), lim.effective());
+    }
 }
@@ -0,0 +1,642 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Federation peering: our signed `/federation/info` document and the directory of peers.
+//!
+//! Every [`PEER_REFRESH_INTERVAL`] each peer (config `peers` plus `madmail peers add`) is
+//! asked for its `/federation/info` through an [`InfoFetcher`]. The response is untrusted:
+//! [`FederationInfo::parse`] caps the size at [`MAX_INFO_BYTES`], requires the known version
+//! and bounds every list and token. A document counts for delivery only when its
+//! `X-Madmail-Signature` verifies against one of the `peer_secret` keys; unsigned or badly
+//! signed documents are still shown in `madmail peers list` so the operator can see why.
+//!
+//! [`PeerDirectory::hints_for`] hands the delivery worker what a verified, reachable peer
+//! advertises (HTTPS/HTTP support and size limits). A peer that stops answering loses its
+//! hints on the next refresh, and delivery falls back to the usual HTTPS → HTTP → SMTP order.
+
+use std::collections::BTreeMap;
+use std::sync::RwLock;
+use std::time::Duration;
+
+use async_trait::async_trait;
+use chatmail_config::AppConfig;
+use chatmail_db::policies::unix_now;
+use chatmail_db::{normalize_peer_url, DbPool};
+use chatmail_types::Result;
+use hmac::{Hmac, Mac};
+use serde_json::{json, Value};
+use sha2::Sha256;
+use subtle::ConstantTimeEq;
+
+use crate::AppState;
+
+/// How often every peer's document is fetched again.
+pub const PEER_REFRESH_INTERVAL: Duration = Duration::from_secs(300);
+
+/// Path of the document on every instance.
+pub const FEDERATION_INFO_PATH: &str = "/federation/info";
+
+/// Response header carrying `v1=<hex HMAC-SHA256 of the body>`.
+pub const SIGNATURE_HEADER: &str = "x-madmail-signature";
+
+/// Largest document accepted from a peer.
+pub const MAX_INFO_BYTES: usize = 16 * 1024;
+
+/// `version` of the document this build writes and understands.
+pub const FEDERATION_INFO_VERSION: u64 = 1;
+
+/// Entries kept per list (`capabilities`, `delivery`, `dkim_selectors`, `peers`).
+const MAX_LIST_ENTRIES: usize = 32;
+/// Longest capability / selector token.
+const MAX_TOKEN_LEN: usize = 64;
+const MAX_DOMAIN_LEN: usize = 253;
+const MAX_ERROR_LEN: usize = 200;
+
+/// Delivery mechanisms a document may list; anything else is ignored.
+pub const DELIVERY_MECHANISMS: &[&str] = &["https", "http", "smtp"];
+
+/// What we advertise besides the delivery mechanisms.
+const LOCAL_CAPABILITIES: &[&str] = &[
+    "mxdeliv",
+    "requiretls",
+    "mt-priority",
+    "smtputf8",
+    "8bitmime",
+];
+
+type HmacSha256 = Hmac<Sha256>;
+
+/// A `/federation/info` document.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FederationInfo {
+    pub domain: String,
+    pub capabilities: Vec<String>,
+    /// Subset of [`DELIVERY_MECHANISMS`] the instance accepts mail over.
+    pub delivery: Vec<String>,
+    /// Largest message accepted, in bytes.
+    pub max_message_size: u64,
+    /// `/mxdeliv` body cap, in bytes.
+    pub max_http_body: u64,
+    /// DKIM selectors in use (empty: this server does not sign).
+    pub dkim_selectors: Vec<String>,
+    /// Domains of the peers the instance currently reaches.
+    pub peers: Vec<String>,
+    pub generated_at: i64,
+}
+
+impl FederationInfo {
+    pub fn to_json(&self) -> Value {
+        json!({
+            "version": FEDERATION_INFO_VERSION,
+            "domain": self.domain,
+            "capabilities": self.capabilities,
+            "delivery": self.delivery,
+            "max_message_size": self.max_message_size,
+            "max_http_body": self.max_http_body,
+            "dkim_selectors": self.dkim_selectors,
+            "peers": self.peers,
+            "generated_at": self.generated_at,
+        })
+    }
+
+    /// Validate a document fetched from a peer.
+    pub fn parse(body: &[u8]) -> std::result::Result<Self, String> {
+        if body.len() > MAX_INFO_BYTES {
+            return Err(format!("document larger than {MAX_INFO_BYTES} bytes"));
+        }
+        let value: Value =
+            serde_json::from_slice(body).map_err(|e| format!("invalid JSON: {e}"))?;
+        let obj = value.as_object().ok_or("document is not a JSON object")?;
+        match obj.get("version").and_then(Value::as_u64) {
+            Some(FEDERATION_INFO_VERSION) => {}
+            Some(v) => return Err(format!("unsupported version {v}")),
+            None => return Err("missing version".into()),
+        }
+        let domain = obj
+            .get("domain")
+            .and_then(Value::as_str)
+            .filter(|d| valid_domain(d))
+            .ok_or("missing or invalid domain")?
+            .to_ascii_lowercase();
+        let size = |key: &str| -> std::result::Result<u64, String> {
+            obj.get(key)
+                .and_then(Value::as_u64)
+                .filter(|n| *n > 0)
+                .ok_or_else(|| format!("missing or invalid {key}"))
+        };
+        let delivery = token_list(obj.get("delivery"), "delivery", valid_token)?
+            .into_iter()
+            .filter(|m| DELIVERY_MECHANISMS.contains(&m.as_str()))
+            .collect();
+        Ok(Self {
+            domain,
+            capabilities: token_list(obj.get("capabilities"), "capabilities", valid_token)?,
+            delivery,
+            max_message_size: size("max_message_size")?,
+            max_http_body: size("max_http_body")?,
+            dkim_selectors: token_list(obj.get("dkim_selectors"), "dkim_selectors", valid_token)?,
+            peers: token_list(obj.get("peers"), "peers", valid_domain)?,
+            generated_at: obj.get("generated_at").and_then(Value::as_i64).unwrap_or(0),
+        })
+    }
+
+    pub fn supports(&self, mechanism: &str) -> bool {
+        self.delivery.iter().any(|m| m == mechanism)
+    }
+}
+
+/// Optional list of short lowercase strings; `null`/absent is empty.
+fn token_list(
+    value: Option<&Value>,
+    key: &str,
+    valid: fn(&str) -> bool,
+) -> std::result::Result<Vec<String>, String> {
+    let items = match value {
+        None | Some(Value::Null) => return Ok(Vec::new()),
+        Some(Value::Array(items)) => items,
+        Some(_) => return Err(format!("{key} is not a list")),
+    };
+    if items.len() > MAX_LIST_ENTRIES {
+        return Err(format!("{key} has more than {MAX_LIST_ENTRIES} entries"));
+    }
+    items
+        .iter()
+        .map(|item| {
+            item.as_str()
+                .filter(|s| valid(s))
+                .map(str::to_ascii_lowercase)
+                .ok_or_else(|| format!("invalid entry in {key}"))
+        })
+        .collect()
+}
+
+fn valid_token(s: &str) -> bool {
+    !s.is_empty()
+        && s.len() <= MAX_TOKEN_LEN
+        && s.bytes()
+            .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'-' | b'_' | b'.'))
+}
+
+/// Hostname or bracketed IP literal (`[203.0.113.5]`).
+fn valid_domain(s: &str) -> bool {
+    !s.is_empty()
+        && s.len() <= MAX_DOMAIN_LEN
+        && s.bytes()
+            .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'-' | b'.' | b'[' | b']' | b':'))
+}
+
+/// `v1=<hex>` signature of `body` under `secret`.
+pub fn sign_info(secret: &str, body: &[u8]) -> String {
+    let mut mac =
+        HmacSha256::new_from_slice(secret.as_bytes()).expect("HMAC accepts keys of any length");
+    mac.update(body);
+    let tag: String = mac
+        .finalize()
+        .into_bytes()
+        .iter()
+        .map(|b| format!("{b:02x}"))
+        .collect();
+    format!("v1={tag}")
+}
+
+/// True when `signature` is `body` signed with any of `secrets`.
+pub fn verify_info(secrets: &[String], body: &[u8], signature: &str) -> bool {
+    let signature = signature.trim().to_ascii_lowercase();
+    secrets.iter().fold(false, |ok, secret| {
+        let expected = sign_info(secret, body);
+        ok | bool::from(expected.as_bytes().ct_eq(signature.as_bytes()))
+    })
+}
+
+/// Raw answer of a peer, before validation.
+#[derive(Debug, Clone, Default)]
+pub struct FetchedInfo {
+    pub body: Vec<u8>,
+    /// `X-Madmail-Signature` response header.
+    pub signature: Option<String>,
+}
+
+/// Transport for [`PeerDirectory::refresh`]; the HTTP implementation lives in the binary.
+#[async_trait]
+pub trait InfoFetcher: Send + Sync {
+    /// `GET {base_url}/federation/info`, reading at most [`MAX_INFO_BYTES`].
+    async fn fetch(&self, base_url: &str) -> std::result::Result<FetchedInfo, String>;
+}
+
+/// Where a peer was configured.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PeerSource {
+    /// `peers` directive.
+    Config,
+    /// `federation_peers` table (`madmail peers add`, `/admin/peers`).
+    Stored,
+}
+
+impl PeerSource {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Config => "config",
+            Self::Stored => "stored",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PeerStatus {
+    pub base_url: String,
+    pub source: PeerSource,
+    /// Last document that passed validation, kept while the peer is unreachable.
+    pub info: Option<FederationInfo>,
+    /// `info` carried a valid signature.
+    pub verified: bool,
+    /// `None` until the first check.
+    pub reachable: Option<bool>,
+    pub checked_at: Option<i64>,
+    pub last_success: Option<i64>,
+    pub error: Option<String>,
+}
+
+impl PeerStatus {
+    fn new(base_url: String, source: PeerSource) -> Self {
+        Self {
+            base_url,
+            source,
+            info: None,
+            verified: false,
+            reachable: None,
+            checked_at: None,
+            last_success: None,
+            error: None,
+        }
+    }
+
+    pub fn to_json(&self) -> Value {
+        json!({
+            "base_url": self.base_url,
+            "source": self.source.as_str(),
+            "domain": self.info.as_ref().map(|i| i.domain.as_str()),
+            "reachable": self.reachable,
+            "verified": self.verified,
+            "checked_at": self.checked_at,
+            "last_success": self.last_success,
+            "error": self.error,
+            "info": self.info.as_ref().map(FederationInfo::to_json),
+        })
+    }
+}
+
+/// Delivery hints from a verified, reachable peer.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct PeerHints {
+    pub https: bool,
+    pub http: bool,
+    pub smtp: bool,
+    pub max_message_size: u64,
+    pub max_http_body: u64,
+}
+
+#[derive(Debug, Default)]
+pub struct PeerDirectory {
+    /// Normalized `peers` from the config.
+    config_peers: Vec<String>,
+    /// `peer_secret` keys; the first one signs.
+    secrets: Vec<String>,
+    peers: RwLock<BTreeMap<String, PeerStatus>>,
+}
+
+impl PeerDirectory {
+    pub fn new(config_peers: Vec<String>, secrets: Vec<String>) -> Self {
+        let mut normalized = Vec::new();
+        for raw in config_peers {
+            match normalize_peer_url(&raw) {
+                Ok(url) if !normalized.contains(&url) => normalized.push(url),
+                Ok(_) => {}
+                Err(e) => tracing::warn!(error = %e, "peers: ignoring config entry"),
+            }
+        }
+        let dir = Self {
+            config_peers: normalized,
+            secrets: secrets.into_iter().filter(|s| !s.is_empty()).collect(),
+            peers: RwLock::default(),
+        };
+        dir.set_stored(Vec::new());
+        dir
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.peers.clone(), config.peer_secrets.clone())
+    }
+
+    /// Key that signs our own document.
+    pub fn signing_secret(&self) -> Option<&str> {
+        self.secrets.first().map(String::as_str)
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.peers.read().map_or(true, |m| m.is_empty())
+    }
+
+    /// Replace the stored part of the peer set, keeping the status of peers still listed.
+    pub fn set_stored(&self, stored: Vec<String>) {
+        let mut guard = self.peers.write().unwrap_or_else(|e| e.into_inner());
+        let mut old = std::mem::take(&mut *guard);
+        let wanted = self
+            .config_peers
+            .iter()
+            .map(|u| (u.clone(), PeerSource::Config))
+            .chain(stored.into_iter().map(|u| (u, PeerSource::Stored)));
+        for (url, source) in wanted {
+            if guard.contains_key(&url) {
+                continue;
+            }
+            let mut status = old
+                .remove(&url)
+                .unwrap_or_else(|| PeerStatus::new(url.clone(), source));
+            status.source = source;
+            guard.insert(url, status);
+        }
+    }
+
+    /// Reload stored peers and fetch every peer's document once.
+    pub async fn refresh(&self, pool: &DbPool, fetcher: &dyn InfoFetcher) -> Result<()> {
+        let stored = chatmail_db::list_peers(pool).await?;
+        self.set_stored(stored.into_iter().map(|p| p.base_url).collect());
+        let urls: Vec<String> = self
+            .peers
+            .read()
+            .map(|m| m.keys().cloned().collect())
+            .unwrap_or_default();
+        for url in urls {
+            self.check(&url, fetcher).await;
+        }
+        Ok(())
+    }
+
+    /// Fetch and validate one peer's document and record the outcome.
+    pub async fn check(&self, base_url: &str, fetcher: &dyn InfoFetcher) {
+        let outcome = fetcher.fetch(base_url).await.and_then(|fetched| {
+            let info = FederationInfo::parse(&fetched.body)?;
+            let verified = fetched
+                .signature
+                .as_deref()
+                .is_some_and(|sig| verify_info(&self.secrets, &fetched.body, sig));
+            Ok((info, verified))
+        });
+        let now = unix_now();
+        let mut guard = self.peers.write().unwrap_or_else(|e| e.into_inner());
+        let Some(status) = guard.get_mut(base_url) else {
+            return;
+        };
+        status.checked_at = Some(now);
+        match outcome {
+            Ok((info, verified)) => {
+                if !verified && status.verified {
+                    tracing::warn!(peer = %base_url, "peers: document no longer verifies");
+                }
+                status.reachable = Some(true);
+                status.last_success = Some(now);
+                status.verified = verified;
+                status.error = (!verified).then(|| {
+                    if self.secrets.is_empty() {
+                        "no peer_secret configured; hints not used".to_string()
+                    } else {
+                        "signature missing or invalid; hints not used".to_string()
+                    }
+                });
+                status.info = Some(info);
+            }
+            Err(e) => {
+                if status.reachable != Some(false) {
+                    tracing::warn!(peer = %base_url, error = %e, "peers: peer unreachable");
+                }
+                status.reachable = Some(false);
+                status.error = Some(e.chars().take(MAX_ERROR_LEN).collect());
+            }
+        }
+    }
+
+    pub fn snapshot(&self) -> Vec<PeerStatus> {
+        self.peers
+            .read()
+            .map(|m| m.values().cloned().collect())
+            .unwrap_or_default()
+    }
+
+    /// Domains of reachable peers, advertised in our own document.
+    pub fn known_domains(&self) -> Vec<String> {
+        let mut domains: Vec<String> = self
+            .snapshot()
+            .into_iter()
+            .filter(|s| s.reachable == Some(true))
+            .filter_map(|s| s.info.map(|i| i.domain))
+            .collect();
+        domains.sort();
+        domains.dedup();
+        domains.truncate(MAX_LIST_ENTRIES);
+        domains
+    }
+
+    /// What a verified, reachable peer serving `domain` advertises.
+    pub fn hints_for(&self, domain: &str) -> Option<PeerHints> {
+        let guard = self.peers.read().ok()?;
+        guard.values().find_map(|s| {
+            let info = s.info.as_ref()?;
+            (s.verified && s.reachable == Some(true) && info.domain.eq_ignore_ascii_case(domain))
+                .then(|| PeerHints {
+                    https: info.supports("https"),
+                    http: info.supports("http"),
+                    smtp: info.supports("smtp"),
+                    max_message_size: info.max_message_size,
+                    max_http_body: info.max_http_body,
+                })
+        })
+    }
+}
+
+/// Our document: listeners that are up, current size limits and reachable peers.
+pub fn local_federation_info(app: &AppState, domain: &str) -> FederationInfo {
+    let ports = app.listener_ports.snapshot();
+    let mut delivery = Vec::new();
+    for (mechanism, up) in [
+        ("https", ports.http_tls_addr.is_some()),
+        ("http", ports.http_plain_addr.is_some()),
+        ("smtp", ports.smtp_addr.is_some()),
+    ] {
+        if up {
+            delivery.push(mechanism.to_string());
+        }
+    }
+    FederationInfo {
+        domain: domain.to_ascii_lowercase(),
+        capabilities: LOCAL_CAPABILITIES.iter().map(|c| c.to_string()).collect(),
+        delivery,
+        max_message_size: app.message_size.effective(),
+        max_http_body: app.federation_size.effective(),
+        dkim_selectors: Vec::new(),
+        peers: app.peers.known_domains(),
+        generated_at: unix_now(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn doc(domain: &str, delivery: &[&str]) -> FederationInfo {
+        FederationInfo {
+            domain: domain.into(),
+            capabilities: vec!["mxdeliv".into()],
+            delivery: delivery.iter().map(|d| d.to_string()).collect(),
+            max_message_size: 1000,
+            max_http_body: 2000,
+            dkim_selectors: vec![],
+            peers: vec![],
+            generated_at: 1,
+        }
+    }
+
+    struct Canned(std::result::Result<FetchedInfo, String>);
+
+    #[async_trait]
+    impl InfoFetcher for Canned {
+        async fn fetch(&self, _base_url: &str) -> std::result::Result<FetchedInfo, String> {
+            self.0.clone()
+        }
+    }
+
+    fn signed(info: &FederationInfo, secret: &str) -> Canned {
+        let body = serde_json::to_vec(&info.to_json()).unwrap();
+        let signature = Some(sign_info(secret, &body));
+        Canned(Ok(FetchedInfo { body, signature }))
+    }
+
+    #[test]
+    fn document_round_trips() {
+        let info = doc("peer.example", &["https", "smtp"]);
+        let body = serde_json::to_vec(&info.to_json()).unwrap();
+        assert_eq!(FederationInfo::parse(&body).unwrap(), info);
+    }
+
+    #[test]
+    fn rejects_malformed_documents() {
+        let base = doc("peer.example", &["https"]).to_json();
+        let with = |key: &str, value: Value| {
+            let mut v = base.clone();
+            v[key] = value;
+            serde_json::to_vec(&v).unwrap()
+        };
+        let too_many: Vec<String> = (0..=MAX_LIST_ENTRIES).map(|i| format!("c{i}")).collect();
+        for body in [
+            b"not json".to_vec(),
+            b"[]".to_vec(),
+            with("version", json!(2)),
+            with("domain", json!("evil domain/../")),
+            with("max_message_size", json!(-1)),
+            with("max_http_body", json!("big")),
+            with("capabilities", json!("mxdeliv")),
+            with("capabilities", json!(too_many)),
+            with("dkim_selectors", json!(["x".repeat(MAX_TOKEN_LEN + 1)])),
+            with("peers", json!([42])),
+            vec![b' '; MAX_INFO_BYTES + 1],
+        ] {
+            assert!(
+                FederationInfo::parse(&body).is_err(),
+                "{}",
+                String::from_utf8_lossy(&body)
+            );
+        }
+        let parsed =
+            FederationInfo::parse(&with("delivery", json!(["carrier-pigeon", "http"]))).unwrap();
+        assert_eq!(parsed.delivery, ["http"], "unknown mechanisms are dropped");
+    }
+
+    #[test]
+    fn signatures_accept_any_listed_secret() {
+        let body = b"{}";
+        let sig = sign_info("old", body);
+        assert!(verify_info(&["new".into(), "old".into()], body, &sig));
+        assert!(verify_info(
+            &["old".into()],
+            body,
+            &sig.to_ascii_uppercase()
+        ));
+        assert!(!verify_info(&["new".into()], body, &sig));
+        assert!(!verify_info(&["old".into()], b"{ }", &sig));
+        assert!(!verify_info(&[], body, &sig));
+    }
+
+    #[tokio::test]
+    async fn hints_need_a_verified_reachable_peer() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let dir = PeerDirectory::new(vec!["peer.example".into()], vec!["s3cret".into()]);
+        let url = "https://peer.example";
+        assert_eq!(dir.snapshot()[0].source, PeerSource::Config);
+        assert!(dir.hints_for("peer.example").is_none());
+
+        let info = doc("peer.example", &["http", "smtp"]);
+        dir.refresh(&pool, &signed(&info, "s3cret")).await.unwrap();
+        let hints = dir.hints_for("PEER.example").unwrap();
+        assert!(!hints.https && hints.http && hints.smtp);
+        assert_eq!(hints.max_message_size, 1000);
+        assert_eq!(dir.known_domains(), ["peer.example"]);
+
+        dir.check(url, &signed(&info, "wrong")).await;
+        assert!(dir.hints_for("peer.example").is_none());
+        assert!(dir.snapshot()[0]
+            .error
+            .as_deref()
+            .unwrap()
+            .contains("signature"));
+
+        dir.check(url, &signed(&info, "s3cret")).await;
+        dir.check(url, &Canned(Err("connection refused".into())))
+            .await;
+        let status = &dir.snapshot()[0];
+        assert_eq!(status.reachable, Some(false));
+        assert!(status.info.is_some(), "last document is kept for display");
+        assert!(dir.hints_for("peer.example").is_none());
+        assert!(dir.known_domains().is_empty());
+    }
+
+    #[tokio::test]
+    async fn stored_peers_join_config_peers() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        chatmail_db::add_peer(&pool, "b.example").await.unwrap();
+        chatmail_db::add_peer(&pool, "a.example").await.unwrap();
+        let dir = PeerDirectory::new(vec!["a.example".into(), "bad url/".into()], vec![]);
+        dir.refresh(&pool, &Canned(Err("down".into())))
+            .await
+            .unwrap();
+        let peers: Vec<_> = dir
+            .snapshot()
+            .into_iter()
+            .map(|s| (s.base_url, s.source))
+            .collect();
+        assert_eq!(
+            peers,
+            [
+                ("https://a.example".to_string(), PeerSource::Config),
+                ("https://b.example".to_string(), PeerSource::Stored),
+            ]
+        );
+        chatmail_db::remove_peer(&pool, "b.example").await.unwrap();
+        dir.refresh(&pool, &Canned(Err("down".into())))
+            .await
+            .unwrap();
+        assert_eq!(dir.snapshot().len(), 1);
+    }
+}
@@ -0,0 +1,293 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! In-memory view of `policy_entries` shared by every consumer (federation, PGP passthrough,
+//! reserved names, reserved sharing slugs, subaddress folders). Mutations go through
+//! [`PolicyCache::add`] / [`PolicyCache::remove`] (or [`PolicyCache::reload`] after an
+//! out-of-process change) and bump a version that subscribers can watch.
+
+use std::sync::RwLock;
+
+use chatmail_db::policies::{
+    add_policy_entry, list_policy_entries, remove_policy_entry, unix_now, PolicyEntry, PolicyType,
+};
+use chatmail_db::DbPool;
+use chatmail_types::Result;
+use tokio::sync::watch;
+
+#[derive(Debug)]
+pub struct PolicyCache {
+    entries: RwLock<Vec<PolicyEntry>>,
+    version: watch::Sender<u64>,
+}
+
+impl PolicyCache {
+    pub fn new() -> Self {
+        Self {
+            entries: RwLock::new(Vec::new()),
+            version: watch::channel(0).0,
+        }
+    }
+
+    pub async fn hydrate(&self, pool: &DbPool) -> Result<()> {
+        self.reload(pool).await
+    }
+
+    /// Re-read every entry from the database and notify subscribers.
+    pub async fn reload(&self, pool: &DbPool) -> Result<()> {
+        let rows = list_policy_entries(pool, None).await?;
+        *self.entries.write().expect("policy entries lock") = rows;
+        self.bump();
+        Ok(())
+    }
+
+    fn bump(&self) {
+        self.version.send_modify(|v| *v += 1);
+    }
+
+    /// Current change counter (increments on every reload/add/remove).
+    pub fn version(&self) -> u64 {
+        *self.version.borrow()
+    }
+
+    /// Receiver that wakes whenever the entry set changes.
+    pub fn subscribe(&self) -> watch::Receiver<u64> {
+        self.version.subscribe()
+    }
+
+    pub async fn add(
+        &self,
+        pool: &DbPool,
+        policy_type: PolicyType,
+        pattern: &str,
+        action: Option<&str>,
+        note: &str,
+        expires_at: Option<i64>,
+    ) -> Result<PolicyEntry> {
+        let entry = add_policy_entry(pool, policy_type, pattern, action, note, expires_at).await?;
+        {
+            let mut entries = self.entries.write().expect("policy entries lock");
+            entries.retain(|e| !(e.policy_type == entry.policy_type && e.pattern == entry.pattern));
+            entries.push(entry.clone());
+        }
+        self.bump();
+        Ok(entry)
+    }
+
+    pub async fn remove(
+        &self,
+        pool: &DbPool,
+        policy_type: PolicyType,
+        pattern: &str,
+    ) -> Result<bool> {
+        let removed = remove_policy_entry(pool, policy_type, pattern).await?;
+        let pattern = pattern.trim().to_ascii_lowercase();
+        self.entries
+            .write()
+            .expect("policy entries lock")
+            .retain(|e| !(e.policy_type == policy_type.as_str() && e.pattern == pattern));
+        self.bump();
+        Ok(removed)
+    }
+
+    /// Snapshot of entries for one type (expired ones included), sorted by pattern.
+    pub fn list(&self, policy_type: PolicyType) -> Vec<PolicyEntry> {
+        let mut out: Vec<PolicyEntry> = self
+            .entries
+            .read()
+            .expect("policy entries lock")
+            .iter()
+            .filter(|e| e.policy_type == policy_type.as_str())
+            .cloned()
+            .collect();
+        out.sort_by(|a, b| a.pattern.cmp(&b.pattern));
+        out
+    }
+
+    /// Action of the most specific active entry matching `value` (exact beats glob/suffix).
+    pub fn action_for(&self, policy_type: PolicyType, value: &str) -> Option<String> {
+        let now = unix_now();
+        let entries = self.entries.read().expect("policy entries lock");
+        let mut matched: Vec<&PolicyEntry> = entries
+            .iter()
+            .filter(|e| e.policy_type == policy_type.as_str() && e.matches(value, now))
+            .collect();
+        matched.sort_by_key(|e| {
+            (
+                e.pattern.contains(['*', '?']) || e.pattern.starts_with(['.', '@']),
+                std::cmp::Reverse(e.pattern.len()),
+            )
+        });
+        matched.first().map(|e| e.action.clone())
+    }
+
+    /// Federation override: `Some(true)` for `allow`, `Some(false)` for `deny`, `None` when
+    /// no entry applies (legacy `federation_rules` decide).
+    pub fn federation_verdict(&self, domain: &str) -> Option<bool> {
+        self.action_for(PolicyType::Federation, domain)
+            .map(|a| a == "allow")
+    }
+
+    /// Local part (or full address) is held back from registration.
+    pub fn is_reserved_name(&self, user: &str) -> bool {
+        self.action_for(PolicyType::ReservedName, user).is_some()
+    }
+
+    pub fn is_reserved_slug(&self, slug: &str) -> bool {
+        self.action_for(PolicyType::SharingReserved, slug).is_some()
+    }
+
+    /// `user+tag@` mail for `account` is filed into its mailbox `tag` when that exists.
+    pub fn files_subaddresses(&self, account: &str) -> bool {
+        self.action_for(PolicyType::SubaddressFolder, account)
+            .is_some()
+    }
+
+    /// PGP-only gate is skipped when every recipient is listed, or when `sender` is. Pass a
+    /// sender only for authenticated submission: inbound (port 25, `/mxdeliv`) `MAIL FROM` is
+    /// whatever the peer claims, so a listed sender there would let anyone send plaintext.
+    pub fn pgp_passthrough(&self, sender: Option<&str>, recipients: &[String]) -> bool {
+        let listed = |addr: &str| self.action_for(PolicyType::PgpPassthrough, addr).is_some();
+        sender.is_some_and(|s| !s.is_empty() && listed(s))
+            || (!recipients.is_empty() && recipients.iter().all(|r| listed(r)))
+    }
+}
+
+impl Default for PolicyCache {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_db::init_memory_db;
+
+    #[tokio::test]
+    async fn add_remove_notifies_and_matches() {
+        let pool = init_memory_db().await.unwrap();
+        let cache = PolicyCache::new();
+        cache.hydrate(&pool).await.unwrap();
+        let mut rx = cache.subscribe();
+        rx.borrow_and_update();
+
+        cache
+            .add(
+                &pool,
+                PolicyType::Federation,
+                ".evil.example",
+                None,
+                "",
+                None,
+            )
+            .await
+            .unwrap();
+        assert!(rx.has_changed().unwrap());
+        assert_eq!(cache.federation_verdict("mx.evil.example"), Some(false));
+        assert_eq!(cache.federation_verdict("good.example"), None);
+
+        cache
+            .add(
+                &pool,
+                PolicyType::Federation,
+                "ok.evil.example",
+                Some("allow"),
+                "",
+                None,
+            )
+            .await
+            .unwrap();
+        assert_eq!(
+            cache.federation_verdict("ok.evil.example"),
+            Some(true),
+            "exact entry wins over suffix"
+        );
+
+        assert!(cache
+            .remove(&pool, PolicyType::Federation, ".evil.example")
+            .await
+            .unwrap());
+        assert_eq!(cache.federation_verdict("mx.evil.example"), None);
+
+        let fresh = PolicyCache::new();
+        fresh.reload(&pool).await.unwrap();
+        assert_eq!(fresh.list(PolicyType::Federation).len(), 1);
+    }
+
+    #[tokio::test]
+    async fn expired_entries_do_not_match() {
+        let pool = init_memory_db().await.unwrap();
+        let cache = PolicyCache::new();
+        let now = unix_now();
+        cache
+            .add(
+                &pool,
+                PolicyType::ReservedName,
+                "old*",
+                None,
+                "",
+                Some(now - 10),
+            )
+            .await
+            .unwrap();
+        cache
+            .add(
+                &pool,
+                PolicyType::ReservedName,
+                "admin",
+                None,
+                "",
+                Some(now + 3600),
+            )
+            .await
+            .unwrap();
+        assert!(!cache.is_reserved_name("oldname@example.org"));
+        assert!(cache.is_reserved_name("admin@example.org"));
+    }
+
+    #[tokio::test]
+    async fn pgp_passthrough_authenticated_sender_or_all_recipients() {
+        let pool = init_memory_db().await.unwrap();
+        let cache = PolicyCache::new();
+        cache
+            .add(
+                &pool,
+                PolicyType::PgpPassthrough,
+                "@partner.example",
+                None,
+                "",
+                None,
+            )
+            .await
+            .unwrap();
+        let local: &[String] = &["x@local.test".into()];
+        assert!(cache.pgp_passthrough(Some("a@partner.example"), local));
+        assert!(
+            !cache.pgp_passthrough(None, local),
+            "inbound callers pass no sender: MAIL FROM is unauthenticated"
+        );
+        assert!(cache.pgp_passthrough(
+            None,
+            &["a@partner.example".into(), "b@partner.example".into()]
+        ));
+        assert!(!cache.pgp_passthrough(
+            Some("x@local.test"),
+            &["a@partner.example".into(), "y@local.test".into()]
+        ));
+    }
+}
@@ -16,13 +16,15 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 use std::collections::HashSet;
-use std::sync::RwLock;
+use std::sync::{Arc, RwLock};
 
 use chatmail_db::{
     db_execute, db_fetch_all, federation_policy_label, normalize_federation_domain, pg_sql, DbPool,
 };
 use chatmail_types::Result;
 
+use crate::policies::PolicyCache;
+
 /// Global federation mode (TDD / Madmail admin API).
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub enum PolicyMode {
@@ -46,13 +48,20 @@ impl PolicyMode {
 pub struct FederationPolicyCache {
     exceptions: RwLock<HashSet<String>>,
     global_mode: RwLock<PolicyMode>,
+    /// `federation` entries from `policy_entries`; an active match overrides the global mode.
+    overrides: Arc<PolicyCache>,
 }
 
 impl FederationPolicyCache {
     pub fn new() -> Self {
+        Self::with_policies(Arc::new(PolicyCache::new()))
+    }
+
+    pub fn with_policies(overrides: Arc<PolicyCache>) -> Self {
         Self {
             exceptions: RwLock::new(HashSet::new()),
             global_mode: RwLock::new(PolicyMode::Accept),
+            overrides,
         }
     }
 
@@ -85,6 +94,9 @@ impl FederationPolicyCache {
 
     /// Madmail `CheckFederationPolicy` with normalized domain.
     pub fn check_policy_normalized(&self, domain: &str, global_policy: PolicyMode) -> bool {
+        if let Some(allowed) = self.overrides.federation_verdict(domain) {
+            return allowed;
+        }
         let exceptions = self.exceptions.read().expect("policy cache lock");
         let listed = exceptions.contains(domain);
         match global_policy {
@@ -0,0 +1,314 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Hashcash challenges in front of `POST /new` (`proof_of_work_bits`).
+//!
+//! `GET /new` hands out a random nonce; the client searches for a hex counter whose
+//! SHA-256 over `nonce + solution` starts with the configured number of zero bits and sends
+//! both with the registration. Challenges live in memory for [`POW_CHALLENGE_TTL`], are
+//! single use, and [`start_proof_of_work_sweep`] drops the ones nobody solved.
+//!
+//! The `__PROOF_OF_WORK_BITS__` setting overrides the difficulty at runtime. A challenge is
+//! checked against the difficulty it was issued with, so changing it does not strand
+//! clients halfway through a search.
+
+use std::sync::atomic::{AtomicU32, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
+
+use chatmail_config::{AppConfig, MAX_PROOF_OF_WORK_BITS};
+use chatmail_db::{get_setting, settings_keys, DbPool};
+use chatmail_types::Result;
+use dashmap::DashMap;
+use sha2::{Digest, Sha256};
+use tokio::task::JoinHandle;
+
+/// How long an issued challenge may be solved.
+pub const POW_CHALLENGE_TTL: Duration = Duration::from_secs(300);
+/// Outstanding challenges kept at most; `GET /new` floods past this get nothing.
+const MAX_OUTSTANDING: usize = 100_000;
+/// How often expired challenges are swept.
+const SWEEP_INTERVAL: Duration = Duration::from_secs(60);
+
+/// What `GET /new` returns to the client.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PowChallenge {
+    /// 16 random bytes, hex.
+    pub nonce: String,
+    pub bits: u32,
+    /// Unix seconds after which the challenge is refused.
+    pub expires: u64,
+}
+
+/// Why a submitted solution was refused.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PowError {
+    /// No `challenge_nonce` / `solution` in the request.
+    Missing,
+    /// Never issued, already used, or swept.
+    Unknown,
+    /// Issued, but [`POW_CHALLENGE_TTL`] has passed.
+    Expired,
+    /// The hash does not have enough leading zero bits (or the solution is not hex).
+    Insufficient,
+}
+
+impl PowError {
+    pub fn message(self) -> &'static str {
+        match self {
+            Self::Missing => "Proof of work required",
+            Self::Unknown => "Unknown or already used challenge",
+            Self::Expired => "Challenge expired",
+            Self::Insufficient => "Proof of work does not meet the difficulty",
+        }
+    }
+}
+
+#[derive(Debug)]
+pub struct PowChallenges {
+    /// Leading zero bits required; `0` = challenges off.
+    bits: AtomicU32,
+    ttl: Duration,
+    /// nonce → (deadline, bits it was issued with).
+    entries: DashMap<String, (Instant, u32)>,
+}
+
+impl PowChallenges {
+    pub fn new(bits: u32, ttl: Duration) -> Self {
+        Self {
+            bits: AtomicU32::new(bits.min(MAX_PROOF_OF_WORK_BITS)),
+            ttl,
+            entries: DashMap::new(),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.proof_of_work_bits.unwrap_or(0), POW_CHALLENGE_TTL)
+    }
+
+    pub fn is_enabled(&self) -> bool {
+        self.bits() > 0
+    }
+
+    pub fn bits(&self) -> u32 {
+        self.bits.load(Ordering::Relaxed)
+    }
+
+    /// Change the difficulty of challenges issued from now on (clamped to
+    /// [`MAX_PROOF_OF_WORK_BITS`]).
+    pub fn set_bits(&self, bits: u32) {
+        self.bits
+            .store(bits.min(MAX_PROOF_OF_WORK_BITS), Ordering::Relaxed);
+    }
+
+    /// Difficulty from `__PROOF_OF_WORK_BITS__`, falling back to `proof_of_work_bits`.
+    pub async fn refresh_from_db(&self, pool: &DbPool, config: &AppConfig) -> Result<()> {
+        let db = get_setting(pool, settings_keys::PROOF_OF_WORK_BITS).await?;
+        let bits = db
+            .as_deref()
+            .and_then(|v| v.trim().parse::<u32>().ok())
+            .or(config.proof_of_work_bits)
+            .unwrap_or(0);
+        self.set_bits(bits);
+        Ok(())
+    }
+
+    /// Hand out a fresh challenge; `None` when off or too many are outstanding.
+    pub fn issue(&self) -> Option<PowChallenge> {
+        if !self.is_enabled() {
+            return None;
+        }
+        if self.entries.len() >= MAX_OUTSTANDING && self.evict_expired() >= MAX_OUTSTANDING {
+            return None;
+        }
+        let bits = self.bits();
+        let nonce = format!("{:032x}", rand::random::<u128>());
+        self.entries
+            .insert(nonce.clone(), (Instant::now() + self.ttl, bits));
+        let expires = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .unwrap_or_default()
+            .saturating_add(self.ttl)
+            .as_secs();
+        Some(PowChallenge {
+            nonce,
+            bits,
+            expires,
+        })
+    }
+
+    /// Check `solution` against the challenge `nonce` and consume it on success.
+    pub fn verify(&self, nonce: &str, solution: &str) -> Result<(), PowError> {
+        self.verify_at(nonce, solution, Instant::now())
+    }
+
+    fn verify_at(&self, nonce: &str, solution: &str, now: Instant) -> Result<(), PowError> {
+        if !self.is_enabled() {
+            return Ok(());
+        }
+        let (nonce, solution) = (nonce.trim(), solution.trim());
+        if nonce.is_empty() || solution.is_empty() {
+            return Err(PowError::Missing);
+        }
+        let (deadline, bits) = *self.entries.get(nonce).ok_or(PowError::Unknown)?;
+        if now >= deadline {
+            self.entries.remove(nonce);
+            return Err(PowError::Expired);
+        }
+        if !meets_difficulty(nonce, solution, bits) {
+            return Err(PowError::Insufficient);
+        }
+        // Two racing submissions of one solution: only the one that removes the entry wins.
+        self.entries
+            .remove(nonce)
+            .map(|_| ())
+            .ok_or(PowError::Unknown)
+    }
+
+    /// Drop challenges past their deadline; returns how many are left.
+    pub fn evict_expired(&self) -> usize {
+        let now = Instant::now();
+        self.entries.retain(|_, (deadline, _)| now < *deadline);
+        self.entries.len()
+    }
+}
+
+/// Whether SHA-256(`nonce` + `solution`) starts with `bits` zero bits. `solution` must be hex.
+pub fn meets_difficulty(nonce: &str, solution: &str, bits: u32) -> bool {
+    if solution.len() > 64 || !solution.bytes().all(|b| b.is_ascii_hexdigit()) {
+        return false;
+    }
+    let digest = Sha256::new()
+        .chain_update(nonce.as_bytes())
+        .chain_update(solution.as_bytes())
+        .finalize();
+    leading_zero_bits(&digest) >= bits
+}
+
+fn leading_zero_bits(bytes: &[u8]) -> u32 {
+    let mut zeros = 0;
+    for b in bytes {
+        zeros += b.leading_zeros();
+        if *b != 0 {
+            break;
+        }
+    }
+    zeros
+}
+
+/// Evict expired challenges once a minute.
+pub fn start_proof_of_work_sweep(challenges: Arc<PowChallenges>) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(SWEEP_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            challenges.evict_expired();
+        }
+    })
+}
+
+/// Brute-force a solution the way a client would; used by tests.
+pub fn solve(nonce: &str, bits: u32) -> String {
+    (0u64..)
+        .map(|n| format!("{n:x}"))
+        .find(|s| meets_difficulty(nonce, s, bits))
+        .expect("counter space exhausted")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn valid_solution_passes_once() {
+        let pow = PowChallenges::new(8, POW_CHALLENGE_TTL);
+        let challenge = pow.issue().unwrap();
+        assert_eq!(challenge.nonce.len(), 32);
+        assert_eq!(challenge.bits, 8);
+        let solution = solve(&challenge.nonce, 8);
+        assert_eq!(
+            pow.verify(&challenge.nonce, "zz"),
+            Err(PowError::Insufficient)
+        );
+        assert_eq!(pow.verify(&challenge.nonce, &solution), Ok(()));
+        // Replaying the same solution finds no challenge.
+        assert_eq!(
+            pow.verify(&challenge.nonce, &solution),
+            Err(PowError::Unknown)
+        );
+        assert_eq!(pow.verify("", &solution), Err(PowError::Missing));
+    }
+
+    #[test]
+    fn expired_challenge_is_refused_and_dropped() {
+        let pow = PowChallenges::new(4, POW_CHALLENGE_TTL);
+        let challenge = pow.issue().unwrap();
+        let solution = solve(&challenge.nonce, 4);
+        let late = Instant::now() + POW_CHALLENGE_TTL + Duration::from_secs(1);
+        assert_eq!(
+            pow.verify_at(&challenge.nonce, &solution, late),
+            Err(PowError::Expired)
+        );
+        assert_eq!(
+            pow.verify(&challenge.nonce, &solution),
+            Err(PowError::Unknown)
+        );
+
+        let pow = PowChallenges::new(4, Duration::ZERO);
+        pow.issue().unwrap();
+        assert_eq!(pow.evict_expired(), 0);
+    }
+
+    #[test]
+    fn zero_bits_disables_challenges() {
+        let pow = PowChallenges::new(0, POW_CHALLENGE_TTL);
+        assert!(pow.issue().is_none());
+        assert_eq!(pow.verify("", ""), Ok(()));
+        assert_eq!(leading_zero_bits(&[0, 0x10, 0xff]), 11);
+        assert_eq!(PowChallenges::new(99, POW_CHALLENGE_TTL).bits(), 32);
+    }
+
+    #[test]
+    fn challenge_keeps_the_difficulty_it_was_issued_with() {
+        let pow = PowChallenges::new(4, POW_CHALLENGE_TTL);
+        let challenge = pow.issue().unwrap();
+        pow.set_bits(30);
+        assert_eq!(pow.issue().unwrap().bits, 30);
+        let solution = solve(&challenge.nonce, 4);
+        assert_eq!(pow.verify(&challenge.nonce, &solution), Ok(()));
+        pow.set_bits(0);
+        assert!(pow.issue().is_none());
+    }
+
+    #[tokio::test]
+    async fn setting_overrides_config_bits() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let config = AppConfig {
+            proof_of_work_bits: Some(12),
+            ..AppConfig::default()
+        };
+        let pow = PowChallenges::from_config(&config);
+        pow.refresh_from_db(&pool, &config).await.unwrap();
+        assert_eq!(pow.bits(), 12);
+        chatmail_db::set_setting(&pool, settings_keys::PROOF_OF_WORK_BITS, "20")
+            .await
+            .unwrap();
+        pow.refresh_from_db(&pool, &config).await.unwrap();
+        assert_eq!(pow.bits(), 20);
+    }
+}
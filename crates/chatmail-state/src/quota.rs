@@ -28,6 +28,11 @@ use chatmail_db::settings_keys::GLOBAL_QUOTA_USERNAME;
 #[derive(Debug)]
 pub struct QuotaEntry {
     pub used_bytes: AtomicU64,
+    /// Portion of `used_bytes` in messages flagged `\Deleted` but not yet expunged. IMAP and
+    /// webimap unlink a message as soon as `\Deleted` is set (relay semantics), so this only
+    /// covers `:2,T` files that arrived by other means (restored or copied-in maildirs) until
+    /// the next EXPUNGE.
+    pub deleted_bytes: AtomicU64,
     pub max_bytes: u64,
     pub is_default: bool,
 }
@@ -36,10 +41,39 @@ impl QuotaEntry {
     fn new(used: u64, max: u64, is_default: bool) -> Self {
         Self {
             used_bytes: AtomicU64::new(used),
+            deleted_bytes: AtomicU64::new(0),
             max_bytes: max,
             is_default,
         }
     }
+
+    fn with_deleted(self, deleted: u64) -> Self {
+        self.deleted_bytes.store(deleted, Ordering::Relaxed);
+        self
+    }
+}
+
+/// One account's counters before and after [`QuotaCache::recalculate`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct QuotaRecalc {
+    pub username: String,
+    /// Cached `(used, of which deleted)` before the scan.
+    pub before: (u64, u64),
+    /// `(used, of which deleted)` on disk, now also cached.
+    pub after: (u64, u64),
+}
+
+impl QuotaRecalc {
+    pub fn drifted(&self) -> bool {
+        self.before != self.after
+    }
+}
+
+/// Saturating atomic subtract (counters must never wrap below zero).
+fn sub_saturating(counter: &AtomicU64, bytes: u64) {
+    let _ = counter.fetch_update(Ordering::Relaxed, Ordering::Relaxed, |v| {
+        Some(v.saturating_sub(bytes))
+    });
 }
 
 #[derive(Debug)]
@@ -68,7 +102,8 @@ impl QuotaCache {
         self.default_max_bytes.store(bytes, Ordering::Relaxed);
     }
 
-    /// Per-user cap lookup (Madmail `GetQuota` / admin accounts).
+    /// Per-user cap lookup (Madmail `GetQuota` / admin accounts). See [`Self::usage`] for the
+    /// `\Deleted` share of `used`.
     pub fn get_quota(&self, user: &str) -> (u64, u64, bool) {
         let default_max = self.default_max_bytes();
         match self.entries.get(user) {
@@ -106,9 +141,11 @@ impl QuotaCache {
             self.reset_max(user);
             return;
         }
-        let used = self.used_bytes(user);
-        self.entries
-            .insert(user.to_string(), QuotaEntry::new(used, max, false));
+        let (used, deleted) = self.usage(user);
+        self.entries.insert(
+            user.to_string(),
+            QuotaEntry::new(used, max, false).with_deleted(deleted),
+        );
     }
 
     /// Drop cached quota for a removed account (Madmail `QuotaCache.Invalidate`).
@@ -140,20 +177,24 @@ impl QuotaCache {
 
         let all_users = passwords::list_users(pool).await?;
         for user in &all_users {
-            let used = store.maildir_used_bytes(user).await.unwrap_or(0);
+            let (used, deleted) = store.maildir_usage(user).await.unwrap_or((0, 0));
             let (max, is_default) = per_user_max(&quota_map, user, default_max);
-            self.entries
-                .insert(user.clone(), QuotaEntry::new(used, max, is_default));
+            self.entries.insert(
+                user.clone(),
+                QuotaEntry::new(used, max, is_default).with_deleted(deleted),
+            );
         }
 
         for user in quota_map.keys() {
             if self.entries.contains_key(user) {
                 continue;
             }
-            let used = store.maildir_used_bytes(user).await.unwrap_or(0);
+            let (used, deleted) = store.maildir_usage(user).await.unwrap_or((0, 0));
             let (max, is_default) = per_user_max(&quota_map, user, default_max);
-            self.entries
-                .insert(user.clone(), QuotaEntry::new(used, max, is_default));
+            self.entries.insert(
+                user.clone(),
+                QuotaEntry::new(used, max, is_default).with_deleted(deleted),
+            );
         }
 
         // Maildirs without a credentials row (legacy / manual dirs).
@@ -168,10 +209,12 @@ impl QuotaCache {
                 if self.entries.contains_key(&user) {
                     continue;
                 }
-                let used = store.maildir_used_bytes(&user).await.unwrap_or(0);
+                let (used, deleted) = store.maildir_usage(&user).await.unwrap_or((0, 0));
                 let (max, is_default) = per_user_max(&quota_map, &user, default_max);
-                self.entries
-                    .insert(user, QuotaEntry::new(used, max, is_default));
+                self.entries.insert(
+                    user,
+                    QuotaEntry::new(used, max, is_default).with_deleted(deleted),
+                );
             }
         }
 
@@ -198,6 +241,70 @@ impl QuotaCache {
             .insert(user.to_string(), QuotaEntry::new(bytes, max, is_default));
     }
 
+    /// A message left the account (expunge / immediate delete); `was_deleted` if it carried
+    /// `\Deleted` and therefore sat in the deleted counter.
+    pub fn record_remove(&self, user: &str, bytes: u64, was_deleted: bool) {
+        let Some(entry) = self.entries.get(user) else {
+            return;
+        };
+        sub_saturating(&entry.used_bytes, bytes);
+        if was_deleted {
+            sub_saturating(&entry.deleted_bytes, bytes);
+        }
+    }
+
+    /// Replace `user`'s cached counters with a fresh scan of every mailbox on disk, fixing
+    /// drift from crashes or writes that bypassed [`Self::record_write`]. A delivery that
+    /// lands while the scan runs may be counted by neither; the next recalculation fixes it.
+    pub async fn recalculate(&self, user: &str, store: &MailboxStore) -> Result<QuotaRecalc> {
+        let before = self.usage(user);
+        let (used, deleted) = store.maildir_usage(user).await?;
+        match self.entries.get(user) {
+            Some(entry) => {
+                entry.used_bytes.store(used, Ordering::Relaxed);
+                entry.deleted_bytes.store(deleted, Ordering::Relaxed);
+            }
+            None => {
+                let (_, max, is_default) = self.get_quota(user);
+                self.entries.insert(
+                    user.to_string(),
+                    QuotaEntry::new(used, max, is_default).with_deleted(deleted),
+                );
+            }
+        }
+        Ok(QuotaRecalc {
+            username: user.to_string(),
+            before,
+            after: (used, deleted),
+        })
+    }
+
+    /// [`Self::recalculate`] for every account in the credentials table.
+    pub async fn recalculate_all(
+        &self,
+        pool: &DbPool,
+        store: &MailboxStore,
+    ) -> Result<Vec<QuotaRecalc>> {
+        let mut out = Vec::new();
+        for user in passwords::list_users(pool).await? {
+            out.push(self.recalculate(&user, store).await?);
+        }
+        Ok(out)
+    }
+
+    /// `(used, of which \Deleted)`.
+    pub fn usage(&self, user: &str) -> (u64, u64) {
+        self.entries
+            .get(user)
+            .map(|e| {
+                (
+                    e.used_bytes.load(Ordering::Relaxed),
+                    e.deleted_bytes.load(Ordering::Relaxed),
+                )
+            })
+            .unwrap_or((0, 0))
+    }
+
     pub fn used_bytes(&self, user: &str) -> u64 {
         self.get_quota(user).0
     }
@@ -332,4 +439,111 @@ mod tests {
         cache.record_write("u@example.org", 50);
         assert_eq!(cache.used_bytes("u@example.org"), 150);
     }
+
+    /// The paths clients use: `+FLAGS (\Deleted)` unlinks at once and EXPUNGE removes `:2,T`
+    /// files left by a restored maildir; the counters follow the disk either way.
+    #[tokio::test]
+    async fn deleted_messages_leave_the_charge_at_once() {
+        use chatmail_storage::{expunge_deleted_bytes, store_add_flags};
+
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(dir.path());
+        let user = "u@x.org";
+        chatmail_db::passwords::create_user(&pool, user, "h")
+            .await
+            .unwrap();
+        let paths = store.init_user_dir(user).await.unwrap();
+        for (name, len) in [("a:2,S", 100usize), ("b:2,S", 250), ("c:2,ST", 40)] {
+            tokio::fs::write(paths.cur.join(name), vec![b'x'; len])
+                .await
+                .unwrap();
+        }
+        let cache = QuotaCache::new(10_000);
+        cache.hydrate(&pool, &store).await.unwrap();
+        assert_eq!(cache.usage(user), (390, 40));
+
+        let flags = store_add_flags(&store, user, "INBOX", "a", false, true)
+            .await
+            .unwrap();
+        assert!(flags.deleted);
+        cache.record_remove(user, 100, false);
+        assert_eq!(cache.usage(user), store.maildir_usage(user).await.unwrap());
+        assert_eq!(cache.used_bytes(user), 290);
+
+        let (n, freed) = expunge_deleted_bytes(&store, user, "INBOX").await.unwrap();
+        assert_eq!((n, freed), (1, 40));
+        cache.record_remove(user, freed, true);
+        assert_eq!(cache.usage(user), store.maildir_usage(user).await.unwrap());
+        assert_eq!(cache.usage(user), (250, 0));
+    }
+
+    /// Counters pushed off the disk truth in both directions converge after a recalculation,
+    /// folders included.
+    #[tokio::test]
+    async fn recalculate_converges_to_disk_usage() {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(dir.path());
+        for user in ["a@x.org", "b@x.org", "c@x.org"] {
+            chatmail_db::passwords::create_user(&pool, user, "h")
+                .await
+                .unwrap();
+        }
+        let inbox = store.init_user_dir("a@x.org").await.unwrap();
+        tokio::fs::write(inbox.cur.join("m1:2,S"), vec![b'x'; 300])
+            .await
+            .unwrap();
+        tokio::fs::write(inbox.cur.join("m2:2,ST"), vec![b'x'; 50])
+            .await
+            .unwrap();
+        let folder = store
+            .init_mailbox_dir("a@x.org", "DeltaChat")
+            .await
+            .unwrap();
+        tokio::fs::write(folder.new.join("m3"), vec![b'x'; 120])
+            .await
+            .unwrap();
+        let b_inbox = store.init_user_dir("b@x.org").await.unwrap();
+        tokio::fs::write(b_inbox.new.join("m4"), vec![b'x'; 10])
+            .await
+            .unwrap();
+
+        let cache = QuotaCache::new(10_000);
+        cache.hydrate(&pool, &store).await.unwrap();
+        assert_eq!(cache.usage("a@x.org"), (470, 50));
+
+        // A copy that was never charged, a removal counted twice, a crash mid-update.
+        cache.record_write("a@x.org", 999);
+        cache.record_remove("b@x.org", 10, false);
+        cache
+            .entries
+            .get("a@x.org")
+            .unwrap()
+            .deleted_bytes
+            .fetch_add(300, Ordering::Relaxed);
+        cache.record_write("c@x.org", 42);
+
+        let report = cache.recalculate_all(&pool, &store).await.unwrap();
+        let mut drifted: Vec<_> = report
+            .iter()
+            .filter(|r| r.drifted())
+            .map(|r| r.username.as_str())
+            .collect();
+        drifted.sort();
+        assert_eq!(drifted, ["a@x.org", "b@x.org", "c@x.org"]);
+        let a = report.iter().find(|r| r.username == "a@x.org").unwrap();
+        assert_eq!(a.before, (1469, 350));
+        assert_eq!(a.after, (470, 50));
+        for user in ["a@x.org", "b@x.org", "c@x.org"] {
+            assert_eq!(
+                cache.usage(user),
+                store.maildir_usage(user).await.unwrap(),
+                "{user}"
+            );
+        }
+
+        let again = cache.recalculate_all(&pool, &store).await.unwrap();
+        assert!(again.iter().all(|r| !r.drifted()));
+    }
 }
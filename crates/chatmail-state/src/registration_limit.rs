@@ -0,0 +1,240 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Token bucket per client subnet in front of `POST /new` (`reg_rate_limit`,
+//! `reg_rate_burst`).
+//!
+//! IPv4 clients share a bucket per /24, IPv6 clients per /64, so rotating addresses inside
+//! one allocation does not buy more accounts. Buckets live in memory only;
+//! [`start_registration_limit_sweep`] drops the ones idle for [`REG_BUCKET_IDLE`].
+//!
+//! The `__REGISTRATION_RATE__` setting (`10/1h`) overrides rate and burst at runtime;
+//! existing buckets pick the new values up on their next request.
+
+use std::net::{IpAddr, Ipv4Addr, Ipv6Addr};
+use std::sync::atomic::{AtomicU32, AtomicU64, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+use chatmail_config::{
+    parse_registration_rate, AppConfig, DEFAULT_REG_RATE_BURST, DEFAULT_REG_RATE_LIMIT,
+};
+use chatmail_db::{get_setting, settings_keys, DbPool};
+use chatmail_types::Result;
+use dashmap::DashMap;
+use tokio::task::JoinHandle;
+
+/// Buckets untouched this long are full again and get dropped.
+pub const REG_BUCKET_IDLE: Duration = Duration::from_secs(600);
+/// How often idle buckets are swept.
+const SWEEP_INTERVAL: Duration = Duration::from_secs(60);
+
+#[derive(Debug, Clone, Copy)]
+struct Bucket {
+    tokens: f64,
+    at: Instant,
+}
+
+#[derive(Debug)]
+pub struct RegistrationLimiter {
+    /// Tokens added per second (`f64` bits); `0` = limiter off.
+    rate: AtomicU64,
+    burst: AtomicU32,
+    buckets: DashMap<IpAddr, Bucket>,
+}
+
+impl RegistrationLimiter {
+    pub fn new(rate: f64, burst: u32) -> Self {
+        let limiter = Self {
+            rate: AtomicU64::new(0),
+            burst: AtomicU32::new(1),
+            buckets: DashMap::new(),
+        };
+        limiter.set_limits(rate, burst);
+        limiter
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(
+            config.reg_rate_limit.unwrap_or(DEFAULT_REG_RATE_LIMIT),
+            config.reg_rate_burst.unwrap_or(DEFAULT_REG_RATE_BURST),
+        )
+    }
+
+    pub fn is_enabled(&self) -> bool {
+        self.rate() > 0.0
+    }
+
+    /// Tokens added per second per subnet.
+    pub fn rate(&self) -> f64 {
+        f64::from_bits(self.rate.load(Ordering::Relaxed))
+    }
+
+    pub fn burst(&self) -> u32 {
+        self.burst.load(Ordering::Relaxed)
+    }
+
+    /// Replace rate and burst; negative or non-finite rates turn the limiter off.
+    pub fn set_limits(&self, rate: f64, burst: u32) {
+        let rate = if rate.is_finite() { rate.max(0.0) } else { 0.0 };
+        self.rate.store(rate.to_bits(), Ordering::Relaxed);
+        self.burst.store(burst.max(1), Ordering::Relaxed);
+    }
+
+    /// Limits from `__REGISTRATION_RATE__`, falling back to `reg_rate_limit` /
+    /// `reg_rate_burst`. A bare per-second override keeps the configured burst.
+    pub async fn refresh_from_db(&self, pool: &DbPool, config: &AppConfig) -> Result<()> {
+        let mut rate = config.reg_rate_limit.unwrap_or(DEFAULT_REG_RATE_LIMIT);
+        let mut burst = config.reg_rate_burst.unwrap_or(DEFAULT_REG_RATE_BURST);
+        let db = get_setting(pool, settings_keys::REGISTRATION_RATE).await?;
+        if let Some(over) = db.as_deref().and_then(parse_registration_rate) {
+            rate = over.per_second;
+            burst = over.burst.unwrap_or(burst);
+        }
+        self.set_limits(rate, burst);
+        Ok(())
+    }
+
+    /// Take a token for `ip`'s subnet, or return how long until one is available.
+    pub fn check(&self, ip: IpAddr) -> Result<(), Duration> {
+        self.check_at(ip, Instant::now())
+    }
+
+    fn check_at(&self, ip: IpAddr, now: Instant) -> Result<(), Duration> {
+        let rate = self.rate();
+        if rate <= 0.0 {
+            return Ok(());
+        }
+        let burst = f64::from(self.burst());
+        let mut bucket = self.buckets.entry(subnet(ip)).or_insert(Bucket {
+            tokens: burst,
+            at: now,
+        });
+        let elapsed = now.saturating_duration_since(bucket.at).as_secs_f64();
+        bucket.tokens = (bucket.tokens + elapsed * rate).min(burst);
+        bucket.at = now;
+        if bucket.tokens >= 1.0 {
+            bucket.tokens -= 1.0;
+            return Ok(());
+        }
+        Err(Duration::from_secs_f64((1.0 - bucket.tokens) / rate))
+    }
+
+    /// Drop buckets not used for `idle`; returns how many are left.
+    pub fn evict_idle(&self, idle: Duration) -> usize {
+        let now = Instant::now();
+        self.buckets
+            .retain(|_, b| now.saturating_duration_since(b.at) < idle);
+        self.buckets.len()
+    }
+}
+
+/// The /24 (IPv4, including v4-mapped IPv6) or /64 (IPv6) that `ip` belongs to.
+fn subnet(ip: IpAddr) -> IpAddr {
+    match ip {
+        IpAddr::V6(v6) => match v6.to_ipv4_mapped() {
+            Some(v4) => subnet(IpAddr::V4(v4)),
+            None => IpAddr::V6(Ipv6Addr::from(u128::from(v6) & !(u128::MAX >> 64))),
+        },
+        IpAddr::V4(v4) => IpAddr::V4(Ipv4Addr::from(u32::from(v4) & 0xffff_ff00)),
+    }
+}
+
+/// Evict buckets idle for [`REG_BUCKET_IDLE`] once a minute.
+pub fn start_registration_limit_sweep(limiter: Arc<RegistrationLimiter>) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(SWEEP_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            limiter.evict_idle(REG_BUCKET_IDLE);
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn ip(s: &str) -> IpAddr {
+        s.parse().unwrap()
+    }
+
+    #[test]
+    fn fourth_request_from_one_subnet_is_refused() {
+        let limiter = RegistrationLimiter::new(1.0, 3);
+        let now = Instant::now();
+        for host in ["198.51.100.1", "198.51.100.2", "198.51.100.200"] {
+            assert!(limiter.check_at(ip(host), now).is_ok());
+        }
+        let wait = limiter.check_at(ip("198.51.100.9"), now).unwrap_err();
+        assert_eq!(wait, Duration::from_secs(1));
+        // Other subnets have their own bucket.
+        assert!(limiter.check_at(ip("198.51.101.1"), now).is_ok());
+        assert!(limiter.check_at(ip("2001:db8::1"), now).is_ok());
+        // One token back per second.
+        let later = now + Duration::from_secs(1);
+        assert!(limiter.check_at(ip("198.51.100.9"), later).is_ok());
+        assert!(limiter.check_at(ip("198.51.100.9"), later).is_err());
+    }
+
+    #[test]
+    fn subnets_and_off_switch() {
+        assert_eq!(subnet(ip("::ffff:203.0.113.77")), ip("203.0.113.0"));
+        assert_eq!(subnet(ip("2001:db8:1:2:3::4")), ip("2001:db8:1:2::"));
+        let off = RegistrationLimiter::new(0.0, 1);
+        for _ in 0..10 {
+            assert!(off.check(ip("192.0.2.1")).is_ok());
+        }
+        let limiter = RegistrationLimiter::new(1.0, 1);
+        limiter.check(ip("192.0.2.1")).unwrap();
+        assert_eq!(limiter.evict_idle(Duration::ZERO), 0);
+    }
+
+    #[tokio::test]
+    async fn setting_overrides_config_limits() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let config = AppConfig {
+            reg_rate_limit: Some(0.5),
+            reg_rate_burst: Some(4),
+            ..AppConfig::default()
+        };
+        let limiter = RegistrationLimiter::from_config(&config);
+        chatmail_db::set_setting(&pool, settings_keys::REGISTRATION_RATE, "2/1h")
+            .await
+            .unwrap();
+        limiter.refresh_from_db(&pool, &config).await.unwrap();
+        assert_eq!(limiter.burst(), 2);
+        let now = Instant::now();
+        assert!(limiter.check_at(ip("192.0.2.1"), now).is_ok());
+        assert!(limiter.check_at(ip("192.0.2.1"), now).is_ok());
+        let wait = limiter.check_at(ip("192.0.2.1"), now).unwrap_err();
+        assert_eq!(wait.as_secs_f64().round(), 1800.0);
+        // A bare per-second rate keeps the configured burst.
+        chatmail_db::set_setting(&pool, settings_keys::REGISTRATION_RATE, "0")
+            .await
+            .unwrap();
+        limiter.refresh_from_db(&pool, &config).await.unwrap();
+        assert!(!limiter.is_enabled());
+        assert_eq!(limiter.burst(), 4);
+        chatmail_db::delete_setting(&pool, settings_keys::REGISTRATION_RATE)
+            .await
+            .unwrap();
+        limiter.refresh_from_db(&pool, &config).await.unwrap();
+        assert_eq!((limiter.rate(), limiter.burst()), (0.5, 4));
+    }
+}
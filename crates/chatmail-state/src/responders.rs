@@ -0,0 +1,216 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Automatic responders configured with `madmail responder`, and per-account autoresponses
+//! (`madmail autoresponse`), cached for the delivery path.
+//!
+//! The `responders` and `autoresponses` tables are the source of truth.
+//! [`Responders::refresh`] reloads both on a 30 s timer, so replies set from the CLI while the
+//! server runs take effect without a reload; the same timer prunes the reply logs.
+
+use std::collections::HashMap;
+use std::sync::{Arc, RwLock};
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use chatmail_db::{Autoresponse, DbPool, Responder, DEFAULT_AUTORESPONSE_WINDOW_SECS};
+use chatmail_types::Result;
+use tokio::task::JoinHandle;
+
+/// How often the responder list is reloaded from the database. This bounds how long a CLI
+/// write (database only) or an API write whose immediate [`Responders::refresh`] failed stays
+/// invisible to delivery.
+pub const RESPONDER_REFRESH_INTERVAL: Duration = Duration::from_secs(30);
+
+#[derive(Debug, Default)]
+pub struct Responders {
+    by_address: RwLock<HashMap<String, Arc<Responder>>>,
+    /// Account autoresponses by username, switched off ones included.
+    by_account: RwLock<HashMap<String, Arc<Autoresponse>>>,
+    /// `responder_suppress` patterns (lowercase), checked on top of the built-in list.
+    suppress: Vec<String>,
+    /// `autoresponse_window` in seconds; 0 means [`DEFAULT_AUTORESPONSE_WINDOW_SECS`].
+    autoresponse_window: i64,
+}
+
+impl Responders {
+    pub fn new(suppress: Vec<String>) -> Self {
+        Self {
+            by_address: RwLock::default(),
+            by_account: RwLock::default(),
+            suppress: suppress
+                .into_iter()
+                .map(|p| p.trim().to_ascii_lowercase())
+                .filter(|p| !p.is_empty())
+                .collect(),
+            autoresponse_window: 0,
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self {
+            autoresponse_window: config.autoresponse_window.map_or(0, |d| d.as_secs() as i64),
+            ..Self::new(config.responder_suppress.clone())
+        }
+    }
+
+    /// Seconds an autoresponse waits before answering the same sender again.
+    pub fn autoresponse_window(&self) -> i64 {
+        match self.autoresponse_window {
+            0 => DEFAULT_AUTORESPONSE_WINDOW_SECS,
+            secs => secs,
+        }
+    }
+
+    /// Extra sender patterns never answered (`*` matches any run of characters).
+    pub fn suppress_patterns(&self) -> &[String] {
+        &self.suppress
+    }
+
+    /// `true` when neither a responder nor an autoresponse is set.
+    pub fn is_empty(&self) -> bool {
+        self.by_address.read().map_or(true, |m| m.is_empty())
+            && self.by_account.read().map_or(true, |m| m.is_empty())
+    }
+
+    /// Responder for a (normalized) local address, if one is set.
+    pub fn get(&self, address: &str) -> Option<Arc<Responder>> {
+        self.by_address
+            .read()
+            .ok()?
+            .get(&address.to_ascii_lowercase())
+            .cloned()
+    }
+
+    /// Autoresponse of a (normalized) account that answers mail arriving at `now`.
+    pub fn autoresponse(&self, username: &str, now: i64) -> Option<Arc<Autoresponse>> {
+        self.by_account
+            .read()
+            .ok()?
+            .get(&username.to_ascii_lowercase())
+            .filter(|a| a.in_effect(now))
+            .cloned()
+    }
+
+    pub fn replace(&self, responders: Vec<Responder>) {
+        let map = responders
+            .into_iter()
+            .map(|r| (r.address.clone(), Arc::new(r)))
+            .collect();
+        if let Ok(mut guard) = self.by_address.write() {
+            *guard = map;
+        }
+    }
+
+    pub fn replace_autoresponses(&self, autoresponses: Vec<Autoresponse>) {
+        let map = autoresponses
+            .into_iter()
+            .map(|a| (a.username.clone(), Arc::new(a)))
+            .collect();
+        if let Ok(mut guard) = self.by_account.write() {
+            *guard = map;
+        }
+    }
+
+    pub async fn refresh(&self, pool: &DbPool) -> Result<()> {
+        self.replace(chatmail_db::list_responders(pool).await?);
+        self.replace_autoresponses(chatmail_db::list_autoresponses(pool).await?);
+        Ok(())
+    }
+}
+
+/// Re-read `responders` and `autoresponses` every [`RESPONDER_REFRESH_INTERVAL`] (picks up
+/// CLI edits) and prune reply rows past their interval or window.
+pub fn start_responder_refresh(responders: Arc<Responders>, pool: DbPool) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(RESPONDER_REFRESH_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            if let Err(e) = chatmail_db::prune_responder_replies(&pool).await {
+                tracing::warn!(error = %e, "responder reply prune failed");
+            }
+            let window = responders.autoresponse_window();
+            if let Err(e) = chatmail_db::prune_autoresponse_replies(&pool, window).await {
+                tracing::warn!(error = %e, "autoresponse reply prune failed");
+            }
+            if let Err(e) = responders.refresh(&pool).await {
+                tracing::warn!(error = %e, "responder list refresh failed");
+            }
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn refresh_picks_up_database_rows() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let responders = Responders::new(vec![" *@Lists.Example ".into(), " ".into()]);
+        assert_eq!(responders.suppress_patterns(), ["*@lists.example"]);
+        assert!(responders.is_empty());
+
+        chatmail_db::set_responder(&pool, "help@example.org", "hi", 60)
+            .await
+            .unwrap();
+        responders.refresh(&pool).await.unwrap();
+        assert_eq!(responders.get("HELP@example.org").unwrap().template, "hi");
+        assert!(responders.get("other@example.org").is_none());
+
+        chatmail_db::remove_responder(&pool, "help@example.org")
+            .await
+            .unwrap();
+        responders.refresh(&pool).await.unwrap();
+        assert!(responders.is_empty());
+    }
+
+    #[tokio::test]
+    async fn autoresponses_answer_only_inside_their_window() {
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let responders = Responders::new(vec![]);
+        assert_eq!(
+            responders.autoresponse_window(),
+            DEFAULT_AUTORESPONSE_WINDOW_SECS
+        );
+        chatmail_db::set_autoresponse(
+            &pool,
+            &Autoresponse {
+                username: "alice@example.org".into(),
+                active: true,
+                subject: String::new(),
+                body: "Away".into(),
+                starts_at: Some(1_000),
+                ends_at: Some(2_000),
+                updated_at: 0,
+            },
+        )
+        .await
+        .unwrap();
+        responders.refresh(&pool).await.unwrap();
+        assert!(!responders.is_empty());
+        assert!(responders
+            .autoresponse("Alice@example.org", 1_500)
+            .is_some());
+        assert!(responders.autoresponse("alice@example.org", 999).is_none());
+        assert!(responders
+            .autoresponse("alice@example.org", 2_000)
+            .is_none());
+        assert!(responders.get("alice@example.org").is_none());
+    }
+}
@@ -0,0 +1,221 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-account sending rate limit for submission (`submission` `max_messages_per_hour` and
+//! `max_rcpt_per_message`).
+//!
+//! SMTP submission asks [`SendingLimits::check_message`] at `MAIL FROM` and
+//! [`SendingLimits::check_rcpt`] at each `RCPT`; WebSMTP asks both before it delivers. A
+//! refused account gets [`SENDING_RATE_LIMITED`] (`450`) and a warning in the log.
+//!
+//! Accepted messages are [recorded](SendingLimits::record) in `sending_stats` only after the
+//! submission succeeded, so the counters survive restarts and a failed attempt costs nothing.
+//! A client that resubmits a message after losing the final `250` is recognised by its
+//! `Message-ID` and not charged twice. Windows are UTC clock hours, not sliding.
+
+use std::collections::{HashMap, VecDeque};
+use std::sync::atomic::{AtomicI64, Ordering};
+use std::sync::Mutex;
+
+use chatmail_config::AppConfig;
+use chatmail_db::policies::unix_now;
+use chatmail_db::{
+    messages_sent_in_hour, prune_sending_stats, record_sent_message, sending_hour, DbPool,
+    SENDING_STATS_KEEP_HOURS,
+};
+use chatmail_types::{ChatmailError, Result};
+use tracing::warn;
+
+/// SMTP reply for a submission over the account's limit.
+pub const SENDING_RATE_LIMITED: &str = "450 4.7.1 Sending rate limit exceeded, try again later";
+
+/// `Message-ID`s remembered per account for resubmission detection.
+const RECENT_MESSAGE_IDS: usize = 64;
+
+#[derive(Debug, Default)]
+pub struct SendingLimits {
+    max_messages_per_hour: Option<i64>,
+    max_rcpt_per_message: Option<usize>,
+    /// Account → `Message-ID`s counted lately, oldest first.
+    recent: Mutex<HashMap<String, VecDeque<String>>>,
+    /// Hour at which `sending_stats` was last pruned.
+    pruned_hour: AtomicI64,
+}
+
+impl SendingLimits {
+    pub fn new(max_messages_per_hour: Option<u32>, max_rcpt_per_message: Option<usize>) -> Self {
+        Self {
+            max_messages_per_hour: max_messages_per_hour.map(i64::from),
+            max_rcpt_per_message,
+            ..Self::default()
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.max_messages_per_hour, config.max_rcpt_per_message)
+    }
+
+    pub fn max_messages_per_hour(&self) -> Option<i64> {
+        self.max_messages_per_hour
+    }
+
+    pub fn max_rcpt_per_message(&self) -> Option<usize> {
+        self.max_rcpt_per_message
+    }
+
+    /// May `username` start another message this hour?
+    pub async fn check_message(&self, pool: &DbPool, username: &str) -> Result<()> {
+        let Some(limit) = self.max_messages_per_hour else {
+            return Ok(());
+        };
+        let sent = messages_sent_in_hour(pool, username, sending_hour(unix_now())).await?;
+        if sent < limit {
+            return Ok(());
+        }
+        warn!(
+            user = username,
+            sent, limit, "submission refused: max_messages_per_hour reached"
+        );
+        Err(ChatmailError::RateLimited(format!(
+            "{username} sent {sent} messages this hour (limit {limit})"
+        )))
+    }
+
+    /// May `username` add a recipient to a message that already has `accepted`?
+    pub fn check_rcpt(&self, username: &str, accepted: usize) -> Result<()> {
+        match self.max_rcpt_per_message {
+            Some(limit) if accepted >= limit => {
+                warn!(
+                    user = username,
+                    limit, "submission refused: max_rcpt_per_message reached"
+                );
+                Err(ChatmailError::RateLimited(format!(
+                    "more than {limit} recipients in one message"
+                )))
+            }
+            _ => Ok(()),
+        }
+    }
+
+    /// Count a submitted message unless its `Message-ID` was counted for `username` already.
+    pub async fn record(
+        &self,
+        pool: &DbPool,
+        username: &str,
+        data: &[u8],
+        recipients: usize,
+    ) -> Result<()> {
+        if let Some(id) = message_id(data) {
+            let mut recent = self.recent.lock().unwrap_or_else(|e| e.into_inner());
+            let ids = recent.entry(username.to_string()).or_default();
+            if ids.contains(&id) {
+                return Ok(());
+            }
+            if ids.len() == RECENT_MESSAGE_IDS {
+                ids.pop_front();
+            }
+            ids.push_back(id);
+        }
+        let hour = sending_hour(unix_now());
+        record_sent_message(pool, username, hour, recipients as i64).await?;
+        if self.pruned_hour.swap(hour, Ordering::Relaxed) != hour {
+            prune_sending_stats(pool, hour - SENDING_STATS_KEEP_HOURS).await?;
+        }
+        Ok(())
+    }
+
+    /// Forget the resubmission history of `username` (`rate-limit reset`).
+    pub fn forget(&self, username: &str) {
+        self.recent
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .remove(username);
+    }
+}
+
+fn message_id(raw: &[u8]) -> Option<String> {
+    let text = String::from_utf8_lossy(raw);
+    for line in text.lines() {
+        if line.is_empty() {
+            break;
+        }
+        if line.starts_with(char::is_whitespace) {
+            continue;
+        }
+        if let Some((k, v)) = line.split_once(':') {
+            if k.trim().eq_ignore_ascii_case("message-id") {
+                let v = v.trim();
+                return (!v.is_empty()).then(|| v.to_string());
+            }
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_db::init_memory_db;
+
+    const MSG: &[u8] = b"From: a@example.org\r\nMessage-ID: <1@example.org>\r\n\r\nbody";
+
+    #[tokio::test]
+    async fn hourly_limit_counts_accepted_messages_once() {
+        let pool = init_memory_db().await.unwrap();
+        let limits = SendingLimits::new(Some(2), None);
+        let user = "a@example.org";
+
+        limits.check_message(&pool, user).await.unwrap();
+        limits.record(&pool, user, MSG, 3).await.unwrap();
+        // Same message resubmitted after a lost 250: not counted again.
+        limits.record(&pool, user, MSG, 3).await.unwrap();
+        let hour = sending_hour(unix_now());
+        assert_eq!(messages_sent_in_hour(&pool, user, hour).await.unwrap(), 1);
+
+        limits.check_message(&pool, user).await.unwrap();
+        limits
+            .record(&pool, user, b"Subject: no id\r\n\r\nx", 1)
+            .await
+            .unwrap();
+        let err = limits.check_message(&pool, user).await.unwrap_err();
+        assert!(matches!(err, ChatmailError::RateLimited(_)), "{err:?}");
+        // Other accounts have their own budget.
+        limits.check_message(&pool, "b@example.org").await.unwrap();
+
+        // Counters live in the database: a fresh limiter (restart) sees them.
+        let restarted = SendingLimits::new(Some(2), None);
+        assert!(restarted.check_message(&pool, user).await.is_err());
+    }
+
+    #[tokio::test]
+    async fn unlimited_by_default_and_rcpt_cap() {
+        let pool = init_memory_db().await.unwrap();
+        let limits = SendingLimits::from_config(&AppConfig::default());
+        for _ in 0..5 {
+            limits
+                .record(&pool, "a@example.org", b"\r\n", 1)
+                .await
+                .unwrap();
+        }
+        limits.check_message(&pool, "a@example.org").await.unwrap();
+        limits.check_rcpt("a@example.org", 10_000).unwrap();
+
+        let capped = SendingLimits::new(None, Some(2));
+        capped.check_rcpt("a@example.org", 1).unwrap();
+        assert!(capped.check_rcpt("a@example.org", 2).is_err());
+    }
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Session revocation: when an account's password changes or the account goes away, its
+//! open IMAP sessions are told to log out.
+//!
+//! Each account has a generation counter. A session notes the generation at login and is
+//! stale once [`SessionRevocations::revoke`] has bumped it. Every revocation also ticks a
+//! shared [`watch`] channel so idling sessions wake up and check.
+
+use dashmap::DashMap;
+use tokio::sync::watch;
+
+#[derive(Debug)]
+pub struct SessionRevocations {
+    generations: DashMap<String, u64>,
+    changed: watch::Sender<u64>,
+}
+
+impl Default for SessionRevocations {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl SessionRevocations {
+    pub fn new() -> Self {
+        Self {
+            generations: DashMap::new(),
+            changed: watch::Sender::new(0),
+        }
+    }
+
+    /// Current generation of `username`; a session stores it at login.
+    pub fn generation(&self, username: &str) -> u64 {
+        self.generations.get(username).map_or(0, |g| *g)
+    }
+
+    /// End every session of `username` opened before now.
+    pub fn revoke(&self, username: &str) {
+        *self.generations.entry(username.to_string()).or_insert(0) += 1;
+        self.changed.send_modify(|n| *n = n.wrapping_add(1));
+    }
+
+    /// Whether a session of `username` that logged in at `generation` has been revoked.
+    pub fn is_revoked(&self, username: &str, generation: u64) -> bool {
+        self.generation(username) != generation
+    }
+
+    /// Ticks on every [`revoke`](Self::revoke), for any account.
+    pub fn subscribe(&self) -> watch::Receiver<u64> {
+        self.changed.subscribe()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn revoke_marks_older_sessions_and_wakes_subscribers() {
+        let sessions = SessionRevocations::new();
+        let mut rx = sessions.subscribe();
+        let a = sessions.generation("a@x.org");
+        let b = sessions.generation("b@x.org");
+        sessions.revoke("a@x.org");
+        rx.changed().await.unwrap();
+        assert!(sessions.is_revoked("a@x.org", a));
+        assert!(!sessions.is_revoked("b@x.org", b));
+        let relogin = sessions.generation("a@x.org");
+        assert!(!sessions.is_revoked("a@x.org", relogin));
+    }
+}
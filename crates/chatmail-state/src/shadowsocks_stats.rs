@@ -0,0 +1,136 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Traffic counters for the Shadowsocks relay (`/admin/ss-stats`, `chatmail ss-stats`).
+//!
+//! The relay opens a [`ShadowsocksSession`] for each accepted connection and reports the
+//! payload bytes it moves through it: `in` is what clients send, `out` is what they get
+//! back. Handshake and cipher overhead are not counted. The counters live on `AppState`,
+//! so they survive listener reloads and reset when the process restarts. Each session
+//! also feeds the `chatmail_ss_*` Prometheus series.
+
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Arc;
+
+use chatmail_metrics::{record_shadowsocks_bytes, ShadowsocksConnection};
+
+#[derive(Debug, Default)]
+pub struct ShadowsocksStats {
+    bytes_in: AtomicU64,
+    bytes_out: AtomicU64,
+    active: AtomicU64,
+    total: AtomicU64,
+}
+
+/// Point-in-time copy of [`ShadowsocksStats`].
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct ShadowsocksTraffic {
+    pub bytes_in: u64,
+    pub bytes_out: u64,
+    pub active_connections: u64,
+    pub total_connections: u64,
+}
+
+/// One relayed connection; counted as active until dropped.
+#[derive(Debug)]
+pub struct ShadowsocksSession {
+    stats: Arc<ShadowsocksStats>,
+    _metrics: ShadowsocksConnection,
+}
+
+impl ShadowsocksStats {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn open(self: &Arc<Self>) -> ShadowsocksSession {
+        self.total.fetch_add(1, Ordering::Relaxed);
+        self.active.fetch_add(1, Ordering::Relaxed);
+        ShadowsocksSession {
+            stats: Arc::clone(self),
+            _metrics: ShadowsocksConnection::open(),
+        }
+    }
+
+    pub fn snapshot(&self) -> ShadowsocksTraffic {
+        ShadowsocksTraffic {
+            bytes_in: self.bytes_in.load(Ordering::Relaxed),
+            bytes_out: self.bytes_out.load(Ordering::Relaxed),
+            active_connections: self.active.load(Ordering::Relaxed),
+            total_connections: self.total.load(Ordering::Relaxed),
+        }
+    }
+}
+
+impl ShadowsocksSession {
+    /// Payload read from the client.
+    pub fn record_in(&self, bytes: usize) {
+        if bytes > 0 {
+            self.stats
+                .bytes_in
+                .fetch_add(bytes as u64, Ordering::Relaxed);
+            record_shadowsocks_bytes("in", bytes as u64);
+        }
+    }
+
+    /// Payload written back to the client.
+    pub fn record_out(&self, bytes: usize) {
+        if bytes > 0 {
+            self.stats
+                .bytes_out
+                .fetch_add(bytes as u64, Ordering::Relaxed);
+            record_shadowsocks_bytes("out", bytes as u64);
+        }
+    }
+}
+
+impl Drop for ShadowsocksSession {
+    fn drop(&mut self) {
+        self.stats.active.fetch_sub(1, Ordering::Relaxed);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn sessions_count_bytes_and_connections() {
+        let stats = Arc::new(ShadowsocksStats::new());
+        let a = stats.open();
+        let b = stats.open();
+        a.record_in(10);
+        b.record_in(5);
+        b.record_out(7);
+        a.record_out(0);
+        assert_eq!(
+            stats.snapshot(),
+            ShadowsocksTraffic {
+                bytes_in: 15,
+                bytes_out: 7,
+                active_connections: 2,
+                total_connections: 2,
+            }
+        );
+        drop(a);
+        drop(b);
+        let after = stats.snapshot();
+        assert_eq!(after.active_connections, 0);
+        assert_eq!(after.total_connections, 2);
+        assert_eq!(after.bytes_in, 15);
+    }
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Aggregate view counters for contact-sharing pages (`sharing_analytics`).
+//!
+//! A slug page render calls [`ShareViews::record`], which only touches memory: a view
+//! counts once per visitor and slug per [`SHARE_VIEW_DEBOUNCE`], and the counts are
+//! written to `sharing.db` in batches by the state flusher ([`ShareViews::flush`]).
+//! Visitors are told apart by a keyed hash that never leaves the process; only the
+//! per-slug total and the UTC day of the latest view are stored.
+//!
+//! The flusher also uses this handle's `sharing.db` pool to drop expired links hourly
+//! ([`ShareViews::prune_expired_links`]).
+
+use std::collections::hash_map::RandomState;
+use std::collections::HashMap;
+use std::hash::BuildHasher;
+use std::net::IpAddr;
+use std::path::{Path, PathBuf};
+use std::sync::Mutex;
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use chatmail_types::Result;
+use dashmap::mapref::entry::Entry;
+use dashmap::DashMap;
+use sqlx::SqlitePool;
+use tokio::sync::OnceCell;
+
+/// Repeat renders of one slug by one visitor inside this window count once.
+pub const SHARE_VIEW_DEBOUNCE: Duration = Duration::from_secs(30 * 60);
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct PendingViews {
+    views: i64,
+    /// Unix day of the latest counted view.
+    day: i64,
+}
+
+pub struct ShareViews {
+    enabled: bool,
+    /// Contact sharing is on: expired links are pruned even with analytics off.
+    sharing: bool,
+    db_path: PathBuf,
+    pool: OnceCell<SqlitePool>,
+    window: i64,
+    /// Per-process random key: visitor hashes cannot be correlated across restarts.
+    hasher: RandomState,
+    /// Visitor+slug hash → unix time the view was last counted.
+    seen: DashMap<u64, i64>,
+    pending: Mutex<HashMap<String, PendingViews>>,
+}
+
+impl ShareViews {
+    pub fn new(enabled: bool, db_path: PathBuf, window: Duration) -> Self {
+        Self {
+            enabled,
+            sharing: false,
+            db_path,
+            pool: OnceCell::new(),
+            window: window.as_secs() as i64,
+            hasher: RandomState::new(),
+            seen: DashMap::new(),
+            pending: Mutex::new(HashMap::new()),
+        }
+    }
+
+    pub fn from_config(state_dir: &Path, config: &AppConfig) -> Self {
+        Self {
+            sharing: config.enable_contact_sharing,
+            ..Self::new(
+                config.sharing_analytics_enabled(),
+                config.sharing_db_path(state_dir),
+                SHARE_VIEW_DEBOUNCE,
+            )
+        }
+    }
+
+    /// False with `sharing_analytics off` (or contact sharing disabled): nothing is
+    /// counted and pages hide the counters.
+    pub fn enabled(&self) -> bool {
+        self.enabled
+    }
+
+    /// Count a render of `slug` by `client`; returns whether it counted.
+    pub fn record(&self, slug: &str, client: Option<IpAddr>) -> bool {
+        self.record_at(slug, client, chatmail_db::policies::unix_now())
+    }
+
+    fn record_at(&self, slug: &str, client: Option<IpAddr>, now: i64) -> bool {
+        if !self.enabled {
+            return false;
+        }
+        let visitor = self.hasher.hash_one((slug, client));
+        match self.seen.entry(visitor) {
+            Entry::Occupied(mut seen) => {
+                if now - *seen.get() < self.window {
+                    return false;
+                }
+                seen.insert(now);
+            }
+            Entry::Vacant(seen) => {
+                seen.insert(now);
+            }
+        }
+        let mut pending = self.pending.lock().unwrap_or_else(|e| e.into_inner());
+        let entry = pending
+            .entry(slug.to_string())
+            .or_insert(PendingViews { views: 0, day: 0 });
+        entry.views += 1;
+        entry.day = entry.day.max(now.div_euclid(86_400));
+        true
+    }
+
+    /// Views counted but not yet written to `sharing.db`.
+    pub fn pending_views(&self, slug: &str) -> i64 {
+        self.pending
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .get(slug)
+            .map_or(0, |p| p.views)
+    }
+
+    /// Write pending counts in one transaction and forget expired visitor hashes. On a
+    /// failed write the counts are kept for the next flush. Returns the slugs written.
+    pub async fn flush(&self) -> Result<usize> {
+        let now = chatmail_db::policies::unix_now();
+        self.seen.retain(|_, last| now - *last < self.window);
+        let batch: Vec<(String, PendingViews)> = {
+            let mut pending = self.pending.lock().unwrap_or_else(|e| e.into_inner());
+            pending.drain().collect()
+        };
+        if batch.is_empty() {
+            return Ok(0);
+        }
+        let rows: Vec<(String, i64, i64)> = batch
+            .iter()
+            .map(|(slug, p)| (slug.clone(), p.views, p.day))
+            .collect();
+        let written = match self.pool().await {
+            Ok(pool) => chatmail_db::add_sharing_views(pool, &rows).await,
+            Err(e) => Err(e),
+        };
+        if let Err(e) = written {
+            let mut pending = self.pending.lock().unwrap_or_else(|e| e.into_inner());
+            for (slug, p) in batch {
+                let entry = pending
+                    .entry(slug)
+                    .or_insert(PendingViews { views: 0, day: 0 });
+                entry.views += p.views;
+                entry.day = entry.day.max(p.day);
+            }
+            return Err(e);
+        }
+        Ok(rows.len())
+    }
+
+    /// Delete sharing links past their expiry; a no-op when contact sharing is off.
+    pub async fn prune_expired_links(&self) -> Result<u64> {
+        if !self.sharing {
+            return Ok(0);
+        }
+        chatmail_db::prune_expired_sharing_contacts(self.pool().await?).await
+    }
+
+    /// The `sharing.db` pool (`sharing_dsn`), opened on first use; the web endpoint reads
+    /// the same file, so admin edits through it show on the next page render.
+    pub async fn pool(&self) -> Result<&SqlitePool> {
+        self.pool
+            .get_or_try_init(|| async { chatmail_db::init_sharing_db(&self.db_path).await })
+            .await
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_db::{create_sharing_contact, get_sharing_contact, init_sharing_db};
+
+    fn ip(s: &str) -> Option<IpAddr> {
+        Some(s.parse().unwrap())
+    }
+
+    #[test]
+    fn repeat_views_inside_window_count_once() {
+        let views = ShareViews::new(true, PathBuf::from("unused.db"), SHARE_VIEW_DEBOUNCE);
+        let t = 1_700_000_000;
+        assert!(views.record_at("alice", ip("192.0.2.1"), t));
+        assert!(!views.record_at("alice", ip("192.0.2.1"), t + 60));
+        assert!(!views.record_at("alice", ip("192.0.2.1"), t + 1799));
+        // Another visitor, another slug, and the same visitor after the window all count.
+        assert!(views.record_at("alice", ip("192.0.2.2"), t + 60));
+        assert!(views.record_at("bob", ip("192.0.2.1"), t + 60));
+        assert!(views.record_at("alice", ip("192.0.2.1"), t + 1800));
+        assert_eq!(views.pending_views("alice"), 3);
+        assert_eq!(views.pending_views("bob"), 1);
+    }
+
+    #[tokio::test]
+    async fn off_switch_collects_nothing() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("sharing.db");
+        let config = AppConfig {
+            enable_contact_sharing: true,
+            sharing_analytics: Some(false),
+            ..AppConfig::default()
+        };
+        let views = ShareViews::from_config(dir.path(), &config);
+        assert!(!views.enabled());
+        assert!(!views.record("alice", ip("192.0.2.1")));
+        assert_eq!(views.pending_views("alice"), 0);
+        assert_eq!(views.flush().await.unwrap(), 0);
+        assert!(!path.exists(), "disabled analytics never opens sharing.db");
+    }
+
+    #[tokio::test]
+    async fn flush_writes_counts_and_clears_pending() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("sharing.db");
+        let pool = init_sharing_db(&path).await.unwrap();
+        create_sharing_contact(
+            &pool,
+            "alice",
+            "openpgp4fpr:0123456789ABCDEF0123456789ABCDEF01234567#a=a%40x.org&i=1&s=2",
+            "Alice",
+        )
+        .await
+        .unwrap();
+
+        let views = ShareViews::new(true, path, SHARE_VIEW_DEBOUNCE);
+        let t = 19_724 * 86_400 + 3_600;
+        views.record_at("alice", ip("192.0.2.1"), t);
+        views.record_at("alice", ip("192.0.2.2"), t);
+        assert_eq!(views.flush().await.unwrap(), 1);
+        assert_eq!(views.pending_views("alice"), 0);
+        assert_eq!(views.flush().await.unwrap(), 0);
+
+        let row = get_sharing_contact(&pool, "alice").await.unwrap().unwrap();
+        assert_eq!(row.views, 2);
+        assert_eq!(row.last_viewed, "2024-01-02");
+    }
+}
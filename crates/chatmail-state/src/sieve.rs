@@ -0,0 +1,146 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Per-account Sieve scripts (`sieve_store`), one `<address>.sieve` file each.
+//!
+//! The store only keeps source text and enforces `sieve_max_size`; scripts are checked by
+//! the caller before [`SieveStore::save`] and run by the delivery path. With `sieve_store`
+//! unset every lookup finds nothing, so delivery never touches the disk for it.
+
+use std::path::{Path, PathBuf};
+
+use chatmail_config::AppConfig;
+use chatmail_types::{ChatmailError, Result};
+
+const SCRIPT_SUFFIX: &str = ".sieve";
+
+#[derive(Debug, Clone, Default)]
+pub struct SieveStore {
+    /// `None` while `sieve_store` is unset.
+    dir: Option<PathBuf>,
+    max_size: u64,
+}
+
+impl SieveStore {
+    pub fn new(dir: Option<PathBuf>, max_size: u64) -> Self {
+        Self { dir, max_size }
+    }
+
+    /// `sieve_store` (relative paths under `state_dir`) and `sieve_max_size`.
+    pub fn from_config(state_dir: &Path, config: &AppConfig) -> Self {
+        Self::new(
+            config.sieve_store.as_ref().map(|dir| state_dir.join(dir)),
+            config.effective_sieve_max_size(),
+        )
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.dir.is_some()
+    }
+
+    /// Largest script [`Self::save`] accepts, in bytes.
+    pub fn max_size(&self) -> u64 {
+        self.max_size
+    }
+
+    /// Script file of `user`; `None` when the store is off or the name cannot be a file name.
+    fn path(&self, user: &str) -> Option<PathBuf> {
+        let dir = self.dir.as_ref()?;
+        let safe = !user.is_empty() && !user.starts_with('.') && !user.contains(['/', '\\', '\0']);
+        safe.then(|| dir.join(format!("{user}{SCRIPT_SUFFIX}")))
+    }
+
+    /// Source of `user`'s script, if one is stored.
+    pub async fn load(&self, user: &str) -> Result<Option<String>> {
+        let Some(path) = self.path(user) else {
+            return Ok(None);
+        };
+        match tokio::fs::read_to_string(&path).await {
+            Ok(source) => Ok(Some(source)),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(None),
+            Err(e) => Err(e.into()),
+        }
+    }
+
+    /// Whether `user` has a script; cheap enough to ask for every delivery.
+    pub async fn has_script(&self, user: &str) -> bool {
+        match self.path(user) {
+            Some(path) => tokio::fs::try_exists(&path).await.unwrap_or(false),
+            None => false,
+        }
+    }
+
+    /// Replace `user`'s script. The file is written next to the old one and renamed over
+    /// it, so delivery never reads half a script.
+    pub async fn save(&self, user: &str, source: &str) -> Result<()> {
+        let path = self
+            .path(user)
+            .ok_or_else(|| ChatmailError::config("sieve_store is not configured"))?;
+        if source.len() as u64 > self.max_size {
+            return Err(ChatmailError::config(format!(
+                "script is larger than sieve_max_size ({} bytes)",
+                self.max_size
+            )));
+        }
+        if let Some(dir) = path.parent() {
+            tokio::fs::create_dir_all(dir).await?;
+        }
+        let tmp = path.with_extension("sieve.tmp");
+        tokio::fs::write(&tmp, source).await?;
+        tokio::fs::rename(&tmp, &path).await?;
+        Ok(())
+    }
+
+    /// Delete `user`'s script; false when there was none.
+    pub async fn remove(&self, user: &str) -> Result<bool> {
+        let Some(path) = self.path(user) else {
+            return Ok(false);
+        };
+        match tokio::fs::remove_file(&path).await {
+            Ok(()) => Ok(true),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(false),
+            Err(e) => Err(e.into()),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn scripts_round_trip_within_the_size_cap() {
+        let dir = tempfile::tempdir().unwrap();
+        let off = SieveStore::default();
+        assert!(off.load("u@x.org").await.unwrap().is_none());
+        assert!(off.save("u@x.org", "keep;").await.is_err());
+
+        let store = SieveStore::new(Some(dir.path().join("sieve")), 16);
+        assert!(store.load("u@x.org").await.unwrap().is_none());
+        store.save("u@x.org", "keep;").await.unwrap();
+        assert!(store.has_script("u@x.org").await);
+        assert_eq!(
+            store.load("u@x.org").await.unwrap().as_deref(),
+            Some("keep;")
+        );
+        assert!(dir.path().join("sieve/u@x.org.sieve").is_file());
+        assert!(store.save("u@x.org", &"#".repeat(17)).await.is_err());
+        assert!(store.load("../u@x.org").await.unwrap().is_none());
+        assert!(store.remove("u@x.org").await.unwrap());
+        assert!(!store.remove("u@x.org").await.unwrap());
+        assert!(!store.has_script("u@x.org").await);
+    }
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Cached storage usage scan (`storage_orphan_warning`).
+//!
+//! [`chatmail_storage::storage_usage`] walks every maildir and the CAS blob directory, which is
+//! too slow for a status request on a large store. [`start_storage_usage_scan`] runs it in the
+//! background every [`STORAGE_SCAN_INTERVAL`]; `/admin/status` and OpenMetrics read the last
+//! result from [`StorageUsageMonitor`], whose [`orphan_hint`](StorageUsageMonitor::orphan_hint)
+//! turns non-empty once orphaned blobs pass the threshold.
+
+use std::sync::{Arc, RwLock};
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use chatmail_db::policies::unix_now;
+use chatmail_storage::{storage_usage, MailboxStore, StorageUsage};
+use tokio::task::JoinHandle;
+
+/// A full walk of `mail/` and `blobs/`: hourly is plenty for capacity planning.
+pub const STORAGE_SCAN_INTERVAL: Duration = Duration::from_secs(3600);
+
+/// Last completed scan.
+#[derive(Debug, Clone, PartialEq)]
+pub struct StorageUsageReading {
+    pub usage: StorageUsage,
+    /// Local unix time the scan finished.
+    pub checked_at: i64,
+    /// Wall time the walk took.
+    pub elapsed_ms: u64,
+}
+
+#[derive(Debug)]
+pub struct StorageUsageMonitor {
+    orphan_warning: u64,
+    last: RwLock<Option<StorageUsageReading>>,
+}
+
+impl Default for StorageUsageMonitor {
+    fn default() -> Self {
+        Self::new(chatmail_config::DEFAULT_STORAGE_ORPHAN_WARNING)
+    }
+}
+
+impl StorageUsageMonitor {
+    pub fn new(orphan_warning: u64) -> Self {
+        Self {
+            orphan_warning,
+            last: RwLock::new(None),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.effective_storage_orphan_warning())
+    }
+
+    /// `storage_orphan_warning` in bytes.
+    pub fn orphan_warning(&self) -> u64 {
+        self.orphan_warning
+    }
+
+    /// Record a finished scan; logs once per scan while orphans exceed the threshold.
+    pub fn observe(&self, usage: StorageUsage, elapsed: Duration, now: i64) {
+        chatmail_metrics::set_storage_usage(
+            usage.logical_bytes,
+            usage.physical_bytes,
+            usage.orphan_bytes,
+        );
+        let reading = StorageUsageReading {
+            usage,
+            checked_at: now,
+            elapsed_ms: elapsed.as_millis() as u64,
+        };
+        if let Some(hint) = orphan_hint(&reading.usage, self.orphan_warning) {
+            tracing::warn!(orphan_blobs = reading.usage.orphan_blobs, "storage: {hint}");
+        }
+        *self.last.write().unwrap_or_else(|e| e.into_inner()) = Some(reading);
+    }
+
+    pub fn reading(&self) -> Option<StorageUsageReading> {
+        self.last.read().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+
+    /// Operator-facing note once orphaned blob bytes exceed `storage_orphan_warning`.
+    pub fn orphan_hint(&self) -> Option<String> {
+        orphan_hint(&self.reading()?.usage, self.orphan_warning)
+    }
+}
+
+fn orphan_hint(usage: &StorageUsage, threshold: u64) -> Option<String> {
+    (usage.orphan_blobs > 0 && usage.orphan_bytes >= threshold).then(|| {
+        format!(
+            "{} orphaned blobs hold {} bytes; run `madmail imap-acct gc` to reclaim them",
+            usage.orphan_blobs, usage.orphan_bytes
+        )
+    })
+}
+
+/// Scan now and then every [`STORAGE_SCAN_INTERVAL`].
+pub fn start_storage_usage_scan(
+    monitor: Arc<StorageUsageMonitor>,
+    store: Arc<MailboxStore>,
+) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(STORAGE_SCAN_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            let started = std::time::Instant::now();
+            match storage_usage(&store).await {
+                Ok(usage) => monitor.observe(usage, started.elapsed(), unix_now()),
+                Err(e) => tracing::warn!(error = %e, "storage usage scan failed"),
+            }
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn usage(orphan_blobs: u64, orphan_bytes: u64) -> StorageUsage {
+        StorageUsage {
+            orphan_blobs,
+            orphan_bytes,
+            ..StorageUsage::default()
+        }
+    }
+
+    #[test]
+    fn hint_only_above_threshold() {
+        let monitor = StorageUsageMonitor::new(1000);
+        assert_eq!(monitor.orphan_hint(), None);
+
+        monitor.observe(usage(3, 999), Duration::from_millis(5), 1_800_000_000);
+        assert_eq!(monitor.orphan_hint(), None);
+        let reading = monitor.reading().unwrap();
+        assert_eq!(reading.checked_at, 1_800_000_000);
+        assert_eq!(reading.elapsed_ms, 5);
+
+        monitor.observe(usage(4, 1000), Duration::ZERO, 1_800_003_600);
+        assert_eq!(
+            monitor.orphan_hint().as_deref(),
+            Some("4 orphaned blobs hold 1000 bytes; run `madmail imap-acct gc` to reclaim them")
+        );
+    }
+
+    #[test]
+    fn zero_threshold_warns_on_any_orphan() {
+        let monitor = StorageUsageMonitor::new(0);
+        monitor.observe(usage(0, 0), Duration::ZERO, 0);
+        assert_eq!(monitor.orphan_hint(), None);
+        monitor.observe(usage(1, 10), Duration::ZERO, 0);
+        assert!(monitor.orphan_hint().is_some());
+    }
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Subaddressing (`user+tag@domain`, `subaddress_delimiter`).
+//!
+//! Delivery resolves a local envelope recipient (already casefolded by
+//! `normalize_username`) to the account that stores it: an account with the exact address
+//! wins, otherwise the delimiter and everything after it are stripped from the local part.
+//! Every later check — `local_recipient_allowed`, quota, the catch-all — runs against the
+//! stripped account, so a tag never changes what an address may receive.
+//!
+//! Login names are never rewritten. Instead JIT registration refuses new local parts that
+//! contain the delimiter, so nobody can claim `user+tag` ahead of `user` (or of a reserved
+//! or suspended name).
+
+use chatmail_config::AppConfig;
+
+use crate::auth::AuthCache;
+
+/// Delimiter used when `subaddress_delimiter` is not configured.
+pub const DEFAULT_SUBADDRESS_DELIMITER: &str = "+";
+
+/// A local envelope recipient resolved to its account.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct LocalRcpt {
+    /// Account the message is stored for.
+    pub account: String,
+    /// Envelope address as written; equals `account` when no tag was stripped.
+    pub original: String,
+    /// Subaddress after the delimiter, when one was stripped.
+    pub tag: Option<String>,
+}
+
+impl LocalRcpt {
+    fn plain(rcpt: &str) -> Self {
+        Self {
+            account: rcpt.to_string(),
+            original: rcpt.to_string(),
+            tag: None,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct Subaddressing {
+    /// `None` while subaddressing is off.
+    delimiter: Option<String>,
+}
+
+impl Subaddressing {
+    /// An empty `delimiter` turns subaddressing off.
+    pub fn new(delimiter: &str) -> Self {
+        Self {
+            delimiter: Some(delimiter.trim().to_string()).filter(|d| !d.is_empty()),
+        }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(
+            config
+                .subaddress_delimiter
+                .as_deref()
+                .unwrap_or(DEFAULT_SUBADDRESS_DELIMITER),
+        )
+    }
+
+    pub fn delimiter(&self) -> Option<&str> {
+        self.delimiter.as_deref()
+    }
+
+    /// `(account address, tag)` when the local part of `addr` carries a subaddress.
+    pub fn split<'a>(&self, addr: &'a str) -> Option<(String, &'a str)> {
+        let delimiter = self.delimiter.as_deref()?;
+        let (local, domain) = addr.rsplit_once('@')?;
+        let (base, tag) = local.split_once(delimiter)?;
+        if base.is_empty() {
+            return None;
+        }
+        Some((format!("{base}@{domain}"), tag))
+    }
+
+    /// Account for the normalized local recipient `rcpt`: the address itself when it has
+    /// an account (or carries no tag), else the address with its subaddress stripped.
+    pub fn resolve(&self, rcpt: &str, auth: &AuthCache) -> LocalRcpt {
+        if auth.user_exists(rcpt) {
+            return LocalRcpt::plain(rcpt);
+        }
+        match self.split(rcpt) {
+            Some((account, tag)) => LocalRcpt {
+                account,
+                original: rcpt.to_string(),
+                tag: Some(tag.to_string()),
+            },
+            None => LocalRcpt::plain(rcpt),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn strips_the_tag_unless_the_exact_address_has_an_account() {
+        let auth = AuthCache::new();
+        auth.insert("me@example.org", "h");
+        auth.insert("old+style@example.org", "h");
+        let sub = Subaddressing::new("+");
+
+        let r = sub.resolve("me+lists@example.org", &auth);
+        assert_eq!(r.account, "me@example.org");
+        assert_eq!(r.original, "me+lists@example.org");
+        assert_eq!(r.tag.as_deref(), Some("lists"));
+
+        let r = sub.resolve("old+style@example.org", &auth);
+        assert_eq!(r.account, "old+style@example.org");
+        assert!(r.tag.is_none(), "exact account wins");
+
+        assert_eq!(
+            sub.resolve("me@example.org", &auth),
+            LocalRcpt::plain("me@example.org")
+        );
+        assert!(sub.split("+tag@example.org").is_none(), "empty base");
+        assert_eq!(
+            sub.split("a+b+c@example.org"),
+            Some(("a@example.org".to_string(), "b+c"))
+        );
+    }
+
+    #[test]
+    fn empty_delimiter_disables() {
+        let auth = AuthCache::new();
+        let sub = Subaddressing::new("");
+        assert!(sub.delimiter().is_none());
+        let r = sub.resolve("me+lists@example.org", &auth);
+        assert_eq!(r.account, "me+lists@example.org");
+        assert_eq!(
+            Subaddressing::from_config(&AppConfig::default()).delimiter(),
+            Some("+")
+        );
+        let dash = Subaddressing::new("-");
+        assert_eq!(
+            dash.resolve("me-lists@example.org", &auth).account,
+            "me@example.org"
+        );
+    }
+}
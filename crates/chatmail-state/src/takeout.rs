@@ -0,0 +1,467 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Two-phase account takeout: an authenticated request starts a background export, and the
+//! finished archive is fetched once through an unguessable link.
+//!
+//! Archives are written to `{state_dir}/takeout/{token}.zip`. The token (128 random bits,
+//! hex) is the only credential for the download, so lookups compare it in constant time.
+//! A file is deleted after one complete download or once [`TakeoutSpool::sweep`] finds it
+//! past `takeout_ttl`. Jobs live in memory only: anything left in the spool directory at
+//! startup is an orphan and [`TakeoutSpool::clean_orphans`] removes it.
+//!
+//! While an archive is being written its estimated size is reserved on the [`DiskGuard`],
+//! so pending exports count toward the disk tiers before the bytes hit the filesystem.
+//!
+//! Running jobs report `job_progress` on the admin event stream every
+//! [`JOB_PROGRESS_INTERVAL`] and once more when they finish.
+
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use chatmail_db::policies::unix_now;
+use chatmail_storage::{takeout_size, write_takeout_archive, MailboxStore, TakeoutProgress};
+use chatmail_types::{ChatmailError, Result};
+use subtle::ConstantTimeEq;
+use tokio::task::JoinHandle;
+
+use crate::admin_events::{AdminEventBus, AdminEventKind};
+use crate::disk::{DiskGuard, DiskTier};
+
+/// Spool directory under `state_dir`.
+pub const TAKEOUT_DIR: &str = "takeout";
+/// Default `takeout_ttl`.
+pub const DEFAULT_TAKEOUT_TTL: Duration = Duration::from_secs(24 * 3600);
+const SWEEP_INTERVAL: Duration = Duration::from_secs(60);
+/// How often a running export publishes `job_progress`.
+pub const JOB_PROGRESS_INTERVAL: Duration = Duration::from_secs(2);
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum TakeoutState {
+    Running,
+    Ready {
+        bytes: u64,
+    },
+    /// Claimed by a download in progress; back to `Ready` if the transfer breaks off.
+    Downloading {
+        bytes: u64,
+    },
+    Failed(String),
+}
+
+impl TakeoutState {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Running => "running",
+            Self::Ready { .. } => "ready",
+            Self::Downloading { .. } => "downloading",
+            Self::Failed(_) => "failed",
+        }
+    }
+}
+
+#[derive(Debug)]
+pub struct TakeoutJob {
+    pub token: String,
+    pub user: String,
+    pub created_at: i64,
+    pub expires_at: i64,
+    pub progress: TakeoutProgress,
+    state: Mutex<TakeoutState>,
+}
+
+impl TakeoutJob {
+    pub fn state(&self) -> TakeoutState {
+        self.state.lock().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+
+    fn set_state(&self, state: TakeoutState) {
+        *self.state.lock().unwrap_or_else(|e| e.into_inner()) = state;
+    }
+}
+
+#[derive(Debug)]
+pub struct TakeoutSpool {
+    dir: PathBuf,
+    ttl: Duration,
+    jobs: Mutex<HashMap<String, Arc<TakeoutJob>>>,
+    events: Option<Arc<AdminEventBus>>,
+}
+
+impl TakeoutSpool {
+    pub fn new(state_dir: &Path, ttl: Duration) -> Self {
+        Self {
+            dir: state_dir.join(TAKEOUT_DIR),
+            ttl,
+            jobs: Mutex::new(HashMap::new()),
+            events: None,
+        }
+    }
+
+    /// Publish `job_progress` for every export on `events`.
+    pub fn with_events(mut self, events: Arc<AdminEventBus>) -> Self {
+        self.events = Some(events);
+        self
+    }
+
+    pub fn from_config(state_dir: &Path, config: &AppConfig) -> Self {
+        Self::new(state_dir, config.takeout_ttl.unwrap_or(DEFAULT_TAKEOUT_TTL))
+    }
+
+    pub fn ttl(&self) -> Duration {
+        self.ttl
+    }
+
+    fn path_for(&self, token: &str) -> PathBuf {
+        self.dir.join(format!("{token}.zip"))
+    }
+
+    fn jobs(&self) -> std::sync::MutexGuard<'_, HashMap<String, Arc<TakeoutJob>>> {
+        self.jobs.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// Delete spool files that belong to no live job (all of them at startup).
+    pub fn clean_orphans(&self) -> Result<usize> {
+        let rd = match std::fs::read_dir(&self.dir) {
+            Ok(r) => r,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(0),
+            Err(e) => return Err(e.into()),
+        };
+        let live: Vec<PathBuf> = self.jobs().keys().map(|t| self.path_for(t)).collect();
+        let mut removed = 0;
+        for ent in rd {
+            let path = ent?.path();
+            if path.is_file() && !live.contains(&path) {
+                std::fs::remove_file(&path)?;
+                removed += 1;
+            }
+        }
+        Ok(removed)
+    }
+
+    /// Start exporting every mailbox of `user`. A job still running for the same user is
+    /// returned instead of starting a second one.
+    pub async fn start(
+        self: &Arc<Self>,
+        store: Arc<MailboxStore>,
+        disk: Arc<DiskGuard>,
+        user: &str,
+    ) -> Result<Arc<TakeoutJob>> {
+        if disk.tier() != DiskTier::Normal {
+            return Err(ChatmailError::ServerFull(
+                "disk usage above soft limit".into(),
+            ));
+        }
+        if let Some(job) = self
+            .jobs()
+            .values()
+            .find(|j| j.user == user && j.state() == TakeoutState::Running)
+        {
+            return Ok(Arc::clone(job));
+        }
+        let (_, estimate) = takeout_size(&store, user).await?;
+        tokio::fs::create_dir_all(&self.dir).await?;
+
+        let now = unix_now();
+        let job = Arc::new(TakeoutJob {
+            token: format!("{:032x}", rand::random::<u128>()),
+            user: user.to_string(),
+            created_at: now,
+            expires_at: now + self.ttl.as_secs() as i64,
+            progress: TakeoutProgress::default(),
+            state: Mutex::new(TakeoutState::Running),
+        });
+        self.jobs().insert(job.token.clone(), Arc::clone(&job));
+        disk.reserve(estimate);
+
+        let spool = Arc::clone(self);
+        let running = Arc::clone(&job);
+        tokio::spawn(async move {
+            let path = spool.path_for(&running.token);
+            let export = write_takeout_archive(&store, &running.user, &path, &running.progress);
+            tokio::pin!(export);
+            let mut progress = tokio::time::interval(JOB_PROGRESS_INTERVAL);
+            let result = loop {
+                tokio::select! {
+                    result = &mut export => break result,
+                    _ = progress.tick() => spool.publish_progress(&running),
+                }
+            };
+            disk.release(estimate);
+            match result {
+                Ok(bytes) => running.set_state(TakeoutState::Ready { bytes }),
+                Err(e) => {
+                    tracing::warn!(user = %running.user, error = %e, "takeout export failed");
+                    let _ = tokio::fs::remove_file(&path).await;
+                    running.set_state(TakeoutState::Failed(e.to_string()));
+                }
+            }
+            spool.publish_progress(&running);
+            // Swept while still running: nobody can claim the file any more.
+            if !spool.jobs().contains_key(&running.token) {
+                let _ = tokio::fs::remove_file(&path).await;
+            }
+        });
+        Ok(job)
+    }
+
+    fn publish_progress(&self, job: &TakeoutJob) {
+        let Some(events) = &self.events else {
+            return;
+        };
+        let (done, total, bytes) = job.progress.snapshot();
+        let mut data = serde_json::json!({
+            "job": "takeout",
+            "user": job.user,
+            "state": job.state().as_str(),
+            "messages_done": done,
+            "messages_total": total,
+            "bytes_written": bytes,
+        });
+        if let TakeoutState::Failed(e) = job.state() {
+            data["error"] = e.into();
+        }
+        events.publish(AdminEventKind::JobProgress, data);
+    }
+
+    /// Jobs of `user`, oldest first.
+    pub fn jobs_for(&self, user: &str) -> Vec<Arc<TakeoutJob>> {
+        let mut jobs: Vec<_> = self
+            .jobs()
+            .values()
+            .filter(|j| j.user == user)
+            .cloned()
+            .collect();
+        jobs.sort_by_key(|j| j.created_at);
+        jobs
+    }
+
+    /// Take the finished archive behind `token` for download. `None` for unknown, expired,
+    /// unfinished or already claimed tokens.
+    pub fn claim(self: &Arc<Self>, token: &str) -> Option<TakeoutDownload> {
+        let now = unix_now();
+        let jobs = self.jobs();
+        // Visit every job so the time taken does not depend on which token matched.
+        let mut found = None;
+        for (t, job) in jobs.iter() {
+            if bool::from(t.as_bytes().ct_eq(token.as_bytes())) {
+                found = Some(Arc::clone(job));
+            }
+        }
+        let job = found?;
+        if job.expires_at <= now {
+            return None;
+        }
+        let mut state = job.state.lock().unwrap_or_else(|e| e.into_inner());
+        let TakeoutState::Ready { bytes } = *state else {
+            return None;
+        };
+        *state = TakeoutState::Downloading { bytes };
+        drop(state);
+        Some(TakeoutDownload {
+            spool: Arc::clone(self),
+            path: self.path_for(&job.token),
+            job,
+            bytes,
+            completed: false,
+        })
+    }
+
+    /// Forget jobs past their TTL and delete their archives. Downloads in progress finish
+    /// first. Returns the number of jobs removed.
+    pub fn sweep(&self, now: i64) -> usize {
+        let expired: Vec<Arc<TakeoutJob>> = {
+            let mut jobs = self.jobs();
+            let tokens: Vec<String> = jobs
+                .values()
+                .filter(|j| {
+                    j.expires_at <= now && !matches!(j.state(), TakeoutState::Downloading { .. })
+                })
+                .map(|j| j.token.clone())
+                .collect();
+            tokens.iter().filter_map(|t| jobs.remove(t)).collect()
+        };
+        for job in &expired {
+            // A running job deletes its own file when it finishes.
+            if job.state() != TakeoutState::Running {
+                let _ = std::fs::remove_file(self.path_for(&job.token));
+            }
+        }
+        expired.len()
+    }
+
+    fn finish_download(&self, job: &TakeoutJob, bytes: u64, completed: bool) {
+        if completed {
+            self.jobs().remove(&job.token);
+            if let Err(e) = std::fs::remove_file(self.path_for(&job.token)) {
+                tracing::warn!(error = %e, "takeout: failed to delete downloaded archive");
+            }
+        } else {
+            job.set_state(TakeoutState::Ready { bytes });
+        }
+    }
+}
+
+/// A claimed archive. Call [`complete`](Self::complete) once every byte was sent; dropping
+/// it without that makes the link usable again.
+#[derive(Debug)]
+pub struct TakeoutDownload {
+    spool: Arc<TakeoutSpool>,
+    job: Arc<TakeoutJob>,
+    pub path: PathBuf,
+    pub bytes: u64,
+    completed: bool,
+}
+
+impl TakeoutDownload {
+    pub fn user(&self) -> &str {
+        &self.job.user
+    }
+
+    /// The download succeeded: delete the archive and retire the token.
+    pub fn complete(mut self) {
+        self.completed = true;
+    }
+}
+
+impl Drop for TakeoutDownload {
+    fn drop(&mut self) {
+        self.spool
+            .finish_download(&self.job, self.bytes, self.completed);
+    }
+}
+
+/// Sweep expired takeout archives every [`SWEEP_INTERVAL`].
+pub fn start_takeout_sweeper(spool: Arc<TakeoutSpool>) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(SWEEP_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            let n = spool.sweep(unix_now());
+            if n > 0 {
+                tracing::debug!(expired = n, "takeout: removed expired archives");
+            }
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use chatmail_storage::write_blob;
+
+    use super::*;
+
+    async fn setup() -> (
+        tempfile::TempDir,
+        Arc<TakeoutSpool>,
+        Arc<MailboxStore>,
+        Arc<DiskGuard>,
+    ) {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = Arc::new(MailboxStore::new(tmp.path()));
+        store.init_user_dir("alice@test").await.unwrap();
+        write_blob(&store, "alice@test", "m1", b"Subject: hi\r\n\r\nbody")
+            .await
+            .unwrap();
+        let spool = Arc::new(TakeoutSpool::new(tmp.path(), Duration::from_secs(3600)));
+        (tmp, spool, store, Arc::new(DiskGuard::default()))
+    }
+
+    async fn wait_ready(job: &TakeoutJob) -> u64 {
+        for _ in 0..200 {
+            match job.state() {
+                TakeoutState::Ready { bytes } => return bytes,
+                TakeoutState::Failed(e) => panic!("export failed: {e}"),
+                _ => tokio::time::sleep(Duration::from_millis(10)).await,
+            }
+        }
+        panic!("export did not finish");
+    }
+
+    #[tokio::test]
+    async fn two_phase_download_is_single_use() {
+        let (_tmp, spool, store, disk) = setup().await;
+        let job = spool
+            .start(Arc::clone(&store), Arc::clone(&disk), "alice@test")
+            .await
+            .unwrap();
+        assert_eq!(job.token.len(), 32);
+        let bytes = wait_ready(&job).await;
+        assert_eq!(disk.reserved_bytes(), 0);
+        assert_eq!(job.progress.snapshot().0, 1);
+
+        let dl = spool.claim(&job.token).unwrap();
+        assert_eq!(dl.bytes, bytes);
+        assert_eq!(dl.user(), "alice@test");
+        assert!(dl.path.is_file());
+        assert!(spool.claim(&job.token).is_none(), "claimed twice");
+
+        // A broken transfer releases the claim.
+        drop(dl);
+        let dl = spool.claim(&job.token).unwrap();
+        let path = dl.path.clone();
+        dl.complete();
+        assert!(!path.exists());
+        assert!(spool.claim(&job.token).is_none());
+        assert!(spool.jobs_for("alice@test").is_empty());
+
+        let wrong = format!("{:032x}", u128::from_str_radix(&job.token, 16).unwrap() ^ 1);
+        assert!(spool.claim(&wrong).is_none());
+    }
+
+    #[tokio::test]
+    async fn ttl_sweep_and_orphan_cleanup() {
+        let (tmp, spool, store, disk) = setup().await;
+        let job = spool.start(store, disk, "alice@test").await.unwrap();
+        wait_ready(&job).await;
+        let path = spool.path_for(&job.token);
+        assert!(path.is_file());
+
+        assert_eq!(spool.sweep(job.expires_at - 1), 0);
+        assert_eq!(spool.sweep(job.expires_at), 1);
+        assert!(!path.exists());
+        assert!(spool.claim(&job.token).is_none());
+
+        let orphan = tmp.path().join(TAKEOUT_DIR).join("left-over.zip");
+        std::fs::write(&orphan, b"x").unwrap();
+        assert_eq!(spool.clean_orphans().unwrap(), 1);
+        assert!(!orphan.exists());
+    }
+
+    #[tokio::test]
+    async fn refused_above_soft_disk_limit() {
+        let (_tmp, spool, store, _) = setup().await;
+        let disk = Arc::new(DiskGuard::new(85.0, 95.0, 1024));
+        disk.reserve(u64::MAX / 2);
+        struct Half;
+        impl crate::disk::DiskProbe for Half {
+            fn usage(&self) -> Option<crate::disk::DiskUsage> {
+                Some(crate::disk::DiskUsage {
+                    total_bytes: 1_000,
+                    available_bytes: 500,
+                })
+            }
+        }
+        disk.refresh(&Half);
+        let err = spool.start(store, disk, "alice@test").await.unwrap_err();
+        assert!(matches!(err, ChatmailError::ServerFull(_)), "{err:?}");
+    }
+}
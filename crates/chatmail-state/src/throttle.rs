@@ -0,0 +1,464 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Per-destination-domain delivery caps for the outbound queue.
+//!
+//! Every remote delivery attempt — new messages and retries alike — takes a
+//! [`ThrottlePermit`] for its recipient domain first. A domain is capped at
+//! `max_parallel_per_domain` concurrent attempts and `max_messages_per_minute_per_domain`
+//! attempts started in any 60 second window (`domain_limit` overrides both per domain).
+//! Attempts over the cap are told how long to wait and stay queued.
+//!
+//! Deferrals that read like rate limiting ([`is_rate_limit_reply`]) halve the domain's
+//! parallel cap, and with it the per-minute budget, down to one. The reduction recovers
+//! by one slot per [`ADAPTIVE_RECOVERY_STEP`] without a new rate-limit deferral.
+
+use std::collections::{HashMap, VecDeque};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+
+use chatmail_config::{DomainLimit, QueueSettings};
+
+/// Window of the per-minute cap.
+pub const RATE_WINDOW: Duration = Duration::from_secs(60);
+
+/// Quiet time after which an adaptive reduction gives back one parallel slot.
+pub const ADAPTIVE_RECOVERY_STEP: Duration = Duration::from_secs(60);
+
+/// Rate-limit deferrals closer together than this count as one cut, so the attempts
+/// already in flight when a provider starts pushing back do not collapse the cap at once.
+pub const ADAPTIVE_HOLD: Duration = Duration::from_secs(10);
+
+/// How long an attempt waits when only the parallel cap is exhausted.
+const PARALLEL_WAIT: Duration = Duration::from_secs(1);
+
+/// Lower-cased reply fragments that mark a deferral as rate limiting.
+const RATE_LIMIT_PHRASES: &[&str] = &[
+    "4.7.0",
+    "4.7.28",
+    "try again later",
+    "rate limit",
+    "rate-limit",
+    "too many",
+    "throttl",
+];
+
+/// True when a temporary failure reason reads like the remote rate-limiting us.
+pub fn is_rate_limit_reply(reason: &str) -> bool {
+    let reason = reason.to_ascii_lowercase();
+    RATE_LIMIT_PHRASES.iter().any(|p| reason.contains(p))
+}
+
+/// Configured caps for one domain.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct DomainCaps {
+    pub max_parallel: u32,
+    pub max_per_minute: u32,
+}
+
+#[derive(Debug)]
+struct Reduction {
+    /// Parallel cap as of `since`.
+    parallel: u32,
+    /// Start of the current recovery period.
+    since: Instant,
+    /// Last time the cap was cut.
+    cut_at: Instant,
+}
+
+#[derive(Debug, Default)]
+struct DomainState {
+    in_flight: u32,
+    started: VecDeque<Instant>,
+    reduction: Option<Reduction>,
+    rate_limited: u64,
+    waits: u64,
+    delivered: u64,
+}
+
+impl DomainState {
+    /// Parallel cap at `now`, applying recovery and dropping a fully recovered reduction.
+    fn parallel_cap(&mut self, caps: DomainCaps, now: Instant) -> u32 {
+        let Some(r) = &self.reduction else {
+            return caps.max_parallel;
+        };
+        let steps =
+            now.saturating_duration_since(r.since).as_secs() / ADAPTIVE_RECOVERY_STEP.as_secs();
+        let cap = u64::from(r.parallel).saturating_add(steps);
+        if cap >= u64::from(caps.max_parallel) {
+            self.reduction = None;
+            return caps.max_parallel;
+        }
+        cap as u32
+    }
+
+    fn rate_cap(caps: DomainCaps, parallel: u32) -> u32 {
+        if parallel >= caps.max_parallel {
+            return caps.max_per_minute;
+        }
+        let scaled =
+            u64::from(caps.max_per_minute) * u64::from(parallel) / u64::from(caps.max_parallel);
+        (scaled as u32).max(1)
+    }
+
+    fn prune(&mut self, now: Instant) {
+        while self
+            .started
+            .front()
+            .is_some_and(|t| now.saturating_duration_since(*t) >= RATE_WINDOW)
+        {
+            self.started.pop_front();
+        }
+    }
+}
+
+/// One domain's throttle state for `delivery-stats`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DomainThrottleRow {
+    pub domain: String,
+    pub in_flight: u32,
+    pub started_last_minute: u32,
+    pub max_parallel: u32,
+    pub max_per_minute: u32,
+    /// Caps in force right now (lower than configured while reduced).
+    pub effective_parallel: u32,
+    pub effective_per_minute: u32,
+    /// True while an adaptive reduction is in force.
+    pub reduced: bool,
+    /// Rate-limit deferrals seen since start.
+    pub rate_limited: u64,
+    /// Attempts held back by a cap since start.
+    pub waits: u64,
+    pub delivered: u64,
+}
+
+/// Shared per-domain caps for the outbound queue (see module docs).
+#[derive(Debug)]
+pub struct DeliveryThrottle {
+    defaults: DomainCaps,
+    overrides: Vec<DomainLimit>,
+    domains: Mutex<HashMap<String, DomainState>>,
+}
+
+impl DeliveryThrottle {
+    pub fn new(defaults: DomainCaps, overrides: Vec<DomainLimit>) -> Self {
+        Self {
+            defaults: DomainCaps {
+                max_parallel: defaults.max_parallel.max(1),
+                max_per_minute: defaults.max_per_minute.max(1),
+            },
+            overrides,
+            domains: Mutex::new(HashMap::new()),
+        }
+    }
+
+    pub fn from_settings(settings: &QueueSettings) -> Self {
+        Self::new(
+            DomainCaps {
+                max_parallel: settings.max_parallel_per_domain,
+                max_per_minute: settings.max_messages_per_minute_per_domain,
+            },
+            settings.domain_limits.clone(),
+        )
+    }
+
+    /// `max_parallel_per_domain` / `max_messages_per_minute_per_domain`.
+    pub fn defaults(&self) -> DomainCaps {
+        self.defaults
+    }
+
+    /// Configured caps for `domain`: the first matching `domain_limit`, else the defaults.
+    pub fn caps_for(&self, domain: &str) -> DomainCaps {
+        self.overrides
+            .iter()
+            .find(|l| l.matches(domain))
+            .map(|l| DomainCaps {
+                max_parallel: l.max_parallel.max(1),
+                max_per_minute: l.max_per_minute.max(1),
+            })
+            .unwrap_or(self.defaults)
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, HashMap<String, DomainState>> {
+        self.domains.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// Start an attempt to `domain`, or return how long to keep the message queued.
+    pub fn acquire(
+        self: &Arc<Self>,
+        domain: &str,
+        now: Instant,
+    ) -> Result<ThrottlePermit, Duration> {
+        let key = domain.to_ascii_lowercase();
+        let caps = self.caps_for(&key);
+        let mut domains = self.lock();
+        let st = domains.entry(key.clone()).or_default();
+        st.prune(now);
+        let parallel = st.parallel_cap(caps, now);
+        let per_minute = DomainState::rate_cap(caps, parallel);
+        if st.started.len() >= per_minute as usize {
+            st.waits += 1;
+            let oldest = st.started.front().copied().unwrap_or(now);
+            let wait = RATE_WINDOW.saturating_sub(now.saturating_duration_since(oldest));
+            return Err(wait.max(PARALLEL_WAIT));
+        }
+        if st.in_flight >= parallel {
+            st.waits += 1;
+            return Err(PARALLEL_WAIT);
+        }
+        st.in_flight += 1;
+        st.started.push_back(now);
+        Ok(ThrottlePermit {
+            throttle: Arc::clone(self),
+            domain: key,
+        })
+    }
+
+    /// Record a temporary failure for `domain`. Returns true when `reason` is a rate-limit
+    /// reply and the domain's cap was (or stays) reduced.
+    pub fn record_deferral(&self, domain: &str, reason: &str, now: Instant) -> bool {
+        if !is_rate_limit_reply(reason) {
+            return false;
+        }
+        let key = domain.to_ascii_lowercase();
+        let caps = self.caps_for(&key);
+        let mut domains = self.lock();
+        let st = domains.entry(key).or_default();
+        st.rate_limited += 1;
+        let current = st.parallel_cap(caps, now);
+        let held = st
+            .reduction
+            .as_ref()
+            .is_some_and(|r| now.saturating_duration_since(r.cut_at) < ADAPTIVE_HOLD);
+        if let Some(r) = st.reduction.as_mut().filter(|_| held) {
+            r.parallel = current;
+            r.since = now;
+        } else {
+            st.reduction = Some(Reduction {
+                parallel: (current / 2).max(1),
+                since: now,
+                cut_at: now,
+            });
+        }
+        true
+    }
+
+    /// Record a successful delivery to `domain`.
+    pub fn record_delivered(&self, domain: &str) {
+        let mut domains = self.lock();
+        domains
+            .entry(domain.to_ascii_lowercase())
+            .or_default()
+            .delivered += 1;
+    }
+
+    fn release(&self, domain: &str) {
+        let mut domains = self.lock();
+        if let Some(st) = domains.get_mut(domain) {
+            st.in_flight = st.in_flight.saturating_sub(1);
+        }
+    }
+
+    /// Per-domain state at `now`, sorted by domain.
+    pub fn snapshot(&self, now: Instant) -> Vec<DomainThrottleRow> {
+        let mut domains = self.lock();
+        let mut rows: Vec<_> = domains
+            .iter_mut()
+            .map(|(domain, st)| {
+                let caps = self.caps_for(domain);
+                st.prune(now);
+                let parallel = st.parallel_cap(caps, now);
+                DomainThrottleRow {
+                    domain: domain.clone(),
+                    in_flight: st.in_flight,
+                    started_last_minute: st.started.len() as u32,
+                    max_parallel: caps.max_parallel,
+                    max_per_minute: caps.max_per_minute,
+                    effective_parallel: parallel,
+                    effective_per_minute: DomainState::rate_cap(caps, parallel),
+                    reduced: st.reduction.is_some(),
+                    rate_limited: st.rate_limited,
+                    waits: st.waits,
+                    delivered: st.delivered,
+                }
+            })
+            .collect();
+        rows.sort_by(|a, b| a.domain.cmp(&b.domain));
+        rows
+    }
+}
+
+impl Default for DeliveryThrottle {
+    fn default() -> Self {
+        Self::from_settings(&QueueSettings::default())
+    }
+}
+
+/// One in-flight delivery attempt; frees the domain's parallel slot on drop.
+#[derive(Debug)]
+pub struct ThrottlePermit {
+    throttle: Arc<DeliveryThrottle>,
+    domain: String,
+}
+
+impl Drop for ThrottlePermit {
+    fn drop(&mut self) {
+        self.throttle.release(&self.domain);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn throttle(parallel: u32, per_minute: u32) -> Arc<DeliveryThrottle> {
+        Arc::new(DeliveryThrottle::new(
+            DomainCaps {
+                max_parallel: parallel,
+                max_per_minute: per_minute,
+            },
+            Vec::new(),
+        ))
+    }
+
+    fn row(t: &DeliveryThrottle, domain: &str, now: Instant) -> DomainThrottleRow {
+        t.snapshot(now)
+            .into_iter()
+            .find(|r| r.domain == domain)
+            .expect("domain row")
+    }
+
+    #[test]
+    fn rate_limit_replies_are_recognised() {
+        assert!(is_rate_limit_reply(
+            "smtp expected 250, got: 450 4.7.0 Try again later"
+        ));
+        assert!(is_rate_limit_reply("421 Too many connections"));
+        assert!(is_rate_limit_reply("HTTP 429: rate limited"));
+        assert!(!is_rate_limit_reply("450 4.2.1 Mailbox busy"));
+        assert!(!is_rate_limit_reply("smtp connect: connection refused"));
+    }
+
+    #[test]
+    fn parallel_cap_holds_excess_attempts() {
+        let t = throttle(2, 60);
+        let now = Instant::now();
+        let a = t.acquire("Example.org", now).expect("first");
+        let _b = t.acquire("example.org", now).expect("second");
+        assert_eq!(t.acquire("example.org", now).unwrap_err(), PARALLEL_WAIT);
+        assert!(t.acquire("other.org", now).is_ok(), "caps are per domain");
+        drop(a);
+        assert!(t.acquire("example.org", now).is_ok());
+        assert_eq!(row(&t, "example.org", now).waits, 1);
+    }
+
+    #[test]
+    fn per_minute_cap_waits_for_the_window() {
+        let t = throttle(4, 2);
+        let start = Instant::now();
+        drop(t.acquire("example.org", start).expect("first"));
+        drop(
+            t.acquire("example.org", start + Duration::from_secs(20))
+                .expect("second"),
+        );
+        let wait = t
+            .acquire("example.org", start + Duration::from_secs(30))
+            .unwrap_err();
+        assert_eq!(wait, Duration::from_secs(30));
+        assert!(t.acquire("example.org", start + RATE_WINDOW).is_ok());
+    }
+
+    #[test]
+    fn domain_limit_overrides_defaults() {
+        let t = DeliveryThrottle::new(
+            DomainCaps {
+                max_parallel: 4,
+                max_per_minute: 60,
+            },
+            vec![DomainLimit {
+                domain: "gmail.com".into(),
+                max_parallel: 1,
+                max_per_minute: 10,
+            }],
+        );
+        assert_eq!(
+            t.caps_for("mx.gmail.com"),
+            DomainCaps {
+                max_parallel: 1,
+                max_per_minute: 10
+            }
+        );
+        assert_eq!(t.caps_for("example.org").max_parallel, 4);
+    }
+
+    /// A domain deferring every other message with 450 4.7.0: the cap drops to one
+    /// while the deferrals continue and climbs back once the domain accepts again.
+    #[test]
+    fn adaptive_cap_engages_on_alternating_450_and_recovers() {
+        let t = throttle(4, 60);
+        let domain = "busy.example";
+        let start = Instant::now();
+        let mut now = start;
+        for n in 0..12 {
+            let permit = t.acquire(domain, now);
+            if let Ok(permit) = permit {
+                if n % 2 == 1 {
+                    assert!(t.record_deferral(domain, "450 4.7.0 Try again later", now));
+                } else {
+                    t.record_delivered(domain);
+                }
+                drop(permit);
+            }
+            now += Duration::from_secs(6);
+        }
+        let engaged = row(&t, domain, now);
+        assert!(engaged.reduced);
+        assert_eq!(engaged.effective_parallel, 1);
+        assert_eq!(engaged.effective_per_minute, 15);
+        assert_eq!(engaged.rate_limited, 6);
+
+        let _held = t.acquire(domain, now).expect("one slot left");
+        assert!(t.acquire(domain, now).is_err(), "reduced cap is enforced");
+
+        // Unrelated temporary failures do not cut the cap.
+        assert!(!t.record_deferral(domain, "smtp connect: timed out", now));
+
+        let partial = row(&t, domain, now + ADAPTIVE_RECOVERY_STEP * 2);
+        assert!(partial.reduced);
+        assert_eq!(partial.effective_parallel, 3);
+
+        let recovered = row(&t, domain, now + ADAPTIVE_RECOVERY_STEP * 3);
+        assert!(!recovered.reduced);
+        assert_eq!(recovered.effective_parallel, 4);
+        assert_eq!(recovered.effective_per_minute, 60);
+    }
+
+    #[test]
+    fn deferrals_within_hold_do_not_compound() {
+        let t = throttle(8, 60);
+        let now = Instant::now();
+        for _ in 0..5 {
+            t.record_deferral("example.org", "450 4.7.0 try again later", now);
+        }
+        assert_eq!(row(&t, "example.org", now).effective_parallel, 4);
+        t.record_deferral("example.org", "4.7.0", now + ADAPTIVE_HOLD);
+        assert_eq!(
+            row(&t, "example.org", now + ADAPTIVE_HOLD).effective_parallel,
+            2
+        );
+    }
+}
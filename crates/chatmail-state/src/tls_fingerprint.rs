@@ -0,0 +1,581 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! JA4 fingerprints of inbound TLS ClientHellos (`tls_fingerprint`, default on).
+//!
+//! Implicit-TLS listeners and STARTTLS upgrades peek at the ClientHello before handing the
+//! socket to rustls, so computing the fingerprint costs no extra round trip. The string is
+//! logged with failed logins and registrations and can be banned (`ja4:…` in `bans`); it is
+//! never sent to clients.
+//!
+//! Format (FoxIO JA4, TCP only): `t{version}{d|i}{ciphers:02}{extensions:02}{alpn}` then the
+//! first 12 hex digits of SHA-256 over the sorted cipher list and over the sorted extension
+//! list (SNI and ALPN left out) plus the signature algorithms. GREASE values are ignored.
+//!
+//! A ClientHello may be spread over several TLS records (large session tickets, many cipher
+//! suites, post-quantum key shares, ECH) and each record over several TCP segments, so the
+//! handshake message is reassembled from up to [`MAX_HELLO_PEEK`] peeked bytes.
+
+use std::time::Duration;
+
+use chatmail_config::AppConfig;
+use sha2::{Digest, Sha256};
+use tokio::net::TcpStream;
+
+/// Longest wait for a complete ClientHello before giving up on the fingerprint.
+pub const CLIENT_HELLO_WAIT: Duration = Duration::from_secs(5);
+/// Largest plaintext fragment of one TLS record.
+const MAX_FRAGMENT: usize = 16_384;
+/// TLS record header plus the largest plaintext fragment.
+const MAX_RECORD: usize = 5 + MAX_FRAGMENT;
+/// Most bytes peeked for one ClientHello: four full records. The peek buffer starts at one
+/// record and grows only while a hello is still incomplete.
+pub const MAX_HELLO_PEEK: usize = 4 * MAX_RECORD;
+const CONTENT_HANDSHAKE: u8 = 0x16;
+const HANDSHAKE_CLIENT_HELLO: u8 = 0x01;
+const EXT_SERVER_NAME: u16 = 0x0000;
+const EXT_SIGNATURE_ALGORITHMS: u16 = 0x000d;
+const EXT_ALPN: u16 = 0x0010;
+const EXT_SUPPORTED_VERSIONS: u16 = 0x002b;
+
+/// JA4 of the connection's ClientHello, as an axum request extension on HTTPS listeners.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TlsFingerprint(pub String);
+
+impl std::fmt::Display for TlsFingerprint {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.0)
+    }
+}
+
+/// Computes fingerprints for listeners unless `tls_fingerprint off`.
+#[derive(Debug)]
+pub struct TlsFingerprinter {
+    enabled: bool,
+}
+
+impl Default for TlsFingerprinter {
+    fn default() -> Self {
+        Self::new(true)
+    }
+}
+
+impl TlsFingerprinter {
+    pub fn new(enabled: bool) -> Self {
+        Self { enabled }
+    }
+
+    pub fn from_config(config: &AppConfig) -> Self {
+        Self::new(config.tls_fingerprint.unwrap_or(true))
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.enabled
+    }
+
+    /// JA4 of the ClientHello waiting on `stream`; nothing is consumed. `None` when disabled,
+    /// when the client sends something else, or when the hello does not arrive in time.
+    pub async fn fingerprint(&self, stream: &TcpStream) -> Option<String> {
+        if !self.enabled {
+            return None;
+        }
+        let record = peek_client_hello(stream).await?;
+        ja4(&record)
+    }
+}
+
+/// Peek until the ClientHello is complete and return the TLS records that carry it (headers
+/// included). `None` for anything else, a hello larger than [`MAX_HELLO_PEEK`], or a client
+/// that has not sent it within [`CLIENT_HELLO_WAIT`].
+pub async fn peek_client_hello(stream: &TcpStream) -> Option<Vec<u8>> {
+    let deadline = tokio::time::Instant::now() + CLIENT_HELLO_WAIT;
+    let mut buf = vec![0u8; MAX_RECORD];
+    loop {
+        let n = tokio::time::timeout_at(deadline, stream.peek(&mut buf))
+            .await
+            .ok()?
+            .ok()?;
+        if n == 0 {
+            return None;
+        }
+        match reassemble_client_hello(&buf[..n]) {
+            Reassembly::Complete { records_len, .. } => {
+                buf.truncate(records_len);
+                return Some(buf);
+            }
+            Reassembly::Invalid => return None,
+            Reassembly::Partial if n == buf.len() => {
+                if buf.len() >= MAX_HELLO_PEEK {
+                    return None;
+                }
+                buf.resize((buf.len() * 2).min(MAX_HELLO_PEEK), 0);
+                continue;
+            }
+            Reassembly::Partial => {}
+        }
+        // Peek returns at once while bytes are queued; give the rest of the hello time to land.
+        if tokio::time::Instant::now() >= deadline {
+            return None;
+        }
+        tokio::time::sleep(Duration::from_millis(5)).await;
+    }
+}
+
+#[derive(Debug, PartialEq, Eq)]
+enum Reassembly {
+    /// `message` is the ClientHello handshake message (type and length included), taken
+    /// from the first `records_len` bytes.
+    Complete {
+        message: Vec<u8>,
+        records_len: usize,
+    },
+    /// Well-formed so far; more bytes are needed.
+    Partial,
+    /// Not a ClientHello, or one that cannot fit in [`MAX_HELLO_PEEK`].
+    Invalid,
+}
+
+/// Join the handshake fragments of the TLS records at the start of `bytes` until the
+/// ClientHello they carry is complete.
+fn reassemble_client_hello(bytes: &[u8]) -> Reassembly {
+    let mut message = Vec::new();
+    let mut pos = 0;
+    loop {
+        match bytes.get(pos) {
+            None => return Reassembly::Partial,
+            Some(&CONTENT_HANDSHAKE) => {}
+            Some(_) => return Reassembly::Invalid,
+        }
+        let Some(header) = bytes.get(pos..pos + 5) else {
+            return Reassembly::Partial;
+        };
+        let len = u16::from_be_bytes([header[3], header[4]]) as usize;
+        if len == 0 || len > MAX_FRAGMENT {
+            return Reassembly::Invalid;
+        }
+        let Some(fragment) = bytes.get(pos + 5..pos + 5 + len) else {
+            return Reassembly::Partial;
+        };
+        message.extend_from_slice(fragment);
+        pos += 5 + len;
+        if message.len() < 4 {
+            continue;
+        }
+        if message[0] != HANDSHAKE_CLIENT_HELLO {
+            return Reassembly::Invalid;
+        }
+        let want = 4 + Reader(&message[1..4]).u24().unwrap_or(0);
+        if want > MAX_HELLO_PEEK {
+            return Reassembly::Invalid;
+        }
+        if message.len() >= want {
+            message.truncate(want);
+            return Reassembly::Complete {
+                message,
+                records_len: pos,
+            };
+        }
+    }
+}
+
+/// JA4 string for the TLS records carrying a ClientHello; `None` if it does not parse.
+pub fn ja4(records: &[u8]) -> Option<String> {
+    let Reassembly::Complete { message, .. } = reassemble_client_hello(records) else {
+        return None;
+    };
+    let hello = parse_client_hello(&message)?;
+    let version = hello
+        .supported_versions
+        .iter()
+        .copied()
+        .max()
+        .unwrap_or(hello.version);
+    let version = match version {
+        0x0304 => "13",
+        0x0303 => "12",
+        0x0302 => "11",
+        0x0301 => "10",
+        0x0300 => "s3",
+        _ => "00",
+    };
+    let sni = if hello.extensions.contains(&EXT_SERVER_NAME) {
+        'd'
+    } else {
+        'i'
+    };
+    let alpn = hello
+        .alpn
+        .as_deref()
+        .map_or_else(|| "00".to_string(), alpn_chars);
+
+    let mut ciphers = hello.ciphers.clone();
+    ciphers.sort_unstable();
+    let mut extensions: Vec<u16> = hello
+        .extensions
+        .iter()
+        .copied()
+        .filter(|&e| e != EXT_SERVER_NAME && e != EXT_ALPN)
+        .collect();
+    extensions.sort_unstable();
+    let mut ext_text = hex_list(&extensions);
+    if !extensions.is_empty() && !hello.signature_algorithms.is_empty() {
+        ext_text.push('_');
+        ext_text.push_str(&hex_list(&hello.signature_algorithms));
+    }
+
+    Some(format!(
+        "t{version}{sni}{:02}{:02}{alpn}_{}_{}",
+        hello.ciphers.len().min(99),
+        hello.extensions.len().min(99),
+        truncated_hash(&hex_list(&ciphers)),
+        truncated_hash(&ext_text),
+    ))
+}
+
+#[derive(Debug, Default)]
+struct ClientHello {
+    version: u16,
+    ciphers: Vec<u16>,
+    /// Extension types in wire order.
+    extensions: Vec<u16>,
+    supported_versions: Vec<u16>,
+    signature_algorithms: Vec<u16>,
+    alpn: Option<Vec<u8>>,
+}
+
+/// RFC 8701 GREASE values (`0x?a?a` with equal bytes).
+fn is_grease(v: u16) -> bool {
+    v & 0x0f0f == 0x0a0a && v >> 8 == v & 0xff
+}
+
+/// Parse a ClientHello handshake message (type and length included).
+fn parse_client_hello(message: &[u8]) -> Option<ClientHello> {
+    let mut msg = Reader(message);
+    if msg.u8()? != HANDSHAKE_CLIENT_HELLO {
+        return None;
+    }
+    let len = msg.u24()?;
+    let mut r = Reader(msg.take(len)?);
+
+    let mut hello = ClientHello {
+        version: r.u16()?,
+        ..ClientHello::default()
+    };
+    r.take(32)?;
+    r.vec8()?;
+    hello.ciphers = u16_list(r.vec16()?)
+        .into_iter()
+        .filter(|&c| !is_grease(c))
+        .collect();
+    r.vec8()?;
+    let mut exts = Reader(if r.0.is_empty() { &[][..] } else { r.vec16()? });
+    while !exts.0.is_empty() {
+        let kind = exts.u16()?;
+        let body = exts.vec16()?;
+        if is_grease(kind) {
+            continue;
+        }
+        hello.extensions.push(kind);
+        match kind {
+            EXT_SUPPORTED_VERSIONS => {
+                hello.supported_versions = u16_list(Reader(body).vec8()?)
+                    .into_iter()
+                    .filter(|&v| !is_grease(v))
+                    .collect();
+            }
+            EXT_SIGNATURE_ALGORITHMS => {
+                hello.signature_algorithms = u16_list(Reader(body).vec16()?);
+            }
+            EXT_ALPN => {
+                let mut list = Reader(Reader(body).vec16()?);
+                hello.alpn = list.vec8().map(<[u8]>::to_vec);
+            }
+            _ => {}
+        }
+    }
+    Some(hello)
+}
+
+/// First and last character of the first ALPN value; hex digits when not alphanumeric.
+fn alpn_chars(proto: &[u8]) -> String {
+    match (proto.first(), proto.last()) {
+        (Some(&first), Some(&last))
+            if first.is_ascii_alphanumeric() && last.is_ascii_alphanumeric() =>
+        {
+            format!("{}{}", first as char, last as char)
+        }
+        (Some(&first), Some(&last)) => {
+            let hex = format!("{first:02x}{last:02x}");
+            format!("{}{}", &hex[..1], &hex[3..])
+        }
+        _ => "00".to_string(),
+    }
+}
+
+fn hex_list(values: &[u16]) -> String {
+    values
+        .iter()
+        .map(|v| format!("{v:04x}"))
+        .collect::<Vec<_>>()
+        .join(",")
+}
+
+fn truncated_hash(text: &str) -> String {
+    if text.is_empty() {
+        return "000000000000".to_string();
+    }
+    Sha256::digest(text.as_bytes())[..6]
+        .iter()
+        .map(|b| format!("{b:02x}"))
+        .collect()
+}
+
+fn u16_list(bytes: &[u8]) -> Vec<u16> {
+    bytes
+        .chunks_exact(2)
+        .map(|c| u16::from_be_bytes([c[0], c[1]]))
+        .collect()
+}
+
+/// Bounds-checked big-endian cursor.
+struct Reader<'a>(&'a [u8]);
+
+impl<'a> Reader<'a> {
+    fn take(&mut self, n: usize) -> Option<&'a [u8]> {
+        if self.0.len() < n {
+            return None;
+        }
+        let (head, rest) = self.0.split_at(n);
+        self.0 = rest;
+        Some(head)
+    }
+
+    fn u8(&mut self) -> Option<u8> {
+        Some(self.take(1)?[0])
+    }
+
+    fn u16(&mut self) -> Option<u16> {
+        let b = self.take(2)?;
+        Some(u16::from_be_bytes([b[0], b[1]]))
+    }
+
+    fn u24(&mut self) -> Option<usize> {
+        let b = self.take(3)?;
+        Some(((b[0] as usize) << 16) | ((b[1] as usize) << 8) | b[2] as usize)
+    }
+
+    fn vec8(&mut self) -> Option<&'a [u8]> {
+        let n = self.u8()? as usize;
+        self.take(n)
+    }
+
+    fn vec16(&mut self) -> Option<&'a [u8]> {
+        let n = self.u16()? as usize;
+        self.take(n)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Chrome-shaped hello (GREASE included) rebuilt from the JA4 reference example.
+    const CHROME: &[u8] = include_bytes!("../tests/fixtures/client_hello_chrome.bin");
+    /// Captured from OpenSSL 3.0 (`s_client`-style, SNI and ALPN `imap`).
+    const OPENSSL: &[u8] = include_bytes!("../tests/fixtures/client_hello_openssl.bin");
+    /// TLS 1.2 only, no SNI, no ALPN.
+    const TLS12: &[u8] = include_bytes!("../tests/fixtures/client_hello_tls12.bin");
+
+    #[test]
+    fn fixtures_give_known_fingerprints() {
+        assert_eq!(
+            ja4(CHROME).as_deref(),
+            Some("t13d1516h2_8daaf6152771_e5627efa2ab1")
+        );
+        assert_eq!(
+            ja4(OPENSSL).as_deref(),
+            Some("t13d1812ip_85036bcba153_d41ae481755e")
+        );
+        assert_eq!(
+            ja4(TLS12).as_deref(),
+            Some("t12i030400_e854b2f3a2d0_91e0de064512")
+        );
+    }
+
+    #[test]
+    fn rejects_truncated_and_foreign_records() {
+        assert_eq!(ja4(&CHROME[..CHROME.len() - 1]), None);
+        assert_eq!(ja4(b"a001 CAPABILITY\r\n"), None);
+        assert_eq!(ja4(&[]), None);
+        let mut server_hello = TLS12.to_vec();
+        server_hello[5] = 0x02;
+        assert_eq!(ja4(&server_hello), None);
+    }
+
+    /// ClientHello handshake message with `padding` bytes of padding extension (21) and ALPN
+    /// `imap` as the last extension.
+    fn synthetic_hello(padding: usize) -> Vec<u8> {
+        let mut body = vec![0x03, 0x03];
+        body.extend([7u8; 32]);
+        body.push(0);
+        body.extend([0x00, 0x02, 0x13, 0x01, 0x01, 0x00]);
+        let mut exts = vec![0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04];
+        exts.extend([0x00, 0x15]);
+        exts.extend((padding as u16).to_be_bytes());
+        exts.resize(exts.len() + padding, 0);
+        exts.extend([
+            0x00, 0x10, 0x00, 0x07, 0x00, 0x05, 0x04, b'i', b'm', b'a', b'p',
+        ]);
+        body.extend((exts.len() as u16).to_be_bytes());
+        body.extend(exts);
+        let mut message = vec![HANDSHAKE_CLIENT_HELLO];
+        message.extend(&(body.len() as u32).to_be_bytes()[1..]);
+        message.extend(body);
+        message
+    }
+
+    /// `message` cut into handshake records of at most `fragment` bytes.
+    fn records(message: &[u8], fragment: usize) -> Vec<u8> {
+        let mut out = Vec::new();
+        for chunk in message.chunks(fragment) {
+            out.extend([CONTENT_HANDSHAKE, 0x03, 0x01]);
+            out.extend((chunk.len() as u16).to_be_bytes());
+            out.extend(chunk);
+        }
+        out
+    }
+
+    #[test]
+    fn hellos_split_across_records_are_reassembled() {
+        let expected = ja4(&records(&synthetic_hello(0), MAX_FRAGMENT)).unwrap();
+        assert!(expected.starts_with("t13i0103ip_"), "{expected}");
+        for (padding, fragment) in [
+            (0, 40),
+            (5000, MAX_FRAGMENT),
+            (5000, 1000),
+            (20_000, MAX_FRAGMENT),
+            (50_000, MAX_FRAGMENT),
+        ] {
+            let message = synthetic_hello(padding);
+            let wire = records(&message, fragment);
+            assert_eq!(
+                ja4(&wire).as_ref(),
+                Some(&expected),
+                "padding {padding}, fragment {fragment}"
+            );
+            let mut trailing = wire.clone();
+            trailing.extend(b"\x14\x03\x03\x00\x01\x01");
+            match reassemble_client_hello(&trailing) {
+                Reassembly::Complete {
+                    message: got,
+                    records_len,
+                } => {
+                    assert_eq!(got, message);
+                    assert_eq!(records_len, wire.len());
+                }
+                other => panic!("padding {padding}: {other:?}"),
+            }
+            assert_eq!(
+                reassemble_client_hello(&wire[..wire.len() - 1]),
+                Reassembly::Partial
+            );
+        }
+    }
+
+    #[test]
+    fn refuses_oversized_and_interleaved_records() {
+        let mut huge = vec![CONTENT_HANDSHAKE, 0x03, 0x01, 0x00, 0x04];
+        huge.extend([HANDSHAKE_CLIENT_HELLO, 0x10, 0x00, 0x00]);
+        assert_eq!(reassemble_client_hello(&huge), Reassembly::Invalid);
+
+        let mut interleaved = records(&synthetic_hello(5000)[..3000], 3000);
+        interleaved.extend([0x17, 0x03, 0x03, 0x00, 0x01, 0x00]);
+        assert_eq!(reassemble_client_hello(&interleaved), Reassembly::Invalid);
+
+        let empty_record = [CONTENT_HANDSHAKE, 0x03, 0x01, 0x00, 0x00];
+        assert_eq!(reassemble_client_hello(&empty_record), Reassembly::Invalid);
+        assert_eq!(
+            reassemble_client_hello(&[CONTENT_HANDSHAKE, 0x03]),
+            Reassembly::Partial
+        );
+    }
+
+    #[test]
+    fn grease_and_alpn_rules() {
+        assert!(is_grease(0x0a0a) && is_grease(0xfafa));
+        assert!(!is_grease(0x0a1a) && !is_grease(0x1301));
+        assert_eq!(alpn_chars(b"h2"), "h2");
+        assert_eq!(alpn_chars(b"http/1.1"), "h1");
+        assert_eq!(alpn_chars(b"x"), "xx");
+        assert_eq!(alpn_chars(&[0xab, b'-', 0xcd]), "ad");
+    }
+
+    #[tokio::test]
+    async fn peek_leaves_the_hello_for_the_handshake() {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        let client = tokio::spawn(async move {
+            let mut s = TcpStream::connect(addr).await.unwrap();
+            // Split across two writes, like a hello larger than one segment.
+            s.write_all(&OPENSSL[..100]).await.unwrap();
+            tokio::time::sleep(Duration::from_millis(30)).await;
+            s.write_all(&OPENSSL[100..]).await.unwrap();
+            s
+        });
+        let (mut stream, _) = listener.accept().await.unwrap();
+        let fp = TlsFingerprinter::default().fingerprint(&stream).await;
+        assert_eq!(fp.as_deref(), Some("t13d1812ip_85036bcba153_d41ae481755e"));
+        let mut all = vec![0u8; OPENSSL.len()];
+        stream.read_exact(&mut all).await.unwrap();
+        assert_eq!(all, OPENSSL);
+        assert_eq!(
+            TlsFingerprinter::new(false).fingerprint(&stream).await,
+            None
+        );
+        drop(client.await.unwrap());
+    }
+
+    #[tokio::test]
+    async fn peek_reassembles_large_hellos_sent_in_segments() {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let expected = ja4(&records(&synthetic_hello(0), MAX_FRAGMENT));
+        for (padding, fragment) in [(6000, 3000), (40_000, MAX_FRAGMENT)] {
+            let wire = records(&synthetic_hello(padding), fragment);
+            let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+            let addr = listener.local_addr().unwrap();
+            let sent = wire.clone();
+            let client = tokio::spawn(async move {
+                let mut s = TcpStream::connect(addr).await.unwrap();
+                for segment in sent.chunks(1400) {
+                    s.write_all(segment).await.unwrap();
+                    tokio::time::sleep(Duration::from_millis(2)).await;
+                }
+                s
+            });
+            let (mut stream, _) = listener.accept().await.unwrap();
+            let fp = TlsFingerprinter::default().fingerprint(&stream).await;
+            assert_eq!(fp, expected, "padding {padding}");
+            let mut all = vec![0u8; wire.len()];
+            stream.read_exact(&mut all).await.unwrap();
+            assert_eq!(all, wire);
+            drop(client.await.unwrap());
+        }
+    }
+}
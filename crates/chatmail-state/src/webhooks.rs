@@ -0,0 +1,431 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Account lifecycle webhooks (`webhook_url`, `webhook_secret`, `webhook_events`).
+//!
+//! Emit points (registration and JIT creation, account deletion, the pruning jobs, first
+//! logins and quota rejections) call [`Webhooks::emit`], which only serializes the event and
+//! puts it on a bounded in-process queue: a slow or unreachable receiver never holds up the
+//! caller, and when the queue is full the event is dropped with a warning. The server drains
+//! the queue one event at a time through a [`WebhookSender`]; [`Webhooks::deliver`] retries a
+//! failed POST up to [`WEBHOOK_MAX_ATTEMPTS`] times with exponential backoff.
+//!
+//! The body is `{"event": …, "at": <unix seconds>, "data": {…}}`. With `webhook_secret` set,
+//! the `X-Madmail-Signature` header carries `v1=<hex HMAC-SHA256 of the body>`, the scheme
+//! peers use for `/federation/info` ([`sign_info`]).
+
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Mutex;
+use std::time::Duration;
+
+use async_trait::async_trait;
+use chatmail_config::AppConfig;
+use chatmail_db::policies::unix_now;
+use serde_json::{json, Value};
+use tokio::sync::mpsc;
+
+use crate::peers::sign_info;
+
+/// Request header naming the event, so receivers can route before parsing the body.
+pub const WEBHOOK_EVENT_HEADER: &str = "x-madmail-event";
+
+/// Events waiting for delivery before new ones are dropped.
+pub const WEBHOOK_QUEUE_CAPACITY: usize = 1024;
+
+/// POST attempts per event.
+pub const WEBHOOK_MAX_ATTEMPTS: u32 = 5;
+
+/// Delay before the second attempt; doubles after every failure.
+pub const WEBHOOK_INITIAL_BACKOFF: Duration = Duration::from_secs(2);
+
+/// `quota_exceeded` is sent at most once per account in this window.
+pub const QUOTA_EVENT_INTERVAL: Duration = Duration::from_secs(3600);
+
+/// Throttle entries kept before expired ones are swept.
+const QUOTA_THROTTLE_SWEEP: usize = 4096;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum WebhookEvent {
+    AccountCreated,
+    AccountDeleted,
+    AccountPruned,
+    QuotaExceeded,
+    FirstLogin,
+    /// Sent only by `madmail webhook test`.
+    Ping,
+}
+
+impl WebhookEvent {
+    /// Events `webhook_events` may list.
+    pub const ALL: [Self; 5] = [
+        Self::AccountCreated,
+        Self::AccountDeleted,
+        Self::AccountPruned,
+        Self::QuotaExceeded,
+        Self::FirstLogin,
+    ];
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::AccountCreated => "account_created",
+            Self::AccountDeleted => "account_deleted",
+            Self::AccountPruned => "account_pruned",
+            Self::QuotaExceeded => "quota_exceeded",
+            Self::FirstLogin => "first_login",
+            Self::Ping => "ping",
+        }
+    }
+
+    pub fn parse(s: &str) -> Option<Self> {
+        Self::ALL.into_iter().find(|e| e.as_str() == s)
+    }
+}
+
+/// One serialized event, ready to POST.
+#[derive(Debug, Clone)]
+pub struct WebhookDelivery {
+    pub event: WebhookEvent,
+    /// JSON body.
+    pub body: Vec<u8>,
+    /// `X-Madmail-Signature` value; `None` without `webhook_secret`.
+    pub signature: Option<String>,
+}
+
+/// Transport for [`Webhooks::deliver`]; the HTTP implementation lives in the binary.
+#[async_trait]
+pub trait WebhookSender: Send + Sync {
+    /// POST `delivery` to `url`. `Err` (transport failure or a non-2xx status) is retried.
+    async fn post(&self, url: &str, delivery: &WebhookDelivery) -> std::result::Result<(), String>;
+}
+
+pub struct Webhooks {
+    url: Option<String>,
+    secret: Option<String>,
+    events: Vec<WebhookEvent>,
+    initial_backoff: Duration,
+    tx: mpsc::Sender<WebhookDelivery>,
+    /// Taken once by the worker that drains the queue.
+    rx: Mutex<Option<mpsc::Receiver<WebhookDelivery>>>,
+    /// Account → unix time of its last `quota_exceeded`.
+    quota_notified: Mutex<HashMap<String, i64>>,
+    dropped: AtomicU64,
+}
+
+impl Webhooks {
+    /// `events` empty means every event in [`WebhookEvent::ALL`].
+    pub fn new(url: Option<String>, secret: Option<String>, events: Vec<WebhookEvent>) -> Self {
+        let (tx, rx) = mpsc::channel(WEBHOOK_QUEUE_CAPACITY);
+        Self {
+            url: url.filter(|u| !u.trim().is_empty()),
+            secret: secret.filter(|s| !s.is_empty()),
+            events: if events.is_empty() {
+                WebhookEvent::ALL.to_vec()
+            } else {
+                events
+            },
+            initial_backoff: WEBHOOK_INITIAL_BACKOFF,
+            tx,
+            rx: Mutex::new(Some(rx)),
+            quota_notified: Mutex::new(HashMap::new()),
+            dropped: AtomicU64::new(0),
+        }
+    }
+
+    /// Unknown `webhook_events` names are logged and ignored.
+    pub fn from_config(config: &AppConfig) -> Self {
+        let mut events = Vec::new();
+        for name in &config.webhook_events {
+            match WebhookEvent::parse(name) {
+                Some(event) if !events.contains(&event) => events.push(event),
+                Some(_) => {}
+                None => tracing::warn!(event = %name, "webhook_events: unknown event ignored"),
+            }
+        }
+        Self::new(
+            config.webhook_url.clone(),
+            config.webhook_secret.clone(),
+            events,
+        )
+    }
+
+    /// Shorter retry delays for tests.
+    pub fn with_initial_backoff(mut self, backoff: Duration) -> Self {
+        self.initial_backoff = backoff;
+        self
+    }
+
+    pub fn url(&self) -> Option<&str> {
+        self.url.as_deref()
+    }
+
+    pub fn is_signed(&self) -> bool {
+        self.secret.is_some()
+    }
+
+    /// Allowlisted events, in `webhook_events` order.
+    pub fn events(&self) -> &[WebhookEvent] {
+        &self.events
+    }
+
+    /// True when `event` would be queued.
+    pub fn wants(&self, event: WebhookEvent) -> bool {
+        self.url.is_some() && self.events.contains(&event)
+    }
+
+    /// Events dropped because the queue was full.
+    pub fn dropped(&self) -> u64 {
+        self.dropped.load(Ordering::Relaxed)
+    }
+
+    /// Serialize and sign `event` with `data`, whether or not it is allowlisted.
+    pub fn delivery(&self, event: WebhookEvent, data: Value) -> WebhookDelivery {
+        let body = json!({ "event": event.as_str(), "at": unix_now(), "data": data }).to_string();
+        let signature = self
+            .secret
+            .as_deref()
+            .map(|secret| sign_info(secret, body.as_bytes()));
+        WebhookDelivery {
+            event,
+            body: body.into_bytes(),
+            signature,
+        }
+    }
+
+    /// Queue `event` when a URL is configured and the event is allowlisted. Never waits.
+    pub fn emit(&self, event: WebhookEvent, data: Value) {
+        if !self.wants(event) {
+            return;
+        }
+        if let Err(e) = self.tx.try_send(self.delivery(event, data)) {
+            self.dropped.fetch_add(1, Ordering::Relaxed);
+            let reason = match e {
+                mpsc::error::TrySendError::Full(_) => "queue full",
+                mpsc::error::TrySendError::Closed(_) => "queue closed",
+            };
+            tracing::warn!(event = event.as_str(), reason, "webhook event dropped");
+        }
+    }
+
+    /// `quota_exceeded` for `username`, at most once per [`QUOTA_EVENT_INTERVAL`]: a full
+    /// mailbox rejects every message sent to it and the receiver needs to hear it once.
+    pub fn emit_quota_exceeded(&self, username: &str, used: u64, incoming: u64, max: u64) {
+        if !self.wants(WebhookEvent::QuotaExceeded) {
+            return;
+        }
+        let now = unix_now();
+        let window = QUOTA_EVENT_INTERVAL.as_secs() as i64;
+        {
+            let mut notified = self.quota_notified.lock().expect("quota throttle lock");
+            if notified
+                .get(username)
+                .is_some_and(|last| now - *last < window)
+            {
+                return;
+            }
+            if notified.len() >= QUOTA_THROTTLE_SWEEP {
+                notified.retain(|_, last| now - *last < window);
+            }
+            notified.insert(username.to_string(), now);
+        }
+        self.emit(
+            WebhookEvent::QuotaExceeded,
+            json!({ "username": username, "used": used, "incoming": incoming, "max": max }),
+        );
+    }
+
+    /// `account_pruned` for `username`: `rule` is `unused` or `inactive`, `action` is
+    /// `delete` or `suspend`.
+    pub fn emit_account_pruned(&self, username: &str, rule: &str, action: &str) {
+        self.emit(
+            WebhookEvent::AccountPruned,
+            json!({ "username": username, "rule": rule, "action": action }),
+        );
+    }
+
+    /// The queue's receiving end; `None` once a worker has taken it.
+    pub fn take_queue(&self) -> Option<mpsc::Receiver<WebhookDelivery>> {
+        self.rx.lock().expect("webhook queue lock").take()
+    }
+
+    /// POST `delivery` to `webhook_url`, retrying with exponential backoff. Returns the
+    /// attempt that succeeded, or the last error.
+    pub async fn deliver(
+        &self,
+        sender: &dyn WebhookSender,
+        delivery: &WebhookDelivery,
+    ) -> std::result::Result<u32, String> {
+        let Some(url) = self.url.as_deref() else {
+            return Err("webhook_url is not set".into());
+        };
+        let mut backoff = self.initial_backoff;
+        let mut last_error = String::new();
+        for attempt in 1..=WEBHOOK_MAX_ATTEMPTS {
+            match sender.post(url, delivery).await {
+                Ok(()) => return Ok(attempt),
+                Err(e) => {
+                    tracing::debug!(
+                        attempt,
+                        event = delivery.event.as_str(),
+                        error = %e,
+                        "webhook POST failed"
+                    );
+                    last_error = e;
+                }
+            }
+            if attempt < WEBHOOK_MAX_ATTEMPTS {
+                tokio::time::sleep(backoff).await;
+                backoff *= 2;
+            }
+        }
+        Err(last_error)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::peers::verify_info;
+    use std::sync::atomic::AtomicU32;
+
+    /// Fails the first `failures` POSTs, then records the bodies it accepts.
+    #[derive(Default)]
+    struct Flaky {
+        failures: u32,
+        calls: AtomicU32,
+        accepted: Mutex<Vec<Vec<u8>>>,
+    }
+
+    #[async_trait]
+    impl WebhookSender for Flaky {
+        async fn post(
+            &self,
+            _url: &str,
+            delivery: &WebhookDelivery,
+        ) -> std::result::Result<(), String> {
+            if self.calls.fetch_add(1, Ordering::SeqCst) < self.failures {
+                return Err("HTTP 503".into());
+            }
+            self.accepted.lock().unwrap().push(delivery.body.clone());
+            Ok(())
+        }
+    }
+
+    fn hooks(events: Vec<WebhookEvent>) -> Webhooks {
+        Webhooks::new(
+            Some("https://hooks.example/in".into()),
+            Some("s3cret".into()),
+            events,
+        )
+        .with_initial_backoff(Duration::from_millis(1))
+    }
+
+    #[test]
+    fn event_names_round_trip() {
+        for event in WebhookEvent::ALL {
+            assert_eq!(WebhookEvent::parse(event.as_str()), Some(event));
+        }
+        assert_eq!(WebhookEvent::parse("ping"), None);
+        assert_eq!(WebhookEvent::parse("account_updated"), None);
+    }
+
+    #[test]
+    fn emit_queues_signed_allowlisted_events_only() {
+        let hooks = hooks(vec![WebhookEvent::AccountCreated]);
+        let mut rx = hooks.take_queue().unwrap();
+        assert!(hooks.take_queue().is_none());
+
+        hooks.emit(
+            WebhookEvent::AccountCreated,
+            json!({ "username": "a@test", "source": "registration" }),
+        );
+        hooks.emit(WebhookEvent::FirstLogin, json!({ "username": "a@test" }));
+
+        let queued = rx.try_recv().unwrap();
+        assert!(rx.try_recv().is_err(), "first_login is not allowlisted");
+        let body: Value = serde_json::from_slice(&queued.body).unwrap();
+        assert_eq!(body["event"], "account_created");
+        assert_eq!(body["data"]["username"], "a@test");
+        assert!(body["at"].as_i64().unwrap() > 0);
+        let signature = queued.signature.unwrap();
+        assert!(verify_info(&["s3cret".into()], &queued.body, &signature));
+        assert!(!verify_info(&["other".into()], &queued.body, &signature));
+    }
+
+    #[test]
+    fn nothing_is_queued_without_a_url() {
+        let hooks = Webhooks::from_config(&AppConfig {
+            webhook_events: vec!["account_created".into(), "bogus".into()],
+            ..Default::default()
+        });
+        assert_eq!(hooks.events(), [WebhookEvent::AccountCreated]);
+        let mut rx = hooks.take_queue().unwrap();
+        hooks.emit(WebhookEvent::AccountCreated, json!({}));
+        assert!(rx.try_recv().is_err());
+        assert!(hooks
+            .delivery(WebhookEvent::Ping, json!({}))
+            .signature
+            .is_none());
+    }
+
+    #[test]
+    fn empty_allowlist_sends_everything_but_a_full_queue_drops() {
+        let hooks = hooks(vec![]);
+        assert_eq!(hooks.events(), WebhookEvent::ALL);
+        for _ in 0..WEBHOOK_QUEUE_CAPACITY + 3 {
+            hooks.emit(WebhookEvent::AccountDeleted, json!({}));
+        }
+        assert_eq!(hooks.dropped(), 3);
+    }
+
+    #[test]
+    fn quota_exceeded_is_throttled_per_account() {
+        let hooks = hooks(vec![]);
+        let mut rx = hooks.take_queue().unwrap();
+        hooks.emit_quota_exceeded("a@test", 100, 50, 120);
+        hooks.emit_quota_exceeded("a@test", 100, 60, 120);
+        hooks.emit_quota_exceeded("b@test", 10, 500, 120);
+        let first: Value = serde_json::from_slice(&rx.try_recv().unwrap().body).unwrap();
+        assert_eq!(first["data"]["incoming"], 50);
+        let second: Value = serde_json::from_slice(&rx.try_recv().unwrap().body).unwrap();
+        assert_eq!(second["data"]["username"], "b@test");
+        assert!(rx.try_recv().is_err());
+    }
+
+    #[tokio::test]
+    async fn deliver_retries_with_a_bounded_number_of_attempts() {
+        let hooks = hooks(vec![]);
+        let delivery = hooks.delivery(WebhookEvent::AccountPruned, json!({ "username": "a" }));
+
+        let flaky = Flaky {
+            failures: 2,
+            ..Default::default()
+        };
+        assert_eq!(hooks.deliver(&flaky, &delivery).await, Ok(3));
+        assert_eq!(flaky.accepted.lock().unwrap().len(), 1);
+
+        let down = Flaky {
+            failures: u32::MAX,
+            ..Default::default()
+        };
+        assert_eq!(
+            hooks.deliver(&down, &delivery).await,
+            Err("HTTP 503".to_string())
+        );
+        assert_eq!(down.calls.load(Ordering::SeqCst), WEBHOOK_MAX_ATTEMPTS);
+    }
+}
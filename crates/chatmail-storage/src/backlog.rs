@@ -0,0 +1,232 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Accepted-but-not-fetched report: INBOX messages still unseen long after delivery.
+//!
+//! A client that stopped fetching leaves its INBOX `new/` growing until retention
+//! catches up. The report walks `{state_dir}/mail/*/Maildir/new/` only (folders are
+//! written by clients, so they are fetched by definition) and uses the file mtime as
+//! delivery time, the same clock the retention passes use.
+
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use chatmail_types::Result;
+
+use crate::maildir::MailboxStore;
+use crate::maildir_message::split_maildir_filename;
+
+/// Default `min_age` (`imap-acct backlog --min-age`, `/admin/backlog`, the stats gauge).
+pub const DEFAULT_BACKLOG_MIN_AGE: Duration = Duration::from_secs(24 * 3600);
+/// Default `min_count` for the same surfaces.
+pub const DEFAULT_BACKLOG_MIN_COUNT: usize = 50;
+
+/// One account whose INBOX holds at least `min_count` stale messages.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct BacklogAccount {
+    /// Mail directory name (the username; `/` and `\` are stored as `_`).
+    pub username: String,
+    /// Unseen, undeleted INBOX messages older than the report's `min_age`.
+    pub stale: usize,
+    pub stale_bytes: u64,
+    /// Delivery time (unix seconds) of the oldest stale message.
+    pub oldest: i64,
+}
+
+/// Result of [`inbox_backlog`]; totals cover the listed accounts only.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct BacklogReport {
+    pub min_age: Duration,
+    pub min_count: usize,
+    /// Accounts with an INBOX maildir that were inspected.
+    pub scanned: usize,
+    pub stale: usize,
+    pub stale_bytes: u64,
+    /// Sorted by stale count (largest first), then username.
+    pub accounts: Vec<BacklogAccount>,
+}
+
+/// Accounts whose INBOX holds at least `min_count` unseen messages older than `min_age`.
+pub async fn inbox_backlog(
+    store: &MailboxStore,
+    min_age: Duration,
+    min_count: usize,
+) -> Result<BacklogReport> {
+    let cutoff = SystemTime::now().checked_sub(min_age).unwrap_or(UNIX_EPOCH);
+    let mut report = BacklogReport {
+        min_age,
+        min_count,
+        ..BacklogReport::default()
+    };
+    let mut rd = match tokio::fs::read_dir(store.state_dir().join("mail")).await {
+        Ok(r) => r,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(report),
+        Err(e) => return Err(e.into()),
+    };
+    while let Some(ent) = rd.next_entry().await? {
+        if !ent.file_type().await?.is_dir() {
+            continue;
+        }
+        let new_dir = ent.path().join("Maildir").join("new");
+        let Some(account) = scan_new_dir(&new_dir, cutoff).await? else {
+            continue;
+        };
+        report.scanned += 1;
+        if account.stale == 0 || account.stale < min_count {
+            continue;
+        }
+        report.stale += account.stale;
+        report.stale_bytes += account.stale_bytes;
+        report.accounts.push(BacklogAccount {
+            username: ent.file_name().to_string_lossy().into_owned(),
+            ..account
+        });
+    }
+    report.accounts.sort_by(|a, b| {
+        b.stale
+            .cmp(&a.stale)
+            .then_with(|| a.username.cmp(&b.username))
+    });
+    Ok(report)
+}
+
+/// Stale totals for one `new/` directory; `None` when the account has no INBOX.
+async fn scan_new_dir(dir: &std::path::Path, cutoff: SystemTime) -> Result<Option<BacklogAccount>> {
+    let mut rd = match tokio::fs::read_dir(dir).await {
+        Ok(r) => r,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(None),
+        Err(e) => return Err(e.into()),
+    };
+    let mut account = BacklogAccount {
+        username: String::new(),
+        stale: 0,
+        stale_bytes: 0,
+        oldest: 0,
+    };
+    while let Some(ent) = rd.next_entry().await? {
+        let name = ent.file_name();
+        let (_, flags) = split_maildir_filename(&name.to_string_lossy());
+        if flags.seen || flags.deleted {
+            continue;
+        }
+        // Raced with a fetch that moved the file to `cur/`: it is no longer backlog.
+        let Ok(meta) = ent.metadata().await else {
+            continue;
+        };
+        if !meta.is_file() {
+            continue;
+        }
+        let Ok(modified) = meta.modified() else {
+            continue;
+        };
+        if modified >= cutoff {
+            continue;
+        }
+        let at = modified
+            .duration_since(UNIX_EPOCH)
+            .map(|d| d.as_secs() as i64)
+            .unwrap_or(0);
+        account.stale += 1;
+        account.stale_bytes += meta.len();
+        if account.oldest == 0 || at < account.oldest {
+            account.oldest = at;
+        }
+    }
+    Ok(Some(account))
+}
+
+#[cfg(test)]
+mod tests {
+    use filetime::{set_file_mtime, FileTime};
+
+    use super::*;
+    use crate::{store_add_flags, write_blob};
+
+    const DAY: Duration = Duration::from_secs(24 * 3600);
+
+    /// Deliver `n` messages to `user` and backdate all but the last `fresh` by two days.
+    async fn deliver(store: &MailboxStore, user: &str, n: usize, fresh: usize) {
+        store.init_user_dir(user).await.unwrap();
+        let old = SystemTime::now() - 2 * DAY;
+        for i in 0..n {
+            // Distinct bodies: CAS dedup would otherwise share one inode (and mtime).
+            let id = format!("{user}-{i}");
+            let body = format!("Subject: {id}\r\n\r\nhi");
+            let path = write_blob(store, user, &id, body.as_bytes()).await.unwrap();
+            if i < n - fresh {
+                set_file_mtime(&path, FileTime::from_system_time(old)).unwrap();
+            }
+        }
+    }
+
+    #[tokio::test]
+    async fn reports_only_accounts_over_threshold() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        deliver(&store, "idle@test", 5, 1).await;
+        deliver(&store, "busy@test", 3, 0).await;
+        deliver(&store, "fetched@test", 4, 0).await;
+        deliver(&store, "new@test", 6, 6).await;
+
+        // Client fetched everything but one; the seen ones no longer count.
+        for i in 0..3 {
+            store_add_flags(
+                &store,
+                "fetched@test",
+                "INBOX",
+                &format!("fetched@test-{i}"),
+                true,
+                false,
+            )
+            .await
+            .unwrap();
+        }
+        // Expunged messages leave the backlog too.
+        store_add_flags(&store, "busy@test", "INBOX", "busy@test-0", false, true)
+            .await
+            .unwrap();
+
+        let report = inbox_backlog(&store, DAY, 2).await.unwrap();
+        assert_eq!(report.scanned, 4);
+        let got: Vec<_> = report
+            .accounts
+            .iter()
+            .map(|a| (a.username.as_str(), a.stale))
+            .collect();
+        assert_eq!(got, vec![("idle@test", 4), ("busy@test", 2)]);
+        assert_eq!(report.stale, 6);
+        assert!(report.stale_bytes > 0);
+        assert!(report.accounts[0].oldest > 0);
+
+        let all = inbox_backlog(&store, DAY, 1).await.unwrap();
+        let names: Vec<_> = all.accounts.iter().map(|a| a.username.as_str()).collect();
+        assert_eq!(names, vec!["idle@test", "busy@test", "fetched@test"]);
+
+        let none = inbox_backlog(&store, 3 * DAY, 1).await.unwrap();
+        assert!(none.accounts.is_empty());
+        assert_eq!(none.stale, 0);
+    }
+
+    #[tokio::test]
+    async fn empty_state_dir_reports_nothing() {
+        let tmp = tempfile::tempdir().unwrap();
+        let report = inbox_backlog(&MailboxStore::new(tmp.path()), DAY, 1)
+            .await
+            .unwrap();
+        assert_eq!(report.scanned, 0);
+        assert!(report.accounts.is_empty());
+    }
+}
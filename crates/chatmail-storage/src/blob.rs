@@ -24,6 +24,7 @@ use crate::cas::{
     hash_bytes, stream_to_file_no_hash, stream_to_file_no_hash_largebuf, stream_to_tmp_with_hash,
     BlobHash,
 };
+use crate::cold::{read_if_cold, read_message_file};
 use crate::delivery_batch::PendingDelivery;
 use crate::maildir::MailboxStore;
 use crate::maildir_message::split_maildir_filename;
@@ -44,6 +45,10 @@ pub fn never_delivery_batcher_coordinator_count() -> usize {
     never_batcher().coordinator_count()
 }
 
+/// Recipients hard-linked concurrently during local fan-out. Large groups are processed in
+/// chunks of this size so at most this many INBOX uidlist locks are held at once.
+pub const LOCAL_FANOUT_CHUNK: usize = 16;
+
 /// Per-recipient result of a local fan-out delivery.
 #[derive(Debug, Default)]
 pub struct DeliveryOutcome {
@@ -91,13 +96,32 @@ pub async fn deliver_local_messages(
         ));
     };
 
-    for (user, msg_id) in deliveries.iter().skip(linked_from) {
-        match link_into_inbox(store, user, msg_id, &canonical).await {
-            Ok(_) => outcome.delivered.push((user.clone(), msg_id.clone())),
-            Err(e) => {
-                outcome
-                    .failed
-                    .push((user.clone(), msg_id.clone(), e.to_string()));
+    let canonical = std::sync::Arc::new(canonical);
+    for chunk in deliveries[linked_from..].chunks(LOCAL_FANOUT_CHUNK) {
+        let mut set = tokio::task::JoinSet::new();
+        for (idx, (user, msg_id)) in chunk.iter().enumerate() {
+            let (store, canonical) = (store.clone(), std::sync::Arc::clone(&canonical));
+            let (user, msg_id) = (user.clone(), msg_id.clone());
+            set.spawn(async move {
+                let res = link_into_inbox(&store, &user, &msg_id, &canonical).await;
+                (idx, res)
+            });
+        }
+        let mut results: Vec<(usize, Result<PathBuf>)> = Vec::with_capacity(chunk.len());
+        while let Some(joined) = set.join_next().await {
+            results.push(joined.map_err(|e| ChatmailError::storage(e.to_string()))?);
+        }
+        // Report in recipient order regardless of completion order.
+        results.sort_by_key(|(idx, _)| *idx);
+        for (idx, res) in results {
+            let (user, msg_id) = &chunk[idx];
+            match res {
+                Ok(_) => outcome.delivered.push((user.clone(), msg_id.clone())),
+                Err(e) => {
+                    outcome
+                        .failed
+                        .push((user.clone(), msg_id.clone(), e.to_string()));
+                }
             }
         }
     }
@@ -487,10 +511,8 @@ pub async fn read_blob_known(
 ) -> Result<Option<Vec<u8>>> {
     let paths = store.maildir_for_mailbox(user, mailbox);
     for dir in [&paths.new, &paths.cur] {
-        match tokio::fs::read(dir.join(filename)).await {
-            Ok(bytes) => return Ok(Some(bytes)),
-            Err(e) if e.kind() == std::io::ErrorKind::NotFound => continue,
-            Err(e) => return Err(ChatmailError::from(e)),
+        if let Some(bytes) = read_message_file(store, user, mailbox, &dir.join(filename)).await? {
+            return Ok(Some(bytes));
         }
     }
     Ok(None)
@@ -507,11 +529,20 @@ pub async fn read_blob_range_known(
     use tokio::io::{AsyncReadExt, AsyncSeekExt};
     let paths = store.maildir_for_mailbox(user, mailbox);
     for dir in [&paths.new, &paths.cur] {
-        let mut file = match tokio::fs::File::open(dir.join(filename)).await {
+        let path = dir.join(filename);
+        let mut file = match tokio::fs::File::open(&path).await {
             Ok(f) => f,
             Err(e) if e.kind() == std::io::ErrorKind::NotFound => continue,
             Err(e) => return Err(ChatmailError::from(e)),
         };
+        if let Some(body) = read_if_cold(store, user, mailbox, &path, &mut file).await? {
+            let start = (offset as usize).min(body.len());
+            let end = match count {
+                Some(c) => start.saturating_add(c as usize).min(body.len()),
+                None => body.len(),
+            };
+            return Ok(Some(body[start..end].to_vec()));
+        }
         let len = file.metadata().await.map_err(ChatmailError::from)?.len();
         if offset >= len {
             return Ok(Some(Vec::new()));
@@ -551,7 +582,9 @@ pub async fn read_blob(
             let name = ent.file_name().to_string_lossy().into_owned();
             let (base, _) = split_maildir_filename(&name);
             if base == msg_id {
-                return Ok(tokio::fs::read(ent.path()).await?);
+                if let Some(bytes) = read_message_file(store, user, mailbox, &ent.path()).await? {
+                    return Ok(bytes);
+                }
             }
         }
     }
@@ -622,6 +655,7 @@ mod tests {
             fsync_mode: FsyncMode::Never,
             cas_enabled: true,
             stream_threshold: 1, // force streaming even for small test data
+            cold: None,
         };
         let store = MailboxStore::with_policy(tmp.path(), policy);
 
@@ -661,6 +695,37 @@ mod tests {
         let _ = content_store; // silence unused for this focused test
     }
 
+    #[tokio::test]
+    async fn deliver_local_messages_fans_out_in_chunks() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let body = b"From: a@b.test\r\nSubject: big group\r\n\r\nPGP body";
+        let n = LOCAL_FANOUT_CHUNK * 2 + 5;
+        let mut deliveries: Vec<(String, String)> = (0..n)
+            .map(|i| (format!("u{i}@test"), format!("msg-{i}")))
+            .collect();
+        // A duplicate id inside the second chunk fails alone without stopping the rest.
+        let dup = LOCAL_FANOUT_CHUNK + 3;
+        deliveries.insert(dup + 1, deliveries[dup].clone());
+
+        let outcome = deliver_local_messages(&store, &deliveries, body)
+            .await
+            .unwrap();
+        assert_eq!(outcome.delivered.len(), n);
+        assert_eq!(outcome.failed.len(), 1);
+        assert_eq!(outcome.failed[0].0, format!("u{dup}@test"));
+        let expected: Vec<_> = (0..n)
+            .map(|i| (format!("u{i}@test"), format!("msg-{i}")))
+            .collect();
+        assert_eq!(outcome.delivered, expected, "recipient order preserved");
+        for (user, msg_id) in &expected {
+            assert_eq!(
+                read_blob(&store, user, "INBOX", msg_id).await.unwrap(),
+                body
+            );
+        }
+    }
+
     #[tokio::test]
     async fn deliver_local_messages_hardlinks_body() {
         let tmp = tempfile::tempdir().unwrap();
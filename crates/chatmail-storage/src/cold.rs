@@ -0,0 +1,1086 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Cold storage tier for old message bodies (`cold_storage` in `storage.imapsql`).
+//!
+//! A background job moves message files older than the configured age out of the maildir into
+//! per-account pack files under `{cold root}/{user}/`. Each pack holds the bodies back to back,
+//! each one optionally gzip-compressed, followed by an index. The maildir file is replaced by a
+//! *stub*: a short pointer header (`\0MADMAIL-COLD\0v1 {pack} {id} {len}`) padded with a sparse
+//! hole up to the original length. The name, flags, mtime and `stat` size do not change, so
+//! listings, UIDs, `RFC822.SIZE` and quota still see the logical message.
+//!
+//! Readers in [`crate::blob`] open the file as before and, on a stub, read the entry from its
+//! pack. With `promote_on_read` the body is then written back as a regular file.
+//!
+//! Crash safety: a pack is written to `*.tmp`, fsynced and renamed into place before any stub
+//! exists; a stub is fsynced in the mailbox `tmp/` before it replaces the hot file. The swap
+//! holds the mailbox index lock and parks the old file in `tmp/` until the new one is in place,
+//! so an interrupted swap is rolled back or finished by the next run.
+//!
+//! Packs are never appended to. An entry is dead once no stub points at it (the message was
+//! expunged, purged or promoted). When a pack's dead share reaches `compact_dead_percent` it is
+//! rewritten with only the live entries under the same name and entry ids, so stubs stay valid.
+
+use std::collections::{HashMap, HashSet};
+use std::io::{Read, Write};
+use std::path::{Path, PathBuf};
+use std::time::{Duration, SystemTime};
+
+use chatmail_types::{ChatmailError, Result};
+use tokio::fs;
+use tokio::io::{AsyncReadExt, AsyncSeekExt, AsyncWriteExt};
+
+use crate::maildir::{fsync_dir, MailboxStore};
+use crate::migrate::list_user_mailboxes;
+
+/// First bytes of a stub. A delivered RFC 5322 message never starts with NUL.
+const STUB_MAGIC: &[u8] = b"\0MADMAIL-COLD\0";
+/// Enough to hold any stub header.
+const STUB_HEAD_LEN: usize = 256;
+/// Smaller messages stay hot: a stub would take the same filesystem block.
+pub const COLD_MIN_BYTES: u64 = 4096;
+/// A run starts a new pack after this many stored bytes.
+pub const PACK_TARGET_BYTES: u64 = 64 * 1024 * 1024;
+/// Packs younger than this are never compacted (a concurrent run may still be placing stubs).
+pub const COMPACT_MIN_AGE: Duration = Duration::from_secs(3600);
+
+const PACK_MAGIC: &[u8; 8] = b"MMPACK01";
+const INDEX_MAGIC: &[u8; 8] = b"MMPACKIX";
+/// `u32` entry count, `u64` index offset, index magic.
+const TRAILER_LEN: u64 = 4 + 8 + 8;
+/// `u32` id, `u64` offset, `u64` stored length, `u64` logical length, `u32` CRC-32.
+const INDEX_ENTRY_LEN: usize = 4 + 8 + 8 + 8 + 4;
+const PACK_EXT: &str = "pack";
+/// Old file parked in `tmp/` while its replacement moves in: `.cold-swap-{cur|new}-{name}`.
+const SWAP_PREFIX: &str = ".cold-swap-";
+/// Replacement (stub or promoted body) being written in `tmp/`.
+const STAGING_PREFIX: &str = ".cold-staging-";
+
+/// How pack entries are stored.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum PackCompression {
+    None,
+    #[default]
+    Gzip,
+}
+
+impl PackCompression {
+    pub fn parse(s: &str) -> Option<Self> {
+        match s.trim().to_ascii_lowercase().as_str() {
+            "none" | "off" | "no" => Some(Self::None),
+            "gzip" | "deflate" | "on" | "yes" => Some(Self::Gzip),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::None => "none",
+            Self::Gzip => "gzip",
+        }
+    }
+
+    fn tag(self) -> u8 {
+        match self {
+            Self::None => 0,
+            Self::Gzip => 1,
+        }
+    }
+
+    fn from_tag(tag: u8) -> Option<Self> {
+        match tag {
+            0 => Some(Self::None),
+            1 => Some(Self::Gzip),
+            _ => None,
+        }
+    }
+}
+
+/// Settings of the cold tier; `None` on [`crate::StoragePolicy::cold`] keeps all mail hot.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ColdPolicy {
+    /// Directory holding one pack directory per account.
+    pub root: PathBuf,
+    /// Messages whose internal date (file mtime) is older than this move to packs.
+    pub min_age: Duration,
+    pub compression: PackCompression,
+    /// Write a cold message back into the maildir when it is read.
+    pub promote_on_read: bool,
+    /// Rewrite a pack once this share of its stored bytes is dead (1–100).
+    pub compact_dead_percent: u8,
+}
+
+/// What one [`run_cold_storage`] pass did.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ColdReport {
+    /// Messages replaced by stubs.
+    pub moved: usize,
+    /// Logical bytes of those messages.
+    pub moved_bytes: u64,
+    /// Bytes their entries take in packs.
+    pub packed_bytes: u64,
+    pub packs_rewritten: usize,
+    pub packs_removed: usize,
+    /// Pack bytes given back by rewrites and removals.
+    pub reclaimed_bytes: u64,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct ColdPointer {
+    pack: String,
+    id: u32,
+    len: u64,
+}
+
+impl ColdPointer {
+    fn header(&self) -> Vec<u8> {
+        let mut out = STUB_MAGIC.to_vec();
+        out.extend_from_slice(format!("v1 {} {} {}\n", self.pack, self.id, self.len).as_bytes());
+        out
+    }
+
+    fn parse(head: &[u8]) -> Option<Self> {
+        let rest = head.strip_prefix(STUB_MAGIC)?;
+        let end = rest.iter().position(|&b| b == b'\n')?;
+        let line = std::str::from_utf8(&rest[..end]).ok()?;
+        let mut parts = line.split(' ');
+        if parts.next()? != "v1" {
+            return None;
+        }
+        let pack = parts.next()?.to_string();
+        let id = parts.next()?.parse().ok()?;
+        let len = parts.next()?.parse().ok()?;
+        if parts.next().is_some() || !valid_pack_name(&pack) {
+            return None;
+        }
+        Some(Self { pack, id, len })
+    }
+}
+
+/// Pack names are generated hex/dash strings; anything else could escape the account directory.
+fn valid_pack_name(name: &str) -> bool {
+    !name.is_empty() && name.len() <= 64 && name.bytes().all(|b| b.is_ascii_hexdigit() || b == b'-')
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct IndexEntry {
+    id: u32,
+    offset: u64,
+    stored: u64,
+    len: u64,
+    crc: u32,
+}
+
+fn pack_path(store: &MailboxStore, user: &str, pack: &str) -> PathBuf {
+    store.cold_dir(user).join(format!("{pack}.{PACK_EXT}"))
+}
+
+/// Read up to [`STUB_HEAD_LEN`] bytes and rewind; `Some` when the file is a stub.
+async fn stub_pointer(file: &mut fs::File) -> Result<Option<ColdPointer>> {
+    let mut head = vec![0u8; STUB_HEAD_LEN];
+    let mut filled = 0;
+    while filled < head.len() {
+        let n = file.read(&mut head[filled..]).await?;
+        if n == 0 {
+            break;
+        }
+        filled += n;
+    }
+    file.seek(std::io::SeekFrom::Start(0)).await?;
+    Ok(ColdPointer::parse(&head[..filled]))
+}
+
+/// Whole contents of the maildir file at `path`, through its pack when it is a stub.
+/// `None` when the file does not exist.
+pub(crate) async fn read_message_file(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    path: &Path,
+) -> Result<Option<Vec<u8>>> {
+    let mut file = match fs::File::open(path).await {
+        Ok(f) => f,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(None),
+        Err(e) => return Err(e.into()),
+    };
+    if let Some(ptr) = stub_pointer(&mut file).await? {
+        drop(file);
+        return load_cold(store, user, mailbox, path, &ptr).await.map(Some);
+    }
+    let mut body = Vec::new();
+    file.read_to_end(&mut body).await?;
+    Ok(Some(body))
+}
+
+/// Like [`read_message_file`] for an already opened file; `None` when it is not a stub.
+pub(crate) async fn read_if_cold(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    path: &Path,
+    file: &mut fs::File,
+) -> Result<Option<Vec<u8>>> {
+    match stub_pointer(file).await? {
+        Some(ptr) => load_cold(store, user, mailbox, path, &ptr).await.map(Some),
+        None => Ok(None),
+    }
+}
+
+async fn load_cold(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    path: &Path,
+    ptr: &ColdPointer,
+) -> Result<Vec<u8>> {
+    let body = read_pack_entry(&pack_path(store, user, &ptr.pack), ptr.id).await?;
+    if body.len() as u64 != ptr.len {
+        return Err(ChatmailError::storage(format!(
+            "cold entry {}#{} is {} bytes, stub says {}",
+            ptr.pack,
+            ptr.id,
+            body.len(),
+            ptr.len
+        )));
+    }
+    if store
+        .policy()
+        .cold
+        .as_ref()
+        .is_some_and(|c| c.promote_on_read)
+    {
+        // Best effort: the body is already in hand, a failed promotion is retried next read.
+        let _ = promote(store, user, mailbox, path, &body).await;
+    }
+    Ok(body)
+}
+
+async fn read_index(file: &mut fs::File) -> Result<(PackCompression, Vec<IndexEntry>)> {
+    let corrupt = || ChatmailError::storage("corrupt cold pack");
+    let mut head = [0u8; 9];
+    file.seek(std::io::SeekFrom::Start(0)).await?;
+    file.read_exact(&mut head).await?;
+    if &head[..8] != PACK_MAGIC {
+        return Err(corrupt());
+    }
+    let compression = PackCompression::from_tag(head[8]).ok_or_else(corrupt)?;
+
+    let size = file.metadata().await?.len();
+    if size < head.len() as u64 + TRAILER_LEN {
+        return Err(corrupt());
+    }
+    let mut trailer = [0u8; TRAILER_LEN as usize];
+    file.seek(std::io::SeekFrom::Start(size - TRAILER_LEN))
+        .await?;
+    file.read_exact(&mut trailer).await?;
+    if &trailer[12..] != INDEX_MAGIC {
+        return Err(corrupt());
+    }
+    let count = u32::from_le_bytes(trailer[..4].try_into().unwrap()) as usize;
+    let index_at = u64::from_le_bytes(trailer[4..12].try_into().unwrap());
+    if index_at + (count * INDEX_ENTRY_LEN) as u64 + TRAILER_LEN != size {
+        return Err(corrupt());
+    }
+    let mut raw = vec![0u8; count * INDEX_ENTRY_LEN];
+    file.seek(std::io::SeekFrom::Start(index_at)).await?;
+    file.read_exact(&mut raw).await?;
+    let entries = raw
+        .chunks_exact(INDEX_ENTRY_LEN)
+        .map(|c| IndexEntry {
+            id: u32::from_le_bytes(c[0..4].try_into().unwrap()),
+            offset: u64::from_le_bytes(c[4..12].try_into().unwrap()),
+            stored: u64::from_le_bytes(c[12..20].try_into().unwrap()),
+            len: u64::from_le_bytes(c[20..28].try_into().unwrap()),
+            crc: u32::from_le_bytes(c[28..32].try_into().unwrap()),
+        })
+        .collect();
+    Ok((compression, entries))
+}
+
+async fn read_stored(file: &mut fs::File, entry: &IndexEntry) -> Result<Vec<u8>> {
+    let mut stored = vec![0u8; entry.stored as usize];
+    file.seek(std::io::SeekFrom::Start(entry.offset)).await?;
+    file.read_exact(&mut stored).await?;
+    Ok(stored)
+}
+
+async fn read_pack_entry(path: &Path, id: u32) -> Result<Vec<u8>> {
+    let mut file = fs::File::open(path).await?;
+    let (compression, entries) = read_index(&mut file).await?;
+    let entry = entries
+        .iter()
+        .find(|e| e.id == id)
+        .copied()
+        .ok_or_else(|| ChatmailError::storage(format!("cold entry #{id} missing from pack")))?;
+    let stored = read_stored(&mut file, &entry).await?;
+    let body = tokio::task::spawn_blocking(move || decompress(compression, stored))
+        .await
+        .map_err(|e| ChatmailError::storage(e.to_string()))??;
+    if body.len() as u64 != entry.len || crc32fast::hash(&body) != entry.crc {
+        return Err(ChatmailError::storage(format!(
+            "cold entry #{id} failed its checksum"
+        )));
+    }
+    Ok(body)
+}
+
+fn compress(compression: PackCompression, body: &[u8]) -> Result<Vec<u8>> {
+    match compression {
+        PackCompression::None => Ok(body.to_vec()),
+        PackCompression::Gzip => {
+            let mut enc = flate2::write::GzEncoder::new(Vec::new(), flate2::Compression::default());
+            enc.write_all(body)?;
+            Ok(enc.finish()?)
+        }
+    }
+}
+
+fn decompress(compression: PackCompression, stored: Vec<u8>) -> Result<Vec<u8>> {
+    match compression {
+        PackCompression::None => Ok(stored),
+        PackCompression::Gzip => {
+            let mut body = Vec::new();
+            flate2::read::GzDecoder::new(stored.as_slice()).read_to_end(&mut body)?;
+            Ok(body)
+        }
+    }
+}
+
+/// Streams entries into `{name}.pack.tmp`; [`finish`](Self::finish) appends the index, fsyncs
+/// and renames it into place.
+struct PackWriter {
+    dir: PathBuf,
+    name: String,
+    file: fs::File,
+    compression: PackCompression,
+    offset: u64,
+    entries: Vec<IndexEntry>,
+}
+
+impl PackWriter {
+    async fn create(dir: &Path, name: &str, compression: PackCompression) -> Result<Self> {
+        fs::create_dir_all(dir).await?;
+        let mut file = fs::File::create(dir.join(format!("{name}.{PACK_EXT}.tmp"))).await?;
+        file.write_all(PACK_MAGIC).await?;
+        file.write_all(&[compression.tag()]).await?;
+        Ok(Self {
+            dir: dir.to_path_buf(),
+            name: name.to_string(),
+            file,
+            compression,
+            offset: PACK_MAGIC.len() as u64 + 1,
+            entries: Vec::new(),
+        })
+    }
+
+    /// Compress and append one body; returns its entry id.
+    async fn add(&mut self, body: Vec<u8>) -> Result<u32> {
+        let compression = self.compression;
+        let (stored, len, crc) = tokio::task::spawn_blocking(move || {
+            compress(compression, &body).map(|s| (s, body.len() as u64, crc32fast::hash(&body)))
+        })
+        .await
+        .map_err(|e| ChatmailError::storage(e.to_string()))??;
+        let id = self.entries.len() as u32;
+        self.append(id, &stored, len, crc).await?;
+        Ok(id)
+    }
+
+    /// Append an entry copied verbatim from another pack (compaction keeps ids).
+    async fn append(&mut self, id: u32, stored: &[u8], len: u64, crc: u32) -> Result<()> {
+        self.file.write_all(stored).await?;
+        self.entries.push(IndexEntry {
+            id,
+            offset: self.offset,
+            stored: stored.len() as u64,
+            len,
+            crc,
+        });
+        self.offset += stored.len() as u64;
+        Ok(())
+    }
+
+    fn stored_bytes(&self) -> u64 {
+        self.entries.iter().map(|e| e.stored).sum()
+    }
+
+    async fn finish(mut self) -> Result<u64> {
+        let mut index = Vec::with_capacity(self.entries.len() * INDEX_ENTRY_LEN + 20);
+        for e in &self.entries {
+            index.extend_from_slice(&e.id.to_le_bytes());
+            index.extend_from_slice(&e.offset.to_le_bytes());
+            index.extend_from_slice(&e.stored.to_le_bytes());
+            index.extend_from_slice(&e.len.to_le_bytes());
+            index.extend_from_slice(&e.crc.to_le_bytes());
+        }
+        index.extend_from_slice(&(self.entries.len() as u32).to_le_bytes());
+        index.extend_from_slice(&self.offset.to_le_bytes());
+        index.extend_from_slice(INDEX_MAGIC);
+        self.file.write_all(&index).await?;
+        self.file.flush().await?;
+        // Always durable, whatever `mail_fsync` says: stubs will point here.
+        self.file.sync_all().await?;
+        let size = self.offset + index.len() as u64;
+        let tmp = self.dir.join(format!("{}.{PACK_EXT}.tmp", self.name));
+        fs::rename(&tmp, self.dir.join(format!("{}.{PACK_EXT}", self.name))).await?;
+        fsync_dir(&self.dir).await?;
+        Ok(size)
+    }
+}
+
+fn new_pack_name() -> String {
+    let secs = SystemTime::now()
+        .duration_since(SystemTime::UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0);
+    let rand = uuid::Uuid::new_v4().simple().to_string();
+    format!("{secs:x}-{}", &rand[..8])
+}
+
+/// Replace the maildir file at `path` with `staged` (a file in the mailbox `tmp/`).
+///
+/// Holds the mailbox index lock, so no listing sees the message missing. The old file is
+/// parked in `tmp/` first; a flag change racing the swap fails once instead of leaving two
+/// copies. Returns `false` (and drops `staged`) when `path` is already gone.
+///
+/// [`recover_swaps`] takes the same lock, so it never sees a swap half done.
+async fn swap_in(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    path: &Path,
+    staged: &Path,
+) -> Result<bool> {
+    let paths = store.maildir_for_mailbox(user, mailbox);
+    let (Some(subdir), Some(name)) = (path.parent().and_then(|p| p.file_name()), path.file_name())
+    else {
+        return Err(ChatmailError::storage("bad maildir path"));
+    };
+    let parked = paths.tmp.join(format!(
+        "{SWAP_PREFIX}{}-{}",
+        subdir.to_string_lossy(),
+        name.to_string_lossy()
+    ));
+
+    let _guard = store.uidlist().lock_mailbox(user, mailbox).await;
+    match fs::rename(path, &parked).await {
+        Ok(()) => {}
+        Err(e) => {
+            let _ = fs::remove_file(staged).await;
+            return match e.kind() {
+                std::io::ErrorKind::NotFound => Ok(false),
+                _ => Err(e.into()),
+            };
+        }
+    }
+    if let Err(e) = fs::rename(staged, path).await {
+        let _ = fs::rename(&parked, path).await;
+        return Err(e.into());
+    }
+    if let Some(dir) = path.parent() {
+        fsync_dir(dir).await?;
+    }
+    fs::remove_file(&parked).await?;
+    store.invalidate_mailbox_listing(user, mailbox);
+    Ok(true)
+}
+
+/// Finish or roll back swaps a crash interrupted, and drop half-written staging files.
+async fn recover_swaps(store: &MailboxStore, user: &str, mailbox: &str) -> Result<()> {
+    let paths = store.maildir_for_mailbox(user, mailbox);
+    let _guard = store.uidlist().lock_mailbox(user, mailbox).await;
+    let mut rd = match fs::read_dir(&paths.tmp).await {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(()),
+        Err(e) => return Err(e.into()),
+    };
+    while let Some(ent) = rd.next_entry().await? {
+        let name = ent.file_name().to_string_lossy().into_owned();
+        if name.starts_with(STAGING_PREFIX) {
+            fs::remove_file(ent.path()).await?;
+            continue;
+        }
+        let Some(rest) = name.strip_prefix(SWAP_PREFIX) else {
+            continue;
+        };
+        let Some((subdir, file)) = rest.split_once('-') else {
+            continue;
+        };
+        let target = match subdir {
+            "cur" => paths.cur.join(file),
+            "new" => paths.new.join(file),
+            _ => continue,
+        };
+        if fs::try_exists(&target).await? {
+            // The replacement landed; the parked copy is redundant.
+            fs::remove_file(ent.path()).await?;
+        } else {
+            fs::rename(ent.path(), &target).await?;
+            store.invalidate_mailbox_listing(user, mailbox);
+        }
+    }
+    Ok(())
+}
+
+fn staging_path(store: &MailboxStore, user: &str, mailbox: &str) -> PathBuf {
+    store
+        .maildir_for_mailbox(user, mailbox)
+        .tmp
+        .join(format!("{STAGING_PREFIX}{}", uuid::Uuid::new_v4().simple()))
+}
+
+/// Write `contents` then grow the file to `len` (a sparse hole for stubs), keeping `mtime`.
+async fn write_staged(path: &Path, contents: &[u8], len: u64, mtime: SystemTime) -> Result<()> {
+    let mut file = fs::File::create(path).await?;
+    file.write_all(contents).await?;
+    file.flush().await?;
+    let file = file.into_std().await;
+    file.set_len(len)?;
+    file.set_modified(mtime)?;
+    file.sync_all()?;
+    Ok(())
+}
+
+async fn promote(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    path: &Path,
+    body: &[u8],
+) -> Result<()> {
+    let mtime = fs::metadata(path).await?.modified()?;
+    let staged = staging_path(store, user, mailbox);
+    write_staged(&staged, body, body.len() as u64, mtime).await?;
+    swap_in(store, user, mailbox, path, &staged).await?;
+    Ok(())
+}
+
+/// A hot message old enough for the cold tier.
+struct Candidate {
+    mailbox: String,
+    path: PathBuf,
+    len: u64,
+    mtime: SystemTime,
+}
+
+/// One pass over every stub of `user`: live entry ids per pack, plus hot messages older than
+/// `cutoff` (when given).
+async fn scan_user(
+    store: &MailboxStore,
+    user: &str,
+    cutoff: Option<SystemTime>,
+) -> Result<(HashMap<String, HashSet<u32>>, Vec<Candidate>)> {
+    let mut live: HashMap<String, HashSet<u32>> = HashMap::new();
+    let mut candidates = Vec::new();
+    for mailbox in list_user_mailboxes(store, user).await? {
+        let paths = store.maildir_for_mailbox(user, &mailbox);
+        for dir in [&paths.new, &paths.cur] {
+            let mut rd = match fs::read_dir(dir).await {
+                Ok(rd) => rd,
+                Err(e) if e.kind() == std::io::ErrorKind::NotFound => continue,
+                Err(e) => return Err(e.into()),
+            };
+            while let Some(ent) = rd.next_entry().await? {
+                let Ok(meta) = ent.metadata().await else {
+                    continue;
+                };
+                // Stubs are never shorter than this, whatever their age.
+                if !meta.is_file() || meta.len() < COLD_MIN_BYTES {
+                    continue;
+                }
+                let Ok(mut file) = fs::File::open(ent.path()).await else {
+                    continue;
+                };
+                if let Some(ptr) = stub_pointer(&mut file).await? {
+                    live.entry(ptr.pack).or_default().insert(ptr.id);
+                    continue;
+                }
+                let mtime = meta.modified()?;
+                if cutoff.is_some_and(|c| mtime <= c) {
+                    candidates.push(Candidate {
+                        mailbox: mailbox.clone(),
+                        path: ent.path(),
+                        len: meta.len(),
+                        mtime,
+                    });
+                }
+            }
+        }
+    }
+    Ok((live, candidates))
+}
+
+/// Pack `candidates` and replace each with a stub. Packs are fsynced before any stub exists.
+async fn move_to_cold(
+    store: &MailboxStore,
+    policy: &ColdPolicy,
+    user: &str,
+    candidates: Vec<Candidate>,
+    report: &mut ColdReport,
+) -> Result<()> {
+    let dir = store.cold_dir(user);
+    let mut writer: Option<PackWriter> = None;
+    let mut pending: Vec<(Candidate, u32)> = Vec::new();
+    for c in candidates {
+        let body = match fs::read(&c.path).await {
+            Ok(b) => b,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => continue,
+            Err(e) => return Err(e.into()),
+        };
+        if writer.is_none() {
+            writer = Some(PackWriter::create(&dir, &new_pack_name(), policy.compression).await?);
+        }
+        let w = writer.as_mut().expect("writer just created");
+        let id = w.add(body).await?;
+        pending.push((c, id));
+        if w.stored_bytes() >= PACK_TARGET_BYTES {
+            let w = writer.take().expect("writer in use");
+            place_stubs(store, user, w, &mut pending, report).await?;
+        }
+    }
+    if let Some(w) = writer {
+        place_stubs(store, user, w, &mut pending, report).await?;
+    }
+    Ok(())
+}
+
+/// Make the pack durable, then point each of its messages at it.
+async fn place_stubs(
+    store: &MailboxStore,
+    user: &str,
+    writer: PackWriter,
+    pending: &mut Vec<(Candidate, u32)>,
+    report: &mut ColdReport,
+) -> Result<()> {
+    let (name, stored) = (writer.name.clone(), writer.stored_bytes());
+    writer.finish().await?;
+    report.packed_bytes += stored;
+    for (c, id) in pending.drain(..) {
+        let ptr = ColdPointer {
+            pack: name.clone(),
+            id,
+            len: c.len,
+        };
+        let staged = staging_path(store, user, &c.mailbox);
+        write_staged(&staged, &ptr.header(), c.len, c.mtime).await?;
+        // A message expunged or renamed since the scan leaves a dead entry for compaction.
+        if swap_in(store, user, &c.mailbox, &c.path, &staged).await? {
+            report.moved += 1;
+            report.moved_bytes += c.len;
+        }
+    }
+    Ok(())
+}
+
+async fn pack_names(dir: &Path) -> Result<Vec<String>> {
+    let mut names = Vec::new();
+    let mut rd = match fs::read_dir(dir).await {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(names),
+        Err(e) => return Err(e.into()),
+    };
+    while let Some(ent) = rd.next_entry().await? {
+        let name = ent.file_name().to_string_lossy().into_owned();
+        if let Some(stem) = name.strip_suffix(&format!(".{PACK_EXT}")) {
+            names.push(stem.to_string());
+        } else if name.ends_with(&format!(".{PACK_EXT}.tmp"))
+            && older_than(&ent.path(), COMPACT_MIN_AGE).await
+        {
+            // A pack a crashed run never finished; no stub can point at it.
+            fs::remove_file(ent.path()).await?;
+        }
+    }
+    names.sort();
+    Ok(names)
+}
+
+async fn older_than(path: &Path, age: Duration) -> bool {
+    fs::metadata(path)
+        .await
+        .and_then(|m| m.modified())
+        .ok()
+        .and_then(|t| t.elapsed().ok())
+        .is_some_and(|e| e >= age)
+}
+
+/// Rewrite or remove packs whose dead share reached `compact_dead_percent`.
+///
+/// `live` comes from a scan that may miss a stub being moved between mailboxes, so an entry
+/// only counts as dead when a second scan agrees.
+async fn compact_user(
+    store: &MailboxStore,
+    policy: &ColdPolicy,
+    user: &str,
+    live: &HashMap<String, HashSet<u32>>,
+    report: &mut ColdReport,
+) -> Result<()> {
+    let dir = store.cold_dir(user);
+    let empty = HashSet::new();
+    let mut due = Vec::new();
+    for name in pack_names(&dir).await? {
+        let path = dir.join(format!("{name}.{PACK_EXT}"));
+        if !older_than(&path, COMPACT_MIN_AGE).await {
+            continue;
+        }
+        let mut file = fs::File::open(&path).await?;
+        let (_, entries) = read_index(&mut file).await?;
+        let ids = live.get(&name).unwrap_or(&empty);
+        if compaction_due(&entries, ids, policy.compact_dead_percent) {
+            due.push(name);
+        }
+    }
+    if due.is_empty() {
+        return Ok(());
+    }
+
+    let (second, _) = scan_user(store, user, None).await?;
+    for name in due {
+        let path = dir.join(format!("{name}.{PACK_EXT}"));
+        let mut file = fs::File::open(&path).await?;
+        let (compression, entries) = read_index(&mut file).await?;
+        let keep: HashSet<u32> = live
+            .get(&name)
+            .unwrap_or(&empty)
+            .union(second.get(&name).unwrap_or(&empty))
+            .copied()
+            .collect();
+        if !compaction_due(&entries, &keep, policy.compact_dead_percent) {
+            continue;
+        }
+        let before = file.metadata().await?.len();
+        let kept: Vec<IndexEntry> = entries
+            .iter()
+            .filter(|e| keep.contains(&e.id))
+            .copied()
+            .collect();
+        if kept.is_empty() {
+            drop(file);
+            fs::remove_file(&path).await?;
+            report.packs_removed += 1;
+            report.reclaimed_bytes += before;
+            continue;
+        }
+        let mut writer = PackWriter::create(&dir, &name, compression).await?;
+        for e in &kept {
+            let stored = read_stored(&mut file, e).await?;
+            writer.append(e.id, &stored, e.len, e.crc).await?;
+        }
+        drop(file);
+        let after = writer.finish().await?;
+        report.packs_rewritten += 1;
+        report.reclaimed_bytes += before.saturating_sub(after);
+    }
+    Ok(())
+}
+
+fn compaction_due(entries: &[IndexEntry], live: &HashSet<u32>, dead_percent: u8) -> bool {
+    let total: u64 = entries.iter().map(|e| e.stored).sum();
+    let dead: u64 = entries
+        .iter()
+        .filter(|e| !live.contains(&e.id))
+        .map(|e| e.stored)
+        .sum();
+    dead > 0 && (total == 0 || dead * 100 >= total * u64::from(dead_percent.clamp(1, 100)))
+}
+
+pub(crate) async fn dir_names(dir: &Path) -> Result<Vec<String>> {
+    let mut names = Vec::new();
+    let mut rd = match fs::read_dir(dir).await {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(names),
+        Err(e) => return Err(e.into()),
+    };
+    while let Some(ent) = rd.next_entry().await? {
+        let name = ent.file_name().to_string_lossy().into_owned();
+        if ent.file_type().await?.is_dir() && !name.starts_with('.') {
+            names.push(name);
+        }
+    }
+    names.sort();
+    Ok(names)
+}
+
+/// Move old mail of every account to packs and compact packs with dead entries.
+///
+/// Does nothing without [`crate::StoragePolicy::cold`]. Packs of accounts whose mail directory
+/// is gone are dropped, unless an account deletion is still in progress.
+pub async fn run_cold_storage(store: &MailboxStore) -> Result<ColdReport> {
+    let mut report = ColdReport::default();
+    let Some(policy) = store.policy().cold.clone() else {
+        return Ok(report);
+    };
+    let cutoff = SystemTime::now()
+        .checked_sub(policy.min_age)
+        .unwrap_or(SystemTime::UNIX_EPOCH);
+    let mail_root = store.state_dir().join("mail");
+    let users = dir_names(&mail_root).await?;
+    for user in &users {
+        for mailbox in list_user_mailboxes(store, user).await? {
+            recover_swaps(store, user, &mailbox).await?;
+        }
+        let (live, candidates) = scan_user(store, user, Some(cutoff)).await?;
+        compact_user(store, &policy, user, &live, &mut report).await?;
+        move_to_cold(store, &policy, user, candidates, &mut report).await?;
+    }
+
+    let deleting = deletions_in_progress(&mail_root).await?;
+    for user in dir_names(&store.cold_root()).await? {
+        let prefix = format!(".deleting-{user}-");
+        if users.contains(&user) || deleting.iter().any(|d| d.starts_with(&prefix)) {
+            continue;
+        }
+        let dir = store.cold_dir(&user);
+        for name in pack_names(&dir).await? {
+            let path = dir.join(format!("{name}.{PACK_EXT}"));
+            report.reclaimed_bytes += fs::metadata(&path).await.map(|m| m.len()).unwrap_or(0);
+            report.packs_removed += 1;
+        }
+        store.remove_cold(&user).await?;
+    }
+    Ok(report)
+}
+
+async fn deletions_in_progress(mail_root: &Path) -> Result<Vec<String>> {
+    let mut names = Vec::new();
+    let mut rd = match fs::read_dir(mail_root).await {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(names),
+        Err(e) => return Err(e.into()),
+    };
+    while let Some(ent) = rd.next_entry().await? {
+        let name = ent.file_name().to_string_lossy().into_owned();
+        if name.starts_with(".deleting-") {
+            names.push(name);
+        }
+    }
+    Ok(names)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::maildir_message::{expunge_deleted, list_mailbox_messages, store_add_flags};
+    use crate::storage_policy::StoragePolicy;
+    use crate::{read_blob, read_blob_range_known, write_blob};
+
+    const USER: &str = "u@test";
+
+    fn cold_store(dir: &Path, promote_on_read: bool) -> MailboxStore {
+        MailboxStore::with_policy(
+            dir,
+            StoragePolicy {
+                cold: Some(ColdPolicy {
+                    root: dir.join("cold"),
+                    min_age: Duration::from_secs(24 * 3600),
+                    compression: PackCompression::Gzip,
+                    promote_on_read,
+                    compact_dead_percent: 50,
+                }),
+                ..StoragePolicy::default()
+            },
+        )
+    }
+
+    fn body(n: usize) -> Vec<u8> {
+        let mut b = format!("From: a@test\r\nSubject: m{n}\r\n\r\n").into_bytes();
+        b.extend(vec![b'a' + (n % 26) as u8; 8192]);
+        b
+    }
+
+    /// Deliver a message and backdate it past `min_age`.
+    async fn old_message(store: &MailboxStore, id: &str, body: &[u8]) -> PathBuf {
+        let path = write_blob(store, USER, id, body).await.unwrap();
+        let week_ago = SystemTime::now() - Duration::from_secs(7 * 24 * 3600);
+        std::fs::File::options()
+            .write(true)
+            .open(&path)
+            .unwrap()
+            .set_modified(week_ago)
+            .unwrap();
+        path
+    }
+
+    /// Make every pack old enough to compact.
+    fn age_packs(store: &MailboxStore) {
+        let old = SystemTime::now() - 2 * COMPACT_MIN_AGE;
+        for ent in std::fs::read_dir(store.cold_dir(USER)).unwrap() {
+            let f = std::fs::File::options()
+                .write(true)
+                .open(ent.unwrap().path());
+            f.unwrap().set_modified(old).unwrap();
+        }
+    }
+
+    fn packs(store: &MailboxStore) -> Vec<PathBuf> {
+        std::fs::read_dir(store.cold_dir(USER))
+            .map(|rd| rd.map(|e| e.unwrap().path()).collect())
+            .unwrap_or_default()
+    }
+
+    #[tokio::test]
+    async fn old_mail_moves_to_a_pack_and_reads_back() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = cold_store(tmp.path(), false);
+        let (old, fresh) = (body(1), body(2));
+        let old_path = old_message(&store, "old", &old).await;
+        write_blob(&store, USER, "fresh", &fresh).await.unwrap();
+        write_blob(&store, USER, "tiny", b"From: a@test\r\n\r\nhi")
+            .await
+            .unwrap();
+        let listed = list_mailbox_messages(&store, USER, "INBOX").await.unwrap();
+
+        let report = run_cold_storage(&store).await.unwrap();
+        assert_eq!(report.moved, 1, "only the old, large message moves");
+        assert_eq!(report.moved_bytes, old.len() as u64);
+        assert!(
+            report.packed_bytes < old.len() as u64 / 4,
+            "gzip shrinks the body"
+        );
+        assert_eq!(packs(&store).len(), 1);
+
+        // Same name, size and mtime: listings, UIDs and quota do not change.
+        let meta = std::fs::metadata(&old_path).unwrap();
+        assert_eq!(meta.len(), old.len() as u64);
+        assert!(std::fs::read(&old_path).unwrap().starts_with(STUB_MAGIC));
+        let relisted = list_mailbox_messages(&store, USER, "INBOX").await.unwrap();
+        assert_eq!(
+            relisted.iter().map(|m| (m.uid, m.size)).collect::<Vec<_>>(),
+            listed.iter().map(|m| (m.uid, m.size)).collect::<Vec<_>>()
+        );
+
+        assert_eq!(read_blob(&store, USER, "INBOX", "old").await.unwrap(), old);
+        let name = old_path.file_name().unwrap().to_string_lossy().into_owned();
+        let part = read_blob_range_known(&store, USER, "INBOX", &name, 2, Some(5))
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(part, old[2..7]);
+        assert!(
+            std::fs::read(&old_path).unwrap().starts_with(STUB_MAGIC),
+            "no promotion"
+        );
+
+        // A second run finds nothing new to move.
+        assert_eq!(run_cold_storage(&store).await.unwrap().moved, 0);
+    }
+
+    #[tokio::test]
+    async fn promote_on_read_restores_the_hot_file() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = cold_store(tmp.path(), true);
+        let old = body(3);
+        let path = old_message(&store, "old", &old).await;
+        let mtime = std::fs::metadata(&path).unwrap().modified().unwrap();
+        run_cold_storage(&store).await.unwrap();
+
+        assert_eq!(read_blob(&store, USER, "INBOX", "old").await.unwrap(), old);
+        assert_eq!(
+            std::fs::read(&path).unwrap(),
+            old,
+            "hot again after the read"
+        );
+        assert_eq!(std::fs::metadata(&path).unwrap().modified().unwrap(), mtime);
+    }
+
+    #[tokio::test]
+    async fn expunged_entries_are_compacted_away() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = cold_store(tmp.path(), false);
+        let bodies: Vec<Vec<u8>> = (0..4).map(body).collect();
+        for (i, b) in bodies.iter().enumerate() {
+            old_message(&store, &format!("m{i}"), b).await;
+        }
+        run_cold_storage(&store).await.unwrap();
+        let pack = packs(&store).pop().unwrap();
+        let full = std::fs::metadata(&pack).unwrap().len();
+
+        // One of four expunged: 25% dead stays under the 50% threshold.
+        store_add_flags(&store, USER, "INBOX", "m0", false, true)
+            .await
+            .unwrap();
+        expunge_deleted(&store, USER, "INBOX").await.unwrap();
+        age_packs(&store);
+        let report = run_cold_storage(&store).await.unwrap();
+        assert_eq!(report.packs_rewritten, 0);
+
+        // Three of four dead: rewritten in place, the survivor still reads through its stub.
+        for id in ["m1", "m2"] {
+            store_add_flags(&store, USER, "INBOX", id, false, true)
+                .await
+                .unwrap();
+        }
+        let report = run_cold_storage(&store).await.unwrap();
+        assert_eq!(report.packs_rewritten, 1);
+        assert!(std::fs::metadata(&pack).unwrap().len() < full);
+        assert_eq!(
+            read_blob(&store, USER, "INBOX", "m3").await.unwrap(),
+            bodies[3]
+        );
+
+        // All dead: the pack goes.
+        store_add_flags(&store, USER, "INBOX", "m3", false, true)
+            .await
+            .unwrap();
+        age_packs(&store);
+        let report = run_cold_storage(&store).await.unwrap();
+        assert_eq!(report.packs_removed, 1);
+        assert!(packs(&store).is_empty());
+    }
+
+    #[tokio::test]
+    async fn interrupted_swap_is_rolled_back() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = cold_store(tmp.path(), false);
+        let old = body(5);
+        let path = old_message(&store, "old", &old).await;
+        let paths = store.maildir_for_user(USER);
+        let name = path.file_name().unwrap().to_string_lossy().into_owned();
+        let subdir = path
+            .parent()
+            .unwrap()
+            .file_name()
+            .unwrap()
+            .to_string_lossy()
+            .into_owned();
+        // Crash right after the hot file was parked, before the stub moved in.
+        let parked = paths.tmp.join(format!("{SWAP_PREFIX}{subdir}-{name}"));
+        std::fs::rename(&path, &parked).unwrap();
+        std::fs::write(paths.tmp.join(format!("{STAGING_PREFIX}x")), b"half").unwrap();
+
+        run_cold_storage(&store).await.unwrap();
+        assert!(!parked.exists());
+        assert_eq!(std::fs::read_dir(&paths.tmp).unwrap().count(), 0);
+        assert_eq!(read_blob(&store, USER, "INBOX", "old").await.unwrap(), old);
+        assert!(
+            std::fs::read(&path).unwrap().starts_with(STUB_MAGIC),
+            "moved on the same run"
+        );
+    }
+
+    #[test]
+    fn stub_pointer_rejects_paths_and_junk() {
+        let ptr = ColdPointer {
+            pack: "65f0a1b2-0c1d2e3f".into(),
+            id: 7,
+            len: 9000,
+        };
+        assert_eq!(ColdPointer::parse(&ptr.header()), Some(ptr));
+        assert!(ColdPointer::parse(b"\0MADMAIL-COLD\0v1 ../../x 0 9000\n").is_none());
+        assert!(ColdPointer::parse(b"From: a@test\r\n\r\nv1 abc 0 1\n").is_none());
+    }
+}
@@ -15,8 +15,10 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+pub mod backlog;
 pub mod blob;
 pub mod cas;
+pub mod cold;
 pub mod delivery_batch;
 pub mod external_store;
 pub mod fsync_batch;
@@ -24,10 +26,17 @@ pub mod inbox;
 pub mod maildir;
 pub mod maildir_cache;
 pub mod maildir_message;
+pub mod migrate;
 pub mod purge;
 pub mod storage_policy;
+pub mod takeout;
 pub mod uidlist;
+pub mod usage;
 
+pub use backlog::{
+    inbox_backlog, BacklogAccount, BacklogReport, DEFAULT_BACKLOG_MIN_AGE,
+    DEFAULT_BACKLOG_MIN_COUNT,
+};
 pub use external_store::{ExternalKey, ExternalStore, FsStore};
 pub use inbox::{list_inbox, InboxEntry};
 
@@ -35,16 +44,25 @@ pub use blob::{
     commit_mailbox_blob_from_tmp, delete_blob, deliver_local_messages,
     never_delivery_batcher_coordinator_count, read_blob, read_blob_known, read_blob_range_known,
     stream_append_direct_final_no_hash, stream_append_to_tmp, write_blob, write_blob_mailbox,
-    write_blob_mailbox_stream, DeliveryOutcome,
+    write_blob_mailbox_stream, DeliveryOutcome, LOCAL_FANOUT_CHUNK,
 };
 pub use cas::{hash_bytes, ContentStore};
-pub use maildir::{mailbox_exists, MailboxStore, MaildirPaths};
+pub use cold::{run_cold_storage, ColdPolicy, ColdReport, PackCompression};
+pub use maildir::{mailbox_exists, MailboxExpunge, MailboxStore, MaildirPaths};
+pub use maildir_cache::{MailboxCounts, MailboxSnapshot};
 pub use maildir_message::{
-    copy_message, expunge_deleted, list_mailbox_messages, move_message, split_maildir_filename,
-    store_add_flags, MaildirFlags, StoredMessage,
+    copy_message, expunge_deleted, expunge_deleted_bytes, list_mailbox_messages, mailbox_snapshot,
+    move_message, split_maildir_filename, store_add_flags, store_set_flagged,
+    store_set_junk_keyword, MaildirFlags, StoredMessage,
+};
+pub use migrate::{
+    is_migrated, list_user_mailboxes, migrate_user, verify_user, MailboxMigration, MigrateOptions,
+    UserMigration, PROGRESS_FILE,
 };
 pub use purge::{
-    prune_unread_older, purge_all_mail_blobs, purge_mail_blobs_older, purge_read_messages,
-    purge_user_messages,
+    prune_unread_older, prune_unread_with_policy, purge_all_mail_blobs, purge_mail_blobs_older,
+    purge_mail_blobs_with_policy, purge_read_messages, purge_user_messages, RetentionPolicy,
 };
 pub use storage_policy::{FsyncMode, StoragePolicy};
+pub use takeout::{takeout_size, write_takeout_archive, TakeoutProgress};
+pub use usage::{collect_orphan_blobs, storage_usage, OrphanSweep, StorageUsage, ORPHAN_MIN_AGE};
@@ -16,9 +16,11 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
 use std::sync::Arc;
 
 use chatmail_types::{ChatmailError, Result};
+use tokio::sync::broadcast;
 
 use crate::cas::ContentStore;
 use crate::fsync_batch::FsyncCoordinator;
@@ -35,6 +37,18 @@ pub struct MaildirPaths {
     pub tmp: PathBuf,
 }
 
+/// Messages removed from a mailbox behind the back of its IMAP sessions (retention prunes).
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct MailboxExpunge {
+    pub user: String,
+    pub mailbox: String,
+    /// UIDs of the removed messages, ascending.
+    pub uids: Vec<u32>,
+}
+
+/// Pending expunge notices a slow subscriber may lag behind before it has to resync.
+const EXPUNGE_CHANNEL_CAPACITY: usize = 256;
+
 #[derive(Debug)]
 struct MailboxStoreInner {
     policy: StoragePolicy,
@@ -42,6 +56,9 @@ struct MailboxStoreInner {
     uidlist: UidListStore,
     fsync: FsyncCoordinator,
     content_store: ContentStore,
+    expunges: broadcast::Sender<MailboxExpunge>,
+    /// Bumped with every published [`MailboxExpunge`]; sessions key listing caches on it.
+    expunge_generation: AtomicU64,
 }
 
 /// On-disk maildir access with listing cache, fsync policy, and optional CAS dedup.
@@ -67,6 +84,8 @@ impl MailboxStore {
                 uidlist: UidListStore::default(),
                 fsync: FsyncCoordinator::new(fsync_mode),
                 content_store: ContentStore::new(&state_dir),
+                expunges: broadcast::channel(EXPUNGE_CHANNEL_CAPACITY).0,
+                expunge_generation: AtomicU64::new(0),
             }),
         }
     }
@@ -104,6 +123,37 @@ impl MailboxStore {
         self.inner.list_cache.invalidate(user, mailbox);
     }
 
+    /// Drop every cached listing (purges that sweep the whole `mail/` tree).
+    pub fn invalidate_all_listings(&self) {
+        self.inner.list_cache.clear();
+    }
+
+    /// Expunges made outside an IMAP session, for sessions to report as `EXPUNGE`.
+    pub fn subscribe_expunges(&self) -> broadcast::Receiver<MailboxExpunge> {
+        self.inner.expunges.subscribe()
+    }
+
+    /// Count of expunges published so far; changes whenever a listing lost messages.
+    pub fn expunge_generation(&self) -> u64 {
+        self.inner.expunge_generation.load(Ordering::Acquire)
+    }
+
+    /// Drop the mailbox listing and tell subscribers which UIDs are gone.
+    pub(crate) fn publish_expunge(&self, user: &str, mailbox: &str, mut uids: Vec<u32>) {
+        self.invalidate_mailbox_listing(user, mailbox);
+        self.inner.expunge_generation.fetch_add(1, Ordering::AcqRel);
+        if uids.is_empty() {
+            return;
+        }
+        uids.sort_unstable();
+        // No receivers is fine: nobody has the mailbox selected.
+        let _ = self.inner.expunges.send(MailboxExpunge {
+            user: user.to_string(),
+            mailbox: mailbox.to_string(),
+            uids,
+        });
+    }
+
     /// INBOX maildir (`mail/{user}/Maildir/`).
     pub fn maildir_for_user(&self, user: &str) -> MaildirPaths {
         self.maildir_for_mailbox(user, "INBOX")
@@ -160,6 +210,117 @@ impl MailboxStore {
         }
         Ok(total)
     }
+
+    /// Message bytes in every mailbox of `user` (INBOX and folders) and, of those, bytes in
+    /// messages flagged `\Deleted` (`:2,T`).
+    ///
+    /// Ground truth for the quota cache's used/deleted counters.
+    pub async fn maildir_usage(&self, user: &str) -> Result<(u64, u64)> {
+        let (mut total, mut deleted) = (0u64, 0u64);
+        for mailbox in crate::migrate::list_user_mailboxes(self, user).await? {
+            let paths = self.maildir_for_mailbox(user, &mailbox);
+            for dir in [&paths.cur, &paths.new] {
+                if !dir.exists() {
+                    continue;
+                }
+                let mut read_dir = tokio::fs::read_dir(dir).await?;
+                while let Some(entry) = read_dir.next_entry().await? {
+                    let meta = entry.metadata().await?;
+                    if !meta.is_file() {
+                        continue;
+                    }
+                    total += meta.len();
+                    let name = entry.file_name();
+                    if crate::maildir_message::split_maildir_filename(&name.to_string_lossy())
+                        .1
+                        .deleted
+                    {
+                        deleted += meta.len();
+                    }
+                }
+            }
+        }
+        Ok((total, deleted))
+    }
+
+    /// Everything stored for `user` (`mail/{user}/`: INBOX, folders and UID lists).
+    pub fn user_root(&self, user: &str) -> PathBuf {
+        self.state_dir.join("mail").join(sanitize_user(user))
+    }
+
+    /// Messages in `cur/` and `new/` of every mailbox, without touching the listing cache.
+    pub async fn message_count(&self, user: &str) -> Result<u64> {
+        let mut count = 0u64;
+        for mailbox in crate::migrate::list_user_mailboxes(self, user).await? {
+            let paths = self.maildir_for_mailbox(user, &mailbox);
+            for dir in [&paths.cur, &paths.new] {
+                let Ok(mut read_dir) = tokio::fs::read_dir(dir).await else {
+                    continue;
+                };
+                while let Some(entry) = read_dir.next_entry().await? {
+                    if entry.file_type().await?.is_file() {
+                        count += 1;
+                    }
+                }
+            }
+        }
+        Ok(count)
+    }
+
+    /// Move `user`'s mail out of the live tree (one `rename`) so it can be restored if a
+    /// later step of an account deletion fails. `None` when the user has no mail directory.
+    ///
+    /// Finish with [`remove_detached`](Self::remove_detached) or
+    /// [`restore_detached`](Self::restore_detached).
+    pub async fn detach_user(&self, user: &str) -> Result<Option<PathBuf>> {
+        let root = self.user_root(user);
+        if !root.is_dir() {
+            return Ok(None);
+        }
+        let nanos = std::time::SystemTime::now()
+            .duration_since(std::time::UNIX_EPOCH)
+            .map(|d| d.as_nanos())
+            .unwrap_or(0);
+        let detached = self
+            .state_dir
+            .join("mail")
+            .join(format!(".deleting-{}-{nanos}", sanitize_user(user)));
+        tokio::fs::rename(&root, &detached).await?;
+        self.invalidate_all_listings();
+        Ok(Some(detached))
+    }
+
+    pub async fn restore_detached(&self, user: &str, detached: &Path) -> Result<()> {
+        tokio::fs::rename(detached, self.user_root(user)).await?;
+        Ok(())
+    }
+
+    pub async fn remove_detached(&self, detached: &Path) -> Result<()> {
+        tokio::fs::remove_dir_all(detached).await?;
+        Ok(())
+    }
+
+    /// Root of the cold tier: the configured `cold_storage` path, else `{state_dir}/cold`.
+    pub fn cold_root(&self) -> PathBuf {
+        match &self.inner.policy.cold {
+            Some(cold) => cold.root.clone(),
+            None => self.state_dir.join("cold"),
+        }
+    }
+
+    /// `user`'s pack directory in the cold tier.
+    pub fn cold_dir(&self, user: &str) -> PathBuf {
+        self.cold_root().join(sanitize_user(user))
+    }
+
+    /// Drop `user`'s cold packs (account deletion). Missing directory is fine.
+    pub async fn remove_cold(&self, user: &str) -> Result<()> {
+        match tokio::fs::remove_dir_all(self.cold_dir(user)).await {
+            Ok(()) => Ok(()),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(()),
+            Err(e) => Err(e.into()),
+        }
+    }
 }
 
 fn sanitize_user(user: &str) -> String {
@@ -221,6 +382,39 @@ mod tests {
         assert!(paths.root.ends_with("mail/alice@example.org/Maildir"));
     }
 
+    #[tokio::test]
+    async fn counts_messages_and_detaches_user_mail() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let inbox = store.init_user_dir("bob@example.org").await.unwrap();
+        let lists = store
+            .init_mailbox_dir("bob@example.org", "Lists")
+            .await
+            .unwrap();
+        std::fs::write(inbox.new.join("1.a"), b"x").unwrap();
+        std::fs::write(inbox.cur.join("2.a:2,S"), b"x").unwrap();
+        std::fs::write(lists.cur.join("3.a:2,"), b"x").unwrap();
+        assert_eq!(store.message_count("bob@example.org").await.unwrap(), 3);
+        assert_eq!(store.message_count("nobody@example.org").await.unwrap(), 0);
+
+        let detached = store.detach_user("bob@example.org").await.unwrap().unwrap();
+        assert!(!store.user_root("bob@example.org").exists());
+        store
+            .restore_detached("bob@example.org", &detached)
+            .await
+            .unwrap();
+        assert_eq!(store.message_count("bob@example.org").await.unwrap(), 3);
+
+        let detached = store.detach_user("bob@example.org").await.unwrap().unwrap();
+        store.remove_detached(&detached).await.unwrap();
+        assert!(!detached.exists());
+        assert!(store
+            .detach_user("bob@example.org")
+            .await
+            .unwrap()
+            .is_none());
+    }
+
     /// P11-UT10: custom storage policy is retained on the store handle.
     #[test]
     fn p11_ut10_mailbox_store_with_policy() {
@@ -16,13 +16,24 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 //! Maildir listing cache keyed on directory mtimes (Dovecot `DIR_MTIME_CHANGED` fast path).
+//!
+//! A cached listing is an immutable [`MailboxSnapshot`]: readers (FETCH, STATUS, IDLE) clone
+//! an `Arc` and never hold the map shard while they walk it. A flag change made through this
+//! process builds a new snapshot with the one message replaced and swaps it in
+//! ([`MaildirListCache::update_flags`]), keeping the [`MailboxCounts`] in step, instead of
+//! forcing the next reader to re-sync the directory.
+//!
+//! The swap is only taken when the cached listing was current just before the rename; any
+//! other change drops the entry. In-process writers that add or remove files must call
+//! [`crate::MailboxStore::invalidate_mailbox_listing`] (or clear the cache) once they are done.
 
 use std::path::Path;
+use std::sync::Arc;
 use std::time::SystemTime;
 
 use dashmap::DashMap;
 
-use crate::maildir_message::StoredMessage;
+use crate::maildir_message::{MaildirFlags, StoredMessage};
 
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub(crate) struct DirMtime {
@@ -40,11 +51,64 @@ impl DirMtime {
     }
 }
 
+/// `new/` and `cur/` mtimes, in that order.
+pub(crate) type DirMtimes = (Option<DirMtime>, Option<DirMtime>);
+
+/// Message totals of a listing, adjusted on flag transitions so `STATUS` never scans.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct MailboxCounts {
+    pub messages: usize,
+    /// Messages without `\Seen`.
+    pub unseen: usize,
+    /// Messages flagged `\Deleted` and not yet expunged.
+    pub deleted: usize,
+}
+
+impl MailboxCounts {
+    pub fn of(messages: &[StoredMessage]) -> Self {
+        let mut counts = Self {
+            messages: messages.len(),
+            ..Self::default()
+        };
+        for m in messages {
+            counts.unseen += usize::from(!m.flags.seen);
+            counts.deleted += usize::from(m.flags.deleted);
+        }
+        counts
+    }
+
+    fn transition(&mut self, before: &MaildirFlags, after: &MaildirFlags) {
+        self.unseen = self.unseen + usize::from(!after.seen) - usize::from(!before.seen);
+        self.deleted = self.deleted + usize::from(after.deleted) - usize::from(before.deleted);
+    }
+}
+
+/// One mailbox listing, ordered by UID. Shared read-only; changes replace it whole.
+#[derive(Debug, Clone)]
+pub struct MailboxSnapshot {
+    pub messages: Arc<[StoredMessage]>,
+    pub counts: MailboxCounts,
+}
+
+impl MailboxSnapshot {
+    pub fn new(messages: Vec<StoredMessage>) -> Self {
+        let counts = MailboxCounts::of(&messages);
+        Self {
+            messages: messages.into(),
+            counts,
+        }
+    }
+
+    /// Next UID a delivery would get, as far as this listing can tell.
+    pub fn uid_next(&self) -> u32 {
+        self.messages.last().map(|m| m.uid + 1).unwrap_or(1)
+    }
+}
+
 #[derive(Debug, Clone)]
 struct CachedListing {
-    new_mtime: Option<DirMtime>,
-    cur_mtime: Option<DirMtime>,
-    messages: Vec<StoredMessage>,
+    mtimes: DirMtimes,
+    snapshot: MailboxSnapshot,
 }
 
 /// Per-mailbox listing cache invalidated when `new/` or `cur/` mtimes change.
@@ -59,6 +123,11 @@ impl MaildirListCache {
             .remove(&(user.to_string(), mailbox.to_string()));
     }
 
+    /// Drop every listing (tree-wide purges that do not track mailboxes).
+    pub fn clear(&self) {
+        self.entries.clear();
+    }
+
     pub(crate) async fn dir_mtime(path: &Path) -> Option<DirMtime> {
         if !path.exists() {
             return None;
@@ -70,41 +139,119 @@ impl MaildirListCache {
             .map(DirMtime::from_system_time)
     }
 
+    pub(crate) async fn dir_mtimes(new_dir: &Path, cur_dir: &Path) -> DirMtimes {
+        (
+            Self::dir_mtime(new_dir).await,
+            Self::dir_mtime(cur_dir).await,
+        )
+    }
+
     pub async fn get_if_fresh(
         &self,
         user: &str,
         mailbox: &str,
         new_dir: &Path,
         cur_dir: &Path,
-    ) -> Option<Vec<StoredMessage>> {
+    ) -> Option<MailboxSnapshot> {
         // Read mtimes before locking the shard: never hold a DashMap guard across `.await`.
-        let new_mtime = Self::dir_mtime(new_dir).await;
-        let cur_mtime = Self::dir_mtime(cur_dir).await;
+        let mtimes = Self::dir_mtimes(new_dir, cur_dir).await;
         let key = (user.to_string(), mailbox.to_string());
         let cached = self.entries.get(&key)?;
-        if cached.new_mtime == new_mtime && cached.cur_mtime == cur_mtime {
-            Some(cached.messages.clone())
-        } else {
-            None
-        }
+        (cached.mtimes == mtimes).then(|| cached.snapshot.clone())
     }
 
     pub(crate) fn store(
         &self,
         user: &str,
         mailbox: &str,
-        new_mtime: Option<DirMtime>,
-        cur_mtime: Option<DirMtime>,
+        mtimes: DirMtimes,
         messages: Vec<StoredMessage>,
-    ) {
+    ) -> MailboxSnapshot {
+        let snapshot = MailboxSnapshot::new(messages);
         self.entries.insert(
             (user.to_string(), mailbox.to_string()),
             CachedListing {
-                new_mtime,
-                cur_mtime,
-                messages,
+                mtimes,
+                snapshot: snapshot.clone(),
             },
         );
+        snapshot
+    }
+
+    /// Cached message with `base_id`, if the listing is still the one current at `mtimes`.
+    pub(crate) fn lookup(
+        &self,
+        user: &str,
+        mailbox: &str,
+        mtimes: DirMtimes,
+        base_id: &str,
+    ) -> Option<StoredMessage> {
+        let cached = self.entries.get(&(user.to_string(), mailbox.to_string()))?;
+        if cached.mtimes != mtimes {
+            return None;
+        }
+        cached
+            .snapshot
+            .messages
+            .iter()
+            .find(|m| m.base_id == base_id)
+            .cloned()
+    }
+
+    /// Copy-on-write flag change after renaming `base_id` to `filename`.
+    ///
+    /// `before` are the mtimes read before the rename, `after` the ones read after it. The new
+    /// snapshot is built without holding the shard, then swapped in only if nobody replaced
+    /// the listing meanwhile; otherwise the entry is dropped. Returns whether it was swapped.
+    pub(crate) fn update_flags(
+        &self,
+        user: &str,
+        mailbox: &str,
+        (before, after): (DirMtimes, DirMtimes),
+        base_id: &str,
+        filename: String,
+        flags: MaildirFlags,
+    ) -> bool {
+        let key = (user.to_string(), mailbox.to_string());
+        let current = match self.entries.get(&key) {
+            Some(cached) if cached.mtimes == before => cached.snapshot.clone(),
+            Some(_) => {
+                self.entries.remove(&key);
+                return false;
+            }
+            None => return false,
+        };
+        let Some(pos) = current.messages.iter().position(|m| m.base_id == base_id) else {
+            self.entries.remove(&key);
+            return false;
+        };
+        let mut messages = current.messages.to_vec();
+        let mut counts = current.counts;
+        counts.transition(&messages[pos].flags, &flags);
+        messages[pos].filename = filename;
+        messages[pos].flags = flags;
+        let next = MailboxSnapshot {
+            messages: messages.into(),
+            counts,
+        };
+
+        let swapped = match self.entries.get_mut(&key) {
+            Some(mut cached)
+                if cached.mtimes == before
+                    && Arc::ptr_eq(&cached.snapshot.messages, &current.messages) =>
+            {
+                *cached = CachedListing {
+                    mtimes: after,
+                    snapshot: next,
+                };
+                true
+            }
+            _ => false,
+        };
+        if !swapped {
+            self.entries.remove(&key);
+        }
+        swapped
     }
 }
 
@@ -135,17 +282,16 @@ mod tests {
         tokio::fs::create_dir_all(&cur_dir).await.unwrap();
 
         let cache = MaildirListCache::default();
-        let new_mtime = MaildirListCache::dir_mtime(&new_dir).await;
-        let cur_mtime = MaildirListCache::dir_mtime(&cur_dir).await;
-        let msgs = vec![sample_msg("a")];
-        cache.store("u@test", "INBOX", new_mtime, cur_mtime, msgs.clone());
+        let mtimes = MaildirListCache::dir_mtimes(&new_dir, &cur_dir).await;
+        cache.store("u@test", "INBOX", mtimes, vec![sample_msg("a")]);
 
         let hit = cache
             .get_if_fresh("u@test", "INBOX", &new_dir, &cur_dir)
             .await
             .unwrap();
-        assert_eq!(hit.len(), 1);
-        assert_eq!(hit[0].base_id, "a");
+        assert_eq!(hit.messages.len(), 1);
+        assert_eq!(hit.messages[0].base_id, "a");
+        assert_eq!(hit.counts.unseen, 1);
     }
 
     /// P11-UT05: cache miss after a new message changes `new/` mtime.
@@ -158,15 +304,8 @@ mod tests {
         tokio::fs::create_dir_all(&cur_dir).await.unwrap();
 
         let cache = MaildirListCache::default();
-        let new_mtime = MaildirListCache::dir_mtime(&new_dir).await;
-        let cur_mtime = MaildirListCache::dir_mtime(&cur_dir).await;
-        cache.store(
-            "u@test",
-            "INBOX",
-            new_mtime,
-            cur_mtime,
-            vec![sample_msg("old")],
-        );
+        let mtimes = MaildirListCache::dir_mtimes(&new_dir, &cur_dir).await;
+        cache.store("u@test", "INBOX", mtimes, vec![sample_msg("old")]);
 
         tokio::fs::write(new_dir.join("msg"), b"x").await.unwrap();
 
@@ -196,7 +335,7 @@ mod tests {
 
                 let cache = std::sync::Arc::new(MaildirListCache::default());
                 let m = MaildirListCache::dir_mtime(&new_dir).await;
-                cache.store("u@test", "INBOX", m, m, vec![sample_msg("a")]);
+                cache.store("u@test", "INBOX", (m, m), vec![sample_msg("a")]);
 
                 // Reader suspends inside get_if_fresh (at the mtime .await); the single worker then
                 // runs the writer, whose store() takes the same shard's write lock.
@@ -214,7 +353,7 @@ mod tests {
                     let cache = cache.clone();
                     tokio::spawn(async move {
                         for i in 0..50 {
-                            cache.store("u@test", "INBOX", None, None, vec![sample_msg("b")]);
+                            cache.store("u@test", "INBOX", (None, None), vec![sample_msg("b")]);
                             cache.invalidate("u@test", "INBOX");
                             if i % 7 == 0 {
                                 tokio::task::yield_now().await;
@@ -239,11 +378,72 @@ mod tests {
         worker.join().unwrap();
     }
 
+    #[test]
+    fn update_flags_swaps_snapshot_and_adjusts_counts() {
+        let cache = MaildirListCache::default();
+        let before = (None, None);
+        let old = cache.store(
+            "u@test",
+            "INBOX",
+            before,
+            vec![sample_msg("a"), sample_msg("b")],
+        );
+        assert_eq!(old.counts.unseen, 2);
+
+        let seen = MaildirFlags {
+            seen: true,
+            deleted: true,
+            ..MaildirFlags::default()
+        };
+        let after = (None, Some(DirMtime { secs: 1, nanos: 0 }));
+        assert!(cache.update_flags(
+            "u@test",
+            "INBOX",
+            (before, after),
+            "b",
+            "b:2,ST".into(),
+            seen
+        ));
+
+        let key = ("u@test".to_string(), "INBOX".to_string());
+        let cached = cache.entries.get(&key).unwrap().clone();
+        assert_eq!(cached.mtimes, after);
+        assert_eq!(cached.snapshot.messages[1].filename, "b:2,ST");
+        assert_eq!(
+            cached.snapshot.counts,
+            MailboxCounts::of(&cached.snapshot.messages)
+        );
+        assert_eq!(cached.snapshot.counts.unseen, 1);
+        assert_eq!(cached.snapshot.counts.deleted, 1);
+        // Readers holding the previous snapshot keep seeing it unchanged.
+        assert_eq!(old.messages[1].filename, "b");
+    }
+
+    #[test]
+    fn update_flags_drops_listing_that_moved_on() {
+        let cache = MaildirListCache::default();
+        let stale = (Some(DirMtime { secs: 1, nanos: 0 }), None);
+        cache.store("u@test", "INBOX", stale, vec![sample_msg("a")]);
+        let flags = MaildirFlags::default();
+        assert!(!cache.update_flags(
+            "u@test",
+            "INBOX",
+            ((None, None), (None, None)),
+            "a",
+            "a:2,".into(),
+            flags,
+        ));
+        assert!(cache
+            .entries
+            .get(&("u@test".into(), "INBOX".into()))
+            .is_none());
+    }
+
     /// P11-UT06: explicit invalidation drops cached listing.
     #[tokio::test]
     async fn p11_ut06_invalidate_clears_entry() {
         let cache = MaildirListCache::default();
-        cache.store("u@test", "INBOX", None, None, vec![sample_msg("x")]);
+        cache.store("u@test", "INBOX", (None, None), vec![sample_msg("x")]);
         cache.invalidate("u@test", "INBOX");
         assert!(cache
             .entries
@@ -22,30 +22,57 @@ use chatmail_types::{ChatmailError, Result};
 use tokio::fs;
 
 use crate::maildir::MailboxStore;
+use crate::maildir_cache::{MailboxSnapshot, MaildirListCache};
+
+/// Maildir keyword letter for `$Junk` (RFC 5788 registry).
+///
+/// Chatmail maildirs carry no `dovecot-keywords` index, so the two junk-training keywords use
+/// fixed lowercase letters after the standard uppercase flags.
+const JUNK_KEYWORD_LETTER: char = 'j';
+/// Maildir keyword letter for `$NotJunk`.
+const NOT_JUNK_KEYWORD_LETTER: char = 'n';
 
 /// Parsed maildir filename flags (`:2,XY` suffix).
 #[derive(Debug, Clone, Default, PartialEq, Eq)]
 pub struct MaildirFlags {
+    /// `\Flagged` (maildir `F`); retention keeps these by default.
+    pub flagged: bool,
     pub seen: bool,
     pub deleted: bool,
+    /// `$Junk` keyword (user classified the message as spam).
+    pub junk: bool,
+    /// `$NotJunk` keyword (user classified the message as ham).
+    pub not_junk: bool,
 }
 
 impl MaildirFlags {
     pub fn from_maildir_suffix(suffix: &str) -> Self {
         Self {
+            flagged: suffix.contains('F'),
             seen: suffix.contains('S'),
             deleted: suffix.contains('T'),
+            junk: suffix.contains(JUNK_KEYWORD_LETTER),
+            not_junk: suffix.contains(NOT_JUNK_KEYWORD_LETTER),
         }
     }
 
     pub fn to_maildir_suffix(&self) -> String {
         let mut s = String::new();
+        if self.flagged {
+            s.push('F');
+        }
         if self.seen {
             s.push('S');
         }
         if self.deleted {
             s.push('T');
         }
+        if self.junk {
+            s.push(JUNK_KEYWORD_LETTER);
+        }
+        if self.not_junk {
+            s.push(NOT_JUNK_KEYWORD_LETTER);
+        }
         s
     }
 
@@ -57,6 +84,15 @@ impl MaildirFlags {
         if self.deleted {
             out.push("\\Deleted");
         }
+        if self.flagged {
+            out.push("\\Flagged");
+        }
+        if self.junk {
+            out.push("$Junk");
+        }
+        if self.not_junk {
+            out.push("$NotJunk");
+        }
         out
     }
 }
@@ -102,6 +138,21 @@ pub async fn list_mailbox_messages(
     user: &str,
     mailbox: &str,
 ) -> Result<Vec<StoredMessage>> {
+    Ok(mailbox_snapshot(store, user, mailbox)
+        .await?
+        .messages
+        .to_vec())
+}
+
+/// [`list_mailbox_messages`] without the copy: the shared listing plus its maintained counts.
+///
+/// Hot read paths (FETCH, STATUS, IDLE polling) should prefer this; the snapshot never changes
+/// under the caller, and a concurrent STORE publishes a new one instead of mutating it.
+pub async fn mailbox_snapshot(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+) -> Result<MailboxSnapshot> {
     let paths = store.maildir_for_mailbox(user, mailbox);
     if let Some(cached) = store
         .list_cache()
@@ -111,13 +162,9 @@ pub async fn list_mailbox_messages(
         return Ok(cached);
     }
 
-    let new_mtime = crate::maildir_cache::MaildirListCache::dir_mtime(&paths.new).await;
-    let cur_mtime = crate::maildir_cache::MaildirListCache::dir_mtime(&paths.cur).await;
+    let mtimes = MaildirListCache::dir_mtimes(&paths.new, &paths.cur).await;
     let messages = store.uidlist().sync(user, mailbox, &paths).await?;
-    store
-        .list_cache()
-        .store(user, mailbox, new_mtime, cur_mtime, messages.clone());
-    Ok(messages)
+    Ok(store.list_cache().store(user, mailbox, mtimes, messages))
 }
 
 async fn locate_message(
@@ -127,6 +174,22 @@ async fn locate_message(
     base_id: &str,
 ) -> Result<(PathBuf, MaildirFlags, bool)> {
     let paths = store.maildir_for_mailbox(user, mailbox);
+    // Fresh listing knows the current filename: probe it instead of scanning both dirs.
+    let mtimes = MaildirListCache::dir_mtimes(&paths.new, &paths.cur).await;
+    if let Some(cached) = store.list_cache().lookup(user, mailbox, mtimes, base_id) {
+        let (_, flags) = split_maildir_filename(&cached.filename);
+        let order = if cached.flags.seen {
+            [(true, &paths.cur), (false, &paths.new)]
+        } else {
+            [(false, &paths.new), (true, &paths.cur)]
+        };
+        for (in_cur, dir) in order {
+            let path = dir.join(&cached.filename);
+            if path.is_file() {
+                return Ok((path, flags, in_cur));
+            }
+        }
+    }
     for (in_cur, dir) in [(false, &paths.new), (true, &paths.cur)] {
         if !dir.exists() {
             continue;
@@ -174,23 +237,89 @@ pub async fn store_add_flags(
         store.invalidate_mailbox_listing(user, mailbox);
         return Ok(flags);
     }
+    rename_with_flags(store, user, mailbox, base_id, &path, in_cur, &flags).await?;
+    Ok(flags)
+}
+
+/// Set the `$Junk` (`junk = true`) or `$NotJunk` keyword; the two are mutually exclusive.
+///
+/// Returns the resulting flags and whether the classification actually changed, so callers
+/// can emit exactly one training signal per transition (re-applying the same keyword is a no-op).
+pub async fn store_set_junk_keyword(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    base_id: &str,
+    junk: bool,
+) -> Result<(MaildirFlags, bool)> {
+    let (path, mut flags, in_cur) = locate_message(store, user, mailbox, base_id).await?;
+    if in_cur {
+        flags.seen = true;
+    }
+    let changed = flags.junk != junk || flags.not_junk == junk;
+    if !changed {
+        return Ok((flags, false));
+    }
+    flags.junk = junk;
+    flags.not_junk = !junk;
+    rename_with_flags(store, user, mailbox, base_id, &path, in_cur, &flags).await?;
+    Ok((flags, true))
+}
+
+/// Apply `+FLAGS (\Flagged)`; returns the resulting flags.
+pub async fn store_set_flagged(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    base_id: &str,
+) -> Result<MaildirFlags> {
+    let (path, mut flags, in_cur) = locate_message(store, user, mailbox, base_id).await?;
+    if in_cur {
+        flags.seen = true;
+    }
+    if !flags.flagged {
+        flags.flagged = true;
+        rename_with_flags(store, user, mailbox, base_id, &path, in_cur, &flags).await?;
+    }
+    Ok(flags)
+}
+
+/// Rename a located message so its filename suffix and `new/`/`cur/` placement match `flags`.
+async fn rename_with_flags(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    base_id: &str,
+    path: &std::path::Path,
+    in_cur: bool,
+    flags: &MaildirFlags,
+) -> Result<()> {
     let paths = store.maildir_for_mailbox(user, mailbox);
-    let new_name = maildir_filename(base_id, &flags);
+    let new_name = maildir_filename(base_id, flags);
     let target_dir = if flags.seen { &paths.cur } else { &paths.new };
     fs::create_dir_all(target_dir).await?;
+    let before = MaildirListCache::dir_mtimes(&paths.new, &paths.cur).await;
     let target = target_dir.join(&new_name);
     if path != target {
-        fs::rename(&path, &target).await?;
+        fs::rename(path, &target).await?;
     } else if in_cur != flags.seen {
         let other = if flags.seen {
             paths.new.join(&new_name)
         } else {
             paths.cur.join(&new_name)
         };
-        fs::rename(&path, &other).await?;
+        fs::rename(path, &other).await?;
     }
-    store.invalidate_mailbox_listing(user, mailbox);
-    Ok(flags)
+    let after = MaildirListCache::dir_mtimes(&paths.new, &paths.cur).await;
+    store.list_cache().update_flags(
+        user,
+        mailbox,
+        (before, after),
+        base_id,
+        new_name,
+        flags.clone(),
+    );
+    Ok(())
 }
 
 pub async fn move_message(
@@ -264,8 +393,18 @@ async fn write_message(
 
 /// Remove messages marked `\Deleted` (maildir `:2,T`).
 pub async fn expunge_deleted(store: &MailboxStore, user: &str, mailbox: &str) -> Result<usize> {
+    Ok(expunge_deleted_bytes(store, user, mailbox).await?.0)
+}
+
+/// [`expunge_deleted`] that also reports the bytes freed (quota accounting).
+pub async fn expunge_deleted_bytes(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+) -> Result<(usize, u64)> {
     let paths = store.maildir_for_mailbox(user, mailbox);
     let mut removed = 0usize;
+    let mut freed = 0u64;
     for dir in [&paths.new, &paths.cur] {
         if !dir.exists() {
             continue;
@@ -278,12 +417,16 @@ pub async fn expunge_deleted(store: &MailboxStore, user: &str, mailbox: &str) ->
             let name = ent.file_name().to_string_lossy().into_owned();
             let (_, flags) = split_maildir_filename(&name);
             if flags.deleted {
+                freed += ent.metadata().await.map(|m| m.len()).unwrap_or(0);
                 fs::remove_file(ent.path()).await?;
                 removed += 1;
             }
         }
     }
-    Ok(removed)
+    if removed > 0 {
+        store.invalidate_mailbox_listing(user, mailbox);
+    }
+    Ok((removed, freed))
 }
 
 #[cfg(test)]
@@ -294,8 +437,11 @@ mod tests {
     #[test]
     fn maildir_flag_roundtrip() {
         let flags = MaildirFlags {
+            flagged: false,
             seen: true,
             deleted: true,
+            junk: false,
+            not_junk: false,
         };
         let name = maildir_filename("abc", &flags);
         assert_eq!(name, "abc:2,ST");
@@ -304,6 +450,24 @@ mod tests {
         assert_eq!(parsed, flags);
     }
 
+    #[tokio::test]
+    async fn store_set_flagged_keeps_seen_placement() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        write_blob(&store, "u@test", "m1", b"x").await.unwrap();
+        store_add_flags(&store, "u@test", "INBOX", "m1", true, false)
+            .await
+            .unwrap();
+
+        let flags = store_set_flagged(&store, "u@test", "INBOX", "m1")
+            .await
+            .unwrap();
+        assert!(flags.flagged && flags.seen);
+        let paths = store.maildir_for_mailbox("u@test", "INBOX");
+        assert!(paths.cur.join("m1:2,FS").exists());
+        assert_eq!(flags.imap_flags(), ["\\Seen", "\\Flagged"]);
+    }
+
     /// P11-UT14: list_mailbox_messages serves cached listing when mtimes unchanged.
     #[tokio::test]
     async fn p11_ut14_list_mailbox_messages_uses_mtime_cache() {
@@ -344,4 +508,204 @@ mod tests {
             .unwrap();
         assert_eq!(after.len(), 2);
     }
+
+    #[test]
+    fn junk_keywords_roundtrip_through_suffix() {
+        let flags = MaildirFlags {
+            flagged: false,
+            seen: true,
+            deleted: false,
+            junk: true,
+            not_junk: false,
+        };
+        let name = maildir_filename("abc", &flags);
+        assert_eq!(name, "abc:2,Sj");
+        let (_, parsed) = split_maildir_filename(&name);
+        assert_eq!(parsed, flags);
+        assert_eq!(parsed.imap_flags(), vec!["\\Seen", "$Junk"]);
+    }
+
+    #[tokio::test]
+    async fn store_set_junk_keyword_reports_transitions_once() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        write_blob(&store, "u@test", "m1", b"x").await.unwrap();
+
+        let (flags, changed) = store_set_junk_keyword(&store, "u@test", "INBOX", "m1", true)
+            .await
+            .unwrap();
+        assert!(changed);
+        assert!(flags.junk && !flags.not_junk);
+
+        let (_, changed) = store_set_junk_keyword(&store, "u@test", "INBOX", "m1", true)
+            .await
+            .unwrap();
+        assert!(!changed, "re-applying $Junk is not a transition");
+
+        let (flags, changed) = store_set_junk_keyword(&store, "u@test", "INBOX", "m1", false)
+            .await
+            .unwrap();
+        assert!(changed);
+        assert!(!flags.junk && flags.not_junk);
+
+        let listed = list_mailbox_messages(&store, "u@test", "INBOX")
+            .await
+            .unwrap();
+        assert_eq!(listed.len(), 1);
+        assert!(listed[0].flags.not_junk);
+    }
+
+    #[tokio::test]
+    async fn flag_change_updates_cached_listing_in_place() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        write_blob(&store, "u@test", "m1", b"x").await.unwrap();
+        write_blob(&store, "u@test", "m2", b"y").await.unwrap();
+        let before = mailbox_snapshot(&store, "u@test", "INBOX").await.unwrap();
+        assert_eq!(before.counts.unseen, 2);
+
+        store_add_flags(&store, "u@test", "INBOX", "m1", true, false)
+            .await
+            .unwrap();
+        let paths = store.maildir_for_mailbox("u@test", "INBOX");
+        let after = store
+            .list_cache()
+            .get_if_fresh("u@test", "INBOX", &paths.new, &paths.cur)
+            .await
+            .expect("STORE keeps the listing fresh instead of dropping it");
+        assert_eq!(after.counts.unseen, 1);
+        assert!(after.messages[0].flags.seen);
+        assert!(
+            !before.messages[0].flags.seen,
+            "old snapshot is never mutated"
+        );
+    }
+
+    /// Concurrent STORE, listing readers and expunge on one mailbox: every snapshot is internally
+    /// consistent and the final cached listing matches a fresh directory sync.
+    #[tokio::test(flavor = "multi_thread", worker_threads = 4)]
+    async fn concurrent_store_fetch_expunge_keep_listing_consistent() {
+        use crate::maildir_cache::MailboxCounts;
+        use std::sync::Arc;
+
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        for i in 0..40 {
+            write_blob(&store, "u@test", &format!("m{i:02}"), b"x")
+                .await
+                .unwrap();
+        }
+        mailbox_snapshot(&store, "u@test", "INBOX").await.unwrap();
+
+        let done = Arc::new(std::sync::atomic::AtomicBool::new(false));
+        let mut tasks = Vec::new();
+        for w in 0..6 {
+            let store = store.clone();
+            tasks.push(tokio::spawn(async move {
+                for i in (w..30).step_by(6) {
+                    let id = format!("m{i:02}");
+                    store_set_flagged(&store, "u@test", "INBOX", &id)
+                        .await
+                        .unwrap();
+                    store_add_flags(&store, "u@test", "INBOX", &id, true, false)
+                        .await
+                        .unwrap();
+                    store_set_junk_keyword(&store, "u@test", "INBOX", &id, i % 2 == 0)
+                        .await
+                        .unwrap();
+                }
+            }));
+        }
+        {
+            let store = store.clone();
+            tasks.push(tokio::spawn(async move {
+                for i in 30..40 {
+                    let id = format!("m{i:02}");
+                    store_add_flags(&store, "u@test", "INBOX", &id, false, true)
+                        .await
+                        .unwrap();
+                    expunge_deleted(&store, "u@test", "INBOX").await.unwrap();
+                }
+            }));
+        }
+        let mut readers = Vec::new();
+        for _ in 0..4 {
+            let store = store.clone();
+            let done = done.clone();
+            readers.push(tokio::spawn(async move {
+                while !done.load(std::sync::atomic::Ordering::Relaxed) {
+                    let snap = mailbox_snapshot(&store, "u@test", "INBOX").await.unwrap();
+                    assert_eq!(snap.counts, MailboxCounts::of(&snap.messages));
+                    tokio::task::yield_now().await;
+                }
+            }));
+        }
+        for t in tasks {
+            t.await.unwrap();
+        }
+        done.store(true, std::sync::atomic::Ordering::Relaxed);
+        for r in readers {
+            r.await.unwrap();
+        }
+
+        let cached = mailbox_snapshot(&store, "u@test", "INBOX").await.unwrap();
+        let paths = store.maildir_for_mailbox("u@test", "INBOX");
+        let fresh = store
+            .uidlist()
+            .sync("u@test", "INBOX", &paths)
+            .await
+            .unwrap();
+        let names = |m: &[StoredMessage]| -> Vec<(u32, String)> {
+            m.iter().map(|m| (m.uid, m.filename.clone())).collect()
+        };
+        assert_eq!(names(&cached.messages), names(&fresh));
+        assert_eq!(cached.counts, MailboxCounts::of(&fresh));
+        assert_eq!(cached.counts.messages, 30);
+        assert_eq!(cached.counts.unseen, 0);
+    }
+
+    /// Listing throughput with 100 sessions on one mailbox while flags change underneath.
+    ///
+    /// Compares the copy-on-write path against dropping the listing on every STORE (the previous
+    /// behaviour, where each flag change forced the next reader to re-sync the directory).
+    #[tokio::test(flavor = "multi_thread")]
+    #[ignore = "benchmark: cargo test -p chatmail-storage --release -- --ignored --nocapture"]
+    async fn bench_concurrent_sessions_one_mailbox() {
+        async fn run(store: &MailboxStore, drop_on_store: bool) -> f64 {
+            let start = std::time::Instant::now();
+            let mut sessions = Vec::new();
+            for s in 0..100usize {
+                let store = store.clone();
+                sessions.push(tokio::spawn(async move {
+                    for round in 0..50usize {
+                        mailbox_snapshot(&store, "u@test", "INBOX").await.unwrap();
+                        if round % 10 == s % 10 {
+                            let id = format!("m{:03}", (s * 7 + round) % 500);
+                            store_set_junk_keyword(&store, "u@test", "INBOX", &id, round % 20 == 0)
+                                .await
+                                .unwrap();
+                            if drop_on_store {
+                                store.invalidate_mailbox_listing("u@test", "INBOX");
+                            }
+                        }
+                    }
+                }));
+            }
+            for s in sessions {
+                s.await.unwrap();
+            }
+            (100 * 50) as f64 / start.elapsed().as_secs_f64()
+        }
+
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        for i in 0..500 {
+            write_blob(&store, "u@test", &format!("m{i:03}"), b"x")
+                .await
+                .unwrap();
+        }
+        let invalidating = run(&store, true).await;
+        let cow = run(&store, false).await;
+        println!("listings/s: invalidate-on-store {invalidating:.0}, copy-on-write {cow:.0}");
+    }
 }
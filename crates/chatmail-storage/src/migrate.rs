@@ -0,0 +1,489 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Copy accounts between two mail roots (`madmail storage migrate`).
+//!
+//! Messages are copied one at a time with their maildir flags, `new/`/`cur/` placement and
+//! mtime (IMAP INTERNALDATE). A copy is resumable: messages already present in the
+//! destination are skipped, and a finished, verified account gets [`MIGRATED_MARKER`].
+
+use std::collections::{HashMap, HashSet};
+use std::path::{Path, PathBuf};
+use std::time::{Duration, SystemTime};
+
+use chatmail_types::{ChatmailError, Result};
+use tokio::fs;
+
+use crate::cas::hash_bytes;
+use crate::cold::read_message_file;
+use crate::maildir::MailboxStore;
+use crate::maildir_message::{list_mailbox_messages, split_maildir_filename};
+
+/// Written to `mail/{user}/` in the destination once the account copied and verified.
+pub const MIGRATED_MARKER: &str = ".migrated";
+/// Optional note left in the source account pointing at the destination.
+pub const REDIRECT_NOTE: &str = "MIGRATED";
+/// Progress snapshot in the source state dir, read by `/admin/storage/migrate`.
+pub const PROGRESS_FILE: &str = "storage-migrate.json";
+
+#[derive(Debug, Clone)]
+pub struct MigrateOptions {
+    /// Messages per mailbox whose content hashes are compared after the copy.
+    pub verify_sample: usize,
+    /// Pause after each copied message so a live server keeps its disk bandwidth.
+    pub throttle: Duration,
+    /// Leave a [`REDIRECT_NOTE`] with this text in the source account.
+    pub redirect_note: Option<String>,
+    /// Re-copy accounts that already carry [`MIGRATED_MARKER`].
+    pub force: bool,
+}
+
+impl Default for MigrateOptions {
+    fn default() -> Self {
+        Self {
+            verify_sample: 16,
+            throttle: Duration::ZERO,
+            redirect_note: None,
+            force: false,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct MailboxMigration {
+    pub name: String,
+    pub messages: usize,
+    pub copied: usize,
+    pub skipped: usize,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct UserMigration {
+    pub user: String,
+    pub mailboxes: Vec<MailboxMigration>,
+    /// Destination already carried the marker; nothing was copied.
+    pub already_migrated: bool,
+    pub verified: usize,
+}
+
+impl UserMigration {
+    pub fn copied(&self) -> usize {
+        self.mailboxes.iter().map(|m| m.copied).sum()
+    }
+
+    pub fn messages(&self) -> usize {
+        self.mailboxes.iter().map(|m| m.messages).sum()
+    }
+}
+
+/// One message on disk, as the migration sees it.
+struct Entry {
+    path: PathBuf,
+    /// `new` or `cur`.
+    mailbox: String,
+    subdir: &'static str,
+    name: String,
+    base_id: String,
+}
+
+/// Mailbox names for `user`: `INBOX` plus every `folders/*` directory.
+pub async fn list_user_mailboxes(store: &MailboxStore, user: &str) -> Result<Vec<String>> {
+    let mut names = vec!["INBOX".to_string()];
+    if let Some(dir) = store
+        .maildir_for_user(user)
+        .root
+        .parent()
+        .map(|p| p.join("folders"))
+    {
+        if dir.is_dir() {
+            let mut rd = fs::read_dir(&dir).await?;
+            while let Some(ent) = rd.next_entry().await? {
+                if ent.file_type().await?.is_dir() {
+                    names.push(ent.file_name().to_string_lossy().into_owned());
+                }
+            }
+        }
+    }
+    names[1..].sort();
+    Ok(names)
+}
+
+/// Whether `user` already finished migrating into `store`.
+pub fn is_migrated(store: &MailboxStore, user: &str) -> bool {
+    user_dir(store, user).join(MIGRATED_MARKER).is_file()
+}
+
+/// Copy every mailbox of `user` from `src` to `dst`, then verify and mark the account.
+/// `on_message` is called after each message with the running copied count.
+pub async fn migrate_user(
+    src: &MailboxStore,
+    dst: &MailboxStore,
+    user: &str,
+    opts: &MigrateOptions,
+    mut on_message: impl FnMut(usize),
+) -> Result<UserMigration> {
+    let mut report = UserMigration {
+        user: user.to_string(),
+        ..Default::default()
+    };
+    if !opts.force && is_migrated(dst, user) {
+        report.already_migrated = true;
+        return Ok(report);
+    }
+    if same_root(src, dst) {
+        return Err(ChatmailError::storage(
+            "source and destination are the same mail root",
+        ));
+    }
+
+    let mut copied_total = 0usize;
+    for mailbox in list_user_mailboxes(src, user).await? {
+        let entries = mailbox_entries(src, user, &mailbox).await?;
+        // INTERNALDATE lives in the source uidlist, not necessarily in the file mtime.
+        let dates: HashMap<String, SystemTime> = list_mailbox_messages(src, user, &mailbox)
+            .await?
+            .into_iter()
+            .map(|m| (m.base_id, m.internal_date))
+            .collect();
+        let dst_paths = dst.init_mailbox_dir(user, &mailbox).await?;
+        let present: HashSet<String> = mailbox_entries(dst, user, &mailbox)
+            .await?
+            .into_iter()
+            .map(|e| e.base_id)
+            .collect();
+        let mut mb = MailboxMigration {
+            name: mailbox.clone(),
+            messages: entries.len(),
+            ..Default::default()
+        };
+        for entry in &entries {
+            if present.contains(&entry.base_id) {
+                mb.skipped += 1;
+                continue;
+            }
+            copy_entry(
+                src,
+                dst,
+                user,
+                &dst_paths.root,
+                entry,
+                dates.get(&entry.base_id).copied(),
+            )
+            .await?;
+            mb.copied += 1;
+            copied_total += 1;
+            on_message(copied_total);
+            if !opts.throttle.is_zero() {
+                tokio::time::sleep(opts.throttle).await;
+            }
+        }
+        dst.invalidate_mailbox_listing(user, &mailbox);
+        report.mailboxes.push(mb);
+    }
+
+    report.verified = verify_user(src, dst, user, opts.verify_sample).await?;
+    fs::write(user_dir(dst, user).join(MIGRATED_MARKER), b"").await?;
+    if let Some(note) = &opts.redirect_note {
+        fs::write(user_dir(src, user).join(REDIRECT_NOTE), note.as_bytes()).await?;
+    }
+    Ok(report)
+}
+
+/// Compare mailbox structure, per-message flags and placement, and the content hash of up
+/// to `sample` messages per mailbox. Returns how many hashes were checked.
+pub async fn verify_user(
+    src: &MailboxStore,
+    dst: &MailboxStore,
+    user: &str,
+    sample: usize,
+) -> Result<usize> {
+    let mailboxes = list_user_mailboxes(src, user).await?;
+    let mut dst_mailboxes = list_user_mailboxes(dst, user).await?;
+    dst_mailboxes.retain(|m| mailboxes.contains(m));
+    if dst_mailboxes != mailboxes {
+        return Err(ChatmailError::storage(format!(
+            "{user}: destination is missing mailboxes"
+        )));
+    }
+    let mut hashed = 0usize;
+    for mailbox in &mailboxes {
+        let from = mailbox_entries(src, user, mailbox).await?;
+        let to = mailbox_entries(dst, user, mailbox).await?;
+        let to_names: HashSet<(&str, &str)> =
+            to.iter().map(|e| (e.subdir, e.name.as_str())).collect();
+        if let Some(missing) = from
+            .iter()
+            .find(|e| !to_names.contains(&(e.subdir, e.name.as_str())))
+        {
+            return Err(ChatmailError::storage(format!(
+                "{user}/{mailbox}: {} missing or has different flags in destination",
+                missing.name
+            )));
+        }
+        // Spread the sample over the mailbox instead of only checking the oldest mail.
+        let step = (from.len() / sample.max(1)).max(1);
+        for entry in from.iter().step_by(step).take(sample) {
+            let dst_path = dst
+                .maildir_for_mailbox(user, mailbox)
+                .root
+                .join(entry.subdir)
+                .join(&entry.name);
+            let copied = read_message_file(dst, user, mailbox, &dst_path).await?;
+            if Some(hash_bytes(&read_entry(src, user, entry).await?))
+                != copied.map(|b| hash_bytes(&b))
+            {
+                return Err(ChatmailError::storage(format!(
+                    "{user}/{mailbox}: content mismatch for {}",
+                    entry.name
+                )));
+            }
+            hashed += 1;
+        }
+    }
+    Ok(hashed)
+}
+
+async fn mailbox_entries(store: &MailboxStore, user: &str, mailbox: &str) -> Result<Vec<Entry>> {
+    let paths = store.maildir_for_mailbox(user, mailbox);
+    let mut out = Vec::new();
+    for (subdir, dir) in [("new", &paths.new), ("cur", &paths.cur)] {
+        if !dir.is_dir() {
+            continue;
+        }
+        let mut rd = fs::read_dir(dir).await?;
+        while let Some(ent) = rd.next_entry().await? {
+            if !ent.file_type().await?.is_file() {
+                continue;
+            }
+            let name = ent.file_name().to_string_lossy().into_owned();
+            let base_id = split_maildir_filename(&name).0.to_string();
+            out.push(Entry {
+                path: ent.path(),
+                mailbox: mailbox.to_string(),
+                subdir,
+                name,
+                base_id,
+            });
+        }
+    }
+    out.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(out)
+}
+
+/// Body of `entry`, read back from the cold tier when it was moved there.
+async fn read_entry(store: &MailboxStore, user: &str, entry: &Entry) -> Result<Vec<u8>> {
+    read_message_file(store, user, &entry.mailbox, &entry.path)
+        .await?
+        .ok_or_else(|| ChatmailError::storage(format!("{user}: {} vanished", entry.name)))
+}
+
+/// tmp write + rename, like delivery, so a crash never leaves a torn message behind.
+/// Cold messages land hot in `dst`.
+async fn copy_entry(
+    src: &MailboxStore,
+    dst: &MailboxStore,
+    user: &str,
+    root: &Path,
+    entry: &Entry,
+    internal_date: Option<SystemTime>,
+) -> Result<()> {
+    let mtime = match internal_date {
+        Some(t) => t,
+        None => fs::metadata(&entry.path)
+            .await?
+            .modified()
+            .unwrap_or_else(|_| SystemTime::now()),
+    };
+    let body = read_entry(src, user, entry).await?;
+    let tmp_path = root.join("tmp").join(&entry.name);
+    let target_dir = root.join(entry.subdir);
+    let mut file = fs::File::create(&tmp_path).await?;
+    tokio::io::AsyncWriteExt::write_all(&mut file, &body).await?;
+    dst.fsync().sync_file_data(&mut file).await?;
+    file.into_std().await.set_modified(mtime)?;
+    fs::rename(&tmp_path, target_dir.join(&entry.name)).await?;
+    dst.fsync().commit_directory(&target_dir).await?;
+    Ok(())
+}
+
+fn user_dir(store: &MailboxStore, user: &str) -> PathBuf {
+    let inbox = store.maildir_for_user(user).root;
+    inbox.parent().map(Path::to_path_buf).unwrap_or(inbox)
+}
+
+fn same_root(a: &MailboxStore, b: &MailboxStore) -> bool {
+    match (a.state_dir().canonicalize(), b.state_dir().canonicalize()) {
+        (Ok(a), Ok(b)) => a == b,
+        _ => a.state_dir() == b.state_dir(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::maildir_message::MaildirFlags;
+    use crate::{store_add_flags, write_blob, write_blob_mailbox};
+    use filetime::{set_file_mtime, FileTime};
+
+    async fn fixture(store: &MailboxStore) {
+        let user = "alice@example.org";
+        write_blob(store, user, "m1", b"Subject: one\r\n\r\n1")
+            .await
+            .unwrap();
+        write_blob(store, user, "m2", b"Subject: two\r\n\r\n2")
+            .await
+            .unwrap();
+        write_blob_mailbox(store, user, "Archive", "a1", b"Subject: old\r\n\r\na")
+            .await
+            .unwrap();
+        store_add_flags(store, user, "INBOX", "m1", true, false)
+            .await
+            .unwrap();
+        // Imported mail nobody has listed yet: INTERNALDATE comes from the file mtime.
+        let archive = store.maildir_for_mailbox(user, "Archive");
+        let old = archive.cur.join("a2:2,ST");
+        std::fs::write(&old, b"Subject: older\r\n\r\nb").unwrap();
+        set_file_mtime(&old, FileTime::from_unix_time(1_600_000_000, 0)).unwrap();
+    }
+
+    async fn snapshot(
+        store: &MailboxStore,
+        user: &str,
+    ) -> Vec<(String, String, MaildirFlags, u64)> {
+        let mut out = Vec::new();
+        for mailbox in list_user_mailboxes(store, user).await.unwrap() {
+            for m in list_mailbox_messages(store, user, &mailbox).await.unwrap() {
+                let secs = m
+                    .internal_date
+                    .duration_since(SystemTime::UNIX_EPOCH)
+                    .unwrap()
+                    .as_secs();
+                out.push((mailbox.clone(), m.base_id, m.flags, secs));
+            }
+        }
+        out.sort_by(|a, b| (&a.0, &a.1).cmp(&(&b.0, &b.1)));
+        out
+    }
+
+    #[tokio::test]
+    async fn migrates_structure_flags_and_dates() {
+        let (a, b) = (tempfile::tempdir().unwrap(), tempfile::tempdir().unwrap());
+        let src = MailboxStore::new(a.path());
+        let dst = MailboxStore::new(b.path());
+        fixture(&src).await;
+        let user = "alice@example.org";
+
+        let mut ticks = 0;
+        let opts = MigrateOptions {
+            redirect_note: Some("moved".into()),
+            ..Default::default()
+        };
+        let report = migrate_user(&src, &dst, user, &opts, |_| ticks += 1)
+            .await
+            .unwrap();
+        assert_eq!(report.copied(), 4);
+        assert_eq!(ticks, 4);
+        assert_eq!(report.verified, 4);
+        assert_eq!(
+            list_user_mailboxes(&dst, user).await.unwrap(),
+            vec!["INBOX", "Archive"]
+        );
+        assert_eq!(snapshot(&src, user).await, snapshot(&dst, user).await);
+        assert!(snapshot(&dst, user)
+            .await
+            .iter()
+            .any(|(_, id, flags, date)| id == "m1" && flags.seen && *date > 0));
+        assert!(snapshot(&dst, user)
+            .await
+            .iter()
+            .any(|(_, id, flags, date)| id == "a2"
+                && flags.seen
+                && flags.deleted
+                && *date == 1_600_000_000));
+        assert!(is_migrated(&dst, user));
+        assert_eq!(
+            std::fs::read_to_string(user_dir(&src, user).join(REDIRECT_NOTE)).unwrap(),
+            "moved"
+        );
+
+        let again = migrate_user(&src, &dst, user, &opts, |_| {}).await.unwrap();
+        assert!(again.already_migrated);
+    }
+
+    #[tokio::test]
+    async fn resumes_partial_copy() {
+        let (a, b) = (tempfile::tempdir().unwrap(), tempfile::tempdir().unwrap());
+        let src = MailboxStore::new(a.path());
+        let dst = MailboxStore::new(b.path());
+        fixture(&src).await;
+        let user = "alice@example.org";
+        // An interrupted run left m2 behind but no marker.
+        let paths = dst.init_user_dir(user).await.unwrap();
+        let m2 = mailbox_entries(&src, user, "INBOX")
+            .await
+            .unwrap()
+            .into_iter()
+            .find(|e| e.base_id == "m2")
+            .unwrap();
+        let date = list_mailbox_messages(&src, user, "INBOX")
+            .await
+            .unwrap()
+            .into_iter()
+            .find(|m| m.base_id == "m2")
+            .map(|m| m.internal_date);
+        copy_entry(&src, &dst, user, &paths.root, &m2, date)
+            .await
+            .unwrap();
+
+        let report = migrate_user(&src, &dst, user, &MigrateOptions::default(), |_| {})
+            .await
+            .unwrap();
+        assert_eq!(report.copied(), 3);
+        assert_eq!(report.mailboxes[0].skipped, 1);
+        assert_eq!(snapshot(&src, user).await, snapshot(&dst, user).await);
+    }
+
+    #[tokio::test]
+    async fn verify_detects_content_mismatch() {
+        let (a, b) = (tempfile::tempdir().unwrap(), tempfile::tempdir().unwrap());
+        let src = MailboxStore::new(a.path());
+        let dst = MailboxStore::new(b.path());
+        fixture(&src).await;
+        let user = "alice@example.org";
+        migrate_user(&src, &dst, user, &MigrateOptions::default(), |_| {})
+            .await
+            .unwrap();
+        let victim = mailbox_entries(&dst, user, "Archive").await.unwrap();
+        std::fs::write(&victim[0].path, b"tampered").unwrap();
+        assert!(verify_user(&src, &dst, user, 16).await.is_err());
+    }
+
+    #[tokio::test]
+    async fn refuses_same_root() {
+        let a = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(a.path());
+        fixture(&store).await;
+        let err = migrate_user(
+            &store,
+            &MailboxStore::new(a.path()),
+            "alice@example.org",
+            &MigrateOptions::default(),
+            |_| {},
+        )
+        .await;
+        assert!(err.is_err());
+    }
+}
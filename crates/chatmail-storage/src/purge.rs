@@ -22,12 +22,51 @@ use std::time::{Duration, SystemTime};
 
 use chatmail_types::Result;
 
+use crate::cold::dir_names;
 use crate::maildir::MailboxStore;
+use crate::maildir_message::split_maildir_filename;
+use crate::migrate::list_user_mailboxes;
+
+/// Scheduled retention with its per-server modifiers (`storage.imapsql retention_keep_*`).
+///
+/// Each rule only ever protects a message, so when several apply the longest wins.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct RetentionPolicy {
+    pub retention: Duration,
+    /// Never delete `\Flagged` (maildir `F`) messages.
+    pub keep_flagged: bool,
+    /// Unread (`new/`, no `S`) messages are kept at least this long.
+    pub keep_unseen: Option<Duration>,
+}
+
+impl RetentionPolicy {
+    pub fn new(retention: Duration) -> Self {
+        Self {
+            retention,
+            keep_flagged: true,
+            keep_unseen: None,
+        }
+    }
+
+    /// Minimum age before a message may be deleted; `None` = kept regardless of age.
+    pub fn min_age(&self, flagged: bool, unseen: bool) -> Option<Duration> {
+        if flagged && self.keep_flagged {
+            return None;
+        }
+        match self.keep_unseen {
+            Some(keep) if unseen => Some(keep.max(self.retention)),
+            _ => Some(self.retention),
+        }
+    }
+}
 
 /// Delete all message files under `{state_dir}/mail/` (`new/`, `cur/`, `tmp/`).
 pub async fn purge_all_mail_blobs(store: &MailboxStore) -> Result<usize> {
     let mail_root = store.state_dir().join("mail");
-    purge_tree_files(&mail_root, None).await
+    Ok(forget_listings(
+        store,
+        purge_tree_files(&mail_root, None).await?,
+    ))
 }
 
 /// Delete message files older than `retention` (by filesystem mtime).
@@ -36,7 +75,30 @@ pub async fn purge_mail_blobs_older(store: &MailboxStore, retention: Duration) -
     let cutoff = SystemTime::now()
         .checked_sub(retention)
         .unwrap_or(SystemTime::UNIX_EPOCH);
-    purge_tree_files(&mail_root, Some(cutoff)).await
+    Ok(forget_listings(
+        store,
+        purge_tree_files(&mail_root, Some(cutoff)).await?,
+    ))
+}
+
+/// Scheduled retention pass: like [`purge_mail_blobs_older`], honouring `policy` modifiers.
+pub async fn purge_mail_blobs_with_policy(
+    store: &MailboxStore,
+    policy: &RetentionPolicy,
+) -> Result<usize> {
+    purge_by_policy(store, policy, false).await
+}
+
+/// [`prune_unread_older`] that still keeps `\Flagged` messages when the policy says so.
+pub async fn prune_unread_with_policy(
+    store: &MailboxStore,
+    policy: &RetentionPolicy,
+) -> Result<usize> {
+    let unread_only = RetentionPolicy {
+        keep_unseen: None,
+        ..*policy
+    };
+    purge_by_policy(store, &unread_only, true).await
 }
 
 /// Delete all messages for one user (all mailboxes under `mail/{user}/`).
@@ -57,7 +119,7 @@ pub async fn purge_user_messages(store: &MailboxStore, user: &str) -> Result<usi
             deleted += purge_maildir_subdirs(&ent.path(), None).await?;
         }
     }
-    Ok(deleted)
+    Ok(forget_listings(store, deleted))
 }
 
 /// Delete files in `cur/` (maildir “seen” / opened messages).
@@ -73,6 +135,15 @@ pub async fn prune_unread_older(store: &MailboxStore, retention: Duration) -> Re
     purge_maildir_dirs(store, |name| name == "new", Some(cutoff)).await
 }
 
+/// Cached listings describe files that may be gone now; mtimes alone would catch it, but a
+/// concurrent copy-on-write flag swap could otherwise adopt the post-purge mtimes.
+fn forget_listings(store: &MailboxStore, deleted: usize) -> usize {
+    if deleted > 0 {
+        store.invalidate_all_listings();
+    }
+    deleted
+}
+
 fn user_matches_dir(user: &str, dir_name: &str) -> bool {
     let sanitized = user.replace(['/', '\\'], "_");
     dir_name == sanitized || dir_name == user
@@ -114,6 +185,83 @@ where
             }
         }
     }
+    Ok(forget_listings(store, deleted))
+}
+
+/// Retention passes go mailbox by mailbox so open IMAP sessions learn which UIDs vanished
+/// ([`MailboxStore::subscribe_expunges`]) instead of tripping over missing files.
+async fn purge_by_policy(
+    store: &MailboxStore,
+    policy: &RetentionPolicy,
+    unread_only: bool,
+) -> Result<usize> {
+    let now = SystemTime::now();
+    let mut deleted = 0usize;
+    for user in dir_names(&store.state_dir().join("mail")).await? {
+        for mailbox in list_user_mailboxes(store, &user).await? {
+            deleted +=
+                purge_mailbox_by_policy(store, &user, &mailbox, policy, unread_only, now).await?;
+        }
+    }
+    Ok(deleted)
+}
+
+/// One mailbox of [`purge_by_policy`]. Files are removed and their UIDs looked up under the
+/// mailbox index lock, so no listing sees a half-pruned mailbox; the expunge is published after.
+async fn purge_mailbox_by_policy(
+    store: &MailboxStore,
+    user: &str,
+    mailbox: &str,
+    policy: &RetentionPolicy,
+    unread_only: bool,
+    now: SystemTime,
+) -> Result<usize> {
+    let paths = store.maildir_for_mailbox(user, mailbox);
+    let mut deleted = 0usize;
+    let mut expunged: Vec<String> = Vec::new();
+    let guard = store.uidlist().lock_mailbox(user, mailbox).await;
+    for (subdir, dir) in [
+        ("new", &paths.new),
+        ("cur", &paths.cur),
+        ("tmp", &paths.tmp),
+    ] {
+        if subdir == "tmp" && unread_only {
+            continue;
+        }
+        let mut rd = match tokio::fs::read_dir(dir).await {
+            Ok(r) => r,
+            Err(_) => continue,
+        };
+        while let Some(ent) = rd.next_entry().await? {
+            if !ent.file_type().await?.is_file() {
+                continue;
+            }
+            let name = ent.file_name().to_string_lossy().into_owned();
+            let (base_id, flags) = split_maildir_filename(&name);
+            let unseen = subdir == "new" && !flags.seen;
+            if unread_only && !unseen {
+                continue;
+            }
+            let Some(min_age) = policy.min_age(flags.flagged, unseen) else {
+                continue;
+            };
+            let cutoff = now.checked_sub(min_age).unwrap_or(SystemTime::UNIX_EPOCH);
+            if file_older_than(&ent.path(), Some(cutoff)).await? {
+                tokio::fs::remove_file(ent.path()).await?;
+                deleted += 1;
+                // `tmp/` holds unfinished deliveries, never listed, so no session knows them.
+                if subdir != "tmp" {
+                    expunged.push(base_id.to_string());
+                }
+            }
+        }
+    }
+    if expunged.is_empty() {
+        return Ok(deleted);
+    }
+    let uids = store.uidlist().recorded_uids(&paths, &expunged).await?;
+    drop(guard);
+    store.publish_expunge(user, mailbox, uids);
     Ok(deleted)
 }
 
@@ -204,7 +352,7 @@ mod tests {
     use filetime::{set_file_mtime, FileTime};
 
     use super::*;
-    use crate::{list_inbox, write_blob, MailboxStore};
+    use crate::{list_inbox, list_mailbox_messages, write_blob, MailboxExpunge, MailboxStore};
 
     fn touch_unix_epoch(path: &Path) {
         set_file_mtime(path, FileTime::from_unix_time(1, 0)).unwrap();
@@ -310,4 +458,162 @@ mod tests {
         assert_eq!(left.len(), 1);
         assert_eq!(left[0].msg_id, "new");
     }
+
+    #[tokio::test]
+    async fn retention_purge_publishes_expunged_uids() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let paths = store.init_user_dir("u@test").await.unwrap();
+        write_blob(&store, "u@test", "old", b"o").await.unwrap();
+        write_blob(&store, "u@test", "new", b"n").await.unwrap();
+        touch_unix_epoch(&paths.new.join("old"));
+        let listed = list_mailbox_messages(&store, "u@test", "INBOX")
+            .await
+            .unwrap();
+        let old_uid = listed.iter().find(|m| m.base_id == "old").unwrap().uid;
+        let mut rx = store.subscribe_expunges();
+        let generation = store.expunge_generation();
+
+        let policy = RetentionPolicy::new(Duration::from_secs(3600));
+        let n = purge_mail_blobs_with_policy(&store, &policy).await.unwrap();
+        assert_eq!(n, 1);
+        assert_eq!(
+            rx.try_recv().unwrap(),
+            MailboxExpunge {
+                user: "u@test".into(),
+                mailbox: "INBOX".into(),
+                uids: vec![old_uid],
+            }
+        );
+        assert!(
+            rx.try_recv().is_err(),
+            "untouched mailboxes publish nothing"
+        );
+        assert!(store.expunge_generation() > generation);
+        let left = list_mailbox_messages(&store, "u@test", "INBOX")
+            .await
+            .unwrap();
+        assert_eq!(left.len(), 1);
+        assert_eq!(left[0].base_id, "new");
+    }
+
+    /// Lay out one message per flag/age combination; returns the user's mailbox paths.
+    async fn retention_fixture(store: &MailboxStore) -> crate::MaildirPaths {
+        let paths = store.init_user_dir("u@test").await.unwrap();
+        let two_hours_ago = FileTime::from_unix_time(FileTime::now().unix_seconds() - 2 * 3600, 0);
+        // (file, dir is cur, mtime)
+        let layout = [
+            ("seen-old:2,S", true, Some(two_hours_ago)),
+            ("seen-fresh:2,S", true, None),
+            ("unseen-old", false, Some(two_hours_ago)),
+            (
+                "unseen-ancient",
+                false,
+                Some(FileTime::from_unix_time(1, 0)),
+            ),
+            ("unseen-fresh", false, None),
+            (
+                "flagged-seen-ancient:2,FS",
+                true,
+                Some(FileTime::from_unix_time(1, 0)),
+            ),
+            (
+                "flagged-unseen-ancient:2,F",
+                false,
+                Some(FileTime::from_unix_time(1, 0)),
+            ),
+        ];
+        for (name, cur, mtime) in layout {
+            let dir = if cur { &paths.cur } else { &paths.new };
+            let path = dir.join(name);
+            tokio::fs::write(&path, b"x").await.unwrap();
+            if let Some(t) = mtime {
+                set_file_mtime(&path, t).unwrap();
+            }
+        }
+        paths
+    }
+
+    fn survivors(paths: &crate::MaildirPaths) -> Vec<String> {
+        let mut out: Vec<String> = [&paths.new, &paths.cur]
+            .iter()
+            .flat_map(|d| std::fs::read_dir(d).unwrap())
+            .map(|e| e.unwrap().file_name().to_string_lossy().into_owned())
+            .collect();
+        out.sort();
+        out
+    }
+
+    #[tokio::test]
+    async fn retention_policy_keeps_flagged_and_recent_unseen() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let paths = retention_fixture(&store).await;
+        let policy = RetentionPolicy {
+            keep_unseen: Some(Duration::from_secs(24 * 3600)),
+            ..RetentionPolicy::new(Duration::from_secs(3600))
+        };
+
+        let n = purge_mail_blobs_with_policy(&store, &policy).await.unwrap();
+        assert_eq!(n, 2);
+        assert_eq!(
+            survivors(&paths),
+            [
+                "flagged-seen-ancient:2,FS",
+                "flagged-unseen-ancient:2,F",
+                "seen-fresh:2,S",
+                "unseen-fresh",
+                "unseen-old",
+            ]
+        );
+    }
+
+    #[tokio::test]
+    async fn retention_policy_without_modifiers_matches_plain_age_purge() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let paths = retention_fixture(&store).await;
+        let policy = RetentionPolicy {
+            keep_flagged: false,
+            ..RetentionPolicy::new(Duration::from_secs(3600))
+        };
+
+        let n = purge_mail_blobs_with_policy(&store, &policy).await.unwrap();
+        assert_eq!(n, 5);
+        assert_eq!(survivors(&paths), ["seen-fresh:2,S", "unseen-fresh"]);
+    }
+
+    #[test]
+    fn keep_unseen_shorter_than_retention_does_not_shorten_it() {
+        let policy = RetentionPolicy {
+            keep_unseen: Some(Duration::from_secs(60)),
+            ..RetentionPolicy::new(Duration::from_secs(3600))
+        };
+        assert_eq!(policy.min_age(false, true), Some(Duration::from_secs(3600)));
+        assert_eq!(policy.min_age(true, true), None);
+    }
+
+    #[tokio::test]
+    async fn prune_unread_with_policy_skips_flagged_and_seen() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let paths = retention_fixture(&store).await;
+        let policy = RetentionPolicy {
+            keep_unseen: Some(Duration::from_secs(24 * 3600)),
+            ..RetentionPolicy::new(Duration::from_secs(3600))
+        };
+
+        let n = prune_unread_with_policy(&store, &policy).await.unwrap();
+        assert_eq!(n, 2);
+        assert_eq!(
+            survivors(&paths),
+            [
+                "flagged-seen-ancient:2,FS",
+                "flagged-unseen-ancient:2,F",
+                "seen-fresh:2,S",
+                "seen-old:2,S",
+                "unseen-fresh",
+            ]
+        );
+    }
 }
@@ -17,6 +17,8 @@
 
 //! Maildir durability and throughput policy (Dovecot `mail_fsync` parity).
 
+use crate::cold::ColdPolicy;
+
 /// When to fsync message files and maildir directories after delivery.
 #[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
 pub enum FsyncMode {
@@ -60,6 +62,8 @@ pub struct StoragePolicy {
     pub cas_enabled: bool,
     /// APPEND bodies at or above this size stream socket → tmp instead of a full `Vec` first.
     pub stream_threshold: usize,
+    /// Pack old message bodies into the cold tier ([`crate::cold`]); `None` keeps all mail hot.
+    pub cold: Option<ColdPolicy>,
 }
 
 impl Default for StoragePolicy {
@@ -68,6 +72,7 @@ impl Default for StoragePolicy {
             fsync_mode: FsyncMode::Always,
             cas_enabled: true,
             stream_threshold: 64 * 1024,
+            cold: None,
         }
     }
 }
@@ -0,0 +1,290 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Account takeout: every mailbox of one user packed into a zip archive.
+//!
+//! Entries are stored, not deflated: chatmail messages are mostly OpenPGP ciphertext and do
+//! not compress. There is no zip64 support, so an archive is limited to 4 GiB and 65 535
+//! messages (far above any quota); larger accounts fail instead of producing a corrupt file.
+
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
+
+use chatmail_types::{ChatmailError, Result};
+use tokio::io::{AsyncWriteExt, BufWriter};
+
+use crate::cold::read_message_file;
+use crate::maildir::MailboxStore;
+use crate::migrate::list_user_mailboxes;
+
+const LOCAL_HEADER_SIG: u32 = 0x0403_4b50;
+const CENTRAL_HEADER_SIG: u32 = 0x0201_4b50;
+const END_OF_CENTRAL_DIR_SIG: u32 = 0x0605_4b50;
+/// General purpose flag bit 11: names are UTF-8.
+const FLAG_UTF8: u16 = 0x0800;
+/// 1980-01-01 00:00 in MS-DOS format; message dates live in the headers anyway.
+const DOS_DATE: u16 = 0x0021;
+const MAX_ENTRIES: usize = u16::MAX as usize;
+
+/// Live counters for a running export (read by the job status endpoint).
+#[derive(Debug, Default)]
+pub struct TakeoutProgress {
+    pub messages_total: AtomicU64,
+    pub messages_done: AtomicU64,
+    pub bytes_written: AtomicU64,
+}
+
+impl TakeoutProgress {
+    /// `(messages_done, messages_total, bytes_written)`.
+    pub fn snapshot(&self) -> (u64, u64, u64) {
+        (
+            self.messages_done.load(Ordering::Relaxed),
+            self.messages_total.load(Ordering::Relaxed),
+            self.bytes_written.load(Ordering::Relaxed),
+        )
+    }
+}
+
+struct Member {
+    name: String,
+    mailbox: String,
+    path: PathBuf,
+}
+
+struct CentralEntry {
+    name: String,
+    crc: u32,
+    size: u32,
+    offset: u32,
+}
+
+/// Message files of every mailbox as `(archive name, path)`: `INBOX/cur/…`, `Folder/new/…`.
+async fn collect_members(store: &MailboxStore, user: &str) -> Result<(Vec<Member>, u64)> {
+    let mut members = Vec::new();
+    let mut bytes = 0u64;
+    for mailbox in list_user_mailboxes(store, user).await? {
+        let paths = store.maildir_for_mailbox(user, &mailbox);
+        for (sub, dir) in [("cur", &paths.cur), ("new", &paths.new)] {
+            let mut rd = match tokio::fs::read_dir(dir).await {
+                Ok(r) => r,
+                Err(e) if e.kind() == std::io::ErrorKind::NotFound => continue,
+                Err(e) => return Err(e.into()),
+            };
+            while let Some(ent) = rd.next_entry().await? {
+                let meta = ent.metadata().await?;
+                if !meta.is_file() {
+                    continue;
+                }
+                bytes += meta.len();
+                members.push(Member {
+                    name: format!("{mailbox}/{sub}/{}", ent.file_name().to_string_lossy()),
+                    mailbox: mailbox.clone(),
+                    path: ent.path(),
+                });
+            }
+        }
+    }
+    members.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok((members, bytes))
+}
+
+/// Messages and bytes an archive of `user` would hold right now (for disk reservations).
+pub async fn takeout_size(store: &MailboxStore, user: &str) -> Result<(u64, u64)> {
+    let (members, bytes) = collect_members(store, user).await?;
+    Ok((members.len() as u64, bytes))
+}
+
+fn too_large() -> ChatmailError {
+    ChatmailError::config("mailbox too large for a takeout archive (4 GiB / 65535 messages)")
+}
+
+/// Write the zip archive for `user` to `dest` and return its size in bytes.
+///
+/// Messages removed while the export runs are skipped.
+pub async fn write_takeout_archive(
+    store: &MailboxStore,
+    user: &str,
+    dest: &Path,
+    progress: &TakeoutProgress,
+) -> Result<u64> {
+    let (members, _) = collect_members(store, user).await?;
+    if members.len() > MAX_ENTRIES {
+        return Err(too_large());
+    }
+    progress
+        .messages_total
+        .store(members.len() as u64, Ordering::Relaxed);
+
+    let mut out = BufWriter::new(tokio::fs::File::create(dest).await?);
+    let mut offset = 0u64;
+    let mut central = Vec::with_capacity(members.len());
+    for member in members {
+        let body = match read_message_file(store, user, &member.mailbox, &member.path).await? {
+            Some(b) => b,
+            // Expunged or moved to `cur/` since the listing.
+            None => {
+                progress.messages_done.fetch_add(1, Ordering::Relaxed);
+                continue;
+            }
+        };
+        let size = u32::try_from(body.len()).map_err(|_| too_large())?;
+        let entry = CentralEntry {
+            crc: crc32fast::hash(&body),
+            size,
+            offset: u32::try_from(offset).map_err(|_| too_large())?,
+            name: member.name,
+        };
+        let mut header = Vec::with_capacity(30 + entry.name.len());
+        header.extend_from_slice(&LOCAL_HEADER_SIG.to_le_bytes());
+        header.extend_from_slice(&10u16.to_le_bytes()); // version needed: stored
+        header.extend_from_slice(&FLAG_UTF8.to_le_bytes());
+        header.extend_from_slice(&0u16.to_le_bytes()); // method: stored
+        header.extend_from_slice(&0u16.to_le_bytes()); // time
+        header.extend_from_slice(&DOS_DATE.to_le_bytes());
+        header.extend_from_slice(&entry.crc.to_le_bytes());
+        header.extend_from_slice(&entry.size.to_le_bytes());
+        header.extend_from_slice(&entry.size.to_le_bytes());
+        header.extend_from_slice(&(entry.name.len() as u16).to_le_bytes());
+        header.extend_from_slice(&0u16.to_le_bytes()); // extra length
+        header.extend_from_slice(entry.name.as_bytes());
+        out.write_all(&header).await?;
+        out.write_all(&body).await?;
+        offset += (header.len() + body.len()) as u64;
+        central.push(entry);
+        progress.messages_done.fetch_add(1, Ordering::Relaxed);
+        progress.bytes_written.store(offset, Ordering::Relaxed);
+    }
+
+    let cd_offset = u32::try_from(offset).map_err(|_| too_large())?;
+    let mut cd = Vec::new();
+    for entry in &central {
+        cd.extend_from_slice(&CENTRAL_HEADER_SIG.to_le_bytes());
+        cd.extend_from_slice(&20u16.to_le_bytes()); // version made by
+        cd.extend_from_slice(&10u16.to_le_bytes()); // version needed
+        cd.extend_from_slice(&FLAG_UTF8.to_le_bytes());
+        cd.extend_from_slice(&0u16.to_le_bytes()); // method
+        cd.extend_from_slice(&0u16.to_le_bytes()); // time
+        cd.extend_from_slice(&DOS_DATE.to_le_bytes());
+        cd.extend_from_slice(&entry.crc.to_le_bytes());
+        cd.extend_from_slice(&entry.size.to_le_bytes());
+        cd.extend_from_slice(&entry.size.to_le_bytes());
+        cd.extend_from_slice(&(entry.name.len() as u16).to_le_bytes());
+        cd.extend_from_slice(&[0u8; 12]); // extra, comment, disk, internal attr, external attr
+        cd.extend_from_slice(&entry.offset.to_le_bytes());
+        cd.extend_from_slice(entry.name.as_bytes());
+    }
+    let cd_size = u32::try_from(cd.len()).map_err(|_| too_large())?;
+    cd_offset.checked_add(cd_size).ok_or_else(too_large)?;
+    let count = central.len() as u16;
+    cd.extend_from_slice(&END_OF_CENTRAL_DIR_SIG.to_le_bytes());
+    cd.extend_from_slice(&[0u8; 4]); // disk numbers
+    cd.extend_from_slice(&count.to_le_bytes());
+    cd.extend_from_slice(&count.to_le_bytes());
+    cd.extend_from_slice(&cd_size.to_le_bytes());
+    cd.extend_from_slice(&cd_offset.to_le_bytes());
+    cd.extend_from_slice(&0u16.to_le_bytes()); // comment length
+    out.write_all(&cd).await?;
+    out.flush().await?;
+    out.into_inner().sync_all().await?;
+
+    let total = offset + cd.len() as u64;
+    progress.bytes_written.store(total, Ordering::Relaxed);
+    Ok(total)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{write_blob, write_blob_mailbox};
+
+    fn u16_at(buf: &[u8], at: usize) -> u16 {
+        u16::from_le_bytes([buf[at], buf[at + 1]])
+    }
+
+    fn u32_at(buf: &[u8], at: usize) -> u32 {
+        u32::from_le_bytes(buf[at..at + 4].try_into().unwrap())
+    }
+
+    /// Names and bodies from the central directory of a stored-only archive.
+    fn read_entries(zip: &[u8]) -> Vec<(String, Vec<u8>)> {
+        let eocd = zip.len() - 22;
+        assert_eq!(u32_at(zip, eocd), END_OF_CENTRAL_DIR_SIG);
+        let count = u16_at(zip, eocd + 10) as usize;
+        let mut at = u32_at(zip, eocd + 16) as usize;
+        let mut out = Vec::new();
+        for _ in 0..count {
+            assert_eq!(u32_at(zip, at), CENTRAL_HEADER_SIG);
+            let size = u32_at(zip, at + 24) as usize;
+            let name_len = u16_at(zip, at + 28) as usize;
+            let local = u32_at(zip, at + 42) as usize;
+            let name = String::from_utf8(zip[at + 46..at + 46 + name_len].to_vec()).unwrap();
+            assert_eq!(u32_at(zip, local), LOCAL_HEADER_SIG);
+            let data = local + 30 + u16_at(zip, local + 26) as usize;
+            let body = zip[data..data + size].to_vec();
+            assert_eq!(crc32fast::hash(&body), u32_at(zip, at + 16));
+            out.push((name, body));
+            at += 46 + name_len;
+        }
+        out
+    }
+
+    #[tokio::test]
+    async fn archive_holds_every_mailbox() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let user = "alice@test";
+        store.init_user_dir(user).await.unwrap();
+        write_blob(&store, user, "m1", b"Subject: one\r\n\r\n1")
+            .await
+            .unwrap();
+        write_blob(&store, user, "m2", b"Subject: two\r\n\r\n2")
+            .await
+            .unwrap();
+        write_blob_mailbox(&store, user, "Archive", "m3", b"Subject: three\r\n\r\n3")
+            .await
+            .unwrap();
+
+        assert_eq!(takeout_size(&store, user).await.unwrap().0, 3);
+        let dest = tmp.path().join("out.zip");
+        let progress = TakeoutProgress::default();
+        let size = write_takeout_archive(&store, user, &dest, &progress)
+            .await
+            .unwrap();
+        let zip = std::fs::read(&dest).unwrap();
+        assert_eq!(zip.len() as u64, size);
+        assert_eq!(progress.snapshot(), (3, 3, size));
+
+        let entries = read_entries(&zip);
+        let names: Vec<_> = entries.iter().map(|(n, _)| n.as_str()).collect();
+        assert!(names[0].starts_with("Archive/new/m3"), "{names:?}");
+        assert!(names[1].starts_with("INBOX/new/m1"), "{names:?}");
+        assert_eq!(entries[2].1, b"Subject: two\r\n\r\n2");
+    }
+
+    #[tokio::test]
+    async fn empty_account_gives_empty_archive() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = MailboxStore::new(tmp.path());
+        let dest = tmp.path().join("empty.zip");
+        let progress = TakeoutProgress::default();
+        let size = write_takeout_archive(&store, "nobody@test", &dest, &progress)
+            .await
+            .unwrap();
+        assert_eq!(size, 22);
+        assert!(read_entries(&std::fs::read(&dest).unwrap()).is_empty());
+    }
+}
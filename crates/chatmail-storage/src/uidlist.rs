@@ -44,14 +44,14 @@ use std::time::{Duration, UNIX_EPOCH};
 use chatmail_types::{ChatmailError, Result};
 use dashmap::DashMap;
 use tokio::fs;
-use tokio::sync::Mutex;
+use tokio::sync::{Mutex, OwnedMutexGuard};
 
 use crate::maildir::MaildirPaths;
 use crate::maildir_message::{split_maildir_filename, MaildirFlags, StoredMessage};
 use crate::storage_policy::FsyncMode;
 
 /// Index filename at the maildir root (alongside `new/`, `cur/`, `tmp/`).
-const UIDLIST_FILE: &str = "chatmail-uidlist";
+pub const UIDLIST_FILE: &str = "chatmail-uidlist";
 /// Atomic-write staging name inside `tmp/` (per-mailbox lock makes a fixed name safe).
 const UIDLIST_TMP: &str = ".chatmail-uidlist.tmp";
 /// File format version (header first token).
@@ -110,6 +110,11 @@ impl UidListStore {
             .clone()
     }
 
+    /// Hold the mailbox's index lock: no listing runs until the guard drops.
+    pub(crate) async fn lock_mailbox(&self, user: &str, mailbox: &str) -> OwnedMutexGuard<()> {
+        self.lock_for(user, mailbox).lock_owned().await
+    }
+
     /// Reconcile the persisted index with the maildir and return the listing with stable UIDs.
     ///
     /// Reads `chatmail-uidlist`, `readdir`s `new/`+`cur/`, assigns fresh UIDs to never-seen files
@@ -230,6 +235,23 @@ impl UidListStore {
         UID_VALIDITY
     }
 
+    /// UIDs the index has recorded for `base_ids`; ids it never indexed are skipped. The
+    /// caller holds [`Self::lock_mailbox`], so the records cannot change underneath.
+    pub(crate) async fn recorded_uids(
+        &self,
+        paths: &MaildirPaths,
+        base_ids: &[String],
+    ) -> Result<Vec<u32>> {
+        if base_ids.is_empty() {
+            return Ok(Vec::new());
+        }
+        let data = read_uidlist(&paths.root.join(UIDLIST_FILE)).await?;
+        Ok(base_ids
+            .iter()
+            .filter_map(|id| data.records.get(id).map(|r| r.uid))
+            .collect())
+    }
+
     /// Dovecot-style eager registration at commit time.
     ///
     /// Called by the message commit path (right after the file is placed in new/)
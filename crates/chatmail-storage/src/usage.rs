@@ -0,0 +1,345 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Disk usage of the mail store as the filesystem sees it, and orphaned CAS blobs.
+//!
+//! The quota cache sums maildir file sizes per account, so a body delivered to ten local
+//! recipients counts ten times while sharing one inode, and a `blobs/` entry whose maildir
+//! links were all expunged counts nowhere. [`storage_usage`] walks `{state_dir}/mail/` and
+//! `{state_dir}/blobs/` once, counting every inode a single time; a blob whose link count is
+//! one is referenced by no mailbox and is an orphan. [`collect_orphan_blobs`] removes them
+//! (`imap-acct gc`).
+//!
+//! Both are full tree walks: the server keeps the last scan in `chatmail-state`'s
+//! `StorageUsageMonitor`. There is no body compression, so the only gap between logical and
+//! physical bytes is deduplication (and allocation overhead, reported separately).
+
+use std::collections::HashSet;
+use std::path::{Path, PathBuf};
+use std::time::{Duration, SystemTime};
+
+use chatmail_types::Result;
+
+use crate::maildir::MailboxStore;
+
+/// Blobs younger than this are never orphans: delivery writes the blob before linking it.
+pub const ORPHAN_MIN_AGE: Duration = Duration::from_secs(3600);
+
+/// Result of [`storage_usage`].
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct StorageUsage {
+    /// Message files under `mail/*/…/{cur,new}/`.
+    pub messages: u64,
+    /// Sum of message file sizes (what per-account quota counts).
+    pub logical_bytes: u64,
+    /// Apparent size of messages and blobs, each inode counted once.
+    pub physical_bytes: u64,
+    /// Blocks allocated for the same inodes (filesystem overhead included).
+    pub allocated_bytes: u64,
+    /// Files under `blobs/`.
+    pub blobs: u64,
+    pub blob_bytes: u64,
+    /// Blobs no maildir links to, older than [`ORPHAN_MIN_AGE`].
+    pub orphan_blobs: u64,
+    pub orphan_bytes: u64,
+}
+
+impl StorageUsage {
+    /// `logical_bytes / physical_bytes`: above 1.0 when CAS shares bodies between mailboxes.
+    pub fn dedup_ratio(&self) -> Option<f64> {
+        (self.physical_bytes > 0).then(|| self.logical_bytes as f64 / self.physical_bytes as f64)
+    }
+}
+
+/// Result of [`collect_orphan_blobs`].
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct OrphanSweep {
+    pub removed: u64,
+    pub removed_bytes: u64,
+}
+
+/// Walk the mail store and the blob directory; see the module docs.
+pub async fn storage_usage(store: &MailboxStore) -> Result<StorageUsage> {
+    let mut usage = StorageUsage::default();
+    let mut seen = HashSet::new();
+    for path in message_files(&store.state_dir().join("mail")).await? {
+        let Ok(meta) = tokio::fs::metadata(&path).await else {
+            continue;
+        };
+        usage.messages += 1;
+        usage.logical_bytes += meta.len();
+        if seen.insert(inode(&meta, &path)) {
+            usage.physical_bytes += meta.len();
+            usage.allocated_bytes += allocated(&meta);
+        }
+    }
+    let cutoff = SystemTime::now()
+        .checked_sub(ORPHAN_MIN_AGE)
+        .unwrap_or(SystemTime::UNIX_EPOCH);
+    for path in blob_files(store).await? {
+        let Ok(meta) = tokio::fs::metadata(&path).await else {
+            continue;
+        };
+        usage.blobs += 1;
+        usage.blob_bytes += meta.len();
+        if seen.insert(inode(&meta, &path)) {
+            usage.physical_bytes += meta.len();
+            usage.allocated_bytes += allocated(&meta);
+        }
+        if is_orphan(&meta, cutoff) {
+            usage.orphan_blobs += 1;
+            usage.orphan_bytes += meta.len();
+        }
+    }
+    Ok(usage)
+}
+
+/// Remove blobs no maildir links to that are older than `min_age` (at least
+/// [`ORPHAN_MIN_AGE`]). With `dry_run` nothing is removed; the totals are what would be.
+///
+/// The link count is re-read right before each unlink. A delivery that deduplicates against
+/// an orphan in that instant fails its hard link and is retried by the sender.
+pub async fn collect_orphan_blobs(
+    store: &MailboxStore,
+    min_age: Duration,
+    dry_run: bool,
+) -> Result<OrphanSweep> {
+    let cutoff = SystemTime::now()
+        .checked_sub(min_age.max(ORPHAN_MIN_AGE))
+        .unwrap_or(SystemTime::UNIX_EPOCH);
+    let mut sweep = OrphanSweep::default();
+    for path in blob_files(store).await? {
+        let Ok(meta) = tokio::fs::metadata(&path).await else {
+            continue;
+        };
+        if !is_orphan(&meta, cutoff) {
+            continue;
+        }
+        if !dry_run {
+            match tokio::fs::remove_file(&path).await {
+                Ok(()) => {}
+                Err(e) if e.kind() == std::io::ErrorKind::NotFound => continue,
+                Err(e) => return Err(e.into()),
+            }
+        }
+        sweep.removed += 1;
+        sweep.removed_bytes += meta.len();
+    }
+    Ok(sweep)
+}
+
+/// Files in every `cur/` and `new/` directory below `root` (`tmp/` holds partial writes).
+async fn message_files(root: &Path) -> Result<Vec<PathBuf>> {
+    let mut files = Vec::new();
+    let mut stack = vec![root.to_path_buf()];
+    while let Some(dir) = stack.pop() {
+        let mut rd = match tokio::fs::read_dir(&dir).await {
+            Ok(r) => r,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => continue,
+            Err(e) => return Err(e.into()),
+        };
+        let is_mail_dir = matches!(
+            dir.file_name().and_then(|n| n.to_str()),
+            Some("cur" | "new")
+        );
+        while let Some(ent) = rd.next_entry().await? {
+            let ft = ent.file_type().await?;
+            if ft.is_dir() {
+                if ent.file_name() != "tmp" {
+                    stack.push(ent.path());
+                }
+            } else if ft.is_file() && is_mail_dir {
+                files.push(ent.path());
+            }
+        }
+    }
+    Ok(files)
+}
+
+/// Committed blobs under `blobs/xx/`; `.part` files are writes in flight.
+async fn blob_files(store: &MailboxStore) -> Result<Vec<PathBuf>> {
+    let mut files = Vec::new();
+    let mut shards = match tokio::fs::read_dir(store.state_dir().join("blobs")).await {
+        Ok(r) => r,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(files),
+        Err(e) => return Err(e.into()),
+    };
+    while let Some(shard) = shards.next_entry().await? {
+        if !shard.file_type().await?.is_dir() {
+            continue;
+        }
+        let mut rd = tokio::fs::read_dir(shard.path()).await?;
+        while let Some(ent) = rd.next_entry().await? {
+            let path = ent.path();
+            if ent.file_type().await?.is_file()
+                && path.extension().map_or(true, |ext| ext != "part")
+            {
+                files.push(path);
+            }
+        }
+    }
+    Ok(files)
+}
+
+fn is_orphan(meta: &std::fs::Metadata, cutoff: SystemTime) -> bool {
+    link_count(meta) == Some(1) && meta.modified().map_or(false, |m| m < cutoff)
+}
+
+#[cfg(unix)]
+fn link_count(meta: &std::fs::Metadata) -> Option<u64> {
+    use std::os::unix::fs::MetadataExt;
+    Some(meta.nlink())
+}
+
+/// Link counts are unknown here, so no blob is ever reported or collected as an orphan.
+#[cfg(not(unix))]
+fn link_count(_meta: &std::fs::Metadata) -> Option<u64> {
+    None
+}
+
+#[cfg(unix)]
+fn inode(meta: &std::fs::Metadata, _path: &Path) -> (u64, u64, Option<PathBuf>) {
+    use std::os::unix::fs::MetadataExt;
+    (meta.dev(), meta.ino(), None)
+}
+
+#[cfg(not(unix))]
+fn inode(_meta: &std::fs::Metadata, path: &Path) -> (u64, u64, Option<PathBuf>) {
+    (0, 0, Some(path.to_path_buf()))
+}
+
+#[cfg(unix)]
+fn allocated(meta: &std::fs::Metadata) -> u64 {
+    use std::os::unix::fs::MetadataExt;
+    meta.blocks() * 512
+}
+
+#[cfg(not(unix))]
+fn allocated(meta: &std::fs::Metadata) -> u64 {
+    meta.len()
+}
+
+#[cfg(all(test, unix))]
+mod tests {
+    use super::*;
+    use crate::blob::write_blob;
+    use crate::storage_policy::StoragePolicy;
+
+    fn cas_store(dir: &Path) -> MailboxStore {
+        MailboxStore::with_policy(
+            dir,
+            StoragePolicy {
+                cas_enabled: true,
+                ..StoragePolicy::default()
+            },
+        )
+    }
+
+    /// Age every blob past [`ORPHAN_MIN_AGE`].
+    async fn age_blobs(store: &MailboxStore) {
+        let old = filetime::FileTime::from_unix_time(1_000_000_000, 0);
+        for path in blob_files(store).await.unwrap() {
+            filetime::set_file_mtime(&path, old).unwrap();
+        }
+    }
+
+    #[tokio::test]
+    async fn shared_body_counts_once_on_disk() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = cas_store(tmp.path());
+        for user in ["a@test", "b@test", "c@test"] {
+            write_blob(&store, user, "m1", b"same body").await.unwrap();
+        }
+        let usage = storage_usage(&store).await.unwrap();
+        assert_eq!(usage.messages, 3);
+        assert_eq!(usage.logical_bytes, 27);
+        assert_eq!(usage.blobs, 1);
+        assert_eq!(usage.physical_bytes, 9);
+        assert_eq!(usage.dedup_ratio(), Some(3.0));
+        assert_eq!(usage.orphan_blobs, 0);
+    }
+
+    #[tokio::test]
+    async fn expunged_messages_leave_orphans_for_gc() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = cas_store(tmp.path());
+        write_blob(&store, "a@test", "m1", b"first").await.unwrap();
+        write_blob(&store, "a@test", "m2", b"second!")
+            .await
+            .unwrap();
+        write_blob(&store, "b@test", "m3", b"second!")
+            .await
+            .unwrap();
+        age_blobs(&store).await;
+
+        // Drop a's mailbox entries behind the store's back, as a crash or manual cleanup would.
+        let paths = store.maildir_for_user("a@test");
+        std::fs::remove_file(paths.new.join("m1")).unwrap();
+        std::fs::remove_file(paths.new.join("m2")).unwrap();
+
+        let usage = storage_usage(&store).await.unwrap();
+        assert_eq!(usage.messages, 1);
+        assert_eq!(usage.blobs, 2);
+        assert_eq!(usage.orphan_blobs, 1, "b still links the shared body");
+        assert_eq!(usage.orphan_bytes, 5);
+
+        let dry = collect_orphan_blobs(&store, ORPHAN_MIN_AGE, true)
+            .await
+            .unwrap();
+        assert_eq!(dry.removed, 1);
+        assert_eq!(storage_usage(&store).await.unwrap().blobs, 2);
+
+        let swept = collect_orphan_blobs(&store, ORPHAN_MIN_AGE, false)
+            .await
+            .unwrap();
+        assert_eq!(
+            swept,
+            OrphanSweep {
+                removed: 1,
+                removed_bytes: 5
+            }
+        );
+        let after = storage_usage(&store).await.unwrap();
+        assert_eq!((after.blobs, after.orphan_blobs), (1, 0));
+        assert_eq!(after.physical_bytes, 7);
+    }
+
+    #[tokio::test]
+    async fn fresh_unlinked_blob_is_not_an_orphan() {
+        let tmp = tempfile::tempdir().unwrap();
+        let store = cas_store(tmp.path());
+        write_blob(&store, "a@test", "m1", b"body").await.unwrap();
+        std::fs::remove_file(store.maildir_for_user("a@test").new.join("m1")).unwrap();
+
+        assert_eq!(storage_usage(&store).await.unwrap().orphan_blobs, 0);
+        let swept = collect_orphan_blobs(&store, Duration::ZERO, false)
+            .await
+            .unwrap();
+        assert_eq!(
+            swept.removed, 0,
+            "grace period applies even with a zero min_age"
+        );
+    }
+
+    #[tokio::test]
+    async fn empty_state_dir_reports_zero() {
+        let tmp = tempfile::tempdir().unwrap();
+        let usage = storage_usage(&MailboxStore::new(tmp.path())).await.unwrap();
+        assert_eq!(usage, StorageUsage::default());
+        assert_eq!(usage.dedup_ratio(), None);
+    }
+}
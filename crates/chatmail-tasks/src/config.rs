@@ -21,8 +21,11 @@ use std::time::Duration;
 
 use chatmail_config::{parse_duration, AppConfig};
 use chatmail_db::{effective_message_retention, DbPool};
+use chatmail_storage::RetentionPolicy;
 use chatmail_types::{ChatmailError, Result};
 
+use crate::db_maintenance::DEFAULT_MAX_ACTIVE_DELIVERIES;
+
 /// How often periodic jobs run in the server process (Madmail: 1 hour).
 pub const PERIODIC_INTERVAL: Duration = Duration::from_secs(3600);
 
@@ -32,10 +35,52 @@ pub const AUTO_PURGE_SEEN_INTERVAL: Duration = Duration::from_secs(15);
 /// Let's Encrypt renewal check when `tls_mode = autocert` (daily; IP certs renew within 4d of expiry).
 pub const CERT_RENEWAL_INTERVAL: Duration = Duration::from_secs(24 * 3600);
 
+/// `db_maintenance_schedule` aliases; anything else is a duration.
+const DB_MAINTENANCE_SCHEDULES: &[(&str, Duration)] = &[
+    ("daily", Duration::from_secs(24 * 3600)),
+    ("weekly", Duration::from_secs(7 * 24 * 3600)),
+    ("monthly", Duration::from_secs(30 * 24 * 3600)),
+];
+
+/// What `prune-inactive-accounts` does to a matching account.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum InactiveAccountAction {
+    /// Remove credentials, quota row and maildir; no blocklist (like unused accounts).
+    #[default]
+    Delete,
+    /// Blocklist with [`INACTIVE_SUSPEND_REASON`]; credentials and maildir stay.
+    Suspend,
+}
+
+/// Blocklist reason for suspended inactive accounts (unblock to reactivate).
+pub const INACTIVE_SUSPEND_REASON: &str = "suspended: inactive account";
+
+impl InactiveAccountAction {
+    pub fn parse(raw: Option<&str>) -> Result<Self> {
+        match raw.map(str::trim).unwrap_or("") {
+            "" | "delete" => Ok(Self::Delete),
+            "suspend" => Ok(Self::Suspend),
+            other => Err(ChatmailError::config(format!(
+                "invalid inactive_account_action {other:?} (use delete or suspend)"
+            ))),
+        }
+    }
+}
+
 #[derive(Debug, Clone, PartialEq, Eq)]
 pub struct MaintenanceConfig {
     pub message_retention: Option<Duration>,
     pub unused_account_retention: Option<Duration>,
+    pub inactive_account_retention: Option<Duration>,
+    pub inactive_account_action: InactiveAccountAction,
+    /// `retention_keep_flagged` (default on).
+    pub keep_flagged: bool,
+    /// `retention_keep_unseen`.
+    pub keep_unseen: Option<Duration>,
+    /// `db_maintenance_schedule`; `None` leaves `db-maintenance` to the CLI.
+    pub db_maintenance_interval: Option<Duration>,
+    /// `db_maintenance_max_deliveries` — skip `db-maintenance` above this many in flight.
+    pub db_maintenance_max_deliveries: usize,
 }
 
 impl MaintenanceConfig {
@@ -45,6 +90,20 @@ impl MaintenanceConfig {
             unused_account_retention: optional_duration(
                 config.unused_account_retention.as_deref(),
             )?,
+            inactive_account_retention: optional_duration(
+                config.inactive_account_retention.as_deref(),
+            )?,
+            inactive_account_action: InactiveAccountAction::parse(
+                config.inactive_account_action.as_deref(),
+            )?,
+            keep_flagged: config.effective_retention_keep_flagged(),
+            keep_unseen: optional_duration(config.retention_keep_unseen.as_deref())?,
+            db_maintenance_interval: db_maintenance_interval(
+                config.db_maintenance_schedule.as_deref(),
+            )?,
+            db_maintenance_max_deliveries: config
+                .db_maintenance_max_deliveries
+                .unwrap_or(DEFAULT_MAX_ACTIVE_DELIVERIES),
         })
     }
 
@@ -56,14 +115,54 @@ impl MaintenanceConfig {
             unused_account_retention: optional_duration(
                 config.unused_account_retention.as_deref(),
             )?,
+            inactive_account_retention: optional_duration(
+                config.inactive_account_retention.as_deref(),
+            )?,
+            inactive_account_action: InactiveAccountAction::parse(
+                config.inactive_account_action.as_deref(),
+            )?,
+            keep_flagged: config.effective_retention_keep_flagged(),
+            keep_unseen: optional_duration(config.retention_keep_unseen.as_deref())?,
+            db_maintenance_interval: db_maintenance_interval(
+                config.db_maintenance_schedule.as_deref(),
+            )?,
+            db_maintenance_max_deliveries: config
+                .db_maintenance_max_deliveries
+                .unwrap_or(DEFAULT_MAX_ACTIVE_DELIVERIES),
         })
     }
 
+    /// `retention` with this server's `retention_keep_*` modifiers applied.
+    pub fn retention_policy(&self, retention: Duration) -> RetentionPolicy {
+        RetentionPolicy {
+            retention,
+            keep_flagged: self.keep_flagged,
+            keep_unseen: self.keep_unseen,
+        }
+    }
+
     pub fn periodic_jobs_enabled(&self) -> bool {
-        self.message_retention.is_some() || self.unused_account_retention.is_some()
+        self.message_retention.is_some()
+            || self.unused_account_retention.is_some()
+            || self.inactive_account_retention.is_some()
     }
 }
 
+fn db_maintenance_interval(raw: Option<&str>) -> Result<Option<Duration>> {
+    let s = raw.map(str::trim).unwrap_or("").to_ascii_lowercase();
+    if let Some((_, every)) = DB_MAINTENANCE_SCHEDULES.iter().find(|(name, _)| *name == s) {
+        return Ok(Some(*every));
+    }
+    if s == "off" {
+        return Ok(None);
+    }
+    optional_duration(Some(&s)).map_err(|_| {
+        ChatmailError::config(format!(
+            "invalid db_maintenance_schedule {s:?} (use daily, weekly, monthly, off or e.g. 72h)"
+        ))
+    })
+}
+
 fn optional_duration(raw: Option<&str>) -> Result<Option<Duration>> {
     let Some(s) = raw else {
         return Ok(None);
@@ -92,4 +191,66 @@ mod tests {
         let m = MaintenanceConfig::from_app_config(&cfg).unwrap();
         assert!(m.message_retention.is_none());
     }
+
+    #[test]
+    fn inactive_account_action_parses() {
+        let cfg = AppConfig {
+            inactive_account_retention: Some("4320h".into()),
+            inactive_account_action: Some("suspend".into()),
+            ..Default::default()
+        };
+        let m = MaintenanceConfig::from_app_config(&cfg).unwrap();
+        assert_eq!(m.inactive_account_action, InactiveAccountAction::Suspend);
+        assert!(m.periodic_jobs_enabled());
+        let bad = AppConfig {
+            inactive_account_action: Some("archive".into()),
+            ..Default::default()
+        };
+        assert!(MaintenanceConfig::from_app_config(&bad).is_err());
+    }
+
+    #[test]
+    fn retention_modifiers_flow_into_policy() {
+        let cfg = AppConfig {
+            retention_keep_flagged: Some(false),
+            retention_keep_unseen: Some("72h".into()),
+            ..Default::default()
+        };
+        let m = MaintenanceConfig::from_app_config(&cfg).unwrap();
+        let policy = m.retention_policy(Duration::from_secs(3600));
+        assert!(!policy.keep_flagged);
+        assert_eq!(policy.keep_unseen, Some(Duration::from_secs(72 * 3600)));
+        assert!(
+            MaintenanceConfig::from_app_config(&AppConfig::default())
+                .unwrap()
+                .keep_flagged
+        );
+    }
+
+    #[test]
+    fn db_maintenance_schedule_parses() {
+        let schedule = |raw: &str| {
+            let cfg = AppConfig {
+                db_maintenance_schedule: Some(raw.into()),
+                ..Default::default()
+            };
+            MaintenanceConfig::from_app_config(&cfg).map(|m| m.db_maintenance_interval)
+        };
+        assert_eq!(
+            schedule("weekly").unwrap(),
+            Some(Duration::from_secs(7 * 24 * 3600))
+        );
+        assert_eq!(
+            schedule("72h").unwrap(),
+            Some(Duration::from_secs(72 * 3600))
+        );
+        assert_eq!(schedule("off").unwrap(), None);
+        assert!(schedule("fortnightly").is_err());
+        let default = MaintenanceConfig::from_app_config(&AppConfig::default()).unwrap();
+        assert_eq!(default.db_maintenance_interval, None);
+        assert_eq!(
+            default.db_maintenance_max_deliveries,
+            DEFAULT_MAX_ACTIVE_DELIVERIES
+        );
+    }
 }
@@ -0,0 +1,323 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `db-maintenance`: reclaim free pages and refresh planner statistics.
+//!
+//! SQLite: `wal_checkpoint(TRUNCATE)`, then `incremental_vacuum` when the database was
+//! created with `auto_vacuum = INCREMENTAL`, or a full `VACUUM` once free pages exceed
+//! [`VACUUM_FRAGMENTATION_PERCENT`] of the file and the filesystem has room for the copy
+//! VACUUM writes. Postgres: `ANALYZE` on the tables behind the quota and login queries
+//! (autovacuum already reclaims dead tuples there).
+//!
+//! VACUUM holds the write lock for its whole run, so the job steps aside while more than
+//! `db_maintenance_max_deliveries` deliveries are in flight.
+
+use std::path::{Path, PathBuf};
+
+use chatmail_db::{schema, DbPool};
+use chatmail_types::Result;
+use sqlx::{PgPool, SqlitePool};
+
+/// Free pages, as a share of the file, above which a full VACUUM is worth its lock.
+pub const VACUUM_FRAGMENTATION_PERCENT: u64 = 20;
+
+/// `db_maintenance_max_deliveries` when not configured.
+pub const DEFAULT_MAX_ACTIVE_DELIVERIES: usize = 4;
+
+/// Postgres tables re-analyzed besides the quota table (`quota` or `quotas`).
+const POSTGRES_HOT_TABLES: &[&str] = &[
+    "passwords",
+    "account_activity",
+    "app_passwords",
+    "mailbox_modseq",
+    "message_stats",
+];
+
+/// Result of one `db-maintenance` run.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DbMaintenanceOutcome {
+    pub skipped: bool,
+    /// Bytes the database file and its WAL shrank by.
+    pub reclaimed_bytes: u64,
+    pub detail: String,
+}
+
+impl DbMaintenanceOutcome {
+    fn skipped(reason: impl Into<String>) -> Self {
+        Self {
+            skipped: true,
+            reclaimed_bytes: 0,
+            detail: reason.into(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct SqliteStats {
+    page_size: u64,
+    page_count: u64,
+    freelist_count: u64,
+    /// `PRAGMA auto_vacuum`: 0 none, 1 full, 2 incremental.
+    auto_vacuum: i64,
+}
+
+impl SqliteStats {
+    fn db_bytes(&self) -> u64 {
+        self.page_size * self.page_count
+    }
+
+    fn free_bytes(&self) -> u64 {
+        self.page_size * self.freelist_count
+    }
+
+    fn fragmented(&self) -> bool {
+        self.page_count > 0
+            && self.freelist_count * 100 / self.page_count >= VACUUM_FRAGMENTATION_PERCENT
+    }
+
+    /// VACUUM copies the live pages into a temporary database and then writes them back
+    /// through the WAL, so it needs about twice the live size free.
+    fn vacuum_headroom(&self) -> u64 {
+        2 * (self.db_bytes() - self.free_bytes())
+    }
+}
+
+/// Run the job unless `active_deliveries` exceeds `max_active_deliveries`.
+pub async fn run_db_maintenance(
+    pool: &DbPool,
+    active_deliveries: usize,
+    max_active_deliveries: usize,
+) -> Result<DbMaintenanceOutcome> {
+    if active_deliveries > max_active_deliveries {
+        return Ok(DbMaintenanceOutcome::skipped(format!(
+            "server busy: {active_deliveries} deliveries in flight (limit {max_active_deliveries})"
+        )));
+    }
+    match pool {
+        DbPool::Sqlite(p) => maintain_sqlite(p, available_bytes).await,
+        DbPool::Postgres(p) => analyze_postgres(pool, p).await,
+    }
+}
+
+fn available_bytes(dir: &Path) -> Option<u64> {
+    chatmail_state::disk::disk_usage(dir).map(|u| u.available_bytes)
+}
+
+async fn maintain_sqlite(
+    pool: &SqlitePool,
+    free_space: fn(&Path) -> Option<u64>,
+) -> Result<DbMaintenanceOutcome> {
+    let path = sqlite_main_file(pool).await?;
+    checkpoint(pool).await?;
+    let before = sqlite_stats(pool).await?;
+    let size_before = on_disk_bytes(path.as_deref(), &before);
+
+    let mut notes = vec![format!(
+        "{} of {} pages free before",
+        before.freelist_count, before.page_count
+    )];
+    let mut vacuumed = false;
+    if before.fragmented() {
+        let available = match path.as_deref().and_then(Path::parent) {
+            Some(dir) => free_space(dir),
+            None => Some(u64::MAX),
+        };
+        let needed = before.vacuum_headroom();
+        if available.is_some_and(|a| a >= needed) {
+            sqlx::query("VACUUM").execute(pool).await?;
+            vacuumed = true;
+            notes.push("vacuum".to_string());
+        } else {
+            notes.push(format!(
+                "vacuum skipped: needs {needed} bytes free, {} available",
+                available.map_or_else(|| "unknown".to_string(), |a| a.to_string())
+            ));
+        }
+    }
+    if before.auto_vacuum == 2 && !vacuumed {
+        sqlx::query("PRAGMA incremental_vacuum")
+            .execute(pool)
+            .await?;
+        notes.push("incremental_vacuum".to_string());
+    }
+    checkpoint(pool).await?;
+
+    let after = sqlite_stats(pool).await?;
+    let size_after = on_disk_bytes(path.as_deref(), &after);
+    Ok(DbMaintenanceOutcome {
+        skipped: false,
+        reclaimed_bytes: size_before.saturating_sub(size_after),
+        detail: notes.join("; "),
+    })
+}
+
+async fn checkpoint(pool: &SqlitePool) -> Result<()> {
+    sqlx::query("PRAGMA wal_checkpoint(TRUNCATE)")
+        .fetch_all(pool)
+        .await?;
+    Ok(())
+}
+
+async fn sqlite_stats(pool: &SqlitePool) -> Result<SqliteStats> {
+    let pragma = |name: &'static str| async move {
+        sqlx::query_scalar::<_, i64>(&format!("PRAGMA {name}"))
+            .fetch_one(pool)
+            .await
+            .map(|v| v.max(0) as u64)
+    };
+    Ok(SqliteStats {
+        page_size: pragma("page_size").await?,
+        page_count: pragma("page_count").await?,
+        freelist_count: pragma("freelist_count").await?,
+        auto_vacuum: pragma("auto_vacuum").await? as i64,
+    })
+}
+
+/// Path of the `main` database file; `None` for in-memory databases.
+async fn sqlite_main_file(pool: &SqlitePool) -> Result<Option<PathBuf>> {
+    let file: Option<String> =
+        sqlx::query_scalar("SELECT file FROM pragma_database_list WHERE name = 'main'")
+            .fetch_optional(pool)
+            .await?;
+    Ok(file.filter(|f| !f.is_empty()).map(PathBuf::from))
+}
+
+/// Database file plus WAL; page arithmetic when there is no file.
+fn on_disk_bytes(path: Option<&Path>, stats: &SqliteStats) -> u64 {
+    let Some(path) = path else {
+        return stats.db_bytes();
+    };
+    let len = |p: &Path| std::fs::metadata(p).map(|m| m.len()).unwrap_or(0);
+    let mut wal = path.as_os_str().to_owned();
+    wal.push("-wal");
+    len(path) + len(Path::new(&wal))
+}
+
+async fn analyze_postgres(pool: &DbPool, pg: &PgPool) -> Result<DbMaintenanceOutcome> {
+    let mut analyzed = Vec::new();
+    let quota = schema::quota_table(pool).await?;
+    for table in std::iter::once(quota).chain(POSTGRES_HOT_TABLES.iter().copied()) {
+        if !schema::table_exists(pool, table).await? {
+            continue;
+        }
+        sqlx::query(&format!("ANALYZE {table}")).execute(pg).await?;
+        analyzed.push(table);
+    }
+    Ok(DbMaintenanceOutcome {
+        skipped: false,
+        reclaimed_bytes: 0,
+        detail: format!("analyzed {}", analyzed.join(", ")),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_config::{DatabaseConfig, DbDriver};
+    use chatmail_db::connect_database;
+
+    async fn fragmented_fixture(dir: &Path) -> (DbPool, PathBuf) {
+        let path = dir.join("chatmail.db");
+        let pool = connect_database(&DatabaseConfig {
+            driver: DbDriver::Sqlite3,
+            dsn: path.display().to_string(),
+        })
+        .await
+        .unwrap();
+        let DbPool::Sqlite(p) = &pool else {
+            panic!("sqlite fixture");
+        };
+        sqlx::query("CREATE TABLE fixture (id INTEGER PRIMARY KEY, body BLOB NOT NULL)")
+            .execute(p)
+            .await
+            .unwrap();
+        sqlx::query(
+            "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2000)
+             INSERT INTO fixture (body) SELECT randomblob(1024) FROM n",
+        )
+        .execute(p)
+        .await
+        .unwrap();
+        sqlx::query("DELETE FROM fixture WHERE id > 100")
+            .execute(p)
+            .await
+            .unwrap();
+        checkpoint(p).await.unwrap();
+        (pool, path)
+    }
+
+    fn file_len(path: &Path) -> u64 {
+        std::fs::metadata(path).unwrap().len()
+    }
+
+    #[tokio::test]
+    async fn sqlite_vacuum_shrinks_fragmented_file() {
+        let dir = tempfile::tempdir().unwrap();
+        let (pool, path) = fragmented_fixture(dir.path()).await;
+        let before = file_len(&path);
+
+        let outcome = run_db_maintenance(&pool, 0, DEFAULT_MAX_ACTIVE_DELIVERIES)
+            .await
+            .unwrap();
+        assert!(!outcome.skipped, "{outcome:?}");
+        assert!(outcome.detail.contains("vacuum"), "{outcome:?}");
+        let after = file_len(&path);
+        assert!(after < before / 2, "{before} -> {after}");
+        assert!(outcome.reclaimed_bytes >= before - after, "{outcome:?}");
+
+        let DbPool::Sqlite(p) = &pool else {
+            unreachable!()
+        };
+        let rows: i64 = sqlx::query_scalar("SELECT COUNT(*) FROM fixture")
+            .fetch_one(p)
+            .await
+            .unwrap();
+        assert_eq!(rows, 100);
+    }
+
+    #[tokio::test]
+    async fn skipped_while_deliveries_in_flight() {
+        let dir = tempfile::tempdir().unwrap();
+        let (pool, path) = fragmented_fixture(dir.path()).await;
+        let before = file_len(&path);
+
+        let outcome = run_db_maintenance(&pool, 5, 4).await.unwrap();
+        assert!(outcome.skipped);
+        assert_eq!(outcome.reclaimed_bytes, 0);
+        assert!(outcome.detail.contains("5 deliveries"), "{outcome:?}");
+        assert_eq!(file_len(&path), before);
+    }
+
+    #[tokio::test]
+    async fn vacuum_skipped_without_disk_headroom() {
+        let dir = tempfile::tempdir().unwrap();
+        let (pool, path) = fragmented_fixture(dir.path()).await;
+        let before = file_len(&path);
+        let DbPool::Sqlite(p) = &pool else {
+            unreachable!()
+        };
+
+        let outcome = maintain_sqlite(p, |_| Some(4096)).await.unwrap();
+        assert!(!outcome.skipped);
+        assert!(outcome.detail.contains("vacuum skipped"), "{outcome:?}");
+        assert_eq!(outcome.reclaimed_bytes, 0);
+        assert_eq!(file_len(&path), before);
+
+        let unknown = maintain_sqlite(p, |_| None).await.unwrap();
+        assert!(unknown.detail.contains("unknown available"), "{unknown:?}");
+    }
+}
@@ -21,34 +21,43 @@ use std::time::{Duration, SystemTime, UNIX_EPOCH};
 
 use chatmail_config::parse_duration;
 use chatmail_db::{
-    get_enabled_setting, list_dormant_accounts, remove_account_without_blocklist, settings_keys,
-    DbPool,
+    blocklist, get_enabled_setting, list_dormant_accounts, list_inactive_accounts,
+    remove_account_without_blocklist, settings_keys, DbPool,
 };
+use chatmail_state::{QuotaCache, Webhooks};
 use chatmail_storage::{
-    prune_unread_older, purge_mail_blobs_older, purge_read_messages, MailboxStore,
+    prune_unread_with_policy, purge_mail_blobs_with_policy, purge_read_messages, run_cold_storage,
+    MailboxStore,
 };
 use chatmail_types::{ChatmailError, Result};
 
 use crate::cert_renew::{CertRenewOutcome, CertificateRenewer};
-use crate::config::MaintenanceConfig;
+use crate::config::{InactiveAccountAction, MaintenanceConfig, INACTIVE_SUSPEND_REASON};
+use crate::db_maintenance::run_db_maintenance;
 
 /// Named maintenance job (CLI + scheduler).
 #[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
 pub enum TaskId {
     PruneOldMessages,
     PruneUnusedAccounts,
+    PruneInactiveAccounts,
     PurgeSeenMessages,
     PruneUnreadOlder,
     RenewCertificate,
+    DbMaintenance,
+    ColdStorage,
 }
 
 impl TaskId {
     pub const ALL: &'static [TaskId] = &[
         TaskId::PruneOldMessages,
         TaskId::PruneUnusedAccounts,
+        TaskId::PruneInactiveAccounts,
         TaskId::PurgeSeenMessages,
         TaskId::PruneUnreadOlder,
         TaskId::RenewCertificate,
+        TaskId::DbMaintenance,
+        TaskId::ColdStorage,
     ];
 
     pub fn parse(s: &str) -> Option<Self> {
@@ -57,11 +66,16 @@ impl TaskId {
             "prune-unused-accounts" | "prune-unused" | "unused-accounts" => {
                 Some(TaskId::PruneUnusedAccounts)
             }
+            "prune-inactive-accounts" | "prune-inactive" | "inactive-accounts" => {
+                Some(TaskId::PruneInactiveAccounts)
+            }
             "purge-seen" | "purge-read" | "auto-purge-seen" => Some(TaskId::PurgeSeenMessages),
             "prune-unread-older" | "purge-unread-older" => Some(TaskId::PruneUnreadOlder),
             "renew-certificate" | "certificate-renew" | "renew-cert" => {
                 Some(TaskId::RenewCertificate)
             }
+            "db-maintenance" | "vacuum" | "db-vacuum" => Some(TaskId::DbMaintenance),
+            "cold-storage" | "archive-cold" | "compact-cold" => Some(TaskId::ColdStorage),
             _ => None,
         }
     }
@@ -70,9 +84,12 @@ impl TaskId {
         match self {
             TaskId::PruneOldMessages => "prune-old-messages",
             TaskId::PruneUnusedAccounts => "prune-unused-accounts",
+            TaskId::PruneInactiveAccounts => "prune-inactive-accounts",
             TaskId::PurgeSeenMessages => "purge-seen",
             TaskId::PruneUnreadOlder => "prune-unread-older",
             TaskId::RenewCertificate => "renew-certificate",
+            TaskId::DbMaintenance => "db-maintenance",
+            TaskId::ColdStorage => "cold-storage",
         }
     }
 
@@ -84,11 +101,20 @@ impl TaskId {
             TaskId::PruneUnusedAccounts => {
                 "Delete accounts that never logged in, older than unused_account_retention"
             }
+            TaskId::PruneInactiveAccounts => {
+                "Delete or suspend accounts with no activity within inactive_account_retention"
+            }
             TaskId::PurgeSeenMessages => "Delete maildir cur/ (seen) messages",
             TaskId::PruneUnreadOlder => "Delete maildir new/ messages older than --retention",
             TaskId::RenewCertificate => {
                 "Renew Let's Encrypt TLS certificate when autocert is enabled (IP: <4d left, DNS: <30d)"
             }
+            TaskId::DbMaintenance => {
+                "Checkpoint and VACUUM SQLite (ANALYZE on Postgres); skipped under load"
+            }
+            TaskId::ColdStorage => {
+                "Pack mail older than storage.imapsql cold_storage age and compact expunged packs"
+            }
         }
     }
 }
@@ -98,6 +124,8 @@ pub struct TaskOutcome {
     pub task: TaskId,
     pub deleted: usize,
     pub skipped: bool,
+    /// Bytes given back to the filesystem (`db-maintenance` and `cold-storage`).
+    pub reclaimed_bytes: u64,
     pub detail: Option<String>,
 }
 
@@ -116,6 +144,13 @@ pub struct TaskContext<'a> {
     pub pool: &'a DbPool,
     pub mailbox: &'a MailboxStore,
     pub maintenance: &'a MaintenanceConfig,
+    /// Deliveries in flight when the job starts (0 outside the server process).
+    pub active_deliveries: usize,
+    /// `account_pruned` webhooks; `None` outside the server process.
+    pub webhooks: Option<&'a Webhooks>,
+    /// Live quota counters, resynced from disk after retention purges; `None` outside the
+    /// server process (the next start hydrates from disk anyway).
+    pub quota: Option<&'a QuotaCache>,
 }
 
 /// Run one job. `retention_override` applies to retention-based tasks when set.
@@ -127,6 +162,7 @@ pub async fn run_task(
     match task {
         TaskId::PruneOldMessages => prune_old_messages(ctx, retention_override).await,
         TaskId::PruneUnusedAccounts => prune_unused_accounts(ctx, retention_override).await,
+        TaskId::PruneInactiveAccounts => prune_inactive_accounts(ctx, retention_override).await,
         TaskId::PurgeSeenMessages => purge_seen(ctx).await,
         TaskId::PruneUnreadOlder => {
             let retention = retention_override.ok_or_else(|| {
@@ -141,6 +177,8 @@ pub async fn run_task(
                 "renew-certificate must run inside the server process (scheduled daily) or use `madmail certificate get`",
             ))
         }
+        TaskId::DbMaintenance => db_maintenance(ctx).await,
+        TaskId::ColdStorage => cold_storage(ctx).await,
     }
 }
 
@@ -157,6 +195,12 @@ pub async fn run_all_configured(ctx: &TaskContext<'_>) -> Result<TaskRunReport>
     if ctx.maintenance.unused_account_retention.is_some() {
         report.push(run_task(ctx, TaskId::PruneUnusedAccounts, None).await?);
     }
+    if ctx.maintenance.inactive_account_retention.is_some() {
+        report.push(run_task(ctx, TaskId::PruneInactiveAccounts, None).await?);
+    }
+    if ctx.mailbox.policy().cold.is_some() {
+        report.push(run_task(ctx, TaskId::ColdStorage, None).await?);
+    }
     Ok(report)
 }
 
@@ -180,16 +224,28 @@ async fn prune_old_messages(
         return Ok(TaskOutcome {
             task: TaskId::PruneOldMessages,
             deleted: 0,
+            reclaimed_bytes: 0,
             skipped: true,
             detail: Some("storage.imapsql retention not set (or 0)".into()),
         });
     };
-    let deleted = purge_mail_blobs_older(ctx.mailbox, retention).await?;
+    let policy = ctx.maintenance.retention_policy(retention);
+    let deleted = purge_mail_blobs_with_policy(ctx.mailbox, &policy).await?;
+    let mut detail = format!("retention {:?}", retention);
+    // The purge unlinks files behind the quota cache's back; resync it from disk.
+    if let Some(quota) = ctx.quota {
+        let report = quota.recalculate_all(ctx.pool, ctx.mailbox).await?;
+        let drifted = report.iter().filter(|r| r.drifted()).count();
+        if drifted > 0 {
+            detail.push_str(&format!("; quota corrected for {drifted} account(s)"));
+        }
+    }
     Ok(TaskOutcome {
         task: TaskId::PruneOldMessages,
         deleted,
+        reclaimed_bytes: 0,
         skipped: false,
-        detail: Some(format!("retention {:?}", retention)),
+        detail: Some(detail),
     })
 }
 
@@ -202,34 +258,113 @@ async fn prune_unused_accounts(
         return Ok(TaskOutcome {
             task: TaskId::PruneUnusedAccounts,
             deleted: 0,
+            reclaimed_bytes: 0,
             skipped: true,
             detail: Some("storage.imapsql unused_account_retention not set (or 0)".into()),
         });
     };
-    let deleted = prune_unused_accounts_with_retention(ctx.pool, ctx.mailbox, retention).await?;
+    let deleted =
+        prune_unused_accounts_with_retention(ctx.pool, ctx.mailbox, retention, ctx.webhooks)
+            .await?;
     Ok(TaskOutcome {
         task: TaskId::PruneUnusedAccounts,
         deleted,
+        reclaimed_bytes: 0,
         skipped: false,
         detail: Some(format!("retention {:?}", retention)),
     })
 }
 
+async fn prune_inactive_accounts(
+    ctx: &TaskContext<'_>,
+    retention_override: Option<Duration>,
+) -> Result<TaskOutcome> {
+    let retention = retention_override.or(ctx.maintenance.inactive_account_retention);
+    let Some(retention) = retention else {
+        return Ok(TaskOutcome {
+            task: TaskId::PruneInactiveAccounts,
+            deleted: 0,
+            reclaimed_bytes: 0,
+            skipped: true,
+            detail: Some("storage.imapsql inactive_account_retention not set (or 0)".into()),
+        });
+    };
+    let action = ctx.maintenance.inactive_account_action;
+    let deleted = prune_inactive_accounts_with_retention(
+        ctx.pool,
+        ctx.mailbox,
+        retention,
+        action,
+        ctx.webhooks,
+    )
+    .await?;
+    Ok(TaskOutcome {
+        task: TaskId::PruneInactiveAccounts,
+        deleted,
+        reclaimed_bytes: 0,
+        skipped: false,
+        detail: Some(format!("retention {:?}, action {:?}", retention, action)),
+    })
+}
+
 async fn purge_seen(ctx: &TaskContext<'_>) -> Result<TaskOutcome> {
     let deleted = purge_read_messages(ctx.mailbox).await?;
     Ok(TaskOutcome {
         task: TaskId::PurgeSeenMessages,
         deleted,
+        reclaimed_bytes: 0,
         skipped: false,
         detail: None,
     })
 }
 
+async fn db_maintenance(ctx: &TaskContext<'_>) -> Result<TaskOutcome> {
+    let outcome = run_db_maintenance(
+        ctx.pool,
+        ctx.active_deliveries,
+        ctx.maintenance.db_maintenance_max_deliveries,
+    )
+    .await?;
+    Ok(TaskOutcome {
+        task: TaskId::DbMaintenance,
+        deleted: 0,
+        skipped: outcome.skipped,
+        reclaimed_bytes: outcome.reclaimed_bytes,
+        detail: Some(outcome.detail),
+    })
+}
+
+async fn cold_storage(ctx: &TaskContext<'_>) -> Result<TaskOutcome> {
+    if ctx.mailbox.policy().cold.is_none() {
+        return Ok(TaskOutcome {
+            task: TaskId::ColdStorage,
+            deleted: 0,
+            skipped: true,
+            reclaimed_bytes: 0,
+            detail: Some("storage.imapsql cold_storage not configured".into()),
+        });
+    }
+    let report = run_cold_storage(ctx.mailbox).await?;
+    Ok(TaskOutcome {
+        task: TaskId::ColdStorage,
+        deleted: 0,
+        skipped: false,
+        reclaimed_bytes: report.moved_bytes.saturating_sub(report.packed_bytes)
+            + report.reclaimed_bytes,
+        detail: Some(format!(
+            "moved {} message(s), rewrote {} pack(s), removed {} pack(s)",
+            report.moved, report.packs_rewritten, report.packs_removed
+        )),
+    })
+}
+
 async fn prune_unread_older_job(ctx: &TaskContext<'_>, retention: Duration) -> Result<TaskOutcome> {
-    let deleted = prune_unread_older(ctx.mailbox, retention).await?;
+    let policy = ctx.maintenance.retention_policy(retention);
+    let deleted = prune_unread_with_policy(ctx.mailbox, &policy).await?;
     Ok(TaskOutcome {
         task: TaskId::PruneUnreadOlder,
         deleted,
+        reclaimed_bytes: 0,
         skipped: false,
         detail: Some(format!("retention {:?}", retention)),
     })
@@ -239,6 +374,7 @@ pub async fn prune_unused_accounts_with_retention(
     pool: &DbPool,
     mailbox: &MailboxStore,
     retention: Duration,
+    webhooks: Option<&Webhooks>,
 ) -> Result<usize> {
     let cutoff = unix_now().saturating_sub(retention.as_secs() as i64);
     let accounts = list_dormant_accounts(pool, cutoff).await?;
@@ -250,11 +386,57 @@ pub async fn prune_unused_accounts_with_retention(
             .is_ok()
         {
             deleted += 1;
+            if let Some(hooks) = webhooks {
+                hooks.emit_account_pruned(&username, "unused", "delete");
+            }
         }
     }
     Ok(deleted)
 }
 
+/// Delete or suspend accounts whose last activity predates `retention`. Already
+/// blocklisted accounts are left alone when suspending.
+pub async fn prune_inactive_accounts_with_retention(
+    pool: &DbPool,
+    mailbox: &MailboxStore,
+    retention: Duration,
+    action: InactiveAccountAction,
+    webhooks: Option<&Webhooks>,
+) -> Result<usize> {
+    let cutoff = unix_now().saturating_sub(retention.as_secs() as i64);
+    let accounts = list_inactive_accounts(pool, cutoff).await?;
+    let action_name = match action {
+        InactiveAccountAction::Delete => "delete",
+        InactiveAccountAction::Suspend => "suspend",
+    };
+    let mut affected = 0usize;
+    for username in accounts {
+        let ok = match action {
+            InactiveAccountAction::Delete => {
+                let mail_root = mailbox.maildir_for_user(&username).root;
+                remove_account_without_blocklist(pool, &username, &mail_root)
+                    .await
+                    .is_ok()
+            }
+            InactiveAccountAction::Suspend => {
+                if blocklist::is_blocked(pool, &username).await? {
+                    continue;
+                }
+                blocklist::block_user(pool, &username, INACTIVE_SUSPEND_REASON)
+                    .await
+                    .is_ok()
+            }
+        };
+        if ok {
+            affected += 1;
+            if let Some(hooks) = webhooks {
+                hooks.emit_account_pruned(&username, "inactive", action_name);
+            }
+        }
+    }
+    Ok(affected)
+}
+
 pub fn parse_retention_arg(s: &str) -> Result<Duration> {
     parse_duration(s.trim()).map_err(|_| {
         ChatmailError::config(format!(
@@ -321,6 +503,12 @@ mod tests {
             Some(TaskId::PruneUnusedAccounts)
         );
         assert_eq!(TaskId::parse("retention"), Some(TaskId::PruneOldMessages));
+        assert_eq!(TaskId::parse("vacuum"), Some(TaskId::DbMaintenance));
+        assert_eq!(TaskId::parse("cold-storage"), Some(TaskId::ColdStorage));
+        assert_eq!(
+            TaskId::parse("prune-inactive"),
+            Some(TaskId::PruneInactiveAccounts)
+        );
     }
 
     #[tokio::test]
@@ -333,6 +521,9 @@ mod tests {
             pool: &pool,
             mailbox: &mailbox,
             maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: None,
         };
         let out = run_task(&ctx, TaskId::PruneOldMessages, None)
             .await
@@ -340,6 +531,57 @@ mod tests {
         assert!(out.skipped);
     }
 
+    #[tokio::test]
+    async fn run_all_packs_old_mail_when_cold_storage_is_configured() {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let maintenance = MaintenanceConfig::from_app_config(&AppConfig::default()).unwrap();
+        let hot = MailboxStore::new(dir.path());
+        let ctx = TaskContext {
+            pool: &pool,
+            mailbox: &hot,
+            maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: None,
+        };
+        assert!(run_all_configured(&ctx).await.unwrap().outcomes.is_empty());
+        assert!(
+            run_task(&ctx, TaskId::ColdStorage, None)
+                .await
+                .unwrap()
+                .skipped
+        );
+
+        let mut cfg = AppConfig::default();
+        cfg.cold_storage.enabled = true;
+        let cold =
+            MailboxStore::with_policy(dir.path(), chatmail_state::storage_policy(&cfg, dir.path()));
+        let body = [b"From: a@test\r\n\r\n".as_slice(), &[b'x'; 8192][..]].concat();
+        let path = write_blob(&cold, "u@test", "m1", &body).await.unwrap();
+        touch_unix_epoch(&path);
+        let ctx = TaskContext {
+            mailbox: &cold,
+            ..ctx
+        };
+        let report = run_all_configured(&ctx).await.unwrap();
+        assert_eq!(report.outcomes.len(), 1);
+        let outcome = &report.outcomes[0];
+        assert_eq!(outcome.task, TaskId::ColdStorage);
+        assert!(outcome.reclaimed_bytes > 0);
+        assert!(outcome
+            .detail
+            .as_deref()
+            .unwrap()
+            .starts_with("moved 1 message"));
+        assert_eq!(
+            chatmail_storage::read_blob(&cold, "u@test", "INBOX", "m1")
+                .await
+                .unwrap(),
+            body
+        );
+    }
+
     /// End-to-end: dormant account + maildir removed; logged-in account kept.
     #[tokio::test]
     async fn prune_unused_accounts_removes_dormant_and_maildir_keeps_active() {
@@ -359,11 +601,21 @@ mod tests {
             .await
             .unwrap();
 
-        let deleted =
-            prune_unused_accounts_with_retention(&pool, &mailbox, Duration::from_secs(3600))
-                .await
-                .unwrap();
+        let hooks = Webhooks::new(Some("https://hooks.test/in".into()), None, vec![]);
+        let mut queue = hooks.take_queue().unwrap();
+        let deleted = prune_unused_accounts_with_retention(
+            &pool,
+            &mailbox,
+            Duration::from_secs(3600),
+            Some(&hooks),
+        )
+        .await
+        .unwrap();
         assert_eq!(deleted, 1);
+        let event = String::from_utf8(queue.try_recv().unwrap().body).unwrap();
+        assert!(event.contains(r#""event":"account_pruned""#), "{event}");
+        assert!(event.contains(r#""username":"dormant@test""#), "{event}");
+        assert!(queue.try_recv().is_err());
 
         assert!(!passwords::user_exists(&pool, "dormant@test").await.unwrap());
         assert!(!mailbox.maildir_for_user("dormant@test").root.exists());
@@ -391,6 +643,9 @@ mod tests {
             pool: &pool,
             mailbox: &mailbox,
             maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: None,
         };
         let out = run_task(
             &ctx,
@@ -404,6 +659,71 @@ mod tests {
         assert!(!passwords::user_exists(&pool, "old@test").await.unwrap());
     }
 
+    #[tokio::test]
+    async fn prune_inactive_accounts_deletes_or_suspends_stale_only() {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let mailbox = MailboxStore::new(dir.path());
+
+        seed_logged_in_account(&pool, "stale@test", 1).await;
+        seed_logged_in_account(&pool, "recent@test", 1).await;
+        seed_dormant_account(&pool, "never@test", 1).await;
+        chatmail_db::record_activity(&pool, "recent@test", unix_now())
+            .await
+            .unwrap();
+        mailbox.init_user_dir("stale@test").await.unwrap();
+        let retention = Duration::from_secs(3600);
+
+        let hooks = Webhooks::new(Some("https://hooks.test/in".into()), None, vec![]);
+        let mut queue = hooks.take_queue().unwrap();
+        let suspended = prune_inactive_accounts_with_retention(
+            &pool,
+            &mailbox,
+            retention,
+            InactiveAccountAction::Suspend,
+            Some(&hooks),
+        )
+        .await
+        .unwrap();
+        assert_eq!(suspended, 1);
+        let event = String::from_utf8(queue.try_recv().unwrap().body).unwrap();
+        assert!(event.contains(r#""action":"suspend""#), "{event}");
+        assert!(event.contains(r#""rule":"inactive""#), "{event}");
+        assert!(chatmail_db::blocklist::is_blocked(&pool, "stale@test")
+            .await
+            .unwrap());
+        assert!(passwords::user_exists(&pool, "stale@test").await.unwrap());
+        assert!(mailbox.maildir_for_user("stale@test").root.exists());
+        // Re-running does not count already-suspended accounts again.
+        assert_eq!(
+            prune_inactive_accounts_with_retention(
+                &pool,
+                &mailbox,
+                retention,
+                InactiveAccountAction::Suspend,
+                None,
+            )
+            .await
+            .unwrap(),
+            0
+        );
+
+        let deleted = prune_inactive_accounts_with_retention(
+            &pool,
+            &mailbox,
+            retention,
+            InactiveAccountAction::Delete,
+            None,
+        )
+        .await
+        .unwrap();
+        assert_eq!(deleted, 1);
+        assert!(!passwords::user_exists(&pool, "stale@test").await.unwrap());
+        assert!(!mailbox.maildir_for_user("stale@test").root.exists());
+        assert!(passwords::user_exists(&pool, "recent@test").await.unwrap());
+        assert!(passwords::user_exists(&pool, "never@test").await.unwrap());
+    }
+
     #[tokio::test]
     async fn run_task_prune_old_messages_deletes_stale_maildir_files() {
         let pool = init_memory_db().await.unwrap();
@@ -419,6 +739,9 @@ mod tests {
             pool: &pool,
             mailbox: &mailbox,
             maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: None,
         };
         let out = run_task(
             &ctx,
@@ -434,6 +757,48 @@ mod tests {
         assert_eq!(left[0].msg_id, "fresh");
     }
 
+    #[tokio::test]
+    async fn prune_old_messages_resyncs_quota_counters() {
+        let pool = init_memory_db().await.unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        let mailbox = MailboxStore::new(dir.path());
+        let maintenance = MaintenanceConfig::from_app_config(&AppConfig::default()).unwrap();
+        passwords::create_user(&pool, "u@test", "h").await.unwrap();
+        let paths = mailbox.init_user_dir("u@test").await.unwrap();
+        write_blob(&mailbox, "u@test", "stale", b"old mail")
+            .await
+            .unwrap();
+        write_blob(&mailbox, "u@test", "fresh", b"new")
+            .await
+            .unwrap();
+        touch_unix_epoch(&paths.new.join("stale"));
+        let quota = QuotaCache::new(10_000);
+        quota.hydrate(&pool, &mailbox).await.unwrap();
+        assert_eq!(quota.usage("u@test"), (11, 0));
+
+        let ctx = TaskContext {
+            pool: &pool,
+            mailbox: &mailbox,
+            maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: Some(&quota),
+        };
+        let out = run_task(
+            &ctx,
+            TaskId::PruneOldMessages,
+            Some(Duration::from_secs(3600)),
+        )
+        .await
+        .unwrap();
+        assert_eq!(out.deleted, 1);
+        assert_eq!(
+            out.detail.as_deref(),
+            Some("retention 3600s; quota corrected for 1 account(s)")
+        );
+        assert_eq!(quota.usage("u@test"), (3, 0));
+    }
+
     #[tokio::test]
     async fn run_task_purge_seen_deletes_cur_only() {
         let pool = init_memory_db().await.unwrap();
@@ -453,6 +818,9 @@ mod tests {
             pool: &pool,
             mailbox: &mailbox,
             maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: None,
         };
         let out = run_task(&ctx, TaskId::PurgeSeenMessages, None)
             .await
@@ -479,6 +847,9 @@ mod tests {
             pool: &pool,
             mailbox: &mailbox,
             maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: None,
         };
         let out = run_task(
             &ctx,
@@ -516,6 +887,9 @@ mod tests {
             pool: &pool,
             mailbox: &mailbox,
             maintenance: &maintenance,
+            active_deliveries: 0,
+            webhooks: None,
+            quota: None,
         };
         let report = run_all_configured(&ctx).await.unwrap();
         assert_eq!(report.outcomes.len(), 2);
@@ -19,11 +19,16 @@
 
 mod cert_renew;
 mod config;
+mod db_maintenance;
 mod jobs;
 mod scheduler;
 
 pub use cert_renew::{CertRenewOutcome, CertificateRenewer};
-pub use config::MaintenanceConfig;
+pub use config::{InactiveAccountAction, MaintenanceConfig, INACTIVE_SUSPEND_REASON};
+pub use db_maintenance::{
+    run_db_maintenance, DbMaintenanceOutcome, DEFAULT_MAX_ACTIVE_DELIVERIES,
+    VACUUM_FRAGMENTATION_PERCENT,
+};
 pub use jobs::{
     parse_retention_arg, run_all_configured, run_certificate_renewal, run_task, TaskContext,
     TaskId, TaskOutcome, TaskRunReport,
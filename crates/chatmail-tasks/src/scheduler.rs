@@ -21,8 +21,8 @@ use std::sync::Arc;
 
 use chatmail_config::AppConfig;
 use chatmail_db::DbPool;
+use chatmail_state::{DrainGate, QuotaCache, Webhooks};
 use chatmail_storage::MailboxStore;
-use std::path::Path;
 use tokio::task::JoinHandle;
 use tokio_util::sync::CancellationToken;
 use tracing::{debug, error, info};
@@ -32,7 +32,8 @@ use crate::config::{
     MaintenanceConfig, AUTO_PURGE_SEEN_INTERVAL, CERT_RENEWAL_INTERVAL, PERIODIC_INTERVAL,
 };
 use crate::jobs::{
-    run_all_configured, run_auto_purge_seen_if_enabled, run_certificate_renewal, TaskContext,
+    run_all_configured, run_auto_purge_seen_if_enabled, run_certificate_renewal, run_task,
+    TaskContext, TaskId,
 };
 
 pub struct MaintenanceHandle {
@@ -50,20 +51,27 @@ impl MaintenanceHandle {
     }
 }
 
-/// Background loops: hourly retention jobs, 15s auto-purge seen, daily autocert renewal.
+/// Background loops: hourly retention and `cold-storage` jobs, 15s auto-purge seen, daily
+/// autocert renewal, and `db-maintenance` on `db_maintenance_schedule`. `drain` supplies the
+/// in-flight delivery count `db-maintenance` checks before it starts.
+///
+/// `mailbox` should be the server's own store: cold-storage swaps take its mailbox locks.
+/// Accounts the pruning jobs remove are reported to `webhooks` (`account_pruned`), and
+/// `quota` is resynced from disk after each retention purge.
 pub fn spawn_maintenance_scheduler(
     pool: DbPool,
-    state_dir: &Path,
+    mailbox: MailboxStore,
     file_config: &AppConfig,
     cert_renewer: Option<Arc<dyn CertificateRenewer>>,
+    drain: DrainGate,
+    webhooks: Option<Arc<Webhooks>>,
+    quota: Option<Arc<QuotaCache>>,
 ) -> MaintenanceHandle {
     let cancel = CancellationToken::new();
     let cancel_child = cancel.clone();
-    let state_dir = state_dir.to_path_buf();
     let file_config = file_config.clone();
 
     let join = tokio::spawn(async move {
-        let mailbox = MailboxStore::new(&state_dir);
         let maintenance = match MaintenanceConfig::from_runtime(&pool, &file_config).await {
             Ok(m) => m,
             Err(e) => {
@@ -81,6 +89,11 @@ pub fn spawn_maintenance_scheduler(
             tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
             tick
         });
+        let mut db_tick = maintenance.db_maintenance_interval.map(|every| {
+            let mut tick = tokio::time::interval(every);
+            tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+            tick
+        });
 
         // First run after one interval (Madmail starts ticker then waits).
         periodic_tick.tick().await;
@@ -88,6 +101,9 @@ pub fn spawn_maintenance_scheduler(
         if let Some(tick) = cert_tick.as_mut() {
             tick.tick().await;
         }
+        if let Some(tick) = db_tick.as_mut() {
+            tick.tick().await;
+        }
 
         loop {
             tokio::select! {
@@ -97,6 +113,9 @@ pub fn spawn_maintenance_scheduler(
                         pool: &pool,
                         mailbox: &mailbox,
                         maintenance: &maintenance,
+                        active_deliveries: 0,
+                        webhooks: webhooks.as_deref(),
+                        quota: quota.as_deref(),
                     };
                     match run_all_configured(&ctx).await {
                         Ok(report) => {
@@ -151,6 +170,35 @@ pub fn spawn_maintenance_scheduler(
                         }
                     }
                 }
+                _ = async {
+                    if let Some(tick) = db_tick.as_mut() {
+                        tick.tick().await;
+                    } else {
+                        std::future::pending::<()>().await;
+                    }
+                }, if maintenance.db_maintenance_interval.is_some() => {
+                    let ctx = TaskContext {
+                        pool: &pool,
+                        mailbox: &mailbox,
+                        maintenance: &maintenance,
+                        active_deliveries: drain.in_flight(),
+                        webhooks: webhooks.as_deref(),
+                        quota: quota.as_deref(),
+                    };
+                    match run_task(&ctx, TaskId::DbMaintenance, None).await {
+                        Ok(o) if o.skipped => {
+                            info!(detail = ?o.detail, "db maintenance: skipped");
+                        }
+                        Ok(o) => {
+                            info!(
+                                reclaimed_bytes = o.reclaimed_bytes,
+                                detail = ?o.detail,
+                                "db maintenance: completed"
+                            );
+                        }
+                        Err(e) => error!("db maintenance failed: {e}"),
+                    }
+                }
             }
         }
     });
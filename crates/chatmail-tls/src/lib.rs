@@ -16,25 +16,139 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 //! Server TLS from PEM files (`tls file` in maddy.conf).
+//!
+//! Listeners resolve the certificate per handshake through [`ReloadingCert`], so replacing the
+//! PEM files (certbot, `renewal_hook`) takes effect once [`ReloadingCert::reload_if_changed`]
+//! sees a new mtime, without rebinding any socket.
 
 use std::fs::File;
 use std::io::BufReader;
-use std::path::Path;
-use std::sync::Arc;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex, RwLock};
+use std::time::SystemTime;
 
 use chatmail_types::{ChatmailError, Result};
 use rustls::pki_types::{CertificateDer, PrivateKeyDer};
+use rustls::server::{ClientHello, ResolvesServerCert};
+use rustls::sign::CertifiedKey;
 use rustls::ServerConfig;
 use rustls_pemfile::{certs, pkcs8_private_keys, rsa_private_keys};
 
 pub fn load_server_config(cert_path: &Path, key_path: &Path) -> Result<Arc<ServerConfig>> {
+    Ok(ReloadingCert::load(cert_path, key_path)?.server_config())
+}
+
+/// Certificate/key pair served to every TLS listener, reloaded when the files change.
+#[derive(Debug)]
+pub struct ReloadingCert {
+    cert_path: PathBuf,
+    key_path: PathBuf,
+    current: RwLock<LoadedCert>,
+    /// `(cert, key)` mtimes of the loaded files.
+    stamp: Mutex<(Option<SystemTime>, Option<SystemTime>)>,
+}
+
+#[derive(Debug, Clone)]
+struct LoadedCert {
+    key: Arc<CertifiedKey>,
+    not_before: i64,
+    not_after: i64,
+}
+
+impl ReloadingCert {
+    pub fn load(cert_path: &Path, key_path: &Path) -> Result<Arc<Self>> {
+        let stamp = (mtime(cert_path), mtime(key_path));
+        let loaded = load_certified_key(cert_path, key_path)?;
+        Ok(Arc::new(Self {
+            cert_path: cert_path.to_path_buf(),
+            key_path: key_path.to_path_buf(),
+            current: RwLock::new(loaded),
+            stamp: Mutex::new(stamp),
+        }))
+    }
+
+    /// Server config whose handshakes always use the currently loaded pair.
+    pub fn server_config(self: &Arc<Self>) -> Arc<ServerConfig> {
+        let resolver: Arc<dyn ResolvesServerCert> = Arc::clone(self) as _;
+        Arc::new(
+            ServerConfig::builder()
+                .with_no_client_auth()
+                .with_cert_resolver(resolver),
+        )
+    }
+
+    pub fn cert_path(&self) -> &Path {
+        &self.cert_path
+    }
+
+    pub fn key_path(&self) -> &Path {
+        &self.key_path
+    }
+
+    /// `NotAfter` of the active end-entity certificate (unix seconds).
+    pub fn not_after(&self) -> i64 {
+        self.validity().1
+    }
+
+    /// `(NotBefore, NotAfter)` of the active end-entity certificate (unix seconds).
+    pub fn validity(&self) -> (i64, i64) {
+        let current = self.current.read().unwrap_or_else(|e| e.into_inner());
+        (current.not_before, current.not_after)
+    }
+
+    /// Reload when either file's mtime changed. Returns `true` when a new pair is active;
+    /// on a parse error (e.g. a half-written file) the old pair stays and the next call retries.
+    pub fn reload_if_changed(&self) -> Result<bool> {
+        let stamp = (mtime(&self.cert_path), mtime(&self.key_path));
+        let mut seen = self.stamp.lock().unwrap_or_else(|e| e.into_inner());
+        if *seen == stamp {
+            return Ok(false);
+        }
+        let loaded = load_certified_key(&self.cert_path, &self.key_path)?;
+        *self.current.write().unwrap_or_else(|e| e.into_inner()) = loaded;
+        *seen = stamp;
+        Ok(true)
+    }
+}
+
+impl ResolvesServerCert for ReloadingCert {
+    fn resolve(&self, _client_hello: ClientHello<'_>) -> Option<Arc<CertifiedKey>> {
+        Some(Arc::clone(
+            &self.current.read().unwrap_or_else(|e| e.into_inner()).key,
+        ))
+    }
+}
+
+fn mtime(path: &Path) -> Option<SystemTime> {
+    std::fs::metadata(path).and_then(|m| m.modified()).ok()
+}
+
+fn load_certified_key(cert_path: &Path, key_path: &Path) -> Result<LoadedCert> {
     let certs = load_certs(cert_path)?;
     let key = load_private_key(key_path)?;
-    let config = ServerConfig::builder()
-        .with_no_client_auth()
-        .with_single_cert(certs, key)
+    let (not_before, not_after) = certificate_validity(&certs[0])?;
+    let signing_key = rustls::crypto::ring::sign::any_supported_type(&key)
         .map_err(|e| ChatmailError::config(format!("TLS server config: {e}")))?;
-    Ok(Arc::new(config))
+    Ok(LoadedCert {
+        key: Arc::new(CertifiedKey::new(certs, signing_key)),
+        not_before,
+        not_after,
+    })
+}
+
+/// `(NotBefore, NotAfter)` of a DER certificate as unix seconds.
+pub fn certificate_validity(der: &[u8]) -> Result<(i64, i64)> {
+    let cert = openssl::x509::X509::from_der(der)
+        .map_err(|e| ChatmailError::config(format!("parse TLS certificate: {e}")))?;
+    let epoch = openssl::asn1::Asn1Time::from_unix(0)
+        .map_err(|e| ChatmailError::config(format!("TLS certificate time: {e}")))?;
+    let unix = |t: &openssl::asn1::Asn1TimeRef| {
+        epoch
+            .diff(t)
+            .map(|d| i64::from(d.days) * 86_400 + i64::from(d.secs))
+            .map_err(|e| ChatmailError::config(format!("TLS certificate time: {e}")))
+    };
+    Ok((unix(cert.not_before())?, unix(cert.not_after())?))
 }
 
 fn load_certs(path: &Path) -> Result<Vec<CertificateDer<'static>>> {
@@ -77,3 +191,70 @@ fn load_private_key(path: &Path) -> Result<PrivateKeyDer<'static>> {
         .ok_or_else(|| ChatmailError::config(format!("no private key in {}", path.display())))?;
     Ok(PrivateKeyDer::Pkcs1(key))
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rcgen::{CertificateParams, KeyPair};
+    use time::{Duration, OffsetDateTime};
+
+    /// Self-signed pair valid for `days` from now.
+    fn write_pair(dir: &Path, days: i64) -> (PathBuf, PathBuf, i64) {
+        let mut params = CertificateParams::new(vec!["mx.test".into()]).unwrap();
+        let not_after = OffsetDateTime::now_utc() + Duration::days(days);
+        params.not_after = not_after;
+        let key = KeyPair::generate().unwrap();
+        let cert = params.self_signed(&key).unwrap();
+        let (cert_path, key_path) = (dir.join("fullchain.pem"), dir.join("privkey.pem"));
+        std::fs::write(&cert_path, cert.pem()).unwrap();
+        std::fs::write(&key_path, key.serialize_pem()).unwrap();
+        (cert_path, key_path, not_after.unix_timestamp())
+    }
+
+    fn bump_mtime(path: &Path, secs: u64) {
+        let file = File::options().write(true).open(path).unwrap();
+        file.set_modified(SystemTime::now() + std::time::Duration::from_secs(secs))
+            .unwrap();
+    }
+
+    #[test]
+    fn reads_not_after_of_short_lived_cert() {
+        let dir = tempfile::tempdir().unwrap();
+        let (cert, key, not_after) = write_pair(dir.path(), 3);
+        let loaded = ReloadingCert::load(&cert, &key).unwrap();
+        assert!((loaded.not_after() - not_after).abs() <= 1);
+        assert!(!loaded.reload_if_changed().unwrap(), "unchanged files");
+    }
+
+    #[test]
+    fn reloads_when_mtime_changes() {
+        let dir = tempfile::tempdir().unwrap();
+        let (cert, key, _) = write_pair(dir.path(), 2);
+        let loaded = ReloadingCert::load(&cert, &key).unwrap();
+        let config = loaded.server_config();
+        let before = loaded.not_after();
+
+        let (_, _, renewed) = write_pair(dir.path(), 90);
+        bump_mtime(&cert, 5);
+        bump_mtime(&key, 5);
+        assert!(loaded.reload_if_changed().unwrap());
+        assert!((loaded.not_after() - renewed).abs() <= 1);
+        assert!(loaded.not_after() > before);
+        assert!(!loaded.reload_if_changed().unwrap());
+        // Configs handed out earlier resolve through the same reloaded pair.
+        assert_eq!(Arc::strong_count(&loaded), 2);
+        drop(config);
+    }
+
+    #[test]
+    fn broken_rewrite_keeps_the_old_pair() {
+        let dir = tempfile::tempdir().unwrap();
+        let (cert, key, not_after) = write_pair(dir.path(), 10);
+        let loaded = ReloadingCert::load(&cert, &key).unwrap();
+
+        std::fs::write(&cert, "-----BEGIN CERTIFICATE-----\n").unwrap();
+        bump_mtime(&cert, 5);
+        assert!(loaded.reload_if_changed().is_err());
+        assert!((loaded.not_after() - not_after).abs() <= 1);
+    }
+}
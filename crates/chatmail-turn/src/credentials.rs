@@ -16,6 +16,9 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 //! TURN REST shared-secret credentials (Madmail / Delta Chat core compatible).
+//!
+//! The username is the expiry as unix seconds, optionally followed by `:<account>` (the form
+//! `POST /turn/credentials` hands out); the password is `base64(HMAC-SHA1(secret, username))`.
 
 use hmac::{Hmac, Mac};
 use sha1::Sha1;
@@ -45,6 +48,57 @@ pub fn hmac_turn_password(secret: &str, username: &str) -> Result<String, TurnCr
     ))
 }
 
+/// Expiry of a REST username: `1700086400` or `1700086400:alice@example.org`.
+pub fn turn_username_expiry(username: &str) -> Option<i64> {
+    let expiry = username
+        .split_once(':')
+        .map_or(username, |(expiry, _)| expiry);
+    expiry.parse::<i64>().ok().filter(|t| *t > 0)
+}
+
+/// Whether `password` was issued for `username` under `secret` and has not expired at
+/// `now_unix`.
+pub fn verify_turn_password(secret: &str, username: &str, password: &str, now_unix: i64) -> bool {
+    if secret.is_empty() || turn_username_expiry(username).is_none_or(|t| t <= now_unix) {
+        return false;
+    }
+    let Ok(tag) = base64::Engine::decode(&base64::engine::general_purpose::STANDARD, password)
+    else {
+        return false;
+    };
+    let Ok(mut mac) = HmacSha1::new_from_slice(secret.as_bytes()) else {
+        return false;
+    };
+    mac.update(username.as_bytes());
+    mac.verify_slice(&tag).is_ok()
+}
+
+/// One set of credentials from `POST /turn/credentials`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TurnRestCredentials {
+    /// `<unix-expiry>:<account>`.
+    pub username: String,
+    pub password: String,
+    pub ttl_secs: u64,
+}
+
+/// Credentials for `account`, valid for `ttl_secs` from `now_unix`.
+pub fn turn_rest_credentials(
+    secret: &str,
+    account: &str,
+    ttl_secs: u64,
+    now_unix: i64,
+) -> Result<TurnRestCredentials, TurnCredentialError> {
+    let expiry = now_unix.saturating_add(ttl_secs as i64);
+    let username = format!("{expiry}:{account}");
+    let password = hmac_turn_password(secret, &username)?;
+    Ok(TurnRestCredentials {
+        username,
+        password,
+        ttl_secs,
+    })
+}
+
 /// Build `hostname:port:username:password` for `/shared/vendor/deltachat/turn`.
 ///
 /// `username` is `now_unix + ttl_secs` (expiration timestamp as decimal string).
@@ -88,6 +142,34 @@ mod tests {
         assert_eq!(line, format!("turn.example.com:3478:{expiry}:{password}"));
     }
 
+    #[test]
+    fn rest_credentials_follow_the_turn_rest_formula() {
+        let creds =
+            turn_rest_credentials("test-secret", "alice@example.org", 86_400, 1_700_000_000)
+                .unwrap();
+        assert_eq!(creds.username, "1700086400:alice@example.org");
+        // base64(HMAC-SHA1("test-secret", "1700086400:alice@example.org"))
+        assert_eq!(creds.password, "HrqY3G2JZpJqlDv0Rak3ihxEIs4=");
+        assert_eq!(creds.ttl_secs, 86_400);
+        assert_eq!(turn_username_expiry(&creds.username), Some(1_700_086_400));
+        assert_eq!(turn_username_expiry("1700086400"), Some(1_700_086_400));
+        assert_eq!(turn_username_expiry("alice@example.org"), None);
+    }
+
+    #[test]
+    fn verify_rejects_stale_or_foreign_credentials() {
+        let creds =
+            turn_rest_credentials("test-secret", "alice@example.org", 600, 1_700_000_000).unwrap();
+        let verify = |secret, user, pass, now| verify_turn_password(secret, user, pass, now);
+        let (user, pass) = (creds.username.as_str(), creds.password.as_str());
+        assert!(verify("test-secret", user, pass, 1_700_000_599));
+        assert!(!verify("test-secret", user, pass, 1_700_000_600), "expired");
+        assert!(!verify("other-secret", user, pass, 1_700_000_000));
+        let bob = "1700000600:bob@example.org";
+        assert!(!verify("test-secret", bob, pass, 1_700_000_000));
+        assert!(!verify("test-secret", user, "not base64", 1_700_000_000));
+    }
+
     #[test]
     fn rejects_empty_secret() {
         assert!(hmac_turn_password("", "1").is_err());
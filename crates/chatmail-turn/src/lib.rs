@@ -26,7 +26,10 @@ mod turn_client;
 pub use allocate_client::turn_allocate;
 pub use turn_client::{turn_allocate_on_socket, TurnClient};
 
-pub use credentials::{hmac_turn_password, turn_metadata_line, TurnCredentialError};
+pub use credentials::{
+    hmac_turn_password, turn_metadata_line, turn_rest_credentials, turn_username_expiry,
+    verify_turn_password, TurnCredentialError, TurnRestCredentials,
+};
 pub use parse::{parse_turn_metadata, ParseTurnMetadataError, ParsedTurnMetadata};
 pub use runner::{
     spawn_turn_server, spawn_turn_server_with_opts, turn_debug_from_env,
@@ -74,6 +77,20 @@ impl TurnDiscovery {
         })
     }
 
+    /// `turn:<server>:<port>` as listed in `POST /turn/credentials`.
+    pub fn uri(&self) -> String {
+        format!("turn:{}:{}", self.server, self.port)
+    }
+
+    /// REST credentials for `account` (`POST /turn/credentials`).
+    pub fn rest_credentials(
+        &self,
+        account: &str,
+        now_unix: i64,
+    ) -> Result<TurnRestCredentials, TurnCredentialError> {
+        turn_rest_credentials(&self.secret, account, self.ttl_secs, now_unix)
+    }
+
     pub fn metadata_line(&self, now_unix: i64) -> Result<String, TurnCredentialError> {
         turn_metadata_line(
             &self.server,
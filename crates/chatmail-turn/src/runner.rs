@@ -20,16 +20,18 @@
 use std::collections::BTreeSet;
 use std::net::{IpAddr, SocketAddr};
 use std::sync::Arc;
-use std::time::Duration;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
 
 use anyhow::{Context as _, Result};
 use tokio::net::UdpSocket;
-use turn::auth::LongTermAuthHandler;
+use turn::auth::{generate_auth_key, AuthHandler};
 use turn::relay::relay_range::RelayAddressGeneratorRanges;
 use turn::server::config::{ConnConfig, ServerConfig};
 use turn::server::Server;
 use webrtc_util::vnet::net::Net;
 
+use crate::credentials::{hmac_turn_password, turn_username_expiry};
+
 /// Options when starting embedded TURN.
 #[derive(Debug, Clone)]
 pub struct TurnSpawnOpts {
@@ -101,6 +103,39 @@ pub struct TurnServerHandle {
     pub realm: String,
 }
 
+/// TURN REST long-term credentials: like webrtc's `LongTermAuthHandler`, but the username may
+/// carry `:<account>` after the expiry (`POST /turn/credentials`).
+struct RestAuthHandler {
+    secret: String,
+}
+
+impl AuthHandler for RestAuthHandler {
+    fn auth_handle(
+        &self,
+        username: &str,
+        realm: &str,
+        src_addr: SocketAddr,
+    ) -> std::result::Result<Vec<u8>, turn::Error> {
+        let now = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map_or(0, |d| d.as_secs() as i64);
+        let Some(expiry) = turn_username_expiry(username) else {
+            return Err(turn::Error::Other(format!(
+                "not a time-windowed username: {username}"
+            )));
+        };
+        if expiry <= now {
+            tracing::debug!(%username, %src_addr, "TURN: expired credentials");
+            return Err(turn::Error::Other(format!(
+                "expired time-windowed username {username}"
+            )));
+        }
+        let password = hmac_turn_password(&self.secret, username)
+            .map_err(|e| turn::Error::Other(e.to_string()))?;
+        Ok(generate_auth_key(username, realm, &password))
+    }
+}
+
 /// Spawn TURN on `listen` (UDP), advertising relay address `external` to clients.
 pub async fn spawn_turn_server(
     secret: &str,
@@ -133,7 +168,9 @@ pub async fn spawn_turn_server_with_opts(
     let conn_configs = build_conn_configs(listen, relay_ip, relay_range).await?;
     let n_ifaces = conn_configs.len();
 
-    let auth_handler = Arc::new(LongTermAuthHandler::new(secret.to_string()));
+    let auth_handler = Arc::new(RestAuthHandler {
+        secret: secret.to_string(),
+    });
     let server = Server::new(ServerConfig {
         conn_configs,
         realm: realm.to_string(),
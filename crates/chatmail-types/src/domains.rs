@@ -97,8 +97,9 @@ pub fn address_is_local(addr: &str, accepted_domains: &[String]) -> bool {
 }
 
 /// JIT login restriction: username must be `local@expected` (Madmail `ValidateLoginDomain`).
+/// `expected_domain` may list several domains separated by whitespace (extra mail domains).
 pub fn validate_login_domain(username: &str, expected_domain: &str) -> Result<(), String> {
-    if expected_domain.is_empty() {
+    if expected_domain.trim().is_empty() {
         return Ok(());
     }
     if username.contains('%') {
@@ -111,11 +112,18 @@ pub fn validate_login_domain(username: &str, expected_domain: &str) -> Result<()
         return Err("invalid username: empty localpart".into());
     }
     let domain = wrap_ip_domain(domain);
-    let expected = wrap_ip_domain(expected_domain);
-    if domain.eq_ignore_ascii_case(&expected) {
+    let expected: Vec<String> = expected_domain
+        .split_whitespace()
+        .map(wrap_ip_domain)
+        .collect();
+    if expected.iter().any(|e| domain.eq_ignore_ascii_case(e)) {
         Ok(())
     } else {
-        Err(format!("invalid login domain: expected @{expected}"))
+        let list: Vec<String> = expected.iter().map(|e| format!("@{e}")).collect();
+        Err(format!(
+            "invalid login domain: expected {}",
+            list.join(" or ")
+        ))
     }
 }
 
@@ -153,4 +161,15 @@ mod tests {
         assert!(validate_login_domain("x@1.1.1.1", "[1.1.1.1]").is_ok());
         assert!(validate_login_domain("x@wrong.com", "[1.1.1.1]").is_err());
     }
+
+    #[test]
+    fn validate_login_domain_list() {
+        let expected = "example.org example.net";
+        assert!(validate_login_domain("x@example.org", expected).is_ok());
+        assert!(validate_login_domain("x@Example.NET", expected).is_ok());
+        assert_eq!(
+            validate_login_domain("x@wrong.com", expected),
+            Err("invalid login domain: expected @example.org or @example.net".into())
+        );
+    }
 }
@@ -61,6 +61,14 @@ pub enum ChatmailError {
 
     #[error("protocol error: {0}")]
     Protocol(String),
+
+    /// New accounts refused: `max_accounts` reached or disk above the soft limit.
+    #[error("server full: {0}")]
+    ServerFull(String),
+
+    /// Submission refused: the account's sending rate limit is spent (retry later).
+    #[error("rate limited: {0}")]
+    RateLimited(String),
 }
 
 impl ChatmailError {
@@ -0,0 +1,693 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Self-service account endpoints.
+//!
+//! - `DELETE /account` (Basic auth): an account deletes itself when `allow_self_delete` is
+//!   on. Uses the same teardown as `DELETE /admin/accounts/{username}`
+//!   ([`chatmail_state::AppState::delete_account`]): mail, credentials and per-account rows
+//!   go, and the name is blocklisted so JIT login cannot bring it back. Share links whose
+//!   invite belongs to the address are removed as well.
+//! - `GET` / `POST /account/delete` (Basic auth): the two-step form of the same deletion. The
+//!   `GET` hands out a short-lived confirmation token that the `POST` must echo.
+//! - `POST /recover`: trade one of the `recovery_codes` handed out at registration for a new
+//!   password. Open IMAP sessions of the account are ended.
+//! - `POST /account/password` (Basic auth): the owner sets a password of their choice, checked
+//!   by [`chatmail_auth::validate_new_password`]. Open IMAP sessions are ended here too.
+//! - `GET` / `PUT` / `DELETE /account/sieve` (Basic auth): the owner's Sieve script, stored
+//!   under `sieve_store` once it parses ([`chatmail_delivery::parse_sieve`]). Not found while
+//!   `sieve_store` is unset.
+//!
+//! - `GET` / `PUT` / `DELETE /account/vacation` (Basic auth): the owner's automatic reply,
+//!   the same row `madmail autoresponse` and `/admin/accounts/{username}/autoresponse` edit
+//!   ([`chatmail_db::Autoresponse`]). Times are RFC 3339 here instead of Unix seconds.
+//!
+//! `self_service off` closes `/account/password`, `/account/sieve`, `/account/vacation` and
+//! both deletion routes.
+//! Wrong passwords on them count per client IP and per address; once either budget is spent
+//! the routes answer `429` until the window moves on.
+
+use std::collections::HashMap;
+use std::net::{IpAddr, SocketAddr};
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+
+use axum::body::Bytes;
+use axum::extract::{ConnectInfo, State};
+use axum::http::{header, HeaderMap, HeaderValue, StatusCode};
+use axum::response::{IntoResponse, Response};
+use axum::{Extension, Json};
+use chatmail_auth::{change_password, normalize_username, redeem_recovery_code};
+use chatmail_config::{build_dclogin_link, DEFAULT_GENERATED_CHARSET};
+use chatmail_db::policies::unix_now;
+use chatmail_db::{
+    record_account_audit, remove_sharing_contacts_for, Autoresponse, AUDIT_PASSWORD_CHANGED,
+    AUDIT_RECOVERED, SELF_DELETE_REASON,
+};
+use chatmail_delivery::parse_sieve;
+use chatmail_types::ChatmailError;
+use serde::Deserialize;
+use serde_json::{json, Value};
+use time::format_description::well_known::Rfc3339;
+use time::OffsetDateTime;
+
+use crate::cors::CorsSnap;
+use crate::handlers::{basic_authenticate, basic_credentials, client_ip, dclogin_mail_settings};
+use crate::response::{json_err, json_ok, with_cors};
+use crate::WwwState;
+
+/// The one refusal for an unknown, blocked or wrong address/code pair.
+const RECOVERY_REFUSED: &str = "invalid email or recovery code";
+/// How long a `GET /account/delete` confirmation token stays valid.
+pub const DELETE_CONFIRM_TTL: Duration = Duration::from_secs(300);
+
+/// Outstanding `/account/delete` confirmation tokens, one per account (in memory only).
+#[derive(Default)]
+pub struct DeleteConfirmations {
+    pending: Mutex<HashMap<String, (String, Instant)>>,
+}
+
+impl DeleteConfirmations {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// A fresh token for `user`; any earlier one stops working.
+    fn issue(&self, user: &str) -> String {
+        let token = format!("{:032x}", rand::random::<u128>());
+        let mut pending = self.pending.lock().expect("delete confirmations lock");
+        let now = Instant::now();
+        pending.retain(|_, (_, expires)| *expires > now);
+        pending.insert(user.to_string(), (token.clone(), now + DELETE_CONFIRM_TTL));
+        token
+    }
+
+    /// Use up `user`'s token when `token` matches it and it has not expired.
+    fn take(&self, user: &str, token: &str) -> bool {
+        let mut pending = self.pending.lock().expect("delete confirmations lock");
+        let valid = pending
+            .get(user)
+            .is_some_and(|(t, expires)| t == token && *expires > Instant::now());
+        if valid {
+            pending.remove(user);
+        }
+        valid
+    }
+}
+
+/// `403` while `self_service off`.
+fn self_service_closed(st: &WwwState, cors: &CorsSnap) -> Option<Response> {
+    (!st.config.self_service_enabled()).then(|| {
+        json_err(
+            StatusCode::FORBIDDEN,
+            "account self-service is disabled",
+            cors,
+        )
+    })
+}
+
+/// `403` unless self-deletion is allowed ([`chatmail_config::AppConfig::self_delete_enabled`]).
+fn self_delete_closed(st: &WwwState, cors: &CorsSnap) -> Option<Response> {
+    (!st.config.self_delete_enabled()).then(|| {
+        json_err(
+            StatusCode::FORBIDDEN,
+            "account self-deletion is disabled",
+            cors,
+        )
+    })
+}
+
+/// [`basic_authenticate`] behind the `/account/*` wrong-password limiter.
+async fn owner_authenticate(
+    st: &WwwState,
+    headers: &HeaderMap,
+    ip: Option<IpAddr>,
+    cors: &CorsSnap,
+) -> Result<String, Response> {
+    let claimed =
+        basic_credentials(headers).and_then(|(email, _)| normalize_username(email.trim()).ok());
+    if st.device_limits.owner_auth_blocked(ip, claimed.as_deref()) {
+        return Err(json_err(
+            StatusCode::TOO_MANY_REQUESTS,
+            "too many failed attempts",
+            cors,
+        ));
+    }
+    let result = basic_authenticate(st, headers, cors, "account").await;
+    if let Err(resp) = &result {
+        if resp.status() == StatusCode::UNAUTHORIZED && claimed.is_some() {
+            st.device_limits
+                .note_owner_auth_failure(ip, claimed.as_deref());
+            tracing::warn!(client_ip = ?ip, "account self-service: wrong password");
+        }
+    }
+    result
+}
+
+/// Delete `user` and the share links it owns; `204` or `500`.
+async fn delete_owned(st: &WwwState, user: &str, cors: &CorsSnap) -> Response {
+    if let Err(e) = st
+        .app
+        .delete_account(&st.pool, user, SELF_DELETE_REASON)
+        .await
+    {
+        tracing::warn!(%user, error = %e, "self delete failed");
+        return json_err(StatusCode::INTERNAL_SERVER_ERROR, "delete failed", cors);
+    }
+    if let Some(sharing) = &st.sharing {
+        let removed = match sharing.pool().await {
+            Ok(pool) => remove_sharing_contacts_for(pool, user).await,
+            Err(e) => Err(e),
+        };
+        if let Err(e) = removed {
+            tracing::warn!(%user, error = %e, "self delete: share link removal failed");
+        }
+    }
+    tracing::info!(%user, "account deleted by its owner");
+    with_cors(StatusCode::NO_CONTENT.into_response(), cors)
+}
+
+fn peer_ip(
+    st: &WwwState,
+    headers: &HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Option<IpAddr> {
+    client_ip(st, headers, peer.map(|Extension(ConnectInfo(addr))| addr))
+}
+
+/// DELETE `/account`
+pub async fn delete(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    if let Some(resp) = self_delete_closed(&st, &cors) {
+        return resp;
+    }
+    let ip = peer_ip(&st, &headers, peer);
+    let user = match owner_authenticate(&st, &headers, ip, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    delete_owned(&st, &user, &cors).await
+}
+
+/// GET `/account/delete` → `{"confirm", "expires_in"}`: the token `POST /account/delete`
+/// wants back.
+pub async fn delete_confirmation(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    if let Some(resp) = self_delete_closed(&st, &cors) {
+        return resp;
+    }
+    let ip = peer_ip(&st, &headers, peer);
+    let user = match owner_authenticate(&st, &headers, ip, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let mut resp = json_ok(
+        StatusCode::OK,
+        &json!({
+            "confirm": st.delete_confirms.issue(&user),
+            "expires_in": DELETE_CONFIRM_TTL.as_secs(),
+        }),
+        &cors,
+    );
+    resp.headers_mut()
+        .insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    resp
+}
+
+#[derive(Debug, Default, Deserialize)]
+pub struct DeleteRequest {
+    #[serde(default)]
+    pub confirm: String,
+}
+
+/// POST `/account/delete` — `{"confirm"}` from `GET /account/delete` → `204`.
+pub async fn delete_confirmed(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    body: Result<Json<DeleteRequest>, axum::extract::rejection::JsonRejection>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    if let Some(resp) = self_delete_closed(&st, &cors) {
+        return resp;
+    }
+    let ip = peer_ip(&st, &headers, peer);
+    let user = match owner_authenticate(&st, &headers, ip, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let req = body.map(|Json(req)| req).unwrap_or_default();
+    if !st.delete_confirms.take(&user, req.confirm.trim()) {
+        return json_err(
+            StatusCode::BAD_REQUEST,
+            "missing or expired confirmation; GET /account/delete first",
+            &cors,
+        );
+    }
+    delete_owned(&st, &user, &cors).await
+}
+
+#[derive(Debug, Default, Deserialize)]
+pub struct RecoverRequest {
+    #[serde(default)]
+    pub email: String,
+    #[serde(default)]
+    pub code: String,
+}
+
+/// POST `/recover` — `{"email", "code"}` → `{"email", "password", "dclogin_url"}`.
+pub async fn recover(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    body: Result<Json<RecoverRequest>, axum::extract::rejection::JsonRejection>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    if !st.config.recovery_codes.is_some_and(|n| n > 0) {
+        return json_err(StatusCode::NOT_FOUND, "Not found", &cors);
+    }
+    let ip = peer_ip(&st, &headers, peer);
+    if !st.device_limits.allow_ip(ip) {
+        return json_err(StatusCode::TOO_MANY_REQUESTS, "too many requests", &cors);
+    }
+    let req = body.map(|Json(req)| req).unwrap_or_default();
+    let refused = || json_err(StatusCode::FORBIDDEN, RECOVERY_REFUSED, &cors);
+    let Ok(user) = normalize_username(req.email.trim()) else {
+        return refused();
+    };
+    if !st.device_limits.allow_recovery(&user) {
+        return json_err(StatusCode::TOO_MANY_REQUESTS, "too many requests", &cors);
+    }
+    if st.app.auth.is_blocked(&user) {
+        return refused();
+    }
+    let policy = st.config.credential_policy();
+    let charset = st
+        .config
+        .generated_password_charset(DEFAULT_GENERATED_CHARSET);
+    let redeemed = redeem_recovery_code(
+        &st.pool,
+        &user,
+        &req.code,
+        &charset,
+        policy.generated_password_length(),
+    )
+    .await;
+    let (password, hash) = match redeemed {
+        Ok(Some(pair)) => pair,
+        Ok(None) => {
+            tracing::warn!(client_ip = ?ip, "account recovery rejected");
+            return refused();
+        }
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "account recovery failed");
+            return json_err(StatusCode::INTERNAL_SERVER_ERROR, "recovery failed", &cors);
+        }
+    };
+    st.app.auth.insert(&user, &hash);
+    st.app.sessions.revoke(&user);
+    let actor = ip.map(|ip| ip.to_string()).unwrap_or_default();
+    if let Err(e) = record_account_audit(&st.pool, AUDIT_RECOVERED, &user, &actor).await {
+        tracing::warn!(%user, error = %e, "account recovery: audit write failed");
+    }
+    tracing::info!(%user, client_ip = ?ip, "account recovered with a recovery code");
+    let mail = dclogin_mail_settings(&st, &headers).await;
+    let mut resp = json_ok(
+        StatusCode::OK,
+        &json!({
+            "email": user,
+            "password": password,
+            "dclogin_url": build_dclogin_link(&user, &password, &mail),
+        }),
+        &cors,
+    );
+    resp.headers_mut()
+        .insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    resp
+}
+
+#[derive(Debug, Deserialize)]
+pub struct ChangePasswordRequest {
+    #[serde(default)]
+    pub new_password: String,
+}
+
+/// POST `/account/password` — `{"new_password"}` → `{"email"}`. Repeating the call with the
+/// same password (now authenticating with it) succeeds again.
+pub async fn password(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    body: Result<Json<ChangePasswordRequest>, axum::extract::rejection::JsonRejection>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    if let Some(resp) = self_service_closed(&st, &cors) {
+        return resp;
+    }
+    let ip = peer_ip(&st, &headers, peer);
+    let user = match owner_authenticate(&st, &headers, ip, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let Ok(Json(req)) = body else {
+        return json_err(
+            StatusCode::BAD_REQUEST,
+            "expected a JSON body {\"new_password\": \"...\"}",
+            &cors,
+        );
+    };
+    if req.new_password.is_empty() {
+        return json_err(StatusCode::BAD_REQUEST, "new_password is required", &cors);
+    }
+    let policy = st.config.credential_policy();
+    let hash = match change_password(&st.pool, &policy, &user, &req.new_password).await {
+        Ok(Some(hash)) => hash,
+        // Deleted between the credential check and the write.
+        Ok(None) => return json_err(StatusCode::UNAUTHORIZED, "invalid credentials", &cors),
+        Err(ChatmailError::Config(msg)) => return json_err(StatusCode::BAD_REQUEST, &msg, &cors),
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "password change failed");
+            return json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "password change failed",
+                &cors,
+            );
+        }
+    };
+    st.app.auth.insert(&user, &hash);
+    st.app.sessions.revoke(&user);
+    let actor = ip.map(|ip| ip.to_string()).unwrap_or_default();
+    if let Err(e) = record_account_audit(&st.pool, AUDIT_PASSWORD_CHANGED, &user, &actor).await {
+        tracing::warn!(%user, error = %e, "password change: audit write failed");
+    }
+    tracing::info!(%user, client_ip = ?ip, "account password changed by its owner");
+    json_ok(StatusCode::OK, &json!({ "email": user }), &cors)
+}
+
+/// `404` while `sieve_store` is unset, else the self-service gate and owner authentication
+/// shared by the `/account/sieve` routes.
+async fn sieve_owner(
+    st: &WwwState,
+    headers: &HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    cors: &CorsSnap,
+) -> Result<String, Response> {
+    if !st.app.sieve.enabled() {
+        return Err(json_err(StatusCode::NOT_FOUND, "Not found", cors));
+    }
+    if let Some(resp) = self_service_closed(st, cors) {
+        return Err(resp);
+    }
+    let ip = peer_ip(st, headers, peer);
+    owner_authenticate(st, headers, ip, cors).await
+}
+
+/// GET `/account/sieve` → the script as `text/plain`, or `404` when there is none.
+pub async fn sieve_get(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match sieve_owner(&st, &headers, peer, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    match st.app.sieve.load(&user).await {
+        Ok(Some(source)) => with_cors(
+            (
+                StatusCode::OK,
+                [
+                    (header::CONTENT_TYPE, "text/plain; charset=utf-8"),
+                    (header::CACHE_CONTROL, "no-store"),
+                ],
+                source,
+            )
+                .into_response(),
+            &cors,
+        ),
+        Ok(None) => json_err(StatusCode::NOT_FOUND, "no sieve script", &cors),
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "sieve script read failed");
+            json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "sieve read failed",
+                &cors,
+            )
+        }
+    }
+}
+
+/// PUT `/account/sieve` — the script source (`application/sieve` or `text/plain`) → `204`.
+/// A script that does not parse is refused with `400` and the parser's message.
+pub async fn sieve_put(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    body: Bytes,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match sieve_owner(&st, &headers, peer, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let max = st.app.sieve.max_size();
+    if body.len() as u64 > max {
+        let msg = format!("script is larger than sieve_max_size ({max} bytes)");
+        return json_err(StatusCode::PAYLOAD_TOO_LARGE, &msg, &cors);
+    }
+    let Ok(source) = std::str::from_utf8(&body) else {
+        return json_err(StatusCode::BAD_REQUEST, "script is not UTF-8", &cors);
+    };
+    match parse_sieve(source) {
+        Ok(_) => {}
+        Err(ChatmailError::Config(msg)) => return json_err(StatusCode::BAD_REQUEST, &msg, &cors),
+        Err(e) => return json_err(StatusCode::BAD_REQUEST, &e.to_string(), &cors),
+    }
+    if let Err(e) = st.app.sieve.save(&user, source).await {
+        tracing::warn!(%user, error = %e, "sieve script write failed");
+        return json_err(
+            StatusCode::INTERNAL_SERVER_ERROR,
+            "sieve write failed",
+            &cors,
+        );
+    }
+    tracing::info!(%user, bytes = body.len(), "sieve script stored by its owner");
+    with_cors(StatusCode::NO_CONTENT.into_response(), &cors)
+}
+
+/// DELETE `/account/sieve` → `204`, or `404` when there was no script.
+pub async fn sieve_delete(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match sieve_owner(&st, &headers, peer, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    match st.app.sieve.remove(&user).await {
+        Ok(true) => with_cors(StatusCode::NO_CONTENT.into_response(), &cors),
+        Ok(false) => json_err(StatusCode::NOT_FOUND, "no sieve script", &cors),
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "sieve script removal failed");
+            json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "sieve delete failed",
+                &cors,
+            )
+        }
+    }
+}
+
+/// Self-service gate and owner authentication shared by the `/account/vacation` routes.
+async fn vacation_owner(
+    st: &WwwState,
+    headers: &HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    cors: &CorsSnap,
+) -> Result<String, Response> {
+    if let Some(resp) = self_service_closed(st, cors) {
+        return Err(resp);
+    }
+    let ip = peer_ip(st, headers, peer);
+    owner_authenticate(st, headers, ip, cors).await
+}
+
+#[derive(Debug, Deserialize)]
+pub struct VacationRequest {
+    #[serde(default)]
+    pub subject: String,
+    #[serde(default)]
+    pub body: String,
+    /// RFC 3339; no replies before it.
+    #[serde(default)]
+    pub start: Option<String>,
+    /// RFC 3339; no replies from it on.
+    #[serde(default)]
+    pub end: Option<String>,
+}
+
+fn parse_vacation_time(field: &str, raw: Option<&str>) -> Result<Option<i64>, String> {
+    let Some(raw) = raw.map(str::trim).filter(|r| !r.is_empty()) else {
+        return Ok(None);
+    };
+    OffsetDateTime::parse(raw, &Rfc3339)
+        .map(|t| Some(t.unix_timestamp()))
+        .map_err(|_| format!("{field} must be an RFC 3339 time, e.g. 2026-10-16T09:00:00Z"))
+}
+
+fn format_vacation_time(t: Option<i64>) -> Value {
+    t.and_then(|t| OffsetDateTime::from_unix_timestamp(t).ok())
+        .and_then(|t| t.format(&Rfc3339).ok())
+        .map_or(Value::Null, Value::String)
+}
+
+fn vacation_json(a: &Autoresponse) -> Value {
+    json!({
+        "email": a.username,
+        "active": a.active,
+        "in_effect": a.in_effect(unix_now()),
+        "subject": a.subject,
+        "body": a.body,
+        "start": format_vacation_time(a.starts_at),
+        "end": format_vacation_time(a.ends_at),
+        "updated_at": a.updated_at,
+    })
+}
+
+/// Apply a vacation change before the next delivery; a failed reload only delays it by one
+/// [`chatmail_state::RESPONDER_REFRESH_INTERVAL`].
+async fn refresh_responders(st: &WwwState) {
+    if let Err(e) = st.app.responders.refresh(&st.pool).await {
+        tracing::warn!(error = %e, "autoresponse cache refresh failed");
+    }
+}
+
+/// GET `/account/vacation` → the reply, or `404` when there is none.
+pub async fn vacation_get(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match vacation_owner(&st, &headers, peer, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    match chatmail_db::get_autoresponse(&st.pool, &user).await {
+        Ok(Some(a)) => json_ok(StatusCode::OK, &vacation_json(&a), &cors),
+        Ok(None) => json_err(StatusCode::NOT_FOUND, "no vacation reply", &cors),
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "vacation reply read failed");
+            json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "vacation read failed",
+                &cors,
+            )
+        }
+    }
+}
+
+/// PUT `/account/vacation` — `{"subject"?, "body", "start"?, "end"?}` replaces the reply and
+/// switches it on → the stored reply. Senders already answered stay answered.
+pub async fn vacation_put(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    body: Result<Json<VacationRequest>, axum::extract::rejection::JsonRejection>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match vacation_owner(&st, &headers, peer, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let Ok(Json(req)) = body else {
+        return json_err(
+            StatusCode::BAD_REQUEST,
+            "expected a JSON body {\"subject\", \"body\", \"start\", \"end\"}",
+            &cors,
+        );
+    };
+    let times = parse_vacation_time("start", req.start.as_deref())
+        .and_then(|start| Ok((start, parse_vacation_time("end", req.end.as_deref())?)));
+    let (starts_at, ends_at) = match times {
+        Ok(t) => t,
+        Err(msg) => return json_err(StatusCode::BAD_REQUEST, &msg, &cors),
+    };
+    let reply = Autoresponse {
+        username: user.clone(),
+        active: true,
+        subject: req.subject,
+        body: req.body,
+        starts_at,
+        ends_at,
+        updated_at: 0,
+    };
+    let stored = match chatmail_db::set_autoresponse(&st.pool, &reply).await {
+        Ok(stored) => stored,
+        Err(ChatmailError::Config(msg)) => return json_err(StatusCode::BAD_REQUEST, &msg, &cors),
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "vacation reply write failed");
+            return json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "vacation write failed",
+                &cors,
+            );
+        }
+    };
+    refresh_responders(&st).await;
+    tracing::info!(%user, "vacation reply set by its owner");
+    json_ok(StatusCode::OK, &vacation_json(&stored), &cors)
+}
+
+/// DELETE `/account/vacation` → `204`, or `404` when there was no reply.
+pub async fn vacation_delete(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match vacation_owner(&st, &headers, peer, &cors).await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    match chatmail_db::clear_autoresponse(&st.pool, &user).await {
+        Ok(true) => {
+            refresh_responders(&st).await;
+            with_cors(StatusCode::NO_CONTENT.into_response(), &cors)
+        }
+        Ok(false) => json_err(StatusCode::NOT_FOUND, "no vacation reply", &cors),
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "vacation reply removal failed");
+            json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "vacation delete failed",
+                &cors,
+            )
+        }
+    }
+}
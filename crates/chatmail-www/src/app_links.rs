@@ -0,0 +1,283 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Contact landing deep links: platform detection from `User-Agent`, the primary
+//! "open in Delta Chat" target per platform, and store fallbacks.
+//!
+//! Only data is computed here; `contact_view.html` decides what to show so
+//! `www_dir` overrides can restyle or reorder the buttons.
+
+use chatmail_config::AppLinksConfig;
+use serde::Serialize;
+
+const OPENPGP4FPR: &str = "openpgp4fpr:";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Platform {
+    Android,
+    Ios,
+    Desktop,
+}
+
+impl Platform {
+    /// Coarse UA sniffing; anything that is not Android or iOS is treated as desktop.
+    pub fn from_user_agent(ua: &str) -> Self {
+        if ua.contains("Android") {
+            Self::Android
+        } else if ua.contains("iPhone") || ua.contains("iPad") || ua.contains("iPod") {
+            Self::Ios
+        } else {
+            Self::Desktop
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Android => "android",
+            Self::Ios => "ios",
+            Self::Desktop => "desktop",
+        }
+    }
+}
+
+/// `Custom.App` in `contact_view.html`. Empty store URLs are hidden by the template.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "PascalCase")]
+#[allow(non_snake_case)]
+pub struct AppLinks {
+    /// `android`, `ios` or `desktop`.
+    pub Platform: String,
+    /// Primary button: Android `intent:` with store fallback, iOS universal link,
+    /// desktop `openpgp4fpr:` URI.
+    pub OpenURL: String,
+    /// Universal link (`https://i.delta.chat/#…`); the raw invite when no base is set.
+    pub InviteURL: String,
+    pub FDroidURL: String,
+    pub PlayURL: String,
+    pub AppStoreURL: String,
+    pub FlathubURL: String,
+    pub DesktopURL: String,
+}
+
+impl AppLinks {
+    pub fn new(cfg: &AppLinksConfig, invite: &str, platform: Platform) -> Self {
+        let invite_url = universal_link(&cfg.invite_base_url, invite);
+        let open_url = match platform {
+            Platform::Android => {
+                let fallback = [&cfg.play_url, &cfg.fdroid_url, &invite_url]
+                    .into_iter()
+                    .find(|u| !u.is_empty())
+                    .cloned()
+                    .unwrap_or_default();
+                android_intent(invite, &cfg.android_package, &fallback)
+                    .unwrap_or_else(|| invite.to_string())
+            }
+            Platform::Ios => invite_url.clone(),
+            Platform::Desktop => invite.to_string(),
+        };
+        Self {
+            Platform: platform.as_str().to_string(),
+            OpenURL: open_url,
+            InviteURL: invite_url,
+            FDroidURL: cfg.fdroid_url.clone(),
+            PlayURL: cfg.play_url.clone(),
+            AppStoreURL: cfg.app_store_url.clone(),
+            FlathubURL: cfg.flathub_url.clone(),
+            DesktopURL: cfg.desktop_url.clone(),
+        }
+    }
+}
+
+/// `openpgp4fpr:FPR#a=…&n=…` → `{base}#FPR&a=…&n=…` (inverse of `normalize_sharing_url`).
+fn universal_link(base: &str, invite: &str) -> String {
+    let Some(rest) = strip_scheme(invite) else {
+        return invite.to_string();
+    };
+    if base.is_empty() {
+        return invite.to_string();
+    }
+    format!("{base}#{}", rest.replacen('#', "&", 1))
+}
+
+/// Chrome/Android intent URI: opens any `openpgp4fpr` handler (or `package`), else
+/// navigates to `fallback`. `Intent.parseUri` splits on the last `#`, so the invite's
+/// own fragment survives as long as the fallback is percent-encoded.
+fn android_intent(invite: &str, package: &str, fallback: &str) -> Option<String> {
+    let rest = strip_scheme(invite)?;
+    let mut out = format!("intent:{rest}#Intent;scheme=openpgp4fpr;");
+    if !package.is_empty() {
+        out.push_str(&format!("package={package};"));
+    }
+    if !fallback.is_empty() {
+        out.push_str(&format!(
+            "S.browser_fallback_url={};",
+            percent_encode(fallback)
+        ));
+    }
+    out.push_str("end");
+    Some(out)
+}
+
+fn strip_scheme(invite: &str) -> Option<&str> {
+    let head = invite.get(..OPENPGP4FPR.len())?;
+    head.eq_ignore_ascii_case(OPENPGP4FPR)
+        .then(|| &invite[OPENPGP4FPR.len()..])
+}
+
+fn percent_encode(s: &str) -> String {
+    let mut out = String::with_capacity(s.len() * 3);
+    for b in s.bytes() {
+        if b.is_ascii_alphanumeric() || b"-._~".contains(&b) {
+            out.push(b as char);
+        } else {
+            out.push_str(&format!("%{b:02X}"));
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::template::{CustomFields, TemplateEngine, WwwContext};
+
+    const INVITE: &str = "openpgp4fpr:ABCDEF0123456789#a=alice%40example.org&n=Alice";
+    const ANDROID_UA: &str =
+        "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/126.0 Mobile";
+    const IOS_UA: &str =
+        "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 Mobile";
+    const DESKTOP_UA: &str =
+        "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0";
+
+    fn render(ua: &str, cfg: &AppLinksConfig) -> String {
+        let links = AppLinks::new(cfg, INVITE, Platform::from_user_agent(ua));
+        let ctx = WwwContext {
+            MailDomain: "example.org".into(),
+            MailDomains: vec!["example.org".into()],
+            MXDomain: String::new(),
+            WebDomain: "example.org".into(),
+            PublicIP: String::new(),
+            Version: "test".into(),
+            RegistrationOpen: true,
+            JitRegistrationEnabled: false,
+            Language: "en".into(),
+            ClientHost: String::new(),
+            ImapPortTLS: "993".into(),
+            ImapPortStartTLS: "143".into(),
+            SmtpPortTLS: "465".into(),
+            SmtpPortStartTLS: "587".into(),
+            DcloginImapSecurity: String::new(),
+            DcloginSmtpSecurity: String::new(),
+            DefaultQuota: 0,
+            SSURL: String::new(),
+            V2rayNGConfigWS: String::new(),
+            V2rayNGConfigGRPC: String::new(),
+            MessageRetentionLine: None,
+            Custom: Some(CustomFields {
+                Slug: "alice".into(),
+                URL: INVITE.into(),
+                Name: "Alice".into(),
+                Kind: "contact".into(),
+                MemberNote: String::new(),
+                Description: String::new(),
+                App: Some(links),
+                Links: Vec::new(),
+                Analytics: false,
+            }),
+        };
+        let html = TemplateEngine::new()
+            .render("contact_view.html", &ctx)
+            .unwrap();
+        // Undo attribute auto-escaping so assertions read like the URLs themselves.
+        html.replace("&#x2f;", "/")
+            .replace("&quot;", "\"")
+            .replace("&amp;", "&")
+    }
+
+    #[test]
+    fn detects_platform_from_user_agent() {
+        assert_eq!(Platform::from_user_agent(ANDROID_UA), Platform::Android);
+        assert_eq!(Platform::from_user_agent(IOS_UA), Platform::Ios);
+        assert_eq!(Platform::from_user_agent(DESKTOP_UA), Platform::Desktop);
+        assert_eq!(Platform::from_user_agent(""), Platform::Desktop);
+    }
+
+    #[test]
+    fn universal_link_round_trips_invite() {
+        assert_eq!(
+            universal_link("https://i.delta.chat/", INVITE),
+            "https://i.delta.chat/#ABCDEF0123456789&a=alice%40example.org&n=Alice"
+        );
+        assert_eq!(universal_link("", INVITE), INVITE);
+        assert_eq!(
+            universal_link("https://i.delta.chat/", "reserved"),
+            "reserved"
+        );
+    }
+
+    #[test]
+    fn android_page_uses_intent_with_store_fallback() {
+        let page = render(ANDROID_UA, &AppLinksConfig::default());
+        assert!(page.contains(r#"data-platform="android""#), "{page}");
+        assert!(page.contains(
+            "intent:ABCDEF0123456789#a=alice%40example.org&n=Alice#Intent;scheme=openpgp4fpr;\
+             S.browser_fallback_url=https%3A%2F%2Fplay.google.com%2Fstore%2Fapps%2Fdetails%3Fid%3Dchat.delta;end"
+        ));
+        assert!(page.contains("f-droid.org/packages/com.b44t.messenger/"));
+        assert!(!page.contains("apps.apple.com"));
+        assert!(!page.contains("flathub.org"));
+        assert!(page.contains(r#"id="contact-qr""#));
+    }
+
+    #[test]
+    fn ios_page_uses_universal_link_and_app_store() {
+        let page = render(IOS_UA, &AppLinksConfig::default());
+        assert!(page.contains(r#"data-platform="ios""#), "{page}");
+        assert!(page.contains(
+            r#"href="https://i.delta.chat/#ABCDEF0123456789&a=alice%40example.org&n=Alice""#
+        ));
+        assert!(page.contains("apps.apple.com"));
+        assert!(!page.contains("play.google.com"));
+        assert!(!page.contains("intent:"));
+    }
+
+    #[test]
+    fn desktop_page_uses_uri_scheme_and_desktop_stores() {
+        let mut cfg = AppLinksConfig::default();
+        cfg.apply_directive("app_desktop_url", "https://fork.example/download");
+        let page = render(DESKTOP_UA, &cfg);
+        assert!(page.contains(r#"data-platform="desktop""#), "{page}");
+        assert!(
+            page.contains(r#"href="openpgp4fpr:ABCDEF0123456789#a=alice%40example.org&n=Alice""#)
+        );
+        assert!(page.contains("flathub.org/apps/chat.delta.desktop"));
+        assert!(page.contains("https://fork.example/download"));
+        assert!(!page.contains("play.google.com"));
+    }
+
+    #[test]
+    fn android_intent_honours_package_and_disabled_stores() {
+        let mut cfg = AppLinksConfig::default();
+        cfg.apply_directive("app_android_package", "org.fork.chat");
+        cfg.apply_directive("app_play_url", "off");
+        let links = AppLinks::new(&cfg, INVITE, Platform::Android);
+        assert!(links.OpenURL.contains(";package=org.fork.chat;"));
+        assert!(links
+            .OpenURL
+            .contains("S.browser_fallback_url=https%3A%2F%2Ff-droid.org"));
+        assert!(links.PlayURL.is_empty());
+    }
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Mail client setup documents: Mozilla autoconfig (Thunderbird, Delta Chat) and Outlook
+//! AutoDiscover. Both answer GET and POST.
+//!
+//! When the client names an address (`?emailaddress=`, or `<EMailAddress>` in an Outlook
+//! POST body) on a domain this server does not serve, the answer is the format's XML error
+//! document with status 200: clients treat a 404 as "try the next lookup method" and
+//! would end up probing unrelated hosts.
+
+use axum::body::Bytes;
+use axum::extract::{Query, State};
+use axum::http::{header, HeaderMap, StatusCode};
+use axum::response::{IntoResponse, Response};
+use chatmail_auth::normalize_username;
+use chatmail_config::{
+    build_autoconfig_error_xml, build_autoconfig_xml, build_autodiscover_error_xml,
+    build_autodiscover_xml, AutoconfigParams, RuntimeListeners,
+};
+use serde::Deserialize;
+
+use crate::handlers::dclogin_mail_settings;
+use crate::WwwState;
+
+/// Thunderbird's fallback to the mail domain's own host (next to `/.well-known/…`).
+pub const AUTOCONFIG_PATH: &str = "/mail/config-v1.1.xml";
+pub const AUTODISCOVER_PATH: &str = "/autodiscover/autodiscover.xml";
+/// Spelling Outlook uses on most versions.
+pub const AUTODISCOVER_PATH_OUTLOOK: &str = "/Autodiscover/Autodiscover.xml";
+
+#[derive(Deserialize, Default)]
+pub struct SetupQuery {
+    #[serde(default)]
+    pub emailaddress: Option<String>,
+}
+
+/// Mozilla ISPDB autoconfig for Delta Chat / Thunderbird (`dcaccount:` fetch).
+pub async fn mail_autoconfig(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    Query(query): Query<SetupQuery>,
+) -> Response {
+    if let Some(raw) = requested_address(query.emailaddress.as_deref()) {
+        if let Err(reason) = setup_address(&st, raw) {
+            return xml_response(build_autoconfig_error_xml(&reason), false);
+        }
+    }
+    let params = autoconfig_params(&st, &headers).await;
+    xml_response(build_autoconfig_xml(&params), true)
+}
+
+/// Outlook AutoDiscover: the address comes from the POST body (`<EMailAddress>`) or, for
+/// GET, from `?emailaddress=`.
+pub async fn autodiscover(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    Query(query): Query<SetupQuery>,
+    body: Bytes,
+) -> Response {
+    let body = String::from_utf8_lossy(&body);
+    let raw = requested_address(xml_text(&body, "EMailAddress"))
+        .or_else(|| requested_address(query.emailaddress.as_deref()));
+    let email = match raw.map(|raw| setup_address(&st, raw)) {
+        Some(Ok(email)) => email,
+        Some(Err(reason)) => return xml_response(build_autodiscover_error_xml(&reason), false),
+        None => "%EMAILADDRESS%".to_string(),
+    };
+    let params = autoconfig_params(&st, &headers).await;
+    xml_response(build_autodiscover_xml(&params, &email), true)
+}
+
+fn requested_address(raw: Option<&str>) -> Option<&str> {
+    raw.map(str::trim).filter(|s| !s.is_empty())
+}
+
+/// Normalized `raw`, or why this server cannot set it up.
+fn setup_address(st: &WwwState, raw: &str) -> Result<String, String> {
+    let address = normalize_username(raw).map_err(|_| format!("invalid e-mail address {raw:?}"))?;
+    let domain = address.rsplit_once('@').map_or("", |(_, d)| d);
+    if !st.serves_domain(domain) {
+        return Err(format!("{domain} is not served by this server"));
+    }
+    Ok(address)
+}
+
+/// Text of the first `<tag>…</tag>` in `xml` (no namespaces prefixes, no nesting).
+fn xml_text<'a>(xml: &'a str, tag: &str) -> Option<&'a str> {
+    let open = format!("<{tag}>");
+    let start = xml.find(&open)? + open.len();
+    let len = xml[start..].find(&format!("</{tag}>"))?;
+    Some(&xml[start..start + len])
+}
+
+async fn autoconfig_params(st: &WwwState, headers: &HeaderMap) -> AutoconfigParams {
+    let mail = dclogin_mail_settings(st, headers).await;
+    let snap = st.app.listener_ports.snapshot();
+    let runtime = RuntimeListeners {
+        imap_plain_addr: snap.imap_plain_addr,
+        imap_tls_addr: snap.imap_tls_addr,
+        submission_plain_addr: snap.submission_plain_addr,
+        submission_tls_addr: snap.submission_tls_addr,
+        smtp_addr: snap.smtp_addr,
+        http_plain_addr: snap.http_plain_addr,
+        http_tls_addr: snap.http_tls_addr,
+    };
+    AutoconfigParams::from_mail_settings(&st.mail_domain, &mail, Some(&runtime))
+}
+
+/// Settings are cached for an hour; error documents are not cached at all.
+fn xml_response(xml: String, cacheable: bool) -> Response {
+    let cache = if cacheable {
+        "public, max-age=3600"
+    } else {
+        "no-store"
+    };
+    (
+        StatusCode::OK,
+        [
+            (header::CONTENT_TYPE, "application/xml; charset=utf-8"),
+            (header::CACHE_CONTROL, cache),
+        ],
+        xml,
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn xml_text_reads_the_outlook_request() {
+        let body = r#"<?xml version="1.0" encoding="utf-8"?>
+<Autodiscover>
+  <Request>
+    <EMailAddress>Alice@Example.org</EMailAddress>
+  </Request>
+</Autodiscover>"#;
+        assert_eq!(xml_text(body, "EMailAddress"), Some("Alice@Example.org"));
+        assert_eq!(xml_text(body, "LegacyDN"), None);
+        assert_eq!(xml_text("", "EMailAddress"), None);
+    }
+}
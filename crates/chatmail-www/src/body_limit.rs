@@ -0,0 +1,344 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Request body caps and `Content-Type` checks for the HTTP routes.
+//!
+//! Each route that reads a body has a [`BodyClass`] in the table next to its registration
+//! (`router::BODY_RULES`). Routes missing from the table get [`UNLISTED_BODY_LIMIT`], so a
+//! new handler cannot end up with an unbounded body. [`limit_body`] buffers the body up to
+//! the class cap before any extractor runs, which means an oversized upload costs at most
+//! `limit` bytes and is answered with `413`. A non-empty body with the wrong media type gets
+//! `415`. Multipart is never accepted, so no multipart parser ever sees client data.
+
+use axum::body::Body;
+use axum::extract::{Request, State};
+use axum::http::{header, HeaderMap, StatusCode};
+use axum::middleware::Next;
+use axum::response::{IntoResponse, Response};
+use futures_util::StreamExt;
+
+use crate::cors::CorsSnap;
+use crate::response::json_err;
+use crate::router::{body_class, WwwState};
+
+/// Cap for urlencoded form posts (`/share`).
+pub const FORM_BODY_LIMIT: usize = 64 * 1024;
+
+/// Cap for small JSON API calls (`/new`, `/device-redeem`, flag updates).
+pub const JSON_BODY_LIMIT: usize = 64 * 1024;
+
+/// Cap for routes that read no body at all.
+pub const UNLISTED_BODY_LIMIT: usize = 8 * 1024;
+
+/// Headroom over `max_message_size` for JSON framing and escaping (`\r\n` → `\\r\\n`).
+const MESSAGE_FRAMING: usize = 64 * 1024;
+
+pub const FORM_CONTENT_TYPE: &str = "application/x-www-form-urlencoded";
+pub const JSON_CONTENT_TYPE: &str = "application/json";
+pub const SIEVE_CONTENT_TYPE: &str = "application/sieve";
+
+/// How a route reads its body; picks the cap and the accepted media type.
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub enum BodyClass {
+    /// HTML form posts: urlencoded only, [`FORM_BODY_LIMIT`].
+    Form,
+    /// JSON API calls: [`JSON_BODY_LIMIT`].
+    Json,
+    /// JSON carrying a whole message: `max_message_size` plus framing.
+    Message,
+    /// JSON uploads (admin API, theme archives): `upload_body_limit`.
+    Upload,
+    /// Sieve script source (`/account/sieve`): `sieve_max_size`, `application/sieve` or
+    /// `text/plain`.
+    Sieve,
+    /// Routes that ignore the body: [`UNLISTED_BODY_LIMIT`], any media type.
+    Unlisted,
+}
+
+impl BodyClass {
+    /// Cap in bytes, given the live `max_message_size`, `upload_body_limit` and
+    /// `sieve_max_size`.
+    pub fn limit(self, max_message: u64, upload: u64, sieve: u64) -> usize {
+        let clamp = |n: u64| usize::try_from(n).unwrap_or(usize::MAX);
+        match self {
+            Self::Form => FORM_BODY_LIMIT,
+            Self::Json => JSON_BODY_LIMIT,
+            Self::Message => {
+                let m = clamp(max_message);
+                m.saturating_add(m / 8).saturating_add(MESSAGE_FRAMING)
+            }
+            Self::Upload => clamp(upload),
+            Self::Sieve => clamp(sieve),
+            Self::Unlisted => UNLISTED_BODY_LIMIT,
+        }
+    }
+
+    /// Media type a non-empty body must carry, if the class checks it.
+    pub fn expected_type(self) -> Option<&'static str> {
+        match self {
+            Self::Form => Some(FORM_CONTENT_TYPE),
+            Self::Json | Self::Message | Self::Upload => Some(JSON_CONTENT_TYPE),
+            Self::Sieve => Some(SIEVE_CONTENT_TYPE),
+            Self::Unlisted => None,
+        }
+    }
+
+    /// JSON bodies may omit `Content-Type` (CLI tools, `curl --data-binary`); forms may not.
+    fn accepts(self, headers: &HeaderMap) -> bool {
+        let media = headers
+            .get(header::CONTENT_TYPE)
+            .and_then(|v| v.to_str().ok())
+            .map(|v| {
+                v.split(';')
+                    .next()
+                    .unwrap_or("")
+                    .trim()
+                    .to_ascii_lowercase()
+            });
+        match (self, media.as_deref()) {
+            (Self::Unlisted, _) => true,
+            (Self::Form, Some(m)) => m == FORM_CONTENT_TYPE,
+            (Self::Form, None) => false,
+            (_, None) => true,
+            (Self::Sieve, Some(m)) => m == SIEVE_CONTENT_TYPE || m == "text/plain",
+            (_, Some(m)) => m == JSON_CONTENT_TYPE || m.ends_with("+json"),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum BodyRejection {
+    TooLarge {
+        limit: usize,
+    },
+    UnsupportedType {
+        expected: &'static str,
+    },
+    /// The client stopped sending mid-body.
+    Incomplete,
+}
+
+impl BodyRejection {
+    pub fn status(&self) -> StatusCode {
+        match self {
+            Self::TooLarge { .. } => StatusCode::PAYLOAD_TOO_LARGE,
+            Self::UnsupportedType { .. } => StatusCode::UNSUPPORTED_MEDIA_TYPE,
+            Self::Incomplete => StatusCode::BAD_REQUEST,
+        }
+    }
+
+    pub fn message(&self) -> String {
+        match self {
+            Self::TooLarge { limit } => format!("request body too large (limit {limit} bytes)"),
+            Self::UnsupportedType { expected } => {
+                format!("unsupported Content-Type: expected {expected}")
+            }
+            Self::Incomplete => "incomplete request body".into(),
+        }
+    }
+}
+
+/// Buffers the body of `req` up to `limit` and hands back an equivalent request, or the
+/// request headers with the reason it was refused.
+pub async fn limit_body(
+    req: Request,
+    class: BodyClass,
+    limit: usize,
+) -> Result<Request, (HeaderMap, BodyRejection)> {
+    let (parts, body) = req.into_parts();
+    let declared = parts
+        .headers
+        .get(header::CONTENT_LENGTH)
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.trim().parse::<u64>().ok());
+    let reject = |parts: axum::http::request::Parts, why| Err((parts.headers, why));
+    if declared.is_some_and(|n| n > limit as u64) {
+        return reject(parts, BodyRejection::TooLarge { limit });
+    }
+    let unsupported = BodyRejection::UnsupportedType {
+        expected: class.expected_type().unwrap_or("*/*"),
+    };
+    if declared.is_some_and(|n| n > 0) && !class.accepts(&parts.headers) {
+        return reject(parts, unsupported);
+    }
+
+    let mut buf = Vec::with_capacity(declared.map_or(0, |n| n as usize));
+    let mut stream = body.into_data_stream();
+    while let Some(chunk) = stream.next().await {
+        let Ok(chunk) = chunk else {
+            return reject(parts, BodyRejection::Incomplete);
+        };
+        if buf.len() + chunk.len() > limit {
+            return reject(parts, BodyRejection::TooLarge { limit });
+        }
+        buf.extend_from_slice(&chunk);
+    }
+    if !buf.is_empty() && !class.accepts(&parts.headers) {
+        return reject(parts, unsupported);
+    }
+    Ok(Request::from_parts(parts, Body::from(buf)))
+}
+
+/// JSON unless the client asked for HTML, or posted a form without asking for JSON.
+pub fn wants_html(headers: &HeaderMap, class: BodyClass) -> bool {
+    let accept = headers
+        .get(header::ACCEPT)
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or("");
+    if accept.contains("application/json") {
+        return false;
+    }
+    accept.contains("text/html") || class == BodyClass::Form
+}
+
+/// `413` / `415` / `400` as a small HTML page or `{"error": ...}` with CORS.
+pub fn rejection_response(
+    rejection: &BodyRejection,
+    class: BodyClass,
+    headers: &HeaderMap,
+    cors: &CorsSnap,
+) -> Response {
+    let status = rejection.status();
+    let message = rejection.message();
+    if !wants_html(headers, class) {
+        return json_err(status, &message, cors);
+    }
+    let title = format!(
+        "{} {}",
+        status.as_u16(),
+        status.canonical_reason().unwrap_or("Error")
+    );
+    let page = format!(
+        "<!doctype html>\n<html><head><meta charset=\"utf-8\"><title>{title}</title></head>\
+         <body><h1>{title}</h1><p>{message}</p></body></html>\n"
+    );
+    (
+        status,
+        [(header::CONTENT_TYPE, "text/html; charset=utf-8")],
+        page,
+    )
+        .into_response()
+}
+
+/// Middleware for the public routes: looks up the route's class and enforces it.
+pub async fn www_body_limit(State(st): State<WwwState>, req: Request, next: Next) -> Response {
+    let class = body_class(req.uri().path());
+    let limit = class.limit(
+        st.app.message_size.effective(),
+        st.config.effective_upload_body_limit(),
+        st.app.sieve.max_size(),
+    );
+    match limit_body(req, class, limit).await {
+        Ok(req) => next.run(req).await,
+        Err((headers, rejection)) => {
+            let cors = st.cors_snap(&headers).await;
+            rejection_response(&rejection, class, &headers, &cors)
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn post(content_type: Option<&str>, body: &'static str) -> Request {
+        let mut req = axum::http::Request::builder().method("POST").uri("/x");
+        if let Some(ct) = content_type {
+            req = req.header(header::CONTENT_TYPE, ct);
+        }
+        req.body(Body::from(body)).unwrap()
+    }
+
+    #[test]
+    fn message_class_tracks_max_message_size() {
+        assert_eq!(BodyClass::Form.limit(1 << 30, 1 << 30, 0), FORM_BODY_LIMIT);
+        assert_eq!(
+            BodyClass::Message.limit(8 * 1024 * 1024, 0, 0),
+            9 * 1024 * 1024 + MESSAGE_FRAMING
+        );
+        assert_eq!(BodyClass::Upload.limit(0, 5000, 0), 5000);
+        assert_eq!(BodyClass::Sieve.limit(0, 0, 700), 700);
+    }
+
+    #[tokio::test]
+    async fn oversized_body_is_refused_without_content_length() {
+        let body = Body::from_stream(futures_util::stream::iter(
+            (0..4).map(|_| Ok::<_, std::io::Error>(vec![b'x'; 100])),
+        ));
+        let req = axum::http::Request::builder()
+            .method("POST")
+            .header(header::CONTENT_TYPE, JSON_CONTENT_TYPE)
+            .body(body)
+            .unwrap();
+        let err = limit_body(req, BodyClass::Json, 250).await.unwrap_err().1;
+        assert_eq!(err, BodyRejection::TooLarge { limit: 250 });
+        assert_eq!(err.status(), StatusCode::PAYLOAD_TOO_LARGE);
+    }
+
+    #[tokio::test]
+    async fn declared_length_over_limit_is_refused_up_front() {
+        let req = axum::http::Request::builder()
+            .method("POST")
+            .header(header::CONTENT_LENGTH, "1000000")
+            .body(Body::empty())
+            .unwrap();
+        let err = limit_body(req, BodyClass::Form, FORM_BODY_LIMIT).await;
+        assert_eq!(
+            err.unwrap_err().1,
+            BodyRejection::TooLarge {
+                limit: FORM_BODY_LIMIT
+            }
+        );
+    }
+
+    #[tokio::test]
+    async fn media_types_per_class() {
+        let ok = |ct, class| async move { limit_body(post(ct, "{}"), class, 100).await.is_ok() };
+        assert!(ok(Some("application/json; charset=utf-8"), BodyClass::Json).await);
+        assert!(ok(Some("application/merge-patch+json"), BodyClass::Upload).await);
+        assert!(ok(None, BodyClass::Message).await);
+        assert!(!ok(Some("text/plain"), BodyClass::Json).await);
+        assert!(!ok(Some("multipart/form-data; boundary=x"), BodyClass::Form).await);
+        assert!(!ok(None, BodyClass::Form).await);
+        assert!(ok(Some(FORM_CONTENT_TYPE), BodyClass::Form).await);
+        assert!(ok(Some("image/png"), BodyClass::Unlisted).await);
+        assert!(ok(Some("application/sieve"), BodyClass::Sieve).await);
+        assert!(ok(Some("text/plain; charset=utf-8"), BodyClass::Sieve).await);
+        assert!(!ok(Some(JSON_CONTENT_TYPE), BodyClass::Sieve).await);
+    }
+
+    #[tokio::test]
+    async fn empty_body_needs_no_content_type() {
+        let req = axum::http::Request::builder()
+            .method("POST")
+            .header(header::CONTENT_TYPE, "text/plain")
+            .body(Body::empty())
+            .unwrap();
+        assert!(limit_body(req, BodyClass::Json, 100).await.is_ok());
+    }
+
+    #[test]
+    fn forms_get_html_unless_json_is_asked_for() {
+        let mut headers = HeaderMap::new();
+        assert!(wants_html(&headers, BodyClass::Form));
+        assert!(!wants_html(&headers, BodyClass::Json));
+        headers.insert(header::ACCEPT, "application/json".parse().unwrap());
+        assert!(!wants_html(&headers, BodyClass::Form));
+        headers.insert(header::ACCEPT, "text/html,*/*;q=0.8".parse().unwrap());
+        assert!(wants_html(&headers, BodyClass::Json));
+    }
+}
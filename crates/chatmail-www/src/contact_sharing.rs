@@ -25,28 +25,6 @@ use chatmail_types::Result;
 use sqlx::SqlitePool;
 use tokio::sync::OnceCell;
 
-/// Slugs that must not be used for contact pages (Madmail Go reserved list).
-pub fn is_reserved_slug(slug: &str) -> bool {
-    matches!(
-        slug,
-        "share"
-            | "qr"
-            | "new"
-            | "madmail"
-            | "mxdeliv"
-            | "main.css"
-            | "index.html"
-            | "info.html"
-            | "security.html"
-            | "deploy.html"
-            | "app"
-            | "docs"
-            | "webimap"
-            | "websmtp"
-            | "inv"
-    )
-}
-
 pub struct SharingStore {
     pool: OnceCell<SqlitePool>,
     db_path: std::path::PathBuf,
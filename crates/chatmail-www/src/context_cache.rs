@@ -42,6 +42,7 @@ pub const WWW_SETTINGS_KEYS: &[&str] = &[
     settings_keys::HTTP_PORT,
     settings_keys::HTTPS_PORT,
     settings_keys::SS_PASSWORD,
+    settings_keys::SS_PASSWORD_SLOTS,
     settings_keys::SS_CIPHER,
     settings_keys::SS_PORT,
     settings_keys::SS_ENABLED,
@@ -0,0 +1,253 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `POST /device-code` and `POST /device-redeem`: move an account to a new device without
+//! a backup file. The signed-in device asks for a short one-time code; the new device trades
+//! it for the address plus its own app password.
+
+use std::collections::HashMap;
+use std::net::{IpAddr, SocketAddr};
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+
+use axum::extract::{ConnectInfo, State};
+use axum::http::{header, HeaderMap, HeaderValue, StatusCode};
+use axum::response::Response;
+use axum::{Extension, Json};
+use chatmail_auth::{issue_device_code, redeem_device_code, DEVICE_CODE_TTL_SECS};
+use chatmail_config::DEFAULT_GENERATED_CHARSET;
+use chatmail_db::{record_account_audit, AUDIT_DEVICE_REDEEMED};
+use serde::Deserialize;
+use serde_json::json;
+
+use crate::handlers::{client_ip, webimap_authenticate};
+use crate::response::{json_err, json_ok};
+use crate::WwwState;
+
+const WINDOW: Duration = Duration::from_secs(600);
+/// Codes one account may mint per [`WINDOW`].
+const MAX_CODES_PER_ACCOUNT: usize = 5;
+/// Issue + redeem attempts one client IP may make per [`WINDOW`].
+const MAX_ATTEMPTS_PER_IP: usize = 20;
+/// `POST /recover` attempts against one address per [`WINDOW`], whoever makes them.
+const MAX_RECOVERIES_PER_ACCOUNT: usize = 5;
+/// Wrong passwords against one address on `/account/*` per [`WINDOW`].
+const MAX_AUTH_FAILURES_PER_ACCOUNT: usize = 5;
+/// Wrong passwords one client IP may send to `/account/*` per [`WINDOW`].
+const MAX_AUTH_FAILURES_PER_IP: usize = 10;
+
+/// Sliding-window counters keyed by account or client IP (in memory only).
+pub struct DeviceRateLimiter {
+    hits: Mutex<HashMap<String, Vec<Instant>>>,
+}
+
+impl Default for DeviceRateLimiter {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl DeviceRateLimiter {
+    pub fn new() -> Self {
+        Self {
+            hits: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Run `f` on the hits of `key` that still fall inside the window.
+    fn with_hits<R>(&self, key: &str, f: impl FnOnce(&mut Vec<Instant>) -> R) -> R {
+        let mut map = self.hits.lock().expect("device limiter lock");
+        let cutoff = Instant::now() - WINDOW;
+        map.retain(|_, hits| hits.last().is_some_and(|t| *t > cutoff));
+        let hits = map.entry(key.to_string()).or_default();
+        hits.retain(|t| *t > cutoff);
+        f(hits)
+    }
+
+    /// Record one hit for `key`; false once `max` hits already fall inside the window.
+    fn allow(&self, key: &str, max: usize) -> bool {
+        self.with_hits(key, |hits| {
+            if hits.len() >= max {
+                return false;
+            }
+            hits.push(Instant::now());
+            true
+        })
+    }
+
+    pub(crate) fn allow_ip(&self, ip: Option<IpAddr>) -> bool {
+        match ip {
+            Some(ip) => self.allow(&format!("ip:{ip}"), MAX_ATTEMPTS_PER_IP),
+            None => true,
+        }
+    }
+
+    fn allow_account(&self, user: &str) -> bool {
+        self.allow(&format!("user:{user}"), MAX_CODES_PER_ACCOUNT)
+    }
+
+    pub(crate) fn allow_recovery(&self, user: &str) -> bool {
+        self.allow(&format!("recover:{user}"), MAX_RECOVERIES_PER_ACCOUNT)
+    }
+
+    /// True while `ip` or `user` has used up its `/account/*` wrong-password budget.
+    /// Only failures count, so the owner is never slowed down by their own logins.
+    pub(crate) fn owner_auth_blocked(&self, ip: Option<IpAddr>, user: Option<&str>) -> bool {
+        let full = |key: String, max: usize| self.with_hits(&key, |hits| hits.len() >= max);
+        ip.is_some_and(|ip| full(format!("authfail-ip:{ip}"), MAX_AUTH_FAILURES_PER_IP))
+            || user.is_some_and(|u| full(format!("authfail:{u}"), MAX_AUTH_FAILURES_PER_ACCOUNT))
+    }
+
+    /// Count one wrong password on `/account/*` against `ip` and `user`.
+    pub(crate) fn note_owner_auth_failure(&self, ip: Option<IpAddr>, user: Option<&str>) {
+        let keys = [
+            ip.map(|ip| format!("authfail-ip:{ip}")),
+            user.map(|u| format!("authfail:{u}")),
+        ];
+        for key in keys.into_iter().flatten() {
+            self.with_hits(&key, |hits| hits.push(Instant::now()));
+        }
+    }
+}
+
+fn no_store(mut resp: Response) -> Response {
+    resp.headers_mut()
+        .insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    resp
+}
+
+fn peer_addr(peer: Option<Extension<ConnectInfo<SocketAddr>>>) -> Option<SocketAddr> {
+    peer.map(|Extension(ConnectInfo(addr))| addr)
+}
+
+/// `POST /device-code` — authenticated with `X-Email` / `X-Password` (the primary password).
+pub async fn device_code(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let ip = client_ip(&st, &headers, peer_addr(peer));
+    if !st.device_limits.allow_ip(ip) {
+        return json_err(StatusCode::TOO_MANY_REQUESTS, "too many requests", &cors);
+    }
+    let user = match webimap_authenticate(&st.app, &st.pool, &headers, &cors).await {
+        Ok(u) => u,
+        Err(resp) => return resp,
+    };
+    if !st.device_limits.allow_account(&user) {
+        return json_err(
+            StatusCode::TOO_MANY_REQUESTS,
+            "too many device codes for this account",
+            &cors,
+        );
+    }
+    match issue_device_code(&st.pool, &user).await {
+        Ok(code) => {
+            tracing::info!(%user, ip = ?ip, "device code issued");
+            no_store(json_ok(
+                StatusCode::OK,
+                &json!({ "code": code, "expires_in": DEVICE_CODE_TTL_SECS }),
+                &cors,
+            ))
+        }
+        Err(e) => json_err(StatusCode::INTERNAL_SERVER_ERROR, &e.to_string(), &cors),
+    }
+}
+
+#[derive(Debug, Default, Deserialize)]
+pub struct DeviceRedeemRequest {
+    #[serde(default)]
+    pub code: String,
+}
+
+/// `POST /device-redeem` — `{"code": "ABCD-EFGH"}` → `{"email", "password"}` (new app password).
+pub async fn device_redeem(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    body: Result<Json<DeviceRedeemRequest>, axum::extract::rejection::JsonRejection>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let ip = client_ip(&st, &headers, peer_addr(peer));
+    if !st.device_limits.allow_ip(ip) {
+        return json_err(StatusCode::TOO_MANY_REQUESTS, "too many requests", &cors);
+    }
+    let req = body.map(|Json(req)| req).unwrap_or_default();
+    let password_len = st.config.credential_policy().generated_password_length();
+    let charset = st
+        .config
+        .generated_password_charset(DEFAULT_GENERATED_CHARSET);
+    match redeem_device_code(&st.pool, &req.code, &charset, password_len).await {
+        Ok(Some((user, password))) => {
+            let actor = ip.map(|ip| ip.to_string()).unwrap_or_default();
+            if let Err(e) =
+                record_account_audit(&st.pool, AUDIT_DEVICE_REDEEMED, &user, &actor).await
+            {
+                tracing::warn!(%user, error = %e, "device code redemption: audit write failed");
+            }
+            tracing::info!(%user, ip = ?ip, "device code redeemed");
+            no_store(json_ok(
+                StatusCode::OK,
+                &json!({ "email": user, "password": password }),
+                &cors,
+            ))
+        }
+        Ok(None) => {
+            tracing::warn!(ip = ?ip, "device code redemption rejected");
+            json_err(
+                StatusCode::BAD_REQUEST,
+                "invalid or expired device code",
+                &cors,
+            )
+        }
+        Err(e) => json_err(StatusCode::INTERNAL_SERVER_ERROR, &e.to_string(), &cors),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn limiter_caps_each_key_separately() {
+        let limits = DeviceRateLimiter::new();
+        for _ in 0..MAX_CODES_PER_ACCOUNT {
+            assert!(limits.allow_account("a@x.org"));
+        }
+        assert!(!limits.allow_account("a@x.org"));
+        assert!(limits.allow_account("b@x.org"));
+        assert!(limits.allow_ip(None));
+    }
+
+    #[test]
+    fn owner_auth_counts_failures_only() {
+        let limits = DeviceRateLimiter::new();
+        let ip: Option<IpAddr> = Some("192.0.2.7".parse().unwrap());
+        assert!(!limits.owner_auth_blocked(ip, Some("a@x.org")));
+        for _ in 0..MAX_AUTH_FAILURES_PER_ACCOUNT {
+            limits.note_owner_auth_failure(ip, Some("a@x.org"));
+        }
+        assert!(limits.owner_auth_blocked(None, Some("a@x.org")));
+        assert!(!limits.owner_auth_blocked(ip, Some("b@x.org")));
+        for _ in MAX_AUTH_FAILURES_PER_ACCOUNT..MAX_AUTH_FAILURES_PER_IP {
+            limits.note_owner_auth_failure(ip, None);
+        }
+        assert!(limits.owner_auth_blocked(ip, Some("b@x.org")));
+        assert!(!limits.owner_auth_blocked(None, None));
+    }
+}
@@ -15,33 +15,40 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::net::{IpAddr, SocketAddr};
 use std::sync::Arc;
 
 use axum::body::Body;
-use axum::extract::{Query, State};
+use axum::extract::{ConnectInfo, Query, State};
 use axum::http::{header, HeaderMap, HeaderValue, Method, StatusCode};
 use axum::response::{Html, IntoResponse, Redirect, Response};
-use axum::Json;
+use axum::{Extension, Json};
+use base64::Engine;
 use chatmail_auth::{
-    hash_password, normalize_username, schedule_hash_upgrade_if_needed, verify_password,
+    hash_password, issue_recovery_codes, normalize_username, random_string,
+    schedule_hash_upgrade_if_needed, verify_password,
 };
-use chatmail_config::{build_dclogin_link, DcloginMailSettings};
+use chatmail_config::{build_dclogin_link, DcloginMailSettings, DEFAULT_GENERATED_CHARSET};
 use chatmail_db::{
-    create_sharing_contact, get_bool_setting, get_sharing_contact, normalize_sharing_url,
-    passwords, registration_tokens, settings_keys, sharing_slug_exists, validate_slug,
+    create_sharing_invite, get_bool_setting, get_live_sharing_contact, is_reserved_slug,
+    list_sharing_contacts_for, parse_invite_url, parse_sharing_ttl, passwords, registration_tokens,
+    settings_keys, sharing_slug_exists, validate_group_fields, validate_slug, GroupInviteFields,
+    InviteKind, TokenRejection,
 };
-use chatmail_delivery::DeliveryContext;
+use chatmail_delivery::{DeliveryContext, MailOptions};
 use chatmail_pgp::{enforce_encryption, EnforceOptions};
 use chatmail_smtp::protocol::validate_submission_headers;
+use chatmail_state::{PowError, TlsFingerprint, SENDING_RATE_LIMITED};
 use chatmail_types::{ChatmailError, MESSAGE_FILE_TOO_BIG};
 use rand::Rng;
 use serde::Deserialize;
 use serde_json::json;
 
+use crate::app_links::{AppLinks, Platform};
 use crate::assets::www_html_exists;
-use crate::contact_sharing::is_reserved_slug;
 use crate::gate::{is_websmtp_enabled, service_disabled};
-use crate::template::{build_context, CustomFields};
+use crate::idempotency;
+use crate::template::{build_context, CustomFields, SharedLink};
 use crate::WwwState;
 
 #[derive(Deserialize)]
@@ -49,6 +56,12 @@ pub struct ShareForm {
     pub url: Option<String>,
     pub name: Option<String>,
     pub slug: Option<String>,
+    /// Group invites only: free-form member count shown on the page.
+    pub member_note: Option<String>,
+    /// Group invites only: group description shown on the page.
+    pub description: Option<String>,
+    /// Link lifetime (`24h`, `7d`, …); empty or absent keeps the link forever.
+    pub expires: Option<String>,
 }
 
 pub async fn index(State(st): State<WwwState>, headers: HeaderMap) -> impl IntoResponse {
@@ -166,14 +179,25 @@ pub async fn share_post(
         );
     }
 
-    let url = match normalize_sharing_url(raw_url) {
-        Ok(u) => u,
+    let invite = match parse_invite_url(raw_url) {
+        Ok(invite) => invite,
         Err(e) => return plain_error(StatusCode::BAD_REQUEST, &e.to_string()),
     };
-    if url.contains(' ') {
-        return plain_error(StatusCode::BAD_REQUEST, "Invalid Delta Chat invite URL.");
+    let url = invite.to_openpgp4fpr();
+    let kind = invite.kind();
+    let group = GroupInviteFields {
+        member_note: form.member_note.as_deref().unwrap_or("").trim().to_string(),
+        description: form.description.as_deref().unwrap_or("").trim().to_string(),
+    };
+    if let Err(e) = validate_group_fields(kind, &group) {
+        return plain_error(StatusCode::BAD_REQUEST, &e.to_string());
     }
 
+    let ttl = match parse_sharing_ttl(form.expires.as_deref().unwrap_or("")) {
+        Ok(ttl) => ttl,
+        Err(e) => return plain_error(StatusCode::BAD_REQUEST, &e.to_string()),
+    };
+
     let name = form.name.as_deref().unwrap_or("").trim().to_string();
     let slug = match form
         .slug
@@ -192,7 +216,7 @@ pub async fn share_post(
             if let Err(e) = validate_slug(s) {
                 return plain_error(StatusCode::BAD_REQUEST, &e.to_string());
             }
-            if is_reserved_slug(s) {
+            if is_reserved_slug(s) || st.app.policies.is_reserved_slug(s) {
                 return plain_error(StatusCode::BAD_REQUEST, "This path name is reserved.");
             }
             s.to_string()
@@ -214,7 +238,7 @@ pub async fn share_post(
         return plain_error(StatusCode::BAD_REQUEST, "This path name is already taken.");
     }
 
-    if let Err(e) = create_sharing_contact(pool, &slug, &url, &name).await {
+    if let Err(e) = create_sharing_invite(pool, &slug, &url, &name, &group, ttl).await {
         tracing::error!(error = %e, slug = %slug, "failed to store contact share");
         return plain_error(
             StatusCode::INTERNAL_SERVER_ERROR,
@@ -226,6 +250,12 @@ pub async fn share_post(
         Slug: slug,
         URL: url,
         Name: name,
+        Kind: kind.as_str().to_string(),
+        MemberNote: group.member_note,
+        Description: group.description,
+        App: None,
+        Links: Vec::new(),
+        Analytics: false,
     };
     render_template(
         &st,
@@ -236,6 +266,70 @@ pub async fn share_post(
     .await
 }
 
+/// GET `/share/links` (Basic auth): the account's share links, with view counters
+/// unless `sharing_analytics off`. A link belongs to the address in its invite (`a=`).
+pub async fn share_links(State(st): State<WwwState>, headers: HeaderMap) -> Response {
+    let Some(sharing) = st.sharing.as_ref() else {
+        return StatusCode::NOT_FOUND.into_response();
+    };
+    let cors = st.cors_snap(&headers).await;
+    let user = match basic_authenticate(&st, &headers, &cors, "share").await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let contacts = match sharing.pool().await {
+        Ok(pool) => list_sharing_contacts_for(pool, &user).await,
+        Err(e) => Err(e),
+    };
+    let contacts = match contacts {
+        Ok(c) => c,
+        Err(e) => {
+            tracing::error!(error = %e, "contact sharing DB unavailable");
+            return StatusCode::INTERNAL_SERVER_ERROR.into_response();
+        }
+    };
+    let analytics = st.app.share_views.enabled();
+    let links = contacts
+        .into_iter()
+        .map(|c| SharedLink {
+            Slug: c.slug,
+            Name: c.name,
+            Kind: c.kind,
+            CreatedAt: c.created_at,
+            ExpiresAt: c.expires_at.unwrap_or_default(),
+            Views: if analytics { c.views } else { 0 },
+            LastViewed: if analytics {
+                c.last_viewed
+            } else {
+                String::new()
+            },
+        })
+        .collect();
+    let custom = CustomFields {
+        Slug: String::new(),
+        URL: String::new(),
+        Name: user,
+        Kind: String::new(),
+        MemberNote: String::new(),
+        Description: String::new(),
+        App: None,
+        Links: links,
+        Analytics: analytics,
+    };
+    let mut resp = render_template(
+        &st,
+        "contact_links.html",
+        Some(custom),
+        client_host(&headers),
+    )
+    .await;
+    resp.headers_mut().insert(
+        header::CACHE_CONTROL,
+        HeaderValue::from_static("private, no-store"),
+    );
+    resp
+}
+
 pub async fn app_page(State(st): State<WwwState>, headers: HeaderMap) -> impl IntoResponse {
     render_template(&st, "app.html", None, client_host(&headers)).await
 }
@@ -246,14 +340,28 @@ pub async fn invite_page(State(st): State<WwwState>, headers: HeaderMap) -> impl
 
 #[derive(Deserialize, Default)]
 pub struct NewAccountRequest {
-    #[serde(default)]
+    #[serde(default, alias = "voucher")]
     pub token: String,
+    /// Body alternative to the `Idempotency-Key` header.
+    #[serde(default)]
+    pub idempotency_key: Option<String>,
+    /// `nonce` of a `GET /new` challenge (`proof_of_work_bits`).
+    #[serde(default)]
+    pub challenge_nonce: String,
+    /// Hex counter whose SHA-256 with the nonce meets the challenge's difficulty.
+    #[serde(default)]
+    pub solution: String,
+    /// One of the configured mail domains; empty means the first (default) one.
+    #[serde(default)]
+    pub domain: String,
 }
 
 #[derive(Deserialize, Default)]
 pub struct NewAccountQuery {
-    #[serde(default)]
+    #[serde(default, alias = "voucher")]
     pub token: String,
+    #[serde(default)]
+    pub domain: String,
 }
 
 pub async fn new_account_options(
@@ -264,82 +372,272 @@ pub async fn new_account_options(
     crate::response::options_preflight(&cors)
 }
 
+/// `GET /new`: a hashcash challenge to solve before `POST /new`; 404 when
+/// `proof_of_work_bits` is off.
+pub async fn new_account_challenge(State(st): State<WwwState>, headers: HeaderMap) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let pow = &st.app.pow_challenges;
+    let (status, value) = if !pow.is_enabled() {
+        (StatusCode::NOT_FOUND, json!({"error": "Not found"}))
+    } else {
+        match pow.issue() {
+            Some(c) => (
+                StatusCode::OK,
+                json!({"nonce": c.nonce, "bits": c.bits, "expires": c.expires}),
+            ),
+            None => (
+                StatusCode::SERVICE_UNAVAILABLE,
+                json!({"error": "Too many outstanding challenges, try again later"}),
+            ),
+        }
+    };
+    let mut resp = cors_json(status, value, &cors);
+    resp.headers_mut()
+        .insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    resp
+}
+
 pub async fn new_account(
     State(st): State<WwwState>,
     headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+    tls: Option<Extension<TlsFingerprint>>,
     Query(query): Query<NewAccountQuery>,
     body: Result<Json<NewAccountRequest>, axum::extract::rejection::JsonRejection>,
-) -> impl IntoResponse {
+) -> Response {
     let cors = st.cors_snap(&headers).await;
+    let req = body.map(|Json(req)| req).unwrap_or_default();
     let mut registration_token = query.token;
     if registration_token.is_empty() {
-        if let Ok(Json(req)) = body {
-            registration_token = req.token;
-        }
+        registration_token = req.token;
     }
-    registration_token = registration_token.trim().to_string();
+    let registration_token = registration_token.trim().to_string();
+    let domain = if query.domain.trim().is_empty() {
+        req.domain
+    } else {
+        query.domain
+    };
 
-    if !registration_token.is_empty() {
-        if let Err(e) =
-            registration_tokens::validate_registration_token(&st.pool, &registration_token).await
+    // Retries with the same key (per client IP) replay the first success verbatim.
+    let key = headers
+        .get(idempotency::HEADER)
+        .and_then(|v| v.to_str().ok())
+        .or(req.idempotency_key.as_deref())
+        .and_then(idempotency::parse_key);
+    let peer = peer.map(|Extension(ConnectInfo(addr))| addr);
+    let ip = client_ip(&st, &headers, peer);
+    // Behind a trusted proxy the ClientHello is the proxy's, not the client's.
+    let ja4 = tls
+        .map(|Extension(TlsFingerprint(fp))| fp)
+        .filter(|_| !peer.is_some_and(|p| st.app.trusted_proxies.is_trusted(p.ip())));
+    let origin = RegistrationOrigin {
+        ip,
+        ja4: ja4.as_deref(),
+    };
+    // A replayed success costs no token; anything that may create an account does.
+    let replay = key
+        .as_deref()
+        .is_some_and(|k| st.idempotency.get(ip, k).is_some());
+    if !replay {
+        if let Err(e) = st
+            .app
+            .pow_challenges
+            .verify(&req.challenge_nonce, &req.solution)
         {
-            return cors_json(
-                StatusCode::FORBIDDEN,
-                json!({"error": format!("Invalid registration token: {e}")}),
+            let status = match e {
+                PowError::Expired => StatusCode::REQUEST_TIMEOUT,
+                _ => StatusCode::FORBIDDEN,
+            };
+            chatmail_metrics::record_registration("rejected");
+            return cors_json(status, json!({"error": e.message()}), &cors);
+        }
+    }
+    if let (false, Some(ip)) = (replay, ip.filter(|ip| !st.app.bans.is_exempt(*ip))) {
+        if let Err(wait) = st.app.registration_limiter.check(ip) {
+            tracing::info!(client_ip = %ip, "registration rate limited");
+            chatmail_metrics::record_registration("rejected");
+            let mut resp = cors_json(
+                StatusCode::TOO_MANY_REQUESTS,
+                json!({"error": "Too many registrations, try again later"}),
                 &cors,
             );
+            let secs = wait.as_secs_f64().ceil().max(1.0) as u64;
+            resp.headers_mut()
+                .insert(header::RETRY_AFTER, HeaderValue::from(secs));
+            return resp;
+        }
+    }
+    let (status, value) = match key {
+        Some(key) => {
+            let flight = st.idempotency.flight(ip, &key);
+            let _guard = flight.lock().await;
+            match st.idempotency.get(ip, &key) {
+                Some(stored) => (StatusCode::OK, stored),
+                None => {
+                    let (status, value) =
+                        register_account(&st, &headers, origin, &registration_token, &domain).await;
+                    if status == StatusCode::OK {
+                        st.idempotency.put(ip, &key, value.clone());
+                    }
+                    (status, value)
+                }
+            }
+        }
+        None => register_account(&st, &headers, origin, &registration_token, &domain).await,
+    };
+    chatmail_metrics::record_registration(registration_result(status, &value));
+
+    let mut resp = cors_json(status, value, &cors);
+    // Credentials in the body: never let a proxy or browser cache keep them.
+    resp.headers_mut()
+        .insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    resp
+}
+
+/// `result` label of `chatmail_registrations_total` for a finished `/new`.
+fn registration_result(status: StatusCode, body: &serde_json::Value) -> &'static str {
+    if status.is_success() {
+        "ok"
+    } else if body["reason"] == "registration_closed" {
+        "closed"
+    } else if status.is_server_error() {
+        "error"
+    } else {
+        "rejected"
+    }
+}
+
+/// `403` for an unusable registration token; `reason` is the stable machine-readable part.
+fn token_refused(rejection: TokenRejection) -> (StatusCode, serde_json::Value) {
+    let e = rejection.into_error();
+    (
+        StatusCode::FORBIDDEN,
+        json!({
+            "error": format!("Invalid registration token: {e}"),
+            "reason": rejection.reason(),
+        }),
+    )
+}
+
+/// Where a `/new` request came from: resolved client IP and TLS fingerprint.
+#[derive(Debug, Clone, Copy)]
+struct RegistrationOrigin<'a> {
+    ip: Option<IpAddr>,
+    ja4: Option<&'a str>,
+}
+
+/// Domain a `/new` account is created under: `requested` when it is one of the configured
+/// mail domains, the default (first) one when `requested` is empty.
+fn registration_domain(st: &WwwState, headers: &HeaderMap, requested: &str) -> Option<String> {
+    let domains = st.config.mail_domains(client_host(headers));
+    let requested = requested.trim();
+    if requested.is_empty() {
+        return domains.into_iter().next();
+    }
+    let requested = chatmail_types::wrap_ip_domain(requested);
+    domains
+        .into_iter()
+        .find(|d| d.eq_ignore_ascii_case(&requested))
+}
+
+async fn register_account(
+    st: &WwwState,
+    headers: &HeaderMap,
+    origin: RegistrationOrigin<'_>,
+    registration_token: &str,
+    requested_domain: &str,
+) -> (StatusCode, serde_json::Value) {
+    let RegistrationOrigin { ip, ja4 } = origin;
+    let Some(domain) = registration_domain(st, headers, requested_domain) else {
+        return (
+            StatusCode::BAD_REQUEST,
+            json!({"error": "Unknown domain", "reason": "unknown_domain"}),
+        );
+    };
+    if !registration_token.is_empty() {
+        match registration_tokens::check_registration_token(&st.pool, registration_token).await {
+            Ok(None) => {}
+            Ok(Some(rejection)) => return token_refused(rejection),
+            Err(e) => {
+                return (
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    json!({"error": e.to_string()}),
+                );
+            }
         }
     } else if get_bool_setting(&st.pool, settings_keys::REGISTRATION_TOKEN_REQUIRED, false)
         .await
         .unwrap_or(false)
     {
-        return cors_json(
+        return (
             StatusCode::FORBIDDEN,
-            json!({"error": "Registration token is required"}),
-            &cors,
+            json!({"error": "Registration token is required", "reason": "token_required"}),
         );
     } else if !get_bool_setting(&st.pool, settings_keys::REGISTRATION_OPEN, true)
         .await
         .unwrap_or(false)
     {
-        return cors_json(
+        return (
             StatusCode::FORBIDDEN,
-            json!({"error": "Registration is closed"}),
-            &cors,
+            json!({"error": "Registration is closed", "reason": "registration_closed"}),
         );
     }
+    match st.app.check_registration_capacity(&st.pool).await {
+        Ok(()) => {}
+        Err(ChatmailError::ServerFull(reason)) => {
+            tracing::warn!(%reason, "registration refused");
+            return (
+                StatusCode::SERVICE_UNAVAILABLE,
+                json!({"error": "Server is full"}),
+            );
+        }
+        Err(e) => {
+            return (
+                StatusCode::INTERNAL_SERVER_ERROR,
+                json!({"error": e.to_string()}),
+            );
+        }
+    }
 
     const MAX_ATTEMPTS: u32 = 5;
-    let domain = st
-        .config
-        .effective_registration_domain(client_host(&headers));
     for _ in 0..MAX_ATTEMPTS {
         let policy = st.config.credential_policy();
-        let user = match normalize_username(&format!(
-            "{}@{}",
-            random_alnum(policy.generated_username_length()),
-            domain
-        )) {
+        let generated = random_string(
+            &st.config.generated_username_charset(),
+            policy.generated_username_length(),
+        )
+        .and_then(|local| normalize_username(&format!("{local}@{domain}")));
+        let user = match generated {
             Ok(u) => u,
             Err(e) => {
-                return cors_json(
+                return (
                     StatusCode::INTERNAL_SERVER_ERROR,
                     json!({"error": e.to_string()}),
-                    &cors,
                 );
             }
         };
-        if st.app.auth.is_blocked(&user) {
+        if st.app.auth.is_blocked(&user) || st.app.policies.is_reserved_name(&user) {
             continue;
         }
-        let password = random_alnum(policy.generated_password_length());
+        let password = match random_string(
+            &st.config
+                .generated_password_charset(DEFAULT_GENERATED_CHARSET),
+            policy.generated_password_length(),
+        ) {
+            Ok(p) => p,
+            Err(e) => {
+                return (
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    json!({"error": e.to_string()}),
+                );
+            }
+        };
         let hash = match hash_password(&password) {
             Ok(h) => h,
             Err(e) => {
-                return cors_json(
+                return (
                     StatusCode::INTERNAL_SERVER_ERROR,
                     json!({"error": e.to_string()}),
-                    &cors,
                 );
             }
         };
@@ -351,55 +649,77 @@ pub async fn new_account(
         }
         if st.app.mailbox_store.init_user_dir(&user).await.is_err() {
             let _ = passwords::delete_user(&st.pool, &user).await;
-            return cors_json(
+            return (
                 StatusCode::INTERNAL_SERVER_ERROR,
                 json!({"error": "failed to init mailbox"}),
-                &cors,
             );
         }
         if let Err(e) = registration_tokens::ensure_new_account_quota(&st.pool, &user).await {
             let _ = passwords::delete_user(&st.pool, &user).await;
-            return cors_json(
+            return (
                 StatusCode::INTERNAL_SERVER_ERROR,
                 json!({"error": e.to_string()}),
-                &cors,
             );
         }
         if !registration_token.is_empty() {
-            if let Err(e) =
-                registration_tokens::attach_registration_token(&st.pool, &user, &registration_token)
-                    .await
-            {
+            let reserved = registration_tokens::reserve_registration_token(
+                &st.pool,
+                &user,
+                registration_token,
+            )
+            .await;
+            if !matches!(reserved, Ok(true)) {
                 let _ = passwords::delete_user(&st.pool, &user).await;
                 let _ = chatmail_db::db_execute!(
                     &st.pool,
                     "DELETE FROM quotas WHERE username = ?",
                     user
                 );
-                return cors_json(
-                    StatusCode::INTERNAL_SERVER_ERROR,
-                    json!({"error": e.to_string()}),
-                    &cors,
-                );
+                return match reserved {
+                    // Another sign-up took the last slot between the check and now.
+                    Ok(_) => token_refused(TokenRejection::Exhausted),
+                    Err(e) => (
+                        StatusCode::INTERNAL_SERVER_ERROR,
+                        json!({"error": e.to_string()}),
+                    ),
+                };
             }
         }
+        let recovery_codes = match st.config.recovery_codes.filter(|n| *n > 0) {
+            Some(n) => match issue_recovery_codes(&st.pool, &user, n).await {
+                Ok(codes) => Some(codes),
+                Err(e) => {
+                    let _ = passwords::delete_user(&st.pool, &user).await;
+                    let _ = chatmail_db::account_info::delete_quota_row(&st.pool, &user).await;
+                    return (
+                        StatusCode::INTERNAL_SERVER_ERROR,
+                        json!({"error": e.to_string()}),
+                    );
+                }
+            },
+            None => None,
+        };
         st.app.auth.insert(&user, &hash);
-        let mail = dclogin_mail_settings(&st, &headers).await;
+        tracing::info!(%user, client_ip = ?ip, ja4 = ?ja4, "account registered");
+        st.app.publish_account_created(&user, "registration");
+        if let Some(ip) = ip {
+            st.app.note_registration(&st.pool, ip).await;
+        }
+        let mail = dclogin_mail_settings(st, headers).await;
         let dclogin_url = build_dclogin_link(&user, &password, &mail);
-        return cors_json(
-            StatusCode::OK,
-            json!({
-                "email": user,
-                "password": password,
-                "dclogin_url": dclogin_url,
-            }),
-            &cors,
-        );
+        let mut body = json!({
+            "email": user,
+            "password": password,
+            "dclogin_url": dclogin_url,
+        });
+        if let Some(codes) = recovery_codes {
+            body["recovery_codes"] = json!(codes);
+        }
+        return (StatusCode::OK, body);
     }
-    cors_json(
+    (
         StatusCode::INTERNAL_SERVER_ERROR,
         json!({"error": "failed to create account"}),
-        &cors,
     )
 }
 
@@ -464,6 +784,10 @@ pub(crate) fn web_delivery_error(e: &ChatmailError) -> (StatusCode, String) {
             (StatusCode::BAD_REQUEST, m.clone())
         }
         ChatmailError::UserBlocked(u) => (StatusCode::FORBIDDEN, format!("user blocked: {u}")),
+        ChatmailError::RateLimited(m) => (
+            StatusCode::TOO_MANY_REQUESTS,
+            format!("{SENDING_RATE_LIMITED}: {m}"),
+        ),
         ChatmailError::AuthFailed => (
             StatusCode::UNAUTHORIZED,
             "authentication failed".into(),
@@ -486,12 +810,16 @@ pub async fn websmtp_deliver(
     let raw = body.as_bytes();
     st.app.check_message_size(raw.len())?;
     validate_submission_headers(raw, user)?;
+    let limits = &st.app.sending_limits;
+    limits.check_message(&st.pool, user).await?;
+    limits.check_rcpt(user, to.len().saturating_sub(1))?;
 
     enforce_encryption(
         raw,
         &EnforceOptions {
             mail_from: user.to_string(),
             recipients: to.to_vec(),
+            passthrough: st.app.policies.pgp_passthrough(Some(user), to),
         },
     )?;
 
@@ -508,7 +836,13 @@ pub async fn websmtp_deliver(
     };
 
     // Same function path as SMTP submission after AUTH + DATA.
-    delivery.submit_authenticated(user, to, raw).await
+    delivery
+        .submit_authenticated(user, to, raw, MailOptions::default())
+        .await?;
+    if let Err(e) = limits.record(&st.pool, user, raw, to.len()).await {
+        tracing::warn!(user, error = %e, "recording sending stats failed");
+    }
+    Ok(())
 }
 
 pub(crate) async fn webimap_authenticate(
@@ -527,7 +861,18 @@ pub(crate) async fn webimap_authenticate(
         .ok_or_else(|| {
             webimap_error(StatusCode::UNAUTHORIZED, "missing X-Password header", cors)
         })?;
+    password_authenticate(app, pool, email, password, cors).await
+}
 
+/// Check an account password (WebIMAP headers, `/takeout` Basic auth). Returns the
+/// normalized username or the JSON error response to send.
+pub(crate) async fn password_authenticate(
+    app: &chatmail_state::AppState,
+    pool: &chatmail_db::DbPool,
+    email: &str,
+    password: &str,
+    cors: &crate::cors::CorsSnap,
+) -> Result<String, Response> {
     let user = normalize_username(email)
         .map_err(|e| webimap_error(StatusCode::BAD_REQUEST, &e.to_string(), cors))?;
 
@@ -564,6 +909,51 @@ pub(crate) async fn webimap_authenticate(
     Ok(user)
 }
 
+/// `Authorization: Basic` credentials checked against the account password. Every `401`
+/// carries a `WWW-Authenticate` challenge for `realm` so browsers prompt again.
+pub(crate) async fn basic_authenticate(
+    st: &WwwState,
+    headers: &HeaderMap,
+    cors: &crate::cors::CorsSnap,
+    realm: &str,
+) -> Result<String, Response> {
+    let result = match basic_credentials(headers) {
+        Some((email, password)) => {
+            password_authenticate(&st.app, &st.pool, &email, &password, cors).await
+        }
+        None => Err(webimap_error(
+            StatusCode::UNAUTHORIZED,
+            "authentication required",
+            cors,
+        )),
+    };
+    result.map_err(|mut resp| {
+        if resp.status() == StatusCode::UNAUTHORIZED {
+            if let Ok(challenge) = HeaderValue::from_str(&format!("Basic realm=\"{realm}\"")) {
+                resp.headers_mut()
+                    .insert(header::WWW_AUTHENTICATE, challenge);
+            }
+        }
+        resp
+    })
+}
+
+/// `(email, password)` from `Authorization: Basic`, unchecked.
+pub(crate) fn basic_credentials(headers: &HeaderMap) -> Option<(String, String)> {
+    let raw = headers
+        .get(header::AUTHORIZATION)
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.strip_prefix("Basic "))
+        .and_then(|b64| {
+            base64::engine::general_purpose::STANDARD
+                .decode(b64.trim())
+                .ok()
+        })
+        .and_then(|raw| String::from_utf8(raw).ok())?;
+    let (email, password) = raw.split_once(':')?;
+    Some((email.to_string(), password.to_string()))
+}
+
 fn webimap_error(status: StatusCode, message: &str, cors: &crate::cors::CorsSnap) -> Response {
     crate::response::json_err(status, message, cors)
 }
@@ -622,37 +1012,10 @@ pub async fn binary_download(method: Method) -> impl IntoResponse {
     (headers, bytes).into_response()
 }
 
-/// Mozilla ISPDB autoconfig for Delta Chat / Thunderbird (`dcaccount:` fetch).
-pub async fn mail_autoconfig(State(st): State<WwwState>, headers: HeaderMap) -> impl IntoResponse {
-    use axum::http::header;
-    use chatmail_config::{build_autoconfig_xml, AutoconfigParams};
-
-    let mail = dclogin_mail_settings(&st, &headers).await;
-    let snap = st.app.listener_ports.snapshot();
-    let runtime = chatmail_config::RuntimeListeners {
-        imap_plain_addr: snap.imap_plain_addr,
-        imap_tls_addr: snap.imap_tls_addr,
-        submission_plain_addr: snap.submission_plain_addr,
-        submission_tls_addr: snap.submission_tls_addr,
-        smtp_addr: snap.smtp_addr,
-        http_plain_addr: snap.http_plain_addr,
-        http_tls_addr: snap.http_tls_addr,
-    };
-    let params = AutoconfigParams::from_mail_settings(&st.mail_domain, &mail, Some(&runtime));
-    let xml = build_autoconfig_xml(&params);
-
-    Response::builder()
-        .status(StatusCode::OK)
-        .header(header::CONTENT_TYPE, "application/xml; charset=utf-8")
-        .header(header::CACHE_CONTROL, "public, max-age=3600")
-        .body(Body::from(xml))
-        .unwrap()
-        .into_response()
-}
-
 pub async fn catch_all(
     State(st): State<WwwState>,
     headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
     axum::extract::Path(path): axum::extract::Path<String>,
 ) -> impl IntoResponse {
     if path.is_empty() {
@@ -666,15 +1029,20 @@ pub async fn catch_all(
     if let Some(resp) = serve_bytes(&st, &path) {
         return resp.into_response();
     }
-    if let Some(contact) = lookup_shared_contact(&st, &path).await {
-        return render_template(
-            &st,
-            "contact_view.html",
-            Some(contact),
-            client_host(&headers),
-        )
-        .await
-        .into_response();
+    if let Some(contact) = lookup_shared_contact(&st, &path, &headers).await {
+        // Memory only; the state flusher batches the counts into sharing.db.
+        let peer = peer.map(|Extension(ConnectInfo(addr))| addr);
+        st.app
+            .share_views
+            .record(&contact.Slug, client_ip(&st, &headers, peer));
+        let page = if contact.Kind == InviteKind::Group.as_str() {
+            "group_view.html"
+        } else {
+            "contact_view.html"
+        };
+        return render_template(&st, page, Some(contact), client_host(&headers))
+            .await
+            .into_response();
     }
     StatusCode::NOT_FOUND.into_response()
 }
@@ -687,17 +1055,40 @@ fn plain_error(status: StatusCode, message: &str) -> Response {
         .unwrap_or_else(|_| status.into_response())
 }
 
-async fn lookup_shared_contact(st: &WwwState, path: &str) -> Option<CustomFields> {
-    if path.contains('.') || path.contains('/') || is_reserved_slug(path) {
+async fn lookup_shared_contact(
+    st: &WwwState,
+    path: &str,
+    headers: &HeaderMap,
+) -> Option<CustomFields> {
+    if path.contains('.')
+        || path.contains('/')
+        || is_reserved_slug(path)
+        || st.app.policies.is_reserved_slug(path)
+    {
         return None;
     }
     let sharing = st.sharing.as_ref()?;
     let pool = sharing.pool().await.ok()?;
-    let contact = get_sharing_contact(pool, path).await.ok()??;
+    let contact = get_live_sharing_contact(pool, path).await.ok()??;
+    let ua = headers
+        .get(header::USER_AGENT)
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or("");
+    let app = AppLinks::new(
+        &st.config.app_links,
+        &contact.url,
+        Platform::from_user_agent(ua),
+    );
     Some(CustomFields {
         Slug: contact.slug,
         URL: contact.url,
         Name: contact.name,
+        Kind: contact.kind,
+        MemberNote: contact.member_note,
+        Description: contact.description,
+        App: Some(app),
+        Links: Vec::new(),
+        Analytics: false,
     })
 }
 
@@ -737,11 +1128,47 @@ fn static_cache_control(path: &str, live_www_dir: bool) -> Option<&'static str>
     }
 }
 
+/// Refuse every request from a banned client address with `403`.
+pub async fn ban_gate(
+    State(st): State<WwwState>,
+    req: axum::extract::Request,
+    next: axum::middleware::Next,
+) -> Response {
+    let peer = req
+        .extensions()
+        .get::<ConnectInfo<SocketAddr>>()
+        .map(|ConnectInfo(addr)| *addr);
+    if let Some(ip) = client_ip(&st, req.headers(), peer) {
+        if st.app.bans.is_banned(ip) {
+            return (StatusCode::FORBIDDEN, Json(json!({"error": "Forbidden"}))).into_response();
+        }
+    }
+    next.run(req).await
+}
+
+/// Real client address: the TCP peer, or the forwarded one when the peer is a
+/// `trusted_cdn` proxy.
+pub(crate) fn client_ip(
+    st: &WwwState,
+    headers: &HeaderMap,
+    peer: Option<SocketAddr>,
+) -> Option<IpAddr> {
+    let peer = peer?.ip();
+    Some(
+        st.app
+            .trusted_proxies
+            .client_ip(peer, |name| headers.get(name).and_then(|v| v.to_str().ok())),
+    )
+}
+
 fn client_host(headers: &HeaderMap) -> Option<&str> {
     headers.get(header::HOST).and_then(|v| v.to_str().ok())
 }
 
-async fn dclogin_mail_settings(st: &WwwState, headers: &HeaderMap) -> DcloginMailSettings {
+pub(crate) async fn dclogin_mail_settings(
+    st: &WwwState,
+    headers: &HeaderMap,
+) -> DcloginMailSettings {
     let snap = st.app.listener_ports.snapshot();
     let runtime = chatmail_config::RuntimeListeners {
         imap_plain_addr: snap.imap_plain_addr,
@@ -0,0 +1,230 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `Idempotency-Key` replay cache for `POST /new`.
+//!
+//! A retried registration carrying the same key from the same client IP gets the stored
+//! response back instead of a second account. Entries live for [`DEFAULT_TTL`] in memory
+//! only; a restart (or HTTP router rebuild) forgets them, which at worst yields the orphan
+//! account the cache exists to avoid.
+
+use std::collections::HashMap;
+use std::net::IpAddr;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+
+use serde_json::Value;
+
+pub const DEFAULT_TTL: Duration = Duration::from_secs(3600);
+
+/// Caps memory if a client floods distinct keys; applies to stored bodies and to requests in
+/// flight alike.
+const MAX_ENTRIES: usize = 10_000;
+
+/// `Idempotency-Key` request header.
+pub const HEADER: &str = "idempotency-key";
+
+/// Keys are opaque client tokens (normally a UUID): 8–64 chars of `[A-Za-z0-9_-]`.
+/// Anything else is ignored rather than rejected.
+pub fn parse_key(raw: &str) -> Option<String> {
+    let key = raw.trim();
+    let ok = (8..=64).contains(&key.len())
+        && key
+            .bytes()
+            .all(|b| b.is_ascii_alphanumeric() || b == b'-' || b == b'_');
+    ok.then(|| key.to_ascii_lowercase())
+}
+
+type Scope = (Option<IpAddr>, String);
+
+struct Stored {
+    at: Instant,
+    body: Value,
+}
+
+pub struct IdempotencyStore {
+    ttl: Duration,
+    done: Mutex<HashMap<Scope, Stored>>,
+    /// Per-key locks so two concurrent retries do not both create an account.
+    flights: Mutex<HashMap<Scope, Arc<tokio::sync::Mutex<()>>>>,
+}
+
+impl IdempotencyStore {
+    pub fn new() -> Self {
+        Self::with_ttl(DEFAULT_TTL)
+    }
+
+    pub fn with_ttl(ttl: Duration) -> Self {
+        Self {
+            ttl,
+            done: Mutex::new(HashMap::new()),
+            flights: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Lock shared by the requests for `(ip, key)` that run at the same time. The entry is
+    /// dropped with the last [`Flight`] for the key, whatever the outcome. With
+    /// [`MAX_ENTRIES`] keys already in flight the lock is private, so concurrent retries are
+    /// no longer serialized but the map stays bounded.
+    pub fn flight(&self, ip: Option<IpAddr>, key: &str) -> Flight<'_> {
+        let scope = (ip, key.to_string());
+        let mut flights = self.flights.lock().expect("idempotency flights lock");
+        let lock = match flights.get(&scope) {
+            Some(lock) => Arc::clone(lock),
+            None if flights.len() >= MAX_ENTRIES => Arc::default(),
+            None => Arc::clone(flights.entry(scope.clone()).or_default()),
+        };
+        Flight {
+            store: self,
+            scope,
+            lock,
+        }
+    }
+
+    /// Stored success body for `(ip, key)` if still inside the window.
+    pub fn get(&self, ip: Option<IpAddr>, key: &str) -> Option<Value> {
+        let mut done = self.done.lock().expect("idempotency lock");
+        let scope = (ip, key.to_string());
+        match done.get(&scope) {
+            Some(s) if s.at.elapsed() < self.ttl => Some(s.body.clone()),
+            Some(_) => {
+                done.remove(&scope);
+                None
+            }
+            None => None,
+        }
+    }
+
+    pub fn put(&self, ip: Option<IpAddr>, key: &str, body: Value) {
+        let mut done = self.done.lock().expect("idempotency lock");
+        if done.len() >= MAX_ENTRIES {
+            let ttl = self.ttl;
+            done.retain(|_, s| s.at.elapsed() < ttl);
+        }
+        if done.len() >= MAX_ENTRIES {
+            return;
+        }
+        done.insert(
+            (ip, key.to_string()),
+            Stored {
+                at: Instant::now(),
+                body,
+            },
+        );
+    }
+
+    #[cfg(test)]
+    fn flights_len(&self) -> usize {
+        self.flights.lock().expect("idempotency flights lock").len()
+    }
+}
+
+/// One request's share of a per-key lock, from [`IdempotencyStore::flight`].
+pub struct Flight<'a> {
+    store: &'a IdempotencyStore,
+    scope: Scope,
+    lock: Arc<tokio::sync::Mutex<()>>,
+}
+
+impl Flight<'_> {
+    pub async fn lock(&self) -> tokio::sync::MutexGuard<'_, ()> {
+        self.lock.lock().await
+    }
+}
+
+impl Drop for Flight<'_> {
+    fn drop(&mut self) {
+        let mut flights = self.store.flights.lock().expect("idempotency flights lock");
+        // Clones are only taken under this lock: the map's and ours mean no one else waits.
+        let last = flights
+            .get(&self.scope)
+            .is_some_and(|l| Arc::ptr_eq(l, &self.lock) && Arc::strong_count(l) == 2);
+        if last {
+            flights.remove(&self.scope);
+        }
+    }
+}
+
+impl Default for IdempotencyStore {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn parse_key_accepts_uuid_and_ignores_garbage() {
+        assert_eq!(
+            parse_key(" 3F2504E0-4F89-11D3-9A0C-0305E82C3301 ").as_deref(),
+            Some("3f2504e0-4f89-11d3-9a0c-0305e82c3301")
+        );
+        assert!(parse_key("short").is_none());
+        assert!(parse_key("has space in it").is_none());
+        assert!(parse_key(&"x".repeat(65)).is_none());
+    }
+
+    #[test]
+    fn entries_are_scoped_per_ip_and_expire() {
+        let store = IdempotencyStore::with_ttl(Duration::from_millis(30));
+        let a: IpAddr = "192.0.2.1".parse().unwrap();
+        let b: IpAddr = "192.0.2.2".parse().unwrap();
+        store.put(Some(a), "key-000001", json!({"email": "x@y"}));
+        assert_eq!(store.get(Some(a), "key-000001").unwrap()["email"], "x@y");
+        assert!(store.get(Some(b), "key-000001").is_none());
+        std::thread::sleep(Duration::from_millis(40));
+        assert!(store.get(Some(a), "key-000001").is_none());
+    }
+
+    #[tokio::test]
+    async fn flights_are_dropped_on_every_exit() {
+        let store = IdempotencyStore::new();
+        let ip: Option<IpAddr> = Some("192.0.2.1".parse().unwrap());
+        // A failed attempt never calls `put`.
+        {
+            let flight = store.flight(ip, "key-failed");
+            let _guard = flight.lock().await;
+        }
+        assert_eq!(store.flights_len(), 0);
+
+        let first = store.flight(ip, "key-shared");
+        let second = store.flight(ip, "key-shared");
+        let guard = first.lock().await;
+        assert!(second.lock.try_lock().is_err(), "retries share one lock");
+        drop(guard);
+        drop(first);
+        assert_eq!(store.flights_len(), 1, "the waiting retry keeps the entry");
+        drop(second);
+        assert_eq!(store.flights_len(), 0);
+    }
+
+    #[test]
+    fn flights_are_capped() {
+        let store = IdempotencyStore::new();
+        let held: Vec<_> = (0..MAX_ENTRIES)
+            .map(|i| store.flight(None, &format!("key-{i:06}")))
+            .collect();
+        let extra = store.flight(None, "key-overflow");
+        assert_eq!(store.flights_len(), MAX_ENTRIES);
+        drop(extra);
+        drop(held);
+        assert_eq!(store.flights_len(), 0);
+    }
+}
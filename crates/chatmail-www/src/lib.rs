@@ -15,17 +15,31 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+pub mod account;
+pub mod app_links;
 pub mod assets;
+pub mod autoconfig;
+pub mod body_limit;
 mod contact_sharing;
 pub mod context_cache;
 pub mod cors;
+pub mod device;
 pub mod export;
 pub mod gate;
 mod go_template;
 pub mod handlers;
+pub mod idempotency;
+pub mod probe;
+pub mod pwa;
 pub mod response;
 pub mod router;
+pub mod status_page;
+pub mod takeout;
 pub mod template;
+pub mod theme;
+pub mod theme_archive;
+pub mod turn;
+pub mod webfinger;
 pub mod webimap;
 pub mod webimap_ws;
 mod www_facts;
@@ -34,6 +48,10 @@ pub mod www_migrate;
 pub use export::export_www_files;
 pub use go_template::{looks_like_go_template, prepare_template};
 pub use router::{www_router, WwwState};
+pub use theme::{
+    effective_www_dir, validate_theme_dir, ThemeActivation, ThemeIssue, ThemeReport, ThemeStore,
+};
+pub use theme_archive::{extract_theme_archive, MAX_THEME_ARCHIVE_BYTES};
 pub use www_migrate::{
     format_www_template_error, migrate_www_dir, migrate_www_html_file, rewrite_legacy_qr_js,
     scan_literal_brace_warnings, scan_www_dir_for_go_templates, transform_html_source,
@@ -0,0 +1,132 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Probe targets for load balancers and orchestrators: `GET /health` / `GET /healthz`
+//! (liveness) and `GET /ready` / `GET /readyz` (readiness). No authentication; the
+//! `health_checks off` directive answers 404 instead.
+//!
+//! `/cdn-cgi/*` is Cloudflare's reserved prefix; the edge normally answers it, and whatever
+//! reaches us (edge health checks, scanners) gets an empty `204` rather than a 404 page.
+//!
+//! Unlike `/status`, readiness checks run on every request, so they stay cheap and each is
+//! bounded by [`CHECK_TIMEOUT`]: a settings read from the database (a hung SQLite lock must
+//! not hang the probe), a `stat` of the mail root, and the mail listeners' state from the
+//! cached supervisor probe.
+
+use axum::extract::State;
+use axum::http::{header, StatusCode};
+use axum::response::{IntoResponse, Response};
+use axum::Json;
+use chatmail_db::{get_bool_setting, settings_keys};
+use chatmail_state::ComponentState;
+use serde_json::{json, Map, Value};
+use tokio::time::{timeout, Duration};
+
+use crate::WwwState;
+
+const CHECK_TIMEOUT: Duration = Duration::from_secs(2);
+/// Cached components that make the server useless when down.
+const MAIL_LISTENERS: &[&str] = &["smtp", "submission", "imap"];
+
+type CheckResult = Result<(), String>;
+
+/// `GET /health`: 200 for as long as the process serves HTTP.
+pub async fn health(State(st): State<WwwState>) -> Response {
+    if !st.config.health_checks_enabled() {
+        return StatusCode::NOT_FOUND.into_response();
+    }
+    Json(json!({ "status": "ok" })).into_response()
+}
+
+/// `GET /cdn-cgi/*`: empty `204`, never cached. No lookups, so it stays cheap under probes.
+pub async fn cdn_cgi() -> Response {
+    (
+        StatusCode::NO_CONTENT,
+        [(header::CACHE_CONTROL, "no-store")],
+    )
+        .into_response()
+}
+
+/// `GET /ready`: 200 when every check passes, else 503 with the first failure as `reason`
+/// and every check's outcome under `checks`.
+pub async fn ready(State(st): State<WwwState>) -> Response {
+    if !st.config.health_checks_enabled() {
+        return StatusCode::NOT_FOUND.into_response();
+    }
+    let (database, storage) = tokio::join!(check_database(&st), check_storage(&st));
+    let results = [
+        ("database", database),
+        ("storage", storage),
+        ("listeners", check_listeners(&st)),
+    ];
+    let mut checks = Map::new();
+    let mut reason = None;
+    for (name, result) in results {
+        let outcome = match result {
+            Ok(()) => "ok".to_string(),
+            Err(e) => {
+                reason.get_or_insert_with(|| format!("{name}: {e}"));
+                e
+            }
+        };
+        checks.insert(name.into(), Value::String(outcome));
+    }
+    match reason {
+        None => Json(json!({ "status": "ok", "checks": checks })).into_response(),
+        Some(reason) => (
+            StatusCode::SERVICE_UNAVAILABLE,
+            Json(json!({ "status": "degraded", "reason": reason, "checks": checks })),
+        )
+            .into_response(),
+    }
+}
+
+async fn check_database(st: &WwwState) -> CheckResult {
+    let read = get_bool_setting(&st.pool, settings_keys::REGISTRATION_OPEN, false);
+    match timeout(CHECK_TIMEOUT, read).await {
+        Ok(Ok(_)) => Ok(()),
+        Ok(Err(e)) => Err(e.to_string()),
+        Err(_) => Err("timed out".into()),
+    }
+}
+
+async fn check_storage(st: &WwwState) -> CheckResult {
+    let root = st.app.mailbox_store.state_dir();
+    match timeout(CHECK_TIMEOUT, tokio::fs::metadata(root)).await {
+        Ok(Ok(meta)) if meta.is_dir() => Ok(()),
+        Ok(Ok(_)) => Err(format!("{} is not a directory", root.display())),
+        Ok(Err(e)) => Err(e.to_string()),
+        Err(_) => Err("timed out".into()),
+    }
+}
+
+/// Only `down` counts: a listener failing for less than the debounce period is still ready.
+fn check_listeners(st: &WwwState) -> CheckResult {
+    let down: Vec<String> = st
+        .app
+        .health
+        .snapshot()
+        .into_iter()
+        .filter(|c| MAIL_LISTENERS.contains(&c.name.as_str()) && c.state == ComponentState::Down)
+        .map(|c| c.name)
+        .collect();
+    if down.is_empty() {
+        Ok(())
+    } else {
+        Err(format!("{} down", down.join(", ")))
+    }
+}
@@ -0,0 +1,322 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Installable landing page: web app manifest, service worker and offline fallback.
+//!
+//! The service worker precaches the landing page, its CSS/JS and the docs index and
+//! answers them cache-first, refreshing the copy in the background, so a page opened once
+//! keeps working on a flaky or blocked connection. Its cache name carries a fingerprint of
+//! the precached files ([`cache_version`]): when any of them changes, `/sw.js` changes,
+//! the browser installs the new worker and the old cache is dropped.
+//!
+//! Registration (`/new`), the admin API and every other dynamic endpoint stay network-only;
+//! the worker ignores them and all non-GET requests.
+
+use axum::body::Body;
+use axum::extract::State;
+use axum::http::{header, StatusCode};
+use axum::response::{IntoResponse, Response};
+use chatmail_config::AppConfig;
+use serde_json::{json, Value};
+use sha2::{Digest, Sha256};
+
+use crate::router::WwwState;
+
+pub const MANIFEST_PATH: &str = "/manifest.webmanifest";
+pub const SERVICE_WORKER_PATH: &str = "/sw.js";
+pub const OFFLINE_PAGE: &str = "/offline.html";
+
+/// `Cache-Control` of `/sw.js`: browsers must revalidate the worker script on every check.
+pub const SERVICE_WORKER_CACHE_CONTROL: &str = "no-cache, max-age=0, must-revalidate";
+
+/// Manifest colors when `pwa_theme_color` / `pwa_background_color` are unset or invalid
+/// (`--primary` and `--background` of `main.css`).
+pub const DEFAULT_THEME_COLOR: &str = "#171717";
+pub const DEFAULT_BACKGROUND_COLOR: &str = "#ffffff";
+
+/// Precached URL and the www file whose bytes stand for it in the fingerprint.
+pub const PRECACHE: &[(&str, &str)] = &[
+    ("/", "index.html"),
+    ("/docs/", "docs_index.html"),
+    (OFFLINE_PAGE, "offline.html"),
+    ("/main.css", "main.css"),
+    ("/main.js", "main.js"),
+    ("/translations.js", "translations.js"),
+    ("/qrcode.min.js", "qrcode.min.js"),
+    ("/logo.svg", "logo.svg"),
+];
+
+/// Paths (and everything below them) the worker never answers from cache.
+pub const NETWORK_ONLY: &[&str] = &[
+    "/new",
+    "/admin",
+    "/device-code",
+    "/device-redeem",
+    "/webimap",
+    "/websmtp",
+    "/takeout",
+    "/share",
+    "/status",
+    "/status.json",
+    "/madmail",
+    "/app",
+    "/inv",
+    "/.well-known",
+];
+
+/// Prefix of every cache the worker creates; older versions are deleted on activation.
+const CACHE_PREFIX: &str = "madmail-www-";
+
+/// Fingerprint of the precached files (and the server version): 12 hex digits.
+pub fn cache_version<'a>(files: impl IntoIterator<Item = (&'a str, Option<&'a [u8]>)>) -> String {
+    let mut hasher = Sha256::new();
+    hasher.update(env!("CARGO_PKG_VERSION").as_bytes());
+    for (path, bytes) in files {
+        hasher.update(path.as_bytes());
+        let bytes = bytes.unwrap_or_default();
+        hasher.update((bytes.len() as u64).to_le_bytes());
+        hasher.update(bytes);
+    }
+    hasher
+        .finalize()
+        .iter()
+        .take(6)
+        .map(|b| format!("{b:02x}"))
+        .collect()
+}
+
+/// `#rgb` / `#rrggbb`, with or without the `#` (which starts a comment in the config).
+fn css_color(raw: Option<&str>, default: &str) -> String {
+    let Some(hex) = raw.map(|r| r.trim().trim_start_matches('#')) else {
+        return default.to_string();
+    };
+    if matches!(hex.len(), 3 | 6) && hex.bytes().all(|b| b.is_ascii_hexdigit()) {
+        format!("#{}", hex.to_ascii_lowercase())
+    } else {
+        tracing::warn!(color = %hex, "pwa: ignoring invalid color, using default");
+        default.to_string()
+    }
+}
+
+/// The site's name in the manifest: `hostname`, else the registration domain.
+fn web_domain(config: &AppConfig) -> String {
+    config
+        .hostname
+        .clone()
+        .unwrap_or_else(|| config.effective_registration_domain(None))
+        .trim_matches(|c| c == '[' || c == ']')
+        .to_string()
+}
+
+pub fn manifest(config: &AppConfig) -> Value {
+    let name = web_domain(config);
+    json!({
+        "id": "/",
+        "name": name,
+        "short_name": name,
+        "description": "Delta Chat relay: create an account and keep its login link offline.",
+        "start_url": "/",
+        "scope": "/",
+        "display": "standalone",
+        "theme_color": css_color(config.pwa_theme_color.as_deref(), DEFAULT_THEME_COLOR),
+        "background_color": css_color(
+            config.pwa_background_color.as_deref(),
+            DEFAULT_BACKGROUND_COLOR,
+        ),
+        "icons": [{
+            "src": "/logo.svg",
+            "sizes": "any",
+            "type": "image/svg+xml",
+            "purpose": "any",
+        }],
+    })
+}
+
+/// Paths the worker leaves alone: [`NETWORK_ONLY`] plus the configured `admin_path`.
+fn network_only(config: &AppConfig) -> Vec<String> {
+    let mut paths: Vec<String> = NETWORK_ONLY.iter().map(|p| p.to_string()).collect();
+    let admin = config.admin_path.as_deref().unwrap_or("/api/admin");
+    let admin = format!("/{}", admin.trim_matches('/'));
+    if admin != "/" && !paths.contains(&admin) {
+        paths.push(admin);
+    }
+    paths
+}
+
+pub fn service_worker(config: &AppConfig, version: &str) -> String {
+    let precache: Vec<&str> = PRECACHE.iter().map(|(url, _)| *url).collect();
+    let precache = serde_json::to_string(&precache).unwrap_or_else(|_| "[]".into());
+    let network_only = serde_json::to_string(&network_only(config)).unwrap_or_else(|_| "[]".into());
+    SERVICE_WORKER_TEMPLATE
+        .replace("__CACHE__", &format!("{CACHE_PREFIX}{version}"))
+        .replace("__CACHE_PREFIX__", CACHE_PREFIX)
+        .replace("__PRECACHE__", &precache)
+        .replace("__OFFLINE__", OFFLINE_PAGE)
+        .replace("__NETWORK_ONLY__", &network_only)
+}
+
+const SERVICE_WORKER_TEMPLATE: &str = r#"// madmail landing page service worker (generated by the server).
+const CACHE = "__CACHE__";
+const PRECACHE = __PRECACHE__;
+const OFFLINE_URL = "__OFFLINE__";
+const NETWORK_ONLY = __NETWORK_ONLY__;
+
+self.addEventListener("install", (event) => {
+  event.waitUntil(
+    caches.open(CACHE)
+      .then((cache) => cache.addAll(PRECACHE.map((url) => new Request(url, { cache: "reload" }))))
+      .then(() => self.skipWaiting())
+  );
+});
+
+self.addEventListener("activate", (event) => {
+  event.waitUntil(
+    caches.keys()
+      .then((keys) => Promise.all(keys
+        .filter((key) => key.startsWith("__CACHE_PREFIX__") && key !== CACHE)
+        .map((key) => caches.delete(key))))
+      .then(() => self.clients.claim())
+  );
+});
+
+function networkOnly(url) {
+  return url.origin !== self.location.origin ||
+    NETWORK_ONLY.some((p) => url.pathname === p || url.pathname.startsWith(p + "/"));
+}
+
+self.addEventListener("fetch", (event) => {
+  const request = event.request;
+  if (request.method !== "GET") return;
+  const url = new URL(request.url);
+  if (networkOnly(url)) return;
+
+  if (PRECACHE.includes(url.pathname)) {
+    // Cache first; refresh the stored copy in the background for next time.
+    event.respondWith(caches.open(CACHE).then((cache) =>
+      cache.match(url.pathname).then((hit) => {
+        const fresh = fetch(request).then((response) => {
+          if (response.ok) cache.put(url.pathname, response.clone());
+          return response;
+        });
+        if (hit) {
+          event.waitUntil(fresh.catch(() => undefined));
+          return hit;
+        }
+        return fresh.catch(() => caches.match(OFFLINE_URL));
+      })));
+    return;
+  }
+
+  if (request.mode === "navigate") {
+    event.respondWith(fetch(request).catch(() => caches.match(OFFLINE_URL)));
+  }
+});
+"#;
+
+/// Fingerprint of what the site serves right now (embedded files, or the live `www_dir`
+/// with embedded fallbacks), so editing a theme file also rolls the cache.
+pub fn current_version(st: &WwwState) -> String {
+    let files: Vec<_> = PRECACHE
+        .iter()
+        .map(|(_, file)| (*file, st.load_asset(file)))
+        .collect();
+    cache_version(files.iter().map(|(file, bytes)| (*file, bytes.as_deref())))
+}
+
+fn plain(content_type: &str, cache_control: &str, body: String) -> Response {
+    Response::builder()
+        .status(StatusCode::OK)
+        .header(header::CONTENT_TYPE, content_type)
+        .header(header::CACHE_CONTROL, cache_control)
+        .body(Body::from(body))
+        .unwrap_or_else(|_| StatusCode::INTERNAL_SERVER_ERROR.into_response())
+}
+
+/// `GET /manifest.webmanifest`
+pub async fn manifest_handler(State(st): State<WwwState>) -> Response {
+    plain(
+        "application/manifest+json",
+        "no-cache",
+        manifest(&st.config).to_string(),
+    )
+}
+
+/// `GET /sw.js` — never cached by the browser, so a new version is picked up at once.
+pub async fn service_worker_handler(State(st): State<WwwState>) -> Response {
+    let mut resp = plain(
+        "application/javascript; charset=utf-8",
+        SERVICE_WORKER_CACHE_CONTROL,
+        service_worker(&st.config, &current_version(&st)),
+    );
+    resp.headers_mut().insert(
+        "Service-Worker-Allowed",
+        header::HeaderValue::from_static("/"),
+    );
+    resp
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn manifest_is_valid_json_with_configured_colors() {
+        let config = AppConfig {
+            hostname: Some("chat.example.org".into()),
+            pwa_theme_color: Some("0B7285".into()),
+            pwa_background_color: Some("#nope".into()),
+            ..AppConfig::default()
+        };
+        let text = serde_json::to_string(&manifest(&config)).unwrap();
+        let parsed: Value = serde_json::from_str(&text).unwrap();
+        assert_eq!(parsed["name"], "chat.example.org");
+        assert_eq!(parsed["start_url"], "/");
+        assert_eq!(parsed["display"], "standalone");
+        assert_eq!(parsed["theme_color"], "#0b7285");
+        assert_eq!(parsed["background_color"], DEFAULT_BACKGROUND_COLOR);
+        assert_eq!(parsed["icons"][0]["src"], "/logo.svg");
+    }
+
+    #[test]
+    fn cache_version_changes_with_any_precached_file() {
+        let v1 = cache_version([("main.css", Some(&b"body{}"[..])), ("main.js", None)]);
+        assert_eq!(v1.len(), 12);
+        assert_eq!(
+            v1,
+            cache_version([("main.css", Some(&b"body{}"[..])), ("main.js", None)])
+        );
+        let v2 = cache_version([
+            ("main.css", Some(&b"body{color:red}"[..])),
+            ("main.js", None),
+        ]);
+        assert_ne!(v1, v2);
+    }
+
+    #[test]
+    fn service_worker_skips_registration_and_admin() {
+        let config = AppConfig {
+            admin_path: Some("/secret-admin/".into()),
+            ..AppConfig::default()
+        };
+        let sw = service_worker(&config, "0123456789ab");
+        assert!(sw.contains(r#"const CACHE = "madmail-www-0123456789ab";"#));
+        assert!(sw.contains(r#""/new""#));
+        assert!(sw.contains(r#""/secret-admin""#));
+        assert!(sw.contains(r#"if (request.method !== "GET") return;"#));
+        assert!(!sw.contains("__"), "all placeholders substituted");
+    }
+}
@@ -19,17 +19,30 @@ use std::collections::HashMap;
 use std::path::{Path, PathBuf};
 use std::sync::{Arc, RwLock};
 
+use axum::extract::DefaultBodyLimit;
 use axum::routing::{delete, get, post};
-use axum::Router;
+use axum::{middleware, Router};
 use chatmail_config::AppConfig;
 use chatmail_db::DbPool;
 use chatmail_state::AppState;
+use chatmail_turn::TurnDiscovery;
 
+use crate::account::{self, DeleteConfirmations};
 use crate::assets::{embedded_asset_bytes, external_asset_bytes, preload_embedded_www};
+use crate::autoconfig;
+use crate::body_limit::{self, BodyClass};
 use crate::contact_sharing::SharingStore;
 use crate::context_cache::{SharedWwwContextCache, WwwContextCache};
+use crate::device::{self, DeviceRateLimiter};
 use crate::handlers;
+use crate::idempotency::IdempotencyStore;
+use crate::probe;
+use crate::pwa;
+use crate::status_page;
+use crate::takeout;
 use crate::template::TemplateEngine;
+use crate::turn;
+use crate::webfinger;
 use crate::webimap;
 
 #[derive(Clone)]
@@ -41,7 +54,8 @@ pub struct WwwState {
     pub mail_domain: String,
     /// Domains accepted for local delivery (WebIMAP send + SMTP).
     pub local_domains: Vec<String>,
-    /// External www root (`chatmail { www_dir }` / `html-serve`). Unset = embedded default site.
+    /// External www root: the active installed theme, else `chatmail { www_dir }` / `html-serve`.
+    /// Unset = embedded default site.
     pub www_dir: Option<PathBuf>,
     /// Cached DB-backed template fields (Madmail `hydrateCache`, 5s TTL).
     pub context_cache: SharedWwwContextCache,
@@ -50,6 +64,16 @@ pub struct WwwState {
     pub state_dir: PathBuf,
     /// Lazy `{state_dir}/sharing.db` pool when `enable_contact_sharing` is set.
     pub sharing: Option<Arc<SharingStore>>,
+    /// `POST /new` replay cache keyed by `Idempotency-Key` + client IP.
+    pub idempotency: Arc<IdempotencyStore>,
+    /// Per-account / per-IP limits for `/device-code`, `/device-redeem`, `/recover` and
+    /// wrong passwords on `/account/*`.
+    pub device_limits: Arc<DeviceRateLimiter>,
+    /// Outstanding `GET /account/delete` confirmation tokens.
+    pub delete_confirms: Arc<DeleteConfirmations>,
+    /// TURN settings behind `POST /turn/credentials` (the IMAP METADATA discovery; `None`
+    /// while TURN is off). Rebuilt with the router on soft reload.
+    pub turn: Option<TurnDiscovery>,
 }
 
 impl WwwState {
@@ -66,8 +90,8 @@ impl WwwState {
             .clone()
             .unwrap_or_else(|| "127.0.0.1".into());
         let local_domains = config.effective_local_domains(&hostname);
-        let www_dir = config.www_dir.clone();
-        let templates = Arc::new(TemplateEngine::from_config(&config));
+        let www_dir = crate::theme::effective_www_dir(&state_dir, config.www_dir.as_deref());
+        let templates = Arc::new(TemplateEngine::from_www_dir(www_dir.as_deref()));
         let asset_cache = Arc::new(RwLock::new(HashMap::new()));
         if www_dir.is_none() {
             preload_embedded_www(&asset_cache);
@@ -93,13 +117,26 @@ impl WwwState {
             asset_cache,
             state_dir,
             sharing,
+            idempotency: Arc::new(IdempotencyStore::new()),
+            device_limits: Arc::new(DeviceRateLimiter::new()),
+            delete_confirms: Arc::new(DeleteConfirmations::new()),
+            turn: None,
         }
     }
 
-    /// CSS/JS/SVG: embedded default = RAM only; external `www_dir` = live disk.
+    /// Serve `POST /turn/credentials` from `turn`.
+    pub fn with_turn(mut self, turn: Option<TurnDiscovery>) -> Self {
+        self.turn = turn;
+        self
+    }
+
+    /// CSS/JS/SVG: embedded default = RAM only; external `www_dir` = live disk, with
+    /// the embedded file as fallback for anything the external tree leaves out.
     pub fn load_asset(&self, path: &str) -> Option<Arc<[u8]>> {
         if let Some(ref dir) = self.www_dir {
-            return external_asset_bytes(path, dir).map(Arc::from);
+            return external_asset_bytes(path, dir)
+                .map(Arc::from)
+                .or_else(|| embedded_asset_bytes(path));
         }
         if let Some(b) = self.asset_cache.read().ok()?.get(path) {
             return Some(Arc::clone(b));
@@ -111,6 +148,12 @@ impl WwwState {
         Some(arc)
     }
 
+    /// `domain` (normalized, IP domains bracketed) is the mail domain or another local domain.
+    pub fn serves_domain(&self, domain: &str) -> bool {
+        let same = |d: &String| d.eq_ignore_ascii_case(domain);
+        same(&self.mail_domain) || self.local_domains.iter().any(same)
+    }
+
     /// Default webpage baked into the binary (no `www_dir` in config).
     pub fn uses_embedded_www(&self) -> bool {
         self.www_dir.is_none()
@@ -122,13 +165,46 @@ impl WwwState {
     }
 }
 
+/// Body class of every route that reads a request body; see [`body_limit`].
+/// Routes not listed here are capped at [`body_limit::UNLISTED_BODY_LIMIT`].
+pub const BODY_RULES: &[(&str, BodyClass)] = &[
+    ("/new", BodyClass::Json),
+    ("/device-redeem", BodyClass::Json),
+    ("/recover", BodyClass::Json),
+    ("/account/password", BodyClass::Json),
+    ("/account/delete", BodyClass::Json),
+    ("/account/sieve", BodyClass::Sieve),
+    ("/account/vacation", BodyClass::Json),
+    ("/webimap/send", BodyClass::Message),
+    ("/websmtp/send", BodyClass::Message),
+    ("/webimap/message/flags", BodyClass::Json),
+    ("/share", BodyClass::Form),
+];
+
+pub fn body_class(path: &str) -> BodyClass {
+    BODY_RULES
+        .iter()
+        .find(|(p, _)| *p == path)
+        .map_or(BodyClass::Unlisted, |(_, class)| *class)
+}
+
 /// Public Madmail-compatible web UI (index, docs, /new, `/madmail` binary, static assets).
 pub fn www_router(state: WwwState) -> Router {
     Router::new()
         .route("/madmail", get(handlers::binary_download))
         .route(
             "/new",
-            post(handlers::new_account).options(handlers::new_account_options),
+            post(handlers::new_account)
+                .get(handlers::new_account_challenge)
+                .options(handlers::new_account_options),
+        )
+        .route(
+            "/device-code",
+            post(device::device_code).options(webimap::options_preflight),
+        )
+        .route(
+            "/device-redeem",
+            post(device::device_redeem).options(webimap::options_preflight),
         )
         .route(
             "/webimap/send",
@@ -165,6 +241,49 @@ pub fn www_router(state: WwwState) -> Router {
             "/share",
             get(handlers::share_get).post(handlers::share_post),
         )
+        .route("/share/links", get(handlers::share_links))
+        .route("/takeout", post(takeout::start).get(takeout::status))
+        .route("/account", delete(account::delete))
+        .route(
+            "/account/password",
+            post(account::password).options(webimap::options_preflight),
+        )
+        .route(
+            "/account/delete",
+            get(account::delete_confirmation)
+                .post(account::delete_confirmed)
+                .options(webimap::options_preflight),
+        )
+        .route(
+            "/account/sieve",
+            get(account::sieve_get)
+                .put(account::sieve_put)
+                .delete(account::sieve_delete)
+                .options(webimap::options_preflight),
+        )
+        .route(
+            "/account/vacation",
+            get(account::vacation_get)
+                .put(account::vacation_put)
+                .delete(account::vacation_delete)
+                .options(webimap::options_preflight),
+        )
+        .route(
+            "/recover",
+            post(account::recover).options(webimap::options_preflight),
+        )
+        .route("/takeout/{token}", get(takeout::download))
+        .route(
+            "/turn/credentials",
+            post(turn::credentials).options(webimap::options_preflight),
+        )
+        .route("/status", get(status_page::status_page))
+        .route("/status.json", get(status_page::status_json))
+        .route("/health", get(probe::health))
+        .route("/healthz", get(probe::health))
+        .route("/ready", get(probe::ready))
+        .route("/readyz", get(probe::ready))
+        .route("/cdn-cgi/{*path}", get(probe::cdn_cgi))
         .route("/app", get(handlers::app_page))
         .route("/docs", get(handlers::docs_redirect))
         .route("/docs/", get(handlers::docs_index))
@@ -172,9 +291,35 @@ pub fn www_router(state: WwwState) -> Router {
         .route("/inv/{*token}", get(handlers::invite_page))
         .route(
             "/.well-known/autoconfig/mail/config-v1.1.xml",
-            get(handlers::mail_autoconfig),
+            get(autoconfig::mail_autoconfig).post(autoconfig::mail_autoconfig),
+        )
+        .route(
+            autoconfig::AUTOCONFIG_PATH,
+            get(autoconfig::mail_autoconfig).post(autoconfig::mail_autoconfig),
+        )
+        .route(
+            autoconfig::AUTODISCOVER_PATH,
+            get(autoconfig::autodiscover).post(autoconfig::autodiscover),
+        )
+        .route(
+            autoconfig::AUTODISCOVER_PATH_OUTLOOK,
+            get(autoconfig::autodiscover).post(autoconfig::autodiscover),
         )
+        .route(webfinger::WEBFINGER_PATH, get(webfinger::webfinger))
+        .route(pwa::MANIFEST_PATH, get(pwa::manifest_handler))
+        .route(pwa::SERVICE_WORKER_PATH, get(pwa::service_worker_handler))
         .route("/", get(handlers::index))
         .route("/{*path}", get(handlers::catch_all))
+        // `body_limit` enforces the per-route caps; axum's 2 MiB default would cut
+        // `/webimap/send` below `max_message_size`.
+        .layer(DefaultBodyLimit::disable())
+        .layer(middleware::from_fn_with_state(
+            state.clone(),
+            body_limit::www_body_limit,
+        ))
+        .layer(middleware::from_fn_with_state(
+            state.clone(),
+            handlers::ban_gate,
+        ))
         .with_state(state)
 }
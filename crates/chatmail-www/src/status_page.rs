@@ -0,0 +1,272 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Public status page: `GET /status` (HTML) and `GET /status.json`.
+//!
+//! Both read the cached [`HealthMonitor`](chatmail_state::HealthMonitor) snapshot and the
+//! stored incident log; a request never probes a service. The HTML is built in code rather
+//! than from `www_dir` templates, so a customised site cannot break it. Federation peers
+//! (`madmail peers`) are listed with their last reachability; they never affect the overall
+//! status, since a peer outage is not ours.
+
+use axum::extract::State;
+use axum::http::{header, StatusCode};
+use axum::response::{Html, IntoResponse, Response};
+use axum::Json;
+use chatmail_db::{list_incidents, Incident};
+use chatmail_state::{ComponentHealth, ComponentState, PeerStatus};
+use serde_json::{json, Value};
+use time::format_description::well_known::Rfc3339;
+use time::OffsetDateTime;
+
+use crate::WwwState;
+
+/// Incidents shown on the page / in the JSON.
+const RECENT_INCIDENTS: i64 = 50;
+
+struct StatusReport {
+    overall: ComponentState,
+    started_at: i64,
+    now: i64,
+    components: Vec<ComponentHealth>,
+    incidents: Vec<Incident>,
+    peers: Vec<PeerStatus>,
+}
+
+impl StatusReport {
+    async fn load(st: &WwwState) -> Self {
+        let components = st.app.health.snapshot();
+        // A database outage must not take the status page down with it.
+        let incidents = list_incidents(&st.pool, RECENT_INCIDENTS)
+            .await
+            .unwrap_or_else(|e| {
+                tracing::warn!(error = %e, "status page: incident log unavailable");
+                Vec::new()
+            });
+        Self {
+            overall: overall_state(&components),
+            started_at: st.app.health.started_at(),
+            now: chatmail_db::policies::unix_now(),
+            components,
+            incidents,
+            peers: st.app.peers.snapshot(),
+        }
+    }
+
+    fn to_json(&self) -> Value {
+        json!({
+            "status": self.overall.as_str(),
+            "started_at": self.started_at,
+            "uptime_seconds": (self.now - self.started_at).max(0),
+            "components": self.components.iter().map(|c| json!({
+                "name": c.name,
+                "state": c.state.as_str(),
+                "since": c.since,
+                "checked_at": c.checked_at,
+            })).collect::<Vec<_>>(),
+            "incidents": self.incidents.iter().map(|i| json!({
+                "id": i.id,
+                "component": i.component,
+                "kind": i.kind,
+                "message": i.message,
+                "created_at": i.created_at,
+            })).collect::<Vec<_>>(),
+            "peers": self.peers.iter().map(|p| json!({
+                "name": peer_name(p),
+                "reachable": p.reachable,
+                "checked_at": p.checked_at,
+            })).collect::<Vec<_>>(),
+        })
+    }
+
+    fn to_html(&self, domain: &str) -> String {
+        let mut components = String::new();
+        for c in &self.components {
+            components.push_str(&format!(
+                "<tr><td>{}</td><td class=\"{state}\">{state}</td><td>{}</td></tr>\n",
+                escape_html(&c.name),
+                format_unix(c.since),
+                state = c.state.as_str(),
+            ));
+        }
+        if components.is_empty() {
+            components.push_str("<tr><td colspan=\"3\">No checks have run yet.</td></tr>\n");
+        }
+        let mut incidents = String::new();
+        for i in &self.incidents {
+            incidents.push_str(&format!(
+                "<li><time>{}</time> <b>{}</b> {}: {}</li>\n",
+                format_unix(i.created_at),
+                escape_html(&i.kind),
+                escape_html(&i.component),
+                escape_html(&i.message),
+            ));
+        }
+        if incidents.is_empty() {
+            incidents.push_str("<li>No incidents recorded.</li>\n");
+        }
+        let mut peers = String::new();
+        if !self.peers.is_empty() {
+            peers.push_str("<h2>Peers</h2>\n<table>\n");
+            for p in &self.peers {
+                let state = match p.reachable {
+                    Some(true) => "operational",
+                    Some(false) => "down",
+                    None => "unknown",
+                };
+                peers.push_str(&format!(
+                    "<tr><td>{}</td><td class=\"{state}\">{state}</td><td>{}</td></tr>\n",
+                    escape_html(&peer_name(p)),
+                    p.checked_at.map(format_unix).unwrap_or_default(),
+                ));
+            }
+            peers.push_str("</table>\n");
+        }
+        format!(
+            "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\">\
+             <meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\
+             <title>{domain} status</title><style>\
+             body{{font-family:sans-serif;max-width:40em;margin:2em auto;padding:0 1em}}\
+             table{{border-collapse:collapse;width:100%}}td{{padding:.3em;border-bottom:1px solid #ddd}}\
+             .operational{{color:#080}}.degraded{{color:#b60}}.down{{color:#c00}}\
+             </style></head><body>\n<h1>{domain}</h1>\n\
+             <p>Status: <span class=\"{overall}\">{overall}</span> &middot; up {uptime} (since {started})</p>\n\
+             <table>\n{components}</table>\n<h2>Incidents</h2>\n<ul>\n{incidents}</ul>\n{peers}</body></html>\n",
+            domain = escape_html(domain),
+            overall = self.overall.as_str(),
+            uptime = format_uptime(self.now - self.started_at),
+            started = format_unix(self.started_at),
+        )
+    }
+}
+
+/// Domain from the peer's last document, else its base URL.
+fn peer_name(p: &PeerStatus) -> String {
+    p.info
+        .as_ref()
+        .map_or_else(|| p.base_url.clone(), |i| i.domain.clone())
+}
+
+/// Worst state across components; no components yet counts as operational.
+fn overall_state(components: &[ComponentHealth]) -> ComponentState {
+    let states = || components.iter().map(|c| c.state);
+    if states().any(|s| s == ComponentState::Down) {
+        ComponentState::Down
+    } else if states().any(|s| s == ComponentState::Degraded) {
+        ComponentState::Degraded
+    } else {
+        ComponentState::Operational
+    }
+}
+
+fn format_unix(ts: i64) -> String {
+    OffsetDateTime::from_unix_timestamp(ts)
+        .ok()
+        .and_then(|t| t.format(&Rfc3339).ok())
+        .unwrap_or_else(|| ts.to_string())
+}
+
+fn format_uptime(secs: i64) -> String {
+    let secs = secs.max(0);
+    let (days, hours, mins) = (secs / 86_400, secs % 86_400 / 3600, secs % 3600 / 60);
+    if days > 0 {
+        format!("{days}d {hours}h {mins}m")
+    } else if hours > 0 {
+        format!("{hours}h {mins}m")
+    } else {
+        format!("{mins}m")
+    }
+}
+
+fn escape_html(s: &str) -> String {
+    let mut out = String::with_capacity(s.len());
+    for ch in s.chars() {
+        match ch {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            '\'' => out.push_str("&#39;"),
+            c => out.push(c),
+        }
+    }
+    out
+}
+
+pub async fn status_page(State(st): State<WwwState>) -> Response {
+    let report = StatusReport::load(&st).await;
+    (
+        StatusCode::OK,
+        [(header::CACHE_CONTROL, "no-cache")],
+        Html(report.to_html(&st.mail_domain)),
+    )
+        .into_response()
+}
+
+pub async fn status_json(State(st): State<WwwState>) -> Response {
+    let report = StatusReport::load(&st).await;
+    (
+        StatusCode::OK,
+        [(header::CACHE_CONTROL, "no-cache")],
+        Json(report.to_json()),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn uptime_and_escaping() {
+        assert_eq!(format_uptime(59), "0m");
+        assert_eq!(format_uptime(3 * 3600 + 120), "3h 2m");
+        assert_eq!(format_uptime(86_400 + 60), "1d 0h 1m");
+        assert_eq!(
+            escape_html("<a href=\"x\">&"),
+            "&lt;a href=&quot;x&quot;&gt;&amp;"
+        );
+        assert_eq!(format_unix(0), "1970-01-01T00:00:00Z");
+    }
+
+    #[test]
+    fn peers_are_listed_without_their_errors() {
+        let report = StatusReport {
+            overall: ComponentState::Operational,
+            started_at: 0,
+            now: 60,
+            components: vec![],
+            incidents: vec![],
+            peers: vec![PeerStatus {
+                base_url: "https://b.example".into(),
+                source: chatmail_state::PeerSource::Stored,
+                info: None,
+                verified: false,
+                reachable: Some(false),
+                checked_at: Some(0),
+                last_success: None,
+                error: Some("connection refused".into()),
+            }],
+        };
+        let html = report.to_html("a.example");
+        assert!(html.contains("<td>https://b.example</td><td class=\"down\">down</td>"));
+        assert!(!html.contains("refused"));
+        let json = report.to_json();
+        assert_eq!(json["status"], "operational");
+        assert_eq!(json["peers"][0]["reachable"], false);
+        assert!(json["peers"][0].get("error").is_none());
+    }
+}
@@ -0,0 +1,168 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Account takeout over HTTP, in two phases (see `chatmail_state::takeout`):
+//!
+//! - `POST /takeout` (Basic auth) queues an export of every mailbox and answers `202` with
+//!   the job, including its single-use `download_url`;
+//! - `GET /takeout` (Basic auth) reports progress of the caller's jobs;
+//! - `GET /takeout/{token}` streams the finished zip without authentication, once.
+
+use std::sync::Arc;
+
+use axum::body::{Body, Bytes};
+use axum::extract::{Path, State};
+use axum::http::{header, HeaderMap, HeaderValue, StatusCode};
+use axum::response::{IntoResponse, Response};
+use chatmail_state::{TakeoutDownload, TakeoutJob, TakeoutState};
+use chatmail_types::ChatmailError;
+use serde_json::{json, Value};
+use tokio::io::AsyncReadExt;
+
+use crate::handlers::basic_authenticate;
+use crate::response::{json_err, json_ok};
+use crate::WwwState;
+
+const CHUNK: usize = 64 * 1024;
+
+fn job_json(job: &TakeoutJob) -> Value {
+    let (done, total, written) = job.progress.snapshot();
+    let state = job.state();
+    let mut v = json!({
+        "token": job.token,
+        "state": state.as_str(),
+        "messages_done": done,
+        "messages_total": total,
+        "bytes_written": written,
+        "created_at": job.created_at,
+        "expires_at": job.expires_at,
+        "download_url": format!("/takeout/{}", job.token),
+    });
+    if let TakeoutState::Failed(e) = state {
+        v["error"] = json!(e);
+    }
+    v
+}
+
+/// POST `/takeout`
+pub async fn start(State(st): State<WwwState>, headers: HeaderMap) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match basic_authenticate(&st, &headers, &cors, "takeout").await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let started = st
+        .app
+        .takeout
+        .start(
+            Arc::clone(&st.app.mailbox_store),
+            Arc::clone(&st.app.disk),
+            &user,
+        )
+        .await;
+    match started {
+        Ok(job) => json_ok(StatusCode::ACCEPTED, &job_json(&job), &cors),
+        Err(ChatmailError::ServerFull(msg)) => {
+            json_err(StatusCode::INSUFFICIENT_STORAGE, &msg, &cors)
+        }
+        Err(e) => {
+            tracing::warn!(user = %user, error = %e, "takeout: could not start export");
+            json_err(StatusCode::INTERNAL_SERVER_ERROR, "export failed", &cors)
+        }
+    }
+}
+
+/// GET `/takeout`
+pub async fn status(State(st): State<WwwState>, headers: HeaderMap) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match basic_authenticate(&st, &headers, &cors, "takeout").await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let jobs: Vec<Value> = st
+        .app
+        .takeout
+        .jobs_for(&user)
+        .iter()
+        .map(|j| job_json(j))
+        .collect();
+    json_ok(StatusCode::OK, &json!({ "jobs": jobs }), &cors)
+}
+
+struct Transfer {
+    file: tokio::fs::File,
+    download: Option<TakeoutDownload>,
+    sent: u64,
+}
+
+/// GET `/takeout/{token}`
+pub async fn download(State(st): State<WwwState>, Path(token): Path<String>) -> Response {
+    let Some(download) = st.app.takeout.claim(&token) else {
+        return (StatusCode::NOT_FOUND, "Not Found").into_response();
+    };
+    let file = match tokio::fs::File::open(&download.path).await {
+        Ok(f) => f,
+        Err(e) => {
+            tracing::warn!(error = %e, "takeout: archive missing");
+            return (StatusCode::NOT_FOUND, "Not Found").into_response();
+        }
+    };
+    let filename = format!("takeout-{}.zip", download.user().replace(['"', '\\'], "_"));
+    let length = download.bytes;
+    let transfer = Transfer {
+        file,
+        download: Some(download),
+        sent: 0,
+    };
+    // The token is retired only after the last byte was handed to the connection; an
+    // aborted transfer drops the claim and the link works again until it expires.
+    let stream = futures_util::stream::unfold(transfer, |mut t| async move {
+        t.download.as_ref()?;
+        let mut buf = vec![0u8; CHUNK];
+        match t.file.read(&mut buf).await {
+            Ok(0) => {
+                let download = t.download.take()?;
+                if t.sent == download.bytes {
+                    download.complete();
+                }
+                None
+            }
+            Ok(n) => {
+                buf.truncate(n);
+                t.sent += n as u64;
+                Some((Ok(Bytes::from(buf)), t))
+            }
+            Err(e) => {
+                t.download = None;
+                Some((Err(e), t))
+            }
+        }
+    });
+
+    let mut resp = Body::from_stream(stream).into_response();
+    let headers = resp.headers_mut();
+    headers.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static("application/zip"),
+    );
+    headers.insert(header::CONTENT_LENGTH, HeaderValue::from(length));
+    if let Ok(v) = HeaderValue::try_from(format!("attachment; filename=\"{filename}\"")) {
+        headers.insert(header::CONTENT_DISPOSITION, v);
+    }
+    headers.insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    resp
+}
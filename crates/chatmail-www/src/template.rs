@@ -21,8 +21,9 @@ use chatmail_config::{AppConfig, DcloginMailSettings, RuntimeListeners};
 use chatmail_db::{resolve_default_quota_bytes, DbPool};
 use chatmail_types::Result;
 
+use crate::app_links::AppLinks;
 use crate::context_cache::WwwContextCache;
-use crate::www_facts::{format_retention_label, retention_info_line};
+use crate::www_facts::{format_retention_label, retention_exceptions_line, retention_info_line};
 use minijinja::Environment;
 use serde::Serialize;
 
@@ -35,6 +36,8 @@ use crate::go_template::prepare_template;
 #[allow(non_snake_case)]
 pub struct WwwContext {
     pub MailDomain: String,
+    /// Every registration domain, `MailDomain` first (domain picker on the index page).
+    pub MailDomains: Vec<String>,
     pub MXDomain: String,
     pub WebDomain: String,
     pub PublicIP: String,
@@ -70,12 +73,43 @@ pub struct CustomFields {
     pub Slug: String,
     pub URL: String,
     pub Name: String,
+    /// `contact` or `group`; selects `contact_view.html` vs `group_view.html`.
+    pub Kind: String,
+    /// Group invites only; empty otherwise.
+    pub MemberNote: String,
+    /// Group invites only; empty otherwise.
+    pub Description: String,
+    /// Platform deep link + store fallbacks (contact landing page only).
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub App: Option<AppLinks>,
+    /// `contact_links.html`: the signed-in account's links.
+    pub Links: Vec<SharedLink>,
+    /// View counters are collected and shown (`sharing_analytics`).
+    pub Analytics: bool,
+}
+
+/// One row of `contact_links.html`.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "PascalCase")]
+#[allow(non_snake_case)]
+pub struct SharedLink {
+    pub Slug: String,
+    pub Name: String,
+    pub Kind: String,
+    pub CreatedAt: String,
+    /// UTC `YYYY-MM-DD HH:MM:SS`, empty when the link never expires.
+    pub ExpiresAt: String,
+    pub Views: i64,
+    /// `YYYY-MM-DD`, empty when never viewed.
+    pub LastViewed: String,
 }
 
 pub struct TemplateEngine {
-    /// Compiled embedded templates (`www-src/` in the binary).
+    /// Compiled embedded templates (`www-src/` in the binary); also the fallback
+    /// when an external template fails to render.
     embedded: Option<Environment<'static>>,
-    /// `html-serve` / `html-export` tree — templates read from disk on every render.
+    /// `html-serve` / `html-export` / installed theme tree — templates read from disk
+    /// on every render.
     external_root: Option<std::path::PathBuf>,
 }
 
@@ -96,7 +130,13 @@ impl TemplateEngine {
 
     /// Default: all HTML templates compiled into RAM. With `www_dir`: live disk reload.
     pub fn from_config(config: &AppConfig) -> Self {
-        if let Some(ref dir) = config.www_dir {
+        Self::from_www_dir(config.www_dir.as_deref())
+    }
+
+    /// Like [`TemplateEngine::from_config`] for an already resolved www root
+    /// (`www_dir` or the active theme, see [`crate::theme::effective_www_dir`]).
+    pub fn from_www_dir(www_dir: Option<&Path>) -> Self {
+        if let Some(dir) = www_dir {
             if dir.is_dir() {
                 if walk_html_files(dir, dir, &mut |_, _| Ok(())).is_ok() {
                     tracing::info!(
@@ -104,8 +144,8 @@ impl TemplateEngine {
                         "www: external www_dir (live disk reload)"
                     );
                     return Self {
-                        embedded: None,
-                        external_root: Some(dir.clone()),
+                        embedded: Some(Self::load_embedded_env()),
+                        external_root: Some(dir.to_path_buf()),
                     };
                 }
                 tracing::warn!(
@@ -145,10 +185,32 @@ impl TemplateEngine {
         env
     }
 
+    /// Render `name`. A broken external template falls back to the embedded page of
+    /// the same name (logged), so a bad edit or theme never takes a page offline.
     pub fn render(&self, name: &str, ctx: &WwwContext) -> Result<String> {
         if let Some(root) = &self.external_root {
-            return Self::render_external(root, name, ctx);
+            let err = match Self::render_external(root, name, ctx) {
+                Ok(html) => return Ok(html),
+                Err(e) => e,
+            };
+            let has_embedded = self
+                .embedded
+                .as_ref()
+                .is_some_and(|env| env.get_template(name).is_ok());
+            if !has_embedded {
+                return Err(err);
+            }
+            tracing::warn!(
+                %err,
+                file = %name,
+                "www: external template failed; serving embedded page"
+            );
+            return self.render_embedded(name, ctx);
         }
+        self.render_embedded(name, ctx)
+    }
+
+    fn render_embedded(&self, name: &str, ctx: &WwwContext) -> Result<String> {
         let env = self.embedded.as_ref().ok_or_else(|| {
             chatmail_types::ChatmailError::config("www template engine not initialized")
         })?;
@@ -196,7 +258,7 @@ impl TemplateEngine {
     }
 }
 
-fn add_filters(env: &mut Environment<'_>) {
+pub(crate) fn add_filters(env: &mut Environment<'_>) {
     env.add_filter("clean_domain", clean_domain);
     env.add_filter("format_bytes", format_bytes);
     env.add_filter("safe_html", safe_html);
@@ -270,7 +332,8 @@ pub async fn build_context(
         .await
         .ok_or_else(|| chatmail_types::ChatmailError::config("www context cache empty"))?;
 
-    let mail_domain = config.effective_registration_domain(http_host);
+    let mail_domains = config.mail_domains(http_host);
+    let mail_domain = mail_domains[0].clone();
     let mx_domain = config
         .mx_domain
         .clone()
@@ -296,11 +359,17 @@ pub async fn build_context(
         .unwrap_or_default();
 
     let default_quota = resolve_default_quota_bytes(pool, config).await? as i64;
-    let message_retention_line =
-        format_retention_label(config).map(|label| retention_info_line(&cached.language, &label));
+    let message_retention_line = format_retention_label(config).map(|label| {
+        let line = retention_info_line(&cached.language, &label);
+        match retention_exceptions_line(&cached.language, config) {
+            Some(extra) => format!("{line} {extra}"),
+            None => line,
+        }
+    });
 
     Ok(WwwContext {
         MailDomain: mail_domain,
+        MailDomains: mail_domains,
         MXDomain: mx_domain,
         WebDomain: web_domain,
         PublicIP: public_ip,
@@ -458,6 +458,16 @@ fn web_delivery_error_maps_message_too_large_to_413() {
     assert_eq!(msg, MESSAGE_FILE_TOO_BIG);
 }
 
+#[test]
+fn web_delivery_error_maps_rate_limited_to_429() {
+    use crate::handlers::web_delivery_error;
+    use chatmail_types::ChatmailError;
+
+    let (status, msg) = web_delivery_error(&ChatmailError::RateLimited("limit 5".into()));
+    assert_eq!(status, axum::http::StatusCode::TOO_MANY_REQUESTS);
+    assert!(msg.starts_with("450 4.7.1"), "{msg}");
+}
+
 #[tokio::test]
 async fn app_state_check_message_size_enforces_limit() {
     use chatmail_state::AppState;
@@ -604,6 +614,132 @@ async fn mail_autoconfig_omits_https_alpn_entry() {
     assert!(!xml.contains("<port>443</port>"));
 }
 
+/// Text of every `<tag>…</tag>` in `xml`, in document order.
+fn xml_texts(xml: &str, tag: &str) -> Vec<String> {
+    let (open, close) = (format!("<{tag}>"), format!("</{tag}>"));
+    xml.split(&open)
+        .skip(1)
+        .filter_map(|rest| rest.split_once(&close).map(|(text, _)| text.to_string()))
+        .collect()
+}
+
+#[tokio::test]
+async fn autodiscover_and_autoconfig_embed_listener_ports() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use tower::ServiceExt;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let cfg = AppConfig {
+        mail_domain: Some("example.org".into()),
+        ..AppConfig::default()
+    };
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    app_state.listener_ports.set_runtime(
+        "0.0.0.0:25",
+        Some("0.0.0.0:1143".into()),
+        Some("0.0.0.0:1993".into()),
+        Some("0.0.0.0:1587".into()),
+        Some("0.0.0.0:1465".into()),
+        None,
+        None,
+    );
+    let st = crate::WwwState::new(pool, app_state, cfg, dir.path());
+    let call = |method: &str, uri: &str, body: String| {
+        let app = crate::www_router(st.clone());
+        let req = Request::builder()
+            .method(method)
+            .uri(uri)
+            .header(header::HOST, "example.org")
+            .header(header::CONTENT_TYPE, "text/xml")
+            .body(axum::body::Body::from(body))
+            .unwrap();
+        async move {
+            let resp = app.oneshot(req).await.unwrap();
+            let status = resp.status();
+            let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            (status, String::from_utf8_lossy(&bytes).into_owned())
+        }
+    };
+    const REQUEST_NS: &str =
+        "http://schemas.microsoft.com/exchange/autodiscover/outlook/requestschema/2006";
+    let outlook = |email: &str| {
+        format!(
+            "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n\
+<Autodiscover xmlns=\"{REQUEST_NS}\">\n\
+<Request><EMailAddress>{email}</EMailAddress></Request></Autodiscover>"
+        )
+    };
+
+    let (status, xml) = call(
+        "POST",
+        "/autodiscover/autodiscover.xml",
+        outlook("Alice@example.org"),
+    )
+    .await;
+    assert_eq!(status, StatusCode::OK);
+    assert_eq!(xml_texts(&xml, "Type"), ["IMAP", "SMTP"]);
+    assert_eq!(xml_texts(&xml, "Port"), ["1993", "1465"]);
+    assert_eq!(xml_texts(&xml, "Encryption"), ["SSL", "SSL"]);
+    assert_eq!(
+        xml_texts(&xml, "LoginName"),
+        ["alice@example.org", "alice@example.org"]
+    );
+
+    let (status, xml) = call(
+        "GET",
+        "/Autodiscover/Autodiscover.xml?emailaddress=bob@example.org",
+        String::new(),
+    )
+    .await;
+    assert_eq!(status, StatusCode::OK);
+    assert_eq!(xml_texts(&xml, "Port"), ["1993", "1465"]);
+
+    let (status, xml) = call(
+        "POST",
+        "/autodiscover/autodiscover.xml",
+        outlook("alice@elsewhere.org"),
+    )
+    .await;
+    assert_eq!(status, StatusCode::OK, "error document, not a 404");
+    assert_eq!(xml_texts(&xml, "ErrorCode"), ["600"]);
+    assert!(xml_texts(&xml, "Port").is_empty());
+
+    for method in ["GET", "POST"] {
+        let (status, xml) = call(
+            method,
+            "/mail/config-v1.1.xml?emailaddress=alice%40example.org",
+            String::new(),
+        )
+        .await;
+        assert_eq!(status, StatusCode::OK, "{method}");
+        assert_eq!(xml_texts(&xml, "domain"), ["example.org"]);
+        assert_eq!(
+            xml_texts(&xml, "port"),
+            ["1993", "1143", "1465", "1587"],
+            "{method}"
+        );
+        assert_eq!(
+            xml_texts(&xml, "socketType"),
+            ["SSL", "STARTTLS", "SSL", "STARTTLS"]
+        );
+    }
+
+    let (status, xml) = call(
+        "GET",
+        "/mail/config-v1.1.xml?emailaddress=alice%40elsewhere.org",
+        String::new(),
+    )
+    .await;
+    assert_eq!(status, StatusCode::OK, "error document, not a 404");
+    assert_eq!(
+        xml_texts(&xml, "error"),
+        ["elsewhere.org is not served by this server"]
+    );
+    assert!(!xml.contains("emailProvider"));
+}
+
 /// Contact sharing: POST /share persists to sharing.db; GET /{slug} renders contact page.
 #[tokio::test]
 async fn contact_sharing_post_and_slug_view() {
@@ -628,7 +764,7 @@ async fn contact_sharing_post_and_slug_view() {
         dir.path(),
     ));
 
-    let form = "url=https%3A%2F%2Fi.delta.chat%2F%23ABCDEF0123456789%26name%3DTest&name=Alice&slug=alicepage";
+    let form = "url=https%3A%2F%2Fi.delta.chat%2F%230123456789ABCDEF0123456789ABCDEF01234567%26a%3Dalice%2540share.test%26n%3DAlice%26i%3Dinv%26s%3Dauth&name=Alice&slug=alicepage";
     let resp = app
         .clone()
         .oneshot(
@@ -659,6 +795,10 @@ async fn contact_sharing_post_and_slug_view() {
         .oneshot(
             Request::builder()
                 .uri("/alicepage")
+                .header(
+                    "user-agent",
+                    "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X)",
+                )
                 .body(axum::body::Body::empty())
                 .unwrap(),
         )
@@ -669,6 +809,263 @@ async fn contact_sharing_post_and_slug_view() {
     let page = String::from_utf8_lossy(&view_body);
     assert!(page.contains("Alice"));
     assert!(page.contains("openpgp4fpr:"));
+    assert!(page.contains(r#"data-platform="ios""#));
+}
+
+/// `/share` takes an optional `expires` lifetime; an expired slug is a 404.
+#[tokio::test]
+async fn contact_sharing_links_expire() {
+    use axum::http::{Request, StatusCode};
+    use tower::ServiceExt;
+
+    use chatmail_db::{get_sharing_contact, init_sharing_db};
+    use chatmail_state::AppState;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let mut cfg = AppConfig::default();
+    cfg.enable_contact_sharing = true;
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        app_state,
+        cfg,
+        dir.path(),
+    ));
+    let url = "https%3A%2F%2Fi.delta.chat%2F%230123456789ABCDEF0123456789ABCDEF01234567%26a%3Dalice%2540share.test%26n%3DAlice%26i%3Dinv%26s%3Dauth";
+    let post = |expires: &str| {
+        Request::builder()
+            .method("POST")
+            .uri("/share")
+            .header("content-type", "application/x-www-form-urlencoded")
+            .body(axum::body::Body::from(format!(
+                "url={url}&name=Alice&slug=shortlived&expires={expires}"
+            )))
+            .unwrap()
+    };
+    let view = || {
+        Request::builder()
+            .uri("/shortlived")
+            .body(axum::body::Body::empty())
+            .unwrap()
+    };
+
+    let resp = app.clone().oneshot(post("soon")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::BAD_REQUEST);
+
+    let resp = app.clone().oneshot(post("24h")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let sharing = init_sharing_db(&dir.path().join("sharing.db"))
+        .await
+        .unwrap();
+    let row = get_sharing_contact(&sharing, "shortlived")
+        .await
+        .unwrap()
+        .expect("contact row");
+    assert!(row.expires_at.is_some());
+    let resp = app.clone().oneshot(view()).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+
+    sqlx::query("UPDATE contacts SET expires_at = datetime('now', '-1 minute')")
+        .execute(&sharing)
+        .await
+        .unwrap();
+    let resp = app.clone().oneshot(view()).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::NOT_FOUND);
+
+    // The slug is free again once its link has expired.
+    let resp = app.oneshot(post("")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let row = get_sharing_contact(&sharing, "shortlived")
+        .await
+        .unwrap()
+        .expect("contact row");
+    assert!(row.expires_at.is_none());
+}
+
+/// Slug views are counted in memory (debounced per visitor) and listed on `/share/links`.
+#[tokio::test]
+async fn share_links_page_lists_view_counters() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::{create_sharing_contact, init_sharing_db, passwords};
+    use chatmail_state::AppState;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let mut cfg = AppConfig::default();
+    cfg.enable_contact_sharing = true;
+    let app_state = Arc::new(AppState::with_quota_and_message_limit(
+        dir.path(),
+        chatmail_config::DEFAULT_QUOTA_BYTES,
+        &cfg,
+        pool.clone(),
+    ));
+    let hash = hash_password("secret").unwrap();
+    passwords::create_user(&pool, "u@x.org", &hash)
+        .await
+        .unwrap();
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let sharing = init_sharing_db(&dir.path().join("sharing.db"))
+        .await
+        .unwrap();
+    create_sharing_contact(
+        &sharing,
+        "mine",
+        "https://i.delta.chat/#0123456789ABCDEF0123456789ABCDEF01234567&a=u%40x.org&i=1&s=2",
+        "Me",
+    )
+    .await
+    .unwrap();
+    create_sharing_contact(
+        &sharing,
+        "theirs",
+        "https://i.delta.chat/#0123456789ABCDEF0123456789ABCDEF01234567&a=v%40x.org&i=1&s=2",
+        "V",
+    )
+    .await
+    .unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        Arc::clone(&app_state),
+        cfg,
+        dir.path(),
+    ));
+
+    for _ in 0..3 {
+        let view = app
+            .clone()
+            .oneshot(
+                Request::get("/mine")
+                    .body(axum::body::Body::empty())
+                    .unwrap(),
+            )
+            .await
+            .unwrap();
+        assert_eq!(view.status(), StatusCode::OK);
+    }
+    assert_eq!(app_state.share_views.pending_views("mine"), 1);
+    app_state.share_views.flush().await.unwrap();
+
+    let resp = app
+        .clone()
+        .oneshot(
+            Request::get("/share/links")
+                .body(axum::body::Body::empty())
+                .unwrap(),
+        )
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::UNAUTHORIZED);
+    assert!(resp.headers().contains_key(header::WWW_AUTHENTICATE));
+
+    let auth = format!(
+        "Basic {}",
+        base64::engine::general_purpose::STANDARD.encode("u@x.org:secret")
+    );
+    let resp = app
+        .oneshot(
+            Request::get("/share/links")
+                .header(header::AUTHORIZATION, auth)
+                .body(axum::body::Body::empty())
+                .unwrap(),
+        )
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let body = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    let page = String::from_utf8_lossy(&body);
+    assert!(page.contains("/mine"));
+    assert!(!page.contains("/theirs"));
+    assert!(page.contains("<td>1</td>"));
+}
+
+#[tokio::test]
+async fn group_invite_share_renders_group_page() {
+    use axum::body::to_bytes;
+    use axum::http::{Request, StatusCode};
+    use tower::ServiceExt;
+
+    use chatmail_state::AppState;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let mut cfg = AppConfig::default();
+    cfg.enable_contact_sharing = true;
+    cfg.mail_domain = Some("share.test".into());
+
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        app_state,
+        cfg,
+        dir.path(),
+    ));
+    let post = |form: &'static str| {
+        Request::builder()
+            .method("POST")
+            .uri("/share")
+            .header("content-type", "application/x-www-form-urlencoded")
+            .body(axum::body::Body::from(form))
+            .unwrap()
+    };
+
+    // g= without x= is neither a contact nor a usable group invite.
+    let resp = app
+        .clone()
+        .oneshot(post(
+            "url=https%3A%2F%2Fi.delta.chat%2F%230123456789ABCDEF0123456789ABCDEF01234567%26a%3Db%2540x.org%26g%3DClub%26i%3D1%26s%3D2&slug=badgroup",
+        ))
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::BAD_REQUEST);
+
+    // Group-only fields on a contact invite are refused.
+    let resp = app
+        .clone()
+        .oneshot(post(
+            "url=https%3A%2F%2Fi.delta.chat%2F%230123456789ABCDEF0123456789ABCDEF01234567%26a%3Db%2540x.org%26i%3D1%26s%3D2&slug=notgroup&description=hi",
+        ))
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::BAD_REQUEST);
+
+    let resp = app
+        .clone()
+        .oneshot(post(
+            "url=https%3A%2F%2Fi.delta.chat%2F%230123456789ABCDEF0123456789ABCDEF01234567%26a%3Db%2540x.org%26g%3DChess%26x%3Dgrp42%26i%3D1%26s%3D2\
+             &name=Chess+Club&slug=chess&member_note=about+30\
+             &description=%3Cscript%3Ealert(1)%3C%2Fscript%3E+Weekly",
+        ))
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let body = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    assert!(String::from_utf8_lossy(&body).contains("share_success_group_text"));
+
+    let view = app
+        .oneshot(
+            Request::builder()
+                .uri("/chess")
+                .body(axum::body::Body::empty())
+                .unwrap(),
+        )
+        .await
+        .unwrap();
+    assert_eq!(view.status(), StatusCode::OK);
+    let view_body = to_bytes(view.into_body(), usize::MAX).await.unwrap();
+    let page = String::from_utf8_lossy(&view_body);
+    assert!(page.contains("Chess Club"));
+    assert!(page.contains("about 30"));
+    assert!(page.contains(r#"data-i18n="group_join""#));
+    assert!(!page.contains("contact_send"));
+    assert!(!page.contains("<script>alert(1)"));
+    assert!(page.contains("&lt;script&gt;alert(1)"));
+    assert!(page.contains("openpgp4fpr:0123456789ABCDEF0123456789ABCDEF01234567#"));
 }
 
 /// Regression for #94: share page must POST urlencoded fields, not bare FormData/multipart.
@@ -836,3 +1233,1864 @@ async fn new_account_cors_reflects_origin_when_browser_access_enabled() {
     let v: serde_json::Value = serde_json::from_slice(&bytes).unwrap();
     assert!(v.get("email").and_then(|e| e.as_str()).is_some());
 }
+
+struct FakeDisk(f64);
+
+impl chatmail_state::DiskProbe for FakeDisk {
+    fn usage(&self) -> Option<chatmail_state::DiskUsage> {
+        Some(chatmail_state::DiskUsage {
+            total_bytes: 1000,
+            available_bytes: 1000 - (self.0 * 10.0) as u64,
+        })
+    }
+}
+
+#[tokio::test]
+async fn new_account_refused_when_server_full() {
+    use axum::http::StatusCode;
+
+    let pool = init_memory_db().await.unwrap();
+    set_setting(&pool, settings_keys::MAX_ACCOUNTS, "1")
+        .await
+        .unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        Arc::clone(&app_state),
+        AppConfig::default(),
+        dir.path(),
+    ));
+
+    let (status, _, _) = post_new(&app, "192.0.2.1:1000", None).await;
+    assert_eq!(status, StatusCode::OK);
+    let (status, _, body) = post_new(&app, "192.0.2.1:1000", None).await;
+    assert_eq!(status, StatusCode::SERVICE_UNAVAILABLE);
+    assert_eq!(body["error"], "Server is full");
+
+    set_setting(&pool, settings_keys::MAX_ACCOUNTS, "0")
+        .await
+        .unwrap();
+    app_state.disk.refresh(&FakeDisk(90.0));
+    let (status, _, _) = post_new(&app, "192.0.2.1:1000", None).await;
+    assert_eq!(status, StatusCode::SERVICE_UNAVAILABLE);
+
+    app_state.disk.refresh(&FakeDisk(40.0));
+    let (status, _, _) = post_new(&app, "192.0.2.1:1000", None).await;
+    assert_eq!(status, StatusCode::OK);
+}
+
+async fn post_new(
+    app: &axum::Router,
+    peer: &str,
+    key: Option<&str>,
+) -> (
+    axum::http::StatusCode,
+    axum::http::HeaderMap,
+    serde_json::Value,
+) {
+    post_new_with(app, peer, key, &[]).await
+}
+
+async fn post_new_with(
+    app: &axum::Router,
+    peer: &str,
+    key: Option<&str>,
+    extra: &[(&str, &str)],
+) -> (
+    axum::http::StatusCode,
+    axum::http::HeaderMap,
+    serde_json::Value,
+) {
+    use axum::body::to_bytes;
+    use axum::extract::ConnectInfo;
+    use axum::http::Request;
+    use tower::ServiceExt;
+
+    let mut req = Request::builder()
+        .method("POST")
+        .uri("/new")
+        .header("host", "example.org");
+    if let Some(key) = key {
+        req = req.header("Idempotency-Key", key);
+    }
+    for (name, value) in extra {
+        req = req.header(*name, *value);
+    }
+    let mut req = req.body(axum::body::Body::empty()).unwrap();
+    req.extensions_mut()
+        .insert(ConnectInfo(peer.parse::<std::net::SocketAddr>().unwrap()));
+    let resp = app.clone().oneshot(req).await.unwrap();
+    let status = resp.status();
+    let headers = resp.headers().clone();
+    let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    (status, headers, serde_json::from_slice(&bytes).unwrap())
+}
+
+async fn idempotency_router(ttl: std::time::Duration) -> (axum::Router, tempfile::TempDir) {
+    // These tests send more than a burst from one /24; the limiter has its own tests.
+    let cfg = AppConfig {
+        reg_rate_limit: Some(0.0),
+        ..AppConfig::default()
+    };
+    idempotency_router_with(ttl, cfg).await
+}
+
+async fn idempotency_router_with(
+    ttl: std::time::Duration,
+    mut cfg: AppConfig,
+) -> (axum::Router, tempfile::TempDir) {
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    cfg.primary_domain = Some("example.org".into());
+    let app_state = Arc::new(AppState::with_quota_and_message_limit(
+        dir.path(),
+        chatmail_config::DEFAULT_QUOTA_BYTES,
+        &cfg,
+        pool.clone(),
+    ));
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let mut st = crate::WwwState::new(pool, app_state, cfg, dir.path());
+    st.idempotency = Arc::new(crate::idempotency::IdempotencyStore::with_ttl(ttl));
+    (crate::www_router(st), dir)
+}
+
+#[tokio::test]
+async fn new_account_picks_requested_mail_domain() {
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let cfg = parse_maddy_config(
+        "chatmail tcp://0.0.0.0:80 {\n    mail_domain example.org example.net\n}\n",
+    )
+    .unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let st = crate::WwwState::new(pool, app_state, cfg, dir.path());
+    assert!(st.serves_domain("example.net"));
+    let app = crate::www_router(st);
+
+    let (status, body) = send_new(&app, "POST", None).await;
+    assert_eq!(status, axum::http::StatusCode::OK);
+    assert!(body["email"].as_str().unwrap().ends_with("@example.org"));
+
+    let (status, body) = send_new(
+        &app,
+        "POST",
+        Some(serde_json::json!({"domain": "example.net"})),
+    )
+    .await;
+    assert_eq!(status, axum::http::StatusCode::OK);
+    assert!(body["email"].as_str().unwrap().ends_with("@example.net"));
+
+    let (status, body) = send_json(&app, "POST", "/new?domain=Example.NET", None).await;
+    assert_eq!(status, axum::http::StatusCode::OK);
+    assert!(body["email"].as_str().unwrap().ends_with("@example.net"));
+
+    let (status, body) = send_new(
+        &app,
+        "POST",
+        Some(serde_json::json!({"domain": "elsewhere.org"})),
+    )
+    .await;
+    assert_eq!(status, axum::http::StatusCode::BAD_REQUEST);
+    assert_eq!(body["reason"], "unknown_domain");
+}
+
+#[tokio::test]
+async fn new_account_idempotency_key_replays_first_response() {
+    let (app, _dir) = idempotency_router(std::time::Duration::from_secs(3600)).await;
+    let key = "6f1c2a8e-1d7b-4c3e-9a55-0b7d3f2e9c11";
+
+    let (status, headers, first) = post_new(&app, "198.51.100.7:40000", Some(key)).await;
+    assert_eq!(status, axum::http::StatusCode::OK);
+    assert_eq!(
+        headers.get("cache-control").and_then(|v| v.to_str().ok()),
+        Some("no-store")
+    );
+    let (_, _, retry) = post_new(&app, "198.51.100.7:40001", Some(key)).await;
+    assert_eq!(retry["email"], first["email"]);
+    assert_eq!(retry["password"], first["password"]);
+
+    let (_, _, other_key) = post_new(
+        &app,
+        "198.51.100.7:40002",
+        Some("0a9d1e55-7c44-4f0e-8d2b-6f9e3c7a1b20"),
+    )
+    .await;
+    assert_ne!(other_key["email"], first["email"]);
+
+    let (_, _, other_ip) = post_new(&app, "203.0.113.9:40000", Some(key)).await;
+    assert_ne!(other_ip["email"], first["email"], "keys are scoped per IP");
+
+    let (_, _, malformed) = post_new(&app, "198.51.100.7:40003", Some("bad key!")).await;
+    let (_, _, malformed2) = post_new(&app, "198.51.100.7:40004", Some("bad key!")).await;
+    assert_ne!(
+        malformed["email"], malformed2["email"],
+        "malformed keys are ignored"
+    );
+}
+
+#[tokio::test]
+async fn new_account_idempotency_window_expires() {
+    let (app, _dir) = idempotency_router(std::time::Duration::from_millis(50)).await;
+    let key = "2b7e1516-28ae-4d2a-abf7-158809cf4f3c";
+    let (_, _, first) = post_new(&app, "198.51.100.7:40000", Some(key)).await;
+    tokio::time::sleep(std::time::Duration::from_millis(80)).await;
+    let (status, _, later) = post_new(&app, "198.51.100.7:40000", Some(key)).await;
+    assert_eq!(status, axum::http::StatusCode::OK);
+    assert_ne!(later["email"], first["email"]);
+}
+
+/// `chatmail-client` against the real router: idempotent retries replay the first account.
+#[tokio::test]
+async fn registration_client_replays_with_idempotency_key() {
+    use chatmail_client::{ClientOptions, NewAccountRequest, RegistrationClient, RetryPolicy};
+
+    let (app, _dir) = idempotency_router(std::time::Duration::from_secs(3600)).await;
+    let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+    let addr = listener.local_addr().unwrap();
+    tokio::spawn(async move {
+        axum::serve(listener, app).await.unwrap();
+    });
+    let client = RegistrationClient::new(
+        &format!("http://{addr}"),
+        &ClientOptions::default().retry(RetryPolicy::none()),
+    )
+    .unwrap();
+
+    let req = NewAccountRequest {
+        idempotency_key: Some(chatmail_client::new_idempotency_key()),
+        ..Default::default()
+    };
+    let first = client.create_account(&req).await.unwrap();
+    let again = client.create_account(&req).await.unwrap();
+    assert_eq!(first.email, again.email);
+    assert_eq!(first.password, again.password);
+    assert!(first.dclogin_url.starts_with("dclogin:"));
+    let other = client
+        .create_account(&NewAccountRequest::default())
+        .await
+        .unwrap();
+    assert_ne!(other.email, first.email);
+
+    let bad_token = client
+        .create_account(&NewAccountRequest {
+            token: "no-such-token".into(),
+            idempotency_key: None,
+        })
+        .await
+        .unwrap_err();
+    assert_eq!(bad_token.status(), Some(403));
+
+    let info = client.server_info().await.unwrap();
+    assert!(!info.status.is_empty());
+}
+
+#[tokio::test]
+async fn new_account_client_ip_honours_trusted_cdn_only() {
+    let cfg = AppConfig {
+        trusted_cdn: Some("cloudflare".into()),
+        ..AppConfig::default()
+    };
+    let (app, _dir) = idempotency_router_with(std::time::Duration::from_secs(3600), cfg).await;
+    let key = "9c4d2f1a-5b6e-4a7c-8d9e-0f1a2b3c4d5e";
+    let edge = "162.158.10.20:443";
+
+    // Behind a Cloudflare edge, each CF-Connecting-IP is its own client.
+    let (_, _, a) = post_new_with(
+        &app,
+        edge,
+        Some(key),
+        &[("CF-Connecting-IP", "198.51.100.7")],
+    )
+    .await;
+    let (_, _, a2) = post_new_with(
+        &app,
+        edge,
+        Some(key),
+        &[("CF-Connecting-IP", "198.51.100.7")],
+    )
+    .await;
+    let (_, _, b) = post_new_with(
+        &app,
+        edge,
+        Some(key),
+        &[("CF-Connecting-IP", "203.0.113.9")],
+    )
+    .await;
+    assert_eq!(a2["email"], a["email"]);
+    assert_ne!(b["email"], a["email"]);
+
+    // A direct client cannot claim someone else's address.
+    let spoof = [
+        ("CF-Connecting-IP", "198.51.100.7"),
+        ("X-Forwarded-For", "198.51.100.7"),
+    ];
+    let (_, _, s1) = post_new_with(&app, "192.0.2.50:1000", Some(key), &spoof).await;
+    assert_ne!(s1["email"], a["email"], "spoofed header ignored");
+    let (_, _, s2) = post_new_with(&app, "192.0.2.50:1001", Some(key), &[]).await;
+    assert_eq!(s2["email"], s1["email"], "scoped to the real peer");
+}
+
+#[tokio::test]
+async fn new_account_rate_limited_per_subnet() {
+    let (app, _dir) =
+        idempotency_router_with(std::time::Duration::from_secs(3600), AppConfig::default()).await;
+    for host in 1..=3 {
+        let (status, _, _) = post_new(&app, &format!("198.51.100.{host}:40000"), None).await;
+        assert_eq!(status, axum::http::StatusCode::OK);
+    }
+    let (status, headers, body) = post_new(&app, "198.51.100.4:40000", None).await;
+    assert_eq!(status, axum::http::StatusCode::TOO_MANY_REQUESTS);
+    assert_eq!(
+        headers.get("retry-after").and_then(|v| v.to_str().ok()),
+        Some("1")
+    );
+    assert!(body["error"].as_str().unwrap().contains("try again later"));
+
+    // Another /24 has its own bucket.
+    for port in 0..3 {
+        let (status, _, _) = post_new(&app, &format!("198.51.101.1:{port}"), None).await;
+        assert_eq!(status, axum::http::StatusCode::OK);
+    }
+}
+
+async fn pow_router(ttl: std::time::Duration) -> (axum::Router, tempfile::TempDir) {
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let cfg = AppConfig {
+        primary_domain: Some("example.org".into()),
+        reg_rate_limit: Some(0.0),
+        ..AppConfig::default()
+    };
+    let app_state = Arc::new(AppState {
+        pow_challenges: Arc::new(chatmail_state::PowChallenges::new(8, ttl)),
+        ..AppState::with_quota_and_message_limit(
+            dir.path(),
+            chatmail_config::DEFAULT_QUOTA_BYTES,
+            &cfg,
+            pool.clone(),
+        )
+    });
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let st = crate::WwwState::new(pool, app_state, cfg, dir.path());
+    (crate::www_router(st), dir)
+}
+
+async fn send_new(
+    app: &axum::Router,
+    method: &str,
+    body: Option<serde_json::Value>,
+) -> (axum::http::StatusCode, serde_json::Value) {
+    send_json(app, method, "/new", body).await
+}
+
+async fn send_json(
+    app: &axum::Router,
+    method: &str,
+    uri: &str,
+    body: Option<serde_json::Value>,
+) -> (axum::http::StatusCode, serde_json::Value) {
+    use axum::body::to_bytes;
+    use axum::http::Request;
+    use tower::ServiceExt;
+
+    let req = Request::builder()
+        .method(method)
+        .uri(uri)
+        .header("host", "example.org")
+        .header("content-type", "application/json");
+    let body = body.map_or_else(axum::body::Body::empty, |v| v.to_string().into());
+    let resp = app.clone().oneshot(req.body(body).unwrap()).await.unwrap();
+    let status = resp.status();
+    let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    (status, serde_json::from_slice(&bytes).unwrap())
+}
+
+#[tokio::test]
+async fn new_account_requires_single_use_proof_of_work() {
+    use axum::http::StatusCode;
+
+    let (app, _dir) = pow_router(chatmail_state::POW_CHALLENGE_TTL).await;
+    let (status, _) = send_new(&app, "POST", None).await;
+    assert_eq!(status, StatusCode::FORBIDDEN, "no solution, no account");
+
+    let (status, challenge) = send_new(&app, "GET", None).await;
+    assert_eq!(status, StatusCode::OK);
+    assert_eq!(challenge["bits"], 8);
+    assert!(challenge["expires"].as_u64().is_some());
+    let nonce = challenge["nonce"].as_str().unwrap();
+    let solution = chatmail_state::proof_of_work::solve(nonce, 8);
+    let body = serde_json::json!({"challenge_nonce": nonce, "solution": solution});
+
+    let (status, account) = send_new(&app, "POST", Some(body.clone())).await;
+    assert_eq!(status, StatusCode::OK);
+    assert!(account["email"].as_str().is_some());
+    let (status, replay) = send_new(&app, "POST", Some(body)).await;
+    assert_eq!(status, StatusCode::FORBIDDEN);
+    assert!(replay["error"].as_str().unwrap().contains("already used"));
+
+    let (app, _dir) = pow_router(std::time::Duration::ZERO).await;
+    let (_, challenge) = send_new(&app, "GET", None).await;
+    let nonce = challenge["nonce"].as_str().unwrap();
+    let solution = chatmail_state::proof_of_work::solve(nonce, 8);
+    let body = serde_json::json!({"challenge_nonce": nonce, "solution": solution});
+    let (status, _) = send_new(&app, "POST", Some(body)).await;
+    assert_eq!(status, StatusCode::REQUEST_TIMEOUT);
+
+    // Off by default: no challenge to fetch.
+    let (app, _dir) = idempotency_router(std::time::Duration::from_secs(3600)).await;
+    let (status, _) = send_new(&app, "GET", None).await;
+    assert_eq!(status, StatusCode::NOT_FOUND);
+}
+
+#[tokio::test]
+async fn registration_burst_bans_client_ip() {
+    let cfg = AppConfig {
+        ip_ban_registrations: Some(2),
+        trusted_networks: Some("192.0.2.0/24".into()),
+        ..AppConfig::default()
+    };
+    let (app, _dir) = idempotency_router_with(std::time::Duration::from_secs(3600), cfg).await;
+    let (first, _, _) = post_new(&app, "198.51.100.7:40000", None).await;
+    let (second, _, _) = post_new(&app, "198.51.100.7:40001", None).await;
+    assert_eq!(first, axum::http::StatusCode::OK);
+    assert_eq!(second, axum::http::StatusCode::OK);
+    let (banned, _, body) = post_new(&app, "198.51.100.7:40002", None).await;
+    assert_eq!(banned, axum::http::StatusCode::FORBIDDEN);
+    assert_eq!(body["error"], "Forbidden");
+
+    let (other, _, _) = post_new(&app, "203.0.113.9:40000", None).await;
+    assert_eq!(other, axum::http::StatusCode::OK);
+    for port in 0..4 {
+        let (trusted, _, _) = post_new(&app, &format!("192.0.2.10:{port}"), None).await;
+        assert_eq!(
+            trusted,
+            axum::http::StatusCode::OK,
+            "trusted_networks exempt"
+        );
+    }
+}
+
+#[tokio::test]
+async fn recovery_code_resets_password_once_and_revokes_sessions() {
+    use axum::http::StatusCode;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let cfg = AppConfig {
+        primary_domain: Some("example.org".into()),
+        reg_rate_limit: Some(0.0),
+        recovery_codes: Some(3),
+        ..AppConfig::default()
+    };
+    let app_state = Arc::new(AppState::with_quota_and_message_limit(
+        dir.path(),
+        chatmail_config::DEFAULT_QUOTA_BYTES,
+        &cfg,
+        pool.clone(),
+    ));
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        Arc::clone(&app_state),
+        cfg,
+        dir.path(),
+    ));
+
+    let (status, account) = send_new(&app, "POST", Some(serde_json::json!({}))).await;
+    assert_eq!(status, StatusCode::OK);
+    let email = account["email"].as_str().unwrap().to_string();
+    let codes: Vec<String> = serde_json::from_value(account["recovery_codes"].clone()).unwrap();
+    assert_eq!(codes.len(), 3);
+    let login_generation = app_state.sessions.generation(&email);
+
+    // A wrong code and an unknown account get the very same answer.
+    let (wrong, wrong_body) = send_json(
+        &app,
+        "POST",
+        "/recover",
+        Some(serde_json::json!({"email": email, "code": "AAAA-AAAA-AAAA"})),
+    )
+    .await;
+    let (unknown, unknown_body) = send_json(
+        &app,
+        "POST",
+        "/recover",
+        Some(serde_json::json!({"email": "nobody@example.org", "code": codes[0]})),
+    )
+    .await;
+    assert_eq!(wrong, StatusCode::FORBIDDEN);
+    assert_eq!((unknown, unknown_body), (wrong, wrong_body.clone()));
+    assert!(!app_state.sessions.is_revoked(&email, login_generation));
+
+    let body = Some(serde_json::json!({"email": email, "code": codes[0]}));
+    let (status, recovered) = send_json(&app, "POST", "/recover", body.clone()).await;
+    assert_eq!(status, StatusCode::OK);
+    let password = recovered["password"].as_str().unwrap();
+    assert_ne!(password, account["password"].as_str().unwrap());
+    assert!(recovered["dclogin_url"]
+        .as_str()
+        .unwrap()
+        .starts_with("dclogin:"));
+    let hash = app_state.auth.get_hash(&email).unwrap();
+    assert!(chatmail_auth::verify_password(password, &hash).unwrap());
+    assert!(
+        app_state.sessions.is_revoked(&email, login_generation),
+        "open IMAP sessions are told to log out"
+    );
+    let audit = chatmail_db::list_account_audit(&pool, 10).await.unwrap();
+    assert_eq!(audit[0].username, email);
+    assert_eq!(audit[0].action, chatmail_db::AUDIT_RECOVERED);
+
+    let (replay, replay_body) = send_json(&app, "POST", "/recover", body).await;
+    assert_eq!((replay, replay_body), (wrong, wrong_body));
+}
+
+#[tokio::test]
+async fn token_only_registration_reports_reasons_and_caps_uses() {
+    use axum::http::StatusCode;
+    use chatmail_db::db_execute;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    set_setting(&pool, settings_keys::REGISTRATION_TOKEN_REQUIRED, "true")
+        .await
+        .unwrap();
+    db_execute!(
+        &pool,
+        "INSERT INTO registration_tokens (token, max_uses, used_count, comment)
+         VALUES ('v-one', 1, 0, '')"
+    )
+    .unwrap();
+    let cfg = AppConfig {
+        primary_domain: Some("example.org".into()),
+        reg_rate_limit: Some(0.0),
+        ..AppConfig::default()
+    };
+    let app_state = Arc::new(AppState::with_quota_and_message_limit(
+        dir.path(),
+        chatmail_config::DEFAULT_QUOTA_BYTES,
+        &cfg,
+        pool.clone(),
+    ));
+    let app = crate::www_router(crate::WwwState::new(pool, app_state, cfg, dir.path()));
+
+    let new = |body: serde_json::Value| {
+        let app = app.clone();
+        async move { send_new(&app, "POST", Some(body)).await }
+    };
+    let (status, body) = new(serde_json::json!({})).await;
+    assert_eq!(status, StatusCode::FORBIDDEN);
+    assert_eq!(body["reason"], "token_required");
+    let (status, body) = new(serde_json::json!({"voucher": "nope"})).await;
+    assert_eq!(status, StatusCode::FORBIDDEN);
+    assert_eq!(body["reason"], "token_not_found");
+
+    let (status, body) = new(serde_json::json!({"voucher": "v-one"})).await;
+    assert_eq!(status, StatusCode::OK, "{body}");
+    let (status, body) = new(serde_json::json!({"token": "v-one"})).await;
+    assert_eq!(status, StatusCode::FORBIDDEN);
+    assert_eq!(body["reason"], "token_exhausted");
+}
+
+#[tokio::test]
+async fn self_delete_account_removes_login_and_mail() {
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::{passwords, DbPool};
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let hash = hash_password("secret").unwrap();
+    for user in ["u@x.org", "v@x.org"] {
+        passwords::create_user(&pool, user, &hash).await.unwrap();
+        app_state.mailbox_store.init_user_dir(user).await.unwrap();
+    }
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let router = |allow: bool| {
+        let cfg = AppConfig {
+            allow_self_delete: allow,
+            ..AppConfig::default()
+        };
+        crate::www_router(crate::WwwState::new(
+            pool.clone(),
+            Arc::clone(&app_state),
+            cfg,
+            dir.path(),
+        ))
+    };
+    let delete = |app: axum::Router, creds: &str| {
+        let auth = format!(
+            "Basic {}",
+            base64::engine::general_purpose::STANDARD.encode(creds)
+        );
+        async move {
+            let req = Request::builder()
+                .method("DELETE")
+                .uri("/account")
+                .header(header::AUTHORIZATION, auth)
+                .body(axum::body::Body::empty())
+                .unwrap();
+            app.oneshot(req).await.unwrap().status()
+        }
+    };
+    let root = |user: &str| app_state.mailbox_store.user_root(user);
+
+    assert_eq!(
+        delete(router(false), "u@x.org:secret").await,
+        StatusCode::FORBIDDEN,
+        "off by default"
+    );
+    let app = router(true);
+    assert_eq!(
+        delete(app.clone(), "u@x.org:wrong").await,
+        StatusCode::UNAUTHORIZED
+    );
+    assert!(root("u@x.org").is_dir());
+
+    assert_eq!(
+        delete(app.clone(), "u@x.org:secret").await,
+        StatusCode::NO_CONTENT
+    );
+    assert!(!root("u@x.org").exists());
+    assert!(app_state.auth.get_hash("u@x.org").is_none());
+    assert!(app_state.auth.is_blocked("u@x.org"));
+    assert!(passwords::get_user_hash(&pool, "u@x.org")
+        .await
+        .unwrap()
+        .is_none());
+    assert_eq!(
+        delete(app.clone(), "u@x.org:secret").await,
+        StatusCode::FORBIDDEN,
+        "the name stays blocklisted"
+    );
+
+    // Credentials cannot be deleted: the mail moved aside is put back.
+    let DbPool::Sqlite(p) = &pool else {
+        panic!("memory db is sqlite");
+    };
+    sqlx::query("DROP TABLE passwords")
+        .execute(p)
+        .await
+        .unwrap();
+    assert_eq!(
+        delete(app, "v@x.org:secret").await,
+        StatusCode::INTERNAL_SERVER_ERROR
+    );
+    assert!(root("v@x.org").is_dir());
+    assert!(app_state.auth.get_hash("v@x.org").is_some());
+}
+
+#[tokio::test]
+async fn two_step_delete_needs_confirmation_and_limits_wrong_passwords() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::{create_sharing_contact, get_sharing_contact, passwords};
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let hash = hash_password("secret").unwrap();
+    for user in ["u@x.org", "v@x.org"] {
+        passwords::create_user(&pool, user, &hash).await.unwrap();
+    }
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let state = |self_service: Option<bool>| {
+        let cfg = AppConfig {
+            allow_self_delete: true,
+            enable_contact_sharing: true,
+            self_service,
+            ..AppConfig::default()
+        };
+        crate::WwwState::new(pool.clone(), Arc::clone(&app_state), cfg, dir.path())
+    };
+    let call = |app: axum::Router, method: &str, uri: &str, creds: &str, body: String| {
+        let req = Request::builder()
+            .method(method)
+            .uri(uri)
+            .header(header::CONTENT_TYPE, "application/json")
+            .header(
+                header::AUTHORIZATION,
+                format!(
+                    "Basic {}",
+                    base64::engine::general_purpose::STANDARD.encode(creds)
+                ),
+            )
+            .body(axum::body::Body::from(body))
+            .unwrap();
+        async move {
+            let resp = app.oneshot(req).await.unwrap();
+            let status = resp.status();
+            let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            let json = serde_json::from_slice(&bytes).unwrap_or(serde_json::Value::Null);
+            (status, json)
+        }
+    };
+
+    let closed = crate::www_router(state(Some(false)));
+    for (method, uri) in [
+        ("GET", "/account/delete"),
+        ("POST", "/account/delete"),
+        ("DELETE", "/account"),
+        ("POST", "/account/password"),
+        ("GET", "/account/vacation"),
+    ] {
+        let (status, _) = call(closed.clone(), method, uri, "u@x.org:secret", "{}".into()).await;
+        assert_eq!(
+            status,
+            StatusCode::FORBIDDEN,
+            "{method} {uri} with self_service off"
+        );
+    }
+
+    let st = state(None);
+    let sharing = st.sharing.clone().unwrap();
+    let share_db = sharing.pool().await.unwrap();
+    create_sharing_contact(
+        share_db,
+        "mine",
+        "https://i.delta.chat/#FP&a=u%40x.org",
+        "U",
+    )
+    .await
+    .unwrap();
+    let app = crate::www_router(st);
+
+    let (status, _) = call(
+        app.clone(),
+        "POST",
+        "/account/delete",
+        "u@x.org:secret",
+        "{}".into(),
+    )
+    .await;
+    assert_eq!(status, StatusCode::BAD_REQUEST, "no confirmation yet");
+    let (status, json) = call(
+        app.clone(),
+        "GET",
+        "/account/delete",
+        "u@x.org:secret",
+        "".into(),
+    )
+    .await;
+    assert_eq!(status, StatusCode::OK);
+    let token = json["confirm"].as_str().unwrap().to_string();
+    let confirm = serde_json::json!({ "confirm": token }).to_string();
+    let (status, _) = call(
+        app.clone(),
+        "POST",
+        "/account/delete",
+        "v@x.org:secret",
+        confirm.clone(),
+    )
+    .await;
+    assert_eq!(
+        status,
+        StatusCode::BAD_REQUEST,
+        "tokens belong to one account"
+    );
+    let (status, _) = call(
+        app.clone(),
+        "POST",
+        "/account/delete",
+        "u@x.org:secret",
+        confirm.clone(),
+    )
+    .await;
+    assert_eq!(status, StatusCode::NO_CONTENT);
+    assert!(passwords::get_user_hash(&pool, "u@x.org")
+        .await
+        .unwrap()
+        .is_none());
+    assert!(get_sharing_contact(share_db, "mine")
+        .await
+        .unwrap()
+        .is_none());
+
+    // Wrong passwords count per address; the right one is refused too once they run out.
+    for _ in 0..5 {
+        let (status, _) = call(
+            app.clone(),
+            "GET",
+            "/account/delete",
+            "v@x.org:wrong",
+            "".into(),
+        )
+        .await;
+        assert_eq!(status, StatusCode::UNAUTHORIZED);
+    }
+    let (status, _) = call(
+        app.clone(),
+        "GET",
+        "/account/delete",
+        "v@x.org:secret",
+        "".into(),
+    )
+    .await;
+    assert_eq!(status, StatusCode::TOO_MANY_REQUESTS);
+}
+
+#[tokio::test]
+async fn change_password_checks_credentials_and_policy() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::{hash_password, verify_password};
+    use chatmail_db::passwords;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    passwords::create_user(&pool, "u@x.org", &hash_password("old-secret").unwrap())
+        .await
+        .unwrap();
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        Arc::clone(&app_state),
+        AppConfig::default(),
+        dir.path(),
+    ));
+    let login_generation = app_state.sessions.generation("u@x.org");
+
+    // (basic auth, raw body, status, error substring)
+    let cases: &[(Option<&str>, &str, StatusCode, &str)] = &[
+        (
+            None,
+            r#"{"new_password":"new-Secret1"}"#,
+            StatusCode::UNAUTHORIZED,
+            "authentication required",
+        ),
+        (
+            Some("u@x.org:wrong"),
+            r#"{"new_password":"new-Secret1"}"#,
+            StatusCode::UNAUTHORIZED,
+            "invalid credentials",
+        ),
+        (
+            Some("nobody@x.org:old-secret"),
+            r#"{"new_password":"new-Secret1"}"#,
+            StatusCode::UNAUTHORIZED,
+            "invalid credentials",
+        ),
+        (
+            Some("u@x.org:old-secret"),
+            "not json",
+            StatusCode::BAD_REQUEST,
+            "JSON body",
+        ),
+        (
+            Some("u@x.org:old-secret"),
+            "{}",
+            StatusCode::BAD_REQUEST,
+            "new_password is required",
+        ),
+        (
+            Some("u@x.org:old-secret"),
+            r#"{"new_password":"short"}"#,
+            StatusCode::BAD_REQUEST,
+            "at least 8 characters",
+        ),
+        (
+            Some("u@x.org:old-secret"),
+            r#"{"new_password":"with spaces"}"#,
+            StatusCode::BAD_REQUEST,
+            "printable ASCII",
+        ),
+        (
+            Some("u@x.org:old-secret"),
+            r#"{"new_password":"new-Secret1"}"#,
+            StatusCode::OK,
+            "",
+        ),
+        // Idempotent: the same change again, now authenticated with the new password.
+        (
+            Some("u@x.org:new-Secret1"),
+            r#"{"new_password":"new-Secret1"}"#,
+            StatusCode::OK,
+            "",
+        ),
+        (
+            Some("u@x.org:old-secret"),
+            r#"{"new_password":"new-Secret1"}"#,
+            StatusCode::UNAUTHORIZED,
+            "invalid credentials",
+        ),
+    ];
+    for (i, (creds, body, want, err)) in cases.iter().enumerate() {
+        let mut req = Request::builder()
+            .method("POST")
+            .uri("/account/password")
+            .header("host", "example.org")
+            .header(header::CONTENT_TYPE, "application/json");
+        if let Some(creds) = creds {
+            let encoded = base64::engine::general_purpose::STANDARD.encode(creds);
+            req = req.header(header::AUTHORIZATION, format!("Basic {encoded}"));
+        }
+        let resp = app
+            .clone()
+            .oneshot(req.body(axum::body::Body::from(body.to_string())).unwrap())
+            .await
+            .unwrap();
+        let status = resp.status();
+        let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+        let json: serde_json::Value = serde_json::from_slice(&bytes).unwrap();
+        assert_eq!(status, *want, "case {i}: {json}");
+        if *want == StatusCode::OK {
+            assert_eq!(json["email"], "u@x.org", "case {i}");
+        } else {
+            assert!(
+                json["error"].as_str().unwrap().contains(err),
+                "case {i}: {json}"
+            );
+        }
+        if i == 6 {
+            assert!(
+                !app_state.sessions.is_revoked("u@x.org", login_generation),
+                "refused changes leave sessions alone"
+            );
+        }
+    }
+
+    let hash = passwords::get_user_hash(&pool, "u@x.org")
+        .await
+        .unwrap()
+        .unwrap();
+    assert!(verify_password("new-Secret1", &hash).unwrap());
+    assert!(!verify_password("old-secret", &hash).unwrap());
+    assert_eq!(
+        app_state.auth.get_hash("u@x.org").as_deref(),
+        Some(hash.as_str())
+    );
+    assert!(app_state.sessions.is_revoked("u@x.org", login_generation));
+    let audit = chatmail_db::list_account_audit(&pool, 10).await.unwrap();
+    assert_eq!(audit.len(), 2);
+    assert!(audit
+        .iter()
+        .all(|a| a.action == chatmail_db::AUDIT_PASSWORD_CHANGED && a.username == "u@x.org"));
+}
+
+#[tokio::test]
+async fn takeout_two_phase_download_is_single_use() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::passwords;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let hash = hash_password("secret").unwrap();
+    passwords::create_user(&pool, "u@x.org", &hash)
+        .await
+        .unwrap();
+    app_state.auth.hydrate(&pool).await.unwrap();
+    app_state
+        .mailbox_store
+        .init_user_dir("u@x.org")
+        .await
+        .unwrap();
+    chatmail_storage::write_blob(
+        &app_state.mailbox_store,
+        "u@x.org",
+        "m1",
+        b"Subject: a\r\n\r\nhi",
+    )
+    .await
+    .unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        app_state,
+        AppConfig::default(),
+        dir.path(),
+    ));
+
+    let basic = |creds: &str| {
+        format!(
+            "Basic {}",
+            base64::engine::general_purpose::STANDARD.encode(creds)
+        )
+    };
+    let send = |method: &str, uri: String, auth: Option<String>| {
+        let mut req = Request::builder().method(method).uri(uri);
+        if let Some(auth) = auth {
+            req = req.header(header::AUTHORIZATION, auth);
+        }
+        app.clone()
+            .oneshot(req.body(axum::body::Body::empty()).unwrap())
+    };
+
+    let resp = send("POST", "/takeout".into(), None).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::UNAUTHORIZED);
+    assert!(resp.headers().contains_key(header::WWW_AUTHENTICATE));
+    let resp = send("POST", "/takeout".into(), Some(basic("u@x.org:wrong")))
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::UNAUTHORIZED);
+
+    let resp = send("POST", "/takeout".into(), Some(basic("u@x.org:secret")))
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::ACCEPTED);
+    let job: serde_json::Value =
+        serde_json::from_slice(&to_bytes(resp.into_body(), usize::MAX).await.unwrap()).unwrap();
+    let url = job["download_url"].as_str().unwrap().to_string();
+    assert_eq!(job["token"].as_str().unwrap().len(), 32);
+
+    let mut ready = false;
+    for _ in 0..200 {
+        let resp = send("GET", "/takeout".into(), Some(basic("u@x.org:secret")))
+            .await
+            .unwrap();
+        let v: serde_json::Value =
+            serde_json::from_slice(&to_bytes(resp.into_body(), usize::MAX).await.unwrap()).unwrap();
+        if v["jobs"][0]["state"] == "ready" {
+            assert_eq!(v["jobs"][0]["messages_done"], 1);
+            ready = true;
+            break;
+        }
+        tokio::time::sleep(std::time::Duration::from_millis(10)).await;
+    }
+    assert!(ready, "export did not finish");
+
+    // No credentials needed for the download itself.
+    let resp = send("GET", url.clone(), None).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    assert_eq!(resp.headers()[header::CONTENT_TYPE], "application/zip");
+    let zip = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    assert!(zip.starts_with(b"PK\x03\x04"));
+
+    let resp = send("GET", url, None).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::NOT_FOUND, "single use");
+    let resp = send("GET", format!("/takeout/{:032x}", 7u128), None)
+        .await
+        .unwrap();
+    assert_eq!(resp.status(), StatusCode::NOT_FOUND);
+}
+
+#[tokio::test]
+async fn device_code_round_trip_issues_single_use_app_password() {
+    use axum::body::to_bytes;
+    use axum::http::{Request, StatusCode};
+    use tower::ServiceExt;
+
+    use chatmail_auth::{hash_password, DEVICE_CODE_LABEL};
+    use chatmail_db::{list_app_passwords, passwords};
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let cfg = AppConfig::default();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let hash = hash_password("secret").unwrap();
+    passwords::create_user(&pool, "u@x.org", &hash)
+        .await
+        .unwrap();
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        app_state,
+        cfg,
+        dir.path(),
+    ));
+
+    async fn call(
+        app: &axum::Router,
+        req: Request<axum::body::Body>,
+    ) -> (StatusCode, serde_json::Value) {
+        let resp = app.clone().oneshot(req).await.unwrap();
+        let status = resp.status();
+        let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+        (status, serde_json::from_slice(&bytes).unwrap())
+    }
+    let issue = |password: &str| {
+        Request::builder()
+            .method("POST")
+            .uri("/device-code")
+            .header("x-email", "u@x.org")
+            .header("x-password", password)
+            .body(axum::body::Body::empty())
+            .unwrap()
+    };
+    let redeem = |code: &str| {
+        Request::builder()
+            .method("POST")
+            .uri("/device-redeem")
+            .header("content-type", "application/json")
+            .body(axum::body::Body::from(
+                serde_json::json!({ "code": code }).to_string(),
+            ))
+            .unwrap()
+    };
+
+    let (status, _) = call(&app, issue("wrong")).await;
+    assert_eq!(status, StatusCode::UNAUTHORIZED);
+
+    let (status, body) = call(&app, issue("secret")).await;
+    assert_eq!(status, StatusCode::OK);
+    let code = body["code"].as_str().unwrap().to_string();
+    assert_eq!(body["expires_in"], 600);
+
+    let (status, body) = call(&app, redeem(&code.to_lowercase())).await;
+    assert_eq!(status, StatusCode::OK);
+    assert_eq!(body["email"], "u@x.org");
+    let app_password = body["password"].as_str().unwrap();
+    assert_ne!(app_password, "secret");
+
+    let listed = list_app_passwords(&pool, "u@x.org").await.unwrap();
+    assert_eq!(listed.len(), 1);
+    assert_eq!(listed[0].label, DEVICE_CODE_LABEL);
+
+    let (status, body) = call(&app, redeem(&code)).await;
+    assert_eq!(status, StatusCode::BAD_REQUEST);
+    assert_eq!(body["error"], "invalid or expired device code");
+    assert_eq!(list_app_passwords(&pool, "u@x.org").await.unwrap().len(), 1);
+
+    // Only the successful redemption is audited.
+    let audit = chatmail_db::list_account_audit(&pool, 10).await.unwrap();
+    assert_eq!(audit.len(), 1);
+    assert_eq!(audit[0].username, "u@x.org");
+    assert_eq!(audit[0].action, chatmail_db::AUDIT_DEVICE_REDEEMED);
+}
+
+#[tokio::test]
+async fn status_page_serves_cached_health_and_incidents() {
+    use axum::body::to_bytes;
+    use axum::http::{Request, StatusCode};
+    use tower::ServiceExt;
+
+    use chatmail_db::{record_incident, INCIDENT_OUTAGE};
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    // A broken custom site must not affect the built-in status page.
+    let site = tempfile::tempdir().unwrap();
+    std::fs::write(site.path().join("status.html"), "{{ broken").unwrap();
+    let mut cfg = AppConfig::default();
+    cfg.www_dir = Some(site.path().to_path_buf());
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let now = chatmail_db::policies::unix_now();
+    app_state.health.observe("imap", true, "", now);
+    app_state.health.observe("smtp", false, "refused", now);
+    record_incident(&pool, "smtp", INCIDENT_OUTAGE, "smtp <unavailable>")
+        .await
+        .unwrap();
+    let app = crate::www_router(crate::WwwState::new(pool, app_state, cfg, dir.path()));
+
+    let get = |uri: &str| {
+        Request::builder()
+            .uri(uri)
+            .body(axum::body::Body::empty())
+            .unwrap()
+    };
+    let resp = app.clone().oneshot(get("/status.json")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    let body: serde_json::Value = serde_json::from_slice(&bytes).unwrap();
+    assert_eq!(body["status"], "degraded");
+    assert!(body["uptime_seconds"].as_i64().unwrap() >= 0);
+    assert!(body["started_at"].is_i64());
+    assert_eq!(body["components"][0]["name"], "imap");
+    assert_eq!(body["components"][0]["state"], "operational");
+    assert_eq!(body["components"][1]["name"], "smtp");
+    assert_eq!(body["components"][1]["state"], "degraded");
+    assert!(body["components"][1].get("detail").is_none());
+    assert_eq!(body["incidents"][0]["kind"], "outage");
+    assert_eq!(body["incidents"][0]["component"], "smtp");
+
+    let resp = app.clone().oneshot(get("/status")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+    let html = String::from_utf8(bytes.to_vec()).unwrap();
+    assert!(html.contains("<td>smtp</td>"));
+    assert!(html.contains("smtp &lt;unavailable&gt;"));
+    assert!(!html.contains("{{ broken"));
+}
+
+#[tokio::test]
+async fn health_and_ready_probes() {
+    use axum::body::to_bytes;
+    use axum::http::{Request, StatusCode};
+    use tower::ServiceExt;
+
+    let get = |uri: &str| {
+        Request::builder()
+            .uri(uri)
+            .body(axum::body::Body::empty())
+            .unwrap()
+    };
+    let body = |resp: axum::response::Response| async move {
+        let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+        serde_json::from_slice::<serde_json::Value>(&bytes).unwrap()
+    };
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        app_state,
+        AppConfig::default(),
+        dir.path(),
+    ));
+    let resp = app.clone().oneshot(get("/health")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    assert_eq!(body(resp).await["status"], "ok");
+    let resp = app.clone().oneshot(get("/healthz")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let resp = app.clone().oneshot(get("/ready")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    let v = body(resp).await;
+    assert_eq!(v["status"], "ok");
+    assert_eq!(v["checks"]["database"], "ok");
+    assert_eq!(v["checks"]["storage"], "ok");
+    assert_eq!(v["checks"]["listeners"], "ok");
+
+    // Disabled by `health_checks off`.
+    let mut cfg = AppConfig::default();
+    cfg.health_checks = Some(false);
+    let off = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        Arc::new(AppState::new(dir.path(), pool.clone())),
+        cfg,
+        dir.path(),
+    ));
+    for uri in ["/health", "/healthz", "/ready", "/readyz"] {
+        let resp = off.clone().oneshot(get(uri)).await.unwrap();
+        assert_eq!(resp.status(), StatusCode::NOT_FOUND, "{uri}");
+    }
+
+    // A mail listener the supervisor probe reports down.
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    app_state.health.observe("imap", false, "refused", 0);
+    app_state.health.observe("imap", false, "refused", 600);
+    let imap_down = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        app_state,
+        AppConfig::default(),
+        dir.path(),
+    ));
+    let resp = imap_down.oneshot(get("/readyz")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::SERVICE_UNAVAILABLE);
+    let v = body(resp).await;
+    assert_eq!(v["reason"], "listeners: imap down");
+    assert_eq!(v["checks"]["database"], "ok");
+
+    // Mail root gone: not ready, but still alive.
+    let gone = tempfile::tempdir().unwrap();
+    let gone_path = gone.path().to_path_buf();
+    drop(gone);
+    let app_state = Arc::new(AppState::new(&gone_path, pool.clone()));
+    let no_storage = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        app_state,
+        AppConfig::default(),
+        dir.path(),
+    ));
+    let resp = no_storage.clone().oneshot(get("/ready")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::SERVICE_UNAVAILABLE);
+    let v = body(resp).await;
+    assert_eq!(v["status"], "degraded");
+    assert!(v["reason"].as_str().unwrap().starts_with("storage:"), "{v}");
+    assert_eq!(v["checks"]["database"], "ok");
+    let resp = no_storage.oneshot(get("/health")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+
+    // Database closed: not ready.
+    pool.close().await;
+    let resp = app.oneshot(get("/ready")).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::SERVICE_UNAVAILABLE);
+    let v = body(resp).await;
+    assert!(
+        v["reason"].as_str().unwrap().starts_with("database:"),
+        "{v}"
+    );
+}
+
+#[tokio::test]
+async fn cdn_cgi_paths_answer_quietly() {
+    use axum::body::to_bytes;
+    use axum::http::{Method, Request, StatusCode};
+    use tower::ServiceExt;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        Arc::new(AppState::new(dir.path(), pool)),
+        AppConfig::default(),
+        dir.path(),
+    ));
+    for (method, uri) in [
+        (Method::GET, "/cdn-cgi/trace"),
+        (Method::GET, "/cdn-cgi/challenge-platform/h/b"),
+        (Method::HEAD, "/cdn-cgi/healthcheck"),
+    ] {
+        let req = Request::builder()
+            .method(method)
+            .uri(uri)
+            .body(axum::body::Body::empty())
+            .unwrap();
+        let resp = app.clone().oneshot(req).await.unwrap();
+        assert_eq!(resp.status(), StatusCode::NO_CONTENT, "{uri}");
+        assert_eq!(resp.headers()["cache-control"], "no-store", "{uri}");
+        let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+        assert!(bytes.is_empty(), "{uri}");
+    }
+}
+
+#[tokio::test]
+async fn webimap_message_get_returns_503_while_memory_budget_exhausted() {
+    use axum::http::{Request, StatusCode};
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::passwords;
+    use chatmail_state::MemoryBudget;
+
+    let pool = init_memory_db().await.unwrap();
+    set_setting(&pool, settings_keys::WEBIMAP_ENABLED, "true")
+        .await
+        .unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState {
+        memory: Arc::new(MemoryBudget::new(1024)),
+        ..AppState::new(dir.path(), pool.clone())
+    });
+    let hash = hash_password("secret").unwrap();
+    passwords::create_user(&pool, "u@x.org", &hash)
+        .await
+        .unwrap();
+    app_state.auth.hydrate(&pool).await.unwrap();
+    chatmail_storage::write_blob(
+        &app_state.mailbox_store,
+        "u@x.org",
+        "m1",
+        b"From: a@x.org\r\nSubject: hi\r\n\r\nbody\r\n",
+    )
+    .await
+    .unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool,
+        Arc::clone(&app_state),
+        AppConfig::default(),
+        dir.path(),
+    ));
+    let get = || {
+        Request::builder()
+            .uri("/webimap/message/1")
+            .header("x-email", "u@x.org")
+            .header("x-password", "secret")
+            .body(axum::body::Body::empty())
+            .unwrap()
+    };
+
+    let held = app_state.memory.reserve(2048);
+    let resp = app.clone().oneshot(get()).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::SERVICE_UNAVAILABLE);
+    drop(held);
+    let resp = app.oneshot(get()).await.unwrap();
+    assert_eq!(resp.status(), StatusCode::OK);
+    assert_eq!(app_state.memory.rejections(), 1);
+    assert_eq!(app_state.memory.used(), 0);
+}
+
+/// `/sw.js` is never browser-cached and its cache name follows the precached files;
+/// `/manifest.webmanifest` is JSON built from config; `/offline.html` renders.
+#[tokio::test]
+async fn pwa_service_worker_versions_with_assets() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use tower::ServiceExt;
+
+    let site = tempfile::tempdir().unwrap();
+    std::fs::write(site.path().join("index.html"), "<p>{{ MailDomain }}</p>").unwrap();
+    std::fs::write(site.path().join("main.css"), "body { color: red; }").unwrap();
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let mut cfg = AppConfig::default();
+    cfg.www_dir = Some(site.path().to_path_buf());
+    cfg.hostname = Some("chat.example.org".into());
+    cfg.pwa_theme_color = Some("#0b7285".into());
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    let app = crate::www_router(crate::WwwState::new(pool, app_state, cfg, dir.path()));
+
+    let get = |uri: &'static str| {
+        let app = app.clone();
+        async move {
+            let resp = app
+                .oneshot(
+                    Request::builder()
+                        .uri(uri)
+                        .body(axum::body::Body::empty())
+                        .unwrap(),
+                )
+                .await
+                .unwrap();
+            assert_eq!(resp.status(), StatusCode::OK, "{uri}");
+            let headers = resp.headers().clone();
+            let body = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            (headers, String::from_utf8(body.to_vec()).unwrap())
+        }
+    };
+    let cache_name = |sw: &str| {
+        sw.lines()
+            .find(|l| l.starts_with("const CACHE = "))
+            .unwrap()
+            .to_string()
+    };
+
+    let (headers, sw) = get("/sw.js").await;
+    assert_eq!(
+        headers[header::CACHE_CONTROL],
+        crate::pwa::SERVICE_WORKER_CACHE_CONTROL
+    );
+    assert!(headers[header::CONTENT_TYPE]
+        .to_str()
+        .unwrap()
+        .starts_with("application/javascript"));
+    assert!(sw.contains(r#""/main.css""#) && sw.contains(r#""/new""#));
+    let (_, again) = get("/sw.js").await;
+    assert_eq!(
+        cache_name(&sw),
+        cache_name(&again),
+        "stable while nothing changes"
+    );
+
+    std::fs::write(site.path().join("main.css"), "body { color: blue; }").unwrap();
+    let (_, bumped) = get("/sw.js").await;
+    assert_ne!(
+        cache_name(&sw),
+        cache_name(&bumped),
+        "asset change bumps the cache"
+    );
+
+    let (headers, manifest) = get("/manifest.webmanifest").await;
+    assert_eq!(headers[header::CONTENT_TYPE], "application/manifest+json");
+    let manifest: serde_json::Value = serde_json::from_str(&manifest).unwrap();
+    assert_eq!(manifest["name"], "chat.example.org");
+    assert_eq!(manifest["theme_color"], "#0b7285");
+    assert_eq!(manifest["scope"], "/");
+
+    let (_, offline) = get("/offline.html").await;
+    assert!(offline.contains("offline_title"));
+}
+
+/// Every route class refuses oversized bodies (413) and wrong media types (415) before
+/// the handler runs, as HTML for forms and JSON elsewhere.
+#[tokio::test]
+async fn body_limits_per_route_class() {
+    use axum::body::{to_bytes, Body};
+    use axum::http::{header, Request, StatusCode};
+    use tower::ServiceExt;
+
+    use crate::body_limit::{FORM_BODY_LIMIT, JSON_BODY_LIMIT, UNLISTED_BODY_LIMIT};
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let mut cfg = AppConfig::default();
+    cfg.enable_contact_sharing = true;
+    cfg.mail_domain = Some("limits.test".into());
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    app_state
+        .message_size
+        .set_limit(&pool, &cfg, "1K")
+        .await
+        .unwrap();
+    let app = crate::www_router(crate::WwwState::new(pool, app_state, cfg, dir.path()));
+
+    let send = |path: &'static str, content_type: &'static str, body: Vec<u8>| {
+        let app = app.clone();
+        async move {
+            let resp = app
+                .oneshot(
+                    Request::builder()
+                        .method("POST")
+                        .uri(path)
+                        .header(header::CONTENT_TYPE, content_type)
+                        .body(Body::from(body))
+                        .unwrap(),
+                )
+                .await
+                .unwrap();
+            let status = resp.status();
+            let ct = resp.headers()[header::CONTENT_TYPE]
+                .to_str()
+                .unwrap()
+                .to_string();
+            let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            (status, ct, String::from_utf8_lossy(&bytes).into_owned())
+        }
+    };
+    let form = "application/x-www-form-urlencoded";
+    let json = "application/json";
+
+    let (status, ct, body) = send("/share", form, vec![b'a'; FORM_BODY_LIMIT + 1]).await;
+    assert_eq!(status, StatusCode::PAYLOAD_TOO_LARGE);
+    assert!(ct.starts_with("text/html"), "{ct}");
+    assert!(body.contains("413 Payload Too Large"), "{body}");
+
+    let (status, ct, _) = send("/new", json, vec![b' '; JSON_BODY_LIMIT + 1]).await;
+    assert_eq!(status, StatusCode::PAYLOAD_TOO_LARGE);
+    assert!(ct.starts_with("application/json"), "{ct}");
+    let (status, _, body) = send("/new", "text/plain", b"{}".to_vec()).await;
+    assert_eq!(status, StatusCode::UNSUPPORTED_MEDIA_TYPE);
+    assert!(body.contains("application/json"), "{body}");
+    let (status, _, _) = send("/device-redeem", form, b"code=1".to_vec()).await;
+    assert_eq!(status, StatusCode::UNSUPPORTED_MEDIA_TYPE);
+
+    // `/webimap/send` follows `max_message_size` (1K here), not the JSON cap.
+    let (status, _, body) = send("/webimap/send", json, vec![b' '; 80 * 1024]).await;
+    assert_eq!(status, StatusCode::PAYLOAD_TOO_LARGE);
+    assert!(body.contains("\"error\""), "{body}");
+    let (status, _, _) = send("/websmtp/send", "text/xml", b"<x/>".to_vec()).await;
+    assert_eq!(status, StatusCode::UNSUPPORTED_MEDIA_TYPE);
+
+    // Routes missing from `BODY_RULES` get the small default cap.
+    let (status, _, _) = send("/takeout", json, vec![b' '; UNLISTED_BODY_LIMIT + 1]).await;
+    assert_eq!(status, StatusCode::PAYLOAD_TOO_LARGE);
+
+    // Within the cap and with the right type, the handler runs as before.
+    let (status, _, _) = send("/new", json, b"{}".to_vec()).await;
+    assert_ne!(status, StatusCode::PAYLOAD_TOO_LARGE);
+    assert_ne!(status, StatusCode::UNSUPPORTED_MEDIA_TYPE);
+}
+
+#[tokio::test]
+async fn turn_credentials_need_login_and_expire() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::passwords;
+    use chatmail_turn::{verify_turn_password, TurnDiscovery};
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    passwords::create_user(&pool, "u@x.org", &hash_password("secret").unwrap())
+        .await
+        .unwrap();
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let router = |turn: Option<TurnDiscovery>| {
+        let st = crate::WwwState::new(
+            pool.clone(),
+            Arc::clone(&app_state),
+            AppConfig::default(),
+            dir.path(),
+        );
+        crate::www_router(st.with_turn(turn))
+    };
+    let post = |app: axum::Router, creds: Option<&str>| {
+        let mut req = Request::builder().method("POST").uri("/turn/credentials");
+        if let Some(creds) = creds {
+            let b64 = base64::engine::general_purpose::STANDARD.encode(creds);
+            req = req.header(header::AUTHORIZATION, format!("Basic {b64}"));
+        }
+        let req = req.body(axum::body::Body::empty()).unwrap();
+        async move {
+            let resp = app.oneshot(req).await.unwrap();
+            let status = resp.status();
+            let body = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            (
+                status,
+                serde_json::from_slice::<serde_json::Value>(&body).ok(),
+            )
+        }
+    };
+    let turn = TurnDiscovery::from_config(
+        true,
+        "turn.x.org".into(),
+        3478,
+        Some("turn-secret".into()),
+        600,
+        false,
+    );
+
+    let (status, _) = post(router(turn.clone()), None).await;
+    assert_eq!(status, StatusCode::UNAUTHORIZED);
+    let (status, _) = post(router(turn.clone()), Some("u@x.org:wrong")).await;
+    assert_eq!(status, StatusCode::UNAUTHORIZED);
+    let (status, _) = post(router(None), Some("u@x.org:secret")).await;
+    assert_eq!(status, StatusCode::NOT_FOUND, "TURN off");
+
+    let before = chatmail_db::policies::unix_now();
+    let (status, body) = post(router(turn), Some("u@x.org:secret")).await;
+    assert_eq!(status, StatusCode::OK);
+    let body = body.unwrap();
+    assert_eq!(body["ttl"], 600);
+    assert_eq!(body["uris"], serde_json::json!(["turn:turn.x.org:3478"]));
+    let username = body["username"].as_str().unwrap();
+    let password = body["password"].as_str().unwrap();
+    let (expiry, account) = username.split_once(':').unwrap();
+    assert_eq!(account, "u@x.org");
+    let expiry: i64 = expiry.parse().unwrap();
+    assert!(expiry >= before + 600);
+    assert!(verify_turn_password(
+        "turn-secret",
+        username,
+        password,
+        before
+    ));
+    assert!(
+        !verify_turn_password("turn-secret", username, password, expiry),
+        "stale once the expiry has passed"
+    );
+}
+
+#[tokio::test]
+async fn webfinger_describes_known_accounts_only() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::passwords;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    passwords::create_user(&pool, "u@x.org", &hash_password("secret").unwrap())
+        .await
+        .unwrap();
+    let config = AppConfig {
+        primary_domain: Some("x.org".into()),
+        webfinger_extra_links: vec![
+            r#"{"rel":"self","href":"https://x.org/u"}"#.into(),
+            "not json".into(),
+        ],
+        ..AppConfig::default()
+    };
+    let st = crate::WwwState::new(pool.clone(), Arc::clone(&app_state), config, dir.path());
+    let get = |resource: &str| {
+        let app = crate::www_router(st.clone());
+        let req = Request::builder()
+            .uri(format!("/.well-known/webfinger?resource={resource}"))
+            .body(axum::body::Body::empty())
+            .unwrap();
+        async move {
+            let resp = app.oneshot(req).await.unwrap();
+            let status = resp.status();
+            let content_type = resp.headers().get(header::CONTENT_TYPE).cloned();
+            let body = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            let body = serde_json::from_slice::<serde_json::Value>(&body).ok();
+            (status, content_type, body)
+        }
+    };
+
+    let (status, content_type, body) = get("acct:U@x.org").await;
+    assert_eq!(status, StatusCode::OK);
+    assert_eq!(content_type.unwrap(), "application/jrd+json");
+    let body = body.unwrap();
+    assert_eq!(body["subject"], "acct:u@x.org");
+    let links = body["links"].as_array().unwrap();
+    assert_eq!(links.len(), 3, "{links:?}");
+    assert_eq!(links[0]["rel"], crate::webfinger::ISSUER_REL);
+    assert_eq!(links[0]["href"], "https://x.org");
+    assert_eq!(links[1]["rel"], crate::webfinger::IMAP_REL);
+    assert!(links[1]["href"].as_str().unwrap().starts_with("imap"));
+    assert_eq!(
+        links[2],
+        serde_json::json!({ "rel": "self", "href": "https://x.org/u" })
+    );
+
+    let (status, _, _) = get("acct:nobody@x.org").await;
+    assert_eq!(status, StatusCode::NOT_FOUND);
+    let (status, _, _) = get("acct:u@elsewhere.org").await;
+    assert_eq!(status, StatusCode::NOT_FOUND);
+    let (status, _, _) = get("u@x.org").await;
+    assert_eq!(status, StatusCode::BAD_REQUEST);
+    let (status, _, _) = get("acct:").await;
+    assert_eq!(status, StatusCode::BAD_REQUEST);
+}
+
+#[tokio::test]
+async fn sieve_script_round_trip_and_validation() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::passwords;
+    use chatmail_state::SieveStore;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let hash = hash_password("secret").unwrap();
+    passwords::create_user(&pool, "u@x.org", &hash)
+        .await
+        .unwrap();
+    let router = |app_state: Arc<AppState>| {
+        crate::www_router(crate::WwwState::new(
+            pool.clone(),
+            app_state,
+            AppConfig::default(),
+            dir.path(),
+        ))
+    };
+    let call = |app: axum::Router, method: &str, body: &str| {
+        let req = Request::builder()
+            .method(method)
+            .uri("/account/sieve")
+            .header(header::CONTENT_TYPE, "application/sieve")
+            .header(
+                header::AUTHORIZATION,
+                format!(
+                    "Basic {}",
+                    base64::engine::general_purpose::STANDARD.encode("u@x.org:secret")
+                ),
+            )
+            .body(axum::body::Body::from(body.to_string()))
+            .unwrap();
+        async move {
+            let resp = app.oneshot(req).await.unwrap();
+            let status = resp.status();
+            let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            (status, String::from_utf8_lossy(&bytes).into_owned())
+        }
+    };
+
+    let off = router(Arc::new(AppState::new(dir.path(), pool.clone())));
+    assert_eq!(call(off, "GET", "").await.0, StatusCode::NOT_FOUND);
+
+    let app_state = Arc::new(AppState {
+        sieve: Arc::new(SieveStore::new(Some(dir.path().join("sieve")), 200)),
+        ..AppState::new(dir.path(), pool.clone())
+    });
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let app = router(app_state);
+    assert_eq!(call(app.clone(), "GET", "").await.0, StatusCode::NOT_FOUND);
+    let (status, body) = call(app.clone(), "PUT", r#"fileinto "Junk";"#).await;
+    assert_eq!(status, StatusCode::BAD_REQUEST);
+    assert!(body.contains("missing require"), "{body}");
+    let (status, _) = call(app.clone(), "PUT", &"#".repeat(201)).await;
+    assert_eq!(status, StatusCode::PAYLOAD_TOO_LARGE);
+
+    let script = r#"require "fileinto"; if header :contains "subject" "spam" { fileinto "Junk"; }"#;
+    assert_eq!(
+        call(app.clone(), "PUT", script).await.0,
+        StatusCode::NO_CONTENT
+    );
+    assert_eq!(
+        call(app.clone(), "GET", "").await,
+        (StatusCode::OK, script.to_string())
+    );
+    assert_eq!(
+        call(app.clone(), "DELETE", "").await.0,
+        StatusCode::NO_CONTENT
+    );
+    assert_eq!(call(app, "DELETE", "").await.0, StatusCode::NOT_FOUND);
+}
+
+#[tokio::test]
+async fn vacation_reply_round_trip_and_validation() {
+    use axum::body::to_bytes;
+    use axum::http::{header, Request, StatusCode};
+    use base64::Engine;
+    use tower::ServiceExt;
+
+    use chatmail_auth::hash_password;
+    use chatmail_db::passwords;
+
+    let pool = init_memory_db().await.unwrap();
+    let dir = tempfile::tempdir().unwrap();
+    let hash = hash_password("secret").unwrap();
+    passwords::create_user(&pool, "u@x.org", &hash)
+        .await
+        .unwrap();
+    let app_state = Arc::new(AppState::new(dir.path(), pool.clone()));
+    app_state.auth.hydrate(&pool).await.unwrap();
+    let app = crate::www_router(crate::WwwState::new(
+        pool.clone(),
+        Arc::clone(&app_state),
+        AppConfig::default(),
+        dir.path(),
+    ));
+    let call = |method: &str, body: serde_json::Value| {
+        let req = Request::builder()
+            .method(method)
+            .uri("/account/vacation")
+            .header(header::CONTENT_TYPE, "application/json")
+            .header(
+                header::AUTHORIZATION,
+                format!(
+                    "Basic {}",
+                    base64::engine::general_purpose::STANDARD.encode("u@x.org:secret")
+                ),
+            )
+            .body(axum::body::Body::from(body.to_string()))
+            .unwrap();
+        let app = app.clone();
+        async move {
+            let resp = app.oneshot(req).await.unwrap();
+            let status = resp.status();
+            let bytes = to_bytes(resp.into_body(), usize::MAX).await.unwrap();
+            let json = serde_json::from_slice(&bytes).unwrap_or(serde_json::Value::Null);
+            (status, json)
+        }
+    };
+
+    let none = serde_json::Value::Null;
+    assert_eq!(call("GET", none.clone()).await.0, StatusCode::NOT_FOUND);
+    for bad in [
+        serde_json::json!({ "body": "Away", "start": "next monday" }),
+        serde_json::json!({ "body": " ", "start": "2026-12-20T00:00:00Z" }),
+        serde_json::json!({
+            "body": "Away",
+            "start": "2026-12-20T00:00:00Z",
+            "end": "2026-12-20T00:00:00Z",
+        }),
+    ] {
+        let (status, _) = call("PUT", bad.clone()).await;
+        assert_eq!(status, StatusCode::BAD_REQUEST, "{bad}");
+    }
+
+    let (status, body) = call(
+        "PUT",
+        serde_json::json!({
+            "subject": "Away",
+            "body": "Back in January.",
+            "start": "2026-12-20T00:00:00+01:00",
+            "end": "2027-01-04T00:00:00Z",
+        }),
+    )
+    .await;
+    assert_eq!(status, StatusCode::OK, "{body}");
+    assert_eq!(body["start"], "2026-12-19T23:00:00Z");
+    assert_eq!(body["end"], "2027-01-04T00:00:00Z");
+    assert_eq!(body["active"], true);
+
+    // The delivery path sees the reply without waiting for the periodic refresh.
+    let start = 1_797_721_200; // 2026-12-19T23:00:00Z
+    let end = 1_799_020_800; // 2027-01-04T00:00:00Z
+    let replying = |now| app_state.responders.autoresponse("u@x.org", now).is_some();
+    assert!(!replying(start - 1));
+    assert!(replying(start));
+    assert!(replying(end - 1));
+    assert!(!replying(end));
+
+    let (status, body) = call("GET", none.clone()).await;
+    assert_eq!(status, StatusCode::OK);
+    assert_eq!(body["subject"], "Away");
+    assert_eq!(body["body"], "Back in January.");
+
+    assert_eq!(call("DELETE", none.clone()).await.0, StatusCode::NO_CONTENT);
+    assert!(!replying(start));
+    assert_eq!(call("DELETE", none).await.0, StatusCode::NOT_FOUND);
+}
@@ -0,0 +1,677 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Custom themes: validation and staged activation (`madmail theme`, `POST /admin/theme`).
+//!
+//! Installed themes live in `{state_dir}/themes/<id>/`. The `current` pointer file
+//! names the active one and `previous` the one it replaced; both are rewritten via
+//! temp file + rename, so a crash leaves either the old or the new theme active.
+//! An active theme takes precedence over `chatmail { www_dir }`. If it is missing
+//! or stops validating, the site falls back to `www_dir` and then to the embedded
+//! default; single templates that fail at render time fall back to their embedded
+//! counterpart (see [`crate::template::TemplateEngine::render`]).
+
+use std::fmt;
+use std::path::{Path, PathBuf};
+
+use chatmail_types::{ChatmailError, Result};
+use minijinja::{Environment, UndefinedBehavior};
+use serde::Serialize;
+
+use crate::app_links::AppLinks;
+use crate::go_template::prepare_template;
+use crate::template::{add_filters, CustomFields, SharedLink, WwwContext};
+use crate::theme_archive::{extract_theme_archive, theme_extension_allowed};
+
+/// Subdirectory of `state_dir` holding installed themes.
+pub const THEMES_DIR: &str = "themes";
+const CURRENT_POINTER: &str = "current";
+const PREVIOUS_POINTER: &str = "previous";
+const STAGING_PREFIX: &str = ".staging-";
+
+/// One problem found while validating a theme.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct ThemeIssue {
+    /// Path relative to the theme root.
+    pub file: String,
+    /// 1-based line in the template, when the template engine reports one.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub line: Option<usize>,
+    pub message: String,
+}
+
+impl fmt::Display for ThemeIssue {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self.line {
+            Some(line) => write!(f, "{}:{}: {}", self.file, line, self.message),
+            None => write!(f, "{}: {}", self.file, self.message),
+        }
+    }
+}
+
+/// Result of [`validate_theme_dir`].
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct ThemeReport {
+    /// HTML templates parsed and rendered.
+    pub templates: usize,
+    /// Static files (CSS, JS, images, fonts) that will be served as-is.
+    pub assets: usize,
+    /// Files outside the extension whitelist; never copied on install.
+    pub skipped: Vec<String>,
+    pub issues: Vec<ThemeIssue>,
+}
+
+impl ThemeReport {
+    pub fn is_ok(&self) -> bool {
+        self.issues.is_empty()
+    }
+
+    fn into_result(self) -> Result<Self> {
+        if self.is_ok() {
+            return Ok(self);
+        }
+        let lines: Vec<String> = self.issues.iter().map(ToString::to_string).collect();
+        Err(ChatmailError::config(format!(
+            "theme validation failed:\n  {}",
+            lines.join("\n  ")
+        )))
+    }
+}
+
+/// Context with every optional field set, so templates exercise all branches that
+/// reference them. Rendering uses semi-strict undefined handling: a misspelled
+/// field fails validation instead of printing nothing in production.
+fn fixture_context() -> WwwContext {
+    WwwContext {
+        MailDomain: "chat.example.org".into(),
+        MailDomains: vec!["chat.example.org".into()],
+        MXDomain: "mx.example.org".into(),
+        WebDomain: "chat.example.org".into(),
+        PublicIP: "203.0.113.7".into(),
+        Version: env!("CARGO_PKG_VERSION").to_string(),
+        RegistrationOpen: true,
+        JitRegistrationEnabled: true,
+        Language: "en".into(),
+        ClientHost: "chat.example.org".into(),
+        ImapPortTLS: "993".into(),
+        ImapPortStartTLS: "143".into(),
+        SmtpPortTLS: "465".into(),
+        SmtpPortStartTLS: "587".into(),
+        DcloginImapSecurity: "ssl".into(),
+        DcloginSmtpSecurity: "ssl".into(),
+        DefaultQuota: 1 << 30,
+        SSURL: "ss://Y2hhY2hhMjA6c2VjcmV0@chat.example.org:8388#chat".into(),
+        V2rayNGConfigWS: "vless://example-ws".into(),
+        V2rayNGConfigGRPC: "vless://example-grpc".into(),
+        MessageRetentionLine: Some("Messages are deleted after 20 days.".into()),
+        Custom: Some(CustomFields {
+            Slug: "alice".into(),
+            URL: "https://i.delta.chat/#0123456789ABCDEF&a=alice%40chat.example.org".into(),
+            Name: "Alice".into(),
+            Kind: "group".into(),
+            MemberNote: "Say hi".into(),
+            Description: "Example group".into(),
+            App: Some(AppLinks {
+                Platform: "android".into(),
+                OpenURL: "openpgp4fpr:0123456789ABCDEF".into(),
+                InviteURL: "https://i.delta.chat/#0123456789ABCDEF".into(),
+                FDroidURL: "https://f-droid.org/packages/com.b44t.messenger/".into(),
+                PlayURL: "https://play.google.com/store/apps/details?id=chat.delta".into(),
+                AppStoreURL: "https://apps.apple.com/app/id1459523234".into(),
+                FlathubURL: "https://flathub.org/apps/chat.delta.desktop".into(),
+                DesktopURL: "https://delta.chat/download".into(),
+            }),
+            Links: vec![SharedLink {
+                Slug: "alice".into(),
+                Name: "Alice".into(),
+                Kind: "contact".into(),
+                CreatedAt: "2026-01-01 12:00:00".into(),
+                ExpiresAt: "2026-01-08 12:00:00".into(),
+                Views: 12,
+                LastViewed: "2026-01-05".into(),
+            }],
+            Analytics: true,
+        }),
+    }
+}
+
+fn template_issue(file: &str, err: &minijinja::Error) -> ThemeIssue {
+    let mut message = err.kind().to_string();
+    if let Some(detail) = err.detail() {
+        message = format!("{message}: {detail}");
+    }
+    ThemeIssue {
+        file: file.to_string(),
+        line: err.line(),
+        message,
+    }
+}
+
+fn walk_theme_files(
+    root: &Path,
+    dir: &Path,
+    out: &mut Vec<(String, PathBuf)>,
+    issues: &mut Vec<ThemeIssue>,
+) -> Result<()> {
+    let mut entries = std::fs::read_dir(dir)?.collect::<std::io::Result<Vec<_>>>()?;
+    entries.sort_by_key(|e| e.file_name());
+    for entry in entries {
+        let path = entry.path();
+        let rel = path
+            .strip_prefix(root)
+            .unwrap_or(&path)
+            .to_string_lossy()
+            .replace('\\', "/");
+        let ft = entry.file_type()?;
+        if ft.is_symlink() {
+            issues.push(ThemeIssue {
+                file: rel,
+                line: None,
+                message: "symlinks are not allowed in themes".into(),
+            });
+        } else if ft.is_dir() {
+            walk_theme_files(root, &path, out, issues)?;
+        } else if ft.is_file() {
+            out.push((rel, path));
+        }
+    }
+    Ok(())
+}
+
+/// Parse every template in `dir` with the production filters and render it against
+/// fixture data. Problems are collected into the report rather than returned early,
+/// so one run lists everything that needs fixing.
+pub fn validate_theme_dir(dir: &Path) -> Result<ThemeReport> {
+    if !dir.is_dir() {
+        return Err(ChatmailError::config(format!(
+            "theme directory {} does not exist",
+            dir.display()
+        )));
+    }
+    let mut report = ThemeReport::default();
+    let mut files = Vec::new();
+    walk_theme_files(dir, dir, &mut files, &mut report.issues)?;
+
+    let mut env = Environment::new();
+    env.set_undefined_behavior(UndefinedBehavior::SemiStrict);
+    add_filters(&mut env);
+    let mut templates = Vec::new();
+    for (rel, path) in &files {
+        if !theme_extension_allowed(rel) {
+            report.skipped.push(rel.clone());
+            continue;
+        }
+        if !rel.to_ascii_lowercase().ends_with(".html") {
+            report.assets += 1;
+            continue;
+        }
+        let src = match std::fs::read_to_string(path) {
+            Ok(s) => s,
+            Err(e) => {
+                report.issues.push(ThemeIssue {
+                    file: rel.clone(),
+                    line: None,
+                    message: format!("not readable as UTF-8 text: {e}"),
+                });
+                continue;
+            }
+        };
+        match env.add_template_owned(rel.clone(), prepare_template(&src)) {
+            Ok(()) => templates.push(rel.clone()),
+            Err(e) => report.issues.push(template_issue(rel, &e)),
+        }
+    }
+
+    if !files.iter().any(|(rel, _)| rel == "index.html") {
+        report.issues.push(ThemeIssue {
+            file: "index.html".into(),
+            line: None,
+            message: "missing (every theme needs a landing page)".into(),
+        });
+    }
+
+    let ctx = fixture_context();
+    for name in &templates {
+        report.templates += 1;
+        let rendered = env.get_template(name).and_then(|t| t.render(&ctx));
+        if let Err(e) = rendered {
+            report.issues.push(template_issue(name, &e));
+        }
+    }
+    Ok(report)
+}
+
+/// Outcome of a successful install or rollback.
+#[derive(Debug, Clone, Serialize)]
+pub struct ThemeActivation {
+    /// Theme now active.
+    pub id: String,
+    /// Theme it replaced (target of the next rollback).
+    pub previous: Option<String>,
+    pub templates: usize,
+    pub skipped: Vec<String>,
+}
+
+/// Installed themes under `{state_dir}/themes`.
+#[derive(Debug, Clone)]
+pub struct ThemeStore {
+    root: PathBuf,
+}
+
+/// Theme ids are generated by [`ThemeStore`]; anything else in a pointer file is ignored.
+fn valid_theme_id(id: &str) -> bool {
+    !id.is_empty()
+        && id.len() <= 64
+        && id
+            .bytes()
+            .all(|b| b.is_ascii_alphanumeric() || b == b'-' || b == b'_')
+}
+
+fn new_theme_id() -> String {
+    let secs = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0);
+    format!("{secs}-{:08x}", rand::random::<u32>())
+}
+
+fn copy_theme_files(src: &Path, dest: &Path) -> Result<()> {
+    let mut files = Vec::new();
+    let mut issues = Vec::new();
+    walk_theme_files(src, src, &mut files, &mut issues)?;
+    for (rel, path) in files {
+        if !theme_extension_allowed(&rel) {
+            continue;
+        }
+        let out = dest.join(&rel);
+        if let Some(parent) = out.parent() {
+            std::fs::create_dir_all(parent)?;
+        }
+        std::fs::copy(&path, &out)?;
+    }
+    Ok(())
+}
+
+/// A zip whose files all sit under one top-level folder (`mytheme/index.html`) is
+/// installed from that folder.
+fn single_wrapped_dir(dir: &Path) -> Option<PathBuf> {
+    if dir.join("index.html").is_file() {
+        return None;
+    }
+    let mut entries = std::fs::read_dir(dir).ok()?;
+    let only = entries.next()?.ok()?;
+    if entries.next().is_some() || !only.file_type().ok()?.is_dir() {
+        return None;
+    }
+    Some(only.path())
+}
+
+impl ThemeStore {
+    pub fn new(state_dir: &Path) -> Self {
+        Self {
+            root: state_dir.join(THEMES_DIR),
+        }
+    }
+
+    pub fn root(&self) -> &Path {
+        &self.root
+    }
+
+    fn read_pointer(&self, name: &str) -> Option<String> {
+        let raw = std::fs::read_to_string(self.root.join(name)).ok()?;
+        let id = raw.trim();
+        (valid_theme_id(id) && self.root.join(id).is_dir()).then(|| id.to_string())
+    }
+
+    fn write_pointer(&self, name: &str, id: Option<&str>) -> Result<()> {
+        let path = self.root.join(name);
+        let Some(id) = id else {
+            return match std::fs::remove_file(&path) {
+                Err(e) if e.kind() != std::io::ErrorKind::NotFound => Err(e.into()),
+                _ => Ok(()),
+            };
+        };
+        let tmp = self.root.join(format!(".{name}.tmp"));
+        std::fs::write(&tmp, format!("{id}\n"))?;
+        std::fs::rename(&tmp, &path)?;
+        Ok(())
+    }
+
+    /// Active theme id.
+    pub fn current(&self) -> Option<String> {
+        self.read_pointer(CURRENT_POINTER)
+    }
+
+    /// Theme that [`ThemeStore::rollback`] would restore.
+    pub fn previous(&self) -> Option<String> {
+        self.read_pointer(PREVIOUS_POINTER)
+    }
+
+    /// Installed theme ids, oldest first.
+    pub fn list(&self) -> Vec<String> {
+        let mut ids: Vec<String> = std::fs::read_dir(&self.root)
+            .into_iter()
+            .flatten()
+            .flatten()
+            .filter(|e| e.file_type().is_ok_and(|t| t.is_dir()))
+            .filter_map(|e| e.file_name().into_string().ok())
+            .filter(|id| valid_theme_id(id))
+            .collect();
+        ids.sort();
+        ids
+    }
+
+    /// Directory of the active theme, if it still passes validation.
+    pub fn active_dir(&self) -> Option<PathBuf> {
+        let id = self.current()?;
+        let dir = self.root.join(&id);
+        match validate_theme_dir(&dir) {
+            Ok(report) if report.is_ok() => Some(dir),
+            Ok(report) => {
+                tracing::warn!(
+                    theme = %id,
+                    issues = report.issues.len(),
+                    "www: active theme no longer validates; falling back"
+                );
+                None
+            }
+            Err(e) => {
+                tracing::warn!(theme = %id, %e, "www: active theme unreadable; falling back");
+                None
+            }
+        }
+    }
+
+    /// Validate `src`, copy it into the store and make it active.
+    pub fn install_dir(&self, src: &Path) -> Result<ThemeActivation> {
+        validate_theme_dir(src)?.into_result()?;
+        let staging = self.staging_dir()?;
+        if let Err(e) = copy_theme_files(src, &staging) {
+            let _ = std::fs::remove_dir_all(&staging);
+            return Err(e);
+        }
+        self.commit_staged(&staging, &staging)
+    }
+
+    /// Extract a zip (see [`crate::theme_archive`]), validate it and make it active.
+    pub fn install_archive(&self, data: &[u8]) -> Result<ThemeActivation> {
+        let staging = self.staging_dir()?;
+        if let Err(e) = extract_theme_archive(data, &staging) {
+            let _ = std::fs::remove_dir_all(&staging);
+            return Err(e);
+        }
+        let theme_root = single_wrapped_dir(&staging).unwrap_or_else(|| staging.clone());
+        self.commit_staged(&staging, &theme_root)
+    }
+
+    /// Extract and validate an archive without installing it (dry run).
+    pub fn validate_archive(&self, data: &[u8]) -> Result<ThemeReport> {
+        let staging = self.staging_dir()?;
+        let report = extract_theme_archive(data, &staging).and_then(|_| {
+            let theme_root = single_wrapped_dir(&staging).unwrap_or_else(|| staging.clone());
+            validate_theme_dir(&theme_root)
+        });
+        let _ = std::fs::remove_dir_all(&staging);
+        report
+    }
+
+    fn staging_dir(&self) -> Result<PathBuf> {
+        std::fs::create_dir_all(&self.root)?;
+        let staging = self
+            .root
+            .join(format!("{STAGING_PREFIX}{:08x}", rand::random::<u32>()));
+        std::fs::create_dir(&staging)?;
+        Ok(staging)
+    }
+
+    /// Validate the staged copy (what will actually be served), move it into place
+    /// and flip the pointers. `theme_root` is `staging` or a folder inside it.
+    fn commit_staged(&self, staging: &Path, theme_root: &Path) -> Result<ThemeActivation> {
+        let report = match validate_theme_dir(theme_root).and_then(ThemeReport::into_result) {
+            Ok(r) => r,
+            Err(e) => {
+                let _ = std::fs::remove_dir_all(staging);
+                return Err(e);
+            }
+        };
+        let id = new_theme_id();
+        let final_dir = self.root.join(&id);
+        let moved = std::fs::rename(theme_root, &final_dir);
+        let _ = std::fs::remove_dir_all(staging);
+        moved?;
+        let previous = self.activate(&id)?;
+        self.prune();
+        Ok(ThemeActivation {
+            id,
+            previous,
+            templates: report.templates,
+            skipped: report.skipped,
+        })
+    }
+
+    /// Point `current` at `id`, remembering the old value in `previous`.
+    fn activate(&self, id: &str) -> Result<Option<String>> {
+        let old = self.current().filter(|old| old != id);
+        if let Some(ref old) = old {
+            self.write_pointer(PREVIOUS_POINTER, Some(old))?;
+        }
+        self.write_pointer(CURRENT_POINTER, Some(id))?;
+        Ok(old)
+    }
+
+    /// Swap `current` and `previous`. With no active theme (after
+    /// [`ThemeStore::deactivate`]) this re-activates the last one.
+    pub fn rollback(&self) -> Result<ThemeActivation> {
+        let target = self
+            .previous()
+            .ok_or_else(|| ChatmailError::config("no previous theme to roll back to"))?;
+        let report = validate_theme_dir(&self.root.join(&target))?.into_result()?;
+        let current = self.current();
+        self.write_pointer(CURRENT_POINTER, Some(&target))?;
+        self.write_pointer(PREVIOUS_POINTER, current.as_deref())?;
+        Ok(ThemeActivation {
+            id: target,
+            previous: current,
+            templates: report.templates,
+            skipped: report.skipped,
+        })
+    }
+
+    /// Stop serving the installed theme (site reverts to `www_dir` or embedded).
+    /// The theme stays on disk as the rollback target.
+    pub fn deactivate(&self) -> Result<Option<String>> {
+        let Some(current) = self.current() else {
+            return Ok(None);
+        };
+        self.write_pointer(PREVIOUS_POINTER, Some(&current))?;
+        self.write_pointer(CURRENT_POINTER, None)?;
+        Ok(Some(current))
+    }
+
+    /// Remove themes that are neither current nor previous. Staging dirs are left
+    /// alone: another install may be using one.
+    fn prune(&self) {
+        let keep = [self.current(), self.previous()];
+        let Ok(entries) = std::fs::read_dir(&self.root) else {
+            return;
+        };
+        for entry in entries.flatten() {
+            let name = entry.file_name().to_string_lossy().into_owned();
+            let stale = valid_theme_id(&name) && !keep.iter().flatten().any(|k| *k == name);
+            if stale && entry.file_type().is_ok_and(|t| t.is_dir()) {
+                let _ = std::fs::remove_dir_all(entry.path());
+            }
+        }
+    }
+}
+
+/// www root to serve: the active installed theme, else `configured` (`www_dir`),
+/// else `None` for the embedded default.
+pub fn effective_www_dir(state_dir: &Path, configured: Option<&Path>) -> Option<PathBuf> {
+    if let Some(dir) = ThemeStore::new(state_dir).active_dir() {
+        tracing::info!(path = %dir.display(), "www: serving installed theme");
+        return Some(dir);
+    }
+    configured.map(Path::to_path_buf)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::theme_archive::tests::build_zip;
+
+    fn write(dir: &Path, rel: &str, body: &str) {
+        let path = dir.join(rel);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(path, body).unwrap();
+    }
+
+    #[test]
+    fn embedded_site_validates_clean() {
+        let dir = tempfile::tempdir().unwrap();
+        crate::assets::WwwAssets::iter().for_each(|rel| {
+            let data = crate::assets::WwwAssets::get(&rel).unwrap();
+            let out = dir.path().join(rel.as_ref());
+            std::fs::create_dir_all(out.parent().unwrap()).unwrap();
+            std::fs::write(out, data.data.as_ref()).unwrap();
+        });
+        let report = validate_theme_dir(dir.path()).unwrap();
+        assert!(report.is_ok(), "{:?}", report.issues);
+        assert!(report.templates > 10);
+        assert_eq!(report.skipped, vec!["devserver.go".to_string()]);
+    }
+
+    #[test]
+    fn broken_template_reports_file_and_line() {
+        let dir = tempfile::tempdir().unwrap();
+        write(dir.path(), "index.html", "<h1>{{ MailDomain }}</h1>\n");
+        write(
+            dir.path(),
+            "docs/bad.html",
+            "<p>one</p>\n<p>two</p>\n{% if RegistrationOpen %}\n",
+        );
+        write(dir.path(), "typo.html", "<p>\n{{ MailDomian }}</p>\n");
+        write(
+            dir.path(),
+            "filter.html",
+            "{{ MailDomain | no_such_filter }}\n",
+        );
+        let report = validate_theme_dir(dir.path()).unwrap();
+        let files: Vec<&str> = report.issues.iter().map(|i| i.file.as_str()).collect();
+        assert_eq!(files, vec!["docs/bad.html", "filter.html", "typo.html"]);
+        assert!(report.issues[0].line.is_some());
+        assert_eq!(report.issues[2].line, Some(2));
+        assert!(report.issues[2].to_string().starts_with("typo.html:2:"));
+    }
+
+    #[test]
+    fn missing_index_is_an_issue() {
+        let dir = tempfile::tempdir().unwrap();
+        write(dir.path(), "info.html", "ok");
+        let report = validate_theme_dir(dir.path()).unwrap();
+        assert_eq!(report.issues.len(), 1);
+        assert_eq!(report.issues[0].file, "index.html");
+    }
+
+    #[test]
+    fn install_dir_rejects_broken_theme_and_keeps_current() {
+        let state = tempfile::tempdir().unwrap();
+        let store = ThemeStore::new(state.path());
+        let good = tempfile::tempdir().unwrap();
+        write(good.path(), "index.html", "<p>{{ WebDomain }}</p>");
+        write(good.path(), "notes.sh", "echo skipped");
+        let first = store.install_dir(good.path()).unwrap();
+        assert_eq!(first.skipped, vec!["notes.sh".to_string()]);
+        assert!(!state
+            .path()
+            .join(THEMES_DIR)
+            .join(&first.id)
+            .join("notes.sh")
+            .exists());
+
+        let bad = tempfile::tempdir().unwrap();
+        write(bad.path(), "index.html", "{% for %}");
+        let err = store.install_dir(bad.path()).unwrap_err().to_string();
+        assert!(err.contains("index.html:1"), "{err}");
+        assert_eq!(store.current(), Some(first.id.clone()));
+        assert_eq!(store.list(), vec![first.id]);
+    }
+
+    #[test]
+    fn archive_install_and_rollback() {
+        let state = tempfile::tempdir().unwrap();
+        let store = ThemeStore::new(state.path());
+        let v1 = store
+            .install_archive(&build_zip(&[("index.html", b"v1 {{ MailDomain }}")]))
+            .unwrap();
+        assert_eq!(v1.previous, None);
+        // Folder-wrapped archives install from the inner folder.
+        let v2 = store
+            .install_archive(&build_zip(&[
+                ("site/index.html", b"v2"),
+                ("site/main.css", b"body{}"),
+            ]))
+            .unwrap();
+        assert_eq!(v2.previous.as_deref(), Some(v1.id.as_str()));
+        let active = store.active_dir().unwrap();
+        assert_eq!(
+            std::fs::read_to_string(active.join("index.html")).unwrap(),
+            "v2"
+        );
+
+        // Traversal in an upload never touches the active theme.
+        assert!(store
+            .install_archive(&build_zip(&[("../index.html", b"evil")]))
+            .is_err());
+        assert_eq!(store.current(), Some(v2.id.clone()));
+
+        let back = store.rollback().unwrap();
+        assert_eq!(back.id, v1.id);
+        assert_eq!(store.previous(), Some(v2.id.clone()));
+        assert_eq!(
+            effective_www_dir(state.path(), None),
+            Some(state.path().join(THEMES_DIR).join(&v1.id))
+        );
+
+        assert_eq!(store.deactivate().unwrap(), Some(v1.id.clone()));
+        let fallback = PathBuf::from("/srv/www");
+        assert_eq!(
+            effective_www_dir(state.path(), Some(&fallback)),
+            Some(fallback)
+        );
+        assert_eq!(store.rollback().unwrap().id, v1.id);
+    }
+
+    #[test]
+    fn corrupted_active_theme_falls_back() {
+        let state = tempfile::tempdir().unwrap();
+        let store = ThemeStore::new(state.path());
+        let theme = store
+            .install_archive(&build_zip(&[("index.html", b"ok")]))
+            .unwrap();
+        std::fs::write(
+            state
+                .path()
+                .join(THEMES_DIR)
+                .join(&theme.id)
+                .join("index.html"),
+            "{% if %}",
+        )
+        .unwrap();
+        assert_eq!(effective_www_dir(state.path(), None), None);
+    }
+}
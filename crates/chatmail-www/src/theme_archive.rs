@@ -0,0 +1,450 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Zip extraction for uploaded themes (`POST /admin/theme`).
+//!
+//! Only what a theme needs is supported: stored and deflated entries without
+//! encryption or zip64. Every entry path is checked before anything is
+//! written — absolute paths, `..`, backslashes, symlinks and extensions
+//! outside [`THEME_EXTENSIONS`] reject the whole archive.
+
+use std::io::Read;
+use std::path::{Component, Path, PathBuf};
+
+use chatmail_types::{ChatmailError, Result};
+
+/// Largest accepted archive. Base64 in the admin envelope must stay under its 1 MiB body cap.
+pub const MAX_THEME_ARCHIVE_BYTES: usize = 700 * 1024;
+/// Upper bound on the sum of uncompressed entry sizes (zip-bomb guard).
+pub const MAX_THEME_EXTRACTED_BYTES: u64 = 16 * 1024 * 1024;
+/// Upper bound on entries (files and directories) in one archive.
+pub const MAX_THEME_ENTRIES: usize = 2000;
+
+/// File extensions a theme may contain (lowercase, without the dot).
+pub const THEME_EXTENSIONS: &[&str] = &[
+    "html", "css", "js", "svg", "png", "jpg", "jpeg", "gif", "webp", "ico", "woff", "woff2",
+    "json", "txt", "md",
+];
+
+const EOCD_SIG: u32 = 0x0605_4b50;
+const CENTRAL_SIG: u32 = 0x0201_4b50;
+const LOCAL_SIG: u32 = 0x0403_4b50;
+const EOCD_LEN: usize = 22;
+const CENTRAL_LEN: usize = 46;
+const LOCAL_LEN: usize = 30;
+
+/// `true` when `rel` ends in an extension from [`THEME_EXTENSIONS`].
+pub fn theme_extension_allowed(rel: &str) -> bool {
+    rel.rsplit_once('.')
+        .map(|(stem, ext)| {
+            !stem.is_empty()
+                && !stem.ends_with('/')
+                && THEME_EXTENSIONS
+                    .iter()
+                    .any(|allowed| ext.eq_ignore_ascii_case(allowed))
+        })
+        .unwrap_or(false)
+}
+
+/// Relative path for a zip entry, or an error when it could escape `dest`.
+pub fn sanitize_entry_path(name: &str) -> Result<PathBuf> {
+    let bad = |why: &str| ChatmailError::config(format!("theme archive entry {name:?}: {why}"));
+    if name.is_empty() {
+        return Err(bad("empty name"));
+    }
+    if name.contains('\\') {
+        return Err(bad("backslash in path"));
+    }
+    if name.contains('\0') {
+        return Err(bad("NUL in path"));
+    }
+    if name.starts_with('/') || name.as_bytes().get(1) == Some(&b':') {
+        return Err(bad("absolute path"));
+    }
+    let mut out = PathBuf::new();
+    for comp in Path::new(name.trim_end_matches('/')).components() {
+        match comp {
+            Component::Normal(part) => out.push(part),
+            Component::CurDir => {}
+            Component::ParentDir => return Err(bad("`..` in path")),
+            Component::RootDir | Component::Prefix(_) => return Err(bad("absolute path")),
+        }
+    }
+    if out.as_os_str().is_empty() {
+        return Err(bad("empty name"));
+    }
+    Ok(out)
+}
+
+struct Entry {
+    name: String,
+    flags: u16,
+    method: u16,
+    crc: u32,
+    compressed: u64,
+    uncompressed: u64,
+    unix_mode: u32,
+    local_offset: usize,
+}
+
+impl Entry {
+    fn is_dir(&self) -> bool {
+        self.name.ends_with('/')
+    }
+
+    fn is_symlink(&self) -> bool {
+        self.unix_mode & 0o170000 == 0o120000
+    }
+}
+
+fn u16_at(buf: &[u8], at: usize) -> Option<u16> {
+    Some(u16::from_le_bytes(buf.get(at..at + 2)?.try_into().ok()?))
+}
+
+fn u32_at(buf: &[u8], at: usize) -> Option<u32> {
+    Some(u32::from_le_bytes(buf.get(at..at + 4)?.try_into().ok()?))
+}
+
+fn corrupt(what: &str) -> ChatmailError {
+    ChatmailError::config(format!("theme archive is not a valid zip file: {what}"))
+}
+
+fn read_central_directory(data: &[u8]) -> Result<Vec<Entry>> {
+    if data.len() < EOCD_LEN {
+        return Err(corrupt("too short"));
+    }
+    // The end record sits in the last 22 bytes plus an optional comment of up to 64 KiB.
+    let floor = data.len().saturating_sub(EOCD_LEN + u16::MAX as usize);
+    let eocd = (floor..=data.len() - EOCD_LEN)
+        .rev()
+        .find(|&i| u32_at(data, i) == Some(EOCD_SIG))
+        .ok_or_else(|| corrupt("end of central directory not found"))?;
+    let disk = u16_at(data, eocd + 4).unwrap_or(1);
+    let cd_disk = u16_at(data, eocd + 6).unwrap_or(1);
+    let count = u16_at(data, eocd + 10).ok_or_else(|| corrupt("truncated end record"))?;
+    let cd_size = u32_at(data, eocd + 12).ok_or_else(|| corrupt("truncated end record"))?;
+    let cd_offset = u32_at(data, eocd + 16).ok_or_else(|| corrupt("truncated end record"))?;
+    if disk != 0 || cd_disk != 0 {
+        return Err(corrupt("multi-volume archives are not supported"));
+    }
+    if count == u16::MAX || cd_size == u32::MAX || cd_offset == u32::MAX {
+        return Err(corrupt("zip64 archives are not supported"));
+    }
+    if count as usize > MAX_THEME_ENTRIES {
+        return Err(ChatmailError::config(format!(
+            "theme archive has {count} entries (limit {MAX_THEME_ENTRIES})"
+        )));
+    }
+    let cd_end = (cd_offset as usize)
+        .checked_add(cd_size as usize)
+        .filter(|&end| end <= eocd)
+        .ok_or_else(|| corrupt("central directory out of bounds"))?;
+
+    let mut entries = Vec::with_capacity(count as usize);
+    let mut at = cd_offset as usize;
+    for _ in 0..count {
+        if at + CENTRAL_LEN > cd_end || u32_at(data, at) != Some(CENTRAL_SIG) {
+            return Err(corrupt("bad central directory entry"));
+        }
+        let name_len = u16_at(data, at + 28).unwrap_or(0) as usize;
+        let extra_len = u16_at(data, at + 30).unwrap_or(0) as usize;
+        let comment_len = u16_at(data, at + 32).unwrap_or(0) as usize;
+        let name_bytes = data
+            .get(at + CENTRAL_LEN..at + CENTRAL_LEN + name_len)
+            .ok_or_else(|| corrupt("truncated entry name"))?;
+        let name = std::str::from_utf8(name_bytes)
+            .map_err(|_| corrupt("entry name is not UTF-8"))?
+            .to_string();
+        let compressed = u32_at(data, at + 20).unwrap_or(0);
+        let uncompressed = u32_at(data, at + 24).unwrap_or(0);
+        let local_offset = u32_at(data, at + 42).unwrap_or(0);
+        if compressed == u32::MAX || uncompressed == u32::MAX || local_offset == u32::MAX {
+            return Err(corrupt("zip64 archives are not supported"));
+        }
+        // Upper 16 bits of the external attributes hold the Unix mode when made on Unix.
+        let made_by_unix = u16_at(data, at + 4).unwrap_or(0) >> 8 == 3;
+        let unix_mode = if made_by_unix {
+            u32_at(data, at + 38).unwrap_or(0) >> 16
+        } else {
+            0
+        };
+        entries.push(Entry {
+            name,
+            flags: u16_at(data, at + 8).unwrap_or(0),
+            method: u16_at(data, at + 10).unwrap_or(0),
+            crc: u32_at(data, at + 16).unwrap_or(0),
+            compressed: compressed as u64,
+            uncompressed: uncompressed as u64,
+            unix_mode,
+            local_offset: local_offset as usize,
+        });
+        at += CENTRAL_LEN + name_len + extra_len + comment_len;
+    }
+    Ok(entries)
+}
+
+fn entry_data<'a>(data: &'a [u8], entry: &Entry) -> Result<&'a [u8]> {
+    let at = entry.local_offset;
+    if u32_at(data, at) != Some(LOCAL_SIG) {
+        return Err(corrupt(&format!("bad local header for {}", entry.name)));
+    }
+    let name_len = u16_at(data, at + 26).unwrap_or(0) as usize;
+    let extra_len = u16_at(data, at + 28).unwrap_or(0) as usize;
+    let start = at + LOCAL_LEN + name_len + extra_len;
+    data.get(start..start + entry.compressed as usize)
+        .ok_or_else(|| corrupt(&format!("truncated data for {}", entry.name)))
+}
+
+fn inflate(entry: &Entry, raw: &[u8]) -> Result<Vec<u8>> {
+    let mut out = Vec::with_capacity(entry.uncompressed as usize);
+    match entry.method {
+        0 => out.extend_from_slice(raw),
+        8 => {
+            // Read one byte past the declared size so a lying header is caught.
+            flate2::read::DeflateDecoder::new(raw)
+                .take(entry.uncompressed + 1)
+                .read_to_end(&mut out)
+                .map_err(|e| corrupt(&format!("{}: {e}", entry.name)))?;
+        }
+        other => {
+            return Err(ChatmailError::config(format!(
+                "theme archive entry {:?}: compression method {other} is not supported \
+                 (use stored or deflate)",
+                entry.name
+            )))
+        }
+    }
+    if out.len() as u64 != entry.uncompressed {
+        return Err(corrupt(&format!("{}: size mismatch", entry.name)));
+    }
+    let mut crc = flate2::Crc::new();
+    crc.update(&out);
+    if crc.sum() != entry.crc {
+        return Err(corrupt(&format!("{}: CRC mismatch", entry.name)));
+    }
+    Ok(out)
+}
+
+/// Extract a theme zip into `dest` (which must be empty or missing).
+///
+/// All entries are checked before the first file is written, so a rejected
+/// archive leaves nothing behind but an empty `dest`. Returns the number of
+/// files written.
+pub fn extract_theme_archive(data: &[u8], dest: &Path) -> Result<usize> {
+    if data.len() > MAX_THEME_ARCHIVE_BYTES {
+        return Err(ChatmailError::config(format!(
+            "theme archive is {} bytes (limit {MAX_THEME_ARCHIVE_BYTES})",
+            data.len()
+        )));
+    }
+    let entries = read_central_directory(data)?;
+
+    let mut files = Vec::new();
+    let mut total = 0u64;
+    for entry in &entries {
+        let rel = sanitize_entry_path(&entry.name)?;
+        if entry.is_symlink() {
+            return Err(ChatmailError::config(format!(
+                "theme archive entry {:?}: symlinks are not allowed",
+                entry.name
+            )));
+        }
+        if entry.is_dir() {
+            continue;
+        }
+        if entry.flags & 1 != 0 {
+            return Err(ChatmailError::config(format!(
+                "theme archive entry {:?}: encrypted entries are not supported",
+                entry.name
+            )));
+        }
+        if !theme_extension_allowed(&entry.name) {
+            return Err(ChatmailError::config(format!(
+                "theme archive entry {:?}: file type not allowed (allowed: {})",
+                entry.name,
+                THEME_EXTENSIONS.join(", ")
+            )));
+        }
+        total = total.saturating_add(entry.uncompressed);
+        if total > MAX_THEME_EXTRACTED_BYTES {
+            return Err(ChatmailError::config(format!(
+                "theme archive expands past {MAX_THEME_EXTRACTED_BYTES} bytes"
+            )));
+        }
+        files.push((rel, entry));
+    }
+
+    let mut decoded = Vec::with_capacity(files.len());
+    for (rel, entry) in files {
+        let raw = entry_data(data, entry)?;
+        decoded.push((rel, inflate(entry, raw)?));
+    }
+
+    std::fs::create_dir_all(dest)?;
+    for (rel, bytes) in &decoded {
+        let path = dest.join(rel);
+        if let Some(parent) = path.parent() {
+            std::fs::create_dir_all(parent)?;
+        }
+        // `create_new` rejects duplicate names instead of silently overwriting.
+        let mut f = std::fs::OpenOptions::new()
+            .write(true)
+            .create_new(true)
+            .open(&path)
+            .map_err(|e| {
+                ChatmailError::config(format!("theme archive entry {}: {e}", rel.display()))
+            })?;
+        std::io::Write::write_all(&mut f, bytes)?;
+    }
+    Ok(decoded.len())
+}
+
+#[cfg(test)]
+pub(crate) mod tests {
+    use super::*;
+
+    /// Minimal stored-only zip writer for tests.
+    pub(crate) fn build_zip(files: &[(&str, &[u8])]) -> Vec<u8> {
+        let mut out = Vec::new();
+        let mut central = Vec::new();
+        for (name, body) in files {
+            let mut crc = flate2::Crc::new();
+            crc.update(body);
+            let offset = out.len() as u32;
+            out.extend_from_slice(&LOCAL_SIG.to_le_bytes());
+            out.extend_from_slice(&[20, 0, 0, 0, 0, 0, 0, 0, 0, 0]);
+            out.extend_from_slice(&crc.sum().to_le_bytes());
+            out.extend_from_slice(&(body.len() as u32).to_le_bytes());
+            out.extend_from_slice(&(body.len() as u32).to_le_bytes());
+            out.extend_from_slice(&(name.len() as u16).to_le_bytes());
+            out.extend_from_slice(&0u16.to_le_bytes());
+            out.extend_from_slice(name.as_bytes());
+            out.extend_from_slice(body);
+
+            central.extend_from_slice(&CENTRAL_SIG.to_le_bytes());
+            central.extend_from_slice(&[20, 0, 20, 0, 0, 0, 0, 0, 0, 0, 0, 0]);
+            central.extend_from_slice(&crc.sum().to_le_bytes());
+            central.extend_from_slice(&(body.len() as u32).to_le_bytes());
+            central.extend_from_slice(&(body.len() as u32).to_le_bytes());
+            central.extend_from_slice(&(name.len() as u16).to_le_bytes());
+            central.extend_from_slice(&[0; 12]);
+            central.extend_from_slice(&offset.to_le_bytes());
+            central.extend_from_slice(name.as_bytes());
+        }
+        let cd_offset = out.len() as u32;
+        out.extend_from_slice(&central);
+        out.extend_from_slice(&EOCD_SIG.to_le_bytes());
+        out.extend_from_slice(&[0, 0, 0, 0]);
+        out.extend_from_slice(&(files.len() as u16).to_le_bytes());
+        out.extend_from_slice(&(files.len() as u16).to_le_bytes());
+        out.extend_from_slice(&(central.len() as u32).to_le_bytes());
+        out.extend_from_slice(&cd_offset.to_le_bytes());
+        out.extend_from_slice(&0u16.to_le_bytes());
+        out
+    }
+
+    #[test]
+    fn extracts_nested_files() {
+        let zip = build_zip(&[("index.html", b"<p>hi</p>"), ("css/site.css", b"body{}")]);
+        let dir = tempfile::tempdir().unwrap();
+        let dest = dir.path().join("out");
+        assert_eq!(extract_theme_archive(&zip, &dest).unwrap(), 2);
+        assert_eq!(
+            std::fs::read_to_string(dest.join("css/site.css")).unwrap(),
+            "body{}"
+        );
+    }
+
+    #[test]
+    fn rejects_path_traversal_before_writing() {
+        for bad in [
+            "../escape.html",
+            "a/../../escape.html",
+            "/etc/x.html",
+            "a\\..\\x.html",
+            "C:/x.html",
+        ] {
+            let zip = build_zip(&[("index.html", b"ok"), (bad, b"pwned")]);
+            let dir = tempfile::tempdir().unwrap();
+            let dest = dir.path().join("out");
+            let err = extract_theme_archive(&zip, &dest).unwrap_err().to_string();
+            assert!(err.contains("theme archive entry"), "{bad}: {err}");
+            assert!(!dest.join("index.html").exists(), "{bad}: wrote files");
+            assert!(!dir.path().join("escape.html").exists());
+        }
+    }
+
+    #[test]
+    fn rejects_disallowed_extensions() {
+        let zip = build_zip(&[("index.html", b"ok"), ("run.sh", b"#!/bin/sh")]);
+        let dir = tempfile::tempdir().unwrap();
+        let err = extract_theme_archive(&zip, dir.path())
+            .unwrap_err()
+            .to_string();
+        assert!(err.contains("file type not allowed"), "{err}");
+        assert!(!theme_extension_allowed(".html"));
+        assert!(theme_extension_allowed("img/Logo.PNG"));
+    }
+
+    #[test]
+    fn rejects_oversized_and_corrupt_archives() {
+        let dir = tempfile::tempdir().unwrap();
+        let big = vec![0u8; MAX_THEME_ARCHIVE_BYTES + 1];
+        assert!(extract_theme_archive(&big, dir.path())
+            .unwrap_err()
+            .to_string()
+            .contains("limit"));
+        assert!(extract_theme_archive(b"not a zip at all, sorry", dir.path()).is_err());
+
+        let mut zip = build_zip(&[("index.html", b"hello")]);
+        // Flip a data byte so the CRC no longer matches.
+        let pos = zip.windows(5).position(|w| w == b"hello").unwrap();
+        zip[pos] = b'j';
+        let err = extract_theme_archive(&zip, &dir.path().join("crc"))
+            .unwrap_err()
+            .to_string();
+        assert!(err.contains("CRC"), "{err}");
+    }
+
+    #[test]
+    fn inflates_deflated_entries() {
+        use std::io::Write;
+        let body = b"<html>".repeat(100);
+        let mut enc =
+            flate2::write::DeflateEncoder::new(Vec::new(), flate2::Compression::default());
+        enc.write_all(&body).unwrap();
+        let raw = enc.finish().unwrap();
+        let mut crc = flate2::Crc::new();
+        crc.update(&body);
+        let entry = Entry {
+            name: "index.html".into(),
+            flags: 0,
+            method: 8,
+            crc: crc.sum(),
+            compressed: raw.len() as u64,
+            uncompressed: body.len() as u64,
+            unix_mode: 0,
+            local_offset: 0,
+        };
+        assert_eq!(inflate(&entry, &raw).unwrap(), body);
+        let lying = Entry {
+            uncompressed: 10,
+            ..entry
+        };
+        assert!(inflate(&lying, &raw).is_err());
+    }
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! `POST /turn/credentials` (Basic auth): short-lived TURN REST credentials for the caller.
+//!
+//! The username is `<unix-expiry>:<account>` and the password
+//! `base64(HMAC-SHA1(turn_secret, username))`, the scheme the embedded TURN relay checks.
+//! Secret, port and lifetime are those IMAP METADATA advertises (`turn_secret` / `turn_ttl`,
+//! overridden by `__TURN_SECRET__` / `__TURN_TTL__`); see [`WwwState::turn`].
+
+use axum::extract::State;
+use axum::http::{header, HeaderMap, HeaderValue, StatusCode};
+use axum::response::Response;
+use serde_json::json;
+
+use crate::handlers::basic_authenticate;
+use crate::response::{json_err, json_ok};
+use crate::WwwState;
+
+/// POST `/turn/credentials` → `{"username", "password", "ttl", "uris"}`.
+pub async fn credentials(State(st): State<WwwState>, headers: HeaderMap) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let user = match basic_authenticate(&st, &headers, &cors, "turn").await {
+        Ok(u) => u,
+        Err(r) => return r,
+    };
+    let Some(turn) = st.turn.as_ref().filter(|t| t.enabled()) else {
+        return json_err(StatusCode::NOT_FOUND, "TURN relay is not enabled", &cors);
+    };
+    let creds = match turn.rest_credentials(&user, chatmail_db::policies::unix_now()) {
+        Ok(c) => c,
+        Err(e) => {
+            tracing::warn!(%user, error = %e, "TURN credentials failed");
+            return json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "TURN credentials failed",
+                &cors,
+            );
+        }
+    };
+    let body = json!({
+        "username": creds.username,
+        "password": creds.password,
+        "ttl": creds.ttl_secs,
+        "uris": [turn.uri()],
+    });
+    let mut resp = json_ok(StatusCode::OK, &body, &cors);
+    resp.headers_mut()
+        .insert(header::CACHE_CONTROL, HeaderValue::from_static("no-store"));
+    resp
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `GET /.well-known/webfinger` (RFC 7033): discovery of local accounts by
+//! `resource=acct:user@domain`.
+//!
+//! A known account gets a JRD naming the web issuer (`https://<host>`) and the IMAP
+//! endpoint, followed by the raw link objects of `webfinger_extra_links`. Unknown accounts
+//! and foreign domains are 404, a resource that is not an `acct:` address is 400.
+
+use axum::extract::{Query, State};
+use axum::http::{header, HeaderMap, HeaderValue, StatusCode};
+use axum::response::{IntoResponse, Response};
+use chatmail_auth::normalize_username;
+use chatmail_config::DcloginMailSettings;
+use serde::Deserialize;
+use serde_json::{json, Value};
+
+use crate::handlers::dclogin_mail_settings;
+use crate::response::json_err;
+use crate::WwwState;
+
+pub const WEBFINGER_PATH: &str = "/.well-known/webfinger";
+/// Link relation pointing at the web domain.
+pub const ISSUER_REL: &str = "http://openid.net/specs/connect/1.0/issuer";
+/// Link relation pointing at the IMAP endpoint.
+pub const IMAP_REL: &str = "urn:ietf:params:oauth:grant-type:jwt-bearer";
+const JRD_CONTENT_TYPE: &str = "application/jrd+json";
+
+#[derive(Deserialize, Default)]
+pub struct WebFingerQuery {
+    #[serde(default)]
+    pub resource: String,
+}
+
+/// GET `/.well-known/webfinger?resource=acct:user@domain` → JRD.
+pub async fn webfinger(
+    State(st): State<WwwState>,
+    headers: HeaderMap,
+    Query(query): Query<WebFingerQuery>,
+) -> Response {
+    let cors = st.cors_snap(&headers).await;
+    let Some(address) = parse_acct(&query.resource) else {
+        return json_err(
+            StatusCode::BAD_REQUEST,
+            "resource must be acct:user@domain",
+            &cors,
+        );
+    };
+    let domain = address.rsplit_once('@').map_or("", |(_, d)| d);
+    if !st.serves_domain(domain) {
+        return json_err(StatusCode::NOT_FOUND, "account not found", &cors);
+    }
+    match chatmail_db::passwords::user_exists(&st.pool, &address).await {
+        Ok(true) => {}
+        Ok(false) => return json_err(StatusCode::NOT_FOUND, "account not found", &cors),
+        Err(e) => {
+            tracing::warn!(error = %e, "webfinger account lookup failed");
+            return json_err(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "account lookup failed",
+                &cors,
+            );
+        }
+    }
+    let mail = dclogin_mail_settings(&st, &headers).await;
+    let body = jrd(&address, &mail, &st.config.webfinger_extra_links);
+    let mut resp = (StatusCode::OK, body.to_string()).into_response();
+    let h = resp.headers_mut();
+    h.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static(JRD_CONTENT_TYPE),
+    );
+    // RFC 7033 §5: WebFinger is readable from any origin.
+    h.insert(
+        header::ACCESS_CONTROL_ALLOW_ORIGIN,
+        HeaderValue::from_static("*"),
+    );
+    resp
+}
+
+/// `acct:User@Example.org` → `user@example.org`; `None` unless an `acct:` URI with a
+/// non-empty local part and domain.
+fn parse_acct(resource: &str) -> Option<String> {
+    let resource = resource.trim();
+    let address = resource
+        .get(..5)
+        .filter(|scheme| scheme.eq_ignore_ascii_case("acct:"))
+        .map(|_| &resource[5..])?;
+    let (local, domain) = address.rsplit_once('@')?;
+    if local.is_empty() || domain.is_empty() || local.contains('@') {
+        return None;
+    }
+    normalize_username(address).ok()
+}
+
+/// JRD of `address`: issuer and IMAP links, then every `extra` entry that is a JSON object.
+fn jrd(address: &str, mail: &DcloginMailSettings, extra: &[String]) -> Value {
+    let host = &mail.client_host;
+    let imap = if mail.imap_port_tls.is_empty() {
+        format!("imap://{host}:{}", mail.imap_port_starttls)
+    } else {
+        format!("imaps://{host}:{}", mail.imap_port_tls)
+    };
+    let mut links = vec![
+        json!({ "rel": ISSUER_REL, "href": format!("https://{host}") }),
+        json!({ "rel": IMAP_REL, "href": imap }),
+    ];
+    links.extend(
+        extra
+            .iter()
+            .filter_map(|raw| serde_json::from_str::<Value>(raw).ok())
+            .filter(Value::is_object),
+    );
+    json!({
+        "subject": format!("acct:{address}"),
+        "aliases": [format!("mailto:{address}")],
+        "links": links,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn acct_resources_are_parsed_and_normalized() {
+        assert_eq!(
+            parse_acct(" acct:Alice@Example.org ").as_deref(),
+            Some("alice@example.org")
+        );
+        assert_eq!(
+            parse_acct("ACCT:bob@[127.0.0.1]").as_deref(),
+            Some("bob@[127.0.0.1]")
+        );
+        for bad in [
+            "",
+            "alice@example.org",
+            "acct:",
+            "acct:@example.org",
+            "acct:alice@",
+        ] {
+            assert_eq!(parse_acct(bad), None, "{bad:?}");
+        }
+    }
+}
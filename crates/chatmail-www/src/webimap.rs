@@ -24,9 +24,11 @@ use axum::extract::{Query, State};
 use axum::http::{HeaderMap, StatusCode};
 use axum::response::{IntoResponse, Response};
 use axum::Json;
+use chatmail_state::junk::move_direction;
+use chatmail_state::JunkReclassifiedEvent;
 use chatmail_storage::{
     copy_message, delete_blob, list_inbox, list_mailbox_messages, mailbox_exists, move_message,
-    read_blob, store_add_flags, InboxEntry,
+    read_blob, split_maildir_filename, store_add_flags, InboxEntry,
 };
 use mail_parser::MessageParser;
 use serde::{Deserialize, Serialize};
@@ -386,6 +388,15 @@ pub async fn message_get(
     let Some(entry) = find_entry(&entries, path.uid).await else {
         return json_err(StatusCode::NOT_FOUND, "message not found", &cors);
     };
+    if !st.app.memory.admit("http") {
+        return json_err(
+            StatusCode::SERVICE_UNAVAILABLE,
+            "server busy, retry later",
+            &cors,
+        );
+    }
+    // Raw blob plus parsed body and JSON encoding: roughly twice the message size.
+    let _lease = st.app.memory.reserve(entry.size.saturating_mul(2));
     match build_detail(&st, &user, mailbox, &entry, &cors).await {
         Ok(d) => json_ok(StatusCode::OK, &d, &cors),
         Err(r) => r,
@@ -528,6 +539,7 @@ pub(crate) async fn delete_uid(
     let Some(entry) = entries.iter().find(|e| e.uid == uid) else {
         return Err("message not found".into());
     };
+    let was_deleted = split_maildir_filename(&entry.msg_id).1.deleted;
     if mailbox.eq_ignore_ascii_case("INBOX") {
         delete_blob(&st.app.mailbox_store, user, &entry.msg_id)
             .await
@@ -544,6 +556,7 @@ pub(crate) async fn delete_uid(
         .await
         .map_err(|e| e.to_string())?;
     }
+    st.app.quota.record_remove(user, entry.size, was_deleted);
     Ok(())
 }
 
@@ -619,6 +632,12 @@ pub(crate) async fn delete_user_mailbox(
         tokio::fs::remove_dir_all(&folder)
             .await
             .map_err(|e| e.to_string())?;
+        // The folder's messages counted towards quota; rescan rather than sum them first.
+        st.app
+            .quota
+            .recalculate(user, &st.app.mailbox_store)
+            .await
+            .map_err(|e| e.to_string())?;
     }
     Ok(())
 }
@@ -662,6 +681,10 @@ pub(crate) async fn copy_uid(
     let Some(entry) = entries.iter().find(|e| e.uid == uid) else {
         return Err("message not found".into());
     };
+    st.app
+        .quota
+        .check_quota(user, entry.size)
+        .map_err(|e| e.to_string())?;
     copy_message(
         &st.app.mailbox_store,
         user,
@@ -671,6 +694,7 @@ pub(crate) async fn copy_uid(
     )
     .await
     .map_err(|e| e.to_string())?;
+    st.app.quota.record_write(user, entry.size);
     Ok(())
 }
 
@@ -694,6 +718,17 @@ pub(crate) async fn move_uid(
     )
     .await
     .map_err(|e| e.to_string())?;
+    if let Some(direction) = move_direction(mailbox, dest_mailbox) {
+        st.app
+            .events
+            .publish_junk_reclassified(JunkReclassifiedEvent {
+                username: user.to_string(),
+                mailbox: dest_mailbox.to_string(),
+                msg_id: split_maildir_filename(&entry.msg_id).0.to_string(),
+                size: entry.size,
+                direction,
+            });
+    }
     Ok(())
 }
 
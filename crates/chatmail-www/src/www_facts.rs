@@ -46,6 +46,39 @@ pub fn retention_info_line(language: &str, label: &str) -> String {
     }
 }
 
+/// Retention exceptions (`retention_keep_flagged` / `retention_keep_unseen`), localized.
+/// `None` when retention is off or nothing is exempt.
+pub fn retention_exceptions_line(language: &str, config: &AppConfig) -> Option<String> {
+    let retention = parse_duration(config.retention.as_deref()?.trim()).ok()?;
+    if retention.is_zero() {
+        return None;
+    }
+    let mut parts = Vec::new();
+    if config.effective_retention_keep_flagged() {
+        parts.push(match language {
+            "fa" => "پیام‌های ستاره‌دار نگه داشته می‌شوند.".to_string(),
+            "ru" => "Помеченные звёздочкой сообщения сохраняются.".to_string(),
+            "es" => "Los mensajes destacados se conservan.".to_string(),
+            _ => "Starred messages are kept.".to_string(),
+        });
+    }
+    let keep_unseen = config
+        .retention_keep_unseen
+        .as_deref()
+        .and_then(|raw| parse_duration(raw.trim()).ok())
+        .filter(|d| *d > retention);
+    if let Some(d) = keep_unseen {
+        let label = humanize_duration(d);
+        parts.push(match language {
+            "fa" => format!("پیام‌های خوانده‌نشده دست‌کم {label} نگه داشته می‌شوند."),
+            "ru" => format!("Непрочитанные сообщения хранятся не менее {label}."),
+            "es" => format!("Los mensajes no leídos se conservan al menos {label}."),
+            _ => format!("Unread messages are kept for at least {label}."),
+        });
+    }
+    (!parts.is_empty()).then(|| parts.join(" "))
+}
+
 fn humanize_duration(d: Duration) -> String {
     let secs = d.as_secs();
     if secs >= 86400 && secs.is_multiple_of(86400) {
@@ -97,4 +130,23 @@ mod tests {
         cfg.retention = None;
         assert!(format_retention_label(&cfg).is_none());
     }
+
+    #[test]
+    fn retention_exceptions_follow_modifiers() {
+        let mut cfg = AppConfig::default();
+        assert!(retention_exceptions_line("en", &cfg).is_none());
+        cfg.retention = Some("720h".into());
+        assert_eq!(
+            retention_exceptions_line("en", &cfg).as_deref(),
+            Some("Starred messages are kept.")
+        );
+        cfg.retention_keep_flagged = Some(false);
+        cfg.retention_keep_unseen = Some("24h".into());
+        assert!(retention_exceptions_line("en", &cfg).is_none());
+        cfg.retention_keep_unseen = Some("2160h".into());
+        assert_eq!(
+            retention_exceptions_line("en", &cfg).as_deref(),
+            Some("Unread messages are kept for at least 90 days.")
+        );
+    }
 }
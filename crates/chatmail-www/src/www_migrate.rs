@@ -816,6 +816,7 @@ mod tests {
         };
         let ctx_closed = WwwContext {
             MailDomain: "example.org".into(),
+            MailDomains: vec!["example.org".into()],
             MXDomain: String::new(),
             WebDomain: "example.org".into(),
             PublicIP: String::new(),
@@ -984,6 +985,7 @@ mod tests {
         let engine = TemplateEngine::from_config(&cfg);
         let ctx = WwwContext {
             MailDomain: "example.org".into(),
+            MailDomains: vec!["example.org".into()],
             MXDomain: String::new(),
             WebDomain: "example.org".into(),
             PublicIP: String::new(),
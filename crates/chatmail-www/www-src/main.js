@@ -111,3 +111,43 @@ function setQrCodeImage(imgEl, text, cellSize) {
         console.error('QR generation failed', err);
     }
 }
+
+/* ── Offline support ── */
+
+/**
+ * Download a self-contained page with the login QR code and link, so the credentials
+ * stay usable without the server (nothing is sent anywhere).
+ */
+function downloadCredentialsPage(email, link) {
+    var esc = function (s) {
+        return String(s).replace(/[&<>"']/g, function (c) { return '&#' + c.charCodeAt(0) + ';'; });
+    };
+    var img = document.createElement('img');
+    setQrCodeImage(img, link, 6);
+    var html = '<!DOCTYPE html><html><head><meta charset="utf-8">' +
+        '<meta name="viewport" content="width=device-width, initial-scale=1">' +
+        '<title>' + esc(email) + '</title></head>' +
+        '<body style="font-family:sans-serif;text-align:center;padding:1em;max-width:40em;margin:auto">' +
+        '<h2>' + esc(email) + '</h2>' +
+        (img.src ? '<p><img alt="QR Code" src="' + img.src + '"></p>' : '') +
+        '<p>' + esc(t('credentials_page_text')) + '</p>' +
+        '<p><a href="' + esc(link) + '">' + esc(t('index_open_dc')) + '</a></p>' +
+        '<p style="font-family:monospace;word-break:break-all">' + esc(link) + '</p>' +
+        '</body></html>';
+    var a = document.createElement('a');
+    a.href = URL.createObjectURL(new Blob([html], { type: 'text/html' }));
+    a.download = String(email).replace(/[^A-Za-z0-9@._-]/g, '_') + '.html';
+    document.body.appendChild(a);
+    a.click();
+    document.body.removeChild(a);
+    setTimeout(function () { URL.revokeObjectURL(a.href); }, 1000);
+}
+
+/* Install the service worker (precache + offline page); needs HTTPS or localhost. */
+if ('serviceWorker' in navigator && window.isSecureContext) {
+    window.addEventListener('load', function () {
+        navigator.serviceWorker.register('/sw.js', { scope: '/' }).catch(function (err) {
+            console.warn('service worker registration failed', err);
+        });
+    });
+}
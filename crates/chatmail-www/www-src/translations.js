@@ -34,9 +34,17 @@ var TRANSLATIONS = {
         index_title: "Chat Server",
         index_subtitle: "This is a dedicated server for use with <strong>DeltaChat</strong>.",
         index_generate: "Create New Account",
+        index_domain_label: "Domain",
         index_loading: "Creating account...",
         index_open_dc: "Open DeltaChat",
         index_copy_link: "Copy Link",
+        index_save_page: "Save credentials page",
+        index_save_page_hint: "The saved page opens without a connection and shows the login QR code again.",
+        credentials_page_text: "Scan this code in DeltaChat (\"Use Other Server\"), or open the link below on the device with DeltaChat. Keep this file private: it contains the password.",
+        offline_title: "Offline",
+        offline_text: "This page is not available without a connection. The start page and the documentation index still work, and a saved credentials page opens without the server.",
+        offline_home: "Start page",
+        offline_retry: "Try again",
         index_dc_not_installed_title: "DeltaChat is not installed",
         index_dc_not_installed_text: "Please install the DeltaChat app first, then use the \"Copy Link\" button.",
         index_manual_title: "Manual setup (if the button didn't work):",
@@ -164,6 +172,16 @@ var TRANSLATIONS = {
         share_url_help_step3: "Select <strong>Share QR Code</strong>.",
         share_url_help_step4: "Then choose <strong>Copy link</strong>.",
         share_url_help_note: "Only web links starting with <code>https://i.delta.chat/#</code> will work.",
+        share_group_title: "Group invite details (optional)",
+        share_group_hint: "Only for group invite links. The name above is shown as the group name.",
+        share_member_note_label: "Member count",
+        share_member_note_placeholder: "e.g. about 40 members",
+        share_description_label: "Group description",
+        share_expires_label: "Link expires",
+        share_expires_never: "Never",
+        share_expires_1d: "After 1 day",
+        share_expires_7d: "After 7 days",
+        share_expires_30d: "After 30 days",
         share_submit: "Create Link",
         share_error_default: "An error occurred",
         share_error_server: "Error communicating with server",
@@ -171,18 +189,39 @@ var TRANSLATIONS = {
         // Share success
         share_success_title: "Link created successfully!",
         share_success_text: "Your personal link is ready. Share it with anyone you want to chat with.",
+        share_success_group_text: "Your group link is ready. Share it with anyone you want to invite.",
         share_success_copy: "Copy Link",
         share_success_view: "View your page",
+        // My links
+        links_title: "My links",
+        links_col_link: "Link",
+        links_col_created: "Created",
+        links_col_expires: "Expires",
+        links_col_views: "Views",
+        links_col_last_viewed: "Last viewed",
+        links_privacy: "Only a total and the day of the last view are kept. Repeat visits within 30 minutes count once.",
+        links_empty: "No links use this address yet.",
+        links_create: "Create a link",
         // Contact view
         contact_dc: "DeltaChat Contact",
         contact_ready: "Ready to receive messages on DeltaChat",
         contact_start: "To start a conversation, click the button below or copy the link and enter it in the app.",
         contact_send: "Send message in DeltaChat",
         contact_copy_invite: "Copy invite link",
+        contact_no_app: "No DeltaChat yet? Install it first, then come back to this page:",
+        contact_scan_qr: "On another device? Scan this code with DeltaChat.",
         contact_verify_title: "Identity verification and security",
         contact_verify_text: "This link may belong to someone else or its address may have changed.",
         contact_verify_warning: "Please verify with the other person after connecting that they are who you expect.",
         contact_create_own: "Create a sharing page for yourself",
+        // Group view
+        group_dc: "DeltaChat Group",
+        group_start: "To join, click the button below or copy the link and enter it in the app.",
+        group_join: "Join group in DeltaChat",
+        group_scan_qr: "On another device? Scan this code with DeltaChat to join.",
+        group_verify_text: "Anyone can publish a group link. The name and description above were written by whoever created this page.",
+        group_verify_warning: "Check with a member you know that this is the group you expect before sharing anything sensitive.",
+        group_create_own: "Create a sharing page for your group",
         // Proxy box (shared across pages)
         proxy_box_title: "Please use this proxy inside the DeltaChat app for faster messaging.",
         proxy_box_desc: "Note that this proxy only works within this server and only for messaging to this server!",
@@ -199,7 +238,9 @@ var TRANSLATIONS = {
         inv_invalid_title: "Invalid Invitation",
         inv_invalid_text: "This invitation link is invalid, expired, or has been used up. Please ask the person who invited you for a new link.",
         inv_no_token_title: "Missing Invitation Code",
-        inv_no_token_text: "No invitation code was found in this link. Please check the link you received."
+        inv_no_token_text: "No invitation code was found in this link. Please check the link you received.",
+        inv_recovery_title: "Save your recovery codes",
+        inv_recovery_text: "Each code can reset your password once if you lose this device. They are shown only now."
     },
 
     fa: {
@@ -214,9 +255,17 @@ var TRANSLATIONS = {
         index_title: "سرور چت",
         index_subtitle: "این یک سرور اختصاصی برای استفاده از <strong>دلتاچت</strong> است.",
         index_generate: "ساخت اکانت جدید",
+        index_domain_label: "دامنه",
         index_loading: "در حال ایجاد اکانت...",
         index_open_dc: "ورود به دلتاچت",
         index_copy_link: "کپی لینک",
+        index_save_page: "ذخیره صفحه اطلاعات ورود",
+        index_save_page_hint: "صفحه ذخیره‌شده بدون اتصال به اینترنت باز می‌شود و کد QR ورود را دوباره نشان می‌دهد.",
+        credentials_page_text: "این کد را در دلتاچت («استفاده از سرور دیگر») اسکن کنید یا لینک زیر را در دستگاهی که دلتاچت دارد باز کنید. این فایل را خصوصی نگه دارید: رمز عبور در آن است.",
+        offline_title: "آفلاین",
+        offline_text: "این صفحه بدون اتصال در دسترس نیست. صفحه اصلی و فهرست راهنما همچنان کار می‌کنند و صفحه ذخیره‌شده اطلاعات ورود بدون سرور باز می‌شود.",
+        offline_home: "صفحه اصلی",
+        offline_retry: "تلاش دوباره",
         index_dc_not_installed_title: "دلتاچت نصب نشده است",
         index_dc_not_installed_text: "لطفاً ابتدا اپلیکیشن دلتاچت را نصب کنید و سپس از دکمه «کپی لینک» استفاده کنید.",
         index_manual_title: "راهنمای افزودن دستی (اگر دکمه کار نکرد):",
@@ -349,6 +398,8 @@ var TRANSLATIONS = {
         contact_start: "برای شروع گفتگو، روی دکمه زیر بزنید یا لینک را کپی کرده و در برنامه وارد کنید.",
         contact_send: "ارسال پیام در دلتاچت",
         contact_copy_invite: "کپی لینک دعوت",
+        contact_no_app: "دلتاچت را ندارید؟ ابتدا آن را نصب کنید و سپس به این صفحه برگردید:",
+        contact_scan_qr: "روی دستگاه دیگری هستید؟ این کد را با دلتاچت اسکن کنید.",
         contact_verify_title: "تایید هویت و امنیت",
         contact_verify_text: "ممکن است این لینک متعلق به فرد دیگری باشد یا آدرس آن تغییر کرده باشد.",
         contact_verify_warning: "لطفاً پس از متصل شدن، حتماً از فرد مقابل تایید بگیرید که همان شخصی است که انتظار دارید.",
@@ -366,7 +417,9 @@ var TRANSLATIONS = {
         inv_invalid_title: "دعوت‌نامه نامعتبر",
         inv_invalid_text: "این لینک دعوت نامعتبر، منقضی شده یا تمام شده است. لطفاً از فردی که شما را دعوت کرده لینک جدید بخواهید.",
         inv_no_token_title: "کد دعوت پیدا نشد",
-        inv_no_token_text: "کد دعوتی در این لینک یافت نشد. لطفاً لینکی که دریافت کرده‌اید را بررسی کنید."
+        inv_no_token_text: "کد دعوتی در این لینک یافت نشد. لطفاً لینکی که دریافت کرده‌اید را بررسی کنید.",
+        inv_recovery_title: "کدهای بازیابی خود را ذخیره کنید",
+        inv_recovery_text: "اگر این دستگاه را از دست بدهید، هر کد یک بار رمز عبور شما را بازنشانی می‌کند. این کدها فقط همین حالا نمایش داده می‌شوند."
     },
 
     ru: {
@@ -381,9 +434,17 @@ var TRANSLATIONS = {
         index_title: "Чат-сервер",
         index_subtitle: "Это выделенный сервер для использования с <strong>DeltaChat</strong>.",
         index_generate: "Создать новый аккаунт",
+        index_domain_label: "Домен",
         index_loading: "Создание аккаунта...",
         index_open_dc: "Открыть DeltaChat",
         index_copy_link: "Копировать ссылку",
+        index_save_page: "Сохранить страницу с данными входа",
+        index_save_page_hint: "Сохранённая страница открывается без подключения и снова показывает QR-код для входа.",
+        credentials_page_text: "Отсканируйте этот код в DeltaChat («Использовать другой сервер») или откройте ссылку ниже на устройстве с DeltaChat. Храните файл в тайне: в нём есть пароль.",
+        offline_title: "Нет подключения",
+        offline_text: "Эта страница недоступна без подключения. Главная страница и оглавление документации работают, а сохранённая страница с данными входа открывается без сервера.",
+        offline_home: "Главная страница",
+        offline_retry: "Повторить",
         index_dc_not_installed_title: "DeltaChat не установлен",
         index_dc_not_installed_text: "Пожалуйста, сначала установите приложение DeltaChat, затем используйте кнопку «Копировать ссылку».",
         index_manual_title: "Ручная настройка (если кнопка не сработала):",
@@ -516,6 +577,8 @@ var TRANSLATIONS = {
         contact_start: "Чтобы начать разговор, нажмите кнопку ниже или скопируйте ссылку и введите её в приложении.",
         contact_send: "Отправить сообщение в DeltaChat",
         contact_copy_invite: "Копировать ссылку-приглашение",
+        contact_no_app: "Ещё нет DeltaChat? Установите его и вернитесь на эту страницу:",
+        contact_scan_qr: "На другом устройстве? Отсканируйте этот код в DeltaChat.",
         contact_verify_title: "Проверка личности и безопасность",
         contact_verify_text: "Эта ссылка может принадлежать другому человеку или её адрес мог измениться.",
         contact_verify_warning: "Пожалуйста, после подключения убедитесь у собеседника, что это тот человек, которого вы ожидаете.",
@@ -533,7 +596,9 @@ var TRANSLATIONS = {
         inv_invalid_title: "Недействительное приглашение",
         inv_invalid_text: "Эта ссылка-приглашение недействительна, истекла или была использована полностью. Попросите пригласившего вас человека отправить новую ссылку.",
         inv_no_token_title: "Отсутствует код приглашения",
-        inv_no_token_text: "В этой ссылке не найден код приглашения. Проверьте полученную ссылку."
+        inv_no_token_text: "В этой ссылке не найден код приглашения. Проверьте полученную ссылку.",
+        inv_recovery_title: "Сохраните коды восстановления",
+        inv_recovery_text: "Каждый код один раз сбрасывает пароль, если вы потеряете это устройство. Они показываются только сейчас."
     }
 };
 
@@ -25,9 +25,11 @@ use chatmail_config::{
 use chatmail_db::{init_db_from_config, DbPool};
 use chatmail_state::AppState;
 use chatmail_types::Result;
-use tracing::info;
+use tracing::{info, warn};
 
 use crate::admin::resolve_admin_token;
+use crate::log_json::LogFormat;
+use crate::log_rotate::RotationPolicy;
 use crate::logging::init_logging;
 
 /// Result of a successful boot (for unit tests).
@@ -92,8 +94,18 @@ pub async fn run_until(
     let state_dir = resolve_state_dir(&args, &file_config);
 
     let debug = file_config.debug;
+    let log_format = LogFormat::from_config(file_config.log_format.as_deref())?;
     // No-Log default; `log stderr` / file path / `debug true` enable output.
-    let _log_reload = init_logging(debug, file_config.log_target.as_deref());
+    let _log_reload = init_logging(
+        debug,
+        file_config.log_target.as_deref(),
+        &RotationPolicy::from_config(&file_config),
+        log_format,
+    );
+    #[cfg(unix)]
+    crate::log_rotate::spawn_sighup_reopen();
+    // Stacks for crash reports; `panic_abort yes` turns any panic into a process abort.
+    chatmail_state::install_panic_hook(file_config.panic_abort);
 
     let (artifacts, pool) = initialize_state(&state_dir, &file_config).await?;
 
@@ -105,10 +117,48 @@ pub async fn run_until(
         pool.clone(),
     ));
     app_state.hydrate(&pool, &file_config).await?;
+    app_state
+        .disk
+        .refresh(&chatmail_state::StatvfsProbe::new(&state_dir));
     std::fs::create_dir_all(state_dir.join("pending_notifications"))?;
     app_state.push.requeue_persistent().await;
 
     let flusher = app_state.start_flusher(pool.clone());
+    let _junk_trainer = file_config
+        .junk_training_url
+        .clone()
+        .filter(|u| !u.trim().is_empty())
+        .map(|url| crate::junk_trainer::start_junk_trainer(Arc::clone(&app_state), url));
+    let _cdn_refresh =
+        crate::cdn_ranges::start_cloudflare_refresh(Arc::clone(&app_state.trusted_proxies));
+    let _ban_refresh = chatmail_state::start_ban_refresh(Arc::clone(&app_state.bans), pool.clone());
+    let _registration_sweep =
+        chatmail_state::start_registration_limit_sweep(Arc::clone(&app_state.registration_limiter));
+    let _pow_sweep =
+        chatmail_state::start_proof_of_work_sweep(Arc::clone(&app_state.pow_challenges));
+    let _responder_refresh =
+        chatmail_state::start_responder_refresh(Arc::clone(&app_state.responders), pool.clone());
+    let _catchall_refresh =
+        chatmail_state::start_catchall_refresh(Arc::clone(&app_state.catchalls), pool.clone());
+    let _audit_prune = file_config
+        .effective_audit_retention()
+        .map(|retention| chatmail_state::start_admin_audit_prune(pool.clone(), retention));
+    let _peer_refresh =
+        crate::peer_refresh::start_peer_refresh(Arc::clone(&app_state.peers), pool.clone());
+    let _webhook_worker = crate::webhook::start_webhook_worker(Arc::clone(&app_state.webhooks));
+    let _storage_scan = chatmail_state::start_storage_usage_scan(
+        Arc::clone(&app_state.storage_usage),
+        Arc::clone(&app_state.mailbox_store),
+    );
+    // Takeout jobs are in-memory, so every archive left from a previous run is orphaned.
+    match app_state.takeout.clean_orphans() {
+        Ok(0) => {}
+        Ok(n) => info!(removed = n, "takeout: removed orphaned archives"),
+        Err(e) => warn!(error = %e, "takeout: orphan cleanup failed"),
+    }
+    let _takeout_sweeper = chatmail_state::start_takeout_sweeper(Arc::clone(&app_state.takeout));
+    let _clock_check =
+        crate::clock_check::start_clock_check(Arc::clone(&app_state.clock), &file_config);
 
     if debug {
         info!("madmail-v2 starting (Phases 3–8: auth, SMTP, IMAP, federation, delivery)");
@@ -121,7 +171,7 @@ pub async fn run_until(
         crate::profiling::start_pprof_server().await;
     }
 
-    let _supervisor = if !args.boot_once {
+    let supervisor = if !args.boot_once {
         let (supervisor, _reload_tx) = crate::servers::start_servers(
             pool.clone(),
             Arc::clone(&app_state),
@@ -142,9 +192,15 @@ pub async fn run_until(
 
     shutdown.await;
     if debug {
-        info!("shutdown signal received, flushing federation stats");
+        info!("shutdown signal received, draining in-flight work");
+    }
+    if let Some(supervisor) = &supervisor {
+        supervisor
+            .shutdown(file_config.effective_shutdown_drain())
+            .await;
     }
     flusher.shutdown().await;
+    pool.close().await;
 
     Ok(())
 }
@@ -0,0 +1,143 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Keep `trusted_cdn cloudflare` ranges current: the embedded list seeds boot, this job
+//! re-fetches Cloudflare's published `ips-v4` / `ips-v6` once a day.
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use chatmail_state::client_ip::{parse_cidr_list, IpNet};
+use chatmail_state::{CdnMode, TrustedProxies};
+use tokio::task::JoinHandle;
+
+pub const CLOUDFLARE_IPS_BASE: &str = "https://www.cloudflare.com";
+const REFRESH_INTERVAL: Duration = Duration::from_secs(24 * 3600);
+const REQUEST_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// Spawn the daily refresh when `trusted_cdn cloudflare` is configured.
+pub fn start_cloudflare_refresh(proxies: Arc<TrustedProxies>) -> Option<JoinHandle<()>> {
+    if proxies.mode() != CdnMode::Cloudflare {
+        return None;
+    }
+    let client = reqwest::Client::builder()
+        .timeout(REQUEST_TIMEOUT)
+        .build()
+        .unwrap_or_default();
+    Some(tokio::spawn(async move {
+        let mut tick = tokio::time::interval(REFRESH_INTERVAL);
+        loop {
+            tick.tick().await;
+            if let Err(e) = refresh_once(&proxies, &client, CLOUDFLARE_IPS_BASE).await {
+                tracing::warn!(error = %e, "cloudflare range refresh failed; keeping current list");
+            }
+        }
+    }))
+}
+
+/// Fetch `<base>/ips-v4` and `<base>/ips-v6` and swap them in. Both lists must load;
+/// a half-updated set would drop every edge of the other family.
+pub async fn refresh_once(
+    proxies: &TrustedProxies,
+    client: &reqwest::Client,
+    base: &str,
+) -> Result<usize, String> {
+    let base = base.trim_end_matches('/');
+    let mut ranges = fetch_list(client, &format!("{base}/ips-v4")).await?;
+    ranges.extend(fetch_list(client, &format!("{base}/ips-v6")).await?);
+    let count = ranges.len();
+    if !proxies.replace_ranges(ranges) {
+        return Err("empty range list".into());
+    }
+    tracing::debug!(count, "cloudflare ranges refreshed");
+    Ok(count)
+}
+
+async fn fetch_list(client: &reqwest::Client, url: &str) -> Result<Vec<IpNet>, String> {
+    let resp = client
+        .get(url)
+        .send()
+        .await
+        .map_err(|e| format!("{url}: {e}"))?;
+    if !resp.status().is_success() {
+        return Err(format!("{url}: HTTP {}", resp.status()));
+    }
+    let body = resp.text().await.map_err(|e| format!("{url}: {e}"))?;
+    let ranges = parse_cidr_list(&body);
+    if ranges.is_empty() {
+        return Err(format!("{url}: no ranges"));
+    }
+    Ok(ranges)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::http::StatusCode;
+    use axum::routing::get;
+    use axum::Router;
+
+    async fn serve(router: Router) -> String {
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        tokio::spawn(async move {
+            axum::serve(listener, router).await.unwrap();
+        });
+        format!("http://{addr}")
+    }
+
+    #[tokio::test]
+    async fn refresh_replaces_embedded_ranges() {
+        let base = serve(
+            Router::new()
+                .route(
+                    "/ips-v4",
+                    get(|| async { "192.0.2.0/24\n198.51.100.0/24\n" }),
+                )
+                .route("/ips-v6", get(|| async { "2001:db8::/32" })),
+        )
+        .await;
+        let proxies = TrustedProxies::cloudflare();
+        let client = reqwest::Client::new();
+        assert_eq!(refresh_once(&proxies, &client, &base).await, Ok(3));
+        assert!(proxies.is_trusted("192.0.2.9".parse().unwrap()));
+        assert!(proxies.is_trusted("2001:db8::1".parse().unwrap()));
+        assert!(!proxies.is_trusted("104.16.0.1".parse().unwrap()));
+    }
+
+    #[tokio::test]
+    async fn failed_refresh_keeps_current_ranges() {
+        let base = serve(
+            Router::new()
+                .route("/ips-v4", get(|| async { "192.0.2.0/24\n" }))
+                .route(
+                    "/ips-v6",
+                    get(|| async { (StatusCode::SERVICE_UNAVAILABLE, "") }),
+                ),
+        )
+        .await;
+        let proxies = TrustedProxies::cloudflare();
+        let client = reqwest::Client::new();
+        assert!(refresh_once(&proxies, &client, &base).await.is_err());
+        assert!(proxies.is_trusted("104.16.0.1".parse().unwrap()));
+        assert!(!proxies.is_trusted("192.0.2.9".parse().unwrap()));
+    }
+
+    #[test]
+    fn refresh_only_runs_for_cloudflare() {
+        assert!(start_cloudflare_refresh(Arc::new(TrustedProxies::none())).is_none());
+    }
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! `clock_check`: compare the local clock with the `Date` header of an HTTPS server at
+//! start and every [`CHECK_INTERVAL`]; results land in [`ClockMonitor`].
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use async_trait::async_trait;
+use chatmail_config::AppConfig;
+use chatmail_state::{ClockMonitor, TimeSource};
+use tokio::task::JoinHandle;
+
+const CHECK_INTERVAL: Duration = Duration::from_secs(3600);
+const REQUEST_TIMEOUT: Duration = Duration::from_secs(15);
+
+/// `Date` header of a `HEAD` response (RFC 9110 IMF-fixdate).
+pub struct HttpDateSource {
+    url: String,
+    client: reqwest::Client,
+}
+
+impl HttpDateSource {
+    pub fn new(url: impl Into<String>) -> Self {
+        let client = reqwest::Client::builder()
+            .timeout(REQUEST_TIMEOUT)
+            .build()
+            .unwrap_or_default();
+        Self {
+            url: url.into(),
+            client,
+        }
+    }
+}
+
+#[async_trait]
+impl TimeSource for HttpDateSource {
+    fn name(&self) -> String {
+        self.url.clone()
+    }
+
+    async fn unix_now(&self) -> Result<i64, String> {
+        let resp = self
+            .client
+            .head(&self.url)
+            .send()
+            .await
+            .map_err(|e| e.to_string())?;
+        let date = resp
+            .headers()
+            .get(reqwest::header::DATE)
+            .and_then(|v| v.to_str().ok())
+            .ok_or_else(|| format!("{}: no Date header", self.url))?;
+        parse_http_date(date).ok_or_else(|| format!("{}: bad Date header {date:?}", self.url))
+    }
+}
+
+/// Spawn the periodic check unless `clock_check off`.
+pub fn start_clock_check(clock: Arc<ClockMonitor>, config: &AppConfig) -> Option<JoinHandle<()>> {
+    let url = config.effective_clock_check()?;
+    let source = HttpDateSource::new(url);
+    Some(tokio::spawn(async move {
+        let mut tick = tokio::time::interval(CHECK_INTERVAL);
+        loop {
+            tick.tick().await;
+            // Failures are cached on the monitor and shown in /admin/status.
+            let _ = clock.check(&source).await;
+        }
+    }))
+}
+
+/// `Sun, 06 Nov 1994 08:49:37 GMT` → unix seconds.
+pub fn parse_http_date(value: &str) -> Option<i64> {
+    let format = time::format_description::parse(
+        "[weekday repr:short], [day] [month repr:short] [year] [hour]:[minute]:[second] GMT",
+    )
+    .ok()?;
+    time::PrimitiveDateTime::parse(value.trim(), &format)
+        .ok()
+        .map(|dt| dt.assume_utc().unix_timestamp())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::http::header;
+    use axum::routing::get;
+    use axum::Router;
+
+    #[test]
+    fn parses_imf_fixdate() {
+        assert_eq!(
+            parse_http_date("Sun, 06 Nov 1994 08:49:37 GMT"),
+            Some(784111777)
+        );
+        assert_eq!(parse_http_date("Sunday, 06-Nov-94 08:49:37 GMT"), None);
+        assert_eq!(parse_http_date("yesterday"), None);
+    }
+
+    #[tokio::test]
+    async fn http_source_reads_date_header() {
+        let router = Router::new().route(
+            "/",
+            get(|| async { ([(header::DATE, "Sun, 06 Nov 1994 08:49:37 GMT")], "") }),
+        );
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        tokio::spawn(async move {
+            axum::serve(listener, router).await.unwrap();
+        });
+
+        let source = HttpDateSource::new(format!("http://{addr}/"));
+        assert_eq!(source.unix_now().await, Ok(784111777));
+
+        let clock = ClockMonitor::default();
+        assert!(clock.check(&source).await.is_ok());
+        assert!(clock.skew_hint().unwrap().contains("ahead of http://"));
+    }
+
+    #[test]
+    fn disabled_by_clock_check_off() {
+        let cfg = AppConfig {
+            clock_check: Some("off".into()),
+            ..Default::default()
+        };
+        assert!(start_clock_check(Arc::new(ClockMonitor::from_config(&cfg)), &cfg).is_none());
+    }
+}
@@ -17,7 +17,9 @@
 
 //! Shared account CRUD for operator CLI (mirrors admin `provision` / `delete_account_full`).
 
-use chatmail_db::{passwords, registration_tokens, DbPool};
+use chatmail_db::{
+    app_passwords, device_codes, passwords, recovery_codes, registration_tokens, DbPool,
+};
 use chatmail_storage::MailboxStore;
 use chatmail_types::Result;
 
@@ -42,6 +44,11 @@ pub async fn provision_account(
     stored_hash: &str,
 ) -> Result<()> {
     passwords::create_user(pool, username, stored_hash).await?;
+    // A (re)set password voids any device or recovery code and app password issued under the
+    // old one.
+    device_codes::revoke_device_codes(pool, username).await?;
+    app_passwords::delete_app_passwords(pool, username).await?;
+    recovery_codes::delete_recovery_codes(pool, username).await?;
     mailbox.init_user_dir(username).await?;
     registration_tokens::ensure_new_account_quota(pool, username).await?;
     Ok(())
@@ -82,4 +89,31 @@ mod tests {
         assert!(!mailbox.maildir_for_user(user).root.exists());
         assert!(blocklist::is_blocked(&pool, user).await.unwrap());
     }
+
+    #[tokio::test]
+    async fn reprovision_revokes_outstanding_device_codes() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = init_memory_db().await.unwrap();
+        let mailbox = MailboxStore::new(dir.path());
+        let user = "moved@example.org";
+        let hash = hash_password("secret123456").unwrap();
+        provision_account(&pool, &mailbox, user, &hash)
+            .await
+            .unwrap();
+        let code = chatmail_auth::issue_device_code(&pool, user).await.unwrap();
+
+        let new_hash = hash_password("changed123456").unwrap();
+        provision_account(&pool, &mailbox, user, &new_hash)
+            .await
+            .unwrap();
+        assert!(chatmail_auth::redeem_device_code(
+            &pool,
+            &code,
+            chatmail_config::DEFAULT_GENERATED_CHARSET,
+            16
+        )
+        .await
+        .unwrap()
+        .is_none());
+    }
 }
@@ -20,16 +20,17 @@
 use std::fs;
 use std::path::Path;
 
-use chatmail_auth::{hash_password, is_importable_hash, normalize_username};
+use chatmail_auth::{
+    hash_password, is_importable_hash, issue_recovery_codes, normalize_username, random_string,
+};
 use chatmail_config::cli::AccountsCommand;
-use chatmail_config::{build_dclogin_link, Args, DcloginMailSettings};
+use chatmail_config::{build_dclogin_link, format_data_size, Args, DcloginMailSettings};
 use chatmail_db::{
     account_info, blocklist, delete_quota_row, get_bool_setting, load_mail_port_overrides,
     passwords, settings_keys, DbPool, BULK_DELETE_REASON, CLI_BAN_REASON, CLI_DELETE_REASON,
 };
 use chatmail_storage::MailboxStore;
 use chatmail_types::{ChatmailError, Result};
-use getrandom::fill;
 use serde::{Deserialize, Serialize};
 
 use super::account_ops::{delete_account_full, is_internal_settings_key, provision_account};
@@ -40,10 +41,15 @@ use super::util::{confirm, read_password_stdin};
 
 const ADMIN_USERNAME_LEN: usize = 12;
 const ADMIN_PASSWORD_LEN: usize = 24;
+/// Admin-created passwords include symbols unless `password_charset` overrides them.
+const ADMIN_PASSWORD_CHARSET: &str =
+    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()_+-=[]{}|;:,.<>?";
 
 #[derive(Serialize)]
 struct CreateUserResult {
     dclogin: String,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    recovery_codes: Vec<String>,
 }
 
 #[derive(Serialize, Deserialize)]
@@ -231,6 +237,11 @@ async fn accounts_info(
 
     let maildir = mailbox.maildir_for_user(username);
     let mail_exists = maildir.root.exists();
+    let (stored, deleted) = if mail_exists {
+        mailbox.maildir_usage(username).await.unwrap_or((0, 0))
+    } else {
+        (0, 0)
+    };
 
     if out.is_json() {
         return out.emit(serde_json::json!({
@@ -241,8 +252,11 @@ async fn accounts_info(
             "created_at": if info.created_at > 0 { Some(info.created_at) } else { None },
             "first_login_at": if info.first_login_at > 0 { Some(info.first_login_at) } else { None },
             "last_login_at": if info.last_login_at > 0 { Some(info.last_login_at) } else { None },
+            "last_activity_at": if info.last_activity_at > 0 { Some(info.last_activity_at) } else { None },
             "maildir_present": mail_exists,
             "maildir_path": if mail_exists { Some(maildir.root.display().to_string()) } else { None },
+            "used_bytes": stored,
+            "deleted_bytes": deleted,
         }));
     }
 
@@ -268,12 +282,23 @@ async fn accounts_info(
     if info.last_login_at > 0 {
         out.line(format!("Last login at (unix): {}", info.last_login_at));
     }
+    if info.last_activity_at > 0 {
+        out.line(format!(
+            "Last activity at (unix): {}",
+            info.last_activity_at
+        ));
+    }
     out.line(format!(
         "Maildir: {}",
         if mail_exists { "present" } else { "missing" }
     ));
     if mail_exists {
         out.line(format!("Maildir path: {}", maildir.root.display()));
+        out.line(format!(
+            "Storage used: {} (of which \\Deleted: {})",
+            format_data_size(stored),
+            format_data_size(deleted)
+        ));
     }
     let _ = ctx;
     Ok(())
@@ -320,8 +345,13 @@ async fn create_random_account(
 
     const MAX_ATTEMPTS: u32 = 5;
     for _ in 0..MAX_ATTEMPTS {
-        let localpart = random_alnum(ADMIN_USERNAME_LEN)?;
-        let password = random_password(ADMIN_PASSWORD_LEN)?;
+        let localpart =
+            random_string(&ctx.config.generated_username_charset(), ADMIN_USERNAME_LEN)?;
+        let password = random_string(
+            &ctx.config
+                .generated_password_charset(ADMIN_PASSWORD_CHARSET),
+            ADMIN_PASSWORD_LEN,
+        )?;
         let email = format!("{localpart}@{domain}");
 
         if blocklist::is_blocked(pool, &email).await? {
@@ -334,22 +364,38 @@ async fn create_random_account(
         let hash = hash_password(&password)?;
         match provision_account(pool, mailbox, &email, &hash).await {
             Ok(()) => {
+                let recovery_codes = match ctx.config.recovery_codes.filter(|n| *n > 0) {
+                    Some(n) => issue_recovery_codes(pool, &email, n).await?,
+                    None => Vec::new(),
+                };
                 let dclogin = build_dclogin_link(&email, &password, &mail);
                 if json_only && !args.json {
-                    let body = serde_json::to_string_pretty(&CreateUserResult { dclogin })
+                    let result = CreateUserResult {
+                        dclogin,
+                        recovery_codes,
+                    };
+                    let body = serde_json::to_string_pretty(&result)
                         .map_err(|e| ChatmailError::config(format!("JSON: {e}")))?;
                     println!("{body}");
                     return Ok(());
                 }
                 if args.json {
-                    return out.emit(serde_json::json!({
+                    let mut data = serde_json::json!({
                         "username": localpart,
                         "password": password,
                         "email": email,
                         "dclogin": dclogin,
-                    }));
+                    });
+                    if !recovery_codes.is_empty() {
+                        data["recovery_codes"] = serde_json::json!(recovery_codes);
+                    }
+                    return out.emit(data);
                 }
-                let body = serde_json::to_string_pretty(&CreateUserResult { dclogin })
+                let result = CreateUserResult {
+                    dclogin,
+                    recovery_codes,
+                };
+                let body = serde_json::to_string_pretty(&result)
                     .map_err(|e| ChatmailError::config(format!("JSON: {e}")))?;
                 println!("{body}");
                 return Ok(());
@@ -489,7 +535,7 @@ async fn accounts_import(
             u.hash
         } else {
             let password = if u.password.is_empty() {
-                random_password(ADMIN_PASSWORD_LEN)?
+                random_string(ADMIN_PASSWORD_CHARSET, ADMIN_PASSWORD_LEN)?
             } else {
                 u.password
             };
@@ -556,25 +602,6 @@ async fn accounts_delete_all(
     )
 }
 
-fn random_alnum(len: usize) -> Result<String> {
-    const CHARSET: &[u8] = b"abcdefghijklmnopqrstuvwxyz0123456789";
-    let mut b = vec![0u8; len];
-    fill(&mut b).map_err(|e| ChatmailError::config(format!("random: {e}")))?;
-    Ok(b.iter()
-        .map(|x| CHARSET[(*x as usize) % CHARSET.len()] as char)
-        .collect())
-}
-
-fn random_password(len: usize) -> Result<String> {
-    const CHARSET: &[u8] =
-        b"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()_+-=[]{}|;:,.<>?";
-    let mut b = vec![0u8; len];
-    fill(&mut b).map_err(|e| ChatmailError::config(format!("random: {e}")))?;
-    Ok(b.iter()
-        .map(|x| CHARSET[(*x as usize) % CHARSET.len()] as char)
-        .collect())
-}
-
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -0,0 +1,108 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail admin-audit` / `chatmail audit list` — print the admin audit trail
+//! (`admin_audit`): admin API mutations and CLI settings changes.
+
+use chatmail_config::{parse_duration, Args, AuditListArgs};
+use chatmail_db::policies::unix_now;
+use chatmail_db::{list_admin_audit, AdminAuditQuery};
+use chatmail_types::{ChatmailError, Result};
+use time::format_description::well_known::Rfc3339;
+use time::OffsetDateTime;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn admin_audit(args: &Args, list: &AuditListArgs) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    ctx.require_db()?;
+    let pool = ctx.open_pool().await?;
+    let out = CtlOut::from_args(args, "admin-audit");
+    let (page, limit) = (list.page, list.limit);
+    let from = match list.since.as_deref() {
+        Some(raw) => {
+            let d = parse_duration(raw).map_err(|_| {
+                ChatmailError::config(format!("invalid --since {raw:?} (use e.g. 24h, 7d)"))
+            })?;
+            unix_now().saturating_sub(i64::try_from(d.as_secs()).unwrap_or(i64::MAX))
+        }
+        None => 0,
+    };
+    let query = AdminAuditQuery {
+        from,
+        resource: list.resource.clone().filter(|r| !r.is_empty()),
+        offset: (page - 1).saturating_mul(limit),
+        limit,
+        ..Default::default()
+    };
+    let (rows, total) = list_admin_audit(&pool, &query).await?;
+
+    if out.is_json() {
+        let entries: Vec<_> = rows
+            .iter()
+            .map(|a| {
+                serde_json::json!({
+                    "id": a.id,
+                    "timestamp": a.created_at,
+                    "token": a.token_prefix,
+                    "method": a.method,
+                    "resource": a.resource,
+                    "body": a.body,
+                    "status": a.status,
+                    "remote": a.remote,
+                    "actor": a.actor,
+                    "old_value": a.old_value,
+                    "new_value": a.new_value,
+                })
+            })
+            .collect();
+        return out.emit(serde_json::json!({
+            "entries": entries,
+            "total": total,
+            "page": page,
+            "limit": limit,
+        }));
+    }
+    out.line("TIME\tACTOR\tSTATUS\tMETHOD\tRESOURCE\tTOKEN\tREMOTE\tBODY\tCHANGE");
+    for a in &rows {
+        let time = OffsetDateTime::from_unix_timestamp(a.created_at)
+            .ok()
+            .and_then(|t| t.format(&Rfc3339).ok())
+            .unwrap_or_else(|| a.created_at.to_string());
+        let token = if a.token_prefix.is_empty() {
+            "-".to_string()
+        } else {
+            format!("{}…", a.token_prefix)
+        };
+        let change = if a.old_value.is_empty() && a.new_value.is_empty() {
+            String::new()
+        } else {
+            format!("{} → {}", a.old_value, a.new_value)
+        };
+        out.line(format!(
+            "{time}\t{}\t{}\t{}\t{}\t{token}\t{}\t{}\t{change}",
+            a.actor, a.status, a.method, a.resource, a.remote, a.body
+        ));
+    }
+    out.line("---");
+    out.line(format!(
+        "Page {page}: {} of {total} entries (newest first).",
+        rows.len()
+    ));
+    Ok(())
+}
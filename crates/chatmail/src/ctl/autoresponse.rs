@@ -0,0 +1,206 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail autoresponse` — an account's own automatic reply (`autoresponses`).
+//!
+//! Only the `autoresponses` row changes here; a running server starts or stops replying after
+//! its next responder reload ([`chatmail_state::RESPONDER_REFRESH_INTERVAL`]).
+
+use chatmail_auth::normalize_username;
+use chatmail_config::{Args, AutoresponseCommand};
+use chatmail_db::policies::unix_now;
+use chatmail_db::{passwords, Autoresponse};
+use chatmail_state::parse_day_or_unix;
+use chatmail_types::{ChatmailError, Result};
+use serde_json::{json, Value};
+use time::format_description::well_known::Rfc3339;
+use time::OffsetDateTime;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn autoresponse(args: &Args, cmd: &AutoresponseCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let pool = ctx.open_pool().await?;
+    let out = CtlOut::from_args(args, "autoresponse");
+
+    match cmd {
+        AutoresponseCommand::Set {
+            username,
+            subject,
+            body,
+            body_file,
+            start,
+            end,
+            inactive,
+        } => {
+            let username = normalize_username(username)?;
+            if !passwords::user_exists(&pool, &username).await? {
+                return Err(ChatmailError::config(format!("no account {username}")));
+            }
+            let body = match (body, body_file) {
+                (Some(text), _) => text.clone(),
+                (None, Some(path)) => std::fs::read_to_string(path)
+                    .map_err(|e| ChatmailError::config(format!("read {}: {e}", path.display())))?,
+                (None, None) => {
+                    return Err(ChatmailError::config("--body or --body-file required"))
+                }
+            };
+            let stored = chatmail_db::set_autoresponse(
+                &pool,
+                &Autoresponse {
+                    username,
+                    active: !inactive,
+                    subject: subject.clone().unwrap_or_default(),
+                    body,
+                    starts_at: parse_date_arg("--start", start.as_deref())?,
+                    ends_at: parse_date_arg("--end", end.as_deref())?,
+                    updated_at: 0,
+                },
+            )
+            .await?;
+            out.done_msg(
+                format!(
+                    "Success: autoresponse for {} is {}.",
+                    stored.username,
+                    state_label(&stored, unix_now())
+                ),
+                autoresponse_json(&stored),
+                format!("Set autoresponse {}", stored.username),
+            )?;
+        }
+        AutoresponseCommand::Show { username } => {
+            let username = normalize_username(username)?;
+            let Some(a) = chatmail_db::get_autoresponse(&pool, &username).await? else {
+                return Err(ChatmailError::config(format!(
+                    "no autoresponse for {username}"
+                )));
+            };
+            if out.is_json() {
+                return out.emit(autoresponse_json(&a));
+            }
+            for line in format_autoresponse(&a, unix_now()) {
+                out.line(line);
+            }
+        }
+        AutoresponseCommand::Clear { username } => {
+            let username = normalize_username(username)?;
+            if !chatmail_db::clear_autoresponse(&pool, &username).await? {
+                return Err(ChatmailError::config(format!(
+                    "no autoresponse for {username}"
+                )));
+            }
+            out.done_msg(
+                format!("Success: removed autoresponse for {username}."),
+                json!({ "username": username }),
+                format!("Removed autoresponse {username}"),
+            )?;
+        }
+    }
+    Ok(())
+}
+
+fn parse_date_arg(flag: &str, raw: Option<&str>) -> Result<Option<i64>> {
+    raw.map(|r| {
+        parse_day_or_unix(r.trim())
+            .ok_or_else(|| ChatmailError::config(format!("invalid {flag}: {r} (YYYY-MM-DD)")))
+    })
+    .transpose()
+}
+
+fn autoresponse_json(a: &Autoresponse) -> Value {
+    json!({
+        "username": a.username,
+        "active": a.active,
+        "in_effect": a.in_effect(unix_now()),
+        "subject": a.subject,
+        "body": a.body,
+        "starts_at": a.starts_at,
+        "ends_at": a.ends_at,
+        "updated_at": a.updated_at,
+    })
+}
+
+/// `in effect`, `scheduled`, `ended` or `switched off` at `now`.
+fn state_label(a: &Autoresponse, now: i64) -> &'static str {
+    if !a.active {
+        "switched off"
+    } else if a.in_effect(now) {
+        "in effect"
+    } else if a.starts_at.is_some_and(|t| now < t) {
+        "scheduled"
+    } else {
+        "ended"
+    }
+}
+
+fn format_time(t: Option<i64>) -> String {
+    match t {
+        None => "—".into(),
+        Some(t) => OffsetDateTime::from_unix_timestamp(t)
+            .ok()
+            .and_then(|d| d.format(&Rfc3339).ok())
+            .unwrap_or_else(|| t.to_string()),
+    }
+}
+
+fn format_autoresponse(a: &Autoresponse, now: i64) -> Vec<String> {
+    let subject = if a.subject.is_empty() {
+        "Auto: <original subject>"
+    } else {
+        a.subject.as_str()
+    };
+    let mut lines = vec![
+        format!("Autoresponse for {}: {}", a.username, state_label(a, now)),
+        format!("Subject: {subject}"),
+        format!("Starts:  {}", format_time(a.starts_at)),
+        format!("Ends:    {}", format_time(a.ends_at)),
+        "---".into(),
+    ];
+    lines.extend(a.body.lines().map(str::to_string));
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn format_shows_state_window_and_body() {
+        let a = Autoresponse {
+            username: "alice@example.org".into(),
+            active: true,
+            subject: String::new(),
+            body: "Away.\nBack on Monday.".into(),
+            starts_at: Some(1_767_225_600),
+            ends_at: None,
+            updated_at: 0,
+        };
+        let lines = format_autoresponse(&a, 1_767_225_599);
+        assert_eq!(lines[0], "Autoresponse for alice@example.org: scheduled");
+        assert_eq!(lines[1], "Subject: Auto: <original subject>");
+        assert_eq!(lines[2], "Starts:  2026-01-01T00:00:00Z");
+        assert_eq!(lines[3], "Ends:    —");
+        assert_eq!(lines[5..], ["Away.", "Back on Monday."]);
+        assert_eq!(state_label(&a, 1_767_225_600), "in effect");
+        let ended = Autoresponse {
+            ends_at: Some(1_767_225_601),
+            ..a.clone()
+        };
+        assert_eq!(state_label(&ended, 1_767_225_601), "ended");
+    }
+}
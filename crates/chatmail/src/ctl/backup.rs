@@ -0,0 +1,532 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail backup` — move accounts to another server as one `.tar.gz` archive.
+//!
+//! Archive layout (format 1):
+//!
+//! ```text
+//! manifest.json                                      format, created_at, account count
+//! accounts/{user}/account.json                       username, password hash, quota row
+//! accounts/{user}/mailboxes/{mailbox}/               one directory entry per mailbox
+//! accounts/{user}/mailboxes/{mailbox}/chatmail-uidlist
+//! accounts/{user}/mailboxes/{mailbox}/{new|cur}/{maildir filename}
+//! ```
+//!
+//! Message files keep their maildir names, so flags survive, and carry INTERNALDATE as the
+//! entry mtime. The uidlist is copied verbatim, which preserves UIDs and UIDVALIDITY. Both
+//! directions stream one message at a time; cold messages are exported as plain files and
+//! land hot on import.
+//!
+//! `backup snapshot` / `backup restore` archive the whole server instead; see [`snapshot`].
+
+use std::collections::HashMap;
+use std::fs::File;
+use std::io::{BufReader, BufWriter, Read, Write};
+use std::path::{Component, Path};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use chatmail_config::cli::BackupCommand;
+use chatmail_config::Args;
+use chatmail_db::policies::unix_now;
+use chatmail_db::{get_quota_row, passwords, put_quota_row, DbPool, QuotaRow};
+use chatmail_state::storage_policy;
+use chatmail_storage::uidlist::UIDLIST_FILE;
+use chatmail_storage::{
+    list_mailbox_messages, list_user_mailboxes, read_blob_known, split_maildir_filename,
+    MailboxStore,
+};
+use chatmail_types::{ChatmailError, Result};
+use flate2::read::GzDecoder;
+use flate2::write::GzEncoder;
+use flate2::Compression;
+use serde::{Deserialize, Serialize};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use super::snapshot;
+
+/// Archive layout version written to `manifest.json`.
+const FORMAT: u32 = 1;
+const MANIFEST: &str = "manifest.json";
+const ACCOUNT_FILE: &str = "account.json";
+
+#[derive(Debug, Serialize, Deserialize)]
+struct Manifest {
+    format: u32,
+    created_at: i64,
+    accounts: usize,
+}
+
+#[derive(Debug, Serialize, Deserialize)]
+struct AccountRecord {
+    username: String,
+    password_hash: String,
+    quota: Option<QuotaRecord>,
+}
+
+/// The account's `quotas` row; timestamps are Unix seconds, 0 when never set.
+#[derive(Debug, Serialize, Deserialize)]
+struct QuotaRecord {
+    max_storage: i64,
+    created_at: i64,
+    first_login_at: i64,
+    last_login_at: i64,
+}
+
+impl From<QuotaRow> for QuotaRecord {
+    fn from(row: QuotaRow) -> Self {
+        Self {
+            max_storage: row.max_storage,
+            created_at: row.created_at,
+            first_login_at: row.first_login_at,
+            last_login_at: row.last_login_at,
+        }
+    }
+}
+
+impl From<&QuotaRecord> for QuotaRow {
+    fn from(rec: &QuotaRecord) -> Self {
+        Self {
+            max_storage: rec.max_storage,
+            created_at: rec.created_at,
+            first_login_at: rec.first_login_at,
+            last_login_at: rec.last_login_at,
+        }
+    }
+}
+
+#[derive(Debug, Default)]
+struct ImportReport {
+    imported: Vec<String>,
+    skipped: Vec<String>,
+    messages: usize,
+}
+
+pub async fn backup(args: &Args, cmd: &BackupCommand) -> Result<()> {
+    let out = CtlOut::from_args(args, "backup");
+    // Whole-server snapshots work on files; restore must not open (and migrate) the DB
+    // it is about to replace.
+    match cmd {
+        BackupCommand::Snapshot { output } => {
+            let report = snapshot::snapshot(args, output).await?;
+            return out.done(
+                format!(
+                    "Success: wrote {} file(s) ({} database(s), {} bytes) to {}.",
+                    report.files,
+                    report.databases,
+                    report.bytes,
+                    output.display()
+                ),
+                serde_json::json!({
+                    "output": output,
+                    "files": report.files,
+                    "databases": report.databases,
+                    "bytes": report.bytes,
+                }),
+            );
+        }
+        BackupCommand::Restore { input, force } => {
+            let report = snapshot::restore(args, input, *force)?;
+            return out.done(
+                format!(
+                    "Success: restored {} file(s) from {}{}.",
+                    report.files,
+                    input.display(),
+                    if report.restarted {
+                        "; service restarted"
+                    } else {
+                        ""
+                    }
+                ),
+                serde_json::json!({
+                    "input": input,
+                    "files": report.files,
+                    "restarted": report.restarted,
+                }),
+            );
+        }
+        BackupCommand::Export { .. } | BackupCommand::Import { .. } => {}
+    }
+
+    let ctx = CtlContext::from_args(args)?;
+    ctx.require_db()?;
+    let pool = ctx.open_pool().await?;
+    let store = MailboxStore::with_policy(
+        ctx.state_dir.clone(),
+        storage_policy(&ctx.config, &ctx.state_dir),
+    );
+
+    match cmd {
+        BackupCommand::Export { output, user } => {
+            let users = match user {
+                Some(u) if passwords::user_exists(&pool, u).await? => vec![u.clone()],
+                Some(u) => return Err(ChatmailError::config(format!("account {u} not found"))),
+                None => passwords::list_users(&pool).await?,
+            };
+            let (accounts, messages) = export(&pool, &store, &users, output).await?;
+            out.done(
+                format!(
+                    "Success: exported {accounts} account(s), {messages} message(s) to {}.",
+                    output.display()
+                ),
+                serde_json::json!({
+                    "output": output,
+                    "accounts": accounts,
+                    "messages": messages,
+                }),
+            )
+        }
+        BackupCommand::Import { input, overwrite } => {
+            let report = import(&pool, &store, input, *overwrite).await?;
+            for user in &report.skipped {
+                out.line(format!("skipped {user} (already exists; use --overwrite)"));
+            }
+            out.done(
+                format!(
+                    "Success: imported {} account(s), {} message(s); {} skipped.",
+                    report.imported.len(),
+                    report.messages,
+                    report.skipped.len()
+                ),
+                serde_json::json!({
+                    "input": input,
+                    "imported": report.imported,
+                    "skipped": report.skipped,
+                    "messages": report.messages,
+                }),
+            )
+        }
+        BackupCommand::Snapshot { .. } | BackupCommand::Restore { .. } => unreachable!(),
+    }
+}
+
+/// Write `users` to `output`. Returns `(accounts, messages)` written; accounts deleted while
+/// the export runs are left out.
+async fn export(
+    pool: &DbPool,
+    store: &MailboxStore,
+    users: &[String],
+    output: &Path,
+) -> Result<(usize, usize)> {
+    let file = File::create(output)
+        .map_err(|e| ChatmailError::config(format!("cannot create {}: {e}", output.display())))?;
+    let mut tar = tar::Builder::new(GzEncoder::new(BufWriter::new(file), Compression::default()));
+    let now = unix_now();
+    let manifest = Manifest {
+        format: FORMAT,
+        created_at: now,
+        accounts: users.len(),
+    };
+    append_json(&mut tar, MANIFEST, &manifest, now)?;
+
+    let (mut accounts, mut messages) = (0usize, 0usize);
+    for user in users {
+        let Some(password_hash) = passwords::get_user_hash(pool, user).await? else {
+            continue;
+        };
+        let record = AccountRecord {
+            username: user.clone(),
+            password_hash,
+            quota: get_quota_row(pool, user).await?.map(QuotaRecord::from),
+        };
+        append_json(
+            &mut tar,
+            &format!("accounts/{user}/{ACCOUNT_FILE}"),
+            &record,
+            now,
+        )?;
+        accounts += 1;
+
+        for mailbox in list_user_mailboxes(store, user).await? {
+            // Listing first brings the uidlist up to date with the files on disk.
+            let dates: HashMap<String, SystemTime> = list_mailbox_messages(store, user, &mailbox)
+                .await?
+                .into_iter()
+                .map(|m| (m.base_id, m.internal_date))
+                .collect();
+            let prefix = format!("accounts/{user}/mailboxes/{mailbox}");
+            append_dir(&mut tar, &prefix, now)?;
+            let paths = store.maildir_for_mailbox(user, &mailbox);
+            match std::fs::read(paths.root.join(UIDLIST_FILE)) {
+                Ok(uidlist) => {
+                    append(&mut tar, &format!("{prefix}/{UIDLIST_FILE}"), &uidlist, now)?;
+                }
+                Err(e) if e.kind() == std::io::ErrorKind::NotFound => {}
+                Err(e) => return Err(e.into()),
+            }
+            for (subdir, dir) in [("new", &paths.new), ("cur", &paths.cur)] {
+                for name in file_names(dir)? {
+                    // Expunged since the directory was read.
+                    let Some(body) = read_blob_known(store, user, &mailbox, &name).await? else {
+                        continue;
+                    };
+                    let mtime = dates
+                        .get(split_maildir_filename(&name).0)
+                        .and_then(|t| t.duration_since(UNIX_EPOCH).ok())
+                        .map_or(now, |d| d.as_secs() as i64);
+                    append(&mut tar, &format!("{prefix}/{subdir}/{name}"), &body, mtime)?;
+                    messages += 1;
+                }
+            }
+        }
+    }
+
+    tar.into_inner()
+        .and_then(|gz| gz.finish())
+        .and_then(|mut w| w.flush())?;
+    Ok((accounts, messages))
+}
+
+/// Restore every account of the archive at `input`.
+///
+/// An account that already has credentials is skipped unless `overwrite` is set, in which
+/// case its mail is deleted first. Credentials are written after the account's mail, so a
+/// maildir without credentials is the remainder of an interrupted import and is replaced.
+async fn import(
+    pool: &DbPool,
+    store: &MailboxStore,
+    input: &Path,
+    overwrite: bool,
+) -> Result<ImportReport> {
+    let file = File::open(input)
+        .map_err(|e| ChatmailError::config(format!("cannot open {}: {e}", input.display())))?;
+    let mut archive = tar::Archive::new(GzDecoder::new(BufReader::new(file)));
+    let mut report = ImportReport::default();
+    let mut manifest_seen = false;
+    // Account whose entries are being restored; its credentials are written when it ends.
+    let mut current: Option<AccountRecord> = None;
+
+    for entry in archive.entries().map_err(corrupt)? {
+        let mut entry = entry.map_err(corrupt)?;
+        let path = entry.path().map_err(corrupt)?.into_owned();
+        let member = parse_member(&path)?;
+        if !manifest_seen && !matches!(member, Member::Manifest) {
+            return Err(ChatmailError::config(format!(
+                "{} is not a madmail backup ({MANIFEST} must come first)",
+                input.display()
+            )));
+        }
+        match member {
+            Member::Manifest => {
+                let manifest: Manifest = serde_json::from_reader(&mut entry).map_err(corrupt)?;
+                if manifest.format != FORMAT {
+                    return Err(ChatmailError::config(format!(
+                        "unsupported backup format {} (expected {FORMAT})",
+                        manifest.format
+                    )));
+                }
+                manifest_seen = true;
+            }
+            Member::Account(user) => {
+                finish_account(pool, current.take(), &mut report).await?;
+                let record: AccountRecord = serde_json::from_reader(&mut entry).map_err(corrupt)?;
+                if record.username != user {
+                    return Err(corrupt(format!(
+                        "{} names {}",
+                        path.display(),
+                        record.username
+                    )));
+                }
+                if passwords::user_exists(pool, &user).await? && !overwrite {
+                    report.skipped.push(user);
+                    continue;
+                }
+                let root = store.user_root(&user);
+                if root.exists() {
+                    tokio::fs::remove_dir_all(&root).await?;
+                }
+                store.remove_cold(&user).await?;
+                current = Some(record);
+            }
+            Member::Mailbox { user, mailbox } => {
+                if restoring(&current, &user) {
+                    store.init_mailbox_dir(&user, &mailbox).await?;
+                }
+            }
+            Member::File {
+                user,
+                mailbox,
+                subdir,
+                name,
+            } => {
+                if !restoring(&current, &user) || !entry.header().entry_type().is_file() {
+                    continue;
+                }
+                let paths = store.init_mailbox_dir(&user, &mailbox).await?;
+                let target = match subdir {
+                    Some(subdir) => paths.root.join(subdir).join(&name),
+                    None => paths.root.join(&name),
+                };
+                let mtime = UNIX_EPOCH + Duration::from_secs(entry.header().mtime().unwrap_or(0));
+                restore_file(&mut entry, &paths.tmp.join(&name), &target, mtime)?;
+                if subdir.is_some() {
+                    report.messages += 1;
+                }
+            }
+        }
+    }
+    finish_account(pool, current, &mut report).await?;
+    if !manifest_seen {
+        return Err(ChatmailError::config(format!(
+            "{} is not a madmail backup (empty archive)",
+            input.display()
+        )));
+    }
+    Ok(report)
+}
+
+fn restoring(current: &Option<AccountRecord>, user: &str) -> bool {
+    current.as_ref().is_some_and(|r| r.username == user)
+}
+
+async fn finish_account(
+    pool: &DbPool,
+    record: Option<AccountRecord>,
+    report: &mut ImportReport,
+) -> Result<()> {
+    let Some(record) = record else {
+        return Ok(());
+    };
+    passwords::create_user(pool, &record.username, &record.password_hash).await?;
+    if let Some(quota) = &record.quota {
+        put_quota_row(pool, &record.username, &quota.into()).await?;
+    }
+    report.imported.push(record.username);
+    Ok(())
+}
+
+/// What an archive path refers to.
+enum Member {
+    Manifest,
+    Account(String),
+    Mailbox {
+        user: String,
+        mailbox: String,
+    },
+    /// A message (`subdir` is `new` or `cur`) or, without `subdir`, the mailbox uidlist.
+    File {
+        user: String,
+        mailbox: String,
+        subdir: Option<&'static str>,
+        name: String,
+    },
+}
+
+/// Map an archive path onto the layout; anything else (including `..` or absolute paths)
+/// is rejected rather than skipped, since this tool wrote the archive.
+fn parse_member(path: &Path) -> Result<Member> {
+    let mut parts = Vec::new();
+    for component in path.components() {
+        match component {
+            Component::Normal(part) => match part.to_str() {
+                Some(part) => parts.push(part),
+                None => return Err(unexpected(path)),
+            },
+            Component::CurDir => {}
+            _ => return Err(unexpected(path)),
+        }
+    }
+    let member = match parts.as_slice() {
+        [MANIFEST] => Member::Manifest,
+        ["accounts", user, ACCOUNT_FILE] => Member::Account(user.to_string()),
+        ["accounts", user, "mailboxes", mailbox] => Member::Mailbox {
+            user: user.to_string(),
+            mailbox: mailbox.to_string(),
+        },
+        ["accounts", user, "mailboxes", mailbox, UIDLIST_FILE] => Member::File {
+            user: user.to_string(),
+            mailbox: mailbox.to_string(),
+            subdir: None,
+            name: UIDLIST_FILE.to_string(),
+        },
+        ["accounts", user, "mailboxes", mailbox, subdir @ ("new" | "cur"), name] => Member::File {
+            user: user.to_string(),
+            mailbox: mailbox.to_string(),
+            subdir: Some(if *subdir == "new" { "new" } else { "cur" }),
+            name: name.to_string(),
+        },
+        _ => return Err(unexpected(path)),
+    };
+    Ok(member)
+}
+
+/// Write through `tmp` and rename into place, like delivery, so no torn file is left behind.
+fn restore_file(entry: &mut impl Read, tmp: &Path, target: &Path, mtime: SystemTime) -> Result<()> {
+    let mut file = File::create(tmp)?;
+    std::io::copy(entry, &mut file)?;
+    file.set_modified(mtime)?;
+    drop(file);
+    std::fs::rename(tmp, target)?;
+    Ok(())
+}
+
+/// Regular files in `dir`, sorted; a missing directory has none.
+fn file_names(dir: &Path) -> Result<Vec<String>> {
+    let read_dir = match std::fs::read_dir(dir) {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => return Err(e.into()),
+    };
+    let mut names = Vec::new();
+    for entry in read_dir {
+        let entry = entry?;
+        if entry.file_type()?.is_file() {
+            names.push(entry.file_name().to_string_lossy().into_owned());
+        }
+    }
+    names.sort();
+    Ok(names)
+}
+
+fn append<W: Write>(tar: &mut tar::Builder<W>, path: &str, data: &[u8], mtime: i64) -> Result<()> {
+    let mut header = tar::Header::new_gnu();
+    header.set_size(data.len() as u64);
+    header.set_mode(0o600);
+    header.set_mtime(mtime.max(0) as u64);
+    tar.append_data(&mut header, path, data)?;
+    Ok(())
+}
+
+fn append_json<W: Write>(
+    tar: &mut tar::Builder<W>,
+    path: &str,
+    value: &impl Serialize,
+    mtime: i64,
+) -> Result<()> {
+    let data =
+        serde_json::to_vec_pretty(value).map_err(|e| ChatmailError::config(e.to_string()))?;
+    append(tar, path, &data, mtime)
+}
+
+fn append_dir<W: Write>(tar: &mut tar::Builder<W>, path: &str, mtime: i64) -> Result<()> {
+    let mut header = tar::Header::new_gnu();
+    header.set_entry_type(tar::EntryType::Directory);
+    header.set_size(0);
+    header.set_mode(0o700);
+    header.set_mtime(mtime.max(0) as u64);
+    tar.append_data(&mut header, format!("{path}/"), std::io::empty())?;
+    Ok(())
+}
+
+fn corrupt(e: impl std::fmt::Display) -> ChatmailError {
+    ChatmailError::config(format!("corrupt backup archive: {e}"))
+}
+
+fn unexpected(path: &Path) -> ChatmailError {
+    corrupt(format!("unexpected entry {}", path.display()))
+}
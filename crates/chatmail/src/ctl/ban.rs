@@ -0,0 +1,149 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail ban` — IP / network bans (`bans`, audited in `ban_audit`).
+//!
+//! Writes go straight to the database; a running server re-reads the table every 30 s.
+
+use chatmail_config::{parse_duration, Args, BanCommand};
+use chatmail_db::bans::BAN_SOURCE_MANUAL;
+use chatmail_db::policies::unix_now;
+use chatmail_types::{ChatmailError, Result};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+const ACTOR: &str = "cli";
+/// Audit rows shown under `ban list`.
+const AUDIT_LIMIT: i64 = 20;
+
+pub async fn ban(args: &Args, cmd: &BanCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let pool = ctx.open_pool().await?;
+    let out = CtlOut::from_args(args, "ban");
+
+    match cmd {
+        BanCommand::Add {
+            cidr,
+            reason,
+            expires,
+        } => {
+            let expires_at = match expires.as_deref() {
+                Some(raw) => {
+                    let d = parse_duration(raw).map_err(|_| {
+                        ChatmailError::config(format!("invalid --expires duration: {raw}"))
+                    })?;
+                    unix_now() + d.as_secs() as i64
+                }
+                None => 0,
+            };
+            let ban =
+                chatmail_db::add_ban(&pool, cidr, BAN_SOURCE_MANUAL, reason, expires_at).await?;
+            chatmail_db::record_ban_audit(
+                &pool,
+                "add",
+                &ban.cidr,
+                BAN_SOURCE_MANUAL,
+                ACTOR,
+                &ban.reason,
+            )
+            .await?;
+            out.done_msg(
+                format!("Success: banned {}.", ban.cidr),
+                serde_json::json!({
+                    "cidr": ban.cidr,
+                    "reason": ban.reason,
+                    "expires_at": ban.expires_at,
+                }),
+                format!("Banned {}", ban.cidr),
+            )?;
+        }
+        BanCommand::List { all } => {
+            let bans = chatmail_db::list_bans(&pool, *all).await?;
+            let audit = chatmail_db::list_ban_audit(&pool, AUDIT_LIMIT).await?;
+            let now = unix_now();
+            if out.is_json() {
+                let bans: Vec<_> = bans
+                    .iter()
+                    .map(|b| {
+                        serde_json::json!({
+                            "cidr": b.cidr,
+                            "reason": b.reason,
+                            "source": b.source,
+                            "created_at": b.created_at,
+                            "expires_at": b.expires_at,
+                            "strikes": b.strikes,
+                            "active": b.is_active(now),
+                        })
+                    })
+                    .collect();
+                let audit: Vec<_> = audit
+                    .iter()
+                    .map(|a| {
+                        serde_json::json!({
+                            "action": a.action,
+                            "cidr": a.cidr,
+                            "source": a.source,
+                            "actor": a.actor,
+                            "reason": a.reason,
+                            "created_at": a.created_at,
+                        })
+                    })
+                    .collect();
+                return out.emit(serde_json::json!({ "bans": bans, "audit": audit }));
+            }
+            out.line("CIDR\tSOURCE\tEXPIRES\tSTRIKES\tREASON");
+            for b in &bans {
+                let expires = match b.expires_at {
+                    0 => "never".to_string(),
+                    _ if !b.is_active(now) => "expired".to_string(),
+                    t => format!("in {}s", t - now),
+                };
+                out.line(format!(
+                    "{}\t{}\t{}\t{}\t{}",
+                    b.cidr, b.source, expires, b.strikes, b.reason
+                ));
+            }
+            out.line("---");
+            out.line(format!("Total: {} bans.", bans.len()));
+            if !audit.is_empty() {
+                out.line("");
+                out.line("Recent changes (newest first):");
+                for a in &audit {
+                    out.line(format!(
+                        "{}\t{}\t{}\t{}\t{}",
+                        a.created_at, a.action, a.cidr, a.actor, a.reason
+                    ));
+                }
+            }
+        }
+        BanCommand::Remove { cidr } => {
+            let cidr = chatmail_db::normalize_ban_target(cidr)?;
+            let Some(ban) = chatmail_db::get_ban(&pool, &cidr).await? else {
+                return Err(ChatmailError::config(format!("no ban for {cidr}")));
+            };
+            chatmail_db::remove_ban(&pool, &cidr).await?;
+            chatmail_db::record_ban_audit(&pool, "remove", &cidr, &ban.source, ACTOR, "").await?;
+            out.done_msg(
+                format!("Success: lifted ban on {cidr}."),
+                serde_json::json!({ "cidr": cidr }),
+                format!("Removed ban {cidr}"),
+            )?;
+        }
+    }
+    Ok(())
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail broadcast` — an announcement to every (or every matching) local account.
+//!
+//! The running server does the delivery (`send` → POST `/admin/broadcast`), so the paced
+//! job, its audit entries and `broadcast_sender` are the same as from the admin API.
+//! `send --dry-run` counts the matching accounts straight from the database and works with
+//! the server down; `status` reads GET `/admin/broadcast/{id}`.
+
+use std::time::Duration;
+
+use chatmail_config::{Args, BroadcastArgs, BroadcastCommand};
+use chatmail_db::policies::unix_now;
+use chatmail_state::{select_recipients, BroadcastFilter};
+use chatmail_types::{ChatmailError, Result};
+use serde_json::{json, Value};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use super::request_reload::{admin_get, admin_request};
+
+const WAIT_POLL: Duration = Duration::from_secs(1);
+
+/// `--active-since` / `--domain` / `--created-after` / `--account` as a
+/// [`BroadcastFilter`] string (clap keeps them mutually exclusive).
+fn filter_arg(cmd: &BroadcastArgs) -> String {
+    if let Some(d) = &cmd.active_since {
+        format!("active:{d}")
+    } else if let Some(domain) = &cmd.domain {
+        format!("domain:{domain}")
+    } else if let Some(day) = &cmd.created_after {
+        format!("created_after:{day}")
+    } else if !cmd.account.is_empty() {
+        format!("accounts:{}", cmd.account.join(","))
+    } else {
+        "all".into()
+    }
+}
+
+pub async fn broadcast(args: &Args, cmd: &BroadcastCommand) -> Result<()> {
+    match cmd {
+        BroadcastCommand::Send(send_args) => send(args, send_args).await,
+        BroadcastCommand::Status { id, url, insecure } => {
+            status(args, id.as_deref(), url.as_deref(), *insecure).await
+        }
+    }
+}
+
+async fn send(args: &Args, cmd: &BroadcastArgs) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "broadcast");
+    let filter = BroadcastFilter::parse(&filter_arg(cmd))?;
+
+    if cmd.dry_run {
+        let pool = ctx.open_pool().await?;
+        let recipients = select_recipients(&pool, &filter, unix_now()).await?;
+        return out.done(
+            format!(
+                "Dry run: {} account(s) match filter {}; nothing sent.",
+                recipients.len(),
+                filter.label()
+            ),
+            json!({ "dry_run": true, "filter": filter.label(), "recipients": recipients.len() }),
+        );
+    }
+
+    let body = match (&cmd.body, &cmd.body_file) {
+        (Some(body), _) => body.clone(),
+        (None, Some(path)) => std::fs::read_to_string(path).map_err(|e| {
+            ChatmailError::config(format!("read --body-file {}: {e}", path.display()))
+        })?,
+        (None, None) => return Err(ChatmailError::config("--body or --body-file is required")),
+    };
+    let request = json!({
+        "subject": cmd.subject,
+        "body": body,
+        "markdown": cmd.markdown,
+        "filter": filter.label(),
+        "actor": "cli",
+    });
+    let url = cmd.url.as_deref();
+    let mut job = admin_request(
+        &ctx,
+        url,
+        cmd.insecure,
+        "POST",
+        "/admin/broadcast",
+        Some(request),
+    )
+    .await?;
+    let id = job["id"].as_str().unwrap_or_default().to_string();
+    if !out.is_json() {
+        out.line(format!(
+            "Broadcast {id} queued for {} account(s).",
+            job["recipients"]
+        ));
+    }
+    if cmd.wait {
+        while job["state"] != "finished" {
+            tokio::time::sleep(WAIT_POLL).await;
+            job = admin_get(&ctx, url, cmd.insecure, &format!("/admin/broadcast/{id}")).await?;
+        }
+    }
+    if out.is_json() {
+        return out.emit(job);
+    }
+    if cmd.wait {
+        for line in format_result(&job) {
+            out.line(line);
+        }
+    }
+    Ok(())
+}
+
+async fn status(args: &Args, id: Option<&str>, url: Option<&str>, insecure: bool) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "broadcast");
+    let Some(id) = id else {
+        let body = admin_get(&ctx, url, insecure, "/admin/broadcast").await?;
+        if out.is_json() {
+            return out.emit(body);
+        }
+        let jobs = body["jobs"].as_array().cloned().unwrap_or_default();
+        if jobs.is_empty() {
+            out.line("No broadcast jobs since the server started.");
+        }
+        for job in jobs {
+            out.line(format_job(&job));
+        }
+        return Ok(());
+    };
+    let job = admin_get(&ctx, url, insecure, &format!("/admin/broadcast/{id}")).await?;
+    if out.is_json() {
+        return out.emit(job);
+    }
+    out.line(format_job(&job));
+    for line in format_result(&job) {
+        out.line(line);
+    }
+    Ok(())
+}
+
+/// `<id>  <state>  <subject>  (<filter>, <delivered>/<recipients>)`.
+fn format_job(job: &Value) -> String {
+    let text = |key: &str| job[key].as_str().unwrap_or_default().to_string();
+    format!(
+        "{}  {}  {}  ({}, {}/{})",
+        text("id"),
+        text("state"),
+        text("subject"),
+        text("filter"),
+        job["delivered"],
+        job["recipients"]
+    )
+}
+
+/// Delivered / failed counters and the per-account failures the job kept.
+fn format_result(job: &Value) -> Vec<String> {
+    let mut lines = vec![format!(
+        "Delivered {}, failed {}.",
+        job["delivered"], job["failed"]
+    )];
+    for failure in job["failures"].as_array().into_iter().flatten() {
+        lines.push(format!(
+            "  {}: {}",
+            failure["account"].as_str().unwrap_or_default(),
+            failure["error"].as_str().unwrap_or_default()
+        ));
+    }
+    lines
+}
@@ -94,6 +94,7 @@ async fn autocert_enable(args: &Args, a: &CertificateAutocertEnableArgs) -> Resu
         key_path: Some(key_path),
         http_listen,
         staging: a.staging,
+        directory_url: cfg.acme_directory.clone(),
         skip_if_valid: true,
     };
 
@@ -281,8 +282,11 @@ async fn certificate_status(args: &Args) -> Result<()> {
     };
 
     let cert_kind = describe_cert_kind(&cfg.tls_mode, info.issuer_kind);
+    let warning_days = (cfg.effective_cert_expiry_warning().as_secs() / 86_400) as i64;
     let status = if info.days_remaining <= 0 {
         "expired"
+    } else if info.days_remaining <= warning_days {
+        "expiring"
     } else {
         "valid"
     };
@@ -299,6 +303,8 @@ async fn certificate_status(args: &Args) -> Result<()> {
             "not_before": info.not_before,
             "not_after": info.not_after,
             "days_remaining": info.days_remaining,
+            "warning_days": warning_days,
+            "renewal_hook": cfg.effective_renewal_hook(),
             "status": status,
         }));
     }
@@ -336,10 +342,12 @@ async fn certificate_status(args: &Args) -> Result<()> {
     out.line(format!("Valid until:     {}", info.not_after));
     out.line(format!("Days remaining:  {}", info.days_remaining));
 
-    if info.days_remaining <= 0 {
-        out.line("Status:          expired");
-    } else {
-        out.line("Status:          valid");
+    out.line(format!("Status:          {status}"));
+    out.line(format!(
+        "Expiry warning:  {warning_days} days before expiry"
+    ));
+    if let Some(hook) = cfg.effective_renewal_hook() {
+        out.line(format!("Renewal hook:    {hook}"));
     }
 
     if cfg.tls_mode.as_deref() == Some("autocert") {
@@ -363,7 +371,7 @@ async fn certificate_status(args: &Args) -> Result<()> {
             out.line("Renewal:         not needed yet");
         }
     } else if cfg.tls_mode.as_deref() == Some("file") {
-        out.line("Auto-renewal:    disabled (replace PEM files manually, then reload)");
+        out.line("Auto-renewal:    disabled (replace PEM files; reloaded within a minute)");
         out.line("Tip:             `madmail certificate autocert enable --email you@example.com`");
     } else if cfg.tls_mode.as_deref() == Some("self_signed") {
         out.line("Auto-renewal:    disabled (self-signed; use `madmail certificate regenerate` or reinstall)");
@@ -414,6 +422,7 @@ async fn certificate_obtain(
         key_path: Some(key_path),
         http_listen,
         staging: a.staging,
+        directory_url: a.acme_directory.clone().or(cfg.acme_directory.clone()),
         skip_if_valid,
     };
 
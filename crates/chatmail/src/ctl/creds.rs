@@ -0,0 +1,180 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `madmail creds rate-limit` — per-account sending counters behind `max_messages_per_hour`
+//! (`sending_stats` table, read and cleared directly in the database).
+
+use chatmail_auth::normalize_username;
+use chatmail_config::cli::{CredsCommand, RateLimitCommand};
+use chatmail_config::Args;
+use chatmail_db::policies::unix_now;
+use chatmail_db::{reset_sending_stats, sending_hour, sending_stats_for, top_senders};
+use chatmail_types::Result;
+use serde_json::json;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+/// Hours covered by `rate-limit show`.
+const SHOW_HOURS: i64 = 24;
+/// Accounts listed by `rate-limit show` without a username.
+const TOP_SENDERS: i64 = 20;
+
+pub async fn creds(args: &Args, cmd: &CredsCommand) -> Result<()> {
+    match cmd {
+        CredsCommand::RateLimit(cmd) => rate_limit(args, cmd).await,
+    }
+}
+
+async fn rate_limit(args: &Args, cmd: &RateLimitCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let pool = ctx.open_pool().await?;
+    let limit = ctx.config.max_messages_per_hour;
+    let hour = sending_hour(unix_now());
+
+    match cmd {
+        RateLimitCommand::Show { username: None } => {
+            let out = CtlOut::from_args(args, "creds rate-limit show");
+            let top = top_senders(&pool, hour - SHOW_HOURS + 1, TOP_SENDERS).await?;
+            if out.is_json() {
+                let senders: Vec<_> = top
+                    .iter()
+                    .map(|s| {
+                        json!({
+                            "username": s.username,
+                            "messages": s.messages,
+                            "recipients": s.recipients,
+                        })
+                    })
+                    .collect();
+                return out.emit(json!({
+                    "max_messages_per_hour": limit,
+                    "max_rcpt_per_message": ctx.config.max_rcpt_per_message,
+                    "hours": SHOW_HOURS,
+                    "senders": senders,
+                }));
+            }
+            out.line(limits_line(limit, ctx.config.max_rcpt_per_message));
+            if top.is_empty() {
+                out.line(format!("No submissions in the last {SHOW_HOURS} hours."));
+            }
+            for s in top {
+                out.line(format!(
+                    "{}\t{} messages\t{} recipients",
+                    s.username, s.messages, s.recipients
+                ));
+            }
+            Ok(())
+        }
+        RateLimitCommand::Show {
+            username: Some(username),
+        } => {
+            let out = CtlOut::from_args(args, "creds rate-limit show");
+            let u = account(&ctx, username)?;
+            let rows = sending_stats_for(&pool, &u, hour - SHOW_HOURS + 1).await?;
+            let this_hour = rows
+                .iter()
+                .find(|r| r.hour == hour)
+                .map_or(0, |r| r.messages);
+            if out.is_json() {
+                let hours: Vec<_> = rows
+                    .iter()
+                    .map(|r| {
+                        json!({
+                            "hour_start": r.hour * 3600,
+                            "messages": r.messages,
+                            "recipients": r.recipients,
+                        })
+                    })
+                    .collect();
+                return out.emit(json!({
+                    "username": u,
+                    "max_messages_per_hour": limit,
+                    "messages_this_hour": this_hour,
+                    "hours": hours,
+                }));
+            }
+            out.line(match limit {
+                Some(l) => format!("{u}: {this_hour}/{l} messages this hour"),
+                None => format!("{u}: {this_hour} messages this hour (no limit)"),
+            });
+            for r in rows {
+                out.line(format!(
+                    "{}\t{} messages\t{} recipients",
+                    format_hour(r.hour),
+                    r.messages,
+                    r.recipients
+                ));
+            }
+            Ok(())
+        }
+        RateLimitCommand::Reset { username } => {
+            let out = CtlOut::from_args(args, "creds rate-limit reset");
+            let u = account(&ctx, username)?;
+            let cleared = reset_sending_stats(&pool, &u).await?;
+            out.done_msg(
+                format!("Reset sending counters for {u} ({cleared} hour buckets cleared)"),
+                json!({ "username": u, "cleared": cleared }),
+                format!("Reset sending counters for {u}"),
+            )
+        }
+    }
+}
+
+/// Bare local parts get the registration domain, as in `madmail delete`.
+fn account(ctx: &CtlContext, username: &str) -> Result<String> {
+    let host = ctx.config.hostname.as_deref().unwrap_or("127.0.0.1");
+    let domain = ctx.config.effective_registration_domain(Some(host));
+    if username.trim().contains('@') {
+        normalize_username(username.trim())
+    } else {
+        normalize_username(&format!("{}@{domain}", username.trim()))
+    }
+}
+
+fn limits_line(per_hour: Option<u32>, per_message: Option<usize>) -> String {
+    let fmt = |v: Option<String>| v.unwrap_or_else(|| "unlimited".into());
+    format!(
+        "[ SENDING LIMITS ] {} messages per hour, {} recipients per message",
+        fmt(per_hour.map(|n| n.to_string())),
+        fmt(per_message.map(|n| n.to_string())),
+    )
+}
+
+fn format_hour(hour: i64) -> String {
+    let Ok(fmt) = time::format_description::parse("[year]-[month]-[day] [hour]:00 UTC") else {
+        return hour.to_string();
+    };
+    time::OffsetDateTime::from_unix_timestamp(hour * 3600)
+        .ok()
+        .and_then(|dt| dt.format(&fmt).ok())
+        .unwrap_or_else(|| hour.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn formats_limits_and_hours() {
+        assert_eq!(
+            limits_line(Some(30), None),
+            "[ SENDING LIMITS ] 30 messages per hour, unlimited recipients per message"
+        );
+        assert_eq!(format_hour(480_000), "2024-10-04 00:00 UTC");
+    }
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail db backup` — timestamped online copies of the SQLite databases.
+
+use chatmail_config::{Args, DbCommand};
+use chatmail_db::policies::unix_now;
+use chatmail_db::{backup_databases, backup_stamp, sqlite_databases, DatabaseBackup, BACKUP_DIR};
+use chatmail_types::{ChatmailError, Result};
+use serde_json::Value;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn db(args: &Args, cmd: &DbCommand) -> Result<()> {
+    match cmd {
+        DbCommand::Backup { output } => {
+            let ctx = CtlContext::from_args(args)?;
+            let out = CtlOut::from_args(args, "db backup");
+            let dbs = sqlite_databases(&ctx.state_dir, &ctx.config)?;
+            let dir = output
+                .clone()
+                .unwrap_or_else(|| ctx.state_dir.join(BACKUP_DIR));
+            let report = backup_databases(&dbs, &dir, &backup_stamp(unix_now())).await;
+            for db in &report {
+                out.line(backup_line(db));
+            }
+            let failed = report.iter().filter(|db| db.error.is_some()).count();
+            let summary = serde_json::json!({
+                "directory": dir,
+                "databases": report.iter().map(backup_json).collect::<Vec<_>>(),
+            });
+            if failed > 0 {
+                if out.is_json() {
+                    out.emit(summary)?;
+                }
+                return Err(ChatmailError::storage(format!(
+                    "{failed} of {} database backup(s) failed",
+                    report.len()
+                )));
+            }
+            out.done(
+                format!(
+                    "Success: {} database(s) copied to {}.",
+                    report.len(),
+                    dir.display()
+                ),
+                summary,
+            )
+        }
+    }
+}
+
+fn backup_line(db: &DatabaseBackup) -> String {
+    match (&db.output, &db.error) {
+        (Some(path), _) => format!("{}: {} ({} bytes)", db.name, path.display(), db.bytes),
+        (None, error) => format!(
+            "FAILED {}: {}",
+            db.name,
+            error.as_deref().unwrap_or("unknown error")
+        ),
+    }
+}
+
+/// One entry of the `databases` list; `POST /admin/backup` returns the same fields.
+fn backup_json(db: &DatabaseBackup) -> Value {
+    serde_json::json!({
+        "name": db.name,
+        "source": db.source,
+        "path": db.output,
+        "bytes": db.bytes,
+        "error": db.error,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use std::path::PathBuf;
+
+    use super::*;
+
+    #[test]
+    fn report_lines_name_the_copy_or_the_error() {
+        let ok = DatabaseBackup {
+            name: "credentials".into(),
+            source: PathBuf::from("/var/lib/maddy/credentials.db"),
+            output: Some(PathBuf::from("/srv/db/credentials-20261016T093000Z.db")),
+            bytes: 4096,
+            error: None,
+        };
+        assert_eq!(
+            backup_line(&ok),
+            "credentials: /srv/db/credentials-20261016T093000Z.db (4096 bytes)"
+        );
+        let failed = DatabaseBackup {
+            name: "sharing".into(),
+            output: None,
+            bytes: 0,
+            error: Some("disk full".into()),
+            ..ok
+        };
+        assert_eq!(backup_line(&failed), "FAILED sharing: disk full");
+        assert_eq!(backup_json(&failed)["path"], Value::Null);
+    }
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail delivery-stats` — per-destination-domain caps of the running outbound queue
+//! (GET `/admin/delivery-stats`; the throttle state lives in the server process only).
+
+use chatmail_config::Args;
+use chatmail_types::Result;
+use serde_json::Value;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use super::request_reload::admin_get;
+
+pub async fn delivery_stats(args: &Args, url_override: Option<&str>, insecure: bool) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    ctx.require_db()?;
+    let out = CtlOut::from_args(args, "delivery-stats");
+
+    let body = admin_get(&ctx, url_override, insecure, "/admin/delivery-stats").await?;
+    if out.is_json() {
+        return out.emit(body);
+    }
+    for line in format_stats(&body) {
+        out.line(line);
+    }
+    Ok(())
+}
+
+fn format_stats(body: &Value) -> Vec<String> {
+    let num = |v: &Value, key: &str| v[key].as_u64().unwrap_or(0);
+    let mut lines = vec![format!(
+        "[ DELIVERY THROTTLE ] default {} parallel / {} per minute per domain",
+        num(body, "max_parallel_per_domain"),
+        num(body, "max_messages_per_minute_per_domain"),
+    )];
+    if let Some(depth) = body["queue_depth"].as_u64() {
+        lines.push(format!("Queued: {depth}"));
+    }
+    let domains = body["domains"].as_array().map(Vec::as_slice).unwrap_or(&[]);
+    if domains.is_empty() {
+        lines.push("No remote deliveries since start.".into());
+        return lines;
+    }
+    for d in domains {
+        let mut line = format!(
+            "- {} : {}/{} in flight, {}/{} this minute, {} delivered, {} held, {} rate-limited",
+            d["domain"].as_str().unwrap_or("?"),
+            num(d, "in_flight"),
+            num(d, "effective_parallel"),
+            num(d, "started_last_minute"),
+            num(d, "effective_per_minute"),
+            num(d, "delivered"),
+            num(d, "waits"),
+            num(d, "rate_limited"),
+        );
+        if d["reduced"].as_bool().unwrap_or(false) {
+            line.push_str(&format!(
+                " (REDUCED from {}/{})",
+                num(d, "max_parallel"),
+                num(d, "max_per_minute")
+            ));
+        }
+        lines.push(line);
+    }
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn format_marks_reduced_domains() {
+        let body = serde_json::json!({
+            "max_parallel_per_domain": 4,
+            "max_messages_per_minute_per_domain": 60,
+            "queue_depth": 7,
+            "domains": [{
+                "domain": "gmail.com", "in_flight": 1, "started_last_minute": 3,
+                "max_parallel": 4, "max_per_minute": 60,
+                "effective_parallel": 1, "effective_per_minute": 15,
+                "reduced": true, "rate_limited": 2, "waits": 5, "delivered": 9,
+            }],
+        });
+        let lines = format_stats(&body);
+        assert_eq!(
+            lines[0],
+            "[ DELIVERY THROTTLE ] default 4 parallel / 60 per minute per domain"
+        );
+        assert_eq!(lines[1], "Queued: 7");
+        assert_eq!(
+            lines[2],
+            "- gmail.com : 1/1 in flight, 3/15 this minute, 9 delivered, 5 held, \
+             2 rate-limited (REDUCED from 4/60)"
+        );
+    }
+}
@@ -0,0 +1,246 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `madmail dev` — a throwaway local instance for contributors and integration tests.
+//!
+//! Renders the same `maddy.conf` template as `madmail install` (IP mode for
+//! `127.0.0.1`, self-signed TLS, `turn_off_tls`), with every listener on loopback at
+//! `--port-base` plus the standard port and logging to stderr. A test account is created
+//! before the server starts. The temp state directory is removed on exit unless `--keep`
+//! is passed or `--state` names the directory.
+
+use std::path::{Path, PathBuf};
+
+use chatmail_auth::{hash_password, random_string};
+use chatmail_config::{build_dclogin_link, Args, DcloginMailSettings};
+use chatmail_storage::MailboxStore;
+use chatmail_types::{wrap_ip_domain, ChatmailError, Result};
+use serde_json::json;
+
+use super::account_ops::provision_account;
+use super::context::CtlContext;
+use super::install::{
+    chrono_like_now, local_domains_for_ip, render_maddy_conf, InstallConfig, ListenPorts,
+};
+use super::output::CtlOut;
+
+/// Loopback address every dev listener binds to.
+pub const DEV_HOST: &str = "127.0.0.1";
+
+/// Local part of the pre-created test account.
+pub const DEV_ACCOUNT: &str = "dev";
+
+const DEV_PASSWORD_LEN: usize = 16;
+const DEV_CHARSET: &str = "abcdefghijklmnopqrstuvwxyz0123456789";
+
+/// Removes a generated state directory when dropped.
+struct TempState {
+    path: PathBuf,
+    remove: bool,
+}
+
+impl Drop for TempState {
+    fn drop(&mut self) {
+        if self.remove {
+            let _ = std::fs::remove_dir_all(&self.path);
+        }
+    }
+}
+
+pub async fn dev(args: &Args, port_base: u16, state: Option<&Path>, keep: bool) -> Result<()> {
+    let listen = ListenPorts::offset(DEV_HOST, port_base).ok_or_else(|| {
+        ChatmailError::config(format!(
+            "--port-base {port_base} is too high: the IMAPS listener needs base + 993 <= 65535"
+        ))
+    })?;
+    let (state_dir, generated) = match state {
+        Some(dir) => (dir.to_path_buf(), false),
+        None => {
+            let suffix = random_string(DEV_CHARSET, 8)?;
+            (
+                std::env::temp_dir().join(format!("madmail-dev-{suffix}")),
+                true,
+            )
+        }
+    };
+    let temporary = generated && !keep;
+    std::fs::create_dir_all(&state_dir)?;
+    let _cleanup = TempState {
+        path: state_dir.clone(),
+        remove: temporary,
+    };
+
+    let config_path = state_dir.join("madmail.conf");
+    std::fs::write(
+        &config_path,
+        render_maddy_conf(&dev_install_config(&state_dir, listen.clone())),
+    )?;
+    let run_args = Args {
+        config: config_path.clone(),
+        state_dir: state_dir.clone(),
+        boot_once: args.boot_once,
+        json: args.json,
+    };
+
+    let ctx = CtlContext::from_args(&run_args)?;
+    let pool = ctx.open_pool().await?;
+    let mailbox = MailboxStore::new(&ctx.state_dir);
+    let domain = ctx.config.effective_registration_domain(Some(DEV_HOST));
+    let email = format!("{DEV_ACCOUNT}@{domain}");
+    let password = random_string(DEV_CHARSET, DEV_PASSWORD_LEN)?;
+    provision_account(&pool, &mailbox, &email, &hash_password(&password)?).await?;
+    pool.close().await;
+    let dclogin = build_dclogin_link(
+        &email,
+        &password,
+        &DcloginMailSettings::from_config(&ctx.config, None),
+    );
+
+    let out = CtlOut::from_args(args, "dev");
+    let endpoints = endpoint_rows(&listen);
+    if out.is_json() {
+        let endpoints: serde_json::Map<_, _> = endpoints
+            .iter()
+            .map(|(name, addr, _)| (name.to_string(), json!(addr)))
+            .collect();
+        out.emit(json!({
+            "state_dir": state_dir,
+            "config": config_path,
+            "temporary": temporary,
+            "endpoints": endpoints,
+            "account": { "email": email, "password": password },
+            "dclogin": dclogin,
+        }))?;
+    } else {
+        out.line("[ MADMAIL DEV ]");
+        for (name, addr, note) in &endpoints {
+            out.line(format!("  {name:<14} {addr:<24} {note}"));
+        }
+        out.line(format!("  {:<14} {}", "state", state_dir.display()));
+        out.line(format!("  {:<14} {email}", "test account"));
+        out.line(format!("  {:<14} {password}", "password"));
+        out.line(format!("  {:<14} {dclogin}", "dclogin"));
+        if temporary {
+            out.line("State is removed on exit (pass --keep to preserve it). Ctrl+C to stop.");
+        } else {
+            out.line("State is kept on exit. Ctrl+C to stop.");
+        }
+    }
+
+    crate::boot::run(run_args).await
+}
+
+/// `madmail install --simple --ip 127.0.0.1` plan, rebased onto `state_dir` and `listen`.
+fn dev_install_config(state_dir: &Path, listen: ListenPorts) -> InstallConfig {
+    let certs = state_dir.join("certs");
+    InstallConfig {
+        binary_name: "madmail".into(),
+        binary_path: std::env::current_exe().unwrap_or_else(|_| PathBuf::from("madmail")),
+        maddy_user: String::new(),
+        maddy_group: String::new(),
+        hostname: DEV_HOST.into(),
+        primary_domain: wrap_ip_domain(DEV_HOST),
+        local_domains: local_domains_for_ip(DEV_HOST),
+        extra_domains: vec![],
+        state_dir: state_dir.to_path_buf(),
+        runtime_dir: state_dir.join("run").to_string_lossy().into_owned(),
+        public_ip: DEV_HOST.into(),
+        tls_mode: "self_signed".into(),
+        cert_path: certs.join("fullchain.pem"),
+        key_path: certs.join("privkey.pem"),
+        acme_email: String::new(),
+        acme_directory: String::new(),
+        generate_certs: false,
+        turn_off_tls: true,
+        enable_chatmail: true,
+        enable_contact_sharing: true,
+        enable_ss: false,
+        enable_turn: false,
+        enable_iroh: false,
+        turn_port: String::new(),
+        turn_secret: String::new(),
+        turn_ttl: 0,
+        ss_addr: String::new(),
+        ss_password: String::new(),
+        ss_cipher: String::new(),
+        language: "en".into(),
+        config_dir: state_dir.to_path_buf(),
+        config_path: state_dir.join("madmail.conf"),
+        paths_explicit: true,
+        use_default_systemd_paths: false,
+        system_install: false,
+        skip_user: true,
+        skip_systemd: true,
+        generated: chrono_like_now(),
+        listen,
+        debug_log: true,
+        dkim_algorithm: Default::default(),
+        dkim_dual: false,
+    }
+}
+
+/// `(name, address, note)` rows of the summary table.
+fn endpoint_rows(l: &ListenPorts) -> Vec<(&'static str, String, &'static str)> {
+    let addr = |port: u16| format!("{}:{port}", l.host);
+    vec![
+        ("smtp", addr(l.smtp), "inbound MX"),
+        ("submission", addr(l.submission), "plain + AUTH"),
+        (
+            "submissions",
+            addr(l.submission_tls),
+            "implicit TLS, self-signed",
+        ),
+        ("imap", addr(l.imap), "plain + LOGIN"),
+        ("imaps", addr(l.imap_tls), "implicit TLS, self-signed"),
+        ("http", format!("http://{}", addr(l.http)), "web UI, /new"),
+        ("https", format!("https://{}", addr(l.https)), "self-signed"),
+    ]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn dev_config_binds_loopback_at_port_base() {
+        let dir = Path::new("/tmp/madmail-dev-test");
+        let listen = ListenPorts::offset(DEV_HOST, 10000).unwrap();
+        let conf = render_maddy_conf(&dev_install_config(dir, listen));
+        let cfg = chatmail_config::parse_maddy_config(&conf).expect("parse dev config");
+        assert_eq!(cfg.smtp_listen.as_deref(), Some("127.0.0.1:10025"));
+        assert_eq!(cfg.submission_listen.as_deref(), Some("127.0.0.1:10587"));
+        assert_eq!(
+            cfg.submission_tls_listen.as_deref(),
+            Some("127.0.0.1:10465")
+        );
+        assert_eq!(cfg.imap_listen.as_deref(), Some("127.0.0.1:10143"));
+        assert_eq!(cfg.imap_tls_listen.as_deref(), Some("127.0.0.1:10993"));
+        assert_eq!(cfg.http_listen.as_deref(), Some("127.0.0.1:10080"));
+        assert_eq!(cfg.http_tls_listen.as_deref(), Some("127.0.0.1:10443"));
+        assert_eq!(cfg.state_dir.as_deref(), Some(dir));
+        assert_eq!(cfg.log_target.as_deref(), Some("stderr"));
+        assert!(cfg.debug);
+        assert!(cfg.turn_listen_udp.is_none());
+        assert!(cfg.ss_addr.is_none());
+    }
+
+    #[test]
+    fn port_base_must_leave_room_for_imaps() {
+        assert!(ListenPorts::offset(DEV_HOST, 64542).is_some());
+        assert!(ListenPorts::offset(DEV_HOST, 64543).is_none());
+    }
+}
@@ -0,0 +1,605 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail diagnose` — self-test of an installation.
+//!
+//! Checks run in order and each reports pass, warn, fail or skip with a one-line
+//! explanation; any failure makes the command exit non-zero. Later checks reuse what earlier
+//! ones loaded (config, DB pool), and a config that does not parse turns them into skips
+//! rather than a cascade of failures. Network checks go to the configured listeners, with
+//! wildcard binds probed on loopback.
+
+use std::future::Future;
+use std::net::IpAddr;
+use std::path::PathBuf;
+use std::pin::Pin;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use chatmail_config::{
+    effective_app_db_path, effective_database_config, effective_http_plain_listen,
+    effective_http_tls_listen, effective_imap_plain_listen, effective_imap_tls_listen,
+    effective_smtp_listen, effective_submission_plain_listen, effective_submission_tls_listen,
+    effective_tls_pem_paths, load_config, resolve_state_dir, AppConfig, Args, DatabaseConfig,
+    DbMailPorts,
+};
+use chatmail_db::{
+    init_db_from_config, list_double_underscore_settings, load_mail_port_overrides, passwords,
+    DbPool,
+};
+use chatmail_types::{ChatmailError, Result};
+use serde::Serialize;
+use tokio::io::{AsyncBufRead, AsyncBufReadExt, AsyncWriteExt, BufReader};
+use tokio::net::TcpStream;
+use tokio::sync::OnceCell;
+use tokio::time::timeout;
+
+use super::output::CtlOut;
+
+/// Bound on every network step, so a filtered port fails instead of hanging.
+const NET_TIMEOUT: Duration = Duration::from_secs(5);
+/// Certificates closer than this to `NotAfter` are reported as due for renewal.
+const CERT_WARN_DAYS: i64 = 30;
+/// Listeners that serve implicit TLS and so need the certificate.
+const TLS_LISTENERS: &[&str] = &["submission_tls", "imap_tls", "https"];
+
+pub(super) type CheckFuture<'a> = Pin<Box<dyn Future<Output = DiagResult> + Send + 'a>>;
+/// One self-test step.
+pub(super) type DiagCheck = for<'a> fn(&'a DiagContext) -> CheckFuture<'a>;
+
+/// Every check `diagnose` runs, in order.
+const CHECKS: &[DiagCheck] = &[
+    check_config,
+    check_listeners,
+    check_tls,
+    check_database,
+    check_dns,
+    check_smtp,
+    check_imap,
+];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub(super) enum DiagStatus {
+    Pass,
+    /// Works now but needs attention (e.g. certificate renewal due); does not fail the run.
+    Warn,
+    Fail,
+    /// Not applicable, or blocked by an earlier failure.
+    Skip,
+}
+
+impl DiagStatus {
+    pub(super) fn icon(self) -> &'static str {
+        match self {
+            Self::Pass => "✅",
+            Self::Warn => "⚠️",
+            Self::Fail => "❌",
+            Self::Skip => "➖",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub(super) struct DiagResult {
+    pub name: &'static str,
+    pub status: DiagStatus,
+    pub message: String,
+}
+
+impl DiagResult {
+    fn new(name: &'static str, status: DiagStatus, message: impl Into<String>) -> Self {
+        Self {
+            name,
+            status,
+            message: message.into(),
+        }
+    }
+}
+
+/// What the checks run against; built without touching the network or the DB.
+pub(super) struct DiagContext {
+    config_path: PathBuf,
+    config: std::result::Result<AppConfig, String>,
+    state_dir: PathBuf,
+    database: DatabaseConfig,
+    db_path: PathBuf,
+    imap_login: Option<(String, String)>,
+    pool: OnceCell<std::result::Result<DbPool, String>>,
+}
+
+impl DiagContext {
+    pub(super) fn from_args(args: &Args, imap_login: Option<(String, String)>) -> Self {
+        let config = if args.config.is_file() {
+            load_config(&args.config).map_err(|e| e.to_string())
+        } else {
+            Err(format!("no config file at {}", args.config.display()))
+        };
+        let paths_from = config.clone().unwrap_or_default();
+        let state_dir = resolve_state_dir(args.state_dir.clone(), &paths_from);
+        Self {
+            config_path: args.config.clone(),
+            database: effective_database_config(&state_dir, &paths_from),
+            db_path: effective_app_db_path(&state_dir, &paths_from),
+            state_dir,
+            config,
+            imap_login,
+            pool: OnceCell::new(),
+        }
+    }
+
+    fn config(&self) -> Option<&AppConfig> {
+        self.config.as_ref().ok()
+    }
+
+    /// The application DB, opened once; a missing SQLite file is an error, not created.
+    async fn pool(&self) -> std::result::Result<&DbPool, String> {
+        self.pool
+            .get_or_init(|| async {
+                if !self.database.is_postgres() && !self.db_path.is_file() {
+                    return Err(format!("no database at {}", self.db_path.display()));
+                }
+                init_db_from_config(&self.database)
+                    .await
+                    .map_err(|e| e.to_string())
+            })
+            .await
+            .as_ref()
+            .map_err(Clone::clone)
+    }
+
+    /// `(name, listen address)` of every TCP listener the server would bind, with the
+    /// admin port overrides applied when the DB is readable.
+    async fn listeners(&self) -> Vec<(&'static str, String)> {
+        let Some(cfg) = self.config() else {
+            return Vec::new();
+        };
+        let ports = match self.pool().await {
+            Ok(pool) => load_mail_port_overrides(pool).await.unwrap_or_default(),
+            Err(_) => DbMailPorts::default(),
+        };
+        let optional = [
+            ("submission", effective_submission_plain_listen(cfg, &ports)),
+            (
+                "submission_tls",
+                effective_submission_tls_listen(cfg, &ports),
+            ),
+            ("imap", effective_imap_plain_listen(cfg, &ports)),
+            ("imap_tls", effective_imap_tls_listen(cfg, &ports)),
+            ("http", effective_http_plain_listen(cfg, &ports)),
+            ("https", effective_http_tls_listen(cfg, &ports)),
+        ];
+        let mut out = vec![("smtp", effective_smtp_listen(cfg, &ports))];
+        out.extend(
+            optional
+                .into_iter()
+                .filter_map(|(name, addr)| Some((name, addr?))),
+        );
+        out
+    }
+
+    async fn listener(&self, name: &str) -> Option<String> {
+        self.listeners()
+            .await
+            .into_iter()
+            .find_map(|(n, addr)| (n == name).then_some(addr))
+    }
+}
+
+pub async fn diagnose(args: &Args, imap_login: Option<(String, String)>) -> Result<()> {
+    let ctx = DiagContext::from_args(args, imap_login);
+    let results = run_checks(&ctx, CHECKS).await;
+    report(&CtlOut::from_args(args, "diagnose"), &results)
+}
+
+pub(super) async fn run_checks(ctx: &DiagContext, checks: &[DiagCheck]) -> Vec<DiagResult> {
+    let mut results = Vec::with_capacity(checks.len());
+    for check in checks {
+        results.push(check(ctx).await);
+    }
+    results
+}
+
+/// Print every result; an error (non-zero exit) when any check failed.
+pub(super) fn report(out: &CtlOut, results: &[DiagResult]) -> Result<()> {
+    for r in results {
+        out.line(format!("{} {:<10} {}", r.status.icon(), r.name, r.message));
+    }
+    let failed = results
+        .iter()
+        .filter(|r| r.status == DiagStatus::Fail)
+        .count();
+    out.emit(serde_json::json!({
+        "passed": failed == 0,
+        "checks": results,
+    }))?;
+    if failed > 0 {
+        return Err(ChatmailError::config(format!(
+            "{failed} of {} diagnose check(s) failed",
+            results.len()
+        )));
+    }
+    out.blank();
+    out.line("All checks passed.");
+    Ok(())
+}
+
+fn check_config(ctx: &DiagContext) -> CheckFuture<'_> {
+    Box::pin(async move {
+        let name = "config";
+        match &ctx.config {
+            Ok(_) => DiagResult::new(
+                name,
+                DiagStatus::Pass,
+                format!("{} parsed", ctx.config_path.display()),
+            ),
+            Err(e) => DiagResult::new(name, DiagStatus::Fail, e.clone()),
+        }
+    })
+}
+
+fn check_listeners(ctx: &DiagContext) -> CheckFuture<'_> {
+    Box::pin(async move {
+        let name = "listeners";
+        if ctx.config().is_none() {
+            return skipped(name);
+        }
+        let (mut up, mut down) = (Vec::new(), Vec::new());
+        for (listener, addr) in ctx.listeners().await {
+            let label = format!("{listener} {addr}");
+            match connect(&addr).await {
+                Ok(_) => up.push(label),
+                Err(e) => down.push(format!("{label} ({e})")),
+            }
+        }
+        if down.is_empty() {
+            DiagResult::new(name, DiagStatus::Pass, up.join(", "))
+        } else {
+            DiagResult::new(
+                name,
+                DiagStatus::Fail,
+                format!("not accepting connections: {}", down.join(", ")),
+            )
+        }
+    })
+}
+
+fn check_tls(ctx: &DiagContext) -> CheckFuture<'_> {
+    Box::pin(async move {
+        let name = "tls";
+        let Some(cfg) = ctx.config() else {
+            return skipped(name);
+        };
+        let (cert, key) = effective_tls_pem_paths(cfg, &ctx.state_dir);
+        if !cert.is_file() {
+            let needs_tls = ctx
+                .listeners()
+                .await
+                .iter()
+                .any(|(l, _)| TLS_LISTENERS.contains(l));
+            return if needs_tls {
+                DiagResult::new(
+                    name,
+                    DiagStatus::Fail,
+                    format!("no certificate at {}", cert.display()),
+                )
+            } else {
+                DiagResult::new(name, DiagStatus::Skip, "no TLS listener configured")
+            };
+        }
+        let loaded = match chatmail_tls::ReloadingCert::load(&cert, &key) {
+            Ok(loaded) => loaded,
+            Err(e) => return DiagResult::new(name, DiagStatus::Fail, e.to_string()),
+        };
+        let now = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map_or(0, |d| d.as_secs() as i64);
+        let days = (loaded.not_after() - now).div_euclid(86_400);
+        let (status, message) = if days < 0 {
+            (DiagStatus::Fail, "expired".to_string())
+        } else if days < CERT_WARN_DAYS {
+            (
+                DiagStatus::Warn,
+                format!("expires in {days} day(s); renewal is due"),
+            )
+        } else {
+            (DiagStatus::Pass, format!("valid for {days} more day(s)"))
+        };
+        DiagResult::new(name, status, format!("{}: {message}", cert.display()))
+    })
+}
+
+fn check_database(ctx: &DiagContext) -> CheckFuture<'_> {
+    Box::pin(async move {
+        let name = "database";
+        let pool = match ctx.pool().await {
+            Ok(pool) => pool,
+            Err(e) => return DiagResult::new(name, DiagStatus::Fail, e),
+        };
+        // One query each against the credentials and settings tables.
+        let counts = async {
+            let (_, accounts) = passwords::list_users_page(pool, "", 0, 0).await?;
+            let settings = list_double_underscore_settings(pool).await?.len();
+            Ok::<_, ChatmailError>((accounts, settings))
+        };
+        match counts.await {
+            Ok((accounts, settings)) => DiagResult::new(
+                name,
+                DiagStatus::Pass,
+                format!(
+                    "{}: {accounts} account(s), {settings} setting(s)",
+                    ctx.database.display_location()
+                ),
+            ),
+            Err(e) => DiagResult::new(name, DiagStatus::Fail, e.to_string()),
+        }
+    })
+}
+
+fn check_dns(ctx: &DiagContext) -> CheckFuture<'_> {
+    Box::pin(async move {
+        let name = "dns";
+        let Some(cfg) = ctx.config() else {
+            return skipped(name);
+        };
+        let Some(host) = cfg.hostname.as_deref().or(cfg.primary_domain.as_deref()) else {
+            return DiagResult::new(name, DiagStatus::Skip, "no hostname configured");
+        };
+        let host = host.trim_start_matches('[').trim_end_matches(']');
+        if host.parse::<IpAddr>().is_ok() {
+            return DiagResult::new(name, DiagStatus::Pass, format!("{host} is an IP address"));
+        }
+        match timeout(NET_TIMEOUT, tokio::net::lookup_host((host, 0))).await {
+            Ok(Ok(addrs)) => {
+                let addrs: Vec<String> = addrs.map(|a| a.ip().to_string()).collect();
+                DiagResult::new(
+                    name,
+                    DiagStatus::Pass,
+                    format!("{host} → {}", addrs.join(", ")),
+                )
+            }
+            Ok(Err(e)) => DiagResult::new(name, DiagStatus::Fail, format!("{host}: {e}")),
+            Err(_) => DiagResult::new(name, DiagStatus::Fail, format!("{host}: lookup timed out")),
+        }
+    })
+}
+
+fn check_smtp(ctx: &DiagContext) -> CheckFuture<'_> {
+    Box::pin(async move {
+        let name = "smtp";
+        let Some(addr) = ctx.listener("smtp").await else {
+            return skipped(name);
+        };
+        match timeout(NET_TIMEOUT, smtp_ehlo(&addr)).await {
+            Ok(Ok(greeting)) => DiagResult::new(
+                name,
+                DiagStatus::Pass,
+                format!("EHLO accepted on {addr} ({greeting})"),
+            ),
+            Ok(Err(e)) => DiagResult::new(name, DiagStatus::Fail, format!("{addr}: {e}")),
+            Err(_) => DiagResult::new(name, DiagStatus::Fail, format!("{addr}: timed out")),
+        }
+    })
+}
+
+fn check_imap(ctx: &DiagContext) -> CheckFuture<'_> {
+    Box::pin(async move {
+        let name = "imap";
+        if ctx.config().is_none() {
+            return skipped(name);
+        }
+        let Some(addr) = ctx.listener("imap").await else {
+            return DiagResult::new(
+                name,
+                DiagStatus::Skip,
+                "no plaintext IMAP listener (implicit TLS is covered by the listener check)",
+            );
+        };
+        let login = ctx.imap_login.as_ref();
+        match timeout(NET_TIMEOUT, imap_login(&addr, login)).await {
+            Ok(Ok(())) => match login {
+                Some((user, _)) => {
+                    DiagResult::new(name, DiagStatus::Pass, format!("LOGIN as {user} accepted"))
+                }
+                None => DiagResult::new(
+                    name,
+                    DiagStatus::Pass,
+                    format!(
+                        "greeting received on {addr}; pass --imap-user and --imap-password \
+                         to test LOGIN"
+                    ),
+                ),
+            },
+            Ok(Err(e)) => DiagResult::new(name, DiagStatus::Fail, format!("{addr}: {e}")),
+            Err(_) => DiagResult::new(name, DiagStatus::Fail, format!("{addr}: timed out")),
+        }
+    })
+}
+
+fn skipped(name: &'static str) -> DiagResult {
+    DiagResult::new(name, DiagStatus::Skip, "config did not parse")
+}
+
+/// Listen address → connectable address: wildcard binds are probed on loopback.
+fn probe_addr(listen: &str) -> String {
+    let addr = listen.split_once("://").map_or(listen, |(_, rest)| rest);
+    let (host, port) = addr.rsplit_once(':').unwrap_or((addr, ""));
+    let host = host.trim_start_matches('[').trim_end_matches(']');
+    match host {
+        "" | "0.0.0.0" => format!("127.0.0.1:{port}"),
+        "::" => format!("[::1]:{port}"),
+        h if h.contains(':') => format!("[{h}]:{port}"),
+        h => format!("{h}:{port}"),
+    }
+}
+
+async fn connect(listen: &str) -> std::io::Result<TcpStream> {
+    match timeout(NET_TIMEOUT, TcpStream::connect(probe_addr(listen))).await {
+        Ok(result) => result,
+        Err(_) => Err(std::io::ErrorKind::TimedOut.into()),
+    }
+}
+
+/// Greeting and EHLO; returns the greeting text.
+async fn smtp_ehlo(listen: &str) -> Result<String> {
+    let mut stream = BufReader::new(connect(listen).await?);
+    let (code, greeting) = smtp_reply(&mut stream).await?;
+    if code != 220 {
+        return Err(ChatmailError::protocol(format!(
+            "greeting {code} {greeting}"
+        )));
+    }
+    stream
+        .get_mut()
+        .write_all(b"EHLO diagnose.invalid\r\n")
+        .await?;
+    let (code, text) = smtp_reply(&mut stream).await?;
+    if code != 250 {
+        return Err(ChatmailError::protocol(format!(
+            "EHLO answered {code} {text}"
+        )));
+    }
+    let _ = stream.get_mut().write_all(b"QUIT\r\n").await;
+    Ok(greeting)
+}
+
+/// One SMTP reply, possibly multi-line: the code and the first line's text.
+async fn smtp_reply<R: AsyncBufRead + Unpin>(reader: &mut R) -> Result<(u16, String)> {
+    let mut first = None;
+    loop {
+        let mut line = String::new();
+        if reader.read_line(&mut line).await? == 0 {
+            return Err(ChatmailError::protocol("connection closed"));
+        }
+        let code: u16 = line.get(..3).and_then(|c| c.parse().ok()).ok_or_else(|| {
+            ChatmailError::protocol(format!("not an SMTP reply: {}", line.trim()))
+        })?;
+        let text = first.get_or_insert_with(|| line.get(4..).unwrap_or("").trim().to_string());
+        if line.as_bytes().get(3) != Some(&b'-') {
+            return Ok((code, text.clone()));
+        }
+    }
+}
+
+/// Greeting, then `LOGIN` when credentials are given.
+async fn imap_login(listen: &str, login: Option<&(String, String)>) -> Result<()> {
+    let mut stream = BufReader::new(connect(listen).await?);
+    let mut line = String::new();
+    stream.read_line(&mut line).await?;
+    if !line.starts_with("* OK") {
+        return Err(ChatmailError::protocol(format!(
+            "greeting: {}",
+            line.trim()
+        )));
+    }
+    let Some((user, password)) = login else {
+        return Ok(());
+    };
+    let command = format!(
+        "d1 LOGIN {} {}\r\nd2 LOGOUT\r\n",
+        imap_quote(user),
+        imap_quote(password)
+    );
+    stream.get_mut().write_all(command.as_bytes()).await?;
+    loop {
+        line.clear();
+        if stream.read_line(&mut line).await? == 0 {
+            return Err(ChatmailError::protocol("connection closed"));
+        }
+        if let Some(status) = line.strip_prefix("d1 ") {
+            return if status.starts_with("OK") {
+                Ok(())
+            } else {
+                Err(ChatmailError::protocol(format!("LOGIN: {}", status.trim())))
+            };
+        }
+    }
+}
+
+fn imap_quote(s: &str) -> String {
+    format!("\"{}\"", s.replace('\\', "\\\\").replace('"', "\\\""))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::ctl::test_harness::ctl_args;
+
+    fn passing(_: &DiagContext) -> CheckFuture<'_> {
+        Box::pin(async { DiagResult::new("mock_pass", DiagStatus::Pass, "ok") })
+    }
+
+    fn warning(_: &DiagContext) -> CheckFuture<'_> {
+        Box::pin(async { DiagResult::new("mock_warn", DiagStatus::Warn, "soon") })
+    }
+
+    fn failing(_: &DiagContext) -> CheckFuture<'_> {
+        Box::pin(async { DiagResult::new("mock_fail", DiagStatus::Fail, "broken") })
+    }
+
+    #[tokio::test]
+    async fn any_failing_check_fails_the_run() {
+        let dir = tempfile::tempdir().unwrap();
+        let args = ctl_args(dir.path());
+        let ctx = DiagContext::from_args(&args, None);
+        let out = CtlOut::from_args(&args, "diagnose");
+
+        let results = run_checks(&ctx, &[passing, warning, passing]).await;
+        assert_eq!(results.len(), 3);
+        report(&out, &results).unwrap();
+
+        let mocks: [DiagCheck; 8] = [
+            passing, passing, warning, passing, failing, passing, passing, passing,
+        ];
+        let results = run_checks(&ctx, &mocks).await;
+        assert_eq!(results[4].status, DiagStatus::Fail);
+        let err = report(&out, &results).unwrap_err().to_string();
+        assert!(err.contains("1 of 8"), "{err}");
+    }
+
+    #[tokio::test]
+    async fn missing_config_fails_once_and_skips_the_rest() {
+        let dir = tempfile::tempdir().unwrap();
+        let ctx = DiagContext::from_args(&ctl_args(dir.path()), None);
+        let results = run_checks(&ctx, CHECKS).await;
+        let failed: Vec<_> = results
+            .iter()
+            .filter(|r| r.status == DiagStatus::Fail)
+            .map(|r| r.name)
+            .collect();
+        // No config and no database; nothing else is attempted.
+        assert_eq!(failed, ["config", "database"]);
+        assert!(!ctx.db_path.exists(), "diagnose never creates the DB");
+    }
+
+    #[test]
+    fn wildcard_listen_addresses_are_probed_on_loopback() {
+        assert_eq!(probe_addr("tcp://0.0.0.0:25"), "127.0.0.1:25");
+        assert_eq!(probe_addr("[::]:993"), "[::1]:993");
+        assert_eq!(
+            probe_addr("tls://mail.example.org:465"),
+            "mail.example.org:465"
+        );
+        assert_eq!(probe_addr(":143"), "127.0.0.1:143");
+    }
+
+    #[tokio::test]
+    async fn smtp_reply_reads_multiline_answers() {
+        let mut input: &[u8] = b"250-mx.example.org\r\n250-PIPELINING\r\n250 SIZE 100\r\n";
+        let (code, text) = smtp_reply(&mut input).await.unwrap();
+        assert_eq!((code, text.as_str()), (250, "mx.example.org"));
+    }
+}
@@ -15,19 +15,52 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
-use chatmail_config::{Cli, Command};
+use chatmail_config::{AuditCommand, Cli, Command};
 use chatmail_types::{ChatmailError, Result};
 
 use chatmail_db::settings_keys;
 
+use super::settings_audit::SettingsAudit;
 use super::{
-    accounts, admin_token, admin_web, blocklist_cmd, certificate, delete_cmd, docs, endpoint_cache,
-    federation, firewall_cmd, html, install, language, message_size, port, proxy, push,
-    registration, registration_tokens, reload, service_cmd, service_toggle, sharing, status_cmd,
-    tasks, uninstall, version, webmail_cors,
+    accounts, admin_audit, admin_token, admin_web, autoresponse, backup, ban, blocklist_cmd,
+    broadcast, certificate, creds, db_cmd, delete_cmd, delivery_stats, diagnose, dkim, dns_check,
+    docs, domains, endpoint_cache, federation, firewall_cmd, html, imap_acct, install, language,
+    message_size, peers, policy, port, proxy, push, registration, registration_tokens, reload,
+    responder, service_cmd, service_toggle, settings_cmd, sharing, ss_stats, status_cmd, storage,
+    tasks, theme, uninstall, version, webhook, webmail_cors,
 };
 
 pub async fn dispatch(cli: &Cli) -> Result<()> {
+    let audit = match &cli.command {
+        Some(cmd) if changes_settings(cmd) => SettingsAudit::begin(&cli.args).await,
+        _ => None,
+    };
+    let result = run(cli).await;
+    if let (Some(audit), Some(cmd)) = (audit, &cli.command) {
+        audit.finish(command_name(cmd)).await;
+    }
+    result
+}
+
+/// Commands that write runtime settings; their changes go to the audit trail.
+fn changes_settings(cmd: &Command) -> bool {
+    matches!(
+        cmd,
+        Command::AdminWeb { .. }
+            | Command::Language { .. }
+            | Command::MessageSize { .. }
+            | Command::Port(_)
+            | Command::Proxy { .. }
+            | Command::Registration(_)
+            | Command::Webimap(_)
+            | Command::Websmtp(_)
+            | Command::WebmailCors { .. }
+            | Command::Settings(_)
+            | Command::Dkim(_)
+    )
+}
+
+async fn run(cli: &Cli) -> Result<()> {
     match &cli.command {
         None | Some(Command::Run) => Err(ChatmailError::config(
             "internal error: dispatch called for server run",
@@ -35,31 +68,48 @@ pub async fn dispatch(cli: &Cli) -> Result<()> {
         Some(Command::Upgrade {
             path_or_url,
             accept_unsafe_https,
+            backup,
+            dry_run,
         })
         | Some(Command::Update {
             path_or_url,
             accept_unsafe_https,
+            backup,
+            dry_run,
         }) => {
             // Blocking HTTP download + filesystem replace must not run on the async
             // runtime (reqwest::blocking creates its own runtime).
             let path = path_or_url.clone();
-            let accept_unsafe_https = *accept_unsafe_https;
+            let opts = crate::upgrade::UpgradeOptions {
+                accept_unsafe_https: *accept_unsafe_https,
+                backup: *backup,
+                dry_run: *dry_run,
+            };
             let args = cli.args.clone();
             tokio::task::spawn_blocking(move || {
-                crate::upgrade::upgrade_command(&path, &args, accept_unsafe_https)
+                crate::upgrade::upgrade_command(&path, &args, &opts)
             })
             .await
             .map_err(|e| ChatmailError::config(format!("upgrade task failed: {e}")))?
         }
+        Some(Command::Dev {
+            port_base,
+            state,
+            keep,
+        }) => dev::dev(&cli.args, *port_base, state.as_deref(), *keep).await,
         Some(Command::AdminToken { raw, no_qr }) => {
             admin_token::admin_token(&cli.args, *raw, *no_qr).await
         }
+        Some(Command::AdminAudit(list)) | Some(Command::Audit(AuditCommand::List(list))) => {
+            admin_audit::admin_audit(&cli.args, list).await
+        }
         Some(Command::AdminWeb { cmd }) => admin_web::admin_web(&cli.args, cmd).await,
         Some(Command::Version) => version::print_version(&cli.args),
         Some(Command::Install(args)) => install::install(&cli.args, args.as_ref()).await,
         Some(Command::Certificate { cmd }) => certificate::certificate(&cli.args, cmd).await,
         Some(Command::Accounts(cmd)) => accounts::accounts(&cli.args, cmd).await,
         Some(Command::BanList) => accounts::ban_list(&cli.args).await,
+        Some(Command::Ban(cmd)) => ban::ban(&cli.args, cmd).await,
         Some(Command::Blocklist(cmd)) => blocklist_cmd::blocklist(&cli.args, cmd).await,
         Some(Command::CreateUser { json_only }) => {
             accounts::create_user(&cli.args, *json_only).await
@@ -72,6 +122,7 @@ pub async fn dispatch(cli: &Cli) -> Result<()> {
         Some(Command::HtmlExport { dest }) => html::html_export(&cli.args, dest).await,
         Some(Command::HtmlServe { www_dir }) => html::html_serve(&cli.args, www_dir).await,
         Some(Command::HtmlMigrate { yes }) => html::html_migrate(&cli.args, *yes).await,
+        Some(Command::ImapAcct(cmd)) => imap_acct::imap_acct(&cli.args, cmd).await,
         Some(Command::Language { command }) => {
             language::language(&cli.args, command.as_ref()).await
         }
@@ -106,17 +157,52 @@ pub async fn dispatch(cli: &Cli) -> Result<()> {
         Some(Command::RegistrationTokens(cmd)) => {
             registration_tokens::registration_tokens(&cli.args, cmd).await
         }
+        Some(Command::Responder(cmd)) => responder::responder(&cli.args, cmd).await,
+        Some(Command::Autoresponse(cmd)) => autoresponse::autoresponse(&cli.args, cmd).await,
+        Some(Command::Domains(cmd)) => domains::domains(&cli.args, cmd).await,
+        Some(Command::Peers(cmd)) => peers::peers(&cli.args, cmd).await,
+        Some(Command::Dkim(cmd)) => dkim::dkim(&cli.args, cmd).await,
+        Some(Command::Webhook(cmd)) => webhook::webhook(&cli.args, cmd).await,
+        Some(Command::Broadcast(cmd)) => broadcast::broadcast(&cli.args, cmd).await,
         Some(Command::Sharing(cmd)) => sharing::sharing(&cli.args, cmd).await,
+        Some(Command::Theme(cmd)) => theme::theme(&cli.args, cmd).await,
         Some(Command::Status { details }) => status_cmd::status(&cli.args, *details).await,
         Some(Command::Uninstall(flags)) => uninstall::uninstall(&cli.args, flags).await,
         Some(Command::Service(cmd)) => service_cmd::service(&cli.args, cmd).await,
         Some(Command::Firewall(cmd)) => firewall_cmd::firewall(&cli.args, cmd).await,
+        Some(Command::Creds(cmd)) => creds::creds(&cli.args, cmd).await,
         Some(Command::EndpointCache(cmd)) => endpoint_cache::endpoint_cache(&cli.args, cmd).await,
+        Some(Command::Policy(cmd)) => policy::policy(&cli.args, cmd).await,
         Some(Command::Port(cmd)) => port::port(&cli.args, cmd).await,
         Some(Command::Reload { url, insecure }) => {
             reload::reload(&cli.args, url.as_deref(), *insecure).await
         }
+        Some(Command::DeliveryStats { url, insecure }) => {
+            delivery_stats::delivery_stats(&cli.args, url.as_deref(), *insecure).await
+        }
+        Some(Command::SsStats { url, insecure }) => {
+            ss_stats::ss_stats(&cli.args, url.as_deref(), *insecure).await
+        }
+        Some(Command::Storage(cmd)) => storage::storage(&cli.args, cmd).await,
+        Some(Command::Backup(cmd)) => backup::backup(&cli.args, cmd).await,
+        Some(Command::Db(cmd)) => db_cmd::db(&cli.args, cmd).await,
         Some(Command::Tasks(cmd)) => tasks::tasks(&cli.args, cmd).await,
+        Some(Command::DnsCheck {
+            domain,
+            fix_cloudflare,
+            zone_id,
+        }) => {
+            let cloudflare = fix_cloudflare.as_deref().zip(zone_id.as_deref());
+            dns_check::dns_check(&cli.args, domain.as_deref(), cloudflare).await
+        }
+        Some(Command::Diagnose {
+            imap_user,
+            imap_password,
+        }) => {
+            let login = imap_user.clone().zip(imap_password.clone());
+            diagnose::diagnose(&cli.args, login).await
+        }
+        Some(Command::Settings(cmd)) => settings_cmd::settings(&cli.args, cmd).await,
         Some(Command::Completion(shell)) => docs::print_completion(shell),
         Some(Command::GenerateMan) => docs::print_generate_man(&cli.args),
         Some(Command::GenerateFishCompletion) => docs::print_generate_fish_completion(&cli.args),
@@ -129,23 +215,27 @@ fn not_implemented(cmd: &Command) -> Result<()> {
     Err(ChatmailError::config(format!(
         "'madmail {name}' is not implemented in madmail-v2 yet.\n\
          See docs/TDD/14-cli-tools.md and context/madmail/docs/chatmail/commands.md.\n\
-         Implemented: run, upgrade, update, version, admin-token, admin-web, install, certificate, \
-         accounts, ban-list, blocklist, create-user, delete, registration, language, \
-         html-export, html-serve, html-migrate, webimap, websmtp, webmail-cors, push, federation, registration-tokens, sharing, \
-         status, uninstall, service, firewall, endpoint-cache, port, proxy, reload, message-size, tasks, completion"
+         Implemented: run, dev, upgrade, update, version, admin-token, admin-audit, audit, admin-web, install, certificate, \
+         accounts, ban, ban-list, blocklist, create-user, delete, registration, language, \
+         html-export, html-serve, html-migrate, imap-acct, webimap, websmtp, webmail-cors, push, federation, registration-tokens, responder, autoresponse, domains, peers, webhook, broadcast, sharing, theme, \
+         status, uninstall, service, firewall, creds, endpoint-cache, policy, port, proxy, reload, delivery-stats, ss-stats, message-size, storage, tasks, settings, completion"
     )))
 }
 
 fn command_name(cmd: &Command) -> &'static str {
     match cmd {
         Command::Run => "run",
+        Command::Dev { .. } => "dev",
         Command::Upgrade { .. } => "upgrade",
         Command::Update { .. } => "update",
         Command::AdminToken { .. } => "admin-token",
+        Command::AdminAudit(_) => "admin-audit",
+        Command::Audit(_) => "audit",
         Command::AdminWeb { .. } => "admin-web",
         Command::Version => "version",
         Command::Accounts { .. } => "accounts",
         Command::BanList => "ban-list",
+        Command::Ban(_) => "ban",
         Command::Blocklist { .. } => "blocklist",
         Command::CreateUser { .. } => "create-user",
         Command::Delete { .. } => "delete",
@@ -158,30 +248,47 @@ fn command_name(cmd: &Command) -> &'static str {
         Command::HtmlMigrate { .. } => "html-migrate",
         Command::ImapMboxes => "imap-mboxes",
         Command::ImapMsgs => "imap-msgs",
-        Command::ImapAcct => "imap-acct",
+        Command::ImapAcct(_) => "imap-acct",
         Command::Install { .. } => "install",
         Command::Certificate { cmd: _ } => "certificate",
         Command::Language { .. } => "language",
         Command::Registration { .. } => "registration",
         Command::MigratePgpConfig => "migrate-pgp-config",
         Command::Status { .. } => "status",
+        Command::Policy(_) => "policy",
         Command::Port(_) => "port",
         Command::Queue => "queue",
+        Command::DeliveryStats { .. } => "delivery-stats",
+        Command::SsStats { .. } => "ss-stats",
         Command::RegistrationTokens { .. } => "registration-tokens",
         Command::Reload { .. } => "reload",
+        Command::Responder(_) => "responder",
+        Command::Autoresponse(_) => "autoresponse",
+        Command::Domains(_) => "domains",
+        Command::Peers(_) => "peers",
+        Command::Dkim(_) => "dkim",
+        Command::Webhook(_) => "webhook",
+        Command::Broadcast(_) => "broadcast",
         Command::Sharing { .. } => "sharing",
+        Command::Theme(_) => "theme",
         Command::SubmissionAccess => "submission-access",
         Command::Uninstall { .. } => "uninstall",
         Command::Service { .. } => "service",
         Command::Firewall { .. } => "firewall",
-        Command::Creds => "creds",
+        Command::Creds(_) => "creds",
         Command::Webimap { .. } => "webimap",
         Command::Websmtp { .. } => "websmtp",
         Command::WebmailCors { .. } => "webmail-cors",
         Command::Push { .. } => "push",
         Command::Proxy { .. } => "proxy",
         Command::MessageSize { .. } => "message-size",
+        Command::Storage(_) => "storage",
+        Command::Backup(_) => "backup",
+        Command::Db(_) => "db",
         Command::Tasks { .. } => "tasks",
+        Command::DnsCheck { .. } => "dns-check",
+        Command::Diagnose { .. } => "diagnose",
+        Command::Settings(_) => "settings",
         Command::Completion { .. } => "completion",
         Command::GenerateMan => "generate-man",
         Command::GenerateFishCompletion => "generate-fish-completion",
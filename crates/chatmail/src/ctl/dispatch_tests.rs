@@ -18,11 +18,11 @@
 //! In-process `ctl::dispatch` tests (no subprocess).
 
 use chatmail_config::Command;
-use chatmail_db::{blocklist, passwords, CLI_BAN_REASON, CLI_DELETE_REASON};
+use chatmail_db::{blocklist, passwords, settings_keys, CLI_BAN_REASON, CLI_DELETE_REASON};
 use chatmail_storage::MailboxStore;
 
 use super::dispatch;
-use super::test_harness::{parse_cli, setup_ctl_env};
+use super::test_harness::{parse_cli, parse_cli_with_config, setup_ctl_env};
 
 #[tokio::test]
 async fn dispatch_accounts_create_delete_and_blocklist() {
@@ -124,6 +124,621 @@ async fn dispatch_blocklist_add_and_remove() {
     assert!(!blocklist::is_blocked(&pool, user).await.unwrap());
 }
 
+#[tokio::test]
+async fn dispatch_ban_add_list_remove() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+
+    let cli = parse_cli(
+        dir.path(),
+        &[
+            "ban",
+            "add",
+            "203.0.113.9/24",
+            "--reason",
+            "scan",
+            "--expires",
+            "1h",
+        ],
+    );
+    dispatch(&cli).await.unwrap();
+    let bans = chatmail_db::list_bans(&pool, false).await.unwrap();
+    assert_eq!(bans.len(), 1);
+    assert_eq!(bans[0].cidr, "203.0.113.0/24");
+    assert_eq!(bans[0].source, chatmail_db::BAN_SOURCE_MANUAL);
+    assert!(bans[0].expires_at > 0);
+
+    dispatch(&parse_cli(dir.path(), &["ban", "list", "--all"]))
+        .await
+        .unwrap();
+    assert!(dispatch(&parse_cli(dir.path(), &["ban", "add", "nope"]))
+        .await
+        .is_err());
+
+    dispatch(&parse_cli(dir.path(), &["ban", "remove", "203.0.113.0/24"]))
+        .await
+        .unwrap();
+    assert!(chatmail_db::list_bans(&pool, true)
+        .await
+        .unwrap()
+        .is_empty());
+    assert!(
+        dispatch(&parse_cli(dir.path(), &["ban", "remove", "203.0.113.0/24"]))
+            .await
+            .is_err()
+    );
+    let audit = chatmail_db::list_ban_audit(&pool, 10).await.unwrap();
+    assert_eq!(audit.len(), 2);
+    assert_eq!(audit[0].action, "remove");
+    assert_eq!(audit[0].actor, "cli");
+}
+
+#[tokio::test]
+async fn dispatch_responder_set_list_remove() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    let template = dir.path().join("away.txt");
+    std::fs::write(&template, "Subject: Away\n\nBack on {{subject}}.\n").unwrap();
+    let template = template.to_str().unwrap();
+
+    let cli = parse_cli(
+        dir.path(),
+        &[
+            "responder",
+            "set",
+            "Help@Example.org",
+            "--template",
+            template,
+            "--interval",
+            "7d",
+        ],
+    );
+    dispatch(&cli).await.unwrap();
+    let all = chatmail_db::list_responders(&pool).await.unwrap();
+    assert_eq!(all.len(), 1);
+    assert_eq!(all[0].address, "help@example.org");
+    assert_eq!(all[0].interval_secs, 7 * 24 * 3600);
+    assert!(all[0].template.starts_with("Subject: Away"));
+
+    dispatch(&parse_cli(dir.path(), &["responder", "list"]))
+        .await
+        .unwrap();
+    for bad in [
+        &[
+            "responder",
+            "set",
+            "help@example.org",
+            "--template",
+            template,
+            "--interval",
+            "x",
+        ][..],
+        &[
+            "responder",
+            "set",
+            "help@example.org",
+            "--template",
+            "/nonexistent/t.txt",
+        ][..],
+    ] {
+        assert!(
+            dispatch(&parse_cli(dir.path(), bad)).await.is_err(),
+            "{bad:?}"
+        );
+    }
+
+    dispatch(&parse_cli(
+        dir.path(),
+        &["responder", "remove", "help@example.org"],
+    ))
+    .await
+    .unwrap();
+    assert!(chatmail_db::list_responders(&pool)
+        .await
+        .unwrap()
+        .is_empty());
+    assert!(dispatch(&parse_cli(
+        dir.path(),
+        &["responder", "remove", "help@example.org"]
+    ))
+    .await
+    .is_err());
+}
+
+#[tokio::test]
+async fn dispatch_autoresponse_set_show_clear() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    passwords::create_user(&pool, "alice@example.org", "x")
+        .await
+        .unwrap();
+    let cli = |argv: &[&str]| parse_cli(dir.path(), argv);
+
+    dispatch(&cli(&[
+        "autoresponse",
+        "set",
+        "Alice@Example.org",
+        "--subject",
+        "On vacation",
+        "--body",
+        "Back in May.",
+        "--start",
+        "2026-04-01",
+        "--end",
+        "2026-05-01",
+    ]))
+    .await
+    .unwrap();
+    let a = chatmail_db::get_autoresponse(&pool, "alice@example.org")
+        .await
+        .unwrap()
+        .unwrap();
+    assert!(a.active);
+    assert_eq!(a.subject, "On vacation");
+    assert_eq!(a.body, "Back in May.");
+    assert_eq!(a.starts_at, Some(1_775_001_600));
+    assert_eq!(a.ends_at, Some(1_777_593_600));
+
+    dispatch(&cli(&["autoresponse", "show", "alice@example.org"]))
+        .await
+        .unwrap();
+    for bad in [
+        &["autoresponse", "set", "nobody@example.org", "--body", "x"][..],
+        &[
+            "autoresponse",
+            "set",
+            "alice@example.org",
+            "--body",
+            "x",
+            "--start",
+            "soon",
+        ][..],
+        &[
+            "autoresponse",
+            "set",
+            "alice@example.org",
+            "--body",
+            "x",
+            "--start",
+            "2026-05-01",
+            "--end",
+            "2026-04-01",
+        ][..],
+    ] {
+        assert!(dispatch(&cli(bad)).await.is_err(), "{bad:?}");
+    }
+
+    dispatch(&cli(&["autoresponse", "clear", "alice@example.org"]))
+        .await
+        .unwrap();
+    assert!(chatmail_db::get_autoresponse(&pool, "alice@example.org")
+        .await
+        .unwrap()
+        .is_none());
+    for argv in [
+        &["autoresponse", "clear", "alice@example.org"][..],
+        &["autoresponse", "show", "alice@example.org"][..],
+    ] {
+        assert!(dispatch(&cli(argv)).await.is_err(), "{argv:?}");
+    }
+}
+
+#[tokio::test]
+async fn dispatch_domains_catchall_set_list_clear() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    let config = dir.path().join("catchall.conf");
+    std::fs::write(&config, "$(primary_domain) = example.org\n").unwrap();
+    let cli = |argv: &[&str]| parse_cli_with_config(dir.path(), &config, argv);
+    passwords::create_user(&pool, "team@example.org", "x")
+        .await
+        .unwrap();
+
+    for bad in [
+        &[
+            "domains",
+            "catchall",
+            "set",
+            "other.org",
+            "team@example.org",
+        ][..],
+        &[
+            "domains",
+            "catchall",
+            "set",
+            "example.org",
+            "ghost@example.org",
+        ][..],
+        &[
+            "domains",
+            "catchall",
+            "set",
+            "example.org",
+            "team@example.org",
+            "--daily-cap",
+            "0",
+        ][..],
+    ] {
+        assert!(dispatch(&cli(bad)).await.is_err(), "{bad:?}");
+    }
+
+    dispatch(&cli(&[
+        "domains",
+        "catchall",
+        "set",
+        "Example.org",
+        "Team@Example.org",
+        "--daily-cap",
+        "50",
+    ]))
+    .await
+    .unwrap();
+    let all = chatmail_db::list_catchalls(&pool).await.unwrap();
+    assert_eq!(all.len(), 1);
+    assert_eq!(
+        (
+            all[0].domain.as_str(),
+            all[0].target.as_str(),
+            all[0].daily_cap
+        ),
+        ("example.org", "team@example.org", 50)
+    );
+    dispatch(&cli(&["domains", "catchall", "list"]))
+        .await
+        .unwrap();
+
+    dispatch(&cli(&["domains", "catchall", "clear", "example.org"]))
+        .await
+        .unwrap();
+    assert!(chatmail_db::list_catchalls(&pool).await.unwrap().is_empty());
+    assert!(
+        dispatch(&cli(&["domains", "catchall", "clear", "example.org"]))
+            .await
+            .is_err()
+    );
+}
+
+#[tokio::test]
+async fn dispatch_dkim_rotate_and_activate() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    let config = dir.path().join("dkim.conf");
+    std::fs::write(&config, "$(primary_domain) = example.org\n").unwrap();
+    let cli = |argv: &[&str]| parse_cli_with_config(dir.path(), &config, argv);
+
+    assert!(dispatch(&cli(&["dkim", "rotate", "--algorithm", "dsa"]))
+        .await
+        .is_err());
+    assert!(dispatch(&cli(&["dkim", "activate", "next"])).await.is_err());
+    dispatch(&cli(&[
+        "dkim",
+        "rotate",
+        "--algorithm",
+        "ed25519",
+        "--selector",
+        "next",
+    ]))
+    .await
+    .unwrap();
+    let keys = chatmail_delivery::dkim::list_keys(dir.path()).unwrap();
+    assert_eq!(keys.len(), 1);
+    assert_eq!(keys[0].dns_name, "next._domainkey.example.org");
+    assert!(dispatch(&cli(&["dkim", "rotate", "--selector", "next"]))
+        .await
+        .is_err());
+
+    dispatch(&cli(&["dkim", "activate", "next"])).await.unwrap();
+    assert_eq!(
+        chatmail_db::get_setting(&pool, settings_keys::DKIM_SELECTOR)
+            .await
+            .unwrap()
+            .as_deref(),
+        Some("next")
+    );
+    dispatch(&cli(&["dkim", "list"])).await.unwrap();
+}
+
+#[tokio::test]
+async fn dispatch_peers_add_remove() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    let config = dir.path().join("peers.conf");
+    std::fs::write(&config, "peers https://static.example\n").unwrap();
+    let cli = |argv: &[&str]| parse_cli_with_config(dir.path(), &config, argv);
+
+    assert!(dispatch(&cli(&["peers", "add", "ftp://peer.example"]))
+        .await
+        .is_err());
+    dispatch(&cli(&["peers", "add", "Peer.example"]))
+        .await
+        .unwrap();
+    dispatch(&cli(&["peers", "add", "https://peer.example/"]))
+        .await
+        .unwrap();
+    let urls: Vec<_> = chatmail_db::list_peers(&pool)
+        .await
+        .unwrap()
+        .into_iter()
+        .map(|p| p.base_url)
+        .collect();
+    assert_eq!(urls, ["https://peer.example"]);
+
+    let err = dispatch(&cli(&["peers", "remove", "static.example"]))
+        .await
+        .unwrap_err();
+    assert!(err.to_string().contains("peers directive"), "{err}");
+    dispatch(&cli(&["peers", "rm", "peer.example"]))
+        .await
+        .unwrap();
+    assert!(chatmail_db::list_peers(&pool).await.unwrap().is_empty());
+}
+
+#[tokio::test]
+async fn dispatch_creds_rate_limit_show_and_reset() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    let user = "busy@example.org";
+    let hour = chatmail_db::sending_hour(chatmail_db::policies::unix_now());
+    chatmail_db::record_sent_message(&pool, user, hour, 3)
+        .await
+        .unwrap();
+
+    let cli = parse_cli(dir.path(), &["creds", "rate-limit", "show", user]);
+    dispatch(&cli).await.unwrap();
+    let cli = parse_cli(dir.path(), &["creds", "rate-limit", "show"]);
+    dispatch(&cli).await.unwrap();
+
+    let cli = parse_cli(dir.path(), &["creds", "rate-limit", "reset", user]);
+    dispatch(&cli).await.unwrap();
+    assert_eq!(
+        chatmail_db::messages_sent_in_hour(&pool, user, hour)
+            .await
+            .unwrap(),
+        0
+    );
+}
+
+#[tokio::test]
+async fn dispatch_broadcast_dry_run_counts_without_a_server() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    passwords::create_user(&pool, "a@example.org", "x")
+        .await
+        .unwrap();
+    let run = |extra: &[&str]| {
+        let mut argv = vec![
+            "broadcast",
+            "send",
+            "--subject",
+            "s",
+            "--body",
+            "b",
+            "--dry-run",
+        ];
+        argv.extend_from_slice(extra);
+        parse_cli(dir.path(), &argv)
+    };
+    dispatch(&run(&[])).await.unwrap();
+    dispatch(&run(&["--domain", "example.org"])).await.unwrap();
+    dispatch(&run(&["--active-since", "30d"])).await.unwrap();
+    assert!(dispatch(&run(&["--active-since", "soon"])).await.is_err());
+    dispatch(&run(&["--created-after", "2024-01-01"]))
+        .await
+        .unwrap();
+    dispatch(&run(&["--account", "A@example.org"]))
+        .await
+        .unwrap();
+    assert!(dispatch(&run(&["--account", "z@example.org"]))
+        .await
+        .is_err());
+}
+
+#[tokio::test]
+async fn dispatch_imap_acct_backlog_reports_unfetched_inbox() {
+    let (dir, _args, _db_path, _pool) = setup_ctl_env().await;
+    let mailbox = MailboxStore::new(dir.path());
+    mailbox.init_user_dir("idle@example.org").await.unwrap();
+    chatmail_storage::write_blob(&mailbox, "idle@example.org", "m1", b"Subject: a\r\n\r\nx")
+        .await
+        .unwrap();
+
+    let cli = parse_cli(
+        dir.path(),
+        &[
+            "imap-acct",
+            "backlog",
+            "--min-age",
+            "0s",
+            "--min-count",
+            "1",
+        ],
+    );
+    assert!(matches!(cli.command, Some(Command::ImapAcct(_))));
+    dispatch(&cli).await.unwrap();
+    dispatch(&parse_cli(dir.path(), &["--json", "imap-acct", "backlog"]))
+        .await
+        .unwrap();
+    assert!(dispatch(&parse_cli(
+        dir.path(),
+        &["imap-acct", "backlog", "--min-age", "soon"]
+    ))
+    .await
+    .is_err());
+}
+
+#[tokio::test]
+async fn dispatch_imap_acct_stat_and_gc_orphans() {
+    let (dir, _args, _db_path, _pool) = setup_ctl_env().await;
+    let mailbox = MailboxStore::new(dir.path());
+    chatmail_storage::write_blob(&mailbox, "gone@example.org", "m1", b"Subject: a\r\n\r\nx")
+        .await
+        .unwrap();
+    let inbox = mailbox.maildir_for_user("gone@example.org");
+    std::fs::remove_file(inbox.new.join("m1")).unwrap();
+    let blob = chatmail_storage::ContentStore::new(dir.path())
+        .blob_path(&chatmail_storage::hash_bytes(b"Subject: a\r\n\r\nx"));
+    std::fs::File::options()
+        .write(true)
+        .open(&blob)
+        .unwrap()
+        .set_modified(std::time::UNIX_EPOCH + std::time::Duration::from_secs(1_000_000_000))
+        .unwrap();
+
+    dispatch(&parse_cli(dir.path(), &["imap-acct", "stat"]))
+        .await
+        .unwrap();
+    dispatch(&parse_cli(dir.path(), &["--json", "imap-acct", "stat"]))
+        .await
+        .unwrap();
+    dispatch(&parse_cli(dir.path(), &["imap-acct", "gc", "--dry-run"]))
+        .await
+        .unwrap();
+    assert!(blob.exists());
+    dispatch(&parse_cli(dir.path(), &["imap-acct", "gc"]))
+        .await
+        .unwrap();
+    assert!(!blob.exists());
+    assert!(dispatch(&parse_cli(
+        dir.path(),
+        &["imap-acct", "gc", "--min-age", "soon"]
+    ))
+    .await
+    .is_err());
+}
+
+#[tokio::test]
+async fn dispatch_imap_acct_appendlimit_sets_and_clears() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    passwords::create_user(&pool, "big@example.org", "x")
+        .await
+        .unwrap();
+
+    dispatch(&parse_cli(
+        dir.path(),
+        &["imap-acct", "appendlimit", "big@example.org", "-v", "2M"],
+    ))
+    .await
+    .unwrap();
+    assert_eq!(
+        chatmail_db::get_append_limit(&pool, "big@example.org")
+            .await
+            .unwrap(),
+        Some(2 * 1024 * 1024)
+    );
+    dispatch(&parse_cli(
+        dir.path(),
+        &["--json", "imap-acct", "appendlimit", "big@example.org"],
+    ))
+    .await
+    .unwrap();
+    dispatch(&parse_cli(
+        dir.path(),
+        &["imap-acct", "appendlimit", "big@example.org", "-v", "0"],
+    ))
+    .await
+    .unwrap();
+    assert_eq!(
+        chatmail_db::get_append_limit(&pool, "big@example.org")
+            .await
+            .unwrap(),
+        None
+    );
+    assert!(dispatch(&parse_cli(
+        dir.path(),
+        &["imap-acct", "appendlimit", "nobody@example.org", "-v", "1M"],
+    ))
+    .await
+    .is_err());
+}
+
+#[tokio::test]
+async fn dispatch_sharing_show_and_rename() {
+    let (dir, _args, _db_path, _pool) = setup_ctl_env().await;
+    for argv in [
+        &["sharing", "reserve", "alice"][..],
+        &["sharing", "rename", "alice", "alice2"],
+        &["--json", "sharing", "show", "alice2"],
+    ] {
+        dispatch(&parse_cli(dir.path(), argv)).await.unwrap();
+    }
+    for argv in [
+        &["sharing", "show", "alice"][..],
+        &["sharing", "rename", "alice2", "share"],
+    ] {
+        assert!(
+            dispatch(&parse_cli(dir.path(), argv)).await.is_err(),
+            "{argv:?}"
+        );
+    }
+
+    let sharing = chatmail_db::init_sharing_db(&dir.path().join("sharing.db"))
+        .await
+        .unwrap();
+    let row = chatmail_db::get_sharing_contact(&sharing, "alice2")
+        .await
+        .unwrap()
+        .unwrap();
+    assert_eq!(row.name, "Reserved");
+}
+
+#[tokio::test]
+async fn dispatch_admin_audit_lists_and_rejects_bad_paging() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    let entry = chatmail_db::AdminAudit {
+        id: 0,
+        created_at: 0,
+        token_prefix: "abcdefgh".into(),
+        method: "POST".into(),
+        resource: "/admin/notice".into(),
+        body: "{}".into(),
+        status: 200,
+        remote: "127.0.0.1".into(),
+        actor: chatmail_db::AUDIT_ACTOR_API.into(),
+        old_value: String::new(),
+        new_value: String::new(),
+    };
+    chatmail_db::record_admin_audit(&pool, &entry)
+        .await
+        .unwrap();
+
+    for argv in [
+        &["admin-audit"][..],
+        &["--json", "admin-audit", "--since", "1h", "--limit", "10"],
+        &["audit", "list", "--since", "24h", "--resource", "/admin/"],
+    ] {
+        dispatch(&parse_cli(dir.path(), argv)).await.unwrap();
+    }
+    assert!(
+        dispatch(&parse_cli(dir.path(), &["admin-audit", "--since", "soon"]))
+            .await
+            .is_err()
+    );
+}
+
+/// Settings commands leave one `cli` row per changed key while logging is on, and none
+/// under the No-Log default.
+#[tokio::test]
+async fn dispatch_settings_changes_are_audited_when_logging() {
+    let (dir, _args, _db_path, pool) = setup_ctl_env().await;
+    dispatch(&parse_cli(dir.path(), &["language", "set", "fa"]))
+        .await
+        .unwrap();
+    let (_, total) = chatmail_db::list_admin_audit(&pool, &Default::default())
+        .await
+        .unwrap();
+    assert_eq!(total, 0, "log off: nothing recorded");
+
+    let config = dir.path().join("logging.conf");
+    std::fs::write(&config, "log stderr\n").unwrap();
+    for argv in [&["language", "set", "de"][..], &["language", "status"]] {
+        dispatch(&parse_cli_with_config(dir.path(), &config, argv))
+            .await
+            .unwrap();
+    }
+    let (rows, total) = chatmail_db::list_admin_audit(&pool, &Default::default())
+        .await
+        .unwrap();
+    assert_eq!(total, 1, "status changes nothing");
+    assert_eq!(rows[0].actor, chatmail_db::AUDIT_ACTOR_CLI);
+    assert_eq!(rows[0].resource, chatmail_db::settings_keys::LANGUAGE);
+    assert_eq!(
+        (rows[0].old_value.as_str(), rows[0].new_value.as_str()),
+        ("\"fa\"", "\"de\"")
+    );
+}
+
 #[tokio::test]
 async fn dispatch_accounts_export_import_roundtrip() {
     let (dir, _args, _db_path, pool) = setup_ctl_env().await;
@@ -217,3 +832,54 @@ fn accounts_and_blocklist_subcommands_parse() {
         })) if username == "u@x.org"
     ));
 }
+
+#[tokio::test]
+async fn dispatch_webhook_test_sends_signed_ping() {
+    use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+    let (dir, _args, _db_path, _pool) = setup_ctl_env().await;
+    let unset = dir.path().join("no-webhook.conf");
+    std::fs::write(&unset, "hostname mx.example.org\n").unwrap();
+    let err = dispatch(&parse_cli_with_config(
+        dir.path(),
+        &unset,
+        &["webhook", "test"],
+    ))
+    .await
+    .unwrap_err();
+    assert!(err.to_string().contains("webhook_url"), "{err}");
+
+    let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+    let addr = listener.local_addr().unwrap();
+    let server = tokio::spawn(async move {
+        let (mut sock, _) = listener.accept().await.unwrap();
+        let mut acc = Vec::new();
+        let mut buf = [0u8; 4096];
+        while !acc.ends_with(br#""event":"ping"}"#) {
+            let n = sock.read(&mut buf).await.unwrap();
+            if n == 0 {
+                break;
+            }
+            acc.extend_from_slice(&buf[..n]);
+        }
+        sock.write_all(b"HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n")
+            .await
+            .unwrap();
+        String::from_utf8_lossy(&acc).into_owned()
+    });
+    let config = dir.path().join("webhook.conf");
+    let conf = format!(
+        "chatmail tcp://0.0.0.0:80 {{\n    webhook_url http://{addr}/in\n    webhook_secret k\n}}\n"
+    );
+    std::fs::write(&config, conf).unwrap();
+    dispatch(&parse_cli_with_config(
+        dir.path(),
+        &config,
+        &["webhook", "test"],
+    ))
+    .await
+    .unwrap();
+    let request = server.await.unwrap();
+    assert!(request.starts_with("POST /in "), "{request}");
+    assert!(request.contains("x-madmail-signature: v1="), "{request}");
+}
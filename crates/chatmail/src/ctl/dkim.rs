@@ -0,0 +1,174 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail dkim` — outbound DKIM keys under `{state_dir}/dkim_keys`.
+//!
+//! Works on the files and the settings DB directly, like `/admin/dkim`; a running server picks
+//! an activated selector up with the next submitted message.
+
+use chatmail_config::{Args, DkimCommand};
+use chatmail_db::{set_setting, settings_keys};
+use chatmail_delivery::dkim::{
+    self, generate_key, list_keys, rotation_selector, signing_domains, valid_selector,
+    DkimAlgorithm,
+};
+use chatmail_types::{ChatmailError, Result};
+use serde_json::json;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn dkim(args: &Args, cmd: &DkimCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "dkim");
+    let domains = domains(&ctx);
+
+    match cmd {
+        DkimCommand::List => {
+            let keys = list_keys(&ctx.state_dir)?;
+            let active = active_selectors(&ctx).await;
+            if out.is_json() {
+                return out.emit(json!({ "active": active, "domains": domains, "keys": keys }));
+            }
+            if active.is_empty() {
+                out.line("Signing: off (no dkim line in the submission block)");
+            } else {
+                out.line(format!("Signing with: {}", active.join(", ")));
+            }
+            if keys.is_empty() {
+                out.line(format!(
+                    "No keys under {}",
+                    dkim::keys_dir(&ctx.state_dir).display()
+                ));
+            }
+            for key in &keys {
+                let mark = if active.contains(&key.selector) {
+                    "*"
+                } else {
+                    " "
+                };
+                out.line(format!(
+                    "{mark} {} {} ({})",
+                    key.domain, key.selector, key.algorithm
+                ));
+                out.line(format!("    {} TXT \"{}\"", key.dns_name, key.dns_value));
+            }
+            Ok(())
+        }
+        DkimCommand::Rotate {
+            algorithm,
+            selector,
+        } => {
+            let algorithm = DkimAlgorithm::parse(algorithm).ok_or_else(|| {
+                ChatmailError::config(format!(
+                    "unknown algorithm {algorithm:?} (rsa2048, rsa4096 or ed25519)"
+                ))
+            })?;
+            if domains.is_empty() {
+                return Err(ChatmailError::config(
+                    "DKIM needs a mail domain, not an IP address",
+                ));
+            }
+            let selector = match selector {
+                Some(s) if !valid_selector(s) => {
+                    return Err(ChatmailError::config(format!("invalid selector {s:?}")));
+                }
+                Some(s) => s.clone(),
+                None => {
+                    rotation_selector(&ctx.state_dir, &domains, time::OffsetDateTime::now_utc())
+                }
+            };
+            let keys = domains
+                .iter()
+                .map(|d| generate_key(&ctx.state_dir, d, &selector, algorithm))
+                .collect::<Result<Vec<_>>>()?;
+            if out.is_json() {
+                return out.emit(json!({
+                    "selector": selector,
+                    "algorithm": algorithm.as_str(),
+                    "keys": keys,
+                }));
+            }
+            out.line(format!(
+                "Generated {} key(s) under selector {selector}. Publish:",
+                algorithm.as_str()
+            ));
+            for key in &keys {
+                out.line(format!("  {} TXT \"{}\"", key.dns_name, key.dns_value));
+            }
+            out.line(format!(
+                "Then run `madmail dkim activate {selector}`. The old keys stay in place."
+            ));
+            Ok(())
+        }
+        DkimCommand::Activate { selector } => {
+            let keys = list_keys(&ctx.state_dir)?;
+            let has_key = |d: &String| {
+                keys.iter()
+                    .any(|k| k.domain == *d && k.selector == *selector)
+            };
+            if !domains.first().is_some_and(|d| has_key(d)) {
+                return Err(ChatmailError::config(format!(
+                    "no DKIM key for selector {selector}; run `madmail dkim rotate` first"
+                )));
+            }
+            let pool = ctx.open_pool().await?;
+            set_setting(&pool, settings_keys::DKIM_SELECTOR, selector).await?;
+            let missing: Vec<&String> = domains.iter().filter(|d| !has_key(*d)).collect();
+            if !missing.is_empty() && !out.is_json() {
+                out.line(format!(
+                    "Warning: no key for {selector} on {}; that mail goes out unsigned.",
+                    missing
+                        .iter()
+                        .map(|d| d.as_str())
+                        .collect::<Vec<_>>()
+                        .join(", ")
+                ));
+            }
+            out.done_msg(
+                format!("Success: outbound mail is now signed with selector {selector}."),
+                json!({ "selector": selector, "missing_domains": missing }),
+                format!("Activated DKIM selector {selector}"),
+            )
+        }
+    }
+}
+
+/// Signing domains of the config, primary first.
+fn domains(ctx: &CtlContext) -> Vec<String> {
+    let cfg = &ctx.config;
+    let primary = cfg
+        .primary_domain
+        .as_deref()
+        .or(cfg.hostname.as_deref())
+        .unwrap_or_default();
+    let local = cfg.effective_local_domains(primary).join(" ");
+    signing_domains(primary, Some(&local))
+}
+
+/// Selectors a running server signs with: the activated one, else the config's.
+pub(super) async fn active_selectors(ctx: &CtlContext) -> Vec<String> {
+    let configured = &ctx.config.dkim_selectors;
+    if ctx.require_db().is_ok() {
+        if let Ok(pool) = ctx.open_pool().await {
+            if let Ok(active) = dkim::active_selectors(&pool, configured).await {
+                return active;
+            }
+        }
+    }
+    configured.clone()
+}
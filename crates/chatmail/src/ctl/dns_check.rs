@@ -0,0 +1,643 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail dns-check` — compare the live DNS zone with what this config needs.
+//!
+//! Looks up the A/AAAA, MX, SPF, DMARC, MTA-STS and DKIM records of the mail domain over
+//! [`DnsResolver`] and prints expected against actual for each. Required records (address,
+//! MX) fail the run when wrong; the optional ones warn when missing. `--fix-cloudflare`
+//! creates the records that are missing outright through the Cloudflare API; records that
+//! exist with the wrong value are left for the operator.
+
+use std::io::IsTerminal;
+use std::net::IpAddr;
+use std::path::Path;
+use std::time::Duration;
+
+use chatmail_config::Args;
+use chatmail_delivery::dkim::{keys_dir, DEFAULT_DKIM_SELECTOR};
+use chatmail_state::{DnsError, DnsResolver, SystemResolver};
+use chatmail_types::{ChatmailError, Result};
+use serde::Serialize;
+
+use super::context::CtlContext;
+use super::diagnose::DiagStatus;
+use super::output::CtlOut;
+
+pub const CLOUDFLARE_API_BASE: &str = "https://api.cloudflare.com/client/v4";
+const CLOUDFLARE_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// Suggested records for a fresh zone (same values as the DNS guide).
+const SPF_RECORD: &str = "v=spf1 mx a -all";
+const DMARC_RECORD: &str = "v=DMARC1; p=none";
+const MX_PREFERENCE: u16 = 10;
+
+/// What the zone is checked against.
+#[derive(Debug, Clone)]
+pub(super) struct DnsTarget {
+    /// Mail domain (MX, SPF, DMARC, MTA-STS, DKIM live here).
+    pub domain: String,
+    /// Server hostname (MX target, A/AAAA).
+    pub hostname: String,
+    pub public_ip: Option<IpAddr>,
+    /// Selector outbound mail is signed with (`default` when none is configured).
+    pub dkim_selector: String,
+    /// Contents of `dkim_keys/<domain>_<selector>.dns`, when a key was generated.
+    pub dkim_txt: Option<String>,
+}
+
+impl DnsTarget {
+    fn from_ctl(ctx: &CtlContext, domain: Option<&str>, dkim_selector: String) -> Result<Self> {
+        let cfg = &ctx.config;
+        let domain = domain
+            .or(cfg.primary_domain.as_deref())
+            .or(cfg.hostname.as_deref())
+            .map(normalize_name)
+            .filter(|d| !d.is_empty())
+            .ok_or_else(|| {
+                ChatmailError::config("no primary_domain in the config; pass --domain")
+            })?;
+        let hostname = cfg
+            .hostname
+            .as_deref()
+            .map(normalize_name)
+            .unwrap_or_else(|| domain.clone());
+        let public_ip = match cfg.public_ip.as_deref() {
+            Some(ip) => Some(ip.trim().parse().map_err(|_| {
+                ChatmailError::config(format!("public_ip {ip:?} is not an IP address"))
+            })?),
+            None => None,
+        };
+        let dkim_txt = read_dkim_record(&ctx.state_dir, &domain, &dkim_selector);
+        Ok(Self {
+            domain,
+            hostname,
+            public_ip,
+            dkim_selector,
+            dkim_txt,
+        })
+    }
+}
+
+/// A record `--fix-cloudflare` would create.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub(super) struct DnsFix {
+    #[serde(rename = "type")]
+    pub kind: &'static str,
+    pub name: String,
+    pub content: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub priority: Option<u16>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub(super) struct DnsCheckRow {
+    pub record: &'static str,
+    pub name: String,
+    pub expected: String,
+    pub actual: String,
+    pub status: DiagStatus,
+    /// Set when the record is missing and can be created as `expected`.
+    #[serde(skip)]
+    pub fix: Option<DnsFix>,
+    /// Created by `--fix-cloudflare` during this run.
+    pub fixed: bool,
+}
+
+impl DnsCheckRow {
+    fn new(record: &'static str, name: &str, expected: impl Into<String>) -> Self {
+        Self {
+            record,
+            name: name.to_string(),
+            expected: expected.into(),
+            actual: String::new(),
+            status: DiagStatus::Pass,
+            fix: None,
+            fixed: false,
+        }
+    }
+
+    fn with(mut self, status: DiagStatus, actual: impl Into<String>) -> Self {
+        self.status = status;
+        self.actual = actual.into();
+        self
+    }
+
+    fn fixable(mut self, kind: &'static str, content: &str, priority: Option<u16>) -> Self {
+        self.fix = Some(DnsFix {
+            kind,
+            name: self.name.clone(),
+            content: content.to_string(),
+            priority,
+        });
+        self
+    }
+}
+
+pub async fn dns_check(
+    args: &Args,
+    domain: Option<&str>,
+    cloudflare: Option<(&str, &str)>,
+) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "dns-check");
+    let selector = super::dkim::active_selectors(&ctx)
+        .await
+        .into_iter()
+        .next()
+        .unwrap_or_else(|| DEFAULT_DKIM_SELECTOR.to_string());
+    let target = DnsTarget::from_ctl(&ctx, domain, selector)?;
+    let mut rows = check_records(&SystemResolver::default(), &target).await;
+    if let Some((token, zone)) = cloudflare {
+        let client = reqwest::Client::builder()
+            .timeout(CLOUDFLARE_TIMEOUT)
+            .build()
+            .unwrap_or_default();
+        apply_cloudflare_fixes(&client, CLOUDFLARE_API_BASE, token, zone, &mut rows, &out).await?;
+    }
+    report(&out, &target, &rows)
+}
+
+/// Every row for `target`, in display order. Never fails: lookup errors become rows.
+pub(super) async fn check_records(dns: &dyn DnsResolver, target: &DnsTarget) -> Vec<DnsCheckRow> {
+    if target.hostname.parse::<IpAddr>().is_ok() {
+        return vec![DnsCheckRow::new("A", &target.hostname, "—")
+            .with(DiagStatus::Skip, "IP-only relay: no DNS records are needed")];
+    }
+    vec![
+        check_address(dns, target).await,
+        check_mx(dns, target).await,
+        check_txt(
+            dns,
+            "SPF",
+            &target.domain,
+            "v=spf1",
+            SPF_RECORD,
+            DiagStatus::Warn,
+        )
+        .await,
+        check_txt(
+            dns,
+            "DMARC",
+            &format!("_dmarc.{}", target.domain),
+            "v=DMARC1",
+            DMARC_RECORD,
+            DiagStatus::Warn,
+        )
+        .await,
+        check_txt(
+            dns,
+            "MTA-STS",
+            &format!("_mta-sts.{}", target.domain),
+            "v=STSv1",
+            "v=STSv1; id=… (optional)",
+            DiagStatus::Skip,
+        )
+        .await,
+        check_dkim(dns, target).await,
+    ]
+}
+
+async fn check_address(dns: &dyn DnsResolver, target: &DnsTarget) -> DnsCheckRow {
+    let expected = target
+        .public_ip
+        .map_or_else(|| "any address".to_string(), |ip| ip.to_string());
+    let row = DnsCheckRow::new("A/AAAA", &target.hostname, expected);
+    match dns.ips(&target.hostname).await {
+        Ok(ips) => {
+            let actual = join(ips.iter());
+            match target.public_ip {
+                Some(ip) if !ips.contains(&ip) => row.with(DiagStatus::Fail, actual),
+                _ => row.with(DiagStatus::Pass, actual),
+            }
+        }
+        Err(e) => {
+            let row = row.with(DiagStatus::Fail, lookup_error(&e));
+            match (e, target.public_ip) {
+                (DnsError::NotFound, Some(ip)) => {
+                    let kind = if ip.is_ipv4() { "A" } else { "AAAA" };
+                    row.fixable(kind, &ip.to_string(), None)
+                }
+                _ => row,
+            }
+        }
+    }
+}
+
+async fn check_mx(dns: &dyn DnsResolver, target: &DnsTarget) -> DnsCheckRow {
+    let row = DnsCheckRow::new(
+        "MX",
+        &target.domain,
+        format!("{MX_PREFERENCE} {}", target.hostname),
+    );
+    match dns.mx(&target.domain).await {
+        Ok(hosts) => {
+            let actual = join(hosts.iter());
+            if hosts.iter().any(|h| normalize_name(h) == target.hostname) {
+                row.with(DiagStatus::Pass, actual)
+            } else {
+                row.with(DiagStatus::Fail, actual)
+            }
+        }
+        Err(DnsError::NotFound) => row
+            .with(DiagStatus::Fail, lookup_error(&DnsError::NotFound))
+            .fixable("MX", &target.hostname, Some(MX_PREFERENCE)),
+        Err(e) => row.with(DiagStatus::Fail, lookup_error(&e)),
+    }
+}
+
+/// One TXT record starting with `prefix` at `name`. A missing record gets `when_missing`
+/// (these are optional); two or more fail, since receivers then ignore all of them.
+async fn check_txt(
+    dns: &dyn DnsResolver,
+    record: &'static str,
+    name: &str,
+    prefix: &str,
+    suggested: &str,
+    when_missing: DiagStatus,
+) -> DnsCheckRow {
+    let row = DnsCheckRow::new(record, name, suggested);
+    let found: Vec<String> = match dns.txt(name).await {
+        Ok(txt) => txt
+            .into_iter()
+            .filter(|t| starts_with_ignore_case(t, prefix))
+            .collect(),
+        Err(DnsError::NotFound) => Vec::new(),
+        Err(e) => return row.with(DiagStatus::Fail, lookup_error(&e)),
+    };
+    match found.len() {
+        0 => {
+            let row = row.with(when_missing, "(none)");
+            // Only complete suggestions can be published as they are.
+            if suggested.contains('…') {
+                row
+            } else {
+                row.fixable("TXT", suggested, None)
+            }
+        }
+        1 => row.with(DiagStatus::Pass, found.concat()),
+        _ => row.with(
+            DiagStatus::Fail,
+            format!("{} records: {}", found.len(), found.join(" | ")),
+        ),
+    }
+}
+
+async fn check_dkim(dns: &dyn DnsResolver, target: &DnsTarget) -> DnsCheckRow {
+    let name = format!("{}._domainkey.{}", target.dkim_selector, target.domain);
+    let Some(expected) = &target.dkim_txt else {
+        return DnsCheckRow::new("DKIM", &name, "—").with(
+            DiagStatus::Skip,
+            format!(
+                "no dkim_keys/{}_{}.dns (outbound mail is not signed)",
+                target.domain, target.dkim_selector
+            ),
+        );
+    };
+    let row = DnsCheckRow::new("DKIM", &name, expected.clone());
+    match dns.txt(&name).await {
+        Ok(txt) => {
+            let want = squash(expected);
+            let actual = txt.join(" | ");
+            if txt.iter().any(|t| squash(t) == want) {
+                row.with(DiagStatus::Pass, actual)
+            } else {
+                row.with(DiagStatus::Fail, actual)
+            }
+        }
+        Err(DnsError::NotFound) => row
+            .with(DiagStatus::Fail, lookup_error(&DnsError::NotFound))
+            .fixable("TXT", expected, None),
+        Err(e) => row.with(DiagStatus::Fail, lookup_error(&e)),
+    }
+}
+
+/// Create every fixable row's record in Cloudflare zone `zone`.
+pub(super) async fn apply_cloudflare_fixes(
+    client: &reqwest::Client,
+    base: &str,
+    token: &str,
+    zone: &str,
+    rows: &mut [DnsCheckRow],
+    out: &CtlOut,
+) -> Result<()> {
+    let url = format!("{}/zones/{zone}/dns_records", base.trim_end_matches('/'));
+    for row in rows.iter_mut() {
+        let Some(fix) = row.fix.as_ref().filter(|_| row.status != DiagStatus::Pass) else {
+            continue;
+        };
+        let mut body = serde_json::json!({
+            "type": fix.kind,
+            "name": fix.name,
+            "content": fix.content,
+            "ttl": 1,
+        });
+        if let Some(priority) = fix.priority {
+            body["priority"] = priority.into();
+        }
+        if fix.kind == "A" || fix.kind == "AAAA" {
+            // Mail hosts must resolve to the server itself, not a proxy edge.
+            body["proxied"] = false.into();
+        }
+        let resp = client
+            .post(&url)
+            .bearer_auth(token)
+            .json(&body)
+            .send()
+            .await
+            .map_err(|e| ChatmailError::config(format!("cloudflare: {e}")))?;
+        let status = resp.status();
+        let reply: serde_json::Value = resp.json().await.unwrap_or_default();
+        if !status.is_success() || reply["success"] != true {
+            let errors = reply["errors"]
+                .as_array()
+                .map(|errs| {
+                    errs.iter()
+                        .filter_map(|e| e["message"].as_str())
+                        .collect::<Vec<_>>()
+                        .join("; ")
+                })
+                .unwrap_or_default();
+            return Err(ChatmailError::config(format!(
+                "cloudflare: creating {} {} failed (HTTP {status}): {errors}",
+                fix.kind, fix.name
+            )));
+        }
+        out.line(format!(
+            "Created {} {} → {}",
+            fix.kind, fix.name, fix.content
+        ));
+        row.fixed = true;
+    }
+    Ok(())
+}
+
+fn report(out: &CtlOut, target: &DnsTarget, rows: &[DnsCheckRow]) -> Result<()> {
+    let failed = rows
+        .iter()
+        .filter(|r| r.status == DiagStatus::Fail && !r.fixed)
+        .count();
+    out.emit(serde_json::json!({
+        "domain": target.domain,
+        "hostname": target.hostname,
+        "passed": failed == 0,
+        "records": rows,
+    }))?;
+    let color = std::io::stdout().is_terminal() && std::env::var_os("NO_COLOR").is_none();
+    out.line(format!(
+        "DNS for {} (hostname {})",
+        target.domain, target.hostname
+    ));
+    out.blank();
+    for r in rows {
+        let status = if r.fixed {
+            "created".to_string()
+        } else {
+            status_label(r.status, color)
+        };
+        out.line(format!(
+            "{} {:<8} {:<7} {}",
+            r.status.icon(),
+            status,
+            r.record,
+            r.name
+        ));
+        out.line(format!("      expected: {}", r.expected));
+        out.line(format!("      actual:   {}", r.actual));
+    }
+    if rows.iter().any(|r| r.fixed) {
+        out.blank();
+        out.line("New records can take a few minutes to show up; run dns-check again.");
+    }
+    if failed > 0 {
+        return Err(ChatmailError::config(format!(
+            "{failed} DNS record(s) missing or wrong"
+        )));
+    }
+    Ok(())
+}
+
+fn status_label(status: DiagStatus, color: bool) -> String {
+    let (label, code) = match status {
+        DiagStatus::Pass => ("pass", "32"),
+        DiagStatus::Warn => ("warn", "33"),
+        DiagStatus::Fail => ("FAIL", "31"),
+        DiagStatus::Skip => ("skip", "90"),
+    };
+    if color {
+        // Pad before colouring so the escape codes do not count towards the column width.
+        format!("\x1b[{code}m{label:<8}\x1b[0m")
+    } else {
+        label.to_string()
+    }
+}
+
+/// `dkim_keys/<domain>_<selector>.dns` under the state dir: the TXT value to publish.
+fn read_dkim_record(state_dir: &Path, domain: &str, selector: &str) -> Option<String> {
+    let path = keys_dir(state_dir).join(format!("{domain}_{selector}.dns"));
+    let text = std::fs::read_to_string(path).ok()?;
+    let text = text.trim();
+    (!text.is_empty()).then(|| text.to_string())
+}
+
+fn normalize_name(name: &str) -> String {
+    name.trim().trim_end_matches('.').to_ascii_lowercase()
+}
+
+fn starts_with_ignore_case(text: &str, prefix: &str) -> bool {
+    text.trim_start()
+        .get(..prefix.len())
+        .is_some_and(|head| head.eq_ignore_ascii_case(prefix))
+}
+
+/// TXT value without quoting and whitespace, so split strings compare equal.
+fn squash(txt: &str) -> String {
+    txt.chars()
+        .filter(|c| !c.is_whitespace() && *c != '"')
+        .collect()
+}
+
+fn join<T: ToString>(items: impl Iterator<Item = T>) -> String {
+    items.map(|i| i.to_string()).collect::<Vec<_>>().join(", ")
+}
+
+fn lookup_error(e: &DnsError) -> String {
+    match e {
+        DnsError::NotFound => "(none)".to_string(),
+        DnsError::Temporary(msg) => msg.clone(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::routing::post;
+    use axum::{Json, Router};
+    use chatmail_state::StaticResolver;
+    use std::sync::{Arc, Mutex};
+
+    fn target() -> DnsTarget {
+        DnsTarget {
+            domain: "example.org".into(),
+            hostname: "mail.example.org".into(),
+            public_ip: Some("203.0.113.7".parse().unwrap()),
+            dkim_selector: "default".into(),
+            dkim_txt: Some("v=DKIM1; k=rsa; p=MIIB".into()),
+        }
+    }
+
+    fn good_zone() -> StaticResolver {
+        let mut dns = StaticResolver::default()
+            .with_txt("example.org", "v=spf1 mx a -all")
+            .with_txt("example.org", "google-site-verification=x")
+            .with_txt("_dmarc.example.org", "v=DMARC1; p=none")
+            .with_txt("_mta-sts.example.org", "v=STSv1; id=20260101")
+            .with_txt(
+                "default._domainkey.example.org",
+                "v=DKIM1; k=rsa; \"p=MIIB\"",
+            );
+        dns.mx
+            .insert("example.org".into(), vec!["mail.example.org.".into()]);
+        dns.ips.insert(
+            "mail.example.org".into(),
+            vec!["203.0.113.7".parse().unwrap()],
+        );
+        dns
+    }
+
+    fn status_of<'a>(rows: &'a [DnsCheckRow], record: &str) -> &'a DnsCheckRow {
+        rows.iter().find(|r| r.record == record).unwrap()
+    }
+
+    #[tokio::test]
+    async fn matching_zone_passes_every_record() {
+        let rows = check_records(&good_zone(), &target()).await;
+        assert_eq!(rows.len(), 6);
+        for row in &rows {
+            assert_eq!(row.status, DiagStatus::Pass, "{row:?}");
+        }
+        assert_eq!(status_of(&rows, "SPF").actual, "v=spf1 mx a -all");
+    }
+
+    #[tokio::test]
+    async fn mismatches_fail_with_expected_and_actual() {
+        let mut dns = good_zone();
+        dns.ips.insert(
+            "mail.example.org".into(),
+            vec!["198.51.100.1".parse().unwrap()],
+        );
+        dns.mx
+            .insert("example.org".into(), vec!["mx.elsewhere.net".into()]);
+        dns = dns.with_txt("example.org", "v=spf1 -all");
+        dns.txt.insert(
+            "default._domainkey.example.org".into(),
+            vec!["v=DKIM1; k=rsa; p=OTHER".into()],
+        );
+        let rows = check_records(&dns, &target()).await;
+
+        let a = status_of(&rows, "A/AAAA");
+        assert_eq!(a.status, DiagStatus::Fail);
+        assert_eq!(
+            (a.expected.as_str(), a.actual.as_str()),
+            ("203.0.113.7", "198.51.100.1")
+        );
+        assert!(a.fix.is_none(), "a wrong record is never overwritten");
+        let mx = status_of(&rows, "MX");
+        assert_eq!(mx.status, DiagStatus::Fail);
+        assert_eq!(mx.actual, "mx.elsewhere.net");
+        assert_eq!(
+            status_of(&rows, "SPF").status,
+            DiagStatus::Fail,
+            "two SPF records"
+        );
+        assert_eq!(status_of(&rows, "DKIM").status, DiagStatus::Fail);
+        assert_eq!(status_of(&rows, "DMARC").status, DiagStatus::Pass);
+    }
+
+    #[tokio::test]
+    async fn empty_zone_fails_required_and_warns_optional() {
+        let mut t = target();
+        t.dkim_txt = None;
+        let rows = check_records(&StaticResolver::default(), &t).await;
+        assert_eq!(status_of(&rows, "A/AAAA").status, DiagStatus::Fail);
+        assert_eq!(status_of(&rows, "MX").status, DiagStatus::Fail);
+        assert_eq!(status_of(&rows, "SPF").status, DiagStatus::Warn);
+        assert_eq!(status_of(&rows, "DMARC").status, DiagStatus::Warn);
+        assert_eq!(status_of(&rows, "MTA-STS").status, DiagStatus::Skip);
+        assert_eq!(status_of(&rows, "DKIM").status, DiagStatus::Skip);
+        let fixes: Vec<_> = rows.iter().filter_map(|r| r.fix.as_ref()).collect();
+        assert_eq!(fixes.len(), 4, "A, MX, SPF, DMARC: {fixes:?}");
+        assert_eq!(
+            status_of(&rows, "MX").fix.as_ref().unwrap().priority,
+            Some(10)
+        );
+    }
+
+    #[tokio::test]
+    async fn ip_only_relay_needs_no_records() {
+        let mut t = target();
+        t.hostname = "203.0.113.7".into();
+        let rows = check_records(&StaticResolver::default(), &t).await;
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0].status, DiagStatus::Skip);
+    }
+
+    #[tokio::test]
+    async fn cloudflare_fix_creates_only_missing_records() {
+        let seen: Arc<Mutex<Vec<serde_json::Value>>> = Arc::default();
+        let log = Arc::clone(&seen);
+        let app = Router::new().route(
+            "/zones/zone1/dns_records",
+            post(move |Json(body): Json<serde_json::Value>| {
+                let log = Arc::clone(&log);
+                async move {
+                    log.lock().unwrap().push(body);
+                    Json(serde_json::json!({ "success": true, "errors": [] }))
+                }
+            }),
+        );
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let base = format!("http://{}", listener.local_addr().unwrap());
+        tokio::spawn(async move { axum::serve(listener, app).await.unwrap() });
+
+        let mut dns = good_zone();
+        dns.txt.remove("_dmarc.example.org");
+        dns.mx
+            .insert("example.org".into(), vec!["mx.elsewhere.net".into()]);
+        let mut rows = check_records(&dns, &target()).await;
+        let dir = tempfile::tempdir().unwrap();
+        let out = CtlOut::from_args(&crate::ctl::test_harness::ctl_args(dir.path()), "dns-check");
+        apply_cloudflare_fixes(
+            &reqwest::Client::new(),
+            &base,
+            "tok",
+            "zone1",
+            &mut rows,
+            &out,
+        )
+        .await
+        .unwrap();
+
+        let created = seen.lock().unwrap().clone();
+        assert_eq!(created.len(), 1, "{created:?}");
+        assert_eq!(created[0]["type"], "TXT");
+        assert_eq!(created[0]["name"], "_dmarc.example.org");
+        assert_eq!(created[0]["content"], DMARC_RECORD);
+        assert!(status_of(&rows, "DMARC").fixed);
+        assert!(!status_of(&rows, "MX").fixed);
+    }
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail domains` — per-domain settings of the local domains.
+//!
+//! `domains catchall` writes the `catchalls` table; a running server re-reads it every 30 s.
+
+use chatmail_auth::normalize_username;
+use chatmail_config::{Args, CatchallCommand, DomainsCommand};
+use chatmail_db::policies::unix_now;
+use chatmail_db::{blocklist, passwords};
+use chatmail_types::{ChatmailError, Result};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn domains(args: &Args, cmd: &DomainsCommand) -> Result<()> {
+    match cmd {
+        DomainsCommand::Catchall(cmd) => catchall(args, cmd).await,
+    }
+}
+
+async fn catchall(args: &Args, cmd: &CatchallCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let pool = ctx.open_pool().await?;
+    let out = CtlOut::from_args(args, "domains");
+
+    match cmd {
+        CatchallCommand::Set {
+            domain,
+            target,
+            daily_cap,
+        } => {
+            let host = ctx.config.hostname.as_deref().unwrap_or("127.0.0.1");
+            let local = ctx.config.effective_local_domains(host);
+            let domain = domain.trim().trim_end_matches('.').to_ascii_lowercase();
+            if !local.iter().any(|d| d.eq_ignore_ascii_case(&domain)) {
+                return Err(ChatmailError::config(format!(
+                    "{domain} is not a local domain (local: {})",
+                    local.join(", ")
+                )));
+            }
+            let target = normalize_username(target)?;
+            if !passwords::user_exists(&pool, &target).await? {
+                return Err(ChatmailError::config(format!(
+                    "catch-all target {target} has no account"
+                )));
+            }
+            if blocklist::is_blocked(&pool, &target).await? {
+                return Err(ChatmailError::config(format!(
+                    "catch-all target {target} is blocked"
+                )));
+            }
+            let catchall = chatmail_db::set_catchall(&pool, &domain, &target, *daily_cap).await?;
+            let inactive =
+                chatmail_db::catchall_inactive_reason(&pool, ctx.config.auth_auto_create).await?;
+            if let (false, Some(reason)) = (out.is_json(), inactive) {
+                out.line(format!(
+                    "Note: {reason}; unknown addresses may still become accounts, so nothing \
+                     is redirected until registration is closed."
+                ));
+            }
+            out.done_msg(
+                format!(
+                    "Success: unknown addresses @{} go to {} (at most {} per day).",
+                    catchall.domain, catchall.target, catchall.daily_cap
+                ),
+                serde_json::json!({
+                    "domain": catchall.domain,
+                    "target": catchall.target,
+                    "daily_cap": catchall.daily_cap,
+                    "active": inactive.is_none(),
+                }),
+                format!("Set catch-all for {}", catchall.domain),
+            )?;
+        }
+        CatchallCommand::Clear { domain } => {
+            if !chatmail_db::clear_catchall(&pool, domain).await? {
+                return Err(ChatmailError::config(format!("no catch-all for {domain}")));
+            }
+            let domain = domain.trim().trim_end_matches('.').to_ascii_lowercase();
+            out.done_msg(
+                format!("Success: removed catch-all for {domain}."),
+                serde_json::json!({ "domain": domain }),
+                format!("Removed catch-all {domain}"),
+            )?;
+        }
+        CatchallCommand::List => {
+            let catchalls = chatmail_db::list_catchalls(&pool).await?;
+            let day = chatmail_db::catchall_day(unix_now());
+            let mut today = Vec::with_capacity(catchalls.len());
+            for c in &catchalls {
+                today.push(chatmail_db::catchall_deliveries(&pool, &c.domain, day).await?);
+            }
+            let inactive =
+                chatmail_db::catchall_inactive_reason(&pool, ctx.config.auth_auto_create).await?;
+            if out.is_json() {
+                let rows: Vec<_> = catchalls
+                    .iter()
+                    .zip(&today)
+                    .map(|(c, today)| {
+                        serde_json::json!({
+                            "domain": c.domain,
+                            "target": c.target,
+                            "daily_cap": c.daily_cap,
+                            "today": today,
+                            "created_at": c.created_at,
+                        })
+                    })
+                    .collect();
+                return out.emit(serde_json::json!({
+                    "catchalls": rows,
+                    "active": inactive.is_none(),
+                    "inactive_reason": inactive,
+                }));
+            }
+            out.line("DOMAIN\tTARGET\tTODAY/CAP");
+            for (c, today) in catchalls.iter().zip(&today) {
+                out.line(format!(
+                    "{}\t{}\t{today}/{}",
+                    c.domain, c.target, c.daily_cap
+                ));
+            }
+            out.line("---");
+            out.line(format!("Total: {} catch-alls.", catchalls.len()));
+            if let (false, Some(reason)) = (catchalls.is_empty(), inactive) {
+                out.line(format!("Inactive: {reason}."));
+            }
+        }
+    }
+    Ok(())
+}
@@ -0,0 +1,303 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail imap-acct` — per-account maildir reports.
+//!
+//! `backlog` lists accounts whose clients accept mail but no longer fetch it (same data as
+//! `GET /admin/backlog`). `stat` walks the mail store for real disk usage and orphaned CAS
+//! blobs (the server keeps the same scan in `storage_usage` on `/admin/status`); `gc`
+//! removes those orphans. `appendlimit` shows or sets one account's message size cap.
+//! `quota recalc` asks the running server to rescan usage (POST `/admin/quota`), since the
+//! counters it repairs live in that process.
+
+use chatmail_auth::normalize_username;
+use chatmail_config::{
+    effective_max_message_bytes, format_data_size, parse_data_size, parse_duration,
+    resolve_max_message_bytes, Args, ImapAcctCommand, ImapAcctQuotaCommand,
+};
+use chatmail_db::policies::unix_now;
+use chatmail_db::{get_append_limit, get_setting, passwords, set_append_limit, settings_keys};
+use chatmail_storage::{collect_orphan_blobs, inbox_backlog, storage_usage, MailboxStore};
+use chatmail_types::{ChatmailError, Result};
+use serde_json::Value;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use super::request_reload::admin_request;
+
+pub async fn imap_acct(args: &Args, cmd: &ImapAcctCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "imap-acct");
+
+    match cmd {
+        ImapAcctCommand::Backlog { min_age, min_count } => {
+            let age = parse_duration(min_age).map_err(|_| {
+                ChatmailError::config(format!("invalid --min-age duration: {min_age}"))
+            })?;
+            let store = MailboxStore::new(&ctx.state_dir);
+            let report = inbox_backlog(&store, age, *min_count).await?;
+            if out.is_json() {
+                let accounts: Vec<_> = report
+                    .accounts
+                    .iter()
+                    .map(|a| {
+                        serde_json::json!({
+                            "username": a.username,
+                            "stale": a.stale,
+                            "stale_bytes": a.stale_bytes,
+                            "oldest": a.oldest,
+                        })
+                    })
+                    .collect();
+                return out.emit(serde_json::json!({
+                    "min_age_secs": age.as_secs(),
+                    "min_count": min_count,
+                    "scanned": report.scanned,
+                    "stale": report.stale,
+                    "stale_bytes": report.stale_bytes,
+                    "accounts": accounts,
+                }));
+            }
+            let now = unix_now();
+            out.line("USERNAME\tSTALE\tBYTES\tOLDEST");
+            for a in &report.accounts {
+                out.line(format!(
+                    "{}\t{}\t{}\t{}h ago",
+                    a.username,
+                    a.stale,
+                    a.stale_bytes,
+                    (now - a.oldest).max(0) / 3600
+                ));
+            }
+            out.line("---");
+            out.line(format!(
+                "Total: {} of {} accounts with at least {} unseen messages older than {}; \
+                 {} messages, {} bytes.",
+                report.accounts.len(),
+                report.scanned,
+                min_count,
+                min_age,
+                report.stale,
+                report.stale_bytes
+            ));
+        }
+        ImapAcctCommand::Stat => {
+            let store = MailboxStore::new(&ctx.state_dir);
+            let usage = storage_usage(&store).await?;
+            let threshold = ctx.config.effective_storage_orphan_warning();
+            let warn = usage.orphan_blobs > 0 && usage.orphan_bytes >= threshold;
+            if out.is_json() {
+                return out.emit(serde_json::json!({
+                    "messages": usage.messages,
+                    "logical_bytes": usage.logical_bytes,
+                    "physical_bytes": usage.physical_bytes,
+                    "allocated_bytes": usage.allocated_bytes,
+                    "dedup_ratio": usage.dedup_ratio(),
+                    "blobs": usage.blobs,
+                    "blob_bytes": usage.blob_bytes,
+                    "orphan_blobs": usage.orphan_blobs,
+                    "orphan_bytes": usage.orphan_bytes,
+                    "orphan_warning_bytes": threshold,
+                    "ok": !warn,
+                }));
+            }
+            out.line(format!(
+                "Messages:   {} ({} bytes)",
+                usage.messages, usage.logical_bytes
+            ));
+            out.line(format!(
+                "On disk:    {} bytes ({} allocated)",
+                usage.physical_bytes, usage.allocated_bytes
+            ));
+            if let Some(ratio) = usage.dedup_ratio() {
+                out.line(format!("Dedup:      {ratio:.2}x"));
+            }
+            out.line(format!(
+                "Blobs:      {} ({} bytes)",
+                usage.blobs, usage.blob_bytes
+            ));
+            out.line(format!(
+                "Orphaned:   {} ({} bytes)",
+                usage.orphan_blobs, usage.orphan_bytes
+            ));
+            if warn {
+                out.line(format!(
+                    "Warning: orphaned blobs exceed storage_orphan_warning ({threshold} bytes); \
+                     run `madmail imap-acct gc` to reclaim them."
+                ));
+            }
+        }
+        ImapAcctCommand::Gc { min_age, dry_run } => {
+            let age = parse_duration(min_age).map_err(|_| {
+                ChatmailError::config(format!("invalid --min-age duration: {min_age}"))
+            })?;
+            let store = MailboxStore::new(&ctx.state_dir);
+            let sweep = collect_orphan_blobs(&store, age, *dry_run).await?;
+            let verb = if *dry_run { "Would remove" } else { "Removed" };
+            let summary = format!(
+                "{verb} {} orphaned blobs ({} bytes)",
+                sweep.removed, sweep.removed_bytes
+            );
+            out.done_msg(
+                format!("{summary}."),
+                serde_json::json!({
+                    "dry_run": dry_run,
+                    "removed": sweep.removed,
+                    "removed_bytes": sweep.removed_bytes,
+                }),
+                summary,
+            )?;
+        }
+        ImapAcctCommand::Appendlimit { username, value } => {
+            ctx.require_db()?;
+            let pool = ctx.open_pool().await?;
+            let username = normalize_username(username)?;
+            if !passwords::user_exists(&pool, &username).await? {
+                return Err(ChatmailError::config(format!(
+                    "no such account: {username}"
+                )));
+            }
+            if let Some(value) = value {
+                set_append_limit(&pool, &username, parse_limit(value)?).await?;
+            }
+            let own = get_append_limit(&pool, &username).await?;
+            let append = get_setting(&pool, settings_keys::APPENDLIMIT).await?;
+            let max = get_setting(&pool, settings_keys::MAX_MESSAGE_SIZE).await?;
+            let global = resolve_max_message_bytes(
+                effective_max_message_bytes(&ctx.config),
+                append.as_deref(),
+                max.as_deref(),
+            )?;
+            let effective = own.map_or(global, |own| own.min(global));
+            if out.is_json() {
+                return out.emit(serde_json::json!({
+                    "username": username,
+                    "appendlimit_bytes": own,
+                    "global_bytes": global,
+                    "effective_bytes": effective,
+                }));
+            }
+            let own = own.map_or_else(
+                || "none (global limit applies)".to_string(),
+                |b| format!("{} ({b} bytes)", format_data_size(b)),
+            );
+            out.line(&username);
+            out.line(format!("  Account limit:   {own}"));
+            out.line(format!(
+                "  Global limit:    {} ({global} bytes)",
+                format_data_size(global)
+            ));
+            out.line(format!(
+                "  Effective:       {} ({effective} bytes)",
+                format_data_size(effective)
+            ));
+            if value.is_some() {
+                out.blank();
+                out.line("  Apply to a running server: madmail reload");
+            }
+        }
+        ImapAcctCommand::Quota(ImapAcctQuotaCommand::Recalc {
+            username,
+            all: _,
+            url,
+            insecure,
+        }) => {
+            ctx.require_db()?;
+            let username = match username {
+                Some(u) => normalize_username(u)?,
+                None => String::new(),
+            };
+            let body = admin_request(
+                &ctx,
+                url.as_deref(),
+                *insecure,
+                "POST",
+                "/admin/quota",
+                Some(serde_json::json!({ "action": "recalc", "username": username })),
+            )
+            .await?;
+            if out.is_json() {
+                return out.emit(body);
+            }
+            for line in format_recalc(&body) {
+                out.line(line);
+            }
+        }
+    }
+    Ok(())
+}
+
+fn format_recalc(body: &Value) -> Vec<String> {
+    let accounts = body["accounts"].as_u64().unwrap_or(0);
+    let drifted = body["drifted"].as_array().map(Vec::as_slice).unwrap_or(&[]);
+    let mut lines = vec![format!(
+        "Recalculated {accounts} account(s); {} drifted.",
+        drifted.len()
+    )];
+    if drifted.is_empty() {
+        return lines;
+    }
+    lines.push("USERNAME\tBEFORE\tAFTER\tDELETED BEFORE\tDELETED AFTER".to_string());
+    for d in drifted {
+        let num = |key: &str| d[key].as_u64().unwrap_or(0);
+        lines.push(format!(
+            "{}\t{}\t{}\t{}\t{}",
+            d["username"].as_str().unwrap_or(""),
+            num("before_bytes"),
+            num("after_bytes"),
+            num("before_deleted"),
+            num("after_deleted")
+        ));
+    }
+    lines
+}
+
+/// `10M` / `512K` style sizes, or a plain byte count.
+fn parse_limit(value: &str) -> Result<u64> {
+    let value = value.trim();
+    match value.parse::<u64>() {
+        Ok(bytes) => Ok(bytes),
+        Err(_) => parse_data_size(value),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn format_recalc_lists_drifted_accounts() {
+        let body = serde_json::json!({ "accounts": 3, "drifted": [] });
+        assert_eq!(
+            format_recalc(&body),
+            ["Recalculated 3 account(s); 0 drifted."]
+        );
+        let body = serde_json::json!({
+            "accounts": 3,
+            "drifted": [{
+                "username": "a@x.org",
+                "before_bytes": 1469,
+                "after_bytes": 470,
+                "before_deleted": 350,
+                "after_deleted": 50,
+            }],
+        });
+        let lines = format_recalc(&body);
+        assert_eq!(lines[0], "Recalculated 3 account(s); 1 drifted.");
+        assert_eq!(lines[2], "a@x.org\t1469\t470\t350\t50");
+    }
+}
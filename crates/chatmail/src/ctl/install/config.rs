@@ -19,6 +19,11 @@
 
 use std::path::PathBuf;
 
+use chatmail_delivery::dkim::{DkimAlgorithm, DEFAULT_DKIM_SELECTOR};
+
+/// `--dkim-dual` selectors: ed25519 first, RSA for receivers that cannot verify it.
+pub const DKIM_DUAL_SELECTORS: [&str; 2] = ["ed1", "rsa1"];
+
 /// Install plan used by Unix systemd/user steps and Windows post-install.
 /// Some fields are only read on Unix (`system` / `systemd` modules).
 #[allow(dead_code)]
@@ -30,6 +35,8 @@ pub struct InstallConfig {
     pub hostname: String,
     pub primary_domain: String,
     pub local_domains: String,
+    /// `--extra-domains`: registration domains next to the primary one.
+    pub extra_domains: Vec<String>,
     pub state_dir: PathBuf,
     pub runtime_dir: String,
     pub public_ip: String,
@@ -37,6 +44,8 @@ pub struct InstallConfig {
     pub cert_path: PathBuf,
     pub key_path: PathBuf,
     pub acme_email: String,
+    /// `--acme-directory`; empty means Let's Encrypt.
+    pub acme_directory: String,
     pub generate_certs: bool,
     pub turn_off_tls: bool,
     pub enable_chatmail: bool,
@@ -61,10 +70,92 @@ pub struct InstallConfig {
     pub skip_user: bool,
     pub skip_systemd: bool,
     pub generated: String,
+    /// Listener addresses of the generated endpoints.
+    pub listen: ListenPorts,
+    /// `log stderr` + `debug yes` instead of No-Log (`madmail dev`).
+    pub debug_log: bool,
+    /// `--dkim-algo`: key type of the `default` selector (of `rsa1` with `--dkim-dual`).
+    pub dkim_algorithm: DkimAlgorithm,
+    /// `--dkim-dual`: sign with [`DKIM_DUAL_SELECTORS`] instead of `default`.
+    pub dkim_dual: bool,
+}
+
+impl InstallConfig {
+    /// Selectors of the submission `dkim` lines and the key type generated for each.
+    pub fn dkim_selectors(&self) -> Vec<(&'static str, DkimAlgorithm)> {
+        if !self.dkim_dual {
+            return vec![(DEFAULT_DKIM_SELECTOR, self.dkim_algorithm)];
+        }
+        let rsa = match self.dkim_algorithm {
+            DkimAlgorithm::Ed25519 => DkimAlgorithm::Rsa2048,
+            rsa => rsa,
+        };
+        let [ed, rsa_selector] = DKIM_DUAL_SELECTORS;
+        vec![(ed, DkimAlgorithm::Ed25519), (rsa_selector, rsa)]
+    }
+}
+
+/// Bind host and ports of the SMTP, submission, IMAP and chatmail HTTP endpoints.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ListenPorts {
+    pub host: String,
+    pub smtp: u16,
+    pub submission: u16,
+    pub submission_tls: u16,
+    pub imap: u16,
+    pub imap_tls: u16,
+    pub http: u16,
+    pub https: u16,
+}
+
+impl ListenPorts {
+    /// Standard mail and web ports on every interface (`madmail install`).
+    pub fn standard() -> Self {
+        Self {
+            host: "0.0.0.0".into(),
+            smtp: 25,
+            submission: 587,
+            submission_tls: 465,
+            imap: 143,
+            imap_tls: 993,
+            http: 80,
+            https: 443,
+        }
+    }
+
+    /// Standard ports shifted by `base` on `host` (`madmail dev --port-base`);
+    /// `None` when a port would pass 65535.
+    pub fn offset(host: &str, base: u16) -> Option<Self> {
+        let std = Self::standard();
+        Some(Self {
+            host: host.to_string(),
+            smtp: base.checked_add(std.smtp)?,
+            submission: base.checked_add(std.submission)?,
+            submission_tls: base.checked_add(std.submission_tls)?,
+            imap: base.checked_add(std.imap)?,
+            imap_tls: base.checked_add(std.imap_tls)?,
+            http: base.checked_add(std.http)?,
+            https: base.checked_add(std.https)?,
+        })
+    }
 }
 
 pub fn render_maddy_conf(c: &InstallConfig) -> String {
-    let log_block = "# Disable logging as requested\nlog off\n";
+    // Without systemd there is no journald to catch stderr; write a size-rotated file instead.
+    let log_block = if c.debug_log {
+        "# Development: log everything to stderr\nlog stderr\ndebug yes\n".to_string()
+    } else if c.skip_systemd {
+        format!(
+            "# No systemd/journald: size-rotated log file (SIGHUP reopens for external logrotate)\n\
+             # For No-Log, replace these three lines with `log off`.\n\
+             log file:{}/logs/madmail.log\n\
+             log_rotate_size 50M\n\
+             log_rotate_keep 5\n",
+            c.state_dir.display()
+        )
+    } else {
+        "# Disable logging as requested\nlog off\n".to_string()
+    };
     let require_tls_smtp = if c.turn_off_tls {
         ""
     } else {
@@ -156,13 +247,20 @@ turn udp://0.0.0.0:{port} tcp://0.0.0.0:{port} {{
         "no"
     };
     let lang = c.language.as_str();
+    let dkim = c
+        .dkim_selectors()
+        .iter()
+        .map(|(selector, _)| format!("dkim $(primary_domain) $(local_domains) {selector}"))
+        .collect::<Vec<_>>()
+        .join("\n                ");
 
+    let extra_domains: String = c.extra_domains.iter().map(|d| format!(" {d}")).collect();
     let chatmail_http = if c.enable_chatmail {
         format!(
             r#"
-chatmail tcp://0.0.0.0:80 {{
+chatmail tcp://{host}:{http} {{
     debug false
-    mail_domain $(primary_domain)
+    mail_domain $(primary_domain){extra_domains}
     mx_domain $(primary_domain)
     web_domain $(primary_domain)
     auth_db local_authdb
@@ -174,9 +272,9 @@ chatmail tcp://0.0.0.0:80 {{
     language {lang}
 {ss_block}}}
 
-chatmail tls://0.0.0.0:443 {{
+chatmail tls://{host}:{https} {{
     debug false
-    mail_domain $(primary_domain)
+    mail_domain $(primary_domain){extra_domains}
     mx_domain $(primary_domain)
     web_domain $(primary_domain)
     auth_db local_authdb
@@ -191,19 +289,29 @@ chatmail tls://0.0.0.0:443 {{
     language {lang}
 {ss_block}}}
 "#,
+            host = c.listen.host,
+            http = c.listen.http,
+            https = c.listen.https,
             turn_off = turn_off,
             contact = contact,
             lang = lang,
             cert = c.cert_path.display(),
             key = c.key_path.display(),
             ss_block = ss_block,
+            extra_domains = extra_domains,
         )
     } else {
         String::new()
     };
 
     let tls_mode_directives = match c.tls_mode.as_str() {
-        "autocert" => format!("tls_mode autocert\nacme_email {}\n", c.acme_email),
+        "autocert" if c.acme_directory.is_empty() => {
+            format!("tls_mode autocert\nacme_email {}\n", c.acme_email)
+        }
+        "autocert" => format!(
+            "tls_mode autocert\nacme_email {}\nacme_directory {}\n",
+            c.acme_email, c.acme_directory
+        ),
         "file" => "tls_mode file\n".to_string(),
         "self_signed" => "tls_mode self_signed\n".to_string(),
         _ => String::new(),
@@ -266,7 +374,7 @@ msgpipeline local_routing {{
     }}
 }}
 
-smtp tcp://0.0.0.0:25 {{
+smtp tcp://{host}:{smtp} {{
     limits {{
         all rate 20 1s
         all concurrency 200
@@ -291,7 +399,7 @@ smtp tcp://0.0.0.0:25 {{
     }}
 }}
 
-submission tls://0.0.0.0:465 tcp://0.0.0.0:587 {{
+submission tls://{host}:{submission_tls} tcp://{host}:{submission} {{
     limits {{
         all rate 50 1s
     }}
@@ -315,7 +423,7 @@ submission tls://0.0.0.0:465 tcp://0.0.0.0:587 {{
         }}
         default_destination {{
             modify {{
-                dkim $(primary_domain) $(local_domains) default
+                {dkim}
             }}
             deliver_to &remote_queue
         }}
@@ -346,7 +454,7 @@ target.queue remote_queue {{
     }}
 }}
 
-imap tls://0.0.0.0:993 tcp://0.0.0.0:143 {{
+imap tls://{host}:{imap_tls} tcp://{host}:{imap} {{
     auth &local_authdb
     storage &local_mailboxes
     insecure_auth {insecure}
@@ -381,6 +489,13 @@ imap tls://0.0.0.0:993 tcp://0.0.0.0:143 {{
         imap_iroh = imap_iroh,
         turn_block = turn_block,
         chatmail_http = chatmail_http,
+        host = c.listen.host,
+        smtp = c.listen.smtp,
+        submission = c.listen.submission,
+        submission_tls = c.listen.submission_tls,
+        imap = c.listen.imap,
+        imap_tls = c.listen.imap_tls,
+        dkim = dkim,
     )
 }
 
@@ -32,9 +32,14 @@ use chatmail_config::install_cli::InstallArgs;
 use chatmail_config::is_local_dev_state_dir;
 use chatmail_config::{effective_database_config, AppConfig, Args};
 use chatmail_db::{init_db_from_config, set_setting, settings_keys};
+use chatmail_delivery::dkim::{generate_key, list_keys, signing_domains, DkimAlgorithm, DkimKey};
 use chatmail_types::{wrap_ip_domain, ChatmailError, Result};
 
-use self::config::{local_domains_for_ip, render_maddy_conf, InstallConfig};
+pub(super) use self::config::{
+    local_domains_for_ip, render_maddy_conf, InstallConfig, ListenPorts,
+};
+#[cfg(unix)]
+pub use self::systemd::{refresh_installed_unit, unit_path};
 use super::docs;
 use super::language::validate_language_code;
 use super::output::CtlOut;
@@ -59,6 +64,20 @@ pub async fn install(global: &Args, args: &InstallArgs) -> Result<()> {
     println!("  State dir:      {}", cfg.state_dir.display());
     println!("  Config:         {}", cfg.config_path.display());
     println!("  Language:       {}", cfg.language);
+    println!(
+        "  DKIM:           {}",
+        cfg.dkim_selectors()
+            .iter()
+            .map(|(selector, algo)| format!("{selector} ({})", algo.as_str()))
+            .collect::<Vec<_>>()
+            .join(", ")
+    );
+    if cfg.dkim_algorithm == DkimAlgorithm::Ed25519 && !cfg.dkim_dual {
+        eprintln!(
+            "warning: some providers still do not verify ed25519 DKIM signatures; \
+             --dkim-dual adds an RSA key next to it"
+        );
+    }
 
     #[cfg(unix)]
     system::require_root_for_system_install(&cfg, args.dry_run)?;
@@ -110,6 +129,7 @@ pub async fn install(global: &Args, args: &InstallArgs) -> Result<()> {
     }
     ensure_secrets(&mut cfg)?;
     write_config(&cfg)?;
+    let dkim_keys = generate_dkim_keys(&cfg)?;
     seed_install_language(&cfg).await?;
     #[cfg(unix)]
     {
@@ -131,9 +151,11 @@ pub async fn install(global: &Args, args: &InstallArgs) -> Result<()> {
         CtlOut::from_args(global, "install").emit(serde_json::json!({
             "installed": true,
             "primary_domain": cfg.primary_domain,
+            "extra_domains": cfg.extra_domains,
             "paths_explicit": cfg.paths_explicit,
             "config": cfg.config_path.display().to_string(),
             "state_dir": cfg.state_dir.display().to_string(),
+            "dkim": dkim_keys,
             "service_installed": post.service_installed,
             "service_started": post.service_started,
             "firewall_applied": post.firewall_applied,
@@ -152,7 +174,7 @@ pub async fn install(global: &Args, args: &InstallArgs) -> Result<()> {
             },
         }))?;
     } else {
-        print_next_steps(&cfg);
+        print_next_steps(&cfg, &dkim_keys);
         println!("\nInstallation completed successfully.");
     }
     Ok(())
@@ -376,8 +398,13 @@ async fn setup_certificates(cfg: &InstallConfig, args: &InstallArgs) -> Result<(
         } else {
             "DNS"
         };
+        let issuer = if cfg.acme_directory.is_empty() {
+            "Let's Encrypt"
+        } else {
+            cfg.acme_directory.as_str()
+        };
         let http_listen = parse_http_listen(&args.http_listen)?;
-        println!("Obtaining Let's Encrypt {label} certificate (HTTP-01 on {http_listen})…");
+        println!("Obtaining {issuer} {label} certificate (HTTP-01 on {http_listen})…");
         let opts = ObtainOptions {
             domain: cfg.primary_domain.clone(),
             email: cfg.acme_email.clone(),
@@ -386,6 +413,7 @@ async fn setup_certificates(cfg: &InstallConfig, args: &InstallArgs) -> Result<(
             key_path: Some(cfg.key_path.clone()),
             http_listen,
             staging: false,
+            directory_url: Some(cfg.acme_directory.clone()).filter(|u| !u.is_empty()),
             skip_if_valid: false,
         };
         obtain_certificate(&opts).await?;
@@ -455,6 +483,44 @@ fn write_config(cfg: &InstallConfig) -> Result<()> {
     Ok(())
 }
 
+/// Key pairs for the `dkim` lines of the config, for the primary and `--extra-domains`. A key
+/// left by an earlier install is kept, so its published record stays valid.
+fn generate_dkim_keys(cfg: &InstallConfig) -> Result<Vec<DkimKey>> {
+    let domains = signing_domains(&cfg.primary_domain, Some(&cfg.extra_domains.join(" ")));
+    if domains.is_empty() {
+        println!("  DKIM: skipped, {} is not a DNS name", cfg.primary_domain);
+        return Ok(Vec::new());
+    }
+    let existing = list_keys(&cfg.state_dir)?;
+    let mut keys = Vec::new();
+    for (selector, algorithm) in cfg.dkim_selectors() {
+        for domain in &domains {
+            let found = existing
+                .iter()
+                .find(|k| k.domain == *domain && k.selector == selector);
+            let key = match found {
+                Some(key) => {
+                    println!(
+                        "✓ Kept DKIM key {selector} for {domain} ({})",
+                        key.algorithm
+                    );
+                    key.clone()
+                }
+                None => {
+                    let key = generate_key(&cfg.state_dir, domain, selector, algorithm)?;
+                    println!(
+                        "✓ Generated DKIM key {selector} for {domain} ({})",
+                        key.algorithm
+                    );
+                    key
+                }
+            };
+            keys.push(key);
+        }
+    }
+    Ok(keys)
+}
+
 fn ensure_secrets(cfg: &mut InstallConfig) -> Result<()> {
     ensure_ss_password(cfg)?;
     if cfg.enable_turn && cfg.turn_secret.is_empty() {
@@ -466,7 +532,7 @@ fn ensure_secrets(cfg: &mut InstallConfig) -> Result<()> {
     Ok(())
 }
 
-fn print_next_steps(cfg: &InstallConfig) {
+fn print_next_steps(cfg: &InstallConfig, dkim_keys: &[DkimKey]) {
     println!("\nNext steps:");
     if cfg.tls_mode == "autocert" {
         println!(
@@ -508,7 +574,7 @@ fn print_next_steps(cfg: &InstallConfig) {
     }
     if is_valid_dns_domain(&cfg.primary_domain) {
         println!(
-            "  • DNS:    ensure A/AAAA + MX for {}; optional SPF/DKIM/DMARC — see docs/project/user-guide/12-dns-mail-auth.md",
+            "  • DNS:    ensure A/AAAA + MX for {}, the DKIM records below; optional SPF/DMARC — see docs/project/user-guide/12-dns-mail-auth.md",
             cfg.primary_domain
         );
         println!(
@@ -516,11 +582,40 @@ fn print_next_steps(cfg: &InstallConfig) {
             cfg.hostname
         );
     }
+    for domain in &cfg.extra_domains {
+        println!(
+            "  • DNS ({domain}): MX → {}; SPF/DMARC as for {}; DKIM as listed below",
+            cfg.hostname, cfg.primary_domain
+        );
+        println!("    check with: madmail dns-check --domain {domain}");
+    }
+    for key in dkim_keys {
+        println!("  • DKIM:   {} TXT \"{}\"", key.dns_name, key.dns_value);
+    }
     if cfg.tls_mode == "self_signed" || cfg.turn_off_tls {
         println!("  • Delta Chat: use turn_off_tls / accept self-signed certs for IP relays");
     }
 }
 
+/// `--extra-domains`: DNS names other than `primary`, lowercased and deduplicated.
+fn install_extra_domains(raw: &[String], primary: &str) -> Result<Vec<String>> {
+    let mut domains: Vec<String> = Vec::new();
+    for domain in raw.iter().map(|d| d.trim().to_ascii_lowercase()) {
+        if domain.is_empty() {
+            continue;
+        }
+        if !is_valid_dns_domain(&domain) {
+            return Err(ChatmailError::config(format!(
+                "invalid domain for --extra-domains: {domain:?}"
+            )));
+        }
+        if !domain.eq_ignore_ascii_case(primary) && !domains.contains(&domain) {
+            domains.push(domain);
+        }
+    }
+    Ok(domains)
+}
+
 impl InstallConfig {
     fn from_args(global: &Args, args: &InstallArgs) -> Result<Self> {
         let binary_name = std::env::args()
@@ -664,10 +759,23 @@ impl InstallConfig {
             )
         };
 
+        let extra_domains = install_extra_domains(&args.extra_domains, &primary_domain)?;
+        let local_domains = if extra_domains.is_empty() {
+            local_domains
+        } else {
+            format!("{local_domains} {}", extra_domains.join(" "))
+        };
+
         let enable_ss = args.enable_ss || args.simple;
         let enable_turn = true;
         let language = validate_language_code(&args.lang)?;
         let enable_chatmail = args.enable_chatmail || args.simple || args.domain.is_some();
+        let dkim_algorithm = DkimAlgorithm::parse(&args.dkim_algo).ok_or_else(|| {
+            ChatmailError::config(format!(
+                "invalid --dkim-algo {:?} (rsa2048, rsa4096 or ed25519)",
+                args.dkim_algo
+            ))
+        })?;
 
         Ok(Self {
             binary_name: binary_name.clone(),
@@ -677,6 +785,7 @@ impl InstallConfig {
             hostname,
             primary_domain,
             local_domains,
+            extra_domains,
             runtime_dir: {
                 #[cfg(windows)]
                 {
@@ -689,10 +798,15 @@ impl InstallConfig {
             },
             state_dir,
             public_ip,
-            tls_mode: args.tls_mode.clone().unwrap_or_default(),
+            tls_mode: if args.acme {
+                "autocert".into()
+            } else {
+                args.tls_mode.clone().unwrap_or_default()
+            },
             cert_path,
             key_path,
             acme_email: args.acme_email.clone().unwrap_or_default(),
+            acme_directory: args.acme_directory.clone().unwrap_or_default(),
             generate_certs: false,
             turn_off_tls,
             enable_chatmail,
@@ -715,6 +829,10 @@ impl InstallConfig {
             skip_user: args.skip_user,
             skip_systemd: args.skip_systemd,
             generated: chrono_like_now(),
+            listen: ListenPorts::standard(),
+            debug_log: false,
+            dkim_algorithm,
+            dkim_dual: args.dkim_dual,
         })
     }
 }
@@ -788,7 +906,7 @@ fn resolve_install_state_dir(
     }
 }
 
-fn chrono_like_now() -> String {
+pub(super) fn chrono_like_now() -> String {
     use std::time::{SystemTime, UNIX_EPOCH};
     let secs = SystemTime::now()
         .duration_since(UNIX_EPOCH)
@@ -844,7 +962,12 @@ mod tests {
             cert_only: false,
             http_listen: "0.0.0.0:80".into(),
             auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
             lang: "en".into(),
+            extra_domains: vec![],
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: false,
         };
         let cfg = InstallConfig::from_args(&global, &args).unwrap();
         assert_eq!(cfg.primary_domain, format!("[{EXAMPLE_PUBLIC_IP}]"));
@@ -946,7 +1069,12 @@ mod tests {
             cert_only: false,
             http_listen: "0.0.0.0:80".into(),
             auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
             lang: "en".into(),
+            extra_domains: vec![],
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: false,
         };
         let cfg = InstallConfig::from_args(&global, &args).unwrap();
         assert_eq!(cfg.state_dir, PathBuf::from("/tmp/sd"));
@@ -997,7 +1125,12 @@ mod tests {
             cert_only: false,
             http_listen: "0.0.0.0:80".into(),
             auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
             lang: "en".into(),
+            extra_domains: vec![],
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: false,
         };
         let cfg = InstallConfig::from_args(&global, &args).unwrap();
         assert!(cfg.paths_explicit);
@@ -1053,7 +1186,12 @@ mod tests {
             cert_only: false,
             http_listen: "0.0.0.0:80".into(),
             auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
             lang: "en".into(),
+            extra_domains: vec![],
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: false,
         };
         let cfg = InstallConfig::from_args(&global, &args).unwrap();
         assert!(cfg.use_default_systemd_paths);
@@ -1100,7 +1238,12 @@ mod tests {
                 cert_only: false,
                 http_listen: "0.0.0.0:80".into(),
                 auto_ip_cert: false,
+                acme: false,
+                acme_directory: None,
                 lang: "en".into(),
+                extra_domains: vec![],
+                dkim_algo: "rsa2048".into(),
+                dkim_dual: false,
             }
         };
         let cfg = InstallConfig::from_args(&global, &args).unwrap();
@@ -1145,7 +1288,12 @@ mod tests {
             cert_only: false,
             http_listen: "0.0.0.0:80".into(),
             auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
             lang: "en".into(),
+            extra_domains: vec![],
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: false,
         };
         let cfg = InstallConfig::from_args(&global, &args).unwrap();
         assert_eq!(cfg.primary_domain, "mail.example.org");
@@ -1157,6 +1305,48 @@ mod tests {
         let mut cfg = cfg;
         resolve_tls_mode(&mut cfg, &args).unwrap();
         assert_eq!(cfg.tls_mode, "autocert");
+        assert!(!render_maddy_conf(&cfg).contains("acme_directory"));
+
+        let acme_args = InstallArgs {
+            acme: true,
+            acme_directory: Some("https://ca.internal/acme/directory".into()),
+            acme_email: None,
+            ..args
+        };
+        let mut cfg = InstallConfig::from_args(&global, &acme_args).unwrap();
+        resolve_tls_mode(&mut cfg, &acme_args).unwrap();
+        assert_eq!(cfg.tls_mode, "autocert");
+        assert_eq!(cfg.acme_email, "admin@mail.example.org");
+        let conf = render_maddy_conf(&cfg);
+        assert!(conf.contains("acme_directory https://ca.internal/acme/directory\n"));
+
+        let multi_args = InstallArgs {
+            extra_domains: vec![
+                "Example.NET".into(),
+                "example.com".into(),
+                "mail.example.org".into(),
+            ],
+            ..acme_args
+        };
+        let cfg = InstallConfig::from_args(&global, &multi_args).unwrap();
+        assert_eq!(cfg.extra_domains, ["example.net", "example.com"]);
+        assert_eq!(
+            cfg.local_domains,
+            "$(primary_domain) example.net example.com"
+        );
+        let conf = render_maddy_conf(&cfg);
+        assert_eq!(
+            conf.matches("mail_domain $(primary_domain) example.net example.com\n")
+                .count(),
+            2
+        );
+        let parsed = chatmail_config::parse_maddy_config(&conf).unwrap();
+        assert_eq!(parsed.extra_mail_domains, ["example.net", "example.com"]);
+        let bad = InstallArgs {
+            extra_domains: vec!["not a domain".into()],
+            ..multi_args
+        };
+        assert!(InstallConfig::from_args(&global, &bad).is_err());
     }
 
     #[test]
@@ -1198,7 +1388,12 @@ mod tests {
                 cert_only: false,
                 http_listen: "0.0.0.0:80".into(),
                 auto_ip_cert: false,
+                acme: false,
+                acme_directory: None,
                 lang: "en".into(),
+                extra_domains: vec![],
+                dkim_algo: "rsa2048".into(),
+                dkim_dual: false,
             }
         };
         assert!(InstallConfig::from_args(&global, &args).is_err());
@@ -1237,7 +1432,12 @@ mod tests {
                 cert_only: false,
                 http_listen: "0.0.0.0:80".into(),
                 auto_ip_cert: false,
+                acme: false,
+                acme_directory: None,
                 lang: "en".into(),
+                extra_domains: vec![],
+                dkim_algo: "rsa2048".into(),
+                dkim_dual: false,
             }
         };
         assert!(effective_obtain_certificate(&args));
@@ -1284,7 +1484,12 @@ mod tests {
                 cert_only: false,
                 http_listen: "0.0.0.0:80".into(),
                 auto_ip_cert: false,
+                acme: false,
+                acme_directory: None,
                 lang: "en".into(),
+                extra_domains: vec![],
+                dkim_algo: "rsa2048".into(),
+                dkim_dual: false,
             }
         };
         assert!(InstallConfig::from_args(&global, &args).is_err());
@@ -1326,11 +1531,174 @@ mod tests {
             cert_only: false,
             http_listen: "0.0.0.0:80".into(),
             auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
             lang: "en".into(),
+            extra_domains: vec![],
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: false,
         };
         let cfg = InstallConfig::from_args(&global, &args).unwrap();
         assert!(cfg.enable_iroh);
         let conf = render_maddy_conf(&cfg);
         assert!(conf.contains("iroh_relay_url http://$(public_ip):3340"));
     }
+
+    #[test]
+    fn skip_systemd_logs_to_rotating_file() {
+        let global = Args {
+            config: PathBuf::from("/etc/madmail/madmail.conf"),
+            state_dir: PathBuf::from("./data"),
+            boot_once: false,
+            json: false,
+        };
+        let mut args = InstallArgs {
+            non_interactive: true,
+            simple: true,
+            domain: None,
+            hostname: None,
+            ip: Some(EXAMPLE_PUBLIC_IP.into()),
+            config_dir: None,
+            state_dir: Some(PathBuf::from("/srv/madmail")),
+            tls_mode: None,
+            cert_path: None,
+            key_path: None,
+            acme_email: None,
+            enable_chatmail: false,
+            enable_ss: false,
+            enable_iroh: false,
+            turn_off_tls: false,
+            dry_run: false,
+            skip_systemd: false,
+            skip_user: false,
+            install_service: false,
+            start_service: false,
+            firewall: false,
+            binary_path: None,
+            obtain_certificate: true,
+            no_obtain_certificate: false,
+            cert_only: false,
+            http_listen: "0.0.0.0:80".into(),
+            auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
+            lang: "en".into(),
+            extra_domains: vec![],
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: false,
+        };
+        let conf = render_maddy_conf(&InstallConfig::from_args(&global, &args).unwrap());
+        assert!(!conf.contains("log file:"));
+
+        args.skip_systemd = true;
+        let cfg = InstallConfig::from_args(&global, &args).unwrap();
+        let conf = render_maddy_conf(&cfg);
+        assert!(!conf.contains("\nlog off\n"), "{conf}");
+        let parsed = chatmail_config::parse_maddy_config(&conf).unwrap();
+        assert_eq!(
+            parsed.log_target,
+            Some(format!("file:{}/logs/madmail.log", cfg.state_dir.display()))
+        );
+        assert_eq!(parsed.log_rotate_size.as_deref(), Some("50M"));
+        assert_eq!(parsed.log_rotate_keep, Some(5));
+    }
+
+    #[test]
+    fn dkim_algo_and_dual_pick_selectors_and_keys() {
+        let global = Args {
+            config: PathBuf::from("/etc/madmail/madmail.conf"),
+            state_dir: PathBuf::from("./data"),
+            boot_once: false,
+            json: false,
+        };
+        let dir = tempfile::tempdir().unwrap();
+        let args = InstallArgs {
+            non_interactive: true,
+            simple: true,
+            domain: Some("mail.example.org".into()),
+            hostname: None,
+            ip: Some(EXAMPLE_PUBLIC_IP.into()),
+            config_dir: Some(dir.path().join("etc")),
+            state_dir: Some(dir.path().join("state")),
+            tls_mode: None,
+            cert_path: None,
+            key_path: None,
+            acme_email: None,
+            enable_chatmail: false,
+            enable_ss: false,
+            enable_iroh: false,
+            turn_off_tls: false,
+            dry_run: false,
+            skip_systemd: false,
+            skip_user: false,
+            install_service: false,
+            start_service: false,
+            firewall: false,
+            binary_path: None,
+            obtain_certificate: true,
+            no_obtain_certificate: false,
+            cert_only: false,
+            http_listen: "0.0.0.0:80".into(),
+            auto_ip_cert: false,
+            acme: false,
+            acme_directory: None,
+            lang: "en".into(),
+            extra_domains: vec!["example.net".into()],
+            dkim_algo: "ed25519".into(),
+            dkim_dual: false,
+        };
+        let cfg = InstallConfig::from_args(&global, &args).unwrap();
+        assert_eq!(cfg.dkim_selectors(), [("default", DkimAlgorithm::Ed25519)]);
+        let conf = render_maddy_conf(&cfg);
+        assert!(conf.contains("dkim $(primary_domain) $(local_domains) default\n"));
+        let keys = generate_dkim_keys(&cfg).unwrap();
+        let names: Vec<_> = keys.iter().map(|k| k.dns_name.as_str()).collect();
+        assert_eq!(
+            names,
+            [
+                "default._domainkey.mail.example.org",
+                "default._domainkey.example.net"
+            ]
+        );
+        assert!(keys
+            .iter()
+            .all(|k| k.dns_value.starts_with("v=DKIM1; k=ed25519; p=")));
+
+        let dual = InstallArgs {
+            dkim_algo: "rsa2048".into(),
+            dkim_dual: true,
+            ..args.clone()
+        };
+        let cfg = InstallConfig::from_args(&global, &dual).unwrap();
+        let conf = render_maddy_conf(&cfg);
+        let parsed = chatmail_config::parse_maddy_config(&conf).unwrap();
+        assert_eq!(parsed.dkim_selectors, ["ed1", "rsa1"]);
+        let keys = generate_dkim_keys(&cfg).unwrap();
+        assert_eq!(keys.len(), 4);
+        for key in &keys {
+            let k = if key.selector == "ed1" {
+                "ed25519"
+            } else {
+                "rsa"
+            };
+            assert!(key.dns_value.starts_with(&format!("v=DKIM1; k={k}; p=")));
+        }
+        // A second install keeps the keys whose records are already published.
+        assert_eq!(generate_dkim_keys(&cfg).unwrap(), keys);
+        assert_eq!(list_keys(&cfg.state_dir).unwrap().len(), 6);
+
+        let bad = InstallArgs {
+            dkim_algo: "dsa".into(),
+            ..args.clone()
+        };
+        assert!(InstallConfig::from_args(&global, &bad).is_err());
+
+        let ip = InstallArgs {
+            domain: None,
+            extra_domains: vec![],
+            ..args
+        };
+        let cfg = InstallConfig::from_args(&global, &ip).unwrap();
+        assert!(generate_dkim_keys(&cfg).unwrap().is_empty());
+    }
 }
@@ -17,7 +17,7 @@
 
 //! systemd unit (Madmail `install.go` `systemdServiceTemplate`).
 
-use std::path::Path;
+use std::path::{Path, PathBuf};
 use std::process::Command;
 
 use chatmail_types::{ChatmailError, Result};
@@ -25,7 +25,7 @@ use chatmail_types::{ChatmailError, Result};
 use super::config::InstallConfig;
 
 pub fn install_unit(cfg: &InstallConfig) -> Result<()> {
-    let unit_path = Path::new("/etc/systemd/system").join(format!("{}.service", cfg.binary_name));
+    let unit_path = unit_path(&cfg.binary_name);
     let body = render_systemd_unit(cfg);
 
     std::fs::write(&unit_path, body)
@@ -34,11 +34,90 @@ pub fn install_unit(cfg: &InstallConfig) -> Result<()> {
     Ok(())
 }
 
+pub fn unit_path(binary_name: &str) -> PathBuf {
+    Path::new("/etc/systemd/system").join(format!("{binary_name}.service"))
+}
+
+/// The install-specific values a unit is rendered from.
+struct UnitSpec {
+    binary_name: String,
+    binary_path: PathBuf,
+    user: String,
+    group: String,
+    state_dir: PathBuf,
+    config_dir: PathBuf,
+    config_path: PathBuf,
+    managed_dirs: bool,
+}
+
+impl UnitSpec {
+    fn from_install(cfg: &InstallConfig) -> Self {
+        Self {
+            binary_name: cfg.binary_name.clone(),
+            binary_path: cfg.binary_path.clone(),
+            user: cfg.maddy_user.clone(),
+            group: cfg.maddy_group.clone(),
+            state_dir: cfg.state_dir.clone(),
+            config_dir: cfg.config_dir.clone(),
+            config_path: cfg.config_path.clone(),
+            managed_dirs: cfg.use_default_systemd_paths,
+        }
+    }
+
+    /// Recover the spec from a unit written by [`install_unit`]; `None` for units this
+    /// installer did not render (those are left alone).
+    fn parse(binary_name: &str, unit: &str) -> Option<Self> {
+        let value = |key: &str| {
+            unit.lines()
+                .find_map(|l| l.trim().strip_prefix(key)?.strip_prefix('='))
+                .map(str::trim)
+        };
+        let exec: Vec<&str> = value("ExecStart")?.split_whitespace().collect();
+        let config_at = exec.iter().position(|t| *t == "--config")? + 1;
+        let config_dir = value("ReadWritePaths")?.split_whitespace().nth(1)?;
+        Some(Self {
+            binary_name: binary_name.to_string(),
+            binary_path: PathBuf::from(exec.first()?),
+            user: value("User")?.to_string(),
+            group: value("Group")?.to_string(),
+            state_dir: PathBuf::from(value("WorkingDirectory")?),
+            config_dir: PathBuf::from(config_dir),
+            config_path: PathBuf::from(exec.get(config_at)?),
+            managed_dirs: value("StateDirectory").is_some(),
+        })
+    }
+}
+
 /// Build the `.service` unit body. Default FHS paths use `StateDirectory` /
 /// `ConfigurationDirectory`; explicit `--config-dir` / `--state-dir` are reflected
 /// in `ExecStart`, `WorkingDirectory`, and `ReadWritePaths` only.
 pub fn render_systemd_unit(cfg: &InstallConfig) -> String {
-    let managed_dirs = if cfg.use_default_systemd_paths {
+    render_unit(&UnitSpec::from_install(cfg))
+}
+
+/// Re-render an installed unit with this binary's template, pointing `ExecStart` at
+/// `binary_path`. Returns the previous body when the file was rewritten, so a failed
+/// upgrade can put it back; `None` when there is no unit or it is already current.
+pub fn refresh_installed_unit(binary_name: &str, binary_path: &Path) -> Result<Option<String>> {
+    let path = unit_path(binary_name);
+    let Ok(previous) = std::fs::read_to_string(&path) else {
+        return Ok(None);
+    };
+    let Some(mut spec) = UnitSpec::parse(binary_name, &previous) else {
+        return Ok(None);
+    };
+    spec.binary_path = binary_path.to_path_buf();
+    let body = render_unit(&spec);
+    if body == previous {
+        return Ok(None);
+    }
+    std::fs::write(&path, body)
+        .map_err(|e| ChatmailError::config(format!("write {}: {e}", path.display())))?;
+    Ok(Some(previous))
+}
+
+fn render_unit(cfg: &UnitSpec) -> String {
+    let managed_dirs = if cfg.managed_dirs {
         format!(
             r#"
 StateDirectory={name}
@@ -91,8 +170,8 @@ KillSignal=SIGTERM
 WantedBy=multi-user.target
 "#,
         name = cfg.binary_name,
-        user = cfg.maddy_user,
-        group = cfg.maddy_group,
+        user = cfg.user,
+        group = cfg.group,
         state_dir = cfg.state_dir.display(),
         managed_dirs = managed_dirs,
         config_dir = cfg.config_dir.display(),
@@ -131,6 +210,7 @@ mod tests {
             hostname: "mail.example.org".into(),
             primary_domain: "mail.example.org".into(),
             local_domains: "$(primary_domain)".into(),
+            extra_domains: vec![],
             state_dir: PathBuf::from("/var/lib/madmail"),
             runtime_dir: "/run/madmail".into(),
             public_ip: "203.0.113.1".into(),
@@ -138,6 +218,7 @@ mod tests {
             cert_path: PathBuf::from("/etc/madmail/certs/fullchain.pem"),
             key_path: PathBuf::from("/etc/madmail/certs/privkey.pem"),
             acme_email: String::new(),
+            acme_directory: String::new(),
             generate_certs: true,
             turn_off_tls: true,
             enable_chatmail: true,
@@ -160,6 +241,10 @@ mod tests {
             skip_user: false,
             skip_systemd: false,
             generated: String::new(),
+            listen: super::super::config::ListenPorts::standard(),
+            debug_log: false,
+            dkim_algorithm: Default::default(),
+            dkim_dual: false,
         }
     }
 
@@ -193,4 +278,17 @@ mod tests {
             "ExecStart=/usr/local/bin/madmail --config /tmp/mm/madmail.conf run --libexec /tmp/sd"
         ));
     }
+
+    #[test]
+    fn rendered_unit_parses_back_to_the_same_body() {
+        let mut cfg = sample_cfg();
+        for managed in [true, false] {
+            cfg.use_default_systemd_paths = managed;
+            cfg.config_path = PathBuf::from("/srv/mm/relay.conf");
+            let unit = render_systemd_unit(&cfg);
+            let spec = UnitSpec::parse("madmail", &unit).expect("own unit parses");
+            assert_eq!(render_unit(&spec), unit);
+        }
+        assert!(UnitSpec::parse("madmail", "[Service]\nExecStart=/bin/true\n").is_none());
+    }
 }
@@ -19,24 +19,40 @@
 
 mod account_ops;
 mod accounts;
+mod admin_audit;
 mod admin_login_qr;
 mod admin_token;
 mod admin_url;
 mod admin_web;
+mod autoresponse;
+mod backup;
+mod ban;
 mod blocklist_cmd;
+mod broadcast;
 mod certificate;
 mod context;
+mod creds;
+mod db_cmd;
 mod delete_cmd;
+mod delivery_stats;
+mod dev;
+mod diagnose;
 mod dispatch;
+mod dkim;
+mod dns_check;
 mod docs;
+mod domains;
 mod endpoint_cache;
 mod federation;
 mod firewall_cmd;
 mod html;
+mod imap_acct;
 mod install;
 mod language;
 mod message_size;
 mod output;
+mod peers;
+mod policy;
 mod port;
 mod proxy;
 mod push;
@@ -44,14 +60,22 @@ mod registration;
 mod registration_tokens;
 mod reload;
 mod request_reload;
+mod responder;
 mod service_cmd;
 mod service_toggle;
+mod settings_audit;
+mod settings_cmd;
 mod sharing;
+mod snapshot;
+mod ss_stats;
 mod status_cmd;
+mod storage;
 mod tasks;
+mod theme;
 mod uninstall;
 pub(crate) mod util;
 mod version;
+mod webhook;
 mod webmail_cors;
 
 #[cfg(test)]
@@ -64,6 +88,8 @@ mod test_harness;
 pub use admin_token::admin_token;
 pub use dispatch::dispatch;
 pub use docs::{argv_binary_name, install_cli_docs};
+#[cfg(unix)]
+pub use install::{refresh_installed_unit, unit_path};
 pub use output::{print_error_json, CtlOut};
 pub use service_cmd::{argv_has_service_flag, argv_without_service_flag};
 pub use version::print_version;
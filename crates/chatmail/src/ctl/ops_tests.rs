@@ -17,6 +17,7 @@
 
 //! In-process tests for operator CLI commands.
 
+use chatmail_admin::SettingsExport;
 use chatmail_config::cli::{
     EndpointCacheCommand, FederationCommand, LanguageCommand, PortCommand, PortServiceCommand,
     ProxyCommand, ProxySettingCommand, RegistrationCommand, RegistrationTokensCommand,
@@ -24,8 +25,9 @@ use chatmail_config::cli::{
 };
 use chatmail_config::{Cli, Command};
 use chatmail_db::{
-    federation_policy_label, get_bool_setting, get_endpoint_override, get_setting, init_sharing_db,
-    list_sharing_contacts, settings_keys,
+    delete_setting, federation_policy_label, get_bool_setting, get_endpoint_override, get_setting,
+    init_sharing_db, list_double_underscore_settings, list_sharing_contacts, set_setting,
+    settings_keys,
 };
 use clap::Parser;
 
@@ -270,7 +272,7 @@ async fn dispatch_sharing_create_and_remove() {
             "sharing",
             "create",
             "bob",
-            "https://i.delta.chat/#ABCDEF",
+            "https://i.delta.chat/#0123456789ABCDEF0123456789ABCDEF01234567&a=bob%40x.org&i=inv&s=auth",
             "Bob",
         ],
     );
@@ -360,12 +362,44 @@ async fn dispatch_proxy_configured_toggle_and_settings() {
         Some("new-pass")
     );
 
+    let cli = parse_cli_with_config(
+        dir.path(),
+        &config,
+        &[
+            "proxy",
+            "rotate",
+            "--password",
+            "newer-pass",
+            "--grace",
+            "1h",
+        ],
+    );
+    dispatch(&cli).await.unwrap();
+    assert_eq!(
+        get_setting(&pool, settings_keys::SS_PASSWORD)
+            .await
+            .unwrap()
+            .as_deref(),
+        Some("newer-pass")
+    );
+    let slots = get_setting(&pool, settings_keys::SS_PASSWORD_SLOTS)
+        .await
+        .unwrap()
+        .unwrap();
+    assert!(slots.contains("\"new-pass\""), "{slots}");
+    let cli = parse_cli_with_config(dir.path(), &config, &["proxy", "rotate", "--keep-old"]);
+    dispatch(&cli).await.unwrap();
+
     let cli = parse_cli_with_config(dir.path(), &config, &["proxy", "password", "reset"]);
     dispatch(&cli).await.unwrap();
     assert!(get_setting(&pool, settings_keys::SS_PASSWORD)
         .await
         .unwrap()
         .is_none());
+    assert!(get_setting(&pool, settings_keys::SS_PASSWORD_SLOTS)
+        .await
+        .unwrap()
+        .is_none());
 }
 
 #[tokio::test]
@@ -467,6 +501,270 @@ async fn dispatch_endpoint_cache_crud() {
         .is_none());
 }
 
+#[tokio::test]
+async fn dispatch_settings_export_wipe_import_round_trip() {
+    let (dir, _args, _db, pool) = setup_ctl_env().await;
+    set_setting(&pool, settings_keys::IMAP_PORT, "1143")
+        .await
+        .unwrap();
+    set_setting(&pool, settings_keys::LANGUAGE, "ru")
+        .await
+        .unwrap();
+    set_setting(&pool, settings_keys::TURN_SECRET, "turn-secret")
+        .await
+        .unwrap();
+
+    let first = dir.path().join("settings-1.json");
+    let cli = parse_cli(
+        dir.path(),
+        &["settings", "export", "-o", first.to_str().unwrap()],
+    );
+    dispatch(&cli).await.unwrap();
+
+    for (key, _) in list_double_underscore_settings(&pool).await.unwrap() {
+        delete_setting(&pool, &key).await.unwrap();
+    }
+    let cli = parse_cli(dir.path(), &["settings", "import", first.to_str().unwrap()]);
+    dispatch(&cli).await.unwrap();
+
+    let second = dir.path().join("settings-2.json");
+    let cli = parse_cli(
+        dir.path(),
+        &["settings", "export", "-o", second.to_str().unwrap()],
+    );
+    dispatch(&cli).await.unwrap();
+
+    let read = |path: &std::path::Path| {
+        SettingsExport::parse(&std::fs::read_to_string(path).unwrap()).unwrap()
+    };
+    let (mut first, second) = (read(&first), read(&second));
+    assert_eq!(first.settings[settings_keys::TURN_SECRET], "<redacted>");
+    first.settings.remove(settings_keys::TURN_SECRET);
+    assert_eq!(first, second);
+    assert!(get_setting(&pool, settings_keys::TURN_SECRET)
+        .await
+        .unwrap()
+        .is_none());
+}
+
+#[tokio::test]
+async fn dispatch_settings_import_rejects_invalid_without_writing() {
+    let (dir, _args, _db, pool) = setup_ctl_env().await;
+    let file = dir.path().join("bad.json");
+    std::fs::write(
+        &file,
+        r#"{"format":"madmail-settings","version":1,"settings":{
+            "__LANGUAGE__":"es","__SMTP_PORT__":"not-a-port"}}"#,
+    )
+    .unwrap();
+
+    let cli = parse_cli(dir.path(), &["settings", "import", file.to_str().unwrap()]);
+    let err = dispatch(&cli).await.unwrap_err().to_string();
+    assert!(err.contains("nothing was imported"), "{err}");
+    assert!(get_setting(&pool, settings_keys::LANGUAGE)
+        .await
+        .unwrap()
+        .is_none());
+}
+
+#[tokio::test]
+async fn dispatch_backup_export_import_round_trip() {
+    use chatmail_storage::{list_mailbox_messages, write_blob, MailboxStore};
+
+    let (src, _args, _db, src_pool) = setup_ctl_env().await;
+    chatmail_db::passwords::create_user(&src_pool, "a@x.org", "bcrypt:a")
+        .await
+        .unwrap();
+    let quota = chatmail_db::QuotaRow {
+        max_storage: 1 << 20,
+        created_at: 1_700_000_000,
+        first_login_at: 1_700_000_100,
+        last_login_at: 1_700_000_200,
+    };
+    chatmail_db::put_quota_row(&src_pool, "a@x.org", &quota)
+        .await
+        .unwrap();
+    let src_store = MailboxStore::new(src.path());
+    for id in ["m1", "m2", "m3"] {
+        write_blob(
+            &src_store,
+            "a@x.org",
+            id,
+            format!("Subject: {id}\r\n\r\nbody").as_bytes(),
+        )
+        .await
+        .unwrap();
+    }
+    // A gap in the UIDs must survive the move.
+    let before = list_mailbox_messages(&src_store, "a@x.org", "INBOX")
+        .await
+        .unwrap();
+    chatmail_storage::delete_blob(&src_store, "a@x.org", &before[1].base_id)
+        .await
+        .unwrap();
+    let before = list_mailbox_messages(&src_store, "a@x.org", "INBOX")
+        .await
+        .unwrap();
+
+    let archive = src.path().join("accounts.tar.gz");
+    let cli = parse_cli(
+        src.path(),
+        &["backup", "export", "-o", archive.to_str().unwrap()],
+    );
+    dispatch(&cli).await.unwrap();
+
+    let (dst, _args, _db, dst_pool) = setup_ctl_env().await;
+    let import = parse_cli(dst.path(), &["backup", "import", archive.to_str().unwrap()]);
+    dispatch(&import).await.unwrap();
+    assert_eq!(
+        chatmail_db::passwords::get_user_hash(&dst_pool, "a@x.org")
+            .await
+            .unwrap()
+            .as_deref(),
+        Some("bcrypt:a")
+    );
+    assert_eq!(
+        chatmail_db::get_quota_row(&dst_pool, "a@x.org")
+            .await
+            .unwrap(),
+        Some(quota)
+    );
+    let dst_store = MailboxStore::new(dst.path());
+    let after = list_mailbox_messages(&dst_store, "a@x.org", "INBOX")
+        .await
+        .unwrap();
+    let key = |m: &chatmail_storage::StoredMessage| (m.uid, m.filename.clone(), m.internal_date);
+    assert_eq!(
+        after.iter().map(key).collect::<Vec<_>>(),
+        before.iter().map(key).collect::<Vec<_>>()
+    );
+
+    // Existing accounts are skipped unless --overwrite is given.
+    chatmail_db::passwords::create_user(&dst_pool, "a@x.org", "bcrypt:changed")
+        .await
+        .unwrap();
+    dispatch(&import).await.unwrap();
+    assert_eq!(
+        chatmail_db::passwords::get_user_hash(&dst_pool, "a@x.org")
+            .await
+            .unwrap()
+            .as_deref(),
+        Some("bcrypt:changed")
+    );
+    let cli = parse_cli(
+        dst.path(),
+        &["backup", "import", archive.to_str().unwrap(), "--overwrite"],
+    );
+    dispatch(&cli).await.unwrap();
+    assert_eq!(
+        chatmail_db::passwords::get_user_hash(&dst_pool, "a@x.org")
+            .await
+            .unwrap()
+            .as_deref(),
+        Some("bcrypt:a")
+    );
+}
+
+/// Snapshot a state dir with one account and one message; returns the archive, the source
+/// config and the temp dirs that keep them alive.
+async fn write_test_snapshot() -> (
+    std::path::PathBuf,
+    std::path::PathBuf,
+    [tempfile::TempDir; 3],
+) {
+    let (src, _args, _db, pool) = setup_ctl_env().await;
+    chatmail_db::passwords::create_user(&pool, "a@x.org", "bcrypt:a")
+        .await
+        .unwrap();
+    let store = chatmail_storage::MailboxStore::new(src.path());
+    chatmail_storage::write_blob(&store, "a@x.org", "m1", b"Subject: m1\r\n\r\nbody")
+        .await
+        .unwrap();
+    let cfg_dir = tempfile::tempdir().unwrap();
+    let config = write_ss_test_config(cfg_dir.path());
+    let out = tempfile::tempdir().unwrap();
+    let archive = out.path().join("server.tar.gz");
+    let cli = parse_cli_with_config(
+        src.path(),
+        &config,
+        &["backup", "snapshot", "-o", archive.to_str().unwrap()],
+    );
+    dispatch(&cli).await.unwrap();
+    (archive, config, [src, cfg_dir, out])
+}
+
+#[tokio::test]
+async fn dispatch_backup_snapshot_restore_round_trip() {
+    let (archive, config, _dirs) = write_test_snapshot().await;
+
+    let dst = tempfile::tempdir().unwrap();
+    let dst_config = dst.path().join("restored.conf");
+    let cli = parse_cli_with_config(
+        dst.path(),
+        &dst_config,
+        &["backup", "restore", archive.to_str().unwrap()],
+    );
+    dispatch(&cli).await.unwrap();
+
+    assert_eq!(
+        std::fs::read_to_string(&dst_config).unwrap(),
+        std::fs::read_to_string(&config).unwrap()
+    );
+    let db = chatmail_config::effective_app_db_path(dst.path(), &Default::default());
+    let pool = chatmail_db::init_db(&db).await.unwrap();
+    assert_eq!(
+        chatmail_db::passwords::get_user_hash(&pool, "a@x.org")
+            .await
+            .unwrap()
+            .as_deref(),
+        Some("bcrypt:a")
+    );
+    let store = chatmail_storage::MailboxStore::new(dst.path());
+    let inbox = chatmail_storage::list_mailbox_messages(&store, "a@x.org", "INBOX")
+        .await
+        .unwrap();
+    assert_eq!(inbox.len(), 1);
+}
+
+#[tokio::test]
+async fn dispatch_backup_restore_rejects_tampered_snapshot_without_writing() {
+    use flate2::{read::GzDecoder, write::GzEncoder, Compression};
+
+    let (archive, _config, dirs) = write_test_snapshot().await;
+    // Same members and manifest, but the config file's bytes changed.
+    let tampered = dirs[2].path().join("tampered.tar.gz");
+    let mut src = tar::Archive::new(GzDecoder::new(std::fs::File::open(&archive).unwrap()));
+    let enc = GzEncoder::new(
+        std::fs::File::create(&tampered).unwrap(),
+        Compression::fast(),
+    );
+    let mut dst = tar::Builder::new(enc);
+    for entry in src.entries().unwrap() {
+        let mut entry = entry.unwrap();
+        let path = entry.path().unwrap().into_owned();
+        let mut data = Vec::new();
+        std::io::Read::read_to_end(&mut entry, &mut data).unwrap();
+        if path.starts_with("config") {
+            data[0] ^= 1;
+        }
+        let mut header = entry.header().clone();
+        dst.append_data(&mut header, &path, data.as_slice())
+            .unwrap();
+    }
+    dst.into_inner().unwrap().finish().unwrap();
+
+    let dst = tempfile::tempdir().unwrap();
+    let dst_config = dst.path().join("restored.conf");
+    let cli = parse_cli_with_config(
+        dst.path(),
+        &dst_config,
+        &["backup", "restore", tampered.to_str().unwrap(), "--force"],
+    );
+    let err = dispatch(&cli).await.unwrap_err().to_string();
+    assert!(err.contains("checksum mismatch"), "{err}");
+    assert_eq!(std::fs::read_dir(dst.path()).unwrap().count(), 0);
+}
+
 #[tokio::test]
 async fn dispatch_status_shows_registered_users() {
     let (dir, _args, _db, pool) = setup_ctl_env().await;
@@ -0,0 +1,193 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail peers` — federation peers whose `/federation/info` informs delivery.
+//!
+//! `add`/`remove` write the `federation_peers` table; a running server picks changes up on
+//! its next peer refresh (every 5 minutes). `list` asks the running server for the live
+//! status (GET `/admin/peers`) and falls back to the configured set when it is down.
+
+use chatmail_config::{Args, PeersCommand};
+use chatmail_types::{ChatmailError, Result};
+use serde_json::{json, Value};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use super::request_reload::admin_get;
+
+pub async fn peers(args: &Args, cmd: &PeersCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "peers");
+
+    match cmd {
+        PeersCommand::List { url, insecure } => {
+            ctx.require_db()?;
+            let body = match admin_get(&ctx, url.as_deref(), *insecure, "/admin/peers").await {
+                Ok(body) => body,
+                Err(e) => {
+                    if !out.is_json() {
+                        out.line(format!("Note: live status unavailable: {e}"));
+                    }
+                    offline_list(&ctx).await?
+                }
+            };
+            if out.is_json() {
+                return out.emit(body);
+            }
+            for line in format_peers(&body) {
+                out.line(line);
+            }
+        }
+        PeersCommand::Add { url } => {
+            let pool = ctx.open_pool().await?;
+            let url = chatmail_db::normalize_peer_url(url)?;
+            let added = chatmail_db::add_peer(&pool, &url).await?;
+            let human = if added {
+                format!("Success: added peer {url}.")
+            } else {
+                format!("Peer {url} is already listed.")
+            };
+            out.done_msg(
+                human,
+                json!({ "url": url, "added": added }),
+                format!("Added peer {url}"),
+            )?;
+        }
+        PeersCommand::Remove { url } => {
+            let pool = ctx.open_pool().await?;
+            let url = chatmail_db::normalize_peer_url(url)?;
+            if !chatmail_db::remove_peer(&pool, &url).await? {
+                let configured = ctx
+                    .config
+                    .peers
+                    .iter()
+                    .any(|p| chatmail_db::normalize_peer_url(p).is_ok_and(|p| p == url));
+                return Err(ChatmailError::config(if configured {
+                    format!("{url} comes from the peers directive; edit the config instead")
+                } else {
+                    format!("{url} is not a stored peer")
+                }));
+            }
+            out.done_msg(
+                format!("Success: removed peer {url}."),
+                json!({ "removed": url }),
+                format!("Removed peer {url}"),
+            )?;
+        }
+    }
+    Ok(())
+}
+
+/// `/admin/peers`-shaped body for the configured and stored peers, without status.
+async fn offline_list(ctx: &CtlContext) -> Result<Value> {
+    let pool = ctx.open_pool().await?;
+    let mut peers: Vec<Value> = Vec::new();
+    let configured = ctx
+        .config
+        .peers
+        .iter()
+        .filter_map(|p| chatmail_db::normalize_peer_url(p).ok())
+        .map(|url| (url, "config"));
+    let stored = chatmail_db::list_peers(&pool)
+        .await?
+        .into_iter()
+        .map(|p| (p.base_url, "stored"));
+    for (url, source) in configured.chain(stored) {
+        if peers.iter().any(|p| p["base_url"] == url.as_str()) {
+            continue;
+        }
+        peers.push(json!({ "base_url": url, "source": source, "reachable": null }));
+    }
+    Ok(json!({
+        "total": peers.len(),
+        "peers": peers,
+        "signed": !ctx.config.peer_secrets.is_empty(),
+    }))
+}
+
+fn format_peers(body: &Value) -> Vec<String> {
+    let peers = body["peers"].as_array().map(Vec::as_slice).unwrap_or(&[]);
+    let mut lines = vec!["URL\tSOURCE\tDOMAIN\tSTATUS\tMAX MESSAGE\tDELIVERY".to_string()];
+    for p in peers {
+        let status = match (p["reachable"].as_bool(), p["verified"].as_bool()) {
+            (None, _) => "unknown",
+            (Some(false), _) => "unreachable",
+            (Some(true), Some(true)) => "verified",
+            (Some(true), _) => "unverified",
+        };
+        let info = &p["info"];
+        let delivery: Vec<&str> = info["delivery"]
+            .as_array()
+            .map(|d| d.iter().filter_map(Value::as_str).collect())
+            .unwrap_or_default();
+        lines.push(format!(
+            "{}\t{}\t{}\t{status}\t{}\t{}",
+            p["base_url"].as_str().unwrap_or("?"),
+            p["source"].as_str().unwrap_or("?"),
+            p["domain"].as_str().unwrap_or("-"),
+            info["max_message_size"]
+                .as_u64()
+                .map_or("-".to_string(), |n| n.to_string()),
+            if delivery.is_empty() {
+                "-".to_string()
+            } else {
+                delivery.join(",")
+            },
+        ));
+        if let Some(error) = p["error"].as_str() {
+            lines.push(format!("  {error}"));
+        }
+    }
+    lines.push("---".into());
+    lines.push(format!("Total: {} peers.", peers.len()));
+    if body["signed"] == false && !peers.is_empty() {
+        lines.push("No peer_secret configured: peer documents are not used for delivery.".into());
+    }
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn format_shows_status_and_limits() {
+        let body = json!({
+            "signed": true,
+            "peers": [
+                {
+                    "base_url": "https://b.example", "source": "stored", "domain": "b.example",
+                    "reachable": true, "verified": true, "error": null,
+                    "info": { "max_message_size": 31457280, "delivery": ["https", "smtp"] },
+                },
+                {
+                    "base_url": "https://c.example", "source": "config", "domain": null,
+                    "reachable": false, "verified": false, "error": "connection refused",
+                    "info": null,
+                },
+            ],
+        });
+        let lines = format_peers(&body);
+        assert_eq!(
+            lines[1],
+            "https://b.example\tstored\tb.example\tverified\t31457280\thttps,smtp"
+        );
+        assert_eq!(lines[2], "https://c.example\tconfig\t-\tunreachable\t-\t-");
+        assert_eq!(lines[3], "  connection refused");
+        assert_eq!(lines.last().unwrap(), "Total: 2 peers.");
+    }
+}
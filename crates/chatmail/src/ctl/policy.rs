@@ -0,0 +1,127 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail policy` — unified policy lists (`policy_entries`).
+//!
+//! Writes go straight to the database; a running server picks them up on `reload`.
+
+use chatmail_config::cli::PolicyCommand;
+use chatmail_config::{parse_duration, Args};
+use chatmail_db::policies::{
+    add_policy_entry, list_policy_entries, remove_policy_entry, unix_now, PolicyType,
+};
+use chatmail_types::{ChatmailError, Result};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn policy(args: &Args, cmd: &PolicyCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let pool = ctx.open_pool().await?;
+    let out = CtlOut::from_args(args, "policy");
+
+    match cmd {
+        PolicyCommand::Add {
+            policy_type,
+            pattern,
+            action,
+            note,
+            expires,
+        } => {
+            let ty = PolicyType::parse(policy_type)?;
+            let expires_at = match expires.as_deref() {
+                Some(raw) => {
+                    let d = parse_duration(raw).map_err(|_| {
+                        ChatmailError::config(format!("invalid --expires duration: {raw}"))
+                    })?;
+                    Some(unix_now() + d.as_secs() as i64)
+                }
+                None => None,
+            };
+            let entry =
+                add_policy_entry(&pool, ty, pattern, action.as_deref(), note, expires_at).await?;
+            out.done_msg(
+                format!(
+                    "Success: {} {} '{}'.",
+                    ty.as_str(),
+                    entry.action,
+                    entry.pattern
+                ),
+                serde_json::json!({
+                    "type": ty.as_str(),
+                    "pattern": entry.pattern,
+                    "action": entry.action,
+                    "expires_at": entry.expires_at,
+                }),
+                format!("Added {} policy {}", ty.as_str(), entry.pattern),
+            )?;
+        }
+        PolicyCommand::List { policy_type } => {
+            let ty = policy_type.as_deref().map(PolicyType::parse).transpose()?;
+            let entries = list_policy_entries(&pool, ty).await?;
+            let now = unix_now();
+            if out.is_json() {
+                let entries: Vec<_> = entries
+                    .into_iter()
+                    .map(|e| {
+                        serde_json::json!({
+                            "type": e.policy_type,
+                            "pattern": e.pattern,
+                            "action": e.action,
+                            "note": e.note,
+                            "expires_at": e.expires_at,
+                            "active": e.is_active(now),
+                        })
+                    })
+                    .collect();
+                return out.emit(serde_json::json!({ "entries": entries }));
+            }
+            out.line("TYPE\tPATTERN\tACTION\tEXPIRES\tNOTE");
+            for e in &entries {
+                let expires = match e.expires_at {
+                    Some(_) if !e.is_active(now) => "expired".to_string(),
+                    Some(t) => format!("in {}s", t - now),
+                    None => "never".to_string(),
+                };
+                out.line(format!(
+                    "{}\t{}\t{}\t{}\t{}",
+                    e.policy_type, e.pattern, e.action, expires, e.note
+                ));
+            }
+            out.line("---");
+            out.line(format!("Total: {} entries.", entries.len()));
+        }
+        PolicyCommand::Remove {
+            policy_type,
+            pattern,
+        } => {
+            let ty = PolicyType::parse(policy_type)?;
+            if !remove_policy_entry(&pool, ty, pattern).await? {
+                return Err(ChatmailError::config(format!(
+                    "no {} entry '{pattern}'",
+                    ty.as_str()
+                )));
+            }
+            out.done_msg(
+                format!("Success: removed {} '{pattern}'.", ty.as_str()),
+                serde_json::json!({ "type": ty.as_str(), "pattern": pattern }),
+                format!("Removed {} policy {pattern}", ty.as_str()),
+            )?;
+        }
+    }
+    Ok(())
+}
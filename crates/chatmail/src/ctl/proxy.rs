@@ -18,9 +18,11 @@
 //! `madmail proxy` — Shadowsocks circumvention proxy (`__SS_*__`).
 
 use chatmail_config::cli::{ProxyCommand, ProxySettingCommand};
-use chatmail_config::Args;
+use chatmail_config::{parse_duration, Args};
 use chatmail_db::{delete_setting, get_setting, set_setting, settings_keys};
-use chatmail_shadowsocks::{parse_cipher, resolve_runtime};
+use chatmail_shadowsocks::{
+    generate_password, parse_cipher, resolve_runtime, rotate_password, ss_protocol, SsProtocol,
+};
 use chatmail_types::{ChatmailError, Result};
 use serde_json::{json, Value};
 
@@ -44,6 +46,14 @@ pub async fn proxy(args: &Args, cmd: Option<&ProxyCommand>) -> Result<()> {
         Some(ProxyCommand::Password { cmd }) => {
             password_setting(args, &ctx, &pool, cmd.as_ref()).await
         }
+        Some(ProxyCommand::Rotate {
+            password,
+            grace,
+            keep_old,
+        }) => {
+            let grace = (!keep_old).then_some(grace.as_str());
+            rotate(args, &ctx, &pool, password.as_deref(), grace).await
+        }
     }
 }
 
@@ -83,6 +93,10 @@ async fn status(args: &Args, ctx: &CtlContext, pool: &chatmail_db::DbPool) -> Re
             data["cipher"].as_str().unwrap_or(""),
             data["cipher_source"].as_str().unwrap_or("")
         ));
+        out.line(format!(
+            "  Protocol:    {}",
+            data["protocol"].as_str().unwrap_or("legacy")
+        ));
         out.line(format!(
             "  Password:    {}",
             if data["password_db_override"].as_bool().unwrap_or(false) {
@@ -91,6 +105,10 @@ async fn status(args: &Args, ctx: &CtlContext, pool: &chatmail_db::DbPool) -> Re
                 "config file"
             }
         ));
+        let extra = data["extra_passwords"].as_u64().unwrap_or(0);
+        if extra > 0 {
+            out.line(format!("  Also valid:  {extra} more password(s)"));
+        }
         if let Some(url) = data["shadowsocks_url"].as_str().filter(|s| !s.is_empty()) {
             out.line(format!("  Client URL:  {url}"));
         }
@@ -157,6 +175,7 @@ async fn cipher_setting(
             .await
         }
         Some(ProxySettingCommand::Set { value }) => {
+            let protocol = ss_protocol(&ctx.config)?;
             setting_set(
                 args,
                 ctx,
@@ -164,7 +183,7 @@ async fn cipher_setting(
                 settings_keys::SS_CIPHER,
                 "proxy cipher set",
                 value,
-                validate_cipher,
+                |v| validate_cipher(v, protocol),
             )
             .await
         }
@@ -207,29 +226,72 @@ async fn password_setting(
             .await
         }
         Some(ProxySettingCommand::Reset) => {
+            // Rotation slots go with the override they were rotated from.
+            delete_setting(pool, settings_keys::SS_PASSWORD_SLOTS).await?;
             setting_reset(
                 args,
                 ctx,
                 pool,
                 settings_keys::SS_PASSWORD,
                 "proxy password reset",
-                |cfg| cfg.ss_password.clone().unwrap_or_default(),
+                |cfg| cfg.ss_primary_password().to_string(),
             )
             .await
         }
     }
 }
 
+/// `proxy rotate`: new primary password, the old one kept for `grace` (`None`: for good).
+async fn rotate(
+    args: &Args,
+    ctx: &CtlContext,
+    pool: &chatmail_db::DbPool,
+    password: Option<&str>,
+    grace: Option<&str>,
+) -> Result<()> {
+    require_ss_configured(&ctx.config)?;
+    let out = CtlOut::from_args(args, "proxy rotate");
+    let grace_duration = match grace {
+        Some(raw) => Some(
+            parse_duration(raw)
+                .map_err(|_| ChatmailError::config(format!("invalid --grace: {raw}")))?,
+        ),
+        None => None,
+    };
+    let rt = resolve_runtime(pool, &ctx.config, &mail_domain(ctx), &ctx.state_dir).await?;
+    let password = match password {
+        Some(p) => validate_password(p)?,
+        None => {
+            let mut random = [0u8; 32];
+            getrandom::fill(&mut random)
+                .map_err(|e| ChatmailError::config(format!("generate password: {e}")))?;
+            generate_password(&rt, &random)
+        }
+    };
+    let rotation = rotate_password(pool, &rt, &password, grace_duration).await?;
+    let kept = match grace {
+        Some(raw) => format!("for {raw}"),
+        None => "until `proxy password reset`".into(),
+    };
+    out.done_msg(
+        format!(
+            "🔑 Shadowsocks password rotated; the previous one stays valid {kept} ({RELOAD_HINT})"
+        ),
+        json!({
+            "retired_until": rotation.retired_until,
+            "slots": rotation.slots.len(),
+            "reload_required": true,
+        }),
+        "Shadowsocks password rotated",
+    )
+}
+
 async fn password_status(args: &Args, ctx: &CtlContext, pool: &chatmail_db::DbPool) -> Result<()> {
     let out = CtlOut::from_args(args, "proxy password status");
     require_ss_configured(&ctx.config)?;
 
     let db_override = get_setting(pool, settings_keys::SS_PASSWORD).await?;
-    let configured = ctx
-        .config
-        .ss_password
-        .as_ref()
-        .is_some_and(|s| !s.is_empty());
+    let configured = !ctx.config.ss_primary_password().is_empty();
     let source = if db_override.is_some() {
         "db"
     } else {
@@ -355,8 +417,11 @@ async fn proxy_status_data(ctx: &CtlContext, pool: &chatmail_db::DbPool) -> Resu
             "port": "",
             "cipher": "",
             "cipher_source": "config",
+            "protocol": "",
             "password_db_override": false,
+            "extra_passwords": 0,
             "shadowsocks_url": "",
+            "shadowsocks_urls": [],
             "ws_enabled": false,
             "grpc_enabled": false,
         }));
@@ -375,8 +440,11 @@ async fn proxy_status_data(ctx: &CtlContext, pool: &chatmail_db::DbPool) -> Resu
         "port": port,
         "cipher": rt.cipher,
         "cipher_source": if cipher_db.is_some() { "db" } else { "config" },
+        "protocol": rt.protocol.as_str(),
         "password_db_override": password_db.is_some(),
+        "extra_passwords": urls.shadowsocks_urls.len().saturating_sub(1),
         "shadowsocks_url": urls.shadowsocks_url,
+        "shadowsocks_urls": urls.shadowsocks_urls,
         "ws_enabled": rt.ws_enabled,
         "grpc_enabled": rt.grpc_enabled,
     }))
@@ -394,12 +462,14 @@ fn require_ss_configured(config: &chatmail_config::AppConfig) -> Result<()> {
     }
 }
 
-fn validate_cipher(cipher: &str) -> Result<String> {
+fn validate_cipher(cipher: &str, protocol: SsProtocol) -> Result<String> {
     let trimmed = cipher.trim();
-    if parse_cipher(trimmed).is_none() {
+    if parse_cipher(trimmed, protocol).is_none() {
         return Err(ChatmailError::config(format!(
             "unsupported shadowsocks cipher: {cipher}\n\
-             Supported: aes-128-gcm, aes-256-gcm, chacha20-ietf-poly1305"
+             Supported with ss_protocol {}: {}",
+            protocol.as_str(),
+            protocol.supported_ciphers()
         )));
     }
     Ok(trimmed.to_ascii_lowercase())
@@ -33,6 +33,8 @@ use crate::admin::resolve_admin_token;
 struct AdminEnvelope {
     status: u16,
     error: Option<String>,
+    #[serde(default)]
+    body: Option<serde_json::Value>,
 }
 
 /// Result of asking the running server to soft-reload HTTP routes.
@@ -121,6 +123,75 @@ pub async fn request_soft_reload(
     Ok(SoftReloadOutcome::Accepted { api_url })
 }
 
+/// GET an admin API `resource` on the running server and return the response body.
+pub async fn admin_get(
+    ctx: &CtlContext,
+    url_override: Option<&str>,
+    insecure: bool,
+    resource: &str,
+) -> Result<serde_json::Value> {
+    admin_request(ctx, url_override, insecure, "GET", resource, None).await
+}
+
+/// Call an admin API `resource` on the running server and return the response body.
+pub async fn admin_request(
+    ctx: &CtlContext,
+    url_override: Option<&str>,
+    insecure: bool,
+    method: &str,
+    resource: &str,
+    body: Option<serde_json::Value>,
+) -> Result<serde_json::Value> {
+    let token = resolve_admin_token(&ctx.state_dir, &ctx.config)?;
+    let settings = ctx.load_settings_map().await?;
+    let api_url = url_override
+        .map(str::trim)
+        .filter(|s| !s.is_empty())
+        .map(|s| s.trim_end_matches('/').to_string())
+        .unwrap_or_else(|| {
+            build_admin_url(&ctx.config, &settings)
+                .trim_end_matches('/')
+                .to_string()
+        });
+
+    let mut envelope = json!({
+        "method": method,
+        "resource": resource,
+        "headers": { "Authorization": format!("Bearer {token}") },
+    });
+    if let Some(body) = body {
+        envelope["body"] = body;
+    }
+    let client = build_http_client(insecure)?;
+    let resp = client
+        .post(&api_url)
+        .header("Content-Type", "application/json")
+        .header("User-Agent", "chatmail/ctl")
+        .json(&envelope)
+        .send()
+        .map_err(|e| {
+            ChatmailError::config(format!(
+                "could not reach admin API at {api_url} ({e}) — is the server running? \
+                 Use --url or check hostname/ports"
+            ))
+        })?;
+    let body_text = resp
+        .text()
+        .map_err(|e| ChatmailError::config(format!("read admin response: {e}")))?;
+    let parsed: AdminEnvelope = serde_json::from_str(&body_text)
+        .map_err(|e| ChatmailError::config(format!("admin API response: {e}")))?;
+    if let Some(err) = parsed.error.filter(|s| !s.is_empty()) {
+        return Err(ChatmailError::config(format!("admin API: {err}")));
+    }
+    if parsed.status >= 400 {
+        return Err(ChatmailError::config(format!(
+            "admin API failed (status {})",
+            parsed.status
+        )));
+    }
+    Ok(parsed.body.unwrap_or(serde_json::Value::Null))
+}
+
 /// After changing admin-web settings, reload HTTP routes when the server is up.
 pub async fn notify_http_routes_changed(ctx: &CtlContext, path: &str) -> Result<()> {
     const MAX_ATTEMPTS: usize = 8;
@@ -0,0 +1,109 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail responder` — automatic replies for local addresses (`responders`).
+//!
+//! Writes go straight to the database; a running server re-reads the table every 30 s.
+
+use chatmail_auth::normalize_username;
+use chatmail_config::{parse_duration, Args, ResponderCommand};
+use chatmail_db::passwords;
+use chatmail_types::{ChatmailError, Result};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn responder(args: &Args, cmd: &ResponderCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let pool = ctx.open_pool().await?;
+    let out = CtlOut::from_args(args, "responder");
+
+    match cmd {
+        ResponderCommand::Set {
+            address,
+            template,
+            interval,
+        } => {
+            let address = normalize_username(address)?;
+            let interval_secs = parse_duration(interval)
+                .ok()
+                .filter(|d| !d.is_zero())
+                .ok_or_else(|| {
+                    ChatmailError::config(format!("invalid --interval duration: {interval}"))
+                })?
+                .as_secs() as i64;
+            let text = std::fs::read_to_string(template)
+                .map_err(|e| ChatmailError::config(format!("read {}: {e}", template.display())))?;
+            let responder =
+                chatmail_db::set_responder(&pool, &address, &text, interval_secs).await?;
+            if !out.is_json() && !passwords::user_exists(&pool, &address).await? {
+                out.line(format!(
+                    "Note: {address} has no account; mail to it is not delivered, so no \
+                     replies are sent until it exists."
+                ));
+            }
+            out.done_msg(
+                format!(
+                    "Success: {} answers each correspondent at most once per {interval}.",
+                    responder.address
+                ),
+                serde_json::json!({
+                    "address": responder.address,
+                    "interval_secs": responder.interval_secs,
+                    "template_bytes": responder.template.len(),
+                }),
+                format!("Set responder {}", responder.address),
+            )?;
+        }
+        ResponderCommand::List => {
+            let responders = chatmail_db::list_responders(&pool).await?;
+            if out.is_json() {
+                let rows: Vec<_> = responders
+                    .iter()
+                    .map(|r| {
+                        serde_json::json!({
+                            "address": r.address,
+                            "interval_secs": r.interval_secs,
+                            "created_at": r.created_at,
+                            "template": r.template,
+                        })
+                    })
+                    .collect();
+                return out.emit(serde_json::json!({ "responders": rows }));
+            }
+            out.line("ADDRESS\tINTERVAL\tFIRST LINE");
+            for r in &responders {
+                let first = r.template.lines().next().unwrap_or("");
+                out.line(format!("{}\t{}s\t{}", r.address, r.interval_secs, first));
+            }
+            out.line("---");
+            out.line(format!("Total: {} responders.", responders.len()));
+        }
+        ResponderCommand::Remove { address } => {
+            let address = normalize_username(address)?;
+            if !chatmail_db::remove_responder(&pool, &address).await? {
+                return Err(ChatmailError::config(format!("no responder for {address}")));
+            }
+            out.done_msg(
+                format!("Success: removed responder for {address}."),
+                serde_json::json!({ "address": address }),
+                format!("Removed responder {address}"),
+            )?;
+        }
+    }
+    Ok(())
+}
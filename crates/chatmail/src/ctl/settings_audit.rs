@@ -0,0 +1,148 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! CLI side of the admin audit trail: the runtime settings (`__…__` keys) a command
+//! changed are recorded with actor `cli`, one row per key. Nothing is written while
+//! logging is off (No-Log policy).
+
+use std::collections::BTreeMap;
+
+use chatmail_admin::redact_body;
+use chatmail_config::Args;
+use chatmail_db::{list_double_underscore_settings, record_admin_audit, AdminAudit, DbPool};
+use serde_json::{json, Value};
+
+use super::context::CtlContext;
+
+/// Settings as they were before the command ran.
+pub(super) struct SettingsAudit {
+    pool: DbPool,
+    before: BTreeMap<String, String>,
+}
+
+impl SettingsAudit {
+    /// Snapshot the settings; `None` when logging is off or there is no database yet.
+    pub(super) async fn begin(args: &Args) -> Option<Self> {
+        let ctx = CtlContext::from_args(args).ok()?;
+        if ctx.config.logging_off() || ctx.require_db().is_err() {
+            return None;
+        }
+        let pool = ctx.open_pool().await.ok()?;
+        let before = settings_snapshot(&pool).await?;
+        Some(Self { pool, before })
+    }
+
+    /// Record every key `command` set or removed; a failed write only warns.
+    pub(super) async fn finish(self, command: &str) {
+        let Some(after) = settings_snapshot(&self.pool).await else {
+            return;
+        };
+        for entry in settings_changes(&self.before, &after, command) {
+            if let Err(e) = record_admin_audit(&self.pool, &entry).await {
+                tracing::warn!(key = %entry.resource, error = %e, "admin audit write failed");
+            }
+        }
+    }
+}
+
+async fn settings_snapshot(pool: &DbPool) -> Option<BTreeMap<String, String>> {
+    let rows = list_double_underscore_settings(pool).await.ok()?;
+    Some(rows.into_iter().collect())
+}
+
+/// Audit rows for the keys that differ between `before` and `after`: `SET` with the old
+/// and new value, or `DELETE` with the old one. Secret keys are redacted.
+fn settings_changes(
+    before: &BTreeMap<String, String>,
+    after: &BTreeMap<String, String>,
+    command: &str,
+) -> Vec<AdminAudit> {
+    let keys: std::collections::BTreeSet<&String> = before.keys().chain(after.keys()).collect();
+    let as_value = |v: Option<&String>| v.map_or(Value::Null, |v| json!(v));
+    keys.into_iter()
+        .filter(|key| before.get(*key) != after.get(*key))
+        .map(|key| {
+            let (old, new) = (before.get(key), after.get(key));
+            let method = if new.is_some() { "SET" } else { "DELETE" };
+            AdminAudit {
+                id: 0,
+                created_at: 0,
+                token_prefix: String::new(),
+                method: method.into(),
+                resource: key.clone(),
+                body: json!({ "command": command }).to_string(),
+                status: 0,
+                remote: String::new(),
+                actor: chatmail_db::AUDIT_ACTOR_CLI.into(),
+                old_value: redact_body(key, &as_value(old)),
+                new_value: redact_body(key, &as_value(new)),
+            }
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn changes_are_diffed_per_key_with_secrets_redacted() {
+        let map = |pairs: &[(&str, &str)]| -> BTreeMap<String, String> {
+            pairs
+                .iter()
+                .map(|(k, v)| (k.to_string(), v.to_string()))
+                .collect()
+        };
+        let before = map(&[
+            ("__LANGUAGE__", "en"),
+            ("__TURN_SECRET__", "old-secret"),
+            ("__SMTP_PORT__", "25"),
+            ("__MAX_ACCOUNTS__", "10"),
+        ]);
+        let after = map(&[
+            ("__LANGUAGE__", "de"),
+            ("__TURN_SECRET__", "new-secret"),
+            ("__SMTP_PORT__", "25"),
+        ]);
+        let rows = settings_changes(&before, &after, "settings");
+        let keys: Vec<_> = rows.iter().map(|r| r.resource.as_str()).collect();
+        assert_eq!(
+            keys,
+            ["__LANGUAGE__", "__MAX_ACCOUNTS__", "__TURN_SECRET__"]
+        );
+        assert_eq!(
+            (rows[0].method.as_str(), rows[0].actor.as_str()),
+            ("SET", "cli")
+        );
+        assert_eq!(
+            (rows[0].old_value.as_str(), rows[0].new_value.as_str()),
+            ("\"en\"", "\"de\"")
+        );
+        assert_eq!(rows[1].method, "DELETE");
+        assert_eq!(rows[1].new_value, "");
+        assert!(
+            !rows[2].old_value.contains("secret"),
+            "{}",
+            rows[2].old_value
+        );
+        assert!(
+            !rows[2].new_value.contains("secret"),
+            "{}",
+            rows[2].new_value
+        );
+    }
+}
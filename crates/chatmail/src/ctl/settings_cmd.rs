@@ -0,0 +1,121 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! `chatmail settings export|import` — settings DB dump / restore.
+
+use std::path::Path;
+
+use chatmail_admin::{export_settings, import_settings, ImportStatus, SettingsExport};
+use chatmail_config::{Args, SettingsCommand};
+use chatmail_types::{ChatmailError, Result};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+pub async fn settings(args: &Args, cmd: &SettingsCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    ctx.require_db()?;
+    let pool = ctx.open_pool().await?;
+
+    match cmd {
+        SettingsCommand::Export {
+            include_secrets,
+            output,
+        } => {
+            let out = CtlOut::from_args(args, "settings export");
+            let doc = export_settings(&pool, *include_secrets).await?;
+            match output {
+                Some(path) => {
+                    write_export(path, &doc.to_json_pretty())?;
+                    out.done_msg(
+                        format!(
+                            "✅ Exported {} settings to {}",
+                            doc.settings.len(),
+                            path.display()
+                        ),
+                        serde_json::json!({
+                            "path": path.display().to_string(),
+                            "count": doc.settings.len(),
+                            "secrets_included": doc.secrets_included,
+                        }),
+                        "settings exported",
+                    )
+                }
+                None if out.is_json() => out.emit(&doc),
+                None => {
+                    print!("{}", doc.to_json_pretty());
+                    Ok(())
+                }
+            }
+        }
+        SettingsCommand::Import { file, dry_run } => {
+            let out = CtlOut::from_args(args, "settings import");
+            let text = std::fs::read_to_string(file)
+                .map_err(|e| ChatmailError::config(format!("read {}: {e}", file.display())))?;
+            let doc = SettingsExport::parse(&text)?;
+            let report = import_settings(&pool, &ctx.config, &doc, *dry_run).await?;
+
+            if out.is_json() {
+                out.emit(&report)?;
+            } else {
+                for entry in &report.entries {
+                    match &entry.error {
+                        Some(err) => out.line(format!(
+                            "  {:<10} {}: {err}",
+                            entry.status.as_str(),
+                            entry.key
+                        )),
+                        None => out.line(format!("  {:<10} {}", entry.status.as_str(), entry.key)),
+                    }
+                }
+            }
+
+            let invalid = report.invalid_count();
+            if invalid > 0 {
+                return Err(ChatmailError::config(format!(
+                    "{invalid} invalid setting(s); nothing was imported"
+                )));
+            }
+            let changed = report.count(ImportStatus::Created) + report.count(ImportStatus::Updated);
+            if *dry_run {
+                out.line(format!("Dry run: {changed} setting(s) would change."));
+            } else {
+                out.line(format!(
+                    "✅ Imported {changed} changed setting(s). Run `madmail reload` to apply."
+                ));
+            }
+            Ok(())
+        }
+    }
+}
+
+/// Exports may carry secrets — keep the file owner-only.
+fn write_export(path: &Path, body: &str) -> Result<()> {
+    use std::fs::OpenOptions;
+    use std::io::Write;
+
+    #[cfg(unix)]
+    use std::os::unix::fs::OpenOptionsExt;
+
+    let mut opts = OpenOptions::new();
+    opts.write(true).create(true).truncate(true);
+    #[cfg(unix)]
+    opts.mode(0o600);
+
+    let mut file = opts.open(path)?;
+    file.write_all(body.as_bytes())?;
+    Ok(())
+}
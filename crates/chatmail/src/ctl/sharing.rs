@@ -20,8 +20,9 @@
 use chatmail_config::cli::SharingCommand;
 use chatmail_config::Args;
 use chatmail_db::{
-    create_sharing_contact, init_sharing_db, list_sharing_contacts, remove_sharing_contact,
-    update_sharing_contact,
+    create_sharing_contact, create_sharing_invite, get_sharing_contact, init_sharing_db,
+    list_sharing_contacts, normalize_sharing_url, parse_sharing_ttl, remove_sharing_contact,
+    rename_sharing_contact, update_sharing_contact, GroupInviteFields, SharingContact,
 };
 use chatmail_types::{ChatmailError, Result};
 
@@ -30,9 +31,11 @@ use super::output::CtlOut;
 
 pub async fn sharing(args: &Args, cmd: &SharingCommand) -> Result<()> {
     let ctx = CtlContext::from_args(args)?;
-    let sharing_path = ctx.state_dir.join("sharing.db");
-    let pool = init_sharing_db(&sharing_path).await?;
+    // Same file the `/{slug}` endpoint serves (`sharing_dsn`, default `sharing.db`).
+    let pool = init_sharing_db(&ctx.config.sharing_db_path(&ctx.state_dir)).await?;
     let out = CtlOut::from_args(args, "sharing");
+    // `sharing_analytics off` hides the counters as well as stopping collection.
+    let analytics = ctx.config.sharing_analytics.unwrap_or(true);
 
     match cmd {
         SharingCommand::List => {
@@ -40,31 +43,85 @@ pub async fn sharing(args: &Args, cmd: &SharingCommand) -> Result<()> {
             if out.is_json() {
                 let entries: Vec<_> = contacts
                     .into_iter()
-                    .map(|c| {
-                        serde_json::json!({
-                            "slug": c.slug,
-                            "name": c.name,
-                            "url": c.url,
-                            "created_at": c.created_at,
-                        })
-                    })
+                    .map(|c| contact_json(c, analytics))
                     .collect();
                 return out.emit(serde_json::json!({ "entries": entries }));
             }
-            out.line("SLUG\tNAME\tURL\tCREATED AT");
+            if analytics {
+                out.line("SLUG\tTYPE\tNAME\tURL\tCREATED AT\tEXPIRES AT\tVIEWS\tLAST VIEWED");
+            } else {
+                out.line("SLUG\tTYPE\tNAME\tURL\tCREATED AT\tEXPIRES AT");
+            }
             for c in contacts {
-                out.line(format!(
-                    "{}\t{}\t{}\t{}",
-                    c.slug, c.name, c.url, c.created_at
-                ));
+                let mut row = format!(
+                    "{}\t{}\t{}\t{}\t{}\t{}",
+                    c.slug,
+                    c.kind,
+                    c.name,
+                    c.url,
+                    c.created_at,
+                    c.expires_at.as_deref().unwrap_or("-")
+                );
+                if analytics {
+                    let last = if c.last_viewed.is_empty() {
+                        "-"
+                    } else {
+                        c.last_viewed.as_str()
+                    };
+                    row.push_str(&format!("\t{}\t{last}", c.views));
+                }
+                out.line(row);
+            }
+        }
+        SharingCommand::Show { slug } => {
+            let c = get_sharing_contact(&pool, slug)
+                .await?
+                .ok_or_else(|| ChatmailError::config(format!("slug {slug} not found")))?;
+            if out.is_json() {
+                return out.emit(contact_json(c, analytics));
+            }
+            out.line(format!("slug: {}", c.slug));
+            out.line(format!("type: {}", c.kind));
+            out.line(format!("name: {}", c.name));
+            out.line(format!("url: {}", c.url));
+            if !c.member_note.is_empty() {
+                out.line(format!("members: {}", c.member_note));
+            }
+            if !c.description.is_empty() {
+                out.line(format!("description: {}", c.description));
+            }
+            out.line(format!("created at: {}", c.created_at));
+            out.line(format!(
+                "expires at: {}",
+                c.expires_at.as_deref().unwrap_or("never")
+            ));
+            if analytics {
+                out.line(format!("views: {}", c.views));
+                if !c.last_viewed.is_empty() {
+                    out.line(format!("last viewed: {}", c.last_viewed));
+                }
             }
         }
-        SharingCommand::Create { slug, url, name } => {
+        SharingCommand::Create {
+            slug,
+            url,
+            name,
+            expires,
+        } => {
             let name = name.as_deref().unwrap_or("");
-            create_sharing_contact(&pool, slug, url, name).await?;
+            let ttl = parse_sharing_ttl(expires.as_deref().unwrap_or(""))?;
+            // Same checks as the `/share` form; the canonical URL is what gets stored.
+            let url = normalize_sharing_url(url)?;
+            let group = GroupInviteFields::default();
+            create_sharing_invite(&pool, slug, &url, name, &group, ttl).await?;
             out.done_msg(
                 format!("Successfully created link: {slug}"),
-                serde_json::json!({ "slug": slug, "url": url, "name": name }),
+                serde_json::json!({
+                    "slug": slug,
+                    "url": url,
+                    "name": name,
+                    "expires_in_secs": ttl.map(|t| t.as_secs()),
+                }),
                 format!("Created link: {slug}"),
             )?;
         }
@@ -87,7 +144,8 @@ pub async fn sharing(args: &Args, cmd: &SharingCommand) -> Result<()> {
             )?;
         }
         SharingCommand::Edit { slug, url, name } => {
-            if !update_sharing_contact(&pool, slug, url, name.as_deref()).await? {
+            let url = normalize_sharing_url(url)?;
+            if !update_sharing_contact(&pool, slug, &url, name.as_deref()).await? {
                 return Err(ChatmailError::config(format!("slug {slug} not found")));
             }
             out.done_msg(
@@ -96,6 +154,34 @@ pub async fn sharing(args: &Args, cmd: &SharingCommand) -> Result<()> {
                 format!("Updated link: {slug}"),
             )?;
         }
+        SharingCommand::Rename { old, new } => {
+            if !rename_sharing_contact(&pool, old, new).await? {
+                return Err(ChatmailError::config(format!("slug {old} not found")));
+            }
+            out.done_msg(
+                format!("Successfully renamed link: {old} -> {new}"),
+                serde_json::json!({ "old": old, "new": new }),
+                format!("Renamed link: {old} -> {new}"),
+            )?;
+        }
     }
     Ok(())
 }
+
+fn contact_json(c: SharingContact, analytics: bool) -> serde_json::Value {
+    let mut entry = serde_json::json!({
+        "slug": c.slug,
+        "kind": c.kind,
+        "name": c.name,
+        "url": c.url,
+        "member_note": c.member_note,
+        "description": c.description,
+        "created_at": c.created_at,
+        "expires_at": c.expires_at,
+    });
+    if analytics {
+        entry["views"] = c.views.into();
+        entry["last_viewed"] = c.last_viewed.into();
+    }
+    entry
+}
@@ -0,0 +1,641 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail backup snapshot` / `backup restore` — the whole server as one `.tar.gz`.
+//!
+//! Archive layout (snapshot format 1):
+//!
+//! ```text
+//! config/{file name}      the `--config` file
+//! state/{path}            everything under the state directory
+//! cold/{path}             the `cold_storage` pack directory when it lives outside the state
+//!                         directory
+//! db/{file name}          app or sharing DB when it lives outside the state directory
+//! snapshot.json           format, created_at, and every file with its size and SHA-256
+//! ```
+//!
+//! SQLite databases are copied with `VACUUM INTO`, so each one is consistent even while the
+//! server runs; their `-wal` / `-shm` / `-journal` files are left out. `snapshot.json` is the
+//! last entry because the digests are only known once a file has been streamed. Restore
+//! checks the whole archive against it before touching the disk.
+
+use std::collections::BTreeMap;
+use std::fs::{self, File};
+use std::io::{self, BufReader, BufWriter, Read, Write};
+use std::path::{Component, Path, PathBuf};
+use std::process::Command;
+
+use chatmail_config::Args;
+use chatmail_db::policies::unix_now;
+use chatmail_db::vacuum_into;
+use chatmail_types::{ChatmailError, Result};
+use flate2::read::GzDecoder;
+use flate2::write::GzEncoder;
+use flate2::Compression;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+use super::context::CtlContext;
+use super::docs::argv_binary_name;
+
+/// Snapshot layout version written to `snapshot.json`.
+const FORMAT: u32 = 1;
+const MANIFEST: &str = "snapshot.json";
+/// First entry of a `backup export` archive, which restore points at `backup import`.
+const ACCOUNT_MANIFEST: &str = "manifest.json";
+const SQLITE_MAGIC: &[u8; 16] = b"SQLite format 3\0";
+const SQLITE_SIDECARS: [&str; 3] = ["-wal", "-shm", "-journal"];
+
+#[derive(Debug, Serialize, Deserialize)]
+struct Manifest {
+    format: u32,
+    created_at: i64,
+    files: Vec<ManifestFile>,
+}
+
+#[derive(Debug, Serialize, Deserialize)]
+struct ManifestFile {
+    path: String,
+    size: u64,
+    sha256: String,
+}
+
+#[derive(Debug, Default)]
+pub(super) struct SnapshotReport {
+    pub files: usize,
+    pub databases: usize,
+    pub bytes: u64,
+}
+
+#[derive(Debug, Default)]
+pub(super) struct RestoreReport {
+    pub files: usize,
+    /// The service was running, so it was stopped for the restore and started again.
+    pub restarted: bool,
+}
+
+/// Where archive members live on this host.
+struct Roots {
+    config: PathBuf,
+    state_dir: PathBuf,
+    /// `cold_storage` pack directory when it is enabled and outside the state directory.
+    cold: Option<PathBuf>,
+    /// SQLite DBs outside the state directory, by file name.
+    external_dbs: BTreeMap<String, PathBuf>,
+}
+
+impl Roots {
+    fn new(args: &Args, ctx: &CtlContext) -> Self {
+        let mut external_dbs = BTreeMap::new();
+        for db in [
+            ctx.db_path.clone(),
+            ctx.config.sharing_db_path(&ctx.state_dir),
+        ] {
+            if db.starts_with(&ctx.state_dir) {
+                continue;
+            }
+            if let Some(name) = db.file_name() {
+                external_dbs.insert(name.to_string_lossy().into_owned(), db);
+            }
+        }
+        let cold = chatmail_state::storage_policy(&ctx.config, &ctx.state_dir)
+            .cold
+            .map(|cold| cold.root)
+            .filter(|root| !root.starts_with(&ctx.state_dir));
+        Self {
+            config: args.config.clone(),
+            state_dir: ctx.state_dir.clone(),
+            cold,
+            external_dbs,
+        }
+    }
+
+    /// Host path of an archive member; `None` for anything outside the layout, including
+    /// absolute paths and `..`.
+    fn target(&self, member: &str) -> Option<PathBuf> {
+        let (root, rest) = member.split_once('/')?;
+        let rest = rest.trim_end_matches('/');
+        let rel = Path::new(rest);
+        if rest.is_empty() || !rel.components().all(|c| matches!(c, Component::Normal(_))) {
+            return None;
+        }
+        match root {
+            "config" if rel.components().count() == 1 => Some(self.config.clone()),
+            "state" => Some(self.state_dir.join(rel)),
+            "cold" => self.cold.as_ref().map(|cold| cold.join(rel)),
+            "db" => self.external_dbs.get(rest).cloned(),
+            _ => None,
+        }
+    }
+}
+
+/// Write the config file, every SQLite DB, the state directory and an outside cold storage
+/// directory to `output`.
+pub(super) async fn snapshot(args: &Args, output: &Path) -> Result<SnapshotReport> {
+    let ctx = CtlContext::from_args(args)?;
+    if ctx.database.is_postgres() {
+        return Err(ChatmailError::config(
+            "backup snapshot copies SQLite databases only; dump Postgres with pg_dump",
+        ));
+    }
+    let roots = Roots::new(args, &ctx);
+    let file = File::create(output)
+        .map_err(|e| ChatmailError::config(format!("cannot create {}: {e}", output.display())))?;
+    // The archive and its scratch file may sit inside the state directory.
+    let output = fs::canonicalize(output)?;
+    let staging = staging_path(&output);
+    let skip = [output, staging.clone()];
+    let mut writer = SnapshotWriter {
+        tar: tar::Builder::new(GzEncoder::new(BufWriter::new(file), Compression::default())),
+        staging,
+        files: Vec::new(),
+        report: SnapshotReport::default(),
+    };
+
+    if roots.config.is_file() {
+        let name = roots
+            .config
+            .file_name()
+            .unwrap_or_default()
+            .to_string_lossy();
+        writer
+            .add_file(&roots.config, &format!("config/{name}"))
+            .await?;
+    }
+    let trees = [
+        ("state", Some(&roots.state_dir)),
+        ("cold", roots.cold.as_ref()),
+    ];
+    for (prefix, root) in trees {
+        let Some(root) = root else {
+            continue;
+        };
+        for (rel, is_dir) in walk(root, &skip)? {
+            let member = format!("{prefix}/{}", rel.to_string_lossy());
+            if is_dir {
+                writer.add_dir(&root.join(&rel), &member)?;
+            } else {
+                writer.add_file(&root.join(&rel), &member).await?;
+            }
+        }
+    }
+    for (name, db) in &roots.external_dbs {
+        if db.is_file() {
+            writer.add_file(db, &format!("db/{name}")).await?;
+        }
+    }
+    writer.finish()
+}
+
+struct SnapshotWriter<W: Write> {
+    tar: tar::Builder<W>,
+    /// Scratch file next to the archive that `VACUUM INTO` writes to.
+    staging: PathBuf,
+    files: Vec<ManifestFile>,
+    report: SnapshotReport,
+}
+
+impl<W: Write> SnapshotWriter<W> {
+    async fn add_file(&mut self, path: &Path, member: &str) -> Result<()> {
+        let meta = match fs::metadata(path) {
+            Ok(meta) => meta,
+            // Removed since the directory was read (expunged message, rotated file).
+            Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(()),
+            Err(e) => return Err(e.into()),
+        };
+        let database = is_sqlite(path);
+        if database {
+            vacuum_into(path, &self.staging).await?;
+        }
+        let source = if database { &self.staging } else { path };
+        let result = self.append(source, &meta, member);
+        if database {
+            let _ = fs::remove_file(&self.staging);
+            self.report.databases += 1;
+        }
+        result
+    }
+
+    /// Stream `source` into the archive under `member`, with the owner, mode and mtime of
+    /// `meta` (the live file, which for a DB is not the `VACUUM INTO` copy).
+    fn append(&mut self, source: &Path, meta: &fs::Metadata, member: &str) -> Result<()> {
+        let file = File::open(source)?;
+        let size = file.metadata()?.len();
+        let mut header = tar::Header::new_gnu();
+        header.set_metadata_in_mode(meta, tar::HeaderMode::Complete);
+        header.set_size(size);
+        let mut reader = HashingReader::new(file.take(size));
+        self.tar.append_data(&mut header, member, &mut reader)?;
+        if reader.len != size {
+            return Err(ChatmailError::config(format!(
+                "{} shrank while it was being archived; run the snapshot again",
+                source.display()
+            )));
+        }
+        self.files.push(ManifestFile {
+            path: member.to_string(),
+            size,
+            sha256: hex::encode(reader.hasher.finalize()),
+        });
+        self.report.files += 1;
+        self.report.bytes += size;
+        Ok(())
+    }
+
+    fn add_dir(&mut self, path: &Path, member: &str) -> Result<()> {
+        let meta = fs::metadata(path)?;
+        let mut header = tar::Header::new_gnu();
+        header.set_metadata_in_mode(&meta, tar::HeaderMode::Complete);
+        header.set_size(0);
+        self.tar
+            .append_data(&mut header, format!("{member}/"), io::empty())?;
+        Ok(())
+    }
+
+    fn finish(mut self) -> Result<SnapshotReport> {
+        let manifest = Manifest {
+            format: FORMAT,
+            created_at: unix_now(),
+            files: std::mem::take(&mut self.files),
+        };
+        let data = serde_json::to_vec_pretty(&manifest)
+            .map_err(|e| ChatmailError::config(e.to_string()))?;
+        let mut header = tar::Header::new_gnu();
+        header.set_size(data.len() as u64);
+        header.set_mode(0o600);
+        header.set_mtime(manifest.created_at.max(0) as u64);
+        self.tar
+            .append_data(&mut header, MANIFEST, data.as_slice())?;
+        self.tar
+            .into_inner()
+            .and_then(|gz| gz.finish())
+            .and_then(|mut w| w.flush())?;
+        Ok(self.report)
+    }
+}
+
+/// Check `input` against its manifest, then write every member back to this host's config
+/// path and state directory. A running service is refused unless `force` is set, in which
+/// case it is stopped for the restore and started again.
+pub(super) fn restore(args: &Args, input: &Path, force: bool) -> Result<RestoreReport> {
+    let ctx = CtlContext::from_args(args)?;
+    let roots = Roots::new(args, &ctx);
+    verify(input, &roots)?;
+
+    let service = format!("{}.service", argv_binary_name());
+    let running = service_active(&service);
+    if running && !force {
+        return Err(ChatmailError::config(format!(
+            "{service} is running; stop it first, or pass --force to stop it, restore and \
+             start it again"
+        )));
+    }
+    if running {
+        systemctl(&["stop", &service])?;
+    }
+    // The service goes back up even when extraction stopped half way.
+    let extracted = extract(input, &roots);
+    let started = if running {
+        systemctl(&["start", &service])
+    } else {
+        Ok(())
+    };
+    match (extracted, started) {
+        (Ok(files), Ok(())) => Ok(RestoreReport {
+            files,
+            restarted: running,
+        }),
+        (Ok(_), Err(e)) => Err(e),
+        (Err(e), Ok(())) if running => Err(ChatmailError::config(format!(
+            "restore failed part way: {}; {service} was started again",
+            message(&e)
+        ))),
+        (Err(e), Ok(())) => Err(e),
+        (Err(e), Err(start)) => Err(ChatmailError::config(format!(
+            "restore failed part way: {}; starting {service} again failed too: {}",
+            message(&e),
+            message(&start)
+        ))),
+    }
+}
+
+/// Error text without the `configuration error:` prefix, for joining two errors.
+fn message(e: &ChatmailError) -> String {
+    match e {
+        ChatmailError::Config(msg) => msg.clone(),
+        other => other.to_string(),
+    }
+}
+
+/// Hash every member and compare with `snapshot.json`; nothing is written.
+fn verify(input: &Path, roots: &Roots) -> Result<()> {
+    let mut archive = open_archive(input)?;
+    let mut manifest: Option<Manifest> = None;
+    let mut seen: BTreeMap<String, (u64, String)> = BTreeMap::new();
+    for entry in archive.entries().map_err(corrupt)? {
+        let mut entry = entry.map_err(corrupt)?;
+        let member = member_name(&entry)?;
+        if member == MANIFEST {
+            manifest = Some(serde_json::from_reader(&mut entry).map_err(corrupt)?);
+            continue;
+        }
+        if member == ACCOUNT_MANIFEST {
+            return Err(ChatmailError::config(format!(
+                "{} is an account backup; restore it with `backup import`",
+                input.display()
+            )));
+        }
+        if roots.target(&member).is_none() {
+            return Err(no_place(&member));
+        }
+        match entry.header().entry_type() {
+            kind if kind.is_dir() => {}
+            kind if kind.is_file() => {
+                let mut reader = HashingReader::new(&mut entry);
+                io::copy(&mut reader, &mut io::sink()).map_err(corrupt)?;
+                let digest = hex::encode(reader.hasher.finalize());
+                seen.insert(member, (reader.len, digest));
+            }
+            other => return Err(corrupt(format!("unexpected {other:?} entry {member}"))),
+        }
+    }
+    let Some(manifest) = manifest else {
+        return Err(ChatmailError::config(format!(
+            "{} is not a madmail snapshot (no {MANIFEST})",
+            input.display()
+        )));
+    };
+    if manifest.format != FORMAT {
+        return Err(ChatmailError::config(format!(
+            "unsupported snapshot format {} (expected {FORMAT})",
+            manifest.format
+        )));
+    }
+    for file in &manifest.files {
+        match seen.remove(&file.path) {
+            Some((size, digest)) if size == file.size && digest == file.sha256 => {}
+            Some(_) => return Err(corrupt(format!("checksum mismatch for {}", file.path))),
+            None => return Err(corrupt(format!("{} is missing", file.path))),
+        }
+    }
+    if let Some(extra) = seen.keys().next() {
+        return Err(corrupt(format!("{extra} is not listed in {MANIFEST}")));
+    }
+    Ok(())
+}
+
+/// Write every member in place. Files go through a temporary sibling and a rename, and a
+/// restored database loses the journal files of the one it replaces.
+fn extract(input: &Path, roots: &Roots) -> Result<usize> {
+    let mut archive = open_archive(input)?;
+    let mut files = 0;
+    for entry in archive.entries().map_err(corrupt)? {
+        let mut entry = entry.map_err(corrupt)?;
+        let member = member_name(&entry)?;
+        if member == MANIFEST {
+            continue;
+        }
+        let target = roots.target(&member).ok_or_else(|| no_place(&member))?;
+        let header = entry.header().clone();
+        if header.entry_type().is_dir() {
+            fs::create_dir_all(&target)?;
+            set_owner_and_mode(&target, &header)?;
+            continue;
+        }
+        if let Some(parent) = target.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        let tmp = staging_path(&target);
+        {
+            let mut file = File::create(&tmp)?;
+            io::copy(&mut entry, &mut file)?;
+            file.sync_all()?;
+        }
+        set_owner_and_mode(&tmp, &header)?;
+        if is_sqlite(&tmp) {
+            for suffix in SQLITE_SIDECARS {
+                let mut sidecar = target.as_os_str().to_owned();
+                sidecar.push(suffix);
+                match fs::remove_file(PathBuf::from(sidecar)) {
+                    Err(e) if e.kind() != io::ErrorKind::NotFound => return Err(e.into()),
+                    _ => {}
+                }
+            }
+        }
+        fs::rename(&tmp, &target)?;
+        files += 1;
+    }
+    Ok(files)
+}
+
+/// Entries below `root`, parents before children, sorted. Symlinks, sockets, SQLite journal
+/// files and the paths in `skip` are left out.
+fn walk(root: &Path, skip: &[PathBuf]) -> Result<Vec<(PathBuf, bool)>> {
+    let root = fs::canonicalize(root).unwrap_or_else(|_| root.to_path_buf());
+    let mut out = Vec::new();
+    let mut pending = vec![PathBuf::new()];
+    while let Some(rel) = pending.pop() {
+        let dir = root.join(&rel);
+        let read_dir = match fs::read_dir(&dir) {
+            Ok(rd) => rd,
+            Err(e) if e.kind() == io::ErrorKind::NotFound => continue,
+            Err(e) => return Err(e.into()),
+        };
+        let mut children = Vec::new();
+        for entry in read_dir {
+            let entry = entry?;
+            let path = entry.path();
+            if skip.iter().any(|s| *s == path) || is_sqlite_sidecar(&path) {
+                continue;
+            }
+            let kind = entry.file_type()?;
+            if kind.is_dir() || kind.is_file() {
+                children.push((rel.join(entry.file_name()), kind.is_dir()));
+            }
+        }
+        children.sort();
+        for (child, is_dir) in children.iter().rev() {
+            if *is_dir {
+                pending.push(child.clone());
+            }
+        }
+        out.extend(children);
+    }
+    Ok(out)
+}
+
+fn is_sqlite(path: &Path) -> bool {
+    let mut magic = [0u8; 16];
+    File::open(path)
+        .and_then(|mut f| f.read_exact(&mut magic))
+        .is_ok_and(|()| &magic == SQLITE_MAGIC)
+}
+
+fn is_sqlite_sidecar(path: &Path) -> bool {
+    let name = path.as_os_str().to_string_lossy();
+    SQLITE_SIDECARS.iter().any(|suffix| {
+        name.strip_suffix(suffix)
+            .is_some_and(|db| is_sqlite(Path::new(db)))
+    })
+}
+
+/// `.{name}.snapshot-tmp` next to `path`.
+fn staging_path(path: &Path) -> PathBuf {
+    let name = path.file_name().unwrap_or_default().to_string_lossy();
+    path.with_file_name(format!(".{name}.snapshot-tmp"))
+}
+
+#[cfg(unix)]
+fn set_owner_and_mode(path: &Path, header: &tar::Header) -> Result<()> {
+    use std::os::unix::fs::PermissionsExt;
+
+    if let Ok(mode) = header.mode() {
+        fs::set_permissions(path, fs::Permissions::from_mode(mode & 0o7777))?;
+    }
+    // Only root can hand files back to the service account.
+    if unsafe { libc::geteuid() } == 0 {
+        let uid = header.uid().ok().and_then(|v| u32::try_from(v).ok());
+        let gid = header.gid().ok().and_then(|v| u32::try_from(v).ok());
+        std::os::unix::fs::chown(path, uid, gid)?;
+    }
+    Ok(())
+}
+
+#[cfg(not(unix))]
+fn set_owner_and_mode(_path: &Path, _header: &tar::Header) -> Result<()> {
+    Ok(())
+}
+
+fn service_active(service: &str) -> bool {
+    Command::new("systemctl")
+        .args(["is-active", "--quiet", service])
+        .status()
+        .is_ok_and(|s| s.success())
+}
+
+fn systemctl(args: &[&str]) -> Result<()> {
+    let status = Command::new("systemctl")
+        .args(args)
+        .status()
+        .map_err(|e| ChatmailError::config(format!("systemctl {}: {e}", args.join(" "))))?;
+    if !status.success() {
+        return Err(ChatmailError::config(format!(
+            "systemctl {} failed",
+            args.join(" ")
+        )));
+    }
+    Ok(())
+}
+
+fn open_archive(input: &Path) -> Result<tar::Archive<GzDecoder<BufReader<File>>>> {
+    let file = File::open(input)
+        .map_err(|e| ChatmailError::config(format!("cannot open {}: {e}", input.display())))?;
+    Ok(tar::Archive::new(GzDecoder::new(BufReader::new(file))))
+}
+
+fn member_name<R: Read>(entry: &tar::Entry<R>) -> Result<String> {
+    let path = entry.path().map_err(corrupt)?;
+    path.to_str()
+        .map(str::to_string)
+        .ok_or_else(|| corrupt(format!("non UTF-8 entry {}", path.display())))
+}
+
+struct HashingReader<R> {
+    inner: R,
+    hasher: Sha256,
+    len: u64,
+}
+
+impl<R> HashingReader<R> {
+    fn new(inner: R) -> Self {
+        Self {
+            inner,
+            hasher: Sha256::new(),
+            len: 0,
+        }
+    }
+}
+
+impl<R: Read> Read for HashingReader<R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let n = self.inner.read(buf)?;
+        self.hasher.update(&buf[..n]);
+        self.len += n as u64;
+        Ok(n)
+    }
+}
+
+fn corrupt(e: impl std::fmt::Display) -> ChatmailError {
+    ChatmailError::config(format!("corrupt snapshot archive: {e}"))
+}
+
+fn no_place(member: &str) -> ChatmailError {
+    corrupt(format!(
+        "no place for {member} on this host (restore with the snapshot's --config)"
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn roots(cold: Option<&str>) -> Roots {
+        Roots {
+            config: PathBuf::from("/etc/madmail/madmail.conf"),
+            state_dir: PathBuf::from("/var/lib/madmail"),
+            cold: cold.map(PathBuf::from),
+            external_dbs: BTreeMap::from([(
+                "credentials.db".to_string(),
+                PathBuf::from("/srv/db/credentials.db"),
+            )]),
+        }
+    }
+
+    #[test]
+    fn members_map_into_their_roots() {
+        let roots = roots(Some("/srv/cold"));
+        assert_eq!(
+            roots.target("config/madmail.conf"),
+            Some(PathBuf::from("/etc/madmail/madmail.conf"))
+        );
+        assert_eq!(
+            roots.target("state/mail/a@x.org/cur/1:2,S"),
+            Some(PathBuf::from("/var/lib/madmail/mail/a@x.org/cur/1:2,S"))
+        );
+        assert_eq!(
+            roots.target("cold/a@x.org/0001.pack"),
+            Some(PathBuf::from("/srv/cold/a@x.org/0001.pack"))
+        );
+        assert_eq!(
+            roots.target("db/credentials.db"),
+            Some(PathBuf::from("/srv/db/credentials.db"))
+        );
+        for member in [
+            "cold/../etc/passwd",
+            "state//etc/shadow",
+            "db/other.db",
+            "x/y",
+        ] {
+            assert_eq!(roots.target(member), None, "{member}");
+        }
+    }
+
+    #[test]
+    fn cold_members_need_an_outside_cold_root() {
+        assert_eq!(roots(None).target("cold/a@x.org/0001.pack"), None);
+    }
+}
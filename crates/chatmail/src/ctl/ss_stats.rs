@@ -0,0 +1,93 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail ss-stats` — Shadowsocks relay traffic of the running server
+//! (GET `/admin/ss-stats`; the counters live in the server process only).
+
+use chatmail_config::Args;
+use chatmail_types::Result;
+use serde_json::Value;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use super::request_reload::admin_get;
+
+pub async fn ss_stats(args: &Args, url_override: Option<&str>, insecure: bool) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    ctx.require_db()?;
+    let out = CtlOut::from_args(args, "ss-stats");
+
+    let body = admin_get(&ctx, url_override, insecure, "/admin/ss-stats").await?;
+    if out.is_json() {
+        return out.emit(body);
+    }
+    for line in format_stats(&body) {
+        out.line(line);
+    }
+    Ok(())
+}
+
+fn format_stats(body: &Value) -> Vec<String> {
+    let num = |key: &str| body[key].as_u64().unwrap_or(0);
+    let rows = [
+        ("Bytes in", format_bytes(num("bytes_in"))),
+        ("Bytes out", format_bytes(num("bytes_out"))),
+        ("Active connections", num("active_connections").to_string()),
+        ("Total connections", num("total_connections").to_string()),
+    ];
+    let mut lines = vec!["[ SHADOWSOCKS ] relay traffic since start".to_string()];
+    for (label, value) in rows {
+        lines.push(format!("{label:<20}{value}"));
+    }
+    lines
+}
+
+/// `1536` → `1536 (1.5 KiB)`: the exact count first, then a readable size.
+fn format_bytes(bytes: u64) -> String {
+    const UNITS: [&str; 4] = ["KiB", "MiB", "GiB", "TiB"];
+    if bytes < 1024 {
+        return bytes.to_string();
+    }
+    let mut value = bytes as f64 / 1024.0;
+    let mut unit = 0;
+    while value >= 1024.0 && unit + 1 < UNITS.len() {
+        value /= 1024.0;
+        unit += 1;
+    }
+    format!("{bytes} ({value:.1} {})", UNITS[unit])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn format_lists_counters() {
+        let body = serde_json::json!({
+            "bytes_in": 1536,
+            "bytes_out": 42,
+            "active_connections": 2,
+            "total_connections": 9,
+        });
+        let lines = format_stats(&body);
+        assert_eq!(lines[0], "[ SHADOWSOCKS ] relay traffic since start");
+        assert_eq!(lines[1], "Bytes in            1536 (1.5 KiB)");
+        assert_eq!(lines[2], "Bytes out           42");
+        assert_eq!(lines[3], "Active connections  2");
+        assert_eq!(lines[4], "Total connections   9");
+    }
+}
@@ -0,0 +1,240 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! `chatmail storage migrate` — copy accounts into another mail root while serving.
+//!
+//! Progress is mirrored to `{from}/storage-migrate.json` so `/admin/storage/migrate` can
+//! show it while the copy runs.
+
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use chatmail_config::cli::{StorageCommand, StorageMigrateArgs};
+use chatmail_config::Args;
+use chatmail_db::{copy_quota_row, init_db, passwords, DbPool};
+use chatmail_state::storage_policy;
+use chatmail_storage::{migrate_user, MailboxStore, MigrateOptions, StoragePolicy, PROGRESS_FILE};
+use chatmail_types::{ChatmailError, Result};
+use serde::Serialize;
+use tokio::sync::Semaphore;
+use tokio::task::JoinSet;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+
+/// Rewrite the progress file at most this often per account (plus on every account end).
+const PROGRESS_EVERY: usize = 100;
+
+#[derive(Debug, Default, Serialize)]
+struct Progress {
+    state: &'static str,
+    from: PathBuf,
+    to: PathBuf,
+    started_at: i64,
+    updated_at: i64,
+    accounts_total: usize,
+    accounts_done: usize,
+    accounts_skipped: usize,
+    messages_copied: usize,
+    current: Vec<String>,
+    failed: Vec<Failure>,
+}
+
+#[derive(Debug, Serialize)]
+struct Failure {
+    user: String,
+    error: String,
+}
+
+struct Tracker {
+    path: PathBuf,
+    progress: Mutex<Progress>,
+}
+
+impl Tracker {
+    fn update(&self, f: impl FnOnce(&mut Progress)) {
+        let mut p = self.progress.lock().unwrap_or_else(|e| e.into_inner());
+        f(&mut p);
+        p.updated_at = chatmail_db::policies::unix_now();
+        let tmp = self.path.with_extension("json.tmp");
+        let written = serde_json::to_vec_pretty(&*p)
+            .map_err(std::io::Error::other)
+            .and_then(|b| std::fs::write(&tmp, b))
+            .and_then(|()| std::fs::rename(&tmp, &self.path));
+        if let Err(e) = written {
+            tracing::warn!(error = %e, "storage migrate: cannot write progress file");
+        }
+    }
+}
+
+pub async fn storage(args: &Args, cmd: &StorageCommand) -> Result<()> {
+    match cmd {
+        StorageCommand::Migrate(m) => migrate(args, m).await,
+    }
+}
+
+async fn migrate(args: &Args, m: &StorageMigrateArgs) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "storage");
+    let from = m.from.clone().unwrap_or_else(|| ctx.state_dir.clone());
+    let pool = ctx.open_pool().await?;
+    let users = match &m.user {
+        Some(u) => vec![u.clone()],
+        None => passwords::list_users(&pool).await?,
+    };
+    let dst_pool = open_destination_db(&m.to).await?;
+
+    // Cold mail is read through the source's packs and lands hot in the destination.
+    let src = MailboxStore::with_policy(
+        &from,
+        StoragePolicy {
+            cold: storage_policy(&ctx.config, &from).cold,
+            ..StoragePolicy::default()
+        },
+    );
+    let dst = MailboxStore::with_policy(&m.to, StoragePolicy::default());
+    let opts = MigrateOptions {
+        verify_sample: m.verify_sample,
+        throttle: Duration::from_millis(m.throttle_ms),
+        redirect_note: m
+            .mark_source
+            .then(|| format!("migrated to {}\n", m.to.display())),
+        force: m.force,
+    };
+
+    let tracker = Arc::new(Tracker {
+        path: from.join(PROGRESS_FILE),
+        progress: Mutex::new(Progress {
+            state: "running",
+            from: from.clone(),
+            to: m.to.clone(),
+            started_at: chatmail_db::policies::unix_now(),
+            accounts_total: users.len(),
+            ..Default::default()
+        }),
+    });
+    tracker.update(|_| {});
+
+    let permits = Arc::new(Semaphore::new(usize::from(m.parallel)));
+    let mut tasks = JoinSet::new();
+    for user in users {
+        let permit = Arc::clone(&permits)
+            .acquire_owned()
+            .await
+            .map_err(|e| ChatmailError::storage(e.to_string()))?;
+        let (src, dst, opts) = (src.clone(), dst.clone(), opts.clone());
+        let (tracker, pool, dst_pool) = (Arc::clone(&tracker), pool.clone(), dst_pool.clone());
+        tasks.spawn(async move {
+            let _permit = permit;
+            tracker.update(|p| p.current.push(user.clone()));
+            let result =
+                migrate_account(&src, &dst, &user, &opts, &pool, dst_pool.as_ref(), &tracker).await;
+            tracker.update(|p| {
+                p.current.retain(|u| u != &user);
+                match &result {
+                    Ok(true) => p.accounts_done += 1,
+                    Ok(false) => p.accounts_skipped += 1,
+                    Err(e) => p.failed.push(Failure {
+                        user: user.clone(),
+                        error: e.to_string(),
+                    }),
+                }
+            });
+            (user, result)
+        });
+    }
+    while let Some(joined) = tasks.join_next().await {
+        let (user, result) = joined.map_err(|e| ChatmailError::storage(e.to_string()))?;
+        match result {
+            Ok(true) => out.line(format!("migrated {user}")),
+            Ok(false) => out.line(format!("skipped {user} (already migrated)")),
+            Err(e) => out.line(format!("FAILED {user}: {e}")),
+        }
+    }
+
+    let failed = tracker
+        .progress
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .failed
+        .len();
+    tracker.update(|p| p.state = if failed == 0 { "done" } else { "failed" });
+    let summary = {
+        let p = tracker.progress.lock().unwrap_or_else(|e| e.into_inner());
+        serde_json::json!({
+            "from": p.from,
+            "to": p.to,
+            "accounts_total": p.accounts_total,
+            "accounts_done": p.accounts_done,
+            "accounts_skipped": p.accounts_skipped,
+            "messages_copied": p.messages_copied,
+            "failed": p.failed.iter().map(|f| &f.user).collect::<Vec<_>>(),
+        })
+    };
+    if failed > 0 {
+        if out.is_json() {
+            out.emit(summary)?;
+        }
+        return Err(ChatmailError::storage(format!(
+            "{failed} account(s) failed; rerun to resume"
+        )));
+    }
+    out.done(
+        format!(
+            "Success: {} migrated, {} already done, {} messages copied.",
+            summary["accounts_done"], summary["accounts_skipped"], summary["messages_copied"]
+        ),
+        summary,
+    )
+}
+
+/// Copy one account plus its quota row. `Ok(false)` when it was already migrated.
+async fn migrate_account(
+    src: &MailboxStore,
+    dst: &MailboxStore,
+    user: &str,
+    opts: &MigrateOptions,
+    pool: &DbPool,
+    dst_pool: Option<&DbPool>,
+    tracker: &Tracker,
+) -> Result<bool> {
+    let mut reported = 0usize;
+    let report = migrate_user(src, dst, user, opts, |copied| {
+        if copied - reported >= PROGRESS_EVERY {
+            let delta = copied - reported;
+            reported = copied;
+            tracker.update(|p| p.messages_copied += delta);
+        }
+    })
+    .await?;
+    let rest = report.copied() - reported;
+    tracker.update(|p| p.messages_copied += rest);
+    if let Some(dst_pool) = dst_pool {
+        copy_quota_row(pool, dst_pool, user).await?;
+    }
+    Ok(!report.already_migrated)
+}
+
+/// The destination's own SQLite DB when `--to` is a full state dir.
+async fn open_destination_db(to: &Path) -> Result<Option<DbPool>> {
+    let path = to.join("chatmail.db");
+    if path.is_file() {
+        Ok(Some(init_db(&path).await?))
+    } else {
+        Ok(None)
+    }
+}
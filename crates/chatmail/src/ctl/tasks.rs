@@ -18,6 +18,7 @@
 //! `chatmail tasks` — run scheduled maintenance jobs on demand.
 
 use chatmail_config::{Args, TasksCommand};
+use chatmail_state::storage_policy;
 use chatmail_storage::MailboxStore;
 use chatmail_tasks::{
     parse_retention_arg, run_all_configured, run_task, MaintenanceConfig, TaskContext, TaskId,
@@ -32,12 +33,18 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
     let ctx = CtlContext::from_args(args)?;
     ctx.require_db()?;
     let pool = ctx.open_pool().await?;
-    let mailbox = MailboxStore::new(&ctx.state_dir);
+    let mailbox = MailboxStore::with_policy(
+        ctx.state_dir.clone(),
+        storage_policy(&ctx.config, &ctx.state_dir),
+    );
     let maintenance = MaintenanceConfig::from_runtime(&pool, &ctx.config).await?;
     let task_ctx = TaskContext {
         pool: &pool,
         mailbox: &mailbox,
         maintenance: &maintenance,
+        active_deliveries: 0,
+        webhooks: None,
+        quota: None,
     };
     let out = CtlOut::from_args(args, "tasks");
 
@@ -57,7 +64,10 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
                     "tasks": jobs,
                     "message_retention": maintenance.message_retention.map(|r| format!("{r:?}")),
                     "unused_account_retention": maintenance.unused_account_retention.map(|r| format!("{r:?}")),
+                    "inactive_account_retention": maintenance.inactive_account_retention.map(|r| format!("{r:?}")),
+                    "inactive_account_action": format!("{:?}", maintenance.inactive_account_action).to_ascii_lowercase(),
                     "periodic_jobs_enabled": maintenance.periodic_jobs_enabled(),
+                    "db_maintenance_interval": maintenance.db_maintenance_interval.map(|r| format!("{r:?}")),
                 }));
             }
             out.line("Maintenance jobs (also run on a schedule when `chatmail run` is active):\n");
@@ -65,6 +75,9 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
                 let enabled = match id {
                     TaskId::PruneOldMessages => maintenance.message_retention.is_some(),
                     TaskId::PruneUnusedAccounts => maintenance.unused_account_retention.is_some(),
+                    TaskId::PruneInactiveAccounts => {
+                        maintenance.inactive_account_retention.is_some()
+                    }
                     TaskId::PurgeSeenMessages => {
                         out.line(format!(
                             "  {} — {} (in-process every 15s when __AUTO_PURGE_SEEN__=enabled)",
@@ -75,11 +88,19 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
                     }
                     TaskId::PruneUnreadOlder => false,
                     TaskId::RenewCertificate => ctx.config.tls_mode.as_deref() == Some("autocert"),
+                    TaskId::DbMaintenance => maintenance.db_maintenance_interval.is_some(),
+                    TaskId::ColdStorage => mailbox.policy().cold.is_some(),
                 };
                 let cfg_note = match id {
                     TaskId::RenewCertificate if enabled => {
                         " [enabled — tls_mode autocert; every 24h]"
                     }
+                    TaskId::DbMaintenance if enabled => {
+                        " [enabled — storage.imapsql db_maintenance_schedule]"
+                    }
+                    TaskId::ColdStorage if enabled => {
+                        " [enabled — storage.imapsql cold_storage; hourly]"
+                    }
                     _ if enabled => " [enabled — DB or maddy.conf]",
                     _ => "",
                 };
@@ -97,6 +118,18 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
             if let Some(r) = maintenance.unused_account_retention {
                 out.line(format!("storage.imapsql unused_account_retention: {:?}", r));
             }
+            if let Some(r) = maintenance.inactive_account_retention {
+                out.line(format!(
+                    "storage.imapsql inactive_account_retention: {:?} ({:?})",
+                    r, maintenance.inactive_account_action
+                ));
+            }
+            if let Some(r) = maintenance.db_maintenance_interval {
+                out.line(format!(
+                    "storage.imapsql db_maintenance_schedule: every {:?} (skipped above {} deliveries in flight)",
+                    r, maintenance.db_maintenance_max_deliveries
+                ));
+            }
             if !maintenance.periodic_jobs_enabled() {
                 out.line(
                     "No periodic retention jobs — enable message retention in admin UI or set retention / unused_account_retention in maddy.conf",
@@ -142,6 +175,7 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
                     "task": id.name(),
                     "skipped": outcome.skipped,
                     "deleted": outcome.deleted,
+                    "reclaimed_bytes": outcome.reclaimed_bytes,
                     "detail": outcome.detail,
                 }));
             }
@@ -151,6 +185,13 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
                     id.name(),
                     outcome.detail.unwrap_or_default()
                 ));
+            } else if matches!(id, TaskId::DbMaintenance | TaskId::ColdStorage) {
+                out.line(format!(
+                    "{}: reclaimed {} byte(s) ({})",
+                    id.name(),
+                    outcome.reclaimed_bytes,
+                    outcome.detail.unwrap_or_default()
+                ));
             } else {
                 out.line(format!(
                     "{}: deleted {} item(s){}",
@@ -174,6 +215,7 @@ pub async fn tasks(args: &Args, cmd: &TasksCommand) -> Result<()> {
                             "task": o.task.name(),
                             "skipped": o.skipped,
                             "deleted": o.deleted,
+                            "reclaimed_bytes": o.reclaimed_bytes,
                             "detail": o.detail,
                         })
                     })
@@ -207,8 +249,11 @@ fn task_enabled(id: TaskId, maintenance: &MaintenanceConfig, ctx: &CtlContext) -
     match id {
         TaskId::PruneOldMessages => maintenance.message_retention.is_some(),
         TaskId::PruneUnusedAccounts => maintenance.unused_account_retention.is_some(),
+        TaskId::PruneInactiveAccounts => maintenance.inactive_account_retention.is_some(),
         TaskId::PurgeSeenMessages => false,
         TaskId::PruneUnreadOlder => false,
         TaskId::RenewCertificate => ctx.config.tls_mode.as_deref() == Some("autocert"),
+        TaskId::DbMaintenance => maintenance.db_maintenance_interval.is_some(),
+        TaskId::ColdStorage => ctx.config.cold_storage.enabled,
     }
 }
@@ -0,0 +1,180 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail theme` — validate and activate custom www themes.
+
+use chatmail_config::{Args, ThemeCommand};
+use chatmail_state::ReloadScope;
+use chatmail_types::{ChatmailError, Result};
+use chatmail_www::{validate_theme_dir, ThemeActivation, ThemeReport, ThemeStore};
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use super::request_reload::{request_soft_reload, SoftReloadOutcome};
+
+pub async fn theme(args: &Args, cmd: &ThemeCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "theme");
+    let store = ThemeStore::new(&ctx.state_dir);
+
+    match cmd {
+        ThemeCommand::Status => {
+            let active = store.current();
+            let previous = store.previous();
+            let installed = store.list();
+            if out.is_json() {
+                return out.emit(serde_json::json!({
+                    "active": active,
+                    "previous": previous,
+                    "installed": installed,
+                    "www_dir": ctx.config.www_dir.as_ref().map(|p| p.display().to_string()),
+                }));
+            }
+            match &active {
+                Some(id) => out.line(format!(
+                    "Active theme: {id} ({})",
+                    store.root().join(id).display()
+                )),
+                None => match &ctx.config.www_dir {
+                    Some(dir) => out.line(format!(
+                        "No theme installed; serving www_dir {}",
+                        dir.display()
+                    )),
+                    None => out.line("No theme installed; serving the embedded site"),
+                },
+            }
+            out.line(format!(
+                "Rollback target: {}",
+                previous.as_deref().unwrap_or("(none)")
+            ));
+            out.line(format!("Installed: {}", installed.len()));
+            Ok(())
+        }
+        ThemeCommand::Validate { dir } => {
+            let report = validate_theme_dir(dir)?;
+            if out.is_json() {
+                out.emit(serde_json::json!({
+                    "dir": dir.display().to_string(),
+                    "valid": report.is_ok(),
+                    "report": report,
+                }))?;
+            } else {
+                print_report(&out, &report);
+            }
+            if report.is_ok() {
+                Ok(())
+            } else {
+                Err(ChatmailError::config(format!(
+                    "theme {} has {} issue(s)",
+                    dir.display(),
+                    report.issues.len()
+                )))
+            }
+        }
+        ThemeCommand::Install { dir } => {
+            let activation = store.install_dir(dir)?;
+            activated(&ctx, &out, "Installed", &activation).await
+        }
+        ThemeCommand::Rollback => {
+            let activation = store.rollback()?;
+            activated(&ctx, &out, "Rolled back to", &activation).await
+        }
+        ThemeCommand::Deactivate => {
+            let Some(id) = store.deactivate()? else {
+                return out.done_msg(
+                    "No theme is active.",
+                    serde_json::json!({ "deactivated": null }),
+                    "No theme active",
+                );
+            };
+            let reloaded = reload_http_routes(&ctx).await;
+            out.done_msg(
+                format!(
+                    "Deactivated theme {id} (kept as rollback target).{}",
+                    reload_note(reloaded)
+                ),
+                serde_json::json!({ "deactivated": id, "reloaded": reloaded }),
+                format!("Deactivated {id}"),
+            )
+        }
+    }
+}
+
+fn print_report(out: &CtlOut, report: &ThemeReport) {
+    for issue in &report.issues {
+        out.line(format!("✗ {issue}"));
+    }
+    for skipped in &report.skipped {
+        out.line(format!(
+            "- {skipped}: not a theme file type; will not be installed"
+        ));
+    }
+    if report.is_ok() {
+        out.line(format!(
+            "✅ {} template(s) and {} asset(s) valid",
+            report.templates, report.assets
+        ));
+    }
+}
+
+async fn activated(
+    ctx: &CtlContext,
+    out: &CtlOut,
+    verb: &str,
+    activation: &ThemeActivation,
+) -> Result<()> {
+    if !out.is_json() {
+        for skipped in &activation.skipped {
+            out.line(format!("- skipped {skipped} (not a theme file type)"));
+        }
+    }
+    let reloaded = reload_http_routes(ctx).await;
+    out.done_msg(
+        format!(
+            "{verb} theme {} ({} template(s)); rollback target: {}.{}",
+            activation.id,
+            activation.templates,
+            activation.previous.as_deref().unwrap_or("none"),
+            reload_note(reloaded)
+        ),
+        serde_json::json!({
+            "active": activation.id,
+            "previous": activation.previous,
+            "templates": activation.templates,
+            "skipped": activation.skipped,
+            "reloaded": reloaded,
+        }),
+        format!("{verb} {}", activation.id),
+    )
+}
+
+/// Remount HTTP routes on a running server so the template cache picks up the
+/// new theme. The theme is already active on disk either way.
+async fn reload_http_routes(ctx: &CtlContext) -> bool {
+    matches!(
+        request_soft_reload(ctx, None, false, ReloadScope::HttpRoutes, true).await,
+        Ok(SoftReloadOutcome::Accepted { .. })
+    )
+}
+
+fn reload_note(reloaded: bool) -> &'static str {
+    if reloaded {
+        " Server reloaded."
+    } else {
+        " Server not reachable — applies on next start."
+    }
+}
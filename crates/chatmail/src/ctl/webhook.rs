@@ -0,0 +1,65 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `chatmail webhook test` — send a signed `ping` to `webhook_url`.
+//!
+//! One POST, no retries, so a misconfigured receiver shows up immediately. The running
+//! server sends the real events; the CLI's own account edits do not.
+
+use chatmail_config::{Args, WebhookCommand};
+use chatmail_state::{WebhookEvent, WebhookSender, Webhooks};
+use chatmail_types::{ChatmailError, Result};
+use serde_json::json;
+
+use super::context::CtlContext;
+use super::output::CtlOut;
+use crate::webhook::HttpWebhookSender;
+
+pub async fn webhook(args: &Args, cmd: &WebhookCommand) -> Result<()> {
+    let ctx = CtlContext::from_args(args)?;
+    let out = CtlOut::from_args(args, "webhook");
+
+    match cmd {
+        WebhookCommand::Test => {
+            let hooks = Webhooks::from_config(&ctx.config);
+            let Some(url) = hooks.url() else {
+                return Err(ChatmailError::config(
+                    "webhook_url is not set in the chatmail or storage.imapsql block",
+                ));
+            };
+            let delivery = hooks.delivery(
+                WebhookEvent::Ping,
+                json!({ "message": "madmail webhook test" }),
+            );
+            HttpWebhookSender::new()
+                .post(url, &delivery)
+                .await
+                .map_err(|e| ChatmailError::config(format!("webhook test to {url} failed: {e}")))?;
+            let signed = if hooks.is_signed() {
+                "signed"
+            } else {
+                "unsigned: webhook_secret not set"
+            };
+            out.done_msg(
+                format!("Success: ping delivered to {url} ({signed})."),
+                json!({ "url": url, "signed": hooks.is_signed() }),
+                format!("Ping delivered to {url}"),
+            )?;
+        }
+    }
+    Ok(())
+}
@@ -0,0 +1,171 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Forward Junk reclassifications to an external trainer (`junk_training_url`, rspamd
+//! controller compatible: `POST /learnspam` / `POST /learnham` with the raw message).
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use chatmail_state::{AppState, JunkDirection, JunkReclassifiedEvent};
+use chatmail_storage::read_blob;
+use tokio::sync::broadcast::error::RecvError;
+use tokio::task::JoinHandle;
+
+/// Attempts per message before the training signal is dropped.
+const MAX_ATTEMPTS: u32 = 4;
+/// First retry delay; doubles per attempt.
+const INITIAL_BACKOFF: Duration = Duration::from_secs(1);
+const REQUEST_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// `<base>/learnspam` or `<base>/learnham`.
+pub fn learn_url(base: &str, direction: JunkDirection) -> String {
+    format!("{}{}", base.trim_end_matches('/'), direction.learn_path())
+}
+
+/// Subscribe to Junk reclassifications and train asynchronously. Each event gets its own task,
+/// so IMAP STORE/MOVE only pay for the broadcast send.
+pub fn start_junk_trainer(app: Arc<AppState>, base_url: String) -> JoinHandle<()> {
+    let mut rx = app.events.subscribe_junk();
+    let client = reqwest::Client::builder()
+        .timeout(REQUEST_TIMEOUT)
+        .build()
+        .unwrap_or_default();
+    tokio::spawn(async move {
+        loop {
+            match rx.recv().await {
+                Ok(event) => {
+                    let app = Arc::clone(&app);
+                    let client = client.clone();
+                    let url = learn_url(&base_url, event.direction);
+                    tokio::spawn(async move {
+                        train(&app, &client, &url, &event).await;
+                    });
+                }
+                Err(RecvError::Lagged(skipped)) => {
+                    tracing::warn!(skipped, "junk trainer lagged; training signals dropped");
+                }
+                Err(RecvError::Closed) => break,
+            }
+        }
+    })
+}
+
+async fn train(app: &AppState, client: &reqwest::Client, url: &str, event: &JunkReclassifiedEvent) {
+    // The message may be expunged before we get here; nothing left to learn from.
+    let body = match read_blob(
+        &app.mailbox_store,
+        &event.username,
+        &event.mailbox,
+        &event.msg_id,
+    )
+    .await
+    {
+        Ok(b) => b,
+        Err(e) => {
+            tracing::debug!(msg_id = %event.msg_id, error = %e, "junk training: message gone");
+            return;
+        }
+    };
+    let mut backoff = INITIAL_BACKOFF;
+    for attempt in 1..=MAX_ATTEMPTS {
+        match client.post(url).body(body.clone()).send().await {
+            Ok(resp) if resp.status().is_success() => return,
+            Ok(resp) => {
+                tracing::debug!(attempt, status = %resp.status(), "junk trainer rejected message");
+            }
+            Err(e) => {
+                tracing::debug!(attempt, error = %e, "junk trainer unreachable");
+            }
+        }
+        if attempt < MAX_ATTEMPTS {
+            tokio::time::sleep(backoff).await;
+            backoff *= 2;
+        }
+    }
+    tracing::warn!(
+        direction = event.direction.as_str(),
+        "junk training failed after {MAX_ATTEMPTS} attempts"
+    );
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+    #[test]
+    fn learn_url_appends_rspamd_path() {
+        assert_eq!(
+            learn_url("http://127.0.0.1:11334/", JunkDirection::Spam),
+            "http://127.0.0.1:11334/learnspam"
+        );
+        assert_eq!(
+            learn_url("http://rspamd:11334", JunkDirection::Ham),
+            "http://rspamd:11334/learnham"
+        );
+    }
+
+    #[tokio::test]
+    async fn trainer_posts_raw_message() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool));
+        chatmail_storage::write_blob(
+            &app.mailbox_store,
+            "u@test",
+            "m1",
+            b"Subject: spam\r\n\r\nx",
+        )
+        .await
+        .unwrap();
+
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        let server = tokio::spawn(async move {
+            let (mut sock, _) = listener.accept().await.unwrap();
+            let mut acc = Vec::new();
+            let mut buf = [0u8; 4096];
+            while !acc.ends_with(b"\r\n\r\nx") {
+                let n = sock.read(&mut buf).await.unwrap();
+                if n == 0 {
+                    break;
+                }
+                acc.extend_from_slice(&buf[..n]);
+            }
+            sock.write_all(b"HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n")
+                .await
+                .unwrap();
+            String::from_utf8_lossy(&acc).into_owned()
+        });
+
+        let _trainer = start_junk_trainer(Arc::clone(&app), format!("http://{addr}"));
+        app.events.publish_junk_reclassified(JunkReclassifiedEvent {
+            username: "u@test".into(),
+            mailbox: "INBOX".into(),
+            msg_id: "m1".into(),
+            size: 19,
+            direction: JunkDirection::Spam,
+        });
+        let request = tokio::time::timeout(Duration::from_secs(5), server)
+            .await
+            .unwrap()
+            .unwrap();
+        assert!(request.starts_with("POST /learnspam "), "{request}");
+        assert!(request.ends_with("Subject: spam\r\n\r\nx"), "{request}");
+    }
+}
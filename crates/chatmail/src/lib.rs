@@ -17,9 +17,15 @@
 
 pub mod admin;
 pub mod boot;
+pub mod cdn_ranges;
+pub mod clock_check;
 pub mod ctl;
 pub mod iroh_boot;
+pub mod junk_trainer;
+pub mod log_json;
+pub mod log_rotate;
 pub mod logging;
+pub mod peer_refresh;
 #[cfg(feature = "pprof")]
 pub mod profiling;
 pub mod push_boot;
@@ -31,3 +37,4 @@ pub mod supervisor;
 pub mod tls_boot;
 pub mod turn_boot;
 pub mod upgrade;
+pub mod webhook;
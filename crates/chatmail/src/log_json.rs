@@ -0,0 +1,226 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! `log_format json` — one JSON object per line instead of the text format.
+//!
+//! Every record carries `time` (RFC 3339, UTC), `level`, `name` (the tracing target) and
+//! `msg`; the structured fields of the event (`remote = …`, `email = …`) become top-level
+//! keys next to them instead of being rendered into the message.
+
+use std::fmt;
+
+use chatmail_types::{ChatmailError, Result};
+use serde_json::{Map, Value};
+use time::format_description::well_known::Rfc3339;
+use time::OffsetDateTime;
+use tracing::field::{Field, Visit};
+use tracing::{Event, Subscriber};
+use tracing_subscriber::fmt::format::Writer;
+use tracing_subscriber::fmt::{FmtContext, FormatEvent, FormatFields};
+use tracing_subscriber::registry::LookupSpan;
+
+/// Keys every JSON record has; an event field of the same name is dropped.
+const RESERVED_KEYS: [&str; 4] = ["time", "level", "name", "msg"];
+
+/// The `log_format` directive.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum LogFormat {
+    /// Human-readable lines (default).
+    #[default]
+    Text,
+    /// Newline-delimited JSON objects.
+    Json,
+}
+
+impl LogFormat {
+    /// `text` / `json`; unset means `text`.
+    pub fn from_config(raw: Option<&str>) -> Result<Self> {
+        match raw.map(str::trim) {
+            None | Some("") => Ok(Self::Text),
+            Some(v) if v.eq_ignore_ascii_case("text") => Ok(Self::Text),
+            Some(v) if v.eq_ignore_ascii_case("json") => Ok(Self::Json),
+            Some(v) => Err(ChatmailError::config(format!(
+                "log_format must be text or json, got {v:?}"
+            ))),
+        }
+    }
+}
+
+/// [`FormatEvent`] for `log_format json`.
+pub struct JsonFormat;
+
+impl<S, N> FormatEvent<S, N> for JsonFormat
+where
+    S: Subscriber + for<'a> LookupSpan<'a>,
+    N: for<'a> FormatFields<'a> + 'static,
+{
+    fn format_event(
+        &self,
+        _ctx: &FmtContext<'_, S, N>,
+        mut writer: Writer<'_>,
+        event: &Event<'_>,
+    ) -> fmt::Result {
+        writeln!(writer, "{}", Value::Object(event_json(event)))
+    }
+}
+
+fn event_json(event: &Event<'_>) -> Map<String, Value> {
+    let meta = event.metadata();
+    let mut fields = FieldVisitor::default();
+    event.record(&mut fields);
+    let time = OffsetDateTime::now_utc()
+        .format(&Rfc3339)
+        .unwrap_or_default();
+    let mut record = Map::new();
+    record.insert("time".into(), time.into());
+    record.insert(
+        "level".into(),
+        meta.level().as_str().to_ascii_lowercase().into(),
+    );
+    record.insert("name".into(), meta.target().into());
+    record.insert("msg".into(), fields.msg.into());
+    for (key, value) in fields.fields {
+        if !RESERVED_KEYS.contains(&key.as_str()) {
+            record.insert(key, value);
+        }
+    }
+    record
+}
+
+/// Collects the message and the structured fields of one event.
+#[derive(Default)]
+struct FieldVisitor {
+    msg: String,
+    fields: Map<String, Value>,
+}
+
+impl FieldVisitor {
+    fn insert(&mut self, field: &Field, value: Value) {
+        if field.name() == "message" {
+            self.msg = match value {
+                Value::String(s) => s,
+                other => other.to_string(),
+            };
+        } else {
+            self.fields.insert(field.name().to_string(), value);
+        }
+    }
+}
+
+impl Visit for FieldVisitor {
+    fn record_str(&mut self, field: &Field, value: &str) {
+        self.insert(field, value.into());
+    }
+
+    fn record_bool(&mut self, field: &Field, value: bool) {
+        self.insert(field, value.into());
+    }
+
+    fn record_i64(&mut self, field: &Field, value: i64) {
+        self.insert(field, value.into());
+    }
+
+    fn record_u64(&mut self, field: &Field, value: u64) {
+        self.insert(field, value.into());
+    }
+
+    fn record_f64(&mut self, field: &Field, value: f64) {
+        self.insert(field, value.into());
+    }
+
+    fn record_error(&mut self, field: &Field, value: &(dyn std::error::Error + 'static)) {
+        self.insert(field, value.to_string().into());
+    }
+
+    fn record_debug(&mut self, field: &Field, value: &dyn fmt::Debug) {
+        self.insert(field, format!("{value:?}").into());
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::Write;
+    use std::sync::{Arc, Mutex};
+    use tracing_subscriber::layer::SubscriberExt;
+    use tracing_subscriber::{fmt, Registry};
+
+    #[derive(Clone, Default)]
+    struct Buffer(Arc<Mutex<Vec<u8>>>);
+
+    impl Write for Buffer {
+        fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+            self.0.lock().unwrap().extend_from_slice(buf);
+            Ok(buf.len())
+        }
+
+        fn flush(&mut self) -> std::io::Result<()> {
+            Ok(())
+        }
+    }
+
+    #[test]
+    fn log_format_directive() {
+        assert_eq!(LogFormat::from_config(None).unwrap(), LogFormat::Text);
+        assert_eq!(
+            LogFormat::from_config(Some("JSON")).unwrap(),
+            LogFormat::Json
+        );
+        assert_eq!(
+            LogFormat::from_config(Some("text")).unwrap(),
+            LogFormat::Text
+        );
+        assert!(LogFormat::from_config(Some("logfmt")).is_err());
+    }
+
+    #[test]
+    fn json_records_carry_fields_as_keys() {
+        let buf = Buffer::default();
+        let writer = buf.clone();
+        let subscriber = Registry::default().with(
+            fmt::layer()
+                .event_format(JsonFormat)
+                .with_writer(move || writer.clone()),
+        );
+        tracing::subscriber::with_default(subscriber, || {
+            tracing::warn!(
+                remote = "192.0.2.1",
+                email = "a@example.org",
+                attempts = 3,
+                "login failed"
+            );
+            tracing::info!(path = "/mxdeliv", msg = "shadowed", "delivered");
+        });
+
+        let out = String::from_utf8(buf.0.lock().unwrap().clone()).unwrap();
+        let lines: Vec<Map<String, Value>> = out
+            .lines()
+            .map(|l| serde_json::from_str(l).unwrap_or_else(|e| panic!("{l}: {e}")))
+            .collect();
+        assert_eq!(lines.len(), 2, "{out}");
+        let first = &lines[0];
+        assert_eq!(first["level"], "warn");
+        assert_eq!(first["msg"], "login failed");
+        assert_eq!(first["remote"], "192.0.2.1");
+        assert_eq!(first["email"], "a@example.org");
+        assert_eq!(first["attempts"], 3);
+        assert!(first["name"].as_str().unwrap().ends_with("log_json::tests"));
+        assert!(OffsetDateTime::parse(first["time"].as_str().unwrap(), &Rfc3339).is_ok());
+        assert_eq!(lines[1]["msg"], "delivered");
+        assert_eq!(lines[1]["path"], "/mxdeliv");
+    }
+}
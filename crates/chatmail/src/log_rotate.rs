@@ -0,0 +1,418 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Size-rotated log files for the `log file:/path` target (non-systemd / container deployments).
+//!
+//! A single writer thread owns the file; tracing callers only push formatted records onto a
+//! bounded channel. When the channel is full the record is dropped and counted instead of
+//! blocking a mail-path task on disk I/O. SIGHUP reopens the file so an external `logrotate`
+//! (`create` mode) also works.
+
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::mpsc::{sync_channel, Receiver, SyncSender, TrySendError};
+use std::sync::{Arc, Mutex, OnceLock};
+
+use chatmail_config::{parse_data_size, AppConfig};
+use flate2::write::GzEncoder;
+use flate2::Compression;
+
+/// Default `log_rotate_size`.
+pub const DEFAULT_ROTATE_BYTES: u64 = 50 * 1024 * 1024;
+/// Default `log_rotate_keep`.
+pub const DEFAULT_ROTATE_KEEP: u32 = 5;
+/// Records buffered between tracing callers and the writer thread.
+const CHANNEL_CAPACITY: usize = 8192;
+
+/// Rotation settings from `log_rotate_size` / `log_rotate_keep` / `log_rotate_compress`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RotationPolicy {
+    pub max_bytes: u64,
+    pub keep: u32,
+    pub compress: bool,
+}
+
+impl Default for RotationPolicy {
+    fn default() -> Self {
+        Self {
+            max_bytes: DEFAULT_ROTATE_BYTES,
+            keep: DEFAULT_ROTATE_KEEP,
+            compress: true,
+        }
+    }
+}
+
+impl RotationPolicy {
+    pub fn from_config(config: &AppConfig) -> Self {
+        let defaults = Self::default();
+        Self {
+            max_bytes: config
+                .log_rotate_size
+                .as_deref()
+                .and_then(|s| parse_data_size(s).ok())
+                .filter(|b| *b > 0)
+                .unwrap_or(defaults.max_bytes),
+            keep: config.log_rotate_keep.unwrap_or(defaults.keep),
+            compress: config.log_rotate_compress.unwrap_or(defaults.compress),
+        }
+    }
+}
+
+/// Live log file plus its rotated generations (`<path>.1[.gz]` newest … `<path>.<keep>[.gz]`).
+pub struct RotatingFile {
+    path: PathBuf,
+    policy: RotationPolicy,
+    file: File,
+    size: u64,
+}
+
+impl RotatingFile {
+    pub fn open(path: &Path, policy: RotationPolicy) -> io::Result<Self> {
+        let file = open_append(path)?;
+        let size = file.metadata()?.len();
+        Ok(Self {
+            path: path.to_path_buf(),
+            policy,
+            file,
+            size,
+        })
+    }
+
+    /// Append one formatted record, rotating first when it would cross `max_bytes`.
+    /// Records are never split across files.
+    pub fn write_record(&mut self, buf: &[u8]) -> io::Result<()> {
+        if self.size > 0 && self.size + buf.len() as u64 > self.policy.max_bytes {
+            self.rotate()?;
+        }
+        self.file.write_all(buf)?;
+        self.size += buf.len() as u64;
+        Ok(())
+    }
+
+    /// Shift generations up by one, move the live file to `.1` (gzip when enabled), prune
+    /// anything beyond `keep`, and start a fresh live file.
+    pub fn rotate(&mut self) -> io::Result<()> {
+        self.file.flush()?;
+        let keep = self.policy.keep;
+        if keep == 0 {
+            let _ = fs::remove_file(&self.path);
+        } else {
+            for n in (1..keep).rev() {
+                if let Some(from) = self.existing_generation(n) {
+                    let to = self.generation_path(n + 1, is_gz(&from));
+                    fs::rename(&from, &to)?;
+                }
+            }
+            let first = self.generation_path(1, false);
+            fs::rename(&self.path, &first)?;
+            if self.policy.compress {
+                gzip_in_place(&first)?;
+            }
+        }
+        self.prune_beyond_keep();
+        self.reopen()
+    }
+
+    /// Reopen the live path (after SIGHUP or an external rename by `logrotate`).
+    pub fn reopen(&mut self) -> io::Result<()> {
+        self.file = open_append(&self.path)?;
+        self.size = self.file.metadata()?.len();
+        Ok(())
+    }
+
+    pub fn flush(&mut self) -> io::Result<()> {
+        self.file.flush()
+    }
+
+    fn generation_path(&self, n: u32, gz: bool) -> PathBuf {
+        let mut name = self.path.as_os_str().to_os_string();
+        name.push(format!(".{n}"));
+        if gz {
+            name.push(".gz");
+        }
+        PathBuf::from(name)
+    }
+
+    /// Generation `n` on disk, compressed or not (the setting may have changed between runs).
+    fn existing_generation(&self, n: u32) -> Option<PathBuf> {
+        [true, false]
+            .into_iter()
+            .map(|gz| self.generation_path(n, gz))
+            .find(|p| p.exists())
+    }
+
+    fn prune_beyond_keep(&self) {
+        let mut n = self.policy.keep + 1;
+        while let Some(p) = self.existing_generation(n) {
+            let _ = fs::remove_file(p);
+            n += 1;
+        }
+    }
+}
+
+fn is_gz(path: &Path) -> bool {
+    path.extension().is_some_and(|e| e == "gz")
+}
+
+fn gzip_in_place(path: &Path) -> io::Result<()> {
+    let mut gz_name = path.as_os_str().to_os_string();
+    gz_name.push(".gz");
+    let mut input = File::open(path)?;
+    let mut enc = GzEncoder::new(
+        File::create(PathBuf::from(gz_name))?,
+        Compression::default(),
+    );
+    io::copy(&mut input, &mut enc)?;
+    enc.finish()?.sync_all()?;
+    fs::remove_file(path)
+}
+
+fn open_append(path: &Path) -> io::Result<File> {
+    if let Some(parent) = path.parent() {
+        if !parent.as_os_str().is_empty() {
+            fs::create_dir_all(parent)?;
+        }
+    }
+    OpenOptions::new().create(true).append(true).open(path)
+}
+
+enum LogCommand {
+    Record(Vec<u8>),
+    Reopen,
+    Flush(SyncSender<()>),
+}
+
+/// Cloneable `io::Write` handle feeding the writer thread of one rotating log file.
+#[derive(Clone)]
+pub struct RotatingLogSink {
+    tx: SyncSender<LogCommand>,
+    dropped: Arc<AtomicU64>,
+}
+
+impl RotatingLogSink {
+    /// Open `path` (errors surface here, not on the writer thread) and start its writer thread.
+    pub fn spawn(path: &Path, policy: RotationPolicy) -> io::Result<Self> {
+        let file = RotatingFile::open(path, policy)?;
+        let (tx, rx) = sync_channel(CHANNEL_CAPACITY);
+        std::thread::Builder::new()
+            .name("madmail-log".into())
+            .spawn(move || writer_loop(file, rx))?;
+        let sink = Self {
+            tx,
+            dropped: Arc::new(AtomicU64::new(0)),
+        };
+        registry()
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .push(sink.clone());
+        Ok(sink)
+    }
+
+    /// Ask the writer thread to reopen the live file.
+    pub fn reopen(&self) {
+        let _ = self.tx.send(LogCommand::Reopen);
+    }
+
+    /// Block until every record queued so far is written (shutdown / tests).
+    pub fn flush_blocking(&self) {
+        let (ack_tx, ack_rx) = sync_channel(1);
+        if self.tx.send(LogCommand::Flush(ack_tx)).is_ok() {
+            let _ = ack_rx.recv();
+        }
+    }
+
+    /// Records dropped because the writer thread fell `CHANNEL_CAPACITY` behind.
+    pub fn dropped(&self) -> u64 {
+        self.dropped.load(Ordering::Relaxed)
+    }
+}
+
+impl Write for RotatingLogSink {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        match self.tx.try_send(LogCommand::Record(buf.to_vec())) {
+            Ok(()) | Err(TrySendError::Disconnected(_)) => {}
+            Err(TrySendError::Full(_)) => {
+                self.dropped.fetch_add(1, Ordering::Relaxed);
+            }
+        }
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+fn writer_loop(mut file: RotatingFile, rx: Receiver<LogCommand>) {
+    while let Ok(cmd) = rx.recv() {
+        match cmd {
+            LogCommand::Record(buf) => {
+                if let Err(e) = file.write_record(&buf) {
+                    crate::logging::boot_error(format!("log write failed: {e}"));
+                }
+            }
+            LogCommand::Reopen => {
+                if let Err(e) = file.reopen() {
+                    crate::logging::boot_error(format!("log reopen failed: {e}"));
+                }
+            }
+            LogCommand::Flush(ack) => {
+                let _ = file.flush();
+                let _ = ack.send(());
+            }
+        }
+    }
+}
+
+fn registry() -> &'static Mutex<Vec<RotatingLogSink>> {
+    static SINKS: OnceLock<Mutex<Vec<RotatingLogSink>>> = OnceLock::new();
+    SINKS.get_or_init(|| Mutex::new(Vec::new()))
+}
+
+/// Reopen every rotating log file (SIGHUP handler).
+pub fn reopen_all() {
+    for sink in registry().lock().unwrap_or_else(|e| e.into_inner()).iter() {
+        sink.reopen();
+    }
+}
+
+/// Reopen rotating log files on SIGHUP for the life of the process.
+#[cfg(unix)]
+pub fn spawn_sighup_reopen() {
+    let Ok(mut hup) = tokio::signal::unix::signal(tokio::signal::unix::SignalKind::hangup()) else {
+        return;
+    };
+    tokio::spawn(async move {
+        while hup.recv().await.is_some() {
+            reopen_all();
+        }
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::Read;
+
+    fn policy(max_bytes: u64, keep: u32, compress: bool) -> RotationPolicy {
+        RotationPolicy {
+            max_bytes,
+            keep,
+            compress,
+        }
+    }
+
+    fn gunzip(path: &Path) -> String {
+        let mut out = String::new();
+        flate2::read::GzDecoder::new(File::open(path).unwrap())
+            .read_to_string(&mut out)
+            .unwrap();
+        out
+    }
+
+    #[test]
+    fn rotates_exactly_at_size_boundary() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("madmail.log");
+        let mut f = RotatingFile::open(&path, policy(10, 3, false)).unwrap();
+        f.write_record(b"aaaaa").unwrap();
+        f.write_record(b"bbbbb").unwrap();
+        // Exactly at the threshold: still one file.
+        assert!(!dir.path().join("madmail.log.1").exists());
+        f.write_record(b"c").unwrap();
+        f.flush().unwrap();
+        assert_eq!(
+            fs::read_to_string(dir.path().join("madmail.log.1")).unwrap(),
+            "aaaaabbbbb"
+        );
+        assert_eq!(fs::read_to_string(&path).unwrap(), "c");
+    }
+
+    #[test]
+    fn keep_count_prunes_oldest_generations() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("madmail.log");
+        let mut f = RotatingFile::open(&path, policy(4, 2, true)).unwrap();
+        for rec in ["111\n", "222\n", "333\n", "444\n", "555\n"] {
+            f.write_record(rec.as_bytes()).unwrap();
+        }
+        f.flush().unwrap();
+        assert_eq!(fs::read_to_string(&path).unwrap(), "555\n");
+        assert_eq!(gunzip(&dir.path().join("madmail.log.1.gz")), "444\n");
+        assert_eq!(gunzip(&dir.path().join("madmail.log.2.gz")), "333\n");
+        assert!(!dir.path().join("madmail.log.3.gz").exists());
+        assert!(!dir.path().join("madmail.log.1").exists());
+    }
+
+    #[test]
+    fn reopen_after_external_rename_writes_new_file() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("madmail.log");
+        let sink = RotatingLogSink::spawn(&path, RotationPolicy::default()).unwrap();
+        let mut w = sink.clone();
+        w.write_all(b"before\n").unwrap();
+        sink.flush_blocking();
+
+        // logrotate `create` mode: move the live file aside, then SIGHUP.
+        let moved = dir.path().join("madmail.log.moved");
+        fs::rename(&path, &moved).unwrap();
+        w.write_all(b"still-old-inode\n").unwrap();
+        sink.reopen();
+        w.write_all(b"after\n").unwrap();
+        sink.flush_blocking();
+
+        assert_eq!(
+            fs::read_to_string(&moved).unwrap(),
+            "before\nstill-old-inode\n"
+        );
+        assert_eq!(fs::read_to_string(&path).unwrap(), "after\n");
+    }
+
+    #[test]
+    fn full_channel_drops_and_counts() {
+        let (tx, _rx) = sync_channel(1);
+        let sink = RotatingLogSink {
+            tx,
+            dropped: Arc::new(AtomicU64::new(0)),
+        };
+        let mut w = sink.clone();
+        w.write_all(b"one\n").unwrap();
+        w.write_all(b"two\n").unwrap();
+        w.write_all(b"three\n").unwrap();
+        assert_eq!(sink.dropped(), 2);
+    }
+
+    #[test]
+    fn policy_from_config_defaults_and_overrides() {
+        assert_eq!(
+            RotationPolicy::from_config(&AppConfig::default()),
+            RotationPolicy::default()
+        );
+        let cfg = AppConfig {
+            log_rotate_size: Some("1M".into()),
+            log_rotate_keep: Some(9),
+            log_rotate_compress: Some(false),
+            ..AppConfig::default()
+        };
+        assert_eq!(
+            RotationPolicy::from_config(&cfg),
+            policy(1024 * 1024, 9, false)
+        );
+    }
+}
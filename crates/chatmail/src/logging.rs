@@ -22,35 +22,47 @@
 //! - `log stderr` / `log on` / `log stderr_ts` — write to stderr
 //! - `log syslog` — currently maps to stderr (no dedicated syslog backend yet)
 //! - `log /path/to/file` — append to a file
+//! - `log file:/path/to/file` — size-rotated file (`log_rotate_size` / `log_rotate_keep` /
+//!   `log_rotate_compress`; reopened on SIGHUP), see [`crate::log_rotate`]
 //! - `log stderr /var/lib/madmail/madmail.log` — both
 //!
 //! `debug true` (flexible enable forms) overrides No-Log and forces `debug` filter level;
 //! when no output target is set, debug logs go to stderr.
+//!
+//! `log_format json` writes every target as newline-delimited JSON ([`crate::log_json`]).
 
 use std::fs::{File, OpenOptions};
 use std::io::{self, Write};
 use std::path::{Path, PathBuf};
 use std::sync::{Arc, Mutex};
 
+use crate::log_json::{JsonFormat, LogFormat};
+use crate::log_rotate::{RotatingLogSink, RotationPolicy};
 use tracing_subscriber::{
     fmt::{self, format::FmtSpan, writer::BoxMakeWriter},
+    layer::Layered,
     prelude::*,
     reload::{self, Handle},
-    EnvFilter, Registry,
+    EnvFilter, Layer, Registry,
 };
 
 pub type LogReloadHandle = Handle<EnvFilter, Registry>;
 
+/// Subscriber the output layer sits on: the registry behind the reloadable filter.
+type FilteredRegistry = Layered<reload::Layer<EnvFilter, Registry>, Registry>;
+
 /// Parsed destinations from the `log` config directive.
 #[derive(Debug, Clone, PartialEq, Eq, Default)]
 pub struct LogDestinations {
     pub stderr: bool,
     pub files: Vec<PathBuf>,
+    /// `file:/path` targets with built-in rotation.
+    pub rotating: Vec<PathBuf>,
 }
 
 impl LogDestinations {
     pub fn is_empty(&self) -> bool {
-        !self.stderr && self.files.is_empty()
+        !self.stderr && self.files.is_empty() && self.rotating.is_empty()
     }
 }
 
@@ -58,10 +70,13 @@ impl LogDestinations {
 struct MultiLogWriter {
     stderr: bool,
     files: Vec<Arc<Mutex<File>>>,
+    rotating: Vec<RotatingLogSink>,
 }
 
 impl Write for MultiLogWriter {
     fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        // Recent lines for crash reports (only what the configured targets receive).
+        chatmail_state::record_log_output(buf);
         if self.stderr {
             let _ = io::stderr().write_all(buf);
         }
@@ -70,6 +85,10 @@ impl Write for MultiLogWriter {
                 let _ = g.write_all(buf);
             }
         }
+        // Non-blocking hand-off to each rotating file's writer thread.
+        for sink in &mut self.rotating {
+            let _ = sink.write_all(buf);
+        }
         Ok(buf.len())
     }
 
@@ -135,7 +154,10 @@ pub fn parse_log_destinations(log_target: Option<&str>) -> LogDestinations {
                 // syslog → stderr until a real syslog backend is wired
                 dest.stderr = true;
             }
-            _ => dest.files.push(PathBuf::from(token)),
+            _ => match token.strip_prefix("file:") {
+                Some(path) if !path.is_empty() => dest.rotating.push(PathBuf::from(path)),
+                _ => dest.files.push(PathBuf::from(token)),
+            },
         }
     }
     dest
@@ -156,7 +178,13 @@ pub fn effective_log_destinations(log_target: Option<&str>, debug: bool) -> LogD
 /// `RUST_LOG=info` systemd drop-in (operators can still narrow via `RUST_LOG` when debug is off).
 ///
 /// Output goes to the targets from `log` (stderr and/or files). No-Log uses filter `off`.
-pub fn init_logging(debug: bool, log_target: Option<&str>) -> LogReloadHandle {
+/// `rotation` applies to `file:` targets only; `format` picks text lines or JSON records.
+pub fn init_logging(
+    debug: bool,
+    log_target: Option<&str>,
+    rotation: &RotationPolicy,
+    format: LogFormat,
+) -> LogReloadHandle {
     let disabled = should_disable_logging(log_target, debug);
     let filter = if disabled {
         EnvFilter::new("off")
@@ -168,14 +196,17 @@ pub fn init_logging(debug: bool, log_target: Option<&str>) -> LogReloadHandle {
 
     let (filter_layer, reload_handle) = reload::Layer::new(filter);
     let dest = effective_log_destinations(log_target, debug);
-    let writer = make_log_writer(&dest);
-
-    let subscriber = Registry::default().with(filter_layer).with(
-        fmt::layer()
-            .with_writer(writer)
-            .with_span_events(FmtSpan::CLOSE)
-            .with_ansi(false),
-    );
+    let writer = make_log_writer(&dest, rotation);
+
+    let output = fmt::layer()
+        .with_writer(writer)
+        .with_span_events(FmtSpan::CLOSE)
+        .with_ansi(false);
+    let output: Box<dyn Layer<FilteredRegistry> + Send + Sync> = match format {
+        LogFormat::Text => Box::new(output),
+        LogFormat::Json => Box::new(output.event_format(JsonFormat)),
+    };
+    let subscriber = Registry::default().with(filter_layer).with(output);
 
     tracing::subscriber::set_global_default(subscriber)
         .expect("tracing subscriber must only be initialized once");
@@ -192,7 +223,7 @@ fn open_log_file(path: &Path) -> io::Result<File> {
     OpenOptions::new().create(true).append(true).open(path)
 }
 
-fn make_log_writer(dest: &LogDestinations) -> BoxMakeWriter {
+fn make_log_writer(dest: &LogDestinations, rotation: &RotationPolicy) -> BoxMakeWriter {
     if dest.is_empty() {
         return BoxMakeWriter::new(io::sink);
     }
@@ -210,10 +241,27 @@ fn make_log_writer(dest: &LogDestinations) -> BoxMakeWriter {
         }
     }
 
+    let mut rotating = Vec::new();
+    for path in &dest.rotating {
+        match RotatingLogSink::spawn(path, rotation.clone()) {
+            Ok(sink) => rotating.push(sink),
+            Err(e) => {
+                boot_error(format!(
+                    "failed to open log file {}: {e} (continuing with remaining targets)",
+                    path.display()
+                ));
+            }
+        }
+    }
+
     // Prefer configured stderr; if file open failed and nothing else works, fall back to stderr.
-    let stderr = dest.stderr || files.is_empty();
+    let stderr = dest.stderr || (files.is_empty() && rotating.is_empty());
 
-    let shared = SharedMultiLogWriter(Arc::new(Mutex::new(MultiLogWriter { stderr, files })));
+    let shared = SharedMultiLogWriter(Arc::new(Mutex::new(MultiLogWriter {
+        stderr,
+        files,
+        rotating,
+    })));
     BoxMakeWriter::new(move || shared.clone())
 }
 
@@ -275,6 +323,42 @@ mod tests {
         assert_eq!(d.files, vec![PathBuf::from("/var/lib/madmail/madmail.log")]);
     }
 
+    #[test]
+    fn parse_log_rotating_file_target() {
+        let d = parse_log_destinations(Some("stderr file:/var/log/madmail/madmail.log"));
+        assert!(d.stderr);
+        assert!(d.files.is_empty());
+        assert_eq!(
+            d.rotating,
+            vec![PathBuf::from("/var/log/madmail/madmail.log")]
+        );
+        assert!(!should_disable_logging(Some("file:/tmp/x.log"), false));
+    }
+
+    #[test]
+    fn rotating_log_writer_appends() {
+        use tracing_subscriber::fmt::MakeWriter;
+
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("rot.log");
+        let dest = LogDestinations {
+            stderr: false,
+            files: Vec::new(),
+            rotating: vec![path.clone()],
+        };
+        let maker = make_log_writer(&dest, &RotationPolicy::default());
+        let mut w = maker.make_writer();
+        w.write_all(b"rotating-line\n").unwrap();
+        // The writer thread drains asynchronously; poll briefly for the record.
+        for _ in 0..100 {
+            if std::fs::read_to_string(&path).is_ok_and(|c| c.contains("rotating-line")) {
+                return;
+            }
+            std::thread::sleep(std::time::Duration::from_millis(10));
+        }
+        panic!("rotating log line never reached {}", path.display());
+    }
+
     #[test]
     fn debug_forces_stderr_when_no_log_target() {
         let d = effective_log_destinations(None, true);
@@ -303,8 +387,9 @@ mod tests {
         let dest = LogDestinations {
             stderr: false,
             files: vec![path.clone()],
+            rotating: Vec::new(),
         };
-        let maker = make_log_writer(&dest);
+        let maker = make_log_writer(&dest, &RotationPolicy::default());
         let mut w = maker.make_writer();
         w.write_all(b"hello-log-line\n").unwrap();
         w.flush().unwrap();
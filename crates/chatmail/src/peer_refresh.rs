@@ -0,0 +1,225 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Federation peering: fetch every peer's `/federation/info` at start and every
+//! [`PEER_REFRESH_INTERVAL`]; results land in [`PeerDirectory`].
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use async_trait::async_trait;
+use chatmail_db::DbPool;
+use chatmail_state::{
+    FetchedInfo, InfoFetcher, PeerDirectory, FEDERATION_INFO_PATH, MAX_INFO_BYTES,
+    PEER_REFRESH_INTERVAL, SIGNATURE_HEADER,
+};
+use tokio::task::JoinHandle;
+
+const REQUEST_TIMEOUT: Duration = Duration::from_secs(15);
+
+/// `GET {base_url}/federation/info` over reqwest.
+pub struct HttpInfoFetcher {
+    client: reqwest::Client,
+}
+
+impl HttpInfoFetcher {
+    pub fn new() -> Self {
+        // Self-signed certificates are normal between chatmail relays (see /mxdeliv); the
+        // document's signature, not the TLS chain, decides whether it is used.
+        let client = reqwest::Client::builder()
+            .timeout(REQUEST_TIMEOUT)
+            .redirect(reqwest::redirect::Policy::none())
+            .danger_accept_invalid_certs(true)
+            .build()
+            .unwrap_or_default();
+        Self { client }
+    }
+}
+
+impl Default for HttpInfoFetcher {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[async_trait]
+impl InfoFetcher for HttpInfoFetcher {
+    async fn fetch(&self, base_url: &str) -> Result<FetchedInfo, String> {
+        let mut resp = self
+            .client
+            .get(format!("{base_url}{FEDERATION_INFO_PATH}"))
+            .header(reqwest::header::ACCEPT, "application/json")
+            .send()
+            .await
+            .map_err(|e| e.to_string())?;
+        if !resp.status().is_success() {
+            return Err(format!("HTTP {}", resp.status()));
+        }
+        if resp
+            .content_length()
+            .is_some_and(|n| n > MAX_INFO_BYTES as u64)
+        {
+            return Err(format!("document larger than {MAX_INFO_BYTES} bytes"));
+        }
+        let signature = resp
+            .headers()
+            .get(SIGNATURE_HEADER)
+            .and_then(|v| v.to_str().ok())
+            .map(str::to_string);
+        let mut body = Vec::new();
+        while let Some(chunk) = resp.chunk().await.map_err(|e| e.to_string())? {
+            if body.len() + chunk.len() > MAX_INFO_BYTES {
+                return Err(format!("document larger than {MAX_INFO_BYTES} bytes"));
+            }
+            body.extend_from_slice(&chunk);
+        }
+        Ok(FetchedInfo { body, signature })
+    }
+}
+
+/// Spawn the periodic refresh. It always runs so peers added with `madmail peers add` are
+/// picked up; with no peers a tick is one database read.
+pub fn start_peer_refresh(peers: Arc<PeerDirectory>, pool: DbPool) -> JoinHandle<()> {
+    let fetcher = HttpInfoFetcher::new();
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(PEER_REFRESH_INTERVAL);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Skip);
+        loop {
+            tick.tick().await;
+            if let Err(e) = peers.refresh(&pool, &fetcher).await {
+                tracing::warn!(error = %e, "peer list refresh failed");
+            }
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_config::AppConfig;
+    use chatmail_fed::mxdeliv::FedState;
+    use chatmail_fed::server::federation_router;
+    use chatmail_state::AppState;
+    use tokio::net::TcpListener;
+
+    struct Instance {
+        app: Arc<AppState>,
+        pool: DbPool,
+        _dir: tempfile::TempDir,
+    }
+
+    /// One in-process relay serving the federation router on `listener`.
+    async fn instance(
+        domain: &str,
+        listener: TcpListener,
+        peer: &str,
+        secret: &str,
+        max_message_size: &str,
+    ) -> Instance {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        chatmail_db::seed_install_defaults(&pool).await.unwrap();
+        let config = AppConfig {
+            peers: vec![peer.to_string()],
+            peer_secrets: vec![secret.to_string()],
+            ..Default::default()
+        };
+        let app = Arc::new(AppState::with_quota_and_message_limit(
+            dir.path(),
+            chatmail_config::DEFAULT_QUOTA_BYTES,
+            &config,
+            pool.clone(),
+        ));
+        app.hydrate(&pool, &config).await.unwrap();
+        app.message_size
+            .set_limit(&pool, &config, max_message_size)
+            .await
+            .unwrap();
+        let addr = listener.local_addr().unwrap().to_string();
+        app.listener_ports
+            .set_runtime("127.0.0.1:25", None, None, None, None, Some(addr), None);
+        let router = federation_router(FedState {
+            pool: pool.clone(),
+            app: Arc::clone(&app),
+            primary_domain: domain.to_string(),
+            local_domains: chatmail_types::build_local_domains(domain, None),
+        });
+        tokio::spawn(async move {
+            axum::serve(listener, router).await.unwrap();
+        });
+        Instance {
+            app,
+            pool,
+            _dir: dir,
+        }
+    }
+
+    #[tokio::test]
+    async fn two_instances_exchange_federation_info() {
+        let la = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let lb = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url_a = format!("http://{}", la.local_addr().unwrap());
+        let url_b = format!("http://{}", lb.local_addr().unwrap());
+        let a = instance("a.example", la, &url_b, "shared", "10M").await;
+        let b = instance("b.example", lb, &url_a, "shared", "2M").await;
+        let fetcher = HttpInfoFetcher::new();
+
+        a.app.peers.refresh(&a.pool, &fetcher).await.unwrap();
+        let seen = &a.app.peers.snapshot()[0];
+        assert_eq!(seen.base_url, url_b);
+        assert_eq!(seen.reachable, Some(true));
+        assert!(seen.verified, "{:?}", seen.error);
+        let info = seen.info.as_ref().unwrap();
+        assert_eq!(info.domain, "b.example");
+        assert_eq!(info.delivery, ["http", "smtp"]);
+        assert!(info.capabilities.iter().any(|c| c == "mxdeliv"));
+        let hints = a.app.peers.hints_for("b.example").unwrap();
+        assert!(!hints.https && hints.http);
+        assert_eq!(hints.max_message_size, b.app.message_size.effective());
+
+        // B now learns A, including the peers A already reaches.
+        b.app.peers.refresh(&b.pool, &fetcher).await.unwrap();
+        let info = b.app.peers.snapshot()[0].info.clone().unwrap();
+        assert_eq!(info.domain, "a.example");
+        assert_eq!(info.peers, ["b.example"]);
+        assert!(b.app.peers.hints_for("a.example").is_some());
+
+        // A different key: the document is read but never used for delivery.
+        let outsider = PeerDirectory::new(vec![url_a.clone()], vec!["other".into()]);
+        outsider.check(&url_a, &fetcher).await;
+        let seen = &outsider.snapshot()[0];
+        assert_eq!(seen.reachable, Some(true));
+        assert!(!seen.verified);
+        assert!(outsider.hints_for("a.example").is_none());
+    }
+
+    #[tokio::test]
+    async fn oversized_documents_are_refused() {
+        use axum::routing::get;
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}", listener.local_addr().unwrap());
+        let router = axum::Router::new().route(
+            FEDERATION_INFO_PATH,
+            get(|| async { " ".repeat(MAX_INFO_BYTES + 1) }),
+        );
+        tokio::spawn(async move {
+            axum::serve(listener, router).await.unwrap();
+        });
+        let err = HttpInfoFetcher::new().fetch(&url).await.unwrap_err();
+        assert!(err.contains("larger than"), "{err}");
+    }
+}
@@ -15,10 +15,17 @@
 //
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
+use std::collections::HashMap;
+use std::net::SocketAddr;
 use std::path::Path;
 use std::sync::Arc;
 
-use axum::Router;
+use axum::extract::{ConnectInfo, State};
+use axum::http::{header, HeaderMap, StatusCode};
+use axum::response::{IntoResponse, Response};
+use axum::routing::get;
+use axum::{Extension, Router};
+use chatmail_admin::auth::AuthGate;
 use chatmail_admin::{admin_router, AdminState};
 use chatmail_config::AppConfig;
 use chatmail_db::DbPool;
@@ -58,8 +65,118 @@ pub(crate) async fn build_http_extra(
     );
     let admin_web_extra =
         chatmail_admin_web::router_if_configured(file_config, pool.clone()).await?;
-    let www_extra = www_router(WwwState::new(pool, app, file_config.clone(), state_dir));
-    Ok(merge_http_routers(admin_extra, admin_web_extra, www_extra))
+    let hostname = file_config
+        .hostname
+        .clone()
+        .unwrap_or_else(|| "127.0.0.1".into());
+    let turn = crate::turn_boot::turn_discovery(&pool, file_config, &hostname).await?;
+    let www_extra = www_router(
+        WwwState::new(
+            pool.clone(),
+            Arc::clone(&app),
+            file_config.clone(),
+            state_dir,
+        )
+        .with_turn(turn),
+    );
+    let extra = merge_http_routers(admin_extra, admin_web_extra, www_extra);
+    if !file_config.enable_metrics {
+        return Ok(extra);
+    }
+    register_scrape_hooks(pool);
+    let token = file_config
+        .metrics_token
+        .clone()
+        .or_else(|| resolve_admin_token(file_config, admin_token));
+    let scrape = MetricsScrape {
+        gate: token.map(|t| Arc::new(AuthGate::new(t))),
+        local_only: file_config.metrics_local_only,
+        app,
+    };
+    Ok(extra.map(|router| with_metrics(router, scrape)))
+}
+
+/// Gauges the `/metrics` handlers refresh at scrape time (HTTP listener and `openmetrics`).
+pub(crate) fn register_scrape_hooks(pool: DbPool) {
+    chatmail_metrics::on_scrape("storage_accounts", move || {
+        let pool = pool.clone();
+        async move {
+            if let Ok((_, total)) = chatmail_db::passwords::list_users_page(&pool, "", 0, 0).await {
+                chatmail_metrics::set_storage_accounts(total.max(0) as u64);
+            }
+        }
+    });
+}
+
+/// `enable_metrics`: per-route counters on every HTTP route, plus `/metrics` behind a bearer
+/// token (`metrics_token`, else the admin token) or loopback-only (`metrics_local_only`).
+/// With neither available (`admin_token disabled`, no `metrics_token`) nothing is scraped here.
+fn with_metrics(router: Router, scrape: MetricsScrape) -> Router {
+    chatmail_metrics::init_metrics();
+    let mut router = router;
+    if scrape.local_only || scrape.gate.is_some() {
+        let scrape = Router::new()
+            .route("/metrics", get(scrape_metrics))
+            .with_state(scrape);
+        router = scrape.merge(router);
+    }
+    router.route_layer(axum::middleware::from_fn(chatmail_metrics::track_http))
+}
+
+#[derive(Clone)]
+struct MetricsScrape {
+    gate: Option<Arc<AuthGate>>,
+    local_only: bool,
+    app: Arc<AppState>,
+}
+
+async fn scrape_metrics(
+    State(st): State<MetricsScrape>,
+    headers: HeaderMap,
+    peer: Option<Extension<ConnectInfo<SocketAddr>>>,
+) -> Response {
+    if st.local_only {
+        // The direct peer, not a forwarded address: a proxy on loopback is not a local scraper.
+        let loopback = peer
+            .as_ref()
+            .is_some_and(|Extension(ConnectInfo(addr))| addr.ip().is_loopback());
+        if !loopback {
+            return StatusCode::FORBIDDEN.into_response();
+        }
+        return chatmail_metrics::metrics_handler().await.into_response();
+    }
+    let Some(gate) = st.gate.as_ref() else {
+        return StatusCode::UNAUTHORIZED.into_response();
+    };
+    let remote = peer
+        .map(|Extension(ConnectInfo(addr))| {
+            st.app
+                .trusted_proxies
+                .client_ip(addr.ip(), |name| {
+                    headers.get(name).and_then(|v| v.to_str().ok())
+                })
+                .to_string()
+        })
+        .unwrap_or_else(|| "127.0.0.1".into());
+    let auth: HashMap<String, String> = headers
+        .get(header::AUTHORIZATION)
+        .and_then(|v| v.to_str().ok())
+        .map(|v| HashMap::from([("Authorization".to_string(), v.to_string())]))
+        .unwrap_or_default();
+    if !gate.authenticate(&auth, &remote) {
+        return StatusCode::UNAUTHORIZED.into_response();
+    }
+    chatmail_metrics::metrics_handler().await.into_response()
+}
+
+/// Bearer token for the admin API: `admin_token` from `maddy.conf`, else the bootstrap token;
+/// `None` when the config says `admin_token disabled`.
+fn resolve_admin_token(file_config: &AppConfig, admin_token: &str) -> Option<String> {
+    match file_config.admin_token.as_deref() {
+        Some(t) if t.eq_ignore_ascii_case("disabled") => None,
+        Some(t) => Some(t.to_string()),
+        None => Some(admin_token.to_string()),
+    }
 }
 
 pub(crate) fn build_admin_router(
@@ -70,20 +187,10 @@ pub(crate) fn build_admin_router(
     app: Arc<AppState>,
     reload_tx: Option<mpsc::Sender<ReloadRequest>>,
 ) -> Option<Router> {
-    let disabled = file_config
-        .admin_token
-        .as_deref()
-        .is_some_and(|t| t.eq_ignore_ascii_case("disabled"));
-    if disabled {
+    let Some(token) = resolve_admin_token(file_config, admin_token) else {
         tracing::info!("admin API disabled via config (admin_token disabled)");
         return None;
-    }
-
-    let token = file_config
-        .admin_token
-        .clone()
-        .filter(|t| !t.eq_ignore_ascii_case("disabled"))
-        .unwrap_or_else(|| admin_token.to_string());
+    };
 
     let path = std::env::var("CHATMAIL_ADMIN_PATH")
         .ok()
@@ -151,3 +258,133 @@ fn normalize_admin_path(path: &str) -> String {
         format!("/{path}")
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn has_sample(body: &str, name: &str, labels: &[&str]) -> bool {
+        body.lines()
+            .filter(|l| l.starts_with(name))
+            .any(|l| labels.iter().all(|want| l.contains(want)))
+    }
+
+    /// One test: the account gauge and its scrape hook are process-wide.
+    #[tokio::test]
+    async fn metrics_scrape_counts_registration_behind_admin_token() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        app.auth.hydrate(&pool).await.unwrap();
+        app.listener_ports.set_runtime(
+            "0.0.0.0:25",
+            None,
+            Some("0.0.0.0:993".into()),
+            None,
+            Some("0.0.0.0:465".into()),
+            None,
+            None,
+        );
+        let cfg = AppConfig {
+            primary_domain: Some("192.0.2.1".into()),
+            imap_tls_listen: Some("0.0.0.0:993".into()),
+            submission_tls_listen: Some("0.0.0.0:465".into()),
+            enable_metrics: true,
+            ..Default::default()
+        };
+        let router = build_http_extra(&cfg, dir.path(), "scrape-token", pool, app, None)
+            .await
+            .unwrap()
+            .expect("http router");
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let base = format!("http://{}", listener.local_addr().unwrap());
+        tokio::spawn(async move { axum::serve(listener, router).await });
+
+        let client = reqwest::Client::new();
+        let registered = client.post(format!("{base}/new")).send().await.unwrap();
+        assert_eq!(registered.status(), reqwest::StatusCode::OK);
+
+        let anonymous = client.get(format!("{base}/metrics")).send().await.unwrap();
+        assert_eq!(anonymous.status(), reqwest::StatusCode::UNAUTHORIZED);
+
+        let scraped = client
+            .get(format!("{base}/metrics"))
+            .bearer_auth("scrape-token")
+            .send()
+            .await
+            .unwrap();
+        assert_eq!(scraped.status(), reqwest::StatusCode::OK);
+        let body = scraped.text().await.unwrap();
+        assert!(
+            has_sample(&body, "chatmail_registrations_total", &[r#"result="ok""#]),
+            "{body}"
+        );
+        assert!(
+            has_sample(
+                &body,
+                "chatmail_http_requests_total",
+                &[r#"path="/new""#, r#"method="POST""#, r#"status="200""#],
+            ),
+            "{body}"
+        );
+        assert!(
+            has_sample(&body, "chatmail_storage_accounts", &[" 1"]),
+            "{body}"
+        );
+
+        // Loopback-only access on a second server; its scrape hook replaces the first one.
+        local_only_scrape_needs_no_token().await;
+    }
+
+    async fn local_only_scrape_needs_no_token() {
+        let dir = tempfile::tempdir().unwrap();
+        let pool = chatmail_db::init_memory_db().await.unwrap();
+        let hash = chatmail_auth::hash_password("secret").unwrap();
+        for user in ["a@example.org", "b@example.org"] {
+            chatmail_db::passwords::create_user(&pool, user, &hash)
+                .await
+                .unwrap();
+        }
+        let app = Arc::new(AppState::new(dir.path(), pool.clone()));
+        let cfg = AppConfig {
+            admin_token: Some("disabled".into()),
+            enable_metrics: true,
+            metrics_local_only: true,
+            ..Default::default()
+        };
+        let router = build_http_extra(&cfg, dir.path(), "unused", pool, app, None)
+            .await
+            .unwrap()
+            .expect("http router");
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}/metrics", listener.local_addr().unwrap());
+        tokio::spawn(async move {
+            axum::serve(
+                listener,
+                router.into_make_service_with_connect_info::<SocketAddr>(),
+            )
+            .await
+        });
+
+        let scraped = reqwest::get(&url).await.unwrap();
+        assert_eq!(scraped.status(), reqwest::StatusCode::OK);
+        let body = scraped.text().await.unwrap();
+        assert!(
+            has_sample(&body, "chatmail_storage_accounts", &[" 2"]),
+            "{body}"
+        );
+    }
+
+    #[test]
+    fn admin_token_disabled_resolves_to_none() {
+        let cfg = AppConfig {
+            admin_token: Some("Disabled".into()),
+            ..Default::default()
+        };
+        assert_eq!(resolve_admin_token(&cfg, "boot"), None);
+        assert_eq!(
+            resolve_admin_token(&AppConfig::default(), "boot").as_deref(),
+            Some("boot")
+        );
+    }
+}
@@ -17,12 +17,15 @@
 
 //! Shadowsocks server lifecycle (Madmail `runShadowsocks` + admin toggles).
 
+use std::sync::Arc;
+
 use chatmail_config::AppConfig;
 use chatmail_db::DbPool;
 use chatmail_shadowsocks::{
     resolve_runtime, spawn_shadowsocks_server, ss_runtime_enabled as ss_enabled, ShadowsocksHandle,
     ShadowsocksRuntime,
 };
+use chatmail_state::ShadowsocksStats;
 use chatmail_types::Result;
 
 pub use chatmail_shadowsocks::resolve_runtime as ss_resolve_runtime;
@@ -43,12 +46,13 @@ pub async fn start_shadowsocks_server(
     file_config: &AppConfig,
     mail_domain: &str,
     state_dir: &std::path::Path,
+    stats: Arc<ShadowsocksStats>,
 ) -> Result<Option<ShadowsocksHandle>> {
     if !ss_runtime_enabled(pool, file_config, mail_domain, state_dir).await? {
         tracing::info!("Shadowsocks disabled (not configured or admin toggle)");
         return Ok(None);
     }
     let rt: ShadowsocksRuntime = resolve_runtime(pool, file_config, mail_domain, state_dir).await?;
-    let handle = spawn_shadowsocks_server(rt).await?;
+    let handle = spawn_shadowsocks_server(rt, stats).await?;
     Ok(Some(handle))
 }
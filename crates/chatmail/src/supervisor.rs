@@ -31,28 +31,35 @@ use chatmail_imap::run_imap_listener;
 use chatmail_smtp::run_smtp_listener;
 use chatmail_state::{AppState, ReloadRequest, ReloadScope};
 use chatmail_tasks::MaintenanceHandle;
-use chatmail_tls::load_server_config;
+use chatmail_tls::ReloadingCert;
 use chatmail_types::Result;
 use rustls::ServerConfig;
 use tokio::net::TcpListener;
 use tokio::sync::{mpsc, Mutex};
 use tokio::task::JoinHandle;
-use tokio::time::{timeout, Duration};
+use tokio::time::{timeout, timeout_at, Duration, Instant};
 use tokio_util::sync::CancellationToken;
-use tracing::{error, info};
+use tracing::{error, info, warn};
 
 use crate::logging::boot_error;
-use crate::servers::{build_http_extra, extend_dev_local_aliases};
+use crate::servers::{build_http_extra, extend_dev_local_aliases, register_scrape_hooks};
 
 use chatmail_imap::ImapSessionConfig;
 use chatmail_iroh::IrohRelayHandle;
-use chatmail_shadowsocks::ShadowsocksHandle;
+use chatmail_shadowsocks::{ShadowsocksHandle, SS_DRAIN_TIMEOUT};
 use chatmail_smtp::SmtpSessionConfig;
 use chatmail_turn::TurnServerHandle;
 
 mod cert_renew;
 pub use cert_renew::renew_autocert_from_cli;
 use cert_renew::supervisor_cert_renewer;
+mod cert_watch;
+use cert_watch::spawn_cert_watch;
+mod health_probe;
+use health_probe::spawn_health_probe;
+
+/// How often `maddy_imap_stale_backlog_accounts` is recomputed.
+const BACKLOG_SCAN_INTERVAL: Duration = Duration::from_secs(3600);
 
 struct ListenerSlot {
     cancel: CancellationToken,
@@ -98,6 +105,9 @@ struct SupervisorInner {
     ss_server: Mutex<Option<ShadowsocksHandle>>,
     imap_cfg: Mutex<ImapSessionConfig>,
     maintenance: Mutex<Option<MaintenanceHandle>>,
+    /// Certificate resolver shared by every TLS listener; kept across listener restarts so
+    /// the certificate watch reloads the pair in place.
+    tls_cert: std::sync::Mutex<Option<Arc<ReloadingCert>>>,
 }
 
 /// Owns SMTP/IMAP/HTTP listeners and applies `POST /admin/reload` (stop, hydrate, rebind).
@@ -186,6 +196,7 @@ impl ServerSupervisor {
             file_config,
             &primary_domain,
             state_dir,
+            Arc::clone(&app.shadowsocks),
         )
         .await?;
         let push_enabled = crate::push_boot::push_enabled(&pool).await?;
@@ -218,6 +229,7 @@ impl ServerSupervisor {
             iroh_relay: Mutex::new(iroh_relay),
             ss_server: Mutex::new(ss_server),
             maintenance: Mutex::new(None),
+            tls_cert: std::sync::Mutex::new(None),
         });
 
         let cert_renewer = if file_config.tls_mode.as_deref() == Some("autocert") {
@@ -225,13 +237,22 @@ impl ServerSupervisor {
         } else {
             None
         };
-        let maintenance =
-            chatmail_tasks::spawn_maintenance_scheduler(pool, state_dir, file_config, cert_renewer);
+        let maintenance = chatmail_tasks::spawn_maintenance_scheduler(
+            pool,
+            (*inner.app.mailbox_store).clone(),
+            file_config,
+            cert_renewer,
+            inner.app.drain.clone(),
+            Some(Arc::clone(&inner.app.webhooks)),
+            Some(Arc::clone(&inner.app.quota)),
+        );
         *inner.maintenance.lock().await = Some(maintenance);
 
         inner.rebuild_http_routers().await?;
         inner.start_listeners().await?;
         inner.start_openmetrics().await?;
+        spawn_health_probe(Arc::clone(&inner));
+        spawn_cert_watch(Arc::clone(&inner));
 
         #[cfg(unix)]
         {
@@ -280,6 +301,37 @@ impl ServerSupervisor {
     pub fn reload_sender(&self) -> mpsc::Sender<ReloadRequest> {
         self.inner.reload_tx.clone()
     }
+
+    /// Two-phase shutdown. Phase one stops new work (listeners close, SMTP `MAIL` gets `421`,
+    /// `/mxdeliv` gets `503`) and gives in-flight deliveries and IMAP commands up to `drain`
+    /// to finish. Phase two stops the services sessions depend on; the caller then flushes
+    /// counters and closes the pool.
+    pub async fn shutdown(&self, drain: Duration) {
+        let inner = &self.inner;
+        let deadline = Instant::now() + drain;
+        inner.app.drain.start_draining();
+        let _ = timeout_at(deadline, inner.stop_listeners()).await;
+        let left = deadline.saturating_duration_since(Instant::now());
+        if !inner.app.drain.wait_idle(left).await {
+            warn!(
+                in_flight = inner.app.drain.in_flight(),
+                "shutdown drain expired with work in flight"
+            );
+        }
+
+        if let Some(handle) = inner.maintenance.lock().await.take() {
+            handle.shutdown();
+        }
+        if let Some(handle) = inner.ss_server.lock().await.take() {
+            handle
+                .shutdown(deadline.saturating_duration_since(Instant::now()))
+                .await;
+        }
+        if let Some(handle) = inner.iroh_relay.lock().await.take() {
+            handle.shutdown().await;
+        }
+        inner.turn_server.lock().await.take();
+    }
 }
 
 impl SupervisorInner {
@@ -313,7 +365,23 @@ impl SupervisorInner {
         }
         let (cert, key) =
             crate::tls_boot::ensure_tls_pem_files(&self.file_config, &self.state_dir)?;
-        Ok(Some(load_server_config(&cert, &key)?))
+        let mut slot = self.tls_cert.lock().unwrap_or_else(|e| e.into_inner());
+        let resolver = match slot.as_ref() {
+            Some(current)
+                if current.cert_path() == cert.as_path() && current.key_path() == key.as_path() =>
+            {
+                if let Err(e) = current.reload_if_changed() {
+                    warn!(cert = %cert.display(), error = %e, "tls: keeping previous certificate");
+                }
+                Arc::clone(current)
+            }
+            _ => {
+                let loaded = ReloadingCert::load(&cert, &key)?;
+                *slot = Some(Arc::clone(&loaded));
+                loaded
+            }
+        };
+        Ok(Some(resolver.server_config()))
     }
 
     async fn start_listeners(&self) -> Result<()> {
@@ -480,6 +548,7 @@ impl SupervisorInner {
             return Ok(());
         };
         preflight_listen_addrs(std::iter::once(addr)).await?;
+        register_scrape_hooks(self.pool.clone());
         let cancel = CancellationToken::new();
         let addr_owned = addr.to_string();
         let cancel_metrics = cancel.clone();
@@ -507,8 +576,33 @@ impl SupervisorInner {
                 }
             }
         };
+        let cancel_backlog = cancel.clone();
+        let store = self.app.mailbox_store.clone();
+        let backlog_task = async move {
+            // A full maildir walk: hourly is plenty for a "clients stopped fetching" signal.
+            let mut interval = tokio::time::interval(BACKLOG_SCAN_INTERVAL);
+            loop {
+                tokio::select! {
+                    _ = cancel_backlog.cancelled() => break,
+                    _ = interval.tick() => {
+                        match chatmail_storage::inbox_backlog(
+                            &store,
+                            chatmail_storage::DEFAULT_BACKLOG_MIN_AGE,
+                            chatmail_storage::DEFAULT_BACKLOG_MIN_COUNT,
+                        )
+                        .await
+                        {
+                            Ok(report) => {
+                                chatmail_metrics::set_stale_backlog_accounts(report.accounts.len())
+                            }
+                            Err(e) => warn!(error = %e, "stale backlog scan failed"),
+                        }
+                    }
+                }
+            }
+        };
         let join = tokio::spawn(async move {
-            tokio::join!(metrics_task, queue_task);
+            tokio::join!(metrics_task, queue_task, backlog_task);
         });
 
         if let Some(active) = self.listeners.lock().await.as_mut() {
@@ -709,7 +803,7 @@ impl SupervisorInner {
         {
             let mut ss = self.ss_server.lock().await;
             if let Some(handle) = ss.take() {
-                handle.shutdown();
+                handle.shutdown(SS_DRAIN_TIMEOUT).await;
             }
         }
         let started = crate::ss_boot::start_shadowsocks_server(
@@ -717,6 +811,7 @@ impl SupervisorInner {
             &self.file_config,
             &mail_domain,
             &self.state_dir,
+            Arc::clone(&self.app.shadowsocks),
         )
         .await?;
         *self.ss_server.lock().await = started;
@@ -79,6 +79,7 @@ impl SupervisorInner {
             key_path: Some(key_path),
             http_listen: "0.0.0.0:80".parse().expect("valid default listen"),
             staging: false,
+            directory_url: self.file_config.acme_directory.clone(),
             skip_if_valid: true,
         };
 
@@ -129,6 +130,7 @@ pub async fn renew_autocert_from_cli(
         key_path: Some(key_path),
         http_listen: "0.0.0.0:80".parse().expect("valid default listen"),
         staging: false,
+        directory_url: config.acme_directory.clone(),
         skip_if_valid: true,
     };
 
@@ -0,0 +1,179 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Certificate watch: every [`WATCH_INTERVAL`] reload the TLS pair when its files changed,
+//! feed the active `NotAfter` into `AppState.cert` and run `renewal_hook` once the
+//! certificate enters the `cert_expiry_warning` window.
+
+use std::path::Path;
+use std::sync::Arc;
+use std::time::Duration;
+
+use chatmail_tls::ReloadingCert;
+use tokio::process::Command;
+use tokio::task::JoinHandle;
+use tokio::time::timeout;
+use tracing::{error, info, warn};
+
+use super::SupervisorInner;
+
+const WATCH_INTERVAL: Duration = Duration::from_secs(60);
+
+pub(super) fn spawn_cert_watch(inner: Arc<SupervisorInner>) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(WATCH_INTERVAL);
+        loop {
+            tick.tick().await;
+            inner.watch_cert_once().await;
+        }
+    })
+}
+
+impl SupervisorInner {
+    async fn watch_cert_once(&self) {
+        // No TLS listener, no certificate to watch.
+        let Some(cert) = self
+            .tls_cert
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+        else {
+            return;
+        };
+        reload_logged(&cert);
+
+        let now = chatmail_db::policies::unix_now();
+        let cert_path = cert.cert_path().display().to_string();
+        let (not_before, not_after) = cert.validity();
+        let seen = self
+            .app
+            .cert
+            .observe(&cert_path, not_before, not_after, now);
+        if !seen.run_hook {
+            return;
+        }
+        let Some(hook) = self.file_config.effective_renewal_hook() else {
+            return;
+        };
+        let limit = self.file_config.effective_renewal_hook_timeout();
+        let days_left = (not_after - now).div_euclid(86_400);
+        match run_renewal_hook(&hook, &cert, days_left, limit).await {
+            Ok(()) => info!(hook = %hook, "tls: renewal_hook finished"),
+            Err(e) => error!(hook = %hook, error = %e, "tls: renewal_hook failed"),
+        }
+        // Pick up whatever the hook wrote without waiting for the next tick.
+        if reload_logged(&cert) {
+            let (not_before, not_after) = cert.validity();
+            self.app
+                .cert
+                .observe(&cert_path, not_before, not_after, now);
+        }
+    }
+}
+
+fn reload_logged(cert: &ReloadingCert) -> bool {
+    match cert.reload_if_changed() {
+        Ok(true) => {
+            info!(cert = %cert.cert_path().display(), "tls: certificate files changed, reloaded");
+            true
+        }
+        Ok(false) => false,
+        Err(e) => {
+            warn!(
+                cert = %cert.cert_path().display(),
+                error = %e,
+                "tls: certificate files changed but failed to load; keeping previous certificate"
+            );
+            false
+        }
+    }
+}
+
+/// Run `renewal_hook` through `sh -c` as the server's own user, killing it after `limit`.
+async fn run_renewal_hook(
+    hook: &str,
+    cert: &ReloadingCert,
+    days_left: i64,
+    limit: Duration,
+) -> Result<(), String> {
+    let mut cmd = Command::new("sh");
+    cmd.arg("-c")
+        .arg(hook)
+        .env("MADMAIL_CERT_PATH", cert.cert_path())
+        .env("MADMAIL_KEY_PATH", cert.key_path())
+        .env("MADMAIL_CERT_NOT_AFTER", cert.not_after().to_string())
+        .env("MADMAIL_CERT_DAYS_LEFT", days_left.to_string())
+        .stdin(std::process::Stdio::null())
+        .kill_on_drop(true);
+    let mut child = cmd.spawn().map_err(|e| format!("spawn: {e}"))?;
+    match timeout(limit, child.wait()).await {
+        Ok(Ok(status)) if status.success() => Ok(()),
+        Ok(Ok(status)) => Err(format!("exited with {status}")),
+        Ok(Err(e)) => Err(e.to_string()),
+        Err(_) => {
+            let _ = child.kill().await;
+            Err(format!("timed out after {}s", limit.as_secs()))
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn pair(dir: &Path) -> Arc<ReloadingCert> {
+        let cfg = chatmail_config::AppConfig {
+            tls_mode: Some("self_signed".into()),
+            hostname: Some("127.0.0.1".into()),
+            primary_domain: Some("127.0.0.1".into()),
+            ..Default::default()
+        };
+        let (cert, key) = crate::tls_boot::ensure_tls_pem_files(&cfg, dir).unwrap();
+        ReloadingCert::load(&cert, &key).unwrap()
+    }
+
+    #[tokio::test]
+    async fn hook_sees_certificate_environment() {
+        let dir = tempfile::tempdir().unwrap();
+        let cert = pair(dir.path());
+        let out = dir.path().join("hook.out");
+        let hook = format!(
+            "echo \"$MADMAIL_CERT_DAYS_LEFT $MADMAIL_CERT_PATH\" > {}",
+            out.display()
+        );
+        run_renewal_hook(&hook, &cert, 3, Duration::from_secs(10))
+            .await
+            .unwrap();
+        let written = std::fs::read_to_string(&out).unwrap();
+        assert_eq!(written.trim(), format!("3 {}", cert.cert_path().display()));
+
+        let failed = run_renewal_hook("exit 3", &cert, 3, Duration::from_secs(10)).await;
+        assert!(failed.unwrap_err().contains("exit"));
+    }
+
+    #[tokio::test]
+    async fn hook_is_killed_after_timeout() {
+        let dir = tempfile::tempdir().unwrap();
+        let cert = pair(dir.path());
+        let started = std::time::Instant::now();
+        let err = run_renewal_hook("sleep 30", &cert, 0, Duration::from_millis(200))
+            .await
+            .unwrap_err();
+        assert!(err.contains("timed out"), "{err}");
+        assert!(started.elapsed() < Duration::from_secs(10));
+    }
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//! Background probe behind `GET /status`: checks listeners, storage, the database, relays,
+//! clock skew and certificate expiry every [`PROBE_INTERVAL`] and logs outage/recovery edges
+//! to the incident table.
+//! The same tick refreshes the disk tiers in `AppState.disk`.
+
+use std::net::{IpAddr, Ipv4Addr, Ipv6Addr, SocketAddr};
+use std::sync::Arc;
+
+use chatmail_db::{record_incident, INCIDENT_OUTAGE, INCIDENT_RECOVERY};
+use chatmail_state::{HealthTransition, StatvfsProbe};
+use tokio::net::TcpStream;
+use tokio::task::JoinHandle;
+use tokio::time::{timeout, Duration};
+use tracing::{info, warn};
+
+use super::SupervisorInner;
+
+const PROBE_INTERVAL: Duration = Duration::from_secs(30);
+const PROBE_TIMEOUT: Duration = Duration::from_secs(5);
+
+type ProbeResult = std::result::Result<(), String>;
+
+pub(super) fn spawn_health_probe(inner: Arc<SupervisorInner>) -> JoinHandle<()> {
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(PROBE_INTERVAL);
+        loop {
+            tick.tick().await;
+            inner.probe_health_once().await;
+        }
+    })
+}
+
+impl SupervisorInner {
+    async fn probe_health_once(&self) {
+        let ports = self.app.listener_ports.snapshot();
+        let mut checks: Vec<(&'static str, ProbeResult)> = Vec::new();
+        if let Some(addr) = ports.smtp_addr.as_deref() {
+            checks.push(("smtp", probe_tcp(addr).await));
+        }
+        let listeners = [
+            (
+                "submission",
+                ports.submission_plain_addr.or(ports.submission_tls_addr),
+            ),
+            ("imap", ports.imap_plain_addr.or(ports.imap_tls_addr)),
+            ("http", ports.http_plain_addr.or(ports.http_tls_addr)),
+        ];
+        for (name, addr) in listeners {
+            if let Some(addr) = addr {
+                checks.push((name, probe_tcp(&addr).await));
+            }
+        }
+        // Disk tiers gate registrations and deliveries; they recover on the next probe.
+        let state_dir = self.app.mailbox_store.state_dir().to_path_buf();
+        self.app.disk.refresh(&StatvfsProbe::new(state_dir));
+        checks.push(("storage", self.probe_storage().await));
+        checks.push(("database", self.probe_database().await));
+        if self.turn_server.lock().await.is_some() {
+            // In-process UDP server: up for as long as its handle is held.
+            checks.push(("turn", Ok(())));
+        }
+        if self.app.clock.enabled() {
+            // Cached result of the hourly `clock_check`; nothing is fetched here.
+            checks.push(("clock", self.app.clock.skew_hint().map_or(Ok(()), Err)));
+        }
+        if self.app.cert.reading().is_some() {
+            // Cached by the certificate watch; down while inside `cert_expiry_warning`.
+            checks.push((
+                "tls_certificate",
+                self.app.cert.expiry_hint().map_or(Ok(()), Err),
+            ));
+        }
+        let iroh = self.iroh_relay.lock().await.as_ref().map(|h| h.listen);
+        if let Some(listen) = iroh {
+            checks.push(("iroh_relay", probe_tcp(&listen.to_string()).await));
+        }
+
+        let now = chatmail_db::policies::unix_now();
+        for (name, result) in checks {
+            let detail = result.as_ref().err().map(String::as_str).unwrap_or("");
+            let Some(edge) = self.app.health.observe(name, result.is_ok(), detail, now) else {
+                continue;
+            };
+            let (kind, message) = match edge {
+                HealthTransition::Outage => {
+                    warn!(component = name, error = detail, "health: component down");
+                    (INCIDENT_OUTAGE, format!("{name} unavailable"))
+                }
+                HealthTransition::Recovered => {
+                    info!(component = name, "health: component recovered");
+                    (INCIDENT_RECOVERY, format!("{name} recovered"))
+                }
+            };
+            if let Err(e) = record_incident(&self.pool, name, kind, &message).await {
+                warn!(component = name, error = %e, "health: incident not recorded");
+            }
+        }
+    }
+
+    /// Create and remove a file under the mail root.
+    async fn probe_storage(&self) -> ProbeResult {
+        let path = self.app.mailbox_store.state_dir().join(".health-probe");
+        let io = tokio::task::spawn_blocking(move || {
+            std::fs::write(&path, b"ok")?;
+            std::fs::remove_file(&path)
+        });
+        match timeout(PROBE_TIMEOUT, io).await {
+            Ok(Ok(Ok(()))) => Ok(()),
+            Ok(Ok(Err(e))) => Err(e.to_string()),
+            Ok(Err(e)) => Err(e.to_string()),
+            Err(_) => Err("timed out".into()),
+        }
+    }
+
+    async fn probe_database(&self) -> ProbeResult {
+        match timeout(PROBE_TIMEOUT, self.pool.ping()).await {
+            Ok(Ok(())) => Ok(()),
+            Ok(Err(e)) => Err(e.to_string()),
+            Err(_) => Err("timed out".into()),
+        }
+    }
+}
+
+/// TCP connect to a bind address; wildcard hosts are probed on loopback.
+async fn probe_tcp(bind: &str) -> ProbeResult {
+    let mut addr: SocketAddr = bind.parse().map_err(|_| format!("bad address {bind}"))?;
+    if addr.ip().is_unspecified() {
+        addr.set_ip(match addr.ip() {
+            IpAddr::V4(_) => IpAddr::V4(Ipv4Addr::LOCALHOST),
+            IpAddr::V6(_) => IpAddr::V6(Ipv6Addr::LOCALHOST),
+        });
+    }
+    match timeout(PROBE_TIMEOUT, TcpStream::connect(addr)).await {
+        Ok(Ok(_)) => Ok(()),
+        Ok(Err(e)) => Err(e.to_string()),
+        Err(_) => Err("timed out".into()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn probe_tcp_maps_wildcard_to_loopback() {
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let port = listener.local_addr().unwrap().port();
+        assert_eq!(probe_tcp(&format!("0.0.0.0:{port}")).await, Ok(()));
+        drop(listener);
+        assert!(probe_tcp(&format!("127.0.0.1:{port}")).await.is_err());
+        assert!(probe_tcp("mail.example:25").await.is_err());
+    }
+}
@@ -29,6 +29,7 @@ use chatmail_types::{ChatmailError, Result};
 use ed25519_dalek::{Signature, Verifier, VerifyingKey};
 use flate2::read::GzDecoder;
 use reqwest::blocking::Client;
+use sha2::{Digest, Sha256};
 use tar::Archive;
 
 /// Madmail release signing public key (`internal/auth/signature_key.go`).
@@ -36,6 +37,18 @@ const PUBLIC_KEY_HEX: &str = "7cb0bcc1d8e91e51f631c9ad6025e8e6e0222a27c3eeaf8608
 
 const SIGNATURE_LEN: usize = 64;
 const MAX_DOWNLOAD_SIZE: u64 = 100 * 1024 * 1024; // 100 MB
+const MAX_CHECKSUM_SIZE: u64 = 4 * 1024;
+
+/// Flags shared by `upgrade` and `update`.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct UpgradeOptions {
+    /// `--accept-unsafe-https`: allow HTTPS with untrusted TLS certs.
+    pub accept_unsafe_https: bool,
+    /// `--backup`: keep the replaced binary as `<binary>.bak` and restore it on failure.
+    pub backup: bool,
+    /// `--dry-run`: verify the new binary, then only print the planned steps.
+    pub dry_run: bool,
+}
 
 fn verifying_key() -> Result<VerifyingKey> {
     let bytes = hex::decode(PUBLIC_KEY_HEX)
@@ -328,23 +341,24 @@ fn extract_binary_from_tar_gz(archive_path: &Path, dest: &Path) -> Result<()> {
 
 /// Entry point for `chatmail upgrade` and `chatmail update` (Madmail `upgradeCommand`).
 ///
-/// `accept_unsafe_https` maps to `--accept-unsafe-https`: allow HTTPS with untrusted TLS certs.
-/// Without it, TLS is verified; on certificate failure an interactive TTY may prompt.
-pub fn upgrade_command(input: &str, args: &Args, accept_unsafe_https: bool) -> Result<()> {
+/// Without `--accept-unsafe-https`, TLS is verified; on certificate failure an interactive
+/// TTY may prompt. A `.sha256` file next to the source is checked before anything else.
+pub fn upgrade_command(input: &str, args: &Args, opts: &UpgradeOptions) -> Result<()> {
     let input = input.trim();
     if input.is_empty() {
         return Err(ChatmailError::config("PATH or URL is required"));
     }
     let result = if is_download_url(input) {
-        handle_update_url(input, args, accept_unsafe_https)
+        handle_update_url(input, args, opts)
     } else {
-        perform_upgrade(Path::new(input), args)
+        verify_local_checksum(Path::new(input))
+            .and_then(|()| perform_upgrade(Path::new(input), args, opts))
     };
     if result.is_ok() && args.json {
         let envelope = serde_json::json!({
             "ok": true,
             "command": "upgrade",
-            "data": {}
+            "data": { "dry_run": opts.dry_run }
         });
         if let Ok(body) = serde_json::to_string(&envelope) {
             println!("{body}");
@@ -353,6 +367,93 @@ pub fn upgrade_command(input: &str, args: &Args, accept_unsafe_https: bool) -> R
     result
 }
 
+/// Digest from a `sha256sum`-style file: `<hex>  <name>` or the bare hex.
+fn parse_sha256_sidecar(body: &str) -> Result<String> {
+    let digest = body.split_whitespace().next().unwrap_or_default();
+    if digest.len() != 64 || !digest.bytes().all(|b| b.is_ascii_hexdigit()) {
+        return Err(ChatmailError::config(
+            "checksum file does not start with a SHA-256 hex digest",
+        ));
+    }
+    Ok(digest.to_ascii_lowercase())
+}
+
+fn sha256_file(path: &Path) -> Result<String> {
+    let mut file = File::open(path)
+        .map_err(|e| ChatmailError::config(format!("open {}: {e}", path.display())))?;
+    let mut hasher = Sha256::new();
+    io::copy(&mut file, &mut hasher)
+        .map_err(|e| ChatmailError::config(format!("read {}: {e}", path.display())))?;
+    Ok(hex::encode(hasher.finalize()))
+}
+
+fn verify_sha256(path: &Path, expected: &str) -> Result<()> {
+    eprintln!("🔍 Verifying SHA-256 checksum...");
+    let actual = sha256_file(path)?;
+    if actual != expected {
+        return Err(ChatmailError::config(format!(
+            "CHECKSUM MISMATCH: expected {expected}, got {actual}; upgrade aborted"
+        )));
+    }
+    eprintln!("✅ Checksum matches.");
+    Ok(())
+}
+
+/// Check `<path>.sha256` when it exists; the Ed25519 signature is checked either way.
+fn verify_local_checksum(path: &Path) -> Result<()> {
+    let mut sidecar = path.as_os_str().to_owned();
+    sidecar.push(".sha256");
+    let sidecar = PathBuf::from(sidecar);
+    match fs::read_to_string(&sidecar) {
+        Ok(body) => verify_sha256(path, &parse_sha256_sidecar(&body)?),
+        Err(e) if e.kind() == io::ErrorKind::NotFound => {
+            eprintln!(
+                "ℹ️ No {} — relying on the signature check.",
+                sidecar.display()
+            );
+            Ok(())
+        }
+        Err(e) => Err(ChatmailError::config(format!(
+            "read {}: {e}",
+            sidecar.display()
+        ))),
+    }
+}
+
+/// `https://host/a.tar.gz?x=1` → `https://host/a.tar.gz.sha256?x=1`.
+fn checksum_url(url: &str) -> String {
+    let cut = url.find(['?', '#']).unwrap_or(url.len());
+    format!("{}.sha256{}", &url[..cut], &url[cut..])
+}
+
+/// Fetch the release's `.sha256` file. `None` when it is not published or unreachable;
+/// the download is then trusted on its signature alone.
+fn fetch_url_checksum(url: &str, accept_unsafe_https: bool) -> Result<Option<String>> {
+    let sidecar = checksum_url(url);
+    let resp = match build_download_client(accept_unsafe_https)?
+        .get(&sidecar)
+        .send()
+    {
+        Ok(resp) if resp.status().is_success() => resp,
+        Ok(resp) => {
+            eprintln!(
+                "ℹ️ No checksum at {sidecar} ({}) — relying on the signature check.",
+                resp.status()
+            );
+            return Ok(None);
+        }
+        Err(e) => {
+            eprintln!("⚠️ Could not fetch {sidecar} ({e}) — relying on the signature check.");
+            return Ok(None);
+        }
+    };
+    let mut body = String::new();
+    resp.take(MAX_CHECKSUM_SIZE)
+        .read_to_string(&mut body)
+        .map_err(|e| ChatmailError::config(format!("read {sidecar}: {e}")))?;
+    parse_sha256_sidecar(&body).map(Some)
+}
+
 fn build_download_client(accept_invalid_certs: bool) -> Result<Client> {
     let mut builder = Client::builder().timeout(Duration::from_secs(300));
     if accept_invalid_certs {
@@ -465,7 +566,7 @@ fn download_url_response(
 ///
 /// Local path upgrades never enter this function (`upgrade_command` calls
 /// `perform_upgrade` directly).
-fn handle_update_url(url: &str, args: &Args, accept_unsafe_https: bool) -> Result<()> {
+fn handle_update_url(url: &str, args: &Args, opts: &UpgradeOptions) -> Result<()> {
     check_supported_url_archive(url)?;
 
     let (download_path, mut tmp_file) = create_private_temp_file("madmail-update")?;
@@ -476,7 +577,7 @@ fn handle_update_url(url: &str, args: &Args, accept_unsafe_https: bool) -> Resul
 
     eprintln!("📥 Downloading {url}...");
 
-    let resp = match download_url_response(url, args, accept_unsafe_https) {
+    let resp = match download_url_response(url, args, opts.accept_unsafe_https) {
         Ok(r) => r,
         Err(e) => {
             cleanup_download();
@@ -525,6 +626,17 @@ fn handle_update_url(url: &str, args: &Args, accept_unsafe_https: bool) -> Resul
         .len();
     eprintln!("✅ Downloaded {n} bytes");
 
+    // The checksum covers the published file, i.e. the archive before extraction.
+    let checked = match fetch_url_checksum(url, opts.accept_unsafe_https) {
+        Ok(Some(expected)) => verify_sha256(&download_path, &expected),
+        Ok(None) => Ok(()),
+        Err(e) => Err(e),
+    };
+    if let Err(e) = checked {
+        cleanup_download();
+        return Err(e);
+    }
+
     // If the URL is a release archive, extract the signed `madmail` binary first.
     // Signature verification must never run on the .tar.gz bytes themselves.
     let (bin_path, extracted_tmp) = if is_tar_gz_url(url) {
@@ -542,7 +654,7 @@ fn handle_update_url(url: &str, args: &Args, accept_unsafe_https: bool) -> Resul
     };
 
     // Traditional upgrade path (identical to local-path upgrades).
-    let result = perform_upgrade(&bin_path, args);
+    let result = perform_upgrade(&bin_path, args, opts);
     if extracted_tmp {
         let _ = fs::remove_file(&bin_path);
     } else {
@@ -551,20 +663,48 @@ fn handle_update_url(url: &str, args: &Args, accept_unsafe_https: bool) -> Resul
     result
 }
 
-fn systemd_service_name() -> String {
+/// Unit name for this executable: `madmail` → `madmail`, matching `install`.
+fn service_binary_name() -> String {
     std::env::current_exe()
         .ok()
         .and_then(|p| p.file_name().map(|n| n.to_string_lossy().into_owned()))
-        .map(|name| format!("{name}.service"))
-        .unwrap_or_else(|| "madmail.service".into())
+        .unwrap_or_else(|| "madmail".into())
+}
+
+fn systemd_service_name() -> String {
+    format!("{}.service", service_binary_name())
 }
 
 fn run_systemctl(args: &[&str]) {
     let _ = Command::new("systemctl").args(args).status();
 }
 
-/// Upgrade in place: verify signature, stop service, replace executable, start service.
-pub fn perform_upgrade(new_bin_path: &Path, args: &Args) -> Result<()> {
+fn systemctl_ok(args: &[&str]) -> bool {
+    Command::new("systemctl")
+        .args(args)
+        .status()
+        .map(|s| s.success())
+        .unwrap_or(false)
+}
+
+fn backup_path(bin: &Path) -> PathBuf {
+    let mut name = bin.as_os_str().to_owned();
+    name.push(".bak");
+    PathBuf::from(name)
+}
+
+/// What a failed upgrade has to put back.
+#[derive(Default)]
+struct Rollback {
+    backup: Option<PathBuf>,
+    /// Unit path and its body before it was re-rendered.
+    unit: Option<(PathBuf, String)>,
+}
+
+/// Upgrade in place: verify signature, stop service, replace executable, re-render the
+/// systemd unit, start service. With `--backup`, any failure after the stop restores the
+/// previous binary and unit and starts the old service again.
+pub fn perform_upgrade(new_bin_path: &Path, args: &Args, opts: &UpgradeOptions) -> Result<()> {
     eprintln!("🔍 Verifying digital signature...");
     match verify_signature(new_bin_path)? {
         true => eprintln!("✅ Signature verification successful."),
@@ -581,6 +721,12 @@ pub fn perform_upgrade(new_bin_path: &Path, args: &Args) -> Result<()> {
 
     eprintln!("🚀 Target binary: {}", real_bin_path.display());
 
+    let service = systemd_service_name();
+    if opts.dry_run {
+        print_upgrade_plan(&real_bin_path, &service, opts);
+        return Ok(());
+    }
+
     #[cfg(unix)]
     if unsafe { libc::geteuid() } != 0 {
         return Err(ChatmailError::config(
@@ -588,12 +734,55 @@ pub fn perform_upgrade(new_bin_path: &Path, args: &Args) -> Result<()> {
         ));
     }
 
-    let service = systemd_service_name();
     eprintln!("⏹️ Stopping services...");
     run_systemctl(&["stop", &service]);
     run_systemctl(&["stop", "iroh-relay.service"]);
     thread::sleep(Duration::from_secs(1));
 
+    let mut rollback = Rollback::default();
+    if opts.backup {
+        let bak = backup_path(&real_bin_path);
+        if let Err(e) = fs::copy(&real_bin_path, &bak) {
+            run_systemctl(&["start", &service]);
+            return Err(ChatmailError::config(format!(
+                "failed to back up {} to {}: {e}",
+                real_bin_path.display(),
+                bak.display()
+            )));
+        }
+        eprintln!("💾 Backed up current binary to {}", bak.display());
+        rollback.backup = Some(bak);
+    }
+
+    if let Err(e) = install_and_start(new_bin_path, &real_bin_path, &service, args, &mut rollback) {
+        if let Some(bak) = &rollback.backup {
+            restore_previous(bak, &real_bin_path, &service, &rollback);
+        }
+        return Err(e);
+    }
+
+    let iroh_unit = PathBuf::from("/etc/systemd/system/iroh-relay.service");
+    if iroh_unit.is_file() && !systemctl_ok(&["start", "iroh-relay.service"]) {
+        eprintln!(
+                "⚠️ Warning: failed to start iroh-relay.service; try: systemctl start iroh-relay.service"
+            );
+    }
+
+    refresh_cli_docs_after_upgrade();
+
+    eprintln!("🎉 Upgrade complete.");
+    Ok(())
+}
+
+/// Replace the binary, re-render its unit, and start the service. Without a backup a
+/// failed start only warns, since there is nothing to roll back to.
+fn install_and_start(
+    new_bin_path: &Path,
+    real_bin_path: &Path,
+    service: &str,
+    args: &Args,
+    rollback: &mut Rollback,
+) -> Result<()> {
     eprintln!("🔄 Replacing binary...");
     let tmp_dir = real_bin_path
         .parent()
@@ -614,42 +803,90 @@ pub fn perform_upgrade(new_bin_path: &Path, args: &Args) -> Result<()> {
         fs::set_permissions(&tmp_path, fs::Permissions::from_mode(0o755))?;
     }
 
-    fs::rename(&tmp_path, &real_bin_path).map_err(|e| {
+    fs::rename(&tmp_path, real_bin_path).map_err(|e| {
         let _ = fs::remove_file(&tmp_path);
         ChatmailError::config(format!("failed to replace binary: {e}"))
     })?;
 
     // Run post-upgrade hooks from the *new* binary so first upgrades that ship
     // html-migrate still work (this process is still the old code).
-    run_post_upgrade_www_migrate(&real_bin_path, args);
+    run_post_upgrade_www_migrate(real_bin_path, args);
 
-    eprintln!("▶️ Starting services...");
-    if !Command::new("systemctl")
-        .args(["start", &service])
-        .status()
-        .map(|s| s.success())
-        .unwrap_or(false)
+    #[cfg(unix)]
     {
+        let name = service_binary_name();
+        if let Some(previous) = crate::ctl::refresh_installed_unit(&name, real_bin_path)? {
+            let unit = crate::ctl::unit_path(&name);
+            eprintln!("📝 Re-rendered {}", unit.display());
+            rollback.unit = Some((unit, previous));
+            if !systemctl_ok(&["daemon-reload"]) {
+                return Err(ChatmailError::config("systemctl daemon-reload failed"));
+            }
+        }
+    }
+
+    eprintln!("▶️ Starting services...");
+    if !systemctl_ok(&["start", service]) {
+        if rollback.backup.is_some() {
+            return Err(ChatmailError::config(format!(
+                "failed to start {service} with the new binary"
+            )));
+        }
         eprintln!("⚠️ Warning: failed to start {service}; try: systemctl start {service}");
     }
+    Ok(())
+}
 
-    let iroh_unit = PathBuf::from("/etc/systemd/system/iroh-relay.service");
-    if iroh_unit.is_file()
-        && !Command::new("systemctl")
-            .args(["start", "iroh-relay.service"])
-            .status()
-            .map(|s| s.success())
-            .unwrap_or(false)
-    {
+/// Put the backed-up binary (and the previous unit, if it was rewritten) back in place.
+fn restore_previous(bak: &Path, real_bin_path: &Path, service: &str, rollback: &Rollback) {
+    eprintln!("↩️ Upgrade failed; restoring {}...", bak.display());
+    run_systemctl(&["stop", service]);
+    if let Err(e) = fs::copy(bak, real_bin_path) {
         eprintln!(
-                "⚠️ Warning: failed to start iroh-relay.service; try: systemctl start iroh-relay.service"
-            );
+            "⚠️ Could not restore the previous binary ({e}); copy {} to {} by hand",
+            bak.display(),
+            real_bin_path.display()
+        );
+        return;
     }
+    if let Some((unit, body)) = &rollback.unit {
+        if let Err(e) = fs::write(unit, body) {
+            eprintln!("⚠️ Could not restore {} ({e})", unit.display());
+        }
+        run_systemctl(&["daemon-reload"]);
+    }
+    if systemctl_ok(&["start", service]) {
+        eprintln!("✅ Previous binary restored and {service} started.");
+    } else {
+        eprintln!("⚠️ Previous binary restored, but {service} did not start.");
+    }
+}
 
-    refresh_cli_docs_after_upgrade();
-
-    eprintln!("🎉 Upgrade complete.");
-    Ok(())
+fn print_upgrade_plan(real_bin_path: &Path, service: &str, opts: &UpgradeOptions) {
+    eprintln!("📝 Dry run — nothing will be changed. Planned steps:");
+    eprintln!("   • systemctl stop {service}");
+    if opts.backup {
+        eprintln!(
+            "   • copy {} to {}",
+            real_bin_path.display(),
+            backup_path(real_bin_path).display()
+        );
+    }
+    eprintln!("   • replace {} (write + rename)", real_bin_path.display());
+    #[cfg(unix)]
+    {
+        let unit = crate::ctl::unit_path(&service_binary_name());
+        if unit.is_file() {
+            eprintln!(
+                "   • re-render {} and systemctl daemon-reload",
+                unit.display()
+            );
+        }
+    }
+    eprintln!("   • systemctl start {service}");
+    if opts.backup {
+        eprintln!("   • on failure: restore the backup and start {service} again");
+    }
 }
 
 /// Ask the new binary to migrate custom `www_dir` Go templates (interactive).
@@ -736,6 +973,13 @@ mod tests {
         }
     }
 
+    fn unsafe_https() -> UpgradeOptions {
+        UpgradeOptions {
+            accept_unsafe_https: true,
+            ..UpgradeOptions::default()
+        }
+    }
+
     fn write_tar_gz(path: &Path, members: &[(&str, &[u8])]) {
         let file = File::create(path).unwrap();
         let enc = GzEncoder::new(file, Compression::default());
@@ -812,15 +1056,19 @@ mod tests {
 
     #[test]
     fn upgrade_command_requires_input() {
-        let err = upgrade_command("  ", &test_args(), false).unwrap_err();
+        let err = upgrade_command("  ", &test_args(), &UpgradeOptions::default()).unwrap_err();
         assert!(err.to_string().contains("PATH or URL is required"));
     }
 
     #[test]
     fn upgrade_command_rejects_zip_url_without_download() {
         // Must fail before any network I/O.
-        let err =
-            upgrade_command("https://example.com/madmail.zip", &test_args(), false).unwrap_err();
+        let err = upgrade_command(
+            "https://example.com/madmail.zip",
+            &test_args(),
+            &UpgradeOptions::default(),
+        )
+        .unwrap_err();
         assert!(
             err.to_string().contains("unsupported archive format"),
             "got: {err}"
@@ -829,8 +1077,12 @@ mod tests {
 
     #[test]
     fn upgrade_command_rejects_tar_bz2_url_without_download() {
-        let err = upgrade_command("https://example.com/madmail.tar.bz2", &test_args(), false)
-            .unwrap_err();
+        let err = upgrade_command(
+            "https://example.com/madmail.tar.bz2",
+            &test_args(),
+            &UpgradeOptions::default(),
+        )
+        .unwrap_err();
         assert!(
             err.to_string().contains("unsupported archive format"),
             "got: {err}"
@@ -953,7 +1205,7 @@ mod tests {
         let body = fs::read(&archive).unwrap();
         let (url, server) = serve_once(body);
 
-        let err = upgrade_command(&url, &test_args(), false).unwrap_err();
+        let err = upgrade_command(&url, &test_args(), &UpgradeOptions::default()).unwrap_err();
         let msg = err.to_string();
         assert!(
             msg.contains("INVALID SIGNATURE"),
@@ -984,7 +1236,7 @@ mod tests {
         ready_rx.recv().unwrap();
         let url = format!("http://{addr}/madmail");
 
-        let err = upgrade_command(&url, &test_args(), false).unwrap_err();
+        let err = upgrade_command(&url, &test_args(), &UpgradeOptions::default()).unwrap_err();
         let msg = err.to_string();
         assert!(
             msg.contains("INVALID SIGNATURE"),
@@ -999,10 +1251,72 @@ mod tests {
         let dir = tempfile::tempdir().unwrap();
         let bin = dir.path().join("madmail-signed");
         fs::write(&bin, vec![b'L'; 128]).unwrap();
-        let err = upgrade_command(bin.to_str().unwrap(), &test_args(), false).unwrap_err();
+        let err = upgrade_command(
+            bin.to_str().unwrap(),
+            &test_args(),
+            &UpgradeOptions::default(),
+        )
+        .unwrap_err();
+        assert!(err.to_string().contains("INVALID SIGNATURE"), "got: {err}");
+    }
+
+    #[test]
+    fn checksum_mismatch_aborts_before_writing_anything() {
+        let dir = tempfile::tempdir().unwrap();
+        let bin = dir.path().join("madmail-signed");
+        fs::write(&bin, vec![b'C'; 128]).unwrap();
+        let wrong = "0".repeat(64);
+        fs::write(
+            dir.path().join("madmail-signed.sha256"),
+            format!("{wrong}  madmail\n"),
+        )
+        .unwrap();
+        let opts = UpgradeOptions {
+            backup: true,
+            ..UpgradeOptions::default()
+        };
+
+        let err = upgrade_command(bin.to_str().unwrap(), &test_args(), &opts).unwrap_err();
+        assert!(err.to_string().contains("CHECKSUM MISMATCH"), "got: {err}");
+        let mut names: Vec<_> = fs::read_dir(dir.path())
+            .unwrap()
+            .map(|e| e.unwrap().file_name().into_string().unwrap())
+            .collect();
+        names.sort();
+        assert_eq!(names, ["madmail-signed", "madmail-signed.sha256"]);
+        let exe = std::env::current_exe().unwrap();
+        assert!(!backup_path(&exe).exists(), "no backup written");
+    }
+
+    #[test]
+    fn matching_checksum_falls_through_to_signature_check() {
+        let dir = tempfile::tempdir().unwrap();
+        let bin = dir.path().join("madmail-signed");
+        fs::write(&bin, vec![b'M'; 128]).unwrap();
+        let digest = sha256_file(&bin).unwrap().to_ascii_uppercase();
+        fs::write(dir.path().join("madmail-signed.sha256"), digest).unwrap();
+        let err = upgrade_command(
+            bin.to_str().unwrap(),
+            &test_args(),
+            &UpgradeOptions::default(),
+        )
+        .unwrap_err();
         assert!(err.to_string().contains("INVALID SIGNATURE"), "got: {err}");
     }
 
+    #[test]
+    fn checksum_url_keeps_query_string() {
+        assert_eq!(
+            checksum_url("https://example.com/a.tar.gz?token=x"),
+            "https://example.com/a.tar.gz.sha256?token=x"
+        );
+        assert_eq!(
+            checksum_url("https://example.com/madmail"),
+            "https://example.com/madmail.sha256"
+        );
+        assert!(parse_sha256_sidecar("not-a-digest  madmail").is_err());
+    }
+
     /// When the official signing key is available, prove signed `madmail` inside
     /// `.tar.gz` passes verification (the full traditional check) after extract.
     #[test]
@@ -1043,7 +1357,7 @@ mod tests {
         // Full URL pipeline: download .tar.gz → extract → perform_upgrade verify.
         let body = fs::read(&archive).unwrap();
         let (url, server) = serve_once(body);
-        let err = upgrade_command(&url, &test_args(), false).unwrap_err();
+        let err = upgrade_command(&url, &test_args(), &UpgradeOptions::default()).unwrap_err();
         let msg = err.to_string();
         // Signature OK; non-root should fail before replace (or root-only env).
         assert!(
@@ -1204,7 +1518,7 @@ httpd.handle_request()
             eprintln!("skip: openssl/python HTTPS harness unavailable");
             return;
         };
-        let err = upgrade_command(&url, &test_args(), false).unwrap_err();
+        let err = upgrade_command(&url, &test_args(), &UpgradeOptions::default()).unwrap_err();
         let msg = err.to_string();
         assert!(
             msg.contains("--accept-unsafe-https")
@@ -1224,7 +1538,7 @@ httpd.handle_request()
             eprintln!("skip: openssl/python HTTPS harness unavailable");
             return;
         };
-        let err = upgrade_command(&url, &test_args(), true).unwrap_err();
+        let err = upgrade_command(&url, &test_args(), &unsafe_https()).unwrap_err();
         let msg = err.to_string();
         assert!(
             msg.contains("INVALID SIGNATURE"),
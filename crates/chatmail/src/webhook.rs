@@ -0,0 +1,151 @@
+// Copyright (C) 2026 themadorg
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//! Account lifecycle webhooks: drain [`Webhooks`]' queue and POST every event to
+//! `webhook_url` over reqwest.
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use async_trait::async_trait;
+use chatmail_state::{
+    WebhookDelivery, WebhookSender, Webhooks, SIGNATURE_HEADER, WEBHOOK_EVENT_HEADER,
+};
+use tokio::task::JoinHandle;
+
+const REQUEST_TIMEOUT: Duration = Duration::from_secs(15);
+
+/// JSON `POST` with the event and signature headers.
+pub struct HttpWebhookSender {
+    client: reqwest::Client,
+}
+
+impl HttpWebhookSender {
+    pub fn new() -> Self {
+        let client = reqwest::Client::builder()
+            .timeout(REQUEST_TIMEOUT)
+            .redirect(reqwest::redirect::Policy::none())
+            .build()
+            .unwrap_or_default();
+        Self { client }
+    }
+}
+
+impl Default for HttpWebhookSender {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[async_trait]
+impl WebhookSender for HttpWebhookSender {
+    async fn post(&self, url: &str, delivery: &WebhookDelivery) -> Result<(), String> {
+        let mut req = self
+            .client
+            .post(url)
+            .header(reqwest::header::CONTENT_TYPE, "application/json")
+            .header(WEBHOOK_EVENT_HEADER, delivery.event.as_str())
+            .body(delivery.body.clone());
+        if let Some(signature) = &delivery.signature {
+            req = req.header(SIGNATURE_HEADER, signature);
+        }
+        let resp = req.send().await.map_err(|e| e.to_string())?;
+        if !resp.status().is_success() {
+            return Err(format!("HTTP {}", resp.status()));
+        }
+        Ok(())
+    }
+}
+
+/// Deliver queued events one at a time, in order. `None` without `webhook_url`, or when a
+/// worker already owns the queue.
+pub fn start_webhook_worker(webhooks: Arc<Webhooks>) -> Option<JoinHandle<()>> {
+    if webhooks.url().is_none() {
+        return None;
+    }
+    let mut queue = webhooks.take_queue()?;
+    let sender = HttpWebhookSender::new();
+    Some(tokio::spawn(async move {
+        while let Some(delivery) = queue.recv().await {
+            if let Err(e) = webhooks.deliver(&sender, &delivery).await {
+                tracing::warn!(
+                    event = delivery.event.as_str(),
+                    error = %e,
+                    "webhook delivery failed; event dropped"
+                );
+            }
+        }
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chatmail_state::{verify_info, WebhookEvent};
+    use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+    #[tokio::test]
+    async fn worker_posts_signed_events() {
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        let server = tokio::spawn(async move {
+            let (mut sock, _) = listener.accept().await.unwrap();
+            let mut acc = Vec::new();
+            let mut buf = [0u8; 4096];
+            while !acc.ends_with(br#""event":"account_created"}"#) {
+                let n = sock.read(&mut buf).await.unwrap();
+                if n == 0 {
+                    break;
+                }
+                acc.extend_from_slice(&buf[..n]);
+            }
+            sock.write_all(b"HTTP/1.1 204 No Content\r\n\r\n")
+                .await
+                .unwrap();
+            String::from_utf8_lossy(&acc).into_owned()
+        });
+
+        let hooks = Arc::new(Webhooks::new(
+            Some(format!("http://{addr}/hook")),
+            Some("s3cret".into()),
+            vec![WebhookEvent::AccountCreated],
+        ));
+        let _worker = start_webhook_worker(Arc::clone(&hooks)).unwrap();
+        assert!(start_webhook_worker(Arc::clone(&hooks)).is_none());
+        hooks.emit(
+            WebhookEvent::AccountCreated,
+            serde_json::json!({ "username": "a@test", "source": "registration" }),
+        );
+
+        let request = tokio::time::timeout(Duration::from_secs(5), server)
+            .await
+            .unwrap()
+            .unwrap();
+        assert!(request.starts_with("POST /hook "), "{request}");
+        let lower = request.to_ascii_lowercase();
+        assert!(
+            lower.contains("x-madmail-event: account_created"),
+            "{request}"
+        );
+        let (head, body) = request.split_once("\r\n\r\n").unwrap();
+        let signature = head
+            .lines()
+            .find_map(|l| l.strip_prefix("x-madmail-signature: "))
+            .unwrap();
+        assert!(verify_info(&["s3cret".into()], body.as_bytes(), signature));
+    }
+}
@@ -0,0 +1,231 @@
+//! Smoke test for `madmail dev`: register over HTTP, send over submission, read over IMAP.
+#![cfg(unix)]
+
+use std::io::{BufRead, BufReader, Write};
+use std::net::{TcpListener, TcpStream};
+use std::process::{Child, Command, Stdio};
+use std::time::{Duration, Instant};
+
+use assert_cmd::cargo::cargo_bin;
+use base64::Engine;
+use serde_json::Value;
+
+/// Offsets of the standard ports `madmail dev` adds to `--port-base`.
+const OFFSETS: [u16; 7] = [25, 587, 465, 143, 993, 80, 443];
+
+/// A port base whose whole block is currently free on loopback.
+fn free_port_base() -> u16 {
+    for _ in 0..50 {
+        let probe = TcpListener::bind("127.0.0.1:0").expect("bind probe");
+        let port = probe.local_addr().unwrap().port();
+        drop(probe);
+        let Some(base) = port.checked_sub(1000).filter(|b| *b > 1024 && *b < 64000) else {
+            continue;
+        };
+        let free = OFFSETS
+            .iter()
+            .all(|o| TcpListener::bind(("127.0.0.1", base + o)).is_ok());
+        if free {
+            return base;
+        }
+    }
+    panic!("no free port block for madmail dev");
+}
+
+struct DevServer(Child);
+
+impl Drop for DevServer {
+    fn drop(&mut self) {
+        let _ = self.0.kill();
+        let _ = self.0.wait();
+    }
+}
+
+fn wait_for_port(port: u16) {
+    let deadline = Instant::now() + Duration::from_secs(30);
+    while Instant::now() < deadline {
+        if TcpStream::connect(("127.0.0.1", port)).is_ok() {
+            return;
+        }
+        std::thread::sleep(Duration::from_millis(100));
+    }
+    panic!("port {port} did not open");
+}
+
+struct Line<'a> {
+    reader: BufReader<&'a TcpStream>,
+    writer: &'a TcpStream,
+}
+
+impl<'a> Line<'a> {
+    fn new(stream: &'a TcpStream) -> Self {
+        stream
+            .set_read_timeout(Some(Duration::from_secs(10)))
+            .unwrap();
+        Self {
+            reader: BufReader::new(stream),
+            writer: stream,
+        }
+    }
+
+    fn send(&mut self, line: &str) {
+        self.writer
+            .write_all(format!("{line}\r\n").as_bytes())
+            .unwrap();
+    }
+
+    fn read(&mut self) -> String {
+        let mut line = String::new();
+        self.reader.read_line(&mut line).expect("read line");
+        line
+    }
+
+    /// SMTP reply (multi-line aware); panics unless it starts with `code`.
+    fn smtp(&mut self, code: &str) -> String {
+        loop {
+            let line = self.read();
+            assert!(line.starts_with(code), "expected {code}, got {line:?}");
+            if line.as_bytes().get(3) != Some(&b'-') {
+                return line;
+            }
+        }
+    }
+
+    /// IMAP lines up to the tagged completion; panics unless it is `OK`.
+    fn imap(&mut self, tag: &str) -> String {
+        let mut all = String::new();
+        loop {
+            let line = self.read();
+            assert!(!line.is_empty(), "IMAP connection closed: {all}");
+            all.push_str(&line);
+            if let Some(rest) = line.strip_prefix(&format!("{tag} ")) {
+                assert!(rest.starts_with("OK"), "{tag} failed: {all}");
+                return all;
+            }
+        }
+    }
+}
+
+fn imap_quote(s: &str) -> String {
+    format!("\"{}\"", s.replace('\\', "\\\\").replace('"', "\\\""))
+}
+
+#[test]
+fn dev_mode_registers_sends_and_reads_back() {
+    let base = free_port_base();
+    let mut child = Command::new(cargo_bin("madmail"))
+        .args(["--json", "dev", "--port-base", &base.to_string()])
+        .stdout(Stdio::piped())
+        .stderr(Stdio::null())
+        .spawn()
+        .expect("spawn madmail dev");
+    let stdout = child.stdout.take().unwrap();
+    let server = DevServer(child);
+
+    // Keep the pipe open for the server's lifetime so later prints don't hit EPIPE.
+    let mut stdout = BufReader::new(stdout);
+    let mut summary = String::new();
+    stdout.read_line(&mut summary).expect("read summary");
+    let summary: Value = serde_json::from_str(&summary).expect("summary JSON");
+    let data = &summary["data"];
+    let state_dir = std::path::PathBuf::from(data["state_dir"].as_str().unwrap());
+    assert_eq!(data["temporary"], true);
+    assert!(data["dclogin"]
+        .as_str()
+        .unwrap()
+        .starts_with("dclogin:dev@"));
+    let sender = data["account"]["email"].as_str().unwrap().to_string();
+    let sender_pw = data["account"]["password"].as_str().unwrap().to_string();
+    assert_eq!(
+        data["endpoints"]["submission"],
+        format!("127.0.0.1:{}", base + 587)
+    );
+
+    let (http, submission, imap) = (base + 80, base + 587, base + 143);
+    for port in [http, submission, imap] {
+        wait_for_port(port);
+    }
+
+    // Register a fresh account over HTTP.
+    let registered: Value = reqwest::blocking::Client::new()
+        .post(format!("http://127.0.0.1:{http}/new"))
+        .send()
+        .expect("POST /new")
+        .json()
+        .expect("/new JSON");
+    let rcpt = registered["email"].as_str().expect("email").to_string();
+    let rcpt_pw = registered["password"]
+        .as_str()
+        .expect("password")
+        .to_string();
+
+    // Send from the pre-created account over submission.
+    let stream = TcpStream::connect(("127.0.0.1", submission)).unwrap();
+    let mut smtp = Line::new(&stream);
+    smtp.smtp("220");
+    smtp.send("EHLO dev-test");
+    smtp.smtp("250");
+    let plain =
+        base64::engine::general_purpose::STANDARD.encode(format!("\0{sender}\0{sender_pw}"));
+    smtp.send(&format!("AUTH PLAIN {plain}"));
+    smtp.smtp("235");
+    smtp.send(&format!("MAIL FROM:<{sender}>"));
+    smtp.smtp("250");
+    smtp.send(&format!("RCPT TO:<{rcpt}>"));
+    smtp.smtp("250");
+    smtp.send("DATA");
+    smtp.smtp("354");
+    smtp.send(&format!(
+        "From: <{sender}>\r\nTo: <{rcpt}>\r\nSubject: dev-smoke\r\n\
+         Content-Type: multipart/encrypted; boundary=\"b\"\r\n\r\n\
+         --b\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n--b--\r\n."
+    ));
+    smtp.smtp("250");
+    smtp.send("QUIT");
+    drop(smtp);
+
+    // Read it back over IMAP as the registered account.
+    let stream = TcpStream::connect(("127.0.0.1", imap)).unwrap();
+    let mut imap = Line::new(&stream);
+    assert!(imap.read().starts_with("* OK"));
+    imap.send(&format!(
+        "a1 LOGIN {} {}",
+        imap_quote(&rcpt),
+        imap_quote(&rcpt_pw)
+    ));
+    imap.imap("a1");
+    let deadline = Instant::now() + Duration::from_secs(10);
+    let mut n = 2;
+    loop {
+        let tag = format!("a{n}");
+        imap.send(&format!("{tag} SELECT INBOX"));
+        let select = imap.imap(&tag);
+        n += 1;
+        if !select.contains("* 0 EXISTS") {
+            break;
+        }
+        assert!(Instant::now() < deadline, "message never arrived: {select}");
+        std::thread::sleep(Duration::from_millis(200));
+    }
+    imap.send(&format!(
+        "a{n} FETCH 1 BODY.PEEK[HEADER.FIELDS (SUBJECT FROM)]"
+    ));
+    let fetched = imap.imap(&format!("a{n}"));
+    assert!(fetched.contains("Subject: dev-smoke"), "{fetched}");
+    assert!(fetched.contains(&sender), "{fetched}");
+    imap.send("a99 LOGOUT");
+    drop(imap);
+
+    // SIGTERM: the server drains and the temp state is removed.
+    let mut server = server;
+    unsafe {
+        libc::kill(server.0.id() as i32, libc::SIGTERM);
+    }
+    let deadline = Instant::now() + Duration::from_secs(20);
+    while server.0.try_wait().unwrap().is_none() {
+        assert!(Instant::now() < deadline, "madmail dev did not exit");
+        std::thread::sleep(Duration::from_millis(100));
+    }
+    assert!(!state_dir.exists(), "temp state left at {state_dir:?}");
+    drop(stdout);
+}
@@ -0,0 +1,148 @@
+//! Cross-instance federation tests (TDD `07-federation.md`): two in-process madmail stacks
+//! from [`support::federation`] delivering to each other.
+//!
+//! Run with `cargo test -p chatmail-integration --test federation_e2e`; no network or root
+//! is needed. Unknown recipients are dropped without a bounce (chatmail does not reveal
+//! which addresses exist), and the receiving side records `dkim=none` because outbound
+//! mail is not DKIM-signed yet.
+
+mod support;
+
+use chatmail_delivery::DeliveryOutcome;
+use support::federation::{federation_message, FederationPair, FederationPeerOpts};
+
+const PASS: &str = "federation-secret";
+
+#[tokio::test]
+async fn federation_https_delivers_in_both_directions() {
+    let pair = FederationPair::spawn(
+        FederationPeerOpts::new("alpha.test"),
+        FederationPeerOpts::new("beta.test"),
+    )
+    .await;
+    let alice = pair.a.register("alice", PASS).await;
+    let bob = pair.b.register("bob", PASS).await;
+
+    let out = pair
+        .a
+        .send(&alice, &bob, &federation_message(&alice, &bob, "a-to-b"))
+        .await;
+    assert!(matches!(out, DeliveryOutcome::Success), "{out:?}");
+    let out = pair
+        .b
+        .send(&bob, &alice, &federation_message(&bob, &alice, "b-to-a"))
+        .await;
+    assert!(matches!(out, DeliveryOutcome::Success), "{out:?}");
+
+    let inbox = pair.b.inbox(&bob, PASS).await;
+    assert_eq!(inbox.len(), 1, "{inbox:?}");
+    assert!(inbox[0].contains("Subject: a-to-b"), "{}", inbox[0]);
+    assert!(
+        inbox[0].starts_with(
+            "Authentication-Results: beta.test (transport=http-federation);\r\n\tdkim=none;"
+        ),
+        "{}",
+        inbox[0]
+    );
+    let inbox = pair.a.inbox(&alice, PASS).await;
+    assert_eq!(inbox.len(), 1, "{inbox:?}");
+    assert!(inbox[0].contains("Subject: b-to-a"), "{}", inbox[0]);
+
+    assert_eq!(pair.a.outbound_successes("beta.test"), (1, 0));
+    assert_eq!(pair.b.outbound_successes("alpha.test"), (1, 0));
+}
+
+#[tokio::test]
+async fn federation_uses_smtp_when_peer_has_no_http_endpoint() {
+    let pair = FederationPair::spawn(
+        FederationPeerOpts::new("alpha.test"),
+        FederationPeerOpts {
+            http_federation: false,
+            ..FederationPeerOpts::new("beta.test")
+        },
+    )
+    .await;
+    let alice = pair.a.register("alice", PASS).await;
+    let bob = pair.b.register("bob", PASS).await;
+
+    let out = pair
+        .a
+        .send(&alice, &bob, &federation_message(&alice, &bob, "over-smtp"))
+        .await;
+    assert!(matches!(out, DeliveryOutcome::Success), "{out:?}");
+    let out = pair
+        .b
+        .send(
+            &bob,
+            &alice,
+            &federation_message(&bob, &alice, "over-https"),
+        )
+        .await;
+    assert!(matches!(out, DeliveryOutcome::Success), "{out:?}");
+
+    let inbox = pair.b.inbox(&bob, PASS).await;
+    assert_eq!(inbox.len(), 1, "{inbox:?}");
+    assert!(inbox[0].contains("Subject: over-smtp"), "{}", inbox[0]);
+    assert!(
+        !inbox[0].contains("transport=http-federation"),
+        "{}",
+        inbox[0]
+    );
+    let inbox = pair.a.inbox(&alice, PASS).await;
+    assert_eq!(inbox.len(), 1, "{inbox:?}");
+    assert!(inbox[0].contains("Subject: over-https"), "{}", inbox[0]);
+
+    assert_eq!(pair.a.outbound_successes("beta.test"), (0, 1));
+    assert_eq!(pair.b.outbound_successes("alpha.test"), (1, 0));
+}
+
+#[tokio::test]
+async fn federation_unknown_recipient_is_dropped_without_bounce() {
+    for http_federation in [true, false] {
+        let pair = FederationPair::spawn(
+            FederationPeerOpts::new("alpha.test"),
+            FederationPeerOpts {
+                http_federation,
+                ..FederationPeerOpts::new("beta.test")
+            },
+        )
+        .await;
+        let alice = pair.a.register("alice", PASS).await;
+        pair.b.register("bob", PASS).await;
+
+        let ghost = "ghost@beta.test";
+        let out = pair
+            .a
+            .send(&alice, ghost, &federation_message(&alice, ghost, "nobody"))
+            .await;
+        assert!(matches!(out, DeliveryOutcome::Success), "{out:?}");
+        assert_eq!(pair.b.ctx.quota.used_bytes(ghost), 0);
+        assert!(pair.a.inbox(&alice, PASS).await.is_empty(), "no bounce");
+    }
+}
+
+#[tokio::test]
+async fn federation_unreachable_peer_is_a_temporary_failure() {
+    let pair = FederationPair::spawn(
+        FederationPeerOpts::new("alpha.test"),
+        FederationPeerOpts {
+            http_federation: false,
+            ..FederationPeerOpts::new("beta.test")
+        },
+    )
+    .await;
+    let alice = pair.a.register("alice", PASS).await;
+    let FederationPair { a, b } = pair;
+    drop(b);
+    tokio::time::sleep(std::time::Duration::from_millis(50)).await;
+
+    let out = a
+        .send(
+            &alice,
+            "bob@beta.test",
+            &federation_message(&alice, "bob@beta.test", "dead-peer"),
+        )
+        .await;
+    assert!(matches!(out, DeliveryOutcome::Temporary { .. }), "{out:?}");
+    assert_eq!(a.outbound_successes("beta.test"), (0, 0));
+}
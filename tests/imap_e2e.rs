@@ -88,6 +88,56 @@ async fn imap_e2e_select_requires_login() {
     );
 }
 
+#[tokio::test]
+async fn imap_e2e_password_change_retires_old_password() {
+    let dir = tempfile::tempdir().expect("tempdir");
+    let srv = spawn_mail_servers(dir.path()).await;
+    let base = format!("http://{}", srv.http_addr);
+    let http = reqwest::Client::new();
+
+    let created = http
+        .post(format!("{base}/new"))
+        .send()
+        .await
+        .expect("POST /new")
+        .text()
+        .await
+        .expect("/new body");
+    let created: serde_json::Value = serde_json::from_str(&created).expect("/new json");
+    let email = created["email"].as_str().expect("email").to_string();
+    let old = created["password"].as_str().expect("password").to_string();
+
+    let mut before = ImapClient::connect(srv.imap_addr).await;
+    assert!(before
+        .command(&format!("p001 LOGIN {email} {old}"))
+        .await
+        .contains("OK LOGIN"));
+
+    let new = "rotated-Secret1";
+    let resp = http
+        .post(format!("{base}/account/password"))
+        .basic_auth(&email, Some(&old))
+        .header("content-type", "application/json")
+        .body(serde_json::json!({ "new_password": new }).to_string())
+        .send()
+        .await
+        .expect("POST /account/password");
+    assert_eq!(resp.status().as_u16(), 200);
+
+    let mut stale = ImapClient::connect(srv.imap_addr).await;
+    let r = stale.command(&format!("p002 LOGIN {email} {old}")).await;
+    assert!(
+        !r.contains("OK LOGIN"),
+        "old password must stop working: {r}"
+    );
+
+    let mut fresh = ImapClient::connect(srv.imap_addr).await;
+    assert!(fresh
+        .command(&format!("p003 LOGIN {email} {new}"))
+        .await
+        .contains("OK LOGIN"));
+}
+
 // --- Mailbox management: LIST, SELECT, EXAMINE, STATUS ---
 
 #[tokio::test]
@@ -0,0 +1,310 @@
+//! Two in-process madmail instances that federate with each other, for cross-instance tests.
+//!
+//! Each [`FederationPeer`] has its own database, state directory and domain, a `/mxdeliv`
+//! listener over HTTPS (self-signed, as peers accept) and an inbound SMTP MX. Nothing
+//! touches the network: every peer resolves through a [`StaticResolver`] zone, and
+//! [`FederationPair::spawn`] points each side at the other through `dns_overrides`
+//! (`https://…/mxdeliv`, or `smtp://…` for a peer started with `http_federation: false`).
+//! Outbound mail goes through the real `chatmail-delivery` transport with the sender's own
+//! [`DeliveryContext`], so the process-wide outbound queue is never involved.
+
+use std::net::{SocketAddr, TcpListener as StdListener};
+use std::sync::Arc;
+use std::time::Duration;
+
+use chatmail_config::CredentialPolicy;
+use chatmail_db::DbPool;
+use chatmail_delivery::transport::deliver_remote;
+use chatmail_delivery::{DeliveryContext, DeliveryOutcome, MailOptions, OutboundJob};
+use chatmail_imap::{ImapSession, ImapSessionConfig};
+use chatmail_smtp::{SmtpSession, SmtpSessionConfig};
+use chatmail_state::{AppState, StaticResolver};
+use rustls::pki_types::{CertificateDer, PrivateKeyDer};
+use rustls::ServerConfig;
+use tokio::net::{TcpListener, TcpStream};
+use tokio_util::sync::CancellationToken;
+
+use super::{create_user, imap_fetch_all_bodies};
+
+/// How one side of a [`FederationPair`] is started.
+#[derive(Clone)]
+pub struct FederationPeerOpts {
+    pub domain: &'static str,
+    /// Serve `/mxdeliv`; when off, the other side reaches this peer over SMTP only.
+    pub http_federation: bool,
+    /// Mock DNS zone this peer resolves SPF / DKIM / DMARC records from.
+    pub dns: StaticResolver,
+}
+
+impl FederationPeerOpts {
+    pub fn new(domain: &'static str) -> Self {
+        Self {
+            domain,
+            http_federation: true,
+            dns: StaticResolver::default(),
+        }
+    }
+}
+
+pub struct FederationPeer {
+    pub domain: String,
+    pub pool: DbPool,
+    pub ctx: Arc<AppState>,
+    pub delivery: DeliveryContext,
+    /// `/mxdeliv` listener (HTTPS); `None` when started without HTTP federation.
+    pub https_addr: Option<SocketAddr>,
+    /// Inbound SMTP MX (no authentication, cleartext).
+    pub mx_addr: SocketAddr,
+    pub imap_addr: SocketAddr,
+    cancel: CancellationToken,
+    _dir: tempfile::TempDir,
+}
+
+pub struct FederationPair {
+    pub a: FederationPeer,
+    pub b: FederationPeer,
+}
+
+impl FederationPair {
+    /// Start both peers and point each one's `dns_overrides` at the other.
+    pub async fn spawn(a: FederationPeerOpts, b: FederationPeerOpts) -> Self {
+        let a = FederationPeer::spawn(a).await;
+        let b = FederationPeer::spawn(b).await;
+        a.route_to(&b).await;
+        b.route_to(&a).await;
+        Self { a, b }
+    }
+}
+
+impl FederationPeer {
+    pub async fn spawn(opts: FederationPeerOpts) -> Self {
+        let dir = tempfile::tempdir().expect("tempdir");
+        let domain = opts.domain.to_string();
+        let pool = chatmail_db::init_memory_db().await.expect("db");
+        let ctx = Arc::new(AppState {
+            dns: Arc::new(opts.dns),
+            ..AppState::new(dir.path(), pool.clone())
+        });
+        ctx.federation_policy.hydrate(&pool).await.expect("policy");
+        ctx.auth.hydrate(&pool).await.expect("auth");
+        let local_domains = chatmail_types::build_local_domains(&domain, None);
+        let delivery = DeliveryContext {
+            pool: pool.clone(),
+            state: Arc::clone(&ctx),
+            primary_domain: domain.clone(),
+            local_domains: local_domains.clone(),
+        };
+        let cancel = CancellationToken::new();
+
+        let https_addr = if opts.http_federation {
+            let addr = free_local_addr();
+            let (stop, pool_http, ctx_http) = (cancel.clone(), pool.clone(), Arc::clone(&ctx));
+            let (domain_http, domains_http) = (domain.clone(), local_domains.clone());
+            tokio::spawn(async move {
+                let _ = chatmail_fed::run_http_listener(
+                    &addr.to_string(),
+                    stop,
+                    Some(self_signed_server_config()),
+                    pool_http,
+                    ctx_http,
+                    domain_http,
+                    domains_http,
+                    None,
+                )
+                .await;
+            });
+            wait_listening(addr).await;
+            Some(addr)
+        } else {
+            None
+        };
+
+        let mx_cfg = SmtpSessionConfig {
+            hostname: format!("mx.{domain}"),
+            primary_domain: domain.clone(),
+            local_domains: local_domains.clone(),
+            jit_domain: None,
+            credential_policy: CredentialPolicy::default(),
+            require_auth: false,
+            module: "smtp",
+            starttls_config: None,
+        };
+        let mx_listener = TcpListener::bind("127.0.0.1:0").await.expect("mx bind");
+        let mx_addr = mx_listener.local_addr().expect("mx addr");
+        let (pool_mx, ctx_mx, stop) = (pool.clone(), Arc::clone(&ctx), cancel.clone());
+        tokio::spawn(async move {
+            loop {
+                let stream = tokio::select! {
+                    _ = stop.cancelled() => break,
+                    accept = mx_listener.accept() => match accept {
+                        Ok((stream, _)) => stream,
+                        Err(_) => break,
+                    },
+                };
+                let mut session =
+                    SmtpSession::new(Arc::clone(&ctx_mx), pool_mx.clone(), mx_cfg.clone());
+                tokio::spawn(async move {
+                    let _ = session.handle_connection(stream).await;
+                });
+            }
+        });
+
+        let imap_listener = TcpListener::bind("127.0.0.1:0").await.expect("imap bind");
+        let imap_addr = imap_listener.local_addr().expect("imap addr");
+        let (pool_imap, ctx_imap, stop) = (pool.clone(), Arc::clone(&ctx), cancel.clone());
+        let imap_domain = domain.clone();
+        tokio::spawn(async move {
+            loop {
+                let stream = tokio::select! {
+                    _ = stop.cancelled() => break,
+                    accept = imap_listener.accept() => match accept {
+                        Ok((stream, _)) => stream,
+                        Err(_) => break,
+                    },
+                };
+                let mut session = ImapSession::new(
+                    Arc::clone(&ctx_imap),
+                    pool_imap.clone(),
+                    ImapSessionConfig {
+                        hostname: format!("imap.{imap_domain}"),
+                        primary_domain: imap_domain.clone(),
+                        jit_domain: None,
+                        credential_policy: CredentialPolicy::default(),
+                        turn: None,
+                        iroh: None,
+                        push_enabled: false,
+                        starttls_config: None,
+                    },
+                );
+                tokio::spawn(async move {
+                    let _ = session.handle_connection(stream).await;
+                });
+            }
+        });
+
+        Self {
+            domain,
+            pool,
+            ctx,
+            delivery,
+            https_addr,
+            mx_addr,
+            imap_addr,
+            cancel,
+            _dir: dir,
+        }
+    }
+
+    /// `dns_overrides` target through which other peers reach this one.
+    pub fn endpoint(&self) -> String {
+        match self.https_addr {
+            Some(addr) => format!("https://{addr}/mxdeliv"),
+            None => format!("smtp://{}", self.mx_addr),
+        }
+    }
+
+    /// Route this peer's outbound mail for `peer`'s domain to `peer`'s listeners.
+    pub async fn route_to(&self, peer: &FederationPeer) {
+        chatmail_db::set_endpoint_override(
+            &self.pool,
+            &peer.domain,
+            &peer.endpoint(),
+            "federation test peer",
+        )
+        .await
+        .expect("dns override");
+    }
+
+    /// Create `local@domain` with `password`; returns the address.
+    pub async fn register(&self, local: &str, password: &str) -> String {
+        let email = format!("{local}@{}", self.domain);
+        create_user(&self.ctx, &self.pool, &email, password).await;
+        email
+    }
+
+    /// Deliver `raw` from `from` (a local account) to the remote address `to`, the way the
+    /// outbound queue worker does, and return the transport's verdict.
+    pub async fn send(&self, from: &str, to: &str, raw: &[u8]) -> DeliveryOutcome {
+        let job = OutboundJob {
+            mail_from: from.to_string(),
+            rcpt_to: to.to_string(),
+            data: raw.to_vec(),
+            options: MailOptions::default(),
+            priority: 0,
+        };
+        deliver_remote(&self.delivery, &job).await
+    }
+
+    /// `(HTTPS, SMTP)` outbound deliveries to `domain` this peer's tracker counted as sent.
+    pub fn outbound_successes(&self, domain: &str) -> (i64, i64) {
+        self.ctx
+            .federation_tracker
+            .snapshot()
+            .into_iter()
+            .find(|row| row.domain == domain)
+            .map(|row| (row.success_https, row.success_smtp))
+            .unwrap_or_default()
+    }
+
+    /// INBOX bodies of `user`, fetched over IMAP.
+    pub async fn inbox(&self, user: &str, password: &str) -> Vec<String> {
+        imap_fetch_all_bodies(self.imap_addr, user, password).await
+    }
+}
+
+impl Drop for FederationPeer {
+    fn drop(&mut self) {
+        self.cancel.cancel();
+    }
+}
+
+/// A PGP/MIME message, which is all chatmail peers accept.
+pub fn federation_message(from: &str, to: &str, subject: &str) -> Vec<u8> {
+    format!(
+        "From: <{from}>\r\n\
+To: <{to}>\r\n\
+Subject: {subject}\r\n\
+Message-ID: <{subject}@federation.test>\r\n\
+MIME-Version: 1.0\r\n\
+Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"b\"\r\n\
+\r\n\
+--b\r\n\
+Content-Type: application/pgp-encrypted\r\n\
+\r\n\
+Version: 1\r\n\
+--b\r\n\
+Content-Type: application/octet-stream\r\n\
+\r\n\
+stub\r\n\
+--b--\r\n"
+    )
+    .into_bytes()
+}
+
+fn self_signed_server_config() -> Arc<ServerConfig> {
+    let rc = rcgen::generate_simple_self_signed(vec!["localhost".into()]).expect("cert");
+    let cert = CertificateDer::from(rc.cert.der().to_vec());
+    let key = PrivateKeyDer::Pkcs8(rc.key_pair.serialize_der().into());
+    Arc::new(
+        ServerConfig::builder()
+            .with_no_client_auth()
+            .with_single_cert(vec![cert], key)
+            .expect("server config"),
+    )
+}
+
+/// A loopback port that was free a moment ago (`run_http_listener` binds by address).
+fn free_local_addr() -> SocketAddr {
+    let listener = StdListener::bind("127.0.0.1:0").expect("probe bind");
+    listener.local_addr().expect("probe addr")
+}
+
+async fn wait_listening(addr: SocketAddr) {
+    for _ in 0..100 {
+        if TcpStream::connect(addr).await.is_ok() {
+            return;
+        }
+        tokio::time::sleep(Duration::from_millis(20)).await;
+    }
+    panic!("listener on {addr} did not come up");
+}
@@ -2,6 +2,7 @@
 
 #![allow(dead_code, unused_imports)]
 
+pub mod federation;
 pub mod imap_client;
 pub mod p2p;
 